@@ -34,6 +34,9 @@ type AccountKey struct {
 	since  time.Time
 	until  time.Time
 	pubKey PublicKey
+	// constraints limit which assertions can be signed with the key,
+	// no constraints mean the key can sign any assertion
+	constraints []*keyConstraint
 }
 
 // AccountID returns the account-id of this account-key.
@@ -79,6 +82,101 @@ func (ak *AccountKey) publicKey() PublicKey {
 	return ak.pubKey
 }
 
+// HasConstraints returns whether the account key is restricted by
+// constraints in what it can sign.
+func (ak *AccountKey) HasConstraints() bool {
+	return len(ak.constraints) != 0
+}
+
+// canSign returns whether the account key is allowed by its
+// constraints to sign an assertion with the given type and headers.
+func (ak *AccountKey) canSign(assertType *AssertionType, headers map[string]interface{}) error {
+	if len(ak.constraints) == 0 {
+		return nil
+	}
+	var firstErr error
+	for _, kc := range ak.constraints {
+		if kc.assertType != assertType {
+			continue
+		}
+		err := kc.headers.match("", headers, nil)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return fmt.Errorf("account-key %q cannot sign %q assertions", ak.PublicKeyID(), assertType.Name)
+	}
+	return fmt.Errorf("account-key %q constraints do not allow signing this %q assertion: %v", ak.PublicKeyID(), assertType.Name, firstErr)
+}
+
+// lookupType is Type, set in init to break the initialisation loop
+// through assembleAccountKey.
+var lookupType func(name string) *AssertionType
+
+func init() {
+	lookupType = Type
+}
+
+// keyConstraint restricts an account key to signing assertions of the
+// given type whose headers match the given constraints.
+type keyConstraint struct {
+	assertType *AssertionType
+	headers    attrMatcher
+}
+
+func compileKeyConstraints(constraints interface{}) ([]*keyConstraint, error) {
+	l, ok := constraints.([]interface{})
+	if !ok || len(l) == 0 {
+		return nil, fmt.Errorf(`"constraints" header must be a non-empty list of constraints`)
+	}
+	keyConstraints := make([]*keyConstraint, len(l))
+	for i, c := range l {
+		what := fmt.Sprintf("constraint #%d", i+1)
+		cMap, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a map", what)
+		}
+		headers, err := checkMap(cMap, "headers")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", what, err)
+		}
+		if headers == nil {
+			return nil, fmt.Errorf("%s must specify headers constraints", what)
+		}
+		typeName, ok := headers["type"].(string)
+		if !ok || typeName == "" {
+			return nil, fmt.Errorf("%s must constrain the assertion type", what)
+		}
+		assertType := lookupType(typeName)
+		if assertType == nil {
+			return nil, fmt.Errorf("%s refers to unknown assertion type %q", what, typeName)
+		}
+		matcher, err := compileAttrMatcher(compileContext{}, headers)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", what, err)
+		}
+		if matcher.feature(dollarAttrConstraintsFeature) {
+			return nil, fmt.Errorf("%s cannot use $ constraints", what)
+		}
+		keyConstraints[i] = &keyConstraint{
+			assertType: assertType,
+			headers:    matcher,
+		}
+	}
+	return keyConstraints, nil
+}
+
+func accountKeyFormatAnalyze(headers map[string]interface{}, body []byte) (formatnum int, err error) {
+	if _, ok := headers["constraints"]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
 func checkPublicKey(ab *assertionBase, keyIDName string) (PublicKey, error) {
 	pubKey, err := DecodePublicKey(ab.Body())
 	if err != nil {
@@ -175,12 +273,26 @@ func assembleAccountKey(assert assertionBase) (Assertion, error) {
 		return nil, err
 	}
 
+	var constraints []*keyConstraint
+	if c, ok := assert.headers["constraints"]; ok {
+		// older snapd would ignore the constraints, the format
+		// bump makes them refuse such keys instead
+		if assert.format < 1 {
+			return nil, fmt.Errorf(`"constraints" header requires format 1 or later`)
+		}
+		constraints, err = compileKeyConstraints(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// ignore extra headers for future compatibility
 	return &AccountKey{
 		assertionBase: assert,
 		since:         since,
 		until:         until,
 		pubKey:        pubk,
+		constraints:   constraints,
 	}, nil
 }
 
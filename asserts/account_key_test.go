@@ -807,3 +807,165 @@ func (aks *accountKeySuite) TestAccountKeyRequestNoAccount(c *C) {
 	err = db.Check(akr)
 	c.Assert(err, ErrorMatches, `account-key-request assertion for "acc-id1" does not have a matching account assertion`)
 }
+
+func (aks *accountKeySuite) TestDecodeConstraints(c *C) {
+	encoded := "type: account-key\n" +
+		"format: 1\n" +
+		"authority-id: canonical\n" +
+		"account-id: acc-id1\n" +
+		"name: default\n" +
+		"public-key-sha3-384: " + aks.keyID + "\n" +
+		"constraints:\n" +
+		"  -\n" +
+		"    headers:\n" +
+		"      type: serial\n" +
+		"      model: baz-.*\n" +
+		aks.sinceLine +
+		fmt.Sprintf("body-length: %v", len(aks.pubKeyBody)) + "\n" +
+		"sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij" + "\n\n" +
+		aks.pubKeyBody + "\n\n" +
+		"AXNpZw=="
+	a, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	accKey := a.(*asserts.AccountKey)
+	c.Check(accKey.Format(), Equals, 1)
+	c.Check(accKey.HasConstraints(), Equals, true)
+
+	constraintsLines := "constraints:\n  -\n    headers:\n      type: serial\n      model: baz-.*\n"
+	invalidTests := []struct{ original, invalid, expectedErr string }{
+		{"format: 1\n", "", `"constraints" header requires format 1 or later`},
+		{constraintsLines, "constraints: foo\n", `"constraints" header must be a non-empty list of constraints`},
+		{constraintsLines, "constraints:\n  - foo\n", `constraint #1 must be a map`},
+		{constraintsLines, "constraints:\n  -\n    foo: bar\n", `constraint #1 must specify headers constraints`},
+		{constraintsLines, "constraints:\n  -\n    headers: foo\n", `constraint #1: "headers" header must be a map`},
+		{constraintsLines, "constraints:\n  -\n    headers:\n      model: baz-.*\n", `constraint #1 must constrain the assertion type`},
+		{constraintsLines, "constraints:\n  -\n    headers:\n      type: foo\n", `constraint #1 refers to unknown assertion type "foo"`},
+		{constraintsLines, "constraints:\n  -\n    headers:\n      type: serial\n      model: $MISSING\n", `constraint #1 cannot use \$ constraints`},
+		{constraintsLines, "constraints:\n  -\n    headers:\n      type: serial\n      model: (baz\n", `constraint #1: cannot compile "model" constraint .*`},
+	}
+
+	for _, test := range invalidTests {
+		invalid := strings.Replace(encoded, test.original, test.invalid, 1)
+		_, err := asserts.Decode([]byte(invalid))
+		c.Check(err, ErrorMatches, accKeyErrPrefix+test.expectedErr)
+	}
+}
+
+func (aks *accountKeySuite) TestSuggestFormatConstraints(c *C) {
+	fmtnum, err := asserts.SuggestFormat(asserts.AccountKeyType, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(fmtnum, Equals, 0)
+
+	headers := map[string]interface{}{
+		"constraints": []interface{}{map[string]interface{}{"headers": map[string]interface{}{"type": "serial"}}},
+	}
+	fmtnum, err = asserts.SuggestFormat(asserts.AccountKeyType, headers, nil)
+	c.Assert(err, IsNil)
+	c.Check(fmtnum, Equals, 1)
+}
+
+func (aks *accountKeySuite) TestAccountKeyConstraintsCheck(c *C) {
+	trustedKey := testPrivKey0
+
+	headers := map[string]interface{}{
+		"format":              "1",
+		"authority-id":        "canonical",
+		"account-id":          "acc-id1",
+		"name":                "factory",
+		"public-key-sha3-384": aks.keyID,
+		"since":               aks.since.Format(time.RFC3339),
+		"constraints": []interface{}{
+			map[string]interface{}{
+				"headers": map[string]interface{}{
+					"type":     "serial",
+					"brand-id": "acc-id1",
+					"model":    "baz-3000|baz-4000",
+				},
+			},
+		},
+	}
+	accKey, err := asserts.AssembleAndSignInTest(asserts.AccountKeyType, headers, []byte(aks.pubKeyBody), trustedKey)
+	c.Assert(err, IsNil)
+
+	db := aks.openDB(c)
+	aks.prereqAccount(c, db)
+	err = db.Add(accKey)
+	c.Assert(err, IsNil)
+
+	encodedDevKey, err := asserts.EncodePublicKey(testPrivKey2.PublicKey())
+	c.Assert(err, IsNil)
+	serialHeaders := func(model string) map[string]interface{} {
+		return map[string]interface{}{
+			"authority-id":        "acc-id1",
+			"brand-id":            "acc-id1",
+			"model":               model,
+			"serial":              "2700",
+			"device-key":          string(encodedDevKey),
+			"device-key-sha3-384": testPrivKey2.PublicKey().ID(),
+			"timestamp":           aks.since.Add(time.Hour).Format(time.RFC3339),
+		}
+	}
+
+	serial, err := asserts.AssembleAndSignInTest(asserts.SerialType, serialHeaders("baz-3000"), nil, aks.privKey)
+	c.Assert(err, IsNil)
+	err = db.Check(serial)
+	c.Check(err, IsNil)
+
+	serial, err = asserts.AssembleAndSignInTest(asserts.SerialType, serialHeaders("other-model"), nil, aks.privKey)
+	c.Assert(err, IsNil)
+	err = db.Check(serial)
+	c.Check(err, ErrorMatches, `serial \(2700; brand-id:acc-id1 model:other-model\) is signed with a key not allowed to sign it: account-key ".*" constraints do not allow signing this "serial" assertion: attribute "model" value "other-model" does not match .*`)
+
+	modelHeaders := map[string]interface{}{
+		"authority-id": "acc-id1",
+		"series":       "16",
+		"brand-id":     "acc-id1",
+		"model":        "baz-3000",
+		"architecture": "amd64",
+		"gadget":       "brand-gadget",
+		"kernel":       "baz-linux",
+		"timestamp":    aks.since.Add(time.Hour).Format(time.RFC3339),
+	}
+	model, err := asserts.AssembleAndSignInTest(asserts.ModelType, modelHeaders, nil, aks.privKey)
+	c.Assert(err, IsNil)
+	err = db.Check(model)
+	c.Check(err, ErrorMatches, `model \(baz-3000; series:16 brand-id:acc-id1\) is signed with a key not allowed to sign it: account-key ".*" cannot sign "model" assertions`)
+}
+
+func (aks *accountKeySuite) TestAccountKeyNotYetValidEarliestTime(c *C) {
+	trustedKey := testPrivKey0
+
+	since := time.Now().Add(time.Hour).Truncate(time.Second)
+	headers := map[string]interface{}{
+		"authority-id":        "canonical",
+		"account-id":          "acc-id1",
+		"name":                "default",
+		"public-key-sha3-384": aks.keyID,
+		"since":               since.Format(time.RFC3339),
+	}
+	accKey, err := asserts.AssembleAndSignInTest(asserts.AccountKeyType, headers, []byte(aks.pubKeyBody), trustedKey)
+	c.Assert(err, IsNil)
+
+	db := aks.openDB(c)
+	aks.prereqAccount(c, db)
+	err = db.Add(accKey)
+	c.Assert(err, IsNil)
+
+	a, err := asserts.AssembleAndSignInTest(asserts.TestOnlyType, map[string]interface{}{
+		"authority-id": "acc-id1",
+		"primary-key":  "0",
+	}, nil, aks.privKey)
+	c.Assert(err, IsNil)
+
+	err = db.Check(a)
+	c.Check(err, ErrorMatches, `assertion is signed with expired public key .*`)
+
+	// the system clock is known to be behind
+	db.SetEarliestTime(since.Add(time.Hour))
+	err = db.Check(a)
+	c.Check(err, IsNil)
+
+	db.SetEarliestTime(time.Time{})
+	err = db.Check(a)
+	c.Check(err, ErrorMatches, `assertion is signed with expired public key .*`)
+}
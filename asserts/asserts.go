@@ -34,7 +34,7 @@ import (
 type typeFlags int
 
 const (
-	noAuthority typeFlags = iota + 1
+	noAuthority typeFlags = 1 << iota
 )
 
 // AssertionType describes a known assertion type with its name and metadata.
@@ -126,6 +126,12 @@ func init() {
 	// 2: support for $SLOT()/$PLUG()/$MISSING
 	// 3: support for on-store/on-brand/on-model device scope constraints
 	maxSupportedFormat[SnapDeclarationType.Name] = 3
+
+	// 1: support for constraints on what the key can sign
+	maxSupportedFormat[AccountKeyType.Name] = 1
+
+	// 1: support for body-compression
+	maxSupportedFormat[RepairType.Name] = 1
 }
 
 func MockMaxSupportedFormat(assertType *AssertionType, maxFormat int) (restore func()) {
@@ -138,6 +144,8 @@ func MockMaxSupportedFormat(assertType *AssertionType, maxFormat int) (restore f
 
 var formatAnalyzer = map[*AssertionType]func(headers map[string]interface{}, body []byte) (formatnum int, err error){
 	SnapDeclarationType: snapDeclarationFormatAnalyze,
+	AccountKeyType:      accountKeyFormatAnalyze,
+	RepairType:          bodyCompressionFormatAnalyze,
 }
 
 // SuggestFormat returns a minimum format that supports the features that would be used by an assertion with the given components.
@@ -427,6 +435,10 @@ var _ Assertion = (*assertionBase)(nil)
 //   body-length (expected to be equal to the length of BODY)
 //   format (a positive int for the format iteration of the type used)
 //
+// For types supporting it the optional body-compression header
+// (only "zstd" for now) declares that BODY is compressed and base64
+// encoded, Body then returns the decompressed body.
+//
 // Times are expected to be in the RFC3339 format: "2006-01-02T15:04:05Z07:00".
 //
 func Decode(serializedAssertion []byte) (Assertion, error) {
@@ -454,7 +466,7 @@ func Decode(serializedAssertion []byte) (Assertion, error) {
 
 	headers, err := parseHeaders(head)
 	if err != nil {
-		return nil, fmt.Errorf("parsing assertion headers: %v", err)
+		return nil, parseHeadersError(err)
 	}
 
 	return assemble(headers, body, content, signature)
@@ -467,6 +479,67 @@ const (
 	MaxSignatureSize = 128 * 1024
 )
 
+// Further limits on the structure of assertions and on the chains of
+// their prerequisites, protecting against hostile input.
+const (
+	// MaxHeaderEntries is the maximum number of header entries,
+	// counting the elements of nested lists and maps.
+	MaxHeaderEntries = 4096
+	// MaxHeaderDepth is the maximum nesting depth of lists and maps
+	// in header values.
+	MaxHeaderDepth = 16
+	// MaxPrerequisiteDepth is the maximum length of a chain of
+	// prerequisites followed when fetching assertions.
+	MaxPrerequisiteDepth = 16
+)
+
+// LimitKind identifies a limit enforced when decoding or fetching
+// assertions.
+type LimitKind string
+
+const (
+	HeadersSizeLimit       LimitKind = "headers size"
+	HeaderEntriesLimit     LimitKind = "header entries"
+	HeaderDepthLimit       LimitKind = "header nesting depth"
+	BodySizeLimit          LimitKind = "body size"
+	SignatureSizeLimit     LimitKind = "signature size"
+	PrerequisiteDepthLimit LimitKind = "prerequisite depth"
+)
+
+// LimitError is returned when decoding or fetching assertions exceeds
+// one of the limits.
+type LimitError struct {
+	Kind LimitKind
+	// Max is the value of the exceeded limit.
+	Max int
+
+	msg string
+}
+
+func (e *LimitError) Error() string {
+	return e.msg
+}
+
+// errMaxSizeExceeded is used by Decoder.readUntil, the callers turn
+// it into a LimitError
+var errMaxSizeExceeded = fmt.Errorf("maximum size exceeded while looking for delimiter %q", nlnl)
+
+func parseHeadersError(err error) error {
+	const prefix = "parsing assertion headers: "
+	if lerr, ok := err.(*LimitError); ok {
+		return &LimitError{Kind: lerr.Kind, Max: lerr.Max, msg: prefix + lerr.msg}
+	}
+	return fmt.Errorf("%s%v", prefix, err)
+}
+
+func sizeLimitError(what string, kind LimitKind, max int) error {
+	return &LimitError{
+		Kind: kind,
+		Max:  max,
+		msg:  fmt.Sprintf("error reading assertion %s: %v", what, errMaxSizeExceeded),
+	}
+}
+
 // Decoder parses a stream of assertions bundled by separating them with double newlines.
 type Decoder struct {
 	rd             io.Reader
@@ -561,7 +634,7 @@ func (d *Decoder) readUntil(delim []byte, maxSize int) ([]byte, error) {
 		last = size - len(delim) + 1
 		size *= 2
 		if size > maxSize {
-			return nil, fmt.Errorf("maximum size exceeded while looking for delimiter %q", delim)
+			return nil, errMaxSizeExceeded
 		}
 	}
 }
@@ -578,13 +651,16 @@ func (d *Decoder) Decode() (Assertion, error) {
 			}
 			return nil, io.EOF
 		}
+		if err == errMaxSizeExceeded {
+			return nil, sizeLimitError("headers", HeadersSizeLimit, d.maxHeadersSize)
+		}
 		return nil, fmt.Errorf("error reading assertion headers: %v", err)
 	}
 
 	headLen := len(headAndSep) - len(nlnl)
 	headers, err := parseHeaders(headAndSep[:headLen])
 	if err != nil {
-		return nil, fmt.Errorf("parsing assertion headers: %v", err)
+		return nil, parseHeadersError(err)
 	}
 
 	typeStr, _ := headers["type"].(string)
@@ -595,9 +671,17 @@ func (d *Decoder) Decode() (Assertion, error) {
 		return nil, fmt.Errorf("assertion: %v", err)
 	}
 	if typMaxBodySize := d.typeMaxBodySize[typ]; typMaxBodySize != 0 && length > typMaxBodySize {
-		return nil, fmt.Errorf("assertion body length %d exceeds maximum body size %d for %q assertions", length, typMaxBodySize, typ.Name)
+		return nil, &LimitError{
+			Kind: BodySizeLimit,
+			Max:  typMaxBodySize,
+			msg:  fmt.Sprintf("assertion body length %d exceeds maximum body size %d for %q assertions", length, typMaxBodySize, typ.Name),
+		}
 	} else if length > d.defaultMaxBodySize {
-		return nil, fmt.Errorf("assertion body length %d exceeds maximum body size", length)
+		return nil, &LimitError{
+			Kind: BodySizeLimit,
+			Max:  d.defaultMaxBodySize,
+			msg:  fmt.Sprintf("assertion body length %d exceeds maximum body size", length),
+		}
 	}
 
 	// save the headers before we try to read more, and setup to capture
@@ -616,6 +700,9 @@ func (d *Decoder) Decode() (Assertion, error) {
 
 	// try to read the end of body a.k.a content/signature separator
 	endOfBody, err := d.readUntil(nlnl, d.maxSigSize)
+	if err == errMaxSizeExceeded {
+		return nil, sizeLimitError("trailer", SignatureSizeLimit, d.maxSigSize)
+	}
 	if err != nil && err != io.EOF {
 		return nil, fmt.Errorf("error reading assertion trailer: %v", err)
 	}
@@ -624,6 +711,9 @@ func (d *Decoder) Decode() (Assertion, error) {
 	if bytes.Equal(endOfBody, nlnl) {
 		// we got the nlnl content/signature separator, read the signature now and the assertion/assertion nlnl separation
 		sig, err = d.readUntil(nlnl, d.maxSigSize)
+		if err == errMaxSizeExceeded {
+			return nil, sizeLimitError("signature", SignatureSizeLimit, d.maxSigSize)
+		}
 		if err != nil && err != io.EOF {
 			return nil, fmt.Errorf("error reading assertion signature: %v", err)
 		}
@@ -735,6 +825,17 @@ func assemble(headers map[string]interface{}, body, content, signature []byte) (
 		return nil, fmt.Errorf("assertion: %v", err)
 	}
 
+	compression, err := checkBodyCompression(assertType, headers, formatnum)
+	if err != nil {
+		return nil, fmt.Errorf("assertion: %v", err)
+	}
+	if compression != "" && len(body) > 0 {
+		body, err = decompressBody(compression, body)
+		if err != nil {
+			return nil, fmt.Errorf("assertion %s: %v", assertType.Name, err)
+		}
+	}
+
 	if len(signature) == 0 {
 		return nil, fmt.Errorf("empty assertion signature")
 	}
@@ -777,11 +878,9 @@ func assembleAndSign(assertType *AssertionType, headers map[string]interface{},
 	}
 
 	finalHeaders := copyHeaders(headers)
-	bodyLength := len(body)
-	finalBody := make([]byte, bodyLength)
+	finalBody := make([]byte, len(body))
 	copy(finalBody, body)
 	finalHeaders["type"] = assertType.Name
-	finalHeaders["body-length"] = strconv.Itoa(bodyLength)
 	finalHeaders["sign-key-sha3-384"] = privKey.PublicKey().ID()
 
 	if withAuthority {
@@ -813,6 +912,21 @@ func assembleAndSign(assertType *AssertionType, headers map[string]interface{},
 		return nil, fmt.Errorf("cannot sign %q assertion with format set to %d lower than min format %d covering included features", assertType.Name, formatnum, suggestedFormat)
 	}
 
+	compression, err := checkBodyCompression(assertType, finalHeaders, formatnum)
+	if err != nil {
+		return nil, err
+	}
+	// the body as written in the content
+	contentBody := finalBody
+	if compression != "" && len(body) > 0 {
+		contentBody, err = compressBody(compression, body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compress assertion body: %v", err)
+		}
+	}
+	bodyLength := len(contentBody)
+	finalHeaders["body-length"] = strconv.Itoa(bodyLength)
+
 	revision, err := checkRevision(finalHeaders)
 	if err != nil {
 		return nil, err
@@ -877,7 +991,7 @@ func assembleAndSign(assertType *AssertionType, headers map[string]interface{},
 	if bodyLength > 0 {
 		buf.Grow(bodyLength + 2)
 		buf.Write(nlnl)
-		buf.Write(finalBody)
+		buf.Write(contentBody)
 	} else {
 		finalBody = nil
 	}
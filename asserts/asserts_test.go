@@ -22,6 +22,7 @@ package asserts_test
 import (
 	"bytes"
 	"io"
+	"strconv"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -65,6 +66,7 @@ func (as *assertsSuite) TestTypeNames(c *C) {
 		"system-user",
 		"test-only",
 		"test-only-2",
+		"test-only-compressible",
 		"test-only-no-authority",
 		"test-only-no-authority-pk",
 		"validation",
@@ -489,18 +491,50 @@ func (as *assertsSuite) TestDecoderHeadTooBig(c *C) {
 	decoder := asserts.NewDecoderStressed(bytes.NewBufferString(exampleBodyAndExtraHeaders), 4, 4, 1024, 1024)
 	_, err := decoder.Decode()
 	c.Assert(err, ErrorMatches, `error reading assertion headers: maximum size exceeded while looking for delimiter "\\n\\n"`)
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.HeadersSizeLimit)
+	c.Check(lerr.Max, Equals, 4)
 }
 
 func (as *assertsSuite) TestDecoderBodyTooBig(c *C) {
 	decoder := asserts.NewDecoderStressed(bytes.NewBufferString(exampleBodyAndExtraHeaders), 1024, 1024, 5, 1024)
 	_, err := decoder.Decode()
 	c.Assert(err, ErrorMatches, "assertion body length 8 exceeds maximum body size")
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.BodySizeLimit)
+	c.Check(lerr.Max, Equals, 5)
 }
 
 func (as *assertsSuite) TestDecoderSignatureTooBig(c *C) {
 	decoder := asserts.NewDecoderStressed(bytes.NewBufferString(exampleBodyAndExtraHeaders), 4, 1024, 1024, 7)
 	_, err := decoder.Decode()
 	c.Assert(err, ErrorMatches, `error reading assertion signature: maximum size exceeded while looking for delimiter "\\n\\n"`)
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.SignatureSizeLimit)
+	c.Check(lerr.Max, Equals, 7)
+}
+
+func (as *assertsSuite) TestDecoderTooManyHeaderEntries(c *C) {
+	var buf bytes.Buffer
+	buf.WriteString("type: test-only\nauthority-id: auth-id1\nprimary-key: 0\nlist:\n")
+	for i := 0; i < asserts.MaxHeaderEntries; i++ {
+		buf.WriteString("  - x\n")
+	}
+	buf.WriteString("sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij\n\nAXNpZw==")
+
+	_, err := asserts.Decode(buf.Bytes())
+	c.Assert(err, ErrorMatches, `parsing assertion headers: too many header entries \(maximum 4096\)`)
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.HeaderEntriesLimit)
+
+	decoder := asserts.NewDecoder(&buf)
+	_, err = decoder.Decode()
+	c.Assert(err, ErrorMatches, `parsing assertion headers: too many header entries \(maximum 4096\)`)
+	c.Check(err, FitsTypeOf, &asserts.LimitError{})
 }
 
 func (as *assertsSuite) TestDecoderDefaultMaxBodySize(c *C) {
@@ -720,6 +754,68 @@ func (as *assertsSuite) TestSignBodyIsUTF8Text(c *C) {
 	c.Assert(err, ErrorMatches, "assertion body is not utf8")
 }
 
+func (as *assertsSuite) TestSignCompressedBody(c *C) {
+	headers := map[string]interface{}{
+		"authority-id":     "auth-id1",
+		"primary-key":      "0",
+		"body-compression": "zstd",
+	}
+	body := []byte(strings.Repeat("THE-BODY\n", 1000))
+	a, err := asserts.AssembleAndSignInTest(asserts.TestOnlyCompressibleType, headers, body, testPrivKey1)
+	c.Assert(err, IsNil)
+	c.Check(a.Body(), DeepEquals, body)
+
+	// the content carries the compressed body
+	content, _ := a.Signature()
+	c.Check(len(content) < len(body), Equals, true)
+	c.Check(a.HeaderString("body-length"), Not(Equals), strconv.Itoa(len(body)))
+
+	decoded, err := asserts.Decode(asserts.Encode(a))
+	c.Assert(err, IsNil)
+	c.Check(decoded.Body(), DeepEquals, body)
+	c.Check(decoded.HeaderString("body-compression"), Equals, "zstd")
+
+	dec := asserts.NewDecoder(bytes.NewReader(asserts.Encode(a)))
+	streamed, err := dec.Decode()
+	c.Assert(err, IsNil)
+	c.Check(streamed.Body(), DeepEquals, body)
+}
+
+func (as *assertsSuite) TestSignCompressedBodyErrors(c *C) {
+	headers := map[string]interface{}{
+		"authority-id":     "auth-id1",
+		"primary-key":      "0",
+		"body-compression": "zstd",
+	}
+	_, err := asserts.AssembleAndSignInTest(asserts.TestOnlyType, headers, []byte("THE-BODY"), testPrivKey1)
+	c.Check(err, ErrorMatches, `"test-only" assertion cannot have body-compression set`)
+
+	headers["body-compression"] = "lzma"
+	_, err = asserts.AssembleAndSignInTest(asserts.TestOnlyCompressibleType, headers, []byte("THE-BODY"), testPrivKey1)
+	c.Check(err, ErrorMatches, `unsupported body-compression: "lzma"`)
+}
+
+func (as *assertsSuite) TestDecodeCompressedBodyInvalid(c *C) {
+	for _, t := range []struct {
+		body, expectedErr string
+	}{
+		{"!!!!", `assertion test-only-compressible: cannot decode compressed body: .*`},
+		{"bm90IHpzdGQ=", `assertion test-only-compressible: cannot decompress body: .*`},
+	} {
+		encoded := "type: test-only-compressible\n" +
+			"authority-id: auth-id1\n" +
+			"primary-key: abc\n" +
+			"body-compression: zstd\n" +
+			"body-length: " + strconv.Itoa(len(t.body)) + "\n" +
+			"sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij\n\n" +
+			t.body +
+			"\n\n" +
+			"AXNpZw=="
+		_, err := asserts.Decode([]byte(encoded))
+		c.Check(err, ErrorMatches, t.expectedErr)
+	}
+}
+
 func (as *assertsSuite) TestHeaders(c *C) {
 	encoded := []byte("type: test-only\n" +
 		"authority-id: auth-id2\n" +
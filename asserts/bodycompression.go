@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package asserts
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The body of assertions of types listed in bodyCompressionFormat can
+// be compressed, as declared by their body-compression header. To keep
+// assertions textual the compressed body is carried base64 encoded,
+// body-length and the signature cover this encoded form while Body
+// returns the original body.
+
+const zstdBodyCompression = "zstd"
+
+// bodyCompressionFormat holds the format from which each type of
+// assertions supports body-compression. Requiring that format makes
+// clients not supporting it refuse such assertions, instead of taking
+// the encoded body for the actual one.
+var bodyCompressionFormat = map[*AssertionType]int{
+	RepairType: 1,
+}
+
+func bodyCompressionFormatAnalyze(headers map[string]interface{}, body []byte) (formatnum int, err error) {
+	if _, ok := headers["body-compression"]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// checkBodyCompression returns the body compression declared by the
+// headers, if any.
+func checkBodyCompression(assertType *AssertionType, headers map[string]interface{}, formatnum int) (string, error) {
+	compression, err := checkOptionalString(headers, "body-compression")
+	if err != nil {
+		return "", err
+	}
+	if compression == "" {
+		return "", nil
+	}
+	minFormat, ok := bodyCompressionFormat[assertType]
+	if !ok {
+		return "", fmt.Errorf("%q assertion cannot have body-compression set", assertType.Name)
+	}
+	if formatnum < minFormat {
+		return "", fmt.Errorf("%q assertion with body-compression set must have format %d or higher", assertType.Name, minFormat)
+	}
+	if compression != zstdBodyCompression {
+		return "", fmt.Errorf("unsupported body-compression: %q", compression)
+	}
+	return compression, nil
+}
+
+func compressBody(compression string, body []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	compressed := enc.EncodeAll(body, nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(compressed)))
+	base64.StdEncoding.Encode(encoded, compressed)
+	return encoded, nil
+}
+
+func decompressBody(compression string, encoded []byte) ([]byte, error) {
+	compressed := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(compressed, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode compressed body: %v", err)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	body, err := dec.DecodeAll(compressed[:n], nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress body: %v", err)
+	}
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("decompressed body size %d exceeds maximum body size", len(body))
+	}
+	if !utf8.Valid(body) {
+		return nil, fmt.Errorf("decompressed body is not utf8")
+	}
+	return body, nil
+}
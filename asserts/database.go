@@ -204,6 +204,8 @@ type Database struct {
 	stackedOn []Backstore
 
 	checkers []Checker
+
+	earliestTime time.Time
 }
 
 // OpenDatabase opens the assertion database based on the configuration.
@@ -290,6 +292,8 @@ func (db *Database) WithStackedBackstore(backstore Backstore) *Database {
 		backstores: backstores,
 		stackedOn:  stackedOn,
 		checkers:   db.checkers,
+
+		earliestTime: db.earliestTime,
 	}
 }
 
@@ -361,6 +365,15 @@ func (db *Database) IsTrustedAccount(accountID string) bool {
 	return err == nil
 }
 
+// SetEarliestTime sets a time that the current time is assumed to be
+// at least, when checking key expiration this is used instead of the
+// system time if the latter is before it. This is meant for systems
+// whose clock is known to be wrong. A zero time resets to using just
+// the system time.
+func (db *Database) SetEarliestTime(earliest time.Time) {
+	db.earliestTime = earliest
+}
+
 // Check tests whether the assertion is properly signed and consistent with all the stored knowledge.
 func (db *Database) Check(assert Assertion) error {
 	if !assert.SupportedFormat() {
@@ -369,6 +382,9 @@ func (db *Database) Check(assert Assertion) error {
 
 	typ := assert.Type()
 	now := time.Now()
+	if now.Before(db.earliestTime) {
+		now = db.earliestTime
+	}
 
 	var accKey *AccountKey
 	var err error
@@ -381,6 +397,9 @@ func (db *Database) Check(assert Assertion) error {
 		if err != nil {
 			return fmt.Errorf("error finding matching public key for signature: %v", err)
 		}
+		if err := accKey.canSign(typ, assert.Headers()); err != nil {
+			return fmt.Errorf("%v is signed with a key not allowed to sign it: %v", assert.Ref(), err)
+		}
 	} else {
 		if assert.AuthorityID() != "" {
 			return fmt.Errorf("internal error: %q assertion cannot have authority-id set", typ.Name)
@@ -574,6 +593,58 @@ func (db *Database) FindManyPredefined(assertionType *AssertionType, headers map
 	return db.findMany([]Backstore{db.trusted, db.predefined}, assertionType, headers)
 }
 
+// batchSearcher can be implemented by backstores that can resolve
+// searches for many sets of headers at once more efficiently than
+// through separate Search calls.
+type batchSearcher interface {
+	// searchBatch invokes foundCb with the index of the matched
+	// headers for each assertion matching any of the given sets of
+	// headers.
+	searchBatch(assertType *AssertionType, headersList []map[string]string, foundCb func(int, Assertion), maxFormat int) error
+}
+
+func searchBatch(bs Backstore, assertType *AssertionType, headersList []map[string]string, foundCb func(int, Assertion), maxFormat int) error {
+	if bsb, ok := bs.(batchSearcher); ok {
+		return bsb.searchBatch(assertType, headersList, foundCb, maxFormat)
+	}
+	for i, headers := range headersList {
+		err := bs.Search(assertType, headers, func(a Assertion) { foundCb(i, a) }, maxFormat)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindManyBatch finds the assertions matching each of the given sets
+// of arbitrary headers, resolving them together in one pass over each
+// backstore where possible. The result holds the found assertions for
+// each set of headers at the same position, which is empty if none
+// could be found.
+func (db *Database) FindManyBatch(assertionType *AssertionType, headersList []map[string]string) ([][]Assertion, error) {
+	err := checkAssertType(assertionType)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]Assertion, len(headersList))
+	for i := range res {
+		res[i] = []Assertion{}
+	}
+
+	foundCb := func(i int, assert Assertion) {
+		res[i] = append(res[i], assert)
+	}
+
+	maxFormat := assertionType.MaxSupportedFormat()
+	for _, bs := range db.backstores {
+		err = searchBatch(bs, assertionType, headersList, foundCb, maxFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
 // assertion checkers
 
 // CheckSigningKeyIsNotExpired checks that the signing key is not expired.
@@ -764,6 +764,56 @@ func (safs *signAddFindSuite) TestFindMany(c *C) {
 	})
 }
 
+func (safs *signAddFindSuite) addTestOnlyForBatch(c *C) {
+	for _, h := range []map[string]interface{}{
+		{"primary-key": "a", "other": "other-x"},
+		{"primary-key": "b", "other": "other-y"},
+		{"primary-key": "c", "other": "other-x"},
+	} {
+		h["authority-id"] = "canonical"
+		a, err := safs.signingDB.Sign(asserts.TestOnlyType, h, nil, safs.signingKeyID)
+		c.Assert(err, IsNil)
+		err = safs.db.Add(a)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (safs *signAddFindSuite) checkFindManyBatch(c *C) {
+	res, err := safs.db.FindManyBatch(asserts.TestOnlyType, []map[string]string{
+		{"other": "other-x"},
+		{"primary-key": "b"},
+		{"other": "other-z"},
+		{"primary-key": "b", "other": "other-x"},
+		{},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res, HasLen, 5)
+
+	primKeys := func(as []asserts.Assertion) []string {
+		keys := []string{}
+		for _, a := range as {
+			keys = append(keys, a.HeaderString("primary-key"))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	c.Check(primKeys(res[0]), DeepEquals, []string{"a", "c"})
+	c.Check(primKeys(res[1]), DeepEquals, []string{"b"})
+	c.Check(primKeys(res[2]), DeepEquals, []string{})
+	c.Check(primKeys(res[3]), DeepEquals, []string{})
+	c.Check(primKeys(res[4]), DeepEquals, []string{"a", "b", "c"})
+}
+
+func (safs *signAddFindSuite) TestFindManyBatch(c *C) {
+	safs.addTestOnlyForBatch(c)
+	safs.checkFindManyBatch(c)
+}
+
+func (safs *signAddFindSuite) TestFindManyBatchUnsupportedType(c *C) {
+	_, err := safs.db.FindManyBatch(&asserts.AssertionType{Name: "xyz", PrimaryKey: nil}, nil)
+	c.Check(err, ErrorMatches, `internal error: unknown assertion type: "xyz"`)
+}
+
 func (safs *signAddFindSuite) TestFindFindsPredefined(c *C) {
 	pk1 := testPrivKey1
 
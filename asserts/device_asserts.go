@@ -20,11 +20,14 @@
 package asserts
 
 import (
+	"encoding/base64"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/sha3"
+
 	"github.com/snapcore/snapd/snap/naming"
 	"github.com/snapcore/snapd/strutil"
 )
@@ -33,10 +36,11 @@ import (
 // about the properties of a device model.
 type Model struct {
 	assertionBase
-	classic          bool
-	requiredSnaps    []string
-	sysUserAuthority []string
-	timestamp        time.Time
+	classic            bool
+	requiredSnaps      []string
+	sysUserAuthority   []string
+	kernelCmdlineAllow []string
+	timestamp          time.Time
 }
 
 // BrandID returns the brand identifier. Same as the authority id.
@@ -130,6 +134,11 @@ func (mod *Model) SystemUserAuthority() []string {
 	return mod.sysUserAuthority
 }
 
+// KernelCmdlineAllow returns the kernel command line arguments the gadget of the model can set, either as a parameter name, allowing any value, or as name=value, allowing only that value. Empty list means the gadget decides.
+func (mod *Model) KernelCmdlineAllow() []string {
+	return mod.kernelCmdlineAllow
+}
+
 // Timestamp returns the time when the model assertion was issued.
 func (mod *Model) Timestamp() time.Time {
 	return mod.timestamp
@@ -230,6 +239,10 @@ func validateSnapName(name string, headerName string) error {
 	return nil
 }
 
+// validKernelCmdlineArg matches a single kernel command line argument,
+// quoted ones are not supported.
+var validKernelCmdlineArg = regexp.MustCompile(`^[^\s"=]+(=[^\s"]*)?$`)
+
 func assembleModel(assert assertionBase) (Assertion, error) {
 	err := checkAuthorityMatchesBrand(&assert)
 	if err != nil {
@@ -315,6 +328,11 @@ func assembleModel(assert assertionBase) (Assertion, error) {
 		return nil, err
 	}
 
+	kernelCmdlineAllow, err := checkStringListMatches(assert.headers, "kernel-cmdline-allow", validKernelCmdlineArg)
+	if err != nil {
+		return nil, err
+	}
+
 	timestamp, err := checkRFC3339Date(assert.headers, "timestamp")
 	if err != nil {
 		return nil, err
@@ -329,11 +347,12 @@ func assembleModel(assert assertionBase) (Assertion, error) {
 
 	// ignore extra headers and non-empty body for future compatibility
 	return &Model{
-		assertionBase:    assert,
-		classic:          classic,
-		requiredSnaps:    reqSnaps,
-		sysUserAuthority: sysUserAuthority,
-		timestamp:        timestamp,
+		assertionBase:      assert,
+		classic:            classic,
+		requiredSnaps:      reqSnaps,
+		sysUserAuthority:   sysUserAuthority,
+		kernelCmdlineAllow: kernelCmdlineAllow,
+		timestamp:          timestamp,
 	}, nil
 }
 
@@ -416,7 +435,8 @@ func assembleSerial(assert assertionBase) (Assertion, error) {
 // SerialRequest holds a serial-request assertion, which is a self-signed request to obtain a full device identity bound to the device public key.
 type SerialRequest struct {
 	assertionBase
-	pubKey PublicKey
+	pubKey      PublicKey
+	attestation []byte
 }
 
 // BrandID returns the brand identifier of the device making the request.
@@ -444,6 +464,25 @@ func (sreq *SerialRequest) DeviceKey() PublicKey {
 	return sreq.pubKey
 }
 
+// AttestationType returns the type of the optional attestation of the request, naming the format of the attestation and the hardware or service that produced it.
+func (sreq *SerialRequest) AttestationType() string {
+	return sreq.HeaderString("attestation-type")
+}
+
+// Attestation returns the optional attestation, produced for example by a TPM or a vendor HSM, that binds the request to a hardware-rooted device identity. It is computed over SerialRequestAttestationChallenge.
+func (sreq *SerialRequest) Attestation() []byte {
+	return sreq.attestation
+}
+
+// SerialRequestAttestationChallenge returns the challenge the attestation of a serial-request is computed over, binding together the request-id obtained from the serial signing service and the device key.
+func SerialRequestAttestationChallenge(requestID string, deviceKey PublicKey) []byte {
+	h := sha3.New384()
+	h.Write([]byte(requestID))
+	h.Write([]byte{0})
+	h.Write([]byte(deviceKey.ID()))
+	return h.Sum(nil)
+}
+
 func assembleSerialRequest(assert assertionBase) (Assertion, error) {
 	_, err := checkNotEmptyString(assert.headers, "brand-id")
 	if err != nil {
@@ -478,10 +517,30 @@ func assembleSerialRequest(assert assertionBase) (Assertion, error) {
 		return nil, fmt.Errorf("device key does not match included signing key id")
 	}
 
+	attestationType, err := checkOptionalString(assert.headers, "attestation-type")
+	if err != nil {
+		return nil, err
+	}
+	encodedAttestation, err := checkOptionalString(assert.headers, "attestation")
+	if err != nil {
+		return nil, err
+	}
+	if (attestationType == "") != (encodedAttestation == "") {
+		return nil, fmt.Errorf(`"attestation-type" and "attestation" headers must be both set or both unset`)
+	}
+	var attestation []byte
+	if encodedAttestation != "" {
+		attestation, err = base64.StdEncoding.DecodeString(encodedAttestation)
+		if err != nil {
+			return nil, fmt.Errorf(`"attestation" header must be base64 encoded: %v`, err)
+		}
+	}
+
 	// ignore extra headers and non-empty body for future compatibility
 	return &SerialRequest{
 		assertionBase: assert,
 		pubKey:        pubKey,
+		attestation:   attestation,
 	}, nil
 }
 
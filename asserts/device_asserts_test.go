@@ -20,6 +20,7 @@
 package asserts_test
 
 import (
+	"encoding/base64"
 	"fmt"
 	"strings"
 	"time"
@@ -109,6 +110,16 @@ func (mods *modelSuite) TestDecodeOK(c *C) {
 	c.Check(model.Store(), Equals, "brand-store")
 	c.Check(model.RequiredSnaps(), DeepEquals, []string{"foo", "bar"})
 	c.Check(model.SystemUserAuthority(), HasLen, 0)
+	c.Check(model.KernelCmdlineAllow(), HasLen, 0)
+}
+
+func (mods *modelSuite) TestDecodeKernelCmdlineAllow(c *C) {
+	withTimestamp := strings.Replace(modelExample, "TSLINE", mods.tsLine, 1)
+	encoded := strings.Replace(withTimestamp, reqSnaps, reqSnaps+"kernel-cmdline-allow:\n  - console\n  - quiet=1\n", 1)
+	a, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	model := a.(*asserts.Model)
+	c.Check(model.KernelCmdlineAllow(), DeepEquals, []string{"console", "quiet=1"})
 }
 
 func (mods *modelSuite) TestDecodeStoreIsOptional(c *C) {
@@ -332,6 +343,9 @@ func (mods *modelSuite) TestDecodeInvalid(c *C) {
 		{reqSnaps, "required-snaps:\n  -\n    - nested\n", `"required-snaps" header must be a list of strings`},
 		{sysUserAuths, "system-user-authority:\n  a: 1\n", `"system-user-authority" header must be '\*' or a list of account ids`},
 		{sysUserAuths, "system-user-authority:\n  - 5_6\n", `"system-user-authority" header must be '\*' or a list of account ids`},
+		{reqSnaps, reqSnaps + "kernel-cmdline-allow: console\n", `"kernel-cmdline-allow" header must be a list of strings`},
+		{reqSnaps, reqSnaps + "kernel-cmdline-allow:\n  - console tty1\n", `"kernel-cmdline-allow" header contains an invalid element: "console tty1"`},
+		{reqSnaps, reqSnaps + "kernel-cmdline-allow:\n  - =1\n", `"kernel-cmdline-allow" header contains an invalid element: "=1"`},
 	}
 
 	for _, test := range invalidTests {
@@ -605,6 +619,53 @@ func (ss *serialSuite) TestSerialRequestHappyOptionalSerial(c *C) {
 	c.Check(sreq2.Serial(), Equals, "pserial")
 }
 
+func (ss *serialSuite) TestSerialRequestHappyAttestation(c *C) {
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType,
+		map[string]interface{}{
+			"brand-id":         "brand-id1",
+			"model":            "baz-3000",
+			"device-key":       ss.encodedDevKey,
+			"request-id":       "REQID",
+			"attestation-type": "tpm2-quote",
+			"attestation":      base64.StdEncoding.EncodeToString([]byte("QUOTE")),
+		}, nil, ss.deviceKey)
+	c.Assert(err, IsNil)
+
+	// roundtrip
+	a, err := asserts.Decode(asserts.Encode(sreq))
+	c.Assert(err, IsNil)
+
+	sreq2, ok := a.(*asserts.SerialRequest)
+	c.Assert(ok, Equals, true)
+
+	c.Check(sreq2.AttestationType(), Equals, "tpm2-quote")
+	c.Check(sreq2.Attestation(), DeepEquals, []byte("QUOTE"))
+}
+
+func (ss *serialSuite) TestSerialRequestNoAttestation(c *C) {
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType,
+		map[string]interface{}{
+			"brand-id":   "brand-id1",
+			"model":      "baz-3000",
+			"device-key": ss.encodedDevKey,
+			"request-id": "REQID",
+		}, nil, ss.deviceKey)
+	c.Assert(err, IsNil)
+
+	c.Check(sreq.(*asserts.SerialRequest).AttestationType(), Equals, "")
+	c.Check(sreq.(*asserts.SerialRequest).Attestation(), IsNil)
+}
+
+func (ss *serialSuite) TestSerialRequestAttestationChallenge(c *C) {
+	challenge := asserts.SerialRequestAttestationChallenge("REQID", ss.deviceKey.PublicKey())
+	c.Check(challenge, HasLen, 48)
+	// bound to both the request-id and the device key
+	c.Check(asserts.SerialRequestAttestationChallenge("REQID", ss.deviceKey.PublicKey()), DeepEquals, challenge)
+	c.Check(asserts.SerialRequestAttestationChallenge("REQID2", ss.deviceKey.PublicKey()), Not(DeepEquals), challenge)
+	otherKey, _ := assertstest.GenerateKey(752)
+	c.Check(asserts.SerialRequestAttestationChallenge("REQID", otherKey.PublicKey()), Not(DeepEquals), challenge)
+}
+
 func (ss *serialSuite) TestSerialRequestDecodeInvalid(c *C) {
 	encoded := "type: serial-request\n" +
 		"brand-id: brand-id1\n" +
@@ -629,6 +690,10 @@ func (ss *serialSuite) TestSerialRequestDecodeInvalid(c *C) {
 		{"device-key:\n    DEVICEKEY\n", "device-key: \n", `"device-key" header should not be empty`},
 		{"device-key:\n    DEVICEKEY\n", "device-key: $$$\n", `cannot decode public key: .*`},
 		{"serial: S\n", "serial:\n  - xyz\n", `"serial" header must be a string`},
+		{"serial: S\n", "attestation-type:\n  - xyz\n", `"attestation-type" header must be a string`},
+		{"serial: S\n", "attestation-type: tpm2-quote\n", `"attestation-type" and "attestation" headers must be both set or both unset`},
+		{"serial: S\n", "attestation: UVVPVEU=\n", `"attestation-type" and "attestation" headers must be both set or both unset`},
+		{"serial: S\n", "attestation-type: tpm2-quote\nattestation: $$$\n", `"attestation" header must be base64 encoded: .*`},
 	}
 
 	for _, test := range invalidTests {
@@ -135,12 +135,24 @@ func assembleTestOnlyNoAuthorityPK(assert assertionBase) (Assertion, error) {
 
 var TestOnlyNoAuthorityPKType = &AssertionType{"test-only-no-authority-pk", []string{"pk"}, assembleTestOnlyNoAuthorityPK, noAuthority}
 
+type TestOnlyCompressible struct {
+	assertionBase
+}
+
+func assembleTestOnlyCompressible(assert assertionBase) (Assertion, error) {
+	return &TestOnlyCompressible{assert}, nil
+}
+
+var TestOnlyCompressibleType = &AssertionType{"test-only-compressible", []string{"primary-key"}, assembleTestOnlyCompressible, 0}
+
 func init() {
 	typeRegistry[TestOnlyType.Name] = TestOnlyType
 	maxSupportedFormat[TestOnlyType.Name] = 1
 	typeRegistry[TestOnly2Type.Name] = TestOnly2Type
 	typeRegistry[TestOnlyNoAuthorityType.Name] = TestOnlyNoAuthorityType
 	typeRegistry[TestOnlyNoAuthorityPKType.Name] = TestOnlyNoAuthorityPKType
+	typeRegistry[TestOnlyCompressibleType.Name] = TestOnlyCompressibleType
+	bodyCompressionFormat[TestOnlyCompressibleType] = 0
 	formatAnalyzer[TestOnlyType] = func(headers map[string]interface{}, _ []byte) (int, error) {
 		if _, ok := headers["format-1-feature"]; ok {
 			return 1, nil
@@ -149,6 +161,11 @@ func init() {
 	}
 }
 
+// SearchBatch exposes searchBatch for tests.
+func SearchBatch(bs Backstore, assertType *AssertionType, headersList []map[string]string, foundCb func(int, Assertion), maxFormat int) error {
+	return searchBatch(bs, assertType, headersList, foundCb, maxFormat)
+}
+
 // AccountKeyIsKeyValidAt exposes isKeyValidAt on AccountKey for tests
 func AccountKeyIsKeyValidAt(ak *AccountKey, when time.Time) bool {
 	return ak.isKeyValidAt(when)
@@ -191,3 +208,11 @@ type featureExposer interface {
 func RuleFeature(rule featureExposer, flabel string) bool {
 	return rule.feature(flabel)
 }
+
+func MockMaxPrerequisiteDepth(depth int) (restore func()) {
+	old := maxPrerequisiteDepth
+	maxPrerequisiteDepth = depth
+	return func() {
+		maxPrerequisiteDepth = old
+	}
+}
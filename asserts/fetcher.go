@@ -23,6 +23,8 @@ import (
 	"fmt"
 )
 
+var maxPrerequisiteDepth = MaxPrerequisiteDepth
+
 type fetchProgress int
 
 const (
@@ -47,6 +49,9 @@ type fetcher struct {
 	save     func(Assertion) error
 
 	fetched map[string]fetchProgress
+	// depth is the length of the chain of prerequisites being
+	// chased
+	depth int
 }
 
 // NewFetcher creates a Fetcher which will use trustedDB to determine trusted assertions, will fetch assertions following prerequisites using retrieve, and then will pass them to save, saving prerequisites before dependent assertions.
@@ -76,6 +81,15 @@ func (f *fetcher) chase(ref *Ref, a Assertion) error {
 	case fetchRetrieved:
 		return fmt.Errorf("circular assertions are not expected: %s", ref)
 	}
+	if f.depth >= maxPrerequisiteDepth {
+		return &LimitError{
+			Kind: PrerequisiteDepthLimit,
+			Max:  maxPrerequisiteDepth,
+			msg:  fmt.Sprintf("cannot fetch %s: chain of prerequisites is too long (maximum depth %d)", ref, maxPrerequisiteDepth),
+		}
+	}
+	f.depth++
+	defer func() { f.depth-- }()
 	if a == nil {
 		retrieved, err := f.retrieve(ref)
 		if err != nil {
@@ -165,3 +165,39 @@ func (s *fetcherSuite) TestSave(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(snapDecl.(*asserts.SnapDeclaration).SnapName(), Equals, "foo")
 }
+
+func (s *fetcherSuite) TestFetchPrerequisiteDepthLimit(c *C) {
+	s.prereqSnapAssertions(c, 10)
+
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+		Trusted:   s.storeSigning.Trusted,
+	})
+	c.Assert(err, IsNil)
+
+	ref := &asserts.Ref{
+		Type:       asserts.SnapRevisionType,
+		PrimaryKey: []string{makeDigest(10)},
+	}
+
+	retrieve := func(ref *asserts.Ref) (asserts.Assertion, error) {
+		return ref.Resolve(s.storeSigning.Find)
+	}
+
+	// snap-revision -> snap-declaration -> account
+	restore := asserts.MockMaxPrerequisiteDepth(2)
+	defer restore()
+
+	f := asserts.NewFetcher(db, retrieve, db.Add)
+	err = f.Fetch(ref)
+	c.Assert(err, ErrorMatches, `cannot fetch account \(.*\): chain of prerequisites is too long \(maximum depth 2\)`)
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.PrerequisiteDepthLimit)
+	c.Check(lerr.Max, Equals, 2)
+
+	restore()
+	f = asserts.NewFetcher(db, retrieve, db.Add)
+	err = f.Fetch(ref)
+	c.Assert(err, IsNil)
+}
@@ -219,3 +219,60 @@ func (fsbs *filesystemBackstore) Search(assertType *AssertionType, headers map[s
 	}
 	return fsbs.search(assertType, diskPattern, candCb, maxFormat)
 }
+
+func (fsbs *filesystemBackstore) searchBatch(assertType *AssertionType, headersList []map[string]string, foundCb func(int, Assertion), maxFormat int) error {
+	fsbs.mu.RLock()
+	defer fsbs.mu.RUnlock()
+
+	// searches with the full primary key read only that assertion
+	n := len(assertType.PrimaryKey)
+	var remaining []int
+	for i, headers := range headersList {
+		key, err := PrimaryKeyFromHeaders(assertType, headers)
+		if err != nil {
+			remaining = append(remaining, i)
+			continue
+		}
+		a, err := fsbs.currentAssertion(assertType, key, maxFormat)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if searchMatch(a, headers) {
+			foundCb(i, a)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// resolve the remaining searches with one pass over the assertions
+	// matching the primary key header values they all share
+	diskPattern := make([]string, n+1)
+	for j, k := range assertType.PrimaryKey {
+		keyVal := headersList[remaining[0]][k]
+		for _, i := range remaining[1:] {
+			if headersList[i][k] != keyVal {
+				keyVal = ""
+				break
+			}
+		}
+		if keyVal == "" {
+			diskPattern[j] = "*"
+		} else {
+			diskPattern[j] = url.QueryEscape(keyVal)
+		}
+	}
+	diskPattern[n] = "active*"
+
+	candCb := func(a Assertion) {
+		for _, i := range remaining {
+			if searchMatch(a, headersList[i]) {
+				foundCb(i, a)
+			}
+		}
+	}
+	return fsbs.search(assertType, diskPattern, candCb, maxFormat)
+}
@@ -22,6 +22,7 @@ package asserts_test
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"syscall"
 
 	. "gopkg.in/check.v1"
@@ -256,3 +257,58 @@ func (fsbss *fsBackstoreSuite) TestSearchFormat(c *C) {
 	c.Check(as[0].Revision(), Equals, 1)
 
 }
+
+func (fsbss *fsBackstoreSuite) TestSearchBatch(c *C) {
+	topDir := filepath.Join(c.MkDir(), "asserts-db")
+	bs, err := asserts.OpenFSBackstore(topDir)
+	c.Assert(err, IsNil)
+
+	decode := func(pk1, pk2, other string) asserts.Assertion {
+		a, err := asserts.Decode([]byte("type: test-only-2\n" +
+			"authority-id: auth-id1\n" +
+			"pk1: " + pk1 + "\n" +
+			"pk2: " + pk2 + "\n" +
+			"other: " + other + "\n" +
+			"sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij" +
+			"\n\n" +
+			"AXNpZw=="))
+		c.Assert(err, IsNil)
+		return a
+	}
+
+	for _, a := range []asserts.Assertion{
+		decode("foo", "bar", "x"),
+		decode("foo", "baz", "y"),
+		decode("qux", "bar", "x"),
+	} {
+		err = bs.Put(asserts.TestOnly2Type, a)
+		c.Assert(err, IsNil)
+	}
+
+	searchBatch := func(headersList []map[string]string) [][]string {
+		found := make([][]string, len(headersList))
+		foundCb := func(i int, a asserts.Assertion) {
+			found[i] = append(found[i], a.HeaderString("pk1")+"/"+a.HeaderString("pk2"))
+		}
+		err := asserts.SearchBatch(bs, asserts.TestOnly2Type, headersList, foundCb, 0)
+		c.Assert(err, IsNil)
+		for _, f := range found {
+			sort.Strings(f)
+		}
+		return found
+	}
+
+	c.Check(searchBatch([]map[string]string{
+		{"pk1": "foo", "pk2": "bar"},
+		{"other": "x"},
+		{"pk1": "foo"},
+		{"pk2": "bar", "other": "y"},
+		{"pk1": "foo", "pk2": "baz", "other": "x"},
+	}), DeepEquals, [][]string{
+		{"foo/bar"},
+		{"foo/bar", "qux/bar"},
+		{"foo/bar", "foo/baz"},
+		nil,
+		nil,
+	})
+}
@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build gofuzz
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package asserts
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// FuzzDecode is an entry point for fuzzing the decoding of assertion
+// streams, e.g. with go-fuzz-build -func FuzzDecode. It decodes the
+// assertions in data, checking that the encoding of each of them
+// decodes to the same assertion, and panics otherwise. It returns 1
+// if data contained at least one valid assertion, 0 otherwise.
+func FuzzDecode(data []byte) int {
+	dec := NewDecoder(bytes.NewReader(data))
+	interesting := 0
+	for {
+		a, err := dec.Decode()
+		if err != nil {
+			// includes io.EOF at the end of a well-formed stream
+			return interesting
+		}
+		interesting = 1
+
+		a1, err := Decode(Encode(a))
+		if err != nil {
+			panic(fmt.Sprintf("cannot decode encoded %v: %v", a.Ref(), err))
+		}
+		if !reflect.DeepEqual(a.Headers(), a1.Headers()) || !bytes.Equal(a.Body(), a1.Body()) {
+			panic(fmt.Sprintf("encoded %v does not decode to the same assertion", a.Ref()))
+		}
+	}
+}
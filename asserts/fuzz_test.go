@@ -0,0 +1,33 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// +build gofuzz
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package asserts_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+func (as *assertsSuite) TestFuzzDecode(c *C) {
+	c.Check(asserts.FuzzDecode([]byte(exampleBodyAndExtraHeaders+"\n"+exampleEmptyBody2NlNl)), Equals, 1)
+	c.Check(asserts.FuzzDecode([]byte("type: foo\n\n")), Equals, 0)
+	c.Check(asserts.FuzzDecode(nil), Equals, 0)
+}
@@ -42,6 +42,7 @@ func parseHeaders(head []byte) (map[string]interface{}, error) {
 	}
 	headers := make(map[string]interface{})
 	lines := strings.Split(string(head), "\n")
+	ps := &parseState{}
 	for i := 0; i < len(lines); {
 		entry := lines[i]
 		nameValueSplit := strings.Index(entry, ":")
@@ -56,7 +57,7 @@ func parseHeaders(head []byte) (map[string]interface{}, error) {
 		consumed := nameValueSplit + 1
 		var value interface{}
 		var err error
-		value, i, err = parseEntry(consumed, i, lines, 0)
+		value, i, err = parseEntry(ps, consumed, i, lines, 0)
 		if err != nil {
 			return nil, err
 		}
@@ -81,7 +82,44 @@ func nestingPrefix(baseIndent int, prefix string) string {
 	return strings.Repeat(" ", baseIndent) + prefix
 }
 
-func parseEntry(consumedByIntro int, first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+// parseState tracks the limits enforced while parsing headers.
+type parseState struct {
+	entries int
+	depth   int
+}
+
+func (ps *parseState) entry() error {
+	ps.entries++
+	if ps.entries > MaxHeaderEntries {
+		return &LimitError{
+			Kind: HeaderEntriesLimit,
+			Max:  MaxHeaderEntries,
+			msg:  fmt.Sprintf("too many header entries (maximum %d)", MaxHeaderEntries),
+		}
+	}
+	return nil
+}
+
+func (ps *parseState) nest() error {
+	ps.depth++
+	if ps.depth > MaxHeaderDepth {
+		return &LimitError{
+			Kind: HeaderDepthLimit,
+			Max:  MaxHeaderDepth,
+			msg:  fmt.Sprintf("header values nested too deeply (maximum depth %d)", MaxHeaderDepth),
+		}
+	}
+	return nil
+}
+
+func (ps *parseState) unnest() {
+	ps.depth--
+}
+
+func parseEntry(ps *parseState, consumedByIntro int, first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+	if err := ps.entry(); err != nil {
+		return nil, -1, err
+	}
 	entry := lines[first]
 	i := first + 1
 	if consumedByIntro == len(entry) {
@@ -91,11 +129,11 @@ func parseEntry(consumedByIntro int, first int, lines []string, baseIndent int)
 			rest := lines[i][len(basePrefix):]
 			if strings.HasPrefix(rest, listChar) {
 				// list
-				return parseList(i, lines, baseIndent)
+				return parseList(ps, i, lines, baseIndent)
 			}
 			if len(rest) > 0 && rest[0] != ' ' {
 				// map
-				return parseMap(i, lines, baseIndent)
+				return parseMap(ps, i, lines, baseIndent)
 			}
 		}
 
@@ -145,7 +183,11 @@ func parseMultilineText(first int, lines []string, baseIndent int) (value interf
 	return valueBuf.String(), i, nil
 }
 
-func parseList(first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+func parseList(ps *parseState, first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+	if err := ps.nest(); err != nil {
+		return nil, -1, err
+	}
+	defer ps.unnest()
 	lst := []interface{}(nil)
 	j := first
 	prefix := nestingPrefix(baseIndent, listPrefix)
@@ -155,7 +197,7 @@ func parseList(first int, lines []string, baseIndent int) (value interface{}, fi
 		}
 		var v interface{}
 		var err error
-		v, j, err = parseEntry(len(prefix), j, lines, baseIndent+len(listPrefix)-1)
+		v, j, err = parseEntry(ps, len(prefix), j, lines, baseIndent+len(listPrefix)-1)
 		if err != nil {
 			return nil, -1, err
 		}
@@ -164,7 +206,11 @@ func parseList(first int, lines []string, baseIndent int) (value interface{}, fi
 	return lst, j, nil
 }
 
-func parseMap(first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+func parseMap(ps *parseState, first int, lines []string, baseIndent int) (value interface{}, firstAfter int, err error) {
+	if err := ps.nest(); err != nil {
+		return nil, -1, err
+	}
+	defer ps.unnest()
 	m := make(map[string]interface{})
 	j := first
 	prefix := nestingPrefix(baseIndent, commonPrefix)
@@ -186,7 +232,7 @@ func parseMap(first int, lines []string, baseIndent int) (value interface{}, fir
 		consumed := keyValueSplit + 1
 		var value interface{}
 		var err error
-		value, j, err = parseEntry(len(prefix)+consumed, j, lines, len(prefix))
+		value, j, err = parseEntry(ps, len(prefix)+consumed, j, lines, len(prefix))
 		if err != nil {
 			return nil, -1, err
 		}
@@ -21,6 +21,7 @@ package asserts_test
 
 import (
 	"bytes"
+	"fmt"
 
 	. "gopkg.in/check.v1"
 
@@ -394,3 +395,40 @@ func (s *headersSuite) TestAppendEntryOmitting(c *C) {
 		"start": ".",
 	})
 }
+
+func (s *headersSuite) TestParseHeadersLimits(c *C) {
+	var buf bytes.Buffer
+	for i := 0; i < asserts.MaxHeaderEntries; i++ {
+		fmt.Fprintf(&buf, "h%d: x\n", i)
+	}
+	_, err := asserts.ParseHeaders(bytes.TrimSpace(buf.Bytes()))
+	c.Check(err, IsNil)
+
+	buf.WriteString("one-more: x")
+	_, err = asserts.ParseHeaders(buf.Bytes())
+	c.Check(err, ErrorMatches, `too many header entries \(maximum 4096\)`)
+	lerr, ok := err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.HeaderEntriesLimit)
+
+	nested := func(depth int) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("foo:")
+		indent := ""
+		for i := 0; i < depth; i++ {
+			fmt.Fprintf(&buf, "\n%s  -", indent)
+			indent += "  "
+		}
+		buf.WriteString(" x")
+		return buf.Bytes()
+	}
+	m, err := asserts.ParseHeaders(nested(asserts.MaxHeaderDepth))
+	c.Assert(err, IsNil)
+	c.Check(m["foo"], FitsTypeOf, []interface{}(nil))
+
+	_, err = asserts.ParseHeaders(nested(asserts.MaxHeaderDepth + 1))
+	c.Check(err, ErrorMatches, `header values nested too deeply \(maximum depth 16\)`)
+	lerr, ok = err.(*asserts.LimitError)
+	c.Assert(ok, Equals, true)
+	c.Check(lerr.Kind, Equals, asserts.HeaderDepthLimit)
+}
@@ -181,3 +181,38 @@ func (s *repairSuite) TestRepairCanEmbeddScripts(c *C) {
 hello from the inside
 `)
 }
+
+func (s *repairSuite) TestCompressedBodyNeedsFormat1(c *C) {
+	headers := map[string]interface{}{
+		"authority-id":     "acme",
+		"brand-id":         "acme",
+		"repair-id":        "42",
+		"summary":          "example repair",
+		"timestamp":        s.ts.Format(time.RFC3339),
+		"body-compression": "zstd",
+	}
+	_, err := asserts.AssembleAndSignInTest(asserts.RepairType, headers, []byte(script), testPrivKey1)
+	c.Check(err, ErrorMatches, `cannot sign "repair" assertion with format set to 0 lower than min format 1 covering included features`)
+
+	headers["format"] = "1"
+	a, err := asserts.AssembleAndSignInTest(asserts.RepairType, headers, []byte(script), testPrivKey1)
+	c.Assert(err, IsNil)
+	c.Check(a.Format(), Equals, 1)
+	c.Check(string(a.Body()), Equals, script)
+
+	decoded, err := asserts.Decode(asserts.Encode(a))
+	c.Assert(err, IsNil)
+	c.Check(string(decoded.Body()), Equals, script)
+	c.Check(decoded.SupportedFormat(), Equals, true)
+
+	// clients not supporting body-compression refuse it
+	restore := asserts.MockMaxSupportedFormat(asserts.RepairType, 0)
+	defer restore()
+	c.Check(decoded.SupportedFormat(), Equals, false)
+}
+
+func (s *repairSuite) TestDecodeCompressedBodyWithoutFormat(c *C) {
+	encoded := strings.Replace(s.repairStr, "summary: example repair\n", "summary: example repair\nbody-compression: zstd\n", 1)
+	_, err := asserts.Decode([]byte(encoded))
+	c.Check(err, ErrorMatches, `assertion: "repair" assertion with body-compression set must have format 1 or higher`)
+}
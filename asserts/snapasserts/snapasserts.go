@@ -123,6 +123,35 @@ func DeriveSideInfo(snapPath string, db Finder) (*snap.SideInfo, error) {
 	}, nil
 }
 
+// VerifySnapFile recomputes the digest of the installed snap file at snapPath and checks it, together with the file size and the metadata in the SideInfo, against the snap-revision assertion in the given database. It detects snap files that got corrupted or tampered with after installation.
+func VerifySnapFile(instanceName, snapPath string, si *snap.SideInfo, db Finder) error {
+	snapSHA3_384, snapSize, err := asserts.SnapFileSHA3_384(snapPath)
+	if err != nil {
+		return err
+	}
+
+	a, err := db.Find(asserts.SnapRevisionType, map[string]string{
+		"snap-sha3-384": snapSHA3_384,
+	})
+	if asserts.IsNotFound(err) {
+		return fmt.Errorf("snap %q revision %s file does not match its snap-revision assertion (corrupted or tampered)", instanceName, si.Revision)
+	}
+	if err != nil {
+		return err
+	}
+	snapRev := a.(*asserts.SnapRevision)
+
+	if snapRev.SnapSize() != snapSize {
+		return fmt.Errorf("snap %q revision %s file does not have expected size according to signatures (corrupted or tampered): %d != %d", instanceName, si.Revision, snapSize, snapRev.SnapSize())
+	}
+
+	if snapRev.SnapID() != si.SnapID || snapRev.SnapRevision() != si.Revision.N {
+		return fmt.Errorf("snap %q revision %s file matches the snap-revision assertion of a different snap or revision (tampered): %s / %d", instanceName, si.Revision, snapRev.SnapID(), snapRev.SnapRevision())
+	}
+
+	return nil
+}
+
 // FetchSnapAssertions fetches the assertions matching the snap file digest using the given fetcher.
 func FetchSnapAssertions(f asserts.Fetcher, snapSHA3_384 string) error {
 	// for now starting from the snap-revision will get us all other relevant assertions
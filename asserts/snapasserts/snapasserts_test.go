@@ -332,3 +332,46 @@ func (s *snapassertsSuite) TestDeriveSideInfoRevokedSnapDecl(c *C) {
 	_, err = snapasserts.DeriveSideInfo(snapPath, s.localDB)
 	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot install snap %q with a revoked snap declaration`, snapPath))
 }
+
+func (s *snapassertsSuite) TestVerifySnapFile(c *C) {
+	digest := makeDigest(12)
+	size := uint64(len(fakeSnap(12)))
+	headers := map[string]interface{}{
+		"snap-id":       "snap-id-1",
+		"snap-sha3-384": digest,
+		"snap-size":     fmt.Sprintf("%d", size),
+		"snap-revision": "12",
+		"developer-id":  s.dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, headers, nil, "")
+	c.Assert(err, IsNil)
+	err = s.localDB.Add(snapRev)
+	c.Assert(err, IsNil)
+
+	si := &snap.SideInfo{
+		SnapID:   "snap-id-1",
+		Revision: snap.R(12),
+	}
+
+	snapPath := filepath.Join(c.MkDir(), "foo_12.snap")
+	err = ioutil.WriteFile(snapPath, fakeSnap(12), 0644)
+	c.Assert(err, IsNil)
+
+	err = snapasserts.VerifySnapFile("foo", snapPath, si, s.localDB)
+	c.Check(err, IsNil)
+
+	// metadata for another revision
+	err = snapasserts.VerifySnapFile("foo", snapPath, &snap.SideInfo{SnapID: "snap-id-1", Revision: snap.R(13)}, s.localDB)
+	c.Check(err, ErrorMatches, `snap "foo" revision 13 file matches the snap-revision assertion of a different snap or revision \(tampered\): snap-id-1 / 12`)
+
+	// the file changed
+	err = ioutil.WriteFile(snapPath, fakeSnap(21), 0644)
+	c.Assert(err, IsNil)
+	err = snapasserts.VerifySnapFile("foo", snapPath, si, s.localDB)
+	c.Check(err, ErrorMatches, `snap "foo" revision 12 file does not match its snap-revision assertion \(corrupted or tampered\)`)
+
+	// the file is gone
+	err = snapasserts.VerifySnapFile("foo", filepath.Join(c.MkDir(), "missing.snap"), si, s.localDB)
+	c.Check(err, ErrorMatches, `.*no such file or directory`)
+}
@@ -30,6 +30,7 @@ import (
 type Store struct {
 	assertionBase
 	url            *url.URL
+	assertionsURL  *url.URL
 	friendlyStores []string
 	timestamp      time.Time
 }
@@ -49,6 +50,13 @@ func (store *Store) URL() *url.URL {
 	return store.url
 }
 
+// AssertionsURL returns the URL of the store's assertions API, when
+// assertions are served separately, for example by a mirror. It is
+// nil otherwise.
+func (store *Store) AssertionsURL() *url.URL {
+	return store.assertionsURL
+}
+
 // FriendlyStores returns stores holding snaps that are also exposed
 // through this one.
 func (store *Store) FriendlyStores() []string {
@@ -94,9 +102,9 @@ func (store *Store) Prerequisites() []*Ref {
 	}
 }
 
-// checkStoreURL validates the "url" header and returns a full URL or nil.
-func checkStoreURL(headers map[string]interface{}) (*url.URL, error) {
-	s, err := checkOptionalString(headers, "url")
+// checkStoreURL validates the given URL header and returns a full URL or nil.
+func checkStoreURL(headers map[string]interface{}, name string) (*url.URL, error) {
+	s, err := checkOptionalString(headers, name)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +113,7 @@ func checkStoreURL(headers map[string]interface{}) (*url.URL, error) {
 		return nil, nil
 	}
 
-	errWhat := `"url" header`
+	errWhat := fmt.Sprintf("%q header", name)
 
 	u, err := url.Parse(s)
 	if err != nil {
@@ -133,7 +141,12 @@ func assembleStore(assert assertionBase) (Assertion, error) {
 		return nil, err
 	}
 
-	url, err := checkStoreURL(assert.headers)
+	url, err := checkStoreURL(assert.headers, "url")
+	if err != nil {
+		return nil, err
+	}
+
+	assertionsURL, err := checkStoreURL(assert.headers, "assertions-url")
 	if err != nil {
 		return nil, err
 	}
@@ -156,6 +169,7 @@ func assembleStore(assert assertionBase) (Assertion, error) {
 	return &Store{
 		assertionBase:  assert,
 		url:            url,
+		assertionsURL:  assertionsURL,
 		friendlyStores: friendlyStores,
 		timestamp:      timestamp,
 	}, nil
@@ -64,6 +64,7 @@ func (s *storeSuite) TestDecodeOK(c *C) {
 	c.Check(store.Location(), Equals, "upstairs")
 	c.Check(store.Timestamp().Equal(s.ts), Equals, true)
 	c.Check(store.FriendlyStores(), HasLen, 0)
+	c.Check(store.AssertionsURL(), IsNil)
 }
 
 var storeErrPrefix = "assertion store: "
@@ -142,6 +143,21 @@ func (s *storeSuite) TestURL(c *C) {
 	}
 }
 
+func (s *storeSuite) TestAssertionsURL(c *C) {
+	encoded := strings.Replace(s.validExample, "url: https://store.example.com\n",
+		"url: https://store.example.com\nassertions-url: https://assertions.example.com/mirror\n", 1)
+	assert, err := asserts.Decode([]byte(encoded))
+	c.Assert(err, IsNil)
+	store := assert.(*asserts.Store)
+	c.Check(store.URL().String(), Equals, "https://store.example.com")
+	c.Check(store.AssertionsURL().String(), Equals, "https://assertions.example.com/mirror")
+
+	encoded = strings.Replace(s.validExample, "url: https://store.example.com\n",
+		"assertions-url: ftp://assertions.example.com\n", 1)
+	_, err = asserts.Decode([]byte(encoded))
+	c.Check(err, ErrorMatches, storeErrPrefix+`"assertions-url" header scheme must be "https" or "http": ftp://assertions.example.com`)
+}
+
 func (s *storeSuite) TestLocationOptional(c *C) {
 	encoded := strings.Replace(s.validExample, "location: upstairs\n", "", 1)
 	_, err := asserts.Decode([]byte(encoded))
@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/gadget"
+)
+
+func init() {
+	// gadget updates carry the boot variables over to the
+	// bootloader environments they replace
+	for name, migrate := range bootloader.EnvMigrations() {
+		gadget.RegisterBootConfigMigration(name, migrate)
+	}
+}
+
+// BootAssetsModifiedError is returned by CheckBootAssets when boot
+// assets were modified, or removed, since they were written by a
+// gadget update.
+type BootAssetsModifiedError struct {
+	// Paths are the paths of the modified boot assets.
+	Paths []string
+}
+
+func (e *BootAssetsModifiedError) Error() string {
+	return fmt.Sprintf("boot assets modified outside of gadget updates: %s", strings.Join(e.Paths, ", "))
+}
+
+// CheckBootAssets checks the boot assets against the digests recorded
+// when they were last written by a gadget update, keyed by their path,
+// to detect out-of-band modifications, e.g. before resealing keys
+// against them. It returns a *BootAssetsModifiedError listing the
+// modified ones.
+func CheckBootAssets(tracked map[string]string) error {
+	var modified []string
+	for path, digest := range tracked {
+		current, err := gadget.BootAssetDigest(path)
+		if os.IsNotExist(err) {
+			modified = append(modified, path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot check boot asset: %v", err)
+		}
+		if current != digest {
+			modified = append(modified, path)
+		}
+	}
+	if len(modified) != 0 {
+		sort.Strings(modified)
+		return &BootAssetsModifiedError{Paths: modified}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/sha3"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+)
+
+type assetsSuite struct{}
+
+var _ = Suite(&assetsSuite{})
+
+func (s *assetsSuite) TestCheckBootAssets(c *C) {
+	d := c.MkDir()
+	shim := filepath.Join(d, "bootx64.efi")
+	grub := filepath.Join(d, "grubx64.efi")
+	grubCfg := filepath.Join(d, "grub.cfg")
+	for _, p := range []string{shim, grub, grubCfg} {
+		c.Assert(ioutil.WriteFile(p, []byte(filepath.Base(p)), 0644), IsNil)
+	}
+	tracked := map[string]string{
+		shim:    fmt.Sprintf("%x", sha3.Sum384([]byte("bootx64.efi"))),
+		grub:    fmt.Sprintf("%x", sha3.Sum384([]byte("grubx64.efi"))),
+		grubCfg: fmt.Sprintf("%x", sha3.Sum384([]byte("grub.cfg"))),
+	}
+
+	c.Check(boot.CheckBootAssets(tracked), IsNil)
+	c.Check(boot.CheckBootAssets(nil), IsNil)
+
+	// modified out-of-band
+	c.Assert(ioutil.WriteFile(grubCfg, []byte("tampered"), 0644), IsNil)
+	// removed
+	c.Assert(os.Remove(shim), IsNil)
+
+	err := boot.CheckBootAssets(tracked)
+	c.Assert(err, FitsTypeOf, &boot.BootAssetsModifiedError{})
+	c.Check(err.(*boot.BootAssetsModifiedError).Paths, DeepEquals, []string{shim, grubCfg})
+	c.Check(err, ErrorMatches, fmt.Sprintf("boot assets modified outside of gadget updates: %s, %s", shim, grubCfg))
+}
@@ -50,6 +50,8 @@ type MockBootloader struct {
 
 	ExtractKernelAssetsCalls []*snap.Info
 	RemoveKernelAssetsCalls  []snap.PlaceInfo
+
+	KernelCmdlineUnsupported bool
 }
 
 func NewMockBootloader(name, bootdir string) *MockBootloader {
@@ -94,3 +96,11 @@ func (b *MockBootloader) RemoveKernelAssets(s snap.PlaceInfo) error {
 	b.RemoveKernelAssetsCalls = append(b.RemoveKernelAssetsCalls, s)
 	return nil
 }
+
+func (b *MockBootloader) SupportsKernelCmdline(full bool) (bool, error) {
+	return !b.KernelCmdlineUnsupported, nil
+}
+
+func (b *MockBootloader) HasKernelSlots() (bool, error) {
+	return b.BootVars["snap_ab_slot"] != "", nil
+}
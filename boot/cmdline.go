@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/release"
+)
+
+const (
+	// extraCmdlineArgsVar holds the kernel command line arguments
+	// the bootloader appends to its default ones.
+	extraCmdlineArgsVar = "snapd_extra_cmdline_args"
+	// fullCmdlineArgsVar holds the kernel command line the
+	// bootloader uses instead of its default one.
+	fullCmdlineArgsVar = "snapd_full_cmdline_args"
+)
+
+// KernelCmdlineBootVars returns the bootloader variables to set for
+// the given loader to boot with the kernel command line provided by
+// the gadget, or with the default one if cmdline is nil. It fails if
+// the gadget provides a kernel command line the loader cannot use.
+func KernelCmdlineBootVars(loader bootloader.Bootloader, cmdline *gadget.KernelCmdline) (map[string]string, error) {
+	if cmdline == nil {
+		cmdline = &gadget.KernelCmdline{}
+	}
+	m := map[string]string{
+		extraCmdlineArgsVar: cmdline.Extra,
+		fullCmdlineArgsVar:  cmdline.Full,
+	}
+	if cmdline.Extra == "" && cmdline.Full == "" {
+		return m, nil
+	}
+
+	full := cmdline.Full != ""
+	supported := false
+	if cl, ok := loader.(bootloader.KernelCmdlineBootloader); ok {
+		var err error
+		supported, err = cl.SupportsKernelCmdline(full)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check kernel command line support of %s: %v", loader.Name(), err)
+		}
+	}
+	if !supported {
+		kind := "extra"
+		if full {
+			kind = "full"
+		}
+		return nil, fmt.Errorf("cannot set %s kernel command line: not supported by %s", kind, loader.Name())
+	}
+	return m, nil
+}
+
+// CurrentKernelCmdline returns the kernel command line provided by the
+// gadget that the bootloader is set up to boot with, or nil if it boots
+// with the default one.
+func CurrentKernelCmdline() (*gadget.KernelCmdline, error) {
+	loader, err := bootloader.Find()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get kernel command line: %s", err)
+	}
+	m, err := loader.GetBootVars(extraCmdlineArgsVar, fullCmdlineArgsVar)
+	if err != nil {
+		return nil, err
+	}
+	if m[extraCmdlineArgsVar] == "" && m[fullCmdlineArgsVar] == "" {
+		return nil, nil
+	}
+	return &gadget.KernelCmdline{
+		Extra: m[extraCmdlineArgsVar],
+		Full:  m[fullCmdlineArgsVar],
+	}, nil
+}
+
+// SetKernelCmdline sets up the bootloader to boot with the kernel
+// command line provided by the gadget, or with the default one if
+// cmdline is nil. It returns whether the kernel command line changed,
+// in which case a reboot is needed for it to take effect.
+func SetKernelCmdline(cmdline *gadget.KernelCmdline) (changed bool, err error) {
+	if release.OnClassic {
+		return false, fmt.Errorf("cannot set kernel command line on classic systems")
+	}
+
+	loader, err := bootloader.Find()
+	if err != nil {
+		return false, fmt.Errorf("cannot set kernel command line: %s", err)
+	}
+
+	vars, err := KernelCmdlineBootVars(loader, cmdline)
+	if err != nil {
+		return false, err
+	}
+	m, err := loader.GetBootVars(extraCmdlineArgsVar, fullCmdlineArgsVar)
+	if err != nil {
+		return false, err
+	}
+	if m[extraCmdlineArgsVar] == vars[extraCmdlineArgsVar] && m[fullCmdlineArgsVar] == vars[fullCmdlineArgsVar] {
+		return false, nil
+	}
+
+	if err := loader.SetBootVars(vars); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/release"
+)
+
+type cmdlineSuite struct {
+	baseKernelOSSuite
+
+	loader *boottest.MockBootloader
+}
+
+var _ = Suite(&cmdlineSuite{})
+
+func (s *cmdlineSuite) SetUpTest(c *C) {
+	s.baseKernelOSSuite.SetUpTest(c)
+
+	s.loader = boottest.NewMockBootloader("mock", c.MkDir())
+	bootloader.Force(s.loader)
+	s.AddCleanup(func() { bootloader.Force(nil) })
+}
+
+func (s *cmdlineSuite) TestSetKernelCmdlineExtra(c *C) {
+	changed, err := boot.SetKernelCmdline(&gadget.KernelCmdline{Extra: "console=ttyS0 isolcpus=1,2"})
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(s.loader.BootVars, DeepEquals, map[string]string{
+		"snapd_extra_cmdline_args": "console=ttyS0 isolcpus=1,2",
+		"snapd_full_cmdline_args":  "",
+	})
+
+	// setting it again is a no-op
+	changed, err = boot.SetKernelCmdline(&gadget.KernelCmdline{Extra: "console=ttyS0 isolcpus=1,2"})
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+}
+
+func (s *cmdlineSuite) TestCurrentKernelCmdline(c *C) {
+	cmdline, err := boot.CurrentKernelCmdline()
+	c.Assert(err, IsNil)
+	c.Check(cmdline, IsNil)
+
+	_, err = boot.SetKernelCmdline(&gadget.KernelCmdline{Full: "console=tty1"})
+	c.Assert(err, IsNil)
+	cmdline, err = boot.CurrentKernelCmdline()
+	c.Assert(err, IsNil)
+	c.Check(cmdline, DeepEquals, &gadget.KernelCmdline{Full: "console=tty1"})
+
+	_, err = boot.SetKernelCmdline(nil)
+	c.Assert(err, IsNil)
+	cmdline, err = boot.CurrentKernelCmdline()
+	c.Assert(err, IsNil)
+	c.Check(cmdline, IsNil)
+}
+
+func (s *cmdlineSuite) TestSetKernelCmdlineFullThenDefault(c *C) {
+	changed, err := boot.SetKernelCmdline(&gadget.KernelCmdline{Full: "console=tty1 quiet"})
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(s.loader.BootVars, DeepEquals, map[string]string{
+		"snapd_extra_cmdline_args": "",
+		"snapd_full_cmdline_args":  "console=tty1 quiet",
+	})
+
+	changed, err = boot.SetKernelCmdline(nil)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(s.loader.BootVars, DeepEquals, map[string]string{
+		"snapd_extra_cmdline_args": "",
+		"snapd_full_cmdline_args":  "",
+	})
+}
+
+func (s *cmdlineSuite) TestSetKernelCmdlineDefaultUnchanged(c *C) {
+	changed, err := boot.SetKernelCmdline(nil)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+	c.Check(s.loader.BootVars, HasLen, 0)
+}
+
+func (s *cmdlineSuite) TestSetKernelCmdlineUnsupported(c *C) {
+	s.loader.KernelCmdlineUnsupported = true
+
+	_, err := boot.SetKernelCmdline(&gadget.KernelCmdline{Extra: "console=ttyS0"})
+	c.Assert(err, ErrorMatches, "cannot set extra kernel command line: not supported by mock")
+	_, err = boot.SetKernelCmdline(&gadget.KernelCmdline{Full: "console=ttyS0"})
+	c.Assert(err, ErrorMatches, "cannot set full kernel command line: not supported by mock")
+	c.Check(s.loader.BootVars, HasLen, 0)
+
+	// going back to the default command line is always possible
+	changed, err := boot.SetKernelCmdline(nil)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+}
+
+func (s *cmdlineSuite) TestKernelCmdlineBootVars(c *C) {
+	vars, err := boot.KernelCmdlineBootVars(s.loader, &gadget.KernelCmdline{Extra: "quiet"})
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string]string{
+		"snapd_extra_cmdline_args": "quiet",
+		"snapd_full_cmdline_args":  "",
+	})
+	// nothing is set on the bootloader
+	c.Check(s.loader.BootVars, HasLen, 0)
+}
+
+func (s *cmdlineSuite) TestSetKernelCmdlineOnClassic(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	_, err := boot.SetKernelCmdline(nil)
+	c.Assert(err, ErrorMatches, "cannot set kernel command line on classic systems")
+}
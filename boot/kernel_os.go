@@ -88,6 +88,12 @@ func SetNextBoot(s *snap.Info) error {
 	}
 	blobName := filepath.Base(s.MountFile())
 
+	if s.GetType() == snap.TypeKernel {
+		if err := setNextBootKernelSlot(bootloader, blobName); err != nil {
+			return fmt.Errorf("cannot set next boot kernel slot: %v", err)
+		}
+	}
+
 	// check if we actually need to do anything, i.e. the exact same
 	// kernel/core revision got installed again (e.g. firstboot)
 	// and we are not in any special boot mode
@@ -115,6 +121,35 @@ func SetNextBoot(s *snap.Info) error {
 	})
 }
 
+// SetBootSnaps makes the given OS or base and kernel snap files the
+// ones used for the next and the following boots, discarding any boot
+// in progress. It is used to go back to the seeded snaps when resetting
+// the device.
+func SetBootSnaps(baseBlobName, kernelBlobName string) error {
+	if release.OnClassic {
+		return fmt.Errorf("cannot set boot snaps on classic systems")
+	}
+
+	bootloader, err := bootloader.Find()
+	if err != nil {
+		return fmt.Errorf("cannot set boot snaps: %s", err)
+	}
+
+	m, err := KernelSlotsBootVars(bootloader, kernelBlobName)
+	if err != nil {
+		return fmt.Errorf("cannot set boot snaps: %v", err)
+	}
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m["snap_core"] = baseBlobName
+	m["snap_kernel"] = kernelBlobName
+	m["snap_try_core"] = ""
+	m["snap_try_kernel"] = ""
+	m["snap_mode"] = ""
+	return bootloader.SetBootVars(m)
+}
+
 // ChangeRequiresReboot returns whether a reboot is required to switch
 // to the given OS, base or kernel snap.
 func ChangeRequiresReboot(s *snap.Info) bool {
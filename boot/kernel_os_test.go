@@ -214,6 +214,34 @@ func (s *kernelOSSuite) TestSetNextBootForKernelForTheSameKernelTryMode(c *C) {
 	})
 }
 
+func (s *kernelOSSuite) TestSetBootSnaps(c *C) {
+	s.loader.BootVars = map[string]string{
+		"snap_core":       "core_2.snap",
+		"snap_kernel":     "kernel_3.snap",
+		"snap_try_kernel": "kernel_4.snap",
+		"snap_mode":       "trying",
+	}
+
+	err := boot.SetBootSnaps("core_1.snap", "kernel_1.snap")
+	c.Assert(err, IsNil)
+	c.Check(s.loader.BootVars, DeepEquals, map[string]string{
+		"snap_core":       "core_1.snap",
+		"snap_kernel":     "kernel_1.snap",
+		"snap_try_core":   "",
+		"snap_try_kernel": "",
+		"snap_mode":       "",
+	})
+}
+
+func (s *kernelOSSuite) TestSetBootSnapsOnClassic(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	err := boot.SetBootSnaps("core_1.snap", "kernel_1.snap")
+	c.Assert(err, ErrorMatches, "cannot set boot snaps on classic systems")
+	c.Assert(s.loader.BootVars, HasLen, 0)
+}
+
 func (s *kernelOSSuite) TestInUse(c *C) {
 	for _, t := range []struct {
 		bootVarKey   string
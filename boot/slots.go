@@ -0,0 +1,308 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/snapcore/snapd/bootloader"
+)
+
+// Bootloaders of Android-derived boards keep the kernel in one of two
+// A/B slots, booting the active one and falling back to the other slot
+// once the retries of an active slot that was not marked successful
+// are exhausted. Such bootloaders implement
+// bootloader.KernelSlotsBootloader and keep the slot metadata in the
+// following boot variables:
+//
+//   - snap_ab_slot: the name of the active slot, "a" or "b"
+//   - snap_ab_<slot>_kernel: the kernel snap file in the slot
+//   - snap_ab_<slot>_successful: "1" once the slot booted successfully
+//   - snap_ab_<slot>_retries: the boot tries left for the slot
+const (
+	abSlotVar = "snap_ab_slot"
+
+	// DefaultKernelSlotRetries is the number of boot tries given to
+	// a slot that is tried.
+	DefaultKernelSlotRetries = 3
+)
+
+var kernelSlotNames = []string{"a", "b"}
+
+// ErrNoKernelSlots is returned when the bootloader does not boot the
+// kernel from A/B slots.
+var ErrNoKernelSlots = errors.New("bootloader does not expose kernel slots")
+
+// KernelSlot is the state of one of the A/B kernel slots.
+type KernelSlot struct {
+	Name       string
+	Kernel     string
+	Successful bool
+	RetryCount int
+}
+
+// KernelSlots is the state of the A/B kernel slots of a bootloader.
+type KernelSlots struct {
+	ActiveSlot string
+	Slots      []*KernelSlot
+}
+
+func kernelSlotVar(slot, what string) string {
+	return fmt.Sprintf("snap_ab_%s_%s", slot, what)
+}
+
+// ReadKernelSlots reads the state of the A/B kernel slots from the
+// given bootloader. It returns ErrNoKernelSlots if the bootloader
+// does not boot the kernel from A/B slots.
+func ReadKernelSlots(loader bootloader.Bootloader) (*KernelSlots, error) {
+	sl, ok := loader.(bootloader.KernelSlotsBootloader)
+	if !ok {
+		return nil, ErrNoKernelSlots
+	}
+	hasSlots, err := sl.HasKernelSlots()
+	if err != nil {
+		return nil, fmt.Errorf("cannot check kernel slots of %s: %v", loader.Name(), err)
+	}
+	if !hasSlots {
+		return nil, ErrNoKernelSlots
+	}
+
+	names := []string{abSlotVar}
+	for _, slot := range kernelSlotNames {
+		names = append(names, kernelSlotVar(slot, "kernel"), kernelSlotVar(slot, "successful"), kernelSlotVar(slot, "retries"))
+	}
+	m, err := loader.GetBootVars(names...)
+	if err != nil {
+		return nil, err
+	}
+	if m[abSlotVar] == "" {
+		return nil, ErrNoKernelSlots
+	}
+
+	ks := &KernelSlots{ActiveSlot: m[abSlotVar]}
+	for _, slot := range kernelSlotNames {
+		retries := 0
+		if v := m[kernelSlotVar(slot, "retries")]; v != "" {
+			retries, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse retries of kernel slot %q: %v", slot, err)
+			}
+		}
+		ks.Slots = append(ks.Slots, &KernelSlot{
+			Name:       slot,
+			Kernel:     m[kernelSlotVar(slot, "kernel")],
+			Successful: m[kernelSlotVar(slot, "successful")] == "1",
+			RetryCount: retries,
+		})
+	}
+	if ks.Active() == nil {
+		return nil, fmt.Errorf("invalid active kernel slot %q", ks.ActiveSlot)
+	}
+	return ks, nil
+}
+
+// Write writes the state of the A/B kernel slots to the given
+// bootloader.
+func (ks *KernelSlots) Write(loader bootloader.Bootloader) error {
+	return loader.SetBootVars(ks.bootVars())
+}
+
+func (ks *KernelSlots) bootVars() map[string]string {
+	m := map[string]string{
+		abSlotVar: ks.ActiveSlot,
+	}
+	for _, slot := range ks.Slots {
+		successful := ""
+		if slot.Successful {
+			successful = "1"
+		}
+		m[kernelSlotVar(slot.Name, "kernel")] = slot.Kernel
+		m[kernelSlotVar(slot.Name, "successful")] = successful
+		m[kernelSlotVar(slot.Name, "retries")] = strconv.Itoa(slot.RetryCount)
+	}
+	return m
+}
+
+// Active returns the active slot.
+func (ks *KernelSlots) Active() *KernelSlot {
+	for _, slot := range ks.Slots {
+		if slot.Name == ks.ActiveSlot {
+			return slot
+		}
+	}
+	return nil
+}
+
+// Inactive returns the slot that is not active.
+func (ks *KernelSlots) Inactive() *KernelSlot {
+	for _, slot := range ks.Slots {
+		if slot.Name != ks.ActiveSlot {
+			return slot
+		}
+	}
+	return nil
+}
+
+// TryKernel puts the given kernel snap file in the inactive slot and
+// makes it the active one with the given number of boot tries. If the
+// kernel is already in the active slot this only resets its state.
+func (ks *KernelSlots) TryKernel(kernel string, retries int) {
+	slot := ks.Active()
+	if slot.Kernel != kernel {
+		slot = ks.Inactive()
+		slot.Kernel = kernel
+		ks.ActiveSlot = slot.Name
+	}
+	slot.Successful = false
+	slot.RetryCount = retries
+}
+
+// MarkActiveSuccessful marks the active slot as booted successfully.
+func (ks *KernelSlots) MarkActiveSuccessful() {
+	slot := ks.Active()
+	slot.Successful = true
+	slot.RetryCount = 0
+}
+
+// KernelSlotsBootVars returns the boot variables that put the given
+// kernel snap file in the active slot, as booted successfully, if the
+// bootloader boots the kernel from A/B slots, or no variables
+// otherwise.
+func KernelSlotsBootVars(loader bootloader.Bootloader, kernel string) (map[string]string, error) {
+	ks, err := ReadKernelSlots(loader)
+	if err == ErrNoKernelSlots {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ks.Active().Kernel = kernel
+	ks.MarkActiveSuccessful()
+	return ks.bootVars(), nil
+}
+
+// setNextBootKernelSlot maps trying the given kernel snap file onto
+// the A/B kernel slots, if the bootloader boots the kernel from them.
+func setNextBootKernelSlot(loader bootloader.Bootloader, kernel string) error {
+	ks, err := ReadKernelSlots(loader)
+	if err == ErrNoKernelSlots {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ks.Active().Kernel == "" {
+		// the slots were declared after the image was made, the
+		// current kernel is the one in the active slot
+		m, err := loader.GetBootVars("snap_kernel")
+		if err != nil {
+			return err
+		}
+		ks.Active().Kernel = m["snap_kernel"]
+		ks.MarkActiveSuccessful()
+	}
+	if ks.Active().Kernel == kernel && ks.Active().Successful {
+		// nothing to try
+		return nil
+	}
+	ks.TryKernel(kernel, DefaultKernelSlotRetries)
+	return ks.Write(loader)
+}
+
+// MarkBootSuccessful marks the current boot as successful, like
+// bootloader.MarkBootSuccessful, also taking care of the A/B kernel
+// slots if the bootloader boots the kernel from them: the active slot is marked as
+// successful, and if the bootloader fell back from a tried slot the
+// try is abandoned.
+func MarkBootSuccessful(loader bootloader.Bootloader) error {
+	ks, err := ReadKernelSlots(loader)
+	if err != nil && err != ErrNoKernelSlots {
+		return err
+	}
+	// slots that hold no kernel yet only get one from the next
+	// kernel tried
+	if ks != nil && ks.Active().Kernel != "" {
+		m, err := loader.GetBootVars("snap_mode", "snap_try_kernel")
+		if err != nil {
+			return err
+		}
+		active := ks.Active()
+		if m["snap_try_kernel"] != "" && m["snap_try_kernel"] != active.Kernel {
+			// the bootloader fell back to the other slot,
+			// the kernel in the active slot is the good one
+			if err := loader.SetBootVars(map[string]string{
+				"snap_mode":       "",
+				"snap_try_kernel": "",
+				"snap_kernel":     active.Kernel,
+			}); err != nil {
+				return err
+			}
+		} else if m["snap_mode"] == "try" {
+			// the slot bootloader does not track snap_mode,
+			// booting the active slot means trying it
+			if err := loader.SetBootVars(map[string]string{"snap_mode": "trying"}); err != nil {
+				return err
+			}
+		}
+		if !active.Successful {
+			ks.MarkActiveSuccessful()
+			if err := ks.Write(loader); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bootloader.MarkBootSuccessful(loader)
+}
+
+// DumpBootVars writes the boot variables used by snapd, including the
+// ones of the A/B kernel slots if the bootloader boots the kernel from
+// them, to the
+// given writer.
+func DumpBootVars(w io.Writer) error {
+	loader, err := bootloader.Find()
+	if err != nil {
+		return fmt.Errorf("cannot get boot settings: %s", err)
+	}
+
+	names := []string{"snap_mode", "snap_core", "snap_try_core", "snap_kernel", "snap_try_kernel"}
+	ks, err := ReadKernelSlots(loader)
+	if err != nil && err != ErrNoKernelSlots {
+		return err
+	}
+	if ks != nil {
+		names = append(names, abSlotVar)
+		for _, slot := range ks.Slots {
+			names = append(names, kernelSlotVar(slot.Name, "kernel"), kernelSlotVar(slot.Name, "successful"), kernelSlotVar(slot.Name, "retries"))
+		}
+	}
+
+	m, err := loader.GetBootVars(names...)
+	if err != nil {
+		return fmt.Errorf("cannot get boot variables: %s", err)
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "%s=%s\n", name, m[name])
+	}
+	return nil
+}
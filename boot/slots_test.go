@@ -0,0 +1,284 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package boot_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/snap"
+)
+
+type kernelSlotsSuite struct {
+	baseKernelOSSuite
+
+	loader *boottest.MockBootloader
+}
+
+var _ = Suite(&kernelSlotsSuite{})
+
+func (s *kernelSlotsSuite) SetUpTest(c *C) {
+	s.baseKernelOSSuite.SetUpTest(c)
+
+	s.loader = boottest.NewMockBootloader("mock", c.MkDir())
+	bootloader.Force(s.loader)
+	s.AddCleanup(func() { bootloader.Force(nil) })
+}
+
+func (s *kernelSlotsSuite) mockSlots() {
+	s.loader.SetBootVars(map[string]string{
+		"snap_ab_slot":         "a",
+		"snap_ab_a_kernel":     "krnl_40.snap",
+		"snap_ab_a_successful": "1",
+		"snap_ab_a_retries":    "0",
+		"snap_kernel":          "krnl_40.snap",
+		"snap_core":            "core_1.snap",
+	})
+}
+
+func (s *kernelSlotsSuite) TestReadKernelSlotsNone(c *C) {
+	_, err := boot.ReadKernelSlots(s.loader)
+	c.Check(err, Equals, boot.ErrNoKernelSlots)
+}
+
+func (s *kernelSlotsSuite) TestReadKernelSlots(c *C) {
+	s.mockSlots()
+
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks, DeepEquals, &boot.KernelSlots{
+		ActiveSlot: "a",
+		Slots: []*boot.KernelSlot{
+			{Name: "a", Kernel: "krnl_40.snap", Successful: true},
+			{Name: "b"},
+		},
+	})
+	c.Check(ks.Active().Name, Equals, "a")
+	c.Check(ks.Inactive().Name, Equals, "b")
+}
+
+func (s *kernelSlotsSuite) TestReadKernelSlotsInvalid(c *C) {
+	s.loader.BootVars["snap_ab_slot"] = "c"
+	_, err := boot.ReadKernelSlots(s.loader)
+	c.Check(err, ErrorMatches, `invalid active kernel slot "c"`)
+
+	s.loader.BootVars["snap_ab_slot"] = "a"
+	s.loader.BootVars["snap_ab_b_retries"] = "many"
+	_, err = boot.ReadKernelSlots(s.loader)
+	c.Check(err, ErrorMatches, `cannot parse retries of kernel slot "b": .*`)
+}
+
+func (s *kernelSlotsSuite) TestTryKernel(c *C) {
+	s.mockSlots()
+
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	ks.TryKernel("krnl_42.snap", 3)
+	c.Check(ks.ActiveSlot, Equals, "b")
+	c.Check(ks.Active(), DeepEquals, &boot.KernelSlot{Name: "b", Kernel: "krnl_42.snap", RetryCount: 3})
+
+	// trying again the same kernel only resets its state
+	ks.TryKernel("krnl_42.snap", 2)
+	c.Check(ks.ActiveSlot, Equals, "b")
+	c.Check(ks.Active().RetryCount, Equals, 2)
+	c.Check(ks.Inactive().Kernel, Equals, "krnl_40.snap")
+
+	err = ks.Write(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(s.loader.BootVars["snap_ab_slot"], Equals, "b")
+	c.Check(s.loader.BootVars["snap_ab_b_kernel"], Equals, "krnl_42.snap")
+	c.Check(s.loader.BootVars["snap_ab_b_successful"], Equals, "")
+	c.Check(s.loader.BootVars["snap_ab_b_retries"], Equals, "2")
+}
+
+func (s *kernelSlotsSuite) setNextBootKernel(c *C) {
+	info := &snap.Info{}
+	info.SnapType = snap.TypeKernel
+	info.RealName = "krnl"
+	info.Revision = snap.R(42)
+
+	err := boot.SetNextBoot(info)
+	c.Assert(err, IsNil)
+
+	c.Check(s.loader.BootVars["snap_try_kernel"], Equals, "krnl_42.snap")
+	c.Check(s.loader.BootVars["snap_mode"], Equals, "try")
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.ActiveSlot, Equals, "b")
+	c.Check(ks.Active(), DeepEquals, &boot.KernelSlot{Name: "b", Kernel: "krnl_42.snap", RetryCount: boot.DefaultKernelSlotRetries})
+}
+
+func (s *kernelSlotsSuite) TestSetNextBootKernelSlotsHappy(c *C) {
+	s.mockSlots()
+	s.setNextBootKernel(c)
+
+	// the bootloader boots slot b
+	err := boot.MarkBootSuccessful(s.loader)
+	c.Assert(err, IsNil)
+
+	c.Check(s.loader.BootVars["snap_kernel"], Equals, "krnl_42.snap")
+	c.Check(s.loader.BootVars["snap_try_kernel"], Equals, "")
+	c.Check(s.loader.BootVars["snap_mode"], Equals, "")
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.ActiveSlot, Equals, "b")
+	c.Check(ks.Active().Successful, Equals, true)
+	c.Check(ks.Active().RetryCount, Equals, 0)
+}
+
+func (s *kernelSlotsSuite) TestSetNextBootKernelSlotsFallback(c *C) {
+	s.mockSlots()
+	s.setNextBootKernel(c)
+
+	// the retries of slot b got exhausted and the bootloader went
+	// back to slot a
+	s.loader.BootVars["snap_ab_slot"] = "a"
+	s.loader.BootVars["snap_ab_b_retries"] = "0"
+
+	err := boot.MarkBootSuccessful(s.loader)
+	c.Assert(err, IsNil)
+
+	c.Check(s.loader.BootVars["snap_kernel"], Equals, "krnl_40.snap")
+	c.Check(s.loader.BootVars["snap_try_kernel"], Equals, "")
+	c.Check(s.loader.BootVars["snap_mode"], Equals, "")
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.ActiveSlot, Equals, "a")
+	c.Check(ks.Active().Successful, Equals, true)
+}
+
+func (s *kernelSlotsSuite) TestSetNextBootKernelSameKernelNoop(c *C) {
+	s.mockSlots()
+
+	info := &snap.Info{}
+	info.SnapType = snap.TypeKernel
+	info.RealName = "krnl"
+	info.Revision = snap.R(40)
+
+	err := boot.SetNextBoot(info)
+	c.Assert(err, IsNil)
+
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.ActiveSlot, Equals, "a")
+	c.Check(ks.Active().Successful, Equals, true)
+}
+
+func (s *kernelSlotsSuite) TestSetNextBootKernelSlotsDeclaredLater(c *C) {
+	// the gadget declared the slots after the image was made
+	s.loader.SetBootVars(map[string]string{
+		"snap_ab_slot": "a",
+		"snap_kernel":  "krnl_40.snap",
+	})
+	s.setNextBootKernel(c)
+
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.Inactive(), DeepEquals, &boot.KernelSlot{Name: "a", Kernel: "krnl_40.snap", Successful: true})
+}
+
+func (s *kernelSlotsSuite) TestKernelSlotsBootVars(c *C) {
+	m, err := boot.KernelSlotsBootVars(s.loader, "krnl_40.snap")
+	c.Assert(err, IsNil)
+	c.Check(m, IsNil)
+
+	s.loader.SetBootVars(map[string]string{"snap_ab_slot": "b"})
+	m, err = boot.KernelSlotsBootVars(s.loader, "krnl_40.snap")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_ab_slot":         "b",
+		"snap_ab_a_kernel":     "",
+		"snap_ab_a_successful": "",
+		"snap_ab_a_retries":    "0",
+		"snap_ab_b_kernel":     "krnl_40.snap",
+		"snap_ab_b_successful": "1",
+		"snap_ab_b_retries":    "0",
+	})
+}
+
+func (s *kernelSlotsSuite) TestSetBootSnapsKernelSlots(c *C) {
+	s.mockSlots()
+	s.setNextBootKernel(c)
+
+	err := boot.SetBootSnaps("core_1.snap", "krnl_30.snap")
+	c.Assert(err, IsNil)
+
+	c.Check(s.loader.BootVars["snap_kernel"], Equals, "krnl_30.snap")
+	c.Check(s.loader.BootVars["snap_try_kernel"], Equals, "")
+	ks, err := boot.ReadKernelSlots(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(ks.ActiveSlot, Equals, "b")
+	c.Check(ks.Active(), DeepEquals, &boot.KernelSlot{Name: "b", Kernel: "krnl_30.snap", Successful: true})
+}
+
+func (s *kernelSlotsSuite) TestMarkBootSuccessfulNoSlots(c *C) {
+	s.loader.SetBootVars(map[string]string{
+		"snap_mode":       "trying",
+		"snap_kernel":     "krnl_40.snap",
+		"snap_try_kernel": "krnl_42.snap",
+	})
+
+	err := boot.MarkBootSuccessful(s.loader)
+	c.Assert(err, IsNil)
+	c.Check(s.loader.BootVars["snap_kernel"], Equals, "krnl_42.snap")
+	c.Check(s.loader.BootVars["snap_mode"], Equals, "")
+	c.Check(s.loader.BootVars["snap_ab_slot"], Equals, "")
+}
+
+func (s *kernelSlotsSuite) TestDumpBootVars(c *C) {
+	s.mockSlots()
+
+	var buf bytes.Buffer
+	err := boot.DumpBootVars(&buf)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, `snap_mode=
+snap_core=core_1.snap
+snap_try_core=
+snap_kernel=krnl_40.snap
+snap_try_kernel=
+snap_ab_slot=a
+snap_ab_a_kernel=krnl_40.snap
+snap_ab_a_successful=1
+snap_ab_a_retries=0
+snap_ab_b_kernel=
+snap_ab_b_successful=
+snap_ab_b_retries=
+`)
+}
+
+func (s *kernelSlotsSuite) TestDumpBootVarsNoSlots(c *C) {
+	s.loader.SetBootVars(map[string]string{
+		"snap_kernel": "krnl_40.snap",
+	})
+
+	var buf bytes.Buffer
+	err := boot.DumpBootVars(&buf)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, `snap_mode=
+snap_core=
+snap_try_core=
+snap_kernel=krnl_40.snap
+snap_try_kernel=
+`)
+}
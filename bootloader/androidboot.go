@@ -77,6 +77,17 @@ func (a *androidboot) SetBootVars(values map[string]string) error {
 	return env.Save()
 }
 
+// HasKernelSlots returns whether the bootloader of the board boots the
+// kernel from A/B slots. Gadgets of such boards declare it by setting
+// the active slot, snap_ab_slot, in their androidboot.conf.
+func (a *androidboot) HasKernelSlots() (bool, error) {
+	m, err := a.GetBootVars("snap_ab_slot")
+	if err != nil {
+		return false, err
+	}
+	return m["snap_ab_slot"] != "", nil
+}
+
 func (a *androidboot) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
 	return nil
 
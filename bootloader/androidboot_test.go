@@ -94,3 +94,20 @@ func (s *androidBootTestSuite) TestExtractKernelAssetsNoUnpacksKernel(c *C) {
 	kernimg := filepath.Join(dirs.GlobalRootDir, "boot", "androidboot", "ubuntu-kernel_42.snap", "kernel.img")
 	c.Assert(osutil.FileExists(kernimg), Equals, false)
 }
+
+func (s *androidBootTestSuite) TestHasKernelSlots(c *C) {
+	a := bootloader.NewAndroidBoot()
+	sl, ok := a.(bootloader.KernelSlotsBootloader)
+	c.Assert(ok, Equals, true)
+
+	hasSlots, err := sl.HasKernelSlots()
+	c.Assert(err, IsNil)
+	c.Check(hasSlots, Equals, false)
+
+	// as declared by the androidboot.conf of the gadget
+	err = a.SetBootVars(map[string]string{"snap_ab_slot": "a"})
+	c.Assert(err, IsNil)
+	hasSlots, err = sl.HasKernelSlots()
+	c.Assert(err, IsNil)
+	c.Check(hasSlots, Equals, true)
+}
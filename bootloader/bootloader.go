@@ -68,10 +68,34 @@ type Bootloader interface {
 	RemoveKernelAssets(s snap.PlaceInfo) error
 }
 
+// KernelCmdlineBootloader is implemented by bootloaders that can boot
+// the kernel with the command line arguments set in the
+// snapd_extra_cmdline_args or snapd_full_cmdline_args variables.
+type KernelCmdlineBootloader interface {
+	Bootloader
+
+	// SupportsKernelCmdline returns whether the bootloader, as
+	// configured, uses the full kernel command line variable if full
+	// is set, or the extra arguments one otherwise.
+	SupportsKernelCmdline(full bool) (bool, error)
+}
+
+// KernelSlotsBootloader is implemented by bootloaders that can boot
+// the kernel from one of two A/B slots, falling back to the other slot
+// once the tries of a slot that did not boot successfully are
+// exhausted, see boot.KernelSlots for the variables holding the slots.
+type KernelSlotsBootloader interface {
+	Bootloader
+
+	// HasKernelSlots returns whether the bootloader, as configured,
+	// boots the kernel from A/B slots.
+	HasKernelSlots() (bool, error)
+}
+
 // InstallBootConfig installs the bootloader config from the gadget
 // snap dir into the right place.
 func InstallBootConfig(gadgetDir string) error {
-	for _, bl := range []Bootloader{&grub{}, &uboot{}, &androidboot{}} {
+	for _, bl := range []Bootloader{&grub{}, &uboot{}, &androidboot{}, &sdboot{}} {
 		// the bootloader config file has to be root of the gadget snap
 		gadgetFile := filepath.Join(gadgetDir, bl.Name()+".conf")
 		if !osutil.FileExists(gadgetFile) {
@@ -112,6 +136,11 @@ func Find() (Bootloader, error) {
 		return androidboot, nil
 	}
 
+	// no, try systemd-boot
+	if sdboot := newSdboot(); sdboot != nil {
+		return sdboot, nil
+	}
+
 	// no, weeeee
 	return nil, ErrBootloader
 }
@@ -22,6 +22,7 @@ package bootloader
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	. "gopkg.in/check.v1"
 
@@ -68,3 +69,15 @@ func MockGrubFiles(c *C) {
 	err = ioutil.WriteFile(g.ConfigFile(), nil, 0644)
 	c.Assert(err, IsNil)
 }
+
+func NewSdboot() Bootloader {
+	return newSdboot()
+}
+
+func MockSdbootFiles(c *C) {
+	sd := &sdboot{}
+	err := os.MkdirAll(filepath.Dir(sd.ConfigFile()), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(sd.ConfigFile(), []byte("default snapd-*\n"), 0644)
+	c.Assert(err, IsNil)
+}
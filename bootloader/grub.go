@@ -20,6 +20,8 @@
 package bootloader
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -94,3 +96,18 @@ func (g *grub) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
 func (g *grub) RemoveKernelAssets(s snap.PlaceInfo) error {
 	return removeKernelAssetsFromBootDir(g.dir(), s)
 }
+
+// SupportsKernelCmdline returns whether the grub configuration, which
+// comes from the gadget, passes the kernel command line variable
+// stored in grubenv to the kernel.
+func (g *grub) SupportsKernelCmdline(full bool) (bool, error) {
+	cfg, err := ioutil.ReadFile(g.ConfigFile())
+	if err != nil {
+		return false, err
+	}
+	name := "snapd_extra_cmdline_args"
+	if full {
+		name = "snapd_full_cmdline_args"
+	}
+	return bytes.Contains(cfg, []byte("$"+name)) || bytes.Contains(cfg, []byte("${"+name+"}")), nil
+}
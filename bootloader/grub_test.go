@@ -21,6 +21,7 @@ package bootloader_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os/exec"
 	"path/filepath"
 
@@ -115,6 +116,29 @@ func (s *grubTestSuite) TestGetBootloaderWithGrub(c *C) {
 	c.Assert(bootloader.Name(), Equals, "grub")
 }
 
+func (s *grubTestSuite) TestSupportsKernelCmdline(c *C) {
+	g := bootloader.NewGrub().(bootloader.KernelCmdlineBootloader)
+
+	for _, tc := range []struct {
+		cfg         string
+		extra, full bool
+	}{
+		{"linux $kernel/kernel.img root=LABEL=writable\n", false, false},
+		{"linux $kernel/kernel.img root=LABEL=writable $snapd_extra_cmdline_args\n", true, false},
+		{"if [ -n \"${snapd_full_cmdline_args}\" ]; then\n  linux $kernel/kernel.img $snapd_full_cmdline_args\nfi\n", false, true},
+	} {
+		err := ioutil.WriteFile(g.ConfigFile(), []byte(tc.cfg), 0644)
+		c.Assert(err, IsNil)
+
+		supported, err := g.SupportsKernelCmdline(false)
+		c.Assert(err, IsNil)
+		c.Check(supported, Equals, tc.extra, Commentf("%q", tc.cfg))
+		supported, err = g.SupportsKernelCmdline(true)
+		c.Assert(err, IsNil)
+		c.Check(supported, Equals, tc.full, Commentf("%q", tc.cfg))
+	}
+}
+
 func (s *grubTestSuite) TestGetBootVer(c *C) {
 	s.makeFakeGrubEnv(c)
 	grubEditenvSet(c, "snap_mode", "regular")
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader
+
+import (
+	"github.com/snapcore/snapd/bootloader/androidbootenv"
+	"github.com/snapcore/snapd/bootloader/grubenv"
+	"github.com/snapcore/snapd/bootloader/ubootenv"
+)
+
+// snapdBootVars are the boot variables managed by snapd, they must
+// survive the bootloader environment being replaced.
+var snapdBootVars = []string{
+	bootmodeVar,
+	"snap_core",
+	"snap_kernel",
+	"snap_try_core",
+	"snap_try_kernel",
+}
+
+// EnvMigrations returns, keyed by file name, the functions that carry
+// the boot variables managed by snapd over from the current
+// environment file of a bootloader to the one replacing it, as found
+// in a gadget update, instead of losing them.
+func EnvMigrations() map[string]func(current, update string) error {
+	return map[string]func(current, update string) error{
+		"grubenv":         migrateGrubEnv,
+		"uboot.env":       migrateUbootEnv,
+		"androidboot.env": migrateAndroidBootEnv,
+	}
+}
+
+type bootEnv interface {
+	Get(name string) string
+	Set(name, value string)
+	Save() error
+}
+
+func copySnapdBootVars(from, to bootEnv) error {
+	for _, name := range snapdBootVars {
+		to.Set(name, from.Get(name))
+	}
+	return to.Save()
+}
+
+func migrateGrubEnv(current, update string) error {
+	cur := grubenv.NewEnv(current)
+	if err := cur.Load(); err != nil {
+		return err
+	}
+	upd := grubenv.NewEnv(update)
+	if err := upd.Load(); err != nil {
+		return err
+	}
+	return copySnapdBootVars(cur, upd)
+}
+
+func migrateUbootEnv(current, update string) error {
+	cur, err := ubootenv.OpenWithFlags(current, ubootenv.OpenBestEffort)
+	if err != nil {
+		return err
+	}
+	upd, err := ubootenv.Open(update)
+	if err != nil {
+		return err
+	}
+	return copySnapdBootVars(cur, upd)
+}
+
+func migrateAndroidBootEnv(current, update string) error {
+	cur := androidbootenv.NewEnv(current)
+	if err := cur.Load(); err != nil {
+		return err
+	}
+	upd := androidbootenv.NewEnv(update)
+	if err := upd.Load(); err != nil {
+		return err
+	}
+	return copySnapdBootVars(cur, upd)
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader_test
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/bootloader/grubenv"
+	"github.com/snapcore/snapd/bootloader/ubootenv"
+)
+
+type migrateTestSuite struct{}
+
+var _ = Suite(&migrateTestSuite{})
+
+func (s *migrateTestSuite) TestEnvMigrations(c *C) {
+	migrations := bootloader.EnvMigrations()
+	c.Check(migrations, HasLen, 3)
+	for _, name := range []string{"grubenv", "uboot.env", "androidboot.env"} {
+		c.Check(migrations[name], NotNil, Commentf(name))
+	}
+}
+
+func (s *migrateTestSuite) TestMigrateGrubEnv(c *C) {
+	dir := c.MkDir()
+	current := filepath.Join(dir, "current")
+	update := filepath.Join(dir, "update")
+
+	env := grubenv.NewEnv(current)
+	env.Set("snap_mode", "try")
+	env.Set("snap_kernel", "pc-kernel_1.snap")
+	env.Set("snap_try_kernel", "pc-kernel_2.snap")
+	env.Set("old_gadget_var", "old")
+	c.Assert(env.Save(), IsNil)
+
+	env = grubenv.NewEnv(update)
+	env.Set("snap_kernel", "pc-kernel_0.snap")
+	env.Set("new_gadget_var", "new")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(bootloader.EnvMigrations()["grubenv"](current, update), IsNil)
+
+	env = grubenv.NewEnv(update)
+	c.Assert(env.Load(), IsNil)
+	c.Check(env.Get("snap_mode"), Equals, "try")
+	c.Check(env.Get("snap_kernel"), Equals, "pc-kernel_1.snap")
+	c.Check(env.Get("snap_try_kernel"), Equals, "pc-kernel_2.snap")
+	c.Check(env.Get("new_gadget_var"), Equals, "new")
+	c.Check(env.Get("old_gadget_var"), Equals, "")
+}
+
+func (s *migrateTestSuite) TestMigrateUbootEnv(c *C) {
+	dir := c.MkDir()
+	current := filepath.Join(dir, "current")
+	update := filepath.Join(dir, "update")
+
+	env, err := ubootenv.Create(current, 4096)
+	c.Assert(err, IsNil)
+	env.Set("snap_core", "core_1.snap")
+	env.Set("snap_kernel", "pi-kernel_1.snap")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = ubootenv.Create(update, 4096)
+	c.Assert(err, IsNil)
+	env.Set("snap_mode", "try")
+	env.Set("bootdelay", "1")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(bootloader.EnvMigrations()["uboot.env"](current, update), IsNil)
+
+	env, err = ubootenv.Open(update)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("snap_core"), Equals, "core_1.snap")
+	c.Check(env.Get("snap_kernel"), Equals, "pi-kernel_1.snap")
+	c.Check(env.Get("snap_mode"), Equals, "")
+	c.Check(env.Get("bootdelay"), Equals, "1")
+}
+
+func (s *migrateTestSuite) TestMigrateGrubEnvBroken(c *C) {
+	dir := c.MkDir()
+	current := filepath.Join(dir, "current")
+
+	err := bootloader.EnvMigrations()["grubenv"](current, filepath.Join(dir, "update"))
+	c.Check(err, ErrorMatches, "open .*/current: no such file or directory")
+}
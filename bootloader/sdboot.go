@@ -0,0 +1,227 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/bootloader/androidbootenv"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+)
+
+// sdboot implements the Bootloader interface for systemd-boot.
+//
+// systemd-boot cannot keep state of its own, so the boot variables are
+// stored in an environment file on the ESP and reflected into
+// BootLoaderSpec entries:
+//  - the run entry boots snap_kernel with snap_core
+//  - while snap_mode is "try", a try entry boots snap_try_kernel (or
+//    snap_kernel) with snap_try_core (or snap_core); it has one boot
+//    try left so that systemd-boot tries it only once, falling back
+//    to the run entry afterwards
+// The configuration from the gadget is expected to make systemd-boot
+// prefer the snapd entries, e.g. with "default snapd-*" in loader.conf.
+type sdboot struct{}
+
+// newSdboot creates a new systemd-boot bootloader object
+func newSdboot() Bootloader {
+	sd := &sdboot{}
+	if !osutil.FileExists(sd.ConfigFile()) {
+		return nil
+	}
+	return sd
+}
+
+func (sd *sdboot) Name() string {
+	return "systemd-boot"
+}
+
+// dir is where the ESP is mounted, kernel assets are extracted there
+// as systemd-boot can only load them from the ESP.
+func (sd *sdboot) dir() string {
+	return filepath.Join(dirs.GlobalRootDir, "/boot/efi")
+}
+
+func (sd *sdboot) ConfigFile() string {
+	return filepath.Join(sd.dir(), "loader/loader.conf")
+}
+
+func (sd *sdboot) envFile() string {
+	return filepath.Join(sd.dir(), "loader/snapd.env")
+}
+
+func (sd *sdboot) entriesDir() string {
+	return filepath.Join(sd.dir(), "loader/entries")
+}
+
+const (
+	sdbootRunEntry = "snapd-run.conf"
+	// the try entry is created with one try left, systemd-boot
+	// renames it to snapd-try+0-1.conf when booting it
+	sdbootTryEntry         = "snapd-try+1.conf"
+	sdbootTriedEntry       = "snapd-try+0-1.conf"
+	sdbootTryingCmdlineArg = "snap_mode=trying"
+)
+
+const sdbootProcCmdline = "/proc/cmdline"
+
+func (sd *sdboot) loadEnv() (*androidbootenv.Env, error) {
+	env := androidbootenv.NewEnv(sd.envFile())
+	if err := env.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return env, nil
+}
+
+// bootMode returns the current snap_mode given the stored one, taking
+// into account whether systemd-boot already tried the try entry and
+// whether that is the entry that was booted.
+func (sd *sdboot) bootMode(stored string) (string, error) {
+	if stored != modeTry {
+		return stored, nil
+	}
+	if osutil.FileExists(filepath.Join(sd.entriesDir(), sdbootTryEntry)) {
+		// not tried yet
+		return modeTry, nil
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join(dirs.GlobalRootDir, sdbootProcCmdline))
+	if err != nil {
+		return "", fmt.Errorf("cannot read kernel command line: %v", err)
+	}
+	for _, arg := range strings.Fields(string(cmdline)) {
+		if arg == sdbootTryingCmdlineArg {
+			return "trying", nil
+		}
+	}
+	// the try entry was tried but the run entry was booted, as
+	// grub would do, go back to the known good snaps
+	return modeSuccess, nil
+}
+
+func (sd *sdboot) GetBootVars(names ...string) (map[string]string, error) {
+	env, err := sd.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		if name == bootmodeVar {
+			mode, err := sd.bootMode(env.Get(bootmodeVar))
+			if err != nil {
+				return nil, err
+			}
+			out[name] = mode
+			continue
+		}
+		out[name] = env.Get(name)
+	}
+
+	return out, nil
+}
+
+func (sd *sdboot) SetBootVars(values map[string]string) error {
+	env, err := sd.loadEnv()
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		env.Set(k, v)
+	}
+	if err := env.Save(); err != nil {
+		return err
+	}
+	return sd.writeEntries(env)
+}
+
+func sdbootEntry(kernel, core string, extraOptions ...string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "title Ubuntu Core (%s)\n", kernel)
+	fmt.Fprintf(&buf, "linux /%s/kernel.img\n", kernel)
+	fmt.Fprintf(&buf, "initrd /%s/initrd.img\n", kernel)
+	options := append([]string{"snap_core=" + core, "snap_kernel=" + kernel}, extraOptions...)
+	fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	return buf.Bytes()
+}
+
+// writeEntries writes the BootLoaderSpec entries reflecting the given
+// boot variables, removing stale ones.
+func (sd *sdboot) writeEntries(env *androidbootenv.Env) error {
+	entriesDir := sd.entriesDir()
+	if err := os.MkdirAll(entriesDir, 0755); err != nil {
+		return err
+	}
+	for _, entry := range []string{sdbootRunEntry, sdbootTryEntry, sdbootTriedEntry} {
+		if err := os.Remove(filepath.Join(entriesDir, entry)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	kernel := env.Get("snap_kernel")
+	core := env.Get("snap_core")
+	if kernel == "" || core == "" {
+		// nothing bootable yet
+		return nil
+	}
+	// there is no default command line to replace besides the
+	// snapd arguments, so a full command line is used like extra
+	// arguments
+	gadgetArgs := strings.Fields(env.Get("snapd_full_cmdline_args"))
+	if len(gadgetArgs) == 0 {
+		gadgetArgs = strings.Fields(env.Get("snapd_extra_cmdline_args"))
+	}
+	if err := osutil.AtomicWriteFile(filepath.Join(entriesDir, sdbootRunEntry), sdbootEntry(kernel, core, gadgetArgs...), 0644, 0); err != nil {
+		return err
+	}
+
+	if env.Get(bootmodeVar) != modeTry {
+		return nil
+	}
+	tryKernel := env.Get("snap_try_kernel")
+	if tryKernel == "" {
+		tryKernel = kernel
+	}
+	tryCore := env.Get("snap_try_core")
+	if tryCore == "" {
+		tryCore = core
+	}
+	return osutil.AtomicWriteFile(filepath.Join(entriesDir, sdbootTryEntry), sdbootEntry(tryKernel, tryCore, append(gadgetArgs, sdbootTryingCmdlineArg)...), 0644, 0)
+}
+
+// SupportsKernelCmdline returns true, the boot entries written by snapd
+// always carry the kernel command line.
+func (sd *sdboot) SupportsKernelCmdline(full bool) (bool, error) {
+	return true, nil
+}
+
+func (sd *sdboot) ExtractKernelAssets(s *snap.Info, snapf snap.Container) error {
+	return extractKernelAssetsToBootDir(sd.dir(), s, snapf)
+}
+
+func (sd *sdboot) RemoveKernelAssets(s snap.PlaceInfo) error {
+	return removeKernelAssetsFromBootDir(sd.dir(), s)
+}
@@ -0,0 +1,272 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package bootloader_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type sdbootTestSuite struct {
+	baseBootenvTestSuite
+
+	espdir string
+}
+
+var _ = Suite(&sdbootTestSuite{})
+
+func (s *sdbootTestSuite) SetUpTest(c *C) {
+	s.baseBootenvTestSuite.SetUpTest(c)
+	bootloader.MockSdbootFiles(c)
+
+	s.espdir = filepath.Join(dirs.GlobalRootDir, "boot/efi")
+	s.mockCmdline(c, "console=ttyS0 snap_core=core_1.snap snap_kernel=pc-kernel_1.snap")
+}
+
+func (s *sdbootTestSuite) mockCmdline(c *C, cmdline string) {
+	err := os.MkdirAll(filepath.Join(dirs.GlobalRootDir, "proc"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dirs.GlobalRootDir, "proc/cmdline"), []byte(cmdline+"\n"), 0644)
+	c.Assert(err, IsNil)
+}
+
+func (s *sdbootTestSuite) entry(name string) string {
+	return filepath.Join(s.espdir, "loader/entries", name)
+}
+
+func (s *sdbootTestSuite) TestNewSdbootNoSdbootReturnsNil(c *C) {
+	dirs.GlobalRootDir = "/something/not/there"
+	sd := bootloader.NewSdboot()
+	c.Assert(sd, IsNil)
+}
+
+func (s *sdbootTestSuite) TestNewSdboot(c *C) {
+	sd := bootloader.NewSdboot()
+	c.Assert(sd, NotNil)
+	c.Check(sd.Name(), Equals, "systemd-boot")
+	c.Check(sd.ConfigFile(), Equals, filepath.Join(s.espdir, "loader/loader.conf"))
+}
+
+func (s *sdbootTestSuite) TestGetBootloaderWithSdboot(c *C) {
+	bootloader, err := bootloader.Find()
+	c.Assert(err, IsNil)
+	c.Assert(bootloader.Name(), Equals, "systemd-boot")
+}
+
+func (s *sdbootTestSuite) TestSetGetBootVars(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{"k1": "v1", "k2": "v2"})
+	c.Assert(err, IsNil)
+
+	v, err := sd.GetBootVars("k1", "k2", "k3")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"k1": "v1", "k2": "v2", "k3": ""})
+}
+
+func (s *sdbootTestSuite) TestGetBootVarsNoEnv(c *C) {
+	sd := bootloader.NewSdboot()
+
+	v, err := sd.GetBootVars("snap_mode", "snap_core")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{"snap_mode": "", "snap_core": ""})
+}
+
+func (s *sdbootTestSuite) TestSetBootVarsWritesRunEntry(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{
+		"snap_core":   "core_1.snap",
+		"snap_kernel": "pc-kernel_1.snap",
+		"snap_mode":   "",
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.entry("snapd-run.conf"), testutil.FileEquals, `title Ubuntu Core (pc-kernel_1.snap)
+linux /pc-kernel_1.snap/kernel.img
+initrd /pc-kernel_1.snap/initrd.img
+options snap_core=core_1.snap snap_kernel=pc-kernel_1.snap
+`)
+	c.Check(s.entry("snapd-try+1.conf"), testutil.FileAbsent)
+}
+
+func (s *sdbootTestSuite) TestSetBootVarsNothingBootable(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{"snap_mode": "try"})
+	c.Assert(err, IsNil)
+
+	c.Check(s.entry("snapd-run.conf"), testutil.FileAbsent)
+	c.Check(s.entry("snapd-try+1.conf"), testutil.FileAbsent)
+}
+
+func (s *sdbootTestSuite) TestTryBootHappy(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{
+		"snap_core":   "core_1.snap",
+		"snap_kernel": "pc-kernel_1.snap",
+	})
+	c.Assert(err, IsNil)
+
+	// a new kernel is going to be tried
+	err = sd.SetBootVars(map[string]string{
+		"snap_try_kernel": "pc-kernel_2.snap",
+		"snap_mode":       "try",
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.entry("snapd-run.conf"), testutil.FileContains, "options snap_core=core_1.snap snap_kernel=pc-kernel_1.snap\n")
+	c.Check(s.entry("snapd-try+1.conf"), testutil.FileEquals, `title Ubuntu Core (pc-kernel_2.snap)
+linux /pc-kernel_2.snap/kernel.img
+initrd /pc-kernel_2.snap/initrd.img
+options snap_core=core_1.snap snap_kernel=pc-kernel_2.snap snap_mode=trying
+`)
+
+	v, err := sd.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(v["snap_mode"], Equals, "try")
+
+	// systemd-boot boots the try entry
+	err = os.Rename(s.entry("snapd-try+1.conf"), s.entry("snapd-try+0-1.conf"))
+	c.Assert(err, IsNil)
+	s.mockCmdline(c, "snap_core=core_1.snap snap_kernel=pc-kernel_2.snap snap_mode=trying")
+
+	v, err = sd.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(v["snap_mode"], Equals, "trying")
+
+	err = bootloader.MarkBootSuccessful(sd)
+	c.Assert(err, IsNil)
+
+	v, err = sd.GetBootVars("snap_mode", "snap_kernel", "snap_try_kernel", "snap_core")
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, map[string]string{
+		"snap_mode":       "",
+		"snap_kernel":     "pc-kernel_2.snap",
+		"snap_try_kernel": "",
+		"snap_core":       "core_1.snap",
+	})
+	c.Check(s.entry("snapd-run.conf"), testutil.FileContains, "options snap_core=core_1.snap snap_kernel=pc-kernel_2.snap\n")
+	c.Check(s.entry("snapd-try+0-1.conf"), testutil.FileAbsent)
+}
+
+func (s *sdbootTestSuite) TestEntriesGadgetCmdline(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{
+		"snap_core":                "core_1.snap",
+		"snap_kernel":              "pc-kernel_1.snap",
+		"snap_try_kernel":          "pc-kernel_2.snap",
+		"snap_mode":                "try",
+		"snapd_extra_cmdline_args": "console=ttyS0 isolcpus=1",
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.entry("snapd-run.conf"), testutil.FileContains, "options snap_core=core_1.snap snap_kernel=pc-kernel_1.snap console=ttyS0 isolcpus=1\n")
+	c.Check(s.entry("snapd-try+1.conf"), testutil.FileContains, "options snap_core=core_1.snap snap_kernel=pc-kernel_2.snap console=ttyS0 isolcpus=1 snap_mode=trying\n")
+
+	err = sd.SetBootVars(map[string]string{
+		"snap_mode":                "",
+		"snapd_extra_cmdline_args": "",
+		"snapd_full_cmdline_args":  "console=tty1",
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.entry("snapd-run.conf"), testutil.FileContains, "options snap_core=core_1.snap snap_kernel=pc-kernel_1.snap console=tty1\n")
+	c.Check(s.entry("snapd-try+1.conf"), testutil.FileAbsent)
+}
+
+func (s *sdbootTestSuite) TestSupportsKernelCmdline(c *C) {
+	sd := bootloader.NewSdboot().(bootloader.KernelCmdlineBootloader)
+
+	for _, full := range []bool{false, true} {
+		supported, err := sd.SupportsKernelCmdline(full)
+		c.Assert(err, IsNil)
+		c.Check(supported, Equals, true)
+	}
+}
+
+func (s *sdbootTestSuite) TestTryBootFallback(c *C) {
+	sd := bootloader.NewSdboot()
+	err := sd.SetBootVars(map[string]string{
+		"snap_core":       "core_1.snap",
+		"snap_kernel":     "pc-kernel_1.snap",
+		"snap_try_kernel": "pc-kernel_2.snap",
+		"snap_mode":       "try",
+	})
+	c.Assert(err, IsNil)
+
+	// systemd-boot tried the try entry but then booted the run one
+	err = os.Rename(s.entry("snapd-try+1.conf"), s.entry("snapd-try+0-1.conf"))
+	c.Assert(err, IsNil)
+
+	v, err := sd.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Check(v["snap_mode"], Equals, "")
+
+	err = bootloader.MarkBootSuccessful(sd)
+	c.Assert(err, IsNil)
+
+	v, err = sd.GetBootVars("snap_kernel")
+	c.Assert(err, IsNil)
+	c.Check(v["snap_kernel"], Equals, "pc-kernel_1.snap")
+}
+
+func (s *sdbootTestSuite) TestExtractKernelAssetsUnpacksKernel(c *C) {
+	sd := bootloader.NewSdboot()
+
+	files := [][]string{
+		{"kernel.img", "I'm a kernel"},
+		{"initrd.img", "...and I'm an initrd"},
+		{"meta/kernel.yaml", "version: 4.2"},
+	}
+	si := &snap.SideInfo{
+		RealName: "ubuntu-kernel",
+		Revision: snap.R(42),
+	}
+	fn := snaptest.MakeTestSnapWithFiles(c, packageKernel, files)
+	snapf, err := snap.Open(fn)
+	c.Assert(err, IsNil)
+
+	info, err := snap.ReadInfoFromSnapFile(snapf, si)
+	c.Assert(err, IsNil)
+
+	err = sd.ExtractKernelAssets(info, snapf)
+	c.Assert(err, IsNil)
+
+	// kernel and initrd are on the ESP
+	kernimg := filepath.Join(s.espdir, "ubuntu-kernel_42.snap", "kernel.img")
+	c.Assert(osutil.FileExists(kernimg), Equals, true)
+	initrdimg := filepath.Join(s.espdir, "ubuntu-kernel_42.snap", "initrd.img")
+	c.Assert(osutil.FileExists(initrdimg), Equals, true)
+
+	// ensure that removal of assets also works
+	err = sd.RemoveKernelAssets(info)
+	c.Assert(err, IsNil)
+	exists, _, err := osutil.DirExists(filepath.Dir(kernimg))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+}
@@ -57,26 +57,16 @@ func (u *uboot) envFile() string {
 }
 
 func (u *uboot) SetBootVars(values map[string]string) error {
-	env, err := ubootenv.OpenWithFlags(u.envFile(), ubootenv.OpenBestEffort)
+	// all the variables are written at once, so that the env is never
+	// left with only some of them set
+	tx, err := ubootenv.Begin(u.envFile(), ubootenv.OpenBestEffort)
 	if err != nil {
 		return err
 	}
-
-	dirty := false
 	for k, v := range values {
-		// already set to the right value, nothing to do
-		if env.Get(k) == v {
-			continue
-		}
-		env.Set(k, v)
-		dirty = true
+		tx.Set(k, v)
 	}
-
-	if dirty {
-		return env.Save()
-	}
-
-	return nil
+	return tx.Commit()
 }
 
 func (u *uboot) GetBootVars(names ...string) (map[string]string, error) {
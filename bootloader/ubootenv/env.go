@@ -219,6 +219,10 @@ func (env *Env) Save() error {
 		w.Write([]byte{0})
 	}
 
+	if w.Len() > env.size-headerSize {
+		return fmt.Errorf("cannot write %q: environment of %d bytes exceeds the size of %d bytes", env.fname, w.Len(), env.size-headerSize)
+	}
+
 	// write ff into the remaining parts
 	writtenSoFar := w.Len()
 	for i := 0; i < env.size-headerSize-writtenSoFar; i++ {
@@ -292,3 +296,79 @@ func (env *Env) Import(r io.Reader) error {
 
 	return scanner.Err()
 }
+
+// Transaction is a set of changes to the variables of an uboot env file
+// applied at once, with a single write of the file.
+type Transaction struct {
+	env     *Env
+	flags   OpenFlags
+	changes map[string]string
+}
+
+// Begin starts a transaction on the given existing uboot env file, opened
+// with the given flags.
+func Begin(fname string, flags OpenFlags) (*Transaction, error) {
+	env, err := OpenWithFlags(fname, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		env:     env,
+		flags:   flags,
+		changes: make(map[string]string),
+	}, nil
+}
+
+// Get returns the value of the environment variable, including the
+// changes made in the transaction.
+func (tx *Transaction) Get(name string) string {
+	if value, ok := tx.changes[name]; ok {
+		return value
+	}
+	return tx.env.Get(name)
+}
+
+// Set records the change of an environment variable to the given value,
+// an empty value removes the variable.
+func (tx *Transaction) Set(name, value string) {
+	if name == "" {
+		panic(fmt.Sprintf("Set() can not be called with empty key for value: %q", value))
+	}
+	tx.changes[name] = value
+}
+
+// Commit applies the changes of the transaction, writing the env file only
+// if any variable changes value, and reads the file back to verify that
+// all the variables were written as expected.
+func (tx *Transaction) Commit() error {
+	dirty := false
+	for name, value := range tx.changes {
+		if tx.env.Get(name) == value {
+			continue
+		}
+		tx.env.Set(name, value)
+		dirty = true
+	}
+	tx.changes = make(map[string]string)
+	if !dirty {
+		return nil
+	}
+
+	if err := tx.env.Save(); err != nil {
+		return err
+	}
+
+	written, err := OpenWithFlags(tx.env.fname, tx.flags)
+	if err != nil {
+		return fmt.Errorf("cannot verify %q: %v", tx.env.fname, err)
+	}
+	if len(written.data) != len(tx.env.data) {
+		return fmt.Errorf("cannot verify %q: expected %d variables, got %d", tx.env.fname, len(tx.env.data), len(written.data))
+	}
+	for name, value := range tx.env.data {
+		if written.data[name] != value {
+			return fmt.Errorf("cannot verify %q: variable %q is %q instead of %q", tx.env.fname, name, written.data[name], value)
+		}
+	}
+	return nil
+}
@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -296,3 +297,75 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 	c.Assert(env.String(), Equals, "a=b\nc=d\n")
 	c.Assert(env.Size(), Equals, totalSize)
 }
+
+func (u *uenvTestSuite) TestSaveTooLarge(c *C) {
+	env, err := ubootenv.Create(u.envFile, 16)
+	c.Assert(err, IsNil)
+	env.Set("a", "some-long-value")
+	err = env.Save()
+	c.Assert(err, ErrorMatches, `cannot write ".*/uboot.env": environment of 19 bytes exceeds the size of 11 bytes`)
+}
+
+func (u *uenvTestSuite) TestTransaction(c *C) {
+	env, err := ubootenv.Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("snap_mode", "try")
+	env.Set("snap_core", "core_1.snap")
+	c.Assert(env.Save(), IsNil)
+
+	tx, err := ubootenv.Begin(u.envFile, 0)
+	c.Assert(err, IsNil)
+	tx.Set("snap_mode", "")
+	tx.Set("snap_core", "core_2.snap")
+	tx.Set("snap_kernel", "pc-kernel_3.snap")
+	// changes are visible in the transaction
+	c.Check(tx.Get("snap_core"), Equals, "core_2.snap")
+	c.Check(tx.Get("snap_mode"), Equals, "")
+
+	// but nothing is written until committed
+	env, err = ubootenv.Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "snap_core=core_1.snap\nsnap_mode=try\n")
+
+	c.Assert(tx.Commit(), IsNil)
+	env, err = ubootenv.Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "snap_core=core_2.snap\nsnap_kernel=pc-kernel_3.snap\n")
+}
+
+func (u *uenvTestSuite) TestTransactionNoChangesNoWrite(c *C) {
+	env, err := ubootenv.Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("snap_mode", "try")
+	c.Assert(env.Save(), IsNil)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	c.Assert(os.Chtimes(u.envFile, old, old), IsNil)
+
+	tx, err := ubootenv.Begin(u.envFile, 0)
+	c.Assert(err, IsNil)
+	tx.Set("snap_mode", "try")
+	tx.Set("snap_try_core", "")
+	c.Check(tx.Commit(), IsNil)
+
+	st, err := os.Stat(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(st.ModTime().Equal(old), Equals, true)
+}
+
+func (u *uenvTestSuite) TestTransactionTooLarge(c *C) {
+	env, err := ubootenv.Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+
+	tx, err := ubootenv.Begin(u.envFile, 0)
+	c.Assert(err, IsNil)
+	tx.Set("a", "c")
+	tx.Set("some-key", "some-long-value")
+	c.Assert(tx.Commit(), ErrorMatches, `cannot write .* exceeds the size of 27 bytes`)
+
+	// none of the changes made it
+	env, err = ubootenv.Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "a=b\n")
+}
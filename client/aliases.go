@@ -22,6 +22,7 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 )
 
 // aliasAction represents an action performed on aliases.
@@ -100,3 +101,43 @@ func (client *Client) Aliases() (allStatuses map[string]map[string]AliasStatus,
 	_, err = client.doSync("GET", "/v2/aliases", nil, nil, nil, &allStatuses)
 	return
 }
+
+// AliasPreference is an alias action to perform with
+// ApplyAliasPreferences, as exported by 'snap aliases --export'. The
+// actions are the ones of the alias, unalias and prefer commands.
+type AliasPreference struct {
+	Action string `json:"action"`
+	Snap   string `json:"snap,omitempty"`
+	App    string `json:"app,omitempty"`
+	Alias  string `json:"alias,omitempty"`
+	// Error is set, in the results of ApplyAliasPreferences, when the
+	// preference could not be applied.
+	Error string `json:"error,omitempty"`
+}
+
+// ApplyAliasPreferences performs the given alias actions one after the
+// other in a single change. Preferences that cannot be applied are
+// skipped; the results report how each of them went. Preferences that
+// fail only once the change runs do not fail the change; the final
+// results, with those errors, are in the "alias-preferences" of the
+// change data once the change is ready.
+func (client *Client) ApplyAliasPreferences(prefs []AliasPreference) (changeID string, results []AliasPreference, err error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"action":      "apply",
+		"preferences": prefs,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	result, changeID, err := client.doAsyncFull("POST", "/v2/aliases", nil, nil, bytes.NewReader(b))
+	if err != nil {
+		return "", nil, err
+	}
+	var rsp struct {
+		Preferences []AliasPreference `json:"preferences"`
+	}
+	if err := json.Unmarshal(result, &rsp); err != nil {
+		return "", nil, fmt.Errorf("cannot unmarshal alias preference results: %v", err)
+	}
+	return changeID, rsp.Preferences, nil
+}
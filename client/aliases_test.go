@@ -165,6 +165,45 @@ func (cs *clientSuite) TestClientPrefer(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestClientApplyAliasPreferences(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": {
+			"preferences": [
+				{"action": "prefer", "snap": "some-snap"},
+				{"action": "alias", "snap": "other-snap", "app": "app", "alias": "foo", "error": "snap \"other-snap\" is not installed"}
+			]
+		},
+		"change": "chgid"
+	}`
+	prefs := []client.AliasPreference{
+		{Action: "prefer", Snap: "some-snap"},
+		{Action: "alias", Snap: "other-snap", App: "app", Alias: "foo"},
+	}
+	id, results, err := cs.cli.ApplyAliasPreferences(prefs)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "chgid")
+	c.Check(results, check.DeepEquals, []client.AliasPreference{
+		{Action: "prefer", Snap: "some-snap"},
+		{Action: "alias", Snap: "other-snap", App: "app", Alias: "foo", Error: `snap "other-snap" is not installed`},
+	})
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/aliases")
+	var body map[string]interface{}
+	decoder := json.NewDecoder(cs.req.Body)
+	err = decoder.Decode(&body)
+	c.Check(err, check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "apply",
+		"preferences": []interface{}{
+			map[string]interface{}{"action": "prefer", "snap": "some-snap"},
+			map[string]interface{}{"action": "alias", "snap": "other-snap", "app": "app", "alias": "foo"},
+		},
+	})
+}
+
 func (cs *clientSuite) TestClientAliasesCallsEndpoint(c *check.C) {
 	_, _ = cs.cli.Aliases()
 	c.Check(cs.req.Method, check.Equals, "GET")
@@ -39,6 +39,11 @@ type AppActivator struct {
 	Type    string
 	Active  bool
 	Enabled bool
+	// Listening is true for sockets listening for connections
+	Listening bool
+	// NextTrigger is when a timer is triggered next, zero if it is
+	// not scheduled
+	NextTrigger time.Time
 }
 
 // AppInfo describes a single snap application.
@@ -51,6 +56,18 @@ type AppInfo struct {
 	Active      bool           `json:"active,omitempty"`
 	CommonID    string         `json:"common-id,omitempty"`
 	Activators  []AppActivator `json:"activators,omitempty"`
+	Usage       *AppUsage      `json:"usage,omitempty"`
+}
+
+// AppUsage is the current resource usage of a service, as accounted by
+// systemd.
+type AppUsage struct {
+	// Memory is the memory currently used in bytes, or -1 if not
+	// accounted
+	Memory int64 `json:"memory"`
+	// CPUTime is the CPU time consumed since the service was started,
+	// or -1 if not accounted
+	CPUTime time.Duration `json:"cpu-time"`
 }
 
 // IsService returns true if the application is a background daemon.
@@ -70,6 +87,9 @@ type AppOptions struct {
 	// If Service is true, only return apps that are services
 	// (app.IsService() is true); otherwise, return all.
 	Service bool
+	// If Usage is true, also return the resource usage of the services
+	// that are active.
+	Usage bool
 }
 
 // Apps returns information about all matching apps. Each name can be
@@ -83,6 +103,9 @@ func (client *Client) Apps(names []string, opts AppOptions) ([]*AppInfo, error)
 	if opts.Service {
 		q.Add("select", "service")
 	}
+	if opts.Usage {
+		q.Add("usage", "true")
+	}
 
 	var appInfos []*AppInfo
 	_, err := client.doSync("GET", "/v2/apps", q, nil, nil, &appInfos)
@@ -92,16 +115,19 @@ func (client *Client) Apps(names []string, opts AppOptions) ([]*AppInfo, error)
 
 // LogOptions represent the options of the Logs call.
 type LogOptions struct {
-	N      int  // The maximum number of log lines to retrieve initially. If <0, no limit.
-	Follow bool // Whether to continue returning new lines as they appear
+	N           int    // The maximum number of log lines to retrieve initially. If <0, no limit.
+	Follow      bool   // Whether to continue returning new lines as they appear
+	AfterCursor string // Only return the lines after the one with this cursor, if set
 }
 
 // A Log holds the information of a single syslog entry
 type Log struct {
-	Timestamp time.Time `json:"timestamp"` // Timestamp of the event, in RFC3339 format to µs precision.
-	Message   string    `json:"message"`   // The log message itself
-	SID       string    `json:"sid"`       // The syslog identifier
-	PID       string    `json:"pid"`       // The process identifier
+	Timestamp time.Time `json:"timestamp"`         // Timestamp of the event, in RFC3339 format to µs precision.
+	Message   string    `json:"message"`           // The log message itself
+	SID       string    `json:"sid"`               // The syslog identifier
+	PID       string    `json:"pid"`               // The process identifier
+	Service   string    `json:"service,omitempty"` // The snap app the entry comes from
+	Cursor    string    `json:"cursor,omitempty"`  // The journal cursor of the entry, to resume from
 }
 
 func (l Log) String() string {
@@ -118,6 +144,9 @@ func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error)
 	if opts.Follow {
 		query.Set("follow", strconv.FormatBool(opts.Follow))
 	}
+	if opts.AfterCursor != "" {
+		query.Set("after-cursor", opts.AfterCursor)
+	}
 
 	rsp, err := client.raw("GET", "/v2/logs", query, nil, nil)
 	if err != nil {
@@ -170,8 +199,9 @@ func (client *Client) Logs(names []string, opts LogOptions) (<-chan Log, error)
 var ErrNoNames = errors.New(`"names" must not be empty`)
 
 type appInstruction struct {
-	Action string   `json:"action"`
-	Names  []string `json:"names"`
+	Action     string   `json:"action"`
+	Names      []string `json:"names"`
+	Activators []string `json:"activators,omitempty"`
 	StartOptions
 	StopOptions
 	RestartOptions
@@ -182,6 +212,10 @@ type StartOptions struct {
 	// Enable, as well as starting, the listed services. A
 	// disabled service does not start on boot.
 	Enable bool `json:"enable,omitempty"`
+	// Activators, if not empty, are the sockets, by name, or the
+	// "timer" of the listed services to operate on instead of the
+	// services themselves.
+	Activators []string `json:"-"`
 }
 
 // Start services.
@@ -197,6 +231,7 @@ func (client *Client) Start(names []string, opts StartOptions) (changeID string,
 	buf, err := json.Marshal(appInstruction{
 		Action:       "start",
 		Names:        names,
+		Activators:   opts.Activators,
 		StartOptions: opts,
 	})
 	if err != nil {
@@ -210,6 +245,10 @@ type StopOptions struct {
 	// Disable, as well as stopping, the listed services. A
 	// service that is not disabled starts on boot.
 	Disable bool `json:"disable,omitempty"`
+	// Activators, if not empty, are the sockets, by name, or the
+	// "timer" of the listed services to operate on instead of the
+	// services themselves.
+	Activators []string `json:"-"`
 }
 
 // Stop services.
@@ -225,6 +264,7 @@ func (client *Client) Stop(names []string, opts StopOptions) (changeID string, e
 	buf, err := json.Marshal(appInstruction{
 		Action:      "stop",
 		Names:       names,
+		Activators:  opts.Activators,
 		StopOptions: opts,
 	})
 	if err != nil {
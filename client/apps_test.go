@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -103,6 +104,23 @@ func (cs *clientSuite) TestClientAppCommonID(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientAppsUsage(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": [
+  {"snap": "foo", "name": "foo", "daemon": "simple", "active": true, "enabled": true,
+   "usage": {"memory": 1048576, "cpu-time": 1500000000}}
+]}`
+	actual, err := cs.cli.Apps([]string{"foo"}, client.AppOptions{Service: true, Usage: true})
+	c.Assert(err, check.IsNil)
+	query := cs.req.URL.Query()
+	c.Check(query, check.HasLen, 3)
+	c.Check(query.Get("select"), check.Equals, "service")
+	c.Check(query.Get("usage"), check.Equals, "true")
+
+	expected := mksvc("foo", "foo")
+	expected.Usage = &client.AppUsage{Memory: 1048576, CPUTime: 1500 * time.Millisecond}
+	c.Check(actual, check.DeepEquals, []*client.AppInfo{expected})
+}
+
 func testClientLogs(cs *clientSuite, c *check.C) ([]client.Log, error) {
 	ch, err := cs.cli.Logs([]string{"foo", "bar"}, client.LogOptions{N: -1, Follow: false})
 	c.Check(cs.req.URL.Path, check.Equals, "/v2/logs")
@@ -204,6 +222,23 @@ func (cs *clientSuite) TestClientLogsOpts(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientLogsAfterCursor(c *check.C) {
+	cs.rsp = `
+{"message":"hello","service":"foo.svc","cursor":"c1"}
+`[1:]
+	ch, err := cs.cli.Logs([]string{"foo.svc", "bar.svc"}, client.LogOptions{N: -1, Follow: true, AfterCursor: "c0"})
+	c.Assert(err, check.IsNil)
+	query := cs.req.URL.Query()
+	c.Check(query.Get("names"), check.Equals, "foo.svc,bar.svc")
+	c.Check(query.Get("after-cursor"), check.Equals, "c0")
+
+	var logs []client.Log
+	for l := range ch {
+		logs = append(logs, l)
+	}
+	c.Check(logs, check.DeepEquals, []client.Log{{Message: "hello", Service: "foo.svc", Cursor: "c1"}})
+}
+
 func (cs *clientSuite) TestClientLogsNotFound(c *check.C) {
 	cs.rsp = `{"type":"error","status-code":404,"status":"Not Found","result":{"message":"snap \"foo\" not found","kind":"snap-not-found","value":"foo"}}`
 	cs.status = 404
@@ -336,6 +371,32 @@ func (cs *clientSuite) TestClientServiceStop(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientServiceActivators(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{"type": "async", "status-code": 202, "change": "24"}`
+
+	_, err := cs.cli.Stop([]string{"foo.svc"}, client.StopOptions{Disable: true, Activators: []string{"sock"}})
+	c.Assert(err, check.IsNil)
+	var reqOp map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&reqOp), check.IsNil)
+	c.Check(reqOp, check.DeepEquals, map[string]interface{}{
+		"action":     "stop",
+		"names":      []interface{}{"foo.svc"},
+		"disable":    true,
+		"activators": []interface{}{"sock"},
+	})
+
+	_, err = cs.cli.Start([]string{"foo.svc"}, client.StartOptions{Activators: []string{"timer"}})
+	c.Assert(err, check.IsNil)
+	reqOp = nil
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&reqOp), check.IsNil)
+	c.Check(reqOp, check.DeepEquals, map[string]interface{}{
+		"action":     "start",
+		"names":      []interface{}{"foo.svc"},
+		"activators": []interface{}{"timer"},
+	})
+}
+
 func (cs *clientSuite) TestClientServiceRestart(c *check.C) {
 	cs.status = 202
 	cs.rsp = `{"type": "async", "status-code": 202, "change": "24"}`
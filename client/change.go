@@ -40,6 +40,9 @@ type Change struct {
 	SpawnTime time.Time `json:"spawn-time,omitempty"`
 	ReadyTime time.Time `json:"ready-time,omitempty"`
 
+	// Annotations are attached to the change by external tooling.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
 	data map[string]*json.RawMessage
 }
 
@@ -110,6 +113,31 @@ func (client *Client) Abort(id string) (*Change, error) {
 	return &chg, nil
 }
 
+// AnnotateChange attaches the given annotations to a change, replacing
+// existing ones with the same key. Annotations with an empty value are
+// removed.
+func (client *Client) AnnotateChange(id string, annotations map[string]string) (*Change, error) {
+	postData := struct {
+		Action      string            `json:"action"`
+		Annotations map[string]string `json:"annotations"`
+	}{
+		Action:      "annotate",
+		Annotations: annotations,
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(postData); err != nil {
+		return nil, err
+	}
+
+	var chg Change
+	if _, err := client.doSync("POST", "/v2/changes/"+id, nil, nil, &body, &chg); err != nil {
+		return nil, err
+	}
+
+	return &chg, nil
+}
+
 type ChangeSelector uint8
 
 func (c ChangeSelector) String() string {
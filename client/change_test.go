@@ -22,6 +22,7 @@ package client_test
 import (
 	"gopkg.in/check.v1"
 
+	"encoding/json"
 	"github.com/snapcore/snapd/client"
 	"io/ioutil"
 	"time"
@@ -231,3 +232,43 @@ func (cs *clientSuite) TestClientAbort(c *check.C) {
 
 	c.Assert(string(body), check.Equals, "{\"action\":\"abort\"}\n")
 }
+
+func (cs *clientSuite) TestClientAnnotateChange(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "foo",
+  "summary": "...",
+  "status": "Done",
+  "ready": true,
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "ready-time": "2016-04-21T01:02:04Z",
+  "annotations": {"ticket": "OPS-123"}
+}}`
+
+	chg, err := cs.cli.AnnotateChange("uno", map[string]string{"ticket": "OPS-123", "note": ""})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/changes/uno")
+	c.Check(chg, check.DeepEquals, &client.Change{
+		ID:      "uno",
+		Kind:    "foo",
+		Summary: "...",
+		Status:  "Done",
+		Ready:   true,
+
+		SpawnTime: time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC),
+		ReadyTime: time.Date(2016, 04, 21, 1, 2, 4, 0, time.UTC),
+
+		Annotations: map[string]string{"ticket": "OPS-123"},
+	})
+
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "annotate",
+		"annotations": map[string]interface{}{
+			"ticket": "OPS-123",
+			"note":   "",
+		},
+	})
+}
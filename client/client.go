@@ -84,11 +84,17 @@ type Client struct {
 	interactive bool
 
 	maintenance error
+	// daemonVersion is the version of the daemon as of the last
+	// response
+	daemonVersion string
 
 	warningCount     int
 	warningTimestamp time.Time
 
 	userAgent string
+
+	// idempotencyKey is sent with mutating requests, see Idempotent
+	idempotencyKey string
 }
 
 // New returns a new instance of Client
@@ -130,6 +136,12 @@ func (client *Client) Maintenance() error {
 	return client.maintenance
 }
 
+// DaemonVersion returns the version of the daemon as of the last response,
+// or an empty string if there was none yet.
+func (client *Client) DaemonVersion() string {
+	return client.daemonVersion
+}
+
 // WarningsSummary returns the number of warnings that are ready to be shown to
 // the user, and the timestamp of the most recently added warning (useful for
 // silencing the warning alerts, and OKing the returned warnings).
@@ -189,6 +201,16 @@ func (e ConnectionError) Error() string {
 // that the client is willing to allow interaction.
 const AllowInteractionHeader = "X-Allow-Interaction"
 
+// DaemonVersionHeader is the HTTP response header carrying the version of
+// the daemon.
+const DaemonVersionHeader = "X-Snapd-Version"
+
+// SeenDaemonVersionHeader is the HTTP request header carrying the version
+// of the daemon that answered the previous request of the client. The
+// daemon transmits a daemon-version-changed maintenance hint if it differs
+// from its own version.
+const SeenDaemonVersionHeader = "X-Snapd-Seen-Version"
+
 // raw performs a request and returns the resulting http.Response and
 // error you usually only need to call this directly if you expect the
 // response to not be JSON, otherwise you'd call Do(...) instead.
@@ -221,11 +243,23 @@ func (client *Client) raw(method, urlpath string, query url.Values, headers map[
 		req.Header.Set(AllowInteractionHeader, "true")
 	}
 
+	if client.daemonVersion != "" {
+		req.Header.Set(SeenDaemonVersionHeader, client.daemonVersion)
+	}
+
+	if client.idempotencyKey != "" && method != "GET" {
+		req.Header.Set(IdempotencyKeyHeader, client.idempotencyKey)
+	}
+
 	rsp, err := client.doer.Do(req)
 	if err != nil {
 		return nil, ConnectionError{err}
 	}
 
+	if version := rsp.Header.Get(DaemonVersionHeader); version != "" {
+		client.daemonVersion = version
+	}
+
 	return rsp, nil
 }
 
@@ -436,6 +470,7 @@ const (
 	ErrorKindSnapNeedsClassic       = "snap-needs-classic"
 	ErrorKindSnapNeedsClassicSystem = "snap-needs-classic-system"
 	ErrorKindSnapNotClassic         = "snap-not-classic"
+	ErrorKindSnapNoEntitlement      = "snap-no-entitlement"
 	ErrorKindNoUpdateAvailable      = "snap-no-update-available"
 
 	ErrorKindRevisionNotAvailable     = "snap-revision-not-available"
@@ -453,9 +488,15 @@ const (
 
 	ErrorKindBadQuery           = "bad-query"
 	ErrorKindConfigNoSuchOption = "option-not-found"
+	ErrorKindInvalidConfig      = "invalid-config"
+
+	ErrorKindAssertionPrerequisiteMissing = "assertion-prerequisite-missing"
+	ErrorKindAssertionRevisionConflict    = "assertion-revision-conflict"
+	ErrorKindAssertionUnsupportedFormat   = "assertion-unsupported-format"
 
-	ErrorKindSystemRestart = "system-restart"
-	ErrorKindDaemonRestart = "daemon-restart"
+	ErrorKindSystemRestart        = "system-restart"
+	ErrorKindDaemonRestart        = "daemon-restart"
+	ErrorKindDaemonVersionChanged = "daemon-version-changed"
 )
 
 // IsRetryable returns true if the given error is an error
@@ -520,6 +561,8 @@ type SysInfo struct {
 	Refresh         RefreshInfo         `json:"refresh,omitempty"`
 	Confinement     string              `json:"confinement"`
 	SandboxFeatures map[string][]string `json:"sandbox-features,omitempty"`
+	// Capabilities lists the optional subsystems snapd was built with.
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 func (rsp *response) err(cli *Client, statusCode int) error {
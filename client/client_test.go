@@ -238,7 +238,8 @@ func (cs *clientSuite) TestClientSysInfo(c *C) {
                       "on-classic": true,
                       "build-id": "1234",
                       "confinement": "strict",
-                      "sandbox-features": {"backend": ["feature-1", "feature-2"]}}}`
+                      "sandbox-features": {"backend": ["feature-1", "feature-2"]},
+                      "capabilities": ["hotplug"]}}`
 	sysInfo, err := cs.cli.SysInfo()
 	c.Check(err, IsNil)
 	c.Check(sysInfo, DeepEquals, &client.SysInfo{
@@ -253,7 +254,8 @@ func (cs *clientSuite) TestClientSysInfo(c *C) {
 		SandboxFeatures: map[string][]string{
 			"backend": {"feature-1", "feature-2"},
 		},
-		BuildID: "1234",
+		Capabilities: []string{"hotplug"},
+		BuildID:      "1234",
 	})
 }
 
@@ -386,6 +388,34 @@ func (cs *clientSuite) TestClientMaintenance(c *C) {
 	c.Check(cs.cli.Maintenance(), Equals, error(nil))
 }
 
+func (cs *clientSuite) TestClientDaemonVersion(c *C) {
+	c.Check(cs.cli.DaemonVersion(), Equals, "")
+
+	cs.rsp = `{"type":"sync", "result":{"series":"42"}}`
+	_, err := cs.cli.SysInfo()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Header.Get("X-Snapd-Seen-Version"), Equals, "")
+	// no version from the daemon
+	c.Check(cs.cli.DaemonVersion(), Equals, "")
+
+	cs.header = http.Header{"X-Snapd-Version": {"2.42"}}
+	_, err = cs.cli.SysInfo()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Header.Get("X-Snapd-Seen-Version"), Equals, "")
+	c.Check(cs.cli.DaemonVersion(), Equals, "2.42")
+
+	cs.header = http.Header{"X-Snapd-Version": {"2.43"}}
+	cs.rsp = `{"type":"sync", "result":{"series":"42"}, "maintenance": {"kind": "daemon-version-changed", "message": "snapd changed from version 2.42 to 2.43"}}`
+	_, err = cs.cli.SysInfo()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Header.Get("X-Snapd-Seen-Version"), Equals, "2.42")
+	c.Check(cs.cli.DaemonVersion(), Equals, "2.43")
+	c.Check(cs.cli.Maintenance().(*client.Error), DeepEquals, &client.Error{
+		Kind:    client.ErrorKindDaemonVersionChanged,
+		Message: "snapd changed from version 2.42 to 2.43",
+	})
+}
+
 func (cs *clientSuite) TestClientAsyncOpMaintenance(c *C) {
 	cs.status = 202
 	cs.rsp = `{"type":"async", "status-code": 202, "change": "42", "maintenance": {"kind": "system-restart", "message": "system is restarting"}}`
@@ -39,14 +39,83 @@ func (client *Client) SetConf(snapName string, patch map[string]interface{}) (ch
 //
 // Note that the configuration may include json.Numbers.
 func (client *Client) Conf(snapName string, keys []string) (configuration map[string]interface{}, err error) {
+	configuration, _, err = client.ConfWithOrigins(snapName, keys)
+	return configuration, err
+}
+
+// ConfWithOrigins asks for a snap's current configuration like Conf,
+// returning also the origins (e.g. "gadget") of the values that were
+// not set explicitly, keyed by their configuration path.
+func (client *Client) ConfWithOrigins(snapName string, keys []string) (configuration map[string]interface{}, origins map[string]string, err error) {
 	// Prepare query
 	query := url.Values{}
 	query.Set("keys", strings.Join(keys, ","))
 
-	_, err = client.doSync("GET", "/v2/snaps/"+snapName+"/conf", query, nil, nil, &configuration)
+	info, err := client.doSync("GET", "/v2/snaps/"+snapName+"/conf", query, nil, nil, &configuration)
 	if err != nil {
+		return nil, nil, err
+	}
+
+	return configuration, info.Origins, nil
+}
+
+// ExportConf asks for the full configuration documents of the given
+// snaps, or of all the snaps with some configuration if none are
+// given, keyed by snap name.
+//
+// Note that the configuration may include json.Numbers.
+func (client *Client) ExportConf(snapNames []string) (map[string]map[string]interface{}, error) {
+	query := url.Values{}
+	if len(snapNames) > 0 {
+		query.Set("snaps", strings.Join(snapNames, ","))
+	}
+
+	var docs map[string]map[string]interface{}
+	if _, err := client.doSync("GET", "/v2/config", query, nil, nil, &docs); err != nil {
 		return nil, err
 	}
+	return docs, nil
+}
+
+// A ConfChange describes how a configuration option of a snap changes
+// when importing configuration.
+type ConfChange struct {
+	Snap string `json:"snap"`
+	Key  string `json:"key"`
+	// Kind is one of "added", "removed" or "changed".
+	Kind string      `json:"kind"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
 
-	return configuration, nil
+type importConfData struct {
+	Config map[string]map[string]interface{} `json:"config"`
+	DryRun bool                              `json:"dry-run,omitempty"`
+}
+
+// ImportConf requests the configuration of the snaps to be replaced
+// with the given documents, keyed by snap name, as returned by
+// ExportConf.
+func (client *Client) ImportConf(docs map[string]map[string]interface{}) (changeID string, err error) {
+	b, err := json.Marshal(&importConfData{Config: docs})
+	if err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/config", nil, nil, bytes.NewReader(b))
+}
+
+// DiffConf returns the changes to the configuration of the snaps that
+// importing the given documents with ImportConf would make, without
+// making them.
+func (client *Client) DiffConf(docs map[string]map[string]interface{}) ([]ConfChange, error) {
+	b, err := json.Marshal(&importConfData{Config: docs, DryRun: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []ConfChange
+	if _, err := client.doSync("POST", "/v2/config", nil, nil, bytes.NewReader(b), &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
@@ -23,6 +23,8 @@ import (
 	"encoding/json"
 
 	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
 )
 
 func (cs *clientSuite) TestClientSetConfCallsEndpoint(c *check.C) {
@@ -76,6 +78,19 @@ func (cs *clientSuite) TestClientGetConf(c *check.C) {
 	c.Check(value, check.DeepEquals, map[string]interface{}{"test-key": "test-value"})
 }
 
+func (cs *clientSuite) TestClientGetConfWithOrigins(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"test-key": "test-value", "other-key": 1},
+		"origins": {"test-key": "gadget"}
+	}`
+	value, origins, err := cs.cli.ConfWithOrigins("snap-name", []string{"test-key", "other-key"})
+	c.Assert(err, check.IsNil)
+	c.Check(value, check.DeepEquals, map[string]interface{}{"test-key": "test-value", "other-key": json.Number("1")})
+	c.Check(origins, check.DeepEquals, map[string]string{"test-key": "gadget"})
+}
+
 func (cs *clientSuite) TestClientGetConfBigInt(c *check.C) {
 	cs.rsp = `{
 		"type": "sync",
@@ -103,3 +118,62 @@ func (cs *clientSuite) TestClientGetConfMultipleKeys(c *check.C) {
 		"test-key2": "test-value2",
 	})
 }
+
+func (cs *clientSuite) TestClientExportConf(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"snap-name": {"test-key": 42}}
+	}`
+	docs, err := cs.cli.ExportConf([]string{"snap-name", "other"})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/config")
+	c.Check(cs.req.URL.Query().Get("snaps"), check.Equals, "snap-name,other")
+	c.Check(docs, check.DeepEquals, map[string]map[string]interface{}{
+		"snap-name": {"test-key": json.Number("42")},
+	})
+}
+
+func (cs *clientSuite) TestClientImportConf(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": { },
+		"change": "foo"
+	}`
+	id, err := cs.cli.ImportConf(map[string]map[string]interface{}{
+		"snap-name": {"key": "value"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "foo")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/config")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"config": map[string]interface{}{
+			"snap-name": map[string]interface{}{"key": "value"},
+		},
+	})
+}
+
+func (cs *clientSuite) TestClientDiffConf(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": [{"snap": "snap-name", "key": "key", "kind": "changed", "old": "foo", "new": "value"}]
+	}`
+	changes, err := cs.cli.DiffConf(map[string]map[string]interface{}{
+		"snap-name": {"key": "value"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(changes, check.DeepEquals, []client.ConfChange{
+		{Snap: "snap-name", Key: "key", Kind: "changed", Old: "foo", New: "value"},
+	})
+	c.Check(cs.req.Method, check.Equals, "POST")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body["dry-run"], check.Equals, true)
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ConnRef is a reference to a connection between a plug and a slot.
+type ConnRef struct {
+	Plug PlugRef `json:"plug"`
+	Slot SlotRef `json:"slot"`
+}
+
+// ConnectionProfile is a named set of connections that are made, and
+// of connections that are broken, in a single change when the profile
+// is applied. Reverting the profile does the opposite.
+type ConnectionProfile struct {
+	Name       string    `json:"name"`
+	Connect    []ConnRef `json:"connect,omitempty"`
+	Disconnect []ConnRef `json:"disconnect,omitempty"`
+}
+
+// connectionProfileAction is an action performed on connection
+// profiles, keep this in sync with daemon/connectionProfileAction.
+type connectionProfileAction struct {
+	ConnectionProfile
+	Action string `json:"action"`
+}
+
+func connectionProfileActionBody(action string, profile *ConnectionProfile) (*bytes.Reader, error) {
+	b, err := json.Marshal(&connectionProfileAction{
+		ConnectionProfile: *profile,
+		Action:            action,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// ConnectionProfiles returns the connection profiles defined in the
+// system.
+func (client *Client) ConnectionProfiles() ([]ConnectionProfile, error) {
+	var profiles []ConnectionProfile
+	_, err := client.doSync("GET", "/v2/interfaces/profiles", nil, nil, nil, &profiles)
+	return profiles, err
+}
+
+// SetConnectionProfile defines the given connection profile, replacing
+// any profile with the same name.
+func (client *Client) SetConnectionProfile(profile *ConnectionProfile) error {
+	body, err := connectionProfileActionBody("set", profile)
+	if err != nil {
+		return err
+	}
+	_, err = client.doSync("POST", "/v2/interfaces/profiles", nil, nil, body, nil)
+	return err
+}
+
+// RemoveConnectionProfile removes the named connection profile.
+func (client *Client) RemoveConnectionProfile(name string) error {
+	body, err := connectionProfileActionBody("remove", &ConnectionProfile{Name: name})
+	if err != nil {
+		return err
+	}
+	_, err = client.doSync("POST", "/v2/interfaces/profiles", nil, nil, body, nil)
+	return err
+}
+
+// ApplyConnectionProfile makes and breaks the connections of the named
+// profile in a single change.
+func (client *Client) ApplyConnectionProfile(name string) (changeID string, err error) {
+	body, err := connectionProfileActionBody("apply", &ConnectionProfile{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/interfaces/profiles", nil, nil, body)
+}
+
+// RevertConnectionProfile undoes what applying the named profile does,
+// in a single change.
+func (client *Client) RevertConnectionProfile(name string) (changeID string, err error) {
+	body, err := connectionProfileActionBody("revert", &ConnectionProfile{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/interfaces/profiles", nil, nil, body)
+}
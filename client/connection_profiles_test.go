@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientConnectionProfiles(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": [
+			{
+				"name": "kiosk",
+				"connect": [
+					{
+						"plug": {"snap": "browser", "plug": "camera"},
+						"slot": {"snap": "core", "slot": "camera"}
+					}
+				]
+			}
+		]
+	}`
+	profiles, err := cs.cli.ConnectionProfiles()
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces/profiles")
+	c.Check(profiles, check.DeepEquals, []client.ConnectionProfile{{
+		Name: "kiosk",
+		Connect: []client.ConnRef{{
+			Plug: client.PlugRef{Snap: "browser", Name: "camera"},
+			Slot: client.SlotRef{Snap: "core", Name: "camera"},
+		}},
+	}})
+}
+
+func (cs *clientSuite) TestClientSetConnectionProfile(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": null}`
+	err := cs.cli.SetConnectionProfile(&client.ConnectionProfile{
+		Name: "kiosk",
+		Disconnect: []client.ConnRef{{
+			Plug: client.PlugRef{Snap: "browser", Name: "camera"},
+			Slot: client.SlotRef{Snap: "core", Name: "camera"},
+		}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces/profiles")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "set",
+		"name":   "kiosk",
+		"disconnect": []interface{}{
+			map[string]interface{}{
+				"plug": map[string]interface{}{"snap": "browser", "plug": "camera"},
+				"slot": map[string]interface{}{"snap": "core", "slot": "camera"},
+			},
+		},
+	})
+}
+
+func (cs *clientSuite) TestClientRemoveConnectionProfile(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": null}`
+	err := cs.cli.RemoveConnectionProfile("kiosk")
+	c.Assert(err, check.IsNil)
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "remove",
+		"name":   "kiosk",
+	})
+}
+
+func (cs *clientSuite) TestClientApplyRevertConnectionProfile(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": { },
+		"change": "42"
+	}`
+	for action, f := range map[string]func(string) (string, error){
+		"apply":  cs.cli.ApplyConnectionProfile,
+		"revert": cs.cli.RevertConnectionProfile,
+	} {
+		id, err := f("kiosk")
+		c.Assert(err, check.IsNil)
+		c.Check(id, check.Equals, "42")
+		c.Check(cs.req.Method, check.Equals, "POST")
+		c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces/profiles")
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+		c.Check(body, check.DeepEquals, map[string]interface{}{
+			"action": action,
+			"name":   "kiosk",
+		})
+	}
+}
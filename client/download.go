@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DownloadOptions represent the options of the Download call.
+type DownloadOptions struct {
+	Channel   string `json:"channel,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	CohortKey string `json:"cohort-key,omitempty"`
+}
+
+// DownloadInfo describes a snap downloaded by snapd.
+type DownloadInfo struct {
+	Size     int64
+	Revision string
+	Sha3_384 string
+}
+
+type downloadAction struct {
+	Action       string   `json:"action"`
+	Snaps        []string `json:"snaps"`
+	SnapSHA3_384 string   `json:"snap-sha3-384,omitempty"`
+	DownloadOptions
+}
+
+func (client *Client) postDownload(action *downloadAction) (io.ReadCloser, *DownloadInfo, error) {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot marshal download action: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	rsp, err := client.raw("POST", "/v2/download", nil, headers, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if rsp.StatusCode != 200 {
+		defer rsp.Body.Close()
+		return nil, nil, parseError(rsp)
+	}
+	dlInfo := &DownloadInfo{
+		Revision: rsp.Header.Get("Snap-Revision"),
+		Sha3_384: rsp.Header.Get("Snap-Sha3-384"),
+	}
+	if length := rsp.Header.Get("Content-Length"); length != "" {
+		dlInfo.Size, err = strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			rsp.Body.Close()
+			return nil, nil, fmt.Errorf("cannot get download size: %v", err)
+		}
+	}
+	return rsp.Body, dlInfo, nil
+}
+
+// Download asks snapd to download the given snap from the store, using
+// the store session, proxies and cache of the device, and streams it. The
+// caller is responsible for closing the returned stream.
+func (client *Client) Download(name string, options *DownloadOptions) (stream io.ReadCloser, dlInfo *DownloadInfo, err error) {
+	if options == nil {
+		options = &DownloadOptions{}
+	}
+	return client.postDownload(&downloadAction{
+		Action:          "download",
+		Snaps:           []string{name},
+		DownloadOptions: *options,
+	})
+}
+
+// DownloadAssertions asks snapd to fetch from the store the assertions of
+// the given snap, downloaded with the given digest, and streams them. The
+// assertions are checked by snapd but not added to its assertion
+// database. The caller is responsible for closing the returned stream.
+func (client *Client) DownloadAssertions(name, sha3_384 string) (stream io.ReadCloser, err error) {
+	stream, _, err = client.postDownload(&downloadAction{
+		Action:       "fetch-assertions",
+		Snaps:        []string{name},
+		SnapSHA3_384: sha3_384,
+	})
+	return stream, err
+}
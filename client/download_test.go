@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientDownload(c *check.C) {
+	cs.header = http.Header{
+		"Content-Type":   []string{"application/octet-stream"},
+		"Content-Length": []string{"4"},
+		"Snap-Revision":  []string{"7"},
+		"Snap-Sha3-384":  []string{"some-digest"},
+	}
+	cs.rsp = "SNAP"
+
+	stream, dlInfo, err := cs.cli.Download("foo", &client.DownloadOptions{Channel: "edge"})
+	c.Assert(err, check.IsNil)
+	defer stream.Close()
+	c.Check(dlInfo, check.DeepEquals, &client.DownloadInfo{
+		Size:     4,
+		Revision: "7",
+		Sha3_384: "some-digest",
+	})
+	data, err := ioutil.ReadAll(stream)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "SNAP")
+
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/download")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action":  "download",
+		"snaps":   []interface{}{"foo"},
+		"channel": "edge",
+	})
+}
+
+func (cs *clientSuite) TestClientDownloadError(c *check.C) {
+	cs.status = 404
+	cs.header = http.Header{"Content-Type": []string{"application/json"}}
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "snap not found", "kind": "snap-not-found"}}`
+
+	_, _, err := cs.cli.Download("foo", nil)
+	c.Check(err, check.ErrorMatches, "snap not found")
+}
+
+func (cs *clientSuite) TestClientDownloadAssertions(c *check.C) {
+	cs.header = http.Header{"Content-Type": []string{"application/x.ubuntu.assertion; bundle=y"}}
+	cs.rsp = "type: account\n"
+
+	stream, err := cs.cli.DownloadAssertions("foo", "some-digest")
+	c.Assert(err, check.IsNil)
+	defer stream.Close()
+	data, err := ioutil.ReadAll(stream)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "type: account\n")
+
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action":        "fetch-assertions",
+		"snaps":         []interface{}{"foo"},
+		"snap-sha3-384": "some-digest",
+	})
+}
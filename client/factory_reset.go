@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// FactoryResetOptions holds the options for resetting the device to
+// its factory state.
+type FactoryResetOptions struct {
+	// RegenerateIdentity makes the device register anew after the
+	// reset instead of keeping its current serial.
+	RegenerateIdentity bool
+}
+
+type factoryResetData struct {
+	Identity string `json:"identity,omitempty"`
+}
+
+// FactoryReset resets the device to its factory state. The device
+// reboots in the process.
+func (client *Client) FactoryReset(opts *FactoryResetOptions) (changeID string, err error) {
+	if opts == nil {
+		opts = &FactoryResetOptions{}
+	}
+	var resetData factoryResetData
+	if opts.RegenerateIdentity {
+		resetData.Identity = "regenerate"
+	}
+	data, err := json.Marshal(&resetData)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal factory reset data: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	return client.doAsync("POST", "/v2/factory-reset", nil, headers, bytes.NewReader(data))
+}
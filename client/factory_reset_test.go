@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientFactoryReset(c *C) {
+	for _, t := range []struct {
+		opts     *client.FactoryResetOptions
+		expected map[string]interface{}
+	}{
+		{nil, map[string]interface{}{}},
+		{&client.FactoryResetOptions{}, map[string]interface{}{}},
+		{&client.FactoryResetOptions{RegenerateIdentity: true}, map[string]interface{}{"identity": "regenerate"}},
+	} {
+		cs.status = 202
+		cs.rsp = `{
+			"type": "async",
+			"status-code": 202,
+			"result": {},
+			"change": "d728"
+		}`
+		id, err := cs.cli.FactoryReset(t.opts)
+		c.Assert(err, IsNil)
+		c.Check(id, Equals, "d728")
+		c.Check(cs.req.Method, Equals, "POST")
+		c.Check(cs.req.URL.Path, Equals, "/v2/factory-reset")
+		c.Check(cs.req.Header.Get("Content-Type"), Equals, "application/json")
+
+		body, err := ioutil.ReadAll(cs.req.Body)
+		c.Assert(err, IsNil)
+		var jsonBody map[string]interface{}
+		err = json.Unmarshal(body, &jsonBody)
+		c.Assert(err, IsNil)
+		c.Check(jsonBody, DeepEquals, t.expected)
+	}
+}
@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// IdempotencyKeyHeader is the HTTP request header carrying the key
+// snapd uses to recognise retries of a mutating request.
+const IdempotencyKeyHeader = "X-Snapd-Idempotency-Key"
+
+// NewIdempotencyKey returns a new random key to use with Idempotent.
+func NewIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Idempotent calls f with a client sending the given key along with
+// its mutating requests; f is expected to make a single one. As long as
+// f fails with a ConnectionError, for example because of a timeout, it
+// is called again, until the given timeout expires. Requests made with
+// the original client meanwhile do not carry the key.
+//
+// snapd remembers the change started by a request carrying a key for a
+// day and answers any later request carrying the same key with that
+// change instead of starting a new one. Retrying a request whose
+// response was lost thus does not install or refresh snaps twice. A
+// request carrying a key already used for a different request is
+// refused. Keys are scoped to the user making the request.
+func (client *Client) Idempotent(key string, timeout time.Duration, f func(cli *Client) error) error {
+	idem := *client
+	idem.idempotencyKey = key
+	defer func() {
+		// what was learnt about the daemon still holds
+		client.maintenance = idem.maintenance
+		client.daemonVersion = idem.daemonVersion
+		client.warningCount = idem.warningCount
+		client.warningTimestamp = idem.warningTimestamp
+	}()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f(&idem)
+		if _, ok := err.(ConnectionError); !ok || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(doRetry)
+	}
+}
@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestNewIdempotencyKey(c *check.C) {
+	key1, err := client.NewIdempotencyKey()
+	c.Assert(err, check.IsNil)
+	key2, err := client.NewIdempotencyKey()
+	c.Assert(err, check.IsNil)
+	c.Check(key1, check.Matches, "[0-9a-f]{32}")
+	c.Check(key1, check.Not(check.Equals), key2)
+}
+
+func (cs *clientSuite) TestIdempotentRetriesWithSameKey(c *check.C) {
+	var keys []string
+	cs.cli.Hijack(func(req *http.Request) (*http.Response, error) {
+		keys = append(keys, req.Header.Get(client.IdempotencyKeyHeader))
+		if len(keys) == 1 {
+			return nil, errors.New("timeout")
+		}
+		return &http.Response{
+			Body:       ioutil.NopCloser(strings.NewReader(`{"type": "async", "status-code": 202, "change": "42"}`)),
+			StatusCode: 202,
+		}, nil
+	})
+
+	var id string
+	err := cs.cli.Idempotent("some-key", time.Second, func(cli *client.Client) (err error) {
+		id, err = cli.Install("foo", nil)
+		if err == nil {
+			// other requests do not carry the key
+			_, err = cs.cli.Install("bar", nil)
+		}
+		return err
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "42")
+	c.Check(keys, check.DeepEquals, []string{"some-key", "some-key", ""})
+
+	// nor once done
+	_, err = cs.cli.Install("foo", nil)
+	c.Assert(err, check.IsNil)
+	c.Check(keys[3], check.Equals, "")
+}
+
+func (cs *clientSuite) TestIdempotentGivesUp(c *check.C) {
+	cs.err = errors.New("timeout")
+	n := 0
+	err := cs.cli.Idempotent("some-key", 5*time.Millisecond, func(cli *client.Client) error {
+		n++
+		_, err := cli.Install("foo", nil)
+		return err
+	})
+	c.Check(err, check.ErrorMatches, "cannot communicate with server: timeout")
+	c.Check(n > 1, check.Equals, true)
+	c.Check(cs.req.Header.Get(client.IdempotencyKeyHeader), check.Equals, "some-key")
+}
+
+func (cs *clientSuite) TestIdempotentDoesNotRetryOtherErrors(c *check.C) {
+	n := 0
+	err := cs.cli.Idempotent("some-key", time.Second, func(*client.Client) error {
+		n++
+		return errors.New("boom")
+	})
+	c.Check(err, check.ErrorMatches, "boom")
+	c.Check(n, check.Equals, 1)
+}
+
+func (cs *clientSuite) TestIdempotentKeyNotSentOnGET(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": []}`
+	err := cs.cli.Idempotent("some-key", time.Second, func(cli *client.Client) error {
+		_, err := cli.Changes(nil)
+		return err
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Header.Get(client.IdempotencyKeyHeader), check.Equals, "")
+}
@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Plug represents the potential of a given snap to connect to a slot.
@@ -71,9 +72,10 @@ type Interface struct {
 
 // InterfaceAction represents an action performed on the interface system.
 type InterfaceAction struct {
-	Action string `json:"action"`
-	Plugs  []Plug `json:"plugs,omitempty"`
-	Slots  []Slot `json:"slots,omitempty"`
+	Action   string `json:"action"`
+	Plugs    []Plug `json:"plugs,omitempty"`
+	Slots    []Slot `json:"slots,omitempty"`
+	Duration string `json:"duration,omitempty"`
 }
 
 // InterfaceOptions represents opt-in elements include in responses.
@@ -132,6 +134,17 @@ func (client *Client) Connect(plugSnapName, plugName, slotSnapName, slotName str
 	})
 }
 
+// ConnectFor establishes a temporary connection between a plug and a
+// slot, which is broken again once the given duration has passed.
+func (client *Client) ConnectFor(plugSnapName, plugName, slotSnapName, slotName string, duration time.Duration) (changeID string, err error) {
+	return client.performInterfaceAction(&InterfaceAction{
+		Action:   "connect",
+		Plugs:    []Plug{{Snap: plugSnapName, Name: plugName}},
+		Slots:    []Slot{{Snap: slotSnapName, Name: slotName}},
+		Duration: duration.String(),
+	})
+}
+
 // Disconnect breaks the connection between a plug and a slot.
 func (client *Client) Disconnect(plugSnapName, plugName, slotSnapName, slotName string) (changeID string, err error) {
 	return client.performInterfaceAction(&InterfaceAction{
@@ -21,6 +21,7 @@ package client_test
 
 import (
 	"encoding/json"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -194,6 +195,39 @@ func (cs *clientSuite) TestClientConnect(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestClientConnectFor(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"result": { },
+		"change": "foo"
+	}`
+	id, err := cs.cli.ConnectFor("producer", "plug", "consumer", "slot", 90*time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "foo")
+	var body map[string]interface{}
+	decoder := json.NewDecoder(cs.req.Body)
+	err = decoder.Decode(&body)
+	c.Check(err, check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "connect",
+		"plugs": []interface{}{
+			map[string]interface{}{
+				"snap": "producer",
+				"plug": "plug",
+			},
+		},
+		"slots": []interface{}{
+			map[string]interface{}{
+				"snap": "consumer",
+				"slot": "slot",
+			},
+		},
+		"duration": "1h30m0s",
+	})
+}
+
 func (cs *clientSuite) TestClientDisconnectCallsEndpoint(c *check.C) {
 	cs.cli.Disconnect("producer", "plug", "consumer", "slot")
 	c.Check(cs.req.Method, check.Equals, "POST")
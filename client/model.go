@@ -23,6 +23,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+
+	"github.com/snapcore/snapd/asserts"
 )
 
 type remodelData struct {
@@ -43,3 +45,46 @@ func (client *Client) Remodel(b []byte) (changeID string, err error) {
 
 	return client.doAsync("POST", "/v2/model", nil, headers, bytes.NewReader(data))
 }
+
+// CurrentModelAssertion returns the current model assertion of the device.
+func (client *Client) CurrentModelAssertion() (*asserts.Model, error) {
+	a, err := client.currentAssertion("/v2/model")
+	if err != nil {
+		return nil, err
+	}
+	model, ok := a.(*asserts.Model)
+	if !ok {
+		return nil, fmt.Errorf("unexpected assertion type %q instead of model", a.Type().Name)
+	}
+	return model, nil
+}
+
+// CurrentSerialAssertion returns the current serial assertion of the device.
+func (client *Client) CurrentSerialAssertion() (*asserts.Serial, error) {
+	a, err := client.currentAssertion("/v2/model/serial")
+	if err != nil {
+		return nil, err
+	}
+	serial, ok := a.(*asserts.Serial)
+	if !ok {
+		return nil, fmt.Errorf("unexpected assertion type %q instead of serial", a.Type().Name)
+	}
+	return serial, nil
+}
+
+func (client *Client) currentAssertion(path string) (asserts.Assertion, error) {
+	response, err := client.raw("GET", path, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query current assertion: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, parseError(response)
+	}
+
+	a, err := asserts.NewDecoder(response.Body).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode assertion: %v", err)
+	}
+	return a, nil
+}
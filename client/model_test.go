@@ -22,8 +22,12 @@ package client_test
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
 
 	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/client"
 )
 
 func (cs *clientSuite) TestClientRemodelEndpoint(c *C) {
@@ -54,3 +58,54 @@ func (cs *clientSuite) TestClientRemodel(c *C) {
 	c.Check(jsonBody, HasLen, 1)
 	c.Check(jsonBody["new-model"], Equals, string(remodelJsonData))
 }
+
+const happyModelAssertionResponse = `type: model
+authority-id: mememe
+series: 16
+brand-id: mememe
+model: test-model
+architecture: amd64
+base: core18
+gadget: pc
+kernel: pc-kernel
+required-snaps:
+  - core
+  - hello-world
+timestamp: 2017-07-27T00:00:00.0Z
+sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
+
+openpgp ...
+`
+
+func (cs *clientSuite) TestClientCurrentModelAssertion(c *C) {
+	cs.header = http.Header{}
+	cs.header.Add("Content-Type", asserts.MediaType)
+	cs.rsp = happyModelAssertionResponse
+	model, err := cs.cli.CurrentModelAssertion()
+	c.Assert(err, IsNil)
+	c.Check(cs.req.Method, Equals, "GET")
+	c.Check(cs.req.URL.Path, Equals, "/v2/model")
+	c.Check(model.BrandID(), Equals, "mememe")
+	c.Check(model.Model(), Equals, "test-model")
+
+	// the serial endpoint must serve a serial assertion
+	_, err = cs.cli.CurrentSerialAssertion()
+	c.Check(cs.req.URL.Path, Equals, "/v2/model/serial")
+	c.Check(err, ErrorMatches, `unexpected assertion type "model" instead of serial`)
+}
+
+func (cs *clientSuite) TestClientCurrentModelAssertionNotFound(c *C) {
+	cs.status = 404
+	cs.header = http.Header{}
+	cs.header.Add("Content-Type", "application/json")
+	cs.rsp = `{
+		"type": "error",
+		"status-code": 404,
+		"result": {
+			"message": "no model assertion yet"
+		}
+	}`
+	_, err := cs.cli.CurrentModelAssertion()
+	c.Assert(err, ErrorMatches, "no model assertion yet")
+	c.Check(err.(*client.Error).StatusCode, Equals, 404)
+}
@@ -68,6 +68,7 @@ type Snap struct {
 	Prices      map[string]float64    `json:"prices,omitempty"`
 	Screenshots []snap.ScreenshotInfo `json:"screenshots,omitempty"`
 	Media       snap.MediaInfos       `json:"media,omitempty"`
+	Links       map[string][]string   `json:"links,omitempty"`
 
 	// The flattended channel map with $track/$risk
 	Channels map[string]*snap.ChannelSnapInfo `json:"channels,omitempty"`
@@ -120,7 +121,8 @@ const (
 )
 
 type ResultInfo struct {
-	SuggestedCurrency string `json:"suggested-currency"`
+	SuggestedCurrency string            `json:"suggested-currency"`
+	Origins           map[string]string `json:"origins"`
 }
 
 // FindOptions supports exactly one of the following options:
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// QuotaValues are the resource limits of a quota group, zero values mean
+// no limit.
+type QuotaValues struct {
+	MemoryLimit   uint64 `json:"memory-limit,omitempty"`
+	CPUPercentage int    `json:"cpu-percentage,omitempty"`
+	IOWeight      int    `json:"io-weight,omitempty"`
+}
+
+// QuotaGroupResult describes a quota group of the system.
+type QuotaGroupResult struct {
+	GroupName   string       `json:"group-name"`
+	Parent      string       `json:"parent,omitempty"`
+	SubGroups   []string     `json:"sub-groups,omitempty"`
+	Snaps       []string     `json:"snaps,omitempty"`
+	Constraints *QuotaValues `json:"constraints,omitempty"`
+}
+
+type postQuotaData struct {
+	Action      string       `json:"action"`
+	GroupName   string       `json:"group-name"`
+	Parent      string       `json:"parent,omitempty"`
+	Snaps       []string     `json:"snaps,omitempty"`
+	Constraints *QuotaValues `json:"constraints,omitempty"`
+}
+
+func (client *Client) postQuota(data *postQuotaData) (changeID string, err error) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(data); err != nil {
+		return "", err
+	}
+	return client.doAsync("POST", "/v2/quotas", nil, nil, &body)
+}
+
+// EnsureQuota creates the quota group with the given snaps and
+// constraints, or adds the snaps to it and replaces its constraints, if
+// not nil, when it exists already. The parent group can only be given
+// when creating the group. It returns the ID of the change doing it.
+func (client *Client) EnsureQuota(groupName, parent string, snaps []string, constraints *QuotaValues) (changeID string, err error) {
+	if groupName == "" {
+		return "", fmt.Errorf("cannot create or update quota group without a name")
+	}
+	return client.postQuota(&postQuotaData{
+		Action:      "ensure",
+		GroupName:   groupName,
+		Parent:      parent,
+		Snaps:       snaps,
+		Constraints: constraints,
+	})
+}
+
+// RemoveQuota removes the given quota group. It returns the ID of the
+// change doing it.
+func (client *Client) RemoveQuota(groupName string) (changeID string, err error) {
+	if groupName == "" {
+		return "", fmt.Errorf("cannot remove quota group without a name")
+	}
+	return client.postQuota(&postQuotaData{
+		Action:    "remove",
+		GroupName: groupName,
+	})
+}
+
+// Quotas returns the quota groups of the system.
+func (client *Client) Quotas() ([]*QuotaGroupResult, error) {
+	var res []*QuotaGroupResult
+	if _, err := client.doSync("GET", "/v2/quotas", nil, nil, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
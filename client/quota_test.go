@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestEnsureQuota(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{"type": "async", "status-code": 202, "change": "42"}`
+	chgID, err := cs.cli.EnsureQuota("db", "parent", []string{"foo", "bar"}, &client.QuotaValues{MemoryLimit: 1000})
+	c.Assert(err, check.IsNil)
+	c.Check(chgID, check.Equals, "42")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/quotas")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":      "ensure",
+		"group-name":  "db",
+		"parent":      "parent",
+		"snaps":       []interface{}{"foo", "bar"},
+		"constraints": map[string]interface{}{"memory-limit": float64(1000)},
+	})
+}
+
+func (cs *clientSuite) TestEnsureQuotaNoName(c *check.C) {
+	_, err := cs.cli.EnsureQuota("", "", nil, nil)
+	c.Assert(err, check.ErrorMatches, `cannot create or update quota group without a name`)
+	c.Check(cs.req, check.IsNil)
+}
+
+func (cs *clientSuite) TestRemoveQuota(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{"type": "async", "status-code": 202, "change": "42"}`
+	chgID, err := cs.cli.RemoveQuota("db")
+	c.Assert(err, check.IsNil)
+	c.Check(chgID, check.Equals, "42")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/quotas")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":     "remove",
+		"group-name": "db",
+	})
+}
+
+func (cs *clientSuite) TestRemoveQuotaError(c *check.C) {
+	cs.status = 400
+	cs.rsp = `{"type": "error", "status-code": 400, "result": {"message": "quota group \"db\" does not exist"}}`
+	_, err := cs.cli.RemoveQuota("db")
+	c.Assert(err, check.ErrorMatches, `quota group "db" does not exist`)
+}
+
+func (cs *clientSuite) TestQuotas(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": [
+			{"group-name": "db", "sub-groups": ["small"], "snaps": ["foo"], "constraints": {"memory-limit": 1000}},
+			{"group-name": "small", "parent": "db", "constraints": {"io-weight": 10}}
+		]
+	}`
+	groups, err := cs.cli.Quotas()
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/quotas")
+	c.Check(groups, check.DeepEquals, []*client.QuotaGroupResult{
+		{GroupName: "db", SubGroups: []string{"small"}, Snaps: []string{"foo"}, Constraints: &client.QuotaValues{MemoryLimit: 1000}},
+		{GroupName: "small", Parent: "db", Constraints: &client.QuotaValues{IOWeight: 10}},
+	})
+}
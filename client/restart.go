@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RestartInfo holds information about the restart of snapd or of the
+// system required by a refresh, and about its postponement by clients.
+type RestartInfo struct {
+	// Pending is the kind of the restart that is pending, if any,
+	// either "daemon" or "system"
+	Pending string `json:"pending,omitempty"`
+	// PostponedUntil is the time until which restarts are
+	// postponed, if they are
+	PostponedUntil *time.Time `json:"postponed-until,omitempty"`
+	// PostponementsLeft is how many more times restarts can be
+	// postponed before they are carried out
+	PostponementsLeft int `json:"postponements-left"`
+}
+
+type restartAction struct {
+	Action string `json:"action"`
+}
+
+// SystemRestart returns information about the pending restart of snapd
+// or of the system, if any.
+func (client *Client) SystemRestart() (*RestartInfo, error) {
+	var info RestartInfo
+	if _, err := client.doSync("GET", "/v2/system-restart", nil, nil, nil, &info); err != nil {
+		return nil, fmt.Errorf("cannot get restart information: %v", err)
+	}
+	return &info, nil
+}
+
+// PostponeRestart asks snapd to postpone restarting, pending or not,
+// e.g. while a transaction the restart would interrupt is ongoing.
+func (client *Client) PostponeRestart() (*RestartInfo, error) {
+	data, err := json.Marshal(&restartAction{Action: "postpone"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot postpone restart: %v", err)
+	}
+
+	var info RestartInfo
+	if _, err := client.doSync("POST", "/v2/system-restart", nil, nil, bytes.NewReader(data), &info); err != nil {
+		return nil, fmt.Errorf("cannot postpone restart: %v", err)
+	}
+	return &info, nil
+}
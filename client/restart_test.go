@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientSystemRestart(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"pending": "system", "postponed-until": "2019-10-16T10:00:00Z", "postponements-left": 2}
+	}`
+	info, err := cs.cli.SystemRestart()
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/system-restart")
+
+	until := time.Date(2019, 10, 16, 10, 0, 0, 0, time.UTC)
+	c.Check(info, check.DeepEquals, &client.RestartInfo{
+		Pending:           "system",
+		PostponedUntil:    &until,
+		PostponementsLeft: 2,
+	})
+}
+
+func (cs *clientSuite) TestClientPostponeRestart(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"pending": "daemon", "postponed-until": "2019-10-16T10:00:00Z", "postponements-left": 1}
+	}`
+	info, err := cs.cli.PostponeRestart()
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/system-restart")
+	c.Check(info.Pending, check.Equals, "daemon")
+	c.Check(info.PostponementsLeft, check.Equals, 1)
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action": "postpone",
+	})
+}
+
+func (cs *clientSuite) TestClientPostponeRestartError(c *check.C) {
+	cs.rsp = `{
+		"type": "error",
+		"status-code": 400,
+		"result": {"message": "restart was postponed too many times already"}
+	}`
+	_, err := cs.cli.PostponeRestart()
+	c.Check(err, check.ErrorMatches, "cannot postpone restart: restart was postponed too many times already")
+}
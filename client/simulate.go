@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// PlannedTask describes a task of a simulated change.
+type PlannedTask struct {
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+}
+
+// ChangePlan describes what a change would do, as reported when
+// simulating it.
+type ChangePlan struct {
+	Summary string        `json:"summary"`
+	Tasks   []PlannedTask `json:"tasks"`
+	// Downloads lists the snaps that would be downloaded
+	Downloads []string `json:"downloads,omitempty"`
+	// Restart is set if snapd would restart
+	Restart bool `json:"restart,omitempty"`
+	// Reboot is set if the system would reboot
+	Reboot bool `json:"reboot,omitempty"`
+}
+
+// Simulate reports what installing, refreshing or removing (as given
+// by action) the snaps with the given names would do, without doing
+// it. Options are only supported for a single snap.
+func (client *Client) Simulate(action string, names []string, options *SnapOptions) (*ChangePlan, error) {
+	var path string
+	var data []byte
+	var err error
+	if len(names) == 1 {
+		path = fmt.Sprintf("/v2/snaps/%s", names[0])
+		data, err = json.Marshal(&actionData{
+			Action:      action,
+			Simulate:    true,
+			SnapOptions: options,
+		})
+	} else {
+		if options != nil {
+			return nil, fmt.Errorf("cannot use options for multi-action")
+		}
+		path = "/v2/snaps"
+		data, err = json.Marshal(&multiActionData{
+			Action:   action,
+			Snaps:    names,
+			Simulate: true,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal snap action: %s", err)
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	var plan ChangePlan
+	if _, err := client.doSync("POST", path, nil, headers, bytes.NewBuffer(data), &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientSimulate(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"summary": "Refresh \"foo\" snap",
+			"tasks": [{"kind": "download-snap", "summary": "Download snap \"foo\""}],
+			"downloads": ["foo"],
+			"reboot": true
+		}
+	}`
+	plan, err := cs.cli.Simulate("refresh", []string{"foo"}, &client.SnapOptions{Channel: "beta"})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snaps/foo")
+	c.Check(plan, check.DeepEquals, &client.ChangePlan{
+		Summary:   `Refresh "foo" snap`,
+		Tasks:     []client.PlannedTask{{Kind: "download-snap", Summary: `Download snap "foo"`}},
+		Downloads: []string{"foo"},
+		Reboot:    true,
+	})
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":   "refresh",
+		"channel":  "beta",
+		"simulate": true,
+	})
+}
+
+func (cs *clientSuite) TestClientSimulateMany(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {"summary": "Remove snaps \"foo\", \"bar\"", "tasks": []}
+	}`
+	plan, err := cs.cli.Simulate("remove", []string{"foo", "bar"}, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snaps")
+	c.Check(plan.Summary, check.Equals, `Remove snaps "foo", "bar"`)
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var jsonBody map[string]interface{}
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":   "remove",
+		"snaps":    []interface{}{"foo", "bar"},
+		"simulate": true,
+	})
+
+	_, err = cs.cli.Simulate("remove", []string{"foo", "bar"}, &client.SnapOptions{})
+	c.Check(err, check.ErrorMatches, "cannot use options for multi-action")
+}
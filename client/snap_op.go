@@ -27,6 +27,19 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+// TransactionType is the type of transaction of a multi-snap operation.
+type TransactionType string
+
+const (
+	// TransactionPerSnap makes a multi-snap operation succeed or fail
+	// independently for each snap. This is the default.
+	TransactionPerSnap TransactionType = "per-snap"
+	// TransactionAllOrNothing makes a multi-snap operation undo the
+	// changes to all the snaps if it fails for any of them.
+	TransactionAllOrNothing TransactionType = "all-or-nothing"
 )
 
 type SnapOptions struct {
@@ -42,10 +55,22 @@ type SnapOptions struct {
 	Unaliased        bool   `json:"unaliased,omitempty"`
 	Purge            bool   `json:"purge,omitempty"`
 	Amend            bool   `json:"amend,omitempty"`
+	FromSeed         bool   `json:"from-seed,omitempty"`
+
+	Transaction TransactionType `json:"transaction,omitempty"`
 
 	Users []string `json:"users,omitempty"`
 }
 
+// hasOptionsOtherThanTransaction returns whether any option other than the
+// transaction type, the only one supported by multi-snap actions, is set.
+func (opts *SnapOptions) hasOptionsOtherThanTransaction() bool {
+	return opts.Channel != "" || opts.Revision != "" || opts.CohortKey != "" ||
+		opts.LeaveCohort || opts.DevMode || opts.JailMode || opts.Classic ||
+		opts.Dangerous || opts.IgnoreValidation || opts.Unaliased ||
+		opts.Purge || opts.Amend || opts.FromSeed || len(opts.Users) > 0
+}
+
 func writeFieldBool(mw *multipart.Writer, key string, val bool) error {
 	if !val {
 		return nil
@@ -80,6 +105,7 @@ type actionData struct {
 	Action   string `json:"action"`
 	Name     string `json:"name,omitempty"`
 	SnapPath string `json:"snap-path,omitempty"`
+	Simulate bool   `json:"simulate,omitempty"`
 	*SnapOptions
 }
 
@@ -87,6 +113,10 @@ type multiActionData struct {
 	Action string   `json:"action"`
 	Snaps  []string `json:"snaps,omitempty"`
 	Users  []string `json:"users,omitempty"`
+
+	Transaction TransactionType `json:"transaction,omitempty"`
+
+	Simulate bool `json:"simulate,omitempty"`
 }
 
 // Install adds the snap with the given name from the given channel (or
@@ -136,6 +166,33 @@ func (client *Client) Switch(name string, options *SnapOptions) (changeID string
 	return client.doSnapAction("switch", name, options)
 }
 
+// HoldRefreshes holds the auto-refreshes of the snap until the given
+// time, or releases them if it is zero, and returns the time they are
+// actually held until.
+func (client *Client) HoldRefreshes(name string, until time.Time) (time.Time, error) {
+	action := struct {
+		Action    string    `json:"action"`
+		HoldUntil time.Time `json:"hold-until"`
+	}{
+		Action:    "hold",
+		HoldUntil: until,
+	}
+	data, err := json.Marshal(&action)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot marshal snap action: %s", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	var result struct {
+		HoldUntil time.Time `json:"hold-until"`
+	}
+	if _, err := client.doSync("POST", "/v2/snaps/"+name, nil, headers, bytes.NewBuffer(data), &result); err != nil {
+		return time.Time{}, err
+	}
+	return result.HoldUntil, nil
+}
+
 // SnapshotMany snapshots many snaps (all, if names empty) for many users (all, if users is empty).
 func (client *Client) SnapshotMany(names []string, users []string) (setID uint64, changeID string, err error) {
 	result, changeID, err := client.doMultiSnapActionFull("snapshot", names, &SnapOptions{Users: users})
@@ -178,7 +235,7 @@ func (client *Client) doSnapAction(actionName string, snapName string, options *
 }
 
 func (client *Client) doMultiSnapAction(actionName string, snaps []string, options *SnapOptions) (changeID string, err error) {
-	if options != nil {
+	if options != nil && options.hasOptionsOtherThanTransaction() {
 		return "", fmt.Errorf("cannot use options for multi-action") // (yet)
 	}
 	_, changeID, err = client.doMultiSnapActionFull(actionName, snaps, options)
@@ -193,6 +250,7 @@ func (client *Client) doMultiSnapActionFull(actionName string, snaps []string, o
 	}
 	if options != nil {
 		action.Users = options.Users
+		action.Transaction = options.Transaction
 	}
 	data, err := json.Marshal(&action)
 	if err != nil {
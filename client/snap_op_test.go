@@ -28,6 +28,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -182,6 +183,34 @@ func (cs *clientSuite) TestClientMultiOpSnap(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientMultiOpSnapTransaction(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	for _, s := range multiOps {
+		opts := &client.SnapOptions{Transaction: client.TransactionAllOrNothing}
+		id, err := s.op(cs.cli, []string{"foo", "bar"}, opts)
+		c.Assert(err, check.IsNil)
+
+		var jsonBody map[string]interface{}
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&jsonBody), check.IsNil)
+		c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+			"action":      s.action,
+			"snaps":       []interface{}{"foo", "bar"},
+			"transaction": "all-or-nothing",
+		}, check.Commentf(s.action))
+		c.Check(id, check.Equals, "d728", check.Commentf(s.action))
+
+		// other options are still not supported
+		opts.Purge = true
+		_, err = s.op(cs.cli, []string{"foo", "bar"}, opts)
+		c.Check(err, check.ErrorMatches, "cannot use options for multi-action", check.Commentf(s.action))
+	}
+}
+
 func (cs *clientSuite) TestClientMultiSnapshot(c *check.C) {
 	// Note body is essentially the same as TestClientMultiOpSnap; keep in sync
 	cs.status = 202
@@ -208,6 +237,29 @@ func (cs *clientSuite) TestClientMultiSnapshot(c *check.C) {
 	c.Check(changeID, check.Equals, "d728")
 }
 
+func (cs *clientSuite) TestClientHoldRefreshes(c *check.C) {
+	cs.rsp = `{
+		"result": {"hold-until": "2019-12-01T10:00:00Z"},
+		"status-code": 200,
+		"type": "sync"
+	}`
+	until, err := cs.cli.HoldRefreshes(pkgName, time.Date(2019, 12, 24, 10, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Check(until.Equal(time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)), check.Equals, true)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, fmt.Sprintf("/v2/snaps/%s", pkgName))
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	jsonBody := make(map[string]interface{})
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":     "hold",
+		"hold-until": "2019-12-24T10:00:00Z",
+	})
+}
+
 func (cs *clientSuite) TestClientOpInstallPath(c *check.C) {
 	cs.status = 202
 	cs.rsp = `{
@@ -427,6 +479,7 @@ func (cs *clientSuite) TestSnapOptionsSerialises(c *check.C) {
 		`{"unaliased":true}`:         {Unaliased: true},
 		`{"purge":true}`:             {Purge: true},
 		`{"amend":true}`:             {Amend: true},
+		`{"from-seed":true}`:         {FromSeed: true},
 	}
 	for expected, opts := range tests {
 		buf, err := json.Marshal(&opts)
@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"strconv"
 	"strings"
@@ -32,6 +33,9 @@ import (
 	"github.com/snapcore/snapd/snap"
 )
 
+// SnapshotExportMediaType is the media type of exported snapshot sets.
+const SnapshotExportMediaType = "application/x.snapd.snapshot"
+
 var (
 	ErrSnapshotSetNotFound   = errors.New("no snapshot set with the given ID")
 	ErrSnapshotSnapsNotFound = errors.New("no snapshot for the requested snaps found in the set with the given ID")
@@ -75,6 +79,20 @@ type Snapshot struct {
 
 	// set if the snapshot was created automatically on snap removal
 	Auto bool `json:"auto,omitempty"`
+
+	// set if the archives are encrypted
+	Encryption *SnapshotEncryption `json:"encryption,omitempty"`
+}
+
+// SnapshotEncryption describes how the archives of a snapshot are
+// encrypted. Only the archives are, the metadata (including the
+// configuration) is not.
+type SnapshotEncryption struct {
+	// the cipher the archives are encrypted with, e.g. "aes-256-gcm"
+	Cipher string `json:"cipher"`
+	// the id of the key, bound to the device that took the
+	// snapshot, the archives are encrypted with
+	KeyID string `json:"key-id"`
 }
 
 // IsValid checks whether the snapshot is missing information that
@@ -176,3 +194,45 @@ func (client *Client) snapshotAction(action *snapshotAction) (changeID string, e
 
 	return client.doAsync("POST", "/v2/snapshots", nil, headers, bytes.NewBuffer(data))
 }
+
+// SnapshotExport streams the export of the given snapshot set, whose
+// size is also returned. The caller is responsible for closing the
+// returned stream.
+func (client *Client) SnapshotExport(setID uint64) (stream io.ReadCloser, size int64, err error) {
+	rsp, err := client.raw("GET", fmt.Sprintf("/v2/snapshots/%d/export", setID), nil, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rsp.StatusCode != 200 {
+		defer rsp.Body.Close()
+		return nil, 0, parseError(rsp)
+	}
+	if contentType := rsp.Header.Get("Content-Type"); contentType != SnapshotExportMediaType {
+		rsp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected snapshot export content type %q", contentType)
+	}
+	size, err = strconv.ParseInt(rsp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		rsp.Body.Close()
+		return nil, 0, fmt.Errorf("cannot get snapshot export size: %v", err)
+	}
+	return rsp.Body, size, nil
+}
+
+// SnapshotImportSet is the snapshot set created by an import.
+type SnapshotImportSet struct {
+	ID    uint64   `json:"set-id"`
+	Snaps []string `json:"snaps"`
+}
+
+// SnapshotImport imports the snapshot set export read from r as a new
+// snapshot set.
+func (client *Client) SnapshotImport(r io.Reader) (SnapshotImportSet, error) {
+	headers := map[string]string{
+		"Content-Type": SnapshotExportMediaType,
+	}
+
+	var importSet SnapshotImportSet
+	_, err := client.doSync("POST", "/v2/snapshots", nil, headers, r, &importSet)
+	return importSet, err
+}
@@ -31,7 +31,11 @@ import (
 // silenced for a while before repeating. After a (supposedly longer) while
 // it'll go away on its own (unless it recurrs).
 type Warning struct {
+	// Key identifies the warning, e.g. to acknowledge it with
+	// OkayWarning.
+	Key         string        `json:"key,omitempty"`
 	Message     string        `json:"message"`
+	Severity    string        `json:"severity,omitempty"`
 	FirstAdded  time.Time     `json:"first-added"`
 	LastAdded   time.Time     `json:"last-added"`
 	LastShown   time.Time     `json:"last-shown,omitempty"`
@@ -48,8 +52,11 @@ type jsonWarning struct {
 // WarningsOptions contains options for querying snapd for warnings
 // supported options:
 // - All: return all warnings, instead of only the un-okayed ones.
+// - Severity: only return warnings with at least this severity, one
+//   of "info", "warning" or "critical".
 type WarningsOptions struct {
-	All bool
+	All      bool
+	Severity string
 }
 
 // Warnings returns the list of un-okayed warnings.
@@ -59,6 +66,9 @@ func (client *Client) Warnings(opts WarningsOptions) ([]*Warning, error) {
 	if opts.All {
 		q.Add("select", "all")
 	}
+	if opts.Severity != "" {
+		q.Add("severity", opts.Severity)
+	}
 	_, err := client.doSync("GET", "/v2/warnings", q, nil, nil, &jws)
 
 	ws := make([]*Warning, len(jws))
@@ -76,6 +86,11 @@ type warningsAction struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+type warningAction struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+}
+
 // Okay asks snapd to chill about the warnings that would have been returned by
 // Warnings at the given time.
 func (client *Client) Okay(t time.Time) error {
@@ -87,3 +102,14 @@ func (client *Client) Okay(t time.Time) error {
 	_, err := client.doSync("POST", "/v2/warnings", nil, nil, &body, nil)
 	return err
 }
+
+// OkayWarning asks snapd to chill about the warning with the given key.
+func (client *Client) OkayWarning(key string) error {
+	var body bytes.Buffer
+	var op = warningAction{Action: "okay", Key: key}
+	if err := json.NewEncoder(&body).Encode(op); err != nil {
+		return err
+	}
+	_, err := client.doSync("POST", "/v2/warnings", nil, nil, &body, nil)
+	return err
+}
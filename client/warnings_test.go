@@ -119,3 +119,50 @@ func (cs *clientSuite) TestOkay(c *check.C) {
 	c.Check(count, check.Equals, 0)
 	c.Check(stamp, check.Equals, time.Time{})
 }
+
+func (cs *clientSuite) TestWarningsSeverity(c *check.C) {
+	cs.rsp = `{
+		"result": [
+		    {
+			"key": "0123456789abcdef",
+			"expire-after": "672h0m0s",
+			"first-added": "2018-09-19T12:41:18.505007495Z",
+			"last-added": "2018-09-19T12:41:18.505007495Z",
+			"message": "hello world",
+			"severity": "critical",
+			"repeat-after": "24h0m0s"
+		    }
+		],
+		"status": "OK",
+		"status-code": 200,
+		"type": "sync"
+	}`
+
+	ws, err := cs.cli.Warnings(client.WarningsOptions{Severity: "warning"})
+	c.Assert(err, check.IsNil)
+	c.Assert(ws, check.HasLen, 1)
+	c.Check(ws[0].Key, check.Equals, "0123456789abcdef")
+	c.Check(ws[0].Severity, check.Equals, "critical")
+	c.Check(ws[0].Message, check.Equals, "hello world")
+	query := cs.req.URL.Query()
+	c.Check(query, check.HasLen, 1)
+	c.Check(query.Get("severity"), check.Equals, "warning")
+}
+
+func (cs *clientSuite) TestOkayWarning(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": 1
+	}`
+	err := cs.cli.OkayWarning("0123456789abcdef")
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/warnings")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action": "okay",
+		"key":    "0123456789abcdef",
+	})
+}
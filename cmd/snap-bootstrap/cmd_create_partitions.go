@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+)
+
+const (
+	shortCreatePartitionsHelp = "Create the partitions of the gadget on a disk"
+	longCreatePartitionsHelp  = `
+The create-partitions command partitions the given disk as described by
+the volume of the gadget holding the system-data structure, growing the
+last structure to fill the disk if it has a min-size, and creates the
+filesystems of the structures.
+
+With --encrypt, system-data and system-save are created inside LUKS
+containers set up with the key read from --key-file.
+`
+)
+
+type cmdCreatePartitions struct {
+	Encrypt bool   `long:"encrypt" description:"Encrypt the system-data and system-save structures"`
+	KeyFile string `long:"key-file" description:"File holding the encryption key"`
+
+	Positional struct {
+		GadgetRoot string `positional-arg-name:"<gadget-root>"`
+		Device     string `positional-arg-name:"<device>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// positioningConstraints match the ones of ubuntu-image and gadget
+// updates.
+var positioningConstraints = gadget.PositioningConstraints{
+	NonMBRStartOffset: 1 * gadget.SizeMiB,
+	SectorSize:        512,
+}
+
+func (c *cmdCreatePartitions) Execute(args []string) error {
+	if c.Encrypt != (c.KeyFile != "") {
+		return fmt.Errorf("--encrypt and --key-file must be used together")
+	}
+	var key []byte
+	if c.KeyFile != "" {
+		var err error
+		key, err = ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot read encryption key: %v", err)
+		}
+	}
+
+	pv, err := systemDataVolume(c.Positional.GadgetRoot)
+	if err != nil {
+		return err
+	}
+	diskSize, err := diskSize(c.Positional.Device)
+	if err != nil {
+		return err
+	}
+	plan, err := gadget.PlanInstall(pv, c.Positional.Device, diskSize, &gadget.InstallOptions{Encrypt: c.Encrypt})
+	if err != nil {
+		return err
+	}
+	if err := gadget.Install(plan, key); err != nil {
+		return err
+	}
+	for _, p := range plan.Structures {
+		fmt.Fprintf(Stdout, "%s %s\n", p.Node, p.Name)
+	}
+	return nil
+}
+
+// systemDataVolume returns the positioned volume of the gadget that
+// holds the system-data structure.
+func systemDataVolume(gadgetRoot string) (*gadget.PositionedVolume, error) {
+	info, err := gadget.ReadInfo(gadgetRoot, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(info.Volumes))
+	for name := range info.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vol := info.Volumes[name]
+		for _, vs := range vol.Structure {
+			if vs.EffectiveRole() == gadget.SystemData {
+				return gadget.PositionVolume(gadgetRoot, &vol, positioningConstraints)
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot find a volume with a system-data structure in the gadget")
+}
+
+// diskSize returns the size in bytes of the given disk.
+func diskSize(device string) (gadget.Size, error) {
+	out, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "blockdev",
+		Args: []string{"--getsize64", device},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot get size of %s: %v", device, err)
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse size of %s: %v", device, err)
+	}
+	return gadget.Size(size), nil
+}
@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	// TODO: consider not using go-flags at all
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+var (
+	Stdout io.Writer = os.Stdout
+	Stderr io.Writer = os.Stderr
+
+	osGetuid = os.Getuid
+)
+
+const (
+	shortHelp = "Bootstrap a device"
+	longHelp  = `
+snap-bootstrap sets up the disk of a device from the volume described
+by its gadget, as done when installing the device.
+`
+)
+
+func init() {
+	err := logger.SimpleSetup()
+	if err != nil {
+		fmt.Fprintf(Stderr, "WARNING: failed to activate logging: %v\n", err)
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parser() *flags.Parser {
+	p := flags.NewParser(nil, flags.HelpFlag|flags.PassDoubleDash)
+	p.ShortDescription = shortHelp
+	p.LongDescription = longHelp
+	p.AddCommand("create-partitions", shortCreatePartitionsHelp, longCreatePartitionsHelp, &cmdCreatePartitions{})
+	return p
+}
+
+func run(args []string) error {
+	if osGetuid() != 0 {
+		return fmt.Errorf("must be run as root")
+	}
+	_, err := parser().ParseArgs(args)
+	return err
+}
@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	bootstrap "github.com/snapcore/snapd/cmd/snap-bootstrap"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type bootstrapSuite struct {
+	testutil.BaseTest
+
+	gadgetRoot string
+	stdout     *bytes.Buffer
+
+	calls [][]string
+	stdin map[string]string
+}
+
+var _ = Suite(&bootstrapSuite{})
+
+const gadgetYaml = `volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+      - name: ubuntu-boot
+        role: system-boot
+        filesystem: vfat
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        size: 2M
+      - name: ubuntu-save
+        role: system-save
+        filesystem: ext4
+        type: 83,0FC63DAF-8483-4772-8E79-3D69D8477DE4
+        size: 1M
+      - name: ubuntu-data
+        role: system-data
+        filesystem: ext4
+        type: 83,0FC63DAF-8483-4772-8E79-3D69D8477DE4
+        size: 4M
+        min-size: 4M
+`
+
+func (s *bootstrapSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	s.stdout = bytes.NewBuffer(nil)
+	oldStdout := bootstrap.Stdout
+	bootstrap.Stdout = s.stdout
+	s.AddCleanup(func() { bootstrap.Stdout = oldStdout })
+
+	s.AddCleanup(bootstrap.MockOsGetuid(func() int { return 0 }))
+
+	s.gadgetRoot = c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(s.gadgetRoot, "meta"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.gadgetRoot, "meta/gadget.yaml"), []byte(gadgetYaml), 0644), IsNil)
+
+	s.calls = nil
+	s.stdin = make(map[string]string)
+	s.AddCleanup(osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		s.calls = append(s.calls, append([]string{hc.Name}, hc.Args...))
+		if hc.Stdin != nil {
+			data, err := ioutil.ReadAll(hc.Stdin)
+			c.Assert(err, IsNil)
+			s.stdin[hc.Name] = string(data)
+		}
+		if hc.Name == "blockdev" {
+			return []byte("104857600\n"), nil
+		}
+		return nil, nil
+	}))
+}
+
+func (s *bootstrapSuite) TestCreatePartitionsHappy(c *C) {
+	err := bootstrap.Run([]string{"create-partitions", s.gadgetRoot, "/dev/sda"})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.calls, HasLen, 6)
+	c.Check(s.calls[0], DeepEquals, []string{"blockdev", "--getsize64", "/dev/sda"})
+	c.Check(s.calls[1], DeepEquals, []string{"sfdisk", "/dev/sda"})
+	c.Check(s.calls[2], DeepEquals, []string{"udevadm", "settle", "--timeout=180"})
+	c.Check(s.calls[3][0], Equals, "mkfs.vfat")
+	c.Check(s.calls[3][len(s.calls[3])-1], Equals, "/dev/sda1")
+	c.Check(s.calls[4][len(s.calls[4])-1], Equals, "/dev/sda2")
+	c.Check(s.calls[5][len(s.calls[5])-1], Equals, "/dev/sda3")
+	// the data partition fills the disk, minus the backup GPT
+	c.Check(s.stdin["sfdisk"], testutil.Contains, "start=8192, size=196575, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+
+	c.Check(s.stdout.String(), Equals, `/dev/sda1 ubuntu-boot
+/dev/sda2 ubuntu-save
+/dev/sda3 ubuntu-data
+`)
+}
+
+func (s *bootstrapSuite) TestCreatePartitionsEncrypted(c *C) {
+	keyFile := filepath.Join(c.MkDir(), "key")
+	c.Assert(ioutil.WriteFile(keyFile, []byte("secret"), 0600), IsNil)
+
+	err := bootstrap.Run([]string{"create-partitions", "--encrypt", "--key-file", keyFile, s.gadgetRoot, "/dev/sda"})
+	c.Assert(err, IsNil)
+
+	var luks [][]string
+	for _, call := range s.calls {
+		if call[0] == "cryptsetup" {
+			luks = append(luks, call)
+		}
+	}
+	c.Check(luks, DeepEquals, [][]string{
+		{"cryptsetup", "-q", "luksFormat", "--type", "luks2", "--key-file", "-", "/dev/sda2"},
+		{"cryptsetup", "open", "--key-file", "-", "/dev/sda2", "ubuntu-save"},
+		{"cryptsetup", "-q", "luksFormat", "--type", "luks2", "--key-file", "-", "/dev/sda3"},
+		{"cryptsetup", "open", "--key-file", "-", "/dev/sda3", "ubuntu-data"},
+	})
+	c.Check(s.stdin["cryptsetup"], Equals, "secret")
+}
+
+func (s *bootstrapSuite) TestCreatePartitionsErrors(c *C) {
+	err := bootstrap.Run([]string{"create-partitions", "--encrypt", s.gadgetRoot, "/dev/sda"})
+	c.Check(err, ErrorMatches, "--encrypt and --key-file must be used together")
+
+	err = bootstrap.Run([]string{"create-partitions", c.MkDir(), "/dev/sda"})
+	c.Check(err, ErrorMatches, "open .*/meta/gadget.yaml: no such file or directory")
+
+	s.AddCleanup(osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		return nil, errors.New("no such device")
+	}))
+	err = bootstrap.Run([]string{"create-partitions", s.gadgetRoot, "/dev/sda"})
+	c.Check(err, ErrorMatches, "cannot get size of /dev/sda: no such device")
+	c.Check(s.calls, HasLen, 0)
+}
+
+func (s *bootstrapSuite) TestNotRoot(c *C) {
+	s.AddCleanup(bootstrap.MockOsGetuid(func() int { return 1000 }))
+
+	err := bootstrap.Run([]string{"create-partitions", s.gadgetRoot, "/dev/sda"})
+	c.Check(err, ErrorMatches, "must be run as root")
+	c.Check(s.calls, HasLen, 0)
+}
@@ -17,10 +17,14 @@
 #include "selinux-support.h"
 #include "config.h"
 
+#include <limits.h>
+#include <string.h>
+
 #include <selinux/context.h>
 #include <selinux/selinux.h>
 
 #include "../libsnap-confine-private/cleanup-funcs.h"
+#include "../libsnap-confine-private/feature.h"
 #include "../libsnap-confine-private/string-utils.h"
 #include "../libsnap-confine-private/utils.h"
 
@@ -38,12 +42,51 @@ static void sc_context_free(context_t *ctx) {
     }
 }
 
+/**
+ * Compute the SELinux domain of a security tag.
+ *
+ * The domain is named after the security tag without the "snap." prefix, with
+ * '-' replaced by '_', '.' by "__" and '_' by "___", and with the "_t" suffix,
+ * just like snapd names the domains in the policy modules it generates.
+ **/
+static void sc_selinux_snap_domain(const char *security_tag, char *buf, size_t buf_size) {
+    const char *prefix = "snap.";
+    if (!sc_startswith(security_tag, prefix)) {
+        die("security tag %s does not start with %s", security_tag, prefix);
+    }
+    sc_must_snprintf(buf, buf_size, "snap_");
+    size_t n = strlen(buf);
+    for (const char *c = security_tag + strlen(prefix); *c != '\0'; c++) {
+        const char *repl = NULL;
+        switch (*c) {
+            case '-':
+                repl = "_";
+                break;
+            case '.':
+                repl = "__";
+                break;
+            case '_':
+                repl = "___";
+                break;
+        }
+        if (repl != NULL) {
+            sc_must_snprintf(buf + n, buf_size - n, "%s", repl);
+        } else {
+            sc_must_snprintf(buf + n, buf_size - n, "%c", *c);
+        }
+        n = strlen(buf);
+    }
+    sc_must_snprintf(buf + n, buf_size - n, "_t");
+}
+
 /**
  * Set security context for the snap.
  *
- * Sets up SELinux context transition to unconfined_service_t.
+ * Sets up SELinux context transition to the domain of the security tag, if
+ * the selinux-policy feature is enabled and the domain is defined by the
+ * loaded policy, or to unconfined_service_t otherwise.
  **/
-int sc_selinux_set_snap_execcon(void) {
+int sc_selinux_set_snap_execcon(const char *security_tag) {
     if (is_selinux_enabled() < 1) {
         debug("SELinux not enabled");
         return 0;
@@ -75,16 +118,36 @@ int sc_selinux_set_snap_execcon(void) {
          * covered by the policy.
          *
          * At this point transition to the unconfined_service_t domain (allowed
-         * by snap_confine_t policy) upon the next exec() call.
+         * by snap_confine_t policy) upon the next exec() call, unless snapd
+         * generated and loaded a policy module confining the application or
+         * hook in its own domain.
          */
-        if (context_type_set(ctx, "unconfined_service_t") != 0) {
-            die("cannot update SELinux context %s type to unconfined_service_t", ctx_str);
+        char *new_ctx_str = NULL;
+        if (sc_feature_enabled(SC_FEATURE_SELINUX_POLICY)) {
+            char domain[PATH_MAX] = {0};
+            sc_selinux_snap_domain(security_tag, domain, sizeof domain);
+            if (context_type_set(ctx, domain) != 0) {
+                die("cannot update SELinux context %s type to %s", ctx_str, domain);
+            }
+            /* freed by context_free(ctx) */
+            new_ctx_str = context_str(ctx);
+            if (new_ctx_str == NULL) {
+                die("cannot obtain updated SELinux context string");
+            }
+            if (security_check_context(new_ctx_str) < 0) {
+                debug("SELinux domain %s is not defined by the loaded policy", domain);
+                new_ctx_str = NULL;
+            }
         }
-
-        /* freed by context_free(ctx) */
-        char *new_ctx_str = context_str(ctx);
         if (new_ctx_str == NULL) {
-            die("cannot obtain updated SELinux context string");
+            if (context_type_set(ctx, "unconfined_service_t") != 0) {
+                die("cannot update SELinux context %s type to unconfined_service_t", ctx_str);
+            }
+            /* freed by context_free(ctx) */
+            new_ctx_str = context_str(ctx);
+            if (new_ctx_str == NULL) {
+                die("cannot obtain updated SELinux context string");
+            }
         }
         if (setexeccon(new_ctx_str) < 0) {
             die("cannot set SELinux exec context to %s", new_ctx_str);
@@ -420,7 +420,7 @@ int main(int argc, char **argv)
 	sc_maybe_aa_change_onexec(&apparmor, invocation.security_tag);
 #ifdef HAVE_SELINUX
 	// For classic and confined snaps
-	sc_selinux_set_snap_execcon();
+	sc_selinux_set_snap_execcon(invocation.security_tag);
 #endif
 	if (snap_context != NULL) {
 		setenv("SNAP_COOKIE", snap_context, 1);
@@ -574,7 +574,8 @@ static void enter_non_classic_execution_environment(sc_invocation * inv,
 
 	/** Populate and join the device control group. */
 	struct snappy_udev udev_s;
-	if (snappy_udev_init(inv->security_tag, &udev_s) == 0)
+	if (snappy_udev_init(inv->security_tag, &udev_s) == 0
+	    || snappy_udev_rules_tag_devices(inv->snap_instance, &udev_s))
 		setup_devices_cgroup(inv->security_tag, &udev_s);
 	snappy_udev_cleanup(&udev_s);
 
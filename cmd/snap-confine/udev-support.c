@@ -21,12 +21,14 @@
 #include <limits.h>
 #include <sys/sysmacros.h>
 #include <sched.h>
+#include <stdio.h>
 #include <string.h>
 #include <sys/stat.h>
 #include <sys/types.h>
 #include <sys/wait.h>
 #include <unistd.h>
 
+#include "../libsnap-confine-private/cleanup-funcs.h"
 #include "../libsnap-confine-private/snap.h"
 #include "../libsnap-confine-private/string-utils.h"
 #include "../libsnap-confine-private/utils.h"
@@ -156,6 +158,39 @@ int snappy_udev_init(const char *security_tag, struct snappy_udev *udev_s)
 	return rc;
 }
 
+/*
+ * snappy_udev_rules_tag_devices() - return true if the udev rules of the snap
+ * tag devices for the security tag set up by snappy_udev_init(), whether any
+ * of them are present or not. The device cgroup must then be set up so that
+ * devices plugged in later are only accessible once tagged.
+ */
+bool snappy_udev_rules_tag_devices(const char *snap_instance,
+				   struct snappy_udev *udev_s)
+{
+	char rules_path[PATH_MAX] = { 0 };
+	sc_must_snprintf(rules_path, sizeof(rules_path),
+			 "/etc/udev/rules.d/70-snap.%s.rules", snap_instance);
+	char tag[MAX_BUF + 16] = { 0 };
+	sc_must_snprintf(tag, sizeof(tag), "TAG+=\"%s\"", udev_s->tagname);
+
+	FILE *f SC_CLEANUP(sc_cleanup_file) = NULL;
+	f = fopen(rules_path, "r");
+	if (f == NULL) {
+		if (errno == ENOENT)
+			return false;
+		die("cannot open %s", rules_path);
+	}
+	char *line SC_CLEANUP(sc_cleanup_string) = NULL;
+	size_t line_size = 0;
+	while (getline(&line, &line_size, f) != -1) {
+		if (strstr(line, tag) != NULL)
+			return true;
+	}
+	if (ferror(f))
+		die("cannot read %s", rules_path);
+	return false;
+}
+
 void snappy_udev_cleanup(struct snappy_udev *udev_s)
 {
 	// udev_s->assigned does not need to be unreferenced since it is a
@@ -188,8 +223,6 @@ void setup_devices_cgroup(const char *security_tag, struct snappy_udev *udev_s)
 		die("snappy_udev->udev is NULL");
 	if (udev_s->devices == NULL)
 		die("snappy_udev->devices is NULL");
-	if (udev_s->assigned == NULL)
-		die("snappy_udev->assigned is NULL");
 	if (udev_s->tagname_len == 0
 	    || udev_s->tagname_len >= MAX_BUF
 	    || strnlen(udev_s->tagname, MAX_BUF) != udev_s->tagname_len
@@ -18,6 +18,7 @@
 #ifndef SNAP_CONFINE_UDEV_SUPPORT_H
 #define SNAP_CONFINE_UDEV_SUPPORT_H
 
+#include <stdbool.h>
 #include <stddef.h>
 
 #include <libudev.h>
@@ -34,6 +35,8 @@ struct snappy_udev {
 
 void run_snappy_app_dev_add(struct snappy_udev *udev_s, const char *path);
 int snappy_udev_init(const char *security_tag, struct snappy_udev *udev_s);
+bool snappy_udev_rules_tag_devices(const char *snap_instance,
+				   struct snappy_udev *udev_s);
 void snappy_udev_cleanup(struct snappy_udev *udev_s);
 void setup_devices_cgroup(const char *security_tag, struct snappy_udev *udev_s);
 
@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+var (
+	Run       = run
+	ParseArgs = parseArgs
+)
+
+func MockOsGetuid(f func() int) (restore func()) {
+	old := osGetuid
+	osGetuid = f
+	return func() { osGetuid = old }
+}
+
+func MockSyscallMount(f func(source, target, fstype string, flags uintptr, data string) error) (restore func()) {
+	old := syscallMount
+	syscallMount = f
+	return func() { syscallMount = old }
+}
+
+func MockSyscallUnmount(f func(target string, flags int) error) (restore func()) {
+	old := syscallUnmount
+	syscallUnmount = f
+	return func() { syscallUnmount = old }
+}
+
+func MockMountInfoPath(path string) (restore func()) {
+	old := mountInfoPath
+	mountInfoPath = path
+	return func() { mountInfoPath = old }
+}
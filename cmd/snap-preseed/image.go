@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// imageMount is an image file attached to a loop device, with its root
+// filesystem mounted at a temporary directory.
+type imageMount struct {
+	loopDevice string
+	dir        string
+}
+
+// mountImage attaches the image to a loop device and mounts its root
+// filesystem, which is the given partition of a partitioned image or
+// the whole image otherwise. Nothing is left behind on error.
+func mountImage(image string, partition int) (_ *imageMount, err error) {
+	output, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "losetup",
+		Args: []string{"--find", "--show", "--partscan", image},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot attach %q to a loop device: %v", image, err)
+	}
+	im := &imageMount{loopDevice: strings.TrimSpace(string(output))}
+	defer func() {
+		if err != nil {
+			if uerr := im.unmount(); uerr != nil {
+				logger.Noticef("%v", uerr)
+			}
+		}
+	}()
+
+	device := im.loopDevice
+	if partition > 0 {
+		device = fmt.Sprintf("%sp%d", im.loopDevice, partition)
+		if !osutil.FileExists(device) {
+			return nil, fmt.Errorf("cannot mount %q: it has no partition %d", image, partition)
+		}
+	} else {
+		partitions, err := filepath.Glob(im.loopDevice + "p*")
+		if err != nil {
+			return nil, err
+		}
+		if len(partitions) > 0 {
+			return nil, fmt.Errorf("cannot mount %q: it is partitioned, use --partition to select its root filesystem", image)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "snap-preseed-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "mount",
+		Args: []string{device, dir},
+	}); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("cannot mount %q: %v", image, err)
+	}
+	im.dir = dir
+	return im, nil
+}
+
+// unmount unmounts the root filesystem of the image, if it was mounted,
+// and detaches the image from its loop device.
+func (im *imageMount) unmount() error {
+	if im.dir != "" {
+		if err := syscallUnmount(im.dir, 0); err != nil {
+			return fmt.Errorf("cannot unmount %s: %v", im.dir, err)
+		}
+		if err := os.Remove(im.dir); err != nil {
+			return err
+		}
+		im.dir = ""
+	}
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "losetup",
+		Args: []string{"--detach", im.loopDevice},
+	}); err != nil {
+		return fmt.Errorf("cannot detach %s: %v", im.loopDevice, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	// TODO: consider not using go-flags at all
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+var (
+	Stdout io.Writer = os.Stdout
+	Stderr io.Writer = os.Stderr
+
+	osGetuid = os.Getuid
+)
+
+type options struct {
+	Reset                bool   `long:"reset" description:"Reset the preseeded image and exit"`
+	AppArmorFeaturesFile string `long:"apparmor-features-file" description:"AppArmor features of the kernel of the image, to compile the profiles for"`
+	Partition            int    `long:"partition" description:"Number of the partition holding the root filesystem of a partitioned image file"`
+
+	Positional struct {
+		Image string `positional-arg-name:"<image>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+const (
+	shortHelp = "Preseed an image with snaps"
+	longHelp  = `
+snap-preseed seeds the snaps of an image, given either the directory
+containing its root filesystem or an image file, by running snapd in a
+chroot of the root filesystem up to the point where the running system is
+needed. The root filesystem must include the seed (/var/lib/snapd/seed).
+An image file is attached to a loop device and its root filesystem mounted
+for the time of preseeding; for a partitioned image the partition holding
+it is given with --partition.
+
+Preseeding includes compiling the security profiles, for the kernel of the
+image if its apparmor features are given. The rest of the seeding happens
+on first boot.
+`
+)
+
+func init() {
+	err := logger.SimpleSetup()
+	if err != nil {
+		fmt.Fprintf(Stderr, "WARNING: failed to activate logging: %v\n", err)
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parseArgs(args []string) (*options, error) {
+	var opts options
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.ShortDescription = shortHelp
+	parser.LongDescription = longHelp
+
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+func run(args []string) (err error) {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	if osGetuid() != 0 {
+		return fmt.Errorf("must be run as root")
+	}
+
+	image, err := filepath.Abs(opts.Positional.Image)
+	if err != nil {
+		return err
+	}
+	if !osutil.FileExists(image) {
+		return fmt.Errorf("cannot preseed: %q does not exist", image)
+	}
+
+	chrootDir := image
+	if !osutil.IsDirectory(image) {
+		var im *imageMount
+		im, err = mountImage(image, opts.Partition)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := im.unmount(); uerr != nil {
+				if err == nil {
+					err = uerr
+				} else {
+					logger.Noticef("%v", uerr)
+				}
+			}
+		}()
+		chrootDir = im.dir
+	} else if opts.Partition != 0 {
+		return fmt.Errorf("cannot preseed: --partition is only supported for image files")
+	}
+
+	dirs.SetRootDir(chrootDir)
+	defer dirs.SetRootDir("/")
+
+	if opts.Reset {
+		return resetPreseededChroot(chrootDir)
+	}
+
+	if err := checkChroot(chrootDir); err != nil {
+		return err
+	}
+	return preseed(chrootDir, opts.AppArmorFeaturesFile)
+}
@@ -0,0 +1,448 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	preseed "github.com/snapcore/snapd/cmd/snap-preseed"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type preseedSuite struct {
+	testutil.BaseTest
+
+	chrootDir string
+	mountInfo string
+
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+}
+
+var _ = Suite(&preseedSuite{})
+
+func (s *preseedSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	s.stdout = bytes.NewBuffer(nil)
+	s.stderr = bytes.NewBuffer(nil)
+	oldStdout, oldStderr := preseed.Stdout, preseed.Stderr
+	preseed.Stdout, preseed.Stderr = s.stdout, s.stderr
+	s.AddCleanup(func() { preseed.Stdout, preseed.Stderr = oldStdout, oldStderr })
+
+	s.AddCleanup(preseed.MockOsGetuid(func() int { return 0 }))
+
+	s.chrootDir = c.MkDir()
+	s.mountInfo = filepath.Join(c.MkDir(), "mountinfo")
+	c.Assert(ioutil.WriteFile(s.mountInfo, nil, 0644), IsNil)
+	s.AddCleanup(preseed.MockMountInfoPath(s.mountInfo))
+
+	s.AddCleanup(func() { dirs.SetRootDir("/") })
+}
+
+func mountInfoEntry(dir string) string {
+	return fmt.Sprintf("100 1 0:50 / %s rw - tmpfs tmpfs rw\n", dir)
+}
+
+func (s *preseedSuite) mockMounted(c *C, mountDirs ...string) {
+	var buf bytes.Buffer
+	for _, dir := range mountDirs {
+		buf.WriteString(mountInfoEntry(dir))
+	}
+	c.Assert(ioutil.WriteFile(s.mountInfo, buf.Bytes(), 0644), IsNil)
+}
+
+// mockMount mocks the mount syscall, recording the mounts in the mocked
+// mountinfo.
+func (s *preseedSuite) mockMount(c *C) (mounted *[]string) {
+	mounted = &[]string{}
+	s.AddCleanup(preseed.MockSyscallMount(func(source, target, fstype string, flags uintptr, data string) error {
+		*mounted = append(*mounted, fmt.Sprintf("%s %s %s %d", source, target, fstype, flags))
+		f, err := os.OpenFile(s.mountInfo, os.O_APPEND|os.O_WRONLY, 0644)
+		c.Assert(err, IsNil)
+		defer f.Close()
+		_, err = f.WriteString(mountInfoEntry(target))
+		return err
+	}))
+	return mounted
+}
+
+func (s *preseedSuite) makeSnapd(c *C) {
+	snapd := filepath.Join(s.chrootDir, "usr/lib/snapd/snapd")
+	c.Assert(os.MkdirAll(filepath.Dir(snapd), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(snapd, nil, 0755), IsNil)
+}
+
+func (s *preseedSuite) TestMissingChrootDir(c *C) {
+	err := preseed.Run([]string{})
+	c.Check(err, ErrorMatches, "the required argument `<image>` was not provided")
+}
+
+func (s *preseedSuite) TestNotRoot(c *C) {
+	s.AddCleanup(preseed.MockOsGetuid(func() int { return 1000 }))
+
+	err := preseed.Run([]string{s.chrootDir})
+	c.Check(err, ErrorMatches, "must be run as root")
+}
+
+func (s *preseedSuite) TestNoSnapdInChroot(c *C) {
+	err := preseed.Run([]string{s.chrootDir})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot preseed: /usr/lib/snapd/snapd not found in %q`, s.chrootDir))
+}
+
+func (s *preseedSuite) TestChrootInUse(c *C) {
+	s.makeSnapd(c)
+	s.mockMounted(c, s.chrootDir, filepath.Join(s.chrootDir, "proc"))
+
+	err := preseed.Run([]string{s.chrootDir})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot preseed: %q is in use, %s/proc is mounted`, s.chrootDir, s.chrootDir))
+}
+
+func (s *preseedSuite) TestPreseed(c *C) {
+	s.makeSnapd(c)
+	systemKey := filepath.Join(s.chrootDir, "var/lib/snapd/system-key")
+	c.Assert(os.MkdirAll(filepath.Dir(systemKey), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(systemKey, nil, 0644), IsNil)
+
+	features := filepath.Join(c.MkDir(), "features")
+	c.Assert(ioutil.WriteFile(features, []byte("features"), 0644), IsNil)
+
+	// the image itself is mounted, which is not in the way
+	s.mockMounted(c, s.chrootDir)
+	mounted := s.mockMount(c)
+	var unmounted []string
+	s.AddCleanup(preseed.MockSyscallUnmount(func(target string, flags int) error {
+		unmounted = append(unmounted, target)
+		return nil
+	}))
+
+	// snapd leaves the snaps mounted
+	envFile := filepath.Join(c.MkDir(), "env")
+	chroot := testutil.MockCommand(c, "chroot", fmt.Sprintf(`
+echo "$SNAPD_PRESEED $SNAPD_APPARMOR_FEATURES_FILE" > %[1]s
+cat "$1/$SNAPD_APPARMOR_FEATURES_FILE" >> %[1]s
+echo "100 1 0:50 / $1/snap/core/1 rw - squashfs /dev/loop0 ro" >> %[2]s
+`, envFile, s.mountInfo))
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{"--apparmor-features-file", features, s.chrootDir})
+	c.Assert(err, IsNil)
+
+	c.Check(chroot.Calls(), DeepEquals, [][]string{
+		{"chroot", s.chrootDir, "/usr/lib/snapd/snapd"},
+	})
+	c.Check(envFile, testutil.FileEquals, "1 /var/lib/snapd/preseed-apparmor-features\nfeatures")
+	c.Check(*mounted, DeepEquals, []string{
+		fmt.Sprintf("proc %s/proc proc 0", s.chrootDir),
+		fmt.Sprintf("sysfs %s/sys sysfs 0", s.chrootDir),
+		fmt.Sprintf("/dev %s/dev  20480", s.chrootDir),
+		fmt.Sprintf("securityfs %s/sys/kernel/security securityfs 0", s.chrootDir),
+	})
+	c.Check(unmounted, DeepEquals, []string{
+		filepath.Join(s.chrootDir, "snap/core/1"),
+		filepath.Join(s.chrootDir, "sys/kernel/security"),
+		filepath.Join(s.chrootDir, "dev"),
+		filepath.Join(s.chrootDir, "sys"),
+		filepath.Join(s.chrootDir, "proc"),
+	})
+	c.Check(systemKey, testutil.FileAbsent)
+	c.Check(filepath.Join(s.chrootDir, "var/lib/snapd/preseed-apparmor-features"), testutil.FileAbsent)
+}
+
+func (s *preseedSuite) TestPreseedSnapdFails(c *C) {
+	s.makeSnapd(c)
+
+	s.mockMount(c)
+	var unmounted []string
+	s.AddCleanup(preseed.MockSyscallUnmount(func(target string, flags int) error {
+		unmounted = append(unmounted, target)
+		return nil
+	}))
+	chroot := testutil.MockCommand(c, "chroot", "exit 1")
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{s.chrootDir})
+	c.Check(err, ErrorMatches, "cannot preseed: snapd failed: exit status 1")
+	// mounts are cleaned up regardless
+	c.Check(unmounted, HasLen, 4)
+}
+
+func (s *preseedSuite) TestReset(c *C) {
+	dirs.SetRootDir(s.chrootDir)
+	for _, path := range []string{
+		dirs.SnapStateFile,
+		dirs.SnapSystemKeyFile,
+		filepath.Join(dirs.SnapBlobDir, "core_1.snap"),
+		filepath.Join(dirs.SnapSeqDir, "core.json"),
+		filepath.Join(dirs.SnapAppArmorDir, "snap.foo.bar"),
+		filepath.Join(dirs.SnapSeccompDir, "snap.foo.bar.bin"),
+		filepath.Join(dirs.SnapServicesDir, "snap-core-1.mount"),
+		filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", "snap-core-1.mount"),
+		filepath.Join(dirs.SnapMountDir, "core", "1", "meta", "snap.yaml"),
+		filepath.Join(dirs.SnapDataDir, "core", "1", "data"),
+		filepath.Join(dirs.SnapAssertsDBDir, "asserts-v0", "some"),
+	} {
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+	}
+	// not created by preseeding
+	seed := filepath.Join(dirs.SnapSeedDir, "seed.yaml")
+	otherUnit := filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", "other.service")
+	for _, path := range []string{seed, otherUnit} {
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+	}
+
+	err := preseed.Run([]string{"--reset", s.chrootDir})
+	c.Assert(err, IsNil)
+
+	c.Check(dirs.SnapStateFile, testutil.FileAbsent)
+	c.Check(dirs.SnapSystemKeyFile, testutil.FileAbsent)
+	c.Check(dirs.SnapAssertsDBDir, testutil.FileAbsent)
+	for _, glob := range []string{
+		filepath.Join(dirs.SnapBlobDir, "*"),
+		filepath.Join(dirs.SnapSeqDir, "*"),
+		filepath.Join(dirs.SnapAppArmorDir, "*"),
+		filepath.Join(dirs.SnapSeccompDir, "*"),
+		filepath.Join(dirs.SnapServicesDir, "snap-*"),
+		filepath.Join(dirs.SnapServicesDir, "*", "snap-*"),
+		filepath.Join(dirs.SnapMountDir, "*"),
+		filepath.Join(dirs.SnapDataDir, "*"),
+	} {
+		matches, err := filepath.Glob(glob)
+		c.Assert(err, IsNil)
+		c.Check(matches, HasLen, 0, Commentf(glob))
+	}
+	c.Check(seed, testutil.FilePresent)
+	c.Check(otherUnit, testutil.FilePresent)
+}
+
+func (s *preseedSuite) TestResetChrootInUse(c *C) {
+	s.mockMounted(c, filepath.Join(s.chrootDir, "snap/core/1"))
+
+	err := preseed.Run([]string{"--reset", s.chrootDir})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot reset: %q is in use, %s/snap/core/1 is mounted`, s.chrootDir, s.chrootDir))
+}
+
+// mockImage mocks an image file whose root filesystem has snapd, with
+// the losetup and mount helpers, and the partitions of the loop device
+// it is attached to. It returns the image, the directory its root
+// filesystem gets mounted at and the mocked helpers.
+func (s *preseedSuite) mockImage(c *C, partitions ...string) (image, tmpDir string, losetup *testutil.MockCmd) {
+	image = filepath.Join(c.MkDir(), "image.img")
+	c.Assert(ioutil.WriteFile(image, nil, 0644), IsNil)
+
+	tmpDir = c.MkDir()
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmpDir)
+	s.AddCleanup(func() { os.Setenv("TMPDIR", oldTmpDir) })
+
+	loopDevice := filepath.Join(c.MkDir(), "loop7")
+	c.Assert(ioutil.WriteFile(loopDevice, nil, 0644), IsNil)
+	for _, part := range partitions {
+		c.Assert(ioutil.WriteFile(loopDevice+part, nil, 0644), IsNil)
+	}
+	losetup = testutil.MockCommand(c, "losetup", fmt.Sprintf(`
+if [ "$1" = --find ]; then
+    echo %s
+fi
+`, loopDevice))
+	s.AddCleanup(losetup.Restore)
+	return image, tmpDir, losetup
+}
+
+// mockImageUnmount mocks the unmount syscall, emptying the directory
+// the root filesystem of the image was mounted at.
+func (s *preseedSuite) mockImageUnmount(c *C, tmpDir string) (unmounted *[]string) {
+	unmounted = &[]string{}
+	s.AddCleanup(preseed.MockSyscallUnmount(func(target string, flags int) error {
+		*unmounted = append(*unmounted, target)
+		if filepath.Dir(target) == tmpDir {
+			c.Assert(os.RemoveAll(target), IsNil)
+			c.Assert(os.Mkdir(target, 0755), IsNil)
+		}
+		return nil
+	}))
+	return unmounted
+}
+
+func (s *preseedSuite) TestPreseedImage(c *C) {
+	image, tmpDir, losetup := s.mockImage(c)
+	mount := testutil.MockCommand(c, "mount", `
+mkdir -p "$2/usr/lib/snapd"
+touch "$2/usr/lib/snapd/snapd"
+`)
+	defer mount.Restore()
+	s.mockMount(c)
+	unmounted := s.mockImageUnmount(c, tmpDir)
+	chroot := testutil.MockCommand(c, "chroot", "")
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Assert(err, IsNil)
+
+	c.Assert(mount.Calls(), HasLen, 1)
+	imageDir := mount.Calls()[0][2]
+	c.Check(strings.HasPrefix(imageDir, filepath.Join(tmpDir, "snap-preseed-")), Equals, true)
+	// the loop device itself is mounted
+	c.Check(losetup.Calls(), DeepEquals, [][]string{
+		{"losetup", "--find", "--show", "--partscan", image},
+		{"losetup", "--detach", mount.Calls()[0][1]},
+	})
+	c.Check(chroot.Calls(), DeepEquals, [][]string{
+		{"chroot", imageDir, "/usr/lib/snapd/snapd"},
+	})
+	// the mounts for snapd are unmounted first, the image last
+	c.Assert(*unmounted, HasLen, 5)
+	c.Check((*unmounted)[4], Equals, imageDir)
+	c.Check(imageDir, testutil.FileAbsent)
+}
+
+func (s *preseedSuite) TestPreseedImagePartitioned(c *C) {
+	image, tmpDir, losetup := s.mockImage(c, "p1", "p2")
+	mount := testutil.MockCommand(c, "mount", `
+mkdir -p "$2/usr/lib/snapd"
+touch "$2/usr/lib/snapd/snapd"
+`)
+	defer mount.Restore()
+	s.mockMount(c)
+	s.mockImageUnmount(c, tmpDir)
+	chroot := testutil.MockCommand(c, "chroot", "")
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot mount %q: it is partitioned, use --partition to select its root filesystem`, image))
+	c.Check(mount.Calls(), HasLen, 0)
+	c.Assert(losetup.Calls(), HasLen, 2)
+	c.Check(losetup.Calls()[1][1], Equals, "--detach")
+
+	losetup.ForgetCalls()
+	err = preseed.Run([]string{"--partition", "3", image})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot mount %q: it has no partition 3`, image))
+	c.Check(mount.Calls(), HasLen, 0)
+	c.Assert(losetup.Calls(), HasLen, 2)
+	c.Check(losetup.Calls()[1][1], Equals, "--detach")
+
+	losetup.ForgetCalls()
+	err = preseed.Run([]string{"--partition", "2", image})
+	c.Assert(err, IsNil)
+	c.Assert(mount.Calls(), HasLen, 1)
+	loopDevice := losetup.Calls()[1][2]
+	c.Check(mount.Calls()[0][1], Equals, loopDevice+"p2")
+	c.Check(chroot.Calls(), HasLen, 1)
+}
+
+func (s *preseedSuite) TestPreseedPartitionOfDirectory(c *C) {
+	s.makeSnapd(c)
+
+	err := preseed.Run([]string{"--partition", "2", s.chrootDir})
+	c.Check(err, ErrorMatches, "cannot preseed: --partition is only supported for image files")
+}
+
+func (s *preseedSuite) TestPreseedImageAttachFails(c *C) {
+	image, _, losetup := s.mockImage(c)
+	losetup.Restore()
+	losetup = testutil.MockCommand(c, "losetup", "exit 1")
+	defer losetup.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot attach %q to a loop device: exit status 1`, image))
+}
+
+func (s *preseedSuite) TestPreseedImageMountFails(c *C) {
+	image, tmpDir, losetup := s.mockImage(c)
+	mount := testutil.MockCommand(c, "mount", "exit 1")
+	defer mount.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot mount %q: exit status 1`, image))
+	// the image is detached and the mount point removed
+	c.Assert(losetup.Calls(), HasLen, 2)
+	c.Check(losetup.Calls()[1][1], Equals, "--detach")
+	dirs, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(dirs, HasLen, 0)
+}
+
+func (s *preseedSuite) TestPreseedImageSnapdFails(c *C) {
+	image, tmpDir, losetup := s.mockImage(c)
+	mount := testutil.MockCommand(c, "mount", `
+mkdir -p "$2/usr/lib/snapd"
+touch "$2/usr/lib/snapd/snapd"
+`)
+	defer mount.Restore()
+	s.mockMount(c)
+	unmounted := s.mockImageUnmount(c, tmpDir)
+	chroot := testutil.MockCommand(c, "chroot", "exit 1")
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Check(err, ErrorMatches, "cannot preseed: snapd failed: exit status 1")
+	// the image is unmounted and detached regardless
+	c.Assert(*unmounted, HasLen, 5)
+	c.Check((*unmounted)[4], Equals, mount.Calls()[0][2])
+	c.Assert(losetup.Calls(), HasLen, 2)
+	c.Check(losetup.Calls()[1][1], Equals, "--detach")
+	dirs, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Check(dirs, HasLen, 0)
+}
+
+func (s *preseedSuite) TestPreseedImageUnmountFails(c *C) {
+	image, tmpDir, losetup := s.mockImage(c)
+	mount := testutil.MockCommand(c, "mount", `
+mkdir -p "$2/usr/lib/snapd"
+touch "$2/usr/lib/snapd/snapd"
+`)
+	defer mount.Restore()
+	s.mockMount(c)
+	s.AddCleanup(preseed.MockSyscallUnmount(func(target string, flags int) error {
+		if filepath.Dir(target) == tmpDir {
+			return fmt.Errorf("busy")
+		}
+		return nil
+	}))
+	chroot := testutil.MockCommand(c, "chroot", "")
+	defer chroot.Restore()
+
+	err := preseed.Run([]string{image})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot unmount %s: busy`, mount.Calls()[0][2]))
+	// the image stays attached as it is still mounted
+	c.Check(losetup.Calls(), HasLen, 1)
+}
+
+func (s *preseedSuite) TestPreseedMissingImage(c *C) {
+	missing := filepath.Join(c.MkDir(), "missing.img")
+
+	err := preseed.Run([]string{missing})
+	c.Check(err, ErrorMatches, fmt.Sprintf(`cannot preseed: %q does not exist`, missing))
+}
@@ -0,0 +1,221 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+var (
+	syscallMount   = syscall.Mount
+	syscallUnmount = syscall.Unmount
+
+	mountInfoPath = "/proc/self/mountinfo"
+
+	// preseedTimeout is how long snapd may take to preseed the
+	// image
+	preseedTimeout = 30 * time.Minute
+)
+
+const (
+	// snapdPath is the path of snapd inside the chroot
+	snapdPath = "/usr/lib/snapd/snapd"
+
+	// apparmorFeaturesPath is where the apparmor features of the
+	// kernel of the image are made available to snapd inside the
+	// chroot while preseeding
+	apparmorFeaturesPath = "/var/lib/snapd/preseed-apparmor-features"
+)
+
+// chrootMount is a filesystem snapd needs inside the chroot.
+type chrootMount struct {
+	source string
+	target string
+	fstype string
+	flags  uintptr
+}
+
+var chrootMountsToSetup = []chrootMount{
+	{"proc", "/proc", "proc", 0},
+	{"sysfs", "/sys", "sysfs", 0},
+	{"/dev", "/dev", "", syscall.MS_BIND | syscall.MS_REC},
+	{"securityfs", "/sys/kernel/security", "securityfs", 0},
+}
+
+// mountsUnder returns the mount points under the given directory, in
+// the order they were mounted.
+func mountsUnder(dir string) ([]string, error) {
+	entries, err := osutil.LoadMountInfo(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.MountDir, dir+"/") {
+			mounts = append(mounts, entry.MountDir)
+		}
+	}
+	return mounts, nil
+}
+
+func checkChroot(chrootDir string) error {
+	if !osutil.IsDirectory(chrootDir) {
+		return fmt.Errorf("cannot preseed: %q is not a directory", chrootDir)
+	}
+	if !osutil.FileExists(filepath.Join(chrootDir, snapdPath)) {
+		return fmt.Errorf("cannot preseed: %s not found in %q", snapdPath, chrootDir)
+	}
+	mounts, err := mountsUnder(chrootDir)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("cannot preseed: %q is in use, %s is mounted", chrootDir, mounts[0])
+	}
+	return nil
+}
+
+// unmountAll unmounts everything under the chroot, in reverse order, that
+// is both what was set up for snapd and the snaps it mounted.
+func unmountAll(chrootDir string) error {
+	mounts, err := mountsUnder(chrootDir)
+	if err != nil {
+		return err
+	}
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := syscallUnmount(mounts[i], 0); err != nil {
+			return fmt.Errorf("cannot unmount %s: %v", mounts[i], err)
+		}
+	}
+	return nil
+}
+
+func preseed(chrootDir, apparmorFeaturesFile string) (err error) {
+	defer func() {
+		if uerr := unmountAll(chrootDir); uerr != nil {
+			if err == nil {
+				err = uerr
+			} else {
+				logger.Noticef("%v", uerr)
+			}
+		}
+	}()
+
+	for _, m := range chrootMountsToSetup {
+		target := filepath.Join(chrootDir, m.target)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := syscallMount(m.source, target, m.fstype, m.flags, ""); err != nil {
+			return fmt.Errorf("cannot mount %s at %s: %v", m.source, target, err)
+		}
+	}
+
+	env := []string{"SNAPD_PRESEED=1"}
+	if apparmorFeaturesFile != "" {
+		dst := filepath.Join(chrootDir, apparmorFeaturesPath)
+		if err := osutil.CopyFile(apparmorFeaturesFile, dst, osutil.CopyFlagOverwrite); err != nil {
+			return fmt.Errorf("cannot copy apparmor features: %v", err)
+		}
+		defer os.Remove(dst)
+		env = append(env, "SNAPD_APPARMOR_FEATURES_FILE="+apparmorFeaturesPath)
+	}
+
+	output, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:    "chroot",
+		Args:    []string{chrootDir, snapdPath},
+		Env:     env,
+		Timeout: preseedTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot preseed: snapd failed: %v", err)
+	}
+	Stdout.Write(output)
+
+	// the system key describes the system snapd ran on while
+	// preseeding, it is regenerated on first boot
+	if err := os.Remove(dirs.SnapSystemKeyFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// preseedArtifacts returns the globs of the files and directories
+// created by preseeding.
+func preseedArtifacts() []string {
+	return []string{
+		dirs.SnapStateFile,
+		dirs.SnapSystemKeyFile,
+		dirs.SnapAssertsDBDir,
+		dirs.SnapDeviceDir,
+		filepath.Join(dirs.SnapBlobDir, "*.snap"),
+		filepath.Join(dirs.SnapSeqDir, "*.json"),
+		filepath.Join(dirs.SnapCookieDir, "snap.*"),
+		filepath.Join(dirs.SnapMountPolicyDir, "*.fstab"),
+		filepath.Join(dirs.SnapAppArmorDir, "*"),
+		filepath.Join(dirs.AppArmorCacheDir, "snap*"),
+		filepath.Join(dirs.AppArmorCacheDir, "*", "snap*"),
+		filepath.Join(dirs.SnapSeccompDir, "*"),
+		filepath.Join(dirs.SnapUdevRulesDir, "*-snap.*.rules"),
+		filepath.Join(dirs.SnapKModModulesDir, "snap.*.conf"),
+		filepath.Join(dirs.SnapBusPolicyDir, "snap.*.conf"),
+		filepath.Join(dirs.SnapDesktopFilesDir, "*.desktop"),
+		filepath.Join(dirs.SnapServicesDir, "snap-*.mount"),
+		filepath.Join(dirs.SnapServicesDir, "snap.*"),
+		filepath.Join(dirs.SnapServicesDir, "*.wants", "snap-*.mount"),
+		filepath.Join(dirs.SnapServicesDir, "*.wants", "snap.*"),
+		filepath.Join(dirs.SnapMountDir, "*"),
+		filepath.Join(dirs.SnapDataDir, "*"),
+	}
+}
+
+// resetPreseededChroot removes the artifacts of preseeding from the
+// chroot, so that it can be preseeded again.
+func resetPreseededChroot(chrootDir string) error {
+	mounts, err := mountsUnder(chrootDir)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("cannot reset: %q is in use, %s is mounted", chrootDir, mounts[0])
+	}
+
+	for _, glob := range preseedArtifacts() {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("cannot reset: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -286,6 +286,9 @@ func NewRunner() *Runner {
 		TLSConfig: &tls.Config{
 			Time: run.now,
 		},
+		// follow the system-wide proxy settings maintained by snapd,
+		// including changes made after startup
+		Proxy: httputil.NewSystemProxy(filepath.Join(dirs.GlobalRootDir, "/etc/environment"), httputil.NetplanDirs(dirs.GlobalRootDir)...).Proxy,
 	}
 	run.cli = httputil.NewHTTPClient(&opts)
 	return run
@@ -416,6 +419,10 @@ func checkStream(brandID string, repairID int, r []asserts.Assertion) (repair *a
 		return nil, nil, fmt.Errorf("repair id mismatch %s/%d != %s/%d", repair.BrandID(), repair.RepairID(), brandID, repairID)
 	}
 
+	if !repair.SupportedFormat() {
+		return nil, nil, fmt.Errorf("repair %s/%d has unsupported format %d", brandID, repairID, repair.Format())
+	}
+
 	return repair, r[1:], nil
 }
 
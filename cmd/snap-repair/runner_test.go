@@ -270,6 +270,30 @@ func (s *runnerSuite) TestFetchJustRepair(c *C) {
 	s.checkBrokenTimeNowMitigated(c, runner)
 }
 
+func (s *runnerSuite) TestFetchUsesSystemProxy(c *C) {
+	proxied := 0
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		c.Check(r.URL.String(), Equals, "http://repair.example.com/repairs/canonical/2")
+		io.WriteString(w, testRepair)
+	}))
+	c.Assert(mockProxy, NotNil)
+	defer mockProxy.Close()
+
+	runner := repair.NewRunner()
+	runner.BaseURL = mustParseURL("http://repair.example.com/")
+
+	// the proxy is set after the runner is created
+	etcEnvironment := filepath.Join(dirs.GlobalRootDir, "/etc/environment")
+	c.Assert(os.MkdirAll(filepath.Dir(etcEnvironment), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(etcEnvironment, []byte("http_proxy="+mockProxy.URL+"\n"), 0644), IsNil)
+
+	repair, _, err := runner.Fetch("canonical", 2, -1)
+	c.Assert(err, IsNil)
+	c.Check(repair.RepairID(), Equals, 2)
+	c.Check(proxied, Equals, 1)
+}
+
 func (s *runnerSuite) TestFetchScriptTooBig(c *C) {
 	restore := repair.MockMaxRepairScriptSize(4)
 	defer restore()
@@ -467,6 +491,21 @@ func (s *runnerSuite) TestFetchWrongFirstType(c *C) {
 	c.Assert(err, ErrorMatches, `cannot fetch repair, unexpected first assertion "account-key"`)
 }
 
+func (s *runnerSuite) TestFetchUnsupportedFormat(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Replace(testRepair, "type: repair\n", "type: repair\nformat: 2\n", 1))
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	runner := repair.NewRunner()
+	runner.BaseURL = mustParseURL(mockServer.URL)
+
+	_, _, err := runner.Fetch("canonical", 2, -1)
+	c.Assert(err, ErrorMatches, `cannot fetch repair, repair canonical/2 has unsupported format 2`)
+}
+
 func (s *runnerSuite) TestFetchRepairPlusKey(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c.Check(r.Header.Get("Accept"), Equals, "application/x.ubuntu.assertion")
@@ -292,14 +292,26 @@ func (c *Change) lowLevelPerform(as *Assumptions) error {
 			// Split the mount flags from the event propagation changes.
 			// Those have to be applied separately.
 			const propagationMask = syscall.MS_SHARED | syscall.MS_SLAVE | syscall.MS_PRIVATE | syscall.MS_UNBINDABLE
-			maskedFlagsRecursive := flags & syscall.MS_REC
 			maskedFlagsPropagation := flags & propagationMask
 			maskedFlagsNotPropagationNotRecursive := flags & ^(propagationMask | syscall.MS_REC)
+			// MS_REC is used both by recursive bind mounts and by
+			// recursive propagation changes, look at the options to
+			// tell them apart so that e.g. "bind,ro,rslave" stays a
+			// non-recursive (read-only) bind mount.
+			var bindRecursive, propagationRecursive int
+			for _, opt := range c.Entry.Options {
+				switch opt {
+				case "rbind":
+					bindRecursive = syscall.MS_REC
+				case "rprivate", "rslave", "rshared", "runbindable":
+					propagationRecursive = syscall.MS_REC
+				}
+			}
 
 			var flagsForMount uintptr
 			if flags&syscall.MS_BIND == syscall.MS_BIND {
 				// bind / rbind mount
-				flagsForMount = uintptr(maskedFlagsNotPropagationNotRecursive | maskedFlagsRecursive)
+				flagsForMount = uintptr(maskedFlagsNotPropagationNotRecursive | bindRecursive)
 				err = BindMount(c.Entry.Name, c.Entry.Dir, uint(flagsForMount))
 			} else {
 				// normal mount, not bind / rbind, not propagation change
@@ -310,7 +322,7 @@ func (c *Change) lowLevelPerform(as *Assumptions) error {
 			if err == nil && maskedFlagsPropagation != 0 {
 				// now change mount propagation (shared/rshared, private/rprivate,
 				// slave/rslave, unbindable/runbindable).
-				flagsForMount := uintptr(maskedFlagsPropagation | maskedFlagsRecursive)
+				flagsForMount := uintptr(maskedFlagsPropagation | propagationRecursive)
 				err = sysMount("none", c.Entry.Dir, "", flagsForMount, "")
 				logger.Debugf("mount %q %q %q %d %q (error: %v)", "none", c.Entry.Dir, "", flagsForMount, "", err)
 			}
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -32,6 +33,7 @@ import (
 
 type cmdAliases struct {
 	clientMixin
+	Export      bool `long:"export"`
 	Positionals struct {
 		Snap installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"true"`
@@ -45,6 +47,12 @@ $ snap aliases <snap>
 
 Lists only the aliases defined by the specified snap.
 
+$ snap aliases --export
+
+Prints the manual aliases and the snaps with disabled aliases as JSON
+alias preferences, that can be applied in a single change through the
+REST API.
+
 An alias noted as undefined means it was explicitly enabled or disabled but is
 not defined in the current revision of the snap, possibly temporarily (e.g.
 because of a revert). This can cleared with 'snap alias --reset'.
@@ -53,7 +61,9 @@ because of a revert). This can cleared with 'snap alias --reset'.
 func init() {
 	addCommand("aliases", shortAliasesHelp, longAliasesHelp, func() flags.Commander {
 		return &cmdAliases{}
-	}, nil, nil)
+	}, map[string]string{
+		"export": i18n.G("Print the alias preferences as JSON"),
+	}, nil)
 }
 
 type aliasInfo struct {
@@ -102,6 +112,9 @@ func (x *cmdAliases) Execute(args []string) error {
 			filterSnap: allStatuses[filterSnap],
 		}
 	}
+	if x.Export {
+		return exportAliasPreferences(allStatuses)
+	}
 	for snapName, aliasStatuses := range allStatuses {
 		for alias, aliasStatus := range aliasStatuses {
 			infos = append(infos, &aliasInfo{
@@ -145,3 +158,75 @@ func (x *cmdAliases) Execute(args []string) error {
 	}
 	return nil
 }
+
+// exportAliasPreferences prints the alias preferences that reproduce
+// the given alias statuses: the snaps with disabled aliases have all of
+// them disabled first, then the snaps whose automatic aliases took over
+// the disabled aliases of other snaps are preferred, then the manual
+// aliases are set up.
+func exportAliasPreferences(allStatuses map[string]map[string]client.AliasStatus) error {
+	snapNames := make([]string, 0, len(allStatuses))
+	for snapName := range allStatuses {
+		snapNames = append(snapNames, snapName)
+	}
+	sort.Strings(snapNames)
+
+	// alias -> snaps that have it disabled
+	disabledBy := make(map[string][]string)
+	for _, snapName := range snapNames {
+		for alias, aliasStatus := range allStatuses[snapName] {
+			if aliasStatus.Status == "disabled" {
+				disabledBy[alias] = append(disabledBy[alias], snapName)
+			}
+		}
+	}
+
+	prefs := []client.AliasPreference{}
+	var preferred, manual []client.AliasPreference
+	for _, snapName := range snapNames {
+		aliases := make([]string, 0, len(allStatuses[snapName]))
+		for alias := range allStatuses[snapName] {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+
+		disabled := false
+		prefer := false
+		for _, alias := range aliases {
+			aliasStatus := allStatuses[snapName][alias]
+			switch aliasStatus.Status {
+			case "disabled":
+				disabled = true
+			case "auto":
+				if len(disabledBy[alias]) != 0 {
+					prefer = true
+				}
+			case "manual":
+				manual = append(manual, client.AliasPreference{
+					Action: "alias",
+					Snap:   snapName,
+					App:    aliasStatus.Manual,
+					Alias:  alias,
+				})
+			}
+		}
+		if disabled {
+			prefs = append(prefs, client.AliasPreference{
+				Action: "unalias",
+				Snap:   snapName,
+			})
+		}
+		if prefer {
+			preferred = append(preferred, client.AliasPreference{
+				Action: "prefer",
+				Snap:   snapName,
+			})
+		}
+	}
+	prefs = append(prefs, preferred...)
+	prefs = append(prefs, manual...)
+
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(prefs)
+}
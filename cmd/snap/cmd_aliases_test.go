@@ -31,7 +31,7 @@ import (
 
 func (s *SnapSuite) TestAliasesHelp(c *C) {
 	msg := `Usage:
-  snap.test aliases [<snap>]
+  snap.test aliases [aliases-OPTIONS] [<snap>]
 
 The aliases command lists all aliases available in the system and their status.
 
@@ -39,9 +39,18 @@ $ snap aliases <snap>
 
 Lists only the aliases defined by the specified snap.
 
+$ snap aliases --export
+
+Prints the manual aliases and the snaps with disabled aliases as JSON
+alias preferences, that can be applied in a single change through the
+REST API.
+
 An alias noted as undefined means it was explicitly enabled or disabled but is
 not defined in the current revision of the snap, possibly temporarily (e.g.
 because of a revert). This can cleared with 'snap alias --reset'.
+
+[aliases command options]
+      --export    Print the alias preferences as JSON
 `
 	s.testSubCommandHelp(c, "aliases", msg)
 }
@@ -177,3 +186,61 @@ func (s *SnapSuite) TestAliasesSorting(c *C) {
 	}
 
 }
+
+func (s *SnapSuite) TestAliasesExport(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/aliases")
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type": "sync",
+			"result": map[string]map[string]client.AliasStatus{
+				"foo": {
+					"foo0":      {Command: "foo", Status: "auto", Auto: "foo"},
+					"foo_reset": {Command: "foo.reset", Manual: "reset", Status: "manual"},
+				},
+				"bar": {
+					"bar_dump":    {Command: "bar.dump", Status: "manual", Manual: "dump"},
+					"bar_dump.1":  {Command: "bar.dump", Status: "disabled", Auto: "dump"},
+					"bar_restore": {Command: "bar.safe-restore", Status: "manual", Auto: "restore", Manual: "safe-restore"},
+				},
+				"baz": {
+					"bar_dump.1": {Command: "baz", Status: "auto", Auto: "baz"},
+				},
+			},
+		})
+	})
+	rest, err := Parser(Client()).ParseArgs([]string{"aliases", "--export"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	expectedStdout := `[
+  {
+    "action": "unalias",
+    "snap": "bar"
+  },
+  {
+    "action": "prefer",
+    "snap": "baz"
+  },
+  {
+    "action": "alias",
+    "snap": "bar",
+    "app": "dump",
+    "alias": "bar_dump"
+  },
+  {
+    "action": "alias",
+    "snap": "bar",
+    "app": "safe-restore",
+    "alias": "bar_restore"
+  },
+  {
+    "action": "alias",
+    "snap": "foo",
+    "app": "reset",
+    "alias": "foo_reset"
+  }
+]
+`
+	c.Assert(s.Stdout(), Equals, expectedStdout)
+	c.Assert(s.Stderr(), Equals, "")
+}
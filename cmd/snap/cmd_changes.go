@@ -34,15 +34,23 @@ var shortChangesHelp = i18n.G("List system changes")
 var shortTasksHelp = i18n.G("List a change's tasks")
 var longChangesHelp = i18n.G(`
 The changes command displays a summary of system changes performed recently.
+
+With the global --format=json or --format=yaml option, the changes are printed
+as a list in the given format, with the fields used by the snapd REST API.
 `)
 var longTasksHelp = i18n.G(`
 The tasks command displays a summary of tasks associated with an individual
 change.
+
+With the global --format=json or --format=yaml option, the change and its
+tasks are printed in the given format, with the fields used by the snapd
+REST API.
 `)
 
 type cmdChanges struct {
 	clientMixin
 	timeMixin
+	formatMixin
 	Positional struct {
 		Snap string `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -51,6 +59,7 @@ type cmdChanges struct {
 type cmdTasks struct {
 	timeMixin
 	changeIDMixin
+	formatMixin
 }
 
 func init() {
@@ -106,12 +115,19 @@ func (c *cmdChanges) Execute(args []string) error {
 		return err
 	}
 
+	sort.Sort(changesByTime(changes))
+
+	if changes == nil {
+		changes = []*client.Change{}
+	}
+	if ok, err := printFormatted(changes); ok {
+		return err
+	}
+
 	if len(changes) == 0 {
 		return fmt.Errorf(i18n.G("no changes found"))
 	}
 
-	sort.Sort(changesByTime(changes))
-
 	w := tabWriter()
 
 	fmt.Fprintf(w, i18n.G("ID\tStatus\tSpawn\tReady\tSummary\n"))
@@ -159,6 +175,10 @@ func (c *cmdTasks) showChange(chid string) error {
 		return err
 	}
 
+	if ok, err := printFormatted(chg); ok {
+		return err
+	}
+
 	w := tabWriter()
 
 	fmt.Fprintf(w, i18n.G("Status\tSpawn\tReady\tSummary\n"))
@@ -20,6 +20,7 @@
 package main_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -131,6 +132,59 @@ var mockChangesJSON = `{"type": "sync", "result": [
   }
 ]}`
 
+func (s *SnapSuite) TestChangesAndTasksFormat(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		switch r.URL.Path {
+		case "/v2/changes":
+			fmt.Fprintln(w, mockChangesJSON)
+		case "/v2/changes/42":
+			fmt.Fprintln(w, mockChangeJSON)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"changes", "--format=json"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	var changes []map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &changes), check.IsNil)
+	c.Assert(changes, check.HasLen, 4)
+	// sorted by spawn time
+	for i, id := range []string{"four", "three", "one", "two"} {
+		c.Check(changes[i]["id"], check.Equals, id)
+	}
+	c.Check(changes[0]["kind"], check.Equals, "install-snap")
+	c.Check(changes[0]["spawn-time"], check.Equals, "2015-02-21T01:02:03Z")
+
+	s.ResetStdStreams()
+	rest, err = snap.Parser(snap.Client()).ParseArgs([]string{"tasks", "--format=yaml", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	// keys are in the order of the fields of the API structs
+	c.Check(s.Stdout(), check.Equals, `id: uno
+kind: foo
+summary: '...'
+status: Do
+tasks:
+- id: ""
+  kind: bar
+  summary: some summary
+  status: Do
+  progress:
+    label: ""
+    done: 0
+    total: 1
+  spawn-time: "2016-04-21T01:02:03Z"
+  ready-time: "2016-04-21T01:02:04Z"
+ready: false
+spawn-time: "2016-04-21T01:02:03Z"
+ready-time: "2016-04-21T01:02:04Z"
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *SnapSuite) TestTasksLast(c *check.C) {
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
 		c.Check(r.Method, check.Equals, "GET")
@@ -20,6 +20,9 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/snapcore/snapd/i18n"
 
 	"github.com/jessevdk/go-flags"
@@ -27,6 +30,7 @@ import (
 
 type cmdConnect struct {
 	waitMixin
+	Duration    time.Duration `long:"duration"`
 	Positionals struct {
 		PlugSpec connectPlugSpec `required:"yes"`
 		SlotSpec connectSlotSpec
@@ -52,12 +56,18 @@ $ snap connect <snap>:<plug>
 
 Connects the provided plug to the slot in the core snap with a name matching
 the plug name.
+
+With --duration the connection is temporary, and it is disconnected again
+once the given duration (for example 1h or 30m) has passed.
 `)
 
 func init() {
 	addCommand("connect", shortConnectHelp, longConnectHelp, func() flags.Commander {
 		return &cmdConnect{}
-	}, waitDescs, []argDesc{
+	}, waitDescs.also(map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"duration": i18n.G("Disconnect again after the given duration"),
+	}), []argDesc{
 		// TRANSLATORS: This needs to begin with < and end with >
 		{name: i18n.G("<snap>:<plug>")},
 		// TRANSLATORS: This needs to begin with < and end with >
@@ -77,7 +87,16 @@ func (x *cmdConnect) Execute(args []string) error {
 		x.Positionals.PlugSpec.Snap = ""
 	}
 
-	id, err := x.client.Connect(x.Positionals.PlugSpec.Snap, x.Positionals.PlugSpec.Name, x.Positionals.SlotSpec.Snap, x.Positionals.SlotSpec.Name)
+	var id string
+	var err error
+	if x.Duration != 0 {
+		if x.Duration < 0 {
+			return fmt.Errorf(i18n.G("cannot connect for a negative duration"))
+		}
+		id, err = x.client.ConnectFor(x.Positionals.PlugSpec.Snap, x.Positionals.PlugSpec.Name, x.Positionals.SlotSpec.Snap, x.Positionals.SlotSpec.Name, x.Duration)
+	} else {
+		id, err = x.client.Connect(x.Positionals.PlugSpec.Snap, x.Positionals.PlugSpec.Name, x.Positionals.SlotSpec.Snap, x.Positionals.SlotSpec.Name)
+	}
 	if err != nil {
 		return err
 	}
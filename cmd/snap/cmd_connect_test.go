@@ -53,9 +53,13 @@ $ snap connect <snap>:<plug>
 Connects the provided plug to the slot in the core snap with a name matching
 the plug name.
 
+With --duration the connection is temporary, and it is disconnected again
+once the given duration (for example 1h or 30m) has passed.
+
 [connect command options]
       --no-wait          Do not wait for the operation to finish but just print
                          the change id.
+      --duration=        Disconnect again after the given duration
 `
 	s.testSubCommandHelp(c, "connect", msg)
 }
@@ -94,6 +98,44 @@ func (s *SnapSuite) TestConnectExplicitEverything(c *C) {
 	c.Assert(rest, DeepEquals, []string{})
 }
 
+func (s *SnapSuite) TestConnectWithDuration(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/interfaces":
+			c.Check(r.Method, Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), DeepEquals, map[string]interface{}{
+				"action": "connect",
+				"plugs": []interface{}{
+					map[string]interface{}{
+						"snap": "producer",
+						"plug": "plug",
+					},
+				},
+				"slots": []interface{}{
+					map[string]interface{}{
+						"snap": "consumer",
+						"slot": "slot",
+					},
+				},
+				"duration": "1h0m0s",
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		case "/v2/changes/zzz":
+			c.Check(r.Method, Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+	rest, err := Parser(Client()).ParseArgs([]string{"connect", "--duration=1h", "producer:plug", "consumer:slot"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	_, err = Parser(Client()).ParseArgs([]string{"connect", "--duration=-1h", "producer:plug", "consumer:slot"})
+	c.Assert(err, ErrorMatches, "cannot connect for a negative duration")
+}
+
 func (s *SnapSuite) TestConnectExplicitPlugImplicitSlot(c *C) {
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
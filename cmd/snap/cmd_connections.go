@@ -32,7 +32,9 @@ import (
 
 type cmdConnections struct {
 	clientMixin
-	All         bool `long:"all"`
+	formatMixin
+	All         bool   `long:"all"`
+	Profile     string `long:"profile"`
 	Positionals struct {
 		Snap installedSnapName
 	} `positional-args:"true"`
@@ -51,13 +53,24 @@ $ snap connections <snap>
 
 Lists connected and unconnected plugs and slots for the specified
 snap.
+
+$ snap connections --profile=<name>
+
+Lists the connections that are made and broken when the given
+connection profile is applied, and whether they are currently
+connected.
+
+With the global --format=json or --format=yaml option, the plugs, slots
+and connections are printed in the given format, with the fields used by
+the snapd REST API.
 `)
 
 func init() {
 	addCommand("connections", shortConnectionsHelp, longConnectionsHelp, func() flags.Commander {
 		return &cmdConnections{}
 	}, map[string]string{
-		"all": i18n.G("Show connected and unconnected plugs and slots"),
+		"all":     i18n.G("Show connected and unconnected plugs and slots"),
+		"profile": i18n.G("Show the connections of the given connection profile"),
 	}, []argDesc{{
 		// TRANSLATORS: This needs to be wrapped in <>s.
 		name: "<snap>",
@@ -84,6 +97,7 @@ type connection struct {
 	interfaceDeterminant string
 	manual               bool
 	gadget               bool
+	temporary            bool
 }
 
 func (cn connection) String() string {
@@ -94,6 +108,9 @@ func (cn connection) String() string {
 	if cn.gadget {
 		opts = append(opts, "gadget")
 	}
+	if cn.temporary {
+		opts = append(opts, "temporary")
+	}
 	if len(opts) == 0 {
 		return "-"
 	}
@@ -136,6 +153,16 @@ func (x *cmdConnections) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	if x.Profile != "" {
+		if x.All || x.Positionals.Snap != "" {
+			return fmt.Errorf(i18n.G("cannot use --profile with --all or a snap name"))
+		}
+		if optionsData.Format != "" {
+			return fmt.Errorf(i18n.G("cannot use --profile with --format"))
+		}
+		return x.showProfile()
+	}
+
 	opts := client.ConnectionOptions{
 		All: x.All,
 	}
@@ -158,6 +185,9 @@ func (x *cmdConnections) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	if ok, err := printFormatted(connections); ok {
+		return err
+	}
 	if len(connections.Plugs) == 0 && len(connections.Slots) == 0 {
 		return nil
 	}
@@ -169,6 +199,7 @@ func (x *cmdConnections) Execute(args []string) error {
 			slot:                 endpoint(conn.Slot.Snap, conn.Slot.Name),
 			manual:               conn.Manual,
 			gadget:               conn.Gadget,
+			temporary:            conn.Expiry != nil,
 			interfaceName:        conn.Interface,
 			interfaceDeterminant: interfaceDeterminant(&conn),
 		})
@@ -212,3 +243,53 @@ func (x *cmdConnections) Execute(args []string) error {
 	}
 	return nil
 }
+
+func (x *cmdConnections) showProfile() error {
+	profiles, err := x.client.ConnectionProfiles()
+	if err != nil {
+		return err
+	}
+	var profile *client.ConnectionProfile
+	for i := range profiles {
+		if profiles[i].Name == x.Profile {
+			profile = &profiles[i]
+			break
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf(i18n.G("no connection profile named %q"), x.Profile)
+	}
+
+	connections, err := x.client.Connections(&client.ConnectionOptions{All: true})
+	if err != nil {
+		return err
+	}
+	established := make(map[client.ConnRef]bool, len(connections.Established))
+	for _, conn := range connections.Established {
+		established[client.ConnRef{Plug: conn.Plug, Slot: conn.Slot}] = true
+	}
+
+	w := tabWriter()
+	fmt.Fprintln(w, i18n.G("Plug\tSlot\tProfile\tCurrent"))
+	for _, list := range []struct {
+		refs   []client.ConnRef
+		action string
+	}{
+		// TRANSLATORS: what the connection profile does to the connection
+		{profile.Connect, i18n.G("connect")},
+		// TRANSLATORS: what the connection profile does to the connection
+		{profile.Disconnect, i18n.G("disconnect")},
+	} {
+		for _, ref := range list.refs {
+			// TRANSLATORS: the current state of the connection
+			current := i18n.G("disconnected")
+			if established[ref] {
+				// TRANSLATORS: the current state of the connection
+				current = i18n.G("connected")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", endpoint(ref.Plug.Snap, ref.Plug.Name), endpoint(ref.Slot.Snap, ref.Slot.Name), list.action, current)
+		}
+	}
+	w.Flush()
+	return nil
+}
@@ -20,6 +20,7 @@
 package main_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -851,3 +852,95 @@ func (s *SnapSuite) TestConnectionsDefiningAttribute(c *C) {
 	c.Assert(s.Stdout(), Equals, expectedStdout)
 	c.Assert(s.Stderr(), Equals, "")
 }
+
+func (s *SnapSuite) TestConnectionsProfile(c *C) {
+	profiles := []client.ConnectionProfile{{
+		Name: "kiosk",
+		Connect: []client.ConnRef{{
+			Plug: client.PlugRef{Snap: "browser", Name: "camera"},
+			Slot: client.SlotRef{Snap: "core", Name: "camera"},
+		}},
+		Disconnect: []client.ConnRef{{
+			Plug: client.PlugRef{Snap: "browser", Name: "audio-record"},
+			Slot: client.SlotRef{Snap: "core", Name: "audio-record"},
+		}},
+	}}
+	conns := client.Connections{
+		Established: []client.Connection{{
+			Plug:      client.PlugRef{Snap: "browser", Name: "camera"},
+			Slot:      client.SlotRef{Snap: "core", Name: "camera"},
+			Interface: "camera",
+		}},
+	}
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		switch n {
+		case 0:
+			c.Check(r.URL.Path, Equals, "/v2/interfaces/profiles")
+			EncodeResponseBody(c, w, map[string]interface{}{
+				"type":   "sync",
+				"result": profiles,
+			})
+		case 1:
+			c.Check(r.URL.Path, Equals, "/v2/connections")
+			c.Check(r.URL.Query(), DeepEquals, url.Values{"select": []string{"all"}})
+			EncodeResponseBody(c, w, map[string]interface{}{
+				"type":   "sync",
+				"result": conns,
+			})
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d", n+1)
+		}
+		n++
+	})
+
+	rest, err := Parser(Client()).ParseArgs([]string{"connections", "--profile=kiosk"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	expectedStdout := "" +
+		"Plug                  Slot           Profile     Current\n" +
+		"browser:camera        :camera        connect     connected\n" +
+		"browser:audio-record  :audio-record  disconnect  disconnected\n"
+	c.Assert(s.Stdout(), Equals, expectedStdout)
+	c.Assert(s.Stderr(), Equals, "")
+
+	s.ResetStdStreams()
+	n = 0
+	_, err = Parser(Client()).ParseArgs([]string{"connections", "--profile=other"})
+	c.Assert(err, ErrorMatches, `no connection profile named "other"`)
+
+	_, err = Parser(Client()).ParseArgs([]string{"connections", "--profile=kiosk", "--all"})
+	c.Assert(err, ErrorMatches, `cannot use --profile with --all or a snap name`)
+}
+
+func (s *SnapSuite) TestConnectionsFormat(c *C) {
+	result := client.Connections{
+		Established: []client.Connection{
+			{
+				Slot:      client.SlotRef{Snap: "core", Name: "network"},
+				Plug:      client.PlugRef{Snap: "foo", Name: "network"},
+				Interface: "network",
+			},
+		},
+	}
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/connections")
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "sync",
+			"result": result,
+		})
+	})
+	_, err := Parser(Client()).ParseArgs([]string{"connections", "--format=json"})
+	c.Assert(err, IsNil)
+	var conns client.Connections
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &conns), IsNil)
+	c.Check(conns, DeepEquals, result)
+	c.Assert(s.Stderr(), Equals, "")
+
+	s.ResetStdStreams()
+
+	_, err = Parser(Client()).ParseArgs([]string{"connections", "--format=json", "--profile=foo"})
+	c.Check(err, ErrorMatches, "cannot use --profile with --format")
+}
@@ -0,0 +1,45 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/boot"
+)
+
+type cmdBootVars struct{}
+
+func init() {
+	cmd := addDebugCommand("boot-vars",
+		"(internal) obtain the snapd boot variables",
+		"(internal) obtain the snapd boot variables, including the A/B kernel slots if the bootloader has them",
+		func() flags.Commander {
+			return &cmdBootVars{}
+		}, nil, nil)
+	cmd.hidden = true
+}
+
+func (x *cmdBootVars) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	return boot.DumpBootVars(Stdout)
+}
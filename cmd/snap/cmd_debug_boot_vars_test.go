@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/boot/boottest"
+	"github.com/snapcore/snapd/bootloader"
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestBootVars(c *check.C) {
+	loader := boottest.NewMockBootloader("mock", c.MkDir())
+	loader.SetBootVars(map[string]string{
+		"snap_mode":       "try",
+		"snap_core":       "core_1.snap",
+		"snap_kernel":     "pc-kernel_1.snap",
+		"snap_try_kernel": "pc-kernel_2.snap",
+	})
+	bootloader.Force(loader)
+	defer bootloader.Force(nil)
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "boot-vars"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `snap_mode=try
+snap_core=core_1.snap
+snap_try_core=
+snap_kernel=pc-kernel_1.snap
+snap_try_kernel=pc-kernel_2.snap
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type cmdExportState struct {
+	Positionals struct {
+		StateFilePath string `positional-arg-name:"<state-file>"`
+	} `positional-args:"true"`
+}
+
+func init() {
+	cmd := addDebugCommand("export-state",
+		"(internal) print the state with its secrets redacted",
+		"(internal) print the state with its secrets redacted",
+		func() flags.Commander {
+			return &cmdExportState{}
+		}, nil, nil)
+	cmd.hidden = true
+}
+
+func (x *cmdExportState) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	path := x.Positionals.StateFilePath
+	if path == "" {
+		path = dirs.SnapStateFile
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	redacted, err := state.Redact(data)
+	if err != nil {
+		return fmt.Errorf("cannot redact state from %s: %v", path, err)
+	}
+	fmt.Fprintf(Stdout, "%s\n", redacted)
+	return nil
+}
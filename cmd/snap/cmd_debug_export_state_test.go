@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/dirs"
+)
+
+func (s *SnapSuite) TestDebugExportState(c *C) {
+	c.Assert(os.MkdirAll(filepath.Dir(dirs.SnapStateFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(dirs.SnapStateFile, []byte(`{"data":{"auth":{"users":[{"id":1,"macaroon":"secret"}]}},"last-task-id":0}`), 0600), IsNil)
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "export-state"})
+	c.Assert(err, IsNil)
+	c.Check(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `{"data":{"auth":{"users":[{"id":1,"macaroon":"<redacted>"}]}},"last-task-id":0}`+"\n")
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestDebugExportStateOtherFile(c *C) {
+	path := filepath.Join(c.MkDir(), "state.json")
+	c.Assert(ioutil.WriteFile(path, []byte(`not json`), 0600), IsNil)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"debug", "export-state", path})
+	c.Assert(err, ErrorMatches, `cannot redact state from .*/state.json: invalid character .*`)
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"debug", "export-state", path + ".missing"})
+	c.Assert(err, ErrorMatches, `open .*/state.json.missing: no such file or directory`)
+}
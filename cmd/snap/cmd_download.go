@@ -21,6 +21,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,16 +30,20 @@ import (
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/image"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
 )
 
 type cmdDownload struct {
+	clientMixin
 	channelMixin
 	Revision  string `long:"revision"`
 	Basename  string `long:"basename"`
 	TargetDir string `long:"target-directory"`
+	ViaSnapd  bool   `long:"via-snapd"`
 
 	CohortKey  string `long:"cohort"`
 	Positional struct {
@@ -50,6 +55,10 @@ var shortDownloadHelp = i18n.G("Download the given snap")
 var longDownloadHelp = i18n.G(`
 The download command downloads the given snap and its supporting assertions
 to the current directory with .snap and .assert file extensions, respectively.
+
+If the --via-snapd option is given, snapd downloads the snap and checks its
+assertions using the store session, proxies and cache of the device, without
+installing it.
 `)
 
 func init() {
@@ -64,6 +73,8 @@ func init() {
 		"basename": i18n.G("Use this basename for the snap and assertion files (defaults to <snap>_<revision>)"),
 		// TRANSLATORS: This should not start with a lowercase letter.
 		"target-directory": i18n.G("Download to this directory (defaults to the current directory)"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"via-snapd": i18n.G("Download through snapd, as configured for this device"),
 	}), []argDesc{{
 		name: "<snap>",
 		// TRANSLATORS: This should not start with a lowercase letter.
@@ -97,6 +108,98 @@ func fetchSnapAssertions(tsto *image.ToolingStore, snapPath string, snapInfo *sn
 	return assertPath, err
 }
 
+func (x *cmdDownload) downloadDirectly(snapName string, revision snap.Revision) (snapPath, assertPath string, err error) {
+	tsto, err := image.NewToolingStore()
+	if err != nil {
+		return "", "", err
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("Fetching snap %q\n"), snapName)
+	dlOpts := image.DownloadOptions{
+		TargetDir: x.TargetDir,
+		Basename:  x.Basename,
+		Channel:   x.Channel,
+		CohortKey: x.CohortKey,
+		Revision:  revision,
+	}
+	snapPath, snapInfo, err := tsto.DownloadSnap(snapName, dlOpts)
+	if err != nil {
+		return "", "", err
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("Fetching assertions for %q\n"), snapName)
+	assertPath, err = fetchSnapAssertions(tsto, snapPath, snapInfo)
+	if err != nil {
+		return "", "", err
+	}
+
+	return snapPath, assertPath, nil
+}
+
+// downloadViaSnapd has snapd download the snap and fetch its assertions,
+// checking that they match the downloaded file.
+func (x *cmdDownload) downloadViaSnapd(snapName string, revision snap.Revision) (snapPath, assertPath string, err error) {
+	fmt.Fprintf(Stdout, i18n.G("Fetching snap %q\n"), snapName)
+	opts := &client.DownloadOptions{
+		Channel:   x.Channel,
+		CohortKey: x.CohortKey,
+	}
+	if !revision.Unset() {
+		opts.Revision = revision.String()
+	}
+	stream, dlInfo, err := x.client.Download(snapName, opts)
+	if err != nil {
+		return "", "", err
+	}
+	defer stream.Close()
+
+	baseName := x.Basename
+	if baseName == "" {
+		baseName = snapName
+		if dlInfo.Revision != "" {
+			baseName += "_" + dlInfo.Revision
+		}
+	}
+	snapPath = filepath.Join(x.TargetDir, baseName+".snap")
+	if err := writeDownload(snapPath, stream); err != nil {
+		return "", "", fmt.Errorf(i18n.G("cannot download snap %q: %v"), snapName, err)
+	}
+	sha3_384, _, err := asserts.SnapFileSHA3_384(snapPath)
+	if err != nil {
+		return "", "", err
+	}
+	if dlInfo.Sha3_384 != "" && sha3_384 != dlInfo.Sha3_384 {
+		os.Remove(snapPath)
+		return "", "", fmt.Errorf(i18n.G("cannot download snap %q: digest mismatch"), snapName)
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("Fetching assertions for %q\n"), snapName)
+	assertStream, err := x.client.DownloadAssertions(snapName, sha3_384)
+	if err != nil {
+		return "", "", err
+	}
+	defer assertStream.Close()
+	assertPath = strings.TrimSuffix(snapPath, filepath.Ext(snapPath)) + ".assert"
+	if err := writeDownload(assertPath, assertStream); err != nil {
+		return "", "", fmt.Errorf(i18n.G("cannot create assertions file: %v"), err)
+	}
+
+	return snapPath, assertPath, nil
+}
+
+// writeDownload atomically writes the content of r to the given path.
+func writeDownload(path string, r io.Reader) error {
+	f, err := osutil.NewAtomicFile(path, 0644, 0, osutil.NoChown, osutil.NoChown)
+	if err != nil {
+		return err
+	}
+	defer f.Cancel()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
 func (x *cmdDownload) Execute(args []string) error {
 	if strings.ContainsRune(x.Basename, filepath.Separator) {
 		return fmt.Errorf(i18n.G("cannot specify a path in basename (use --target-dir for that)"))
@@ -128,26 +231,13 @@ func (x *cmdDownload) Execute(args []string) error {
 
 	snapName := string(x.Positional.Snap)
 
-	tsto, err := image.NewToolingStore()
-	if err != nil {
-		return err
-	}
-
-	fmt.Fprintf(Stdout, i18n.G("Fetching snap %q\n"), snapName)
-	dlOpts := image.DownloadOptions{
-		TargetDir: x.TargetDir,
-		Basename:  x.Basename,
-		Channel:   x.Channel,
-		CohortKey: x.CohortKey,
-		Revision:  revision,
-	}
-	snapPath, snapInfo, err := tsto.DownloadSnap(snapName, dlOpts)
-	if err != nil {
-		return err
+	var snapPath, assertPath string
+	var err error
+	if x.ViaSnapd {
+		snapPath, assertPath, err = x.downloadViaSnapd(snapName, revision)
+	} else {
+		snapPath, assertPath, err = x.downloadDirectly(snapName, revision)
 	}
-
-	fmt.Fprintf(Stdout, i18n.G("Fetching assertions for %q\n"), snapName)
-	assertPath, err := fetchSnapAssertions(tsto, snapPath, snapInfo)
 	if err != nil {
 		return err
 	}
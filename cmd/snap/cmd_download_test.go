@@ -20,9 +20,18 @@
 package main_test
 
 import (
+	"crypto"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/sha3"
 	"gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/asserts"
 	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/testutil"
 )
 
 // these only cover errors that happen before hitting the network,
@@ -59,3 +68,77 @@ func (s *SnapSuite) TestDownloadChannelAndRevision(c *check.C) {
 
 	c.Check(err, check.ErrorMatches, "cannot specify both channel and revision")
 }
+
+func (s *SnapSuite) TestDownloadViaSnapd(c *check.C) {
+	content := "SNAP"
+	// as sent by snapd, encoded like asserts.SnapFileSHA3_384 does
+	sum := sha3.Sum384([]byte(content))
+	digest, err := asserts.EncodeDigest(crypto.SHA3_384, sum[:])
+	c.Assert(err, check.IsNil)
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/download")
+		switch n {
+		case 0:
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":  "download",
+				"snaps":   []interface{}{"a-snap"},
+				"channel": "edge",
+			})
+			w.Header().Set("Snap-Revision", "7")
+			w.Header().Set("Snap-Sha3-384", digest)
+			w.WriteHeader(200)
+			fmt.Fprint(w, content)
+		case 1:
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":        "fetch-assertions",
+				"snaps":         []interface{}{"a-snap"},
+				"snap-sha3-384": digest,
+			})
+			w.WriteHeader(200)
+			fmt.Fprint(w, "type: account\n")
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d", n+1)
+		}
+		n++
+	})
+
+	dir := c.MkDir()
+	oldWd, err := os.Getwd()
+	c.Assert(err, check.IsNil)
+	c.Assert(os.Chdir(dir), check.IsNil)
+	defer os.Chdir(oldWd)
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{
+		"download", "--via-snapd", "--edge", "a-snap",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(n, check.Equals, 2)
+	c.Check(s.Stdout(), check.Equals, `Fetching snap "a-snap"
+Fetching assertions for "a-snap"
+Install the snap with:
+   snap ack a-snap_7.assert
+   snap install a-snap_7.snap
+`)
+	c.Check(filepath.Join(dir, "a-snap_7.snap"), testutil.FileEquals, content)
+	c.Check(filepath.Join(dir, "a-snap_7.assert"), testutil.FileEquals, "type: account\n")
+}
+
+func (s *SnapSuite) TestDownloadViaSnapdDigestMismatch(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Snap-Revision", "7")
+		w.Header().Set("Snap-Sha3-384", "other-digest")
+		w.WriteHeader(200)
+		fmt.Fprint(w, "SNAP")
+	})
+
+	dir := c.MkDir()
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{
+		"download", "--via-snapd", "--target-directory", dir, "a-snap",
+	})
+	c.Assert(err, check.ErrorMatches, `cannot download snap "a-snap": digest mismatch`)
+	c.Check(filepath.Join(dir, "a-snap_7.snap"), testutil.FileAbsent)
+}
@@ -49,18 +49,28 @@ Nested values may be retrieved via a dotted path:
 
     $ snap get snap-name author.name
     frank
+
+When listing options, values that were not set explicitly but
+come from the gadget defaults are noted as such.
+
+With --export the full configuration of the given snaps, or of all
+the snaps with some configuration if none are given, is printed as
+a single document that 'snap set --from-file' can restore:
+
+    $ snap get --export snap-name other-snap > config.json
 `)
 
 type cmdGet struct {
 	clientMixin
 	Positional struct {
-		Snap installedSnapName `required:"yes"`
+		Snap installedSnapName
 		Keys []string
 	} `positional-args:"yes"`
 
 	Typed    bool `short:"t"`
 	Document bool `short:"d"`
 	List     bool `short:"l"`
+	Export   bool `long:"export"`
 }
 
 func init() {
@@ -72,6 +82,8 @@ func init() {
 			"l": i18n.G("Always return list, even with single key"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"t": i18n.G("Strict typing with nulls and quoted strings"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"export": i18n.G("Print the full configuration of the given snaps, or of all snaps"),
 		}, []argDesc{
 			{
 				name: "<snap>",
@@ -161,8 +173,9 @@ func (c *cmdGet) outputJson(conf interface{}) error {
 }
 
 // outputList will be used when the user requested list output via the
-// "-l" commandline switch.
-func (x *cmdGet) outputList(conf map[string]interface{}) error {
+// "-l" commandline switch. Values with a recorded origin get it shown
+// in an extra notes column.
+func (x *cmdGet) outputList(conf map[string]interface{}, origins map[string]string) error {
 	if rootRequested(x.Positional.Keys) && len(conf) == 0 {
 		return fmt.Errorf("snap %q has no configuration", x.Positional.Snap)
 	}
@@ -170,10 +183,23 @@ func (x *cmdGet) outputList(conf map[string]interface{}) error {
 	w := tabWriter()
 	defer w.Flush()
 
-	fmt.Fprintf(w, "Key\tValue\n")
 	values := flattenConfig(conf, rootRequested(x.Positional.Keys))
+	if len(origins) == 0 {
+		fmt.Fprintf(w, "Key\tValue\n")
+		for _, v := range values {
+			fmt.Fprintf(w, "%s\t%v\n", v.Path, v.Value)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "Key\tValue\tNotes\n")
 	for _, v := range values {
-		fmt.Fprintf(w, "%s\t%v\n", v.Path, v.Value)
+		notes := "-"
+		if origin := origins[v.Path]; origin != "" {
+			// TRANSLATORS: %s is where the value came from, e.g. "gadget"
+			notes = fmt.Sprintf(i18n.G("set by %s"), origin)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%s\n", v.Path, v.Value, notes)
 	}
 	return nil
 }
@@ -184,7 +210,7 @@ func (x *cmdGet) outputList(conf map[string]interface{}) error {
 // - multiple keys are printed as a list to the terminal (if there is one)
 //   or as json if there is no terminal
 // - the option "typed" is honored
-func (x *cmdGet) outputDefault(conf map[string]interface{}, snapName string, confKeys []string) error {
+func (x *cmdGet) outputDefault(conf map[string]interface{}, origins map[string]string, snapName string, confKeys []string) error {
 	if rootRequested(confKeys) && len(conf) == 0 {
 		return fmt.Errorf("snap %q has no configuration", snapName)
 	}
@@ -203,7 +229,7 @@ func (x *cmdGet) outputDefault(conf map[string]interface{}, snapName string, con
 	// conf looks like a map
 	if cfg, ok := confToPrint.(map[string]interface{}); ok {
 		if isStdinTTY {
-			return x.outputList(cfg)
+			return x.outputList(cfg, origins)
 		}
 
 		// TODO: remove this conditional and the warning below
@@ -240,10 +266,21 @@ func (x *cmdGet) Execute(args []string) error {
 		return fmt.Errorf("cannot use -d and -l together")
 	}
 
+	if x.Export {
+		if x.Document || x.List || x.Typed {
+			return fmt.Errorf("cannot use --export with -d, -l or -t")
+		}
+		return x.export()
+	}
+
+	if x.Positional.Snap == "" {
+		return fmt.Errorf("the required argument `<snap>` was not provided")
+	}
+
 	snapName := string(x.Positional.Snap)
 	confKeys := x.Positional.Keys
 
-	conf, err := x.client.Conf(snapName, confKeys)
+	conf, origins, err := x.client.ConfWithOrigins(snapName, confKeys)
 	if err != nil {
 		return err
 	}
@@ -252,8 +289,23 @@ func (x *cmdGet) Execute(args []string) error {
 	case x.Document:
 		return x.outputJson(conf)
 	case x.List:
-		return x.outputList(conf)
+		return x.outputList(conf, origins)
 	default:
-		return x.outputDefault(conf, snapName, confKeys)
+		return x.outputDefault(conf, origins, snapName, confKeys)
+	}
+}
+
+// export prints the full configuration documents of the requested
+// snaps, with the snaps given as positional arguments.
+func (x *cmdGet) export() error {
+	var snapNames []string
+	if x.Positional.Snap != "" {
+		snapNames = append([]string{string(x.Positional.Snap)}, x.Positional.Keys...)
+	}
+
+	docs, err := x.client.ExportConf(snapNames)
+	if err != nil {
+		return err
 	}
+	return x.outputJson(docs)
 }
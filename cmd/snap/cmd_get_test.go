@@ -99,6 +99,16 @@ var getTests = []getCmdArgs{{
 	args:       "get snapname  test-key1 test-key2",
 	stdout:     "{\n\t\"test-key1\": \"test-value1\",\n\t\"test-key2\": 2\n}\n",
 	stderr:     `WARNING: The output of 'snap get' will become a list with columns - use -d or -l to force the output format.\n`,
+}, {
+	args:   "get snapname -l gadget-key1 gadget-key2",
+	stdout: "Key          Value   Notes\ngadget-key1  value1  set by gadget\ngadget-key2  2       -\n",
+}, {
+	isTerminal: true,
+	args:       "get snapname gadget-doc",
+	stdout:     "Key              Value   Notes\ngadget-doc.key1  value1  set by gadget\ngadget-doc.key2  value2  -\n",
+}, {
+	args:   "get -d snapname gadget-key1 gadget-key2",
+	stdout: "{\n\t\"gadget-key1\": \"value1\",\n\t\"gadget-key2\": 2\n}\n",
 },
 }
 
@@ -204,6 +214,10 @@ func (s *SnapSuite) mockGetConfigServer(c *C) {
 			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {}}`)
 		case "document":
 			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {"document":{"key1":"value1","key2":"value2"}}}`)
+		case "gadget-key1,gadget-key2":
+			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {"gadget-key1":"value1","gadget-key2":2}, "origins": {"gadget-key1":"gadget"}}`)
+		case "gadget-doc":
+			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {"gadget-doc":{"key1":"value1","key2":"value2"}}, "origins": {"gadget-doc.key1":"gadget"}}`)
 		case "":
 			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {"foo":{"key1":"value1","key2":"value2"},"bar":100}}`)
 		default:
@@ -224,3 +238,33 @@ func (s *SnapSuite) mockGetEmptyConfigServer(c *C) {
 		fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {}}`)
 	})
 }
+
+var getExportTests = []getCmdArgs{{
+	args:   "get --export",
+	stdout: "{\n\t\"snapname\": {\n\t\t\"bar\": 100\n\t},\n\t\"system\": {\n\t\t\"foo\": true\n\t}\n}\n",
+}, {
+	args:   "get --export snapname system",
+	stdout: "{\n\t\"snapname\": {\n\t\t\"bar\": 100\n\t},\n\t\"system\": {\n\t\t\"foo\": true\n\t}\n}\n",
+}, {
+	args:  "get --export -d snapname",
+	error: `cannot use --export with -d, -l or -t`,
+}}
+
+func (s *SnapSuite) TestSnapGetExport(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/config")
+		switch snaps := r.URL.Query().Get("snaps"); snaps {
+		case "", "snapname,system":
+			fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": {"snapname":{"bar":100},"system":{"foo":true}}}`)
+		default:
+			c.Errorf("unexpected snaps %q", snaps)
+		}
+	})
+	s.runTests(getExportTests, c)
+}
+
+func (s *SnapSuite) TestSnapGetMissingSnap(c *C) {
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"get"})
+	c.Check(err, ErrorMatches, "the required argument `<snap>` was not provided")
+}
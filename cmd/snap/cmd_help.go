@@ -212,11 +212,11 @@ var helpCategories = []helpCategory{
 	}, {
 		Label:       i18n.G("Snapshots"),
 		Description: i18n.G("archives of snap data"),
-		Commands:    []string{"saved", "save", "check-snapshot", "restore", "forget"},
+		Commands:    []string{"saved", "save", "check-snapshot", "restore", "forget", "export-snapshot", "import-snapshot"},
 	}, {
 		Label:       i18n.G("Other"),
 		Description: i18n.G("miscellanea"),
-		Commands:    []string{"version", "warnings", "okay", "ack", "known", "create-cohort"},
+		Commands:    []string{"version", "warnings", "okay", "ack", "known", "model", "create-cohort"},
 	}, {
 		Label:       i18n.G("Development"),
 		Description: i18n.G("developer-oriented features"),
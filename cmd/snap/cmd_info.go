@@ -47,6 +47,7 @@ type infoCmd struct {
 	clientMixin
 	colorMixin
 	timeMixin
+	formatMixin
 
 	Verbose    bool `long:"verbose"`
 	Positional struct {
@@ -62,6 +63,11 @@ The snaps can be specified by name or by path; names are looked for both in the
 store and in the installed snaps; paths can refer to a .snap file, or to a
 directory that contains an unpacked snap suitable for 'snap try' (an example
 of this would be the 'prime' directory snapcraft produces).
+
+With the global --format=json or --format=yaml option, a list is printed in
+the given format with, for each snap, its "name" and its details as found
+"installed", in the "store", or in the "file" at the given "path", with the
+fields used by the snapd REST API.
 `)
 
 func init() {
@@ -662,7 +668,51 @@ func (chInfos *channelInfos) dump(w io.Writer) {
 	}
 }
 
+// infoOutput is what info prints about a snap when a structured format
+// is requested.
+type infoOutput struct {
+	Name      string       `json:"name"`
+	Path      string       `json:"path,omitempty"`
+	File      *client.Snap `json:"file,omitempty"`
+	Installed *client.Snap `json:"installed,omitempty"`
+	Store     *client.Snap `json:"store,omitempty"`
+}
+
+func (x *infoCmd) executeFormatted() error {
+	output := make([]*infoOutput, 0, len(x.Positional.Snaps))
+	for _, snapName := range x.Positional.Snaps {
+		snapName := norm(string(snapName))
+		out := &infoOutput{Name: snapName}
+		if diskSnap, err := clientSnapFromPath(snapName); err == nil {
+			out.Name = diskSnap.Name
+			out.Path = snapName
+			out.File = diskSnap
+		} else {
+			out.Store, _, _ = x.client.FindOne(snapName)
+			out.Installed, _, _ = x.client.Snap(snapName)
+		}
+		if out.File == nil && out.Store == nil && out.Installed == nil {
+			if len(x.Positional.Snaps) == 1 {
+				return fmt.Errorf("no snap found for %q", snapName)
+			}
+			fmt.Fprintf(Stderr, i18n.G("warning: no snap found for %q\n"), snapName)
+			continue
+		}
+		output = append(output, out)
+	}
+	if len(output) == 0 {
+		return fmt.Errorf(i18n.G("no valid snaps given"))
+	}
+
+	_, err := printFormatted(output)
+	return err
+}
+
 func (x *infoCmd) Execute([]string) error {
+	if optionsData.Format != "" {
+		return x.executeFormatted()
+	}
+
 	termWidth, _ := termSize()
 	termWidth -= 3
 	if termWidth > 100 {
@@ -21,6 +21,7 @@ package main_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -411,7 +412,7 @@ func (s *infoSuite) TestInfoPricedNarrowTerminal(c *check.C) {
 		case 0:
 			c.Check(r.Method, check.Equals, "GET")
 			c.Check(r.URL.Path, check.Equals, "/v2/find")
-			fmt.Fprintln(w, findPricedJSON)
+			fmt.Fprint(w, findPricedJSON)
 		case 1:
 			c.Check(r.Method, check.Equals, "GET")
 			c.Check(r.URL.Path, check.Equals, "/v2/snaps/hello")
@@ -441,6 +442,39 @@ snap-id: mVyGrEwiqSi5PugCwyH7WgpoQLemtTd6
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *infoSuite) TestInfoFormat(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/find")
+			fmt.Fprint(w, findPricedJSON)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/hello")
+			fmt.Fprintln(w, "{}")
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d (%v)", n+1, r)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"info", "--format=json", "hello"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	var output []map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &output), check.IsNil)
+	c.Assert(output, check.HasLen, 1)
+	c.Check(output[0]["name"], check.Equals, "hello")
+	c.Check(output[0]["installed"], check.IsNil)
+	store, ok := output[0]["store"].(map[string]interface{})
+	c.Assert(ok, check.Equals, true)
+	c.Check(store["name"], check.Equals, "hello")
+	c.Check(store["id"], check.Equals, "mVyGrEwiqSi5PugCwyH7WgpoQLemtTd6")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *infoSuite) TestInfoPriced(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
@@ -448,7 +482,7 @@ func (s *infoSuite) TestInfoPriced(c *check.C) {
 		case 0:
 			c.Check(r.Method, check.Equals, "GET")
 			c.Check(r.URL.Path, check.Equals, "/v2/find")
-			fmt.Fprintln(w, findPricedJSON)
+			fmt.Fprint(w, findPricedJSON)
 		case 1:
 			c.Check(r.Method, check.Equals, "GET")
 			c.Check(r.URL.Path, check.Equals, "/v2/snaps/hello")
@@ -869,7 +903,7 @@ func (s *infoSuite) TestInfoHumanTimes(c *check.C) {
 		case 1:
 			c.Check(r.Method, check.Equals, "GET")
 			c.Check(r.URL.Path, check.Equals, "/v2/snaps/hello")
-			fmt.Fprintln(w, mockInfoJSONNoLicense)
+			fmt.Fprint(w, mockInfoJSONNoLicense)
 		default:
 			c.Fatalf("expected to get 2 requests, now on %d (%v)", n+1, r)
 		}
@@ -39,10 +39,14 @@ The list command displays a summary of snaps installed in the current system.
 
 A green check mark (given color and unicode support) after a publisher name
 indicates that the publisher has been verified.
+
+With the global --format=json or --format=yaml option, the snaps are printed
+as a list in the given format, with the fields used by the snapd REST API.
 `)
 
 type cmdList struct {
 	clientMixin
+	formatMixin
 	Positional struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -113,6 +117,9 @@ func (x *cmdList) Execute(args []string) error {
 	if err != nil {
 		if err == client.ErrNoSnapsInstalled {
 			if len(names) == 0 {
+				if ok, err := printFormatted([]*client.Snap{}); ok {
+					return err
+				}
 				fmt.Fprintln(Stderr, i18n.G("No snaps are installed yet. Try 'snap install hello-world'."))
 				return nil
 			} else {
@@ -125,6 +132,10 @@ func (x *cmdList) Execute(args []string) error {
 	}
 	sort.Sort(snapsByName(snaps))
 
+	if ok, err := printFormatted(snaps); ok {
+		return err
+	}
+
 	esc := x.getEscapes()
 	w := tabWriter()
 
@@ -20,6 +20,7 @@
 package main_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -37,6 +38,9 @@ The list command displays a summary of snaps installed in the current system.
 A green check mark (given color and unicode support) after a publisher name
 indicates that the publisher has been verified.
 
+With the global --format=json or --format=yaml option, the snaps are printed
+as a list in the given format, with the fields used by the snapd REST API.
+
 [list command options]
       --all                           Show all revisions
       --color=[auto|never|always]     Use a little bit of color to highlight
@@ -82,6 +86,71 @@ foo   4.2      17   potatoes  bar        blocked
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *SnapSuite) TestListFormat(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+		fmt.Fprintln(w, `{"type": "sync", "result": [{"name": "foo", "status": "active", "version": "4.2", "revision": 17, "tracking-channel": "stable", "installed-size": 12345678}]}`)
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"list", "--format=json"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	var snaps []map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &snaps), check.IsNil)
+	c.Assert(snaps, check.HasLen, 1)
+	c.Check(snaps[0]["name"], check.Equals, "foo")
+	c.Check(snaps[0]["version"], check.Equals, "4.2")
+	c.Check(snaps[0]["revision"], check.Equals, "17")
+	c.Check(snaps[0]["tracking-channel"], check.Equals, "stable")
+	c.Check(s.Stderr(), check.Equals, "")
+
+	s.ResetStdStreams()
+	rest, err = snap.Parser(snap.Client()).ParseArgs([]string{"--format=yaml", "list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	// keys are in the order of the fields of client.Snap
+	c.Check(s.Stdout(), check.Equals, `- id: ""
+  summary: ""
+  description: ""
+  installed-size: 12345678
+  name: foo
+  developer: ""
+  status: active
+  type: ""
+  version: "4.2"
+  channel: ""
+  tracking-channel: stable
+  ignore-validation: false
+  revision: "17"
+  confinement: ""
+  private: false
+  devmode: false
+  jailmode: false
+  contact: ""
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestListFormatNoSnaps(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "sync", "result": []}`)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"list", "--format=json"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "[]\n")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestFormatUnsupported(c *check.C) {
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"version", "--format=json"})
+	c.Assert(err, check.ErrorMatches, "this command does not support --format")
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"list", "--format=xml"})
+	c.Assert(err, check.ErrorMatches, "Invalid value .xml. for option .--format.*")
+}
+
 func (s *SnapSuite) TestListAll(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
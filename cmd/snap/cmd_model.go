@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+var (
+	shortModelHelp = i18n.G("Show model assertion details of the device")
+	longModelHelp  = i18n.G(`
+The model command shows the brand, model and serial of the device.
+
+With --serial the details of the serial assertion are shown instead. With
+--verbose all the headers of the assertion are shown, with --json they are
+output in JSON format, and with --assertion the assertion itself is output.
+`)
+)
+
+type cmdModel struct {
+	clientMixin
+
+	Serial    bool `long:"serial"`
+	Verbose   bool `long:"verbose"`
+	JSON      bool `long:"json"`
+	Assertion bool `long:"assertion"`
+}
+
+func init() {
+	addCommand("model",
+		shortModelHelp,
+		longModelHelp,
+		func() flags.Commander {
+			return &cmdModel{}
+		}, map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"serial": i18n.G("Show the serial assertion instead of the model assertion"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"verbose": i18n.G("Show all the headers of the assertion"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"json": i18n.G("Output the headers of the assertion in JSON format"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"assertion": i18n.G("Output the assertion itself"),
+		}, nil)
+}
+
+// hiddenModelHeaders are not shown by 'snap model --verbose', they are
+// either implied or not meant for humans.
+var hiddenModelHeaders = map[string]bool{
+	"type":              true,
+	"body-length":       true,
+	"sign-key-sha3-384": true,
+	"device-key":        true,
+}
+
+func (x *cmdModel) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if x.Assertion && (x.Verbose || x.JSON) {
+		return fmt.Errorf(i18n.G("cannot use --assertion with --verbose or --json"))
+	}
+	if x.Verbose && x.JSON {
+		return fmt.Errorf(i18n.G("cannot use --verbose with --json"))
+	}
+
+	var a asserts.Assertion
+	// the serial is shown along the model, if there is one
+	serial, err := x.client.CurrentSerialAssertion()
+	if err != nil {
+		if e, ok := err.(*client.Error); !ok || e.StatusCode != 404 {
+			return err
+		}
+		if x.Serial {
+			return fmt.Errorf(i18n.G("device not registered yet (no serial assertion found)"))
+		}
+	}
+	if x.Serial {
+		a = serial
+	} else {
+		model, err := x.client.CurrentModelAssertion()
+		if err != nil {
+			return err
+		}
+		a = model
+	}
+
+	switch {
+	case x.Assertion:
+		return asserts.NewEncoder(Stdout).Encode(a)
+	case x.JSON:
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a.Headers())
+	}
+
+	serialStr := "-"
+	if serial != nil {
+		serialStr = serial.Serial()
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	if !x.Verbose {
+		fmt.Fprintf(w, "brand\t%s\n", a.HeaderString("brand-id"))
+		fmt.Fprintf(w, "model\t%s\n", a.HeaderString("model"))
+		fmt.Fprintf(w, "serial\t%s\n", serialStr)
+		return nil
+	}
+
+	headers := a.Headers()
+	fmt.Fprintf(w, "brand-id:\t%s\n", a.HeaderString("brand-id"))
+	fmt.Fprintf(w, "model:\t%s\n", a.HeaderString("model"))
+	fmt.Fprintf(w, "serial:\t%s\n", serialStr)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		switch name {
+		case "brand-id", "model", "serial":
+			continue
+		}
+		if !hiddenModelHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch v := headers[name].(type) {
+		case []interface{}:
+			fmt.Fprintf(w, "%s:\t\n", name)
+			for _, elem := range v {
+				fmt.Fprintf(w, "  - %v\n", elem)
+			}
+		default:
+			fmt.Fprintf(w, "%s:\t%v\n", name, v)
+		}
+	}
+	return nil
+}
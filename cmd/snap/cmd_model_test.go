@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+const mockSerialAssertion = `type: serial
+authority-id: canonical
+brand-id: canonical
+model: pi99
+serial: 9999
+device-key:
+    AcbBTQRWhcGAARAAtJGIguK7FhSyRxL/6jvdy0zAgGCjC1xVNFzeF76p5G8BXNEEHZUHK+z8Gr2J
+    inVrpvhJhllf5Ob2dIMH2YQbC9jE1kjbzvuauQGDqk6tNQm0i3KDeHCSPgVN+PFXPwKIiLrh66Po
+    AC7OfR1rFUgCqu0jch0H6Nue0ynvEPiY4dPeXq7mCdpDr5QIAM41L+3hg0OdzvO8HMIGZQpdF6jP
+    7fkkVMROYvHUOJ8kknpKE7FiaNNpH7jK1qNxOYhLeiioX0LYrdmTvdTWHrSKZc82ZmlDjpKc4hUx
+    VtTXMAysw7CzIdREPom/vJklnKLvZt+Wk5AEF5V5YKnuT3pY+fjVMZ56GtTEeO/Er/oLk/n2xUK5
+    fD5DAyW/9z0ygzwTbY5IuWXyDfYneL4nXwWOEgg37Z4+8mTH+ftTz2dl1x1KIlIR2xo0kxf9t8K+
+    jlr13vwF1+QReMCSUycUsZ2Eep5XhjI+LG7G1bMSGqodZTIOXLkIy6+3iJ8Z/feIHlJ0ELBDyFbl
+    Yy04Sf9LI148vJMsYenonkoWejWdMi8iCUTeaZydHJEUBU/RbNFLjCWa6NIUe9bfZgLiOOZkps54
+    +/AL078ri/tGjo/5UGvezSmwrEoWJyqrJt2M69N2oVDLJcHeo2bUYPtFC2Kfb2je58JrJ+llifdg
+    rAsxbnHXiXyVimUAEQEAAQ==
+device-key-sha3-384: EAD4DbLxK_kn0gzNCXOs3kd6DeMU3f-L6BEsSEuJGBqCORR0gXkdDxMbOm11mRFu
+timestamp: 2016-08-24T21:55:00Z
+sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
+
+AXNpZw==
+`
+
+func (s *SnapSuite) mockModelServer(c *check.C, withSerial bool) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		switch r.URL.Path {
+		case "/v2/model":
+			w.Header().Set("Content-Type", "application/x.ubuntu.assertion")
+			fmt.Fprint(w, mockModelAssertion)
+		case "/v2/model/serial":
+			if !withSerial {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(404)
+				fmt.Fprint(w, `{"type": "error", "status-code": 404, "result": {"message": "no serial assertion yet"}}`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x.ubuntu.assertion")
+			fmt.Fprint(w, mockSerialAssertion)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+}
+
+func (s *SnapSuite) TestModel(c *check.C) {
+	s.mockModelServer(c, true)
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"model"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `
+brand   canonical
+model   pi99
+serial  9999
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestModelNoSerial(c *check.C) {
+	s.mockModelServer(c, false)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"model"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, `
+brand   canonical
+model   pi99
+serial  -
+`[1:])
+
+	s.ResetStdStreams()
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"model", "--serial"})
+	c.Assert(err, check.ErrorMatches, `device not registered yet \(no serial assertion found\)`)
+}
+
+func (s *SnapSuite) TestModelVerbose(c *check.C) {
+	s.mockModelServer(c, true)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"model", "--verbose"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, `
+brand-id:      canonical
+model:         pi99
+serial:        9999
+architecture:  armhf
+authority-id:  canonical
+gadget:        pi99
+kernel:        pi99-kernel
+series:        16
+timestamp:     2016-08-31T00:00:00.0Z
+`[1:])
+}
+
+func (s *SnapSuite) TestModelSerialJSON(c *check.C) {
+	s.mockModelServer(c, true)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"model", "--serial", "--json"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, `(?s)\{
+  "authority-id": "canonical",
+  "brand-id": "canonical",
+  "device-key": ".*",
+  "device-key-sha3-384": "EAD4DbLxK_kn0gzNCXOs3kd6DeMU3f-L6BEsSEuJGBqCORR0gXkdDxMbOm11mRFu",
+  "model": "pi99",
+  "serial": "9999",
+  "sign-key-sha3-384": "Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij",
+  "timestamp": "2016-08-24T21:55:00Z",
+  "type": "serial"
+\}
+`)
+}
+
+func (s *SnapSuite) TestModelAssertion(c *check.C) {
+	s.mockModelServer(c, true)
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"model", "--assertion"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, mockModelAssertion)
+}
+
+func (s *SnapSuite) TestModelConflictingOptions(c *check.C) {
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"model", "--assertion", "--json"})
+	c.Assert(err, check.ErrorMatches, "cannot use --assertion with --verbose or --json")
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"model", "--verbose", "--json"})
+	c.Assert(err, check.ErrorMatches, "cannot use --verbose with --json")
+}
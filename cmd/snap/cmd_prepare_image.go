@@ -22,6 +22,7 @@ package main
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 
@@ -40,8 +41,13 @@ type cmdPrepareImage struct {
 
 	Channel string `long:"channel" default:"stable"`
 	// TODO: introduce SnapWithChannel?
-	Snaps      []string `long:"snap" value-name:"<snap>[=<channel>]"`
-	ExtraSnaps []string `long:"extra-snaps" hidden:"yes"` // DEPRECATED
+	Snaps         []string `long:"snap" value-name:"<snap>[=<channel>]"`
+	OnDemandSnaps []string `long:"on-demand-snap" value-name:"<snap>[=<channel>]"`
+	Assertions    []string `long:"assertion" value-name:"<file>"`
+	ExtraSnaps    []string `long:"extra-snaps" hidden:"yes"` // DEPRECATED
+
+	SourceDateEpoch int64  `long:"source-date-epoch" value-name:"<seconds>"`
+	ExpectDigest    string `long:"expect-digest" value-name:"<digest>"`
 }
 
 func init() {
@@ -64,9 +70,17 @@ For preparing classic images it supports a --classic mode`),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"snap": i18n.G("Include the given snap from the store or a local file and/or specify the channel to track for the given snap"),
 			// TRANSLATORS: This should not start with a lowercase letter.
+			"on-demand-snap": i18n.G("Include the given snap like --snap, but install it only on demand instead of on first boot"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"assertion": i18n.G("Include the assertions from the given file, e.g. validation sets, in the seed"),
+			// TRANSLATORS: This should not start with a lowercase letter.
 			"extra-snaps": i18n.G("Extra snaps to be installed (DEPRECATED)"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"channel": i18n.G("The channel to use"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"source-date-epoch": i18n.G("Clamp the timestamps of the prepared files to the given time since the epoch, for reproducible images, and report the image digest"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"expect-digest": i18n.G("Fail unless the prepared image has the given digest, as reported by an earlier build"),
 		}, []argDesc{
 			{
 				// TRANSLATORS: This needs to begin with < and end with >
@@ -90,9 +104,14 @@ func (x *cmdPrepareImage) Execute(args []string) error {
 		ModelFile:    x.Positional.ModelAssertionFn,
 		Channel:      x.Channel,
 		Architecture: x.Architecture,
+
+		ExpectedDigest: x.ExpectDigest,
+	}
+	if x.SourceDateEpoch != 0 {
+		opts.SourceDateEpoch = time.Unix(x.SourceDateEpoch, 0)
 	}
 
-	snaps := make([]string, 0, len(x.Snaps)+len(x.ExtraSnaps))
+	snaps := make([]string, 0, len(x.Snaps)+len(x.OnDemandSnaps)+len(x.ExtraSnaps))
 	snapChannels := make(map[string]string)
 	for _, snapWChannel := range x.Snaps {
 		snapAndChannel := strings.SplitN(snapWChannel, "=", 2)
@@ -101,6 +120,17 @@ func (x *cmdPrepareImage) Execute(args []string) error {
 			snapChannels[snapAndChannel[0]] = snapAndChannel[1]
 		}
 	}
+	for _, snapWChannel := range x.OnDemandSnaps {
+		snapAndChannel := strings.SplitN(snapWChannel, "=", 2)
+		snaps = append(snaps, snapAndChannel[0])
+		opts.OnDemandSnaps = append(opts.OnDemandSnaps, snapAndChannel[0])
+		if len(snapAndChannel) == 2 {
+			snapChannels[snapAndChannel[0]] = snapAndChannel[1]
+		}
+	}
+	if len(x.Assertions) != 0 {
+		opts.ExtraAssertions = x.Assertions
+	}
 
 	snaps = append(snaps, x.ExtraSnaps...)
 
@@ -20,6 +20,8 @@
 package main_test
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 
 	snap "github.com/snapcore/snapd/cmd/snap"
@@ -118,3 +120,51 @@ func (s *SnapPrepareImageSuite) TestPrepareImageExtraSnaps(c *C) {
 		SnapChannels:    map[string]string{"bar": "t/edge"},
 	})
 }
+
+func (s *SnapPrepareImageSuite) TestPrepareImageOnDemandSnapsAndAssertions(c *C) {
+	var opts *image.Options
+	prep := func(o *image.Options) error {
+		opts = o
+		return nil
+	}
+	r := snap.MockImagePrepare(prep)
+	defer r()
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "root-dir", "--snap", "foo", "--on-demand-snap", "bar=t/edge", "--on-demand-snap", "local.snap", "--assertion", "validation.assert"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	c.Check(opts, DeepEquals, &image.Options{
+		ModelFile:       "model",
+		Channel:         "stable",
+		RootDir:         "root-dir/image",
+		GadgetUnpackDir: "root-dir/gadget",
+		Snaps:           []string{"foo", "bar", "local.snap"},
+		SnapChannels:    map[string]string{"bar": "t/edge"},
+		OnDemandSnaps:   []string{"bar", "local.snap"},
+		ExtraAssertions: []string{"validation.assert"},
+	})
+}
+
+func (s *SnapPrepareImageSuite) TestPrepareImageReproducible(c *C) {
+	var opts *image.Options
+	prep := func(o *image.Options) error {
+		opts = o
+		return nil
+	}
+	r := snap.MockImagePrepare(prep)
+	defer r()
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"prepare-image", "model", "root-dir", "--source-date-epoch", "1546300800", "--expect-digest", "sha3-384-digest"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+
+	c.Check(opts, DeepEquals, &image.Options{
+		ModelFile:       "model",
+		Channel:         "stable",
+		RootDir:         "root-dir/image",
+		GadgetUnpackDir: "root-dir/gadget",
+		SourceDateEpoch: time.Unix(1546300800, 0),
+		ExpectedDigest:  "sha3-384-digest",
+	})
+}
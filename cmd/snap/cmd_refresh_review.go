@@ -0,0 +1,195 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// reviewHoldDuration is for how long automatic refreshes are held when
+// the user chooses to postpone some or all of the reviewed refreshes.
+// Note that snapd caps the hold to the maximum postponement.
+const reviewHoldDuration = 7 * 24 * time.Hour
+
+// refreshCandidates returns the snaps with pending refreshes, sorted by
+// name, along with the installed snaps they would refresh.
+func (x *cmdRefresh) refreshCandidates() (candidates []*client.Snap, installed map[string]*client.Snap, err error) {
+	candidates, _, err = x.client.Find(&client.FindOptions{
+		Refresh: true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Sort(snapsByName(candidates))
+
+	installed = make(map[string]*client.Snap, len(candidates))
+	if len(candidates) == 0 {
+		return candidates, installed, nil
+	}
+	names := make([]string, len(candidates))
+	for i, snap := range candidates {
+		names[i] = snap.Name
+	}
+	snaps, err := x.client.List(names, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, snap := range snaps {
+		installed[snap.Name] = snap
+	}
+	return candidates, installed, nil
+}
+
+// printReview prints a table of the pending refreshes, followed by the
+// summaries the store has for the new revisions.
+func (x *cmdRefresh) printReview(candidates []*client.Snap, installed map[string]*client.Snap) {
+	w := tabWriter()
+
+	fmt.Fprintln(w, i18n.G("Name\tCurrent\tNew\tRev\tChannel\tSize\tNotes"))
+	for _, snap := range candidates {
+		current, channel := "-", snap.Channel
+		if local := installed[snap.Name]; local != nil {
+			current = local.Version
+			if local.TrackingChannel != "" {
+				channel = local.TrackingChannel
+			}
+		}
+		if channel == "" {
+			channel = "-"
+		}
+		size := "-"
+		if snap.DownloadSize > 0 {
+			size = strutil.SizeToStr(snap.DownloadSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", snap.Name, current, snap.Version, snap.Revision, channel, size, NotesFromRemote(snap, nil))
+	}
+	w.Flush()
+
+	sep := "\n"
+	for _, snap := range candidates {
+		if snap.Summary == "" {
+			continue
+		}
+		fmt.Fprintf(Stdout, "%s%s %s: %s\n", sep, snap.Name, snap.Version, snap.Summary)
+		sep = ""
+	}
+}
+
+// ask prints the question and returns the lowercased first word of the
+// answer, or def if the answer was empty.
+func ask(reader *bufio.Reader, question, def string) (string, error) {
+	fmt.Fprint(Stdout, question)
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return def, nil
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// holdRefreshes holds automatic refreshes of the given snaps for
+// reviewHoldDuration, leaving those of the other snaps alone. snapd
+// keeps holds that are already longer.
+func (x *cmdRefresh) holdRefreshes(names []string) error {
+	for _, name := range names {
+		until, err := x.client.HoldRefreshes(name, timeNow().Add(reviewHoldDuration))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, i18n.G("Automatic refreshes of %s held until %s.\n"), name, x.fmtTime(until))
+	}
+	return nil
+}
+
+func (x *cmdRefresh) reviewRefresh() error {
+	candidates, installed, err := x.refreshCandidates()
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintln(Stderr, i18n.G("All snaps up to date."))
+		return nil
+	}
+
+	x.printReview(candidates, installed)
+
+	reader := bufio.NewReader(Stdin)
+	var selected, held []string
+	for {
+		answer, err := ask(reader, i18n.G("Refresh all of the above? [Y]es, [n]o, [s]elect, [h]old: "), "y")
+		if err != nil {
+			return err
+		}
+		switch answer {
+		case "y", "yes":
+			for _, snap := range candidates {
+				selected = append(selected, snap.Name)
+			}
+		case "n", "no":
+			fmt.Fprintln(Stdout, i18n.G("No snaps refreshed."))
+			return nil
+		case "h", "hold":
+			for _, snap := range candidates {
+				held = append(held, snap.Name)
+			}
+		case "s", "select":
+			for _, snap := range candidates {
+				answer, err := ask(reader, fmt.Sprintf(i18n.G("Refresh %s to %s? [Y/n]: "), snap.Name, snap.Version), "y")
+				if err != nil {
+					return err
+				}
+				if answer == "y" || answer == "yes" {
+					selected = append(selected, snap.Name)
+				} else {
+					held = append(held, snap.Name)
+				}
+			}
+		default:
+			fmt.Fprintf(Stdout, i18n.G("Unknown answer %q.\n"), answer)
+			continue
+		}
+		break
+	}
+
+	// hold the refreshes that were not selected so that they are not
+	// done automatically before they can be reviewed again
+	if err := x.holdRefreshes(held); err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	if len(selected) == 1 {
+		return x.refreshOne(selected[0], &client.SnapOptions{})
+	}
+	return x.refreshMany(selected, nil)
+}
@@ -75,6 +75,7 @@ func (x *cmdRemodel) Execute(args []string) error {
 		return fmt.Errorf("cannot remodel: %v", err)
 	}
 
+	x.reportSteps = true
 	if _, err := x.wait(changeID); err != nil {
 		if err == noWait {
 			return nil
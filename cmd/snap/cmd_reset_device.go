@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+var (
+	shortResetDeviceHelp = i18n.G("Reset this device to its factory state")
+	longResetDeviceHelp  = i18n.G(`
+The reset-device command wipes all system and user data of the device and
+reboots it into the base and kernel snaps it was originally seeded with, to
+then seed it again.
+
+The device keeps its identity, unless --regenerate-identity is given in which
+case it registers anew.
+`)
+)
+
+type cmdResetDevice struct {
+	waitMixin
+	RegenerateIdentity bool `long:"regenerate-identity"`
+}
+
+func init() {
+	cmd := addCommand("reset-device",
+		shortResetDeviceHelp,
+		longResetDeviceHelp,
+		func() flags.Commander {
+			return &cmdResetDevice{}
+		}, waitDescs.also(map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"regenerate-identity": i18n.G("Drop the device serial and register anew after the reset"),
+		}), nil)
+	cmd.hidden = true
+}
+
+func (x *cmdResetDevice) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	changeID, err := x.client.FactoryReset(&client.FactoryResetOptions{
+		RegenerateIdentity: x.RegenerateIdentity,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot reset device: %v", err)
+	}
+
+	if _, err := x.wait(changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(Stdout, i18n.G("Device will be reset to its factory state after the reboot\n"))
+	return nil
+}
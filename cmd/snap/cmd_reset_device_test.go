@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+
+	. "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) testResetDevice(c *C, args []string, expectedBody map[string]interface{}) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		switch r.URL.Path {
+		case "/v2/factory-reset":
+			c.Check(r.Method, Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), DeepEquals, expectedBody)
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		case "/v2/changes/zzz":
+			c.Check(r.Method, Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+	rest, err := Parser(Client()).ParseArgs(append([]string{"reset-device"}, args...))
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, "Device will be reset to its factory state after the reboot\n")
+	c.Check(s.Stderr(), Equals, "")
+	c.Check(n, Equals, 2)
+}
+
+func (s *SnapSuite) TestResetDevice(c *C) {
+	s.testResetDevice(c, nil, map[string]interface{}{})
+}
+
+func (s *SnapSuite) TestResetDeviceRegenerateIdentity(c *C) {
+	s.testResetDevice(c, []string{"--regenerate-identity"}, map[string]interface{}{
+		"identity": "regenerate",
+	})
+}
+
+func (s *SnapSuite) TestResetDeviceError(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v2/factory-reset")
+		w.WriteHeader(400)
+		fmt.Fprintln(w, `{"type":"error", "status-code": 400, "result": {"message": "cannot factory reset a classic system"}}`)
+	})
+	_, err := Parser(Client()).ParseArgs([]string{"reset-device"})
+	c.Check(err, ErrorMatches, "cannot reset device: cannot factory reset a classic system")
+}
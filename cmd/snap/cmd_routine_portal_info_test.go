@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/dirs"
+)
+
+func (s *SnapSuite) mockPortalInfoProcess(c *C) {
+	procDir := filepath.Join(dirs.GlobalRootDir, "proc/42")
+	c.Assert(os.MkdirAll(filepath.Join(procDir, "attr"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(procDir, "cgroup"), []byte("7:freezer:/snap.hello\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(procDir, "attr/current"), []byte("snap.hello.universe (enforce)\n"), 0644), IsNil)
+}
+
+func (s *SnapSuite) TestPortalInfo(c *C) {
+	s.mockPortalInfoProcess(c)
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, Equals, "GET")
+			c.Check(r.URL.Path, Equals, "/v2/snaps/hello")
+			EncodeResponseBody(c, w, map[string]interface{}{
+				"type": "sync",
+				"result": map[string]interface{}{
+					"name": "hello",
+					"apps": []interface{}{
+						map[string]interface{}{"snap": "hello", "name": "hello", "desktop-file": "/path/to/hello_hello.desktop"},
+						map[string]interface{}{"snap": "hello", "name": "universe"},
+					},
+				},
+			})
+		case 1:
+			c.Check(r.Method, Equals, "GET")
+			c.Check(r.URL.Path, Equals, "/v2/connections")
+			c.Check(r.URL.Query().Get("snap"), Equals, "hello")
+			c.Check(r.URL.Query().Get("interface"), Equals, "network-status")
+			EncodeResponseBody(c, w, map[string]interface{}{
+				"type": "sync",
+				"result": map[string]interface{}{
+					"established": []interface{}{
+						map[string]interface{}{
+							"slot":      map[string]interface{}{"snap": "core", "slot": "network-status"},
+							"plug":      map[string]interface{}{"snap": "hello", "plug": "network-status"},
+							"interface": "network-status",
+						},
+					},
+				},
+			})
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d (%v)", n+1, r)
+		}
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "portal-info", "42"})
+	c.Assert(err, IsNil)
+	c.Check(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `[Snap Info]
+InstanceName=hello
+AppName=universe
+HasNetworkStatus=true
+`)
+	c.Check(s.Stderr(), Equals, "")
+	c.Check(n, Equals, 2)
+}
+
+func (s *SnapSuite) TestPortalInfoNotASnap(c *C) {
+	procDir := filepath.Join(dirs.GlobalRootDir, "proc/42")
+	c.Assert(os.MkdirAll(procDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(procDir, "cgroup"), []byte("7:freezer:/\n"), 0644), IsNil)
+
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request: %v", r)
+	})
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"routine", "portal-info", "42"})
+	c.Assert(err, ErrorMatches, "cannot find a snap for pid 42")
+}
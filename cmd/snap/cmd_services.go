@@ -21,6 +21,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/jessevdk/go-flags"
@@ -28,10 +29,16 @@ import (
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/strutil/quantity"
 )
 
 type svcStatus struct {
 	clientMixin
+	timeMixin
+	formatMixin
+	Usage      bool `long:"usage"`
+	Activators bool `long:"activators"`
 	Positional struct {
 		ServiceNames []serviceName
 	} `positional-args:"yes"`
@@ -39,9 +46,11 @@ type svcStatus struct {
 
 type svcLogs struct {
 	clientMixin
-	N          string `short:"n" default:"10"`
-	Follow     bool   `short:"f"`
-	Positional struct {
+	N           string `short:"n" default:"10"`
+	Follow      bool   `short:"f"`
+	AfterCursor string `long:"after-cursor"`
+	ShowCursor  bool   `long:"show-cursor"`
+	Positional  struct {
 		ServiceNames []serviceName `required:"1"`
 	} `positional-args:"yes" required:"yes"`
 }
@@ -51,11 +60,26 @@ var (
 	longServicesHelp  = i18n.G(`
 The services command lists information about the services specified, or about
 the services in all currently installed snaps.
+
+If the --usage option is given, the memory currently used by active services
+and the CPU time they consumed since they were started are also shown, as
+accounted by systemd.
+
+If the --activators option is given, the sockets and timers that activate the
+services are also shown, as <snap>.<app>:<socket> and <snap>.<app>:timer.
+
+With the global --format=json or --format=yaml option, the services are
+printed as a list in the given format, with the fields used by the snapd REST
+API, including the activators and, with --usage, the resource usage.
 `)
 	shortLogsHelp = i18n.G("Retrieve logs for services")
 	longLogsHelp  = i18n.G(`
 The logs command fetches logs of the given services and displays them in
 chronological order.
+
+If the --show-cursor option is given, the journal cursor of the last line is
+shown once all lines are displayed. Passing it to --after-cursor resumes the
+logs after that line.
 `)
 	shortStartHelp = i18n.G("Start services")
 	longStartHelp  = i18n.G(`
@@ -81,24 +105,38 @@ func init() {
 		// TRANSLATORS: This should not start with a lowercase letter.
 		desc: i18n.G("A service specification, which can be just a snap name (for all services in the snap), or <snap>.<app> for a single service."),
 	}}
-	addCommand("services", shortServicesHelp, longServicesHelp, func() flags.Commander { return &svcStatus{} }, nil, argdescs)
+	addCommand("services", shortServicesHelp, longServicesHelp, func() flags.Commander { return &svcStatus{} },
+		timeDescs.also(map[string]string{
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"usage": i18n.G("Show the memory and CPU time currently used by active services."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"activators": i18n.G("Also show the sockets and timers activating the services."),
+		}), argdescs)
 	addCommand("logs", shortLogsHelp, longLogsHelp, func() flags.Commander { return &svcLogs{} },
 		map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"n": i18n.G("Show only the given number of lines, or 'all'."),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"f": i18n.G("Wait for new lines and print them as they come in."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"after-cursor": i18n.G("Show only the lines after the one with the given journal cursor."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"show-cursor": i18n.G("Show the journal cursor of the last line after the lines."),
 		}, argdescs)
 
 	addCommand("start", shortStartHelp, longStartHelp, func() flags.Commander { return &svcStart{} },
 		waitDescs.also(map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"enable": i18n.G("As well as starting the service now, arrange for it to be started on boot."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"activator": i18n.G("Start the given socket, or the timer, of the services instead of the services themselves (can be repeated)."),
 		}), argdescs)
 	addCommand("stop", shortStopHelp, longStopHelp, func() flags.Commander { return &svcStop{} },
 		waitDescs.also(map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"disable": i18n.G("As well as stopping the service now, arrange for it to no longer be started on boot."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"activator": i18n.G("Stop the given socket, or the timer, of the services instead of the services themselves (can be repeated)."),
 		}), argdescs)
 	addCommand("restart", shortRestartHelp, longRestartHelp, func() flags.Commander { return &svcRestart{} },
 		waitDescs.also(map[string]string{
@@ -120,11 +158,18 @@ func (s *svcStatus) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
-	services, err := s.client.Apps(svcNames(s.Positional.ServiceNames), client.AppOptions{Service: true})
+	services, err := s.client.Apps(svcNames(s.Positional.ServiceNames), client.AppOptions{Service: true, Usage: s.Usage})
 	if err != nil {
 		return err
 	}
 
+	if services == nil {
+		services = []*client.AppInfo{}
+	}
+	if ok, err := printFormatted(services); ok {
+		return err
+	}
+
 	if len(services) == 0 {
 		fmt.Fprintln(Stderr, i18n.G("There are no services provided by installed snaps."))
 		return nil
@@ -133,7 +178,11 @@ func (s *svcStatus) Execute(args []string) error {
 	w := tabWriter()
 	defer w.Flush()
 
-	fmt.Fprintln(w, i18n.G("Service\tStartup\tCurrent\tNotes"))
+	if s.Usage {
+		fmt.Fprintln(w, i18n.G("Service\tStartup\tCurrent\tMemory\tCPU\tNotes"))
+	} else {
+		fmt.Fprintln(w, i18n.G("Service\tStartup\tCurrent\tNotes"))
+	}
 
 	for _, svc := range services {
 		startup := i18n.G("disabled")
@@ -144,12 +193,69 @@ func (s *svcStatus) Execute(args []string) error {
 		if svc.Active {
 			current = i18n.G("active")
 		}
-		fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\n", svc.Snap, svc.Name, startup, current, cmd.ClientAppInfoNotes(svc))
+		if s.Usage {
+			memory, cpu := fmtServiceUsage(svc.Usage)
+			fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\t%s\t%s\n", svc.Snap, svc.Name, startup, current, memory, cpu, cmd.ClientAppInfoNotes(svc))
+		} else {
+			fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\n", svc.Snap, svc.Name, startup, current, cmd.ClientAppInfoNotes(svc))
+		}
+		if !s.Activators {
+			continue
+		}
+		for _, act := range svc.Activators {
+			s.printActivator(w, svc, &act)
+		}
 	}
 
 	return nil
 }
 
+// printActivator prints the row of a socket or timer of a service, named
+// as they are given to --activator when starting or stopping it.
+func (s *svcStatus) printActivator(w io.Writer, svc *client.AppInfo, act *client.AppActivator) {
+	name := act.Name
+	notes := act.Type
+	if act.Type == "timer" {
+		name = "timer"
+		if !act.NextTrigger.IsZero() {
+			// TRANSLATORS: %s is the time the timer of a service is triggered next
+			notes = fmt.Sprintf(i18n.G("timer, next %s"), s.fmtTime(act.NextTrigger))
+		}
+	}
+	startup := i18n.G("disabled")
+	if act.Enabled {
+		startup = i18n.G("enabled")
+	}
+	current := i18n.G("inactive")
+	switch {
+	case act.Listening:
+		current = i18n.G("listening")
+	case act.Active:
+		current = i18n.G("active")
+	}
+	if s.Usage {
+		fmt.Fprintf(w, "%s.%s:%s\t%s\t%s\t-\t-\t%s\n", svc.Snap, svc.Name, name, startup, current, notes)
+	} else {
+		fmt.Fprintf(w, "%s.%s:%s\t%s\t%s\t%s\n", svc.Snap, svc.Name, name, startup, current, notes)
+	}
+}
+
+// fmtServiceUsage returns the memory and CPU time columns of a service,
+// "-" being used for inactive services and usage not accounted by systemd.
+func fmtServiceUsage(usage *client.AppUsage) (memory, cpu string) {
+	memory, cpu = "-", "-"
+	if usage == nil {
+		return memory, cpu
+	}
+	if usage.Memory >= 0 {
+		memory = strutil.SizeToStr(usage.Memory)
+	}
+	if usage.CPUTime >= 0 {
+		cpu = quantity.FormatDuration(usage.CPUTime.Seconds())
+	}
+	return memory, cpu
+}
+
 func (s *svcLogs) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
@@ -164,13 +270,21 @@ func (s *svcLogs) Execute(args []string) error {
 		sN = int(n)
 	}
 
-	logs, err := s.client.Logs(svcNames(s.Positional.ServiceNames), client.LogOptions{N: sN, Follow: s.Follow})
+	logs, err := s.client.Logs(svcNames(s.Positional.ServiceNames), client.LogOptions{N: sN, Follow: s.Follow, AfterCursor: s.AfterCursor})
 	if err != nil {
 		return err
 	}
 
+	cursor := s.AfterCursor
 	for log := range logs {
 		fmt.Fprintln(Stdout, log)
+		if log.Cursor != "" {
+			cursor = log.Cursor
+		}
+	}
+	if s.ShowCursor && cursor != "" {
+		// like journalctl --show-cursor
+		fmt.Fprintf(Stdout, "-- cursor: %s\n", cursor)
 	}
 
 	return nil
@@ -181,7 +295,8 @@ type svcStart struct {
 	Positional struct {
 		ServiceNames []serviceName `required:"1"`
 	} `positional-args:"yes" required:"yes"`
-	Enable bool `long:"enable"`
+	Enable     bool     `long:"enable"`
+	Activators []string `long:"activator"`
 }
 
 func (s *svcStart) Execute(args []string) error {
@@ -189,7 +304,7 @@ func (s *svcStart) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 	names := svcNames(s.Positional.ServiceNames)
-	changeID, err := s.client.Start(names, client.StartOptions{Enable: s.Enable})
+	changeID, err := s.client.Start(names, client.StartOptions{Enable: s.Enable, Activators: s.Activators})
 	if err != nil {
 		return err
 	}
@@ -210,7 +325,8 @@ type svcStop struct {
 	Positional struct {
 		ServiceNames []serviceName `required:"1"`
 	} `positional-args:"yes" required:"yes"`
-	Disable bool `long:"disable"`
+	Disable    bool     `long:"disable"`
+	Activators []string `long:"activator"`
 }
 
 func (s *svcStop) Execute(args []string) error {
@@ -218,7 +334,7 @@ func (s *svcStop) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 	names := svcNames(s.Positional.ServiceNames)
-	changeID, err := s.client.Stop(names, client.StopOptions{Disable: s.Disable})
+	changeID, err := s.client.Stop(names, client.StopOptions{Disable: s.Disable, Activators: s.Activators})
 	if err != nil {
 		return err
 	}
@@ -224,6 +224,42 @@ foo.zed  enabled  active    -
 	c.Check(n, check.Equals, 1)
 }
 
+func (s *appOpSuite) TestAppStatusFormat(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/apps")
+		c.Check(r.Method, check.Equals, "GET")
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]interface{}{
+			"type": "sync",
+			"result": []map[string]interface{}{
+				{"snap": "foo", "name": "bar", "daemon": "simple",
+					"active": true, "enabled": true,
+					"activators": []map[string]interface{}{
+						{"name": "sock", "type": "socket", "active": true, "enabled": true},
+					},
+				},
+			},
+			"status":      "OK",
+			"status-code": 200,
+		})
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"services", "--format=json"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	var services []map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &services), check.IsNil)
+	c.Assert(services, check.HasLen, 1)
+	c.Check(services[0]["snap"], check.Equals, "foo")
+	c.Check(services[0]["name"], check.Equals, "bar")
+	c.Check(services[0]["daemon"], check.Equals, "simple")
+	c.Check(services[0]["active"], check.Equals, true)
+	activators, ok := services[0]["activators"].([]interface{})
+	c.Assert(ok, check.Equals, true)
+	c.Check(activators, check.HasLen, 1)
+}
+
 func (s *appOpSuite) TestServiceCompletion(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
@@ -269,6 +305,136 @@ func (s *appOpSuite) TestServiceCompletion(c *check.C) {
 	c.Check(n, check.Equals, 7)
 }
 
+func (s *appOpSuite) TestAppStatusUsage(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.URL.Path, check.Equals, "/v2/apps")
+			c.Check(r.URL.Query(), check.HasLen, 2)
+			c.Check(r.URL.Query().Get("select"), check.Equals, "service")
+			c.Check(r.URL.Query().Get("usage"), check.Equals, "true")
+			c.Check(r.Method, check.Equals, "GET")
+			w.WriteHeader(200)
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{
+				"type": "sync",
+				"result": []map[string]interface{}{
+					{"snap": "foo", "name": "bar", "daemon": "simple",
+						"active": true, "enabled": true,
+						"usage": map[string]interface{}{"memory": 12345678, "cpu-time": 1500000000},
+					}, {"snap": "foo", "name": "baz", "daemon": "simple",
+						"active": true, "enabled": false,
+						"usage": map[string]interface{}{"memory": -1, "cpu-time": -1},
+					}, {"snap": "foo", "name": "zed", "daemon": "simple",
+						"active": false, "enabled": true,
+					},
+				},
+				"status":      "OK",
+				"status-code": 200,
+			})
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"services", "--usage"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `Service  Startup   Current   Memory  CPU    Notes
+foo.bar  enabled   active    12MB    1.50s  -
+foo.baz  disabled  active    -       -      -
+foo.zed  enabled   inactive  -       -      -
+`)
+	// ensure that the fake server api was actually hit
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *appOpSuite) TestAppStatusActivators(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.URL.Path, check.Equals, "/v2/apps")
+			c.Check(r.URL.Query(), check.HasLen, 1)
+			c.Check(r.URL.Query().Get("select"), check.Equals, "service")
+			c.Check(r.Method, check.Equals, "GET")
+			w.WriteHeader(200)
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{
+				"type": "sync",
+				"result": []map[string]interface{}{
+					{"snap": "foo", "name": "bar", "daemon": "oneshot",
+						"active": false, "enabled": true,
+						"activators": []map[string]interface{}{
+							{"name": "bar", "type": "timer", "active": true, "enabled": true,
+								"nexttrigger": "2020-11-02T12:15:00Z"},
+						},
+					}, {"snap": "foo", "name": "baz", "daemon": "oneshot",
+						"active": false, "enabled": true,
+						"activators": []map[string]interface{}{
+							{"name": "baz-sock1", "type": "socket", "active": true, "enabled": true, "listening": true},
+							{"name": "baz-sock2", "type": "socket", "active": false, "enabled": false},
+						},
+					}, {"snap": "foo", "name": "zed",
+						"active": true, "enabled": true,
+					},
+				},
+				"status":      "OK",
+				"status-code": 200,
+			})
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"services", "--activators", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `Service            Startup   Current    Notes
+foo.bar            enabled   inactive   timer-activated
+foo.bar:timer      enabled   active     timer, next 2020-11-02T12:15:00Z
+foo.baz            enabled   inactive   socket-activated
+foo.baz:baz-sock1  enabled   listening  socket
+foo.baz:baz-sock2  disabled  inactive   socket
+foo.zed            enabled   active     -
+`)
+	// ensure that the fake server api was actually hit
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *appOpSuite) TestAppOpActivator(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.URL.Path, check.Equals, "/v2/apps")
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":     "stop",
+				"names":      []interface{}{"foo.baz"},
+				"disable":    true,
+				"activators": []interface{}{"baz-sock1", "timer"},
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "change": "42", "status-code": 202}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"stop", "--no-wait", "--disable", "--activator=baz-sock1", "--activator=timer", "foo.baz"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, "42\n")
+	c.Check(n, check.Equals, 1)
+}
+
 func (s *appOpSuite) TestAppStatusNoServices(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
@@ -299,3 +465,32 @@ func (s *appOpSuite) TestAppStatusNoServices(c *check.C) {
 	// ensure that the fake server api was actually hit
 	c.Check(n, check.Equals, 1)
 }
+
+func (s *appOpSuite) TestLogsCursor(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/logs")
+			c.Check(r.URL.Query().Get("names"), check.Equals, "foo.svc,bar.svc")
+			c.Check(r.URL.Query().Get("after-cursor"), check.Equals, "c0")
+			w.WriteHeader(200)
+			fmt.Fprintln(w, "\x1e"+`{"timestamp":"2020-01-01T00:00:00Z","message":"hello","sid":"foo","pid":"42","service":"foo.svc","cursor":"c1"}`)
+			fmt.Fprintln(w, "\x1e"+`{"timestamp":"2020-01-01T00:00:01Z","message":"world","sid":"bar","pid":"43","service":"bar.svc","cursor":"c2"}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"logs", "--after-cursor=c0", "--show-cursor", "foo.svc", "bar.svc"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `
+2020-01-01T00:00:00Z foo[42]: hello
+2020-01-01T00:00:01Z bar[43]: world
+-- cursor: c2
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
@@ -20,11 +20,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
 
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/jsonutil"
 )
@@ -44,18 +48,32 @@ Nested values may be modified via a dotted path:
 
 Configuration option may be unset with exclamation mark:
     $ snap set author!
+
+With --from-file the configuration of the snaps is replaced with the
+one in the given file, as printed by 'snap get --export'. Options not
+in the file are unset. With --dry-run the changes this would make are
+shown instead:
+
+    $ snap set --dry-run --from-file config.json
 `)
 
 type cmdSet struct {
 	waitMixin
+	FromFile   flags.Filename `long:"from-file"`
+	DryRun     bool           `long:"dry-run"`
 	Positional struct {
 		Snap       installedSnapName
-		ConfValues []string `required:"1"`
-	} `positional-args:"yes" required:"yes"`
+		ConfValues []string
+	} `positional-args:"yes"`
 }
 
 func init() {
-	addCommand("set", shortSetHelp, longSetHelp, func() flags.Commander { return &cmdSet{} }, waitDescs, []argDesc{
+	addCommand("set", shortSetHelp, longSetHelp, func() flags.Commander { return &cmdSet{} }, waitDescs.also(map[string]string{
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"from-file": i18n.G("Replace the configuration of snaps with the one in the given file"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"dry-run": i18n.G("Only show the configuration changes --from-file would make"),
+	}), []argDesc{
 		{
 			name: "<snap>",
 			// TRANSLATORS: This should not start with a lowercase letter.
@@ -70,6 +88,22 @@ func init() {
 }
 
 func (x *cmdSet) Execute(args []string) error {
+	if x.FromFile != "" {
+		if x.Positional.Snap != "" {
+			return fmt.Errorf(i18n.G("cannot use --from-file together with a snap or configuration values"))
+		}
+		return x.setFromFile()
+	}
+	if x.DryRun {
+		return fmt.Errorf(i18n.G("cannot use --dry-run without --from-file"))
+	}
+	if x.Positional.Snap == "" {
+		return fmt.Errorf("the required argument `<snap>` was not provided")
+	}
+	if len(x.Positional.ConfValues) == 0 {
+		return fmt.Errorf("the required argument `<conf value> (at least 1 argument)` was not provided")
+	}
+
 	patchValues := make(map[string]interface{})
 	for _, patchValue := range x.Positional.ConfValues {
 		parts := strings.SplitN(patchValue, "=", 2)
@@ -95,6 +129,10 @@ func (x *cmdSet) Execute(args []string) error {
 		return err
 	}
 
+	return x.waitConf(id)
+}
+
+func (x *cmdSet) waitConf(id string) error {
 	if _, err := x.wait(id); err != nil {
 		if err == noWait {
 			return nil
@@ -104,3 +142,60 @@ func (x *cmdSet) Execute(args []string) error {
 
 	return nil
 }
+
+func (x *cmdSet) setFromFile() error {
+	data, err := ioutil.ReadFile(string(x.FromFile))
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot read configuration: %v"), err)
+	}
+	var docs map[string]map[string]interface{}
+	if err := jsonutil.DecodeWithNumber(bytes.NewReader(data), &docs); err != nil {
+		return fmt.Errorf(i18n.G("cannot read configuration from %q: %v"), x.FromFile, err)
+	}
+
+	if x.DryRun {
+		changes, err := x.client.DiffConf(docs)
+		if err != nil {
+			return err
+		}
+		return showConfChanges(changes)
+	}
+
+	id, err := x.client.ImportConf(docs)
+	if err != nil {
+		return err
+	}
+	return x.waitConf(id)
+}
+
+func confValueString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// showConfChanges prints the configuration changes, marking added
+// options with "+", removed ones with "-" and changed ones with "~".
+func showConfChanges(changes []client.ConfChange) error {
+	if len(changes) == 0 {
+		fmt.Fprintln(Stdout, i18n.G("No configuration changes."))
+		return nil
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	for _, chg := range changes {
+		switch chg.Kind {
+		case "added":
+			fmt.Fprintf(w, "+\t%s\t%s\t%s\n", chg.Snap, chg.Key, confValueString(chg.New))
+		case "removed":
+			fmt.Fprintf(w, "-\t%s\t%s\t%s\n", chg.Snap, chg.Key, confValueString(chg.Old))
+		default:
+			fmt.Fprintf(w, "~\t%s\t%s\t%s -> %s\n", chg.Snap, chg.Key, confValueString(chg.Old), confValueString(chg.New))
+		}
+	}
+	return nil
+}
@@ -22,7 +22,9 @@ package main_test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
 
 	"gopkg.in/check.v1"
 
@@ -128,3 +130,94 @@ func (s *snapSetSuite) mockSetConfigServer(c *check.C, expectedValue interface{}
 		}
 	})
 }
+
+func (s *snapSetSuite) TestSnapSetMissingArgs(c *check.C) {
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set"})
+	c.Check(err, check.ErrorMatches, "the required argument `<snap>` was not provided")
+	_, err = snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "snapname"})
+	c.Check(err, check.ErrorMatches, "the required argument `<conf value> \\(at least 1 argument\\)` was not provided")
+	_, err = snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--dry-run", "snapname", "key=value"})
+	c.Check(err, check.ErrorMatches, "cannot use --dry-run without --from-file")
+	_, err = snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--from-file", "foo", "snapname"})
+	c.Check(err, check.ErrorMatches, "cannot use --from-file together with a snap or configuration values")
+	c.Check(s.setConfApiCalls, check.Equals, 0)
+}
+
+const importConf = `{"snapname": {"key": "value", "port": 8080}, "system": {}}`
+
+func (s *snapSetSuite) writeConfFile(c *check.C, content string) string {
+	fn := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(fn, []byte(content), 0644), check.IsNil)
+	return fn
+}
+
+func (s *snapSetSuite) TestSnapSetFromFile(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/config":
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"config": map[string]interface{}{
+					"snapname": map[string]interface{}{"key": "value", "port": json.Number("8080")},
+					"system":   map[string]interface{}{},
+				},
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+			s.setConfApiCalls += 1
+		case "/v2/changes/zzz":
+			c.Check(r.Method, check.Equals, "GET")
+			fmt.Fprintln(w, `{"type":"sync", "result":{"ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("unexpected path %q", r.URL.Path)
+		}
+	})
+
+	fn := s.writeConfFile(c, importConf)
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--from-file", fn})
+	c.Assert(err, check.IsNil)
+	c.Check(s.setConfApiCalls, check.Equals, 1)
+}
+
+func (s *snapSetSuite) TestSnapSetFromFileDryRun(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/config")
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(DecodedRequestBody(c, r)["dry-run"], check.Equals, true)
+		fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": [
+			{"snap": "snapname", "key": "key", "kind": "added", "new": "value"},
+			{"snap": "snapname", "key": "port", "kind": "changed", "old": 80, "new": 8080},
+			{"snap": "system", "key": "service.ssh.disable", "kind": "removed", "old": true}
+		]}`)
+		s.setConfApiCalls += 1
+	})
+
+	fn := s.writeConfFile(c, importConf)
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--dry-run", "--from-file", fn})
+	c.Assert(err, check.IsNil)
+	c.Check(s.setConfApiCalls, check.Equals, 1)
+	c.Check(s.Stdout(), check.Equals, `
++    snapname  key                  "value"
+~    snapname  port                 80 -> 8080
+-    system    service.ssh.disable  true
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *snapSetSuite) TestSnapSetFromFileDryRunNoChanges(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type":"sync", "status-code": 200, "result": []}`)
+	})
+
+	fn := s.writeConfFile(c, importConf)
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--dry-run", "--from-file", fn})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "No configuration changes.\n")
+}
+
+func (s *snapSetSuite) TestSnapSetFromFileInvalid(c *check.C) {
+	fn := s.writeConfFile(c, `{"snapname": 1}`)
+	_, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "--from-file", fn})
+	c.Check(err, check.ErrorMatches, `cannot read configuration from ".*/config.json": .*`)
+	c.Check(s.setConfApiCalls, check.Equals, 0)
+}
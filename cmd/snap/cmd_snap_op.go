@@ -65,6 +65,9 @@ Note a later refresh will typically undo a revision override, taking the snap
 back to the current revision of the channel it's tracking.
 
 Use --name to set the instance name when installing from snap file.
+
+Use --from-seed to install a snap that the image of the device holds to be
+installed on demand, instead of getting it from the store.
 `)
 
 var longRemoveHelp = i18n.G(`
@@ -87,6 +90,11 @@ have developer access to the snap, either directly or through the
 store's collaboration feature, and to be logged in (see 'snap help login').
 
 Note a later refresh will typically undo a revision override.
+
+The --review option lists the pending refreshes, with the summaries of the
+new revisions, and asks whether to do all of them, some of them or none.
+Automatic refreshes of the snaps whose refreshes are not done are held for a
+week, while the other snaps are still refreshed automatically.
 `)
 
 var longTryHelp = i18n.G(`
@@ -412,8 +420,12 @@ type cmdInstall struct {
 
 	Unaliased bool `long:"unaliased"`
 
+	FromSeed bool `long:"from-seed"`
+
 	Name string `long:"name"`
 
+	Transaction string `long:"transaction" choice:"per-snap" choice:"all-or-nothing"`
+
 	Cohort     string `long:"cohort"`
 	Positional struct {
 		Snaps []remoteSnapName `positional-arg-name:"<snap>"`
@@ -427,6 +439,9 @@ func (x *cmdInstall) installOne(nameOrPath, desiredName string, opts *client.Sna
 	var path string
 
 	if strings.Contains(nameOrPath, "/") || strings.HasSuffix(nameOrPath, ".snap") || strings.Contains(nameOrPath, ".snap.") {
+		if opts.FromSeed {
+			return errors.New(i18n.G("cannot install a snap file from the seed"))
+		}
 		path = nameOrPath
 		changeID, err = x.client.InstallPath(path, x.Name, opts)
 	} else {
@@ -536,9 +551,14 @@ func (x *cmdInstall) Execute([]string) error {
 		Dangerous: dangerous,
 		Unaliased: x.Unaliased,
 		CohortKey: x.Cohort,
+		FromSeed:  x.FromSeed,
 	}
 	x.setModes(opts)
 
+	if x.FromSeed && (x.asksForMode() || x.asksForChannel() || x.Revision != "" || x.Cohort != "" || dangerous || x.Name != "") {
+		return errors.New(i18n.G("cannot use --from-seed with channel, revision, cohort, mode, dangerous or name options"))
+	}
+
 	names := remoteSnapNames(x.Positional.Snaps)
 	if len(names) == 0 {
 		return errors.New(i18n.G("cannot install zero snaps"))
@@ -560,7 +580,16 @@ func (x *cmdInstall) Execute([]string) error {
 	if x.Name != "" {
 		return errors.New(i18n.G("cannot use instance name when installing multiple snaps"))
 	}
-	return x.installMany(names, nil)
+
+	if x.FromSeed {
+		return errors.New(i18n.G("a single snap name is needed to install from the seed"))
+	}
+
+	var manyOpts *client.SnapOptions
+	if x.Transaction != "" {
+		manyOpts = &client.SnapOptions{Transaction: client.TransactionType(x.Transaction)}
+	}
+	return x.installMany(names, manyOpts)
 }
 
 type cmdRefresh struct {
@@ -575,6 +604,7 @@ type cmdRefresh struct {
 	Cohort           string `long:"cohort"`
 	LeaveCohort      bool   `long:"leave-cohort"`
 	List             bool   `long:"list"`
+	Review           bool   `long:"review"`
 	Time             bool   `long:"time"`
 	IgnoreValidation bool   `long:"ignore-validation"`
 	Positional       struct {
@@ -731,6 +761,14 @@ func (x *cmdRefresh) Execute([]string) error {
 		return x.listRefresh()
 	}
 
+	if x.Review {
+		if len(x.Positional.Snaps) > 0 || x.asksForMode() || x.asksForChannel() {
+			return errors.New(i18n.G("--review does not accept additional arguments"))
+		}
+
+		return x.reviewRefresh()
+	}
+
 	if len(x.Positional.Snaps) == 0 && os.Getenv("SNAP_REFRESH_FROM_TIMER") == "1" {
 		fmt.Fprintf(Stdout, "Ignoring `snap refresh` from the systemd timer")
 		return nil
@@ -1051,6 +1089,10 @@ func init() {
 			"name": i18n.G("Install the snap file under the given instance name"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"cohort": i18n.G("Install the snap in the given cohort"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"from-seed": i18n.G("Install the snap the seed of the device holds to be installed on demand"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"transaction": i18n.G("Have one change install all the given snaps, undoing all of them if any fails (all-or-nothing), or each snap independently (per-snap, the default)"),
 		}), nil)
 	addCommand("refresh", shortRefreshHelp, longRefreshHelp, func() flags.Commander { return &cmdRefresh{} },
 		colorDescs.also(waitDescs).also(channelDescs).also(modeDescs).also(timeDescs).also(map[string]string{
@@ -1061,6 +1103,8 @@ func init() {
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"list": i18n.G("Show the new versions of snaps that would be updated with the next refresh"),
 			// TRANSLATORS: This should not start with a lowercase letter.
+			"review": i18n.G("Review the pending refreshes and choose which of them to do now"),
+			// TRANSLATORS: This should not start with a lowercase letter.
 			"time": i18n.G("Show auto refresh information but do not perform a refresh"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"ignore-validation": i18n.G("Ignore validation by other snaps blocking the refresh"),
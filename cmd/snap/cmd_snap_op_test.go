@@ -208,6 +208,43 @@ func (s *SnapOpSuite) TestInstall(c *check.C) {
 	c.Check(s.srv.n, check.Equals, s.srv.total)
 }
 
+func (s *SnapOpSuite) TestInstallFromSeed(c *check.C) {
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":    "install",
+			"from-seed": true,
+		})
+	}
+
+	s.RedirectClientToTestServer(s.srv.handle)
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"install", "--from-seed", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?sm).*foo 1.0 from Bar installed`)
+	c.Check(s.Stderr(), check.Equals, "")
+	// ensure that the fake server api was actually hit
+	c.Check(s.srv.n, check.Equals, s.srv.total)
+}
+
+func (s *SnapOpSuite) TestInstallFromSeedUnsupportedOptions(c *check.C) {
+	s.RedirectClientToTestServer(nil)
+	for _, args := range [][]string{
+		{"install", "--from-seed", "--beta", "foo"},
+		{"install", "--from-seed", "--devmode", "foo"},
+		{"install", "--from-seed", "--revision", "1", "foo"},
+	} {
+		_, err := snap.Parser(snap.Client()).ParseArgs(args)
+		c.Check(err, check.ErrorMatches, `cannot use --from-seed with channel, revision, cohort, mode, dangerous or name options`)
+	}
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"install", "--from-seed", "foo", "bar"})
+	c.Check(err, check.ErrorMatches, `a single snap name is needed to install from the seed`)
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"install", "--from-seed", "./foo.snap"})
+	c.Check(err, check.ErrorMatches, `cannot install a snap file from the seed`)
+}
+
 func (s *SnapOpSuite) TestInstallNoPATH(c *check.C) {
 	// PATH restored by test tear down
 	os.Setenv("PATH", "/bin:/usr/bin:/sbin:/usr/sbin")
@@ -1059,6 +1096,159 @@ foo +4.2update1 +17 +bar +-.*
 	c.Check(n, check.Equals, 1)
 }
 
+const refreshReviewFindJSON = `{"type": "sync", "result": [
+{"name": "foo", "status": "active", "version": "2.0", "developer": "bar", "publisher": {"id": "bar-id", "username": "bar", "display-name": "Bar", "validation": "unproven"}, "revision": 17, "download-size": 1048576, "summary": "Foo with fewer bugs"},
+{"name": "bar", "status": "active", "version": "3.0", "developer": "bar", "publisher": {"id": "bar-id", "username": "bar", "display-name": "Bar", "validation": "unproven"}, "revision": 5, "download-size": 2048, "summary": "Bar for all"}
+]}`
+
+const refreshReviewListJSON = `{"type": "sync", "result": [
+{"name": "foo", "status": "active", "version": "1.0", "developer": "bar", "publisher": {"id": "bar-id", "username": "bar", "display-name": "Bar", "validation": "unproven"}, "revision": 16, "tracking-channel": "latest/stable"},
+{"name": "bar", "status": "active", "version": "2.9", "developer": "bar", "publisher": {"id": "bar-id", "username": "bar", "display-name": "Bar", "validation": "unproven"}, "revision": 4, "tracking-channel": "latest/edge"}
+]}`
+
+func (s *SnapSuite) TestRefreshReviewLessOptions(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatal("expected to get 0 requests")
+	})
+
+	for _, args := range [][]string{{"--beta"}, {"--channel=potato"}, {"some-snap"}} {
+		_, err := snap.Parser(snap.Client()).ParseArgs(append([]string{"refresh", "--review"}, args...))
+		c.Assert(err, check.ErrorMatches, "--review does not accept additional arguments")
+	}
+}
+
+func (s *SnapSuite) TestRefreshReviewNo(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/find")
+			c.Check(r.URL.Query().Get("select"), check.Equals, "refresh")
+			fmt.Fprintln(w, refreshReviewFindJSON)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			c.Check(r.URL.Query().Get("snaps"), check.Equals, "bar,foo")
+			fmt.Fprintln(w, refreshReviewListJSON)
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	fmt.Fprint(s.stdin, "n\n")
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--review"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `Name +Current +New +Rev +Channel +Size +Notes
+bar +2.9 +3.0 +5 +latest/edge +2kB +-
+foo +1.0 +2.0 +17 +latest/stable +1MB +-
+
+bar 3.0: Bar for all
+foo 2.0: Foo with fewer bugs
+Refresh all of the above\? \[Y\]es, \[n\]o, \[s\]elect, \[h\]old: No snaps refreshed.
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 2)
+}
+
+func (s *SnapSuite) TestRefreshReviewSelect(c *check.C) {
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	restore := snap.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/find")
+			fmt.Fprintln(w, refreshReviewFindJSON)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			fmt.Fprintln(w, refreshReviewListJSON)
+		case 2:
+			// bar was not selected so only its refreshes are held
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/bar")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":     "hold",
+				"hold-until": "2020-06-08T10:00:00Z",
+			})
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"hold-until": "2020-06-08T10:00:00Z"}}`)
+		case 3:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action": "refresh",
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "change": "42", "status-code": 202}`)
+		default:
+			c.Fatalf("expected to get 4 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	fmt.Fprint(s.stdin, "s\nn\n\n")
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--review", "--no-wait", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), testutil.Contains, "Refresh bar to 3.0? [Y/n]: Refresh foo to 2.0? [Y/n]: ")
+	c.Check(s.Stdout(), testutil.Contains, "Automatic refreshes of bar held until 2020-06-08T10:00:00Z.\n")
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 4)
+}
+
+func (s *SnapSuite) TestRefreshReviewHold(c *check.C) {
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	restore := snap.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/find")
+			fmt.Fprintln(w, refreshReviewFindJSON)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			fmt.Fprintln(w, refreshReviewListJSON)
+		case 2:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/bar")
+			c.Check(DecodedRequestBody(c, r)["action"], check.Equals, "hold")
+			// refreshes of bar are already held for longer, that is kept
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"hold-until": "2020-06-20T10:00:00Z"}}`)
+		case 3:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":     "hold",
+				"hold-until": "2020-06-08T10:00:00Z",
+			})
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"hold-until": "2020-06-08T10:00:00Z"}}`)
+		default:
+			c.Fatalf("expected to get 4 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	fmt.Fprint(s.stdin, "h\n")
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--review", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), testutil.Contains, `Automatic refreshes of bar held until 2020-06-20T10:00:00Z.
+Automatic refreshes of foo held until 2020-06-08T10:00:00Z.
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 4)
+}
+
 func (s *SnapSuite) TestRefreshLegacyTime(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
@@ -1735,6 +1925,38 @@ func (s *SnapOpSuite) TestInstallMany(c *check.C) {
 	c.Check(n, check.Equals, total)
 }
 
+func (s *SnapOpSuite) TestInstallManyTransaction(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":      "install",
+				"snaps":       []interface{}{"one", "two"},
+				"transaction": "all-or-nothing",
+			})
+			w.WriteHeader(202)
+			fmt.Fprintln(w, `{"type":"async", "change": "42", "status-code": 202}`)
+		default:
+			c.Fatalf("expected to get 1 request, now on %d", n+1)
+		}
+		n++
+	})
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"install", "--no-wait", "--transaction=all-or-nothing", "one", "two"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, "(?sm)42\n")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapOpSuite) TestInstallManyTransactionInvalid(c *check.C) {
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"install", "--transaction=some-of-it", "one", "two"})
+	c.Assert(err, check.ErrorMatches, `Invalid value .some-of-it. for option .--transaction.*`)
+}
+
 func (s *SnapOpSuite) TestInstallZeroEmpty(c *check.C) {
 	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"install"})
 	c.Assert(err, check.ErrorMatches, "cannot install zero snaps")
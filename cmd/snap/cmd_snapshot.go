@@ -21,12 +21,16 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
 
 	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/strutil/quantity"
 )
@@ -41,6 +45,8 @@ var (
 	shortForgetHelp  = i18n.G("Delete a snapshot")
 	shortCheckHelp   = i18n.G("Check a snapshot")
 	shortRestoreHelp = i18n.G("Restore a snapshot")
+	shortExportHelp  = i18n.G("Export a snapshot")
+	shortImportHelp  = i18n.G("Import a snapshot")
 )
 
 var longSavedHelp = i18n.G(`
@@ -98,6 +104,22 @@ configuration data from the restore is not currently possible. This
 restriction may be lifted in the future.
 `)
 
+var longExportHelp = i18n.G(`
+The export-snapshot command writes the specified snapshot to a single
+file, which can be used to import the snapshot on this or another
+system with the 'import-snapshot' command.
+
+The exported file records the integrity hashes of the snapshot
+archives, which are verified when the snapshot is imported.
+`)
+var longImportHelp = i18n.G(`
+The import-snapshot command imports a snapshot previously written
+with the 'export-snapshot' command as a new snapshot, after verifying
+its integrity.
+`)
+
+var osutilFreeSpace = osutil.FreeSpace
+
 type savedCmd struct {
 	clientMixin
 	durationMixin
@@ -305,6 +327,84 @@ func (x *restoreCmd) Execute([]string) error {
 	return nil
 }
 
+type exportSnapshotCmd struct {
+	clientMixin
+	Positional struct {
+		ID       snapshotID     `positional-arg-name:"<id>"`
+		Filename flags.Filename `positional-arg-name:"<filename>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (x *exportSnapshotCmd) Execute([]string) (err error) {
+	setID, err := x.Positional.ID.ToUint()
+	if err != nil {
+		return err
+	}
+	filename := string(x.Positional.Filename)
+
+	stream, size, err := x.client.SnapshotExport(setID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	dir := filepath.Dir(filename)
+	free, err := osutilFreeSpace(dir)
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot check free space in %q: %v"), dir, err)
+	}
+	if uint64(size) > free {
+		return fmt.Errorf(i18n.G("cannot export snapshot #%s: not enough free space in %q (need %s, have %s)"),
+			x.Positional.ID, dir, strutil.SizeToStr(size), strutil.SizeToStr(int64(free)))
+	}
+
+	f, err := osutil.NewAtomicFile(filename, 0600, 0, osutil.NoChown, osutil.NoChown)
+	if err != nil {
+		return err
+	}
+	// if things worked, we'll commit (and Cancel becomes a NOP)
+	defer f.Cancel()
+
+	n, err := io.Copy(f, stream)
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot export snapshot #%s: %v"), x.Positional.ID, err)
+	}
+	if n != size {
+		return fmt.Errorf(i18n.G("cannot export snapshot #%s: short export (got %d bytes, expected %d)"), x.Positional.ID, n, size)
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("Exported snapshot #%s into %q.\n"), x.Positional.ID, filename)
+	return nil
+}
+
+type importSnapshotCmd struct {
+	clientMixin
+	Positional struct {
+		Filename flags.Filename `positional-arg-name:"<filename>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (x *importSnapshotCmd) Execute([]string) error {
+	f, err := os.Open(string(x.Positional.Filename))
+	if err != nil {
+		return fmt.Errorf(i18n.G("cannot import snapshot: %v"), err)
+	}
+	defer f.Close()
+
+	importSet, err := x.client.SnapshotImport(f)
+	if err != nil {
+		return err
+	}
+
+	// TRANSLATORS: the %s is a comma-separated list of quoted snap names
+	fmt.Fprintf(Stdout, i18n.NG("Imported snapshot as #%d of snap %s.\n", "Imported snapshot as #%d of snaps %s.\n", len(importSet.Snaps)),
+		importSet.ID, strutil.Quoted(importSet.Snaps))
+	return nil
+}
+
 func init() {
 	addCommand("saved",
 		shortSavedHelp,
@@ -354,4 +454,18 @@ func init() {
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"users": i18n.G("Check data of only specific users (comma-separated) (default: all users)"),
 		}), nil)
+
+	addCommand("export-snapshot",
+		shortExportHelp,
+		longExportHelp,
+		func() flags.Commander {
+			return &exportSnapshotCmd{}
+		}, nil, nil)
+
+	addCommand("import-snapshot",
+		shortImportHelp,
+		longImportHelp,
+		func() flags.Commander {
+			return &importSnapshotCmd{}
+		}, nil, nil)
 }
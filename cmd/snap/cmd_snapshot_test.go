@@ -21,12 +21,15 @@ package main_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/cmd/snap"
 	"github.com/snapcore/snapd/testutil"
 )
@@ -112,3 +115,61 @@ func (s *SnapSuite) mockSnapshotsServer(c *C) {
 		}
 	})
 }
+
+func (s *SnapSuite) mockSnapshotExportServer(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/snapshots/1/export":
+			c.Check(r.Method, Equals, "GET")
+			w.Header().Set("Content-Type", client.SnapshotExportMediaType)
+			w.Header().Set("Content-Length", "6")
+			fmt.Fprint(w, "export")
+		case "/v2/snapshots":
+			c.Check(r.Method, Equals, "POST")
+			c.Check(r.Header.Get("Content-Type"), Equals, client.SnapshotExportMediaType)
+			data, err := ioutil.ReadAll(r.Body)
+			c.Check(err, IsNil)
+			c.Check(string(data), Equals, "export")
+			fmt.Fprintln(w, `{"type": "sync", "result": {"set-id": 7, "snaps": ["htop", "foo"]}}`)
+		default:
+			c.Errorf("unexpected path %q", r.URL.Path)
+		}
+	})
+}
+
+func (s *SnapSuite) TestExportImportSnapshot(c *C) {
+	s.mockSnapshotExportServer(c)
+	defer main.MockOsutilFreeSpace(func(string) (uint64, error) {
+		return 1024, nil
+	})()
+
+	filename := filepath.Join(c.MkDir(), "snapshot.tar")
+	_, err := main.Parser(main.Client()).ParseArgs([]string{"export-snapshot", "1", filename})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, fmt.Sprintf("Exported snapshot #1 into %q.\n", filename))
+	c.Check(filename, testutil.FileEquals, "export")
+
+	s.stdout.Truncate(0)
+	_, err = main.Parser(main.Client()).ParseArgs([]string{"import-snapshot", filename})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, "Imported snapshot as #7 of snaps \"htop\", \"foo\".\n")
+}
+
+func (s *SnapSuite) TestExportSnapshotNotEnoughSpace(c *C) {
+	s.mockSnapshotExportServer(c)
+	dir := c.MkDir()
+	defer main.MockOsutilFreeSpace(func(path string) (uint64, error) {
+		c.Check(path, Equals, dir)
+		return 5, nil
+	})()
+
+	filename := filepath.Join(dir, "snapshot.tar")
+	_, err := main.Parser(main.Client()).ParseArgs([]string{"export-snapshot", "1", filename})
+	c.Assert(err, ErrorMatches, `cannot export snapshot #1: not enough free space in ".*" \(need 6B, have 5B\)`)
+	c.Check(filename, testutil.FileAbsent)
+}
+
+func (s *SnapSuite) TestImportSnapshotMissingFile(c *C) {
+	_, err := main.Parser(main.Client()).ParseArgs([]string{"import-snapshot", filepath.Join(c.MkDir(), "missing.tar")})
+	c.Assert(err, ErrorMatches, `cannot import snapshot: open .*/missing.tar: no such file or directory`)
+}
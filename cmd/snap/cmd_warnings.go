@@ -39,11 +39,18 @@ type cmdWarnings struct {
 	clientMixin
 	timeMixin
 	unicodeMixin
-	All     bool `long:"all"`
-	Verbose bool `long:"verbose"`
+	All      bool   `long:"all"`
+	Verbose  bool   `long:"verbose"`
+	JSON     bool   `long:"json"`
+	Severity string `long:"severity" choice:"info" choice:"warning" choice:"critical"`
 }
 
-type cmdOkay struct{ clientMixin }
+type cmdOkay struct {
+	clientMixin
+	Positional struct {
+		Keys []string `positional-arg-name:"<key>"`
+	} `positional-args:"yes"`
+}
 
 var shortWarningsHelp = i18n.G("List warnings")
 var longWarningsHelp = i18n.G(`
@@ -54,6 +61,9 @@ silence them. A warning that's been silenced in this way will not be listed
 again unless it happens again, _and_ a cooldown time has passed.
 
 Warnings expire automatically, and once expired they are forgotten.
+
+With --json the warnings are listed in JSON format, including the key
+that identifies each of them, for use by monitoring tools.
 `)
 
 var shortOkayHelp = i18n.G("Acknowledge warnings")
@@ -62,6 +72,10 @@ The okay command acknowledges the warnings listed with 'snap warnings'.
 
 Once acknowledged a warning won't appear again unless it re-occurrs and
 sufficient time has passed.
+
+If keys are given, as listed by 'snap warnings --verbose' or
+'snap warnings --json', only the warnings with those keys are acknowledged,
+whether they were listed or not.
 `)
 
 func init() {
@@ -70,8 +84,51 @@ func init() {
 		"all": i18n.G("Show all warnings"),
 		// TRANSLATORS: This should not start with a lowercase letter.
 		"verbose": i18n.G("Show more information"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"json": i18n.G("Output warnings in JSON format"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		"severity": i18n.G("Only show warnings with at least the given severity"),
 	}), nil)
-	addCommand("okay", shortOkayHelp, longOkayHelp, func() flags.Commander { return &cmdOkay{} }, nil, nil)
+	addCommand("okay", shortOkayHelp, longOkayHelp, func() flags.Commander { return &cmdOkay{} }, nil, []argDesc{{
+		// TRANSLATORS: This needs to begin with < and end with >
+		name: i18n.G("<key>"),
+		// TRANSLATORS: This should not start with a lowercase letter.
+		desc: i18n.G("Key of a warning to acknowledge"),
+	}})
+}
+
+// jsonWarning is the JSON representation of a warning as output by
+// 'snap warnings --json'.
+type jsonWarning struct {
+	Key         string     `json:"key"`
+	Message     string     `json:"message"`
+	Severity    string     `json:"severity"`
+	FirstAdded  time.Time  `json:"first-added"`
+	LastAdded   time.Time  `json:"last-added"`
+	LastShown   *time.Time `json:"last-shown,omitempty"`
+	ExpireAfter string     `json:"expire-after"`
+	RepeatAfter string     `json:"repeat-after"`
+}
+
+func outputWarningsJSON(warnings []*client.Warning) error {
+	jws := make([]jsonWarning, len(warnings))
+	for i, warning := range warnings {
+		jws[i] = jsonWarning{
+			Key:         warning.Key,
+			Message:     warning.Message,
+			Severity:    warning.Severity,
+			FirstAdded:  warning.FirstAdded,
+			LastAdded:   warning.LastAdded,
+			ExpireAfter: warning.ExpireAfter.String(),
+			RepeatAfter: warning.RepeatAfter.String(),
+		}
+		if !warning.LastShown.IsZero() {
+			jws[i].LastShown = &warning.LastShown
+		}
+	}
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jws)
 }
 
 func (cmd *cmdWarnings) Execute(args []string) error {
@@ -80,10 +137,21 @@ func (cmd *cmdWarnings) Execute(args []string) error {
 	}
 	now := time.Now()
 
-	warnings, err := cmd.client.Warnings(client.WarningsOptions{All: cmd.All})
+	warnings, err := cmd.client.Warnings(client.WarningsOptions{
+		All:      cmd.All,
+		Severity: cmd.Severity,
+	})
 	if err != nil {
 		return err
 	}
+	if cmd.JSON {
+		if len(warnings) > 0 {
+			if err := writeWarningTimestamp(now); err != nil {
+				return err
+			}
+		}
+		return outputWarningsJSON(warnings)
+	}
 	if len(warnings) == 0 {
 		if t, _ := lastWarningTimestamp(); t.IsZero() {
 			fmt.Fprintln(Stdout, i18n.G("No warnings."))
@@ -110,6 +178,8 @@ func (cmd *cmdWarnings) Execute(args []string) error {
 			fmt.Fprintln(w, "---")
 		}
 		if cmd.Verbose {
+			fmt.Fprintf(w, "key:\t%s\n", warning.Key)
+			fmt.Fprintf(w, "severity:\t%s\n", warning.Severity)
 			fmt.Fprintf(w, "first-occurrence:\t%s\n", cmd.fmtTime(warning.FirstAdded))
 		}
 		fmt.Fprintf(w, "last-occurrence:\t%s\n", cmd.fmtTime(warning.LastAdded))
@@ -136,6 +206,15 @@ func (cmd *cmdOkay) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	if len(cmd.Positional.Keys) > 0 {
+		for _, key := range cmd.Positional.Keys {
+			if err := cmd.client.OkayWarning(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	last, err := lastWarningTimestamp()
 	if err != nil {
 		return err
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"gopkg.in/check.v1"
@@ -39,17 +40,22 @@ var _ = check.Suite(&warningSuite{})
 const twoWarnings = `{
 			"result": [
 			    {
+				"key": "4b2ac8e4b4e1bd20",
 				"expire-after": "672h0m0s",
 				"first-added": "2018-09-19T12:41:18.505007495Z",
 				"last-added": "2018-09-19T12:41:18.505007495Z",
 				"message": "hello world number one",
+				"severity": "warning",
 				"repeat-after": "24h0m0s"
 			    },
 			    {
+				"key": "9d3e1a7f0c5b2d68",
 				"expire-after": "672h0m0s",
 				"first-added": "2018-09-19T12:44:19.680362867Z",
 				"last-added": "2018-09-19T12:44:19.680362867Z",
+				"last-shown": "2018-09-20T08:00:00Z",
 				"message": "hello world number two",
+				"severity": "critical",
 				"repeat-after": "24h0m0s"
 			    }
 			],
@@ -125,6 +131,8 @@ func (s *warningSuite) TestVerboseWarnings(c *check.C) {
 	c.Check(rest, check.HasLen, 0)
 	c.Check(s.Stderr(), check.Equals, "")
 	c.Check(s.Stdout(), check.Equals, `
+key:               4b2ac8e4b4e1bd20
+severity:          warning
 first-occurrence:  2018-09-19T12:41:18Z
 last-occurrence:   2018-09-19T12:41:18Z
 acknowledged:      --
@@ -133,9 +141,11 @@ expires-after:     28d0h
 warning: |
   hello world number one
 ---
+key:               9d3e1a7f0c5b2d68
+severity:          critical
 first-occurrence:  2018-09-19T12:44:19Z
 last-occurrence:   2018-09-19T12:44:19Z
-acknowledged:      --
+acknowledged:      2018-09-20T08:00:00Z
 repeats-after:     1d00h
 expires-after:     28d0h
 warning: |
@@ -172,6 +182,100 @@ func (s *warningSuite) TestOkay(c *check.C) {
 	c.Check(s.Stdout(), check.Equals, "")
 }
 
+func (s *warningSuite) TestWarningsJSON(c *check.C) {
+	s.RedirectClientToTestServer(mkWarningsFakeHandler(c, twoWarnings))
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"warnings", "--json"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `[
+  {
+    "key": "4b2ac8e4b4e1bd20",
+    "message": "hello world number one",
+    "severity": "warning",
+    "first-added": "2018-09-19T12:41:18.505007495Z",
+    "last-added": "2018-09-19T12:41:18.505007495Z",
+    "expire-after": "672h0m0s",
+    "repeat-after": "24h0m0s"
+  },
+  {
+    "key": "9d3e1a7f0c5b2d68",
+    "message": "hello world number two",
+    "severity": "critical",
+    "first-added": "2018-09-19T12:44:19.680362867Z",
+    "last-added": "2018-09-19T12:44:19.680362867Z",
+    "last-shown": "2018-09-20T08:00:00Z",
+    "expire-after": "672h0m0s",
+    "repeat-after": "24h0m0s"
+  }
+]
+`)
+
+	// the listed warnings can be acknowledged with 'snap okay'
+	t, err := snap.LastWarningTimestamp()
+	c.Assert(err, check.IsNil)
+	c.Check(t.IsZero(), check.Equals, false)
+}
+
+func (s *warningSuite) TestNoWarningsJSON(c *check.C) {
+	s.RedirectClientToTestServer(mkWarningsFakeHandler(c, `{"type": "sync", "status-code": 200, "result": []}`))
+
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"warnings", "--json"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, "[]\n")
+}
+
+func (s *warningSuite) TestWarningsSeverity(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/warnings")
+		c.Check(r.URL.Query(), check.DeepEquals, url.Values{"severity": {"critical"}})
+		c.Check(r.Method, check.Equals, "GET")
+		w.WriteHeader(200)
+		fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": []}`)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"warnings", "--severity=critical"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Equals, "No warnings.\n")
+
+	_, err = snap.Parser(snap.Client()).ParseArgs([]string{"warnings", "--severity=meh"})
+	c.Assert(err, check.ErrorMatches, `Invalid value .meh. for option .*--severity.*`)
+}
+
+func (s *warningSuite) TestOkayKeys(c *check.C) {
+	var keys []string
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/warnings")
+		c.Check(r.Method, check.Equals, "POST")
+		body := DecodedRequestBody(c, r)
+		c.Check(body["action"], check.Equals, "okay")
+		keys = append(keys, body["key"].(string))
+		w.WriteHeader(200)
+		fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": 1}`)
+	})
+
+	// no need to have looked at the warnings before
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"okay", "4b2ac8e4b4e1bd20", "9d3e1a7f0c5b2d68"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(keys, check.DeepEquals, []string{"4b2ac8e4b4e1bd20", "9d3e1a7f0c5b2d68"})
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, "")
+}
+
+func (s *warningSuite) TestOkayKeyNotFound(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, `{"type": "error", "status-code": 404, "result": {"message": "no warning with key \"0123456789abcdef\""}}`)
+	})
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"okay", "0123456789abcdef"})
+	c.Assert(err, check.ErrorMatches, `no warning with key "0123456789abcdef"`)
+}
+
 func (s *warningSuite) TestOkayBeforeWarnings(c *check.C) {
 	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"okay"})
 	c.Assert(err, check.ErrorMatches, "you must have looked at the warnings before acknowledging them. Try 'snap warnings'.")
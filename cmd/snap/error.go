@@ -222,6 +222,10 @@ If you understand and want to proceed repeat the command including --classic.
 		isError = false
 		usesSnapName = false
 		msg = i18n.G("snapd is about to reboot the system")
+	case client.ErrorKindDaemonVersionChanged:
+		isError = false
+		usesSnapName = false
+		msg = err.Message
 	default:
 		usesSnapName = false
 		msg = err.Message
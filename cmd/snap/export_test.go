@@ -63,6 +63,7 @@ var (
 	ReadRpc = readRpc
 
 	WriteWarningTimestamp = writeWarningTimestamp
+	LastWarningTimestamp  = lastWarningTimestamp
 	MaybePresentWarnings  = maybePresentWarnings
 
 	LongSnapDescription     = longSnapDescription
@@ -307,3 +308,11 @@ func MockSignalNotify(newSignalNotify func(sig ...os.Signal) (chan os.Signal, fu
 }
 
 type ServiceName = serviceName
+
+func MockOsutilFreeSpace(f func(string) (uint64, error)) (restore func()) {
+	old := osutilFreeSpace
+	osutilFreeSpace = f
+	return func() {
+		osutilFreeSpace = old
+	}
+}
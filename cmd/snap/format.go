@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+// formatMixin is embedded by the commands that can print their output in
+// the structured format requested with the global --format option.
+type formatMixin struct{}
+
+func (formatMixin) supportsFormat() {}
+
+type formatSupporter interface {
+	supportsFormat()
+}
+
+// checkFormatSupported errors out if a structured format was requested
+// for a command that does not support it.
+func checkFormatSupported(command flags.Commander) error {
+	if optionsData.Format == "" {
+		return nil
+	}
+	if _, ok := command.(formatSupporter); !ok {
+		return fmt.Errorf(i18n.G("this command does not support --format"))
+	}
+	return nil
+}
+
+// printFormatted prints v in the structured format requested with
+// --format, if any, and returns whether it did. The printed structure is
+// the JSON one of v, which for data from snapd is that of its API, also
+// for YAML.
+func printFormatted(v interface{}) (bool, error) {
+	switch optionsData.Format {
+	case "":
+		return false, nil
+	case "json":
+		enc := json.NewEncoder(Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return true, enc.Encode(v)
+	case "yaml":
+		generic, err := toGeneric(v)
+		if err != nil {
+			return true, err
+		}
+		enc := yaml.NewEncoder(Stdout)
+		defer enc.Close()
+		return true, enc.Encode(generic)
+	default:
+		return true, fmt.Errorf("internal error: unknown format %q", optionsData.Format)
+	}
+}
+
+// toGeneric converts v to the generic maps, slices and values of its
+// JSON representation, keeping integers as such. Objects are converted
+// to yaml.MapSlice so that their keys keep the order of the JSON one,
+// which for structs is the order of their fields.
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeOrdered(dec)
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch x := tok.(type) {
+	case json.Delim:
+		switch x {
+		case '{':
+			m := yaml.MapSlice{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, yaml.MapItem{Key: key, Value: value})
+			}
+			// consume the closing delimiter
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return m, nil
+		case '[':
+			l := []interface{}{}
+			for dec.More() {
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return l, nil
+		}
+		return nil, fmt.Errorf("internal error: unexpected JSON delimiter %v", x)
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n, nil
+		}
+		f, _ := x.Float64()
+		return f, nil
+	}
+	return tok, nil
+}
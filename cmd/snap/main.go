@@ -71,6 +71,7 @@ var (
 
 type options struct {
 	Version func() `long:"version"`
+	Format  string `long:"format" choice:"json" choice:"yaml"`
 }
 
 type argDesc struct {
@@ -100,6 +101,9 @@ var commands []*cmdInfo
 // debugCommands holds information about all debug commands.
 var debugCommands []*cmdInfo
 
+// routineCommands holds information about all internal commands.
+var routineCommands []*cmdInfo
+
 // addCommand replaces parser.addCommand() in a way that is compatible with
 // re-constructing a pristine parser.
 func addCommand(name, shortHelp, longHelp string, builder func() flags.Commander, optDescs map[string]string, argDescs []argDesc) *cmdInfo {
@@ -131,6 +135,22 @@ func addDebugCommand(name, shortHelp, longHelp string, builder func() flags.Comm
 	return info
 }
 
+// addRoutineCommand replaces parser.addCommand() in a way that is
+// compatible with re-constructing a pristine parser. It is meant for
+// adding "snap routine" commands.
+func addRoutineCommand(name, shortHelp, longHelp string, builder func() flags.Commander, optDescs map[string]string, argDescs []argDesc) *cmdInfo {
+	info := &cmdInfo{
+		name:      name,
+		shortHelp: shortHelp,
+		longHelp:  longHelp,
+		builder:   builder,
+		optDescs:  optDescs,
+		argDescs:  argDescs,
+	}
+	routineCommands = append(routineCommands, info)
+	return info
+}
+
 type parserSetter interface {
 	setParser(*flags.Parser)
 }
@@ -214,6 +234,7 @@ func Parser(cli *client.Client) *flags.Parser {
 	if firstNonOptionIsRun() {
 		flagopts |= flags.PassAfterNonOption
 	}
+	optionsData.Format = ""
 	parser := flags.NewParser(&optionsData, flagopts)
 	parser.ShortDescription = i18n.G("Tool to interact with snaps")
 	parser.LongDescription = longSnapDescription
@@ -223,6 +244,21 @@ func Parser(cli *client.Client) *flags.Parser {
 		version.Description = i18n.G("Print the version and exit")
 		version.Hidden = true
 	}
+	// --format is documented by the commands supporting it, as it
+	// would otherwise be listed in the help of every command
+	if format := parser.FindOptionByLongName("format"); format != nil {
+		format.Description = i18n.G("Print the output in the given structured format")
+		format.Hidden = true
+	}
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if command == nil {
+			return nil
+		}
+		if err := checkFormatSupported(command); err != nil {
+			return err
+		}
+		return command.Execute(args)
+	}
 	// add --help like what go-flags would do for us, but hidden
 	addHelp(parser)
 
@@ -283,56 +319,67 @@ func Parser(cli *client.Client) *flags.Parser {
 			c.extra(cmd)
 		}
 	}
-	// Add the debug command
-	debugCommand, err := parser.AddCommand("debug", shortDebugHelp, longDebugHelp, &cmdDebug{})
-	debugCommand.Hidden = true
-	if err != nil {
-		logger.Panicf("cannot add command %q: %v", "debug", err)
-	}
-	// Add all the sub-commands of the debug command
-	for _, c := range debugCommands {
-		obj := c.builder()
-		if x, ok := obj.(clientSetter); ok {
-			x.setClient(cli)
-		}
-		cmd, err := debugCommand.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
+	// Add the hidden debug and routine commands, along with their
+	// sub-commands
+	for _, parent := range []struct {
+		name        string
+		shortHelp   string
+		longHelp    string
+		data        interface{}
+		subCommands []*cmdInfo
+	}{
+		{"debug", shortDebugHelp, longDebugHelp, &cmdDebug{}, debugCommands},
+		{"routine", shortRoutineHelp, longRoutineHelp, &cmdRoutine{}, routineCommands},
+	} {
+		parentCommand, err := parser.AddCommand(parent.name, parent.shortHelp, parent.longHelp, parent.data)
 		if err != nil {
-			logger.Panicf("cannot add debug command %q: %v", c.name, err)
+			logger.Panicf("cannot add command %q: %v", parent.name, err)
 		}
-		cmd.Hidden = c.hidden
-		opts := cmd.Options()
-		if c.optDescs != nil && len(opts) != len(c.optDescs) {
-			logger.Panicf("wrong number of option descriptions for %s: expected %d, got %d", c.name, len(opts), len(c.optDescs))
-		}
-		for _, opt := range opts {
-			name := opt.LongName
-			if name == "" {
-				name = string(opt.ShortName)
+		parentCommand.Hidden = true
+		for _, c := range parent.subCommands {
+			obj := c.builder()
+			if x, ok := obj.(clientSetter); ok {
+				x.setClient(cli)
 			}
-			desc, ok := c.optDescs[name]
-			if !(c.optDescs == nil || ok) {
-				logger.Panicf("%s missing description for %s", c.name, name)
+			cmd, err := parentCommand.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
+			if err != nil {
+				logger.Panicf("cannot add %s command %q: %v", parent.name, c.name, err)
 			}
-			lintDesc(c.name, name, desc, opt.Description)
-			if desc != "" {
-				opt.Description = desc
+			cmd.Hidden = c.hidden
+			opts := cmd.Options()
+			if c.optDescs != nil && len(opts) != len(c.optDescs) {
+				logger.Panicf("wrong number of option descriptions for %s: expected %d, got %d", c.name, len(opts), len(c.optDescs))
+			}
+			for _, opt := range opts {
+				name := opt.LongName
+				if name == "" {
+					name = string(opt.ShortName)
+				}
+				desc, ok := c.optDescs[name]
+				if !(c.optDescs == nil || ok) {
+					logger.Panicf("%s missing description for %s", c.name, name)
+				}
+				lintDesc(c.name, name, desc, opt.Description)
+				if desc != "" {
+					opt.Description = desc
+				}
 			}
-		}
 
-		args := cmd.Args()
-		if c.argDescs != nil && len(args) != len(c.argDescs) {
-			logger.Panicf("wrong number of argument descriptions for %s: expected %d, got %d", c.name, len(args), len(c.argDescs))
-		}
-		for i, arg := range args {
-			name, desc := arg.Name, ""
-			if c.argDescs != nil {
-				name = c.argDescs[i].name
-				desc = c.argDescs[i].desc
+			args := cmd.Args()
+			if c.argDescs != nil && len(args) != len(c.argDescs) {
+				logger.Panicf("wrong number of argument descriptions for %s: expected %d, got %d", c.name, len(args), len(c.argDescs))
+			}
+			for i, arg := range args {
+				name, desc := arg.Name, ""
+				if c.argDescs != nil {
+					name = c.argDescs[i].name
+					desc = c.argDescs[i].desc
+				}
+				lintArg(c.name, name, desc, arg.Description)
+				name = fixupArg(name)
+				arg.Name = name
+				arg.Description = desc
 			}
-			lintArg(c.name, name, desc, arg.Description)
-			name = fixupArg(name)
-			arg.Name = name
-			arg.Description = desc
 		}
 	}
 	return parser
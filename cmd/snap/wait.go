@@ -40,6 +40,9 @@ type waitMixin struct {
 	clientMixin
 	NoWait    bool `long:"no-wait"`
 	skipAbort bool
+	// reportSteps makes wait report each task of the change as it
+	// finishes, useful for long changes made of many distinct steps.
+	reportSteps bool
 }
 
 var waitDescs = mixinDescs{
@@ -83,6 +86,7 @@ func (wmx waitMixin) wait(id string) (*client.Change, error) {
 
 	var lastID string
 	lastLog := map[string]string{}
+	reported := map[string]bool{}
 	for {
 		var rebootingErr error
 		chg, err := cli.Change(id)
@@ -135,6 +139,22 @@ func (wmx waitMixin) wait(id string) (*client.Change, error) {
 			break
 		}
 
+		if wmx.reportSteps {
+			for _, t := range chg.Tasks {
+				switch t.Status {
+				case "Done", "Undone", "Error":
+				default:
+					continue
+				}
+				if reported[t.ID] {
+					continue
+				}
+				reported[t.ID] = true
+				// TRANSLATORS: the first two %d are the number of finished and total steps, then the step status and summary
+				pb.Notify(fmt.Sprintf(i18n.G("[%d/%d] %s: %s"), len(reported), len(chg.Tasks), t.Status, t.Summary))
+			}
+		}
+
 		if chg.Ready {
 			if chg.Status == "Done" {
 				return chg, nil
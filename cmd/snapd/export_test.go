@@ -24,7 +24,8 @@ import (
 )
 
 var (
-	Run = run
+	Run        = run
+	RunPreseed = runPreseed
 )
 
 func MockSanityCheck(f func() error) (restore func()) {
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -32,7 +33,11 @@ import (
 	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/sanity"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/systemd"
 )
 
@@ -53,8 +58,24 @@ func init() {
 func main() {
 	cmd.ExecInSnapdOrCoreSnap()
 
+	if osutil.GetenvBool("SNAPD_FACTORY_RESET") {
+		// run by snapd.factory-reset.service early at boot
+		if err := devicestate.PerformPendingFactoryReset(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot reset system data: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ch := make(chan os.Signal, 2)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	if snapdenv.Preseeding() {
+		if err := runPreseed(ch); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot preseed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := run(ch); err != nil {
 		if err == daemon.ErrRestartSocket {
 			// Note that we don't prepend: "error: " here because
@@ -161,3 +182,84 @@ out:
 
 	return d.Stop(ch)
 }
+
+// preseedRestartBehavior signals done on the restart request issued once
+// the system is preseeded.
+type preseedRestartBehavior struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (rb *preseedRestartBehavior) HandleRestart(t state.RestartType) {
+	rb.once.Do(func() { close(rb.done) })
+}
+
+func (rb *preseedRestartBehavior) RebootAsExpected(st *state.State) error {
+	return nil
+}
+
+func (rb *preseedRestartBehavior) RebootDidNotHappen(st *state.State) error {
+	return nil
+}
+
+var preseedCheckInterval = time.Second
+
+// seedChangeErr returns the error of the seeding if it failed.
+func seedChangeErr(st *state.State) error {
+	st.Lock()
+	defer st.Unlock()
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "seed" && chg.Status().Ready() {
+			return chg.Err()
+		}
+	}
+	return nil
+}
+
+// runPreseed runs the managers without the API, as set up by
+// snap-preseed in the chroot of the image to preseed, until the
+// seeding reached the point where the running system is needed.
+func runPreseed(ch chan os.Signal) error {
+	httputil.SetUserAgentFromVersion(cmd.Version)
+
+	rb := &preseedRestartBehavior{done: make(chan struct{})}
+	o, err := overlord.New(rb)
+	if err != nil {
+		return err
+	}
+
+	st := o.State()
+	st.Lock()
+	var preseeded bool
+	err = st.Get("preseeded", &preseeded)
+	st.Unlock()
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if preseeded {
+		return fmt.Errorf("the system is already preseeded")
+	}
+
+	if err := o.StartUp(); err != nil {
+		return err
+	}
+	o.Loop()
+
+	tic := time.NewTicker(preseedCheckInterval)
+	defer tic.Stop()
+	for {
+		select {
+		case sig := <-ch:
+			o.Stop()
+			return fmt.Errorf("interrupted by %s signal", sig)
+		case <-rb.done:
+			logger.Noticef("System preseeded.")
+			return o.Stop()
+		case <-tic.C:
+			if err := seedChangeErr(st); err != nil {
+				o.Stop()
+				return err
+			}
+		}
+	}
+}
@@ -34,6 +34,8 @@ import (
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/seccomp"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 
 	snapd "github.com/snapcore/snapd/cmd/snapd"
@@ -124,3 +126,45 @@ func (s *snapdSuite) TestSanityFailGoesIntoDegradedMode(c *C) {
 	close(ch)
 	wg.Wait()
 }
+
+func (s *snapdSuite) TestRunPreseed(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	restore = apparmor.MockIsHomeUsingNFS(func() (bool, error) { return false, nil })
+	defer restore()
+	restore = seccomp.MockSnapSeccompVersionInfo(func(s seccomp.Compiler) (string, error) {
+		return "abcdef 1.2.3 1234abcd -", nil
+	})
+	defer restore()
+
+	// with nothing to seed, only the configure hook of core is left
+	// for first boot
+	ch := make(chan os.Signal)
+	done := make(chan error)
+	go func() {
+		done <- snapd.RunPreseed(ch)
+	}()
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(10 * time.Second):
+		close(ch)
+		c.Fatal("preseeding did not finish")
+	}
+
+	f, err := os.Open(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	st, err := state.ReadState(nil, f)
+	c.Assert(err, IsNil)
+	st.Lock()
+	defer st.Unlock()
+	var preseeded bool
+	c.Assert(st.Get("preseeded", &preseeded), IsNil)
+	c.Check(preseeded, Equals, true)
+	var seeded bool
+	c.Check(st.Get("seeded", &seeded), Equals, state.ErrNoState)
+
+	// cannot preseed twice
+	c.Check(snapd.RunPreseed(ch), ErrorMatches, "the system is already preseeded")
+}
@@ -72,6 +72,7 @@ func ClientSnapFromSnapInfo(snapInfo *snap.Info) (*client.Snap, error) {
 		Contact:     snapInfo.Contact,
 		Title:       snapInfo.Title(),
 		License:     snapInfo.License,
+		Links:       snapInfo.Links(),
 		Screenshots: snapInfo.Media.Screenshots(),
 		Media:       snapInfo.Media,
 		Prices:      snapInfo.Prices,
@@ -177,17 +178,19 @@ func ClientAppInfosFromSnapAppInfos(apps []*snap.AppInfo) ([]client.AppInfo, err
 				appInfo.Active = st.Active
 			case ".timer":
 				appInfo.Activators = append(appInfo.Activators, client.AppActivator{
-					Name:    app.Name,
-					Enabled: st.Enabled,
-					Active:  st.Active,
-					Type:    "timer",
+					Name:        app.Name,
+					Enabled:     st.Enabled,
+					Active:      st.Active,
+					Type:        "timer",
+					NextTrigger: st.NextTrigger,
 				})
 			case ".socket":
 				appInfo.Activators = append(appInfo.Activators, client.AppActivator{
-					Name:    sockSvcFileToName[st.UnitName],
-					Enabled: st.Enabled,
-					Active:  st.Active,
-					Type:    "socket",
+					Name:      sockSvcFileToName[st.UnitName],
+					Enabled:   st.Enabled,
+					Active:    st.Active,
+					Type:      "socket",
+					Listening: st.Listening,
 				})
 			}
 		}
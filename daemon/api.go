@@ -33,6 +33,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -56,6 +57,7 @@ import (
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/servicestate"
@@ -82,7 +84,10 @@ var api = []*Command{
 	snapFileCmd,
 	snapDownloadCmd,
 	snapConfCmd,
+	configCmd,
 	interfacesCmd,
+	connectionProfilesCmd,
+	interfacesAuditCmd,
 	assertsCmd,
 	assertsFindManyCmd,
 	stateChangeCmd,
@@ -100,9 +105,17 @@ var api = []*Command{
 	debugPprofCmd,
 	debugCmd,
 	snapshotCmd,
+	snapshotExportCmd,
 	connectionsCmd,
 	modelCmd,
+	serialModelCmd,
+	factoryResetCmd,
+	systemsCmd,
+	seedCmd,
 	cohortsCmd,
+	systemRestartCmd,
+	quotaGroupsCmd,
+	notificationsCmd,
 }
 
 var (
@@ -179,7 +192,7 @@ var (
 		UserOK:   true,
 		PolkitOK: "io.snapcraft.snapd.manage",
 		GET:      getChange,
-		POST:     abortChange,
+		POST:     postChange,
 	}
 
 	stateChangesCmd = &Command{
@@ -305,6 +318,20 @@ func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 		m["sandbox-features"] = features
 	}
 
+	// Convey how seeding is going until the system is seeded.
+	seeding, err := devicestate.Seeding(st)
+	if err != nil {
+		return InternalError("cannot get seeding status: %v", err)
+	}
+	if !seeding.Seeded && (seeding.Change != "" || seeding.Error != "") {
+		m["seeding"] = seeding
+	}
+
+	// Convey which optional subsystems snapd was built with.
+	if capabilities := c.d.overlord.Capabilities(); len(capabilities) > 0 {
+		m["capabilities"] = capabilities
+	}
+
 	return SyncResponse(m, nil)
 }
 
@@ -744,6 +771,10 @@ type snapInstruction struct {
 	IgnoreValidation bool          `json:"ignore-validation"`
 	Unaliased        bool          `json:"unaliased"`
 	Purge            bool          `json:"purge,omitempty"`
+	Simulate         bool          `json:"simulate,omitempty"`
+	FromSeed         bool          `json:"from-seed,omitempty"`
+
+	Transaction client.TransactionType `json:"transaction,omitempty"`
 	// dropping support temporarely until flag confusion is sorted,
 	// this isn't supported by client atm anyway
 	LeaveOld bool         `json:"temp-dropped-leave-old"`
@@ -751,6 +782,10 @@ type snapInstruction struct {
 	Snaps    []string     `json:"snaps"`
 	Users    []string     `json:"users"`
 
+	// HoldUntil is when auto-refreshes of the snap are held until
+	// for the hold action, a zero time releases the hold
+	HoldUntil time.Time `json:"hold-until"`
+
 	// The fields below should not be unmarshalled into. Do not export them.
 	userID int
 	ctx    context.Context
@@ -799,14 +834,19 @@ var (
 	snapstateRevert            = snapstate.Revert
 	snapstateRevertToRevision  = snapstate.RevertToRevision
 	snapstateSwitch            = snapstate.Switch
+	snapstateHoldRefresh       = snapstate.HoldRefresh
 
 	snapshotList    = snapshotstate.List
 	snapshotCheck   = snapshotstate.Check
 	snapshotForget  = snapshotstate.Forget
 	snapshotRestore = snapshotstate.Restore
 	snapshotSave    = snapshotstate.Save
+	snapshotExport  = snapshotstate.Export
+	snapshotImport  = snapshotstate.Import
 
 	assertstateRefreshSnapDeclarations = assertstate.RefreshSnapDeclarations
+
+	devicestateInstallOnDemandSnap = devicestate.InstallOnDemandSnap
 )
 
 func ensureStateSoonImpl(st *state.State) {
@@ -846,7 +886,8 @@ func snapUpdateMany(inst *snapInstruction, st *state.State) (*snapInstructionRes
 	}
 
 	// TODO: use a per-request context
-	updated, tasksets, err := snapstateUpdateMany(context.TODO(), st, inst.Snaps, inst.userID, nil)
+	flags := &snapstate.Flags{Transaction: inst.Transaction}
+	updated, tasksets, err := snapstateUpdateMany(context.TODO(), st, inst.Snaps, inst.userID, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -892,6 +933,33 @@ func verifySnapInstructions(inst *snapInstruction) error {
 			return fmt.Errorf("leave-cohort can only be specified for refresh or switch")
 		}
 	}
+	if inst.Simulate {
+		if inst.Action != "install" && inst.Action != "refresh" && inst.Action != "remove" {
+			return fmt.Errorf("simulate can only be specified for install, refresh, or remove")
+		}
+	}
+	if inst.FromSeed {
+		if inst.Action != "install" {
+			return fmt.Errorf("from-seed can only be specified for install")
+		}
+		// the seed decides the revision and confinement of the snap
+		if inst.Channel != "" || !inst.Revision.Unset() || inst.CohortKey != "" || inst.DevMode || inst.JailMode || inst.Classic {
+			return fmt.Errorf("cannot specify channel, revision, cohort-key or confinement options with from-seed")
+		}
+	}
+	if !inst.HoldUntil.IsZero() && inst.Action != "hold" {
+		return fmt.Errorf("hold-until can only be specified for hold")
+	}
+	switch inst.Transaction {
+	case "", client.TransactionPerSnap, client.TransactionAllOrNothing:
+	default:
+		return fmt.Errorf("invalid transaction type %q", inst.Transaction)
+	}
+	if inst.Transaction != "" {
+		if inst.Action != "install" && inst.Action != "refresh" {
+			return fmt.Errorf("transaction can only be specified for install or refresh")
+		}
+	}
 	switch inst.Action {
 	case "install":
 		for _, snapName := range inst.Snaps {
@@ -912,7 +980,8 @@ func snapInstallMany(inst *snapInstruction, st *state.State) (*snapInstructionRe
 			return nil, fmt.Errorf(i18n.G("cannot install snap with empty name"))
 		}
 	}
-	installed, tasksets, err := snapstateInstallMany(st, inst.Snaps, inst.userID)
+	flags := &snapstate.Flags{Transaction: inst.Transaction}
+	installed, tasksets, err := snapstateInstallMany(st, inst.Snaps, inst.userID, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -941,6 +1010,16 @@ func snapInstall(inst *snapInstruction, st *state.State) (string, []*state.TaskS
 		return "", nil, fmt.Errorf(i18n.G("cannot install snap with empty name"))
 	}
 
+	if inst.FromSeed {
+		logger.Noticef("Installing snap %q from the seed", inst.Snaps[0])
+		tset, err := devicestateInstallOnDemandSnap(st, inst.Snaps[0])
+		if err != nil {
+			return "", nil, err
+		}
+		msg := fmt.Sprintf(i18n.G("Install %q snap from the seed"), inst.Snaps[0])
+		return msg, []*state.TaskSet{tset}, nil
+	}
+
 	flags, err := inst.installFlags()
 	if err != nil {
 		return "", nil, err
@@ -1183,6 +1262,10 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("%s", err)
 	}
 
+	if inst.Action == "hold" {
+		return snapHold(&inst, state)
+	}
+
 	impl := inst.dispatch()
 	if impl == nil {
 		return BadRequest("unknown action %s", inst.Action)
@@ -1193,6 +1276,10 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 		return inst.errToResponse(err)
 	}
 
+	if inst.Simulate {
+		return simulatedChange(state, msg, tsets)
+	}
+
 	chg := newChange(state, inst.Action+"-snap", msg, tsets, inst.Snaps)
 
 	ensureStateSoon(state)
@@ -1200,6 +1287,15 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
 }
 
+// snapHold holds the auto-refreshes of the snap, which needs no change.
+func snapHold(inst *snapInstruction, st *state.State) Response {
+	until, err := snapstateHoldRefresh(st, inst.Snaps[0], inst.HoldUntil)
+	if err != nil {
+		return inst.errToResponse(err)
+	}
+	return SyncResponse(map[string]interface{}{"hold-until": until}, nil)
+}
+
 func newChange(st *state.State, kind, summary string, tsets []*state.TaskSet, snapNames []string) *state.Change {
 	chg := st.NewChange(kind, summary)
 	for _, ts := range tsets {
@@ -1211,6 +1307,25 @@ func newChange(st *state.State, kind, summary string, tsets []*state.TaskSet, sn
 	return chg
 }
 
+type simulatedChangeResult struct {
+	Summary string `json:"summary"`
+	*snapstate.ChangePlan
+}
+
+// simulatedChange reports what the change made of the given task sets
+// would do, instead of making it. The tasks are never added to a
+// change so they never run and get pruned.
+func simulatedChange(st *state.State, summary string, tsets []*state.TaskSet) Response {
+	plan, err := snapstate.Plan(st, tsets)
+	if err != nil {
+		return InternalError("cannot simulate change: %v", err)
+	}
+	return SyncResponse(&simulatedChangeResult{
+		Summary:    summary,
+		ChangePlan: plan,
+	}, nil)
+}
+
 const maxReadBuflen = 1024 * 1024
 
 func trySnap(c *Command, r *http.Request, user *auth.UserState, trydir string, flags snapstate.Flags) Response {
@@ -1281,7 +1396,7 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 
 	// TODO: inst.Amend, etc?
-	if inst.Channel != "" || !inst.Revision.Unset() || inst.DevMode || inst.JailMode || inst.CohortKey != "" || inst.LeaveCohort {
+	if inst.Channel != "" || !inst.Revision.Unset() || inst.DevMode || inst.JailMode || inst.CohortKey != "" || inst.LeaveCohort || inst.FromSeed {
 		return BadRequest("unsupported option provided for multi-snap operation")
 	}
 	if err := verifySnapInstructions(&inst); err != nil {
@@ -1315,6 +1430,10 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 		return inst.errToResponse(err)
 	}
 
+	if inst.Simulate {
+		return simulatedChange(st, res.Summary, res.Tasksets)
+	}
+
 	var chg *state.Change
 	if len(res.Tasksets) == 0 {
 		chg = st.NewChange(inst.Action+"-snap", res.Summary)
@@ -1589,13 +1708,67 @@ func getSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
 			if len(keys) > 1 {
 				return BadRequest("keys contains zero-length string")
 			}
-			return SyncResponse(value, nil)
+			var meta *Meta
+			if doc, ok := value.(map[string]interface{}); ok {
+				origins, err := confOrigins(s, snapName, doc)
+				if err != nil {
+					return InternalError("%v", err)
+				}
+				if len(origins) > 0 {
+					meta = &Meta{Origins: origins}
+				}
+			}
+			return SyncResponse(value, meta)
 		}
 
 		currentConfValues[key] = value
 	}
 
-	return SyncResponse(currentConfValues, nil)
+	var meta *Meta
+	origins, err := confOrigins(s, snapName, currentConfValues)
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	if len(origins) > 0 {
+		meta = &Meta{Origins: origins}
+	}
+	return SyncResponse(currentConfValues, meta)
+}
+
+// confOrigins returns the recorded origins (e.g. "gadget") of the
+// given configuration values of the snap and of their direct subkeys,
+// if any.
+func confOrigins(st *state.State, snapName string, values map[string]interface{}) (map[string]string, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	var origins map[string]string
+	record := func(key string) error {
+		origin, err := config.Origin(st, snapName, key)
+		if err != nil {
+			return err
+		}
+		if origin != "" {
+			if origins == nil {
+				origins = make(map[string]string)
+			}
+			origins[key] = origin
+		}
+		return nil
+	}
+	for key, value := range values {
+		if err := record(key); err != nil {
+			return nil, err
+		}
+		if subvalues, ok := value.(map[string]interface{}); ok {
+			for subkey := range subvalues {
+				if err := record(key + "." + subkey); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return origins, nil
 }
 
 func setSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
@@ -1613,11 +1786,7 @@ func setSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	taskset, err := configstate.ConfigureInstalled(st, snapName, patchValues, 0)
 	if err != nil {
-		// TODO: just return snap-not-installed instead ?
-		if _, ok := err.(*snap.NotInstalledError); ok {
-			return SnapNotFound(snapName, err)
-		}
-		return errToResponse(err, []string{snapName}, InternalError, "%v")
+		return configureErrToResponse(err, []string{snapName})
 	}
 
 	summary := fmt.Sprintf("Change configuration of %q snap", snapName)
@@ -1628,6 +1797,25 @@ func setSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
 	return AsyncResponse(nil, &Meta{Change: change.ID()})
 }
 
+func configureErrToResponse(err error, snaps []string) Response {
+	// TODO: just return snap-not-installed instead ?
+	if err, ok := err.(*snap.NotInstalledError); ok {
+		return SnapNotFound(err.Snap, err)
+	}
+	if err, ok := err.(*configstate.InvalidConfigError); ok {
+		return SyncResponse(&resp{
+			Type: ResponseTypeError,
+			Result: &errorResult{
+				Message: err.Error(),
+				Kind:    errorKindInvalidConfig,
+				Value:   err.Errors,
+			},
+			Status: 400,
+		}, nil)
+	}
+	return errToResponse(err, snaps, InternalError, "%v")
+}
+
 // interfacesConnectionsMultiplexer multiplexes to either legacy (connection) or modern behavior (interfaces).
 func interfacesConnectionsMultiplexer(c *Command, r *http.Request, user *auth.UserState) Response {
 	query := r.URL.Query()
@@ -1739,6 +1927,17 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 	if len(a.Plugs) == 0 || len(a.Slots) == 0 {
 		return BadRequest("at least one plug and slot is required")
 	}
+	var duration time.Duration
+	if a.Duration != "" {
+		if a.Action != "connect" {
+			return BadRequest("interface action %q cannot specify a duration", a.Action)
+		}
+		var err error
+		duration, err = time.ParseDuration(a.Duration)
+		if err != nil || duration <= 0 {
+			return BadRequest("invalid connection duration %q", a.Duration)
+		}
+	}
 
 	var summary string
 	var err error
@@ -1766,7 +1965,12 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 			var ts *state.TaskSet
 			affected = snapNamesFromConns([]*interfaces.ConnRef{connRef})
 			summary = fmt.Sprintf("Connect %s:%s to %s:%s", connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name)
-			ts, err = ifacestate.Connect(st, connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name)
+			if duration > 0 {
+				summary = fmt.Sprintf("Connect %s:%s to %s:%s for %s", connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name, duration)
+				ts, err = ifacestate.ConnectTemporarily(st, connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name, time.Now().Add(duration))
+			} else {
+				ts, err = ifacestate.Connect(st, connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name)
+			}
 			if _, ok := err.(*ifacestate.ErrAlreadyConnected); ok {
 				change := newChange(st, a.Action+"-snap", summary, nil, affected)
 				change.SetStatus(state.DoneStatus)
@@ -1805,6 +2009,7 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 	}
 
 	change := newChange(st, a.Action+"-snap", summary, tasksets, affected)
+	setChangeInitiator(change, auditInitiator(r, user))
 	st.EnsureBefore(0)
 
 	return AsyncResponse(nil, &Meta{Change: change.ID()})
@@ -1823,6 +2028,8 @@ type changeInfo struct {
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
 
 	Data map[string]*json.RawMessage `json:"data,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 type taskInfo struct {
@@ -1893,6 +2100,11 @@ func change2changeInfo(chg *state.Change) *changeInfo {
 		chgInfo.Data = data
 	}
 
+	var annotations map[string]string
+	if chg.Get("annotations", &annotations) == nil && len(annotations) > 0 {
+		chgInfo.Annotations = annotations
+	}
+
 	return chgInfo
 }
 
@@ -1964,7 +2176,7 @@ func getChanges(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse(chgInfos, nil)
 }
 
-func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
+func postChange(c *Command, r *http.Request, user *auth.UserState) Response {
 	chID := muxVars(r)["id"]
 	state := c.d.overlord.State()
 	state.Lock()
@@ -1975,7 +2187,8 @@ func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 
 	var reqData struct {
-		Action string `json:"action"`
+		Action      string            `json:"action"`
+		Annotations map[string]string `json:"annotations"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -1983,19 +2196,69 @@ func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("cannot decode data from request body: %v", err)
 	}
 
-	if reqData.Action != "abort" {
+	switch reqData.Action {
+	case "abort":
+		return abortChange(chg)
+	case "annotate":
+		return annotateChange(chg, reqData.Annotations)
+	default:
 		return BadRequest("change action %q is unsupported", reqData.Action)
 	}
+}
 
+func abortChange(chg *state.Change) Response {
 	if chg.Status().Ready() {
-		return BadRequest("cannot abort change %s with nothing pending", chID)
+		return BadRequest("cannot abort change %s with nothing pending", chg.ID())
 	}
 
 	// flag the change
 	chg.Abort()
 
 	// actually ask to proceed with the abort
-	ensureStateSoon(state)
+	ensureStateSoon(chg.State())
+
+	return SyncResponse(change2changeInfo(chg), nil)
+}
+
+const (
+	maxChangeAnnotations      = 16
+	maxChangeAnnotationLength = 1024
+)
+
+var validChangeAnnotationKey = regexp.MustCompile(`^[a-z0-9]+(?:[.-][a-z0-9]+)*$`)
+
+// annotateChange attaches the given annotations to the change, so that
+// external tooling can correlate it with its own workflows. Annotations
+// with an empty value are removed.
+func annotateChange(chg *state.Change, update map[string]string) Response {
+	if len(update) == 0 {
+		return BadRequest("cannot annotate change %s: no annotations given", chg.ID())
+	}
+
+	var annotations map[string]string
+	if err := chg.Get("annotations", &annotations); err != nil && err != state.ErrNoState {
+		return InternalError("cannot get annotations of change %s: %v", chg.ID(), err)
+	}
+	if annotations == nil {
+		annotations = make(map[string]string, len(update))
+	}
+	for key, value := range update {
+		if !validChangeAnnotationKey.MatchString(key) {
+			return BadRequest("cannot annotate change %s: invalid annotation key %q", chg.ID(), key)
+		}
+		if len(value) > maxChangeAnnotationLength {
+			return BadRequest("cannot annotate change %s: annotation %q is longer than %d bytes", chg.ID(), key, maxChangeAnnotationLength)
+		}
+		if value == "" {
+			delete(annotations, key)
+			continue
+		}
+		annotations[key] = value
+	}
+	if len(annotations) > maxChangeAnnotations {
+		return BadRequest("cannot annotate change %s: too many annotations (max %d)", chg.ID(), maxChangeAnnotations)
+	}
+	chg.Set("annotations", annotations)
 
 	return SyncResponse(change2changeInfo(chg), nil)
 }
@@ -2134,30 +2397,30 @@ type aliasAction struct {
 	Snap   string `json:"snap"`
 	App    string `json:"app"`
 	Alias  string `json:"alias"`
+	// for the "apply" action, the alias actions to perform in a
+	// single change
+	Preferences []*aliasAction `json:"preferences,omitempty"`
 	// old now unsupported api
 	Aliases []string `json:"aliases"`
 }
 
-func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
-	var a aliasAction
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&a); err != nil {
-		return BadRequest("cannot decode request body into an alias action: %v", err)
-	}
-	if len(a.Aliases) != 0 {
-		return BadRequest("cannot interpret request, snaps can no longer be expected to declare their aliases")
-	}
-
-	var taskset *state.TaskSet
-	var err error
-
-	st := c.d.overlord.State()
-	st.Lock()
-	defer st.Unlock()
+// aliasPreferenceResult is the result of applying one alias action
+// of an "apply" action.
+type aliasPreferenceResult struct {
+	Action string `json:"action"`
+	Snap   string `json:"snap,omitempty"`
+	App    string `json:"app,omitempty"`
+	Alias  string `json:"alias,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
 
+// aliasActionTaskSet returns the tasks performing the given alias action
+// and a summary of it. For unalias actions, the snap of the action is
+// resolved from the alias as needed.
+func aliasActionTaskSet(st *state.State, a *aliasAction) (taskset *state.TaskSet, summary string, err error) {
 	switch a.Action {
 	default:
-		return BadRequest("unsupported alias action: %q", a.Action)
+		return nil, "", fmt.Errorf("unsupported alias action: %q", a.Action)
 	case "alias":
 		taskset, err = snapstate.Alias(st, a.Snap, a.App, a.Alias)
 	case "unalias":
@@ -2168,7 +2431,7 @@ func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 			var snapst snapstate.SnapState
 			err := snapstate.Get(st, a.Snap, &snapst)
 			if err != nil && err != state.ErrNoState {
-				return InternalError("%v", err)
+				return nil, "", err
 			}
 			if err == state.ErrNoState { // not a snap
 				a.Snap = ""
@@ -2184,10 +2447,9 @@ func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 		taskset, err = snapstate.Prefer(st, a.Snap)
 	}
 	if err != nil {
-		return errToResponse(err, nil, BadRequest, "%v")
+		return nil, "", err
 	}
 
-	var summary string
 	switch a.Action {
 	case "alias":
 		summary = fmt.Sprintf(i18n.G("Setup alias %q => %q for snap %q"), a.Alias, a.App, a.Snap)
@@ -2200,6 +2462,34 @@ func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 	case "prefer":
 		summary = fmt.Sprintf(i18n.G("Prefer aliases of snap %q"), a.Snap)
 	}
+	return taskset, summary, nil
+}
+
+func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
+	var a aliasAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&a); err != nil {
+		return BadRequest("cannot decode request body into an alias action: %v", err)
+	}
+	if len(a.Aliases) != 0 {
+		return BadRequest("cannot interpret request, snaps can no longer be expected to declare their aliases")
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	if a.Action == "apply" {
+		return applyAliasPreferences(st, a.Preferences)
+	}
+	if len(a.Preferences) != 0 {
+		return BadRequest("alias action %q cannot specify preferences", a.Action)
+	}
+
+	taskset, summary, err := aliasActionTaskSet(st, &a)
+	if err != nil {
+		return errToResponse(err, nil, BadRequest, "%v")
+	}
 
 	change := newChange(st, a.Action, summary, []*state.TaskSet{taskset}, []string{a.Snap})
 	st.EnsureBefore(0)
@@ -2207,6 +2497,74 @@ func changeAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 	return AsyncResponse(nil, &Meta{Change: change.ID()})
 }
 
+// applyAliasPreferences performs the given alias actions, one after the
+// other, in a single change. The actions that cannot be performed are
+// skipped, the result reports how each of them went. An action that fails
+// once the change runs does not fail the change either, its error is
+// logged on its task and set in the results kept in the "alias-preferences"
+// of the api-data of the change, which are final once the change is ready.
+func applyAliasPreferences(st *state.State, prefs []*aliasAction) Response {
+	if len(prefs) == 0 {
+		return BadRequest("cannot apply alias preferences: no preferences given")
+	}
+
+	var tasksets []*state.TaskSet
+	var affected []string
+	results := make([]aliasPreferenceResult, 0, len(prefs))
+	involved := make(map[string]bool)
+	for _, pref := range prefs {
+		res := aliasPreferenceResult{
+			Action: pref.Action,
+			Snap:   pref.Snap,
+			App:    pref.App,
+			Alias:  pref.Alias,
+		}
+		var ts *state.TaskSet
+		var err error
+		if pref.Action == "apply" || len(pref.Preferences) != 0 || len(pref.Aliases) != 0 {
+			err = fmt.Errorf("unsupported alias preference")
+		} else {
+			ts, _, err = aliasActionTaskSet(st, pref)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		res.Snap = pref.Snap
+		res.Alias = pref.Alias
+		results = append(results, res)
+		snapstate.BestEffortAliases(ts, len(results)-1)
+
+		if len(tasksets) > 0 {
+			ts.WaitAll(tasksets[len(tasksets)-1])
+		}
+		tasksets = append(tasksets, ts)
+		if !involved[pref.Snap] {
+			involved[pref.Snap] = true
+			affected = append(affected, pref.Snap)
+		}
+	}
+
+	if len(tasksets) == 0 {
+		return &resp{
+			Type: ResponseTypeError,
+			Result: &errorResult{
+				Message: "cannot apply any of the alias preferences",
+				Value:   results,
+			},
+			Status: 400,
+		}
+	}
+
+	summary := fmt.Sprintf(i18n.G("Apply %d alias preferences"), len(tasksets))
+	change := newChange(st, "apply-aliases", summary, tasksets, affected)
+	change.Set("api-data", map[string]interface{}{"alias-preferences": results})
+	st.EnsureBefore(0)
+
+	return AsyncResponse(map[string]interface{}{"preferences": results}, &Meta{Change: change.ID()})
+}
+
 type aliasStatus struct {
 	Command string `json:"command"`
 	Status  string `json:"status"`
@@ -2276,14 +2634,60 @@ func getAppsInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 		return rsp
 	}
 
+	var usage bool
+	switch u := query.Get("usage"); u {
+	case "", "false":
+		// nothing to do
+	case "true":
+		usage = true
+	default:
+		return BadRequest("invalid usage parameter: %q", u)
+	}
+
 	clientAppInfos, err := cmd.ClientAppInfosFromSnapAppInfos(appInfos)
 	if err != nil {
 		return InternalError("%v", err)
 	}
+	if usage {
+		if err := addAppsResourceUsage(appInfos, clientAppInfos); err != nil {
+			return InternalError("%v", err)
+		}
+	}
 
 	return SyncResponse(clientAppInfos, nil)
 }
 
+// addAppsResourceUsage fills in the resource usage of the active services
+// among the given apps, clientAppInfos being in the same order as appInfos.
+func addAppsResourceUsage(appInfos []*snap.AppInfo, clientAppInfos []client.AppInfo) error {
+	var serviceNames []string
+	indices := make(map[string]int)
+	for i, appInfo := range appInfos {
+		if !clientAppInfos[i].Active {
+			continue
+		}
+		serviceName := appInfo.ServiceName()
+		serviceNames = append(serviceNames, serviceName)
+		indices[serviceName] = i
+	}
+	if len(serviceNames) == 0 {
+		return nil
+	}
+
+	sysd := systemd.New(dirs.GlobalRootDir, systemd.SystemMode, progress.Null)
+	usages, err := sysd.ResourceUsage(serviceNames...)
+	if err != nil {
+		return fmt.Errorf("cannot get resource usage of services: %v", err)
+	}
+	for _, usage := range usages {
+		clientAppInfos[indices[usage.UnitName]].Usage = &client.AppUsage{
+			Memory:  usage.Memory,
+			CPUTime: usage.CPUTime,
+		}
+	}
+	return nil
+}
+
 func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
 	query := r.URL.Query()
 	n := 10
@@ -2302,6 +2706,7 @@ func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
 		}
 		follow = f
 	}
+	afterCursor := query.Get("after-cursor")
 
 	// only services have logs for now
 	opts := appInfoOptions{service: true}
@@ -2314,12 +2719,15 @@ func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 
 	serviceNames := make([]string, len(appInfos))
+	// logs of several services are interleaved, label them with the app
+	services := make(map[string]string, len(appInfos))
 	for i, appInfo := range appInfos {
 		serviceNames[i] = appInfo.ServiceName()
+		services[serviceNames[i]] = appInfo.String()
 	}
 
 	sysd := systemd.New(dirs.GlobalRootDir, systemd.SystemMode, progress.Null)
-	reader, err := sysd.LogReader(serviceNames, n, follow)
+	reader, err := sysd.LogReader(serviceNames, n, follow, afterCursor)
 	if err != nil {
 		return InternalError("cannot get logs: %v", err)
 	}
@@ -2327,6 +2735,7 @@ func getLogs(c *Command, r *http.Request, user *auth.UserState) Response {
 	return &journalLineReaderSeqResponse{
 		ReadCloser: reader,
 		follow:     follow,
+		services:   services,
 	}
 }
 
@@ -2370,6 +2779,7 @@ func postApps(c *Command, r *http.Request, user *auth.UserState) Response {
 
 var (
 	stateOkayWarnings    = (*state.State).OkayWarnings
+	stateOkayWarning     = (*state.State).OkayWarning
 	stateAllWarnings     = (*state.State).AllWarnings
 	statePendingWarnings = (*state.State).PendingWarnings
 )
@@ -2379,6 +2789,7 @@ func ackWarnings(c *Command, r *http.Request, _ *auth.UserState) Response {
 	var op struct {
 		Action    string    `json:"action"`
 		Timestamp time.Time `json:"timestamp"`
+		Key       string    `json:"key"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&op); err != nil {
@@ -2387,9 +2798,21 @@ func ackWarnings(c *Command, r *http.Request, _ *auth.UserState) Response {
 	if op.Action != "okay" {
 		return BadRequest("unknown warning action %q", op.Action)
 	}
+	if op.Key != "" && !op.Timestamp.IsZero() {
+		return BadRequest("cannot acknowledge warnings by both key and timestamp")
+	}
 	st := c.d.overlord.State()
 	st.Lock()
 	defer st.Unlock()
+	if op.Key != "" {
+		if err := stateOkayWarning(st, op.Key, time.Now()); err != nil {
+			if err == state.ErrNoWarning {
+				return NotFound("no warning with key %q", op.Key)
+			}
+			return InternalError("cannot acknowledge warning: %v", err)
+		}
+		return SyncResponse(1, nil)
+	}
 	n := stateOkayWarnings(st, op.Timestamp)
 
 	return SyncResponse(n, nil)
@@ -2407,6 +2830,14 @@ func getWarnings(c *Command, r *http.Request, _ *auth.UserState) Response {
 	default:
 		return BadRequest("invalid select parameter: %q", sel)
 	}
+	var severity state.WarningSeverity
+	if sev := query.Get("severity"); sev != "" {
+		var err error
+		severity, err = state.ParseWarningSeverity(sev)
+		if err != nil {
+			return BadRequest("invalid severity parameter: %v", err)
+		}
+	}
 
 	st := c.d.overlord.State()
 	st.Lock()
@@ -2418,6 +2849,15 @@ func getWarnings(c *Command, r *http.Request, _ *auth.UserState) Response {
 	} else {
 		ws, _ = statePendingWarnings(st)
 	}
+	if severity != "" {
+		filtered := make([]*state.Warning, 0, len(ws))
+		for _, w := range ws {
+			if w.Severity().AtLeast(severity) {
+				filtered = append(filtered, w)
+			}
+		}
+		ws = filtered
+	}
 	if len(ws) == 0 {
 		// no need to confuse the issue
 		return SyncResponse([]state.Warning{}, nil)
@@ -20,6 +20,7 @@
 package daemon
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/snapcore/snapd/asserts"
@@ -53,7 +54,7 @@ func doAssert(c *Command, r *http.Request, user *auth.UserState) Response {
 	batch := assertstate.NewBatch()
 	_, err := batch.AddStream(r.Body)
 	if err != nil {
-		return BadRequest("cannot decode request body into assertions: %v", err)
+		return assertErrorResponse("cannot decode request body into assertions", err)
 	}
 
 	state := c.d.overlord.State()
@@ -61,7 +62,7 @@ func doAssert(c *Command, r *http.Request, user *auth.UserState) Response {
 	defer state.Unlock()
 
 	if err := batch.Commit(state); err != nil {
-		return BadRequest("assert failed: %v", err)
+		return assertErrorResponse("assert failed", err)
 	}
 	// TODO: what more info do we want to return on success?
 	return &resp{
@@ -70,6 +71,44 @@ func doAssert(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 }
 
+// assertErrorResponse maps the typed errors from adding assertions to
+// error responses with a precise kind; for a commit error this is based
+// on the first failure.
+func assertErrorResponse(prefix string, err error) Response {
+	cause := err
+	if commitErr, ok := err.(*assertstate.CommitError); ok && len(commitErr.Errors) > 0 {
+		cause = commitErr.Errors[0]
+	}
+
+	var kind errorKind
+	var ref *asserts.Ref
+	status := 400
+	switch e := cause.(type) {
+	case *assertstate.PrerequisiteError:
+		kind = errorKindAssertionPrerequisiteMissing
+		ref = e.Ref
+	case *assertstate.RevisionConflictError:
+		kind = errorKindAssertionRevisionConflict
+		ref = e.Ref
+		status = 409
+	case *asserts.UnsupportedFormatError:
+		kind = errorKindAssertionUnsupportedFormat
+		ref = e.Ref
+	default:
+		return BadRequest("%s: %v", prefix, err)
+	}
+
+	return &resp{
+		Type: ResponseTypeError,
+		Result: &errorResult{
+			Message: fmt.Sprintf("%s: %v", prefix, err),
+			Kind:    kind,
+			Value:   ref.Unique(),
+		},
+		Status: status,
+	}
+}
+
 func assertsFindMany(c *Command, r *http.Request, user *auth.UserState) Response {
 	assertTypeName := muxVars(r)["assertType"]
 	assertType := asserts.Type(assertTypeName)
@@ -162,6 +162,49 @@ func (s *assertsSuite) TestAssertError(c *check.C) {
 	// Verify (external)
 	c.Check(rec.Code, check.Equals, 400)
 	c.Check(rec.Body.String(), testutil.Contains, "assert failed")
+
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Assert(err, check.IsNil)
+	result := body["result"].(map[string]interface{})
+	c.Check(result["kind"], check.Equals, "assertion-prerequisite-missing")
+	c.Check(result["value"], check.Equals, s.storeSigning.StoreAccountKey("").Ref().Unique())
+}
+
+func (s *assertsSuite) TestAssertUnsupportedFormat(c *check.C) {
+	restore := asserts.MockMaxSupportedFormat(asserts.SnapDeclarationType, 111)
+	defer restore()
+
+	var snapDecl asserts.Assertion
+	func() {
+		restore := asserts.MockMaxSupportedFormat(asserts.SnapDeclarationType, 999)
+		defer restore()
+		var err error
+		snapDecl, err = s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+			"format":       "999",
+			"series":       "16",
+			"snap-id":      "snap-id-1",
+			"snap-name":    "foo",
+			"publisher-id": "can0nical",
+			"timestamp":    "2019-07-01T00:00:00Z",
+		}, nil, "")
+		c.Assert(err, check.IsNil)
+	}()
+
+	buf := bytes.NewBuffer(asserts.Encode(snapDecl))
+	req, err := http.NewRequest("POST", "/v2/assertions", buf)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	daemon.AssertsCmd.POST(daemon.AssertsCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 400)
+
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Assert(err, check.IsNil)
+	result := body["result"].(map[string]interface{})
+	c.Check(result["message"], check.Matches, `cannot decode request body into assertions: proposed "snap-declaration" assertion has format 999 but 111 is latest supported`)
+	c.Check(result["kind"], check.Equals, "assertion-unsupported-format")
+	c.Check(result["value"], check.Equals, snapDecl.Ref().Unique())
 }
 
 func (s *assertsSuite) TestAssertsFindManyAll(c *check.C) {
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/snapcore/snapd/jsonutil"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/strutil"
+)
+
+var configCmd = &Command{
+	Path:     "/v2/config",
+	PolkitOK: "io.snapcraft.snapd.manage",
+	GET:      exportConfig,
+	POST:     importConfig,
+}
+
+func exportConfig(c *Command, r *http.Request, user *auth.UserState) Response {
+	snapNames := strutil.CommaSeparatedList(r.URL.Query().Get("snaps"))
+	for i, snapName := range snapNames {
+		snapNames[i] = configstate.RemapSnapFromRequest(snapName)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	docs, err := configstate.ExportConfig(st, snapNames)
+	if err != nil {
+		return InternalError("cannot export configuration: %v", err)
+	}
+	result := make(map[string]map[string]interface{}, len(docs))
+	for snapName, doc := range docs {
+		result[configstate.RemapSnapToResponse(snapName)] = doc
+	}
+	return SyncResponse(result, nil)
+}
+
+type importConfigData struct {
+	Config map[string]map[string]interface{} `json:"config"`
+	DryRun bool                              `json:"dry-run,omitempty"`
+}
+
+func importConfig(c *Command, r *http.Request, user *auth.UserState) Response {
+	var data importConfigData
+	if err := jsonutil.DecodeWithNumber(r.Body, &data); err != nil {
+		return BadRequest("cannot decode request body into configuration: %v", err)
+	}
+	if len(data.Config) == 0 {
+		return BadRequest("no configuration to import")
+	}
+
+	docs := make(map[string]map[string]interface{}, len(data.Config))
+	for snapName, doc := range data.Config {
+		if doc == nil {
+			doc = make(map[string]interface{})
+		}
+		docs[configstate.RemapSnapFromRequest(snapName)] = doc
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	tss, changes, err := configstate.ImportConfig(st, docs, data.DryRun)
+	if err != nil {
+		return configureErrToResponse(err, nil)
+	}
+	for i := range changes {
+		changes[i].Snap = configstate.RemapSnapToResponse(changes[i].Snap)
+	}
+	if data.DryRun {
+		if changes == nil {
+			changes = []configstate.ConfigChange{}
+		}
+		return SyncResponse(changes, nil)
+	}
+
+	var affected []string
+	for _, chg := range changes {
+		if !strutil.ListContains(affected, chg.Snap) {
+			affected = append(affected, chg.Snap)
+		}
+	}
+	sort.Strings(affected)
+
+	var summary string
+	switch len(affected) {
+	case 0:
+		summary = "Import configuration"
+	case 1:
+		summary = fmt.Sprintf("Import configuration of %q snap", affected[0])
+	default:
+		summary = fmt.Sprintf("Import configuration of snaps %s", strutil.Quoted(affected))
+	}
+
+	var chg *state.Change
+	if len(tss) == 0 {
+		chg = st.NewChange("configure-snaps", summary)
+		chg.SetStatus(state.DoneStatus)
+	} else {
+		chg = newChange(st, "configure-snaps", summary, tss, affected)
+		ensureStateSoon(st)
+	}
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
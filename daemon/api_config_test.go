@@ -0,0 +1,187 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+var _ = check.Suite(&configSuite{})
+
+type configSuite struct {
+	o *overlord.Overlord
+	d *daemon.Daemon
+
+	ensured int
+	restore func()
+}
+
+func (s *configSuite) SetUpTest(c *check.C) {
+	dirs.SetRootDir(c.MkDir())
+	s.o = overlord.Mock()
+	s.d = daemon.NewWithOverlord(s.o)
+
+	s.ensured = 0
+	s.restore = daemon.MockEnsureStateSoon(func(*state.State) {
+		s.ensured++
+	})
+
+	st := s.o.State()
+	st.Lock()
+	defer st.Unlock()
+	snapstate.Set(st, "foo", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "foo", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		Active:   true,
+		SnapType: "app",
+	})
+	tr := config.NewTransaction(st)
+	c.Assert(tr.Set("foo", "port", 80), check.IsNil)
+	c.Assert(tr.Set("core", "service.ssh.disable", true), check.IsNil)
+	tr.Commit()
+}
+
+func (s *configSuite) TearDownTest(c *check.C) {
+	s.restore()
+	dirs.SetRootDir("/")
+}
+
+func (s *configSuite) TestConfigCmdAccess(c *check.C) {
+	c.Check(daemon.ConfigCmd.UserOK, check.Equals, false)
+	c.Check(daemon.ConfigCmd.PolkitOK, check.Equals, "io.snapcraft.snapd.manage")
+}
+
+func (s *configSuite) TestExportConfig(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/config", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.ConfigCmd.GET(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, map[string]map[string]interface{}{
+		"foo": {"port": json.Number("80")},
+		"system": {
+			"service": map[string]interface{}{
+				"ssh": map[string]interface{}{"disable": true},
+			},
+		},
+	})
+}
+
+func (s *configSuite) TestExportConfigSnaps(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/config?snaps=foo,bar", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.ConfigCmd.GET(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, map[string]map[string]interface{}{
+		"foo": {"port": json.Number("80")},
+		"bar": {},
+	})
+}
+
+func (s *configSuite) TestImportConfigDryRun(c *check.C) {
+	body := `{"config": {"foo": {"port": 8080, "name": "frank"}}, "dry-run": true}`
+	req, err := http.NewRequest("POST", "/v2/config", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.ConfigCmd.POST(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []configstate.ConfigChange{
+		{Snap: "foo", Key: "name", Kind: "added", New: "frank"},
+		{Snap: "foo", Key: "port", Kind: "changed", Old: json.Number("80"), New: json.Number("8080")},
+	})
+
+	st := s.o.State()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+	c.Check(s.ensured, check.Equals, 0)
+}
+
+func (s *configSuite) TestImportConfig(c *check.C) {
+	body := `{"config": {"foo": {"port": 8080}}}`
+	req, err := http.NewRequest("POST", "/v2/config", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.ConfigCmd.POST(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeAsync)
+
+	st := s.o.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "configure-snaps")
+	c.Check(chg.Summary(), check.Equals, `Import configuration of "foo" snap`)
+	c.Check(chg.Tasks(), check.HasLen, 1)
+	c.Check(s.ensured, check.Equals, 1)
+}
+
+func (s *configSuite) TestImportConfigUnchanged(c *check.C) {
+	body := `{"config": {"foo": {"port": 80}}}`
+	req, err := http.NewRequest("POST", "/v2/config", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.ConfigCmd.POST(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeAsync)
+
+	st := s.o.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Status(), check.Equals, state.DoneStatus)
+	c.Check(s.ensured, check.Equals, 0)
+}
+
+func (s *configSuite) TestImportConfigErrors(c *check.C) {
+	for _, t := range []struct {
+		body   string
+		status int
+		err    string
+	}{
+		{`}`, 400, `cannot decode request body into configuration: .*`},
+		{`{}`, 400, `no configuration to import`},
+		{`{"config": {"bar": {"a": 1}}}`, 404, `snap "bar" is not installed`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/config", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+
+		rsp := daemon.ConfigCmd.POST(daemon.ConfigCmd, req, nil).(*daemon.Resp)
+		c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeError, check.Commentf(t.body))
+		c.Check(rsp.Status, check.Equals, t.status, check.Commentf(t.body))
+		c.Check(rsp.Result.(*daemon.ErrorResult).Message, check.Matches, t.err, check.Commentf(t.body))
+	}
+}
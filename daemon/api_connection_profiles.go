@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var connectionProfilesCmd = &Command{
+	Path:     "/v2/interfaces/profiles",
+	UserOK:   true,
+	PolkitOK: "io.snapcraft.snapd.manage-interfaces",
+	GET:      getConnectionProfiles,
+	POST:     changeConnectionProfiles,
+}
+
+// connRefJSON is a connection between a plug and a slot in a
+// connection profile.
+type connRefJSON struct {
+	Plug interfaces.PlugRef `json:"plug"`
+	Slot interfaces.SlotRef `json:"slot"`
+}
+
+// connectionProfileJSON aids in marshalling a connection profile into
+// JSON, keep this in sync with client.ConnectionProfile.
+type connectionProfileJSON struct {
+	Name       string        `json:"name"`
+	Connect    []connRefJSON `json:"connect,omitempty"`
+	Disconnect []connRefJSON `json:"disconnect,omitempty"`
+}
+
+// connectionProfileAction is an action performed on connection
+// profiles.
+type connectionProfileAction struct {
+	connectionProfileJSON
+	Action string `json:"action"`
+}
+
+func connRefsToJSON(crefs []*interfaces.ConnRef) []connRefJSON {
+	var refs []connRefJSON
+	for _, cref := range crefs {
+		refs = append(refs, connRefJSON{Plug: cref.PlugRef, Slot: cref.SlotRef})
+	}
+	return refs
+}
+
+func connRefsFromJSON(refs []connRefJSON) []*interfaces.ConnRef {
+	var crefs []*interfaces.ConnRef
+	for _, ref := range refs {
+		crefs = append(crefs, &interfaces.ConnRef{
+			PlugRef: interfaces.PlugRef{Snap: ifacestate.RemapSnapFromRequest(ref.Plug.Snap), Name: ref.Plug.Name},
+			SlotRef: interfaces.SlotRef{Snap: ifacestate.RemapSnapFromRequest(ref.Slot.Snap), Name: ref.Slot.Name},
+		})
+	}
+	return crefs
+}
+
+func getConnectionProfiles(c *Command, r *http.Request, user *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	profiles, err := ifacestate.ConnectionProfiles(st)
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	profilesJSON := make([]connectionProfileJSON, 0, len(profiles))
+	for _, profile := range profiles {
+		profilesJSON = append(profilesJSON, connectionProfileJSON{
+			Name:       profile.Name,
+			Connect:    connRefsToJSON(profile.Connect),
+			Disconnect: connRefsToJSON(profile.Disconnect),
+		})
+	}
+	return SyncResponse(profilesJSON, nil)
+}
+
+func changeConnectionProfiles(c *Command, r *http.Request, user *auth.UserState) Response {
+	var a connectionProfileAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&a); err != nil {
+		return BadRequest("cannot decode request body into a connection profile action: %v", err)
+	}
+	if a.Action == "" {
+		return BadRequest("connection profile action not specified")
+	}
+	if a.Name == "" {
+		return BadRequest("connection profile name not specified")
+	}
+	if a.Action != "set" && (len(a.Connect) != 0 || len(a.Disconnect) != 0) {
+		return BadRequest("connection profile action %q cannot specify connections", a.Action)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var err error
+	switch a.Action {
+	case "set":
+		err = ifacestate.SetConnectionProfile(st, &ifacestate.ConnectionProfile{
+			Name:       a.Name,
+			Connect:    connRefsFromJSON(a.Connect),
+			Disconnect: connRefsFromJSON(a.Disconnect),
+		})
+	case "remove":
+		err = ifacestate.RemoveConnectionProfile(st, a.Name)
+	case "apply", "revert":
+		return applyConnectionProfile(st, a.Name, a.Action == "revert", auditInitiator(r, user))
+	default:
+		return BadRequest("unsupported connection profile action: %q", a.Action)
+	}
+	if _, ok := err.(*ifacestate.ErrNoConnectionProfile); ok {
+		return NotFound("%v", err)
+	}
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+	return SyncResponse(nil, nil)
+}
+
+func applyConnectionProfile(st *state.State, name string, revert bool, initiator *ifacestate.AuditInitiator) Response {
+	kind, summary := "apply-connection-profile", fmt.Sprintf("Apply connection profile %q", name)
+	if revert {
+		kind, summary = "revert-connection-profile", fmt.Sprintf("Revert connection profile %q", name)
+	}
+
+	tasksets, affected, err := ifacestate.ApplyConnectionProfile(st, name, revert)
+	if _, ok := err.(*ifacestate.ErrNoConnectionProfile); ok {
+		return NotFound("%v", err)
+	}
+	if err != nil {
+		return errToResponse(err, nil, BadRequest, "%v")
+	}
+
+	change := newChange(st, kind, summary, tasksets, affected)
+	setChangeInitiator(change, initiator)
+	if len(tasksets) == 0 {
+		// all the connections are already as wanted
+		change.SetStatus(state.DoneStatus)
+	}
+	st.EnsureBefore(0)
+
+	return AsyncResponse(nil, &Meta{Change: change.ID()})
+}
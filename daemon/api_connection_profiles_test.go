@@ -0,0 +1,186 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *apiSuite) postConnectionProfileAction(c *check.C, action map[string]interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	text, err := json.Marshal(action)
+	c.Assert(err, check.IsNil)
+	req, err := http.NewRequest("POST", "/v2/interfaces/profiles", bytes.NewBuffer(text))
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	connectionProfilesCmd.POST(connectionProfilesCmd, req, nil).ServeHTTP(rec, req)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	return rec, body
+}
+
+func (s *apiSuite) TestConnectionProfilesSetAndGet(c *check.C) {
+	d := s.daemon(c)
+
+	rec, _ := s.postConnectionProfileAction(c, map[string]interface{}{
+		"action": "set",
+		"name":   "kiosk",
+		"connect": []interface{}{
+			map[string]interface{}{
+				"plug": map[string]interface{}{"snap": "consumer", "plug": "plug"},
+				"slot": map[string]interface{}{"snap": "producer", "slot": "slot"},
+			},
+		},
+	})
+	c.Check(rec.Code, check.Equals, 200)
+
+	st := d.overlord.State()
+	st.Lock()
+	profile, err := ifacestate.GetConnectionProfile(st, "kiosk")
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(profile.Connect, check.DeepEquals, []*interfaces.ConnRef{{
+		PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"},
+	}})
+
+	req, err := http.NewRequest("GET", "/v2/interfaces/profiles", nil)
+	c.Assert(err, check.IsNil)
+	rec = httptest.NewRecorder()
+	connectionProfilesCmd.GET(connectionProfilesCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	c.Check(body["result"], check.DeepEquals, []interface{}{
+		map[string]interface{}{
+			"name": "kiosk",
+			"connect": []interface{}{
+				map[string]interface{}{
+					"plug": map[string]interface{}{"snap": "consumer", "plug": "plug"},
+					"slot": map[string]interface{}{"snap": "producer", "slot": "slot"},
+				},
+			},
+		},
+	})
+
+	rec, _ = s.postConnectionProfileAction(c, map[string]interface{}{"action": "remove", "name": "kiosk"})
+	c.Check(rec.Code, check.Equals, 200)
+
+	rec, body = s.postConnectionProfileAction(c, map[string]interface{}{"action": "remove", "name": "kiosk"})
+	c.Check(rec.Code, check.Equals, 404)
+	c.Check(body["result"], check.DeepEquals, map[string]interface{}{
+		"message": `no connection profile named "kiosk"`,
+	})
+}
+
+func (s *apiSuite) TestConnectionProfilesBadRequests(c *check.C) {
+	s.daemon(c)
+
+	for _, t := range []struct {
+		action map[string]interface{}
+		err    string
+	}{
+		{map[string]interface{}{"name": "kiosk"}, `connection profile action not specified`},
+		{map[string]interface{}{"action": "apply"}, `connection profile name not specified`},
+		{map[string]interface{}{"action": "frob", "name": "kiosk"}, `unsupported connection profile action: "frob"`},
+		{map[string]interface{}{"action": "set", "name": "kiosk"}, `connection profile "kiosk" has no connections`},
+		{map[string]interface{}{"action": "apply", "name": "kiosk", "connect": []interface{}{map[string]interface{}{}}}, `connection profile action "apply" cannot specify connections`},
+	} {
+		rec, body := s.postConnectionProfileAction(c, t.action)
+		c.Check(rec.Code, check.Equals, 400)
+		c.Check(body["result"], check.DeepEquals, map[string]interface{}{"message": t.err})
+	}
+
+	rec, _ := s.postConnectionProfileAction(c, map[string]interface{}{"action": "apply", "name": "kiosk"})
+	c.Check(rec.Code, check.Equals, 404)
+}
+
+func (s *apiSuite) TestConnectionProfilesApply(c *check.C) {
+	restore := builtin.MockInterface(&ifacetest.TestInterface{InterfaceName: "test"})
+	defer restore()
+
+	d := s.daemon(c)
+
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	st := d.overlord.State()
+	st.Lock()
+	err := ifacestate.SetConnectionProfile(st, &ifacestate.ConnectionProfile{
+		Name: "kiosk",
+		Connect: []*interfaces.ConnRef{{
+			PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+			SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"},
+		}},
+	})
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	runChange := func(action string) *state.Change {
+		rec, body := s.postConnectionProfileAction(c, map[string]interface{}{"action": action, "name": "kiosk"})
+		c.Assert(rec.Code, check.Equals, 202)
+		st.Lock()
+		chg := st.Change(body["change"].(string))
+		st.Unlock()
+		c.Assert(chg, check.NotNil)
+		<-chg.Ready()
+		st.Lock()
+		defer st.Unlock()
+		c.Assert(chg.Err(), check.IsNil)
+		return chg
+	}
+
+	repo := d.overlord.InterfaceManager().Repository()
+
+	chg := runChange("apply")
+	st.Lock()
+	c.Check(chg.Kind(), check.Equals, "apply-connection-profile")
+	c.Check(chg.Summary(), check.Equals, `Apply connection profile "kiosk"`)
+	st.Unlock()
+	c.Check(repo.Interfaces().Connections, check.DeepEquals, []*interfaces.ConnRef{{
+		PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"},
+	}})
+
+	// applying it again has nothing to do
+	chg = runChange("apply")
+	st.Lock()
+	c.Check(chg.Tasks(), check.HasLen, 0)
+	st.Unlock()
+
+	chg = runChange("revert")
+	st.Lock()
+	c.Check(chg.Kind(), check.Equals, "revert-connection-profile")
+	st.Unlock()
+	c.Check(repo.Interfaces().Connections, check.HasLen, 0)
+}
@@ -154,6 +154,10 @@ func collectConnections(ifaceMgr *ifacestate.InterfaceManager, filter collectFil
 			PlugAttrs: mergeAttrs(cstate.StaticPlugAttrs, cstate.DynamicPlugAttrs),
 			SlotAttrs: mergeAttrs(cstate.StaticSlotAttrs, cstate.DynamicSlotAttrs),
 		}
+		if !cstate.Expiry.IsZero() {
+			expiry := cstate.Expiry
+			cj.Expiry = &expiry
+		}
 		if cstate.Undesired {
 			// explicitly disconnected are always manual
 			cj.Manual = true
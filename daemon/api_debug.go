@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -55,6 +56,29 @@ type ConnectivityStatus struct {
 	Unreachable  []string `json:"unreachable,omitempty"`
 }
 
+type DownloadStats struct {
+	Deltas      store.DeltaStats              `json:"deltas"`
+	Connections store.DownloadConnectionStats `json:"connections"`
+}
+
+// downloadStatser is implemented by stores keeping statistics about
+// their downloads.
+type downloadStatser interface {
+	DeltaStats() store.DeltaStats
+	DownloadConnectionStats() store.DownloadConnectionStats
+}
+
+func getDownloadStats(st *state.State) Response {
+	theStore, ok := snapstate.Store(st, nil).(downloadStatser)
+	if !ok {
+		return InternalError("store does not keep download statistics")
+	}
+	return SyncResponse(DownloadStats{
+		Deltas:      theStore.DeltaStats(),
+		Connections: theStore.DownloadConnectionStats(),
+	}, nil)
+}
+
 func getBaseDeclaration(st *state.State) Response {
 	bd, err := assertstate.BaseDeclaration(st)
 	if err != nil {
@@ -255,6 +279,23 @@ func getDebug(c *Command, r *http.Request, user *auth.UserState) Response {
 		return SyncResponse(map[string]interface{}{
 			"model": string(asserts.Encode(model)),
 		}, nil)
+	case "assertstate":
+		metrics, err := assertstate.CollectMetrics(st)
+		if err != nil {
+			return InternalError("cannot collect assertion metrics: %v", err)
+		}
+		return SyncResponse(metrics, nil)
+	case "refresh-metrics":
+		metrics, err := snapstate.RefreshMetrics(st)
+		if err != nil {
+			return InternalError("cannot get refresh metrics: %v", err)
+		}
+		if metrics == nil {
+			metrics = []*snapstate.RefreshMetric{}
+		}
+		return SyncResponse(metrics, nil)
+	case "download-stats":
+		return getDownloadStats(st)
 	case "change-timings":
 		chgID := query.Get("change-id")
 		ensureTag := query.Get("ensure")
@@ -26,7 +26,11 @@ import (
 
 	"gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/testutil"
 	"github.com/snapcore/snapd/timings"
 )
@@ -157,6 +161,81 @@ func (s *postDebugSuite) TestGetDebugBaseDeclaration(c *check.C) {
 		testutil.Contains, "type: base-declaration")
 }
 
+func (s *postDebugSuite) TestGetDebugAssertstate(c *check.C) {
+	_ = s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=assertstate", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	metrics, ok := rsp.Result.(*assertstate.Metrics)
+	c.Assert(ok, check.Equals, true)
+	c.Check(metrics.Fetches, check.Equals, 0)
+	c.Check(metrics.DBSize["account-key"] > 0, check.Equals, true)
+}
+
+func (s *postDebugSuite) TestGetDebugRefreshMetrics(c *check.C) {
+	d := s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=refresh-metrics", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []*snapstate.RefreshMetric{})
+
+	st := d.overlord.State()
+	st.Lock()
+	st.Set("refresh-metrics", []*snapstate.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true},
+	})
+	st.Unlock()
+
+	rsp = getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []*snapstate.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true},
+	})
+}
+
+type downloadStatsStore struct {
+	snapstate.StoreService
+}
+
+func (downloadStatsStore) DeltaStats() store.DeltaStats {
+	return store.DeltaStats{Hits: 2, Misses: 1, Failures: 1, BytesSaved: 1024}
+}
+
+func (downloadStatsStore) DownloadConnectionStats() store.DownloadConnectionStats {
+	return store.DownloadConnectionStats{New: 1, Reused: 3}
+}
+
+func (s *postDebugSuite) TestGetDebugDownloadStats(c *check.C) {
+	d := s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=download-stats", nil)
+	c.Assert(err, check.IsNil)
+
+	// the fake store keeps no statistics
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 500)
+
+	st := d.overlord.State()
+	st.Lock()
+	snapstate.ReplaceStore(st, downloadStatsStore{snapstate.Store(st, nil)})
+	st.Unlock()
+
+	rsp = getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, DownloadStats{
+		Deltas:      store.DeltaStats{Hits: 2, Misses: 1, Failures: 1, BytesSaved: 1024},
+		Connections: store.DownloadConnectionStats{New: 1, Reused: 3},
+	})
+}
+
 func mockDurationThreshold() func() {
 	oldDurationThreshold := timings.DurationThreshold
 	restore := func() {
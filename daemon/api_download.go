@@ -22,9 +22,14 @@ package daemon
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
+	"github.com/snapcore/snapd/asserts/sysdb"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
 )
 
@@ -38,6 +43,15 @@ var snapDownloadCmd = &Command{
 type snapDownloadAction struct {
 	Action string   `json:"action"`
 	Snaps  []string `json:"snaps,omitempty"`
+
+	// options of the download action
+	Channel   string `json:"channel,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	CohortKey string `json:"cohort-key,omitempty"`
+
+	// SnapSHA3_384 is the digest of the downloaded snap whose
+	// assertions are fetched by the fetch-assertions action
+	SnapSHA3_384 string `json:"snap-sha3-384,omitempty"`
 }
 
 func postSnapDownload(c *Command, r *http.Request, user *auth.UserState) Response {
@@ -65,7 +79,12 @@ func postSnapDownload(c *Command, r *http.Request, user *auth.UserState) Respons
 	switch action.Action {
 	case "download":
 		snapName := action.Snaps[0]
-		return streamOneSnap(c, user, snapName)
+		if action.Channel == "" && action.Revision == "" && action.CohortKey == "" {
+			return streamOneSnap(c, user, snapName)
+		}
+		return streamOneSnapWithOptions(c, user, snapName, &action)
+	case "fetch-assertions":
+		return fetchOneSnapAssertions(c, user, action.SnapSHA3_384)
 	default:
 		return BadRequest("unknown download operation %q", action.Action)
 	}
@@ -80,12 +99,102 @@ func streamOneSnap(c *Command, user *auth.UserState, snapName string) Response {
 	downloadInfo := info.DownloadInfo
 	r, err := getStore(c).DownloadStream(context.TODO(), snapName, &downloadInfo, user)
 	if err != nil {
-		return InternalError(err.Error())
+		return errToResponse(err, []string{snapName}, InternalError, "cannot download snap: %v")
 	}
 
 	return fileStream{
 		SnapName: snapName,
+		Revision: info.Revision,
 		Info:     downloadInfo,
 		stream:   r,
 	}
 }
+
+// streamOneSnapWithOptions streams the snap from the given channel, cohort
+// or revision, as resolved by the store for the device.
+func streamOneSnapWithOptions(c *Command, user *auth.UserState, snapName string, action *snapDownloadAction) Response {
+	var revision snap.Revision
+	if action.Revision != "" {
+		if action.Channel != "" || action.CohortKey != "" {
+			return BadRequest("cannot download a specific revision from a channel or cohort")
+		}
+		var err error
+		revision, err = snap.ParseRevision(action.Revision)
+		if err != nil {
+			return BadRequest("invalid revision: %v", err)
+		}
+	}
+
+	actions := []*store.SnapAction{{
+		Action:       "download",
+		InstanceName: snapName,
+		Channel:      action.Channel,
+		Revision:     revision,
+		CohortKey:    action.CohortKey,
+	}}
+	sto := getStore(c)
+	infos, err := sto.SnapAction(context.TODO(), nil, actions, user, nil)
+	if err != nil {
+		if saErr, ok := err.(*store.SnapActionError); ok && saErr.Download[snapName] != nil {
+			err = saErr.Download[snapName]
+		}
+		return errToResponse(err, []string{snapName}, InternalError, "cannot download snap: %v")
+	}
+	info := infos[0]
+
+	downloadInfo := info.DownloadInfo
+	r, err := sto.DownloadStream(context.TODO(), snapName, &downloadInfo, user)
+	if err != nil {
+		return errToResponse(err, []string{snapName}, InternalError, "cannot download snap: %v")
+	}
+
+	return fileStream{
+		SnapName: snapName,
+		Revision: info.Revision,
+		Info:     downloadInfo,
+		stream:   r,
+	}
+}
+
+// fetchOneSnapAssertions fetches from the store the assertions of the
+// snap with the given digest, checking them against the trusted
+// assertions, without adding them to the system assertion database.
+func fetchOneSnapAssertions(c *Command, user *auth.UserState, sha3_384 string) Response {
+	if sha3_384 == "" {
+		return BadRequest("fetch-assertions operation requires the snap-sha3-384 of the snap")
+	}
+
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+		Trusted:   sysdb.Trusted(),
+	})
+	if err != nil {
+		return InternalError("cannot open assertion database: %v", err)
+	}
+
+	sto := getStore(c)
+	var assertions []asserts.Assertion
+	retrieve := func(ref *asserts.Ref) (asserts.Assertion, error) {
+		return sto.Assertion(ref.Type, ref.PrimaryKey, user)
+	}
+	save := func(a asserts.Assertion) error {
+		// for checking
+		if err := db.Add(a); err != nil {
+			if _, ok := err.(*asserts.RevisionError); ok {
+				return nil
+			}
+			return fmt.Errorf("cannot add assertion %v: %v", a.Ref(), err)
+		}
+		assertions = append(assertions, a)
+		return nil
+	}
+	f := asserts.NewFetcher(db, retrieve, save)
+	if err := snapasserts.FetchSnapAssertions(f, sha3_384); err != nil {
+		if asserts.IsNotFound(err) {
+			return NotFound("cannot find assertions of snap with digest %q", sha3_384)
+		}
+		return InternalError("cannot fetch assertions of snap: %v", err)
+	}
+
+	return AssertResponse(assertions, true)
+}
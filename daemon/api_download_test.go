@@ -28,9 +28,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/asserts/sysdb"
 	"github.com/snapcore/snapd/daemon"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord"
@@ -49,11 +53,18 @@ type snapDownloadSuite struct {
 	storetest.Store
 	d *daemon.Daemon
 
-	snaps []string
+	snaps        []string
+	actions      []*store.SnapAction
+	storeSigning *assertstest.StoreStack
+	restore      func()
 }
 
 func (s *snapDownloadSuite) SetUpTest(c *check.C) {
 	s.snaps = nil
+	s.actions = nil
+
+	s.storeSigning = assertstest.NewStoreStack("can0nical", nil)
+	s.restore = sysdb.InjectTrusted(s.storeSigning.Trusted)
 
 	o := overlord.Mock()
 	s.d = daemon.NewWithOverlord(o)
@@ -76,6 +87,13 @@ func (s *snapDownloadSuite) SnapInfo(ctx context.Context, spec store.SnapSpec, u
 				AnonDownloadURL: "http://localhost/bar",
 			},
 		}, nil
+	case "private-snap":
+		return &snap.Info{
+			DownloadInfo: snap.DownloadInfo{
+				Size:        100,
+				DownloadURL: "http://localhost/private-snap",
+			},
+		}, nil
 	case "download-error-trigger-snap":
 		return &snap.Info{
 			DownloadInfo: snap.DownloadInfo{
@@ -88,10 +106,38 @@ func (s *snapDownloadSuite) SnapInfo(ctx context.Context, spec store.SnapSpec, u
 	}
 }
 
+func (s *snapDownloadSuite) TearDownTest(c *check.C) {
+	s.restore()
+	dirs.SetRootDir("")
+}
+
+func (s *snapDownloadSuite) SnapAction(ctx context.Context, currentSnaps []*store.CurrentSnap, actions []*store.SnapAction, user *auth.UserState, opts *store.RefreshOptions) ([]*snap.Info, error) {
+	s.actions = append(s.actions, actions...)
+	if len(actions) != 1 || actions[0].InstanceName != "bar" {
+		return nil, &store.SnapActionError{Download: map[string]error{actions[0].InstanceName: store.ErrSnapNotFound}}
+	}
+	return []*snap.Info{{
+		SideInfo: snap.SideInfo{Revision: snap.R(7)},
+		DownloadInfo: snap.DownloadInfo{
+			Size:            int64(len(content)),
+			AnonDownloadURL: "http://localhost/bar",
+			Sha3_384:        "bar-sha3-384",
+		},
+	}}, nil
+}
+
+func (s *snapDownloadSuite) Assertion(assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState) (asserts.Assertion, error) {
+	ref := &asserts.Ref{Type: assertType, PrimaryKey: primaryKey}
+	return ref.Resolve(s.storeSigning.Find)
+}
+
 func (s *snapDownloadSuite) DownloadStream(ctx context.Context, name string, downloadInfo *snap.DownloadInfo, user *auth.UserState) (io.ReadCloser, error) {
 	if name == "bar" {
 		return ioutil.NopCloser(bytes.NewReader([]byte(content))), nil
 	}
+	if name == "private-snap" {
+		return nil, &store.NoEntitlementError{Snap: name}
+	}
 	return nil, fmt.Errorf("unexpected error")
 }
 
@@ -162,7 +208,7 @@ func (s *snapDownloadSuite) TestStreamOneSnap(c *check.C) {
 		{
 			dataJSON: `{"action": "download", "snaps": ["download-error-trigger-snap"]}`,
 			status:   500,
-			err:      "unexpected error",
+			err:      "cannot download snap: unexpected error",
 		},
 		{
 			dataJSON: `{"action": "download", "snaps": ["bar"]}`,
@@ -195,3 +241,117 @@ func (s *snapDownloadSuite) TestStreamOneSnap(c *check.C) {
 		}
 	}
 }
+
+func (s *snapDownloadSuite) TestStreamOneSnapNoEntitlement(c *check.C) {
+	req, err := http.NewRequest("POST", "/v2/download", strings.NewReader(`{"action": "download", "snaps": ["private-snap"]}`))
+	c.Assert(err, check.IsNil)
+	rsp := daemon.SnapDownloadCmd.POST(daemon.SnapDownloadCmd, req, nil)
+
+	c.Assert(rsp.(*daemon.Resp).Status, check.Equals, 400)
+	result := rsp.(*daemon.Resp).Result.(*daemon.ErrorResult)
+	c.Check(result.Kind, check.Equals, daemon.ErrorKindSnapNoEntitlement)
+	c.Check(result.Value, check.Equals, "private-snap")
+	c.Check(result.Message, check.Matches, `no entitlement to snap "private-snap": .*`)
+}
+
+func (s *snapDownloadSuite) TestStreamOneSnapWithOptions(c *check.C) {
+	req, err := http.NewRequest("POST", "/v2/download", strings.NewReader(`{"action": "download", "snaps": ["bar"], "channel": "edge", "cohort-key": "some-cohort"}`))
+	c.Assert(err, check.IsNil)
+	rsp := daemon.SnapDownloadCmd.POST(daemon.SnapDownloadCmd, req, nil)
+
+	c.Assert(rsp, check.FitsTypeOf, daemon.FileStream{})
+	c.Check(s.actions, check.DeepEquals, []*store.SnapAction{{
+		Action:       "download",
+		InstanceName: "bar",
+		Channel:      "edge",
+		CohortKey:    "some-cohort",
+	}})
+
+	w := httptest.NewRecorder()
+	rsp.(daemon.FileStream).ServeHTTP(w, nil)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Check(w.Header().Get("Snap-Revision"), check.Equals, "7")
+	c.Check(w.Header().Get("Snap-Sha3-384"), check.Equals, "bar-sha3-384")
+	c.Check(w.Body.String(), check.Equals, "SNAP")
+}
+
+func (s *snapDownloadSuite) TestStreamOneSnapWithOptionsErrors(c *check.C) {
+	for _, t := range []struct {
+		dataJSON string
+		status   int
+		err      string
+	}{
+		{`{"action": "download", "snaps": ["bar"], "revision": "7", "channel": "edge"}`, 400, "cannot download a specific revision from a channel or cohort"},
+		{`{"action": "download", "snaps": ["bar"], "revision": "potato"}`, 400, "invalid revision: .*"},
+		{`{"action": "download", "snaps": ["doom"], "revision": "7"}`, 404, `snap not found`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/download", strings.NewReader(t.dataJSON))
+		c.Assert(err, check.IsNil)
+		rsp := daemon.SnapDownloadCmd.POST(daemon.SnapDownloadCmd, req, nil)
+		c.Assert(rsp.(*daemon.Resp).Status, check.Equals, t.status, check.Commentf(t.dataJSON))
+		c.Check(rsp.(*daemon.Resp).Result.(*daemon.ErrorResult).Message, check.Matches, t.err)
+	}
+}
+
+func (s *snapDownloadSuite) TestFetchSnapAssertions(c *check.C) {
+	dev := assertstest.NewAccount(s.storeSigning, "developer1", nil, "")
+	c.Assert(s.storeSigning.Add(dev), check.IsNil)
+	decl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      "bar-id",
+		"snap-name":    "bar",
+		"publisher-id": dev.AccountID(),
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.storeSigning.Add(decl), check.IsNil)
+	digest := strings.Repeat("a", 64)
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": digest,
+		"snap-size":     "4",
+		"snap-id":       "bar-id",
+		"snap-revision": "7",
+		"developer-id":  dev.AccountID(),
+		"timestamp":     time.Now().UTC().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.storeSigning.Add(snapRev), check.IsNil)
+
+	req, err := http.NewRequest("POST", "/v2/download", strings.NewReader(fmt.Sprintf(`{"action": "fetch-assertions", "snaps": ["bar"], "snap-sha3-384": %q}`, digest)))
+	c.Assert(err, check.IsNil)
+	rsp := daemon.SnapDownloadCmd.POST(daemon.SnapDownloadCmd, req, nil)
+
+	w := httptest.NewRecorder()
+	rsp.ServeHTTP(w, nil)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Check(w.Header().Get("Content-Type"), check.Equals, "application/x.ubuntu.assertion; bundle=y")
+
+	dec := asserts.NewDecoder(w.Body)
+	var types []string
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		types = append(types, a.Type().Name)
+	}
+	c.Check(types, check.DeepEquals, []string{"account-key", "account", "snap-declaration", "snap-revision"})
+}
+
+func (s *snapDownloadSuite) TestFetchSnapAssertionsErrors(c *check.C) {
+	for _, t := range []struct {
+		dataJSON string
+		status   int
+		err      string
+	}{
+		{`{"action": "fetch-assertions", "snaps": ["bar"]}`, 400, "fetch-assertions operation requires the snap-sha3-384 of the snap"},
+		{`{"action": "fetch-assertions", "snaps": ["bar"], "snap-sha3-384": "missing"}`, 404, `cannot find assertions of snap with digest "missing"`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/download", strings.NewReader(t.dataJSON))
+		c.Assert(err, check.IsNil)
+		rsp := daemon.SnapDownloadCmd.POST(daemon.SnapDownloadCmd, req, nil)
+		c.Assert(rsp.(*daemon.Resp).Status, check.Equals, t.status, check.Commentf(t.dataJSON))
+		c.Check(rsp.(*daemon.Resp).Result.(*daemon.ErrorResult).Message, check.Matches, t.err)
+	}
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+)
+
+var factoryResetCmd = &Command{
+	Path: "/v2/factory-reset",
+	POST: postFactoryReset,
+}
+
+var devicestateFactoryReset = devicestate.FactoryReset
+
+type postFactoryResetData struct {
+	// Identity is either "keep" (the default) or "regenerate"
+	Identity string `json:"identity"`
+}
+
+func postFactoryReset(c *Command, r *http.Request, _ *auth.UserState) Response {
+	defer r.Body.Close()
+	var data postFactoryResetData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode request body into factory reset operation: %v", err)
+	}
+
+	var opts devicestate.FactoryResetOptions
+	switch data.Identity {
+	case "", "keep":
+	case "regenerate":
+		opts.RegenerateIdentity = true
+	default:
+		return BadRequest("invalid identity policy %q", data.Identity)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	chg, err := devicestateFactoryReset(st, &opts)
+	if err != nil {
+		return BadRequest("cannot factory reset device: %v", err)
+	}
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *apiSuite) TestPostFactoryResetUnhappy(c *check.C) {
+	for _, t := range []struct {
+		body, err string
+	}{
+		{`not json`, "cannot decode request body into factory reset operation: .*"},
+		{`{"identity":"forget"}`, `invalid identity policy "forget"`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/factory-reset", bytes.NewBufferString(t.body))
+		c.Assert(err, check.IsNil)
+		rsp := postFactoryReset(factoryResetCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+}
+
+func (s *apiSuite) testPostFactoryReset(c *check.C, body string, expectedOpts *devicestate.FactoryResetOptions) {
+	d := s.daemonWithOverlordMock(c)
+	st := d.overlord.State()
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+	defer func() { ensureStateSoon = func(st *state.State) {} }()
+
+	var gotOpts *devicestate.FactoryResetOptions
+	devicestateFactoryReset = func(st *state.State, opts *devicestate.FactoryResetOptions) (*state.Change, error) {
+		gotOpts = opts
+		return st.NewChange("factory-reset", "..."), nil
+	}
+	defer func() { devicestateFactoryReset = devicestate.FactoryReset }()
+
+	req, err := http.NewRequest("POST", "/v2/factory-reset", bytes.NewBufferString(body))
+	c.Assert(err, check.IsNil)
+	rsp := postFactoryReset(factoryResetCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Check(gotOpts, check.DeepEquals, expectedOpts)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "factory-reset")
+
+	c.Check(soon, check.Equals, 1)
+}
+
+func (s *apiSuite) TestPostFactoryResetDefaultIdentity(c *check.C) {
+	s.testPostFactoryReset(c, `{}`, &devicestate.FactoryResetOptions{})
+}
+
+func (s *apiSuite) TestPostFactoryResetKeepIdentity(c *check.C) {
+	s.testPostFactoryReset(c, `{"identity":"keep"}`, &devicestate.FactoryResetOptions{})
+}
+
+func (s *apiSuite) TestPostFactoryResetRegenerateIdentity(c *check.C) {
+	s.testPostFactoryReset(c, `{"identity":"regenerate"}`, &devicestate.FactoryResetOptions{RegenerateIdentity: true})
+}
+
+func (s *apiSuite) TestPostFactoryResetError(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	devicestateFactoryReset = func(st *state.State, opts *devicestate.FactoryResetOptions) (*state.Change, error) {
+		return nil, errors.New("cannot factory reset until fully seeded")
+	}
+	defer func() { devicestateFactoryReset = devicestate.FactoryReset }()
+
+	req, err := http.NewRequest("POST", "/v2/factory-reset", bytes.NewBufferString(`{}`))
+	c.Assert(err, check.IsNil)
+	rsp := postFactoryReset(factoryResetCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "cannot factory reset device: cannot factory reset until fully seeded")
+}
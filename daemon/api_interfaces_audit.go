@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var interfacesAuditCmd = &Command{
+	Path: "/v2/interfaces/audit",
+	GET:  getInterfacesAudit,
+}
+
+func getInterfacesAudit(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+	filter := &ifacestate.AuditFilter{
+		Snap:      query.Get("snap"),
+		Interface: query.Get("interface"),
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return BadRequest("invalid since parameter: %v", err)
+		}
+		filter.Since = t
+	}
+
+	entries, err := ifacestate.AuditLog(filter)
+	if err != nil {
+		return InternalError("cannot read the interfaces audit log: %v", err)
+	}
+	if entries == nil {
+		entries = []*ifacestate.AuditEntry{}
+	}
+	return SyncResponse(entries, nil)
+}
+
+// auditInitiator returns who is making the request, for the interfaces
+// audit log.
+func auditInitiator(r *http.Request, user *auth.UserState) *ifacestate.AuditInitiator {
+	_, uid, _, err := ucrednetGet(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	initiator := &ifacestate.AuditInitiator{UID: uid}
+	if user != nil {
+		initiator.Username = user.Username
+	}
+	return initiator
+}
+
+// setChangeInitiator attributes the connections and disconnections done
+// by the change to whoever made the request.
+func setChangeInitiator(chg *state.Change, initiator *ifacestate.AuditInitiator) {
+	if initiator != nil {
+		ifacestate.SetChangeInitiator(chg, initiator)
+	}
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+)
+
+func (s *apiSuite) getInterfacesAudit(c *check.C, query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest("GET", "/v2/interfaces/audit"+query, nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	interfacesAuditCmd.GET(interfacesAuditCmd, req, nil).ServeHTTP(rec, req)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	return rec, body
+}
+
+func (s *apiSuite) TestInterfacesAuditEmpty(c *check.C) {
+	s.daemon(c)
+
+	rec, body := s.getInterfacesAudit(c, "")
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(body["result"], check.DeepEquals, []interface{}{})
+}
+
+func (s *apiSuite) TestInterfacesAuditFilters(c *check.C) {
+	s.daemon(c)
+
+	auditLog := `{"time":"2020-06-01T10:00:00Z","action":"connect","interface":"camera","plug":{"snap":"kiosk","plug":"camera"},"slot":{"snap":"core","slot":"camera"},"initiator":{"uid":1000,"username":"admin"}}
+{"time":"2020-06-01T11:00:00Z","action":"connect","interface":"network","plug":{"snap":"kiosk","plug":"network"},"slot":{"snap":"core","slot":"network"},"auto":true}
+{"time":"2020-06-02T10:00:00Z","action":"disconnect","interface":"camera","plug":{"snap":"kiosk","plug":"camera"},"slot":{"snap":"core","slot":"camera"}}
+`
+	c.Assert(os.MkdirAll(filepath.Dir(dirs.SnapInterfacesAuditFile), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(dirs.SnapInterfacesAuditFile, []byte(auditLog), 0600), check.IsNil)
+
+	actions := func(result interface{}) []string {
+		var actions []string
+		for _, e := range result.([]interface{}) {
+			entry := e.(map[string]interface{})
+			actions = append(actions, entry["action"].(string)+" "+entry["interface"].(string))
+		}
+		return actions
+	}
+
+	rec, body := s.getInterfacesAudit(c, "")
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(actions(body["result"]), check.DeepEquals, []string{"connect camera", "connect network", "disconnect camera"})
+	first := body["result"].([]interface{})[0].(map[string]interface{})
+	c.Check(first["initiator"], check.DeepEquals, map[string]interface{}{"uid": 1000.0, "username": "admin"})
+
+	_, body = s.getInterfacesAudit(c, "?interface=camera")
+	c.Check(actions(body["result"]), check.DeepEquals, []string{"connect camera", "disconnect camera"})
+
+	_, body = s.getInterfacesAudit(c, "?snap=kiosk&since=2020-06-01T10:30:00Z")
+	c.Check(actions(body["result"]), check.DeepEquals, []string{"connect network", "disconnect camera"})
+
+	_, body = s.getInterfacesAudit(c, "?snap=other")
+	c.Check(body["result"], check.DeepEquals, []interface{}{})
+
+	rec, body = s.getInterfacesAudit(c, "?since=yesterday")
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(body["result"].(map[string]interface{})["message"], check.Matches, "invalid since parameter: .*")
+}
+
+func (s *apiSuite) TestConnectRecordsInitiator(c *check.C) {
+	restore := builtin.MockInterface(&ifacetest.TestInterface{InterfaceName: "test"})
+	defer restore()
+
+	d := s.daemon(c)
+
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	action := &interfaceAction{
+		Action: "connect",
+		Plugs:  []plugJSON{{Snap: "consumer", Name: "plug"}},
+		Slots:  []slotJSON{{Snap: "producer", Name: "slot"}},
+	}
+	text, err := json.Marshal(action)
+	c.Assert(err, check.IsNil)
+	req, err := http.NewRequest("POST", "/v2/interfaces", bytes.NewBuffer(text))
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "pid=100;uid=1000;socket=;"
+	rec := httptest.NewRecorder()
+	user := &auth.UserState{ID: 1, Username: "admin"}
+	interfacesCmd.POST(interfacesCmd, req, user).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 202)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	id := body["change"].(string)
+
+	st := d.overlord.State()
+	st.Lock()
+	chg := st.Change(id)
+	st.Unlock()
+	c.Assert(chg, check.NotNil)
+
+	<-chg.Ready()
+
+	st.Lock()
+	err = chg.Err()
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	entries, err := ifacestate.AuditLog(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Check(entries[0].Action, check.Equals, "connect")
+	c.Check(entries[0].Change, check.Equals, id)
+	c.Check(entries[0].Initiator, check.DeepEquals, &ifacestate.AuditInitiator{UID: 1000, Username: "admin"})
+}
@@ -20,6 +20,8 @@
 package daemon
 
 import (
+	"time"
+
 	"github.com/snapcore/snapd/interfaces"
 )
 
@@ -61,6 +63,9 @@ type interfaceAction struct {
 	Action string     `json:"action"`
 	Plugs  []plugJSON `json:"plugs,omitempty"`
 	Slots  []slotJSON `json:"slots,omitempty"`
+	// Duration, if set, makes a connection temporary; it is
+	// disconnected again once the duration has passed.
+	Duration string `json:"duration,omitempty"`
 }
 
 // connectionsJSON aids in marshalling information about a single connection
@@ -73,6 +78,7 @@ type connectionJSON struct {
 	Gadget    bool                   `json:"gadget,omitempty"`
 	SlotAttrs map[string]interface{} `json:"slot-attrs,omitempty"`
 	PlugAttrs map[string]interface{} `json:"plug-attrs,omitempty"`
+	Expiry    *time.Time             `json:"expiry,omitempty"`
 }
 
 // legacyConnectionsJSON aids in marshaling legacy connections into JSON.
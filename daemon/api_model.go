@@ -26,12 +26,20 @@ import (
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
 )
 
 var modelCmd = &Command{
-	Path: "/v2/model",
-	POST: postModel,
-	// TODO: provide GET here too once we decided on the details of the API
+	Path:   "/v2/model",
+	UserOK: true,
+	GET:    getModel,
+	POST:   postModel,
+}
+
+var serialModelCmd = &Command{
+	Path:   "/v2/model/serial",
+	UserOK: true,
+	GET:    getSerial,
 }
 
 var devicestateRemodel = devicestate.Remodel
@@ -69,3 +77,33 @@ func postModel(c *Command, r *http.Request, _ *auth.UserState) Response {
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
 
 }
+
+func getModel(c *Command, r *http.Request, _ *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	model, err := c.d.overlord.DeviceManager().Model()
+	if err == state.ErrNoState {
+		return NotFound("no model assertion yet")
+	}
+	if err != nil {
+		return InternalError("cannot get model: %v", err)
+	}
+	return AssertResponse([]asserts.Assertion{model}, false)
+}
+
+func getSerial(c *Command, r *http.Request, _ *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	serial, err := c.d.overlord.DeviceManager().Serial()
+	if err == state.ErrNoState {
+		return NotFound("no serial assertion yet")
+	}
+	if err != nil {
+		return InternalError("cannot get serial: %v", err)
+	}
+	return AssertResponse([]asserts.Assertion{serial}, false)
+}
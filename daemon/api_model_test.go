@@ -23,10 +23,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"time"
 
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
 	"github.com/snapcore/snapd/overlord/assertstate/assertstatetest"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate"
@@ -105,3 +108,74 @@ func (s *apiSuite) TestPostRemodel(c *check.C) {
 
 	c.Assert(soon, check.Equals, 1)
 }
+
+func (s *apiSuite) daemonWithDeviceMgr(c *check.C) *Daemon {
+	d := s.daemonWithOverlordMock(c)
+	hookMgr, err := hookstate.Manager(d.overlord.State(), d.overlord.TaskRunner())
+	c.Assert(err, check.IsNil)
+	deviceMgr, err := devicestate.Manager(d.overlord.State(), hookMgr, d.overlord.TaskRunner(), nil)
+	c.Assert(err, check.IsNil)
+	d.overlord.AddManager(deviceMgr)
+	return d
+}
+
+func (s *apiSuite) TestGetModelNoModel(c *check.C) {
+	s.daemonWithDeviceMgr(c)
+
+	req, err := http.NewRequest("GET", "/v2/model", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getModel(modelCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 404)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "no model assertion yet")
+
+	req, err = http.NewRequest("GET", "/v2/model/serial", nil)
+	c.Assert(err, check.IsNil)
+	rsp = getSerial(serialModelCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 404)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "no serial assertion yet")
+}
+
+func (s *apiSuite) TestGetModelAndSerial(c *check.C) {
+	d := s.daemonWithDeviceMgr(c)
+	model := s.brands.Model("my-brand", "my-model", modelDefaults)
+
+	st := d.overlord.State()
+	st.Lock()
+	assertstatetest.AddMany(st, s.storeSigning.StoreAccountKey(""))
+	assertstatetest.AddMany(st, s.brands.AccountsAndKeys("my-brand")...)
+	s.mockModel(c, st, model)
+	devKey, _ := assertstest.GenerateKey(752)
+	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
+	c.Assert(err, check.IsNil)
+	serial, err := s.brands.Signing("my-brand").Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            "my-brand",
+		"model":               "my-model",
+		"serial":              "serialserial",
+		"device-key":          string(encDevKey),
+		"device-key-sha3-384": devKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	assertstatetest.AddMany(st, serial)
+	st.Unlock()
+
+	for _, t := range []struct {
+		cmd *Command
+		a   asserts.Assertion
+	}{
+		{modelCmd, model},
+		{serialModelCmd, serial},
+	} {
+		req, err := http.NewRequest("GET", t.cmd.Path, nil)
+		c.Assert(err, check.IsNil)
+		rec := httptest.NewRecorder()
+		t.cmd.GET(t.cmd, req, nil).ServeHTTP(rec, req)
+		c.Assert(rec.Code, check.Equals, 200, check.Commentf(t.cmd.Path))
+		c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, asserts.MediaType)
+
+		a, err := asserts.NewDecoder(rec.Body).Decode()
+		c.Assert(err, check.IsNil)
+		c.Check(asserts.Encode(a), check.DeepEquals, asserts.Encode(t.a))
+	}
+}
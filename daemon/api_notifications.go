@@ -0,0 +1,147 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/strutil"
+)
+
+var notificationsCmd = &Command{
+	Path:   "/v2/notifications",
+	UserOK: true,
+	GET:    getNotifications,
+}
+
+// the types of notifications that can be asked for
+var notificationTypes = []string{
+	"change-update",
+	"warning",
+	"refresh-start",
+	"refresh-finish",
+	"connection",
+}
+
+// how many notifications can be queued for a client before it's
+// considered too slow and the stream is ended
+var notificationsBufferSize = 100
+
+func getNotifications(c *Command, r *http.Request, user *auth.UserState) Response {
+	types := strutil.CommaSeparatedList(r.URL.Query().Get("types"))
+	for _, typ := range types {
+		if !strutil.ListContains(notificationTypes, typ) {
+			return BadRequest("invalid notification type %q", typ)
+		}
+	}
+
+	return &notificationSeqResponse{
+		st:    c.d.overlord.State(),
+		types: types,
+		dying: c.d.Dying(),
+	}
+}
+
+// A notificationSeqResponse's ServeHTTP method streams the notifications
+// of the state, of the given types or of any type if none are given, as
+// a json-seq (RFC7464) until the client goes away.
+type notificationSeqResponse struct {
+	st    *state.State
+	types []string
+	dying <-chan struct{}
+}
+
+func (nr *notificationSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan *state.Notification, notificationsBufferSize)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+
+	nr.st.Lock()
+	id := nr.st.AddObserver(func(n *state.Notification) {
+		if len(nr.types) > 0 && !strutil.ListContains(nr.types, n.Type) {
+			return
+		}
+		// the state is locked, never block here
+		select {
+		case ch <- n:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	nr.st.Unlock()
+	defer func() {
+		nr.st.Lock()
+		nr.st.RemoveObserver(id)
+		nr.st.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json-seq")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, hasFlusher := w.(http.Flusher)
+	writer := bufio.NewWriter(w)
+	enc := json.NewEncoder(writer)
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if hasFlusher {
+			flusher.Flush()
+		}
+		return nil
+	}
+	if err := flush(); err != nil {
+		logger.Noticef("cannot stream notifications: %v", err)
+		return
+	}
+
+	for {
+		var v interface{}
+		select {
+		case n := <-ch:
+			v = n
+		case <-overflow:
+			v = map[string]string{"error": "cannot keep up with notifications, client too slow"}
+		case <-r.Context().Done():
+			return
+		case <-nr.dying:
+			return
+		}
+
+		writer.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
+		if err := enc.Encode(v); err != nil {
+			logger.Noticef("cannot stream notifications: %v", err)
+			return
+		}
+		if err := flush(); err != nil {
+			logger.Noticef("cannot stream notifications: %v", err)
+			return
+		}
+		if _, isNotification := v.(*state.Notification); !isNotification {
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var _ = check.Suite(&notificationsSuite{})
+
+type notificationsSuite struct {
+	d  *daemon.Daemon
+	st *state.State
+}
+
+func (s *notificationsSuite) SetUpTest(c *check.C) {
+	o := overlord.Mock()
+	s.d = daemon.NewWithOverlord(o)
+	s.st = o.State()
+}
+
+// flushRecorder hands over what was written to the response on each flush
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed <- r.Body.String()
+	r.Body.Reset()
+}
+
+func (s *notificationsSuite) serve(c *check.C, query string) (rec *flushRecorder, cancel func(), done chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", "/v2/notifications"+query, nil)
+	c.Assert(err, check.IsNil)
+	req = req.WithContext(ctx)
+
+	rsp := daemon.NotificationsCmd.GET(daemon.NotificationsCmd, req, nil)
+	rec = &flushRecorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		flushed:          make(chan string),
+	}
+	done = make(chan struct{})
+	go func() {
+		rsp.ServeHTTP(rec, req)
+		close(done)
+	}()
+	// the stream is set up once the headers are flushed
+	c.Assert(<-rec.flushed, check.Equals, "")
+	c.Check(rec.Header().Get("Content-Type"), check.Equals, "application/json-seq")
+	return rec, cancel, done
+}
+
+func decodeNotification(c *check.C, data string) map[string]interface{} {
+	c.Assert(strings.HasPrefix(data, "\x1e"), check.Equals, true)
+	var v map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(data[1:]), &v), check.IsNil)
+	return v
+}
+
+func (s *notificationsSuite) TestNotifications(c *check.C) {
+	rec, cancel, done := s.serve(c, "?types=warning,change-update")
+
+	s.st.Lock()
+	// not asked for
+	s.st.Notify("connection", nil)
+	s.st.Warnf("hello")
+	chg := s.st.NewChange("install", "...")
+	t := s.st.NewTask("download", "...")
+	chg.AddTask(t)
+	s.st.Unlock()
+
+	n := decodeNotification(c, <-rec.flushed)
+	c.Check(n["type"], check.Equals, "warning")
+	c.Check(n["data"].(map[string]interface{})["message"], check.Equals, "hello")
+
+	s.st.Lock()
+	t.SetStatus(state.DoneStatus)
+	s.st.Unlock()
+
+	n = decodeNotification(c, <-rec.flushed)
+	c.Check(n["type"], check.Equals, "change-update")
+	c.Check(n["data"], check.DeepEquals, map[string]interface{}{
+		"id":     chg.ID(),
+		"kind":   "install",
+		"status": "Done",
+		"ready":  true,
+	})
+
+	cancel()
+	<-done
+
+	// the observer is gone with the client
+	s.st.Lock()
+	s.st.Warnf("hello again")
+	s.st.Unlock()
+}
+
+func (s *notificationsSuite) TestNotificationsTooSlow(c *check.C) {
+	restore := daemon.MockNotificationsBufferSize(1)
+	defer restore()
+
+	rec, cancel, done := s.serve(c, "")
+	defer cancel()
+
+	s.st.Lock()
+	for i := 0; i < 10; i++ {
+		s.st.Notify("connection", nil)
+	}
+	s.st.Unlock()
+
+	// the first notification and/or the error, after which the stream ends
+	var last map[string]interface{}
+	for last == nil || last["error"] == nil {
+		last = decodeNotification(c, <-rec.flushed)
+	}
+	c.Check(last["error"], check.Equals, "cannot keep up with notifications, client too slow")
+	<-done
+}
+
+func (s *notificationsSuite) TestNotificationsInvalidType(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/notifications?types=warning,foo", nil)
+	c.Assert(err, check.IsNil)
+	rsp := daemon.NotificationsCmd.GET(daemon.NotificationsCmd, req, nil).(*daemon.Resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*daemon.ErrorResult).Message, check.Equals, `invalid notification type "foo"`)
+}
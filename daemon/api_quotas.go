@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var quotaGroupsCmd = &Command{
+	Path:   "/v2/quotas",
+	UserOK: true,
+	GET:    getQuotaGroups,
+	POST:   postQuotaGroup,
+}
+
+type postQuotaGroupData struct {
+	Action      string              `json:"action"`
+	GroupName   string              `json:"group-name"`
+	Parent      string              `json:"parent,omitempty"`
+	Snaps       []string            `json:"snaps,omitempty"`
+	Constraints *client.QuotaValues `json:"constraints,omitempty"`
+}
+
+func getQuotaGroups(c *Command, r *http.Request, user *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	groups, err := servicestate.AllQuotas(st)
+	if err != nil {
+		return InternalError(err.Error())
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]client.QuotaGroupResult, 0, len(groups))
+	for _, name := range names {
+		grp := groups[name]
+		results = append(results, client.QuotaGroupResult{
+			GroupName: grp.Name,
+			Parent:    grp.ParentGroup,
+			SubGroups: grp.SubGroups,
+			Snaps:     grp.Snaps,
+			Constraints: &client.QuotaValues{
+				MemoryLimit:   grp.MemoryLimit,
+				CPUPercentage: grp.CPUPercentage,
+				IOWeight:      grp.IOWeight,
+			},
+		})
+	}
+	return SyncResponse(results, nil)
+}
+
+func postQuotaGroup(c *Command, r *http.Request, user *auth.UserState) Response {
+	var data postQuotaGroupData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode quota action from request body: %v", err)
+	}
+	if data.GroupName == "" {
+		return BadRequest("quota group name is required")
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var ts *state.TaskSet
+	var err error
+	switch data.Action {
+	case "ensure":
+		var groups map[string]*servicestate.QuotaGroup
+		groups, err = servicestate.AllQuotas(st)
+		if err != nil {
+			return InternalError(err.Error())
+		}
+		var resources *servicestate.QuotaResources
+		if data.Constraints != nil {
+			resources = &servicestate.QuotaResources{
+				MemoryLimit:   data.Constraints.MemoryLimit,
+				CPUPercentage: data.Constraints.CPUPercentage,
+				IOWeight:      data.Constraints.IOWeight,
+			}
+		}
+		grp := groups[data.GroupName]
+		if grp == nil {
+			if resources == nil {
+				resources = &servicestate.QuotaResources{}
+			}
+			ts, err = servicestate.CreateQuota(st, data.GroupName, data.Parent, data.Snaps, *resources)
+		} else {
+			if data.Parent != "" && data.Parent != grp.ParentGroup {
+				return BadRequest("cannot change the parent group of quota group %q", data.GroupName)
+			}
+			ts, err = servicestate.UpdateQuota(st, data.GroupName, servicestate.QuotaGroupUpdate{
+				AddSnaps:     data.Snaps,
+				NewResources: resources,
+			})
+		}
+	case "remove":
+		ts, err = servicestate.RemoveQuota(st, data.GroupName)
+	default:
+		return BadRequest("unknown quota action %q", data.Action)
+	}
+	if err != nil {
+		if cce, ok := err.(*snapstate.ChangeConflictError); ok {
+			return SnapChangeConflict(cce)
+		}
+		return BadRequest("%v", err)
+	}
+
+	chg := newChange(st, "quota-control", ts.Tasks()[0].Summary(), []*state.TaskSet{ts}, data.Snaps)
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
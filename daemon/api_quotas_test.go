@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon_test
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/cgroup"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+)
+
+var _ = check.Suite(&quotaSuite{})
+
+type quotaSuite struct {
+	d     *daemon.Daemon
+	o     *overlord.Overlord
+	st    *state.State
+	rests []func()
+}
+
+func (s *quotaSuite) SetUpTest(c *check.C) {
+	dirs.SetRootDir(c.MkDir())
+	s.rests = []func(){
+		func() { dirs.SetRootDir("") },
+		cgroup.MockVersion(cgroup.V2, nil),
+		systemd.MockSystemctl(func(cmd ...string) ([]byte, error) { return nil, nil }),
+	}
+
+	o := overlord.Mock()
+	s.o = o
+	s.d = daemon.NewWithOverlord(o)
+	s.st = o.State()
+	runner := o.TaskRunner()
+	o.AddManager(servicestate.Manager(s.st, runner))
+	o.AddManager(runner)
+	c.Assert(o.StartUp(), check.IsNil)
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	si := &snap.SideInfo{RealName: "foo", Revision: snap.R(1)}
+	snaptest.MockSnap(c, "name: foo\nversion: 1\napps:\n  svc:\n    daemon: simple\n", si)
+	snapstate.Set(s.st, "foo", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+}
+
+func (s *quotaSuite) TearDownTest(c *check.C) {
+	for _, restore := range s.rests {
+		restore()
+	}
+}
+
+func (s *quotaSuite) post(c *check.C, body string) *daemon.Resp {
+	req, err := http.NewRequest("POST", "/v2/quotas", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	return daemon.QuotaGroupsCmd.POST(daemon.QuotaGroupsCmd, req, nil).(*daemon.Resp)
+}
+
+// postAndSettle posts the given quota action and runs the change it
+// makes, checking that it succeeds with the given summary.
+func (s *quotaSuite) postAndSettle(c *check.C, body, summary string) {
+	rsp := s.post(c, body)
+	c.Assert(rsp.Status, check.Equals, 202, check.Commentf("%v", rsp.Result))
+	c.Assert(rsp.Change, check.Not(check.Equals), "")
+
+	c.Assert(s.o.Settle(5*time.Second), check.IsNil)
+	s.st.Lock()
+	defer s.st.Unlock()
+	chg := s.st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "quota-control")
+	c.Check(chg.Summary(), check.Equals, summary)
+	c.Check(chg.Err(), check.IsNil)
+}
+
+func (s *quotaSuite) TestEnsureAndRemoveQuota(c *check.C) {
+	s.postAndSettle(c, `{"action": "ensure", "group-name": "db", "snaps": ["foo"], "constraints": {"memory-limit": 1000}}`, `Create quota group "db"`)
+	s.postAndSettle(c, `{"action": "ensure", "group-name": "small", "parent": "db"}`, `Create quota group "small"`)
+	// updating only changes the constraints
+	s.postAndSettle(c, `{"action": "ensure", "group-name": "db", "constraints": {"memory-limit": 2000}}`, `Update quota group "db"`)
+
+	s.st.Lock()
+	groups, err := servicestate.AllQuotas(s.st)
+	s.st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(groups["db"].Snaps, check.DeepEquals, []string{"foo"})
+	c.Check(groups["db"].MemoryLimit, check.Equals, uint64(2000))
+	c.Check(groups["small"].ParentGroup, check.Equals, "db")
+
+	req, err := http.NewRequest("GET", "/v2/quotas", nil)
+	c.Assert(err, check.IsNil)
+	rsp := daemon.QuotaGroupsCmd.GET(daemon.QuotaGroupsCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, []client.QuotaGroupResult{
+		{GroupName: "db", SubGroups: []string{"small"}, Snaps: []string{"foo"}, Constraints: &client.QuotaValues{MemoryLimit: 2000}},
+		{GroupName: "small", Parent: "db", Constraints: &client.QuotaValues{}},
+	})
+
+	s.postAndSettle(c, `{"action": "remove", "group-name": "small"}`, `Remove quota group "small"`)
+	s.st.Lock()
+	groups, err = servicestate.AllQuotas(s.st)
+	s.st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(groups, check.HasLen, 1)
+}
+
+func (s *quotaSuite) TestPostQuotaConflict(c *check.C) {
+	rsp := s.post(c, `{"action": "ensure", "group-name": "db", "snaps": ["foo"]}`)
+	c.Assert(rsp.Status, check.Equals, 202, check.Commentf("%v", rsp.Result))
+
+	// the first change is not done yet
+	rsp = s.post(c, `{"action": "ensure", "group-name": "other"}`)
+	c.Check(rsp.Status, check.Equals, 409)
+	res := rsp.Result.(*daemon.ErrorResult)
+	c.Check(res.Message, check.Equals, "a quota group change is in progress, no other quota group changes allowed until it is done")
+	c.Check(string(res.Kind), check.Equals, "snap-change-conflict")
+	c.Check(res.Value, check.DeepEquals, map[string]interface{}{"change-kind": "quota-control"})
+}
+
+func (s *quotaSuite) TestPostQuotaErrors(c *check.C) {
+	s.postAndSettle(c, `{"action": "ensure", "group-name": "db"}`, `Create quota group "db"`)
+
+	for _, t := range []struct {
+		body string
+		err  string
+	}{
+		{`{"action": "ensure"}`, `quota group name is required`},
+		{`{"action": "frobnicate", "group-name": "db"}`, `unknown quota action "frobnicate"`},
+		{`{"action": "ensure", "group-name": "db", "parent": "other"}`, `cannot change the parent group of quota group "db"`},
+		{`{"action": "ensure", "group-name": "other", "snaps": ["bar"]}`, `snap "bar" is not installed`},
+		{`{"action": "remove", "group-name": "other"}`, `quota group "other" does not exist`},
+		{`{"action": `, `cannot decode quota action from request body: unexpected EOF`},
+	} {
+		rsp := s.post(c, t.body)
+		c.Check(rsp.Status, check.Equals, 400, check.Commentf(t.body))
+		c.Check(rsp.Result, check.DeepEquals, &daemon.ErrorResult{Message: t.err}, check.Commentf(t.body))
+	}
+}
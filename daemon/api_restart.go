@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/snapcore/snapd/overlord/auth"
+)
+
+var systemRestartCmd = &Command{
+	Path:     "/v2/system-restart",
+	UserOK:   true,
+	PolkitOK: "io.snapcraft.snapd.manage",
+	GET:      getSystemRestart,
+	POST:     postSystemRestart,
+}
+
+func getSystemRestart(c *Command, r *http.Request, user *auth.UserState) Response {
+	return SyncResponse(c.d.restartInfo(), nil)
+}
+
+type postSystemRestartData struct {
+	Action string `json:"action"`
+}
+
+func postSystemRestart(c *Command, r *http.Request, user *auth.UserState) Response {
+	var data postSystemRestartData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode request body into restart action: %v", err)
+	}
+	if data.Action != "postpone" {
+		return BadRequest("unknown restart action %q", data.Action)
+	}
+
+	if err := c.d.postponeRestart(); err != nil {
+		return BadRequest("%v", err)
+	}
+	return SyncResponse(c.d.restartInfo(), nil)
+}
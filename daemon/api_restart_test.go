@@ -0,0 +1,103 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon_test
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var _ = check.Suite(&restartSuite{})
+
+type restartSuite struct {
+	o *overlord.Overlord
+	d *daemon.Daemon
+}
+
+func (s *restartSuite) SetUpTest(c *check.C) {
+	s.o = overlord.Mock()
+	s.d = daemon.NewWithOverlord(s.o)
+}
+
+func (s *restartSuite) TestGetSystemRestart(c *check.C) {
+	state.MockRestarting(s.o.State(), state.RestartSystem)
+
+	req, err := http.NewRequest("GET", "/v2/system-restart", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.SystemRestartCmd.GET(daemon.SystemRestartCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, &client.RestartInfo{
+		Pending:           "system",
+		PostponementsLeft: 3,
+	})
+}
+
+func (s *restartSuite) TestPostSystemRestartPostpone(c *check.C) {
+	req, err := http.NewRequest("POST", "/v2/system-restart", strings.NewReader(`{"action": "postpone"}`))
+	c.Assert(err, check.IsNil)
+
+	rsp := daemon.SystemRestartCmd.POST(daemon.SystemRestartCmd, req, nil).(*daemon.Resp)
+	c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	info := rsp.Result.(*client.RestartInfo)
+	c.Check(info.Pending, check.Equals, "")
+	c.Check(info.PostponedUntil, check.NotNil)
+	c.Check(info.PostponementsLeft, check.Equals, 2)
+}
+
+func (s *restartSuite) TestPostSystemRestartErrors(c *check.C) {
+	for _, t := range []struct {
+		body string
+		err  string
+	}{
+		{`}`, `cannot decode request body into restart action: .*`},
+		{`{"action": "foo"}`, `unknown restart action "foo"`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/system-restart", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+
+		rsp := daemon.SystemRestartCmd.POST(daemon.SystemRestartCmd, req, nil).(*daemon.Resp)
+		c.Check(rsp.Type, check.Equals, daemon.ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*daemon.ErrorResult).Message, check.Matches, t.err)
+	}
+}
+
+func (s *restartSuite) TestPostSystemRestartTooManyPostponements(c *check.C) {
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", "/v2/system-restart", strings.NewReader(`{"action": "postpone"}`))
+		c.Assert(err, check.IsNil)
+		rsp := daemon.SystemRestartCmd.POST(daemon.SystemRestartCmd, req, nil).(*daemon.Resp)
+		c.Assert(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	}
+
+	req, err := http.NewRequest("POST", "/v2/system-restart", strings.NewReader(`{"action": "postpone"}`))
+	c.Assert(err, check.IsNil)
+	rsp := daemon.SystemRestartCmd.POST(daemon.SystemRestartCmd, req, nil).(*daemon.Resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*daemon.ErrorResult).Message, check.Equals, "restart was postponed too many times already")
+}
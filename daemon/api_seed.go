@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+)
+
+var seedCmd = &Command{
+	Path:   "/v2/seed",
+	UserOK: true,
+	GET:    getSeed,
+	POST:   postSeed,
+}
+
+var devicestateRetrySeeding = devicestate.RetrySeeding
+
+func getSeed(c *Command, r *http.Request, user *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	seeding, err := devicestate.Seeding(st)
+	if err != nil {
+		return InternalError("cannot get seeding status: %v", err)
+	}
+	return SyncResponse(seeding, nil)
+}
+
+type postSeedData struct {
+	Action string `json:"action"`
+}
+
+func postSeed(c *Command, r *http.Request, user *auth.UserState) Response {
+	defer r.Body.Close()
+	var data postSeedData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode request body into seed action: %v", err)
+	}
+	if data.Action != "retry" {
+		return BadRequest("unknown seed action %q", data.Action)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	if err := devicestateRetrySeeding(st); err != nil {
+		return BadRequest("%v", err)
+	}
+	ensureStateSoon(st)
+
+	return SyncResponse(nil, nil)
+}
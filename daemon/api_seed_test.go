@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *apiSuite) TestGetSeed(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	st.Lock()
+	// daemon() marks the system as seeded, undo that
+	st.Set("seeded", nil)
+	chg := st.NewChange("seed", "Initialize system state")
+	t := st.NewTask("prerequisites", "Ensure prerequisites")
+	chg.AddTask(t)
+	t.SetStatus(state.ErrorStatus)
+	t.Errorf("cannot install snap: boom")
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/seed", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getSeed(seedCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 200)
+
+	seeding := rsp.Result.(*devicestate.SeedingStatus)
+	c.Check(seeding.Seeded, check.Equals, false)
+	c.Check(seeding.Change, check.Equals, chg.ID())
+	c.Check(seeding.Status, check.Equals, "Error")
+	c.Assert(seeding.FailedTask, check.NotNil)
+	c.Check(seeding.FailedTask.Kind, check.Equals, "prerequisites")
+	c.Check(seeding.Error, check.Matches, ".*cannot install snap: boom")
+
+	// seeding is conveyed by system-info too
+	rec := httptest.NewRecorder()
+	sysInfoCmd.GET(sysInfoCmd, nil, nil).ServeHTTP(rec, nil)
+	c.Assert(rec.Code, check.Equals, 200)
+	var sysInfoRsp struct {
+		Result struct {
+			Seeding *devicestate.SeedingStatus `json:"seeding"`
+		} `json:"result"`
+	}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &sysInfoRsp), check.IsNil)
+	c.Assert(sysInfoRsp.Result.Seeding, check.NotNil)
+	c.Check(sysInfoRsp.Result.Seeding.Change, check.Equals, chg.ID())
+	c.Check(sysInfoRsp.Result.Seeding.FailedTask, check.DeepEquals, seeding.FailedTask)
+}
+
+func (s *apiSuite) TestPostSeedRetry(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+	defer func() { ensureStateSoon = func(st *state.State) {} }()
+
+	retried := 0
+	devicestateRetrySeeding = func(st *state.State) error {
+		retried++
+		return nil
+	}
+	defer func() { devicestateRetrySeeding = devicestate.RetrySeeding }()
+
+	req, err := http.NewRequest("POST", "/v2/seed", bytes.NewBufferString(`{"action":"retry"}`))
+	c.Assert(err, check.IsNil)
+	rsp := postSeed(seedCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(retried, check.Equals, 1)
+	c.Check(soon, check.Equals, 1)
+}
+
+func (s *apiSuite) TestPostSeedUnhappy(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	devicestateRetrySeeding = func(st *state.State) error {
+		return errors.New("cannot retry seeding: system is already seeded")
+	}
+	defer func() { devicestateRetrySeeding = devicestate.RetrySeeding }()
+
+	for _, t := range []struct {
+		body, err string
+	}{
+		{`not json`, "cannot decode request body into seed action: .*"},
+		{`{"action":"reset"}`, `unknown seed action "reset"`},
+		{`{"action":"retry"}`, "cannot retry seeding: system is already seeded"},
+	} {
+		req, err := http.NewRequest("POST", "/v2/seed", bytes.NewBufferString(t.body))
+		c.Assert(err, check.IsNil)
+		rsp := postSeed(seedCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+}
@@ -28,7 +28,9 @@ import (
 	"strings"
 
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/strutil"
 )
@@ -42,6 +44,12 @@ var snapshotCmd = &Command{
 	POST:     changeSnapshots,
 }
 
+var snapshotExportCmd = &Command{
+	Path:     "/v2/snapshots/{id}/export",
+	PolkitOK: "io.snapcraft.snapd.manage",
+	GET:      getSnapshotExport,
+}
+
 func listSnapshots(c *Command, r *http.Request, user *auth.UserState) Response {
 	query := r.URL.Query()
 	var setID uint64
@@ -83,6 +91,10 @@ func (action snapshotAction) String() string {
 }
 
 func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response {
+	if r.Header.Get("Content-Type") == client.SnapshotExportMediaType {
+		return importSnapshot(c, r, user)
+	}
+
 	var action snapshotAction
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&action); err != nil {
@@ -137,3 +149,56 @@ func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response
 
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
 }
+
+func getSnapshotExport(c *Command, r *http.Request, user *auth.UserState) Response {
+	sid := muxVars(r)["id"]
+	setID, err := strconv.ParseUint(sid, 10, 64)
+	if err != nil {
+		return BadRequest("'id' must be a positive base 10 number; got %q", sid)
+	}
+
+	export, err := snapshotExport(context.TODO(), setID)
+	switch err {
+	case nil:
+		// woo
+	case client.ErrSnapshotSetNotFound:
+		return NotFound("%v", err)
+	default:
+		return InternalError("cannot export snapshot set #%d: %v", setID, err)
+	}
+
+	return &snapshotExportResponse{SnapshotExport: export, setID: setID}
+}
+
+// A snapshotExportResponse's ServeHTTP method streams the export of a
+// snapshot set.
+type snapshotExportResponse struct {
+	*backend.SnapshotExport
+	setID uint64
+}
+
+// ServeHTTP from the Response interface
+func (s *snapshotExportResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	hdr.Set("Content-Type", client.SnapshotExportMediaType)
+	hdr.Set("Content-Disposition", fmt.Sprintf("attachment; filename=snapshot-%d.tar", s.setID))
+	hdr.Set("Content-Length", strconv.FormatInt(s.Size(), 10))
+
+	if err := s.StreamTo(r.Context(), w); err != nil {
+		// the headers are already sent, the client notices the
+		// stream is short
+		logger.Noticef("cannot stream export of snapshot set #%d: %v", s.setID, err)
+	}
+}
+
+func importSnapshot(c *Command, r *http.Request, user *auth.UserState) Response {
+	setID, snapNames, err := snapshotImport(context.TODO(), c.d.overlord.State(), r.Body)
+	if err != nil {
+		if _, ok := err.(*backend.InvalidExportError); ok {
+			return BadRequest("%v", err)
+		}
+		return InternalError("%v", err)
+	}
+
+	return SyncResponse(&client.SnapshotImportSet{ID: setID, Snaps: snapNames}, nil)
+}
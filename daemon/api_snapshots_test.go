@@ -23,7 +23,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"gopkg.in/check.v1"
@@ -33,8 +38,10 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store/storetest"
 )
 
@@ -321,3 +328,98 @@ func (s *snapshotSuite) TestChangeSnapshot(c *check.C) {
 
 	}
 }
+
+func (s *snapshotSuite) TestExportSnapshot(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "foo", Revision: snap.R(1)}, Version: "v1"}
+	c.Assert(os.MkdirAll(filepath.Join(info.DataDir(), "data"), 0755), check.IsNil)
+	_, err := backend.Save(context.TODO(), 42, info, nil, nil, &backend.Flags{})
+	c.Assert(err, check.IsNil)
+
+	defer daemon.MockMuxVars(func(*http.Request) map[string]string {
+		return map[string]string{"id": "42"}
+	})()
+
+	c.Check(daemon.SnapshotExportCmd.Path, check.Equals, "/v2/snapshots/{id}/export")
+	req, err := http.NewRequest("GET", "/v2/snapshots/42/export", nil)
+	c.Assert(err, check.IsNil)
+
+	rec := httptest.NewRecorder()
+	daemon.GetSnapshotExport(daemon.SnapshotExportCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, client.SnapshotExportMediaType)
+	c.Check(rec.HeaderMap.Get("Content-Length"), check.Equals, strconv.Itoa(rec.Body.Len()))
+
+	// the export can be imported back
+	defer daemon.MockSnapshotImport(func(ctx context.Context, st *state.State, r io.Reader) (uint64, []string, error) {
+		snapNames, err := backend.Import(ctx, 43, r)
+		return 43, snapNames, err
+	})()
+	req, err = http.NewRequest("POST", "/v2/snapshots", rec.Body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", client.SnapshotExportMediaType)
+
+	rsp := daemon.ChangeSnapshots(daemon.SnapshotCmd, req, nil)
+	c.Check(rsp.Type, check.Equals, daemon.ResponseTypeSync)
+	c.Check(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, &client.SnapshotImportSet{ID: 43, Snaps: []string{"foo"}})
+}
+
+func (s *snapshotSuite) TestExportSnapshotErrors(c *check.C) {
+	var id string
+	defer daemon.MockMuxVars(func(*http.Request) map[string]string {
+		return map[string]string{"id": id}
+	})()
+	var exportErr error
+	defer daemon.MockSnapshotExport(func(context.Context, uint64) (*backend.SnapshotExport, error) {
+		return nil, exportErr
+	})()
+
+	for _, t := range []struct {
+		id      string
+		err     error
+		status  int
+		message string
+	}{
+		{"no", nil, 400, `'id' must be a positive base 10 number; got "no"`},
+		{"42", client.ErrSnapshotSetNotFound, 404, "no snapshot set with the given ID"},
+		{"42", errors.New("bzzt"), 500, "cannot export snapshot set #42: bzzt"},
+	} {
+		id, exportErr = t.id, t.err
+		req, err := http.NewRequest("GET", "/v2/snapshots/"+id+"/export", nil)
+		c.Assert(err, check.IsNil)
+
+		rsp := daemon.GetSnapshotExport(daemon.SnapshotExportCmd, req, nil).(*daemon.Resp)
+		c.Check(rsp.Type, check.Equals, daemon.ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, t.status)
+		c.Check(rsp.ErrorResult().Message, check.Equals, t.message)
+	}
+}
+
+func (s *snapshotSuite) TestImportSnapshotError(c *check.C) {
+	defer daemon.MockSnapshotImport(func(context.Context, *state.State, io.Reader) (uint64, []string, error) {
+		return 0, nil, &backend.InvalidExportError{Msg: "cannot import snapshot: export does not start with a manifest"}
+	})()
+
+	req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader("export"))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", client.SnapshotExportMediaType)
+
+	rsp := daemon.ChangeSnapshots(daemon.SnapshotCmd, req, nil)
+	c.Check(rsp.Type, check.Equals, daemon.ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.ErrorResult().Message, check.Equals, "cannot import snapshot: export does not start with a manifest")
+}
+
+func (s *snapshotSuite) TestImportSnapshotInternalError(c *check.C) {
+	defer daemon.MockSnapshotImport(func(context.Context, *state.State, io.Reader) (uint64, []string, error) {
+		return 0, nil, errors.New("cannot import snapshot: write /var/lib/snapd/snapshots/.import-1: input/output error")
+	})()
+
+	req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader("export"))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", client.SnapshotExportMediaType)
+
+	rsp := daemon.ChangeSnapshots(daemon.SnapshotCmd, req, nil)
+	c.Check(rsp.Type, check.Equals, daemon.ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 500)
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+)
+
+var systemsCmd = &Command{
+	Path:   "/v2/systems",
+	UserOK: true,
+	GET:    getSystems,
+	POST:   postSystems,
+}
+
+var (
+	devicestateRecoverySystems      = devicestate.RecoverySystems
+	devicestateCreateRecoverySystem = devicestate.CreateRecoverySystem
+)
+
+func getSystems(c *Command, r *http.Request, user *auth.UserState) Response {
+	systems, err := devicestateRecoverySystems()
+	if err != nil {
+		return InternalError("cannot list recovery systems: %v", err)
+	}
+	if systems == nil {
+		systems = []*devicestate.RecoverySystem{}
+	}
+	return SyncResponse(systems, nil)
+}
+
+type postSystemsData struct {
+	Action string `json:"action"`
+	Label  string `json:"label,omitempty"`
+}
+
+func postSystems(c *Command, r *http.Request, user *auth.UserState) Response {
+	defer r.Body.Close()
+	var data postSystemsData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return BadRequest("cannot decode request body into systems action: %v", err)
+	}
+	if data.Action != "create" {
+		return BadRequest("unknown systems action %q", data.Action)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	chg, err := devicestateCreateRecoverySystem(st, data.Label)
+	if err != nil {
+		return BadRequest("cannot create recovery system: %v", err)
+	}
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, &Meta{Change: chg.ID()})
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *apiSuite) TestGetSystems(c *check.C) {
+	created := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	devicestateRecoverySystems = func() ([]*devicestate.RecoverySystem, error) {
+		return []*devicestate.RecoverySystem{
+			{Label: "20200304", Brand: "my-brand", Model: "my-model", Created: created},
+		}, nil
+	}
+	defer func() { devicestateRecoverySystems = devicestate.RecoverySystems }()
+
+	req, err := http.NewRequest("GET", "/v2/systems", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getSystems(systemsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []*devicestate.RecoverySystem{
+		{Label: "20200304", Brand: "my-brand", Model: "my-model", Created: created},
+	})
+}
+
+func (s *apiSuite) TestGetSystemsNone(c *check.C) {
+	devicestateRecoverySystems = func() ([]*devicestate.RecoverySystem, error) {
+		return nil, nil
+	}
+	defer func() { devicestateRecoverySystems = devicestate.RecoverySystems }()
+
+	req, err := http.NewRequest("GET", "/v2/systems", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getSystems(systemsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, []*devicestate.RecoverySystem{})
+}
+
+func (s *apiSuite) TestPostSystemsUnhappy(c *check.C) {
+	for _, t := range []struct {
+		body, err string
+	}{
+		{`not json`, "cannot decode request body into systems action: .*"},
+		{`{"action":"remove"}`, `unknown systems action "remove"`},
+		{`{}`, `unknown systems action ""`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/systems", bytes.NewBufferString(t.body))
+		c.Assert(err, check.IsNil)
+		rsp := postSystems(systemsCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+}
+
+func (s *apiSuite) TestPostSystemsCreate(c *check.C) {
+	d := s.daemonWithOverlordMock(c)
+	st := d.overlord.State()
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+	defer func() { ensureStateSoon = func(st *state.State) {} }()
+
+	var gotLabel string
+	devicestateCreateRecoverySystem = func(st *state.State, label string) (*state.Change, error) {
+		gotLabel = label
+		return st.NewChange("create-recovery-system", "..."), nil
+	}
+	defer func() { devicestateCreateRecoverySystem = devicestate.CreateRecoverySystem }()
+
+	req, err := http.NewRequest("POST", "/v2/systems", bytes.NewBufferString(`{"action":"create","label":"before-upgrade"}`))
+	c.Assert(err, check.IsNil)
+	rsp := postSystems(systemsCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Check(gotLabel, check.Equals, "before-upgrade")
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "create-recovery-system")
+
+	c.Check(soon, check.Equals, 1)
+}
+
+func (s *apiSuite) TestPostSystemsCreateError(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	devicestateCreateRecoverySystem = func(st *state.State, label string) (*state.Change, error) {
+		return nil, errors.New(`recovery system "1234" already exists`)
+	}
+	defer func() { devicestateCreateRecoverySystem = devicestate.CreateRecoverySystem }()
+
+	req, err := http.NewRequest("POST", "/v2/systems", bytes.NewBufferString(`{"action":"create","label":"1234"}`))
+	c.Assert(err, check.IsNil)
+	rsp := postSystems(systemsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot create recovery system: recovery system "1234" already exists`)
+}
@@ -75,6 +75,7 @@ import (
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/store/storetest"
+	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/systemd"
 	"github.com/snapcore/snapd/testutil"
 )
@@ -109,6 +110,7 @@ type apiBaseSuite struct {
 	jctlSvcses         [][]string
 	jctlNs             []int
 	jctlFollows        []bool
+	jctlCursors        []string
 	jctlRCs            []io.ReadCloser
 	jctlErrs           []error
 
@@ -241,10 +243,11 @@ func (s *apiBaseSuite) systemctl(args ...string) (buf []byte, err error) {
 	return buf, err
 }
 
-func (s *apiBaseSuite) journalctl(svcs []string, n int, follow bool) (rc io.ReadCloser, err error) {
+func (s *apiBaseSuite) journalctl(svcs []string, n int, follow bool, afterCursor string) (rc io.ReadCloser, err error) {
 	s.jctlSvcses = append(s.jctlSvcses, svcs)
 	s.jctlNs = append(s.jctlNs, n)
 	s.jctlFollows = append(s.jctlFollows, follow)
+	s.jctlCursors = append(s.jctlCursors, afterCursor)
 
 	if len(s.jctlErrs) > 0 {
 		err, s.jctlErrs = s.jctlErrs[0], s.jctlErrs[1:]
@@ -263,6 +266,7 @@ func (s *apiBaseSuite) SetUpTest(c *check.C) {
 	s.jctlSvcses = nil
 	s.jctlNs = nil
 	s.jctlFollows = nil
+	s.jctlCursors = nil
 	s.jctlRCs = nil
 	s.jctlErrs = nil
 
@@ -305,8 +309,10 @@ func (s *apiBaseSuite) SetUpTest(c *check.C) {
 	snapstateUpdate = nil
 	snapstateUpdateMany = nil
 	snapstateSwitch = nil
+	snapstateHoldRefresh = nil
 
 	devicestateRemodel = nil
+	devicestateInstallOnDemandSnap = nil
 }
 
 func (s *apiBaseSuite) TearDownTest(c *check.C) {
@@ -330,6 +336,7 @@ func (s *apiBaseSuite) TearDownTest(c *check.C) {
 	snapstateUpdate = snapstate.Update
 	snapstateUpdateMany = snapstate.UpdateMany
 	snapstateSwitch = snapstate.Switch
+	snapstateHoldRefresh = snapstate.HoldRefresh
 }
 
 var modelDefaults = map[string]interface{}{
@@ -643,6 +650,8 @@ UnitFileState=static
 		[]byte(`Id=snap.foo.svc5.timer
 ActiveState=active
 UnitFileState=enabled
+SubState=waiting
+NextElapseUSecRealtime=Mon 2020-11-02 12:15:00 UTC
 `),
 		[]byte(`Type=simple
 Id=snap.foo.svc6.service
@@ -652,6 +661,7 @@ UnitFileState=static
 		[]byte(`Id=snap.foo.svc6.sock.socket
 ActiveState=active
 UnitFileState=enabled
+SubState=listening
 `),
 		[]byte(`Type=simple
 Id=snap.foo.svc7.service
@@ -769,7 +779,7 @@ UnitFileState=enabled
 					Enabled: true,
 					Active:  false,
 					Activators: []client.AppActivator{
-						{Name: "svc5", Type: "timer", Active: true, Enabled: true},
+						{Name: "svc5", Type: "timer", Active: true, Enabled: true, NextTrigger: time.Date(2020, 11, 2, 12, 15, 0, 0, time.UTC)},
 					},
 				}, {
 					Snap: "foo", Name: "svc6",
@@ -777,7 +787,7 @@ UnitFileState=enabled
 					Enabled: true,
 					Active:  false,
 					Activators: []client.AppActivator{
-						{Name: "sock", Type: "socket", Active: true, Enabled: true},
+						{Name: "sock", Type: "socket", Active: true, Enabled: true, Listening: true},
 					},
 				}, {
 					Snap: "foo", Name: "svc7",
@@ -890,6 +900,9 @@ func (s *apiSuite) TestMapLocalFields(c *check.C) {
 		Confinement: "very strict",
 		CommonIDs:   []string{"foo", "bar"},
 		Media:       media,
+		EditedLinks: map[string][]string{
+			"website": {"https://example.com"},
+		},
 		DownloadInfo: snap.DownloadInfo{
 			Size:     42,
 			Sha3_384: "some-sum",
@@ -948,7 +961,11 @@ func (s *apiSuite) TestMapLocalFields(c *check.C) {
 		CommonIDs:        []string{"foo", "bar"},
 		MountedFrom:      filepath.Join(dirs.SnapBlobDir, "some-snap_instance_7.snap"),
 		Media:            media,
-		Screenshots:      []snap.ScreenshotInfo{{Note: snap.ScreenshotsDeprecationNotice}},
+		Links: map[string][]string{
+			"contact": {"alice@example.com"},
+			"website": {"https://example.com"},
+		},
+		Screenshots: []snap.ScreenshotInfo{{Note: snap.ScreenshotsDeprecationNotice}},
 		Apps: []client.AppInfo{
 			{Snap: "some-snap_instance", Name: "bar"},
 			{Snap: "some-snap_instance", Name: "foo"},
@@ -1077,9 +1094,44 @@ func (s *apiSuite) TestSysInfo(c *check.C) {
 	const kernelVersionKey = "kernel-version"
 	c.Check(rsp.Result.(map[string]interface{})[kernelVersionKey], check.Not(check.Equals), "")
 	delete(rsp.Result.(map[string]interface{}), kernelVersionKey)
+	// the capabilities depend on the build, see TestSysInfoCapabilities
+	delete(rsp.Result.(map[string]interface{}), "capabilities")
 	c.Check(rsp.Result, check.DeepEquals, expected)
 }
 
+type optionalManager struct{}
+
+func (optionalManager) Ensure() error { return nil }
+
+func (s *apiSuite) TestSysInfoCapabilities(c *check.C) {
+	factory := func(*overlord.Overlord) (overlord.StateManager, error) {
+		return optionalManager{}, nil
+	}
+	restore := overlord.RegisterOptionalManager("prompting", factory)
+	defer restore()
+	restore = overlord.RegisterOptionalManager("fde", factory)
+	defer restore()
+
+	rec := httptest.NewRecorder()
+	s.daemon(c)
+
+	sysInfoCmd.GET(sysInfoCmd, nil, nil).ServeHTTP(rec, nil)
+	c.Check(rec.Code, check.Equals, 200)
+
+	var rsp resp
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	// the subsystems built in by default, like hotplug, are there too
+	var capabilities []string
+	for _, capability := range rsp.Result.(map[string]interface{})["capabilities"].([]interface{}) {
+		capabilities = append(capabilities, capability.(string))
+	}
+	c.Check(sort.StringsAreSorted(capabilities), check.Equals, true)
+	c.Check(strutil.ListContains(capabilities, "fde"), check.Equals, true)
+	c.Check(strutil.ListContains(capabilities, "prompting"), check.Equals, true)
+}
+
 func (s *apiSuite) TestSysInfoLegacyRefresh(c *check.C) {
 	rec := httptest.NewRecorder()
 
@@ -1149,6 +1201,8 @@ func (s *apiSuite) TestSysInfoLegacyRefresh(c *check.C) {
 	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
 	const kernelVersionKey = "kernel-version"
 	delete(rsp.Result.(map[string]interface{}), kernelVersionKey)
+	// the capabilities depend on the build, see TestSysInfoCapabilities
+	delete(rsp.Result.(map[string]interface{}), "capabilities")
 	c.Check(rsp.Result, check.DeepEquals, expected)
 }
 
@@ -1195,6 +1249,29 @@ func (s *apiSuite) TestLoginUser(c *check.C) {
 	c.Check(snapdMacaroon.Location(), check.Equals, "snapd")
 }
 
+func (s *apiSuite) TestLoginUserResetsEntitlements(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	st.Lock()
+	st.Set("snaps-without-entitlement", map[string]bool{"private-snap": true})
+	st.Unlock()
+
+	s.loginUserStoreMacaroon = "user-macaroon"
+	s.loginUserDischarge = "the-discharge-macaroon-serialized-data"
+	buf := bytes.NewBufferString(`{"username": "email@.com", "password": "password"}`)
+	req, err := http.NewRequest("POST", "/v2/login", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := loginUser(loginCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 200)
+
+	st.Lock()
+	defer st.Unlock()
+	var snaps map[string]bool
+	c.Check(st.Get("snaps-without-entitlement", &snaps), check.Equals, state.ErrNoState)
+}
+
 func (s *apiSuite) TestLoginUserWithUsername(c *check.C) {
 	d := s.daemon(c)
 	state := d.overlord.State()
@@ -2453,6 +2530,62 @@ func (s *apiSuite) TestPostSnap(c *check.C) {
 	c.Check(soon, check.Equals, 1)
 }
 
+func (s *apiSuite) TestPostSnapSimulate(c *check.C) {
+	d := s.daemonWithOverlordMock(c)
+
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+		ensureStateSoonImpl(st)
+	}
+
+	s.vars = map[string]string{"name": "foo"}
+
+	snapInstructionDispTable["install"] = func(_ *snapInstruction, st *state.State) (string, []*state.TaskSet, error) {
+		t := st.NewTask("fake-install-snap", "Doing a fake install")
+		return "foooo", []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+	defer func() {
+		snapInstructionDispTable["install"] = snapInstall
+	}()
+
+	buf := bytes.NewBufferString(`{"action": "install", "simulate": true}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/hello-world", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, &simulatedChangeResult{
+		Summary: "foooo",
+		ChangePlan: &snapstate.ChangePlan{
+			Tasks: []snapstate.PlannedTask{{Kind: "fake-install-snap", Summary: "Doing a fake install"}},
+		},
+	})
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+	c.Check(soon, check.Equals, 0)
+}
+
+func (s *apiSuite) TestPostSnapSimulateUnsupportedAction(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	s.vars = map[string]string{"name": "foo"}
+
+	buf := bytes.NewBufferString(`{"action": "revert", "simulate": true}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "simulate can only be specified for install, refresh, or remove")
+}
+
 func (s *apiSuite) TestPostSnapVerifySnapInstruction(c *check.C) {
 	s.daemonWithOverlordMock(c)
 
@@ -2557,6 +2690,7 @@ func (s *apiSuite) TestPostSnapsNoWeirdses(c *check.C) {
 			"jailmode":     "true",
 			"cohort-key":   `"what"`,
 			"leave-cohort": "true",
+			"from-seed":    "true",
 		} {
 			buf := strings.NewReader(fmt.Sprintf(`{"action": "%s","snaps":["foo","bar"], "%s": %s}`, action, weird, v))
 			req, err := http.NewRequest("POST", "/v2/snaps", buf)
@@ -3280,6 +3414,42 @@ func (s *apiSuite) TestGetRootDocument(c *check.C) {
 	c.Check(result, check.DeepEquals, map[string]interface{}{"test-key1": "test-value1", "test-key2": "test-value2"})
 }
 
+func (s *apiSuite) TestGetConfOrigins(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.State().Lock()
+	tr := config.NewTransaction(d.overlord.State())
+	tr.Set("test-snap", "test-key1", "test-value1")
+	tr.SetOrigin("test-snap", "test-key1", "gadget")
+	tr.Set("test-snap", "test-key2", map[string]interface{}{"a": 1, "b": 2})
+	tr.SetOrigin("test-snap", "test-key2.a", "gadget")
+	tr.Set("test-snap", "test-key3", "test-value3")
+	tr.Commit()
+	d.overlord.State().Unlock()
+
+	getOrigins := func(keys []string) interface{} {
+		s.vars = map[string]string{"name": "test-snap"}
+		req, err := http.NewRequest("GET", "/v2/snaps/test-snap/conf?keys="+strings.Join(keys, ","), nil)
+		c.Assert(err, check.IsNil)
+		rec := httptest.NewRecorder()
+		snapConfCmd.GET(snapConfCmd, req, nil).ServeHTTP(rec, req)
+		c.Assert(rec.Code, check.Equals, 200)
+
+		var body map[string]interface{}
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+		return body["origins"]
+	}
+
+	c.Check(getOrigins([]string{"test-key1", "test-key3"}), check.DeepEquals, map[string]interface{}{
+		"test-key1": "gadget",
+	})
+	c.Check(getOrigins([]string{"test-key3"}), check.IsNil)
+	// the origins of direct subkeys are reported as well
+	c.Check(getOrigins(nil), check.DeepEquals, map[string]interface{}{
+		"test-key1":   "gadget",
+		"test-key2.a": "gadget",
+	})
+}
+
 func (s *apiSuite) TestGetConfBadKey(c *check.C) {
 	s.daemon(c)
 	// TODO: this one in particular should really be a 400 also
@@ -3463,6 +3633,42 @@ func (s *apiSuite) TestSetConfBadSnap(c *check.C) {
 		"type": "error"})
 }
 
+func (s *apiSuite) TestSetConfInvalid(c *check.C) {
+	s.daemon(c)
+	info := s.mockSnap(c, configYaml)
+	schemaFile := filepath.Join(info.MountDir(), "meta", "config-schema.json")
+	err := ioutil.WriteFile(schemaFile, []byte(`{"properties": {"key": {"type": "integer"}}}`), 0644)
+	c.Assert(err, check.IsNil)
+
+	text, err := json.Marshal(map[string]interface{}{"key": "value"})
+	c.Assert(err, check.IsNil)
+
+	buffer := bytes.NewBuffer(text)
+	req, err := http.NewRequest("PUT", "/v2/snaps/config-snap/conf", buffer)
+	c.Assert(err, check.IsNil)
+
+	s.vars = map[string]string{"name": "config-snap"}
+
+	rec := httptest.NewRecorder()
+	snapConfCmd.PUT(snapConfCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 400)
+
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Assert(err, check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"status-code": 400.,
+		"status":      "Bad Request",
+		"result": map[string]interface{}{
+			"message": `invalid configuration for snap "config-snap": "key" must be of type integer`,
+			"kind":    "invalid-config",
+			"value": []interface{}{
+				map[string]interface{}{"field": "key", "message": "must be of type integer"},
+			},
+		},
+		"type": "error"})
+}
+
 func simulateConflict(o *overlord.Overlord, name string) {
 	st := o.State()
 	st.Lock()
@@ -3976,6 +4182,87 @@ func (s *apiSuite) TestPostSnapsOp(c *check.C) {
 	c.Check(apiData["snap-names"], check.DeepEquals, []interface{}{"fake1", "fake2"})
 }
 
+func (s *apiSuite) TestPostSnapsOpSimulate(c *check.C) {
+	assertstateRefreshSnapDeclarations = func(*state.State, int) error { return nil }
+	snapstateUpdateMany = func(_ context.Context, s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		t := s.NewTask("fake-refresh-all", "Refreshing everything")
+		return []string{"fake1", "fake2"}, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+
+	d := s.daemonWithOverlordMock(c)
+
+	buf := bytes.NewBufferString(`{"action": "refresh", "simulate": true}`)
+	req, err := http.NewRequest("POST", "/v2/snaps", buf)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(ok, check.Equals, true)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, &simulatedChangeResult{
+		Summary: `Refresh snaps "fake1", "fake2"`,
+		ChangePlan: &snapstate.ChangePlan{
+			Tasks: []snapstate.PlannedTask{{Kind: "fake-refresh-all", Summary: "Refreshing everything"}},
+		},
+	})
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+}
+
+func (s *apiSuite) TestPostSnapsOpTransaction(c *check.C) {
+	assertstateRefreshSnapDeclarations = func(*state.State, int) error { return nil }
+	var installFlags, refreshFlags *snapstate.Flags
+	snapstateInstallMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		installFlags = flags
+		t := s.NewTask("fake-install-2", "Install two")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+	snapstateUpdateMany = func(_ context.Context, s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		refreshFlags = flags
+		t := s.NewTask("fake-refresh-2", "Refresh two")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+
+	s.daemonWithOverlordMock(c)
+
+	for _, action := range []string{"install", "refresh"} {
+		buf := bytes.NewBufferString(fmt.Sprintf(`{"action": %q, "snaps": ["foo", "bar"], "transaction": "all-or-nothing"}`, action))
+		req, err := http.NewRequest("POST", "/v2/snaps", buf)
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+
+		rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+		c.Assert(ok, check.Equals, true)
+		c.Check(rsp.Type, check.Equals, ResponseTypeAsync)
+	}
+	c.Check(installFlags, check.DeepEquals, &snapstate.Flags{Transaction: client.TransactionAllOrNothing})
+	c.Check(refreshFlags, check.DeepEquals, &snapstate.Flags{Transaction: client.TransactionAllOrNothing})
+}
+
+func (s *apiSuite) TestPostSnapsOpTransactionErrors(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	for _, tc := range []struct {
+		body string
+		err  string
+	}{
+		{`{"action": "install", "snaps": ["foo"], "transaction": "some-of-it"}`, `invalid transaction type "some-of-it"`},
+		{`{"action": "remove", "snaps": ["foo"], "transaction": "all-or-nothing"}`, `transaction can only be specified for install or refresh`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(tc.body))
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+
+		rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+		c.Assert(ok, check.Equals, true)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, tc.err)
+	}
+}
+
 func (s *apiSuite) TestRefreshAll(c *check.C) {
 	refreshSnapDecls := false
 	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
@@ -4085,7 +4372,7 @@ func (s *apiSuite) TestRefreshMany1(c *check.C) {
 }
 
 func (s *apiSuite) TestInstallMany(c *check.C) {
-	snapstateInstallMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+	snapstateInstallMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		c.Check(names, check.HasLen, 2)
 		t := s.NewTask("fake-install-2", "Install two")
 		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
@@ -4103,7 +4390,7 @@ func (s *apiSuite) TestInstallMany(c *check.C) {
 }
 
 func (s *apiSuite) TestInstallManyEmptyName(c *check.C) {
-	snapstateInstallMany = func(_ *state.State, _ []string, _ int) ([]string, []*state.TaskSet, error) {
+	snapstateInstallMany = func(_ *state.State, _ []string, _ int, _ *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		return nil, nil, errors.New("should not be called")
 	}
 	d := s.daemon(c)
@@ -4247,6 +4534,119 @@ func (s *apiSuite) TestInstallCohort(c *check.C) {
 	c.Check(msg, check.Equals, `Install "fake" snap from "…e damned." cohort`)
 }
 
+func (s *apiSuite) TestInstallFromSeed(c *check.C) {
+	var calledName string
+
+	snapstateInstall = func(ctx context.Context, s *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		c.Fatalf("unexpected install from the store")
+		return nil, nil
+	}
+	devicestateInstallOnDemandSnap = func(s *state.State, name string) (*state.TaskSet, error) {
+		calledName = name
+
+		t := s.NewTask("fake-install-snap", "Doing a fake install")
+		return state.NewTaskSet(t), nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{
+		Action:   "install",
+		FromSeed: true,
+		Snaps:    []string{"fake"},
+	}
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	msg, _, err := inst.dispatch()(inst, st)
+	c.Check(err, check.IsNil)
+	c.Check(calledName, check.Equals, "fake")
+	c.Check(msg, check.Equals, `Install "fake" snap from the seed`)
+}
+
+func (s *apiSuite) TestPostSnapFromSeedUnsupportedOptions(c *check.C) {
+	s.daemonWithOverlordMock(c)
+
+	for _, t := range []struct {
+		body string
+		err  string
+	}{
+		{`{"action": "refresh", "from-seed": true}`, "from-seed can only be specified for install"},
+		{`{"action": "install", "from-seed": true, "channel": "beta"}`, "cannot specify channel, revision, cohort-key or confinement options with from-seed"},
+		{`{"action": "install", "from-seed": true, "devmode": true}`, "cannot specify channel, revision, cohort-key or confinement options with from-seed"},
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps/foo", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+		s.vars = map[string]string{"name": "foo"}
+
+		rsp := postSnap(snapCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError, check.Commentf(t.body))
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, t.err)
+	}
+}
+
+func (s *apiSuite) TestPostSnapHold(c *check.C) {
+	s.daemonWithOverlordMock(c)
+	s.vars = map[string]string{"name": "foo"}
+
+	until := time.Date(2019, 12, 1, 10, 0, 0, 0, time.UTC)
+	var calledName string
+	var calledUntil time.Time
+	snapstateHoldRefresh = func(st *state.State, name string, t time.Time) (time.Time, error) {
+		calledName = name
+		calledUntil = t
+		return until, nil
+	}
+
+	buf := strings.NewReader(`{"action": "hold", "hold-until": "2019-12-24T10:00:00Z"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, map[string]interface{}{"hold-until": until})
+	c.Check(calledName, check.Equals, "foo")
+	c.Check(calledUntil.Equal(time.Date(2019, 12, 24, 10, 0, 0, 0, time.UTC)), check.Equals, true)
+
+	st := s.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+}
+
+func (s *apiSuite) TestPostSnapHoldNotInstalled(c *check.C) {
+	s.daemonWithOverlordMock(c)
+	s.vars = map[string]string{"name": "foo"}
+
+	snapstateHoldRefresh = func(st *state.State, name string, t time.Time) (time.Time, error) {
+		return time.Time{}, &snap.NotInstalledError{Snap: name}
+	}
+
+	buf := strings.NewReader(`{"action": "hold"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Kind, check.Equals, errorKindSnapNotInstalled)
+}
+
+func (s *apiSuite) TestPostSnapHoldUntilRandoAction(c *check.C) {
+	s.daemonWithOverlordMock(c)
+	s.vars = map[string]string{"name": "foo"}
+
+	buf := strings.NewReader(`{"action": "refresh", "hold-until": "2019-12-24T10:00:00Z"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "hold-until can only be specified for hold")
+}
+
 func (s *apiSuite) TestInstallDevMode(c *check.C) {
 	var calledFlags snapstate.Flags
 
@@ -4683,6 +5083,89 @@ func (s *apiSuite) TestConnectPlugSuccess(c *check.C) {
 	}})
 }
 
+func (s *apiSuite) TestConnectPlugTemporarily(c *check.C) {
+	restore := builtin.MockInterface(&ifacetest.TestInterface{InterfaceName: "test"})
+	defer restore()
+
+	d := s.daemon(c)
+
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	action := &interfaceAction{
+		Action:   "connect",
+		Plugs:    []plugJSON{{Snap: "consumer", Name: "plug"}},
+		Slots:    []slotJSON{{Snap: "producer", Name: "slot"}},
+		Duration: "1h",
+	}
+	text, err := json.Marshal(action)
+	c.Assert(err, check.IsNil)
+	buf := bytes.NewBuffer(text)
+	req, err := http.NewRequest("POST", "/v2/interfaces", buf)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	interfacesCmd.POST(interfacesCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 202)
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Check(err, check.IsNil)
+	id := body["change"].(string)
+
+	st := d.overlord.State()
+	st.Lock()
+	chg := st.Change(id)
+	st.Unlock()
+	c.Assert(chg, check.NotNil)
+
+	<-chg.Ready()
+
+	st.Lock()
+	err = chg.Err()
+	c.Check(chg.Summary(), check.Equals, "Connect consumer:plug to producer:slot for 1h0m0s")
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	cstates, err := d.overlord.InterfaceManager().ConnectionStates()
+	c.Assert(err, check.IsNil)
+	expiry := cstates["consumer:plug producer:slot"].Expiry
+	c.Check(expiry.After(time.Now().Add(59*time.Minute)), check.Equals, true)
+	c.Check(expiry.Before(time.Now().Add(time.Hour)), check.Equals, true)
+}
+
+func (s *apiSuite) TestConnectPlugBadDuration(c *check.C) {
+	s.daemon(c)
+
+	for _, t := range []struct {
+		action   string
+		duration string
+		err      string
+	}{
+		{"connect", "forever", `invalid connection duration "forever"`},
+		{"connect", "-1h", `invalid connection duration "-1h"`},
+		{"disconnect", "1h", `interface action "disconnect" cannot specify a duration`},
+	} {
+		action := &interfaceAction{
+			Action:   t.action,
+			Plugs:    []plugJSON{{Snap: "consumer", Name: "plug"}},
+			Slots:    []slotJSON{{Snap: "producer", Name: "slot"}},
+			Duration: t.duration,
+		}
+		text, err := json.Marshal(action)
+		c.Assert(err, check.IsNil)
+		req, err := http.NewRequest("POST", "/v2/interfaces", bytes.NewBuffer(text))
+		c.Assert(err, check.IsNil)
+		rec := httptest.NewRecorder()
+		interfacesCmd.POST(interfacesCmd, req, nil).ServeHTTP(rec, req)
+		c.Check(rec.Code, check.Equals, 400)
+		var body map[string]interface{}
+		c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+		c.Check(body["result"], check.DeepEquals, map[string]interface{}{"message": t.err})
+	}
+}
+
 func (s *apiSuite) TestConnectPlugFailureInterfaceMismatch(c *check.C) {
 	d := s.daemon(c)
 
@@ -5584,7 +6067,7 @@ func (s *apiSuite) TestStateChangeAbort(c *check.C) {
 	// Execute
 	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
 	c.Assert(err, check.IsNil)
-	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rsp := postChange(stateChangeCmd, req, nil).(*resp)
 	rec := httptest.NewRecorder()
 	rsp.ServeHTTP(rec, req)
 
@@ -5650,7 +6133,7 @@ func (s *apiSuite) TestStateChangeAbortIsReady(c *check.C) {
 	// Execute
 	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
 	c.Assert(err, check.IsNil)
-	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rsp := postChange(stateChangeCmd, req, nil).(*resp)
 	rec := httptest.NewRecorder()
 	rsp.ServeHTTP(rec, req)
 
@@ -5668,6 +6151,103 @@ func (s *apiSuite) TestStateChangeAbortIsReady(c *check.C) {
 	})
 }
 
+func (s *apiSuite) TestStateChangeAnnotate(c *check.C) {
+	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
+	defer restore()
+
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	// annotating works on ready changes too
+	st.Change(ids[0]).SetStatus(state.DoneStatus)
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	annotate := func(body string) *resp {
+		req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], bytes.NewBufferString(body))
+		c.Assert(err, check.IsNil)
+		return postChange(stateChangeCmd, req, nil).(*resp)
+	}
+
+	rsp := annotate(`{"action": "annotate", "annotations": {"ticket": "OPS-123", "note": "rolled out by ops"}}`)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result.(*changeInfo).Annotations, check.DeepEquals, map[string]string{
+		"ticket": "OPS-123",
+		"note":   "rolled out by ops",
+	})
+
+	// update one, remove the other
+	rsp = annotate(`{"action": "annotate", "annotations": {"ticket": "OPS-124", "note": ""}}`)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result.(*changeInfo).Annotations, check.DeepEquals, map[string]string{
+		"ticket": "OPS-124",
+	})
+
+	// annotations are kept in the state and returned with the change
+	req, err := http.NewRequest("GET", "/v2/changes/"+ids[0], nil)
+	c.Assert(err, check.IsNil)
+	rsp = getChange(stateChangeCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result.(*changeInfo).Annotations, check.DeepEquals, map[string]string{
+		"ticket": "OPS-124",
+	})
+
+	// other changes are not affected
+	st.Lock()
+	info := change2changeInfo(st.Change(ids[1]))
+	st.Unlock()
+	c.Check(info.Annotations, check.IsNil)
+
+	// removing the last annotation drops the field
+	rsp = annotate(`{"action": "annotate", "annotations": {"ticket": ""}}`)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result.(*changeInfo).Annotations, check.IsNil)
+}
+
+func (s *apiSuite) TestStateChangeAnnotateErrors(c *check.C) {
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	chg := st.Change(ids[0])
+	chg.Set("annotations", map[string]string{"ticket": "OPS-1"})
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	tooMany := make(map[string]string)
+	for i := 0; i < 16; i++ {
+		tooMany[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	tooManyJSON, err := json.Marshal(tooMany)
+	c.Assert(err, check.IsNil)
+
+	for _, t := range []struct {
+		body string
+		err  string
+	}{
+		{`{"action": "annotate"}`, `cannot annotate change \d+: no annotations given`},
+		{`{"action": "annotate", "annotations": {"Ticket": "OPS-1"}}`, `cannot annotate change \d+: invalid annotation key "Ticket"`},
+		{`{"action": "annotate", "annotations": {"-": "OPS-1"}}`, `cannot annotate change \d+: invalid annotation key "-"`},
+		{`{"action": "annotate", "annotations": {"note": "` + strings.Repeat("x", 1025) + `"}}`, `cannot annotate change \d+: annotation "note" is longer than 1024 bytes`},
+		{`{"action": "annotate", "annotations": ` + string(tooManyJSON) + `}`, `cannot annotate change \d+: too many annotations \(max 16\)`},
+		{`{"action": "annotate", "annotations": {"note": 42}}`, `cannot decode data from request body: .*`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], bytes.NewBufferString(t.body))
+		c.Assert(err, check.IsNil)
+		rsp := postChange(stateChangeCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, 400, check.Commentf(t.body))
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+
+	// nothing was changed
+	st.Lock()
+	defer st.Unlock()
+	var annotations map[string]string
+	c.Assert(chg.Get("annotations", &annotations), check.IsNil)
+	c.Check(annotations, check.DeepEquals, map[string]string{"ticket": "OPS-1"})
+}
+
 const validBuyInput = `{
 		  "snap-id": "the-snap-id-1234abcd",
 		  "snap-name": "the snap name",
@@ -6009,6 +6589,120 @@ func (s *apiSuite) TestAliasErrors(c *check.C) {
 	}
 }
 
+func (s *apiSuite) TestApplyAliasPreferences(c *check.C) {
+	err := os.MkdirAll(dirs.SnapBinariesDir, 0755)
+	c.Assert(err, check.IsNil)
+	d := s.daemon(c)
+
+	s.mockSnap(c, aliasYaml)
+
+	oldAutoAliases := snapstate.AutoAliases
+	snapstate.AutoAliases = func(*state.State, *snap.Info) (map[string]string, error) {
+		return nil, nil
+	}
+	defer func() { snapstate.AutoAliases = oldAutoAliases }()
+
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	action := &aliasAction{
+		Action: "apply",
+		Preferences: []*aliasAction{
+			{Action: "alias", Snap: "alias-snap", App: "app", Alias: "alias1"},
+			{Action: "alias", Snap: "lalala", App: "app", Alias: "alias2"},
+			{Action: "alias", Snap: "alias-snap", App: "app2", Alias: "alias3"},
+			{Action: "alias", Snap: "alias-snap", App: "nope", Alias: "alias4"},
+			{Action: "frobnicate", Snap: "alias-snap"},
+		},
+	}
+	text, err := json.Marshal(action)
+	c.Assert(err, check.IsNil)
+	buf := bytes.NewBuffer(text)
+	req, err := http.NewRequest("POST", "/v2/aliases", buf)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	aliasesCmd.POST(aliasesCmd, req, nil).ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, 202)
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Check(err, check.IsNil)
+	c.Check(body["result"], check.DeepEquals, map[string]interface{}{
+		"preferences": []interface{}{
+			map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "app", "alias": "alias1"},
+			map[string]interface{}{"action": "alias", "snap": "lalala", "app": "app", "alias": "alias2", "error": `snap "lalala" is not installed`},
+			map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "app2", "alias": "alias3"},
+			map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "nope", "alias": "alias4"},
+			map[string]interface{}{"action": "frobnicate", "snap": "alias-snap", "error": `unsupported alias action: "frobnicate"`},
+		},
+	})
+	id := body["change"].(string)
+
+	st := d.overlord.State()
+	st.Lock()
+	chg := st.Change(id)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Kind(), check.Equals, "apply-aliases")
+	c.Check(chg.Summary(), check.Equals, "Apply 3 alias preferences")
+	st.Unlock()
+
+	<-chg.Ready()
+
+	st.Lock()
+	err = chg.Err()
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	c.Check(osutil.IsSymlink(filepath.Join(dirs.SnapBinariesDir, "alias1")), check.Equals, true)
+	c.Check(osutil.IsSymlink(filepath.Join(dirs.SnapBinariesDir, "alias3")), check.Equals, true)
+	// the alias to a missing app failed on its own
+	c.Check(osutil.IsSymlink(filepath.Join(dirs.SnapBinariesDir, "alias4")), check.Equals, false)
+	st.Lock()
+	defer st.Unlock()
+	var failed int
+	for _, t := range chg.Tasks() {
+		var aliasErr string
+		if t.Get("alias-error", &aliasErr) == nil {
+			c.Check(aliasErr, check.Matches, `cannot enable alias "alias4" for "alias-snap", target application "nope" does not exist`)
+			failed++
+		}
+	}
+	c.Check(failed, check.Equals, 1)
+
+	// and the results of the change report it
+	var data map[string]interface{}
+	c.Assert(chg.Get("api-data", &data), check.IsNil)
+	c.Check(data["alias-preferences"], check.DeepEquals, []interface{}{
+		map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "app", "alias": "alias1"},
+		map[string]interface{}{"action": "alias", "snap": "lalala", "app": "app", "alias": "alias2", "error": `snap "lalala" is not installed`},
+		map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "app2", "alias": "alias3"},
+		map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "nope", "alias": "alias4", "error": `cannot enable alias "alias4" for "alias-snap", target application "nope" does not exist`},
+		map[string]interface{}{"action": "frobnicate", "snap": "alias-snap", "error": `unsupported alias action: "frobnicate"`},
+	})
+}
+
+func (s *apiSuite) TestApplyAliasPreferencesErrors(c *check.C) {
+	s.daemon(c)
+
+	for _, t := range []struct {
+		action *aliasAction
+		err    string
+	}{
+		{&aliasAction{Action: "apply"}, `cannot apply alias preferences: no preferences given`},
+		{&aliasAction{Action: "prefer", Snap: "alias-snap", Preferences: []*aliasAction{{Action: "prefer"}}}, `alias action "prefer" cannot specify preferences`},
+		{&aliasAction{Action: "apply", Preferences: []*aliasAction{{Action: "prefer", Snap: "lalala"}}}, `cannot apply any of the alias preferences`},
+	} {
+		text, err := json.Marshal(t.action)
+		c.Assert(err, check.IsNil)
+		req, err := http.NewRequest("POST", "/v2/aliases", bytes.NewBuffer(text))
+		c.Assert(err, check.IsNil)
+
+		rsp := changeAliases(aliasesCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, 400)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, t.err)
+	}
+}
+
 func (s *apiSuite) TestUnaliasSnapSuccess(c *check.C) {
 	err := os.MkdirAll(dirs.SnapBinariesDir, 0755)
 	c.Assert(err, check.IsNil)
@@ -6652,6 +7346,66 @@ UnitFileState=enabled
 	c.Check(sort.StringsAreSorted(appNames), check.Equals, true)
 }
 
+func (s *appSuite) TestGetAppsInfoServicesUsage(c *check.C) {
+	s.sysctlBufs = [][]byte{
+		[]byte(`
+Id=snap.snap-a.svc1.service
+Type=simple
+ActiveState=active
+UnitFileState=enabled
+`[1:]),
+		[]byte(`
+Id=snap.snap-a.svc2.service
+Type=simple
+ActiveState=inactive
+UnitFileState=enabled
+`[1:]),
+		[]byte(`
+Id=snap.snap-a.svc1.service
+MemoryCurrent=1048576
+CPUUsageNSec=[not set]
+`[1:]),
+	}
+
+	req, err := http.NewRequest("GET", "/v2/apps?select=service&usage=true", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getAppsInfo(appsCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Assert(rsp.Result, check.FitsTypeOf, []client.AppInfo{})
+	svcs := rsp.Result.([]client.AppInfo)
+	c.Assert(svcs, check.HasLen, 3)
+
+	c.Check(svcs, testutil.DeepContains, client.AppInfo{
+		Snap:    "snap-a",
+		Name:    "svc1",
+		Daemon:  "simple",
+		Active:  true,
+		Enabled: true,
+		Usage:   &client.AppUsage{Memory: 1048576, CPUTime: -1},
+	})
+	// only active services have a resource usage
+	c.Check(svcs, testutil.DeepContains, client.AppInfo{
+		Snap:    "snap-a",
+		Name:    "svc2",
+		Daemon:  "simple",
+		Enabled: true,
+	})
+	c.Check(s.sysctlArgses[len(s.sysctlArgses)-1], check.DeepEquals, []string{
+		"show", "--property=Id,MemoryCurrent,CPUUsageNSec", "snap.snap-a.svc1.service",
+	})
+}
+
+func (s *appSuite) TestGetAppsInfoBadUsage(c *check.C) {
+	req, err := http.NewRequest("GET", "/v2/apps?usage=potato", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getAppsInfo(appsCmd, req, nil).(*resp)
+	c.Assert(rsp.Status, check.Equals, 400)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeError)
+}
+
 func (s *appSuite) TestGetAppsInfoBadSelect(c *check.C) {
 	req, err := http.NewRequest("GET", "/v2/apps?select=potato", nil)
 	c.Assert(err, check.IsNil)
@@ -6840,6 +7594,29 @@ func (s *appSuite) TestLogs(c *check.C) {
 `[1:])
 }
 
+func (s *appSuite) TestLogsMultiplexed(c *check.C) {
+	s.jctlRCs = []io.ReadCloser{ioutil.NopCloser(strings.NewReader(`
+{"MESSAGE": "hello1", "SYSLOG_IDENTIFIER": "xyzzy", "_PID": "42", "__REALTIME_TIMESTAMP": "42", "_SYSTEMD_UNIT": "snap.snap-a.svc2.service", "__CURSOR": "c1"}
+{"MESSAGE": "hello2", "SYSLOG_IDENTIFIER": "plugh", "_PID": "43", "__REALTIME_TIMESTAMP": "44", "_SYSTEMD_UNIT": "snap.snap-b.svc3.service", "__CURSOR": "c2"}
+	`))}
+
+	req, err := http.NewRequest("GET", "/v2/logs?names=snap-a.svc2,snap-b.svc3&follow=true&after-cursor=c0", nil)
+	c.Assert(err, check.IsNil)
+
+	rec := httptest.NewRecorder()
+	getLogs(logsCmd, req, nil).ServeHTTP(rec, req)
+
+	c.Check(s.jctlSvcses, check.DeepEquals, [][]string{{"snap.snap-a.svc2.service", "snap.snap-b.svc3.service"}})
+	c.Check(s.jctlFollows, check.DeepEquals, []bool{true})
+	c.Check(s.jctlCursors, check.DeepEquals, []string{"c0"})
+
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.Body.String(), check.Equals, `
+{"timestamp":"1970-01-01T00:00:00.000042Z","message":"hello1","sid":"xyzzy","pid":"42","service":"snap-a.svc2","cursor":"c1"}
+{"timestamp":"1970-01-01T00:00:00.000044Z","message":"hello2","sid":"plugh","pid":"43","service":"snap-b.svc3","cursor":"c2"}
+`[1:])
+}
+
 func (s *appSuite) TestLogsN(c *check.C) {
 	type T struct {
 		in  string
@@ -7195,13 +7972,16 @@ func (s *apiSuite) testWarnings(c *check.C, all bool, body io.Reader) (calls str
 	s.daemon(c)
 
 	oldOK := stateOkayWarnings
+	oldOKKey := stateOkayWarning
 	oldAll := stateAllWarnings
 	oldPending := statePendingWarnings
 	stateOkayWarnings = func(*state.State, time.Time) int { calls += "ok"; return 0 }
+	stateOkayWarning = func(_ *state.State, key string, _ time.Time) error { calls += "ok:" + key; return nil }
 	stateAllWarnings = func(*state.State) []*state.Warning { calls += "all"; return nil }
 	statePendingWarnings = func(*state.State) ([]*state.Warning, time.Time) { calls += "show"; return nil, time.Time{} }
 	defer func() {
 		stateOkayWarnings = oldOK
+		stateOkayWarning = oldOKKey
 		stateAllWarnings = oldAll
 		statePendingWarnings = oldPending
 	}()
@@ -7246,6 +8026,60 @@ func (s *apiSuite) TestAckWarnings(c *check.C) {
 	c.Check(result, check.DeepEquals, 0)
 }
 
+func (s *apiSuite) TestAckWarningByKey(c *check.C) {
+	calls, result := s.testWarnings(c, false, bytes.NewReader([]byte(`{"action": "okay", "key": "0123456789abcdef"}`)))
+	c.Check(calls, check.Equals, "ok:0123456789abcdef")
+	c.Check(result, check.DeepEquals, 1)
+}
+
+func (s *apiSuite) TestAckWarningByKeyNotFound(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("POST", "/v2/warnings", bytes.NewReader([]byte(`{"action": "okay", "key": "0123456789abcdef"}`)))
+	c.Assert(err, check.IsNil)
+	rsp := warningsCmd.POST(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 404)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `no warning with key "0123456789abcdef"`)
+}
+
+func (s *apiSuite) TestAckWarningsByKeyAndTimestamp(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("POST", "/v2/warnings", bytes.NewReader([]byte(`{"action": "okay", "key": "0123456789abcdef", "timestamp": "2006-01-02T15:04:05Z"}`)))
+	c.Assert(err, check.IsNil)
+	rsp := warningsCmd.POST(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "cannot acknowledge warnings by both key and timestamp")
+}
+
+func (s *apiSuite) TestWarningsSeverity(c *check.C) {
+	d := s.daemon(c)
+
+	st := d.overlord.State()
+	st.Lock()
+	st.WarnfWithSeverity(state.WarningSeverityInfo, "just so you know")
+	st.Warnf("something is off")
+	st.WarnfWithSeverity(state.WarningSeverityCritical, "something is very off")
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/warnings?severity=warning", nil)
+	c.Assert(err, check.IsNil)
+	rsp := warningsCmd.GET(warningsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	ws := rsp.Result.([]*state.Warning)
+	c.Assert(ws, check.HasLen, 2)
+	c.Check(ws[0].String(), check.Equals, "something is off")
+	c.Check(ws[1].String(), check.Equals, "something is very off")
+
+	req, err = http.NewRequest("GET", "/v2/warnings?severity=meh", nil)
+	c.Assert(err, check.IsNil)
+	rsp = warningsCmd.GET(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, 400)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `invalid severity parameter: invalid warning severity "meh"`)
+}
+
 func (s *apiSuite) TestErrToResponseForChangeConflict(c *check.C) {
 	si := &snapInstruction{Action: "frobble", Snaps: []string{"foo"}}
 
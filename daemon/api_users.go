@@ -177,6 +177,11 @@ func loginUser(c *Command, r *http.Request, user *auth.UserState) Response {
 	} else {
 		user, err = auth.NewUser(st, loginData.Username, loginData.Email, macaroon, []string{discharge})
 	}
+	if err == nil {
+		// the user might be entitled to snaps access to which
+		// was lost, have them auto-refreshed again
+		snapstate.ResetEntitlements(st)
+	}
 	st.Unlock()
 	if err != nil {
 		return InternalError("cannot persist authentication details: %v", err)
@@ -36,6 +36,7 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/i18n"
@@ -78,7 +79,24 @@ type Daemon struct {
 
 	expectedRebootDidNotHappen bool
 
+	// restartPostponedUntil is the time until which clients asked
+	// for restarts to be postponed
+	restartPostponedUntil time.Time
+	// restartPostponedSince is when the current series of
+	// postponements started
+	restartPostponedSince time.Time
+	// restartPostponements counts the postponements of the current
+	// series
+	restartPostponements int
+	// restartCarriedOut is set once the restart is carried out and
+	// can no longer be postponed
+	restartCarriedOut bool
+
 	mu sync.Mutex
+	// idempotencyMu protects idempotentInFlight, the requests carrying
+	// idempotency keys being served, by idempotent request ID
+	idempotencyMu      sync.Mutex
+	idempotentInFlight map[string]chan struct{}
 }
 
 // A ResponseFunc handles one of the individual verbs for a method
@@ -257,7 +275,13 @@ func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if rspf != nil {
-		rsp = rspf(c, r, user)
+		if key := idempotencyKey(r); key != "" {
+			rsp = c.d.serveIdempotent(r, key, func() Response {
+				return rspf(c, r, user)
+			})
+		} else {
+			rsp = rspf(c, r, user)
+		}
 	}
 
 	if rsp, ok := rsp.(*resp); ok {
@@ -270,6 +294,14 @@ func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case state.RestartSocket:
 			rsp.transmitMaintenance(errorKindDaemonRestart, "daemon is stopping to wait for socket activation")
 		}
+		if rsp.Maintenance == nil {
+			// the client talked to another version of the daemon
+			// before, it might rely on behaviour that changed
+			seen := r.Header.Get(client.SeenDaemonVersionHeader)
+			if seen != "" && seen != cmd.Version {
+				rsp.transmitVersionChange(seen, cmd.Version)
+			}
+		}
 		if rsp.Type != ResponseTypeError {
 			st.Lock()
 			count, stamp := st.WarningsSummary()
@@ -278,6 +310,7 @@ func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	w.Header().Set(client.DaemonVersionHeader, cmd.Version)
 	rsp.ServeHTTP(w, r)
 }
 
@@ -469,8 +502,134 @@ func (d *Daemon) Start() error {
 	return nil
 }
 
+var (
+	// restartPostponement is how long a restart is postponed each
+	// time a client asks for it
+	restartPostponement = 5 * time.Minute
+	// maxRestartPostponements is how many times in a row a restart
+	// can be postponed
+	maxRestartPostponements = 3
+	// maxRestartDelay is how far from the first of a series of
+	// postponements a restart can be postponed at most
+	maxRestartDelay = 30 * time.Minute
+	// restartNoticeWait is how long a requested restart is pending,
+	// for clients to notice and possibly postpone it, before it is
+	// carried out
+	restartNoticeWait = 10 * time.Second
+)
+
 // HandleRestart implements overlord.RestartBehavior.
 func (d *Daemon) HandleRestart(t state.RestartType) {
+	if t == state.RestartSocket {
+		// the daemon is idle, nobody to postpone for
+		d.restart(t)
+		return
+	}
+	if restartNoticeWait > 0 {
+		// postponements are checked once the restart is acted on
+		time.AfterFunc(restartNoticeWait, func() { d.restartUnlessPostponed(t) })
+		return
+	}
+	d.restartUnlessPostponed(t)
+}
+
+// restartUnlessPostponed carries out the restart, unless clients asked
+// for it to be postponed, in which case it is checked again once the
+// postponement is over.
+func (d *Daemon) restartUnlessPostponed(t state.RestartType) {
+	select {
+	case <-d.tomb.Dying():
+		// the daemon is stopping already
+		return
+	default:
+	}
+
+	d.mu.Lock()
+	if wait := d.restartPostponedUntil.Sub(time.Now()); wait > 0 {
+		logger.Noticef("Restart postponed by clients until %s.", d.restartPostponedUntil.Format(time.RFC3339))
+		time.AfterFunc(wait, func() { d.restartUnlessPostponed(t) })
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+
+	if !d.carryOutPendingRestart() {
+		return
+	}
+	d.restart(t)
+}
+
+// carryOutPendingRestart marks the pending restart as carried out. It
+// returns false if it was carried out already.
+func (d *Daemon) carryOutPendingRestart() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.restartCarriedOut {
+		return false
+	}
+	d.restartCarriedOut = true
+	return true
+}
+
+// postponeRestart postpones restarts, pending or not, by
+// restartPostponement, at most maxRestartPostponements times in a row
+// and no further than maxRestartDelay from the first postponement.
+func (d *Daemon) postponeRestart() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.restartCarriedOut {
+		return fmt.Errorf("restart is already in progress")
+	}
+
+	now := time.Now()
+	if !now.Before(d.restartPostponedUntil) {
+		// the previous postponement is over, start a new series
+		d.restartPostponedSince = now
+		d.restartPostponements = 0
+	}
+	if d.restartPostponements >= maxRestartPostponements {
+		return fmt.Errorf("restart was postponed too many times already")
+	}
+	until := now.Add(restartPostponement)
+	if deadline := d.restartPostponedSince.Add(maxRestartDelay); until.After(deadline) {
+		until = deadline
+	}
+	if !until.After(now) {
+		return fmt.Errorf("restart cannot be postponed any further")
+	}
+
+	d.restartPostponements++
+	d.restartPostponedUntil = until
+	return nil
+}
+
+// restartInfo returns information about the pending restart, if any,
+// and its postponement.
+func (d *Daemon) restartInfo() *client.RestartInfo {
+	info := &client.RestartInfo{}
+	switch _, t := d.overlord.State().Restarting(); t {
+	case state.RestartDaemon:
+		info.Pending = "daemon"
+	case state.RestartSystem:
+		info.Pending = "system"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info.PostponementsLeft = maxRestartPostponements
+	if time.Now().Before(d.restartPostponedUntil) {
+		until := d.restartPostponedUntil
+		info.PostponedUntil = &until
+		info.PostponementsLeft -= d.restartPostponements
+	}
+	if d.restartCarriedOut {
+		info.PostponementsLeft = 0
+	}
+	return info
+}
+
+func (d *Daemon) restart(t state.RestartType) {
 	// die when asked to restart (systemd should get us back up!) etc
 	switch t {
 	case state.RestartDaemon:
@@ -514,6 +673,19 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 
 	d.tomb.Kill(nil)
 
+	// a system restart that is still pending, possibly postponed, is
+	// carried out as part of the shutdown instead of being dropped
+	if _, t := d.state.Restarting(); t == state.RestartSystem && d.carryOutPendingRestart() {
+		// try to schedule a fallback slow reboot already here
+		// in case we get stuck shutting down
+		if err := reboot(rebootWaitTimeout); err != nil {
+			logger.Noticef("%s", err)
+		}
+		d.mu.Lock()
+		d.restartSystem = true
+		d.mu.Unlock()
+	}
+
 	d.mu.Lock()
 	restartSystem := d.restartSystem
 	restartSocket := d.restartSocket
@@ -40,6 +40,7 @@ import (
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
@@ -66,6 +67,8 @@ type daemonSuite struct {
 	lastPolkitFlags polkit.CheckFlags
 	notified        []string
 	restoreBackends func()
+
+	restoreRestartNoticeWait func()
 }
 
 var _ = check.Suite(&daemonSuite{})
@@ -86,9 +89,11 @@ func (s *daemonSuite) SetUpTest(c *check.C) {
 	s.notified = nil
 	polkitCheckAuthorization = s.checkAuthorization
 	s.restoreBackends = ifacestate.MockSecurityBackends(nil)
+	s.restoreRestartNoticeWait = mockRestartNoticeWait(0)
 }
 
 func (s *daemonSuite) TearDownTest(c *check.C) {
+	s.restoreRestartNoticeWait()
 	systemdSdNotify = systemd.SdNotify
 	dirs.SetRootDir("")
 	s.authorized = false
@@ -210,6 +215,70 @@ func (s *daemonSuite) TestCommandRestartingState(c *check.C) {
 	})
 }
 
+func (s *daemonSuite) TestCommandDaemonVersionChanged(c *check.C) {
+	restore := cmd.MockVersion("2.43")
+	defer restore()
+	d := newTestDaemon(c)
+
+	command := &Command{d: d}
+	command.GET = func(*Command, *http.Request, *auth.UserState) Response {
+		return SyncResponse(nil, nil)
+	}
+	req, err := http.NewRequest("GET", "", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "pid=100;uid=0;socket=;"
+
+	// decode into a fresh value each time, so that nothing is left
+	// over from the previous response
+	maintenance := func(rec *httptest.ResponseRecorder) *errorResult {
+		var rst struct {
+			Maintenance *errorResult `json:"maintenance"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &rst)
+		c.Assert(err, check.IsNil)
+		return rst.Maintenance
+	}
+
+	// no version seen before
+	rec := httptest.NewRecorder()
+	command.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.Header().Get("X-Snapd-Version"), check.Equals, "2.43")
+	c.Check(maintenance(rec), check.IsNil)
+
+	// same version seen before
+	req.Header.Set("X-Snapd-Seen-Version", "2.43")
+	rec = httptest.NewRecorder()
+	command.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(maintenance(rec), check.IsNil)
+
+	// another version seen before
+	req.Header.Set("X-Snapd-Seen-Version", "2.42")
+	rec = httptest.NewRecorder()
+	command.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(rec.Header().Get("X-Snapd-Version"), check.Equals, "2.43")
+	c.Check(maintenance(rec), check.DeepEquals, &errorResult{
+		Kind:    errorKindDaemonVersionChanged,
+		Message: "snapd changed from version 2.42 to 2.43",
+		Value: map[string]interface{}{
+			"previous": "2.42",
+			"current":  "2.43",
+		},
+	})
+
+	// restarting takes precedence
+	state.MockRestarting(d.overlord.State(), state.RestartDaemon)
+	rec = httptest.NewRecorder()
+	command.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 200)
+	c.Check(maintenance(rec), check.DeepEquals, &errorResult{
+		Kind:    errorKindDaemonRestart,
+		Message: "daemon is restarting",
+	})
+}
+
 func (s *daemonSuite) TestFillsWarnings(c *check.C) {
 	d := newTestDaemon(c)
 
@@ -866,6 +935,120 @@ func makeDaemonListeners(c *check.C, d *Daemon) {
 	d.snapListener = &witnessAcceptListener{Listener: snapL, accept: snapAccept}
 }
 
+func mockRestartPostponement(postponement, maxDelay time.Duration, maxPostponements int) (restore func()) {
+	oldPostponement, oldMaxDelay, oldMaxPostponements := restartPostponement, maxRestartDelay, maxRestartPostponements
+	restartPostponement, maxRestartDelay, maxRestartPostponements = postponement, maxDelay, maxPostponements
+	return func() {
+		restartPostponement, maxRestartDelay, maxRestartPostponements = oldPostponement, oldMaxDelay, oldMaxPostponements
+	}
+}
+
+func (s *daemonSuite) TestRestartPostponed(c *check.C) {
+	restore := mockRestartPostponement(200*time.Millisecond, time.Minute, 3)
+	defer restore()
+
+	d := newTestDaemon(c)
+
+	c.Assert(d.postponeRestart(), check.IsNil)
+	d.overlord.State().RequestRestart(state.RestartDaemon)
+
+	info := d.restartInfo()
+	c.Check(info.Pending, check.Equals, "daemon")
+	c.Check(info.PostponedUntil, check.NotNil)
+	c.Check(info.PostponementsLeft, check.Equals, 2)
+
+	select {
+	case <-d.Dying():
+		c.Fatal("restart was not postponed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a pending restart can be postponed further
+	c.Assert(d.postponeRestart(), check.IsNil)
+
+	select {
+	case <-d.Dying():
+	case <-time.After(2 * time.Second):
+		c.Fatal("restart was not carried out after the postponement")
+	}
+
+	c.Check(d.postponeRestart(), check.ErrorMatches, "restart is already in progress")
+	c.Check(d.restartInfo().PostponementsLeft, check.Equals, 0)
+}
+
+func mockRestartNoticeWait(wait time.Duration) (restore func()) {
+	old := restartNoticeWait
+	restartNoticeWait = wait
+	return func() {
+		restartNoticeWait = old
+	}
+}
+
+func (s *daemonSuite) TestPendingRestartPostponed(c *check.C) {
+	restore := mockRestartPostponement(200*time.Millisecond, time.Minute, 3)
+	defer restore()
+	restore = mockRestartNoticeWait(100 * time.Millisecond)
+	defer restore()
+
+	d := newTestDaemon(c)
+
+	// the restart is pending for a while before it is carried out
+	d.overlord.State().RequestRestart(state.RestartDaemon)
+	info := d.restartInfo()
+	c.Check(info.Pending, check.Equals, "daemon")
+	c.Check(info.PostponedUntil, check.IsNil)
+	c.Check(info.PostponementsLeft, check.Equals, 3)
+
+	// which lets clients postpone it
+	c.Assert(d.postponeRestart(), check.IsNil)
+
+	select {
+	case <-d.Dying():
+		c.Fatal("pending restart was not postponed")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	select {
+	case <-d.Dying():
+	case <-time.After(2 * time.Second):
+		c.Fatal("restart was not carried out after the postponement")
+	}
+}
+
+func (s *daemonSuite) TestPostponeRestartPolicy(c *check.C) {
+	restore := mockRestartPostponement(10*time.Minute, 25*time.Minute, 3)
+	defer restore()
+
+	d := newTestDaemon(c)
+
+	info := d.restartInfo()
+	c.Check(info, check.DeepEquals, &client.RestartInfo{PostponementsLeft: 3})
+
+	c.Assert(d.postponeRestart(), check.IsNil)
+	c.Check(d.restartPostponedUntil.Sub(time.Now()) > 9*time.Minute, check.Equals, true)
+
+	// a restart cannot be postponed past the deadline of the series
+	d.restartPostponedSince = time.Now().Add(-20 * time.Minute)
+	c.Assert(d.postponeRestart(), check.IsNil)
+	left := d.restartPostponedUntil.Sub(time.Now())
+	c.Check(left > 4*time.Minute && left <= 5*time.Minute, check.Equals, true)
+
+	d.restartPostponedSince = time.Now().Add(-25 * time.Minute)
+	c.Check(d.postponeRestart(), check.ErrorMatches, "restart cannot be postponed any further")
+
+	// nor more than the maximum number of times in a row
+	d.restartPostponedSince = time.Now()
+	c.Assert(d.postponeRestart(), check.IsNil)
+	c.Check(d.restartInfo().PostponementsLeft, check.Equals, 0)
+	c.Check(d.postponeRestart(), check.ErrorMatches, "restart was postponed too many times already")
+
+	// once the postponement is over a new series starts
+	d.restartPostponedUntil = time.Now().Add(-time.Second)
+	c.Check(d.restartInfo().PostponementsLeft, check.Equals, 3)
+	c.Assert(d.postponeRestart(), check.IsNil)
+	c.Check(d.restartInfo().PostponementsLeft, check.Equals, 2)
+}
+
 // This test tests that when the snapd calls a restart of the system
 // a sigterm (from e.g. systemd) is handled when it arrives before
 // stop is fully done.
@@ -897,6 +1080,47 @@ func (s *daemonSuite) TestRestartShutdownWithSigtermInBetween(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *daemonSuite) TestStopCarriesOutPendingRestartSystem(c *check.C) {
+	restore := mockRestartNoticeWait(time.Minute)
+	defer restore()
+	oldRebootNoticeWait := rebootNoticeWait
+	defer func() {
+		reboot = rebootImpl
+		rebootNoticeWait = oldRebootNoticeWait
+	}()
+	rebootNoticeWait = 0
+
+	var delays []time.Duration
+	reboot = func(d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	d := newTestDaemon(c)
+	makeDaemonListeners(c, d)
+	s.markSeeded(d)
+
+	c.Assert(d.Start(), check.IsNil)
+	st := d.overlord.State()
+
+	// the system restart is pending, and postponed, when the daemon
+	// is stopped
+	st.Lock()
+	st.RequestRestart(state.RestartSystem)
+	st.Unlock()
+	c.Assert(d.postponeRestart(), check.IsNil)
+
+	ch := make(chan os.Signal, 2)
+	ch <- syscall.SIGTERM
+	c.Assert(d.Stop(ch), check.IsNil)
+
+	// the fallback reboot and the reboot were still scheduled
+	c.Check(delays, check.HasLen, 2)
+	c.Check(delays[0], check.Equals, rebootWaitTimeout)
+	c.Check(d.restartSystem, check.Equals, true)
+	c.Check(d.postponeRestart(), check.ErrorMatches, "restart is already in progress")
+}
+
 // This test tests that when there is a shutdown we close the sigterm
 // handler so that systemd can kill snapd.
 func (s *daemonSuite) TestRestartShutdown(c *check.C) {
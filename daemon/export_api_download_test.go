@@ -22,6 +22,8 @@ package daemon
 var (
 	SnapDownloadCmd  = snapDownloadCmd
 	PostSnapDownload = postSnapDownload
+
+	ErrorKindSnapNoEntitlement = errorKindSnapNoEntitlement
 )
 
 type (
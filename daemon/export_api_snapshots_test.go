@@ -22,12 +22,14 @@ package daemon
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
 )
 
@@ -103,3 +105,25 @@ var (
 	SnapshotMany = snapshotMany
 	SnapshotCmd  = snapshotCmd
 )
+
+func MockSnapshotExport(newExport func(context.Context, uint64) (*backend.SnapshotExport, error)) (restore func()) {
+	oldExport := snapshotExport
+	snapshotExport = newExport
+	return func() {
+		snapshotExport = oldExport
+	}
+}
+
+func MockSnapshotImport(newImport func(context.Context, *state.State, io.Reader) (uint64, []string, error)) (restore func()) {
+	oldImport := snapshotImport
+	snapshotImport = newImport
+	return func() {
+		snapshotImport = oldImport
+	}
+}
+
+func GetSnapshotExport(c *Command, r *http.Request, user *auth.UserState) Response {
+	return getSnapshotExport(c, r, user)
+}
+
+var SnapshotExportCmd = snapshotExportCmd
@@ -23,6 +23,7 @@ import (
 	"net/http"
 
 	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/state"
 )
 
 type Resp = resp
@@ -49,3 +50,11 @@ func MockBuildID(mock string) (restore func()) {
 		buildID = old
 	}
 }
+
+func MockEnsureStateSoon(mock func(*state.State)) (restore func()) {
+	old := ensureStateSoon
+	ensureStateSoon = mock
+	return func() {
+		ensureStateSoon = old
+	}
+}
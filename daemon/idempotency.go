@@ -0,0 +1,226 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+const (
+	// idempotencyKeyTTL is for how long the change started by a
+	// request carrying an idempotency key is remembered
+	idempotencyKeyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLen is the maximum length of idempotency keys
+	maxIdempotencyKeyLen = 128
+)
+
+// idempotentRequest is what is remembered about a mutating request
+// that carried an idempotency key.
+type idempotentRequest struct {
+	Change string          `json:"change"`
+	Result json.RawMessage `json:"result,omitempty"`
+	// Fingerprint identifies the method, path and body of the
+	// request, see requestFingerprint
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// idempotentRequestID scopes the idempotency key of r to the user
+// making the request.
+func idempotentRequestID(r *http.Request, key string) string {
+	_, uid, _, _ := ucrednetGet(r.RemoteAddr)
+	return fmt.Sprintf("%d:%s", uid, key)
+}
+
+// requestFingerprint computes the fingerprint of a request from its
+// method, path and body, as the body is read.
+type requestFingerprint struct {
+	hash.Hash
+	body io.Reader
+}
+
+func newRequestFingerprint(r *http.Request) *requestFingerprint {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	fp := &requestFingerprint{Hash: h, body: r.Body}
+	if r.Body != nil {
+		r.Body = ioutil.NopCloser(io.TeeReader(r.Body, h))
+	}
+	return fp
+}
+
+// finish reads what is left of the body and returns the fingerprint.
+func (fp *requestFingerprint) finish() (string, error) {
+	if fp.body != nil {
+		if _, err := io.Copy(fp.Hash, fp.body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(fp.Sum(nil)), nil
+}
+
+// startIdempotent waits for any request with the same idempotent
+// request ID to be served, returning a function to call once the one
+// starting is.
+func (d *Daemon) startIdempotent(id string) (done func()) {
+	for {
+		d.idempotencyMu.Lock()
+		inFlight, ok := d.idempotentInFlight[id]
+		if !ok {
+			if d.idempotentInFlight == nil {
+				d.idempotentInFlight = make(map[string]chan struct{})
+			}
+			served := make(chan struct{})
+			d.idempotentInFlight[id] = served
+			d.idempotencyMu.Unlock()
+			return func() {
+				d.idempotencyMu.Lock()
+				delete(d.idempotentInFlight, id)
+				d.idempotencyMu.Unlock()
+				close(served)
+			}
+		}
+		d.idempotencyMu.Unlock()
+		<-inFlight
+	}
+}
+
+// serveIdempotent answers a mutating request carrying an idempotency
+// key. If an earlier request with the same key started a change that
+// still exists, the response to that request is given again, provided
+// the method, path and body of both requests match. Otherwise the
+// request is served and the change it starts, if any, is remembered.
+func (d *Daemon) serveIdempotent(r *http.Request, key string, serve func() Response) Response {
+	if len(key) > maxIdempotencyKeyLen {
+		return BadRequest("idempotency key is longer than %d characters", maxIdempotencyKeyLen)
+	}
+	id := idempotentRequestID(r, key)
+
+	// requests with the same key are served one at a time so that
+	// concurrent retries cannot both start a change, while others
+	// are not held up
+	done := d.startIdempotent(id)
+	defer done()
+
+	fp := newRequestFingerprint(r)
+
+	st := d.state
+	st.Lock()
+	reqs, err := idempotentRequests(st)
+	if err != nil {
+		st.Unlock()
+		return InternalError("cannot get idempotent requests: %v", err)
+	}
+	if req, ok := reqs[id]; ok && st.Change(req.Change) != nil {
+		st.Unlock()
+		fingerprint, err := fp.finish()
+		if err != nil {
+			return BadRequest("cannot read request body: %v", err)
+		}
+		if req.Fingerprint != "" && req.Fingerprint != fingerprint {
+			return Conflict("idempotency key %q was already used with a different request", key)
+		}
+		var result map[string]interface{}
+		if len(req.Result) > 0 {
+			if err := json.Unmarshal(req.Result, &result); err != nil {
+				return InternalError("cannot decode remembered result: %v", err)
+			}
+		}
+		return AsyncResponse(result, &Meta{Change: req.Change})
+	}
+	st.Unlock()
+
+	rsp := serve()
+
+	async, ok := rsp.(*resp)
+	if !ok || async.Type != ResponseTypeAsync || async.Meta == nil || async.Meta.Change == "" {
+		return rsp
+	}
+	fingerprint, err := fp.finish()
+	if err != nil {
+		// the change was started, but a retry would not be
+		// recognised
+		logger.Noticef("cannot read body of request with idempotency key %q: %v", key, err)
+		return rsp
+	}
+	req := &idempotentRequest{
+		Change:      async.Meta.Change,
+		Fingerprint: fingerprint,
+		Time:        time.Now(),
+	}
+	if async.Result != nil {
+		result, err := json.Marshal(async.Result)
+		if err != nil {
+			return InternalError("cannot encode result: %v", err)
+		}
+		req.Result = result
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	// read again, the state was unlocked while serving
+	reqs, err = idempotentRequests(st)
+	if err != nil {
+		return InternalError("cannot get idempotent requests: %v", err)
+	}
+	reqs[id] = req
+	st.Set("idempotent-requests", reqs)
+
+	return rsp
+}
+
+// idempotentRequests returns the remembered idempotent requests, with
+// the ones older than idempotencyKeyTTL pruned.
+func idempotentRequests(st *state.State) (map[string]*idempotentRequest, error) {
+	var reqs map[string]*idempotentRequest
+	if err := st.Get("idempotent-requests", &reqs); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if reqs == nil {
+		reqs = make(map[string]*idempotentRequest)
+	}
+	for id, req := range reqs {
+		if time.Since(req.Time) > idempotencyKeyTTL {
+			delete(reqs, id)
+		}
+	}
+	return reqs, nil
+}
+
+// idempotencyKey returns the idempotency key carried by a mutating
+// request, if any.
+func idempotencyKey(r *http.Request) string {
+	if r.Method == "GET" {
+		return ""
+	}
+	return r.Header.Get(client.IdempotencyKeyHeader)
+}
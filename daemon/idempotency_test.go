@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *daemonSuite) idempotentCommand(c *check.C) (cmd *Command, calls *int) {
+	d := newTestDaemon(c)
+	cmd = &Command{d: d, PolkitOK: "polkit.action"}
+	calls = new(int)
+	cmd.POST = func(*Command, *http.Request, *auth.UserState) Response {
+		*calls++
+		st := d.overlord.State()
+		st.Lock()
+		defer st.Unlock()
+		chg := st.NewChange("install-snap", "...")
+		return AsyncResponse(map[string]interface{}{"snap-names": []string{"foo"}}, &Meta{Change: chg.ID()})
+	}
+	return cmd, calls
+}
+
+func serveIdempotent(c *check.C, cmd *Command, key, uid, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/v2/snaps", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "pid=100;uid=" + uid + ";socket=;"
+	if key != "" {
+		req.Header.Set(client.IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	cmd.ServeHTTP(rec, req)
+	return rec
+}
+
+func postIdempotent(c *check.C, cmd *Command, key, uid string) (changeID string, result map[string]interface{}) {
+	rec := serveIdempotent(c, cmd, key, uid, "{}")
+	c.Assert(rec.Code, check.Equals, 202)
+
+	var rsp struct {
+		Change string                 `json:"change"`
+		Result map[string]interface{} `json:"result"`
+	}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	return rsp.Change, rsp.Result
+}
+
+func (s *daemonSuite) TestIdempotentRequestDeduplicated(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+
+	id1, result1 := postIdempotent(c, cmd, "some-key", "0")
+	id2, result2 := postIdempotent(c, cmd, "some-key", "0")
+	c.Check(*calls, check.Equals, 1)
+	c.Check(id1, check.Not(check.Equals), "")
+	c.Check(id2, check.Equals, id1)
+	c.Check(result2, check.DeepEquals, result1)
+	c.Check(result2, check.DeepEquals, map[string]interface{}{"snap-names": []interface{}{"foo"}})
+
+	// another key starts another change
+	id3, _ := postIdempotent(c, cmd, "other-key", "0")
+	c.Check(*calls, check.Equals, 2)
+	c.Check(id3, check.Not(check.Equals), id1)
+}
+
+func (s *daemonSuite) TestIdempotentRequestWithoutKey(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+
+	id1, _ := postIdempotent(c, cmd, "", "0")
+	id2, _ := postIdempotent(c, cmd, "", "0")
+	c.Check(*calls, check.Equals, 2)
+	c.Check(id2, check.Not(check.Equals), id1)
+}
+
+func (s *daemonSuite) TestIdempotentRequestScopedToUser(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+	s.authorized = true
+
+	id1, _ := postIdempotent(c, cmd, "some-key", "0")
+	id2, _ := postIdempotent(c, cmd, "some-key", "1000")
+	c.Check(*calls, check.Equals, 2)
+	c.Check(id2, check.Not(check.Equals), id1)
+}
+
+func (s *daemonSuite) TestIdempotentRequestExpired(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+
+	id1, _ := postIdempotent(c, cmd, "some-key", "0")
+
+	st := cmd.d.overlord.State()
+	st.Lock()
+	var reqs map[string]*idempotentRequest
+	c.Assert(st.Get("idempotent-requests", &reqs), check.IsNil)
+	c.Assert(reqs, check.HasLen, 1)
+	for _, req := range reqs {
+		req.Time = time.Now().Add(-idempotencyKeyTTL - time.Minute)
+	}
+	st.Set("idempotent-requests", reqs)
+	st.Unlock()
+
+	id2, _ := postIdempotent(c, cmd, "some-key", "0")
+	c.Check(*calls, check.Equals, 2)
+	c.Check(id2, check.Not(check.Equals), id1)
+}
+
+func (s *daemonSuite) TestIdempotentRequestKeyTooLong(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+
+	req, err := http.NewRequest("POST", "", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "pid=100;uid=0;socket=;"
+	req.Header.Set(client.IdempotencyKeyHeader, strings.Repeat("x", maxIdempotencyKeyLen+1))
+	rec := httptest.NewRecorder()
+	cmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, 400)
+	c.Check(*calls, check.Equals, 0)
+}
+
+func (s *daemonSuite) TestIdempotentRequestDifferentRequest(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+
+	postIdempotent(c, cmd, "some-key", "0")
+
+	// the key cannot be reused for another request
+	rec := serveIdempotent(c, cmd, "some-key", "0", `{"action": "remove"}`)
+	c.Check(rec.Code, check.Equals, 409)
+	c.Check(rec.Body.String(), testutil.Contains, `idempotency key \"some-key\" was already used with a different request`)
+	c.Check(*calls, check.Equals, 1)
+}
+
+func (s *daemonSuite) TestIdempotentRequestsServedConcurrently(c *check.C) {
+	cmd, calls := s.idempotentCommand(c)
+	post := cmd.POST
+	serving := make(chan struct{})
+	release := make(chan struct{})
+	cmd.POST = func(cmd *Command, r *http.Request, user *auth.UserState) Response {
+		if r.Header.Get(client.IdempotencyKeyHeader) == "slow-key" {
+			close(serving)
+			<-release
+		}
+		return post(cmd, r, user)
+	}
+
+	slow := make(chan string, 2)
+	go func() {
+		id, _ := postIdempotent(c, cmd, "slow-key", "0")
+		slow <- id
+	}()
+	<-serving
+
+	// a request with another key is not held up
+	postIdempotent(c, cmd, "other-key", "0")
+	c.Check(*calls, check.Equals, 1)
+
+	// while a retry waits for the first request to be served
+	go func() {
+		id, _ := postIdempotent(c, cmd, "slow-key", "0")
+		slow <- id
+	}()
+	select {
+	case <-slow:
+		c.Fatal("retry served before the first request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	id1 := <-slow
+	id2 := <-slow
+	c.Check(id1, check.Not(check.Equals), "")
+	c.Check(id2, check.Equals, id1)
+	c.Check(*calls, check.Equals, 2)
+}
@@ -73,6 +73,21 @@ func (r *resp) transmitMaintenance(kind errorKind, message string) {
 	}
 }
 
+// transmitVersionChange transmits a maintenance hint that the daemon
+// version changed since the version seen by the client, for example
+// because snapd was refreshed, so that the client can refresh its view of
+// the capabilities of the daemon.
+func (r *resp) transmitVersionChange(seen, current string) {
+	r.Maintenance = &errorResult{
+		Kind:    errorKindDaemonVersionChanged,
+		Message: fmt.Sprintf("snapd changed from version %s to %s", seen, current),
+		Value: map[string]interface{}{
+			"previous": seen,
+			"current":  current,
+		},
+	}
+}
+
 func (r *resp) addWarningsToMeta(count int, stamp time.Time) {
 	if r.Meta != nil && r.Meta.WarningCount != 0 {
 		return
@@ -93,11 +108,12 @@ func (r *resp) addWarningsToMeta(count int, stamp time.Time) {
 //      these fields inside resp.
 // Increment the counter if you read this: 42
 type Meta struct {
-	Sources           []string   `json:"sources,omitempty"`
-	SuggestedCurrency string     `json:"suggested-currency,omitempty"`
-	Change            string     `json:"change,omitempty"`
-	WarningTimestamp  *time.Time `json:"warning-timestamp,omitempty"`
-	WarningCount      int        `json:"warning-count,omitempty"`
+	Sources           []string          `json:"sources,omitempty"`
+	SuggestedCurrency string            `json:"suggested-currency,omitempty"`
+	Change            string            `json:"change,omitempty"`
+	WarningTimestamp  *time.Time        `json:"warning-timestamp,omitempty"`
+	WarningCount      int               `json:"warning-count,omitempty"`
+	Origins           map[string]string `json:"origins,omitempty"`
 }
 
 type respJSON struct {
@@ -177,6 +193,7 @@ const (
 	errorKindSnapNeedsClassic       = errorKind("snap-needs-classic")
 	errorKindSnapNeedsClassicSystem = errorKind("snap-needs-classic-system")
 	errorKindSnapNotClassic         = errorKind("snap-not-classic")
+	errorKindSnapNoEntitlement      = errorKind("snap-no-entitlement")
 
 	errorKindBadQuery = errorKind("bad-query")
 
@@ -185,9 +202,15 @@ const (
 	errorKindInterfacesUnchanged = errorKind("interfaces-unchanged")
 
 	errorKindConfigNoSuchOption = errorKind("option-not-found")
+	errorKindInvalidConfig      = errorKind("invalid-config")
 
-	errorKindDaemonRestart = errorKind("daemon-restart")
-	errorKindSystemRestart = errorKind("system-restart")
+	errorKindAssertionPrerequisiteMissing = errorKind("assertion-prerequisite-missing")
+	errorKindAssertionRevisionConflict    = errorKind("assertion-revision-conflict")
+	errorKindAssertionUnsupportedFormat   = errorKind("assertion-unsupported-format")
+
+	errorKindDaemonRestart        = errorKind("daemon-restart")
+	errorKindSystemRestart        = errorKind("system-restart")
+	errorKindDaemonVersionChanged = errorKind("daemon-version-changed")
 )
 
 type errorValue interface{}
@@ -249,6 +272,7 @@ func makeErrorResponder(status int) errorResponder {
 // A FileStream ServeHTTP method streams the snap
 type fileStream struct {
 	SnapName string
+	Revision snap.Revision
 	Info     snap.DownloadInfo
 	stream   io.ReadCloser
 }
@@ -259,6 +283,13 @@ func (s fileStream) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	hdr.Set("Content-Type", "application/octet-stream")
 	snapname := fmt.Sprintf("attachment; filename=%s", s.SnapName)
 	hdr.Set("Content-Disposition", snapname)
+	// let clients name and verify the snap
+	if !s.Revision.Unset() {
+		hdr.Set("Snap-Revision", s.Revision.String())
+	}
+	if s.Info.Sha3_384 != "" {
+		hdr.Set("Snap-Sha3-384", s.Info.Sha3_384)
+	}
 
 	size := fmt.Sprintf("%d", s.Info.Size)
 	hdr.Set("Content-Length", size)
@@ -289,7 +320,9 @@ func (f fileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // be, each one on its own, a JSON dump of a systemd.Log, as output by
 // journalctl -o json) from an io.ReadCloser, loads that into a client.Log, and
 // outputs the json dump of that, padded with RS and LF to make it a valid
-// json-seq response.
+// json-seq response. Each entry is labelled with the app it comes from, as
+// per the services map of unit names, and carries its journal cursor so
+// that clients can resume from it.
 //
 // The reader is always closed when done (this is important for
 // osutil.WatingStdoutPipe).
@@ -297,7 +330,8 @@ func (f fileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // Tip: “jq” knows how to read this; “jq --seq” both reads and writes this.
 type journalLineReaderSeqResponse struct {
 	io.ReadCloser
-	follow bool
+	follow   bool
+	services map[string]string
 }
 
 func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -324,6 +358,8 @@ func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http
 			Message:   log.Message(),
 			SID:       log.SID(),
 			PID:       log.PID(),
+			Service:   rr.services[log.Unit()],
+			Cursor:    log.Cursor(),
 		}); err != nil {
 			break
 		}
@@ -579,6 +615,9 @@ func errToResponse(err error, snaps []string, fallback func(format string, v ...
 		case *snapstate.SnapNotClassicError:
 			kind = errorKindSnapNotClassic
 			snapName = err.Snap
+		case *store.NoEntitlementError:
+			kind = errorKindSnapNoEntitlement
+			snapName = err.Snap
 		case net.Error:
 			if err.Timeout() {
 				kind = errorKindNetworkTimeout
@@ -46,6 +46,7 @@ var (
 	SnapAppArmorAdditionalDir string
 	SnapConfineAppArmorDir    string
 	SnapSeccompDir            string
+	SnapSELinuxPolicyDir      string
 	SnapMountPolicyDir        string
 	SnapUdevRulesDir          string
 	SnapKModModulesDir        string
@@ -57,8 +58,12 @@ var (
 	SnapRunNsDir              string
 	SnapRunLockDir            string
 
-	SnapSeedDir   string
-	SnapDeviceDir string
+	SnapSeedDir        string
+	SnapSeedSystemsDir string
+	SnapDeviceDir      string
+	SnapFDEDir         string
+
+	SnapFactoryResetFile string
 
 	SnapAssertsDBDir      string
 	SnapCookieDir         string
@@ -69,6 +74,8 @@ var (
 	SnapStateFile     string
 	SnapSystemKeyFile string
 
+	SnapInterfacesAuditFile string
+
 	SnapRepairDir        string
 	SnapRepairStateFile  string
 	SnapRepairRunDir     string
@@ -77,11 +84,12 @@ var (
 
 	SnapRollbackDir string
 
-	SnapCacheDir        string
-	SnapNamesFile       string
-	SnapSectionsFile    string
-	SnapCommandsDB      string
-	SnapAuxStoreInfoDir string
+	SnapCacheDir                 string
+	SnapNamesFile                string
+	SnapSectionsFile             string
+	SnapCommandsDB               string
+	SnapAuxStoreInfoDir          string
+	SnapAppArmorCompiledCacheDir string
 
 	SnapBinariesDir     string
 	SnapServicesDir     string
@@ -229,6 +237,7 @@ func SetRootDir(rootdir string) {
 	SnapAppArmorAdditionalDir = filepath.Join(rootdir, snappyDir, "apparmor", "additional")
 	SnapDownloadCacheDir = filepath.Join(rootdir, snappyDir, "cache")
 	SnapSeccompDir = filepath.Join(rootdir, snappyDir, "seccomp", "bpf")
+	SnapSELinuxPolicyDir = filepath.Join(rootdir, snappyDir, "selinux", "modules")
 	SnapMountPolicyDir = filepath.Join(rootdir, snappyDir, "mount")
 	SnapMetaDir = filepath.Join(rootdir, snappyDir, "meta")
 	SnapBlobDir = filepath.Join(rootdir, snappyDir, "snaps")
@@ -249,14 +258,21 @@ func SetRootDir(rootdir string) {
 	SnapStateFile = filepath.Join(rootdir, snappyDir, "state.json")
 	SnapSystemKeyFile = filepath.Join(rootdir, snappyDir, "system-key")
 
+	SnapInterfacesAuditFile = filepath.Join(rootdir, snappyDir, "interfaces-audit.log")
+
 	SnapCacheDir = filepath.Join(rootdir, "/var/cache/snapd")
 	SnapNamesFile = filepath.Join(SnapCacheDir, "names")
 	SnapSectionsFile = filepath.Join(SnapCacheDir, "sections")
 	SnapCommandsDB = filepath.Join(SnapCacheDir, "commands.db")
 	SnapAuxStoreInfoDir = filepath.Join(SnapCacheDir, "aux")
+	SnapAppArmorCompiledCacheDir = filepath.Join(SnapCacheDir, "apparmor")
 
 	SnapSeedDir = filepath.Join(rootdir, snappyDir, "seed")
+	SnapSeedSystemsDir = filepath.Join(SnapSeedDir, "systems")
 	SnapDeviceDir = filepath.Join(rootdir, snappyDir, "device")
+	SnapFDEDir = filepath.Join(SnapDeviceDir, "fde")
+
+	SnapFactoryResetFile = filepath.Join(rootdir, snappyDir, "factory-reset.json")
 
 	SnapRepairDir = filepath.Join(rootdir, snappyDir, "repair")
 	SnapRepairStateFile = filepath.Join(SnapRepairDir, "repair.json")
@@ -43,6 +43,12 @@ const (
 	PerUserMountNamespace
 	// RefreshAppAwareness controls refresh being aware of running applications.
 	RefreshAppAwareness
+	// SELinuxPolicy controls confining snaps with generated SELinux policy modules.
+	SELinuxPolicy
+	// SeccompOverrides controls applying the reviewed seccomp overrides requested by snaps.
+	SeccompOverrides
+	// LANDownloadPeers controls sharing downloaded snaps with, and downloading them from, the local network.
+	LANDownloadPeers
 	// lastFeature is the final known feature, it is only used for testing.
 	lastFeature
 )
@@ -65,6 +71,9 @@ var featureNames = map[SnapdFeature]string{
 	SnapdSnap:             "snapd-snap",
 	PerUserMountNamespace: "per-user-mount-namespace",
 	RefreshAppAwareness:   "refresh-app-awareness",
+	SELinuxPolicy:         "selinux-policy",
+	SeccompOverrides:      "seccomp-overrides",
+	LANDownloadPeers:      "lan-download-peers",
 }
 
 // featuresEnabledWhenUnset contains a set of features that are enabled when not explicitly configured.
@@ -76,6 +85,8 @@ var featuresEnabledWhenUnset = map[SnapdFeature]bool{
 var featuresExported = map[SnapdFeature]bool{
 	PerUserMountNamespace: true,
 	RefreshAppAwareness:   true,
+	SELinuxPolicy:         true,
+	SeccompOverrides:      true,
 }
 
 // String returns the name of a snapd feature.
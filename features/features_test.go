@@ -43,6 +43,9 @@ func (*featureSuite) TestName(c *C) {
 	c.Check(features.SnapdSnap.String(), Equals, "snapd-snap")
 	c.Check(features.PerUserMountNamespace.String(), Equals, "per-user-mount-namespace")
 	c.Check(features.RefreshAppAwareness.String(), Equals, "refresh-app-awareness")
+	c.Check(features.SELinuxPolicy.String(), Equals, "selinux-policy")
+	c.Check(features.SeccompOverrides.String(), Equals, "seccomp-overrides")
+	c.Check(features.LANDownloadPeers.String(), Equals, "lan-download-peers")
 	c.Check(func() { _ = features.SnapdFeature(1000).String() }, PanicMatches, "unknown feature flag code 1000")
 }
 
@@ -62,6 +65,9 @@ func (*featureSuite) TestIsExported(c *C) {
 	c.Check(features.SnapdSnap.IsExported(), Equals, false)
 	c.Check(features.PerUserMountNamespace.IsExported(), Equals, true)
 	c.Check(features.RefreshAppAwareness.IsExported(), Equals, true)
+	c.Check(features.SELinuxPolicy.IsExported(), Equals, true)
+	c.Check(features.SeccompOverrides.IsExported(), Equals, true)
+	c.Check(features.LANDownloadPeers.IsExported(), Equals, false)
 }
 
 func (*featureSuite) TestIsEnabled(c *C) {
@@ -90,11 +96,16 @@ func (*featureSuite) TestIsEnabledWhenUnset(c *C) {
 	c.Check(features.SnapdSnap.IsEnabledWhenUnset(), Equals, false)
 	c.Check(features.PerUserMountNamespace.IsEnabledWhenUnset(), Equals, false)
 	c.Check(features.RefreshAppAwareness.IsEnabledWhenUnset(), Equals, false)
+	c.Check(features.SELinuxPolicy.IsEnabledWhenUnset(), Equals, false)
+	c.Check(features.SeccompOverrides.IsEnabledWhenUnset(), Equals, false)
+	c.Check(features.LANDownloadPeers.IsEnabledWhenUnset(), Equals, false)
 }
 
 func (*featureSuite) TestControlFile(c *C) {
 	c.Check(features.PerUserMountNamespace.ControlFile(), Equals, "/var/lib/snapd/features/per-user-mount-namespace")
 	c.Check(features.RefreshAppAwareness.ControlFile(), Equals, "/var/lib/snapd/features/refresh-app-awareness")
+	c.Check(features.SELinuxPolicy.ControlFile(), Equals, "/var/lib/snapd/features/selinux-policy")
+	c.Check(features.SeccompOverrides.ControlFile(), Equals, "/var/lib/snapd/features/seccomp-overrides")
 	// Features that are not exported don't have a control file.
 	c.Check(features.Layouts.ControlFile, PanicMatches, `cannot compute the control file of feature "layouts" because that feature is not exported`)
 }
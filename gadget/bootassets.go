@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package gadget
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "golang.org/x/crypto/sha3"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// bootAssetNames are the names of the boot assets (shim, grub and the
+// u-boot environment) whose integrity is tracked.
+var bootAssetNames = []string{
+	// shim
+	"shim.efi.signed", "bootx64.efi", "bootaa64.efi",
+	// grub
+	"grubx64.efi", "grubaa64.efi", "grub.cfg",
+	// u-boot
+	"uboot.env", "boot.sel",
+}
+
+// IsBootAsset returns whether the file with the given name is a boot
+// asset whose integrity is tracked.
+func IsBootAsset(name string) bool {
+	return strutil.ListContains(bootAssetNames, filepath.Base(name))
+}
+
+// BootAssetDigest returns the hex encoded sha3-384 digest of the boot
+// asset at the given path.
+func BootAssetDigest(path string) (string, error) {
+	digest, _, err := osutil.FileDigest(path, crypto.SHA3_384)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", digest), nil
+}
+
+// BootConfigMigrationFunc carries the bootloader state kept in the
+// file at current, about to be replaced by a gadget update, over to
+// update, a copy of the file from the update that replaces it.
+type BootConfigMigrationFunc func(current, update string) error
+
+var bootConfigMigrations = make(map[string]BootConfigMigrationFunc)
+
+// RegisterBootConfigMigration registers how to migrate files with the
+// given name when gadget updates replace them in mounted filesystems,
+// so that bootloader configuration or environment is transformed
+// rather than blindly replaced. A failed update rolls back to the
+// original file.
+func RegisterBootConfigMigration(name string, migrate BootConfigMigrationFunc) {
+	bootConfigMigrations[name] = migrate
+}
+
+var bootAssetsMountLookup = FindMountPointForStructure
+
+// BootAssetsDigests returns the digests of the boot assets found in the
+// mounted filesystems of the structures with the system-boot role of
+// the given gadget, keyed by their absolute path.
+func BootAssetsDigests(gd GadgetData) (map[string]string, error) {
+	digests := make(map[string]string)
+	for name, vol := range gd.Info.Volumes {
+		pv, err := PositionVolume(gd.RootDir, &vol, defaultConstraints)
+		if err != nil {
+			return nil, fmt.Errorf("cannot lay out volume %q: %v", name, err)
+		}
+		for i := range pv.PositionedStructure {
+			ps := &pv.PositionedStructure[i]
+			if ps.IsBare() || ps.EffectiveRole() != SystemBoot {
+				continue
+			}
+			mountPoint, err := bootAssetsMountLookup(ps)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find mount point of volume structure %v: %v", ps, err)
+			}
+			err = filepath.Walk(mountPoint, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() || !IsBootAsset(path) {
+					return nil
+				}
+				digest, err := BootAssetDigest(path)
+				if err != nil {
+					return err
+				}
+				digests[path] = digest
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot compute boot assets digests of volume structure %v: %v", ps, err)
+			}
+		}
+	}
+	return digests, nil
+}
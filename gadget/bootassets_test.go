@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package gadget_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/crypto/sha3"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+)
+
+type bootAssetsTestSuite struct{}
+
+var _ = Suite(&bootAssetsTestSuite{})
+
+func (s *bootAssetsTestSuite) TestIsBootAsset(c *C) {
+	for _, name := range []string{"EFI/boot/bootx64.efi", "EFI/ubuntu/grub.cfg", "grubx64.efi", "uboot.env", "boot.sel", "shim.efi.signed"} {
+		c.Check(gadget.IsBootAsset(name), Equals, true, Commentf(name))
+	}
+	for _, name := range []string{"EFI/ubuntu/grubenv", "config.txt", "grub.cfg.bak"} {
+		c.Check(gadget.IsBootAsset(name), Equals, false, Commentf(name))
+	}
+}
+
+func (s *bootAssetsTestSuite) TestBootAssetsDigests(c *C) {
+	gadgetRoot := c.MkDir()
+	bootDir := c.MkDir()
+	makeGadgetData(c, bootDir, []gadgetData{
+		{name: "EFI/boot/bootx64.efi", content: "shim"},
+		{name: "EFI/boot/grubx64.efi", content: "grub"},
+		{name: "EFI/ubuntu/grub.cfg", content: "grub config"},
+		{name: "EFI/ubuntu/grubenv", content: "grub env"},
+		{name: "other", content: "other"},
+	})
+
+	info := &gadget.Info{
+		Volumes: map[string]gadget.Volume{
+			"pc": {
+				Bootloader: "grub",
+				Structure: []gadget.VolumeStructure{
+					{Name: "mbr", Type: "mbr", Role: gadget.MBR, Size: 440},
+					{Name: "EFI System", Type: "EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B", Role: gadget.SystemBoot, Filesystem: "vfat", Size: gadget.SizeMiB},
+					{Name: "writable", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Role: gadget.SystemData, Filesystem: "ext4", Size: gadget.SizeMiB},
+				},
+			},
+		},
+	}
+
+	var looked []string
+	restore := gadget.MockBootAssetsMountLookup(func(ps *gadget.PositionedStructure) (string, error) {
+		looked = append(looked, ps.Name)
+		return bootDir, nil
+	})
+	defer restore()
+
+	digests, err := gadget.BootAssetsDigests(gadget.GadgetData{Info: info, RootDir: gadgetRoot})
+	c.Assert(err, IsNil)
+	c.Check(looked, DeepEquals, []string{"EFI System"})
+	digestOf := func(content string) string {
+		return fmt.Sprintf("%x", sha3.Sum384([]byte(content)))
+	}
+	c.Check(digests, DeepEquals, map[string]string{
+		filepath.Join(bootDir, "EFI/boot/bootx64.efi"): digestOf("shim"),
+		filepath.Join(bootDir, "EFI/boot/grubx64.efi"): digestOf("grub"),
+		filepath.Join(bootDir, "EFI/ubuntu/grub.cfg"):  digestOf("grub config"),
+	})
+
+	digest, err := gadget.BootAssetDigest(filepath.Join(bootDir, "EFI/boot/bootx64.efi"))
+	c.Assert(err, IsNil)
+	c.Check(digest, Equals, digestOf("shim"))
+
+	restore = gadget.MockBootAssetsMountLookup(func(ps *gadget.PositionedStructure) (string, error) {
+		return "", errors.New("not mounted")
+	})
+	defer restore()
+	_, err = gadget.BootAssetsDigests(gadget.GadgetData{Info: info, RootDir: gadgetRoot})
+	c.Assert(err, ErrorMatches, `cannot find mount point of volume structure #1 \("EFI System"\): not mounted`)
+}
@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+const (
+	// cmdlineExtraFile holds kernel command line arguments appended to
+	// the default ones of the bootloader configuration.
+	cmdlineExtraFile = "cmdline.extra"
+	// cmdlineFullFile holds the kernel command line replacing the
+	// default one of the bootloader configuration.
+	cmdlineFullFile = "cmdline.full"
+)
+
+// KernelCmdlineConstraints declares which kernel command line arguments
+// the gadget can set with cmdline.extra or cmdline.full, unless the
+// model declares them instead.
+type KernelCmdlineConstraints struct {
+	// Allow lists the allowed arguments, either as a parameter name,
+	// allowing any value, or as name=value, allowing only that value.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+func validateKernelCmdlineConstraints(kc *KernelCmdlineConstraints) error {
+	for _, allowed := range kc.Allow {
+		if allowed == "" || strings.ContainsAny(allowed, " \t\n\"") {
+			return fmt.Errorf("invalid allowed kernel command line argument %q", allowed)
+		}
+		if isSnapdKernelCmdlineArg(allowed) {
+			return fmt.Errorf("kernel command line argument %q is reserved to snapd", allowed)
+		}
+	}
+	return nil
+}
+
+// isSnapdKernelCmdlineArg returns whether the argument is one of the
+// arguments set by snapd itself through the bootloader.
+func isSnapdKernelCmdlineArg(arg string) bool {
+	name := strings.SplitN(arg, "=", 2)[0]
+	return strings.HasPrefix(name, "snap_") || strings.HasPrefix(name, "snapd_")
+}
+
+// KernelCmdline is the kernel command line provided by a gadget.
+type KernelCmdline struct {
+	// Extra are the arguments appended to the default ones.
+	Extra string
+	// Full is the command line replacing the default one.
+	Full string
+}
+
+func parseKernelCmdline(content []byte) ([]string, error) {
+	var args []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "\"") {
+			return nil, fmt.Errorf("quoted kernel command line arguments are not supported")
+		}
+		args = append(args, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func kernelCmdlineArgAllowed(arg string, allow []string) bool {
+	name := strings.SplitN(arg, "=", 2)[0]
+	for _, allowed := range allow {
+		if allowed == arg || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadKernelCmdline reads and validates the kernel command line provided
+// by the gadget with the given root directory in cmdline.extra or
+// cmdline.full. The arguments must be allowed by modelAllow, the
+// kernel-cmdline-allow list of the model, or if the model has none by
+// the kernel-cmdline constraints in the gadget.yaml of the gadget. It
+// returns nil if the gadget provides none.
+func ReadKernelCmdline(gadgetSnapRootDir string, modelAllow []string) (*KernelCmdline, error) {
+	extraPath := filepath.Join(gadgetSnapRootDir, cmdlineExtraFile)
+	fullPath := filepath.Join(gadgetSnapRootDir, cmdlineFullFile)
+	hasExtra := osutil.FileExists(extraPath)
+	hasFull := osutil.FileExists(fullPath)
+
+	var path string
+	switch {
+	case hasExtra && hasFull:
+		return nil, fmt.Errorf("cannot use both %s and %s", cmdlineExtraFile, cmdlineFullFile)
+	case hasExtra:
+		path = extraPath
+	case hasFull:
+		path = fullPath
+	default:
+		return nil, nil
+	}
+	name := filepath.Base(path)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read %s: %v", name, err)
+	}
+	args, err := parseKernelCmdline(content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", name, err)
+	}
+	allow := modelAllow
+	if len(allow) == 0 {
+		gi, err := ReadInfo(gadgetSnapRootDir, false)
+		if err != nil {
+			return nil, err
+		}
+		allow = gi.KernelCmdline.Allow
+	}
+	for _, arg := range args {
+		if isSnapdKernelCmdlineArg(arg) {
+			return nil, fmt.Errorf("invalid %s: argument %q is reserved to snapd", name, arg)
+		}
+		if !kernelCmdlineArgAllowed(arg, allow) {
+			return nil, fmt.Errorf("invalid %s: argument %q is not allowed", name, arg)
+		}
+	}
+
+	cmdline := strings.Join(args, " ")
+	if hasFull {
+		return &KernelCmdline{Full: cmdline}, nil
+	}
+	return &KernelCmdline{Extra: cmdline}, nil
+}
@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+)
+
+type cmdlineTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&cmdlineTestSuite{})
+
+const mockCmdlineVolumesYaml = `
+volumes:
+  pc:
+    bootloader: grub
+`
+
+var mockCmdlineGadgetYaml = mockCmdlineVolumesYaml + `
+kernel-cmdline:
+  allow:
+    - console
+    - isolcpus
+    - quiet=1
+`
+
+func (s *cmdlineTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(s.dir, "meta"), 0755), IsNil)
+	s.writeFile(c, "meta/gadget.yaml", mockCmdlineGadgetYaml)
+}
+
+func (s *cmdlineTestSuite) writeFile(c *C, name, content string) {
+	err := ioutil.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644)
+	c.Assert(err, IsNil)
+}
+
+func (s *cmdlineTestSuite) TestReadInfoKernelCmdlineConstraints(c *C) {
+	gi, err := gadget.ReadInfo(s.dir, false)
+	c.Assert(err, IsNil)
+	c.Check(gi.KernelCmdline, DeepEquals, gadget.KernelCmdlineConstraints{
+		Allow: []string{"console", "isolcpus", "quiet=1"},
+	})
+}
+
+func (s *cmdlineTestSuite) TestInvalidKernelCmdlineConstraints(c *C) {
+	for _, tc := range []struct {
+		allow string
+		err   string
+	}{
+		{`""`, `invalid allowed kernel command line argument ""`},
+		{`"console tty1"`, `invalid allowed kernel command line argument "console tty1"`},
+		{"snap_mode", `kernel command line argument "snap_mode" is reserved to snapd`},
+	} {
+		gadgetYaml := []byte("kernel-cmdline:\n  allow:\n    - " + tc.allow + "\n")
+		_, err := gadget.InfoFromGadgetYaml(gadgetYaml, false)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineNone(c *C) {
+	cmdline, err := gadget.ReadKernelCmdline(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(cmdline, IsNil)
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineExtra(c *C) {
+	s.writeFile(c, "cmdline.extra", `# serial console
+console=ttyS0,115200n8 console=tty1
+
+isolcpus=1,2 quiet=1
+`)
+	cmdline, err := gadget.ReadKernelCmdline(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(cmdline, DeepEquals, &gadget.KernelCmdline{
+		Extra: "console=ttyS0,115200n8 console=tty1 isolcpus=1,2 quiet=1",
+	})
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineFull(c *C) {
+	s.writeFile(c, "cmdline.full", "console=tty1 isolcpus=3\n")
+	cmdline, err := gadget.ReadKernelCmdline(s.dir, nil)
+	c.Assert(err, IsNil)
+	c.Check(cmdline, DeepEquals, &gadget.KernelCmdline{
+		Full: "console=tty1 isolcpus=3",
+	})
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineModelAllow(c *C) {
+	s.writeFile(c, "cmdline.extra", "panic=-1 console=tty1")
+
+	// the model overrides the constraints of the gadget
+	cmdline, err := gadget.ReadKernelCmdline(s.dir, []string{"panic", "console=tty1"})
+	c.Assert(err, IsNil)
+	c.Check(cmdline, DeepEquals, &gadget.KernelCmdline{
+		Extra: "panic=-1 console=tty1",
+	})
+
+	_, err = gadget.ReadKernelCmdline(s.dir, []string{"panic"})
+	c.Check(err, ErrorMatches, `invalid cmdline.extra: argument "console=tty1" is not allowed`)
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineNothingAllowed(c *C) {
+	s.writeFile(c, "meta/gadget.yaml", mockCmdlineVolumesYaml)
+	s.writeFile(c, "cmdline.extra", "console=tty1")
+
+	_, err := gadget.ReadKernelCmdline(s.dir, nil)
+	c.Check(err, ErrorMatches, `invalid cmdline.extra: argument "console=tty1" is not allowed`)
+}
+
+func (s *cmdlineTestSuite) TestReadKernelCmdlineErrors(c *C) {
+	for _, tc := range []struct {
+		files map[string]string
+		err   string
+	}{{
+		files: map[string]string{"cmdline.extra": "console=tty1", "cmdline.full": "console=tty1"},
+		err:   "cannot use both cmdline.extra and cmdline.full",
+	}, {
+		files: map[string]string{"cmdline.extra": "init=/bin/sh"},
+		err:   `invalid cmdline.extra: argument "init=/bin/sh" is not allowed`,
+	}, {
+		files: map[string]string{"cmdline.full": "quiet=0"},
+		err:   `invalid cmdline.full: argument "quiet=0" is not allowed`,
+	}, {
+		files: map[string]string{"cmdline.extra": "snap_core=core_1.snap"},
+		err:   `invalid cmdline.extra: argument "snap_core=core_1.snap" is reserved to snapd`,
+	}, {
+		files: map[string]string{"cmdline.extra": `console="tty1"`},
+		err:   "cannot parse cmdline.extra: quoted kernel command line arguments are not supported",
+	}} {
+		dir := c.MkDir()
+		c.Assert(os.MkdirAll(filepath.Join(dir, "meta"), 0755), IsNil)
+		err := ioutil.WriteFile(filepath.Join(dir, "meta", "gadget.yaml"), []byte(mockCmdlineGadgetYaml), 0644)
+		c.Assert(err, IsNil)
+		for name, content := range tc.files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+			c.Assert(err, IsNil)
+		}
+
+		_, err = gadget.ReadKernelCmdline(dir, nil)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *cmdlineTestSuite) TestReadInfoIgnoresKernelCmdline(c *C) {
+	s.writeFile(c, "cmdline.extra", "init=/bin/sh")
+
+	// a bad command line is only refused when applied
+	_, err := gadget.ReadInfo(s.dir, false)
+	c.Check(err, IsNil)
+}
@@ -35,3 +35,7 @@ func FindDeviceForStructureWithFallback(ps *PositionedStructure) (string, Size,
 func FindMountPointForStructure(ps *PositionedStructure) (string, error) {
 	return "", errNotImplemented
 }
+
+func ParentDisk(partition string) (string, error) {
+	return "", errNotImplemented
+}
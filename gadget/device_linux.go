@@ -216,8 +216,14 @@ func findParentDeviceWithWritableFallback() (string, error) {
 		return "", err
 	}
 
+	return ParentDisk(deviceWritable)
+}
+
+// ParentDisk returns the device node of the disk holding the given
+// partition device node.
+func ParentDisk(partition string) (string, error) {
 	// /dev/sda3 -> sda3
-	devname := filepath.Base(deviceWritable)
+	devname := filepath.Base(partition)
 
 	// do not bother with investigating major/minor devices (inconsistent
 	// across block device types) or mangling strings, but look at sys
@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var validKeyProtectorType = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// Encryption holds the settings for the encryption of the data
+// partitions of devices using the gadget.
+type Encryption struct {
+	// KeyProtectors lists the protectors of the encryption keys in
+	// order of preference; the first one that is available on the
+	// device is used.
+	KeyProtectors []KeyProtector `yaml:"key-protectors"`
+}
+
+// KeyProtector selects a protector of encryption keys, e.g. "tpm2",
+// "token" or "kms", along with its options.
+type KeyProtector struct {
+	Type    string            `yaml:"type"`
+	Options map[string]string `yaml:",inline"`
+}
+
+func validateEncryption(enc *Encryption) error {
+	if len(enc.KeyProtectors) == 0 {
+		return fmt.Errorf("invalid encryption: no key protectors")
+	}
+	for _, kp := range enc.KeyProtectors {
+		if !validKeyProtectorType.MatchString(kp.Type) {
+			return fmt.Errorf("invalid encryption: invalid key protector type %q", kp.Type)
+		}
+	}
+	return nil
+}
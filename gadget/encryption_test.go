@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+)
+
+type encryptionTestSuite struct{}
+
+var _ = Suite(&encryptionTestSuite{})
+
+func (s *encryptionTestSuite) TestEncryption(c *C) {
+	gi, err := gadget.InfoFromGadgetYaml([]byte(`
+encryption:
+  key-protectors:
+    - type: tpm2
+    - type: token
+      path: /run/mnt/fde-token/key
+    - type: kms
+      url: https://kms.example.com/v1
+`), true)
+	c.Assert(err, IsNil)
+	c.Check(gi.Encryption, DeepEquals, &gadget.Encryption{
+		KeyProtectors: []gadget.KeyProtector{
+			{Type: "tpm2"},
+			{Type: "token", Options: map[string]string{"path": "/run/mnt/fde-token/key"}},
+			{Type: "kms", Options: map[string]string{"url": "https://kms.example.com/v1"}},
+		},
+	})
+}
+
+func (s *encryptionTestSuite) TestNoEncryption(c *C) {
+	gi, err := gadget.InfoFromGadgetYaml([]byte(`
+defaults:
+  system:
+    something: true
+`), true)
+	c.Assert(err, IsNil)
+	c.Check(gi.Encryption, IsNil)
+}
+
+func (s *encryptionTestSuite) TestEncryptionErrors(c *C) {
+	for _, tc := range []struct {
+		yaml string
+		err  string
+	}{
+		{"encryption: {}", "invalid encryption: no key protectors"},
+		{"encryption:\n  key-protectors:\n    - path: foo", `invalid encryption: invalid key protector type ""`},
+		{"encryption:\n  key-protectors:\n    - type: TPM2", `invalid encryption: invalid key protector type "TPM2"`},
+	} {
+		_, err := gadget.InfoFromGadgetYaml([]byte(tc.yaml), true)
+		c.Check(err, ErrorMatches, tc.err, Commentf(tc.yaml))
+	}
+}
@@ -39,7 +39,7 @@ var (
 	UpdaterForStructure = updaterForStructure
 )
 
-func MockUpdaterForStructure(mock func(ps *PositionedStructure, rootDir, rollbackDir string) (Updater, error)) (restore func()) {
+func MockUpdaterForStructure(mock func(ps *PositionedStructure, rootDir, rollbackDir string, vars *TemplateVars) (Updater, error)) (restore func()) {
 	old := updaterForStructure
 	updaterForStructure = mock
 	return func() {
@@ -62,3 +62,19 @@ func MockMkfsHandlers(mock map[string]MkfsFunc) (restore func()) {
 		mkfsHandlers = old
 	}
 }
+
+func MockBootAssetsMountLookup(mock func(ps *PositionedStructure) (string, error)) (restore func()) {
+	old := bootAssetsMountLookup
+	bootAssetsMountLookup = mock
+	return func() {
+		bootAssetsMountLookup = old
+	}
+}
+
+func MockBootConfigMigrations(mock map[string]BootConfigMigrationFunc) (restore func()) {
+	old := bootConfigMigrations
+	bootConfigMigrations = mock
+	return func() {
+		bootConfigMigrations = old
+	}
+}
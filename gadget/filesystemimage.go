@@ -66,6 +66,13 @@ func NewFilesystemImageWriter(contentDir string, ps *PositionedStructure, workDi
 	if _, ok := mkfsHandlers[ps.Filesystem]; !ok {
 		return nil, fmt.Errorf("internal error: filesystem %q has no handler", ps.Filesystem)
 	}
+	// images are not built for a particular device, templates are only
+	// rendered once the gadget is updated on the device
+	for _, c := range ps.Content {
+		if c.Template {
+			return nil, fmt.Errorf("cannot create filesystem image of %v: template content %s can only be written on the device", ps, c)
+		}
+	}
 
 	fiw := &FilesystemImageWriter{
 		contentDir: contentDir,
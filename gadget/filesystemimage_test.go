@@ -315,6 +315,29 @@ func (s *filesystemImageMockedTestSuite) TestMountedWriterError(c *C) {
 	c.Assert(err, ErrorMatches, `cannot prepare filesystem content: cannot write filesystem content of source:/foo: .* no such file or directory`)
 }
 
+func (s *filesystemImageMockedTestSuite) TestTemplateContentError(c *C) {
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Filesystem: "happyfs",
+			Size:       2 * gadget.SizeMiB,
+			Content: []gadget.VolumeContent{
+				{Source: "/serial.txt", Target: "/", Template: true},
+			},
+		},
+		Index: 1,
+	}
+	restore := gadget.MockMkfsHandlers(map[string]gadget.MkfsFunc{
+		"happyfs": func(imgFile, label, contentsRootDir string) error {
+			return errors.New("unexpected call")
+		},
+	})
+	defer restore()
+
+	fiw, err := gadget.NewFilesystemImageWriter(s.content, ps, s.work)
+	c.Assert(err, ErrorMatches, `cannot create filesystem image of #1: template content source:/serial.txt can only be written on the device`)
+	c.Check(fiw, IsNil)
+}
+
 func (s *filesystemImageMockedTestSuite) TestBadWorkDirError(c *C) {
 	cb := func(rootDir string, cbPs *gadget.PositionedStructure) error {
 		return errors.New("unexpected call")
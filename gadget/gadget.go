@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -33,6 +34,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/snapcore/snapd/metautil"
+	"github.com/snapcore/snapd/snap/naming"
 	"github.com/snapcore/snapd/strutil"
 )
 
@@ -47,6 +49,7 @@ const (
 
 	SystemBoot = "system-boot"
 	SystemData = "system-data"
+	SystemSave = "system-save"
 	// ImplicitSystemDataLabel is the implicit filesystem label of structure
 	// of system-data role
 	ImplicitSystemDataLabel = "writable"
@@ -64,7 +67,108 @@ type Info struct {
 	// Default configuration for snaps (snap-id => key => value).
 	Defaults map[string]map[string]interface{} `yaml:"defaults,omitempty"`
 
+	// ScopedDefaults holds default configuration for snaps that
+	// applies only to devices of a given model and/or serial.
+	ScopedDefaults []ScopedDefaults `yaml:"scoped-defaults,omitempty"`
+
 	Connections []Connection `yaml:"connections"`
+
+	// KernelCmdline declares the kernel command line arguments the
+	// gadget can set with cmdline.extra or cmdline.full, unless the
+	// model declares them.
+	KernelCmdline KernelCmdlineConstraints `yaml:"kernel-cmdline,omitempty"`
+
+	// Encryption declares how the keys of the encrypted data
+	// partitions are protected.
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+
+	// PairedKernel names the kernel whose new revisions depend on
+	// the assets of the new revisions of the gadget, e.g. device
+	// trees, so that refreshing both updates them together.
+	PairedKernel string `yaml:"paired-kernel,omitempty"`
+}
+
+// ScopedDefaults holds default configuration for snaps that applies
+// only to devices of the given brand matching the given model and/or
+// serial. Scoped defaults are layered on top of the unscoped ones,
+// with the ones matching the serial taking precedence over the ones
+// matching only the model.
+type ScopedDefaults struct {
+	// Brand is the brand-id of the devices the defaults apply to.
+	Brand string `yaml:"brand-id"`
+	// Model is the name of the model the defaults apply to.
+	Model string `yaml:"model"`
+	// Serial is the serial of the device the defaults apply to.
+	Serial string `yaml:"serial"`
+	// Default configuration for snaps (snap-id => key => value).
+	Defaults map[string]map[string]interface{} `yaml:"defaults"`
+}
+
+func (sd *ScopedDefaults) matches(brandID, model, serial string) bool {
+	if sd.Brand != brandID {
+		return false
+	}
+	if sd.Model != "" && sd.Model != model {
+		return false
+	}
+	if sd.Serial != "" && sd.Serial != serial {
+		return false
+	}
+	return true
+}
+
+// SnapDefaults returns the effective configuration defaults for the
+// snap with the given snap-id (or "system") on a device of the given
+// brand, model and serial, merging the unscoped defaults with the matching
+// scoped ones at the level of top-level keys. It returns false if no
+// defaults are declared for the snap at all.
+func (gi *Info) SnapDefaults(snapIDOrSystem, brandID, model, serial string) (map[string]interface{}, bool) {
+	var defaults map[string]interface{}
+	found := false
+	merge := func(dflt map[string]interface{}, ok bool) {
+		if !ok {
+			return
+		}
+		if defaults == nil {
+			defaults = make(map[string]interface{}, len(dflt))
+		}
+		for k, v := range dflt {
+			defaults[k] = v
+		}
+		found = true
+	}
+
+	dflt, ok := gi.Defaults[snapIDOrSystem]
+	merge(dflt, ok)
+	// model-only scoped defaults first, then the serial-specific
+	// ones so that they win
+	for _, withSerial := range []bool{false, true} {
+		for i := range gi.ScopedDefaults {
+			sd := &gi.ScopedDefaults[i]
+			if (sd.Serial != "") != withSerial || !sd.matches(brandID, model, serial) {
+				continue
+			}
+			dflt, ok := sd.Defaults[snapIDOrSystem]
+			merge(dflt, ok)
+		}
+	}
+	return defaults, found
+}
+
+// VolumeDefaults returns the system configuration defaults declared by
+// the volumes of the gadget. The defaults of all the volumes are merged
+// into a fresh map, ReadInfo ensures that they do not conflict.
+func (gi *Info) VolumeDefaults() map[string]interface{} {
+	var defaults map[string]interface{}
+	for _, v := range gi.Volumes {
+		for k, val := range v.Defaults {
+			if defaults == nil {
+				defaults = make(map[string]interface{})
+			}
+			defaults[k] = val
+		}
+	}
+	return defaults
 }
 
 // Volume defines the structure and content for the image to be written into a
@@ -78,6 +182,9 @@ type Volume struct {
 	ID string `yaml:"id"`
 	// Structure describes the structures that are part of the volume
 	Structure []VolumeStructure `yaml:"structure"`
+	// Defaults holds system configuration (key => value) that is
+	// applied when the gadget is installed or refreshed
+	Defaults map[string]interface{} `yaml:"defaults,omitempty"`
 }
 
 func (v *Volume) EffectiveSchema() string {
@@ -103,6 +210,13 @@ type VolumeStructure struct {
 	OffsetWrite *RelativeOffset `yaml:"offset-write"`
 	// Size of the structure
 	Size Size `yaml:"size"`
+	// MinSize, when non zero, marks the structure as expandable. The
+	// structure is then resized at install time to fill the remaining
+	// space of the disk, but the disk must provide at least MinSize
+	// bytes for it. Size is then the default size of the structure,
+	// used when there is no disk to fill like in images, and is MinSize
+	// if unset. Only the last structure of a volume can be expandable.
+	MinSize Size `yaml:"min-size"`
 	// Type of the structure, which can be 2-hex digit MBR partition,
 	// 36-char GUID partition, comma separated <mbr>,<guid> for hybrid
 	// partitioning schemes, or 'bare' when the structure is not considered
@@ -112,7 +226,7 @@ type VolumeStructure struct {
 	// structure is treated as if it is of role 'mbr'.
 	Type string `yaml:"type"`
 	// Role describes the role of given structure, can be one of 'mbr',
-	// 'system-data', 'system-boot'. Structures of type 'mbr', must have a
+	// 'system-data', 'system-save', 'system-boot'. Structures of type 'mbr', must have a
 	// size of 446 bytes and must start at 0 offset.
 	Role string `yaml:"role"`
 	// ID is the GPT partition ID
@@ -174,6 +288,10 @@ type VolumeContent struct {
 	Size Size `yaml:"size"`
 
 	Unpack bool `yaml:"unpack"`
+
+	// Template indicates that the source file is a template rendered with
+	// device specific variables, eg. model and serial, when written
+	Template bool `yaml:"template"`
 }
 
 func (vc VolumeContent) String() string {
@@ -267,35 +385,60 @@ func systemOrSnapID(s string) bool {
 	return true
 }
 
+func normalizeDefaults(defaults map[string]map[string]interface{}) error {
+	for k, v := range defaults {
+		if !systemOrSnapID(k) {
+			return fmt.Errorf(`default stanza not keyed by "system" or snap-id: %s`, k)
+		}
+		dflt, err := metautil.NormalizeValue(v)
+		if err != nil {
+			return fmt.Errorf("default value %q of %q: %v", v, k, err)
+		}
+		defaults[k] = dflt.(map[string]interface{})
+	}
+	return nil
+}
+
 // ReadInfo reads the gadget specific metadata from gadget.yaml
 // in the snap. classic set to true means classic rules apply,
 // i.e. content/presence of gadget.yaml is fully optional.
 func ReadInfo(gadgetSnapRootDir string, classic bool) (*Info, error) {
-	var gi Info
-
 	gadgetYamlFn := filepath.Join(gadgetSnapRootDir, "meta", "gadget.yaml")
 	gmeta, err := ioutil.ReadFile(gadgetYamlFn)
 	if classic && os.IsNotExist(err) {
 		// gadget.yaml is optional for classic gadgets
-		return &gi, nil
+		return &Info{}, nil
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	return InfoFromGadgetYaml(gmeta, classic)
+}
+
+// InfoFromGadgetYaml parses and validates the given gadget.yaml content.
+// classic set to true means classic rules apply.
+func InfoFromGadgetYaml(gmeta []byte, classic bool) (*Info, error) {
+	var gi Info
+
 	if err := yaml.Unmarshal(gmeta, &gi); err != nil {
 		return nil, fmt.Errorf("cannot parse gadget metadata: %v", err)
 	}
 
-	for k, v := range gi.Defaults {
-		if !systemOrSnapID(k) {
-			return nil, fmt.Errorf(`default stanza not keyed by "system" or snap-id: %s`, k)
+	if err := normalizeDefaults(gi.Defaults); err != nil {
+		return nil, err
+	}
+
+	for i, sd := range gi.ScopedDefaults {
+		if sd.Brand == "" {
+			return nil, fmt.Errorf("scoped defaults #%d must specify a brand-id", i)
 		}
-		dflt, err := metautil.NormalizeValue(v)
-		if err != nil {
-			return nil, fmt.Errorf("default value %q of %q: %v", v, k, err)
+		if sd.Model == "" && sd.Serial == "" {
+			return nil, fmt.Errorf("scoped defaults #%d must specify a model or a serial", i)
+		}
+		if err := normalizeDefaults(sd.Defaults); err != nil {
+			return nil, fmt.Errorf("scoped defaults #%d: %v", i, err)
 		}
-		gi.Defaults[k] = dflt.(map[string]interface{})
 	}
 
 	for i, gconn := range gi.Connections {
@@ -308,6 +451,22 @@ func ReadInfo(gadgetSnapRootDir string, classic bool) (*Info, error) {
 		}
 	}
 
+	if err := validateKernelCmdlineConstraints(&gi.KernelCmdline); err != nil {
+		return nil, err
+	}
+
+	if gi.Encryption != nil {
+		if err := validateEncryption(gi.Encryption); err != nil {
+			return nil, err
+		}
+	}
+
+	if gi.PairedKernel != "" {
+		if err := naming.ValidateSnap(gi.PairedKernel); err != nil {
+			return nil, fmt.Errorf("invalid paired-kernel: %v", err)
+		}
+	}
+
 	if classic && len(gi.Volumes) == 0 {
 		// volumes can be left out on classic
 		// can still specify defaults though
@@ -320,6 +479,10 @@ func ReadInfo(gadgetSnapRootDir string, classic bool) (*Info, error) {
 		if err := validateVolume(name, &v); err != nil {
 			return nil, fmt.Errorf("invalid volume %q: %v", name, err)
 		}
+		if err := normalizeVolumeDefaults(&v); err != nil {
+			return nil, fmt.Errorf("invalid volume %q: %v", name, err)
+		}
+		gi.Volumes[name] = v
 
 		switch v.Bootloader {
 		case "":
@@ -337,9 +500,55 @@ func ReadInfo(gadgetSnapRootDir string, classic bool) (*Info, error) {
 		return nil, fmt.Errorf("too many (%d) bootloaders declared", bootloadersFound)
 	}
 
+	if err := checkVolumeDefaultsConflicts(gi.Volumes); err != nil {
+		return nil, err
+	}
+
 	return &gi, nil
 }
 
+func normalizeVolumeDefaults(vol *Volume) error {
+	if len(vol.Defaults) == 0 {
+		return nil
+	}
+	for k := range vol.Defaults {
+		if k == "" {
+			return errors.New("default system configuration key cannot be empty")
+		}
+	}
+	dflt, err := metautil.NormalizeValue(vol.Defaults)
+	if err != nil {
+		return fmt.Errorf("default system configuration: %v", err)
+	}
+	vol.Defaults = dflt.(map[string]interface{})
+	return nil
+}
+
+// checkVolumeDefaultsConflicts checks that no system configuration key is
+// given different defaults by different volumes.
+func checkVolumeDefaultsConflicts(volumes map[string]Volume) error {
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	declaredBy := make(map[string]string)
+	for _, name := range names {
+		for k, val := range volumes[name].Defaults {
+			other, ok := declaredBy[k]
+			if !ok {
+				declaredBy[k] = name
+				continue
+			}
+			if !reflect.DeepEqual(val, volumes[other].Defaults[k]) {
+				return fmt.Errorf("volumes %q and %q declare conflicting defaults for system configuration %q", other, name, k)
+			}
+		}
+	}
+	return nil
+}
+
 func fmtIndexAndName(idx int, name string) string {
 	if name != "" {
 		return fmt.Sprintf("#%v (%q)", idx, name)
@@ -362,6 +571,11 @@ func validateVolume(name string, vol *Volume) error {
 
 	previousEnd := Size(0)
 	for idx, s := range vol.Structure {
+		if s.Size == 0 && s.MinSize != 0 {
+			// expandable structures default to their minimum size
+			vol.Structure[idx].Size = s.MinSize
+			s.Size = s.MinSize
+		}
 		if err := validateVolumeStructure(&s, vol); err != nil {
 			return fmt.Errorf("invalid structure %v: %v", fmtIndexAndName(idx, s.Name), err)
 		}
@@ -392,6 +606,12 @@ func validateVolume(name string, vol *Volume) error {
 	// sort by starting offset
 	sort.Sort(byStartOffset(structures))
 
+	for idx, ps := range structures {
+		if ps.MinSize != 0 && idx != len(structures)-1 {
+			return fmt.Errorf("invalid structure %v: only the last structure can be expandable", ps)
+		}
+	}
+
 	return validateCrossVolumeStructure(structures, knownStructures)
 }
 
@@ -437,6 +657,81 @@ func validateCrossVolumeStructure(structures []PositionedStructure, knownStructu
 			}
 		}
 	}
+
+	var targets []offsetWriteTarget
+	for _, ps := range structures {
+		offsetWrite, err := resolveOffsetWrite(ps.OffsetWrite, knownStructures)
+		if err != nil {
+			return err
+		}
+		if offsetWrite != nil {
+			targets = append(targets, offsetWriteTarget{
+				offset: *offsetWrite,
+				what:   fmt.Sprintf("structure %v", ps),
+			})
+		}
+		if !ps.IsBare() {
+			continue
+		}
+		for cidx, c := range ps.Content {
+			offsetWrite, err := resolveOffsetWrite(c.OffsetWrite, knownStructures)
+			if err != nil {
+				return err
+			}
+			if offsetWrite != nil {
+				targets = append(targets, offsetWriteTarget{
+					offset: *offsetWrite,
+					what:   fmt.Sprintf("structure %v, content %v", ps, fmtIndexAndName(cidx, c.Image)),
+				})
+			}
+		}
+	}
+	return validateOffsetWriteTargets(structures, targets)
+}
+
+// offsetWriteTarget is a location within the volume at which the
+// offset of a structure or of its content is written.
+type offsetWriteTarget struct {
+	offset Size
+	// what describes whose offset is written
+	what string
+}
+
+// validateOffsetWriteTargets checks that the locations written by
+// offset-write do not overlap with one another, and that they are
+// either outside of all structures or entirely within a single bare
+// structure, as writing within a filesystem would corrupt it. The
+// structures must be sorted by their start offset.
+func validateOffsetWriteTargets(structures []PositionedStructure, targets []offsetWriteTarget) error {
+	sorted := make([]offsetWriteTarget, len(targets))
+	copy(sorted, targets)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+
+	for idx, t := range sorted {
+		start := t.offset
+		end := t.offset + SizeLBA48Pointer
+		if idx > 0 {
+			previous := sorted[idx-1]
+			if start < previous.offset+SizeLBA48Pointer {
+				return fmt.Errorf("offset-write of %s at %#x overlaps with offset-write of %s at %#x",
+					t.what, uint64(t.offset), previous.what, uint64(previous.offset))
+			}
+		}
+		for _, ps := range structures {
+			psEnd := ps.StartOffset + ps.Size
+			if end <= ps.StartOffset || start >= psEnd {
+				continue
+			}
+			if !ps.IsBare() {
+				return fmt.Errorf("offset-write of %s at %#x overlaps with structure %v with a filesystem",
+					t.what, uint64(t.offset), ps)
+			}
+			if start < ps.StartOffset || end > psEnd {
+				return fmt.Errorf("offset-write of %s at %#x crosses the boundary of structure %v",
+					t.what, uint64(t.offset), ps)
+			}
+		}
+	}
 	return nil
 }
 
@@ -444,6 +739,12 @@ func validateVolumeStructure(vs *VolumeStructure, vol *Volume) error {
 	if vs.Size == 0 {
 		return errors.New("missing size")
 	}
+	if vs.MinSize > vs.Size {
+		return fmt.Errorf("min-size %v cannot be larger than size %v", vs.MinSize, vs.Size)
+	}
+	if vs.MinSize != 0 && vs.Type == "bare" {
+		return errors.New("bare structures cannot be expandable")
+	}
 	if err := validateStructureType(vs.Type, vol); err != nil {
 		return fmt.Errorf("invalid type %q: %v", vs.Type, err)
 	}
@@ -579,6 +880,10 @@ func validateRole(vs *VolumeStructure, vol *Volume) error {
 		if vs.Filesystem != "" && vs.Filesystem != "none" {
 			return errors.New("mbr structures must not specify a file system")
 		}
+	case SystemSave:
+		if vs.IsBare() {
+			return errors.New("role of this kind must specify a filesystem")
+		}
 	case SystemBoot, "":
 		// noop
 	default:
@@ -591,6 +896,9 @@ func validateBareContent(vc *VolumeContent) error {
 	if vc.Source != "" || vc.Target != "" {
 		return fmt.Errorf("cannot use non-image content for bare file system")
 	}
+	if vc.Template {
+		return fmt.Errorf("cannot use template content for bare file system")
+	}
 	if vc.Image == "" {
 		return fmt.Errorf("missing image file name")
 	}
@@ -604,6 +912,9 @@ func validateFilesystemContent(vc *VolumeContent) error {
 	if vc.Source == "" || vc.Target == "" {
 		return fmt.Errorf("missing source or target")
 	}
+	if vc.Template && strings.HasSuffix(vc.Source, "/") {
+		return fmt.Errorf("cannot use a directory as template source")
+	}
 	return nil
 }
 
@@ -223,6 +223,15 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlMissing(c *C) {
 	c.Assert(err, ErrorMatches, ".*meta/gadget.yaml: no such file or directory")
 }
 
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlPairedKernel(c *C) {
+	gi, err := gadget.InfoFromGadgetYaml([]byte("paired-kernel: pc-kernel\n"), true)
+	c.Assert(err, IsNil)
+	c.Check(gi.PairedKernel, Equals, "pc-kernel")
+
+	_, err = gadget.InfoFromGadgetYaml([]byte("paired-kernel: pc_kernel\n"), true)
+	c.Check(err, ErrorMatches, `invalid paired-kernel: invalid snap name: "pc_kernel"`)
+}
+
 func (s *gadgetYamlTestSuite) TestReadGadgetYamlOnClassicOptional(c *C) {
 	// no meta/gadget.yaml
 	gi, err := gadget.ReadInfo(s.dir, true)
@@ -413,6 +422,184 @@ defaults:
 	c.Assert(err, ErrorMatches, `default stanza not keyed by "system" or snap-id: foo`)
 }
 
+var mockScopedDefaultsGadgetYaml = []byte(`
+defaults:
+  system:
+    something: true
+  otheridididididididididididididi:
+    foo: 1
+    bar: 1
+scoped-defaults:
+  - brand-id: my-brand
+    serial: serial-1
+    defaults:
+      otheridididididididididididididi:
+        foo: 3
+  - brand-id: my-brand
+    model: my-model
+    defaults:
+      otheridididididididididididididi:
+        foo: 2
+        bar: 2
+      system:
+        other: 1
+`)
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlScopedDefaults(c *C) {
+	err := ioutil.WriteFile(s.gadgetYamlPath, mockScopedDefaultsGadgetYaml, 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, true)
+	c.Assert(err, IsNil)
+	c.Assert(ginfo.ScopedDefaults, DeepEquals, []gadget.ScopedDefaults{
+		{
+			Brand:  "my-brand",
+			Serial: "serial-1",
+			Defaults: map[string]map[string]interface{}{
+				"otheridididididididididididididi": {"foo": int64(3)},
+			},
+		}, {
+			Brand: "my-brand",
+			Model: "my-model",
+			Defaults: map[string]map[string]interface{}{
+				"otheridididididididididididididi": {"foo": int64(2), "bar": int64(2)},
+				"system":                           {"other": int64(1)},
+			},
+		},
+	})
+
+	for _, t := range []struct {
+		key, brand, model, serial string
+		dflts                     map[string]interface{}
+	}{
+		{"system", "my-brand", "other-model", "", map[string]interface{}{"something": true}},
+		{"system", "my-brand", "my-model", "", map[string]interface{}{"something": true, "other": int64(1)}},
+		{"otheridididididididididididididi", "my-brand", "other-model", "", map[string]interface{}{"foo": int64(1), "bar": int64(1)}},
+		{"otheridididididididididididididi", "my-brand", "my-model", "", map[string]interface{}{"foo": int64(2), "bar": int64(2)}},
+		{"otheridididididididididididididi", "my-brand", "other-model", "serial-1", map[string]interface{}{"foo": int64(3), "bar": int64(1)}},
+		// the serial-specific defaults win
+		{"otheridididididididididididididi", "my-brand", "my-model", "serial-1", map[string]interface{}{"foo": int64(3), "bar": int64(2)}},
+		// scoped defaults of other brands do not apply
+		{"otheridididididididididididididi", "other-brand", "my-model", "serial-1", map[string]interface{}{"foo": int64(1), "bar": int64(1)}},
+	} {
+		dflts, ok := ginfo.SnapDefaults(t.key, t.brand, t.model, t.serial)
+		c.Check(ok, Equals, true)
+		c.Check(dflts, DeepEquals, t.dflts, Commentf("%s %s %s %s", t.key, t.brand, t.model, t.serial))
+	}
+
+	_, ok := ginfo.SnapDefaults("snapidsnapidsnapidsnapidsnapidsn", "my-brand", "my-model", "serial-1")
+	c.Check(ok, Equals, false)
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlInvalidScopedDefaults(c *C) {
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{`
+scoped-defaults:
+  - model: my-model
+    defaults:
+      system:
+        foo: 1
+`, `scoped defaults #0 must specify a brand-id`},
+		{`
+scoped-defaults:
+  - brand-id: my-brand
+    defaults:
+      system:
+        foo: 1
+`, `scoped defaults #0 must specify a model or a serial`},
+		{`
+scoped-defaults:
+  - brand-id: my-brand
+    model: my-model
+    defaults:
+      foo:
+        x: 1
+`, `scoped defaults #0: default stanza not keyed by "system" or snap-id: foo`},
+	} {
+		err := ioutil.WriteFile(s.gadgetYamlPath, []byte(t.yaml), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, true)
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+var mockVolumeDefaultsGadgetYaml = []byte(`
+volumes:
+  pc:
+    bootloader: grub
+    defaults:
+      service.ssh.disable: true
+      watchdog:
+        runtime-timeout: 10m
+  other:
+    defaults:
+      service.ssh.disable: true
+      refresh.timer: fri
+`)
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlVolumeDefaults(c *C) {
+	err := ioutil.WriteFile(s.gadgetYamlPath, mockVolumeDefaultsGadgetYaml, 0644)
+	c.Assert(err, IsNil)
+
+	ginfo, err := gadget.ReadInfo(s.dir, false)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.Volumes["pc"].Defaults, DeepEquals, map[string]interface{}{
+		"service.ssh.disable": true,
+		"watchdog": map[string]interface{}{
+			"runtime-timeout": "10m",
+		},
+	})
+	c.Check(ginfo.VolumeDefaults(), DeepEquals, map[string]interface{}{
+		"service.ssh.disable": true,
+		"refresh.timer":       "fri",
+		"watchdog": map[string]interface{}{
+			"runtime-timeout": "10m",
+		},
+	})
+
+	// no volume defaults
+	err = ioutil.WriteFile(s.gadgetYamlPath, mockGadgetYaml, 0644)
+	c.Assert(err, IsNil)
+	ginfo, err = gadget.ReadInfo(s.dir, false)
+	c.Assert(err, IsNil)
+	c.Check(ginfo.VolumeDefaults(), IsNil)
+}
+
+func (s *gadgetYamlTestSuite) TestReadGadgetYamlInvalidVolumeDefaults(c *C) {
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{`
+volumes:
+  pc:
+    bootloader: grub
+    defaults:
+      "": 1
+`, `invalid volume "pc": default system configuration key cannot be empty`},
+		{`
+volumes:
+  pc:
+    bootloader: grub
+    defaults:
+      refresh.timer: fri
+  other:
+    defaults:
+      refresh.timer: mon
+`, `volumes "other" and "pc" declare conflicting defaults for system configuration "refresh.timer"`},
+	} {
+		err := ioutil.WriteFile(s.gadgetYamlPath, []byte(t.yaml), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, false)
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
 func (s *gadgetYamlTestSuite) TestReadGadgetYamlInvalidConnection(c *C) {
 	mockGadgetYamlBroken := `
 connections:
@@ -696,6 +883,13 @@ size: 123M
 	bogusRole := uuidType + `
 role: foobar
 size: 123M
+`
+	validSystemSave := uuidType + `
+role: system-save
+filesystem: ext4
+`
+	bareSystemSave := uuidType + `
+role: system-save
 `
 	legacyMBR := `
 type: mbr
@@ -725,6 +919,9 @@ size: 447`
 		{mustParseStructure(c, bogusRole), vol, `invalid role "foobar": unsupported role`},
 		// system-data, but improper label
 		{mustParseStructure(c, invalidSystemDataLabel), vol, `invalid role "system-data": role of this kind must have an implicit label or "writable", not "foobar"`},
+		// system-save
+		{mustParseStructure(c, validSystemSave), vol, ""},
+		{mustParseStructure(c, bareSystemSave), vol, `invalid role "system-save": role of this kind must specify a filesystem`},
 		// mbr
 		{mustParseStructure(c, mbrTooLarge), mbrVol, `invalid role "mbr": mbr structures cannot be larger than 446 bytes`},
 		{mustParseStructure(c, mbrBadOffset), mbrVol, `invalid role "mbr": mbr structure must start at offset 0`},
@@ -772,6 +969,66 @@ func (s *gadgetYamlTestSuite) TestValidateFilesystem(c *C) {
 	}
 }
 
+func (s *gadgetYamlTestSuite) TestValidateMinSize(c *C) {
+	for i, tc := range []struct {
+		s   *gadget.VolumeStructure
+		err string
+	}{
+		{&gadget.VolumeStructure{Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048, MinSize: 1024}, ""},
+		{&gadget.VolumeStructure{Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048, MinSize: 2048}, ""},
+		{&gadget.VolumeStructure{Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 1024, MinSize: 2048}, `min-size 2048 cannot be larger than size 1024`},
+		{&gadget.VolumeStructure{Type: "bare", Size: 2048, MinSize: 1024}, `bare structures cannot be expandable`},
+	} {
+		c.Logf("tc: %v %+v", i, tc.s)
+
+		err := gadget.ValidateVolumeStructure(tc.s, &gadget.Volume{})
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, tc.err)
+		} else {
+			c.Check(err, IsNil)
+		}
+	}
+}
+
+func (s *gadgetYamlTestSuite) TestValidateVolumeExpandableNotLast(c *C) {
+	err := gadget.ValidateVolume("name", &gadget.Volume{
+		Structure: []gadget.VolumeStructure{
+			{Name: "data", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048, MinSize: 1024},
+			{Name: "save", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048},
+		},
+	})
+	c.Assert(err, ErrorMatches, `invalid structure #0 \("data"\): only the last structure can be expandable`)
+
+	err = gadget.ValidateVolume("name", &gadget.Volume{
+		Structure: []gadget.VolumeStructure{
+			{Name: "save", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048},
+			{Name: "data", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048, MinSize: 1024},
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *gadgetYamlTestSuite) TestValidateVolumeExpandableDefaultSize(c *C) {
+	vol := &gadget.Volume{
+		Structure: []gadget.VolumeStructure{
+			{Name: "save", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", Size: 2048},
+			{Name: "data", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4", MinSize: 1024},
+		},
+	}
+	err := gadget.ValidateVolume("name", vol)
+	c.Assert(err, IsNil)
+	// the size of an expandable structure defaults to its min-size
+	c.Check(vol.Structure[1].Size, Equals, gadget.Size(1024))
+
+	// other structures still need a size
+	err = gadget.ValidateVolume("name", &gadget.Volume{
+		Structure: []gadget.VolumeStructure{
+			{Name: "data", Type: "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4"},
+		},
+	})
+	c.Assert(err, ErrorMatches, `invalid structure #0 \("data"\): missing size`)
+}
+
 func (s *gadgetYamlTestSuite) TestValidateVolumeSchema(c *C) {
 	for i, tc := range []struct {
 		s   string
@@ -906,12 +1163,41 @@ content:
   - source: foo
 `
 
+	bareTemplate := `
+type: 21686148-6449-6E6F-744E-656564454649
+size: 1M
+content:
+  - image: foo.img
+    template: true
+`
+	fsTemplateOk := `
+type: 21686148-6449-6E6F-744E-656564454649
+filesystem: ext4
+size: 1M
+content:
+  - source: foo.conf
+    target: bar.conf
+    template: true
+`
+	fsTemplateDir := `
+type: 21686148-6449-6E6F-744E-656564454649
+filesystem: ext4
+size: 1M
+content:
+  - source: foo/
+    target: bar
+    template: true
+`
+
 	for i, tc := range []struct {
 		s   *gadget.VolumeStructure
 		v   *gadget.Volume
 		err string
 	}{
 		{mustParseStructure(c, bareOnlyOk), nil, ""},
+		{mustParseStructure(c, bareTemplate), nil, `invalid content #0: cannot use template content for bare file system`},
+		{mustParseStructure(c, fsTemplateOk), nil, ""},
+		{mustParseStructure(c, fsTemplateDir), nil, `invalid content #0: cannot use a directory as template source`},
 		{mustParseStructure(c, bareMixed), nil, `invalid content #1: cannot use non-image content for bare file system`},
 		{mustParseStructure(c, bareMissing), nil, `invalid content #0: missing image file name`},
 		{mustParseStructure(c, fsOk), nil, ""},
@@ -1097,6 +1383,105 @@ volumes:
 	c.Check(err, ErrorMatches, `invalid volume "pc": structure #0 \("overlaps-with-foo"\) overlaps with the preceding structure #1 \("foo"\)`)
 }
 
+func (s *gadgetYamlTestSuite) TestValidateOffsetWriteOverlap(c *C) {
+	gadgetYamlHeader := `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+        content:
+          - image: pc-boot.img`
+
+	for _, tc := range []struct {
+		structures string
+		err        string
+	}{{
+		structures: `
+      - name: core
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: mbr+92
+        content:
+          - image: pc-core.img
+            offset-write: mbr+94
+`,
+		err: `invalid volume "pc": offset-write of structure #1 \("core"\), content #0 \("pc-core.img"\) at 0x5e overlaps with offset-write of structure #1 \("core"\) at 0x5c`,
+	}, {
+		structures: `
+      - name: core
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: system-boot+1024
+      - name: system-boot
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 50M
+`,
+		err: `invalid volume "pc": offset-write of structure #1 \("core"\) at 0x200400 overlaps with structure #2 \("system-boot"\) with a filesystem`,
+	}, {
+		structures: `
+      - name: core
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: mbr+438
+`,
+		err: `invalid volume "pc": offset-write of structure #1 \("core"\) at 0x1b6 crosses the boundary of structure #0 \("mbr"\)`,
+	}, {
+		structures: `
+      - name: core
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: 1048574
+`,
+		err: `invalid volume "pc": offset-write of structure #1 \("core"\) at 0xffffe crosses the boundary of structure #1 \("core"\)`,
+	}} {
+		err := ioutil.WriteFile(s.gadgetYamlPath, []byte(gadgetYamlHeader+tc.structures), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = gadget.ReadInfo(s.dir, false)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *gadgetYamlTestSuite) TestValidateOffsetWriteHappy(c *C) {
+	gadgetYaml := `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+        content:
+          - image: pc-boot.img
+      - name: core
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        offset-write: mbr+92
+        content:
+          - image: pc-core.img
+            offset-write: mbr+96
+      - name: other
+        type: bare
+        size: 1M
+        # outside of all structures
+        offset-write: 1024
+`
+	err := ioutil.WriteFile(s.gadgetYamlPath, []byte(gadgetYaml), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = gadget.ReadInfo(s.dir, false)
+	c.Check(err, IsNil)
+}
+
 func (s *gadgetYamlTestSuite) TestValidateCrossStructureMBRFixedOffset(c *C) {
 	gadgetYaml := `
 volumes:
@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"unicode"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// gptBackupSectors is the number of sectors at the end of the disk
+// occupied by the backup GPT header and partition entries.
+const gptBackupSectors = 33
+
+// InstallOptions control how a volume is created on the target disk.
+type InstallOptions struct {
+	// Encrypt requests that the system-data and system-save structures
+	// are placed inside LUKS containers.
+	Encrypt bool
+}
+
+// PlannedStructure is a structure of the volume as it is going to be
+// created on the target disk.
+type PlannedStructure struct {
+	PositionedStructure
+	// Node is the device node of the partition
+	Node string
+	// Encrypted is set when the partition holds a LUKS container
+	Encrypted bool
+	// MapperName is the name of the device mapper node of the
+	// opened LUKS container of an encrypted partition
+	MapperName string
+}
+
+// FilesystemNode returns the device node on which the filesystem of the
+// structure is created.
+func (p *PlannedStructure) FilesystemNode() string {
+	if p.Encrypted {
+		return filepath.Join("/dev/mapper", p.MapperName)
+	}
+	return p.Node
+}
+
+// InstallPlan describes how a positioned volume is created on a disk.
+type InstallPlan struct {
+	// Device is the device node of the target disk
+	Device string
+	// Volume is the volume as positioned on the target disk, with the
+	// expandable structure grown to fill the disk
+	Volume *PositionedVolume
+	// Structures lists the partitions to create, in order of appearance
+	Structures []PlannedStructure
+}
+
+var mapperNames = map[string]string{
+	SystemData: "ubuntu-data",
+	SystemSave: "ubuntu-save",
+}
+
+// partitionNode returns the device node of the given partition number
+// of a disk, e.g. /dev/sda1 or /dev/mmcblk0p1.
+func partitionNode(device string, num int) string {
+	runes := []rune(device)
+	if len(runes) > 0 && unicode.IsDigit(runes[len(runes)-1]) {
+		return fmt.Sprintf("%sp%d", device, num)
+	}
+	return fmt.Sprintf("%s%d", device, num)
+}
+
+// PlanInstall computes how the positioned volume is created on a disk
+// of the given size. Expandable structures are grown to fill the space
+// left on the disk. No changes are made to the disk.
+func PlanInstall(pv *PositionedVolume, device string, diskSize Size, opts *InstallOptions) (*InstallPlan, error) {
+	if opts == nil {
+		opts = &InstallOptions{}
+	}
+	if device == "" {
+		return nil, fmt.Errorf("internal error: device path is unset")
+	}
+	if pv.SectorSize != 512 {
+		return nil, fmt.Errorf("cannot use sector size %v", pv.SectorSize)
+	}
+
+	usableEnd := diskSize
+	if pv.EffectiveSchema() == GPT {
+		if usableEnd < gptBackupSectors*pv.SectorSize {
+			return nil, fmt.Errorf("cannot install volume: disk of size %v is too small", diskSize)
+		}
+		usableEnd -= gptBackupSectors * pv.SectorSize
+	}
+
+	structures := make([]PositionedStructure, len(pv.PositionedStructure))
+	for idx, ps := range pv.PositionedStructure {
+		if ps.MinSize != 0 {
+			if ps.StartOffset+ps.MinSize > usableEnd {
+				return nil, fmt.Errorf("cannot install volume: disk too small for structure %v, need at least %v bytes",
+					ps, ps.StartOffset+ps.MinSize)
+			}
+			vs := *ps.VolumeStructure
+			vs.Size = (usableEnd - ps.StartOffset) / pv.SectorSize * pv.SectorSize
+			ps.VolumeStructure = &vs
+		}
+		if ps.StartOffset+ps.Size > usableEnd {
+			return nil, fmt.Errorf("cannot install volume: structure %v does not fit on the disk", ps)
+		}
+		structures[idx] = ps
+	}
+
+	positioned := *pv
+	positioned.Size = diskSize
+	positioned.PositionedStructure = structures
+
+	parts := partitionStructures(&positioned)
+	firstLogical := firstLogicalPartition(&positioned, parts)
+	if err := checkLogicalPartitions(&positioned, parts, firstLogical); err != nil {
+		return nil, fmt.Errorf("cannot install volume: %v", err)
+	}
+
+	planned := make([]PlannedStructure, 0, len(parts))
+	for idx, ps := range parts {
+		p := PlannedStructure{
+			PositionedStructure: ps,
+			Node:                partitionNode(device, partitionNumber(idx, firstLogical)),
+		}
+		if mapperName, ok := mapperNames[ps.EffectiveRole()]; ok && opts.Encrypt {
+			if ps.IsBare() {
+				return nil, fmt.Errorf("cannot encrypt structure %v without a filesystem", ps)
+			}
+			p.Encrypted = true
+			p.MapperName = mapperName
+		}
+		planned = append(planned, p)
+	}
+
+	return &InstallPlan{
+		Device:     device,
+		Volume:     &positioned,
+		Structures: planned,
+	}, nil
+}
+
+// Install partitions the disk according to the plan, sets up the LUKS
+// containers of encrypted structures using the given key and creates
+// the filesystems of all structures.
+func Install(plan *InstallPlan, key []byte) error {
+	for _, p := range plan.Structures {
+		if p.Encrypted && len(key) == 0 {
+			return fmt.Errorf("internal error: encryption key is unset")
+		}
+	}
+
+	if err := Partition(plan.Device, plan.Volume); err != nil {
+		return err
+	}
+	// wait for the partition device nodes to appear
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "udevadm",
+		Args: []string{"settle", "--timeout=180"},
+	}); err != nil {
+		return fmt.Errorf("cannot wait for partitions: %v", err)
+	}
+
+	for _, p := range plan.Structures {
+		if p.IsBare() {
+			continue
+		}
+		if p.Encrypted {
+			if err := setupLUKS(p.Node, p.MapperName, key); err != nil {
+				return fmt.Errorf("cannot set up encryption of structure %v: %v", p, err)
+			}
+		}
+		if err := Mkfs(p.Filesystem, p.FilesystemNode(), p.EffectiveFilesystemLabel()); err != nil {
+			return fmt.Errorf("cannot create filesystem of structure %v: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func setupLUKS(node, mapperName string, key []byte) error {
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:  "cryptsetup",
+		Args:  []string{"-q", "luksFormat", "--type", "luks2", "--key-file", "-", node},
+		Stdin: bytes.NewReader(key),
+	}); err != nil {
+		return err
+	}
+	_, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:  "cryptsetup",
+		Args:  []string{"open", "--key-file", "-", node, mapperName},
+		Stdin: bytes.NewReader(key),
+	})
+	return err
+}
+
+// Mkfs creates a filesystem of the given type, with the given label, on
+// the device node.
+func Mkfs(filesystem, node, label string) error {
+	switch filesystem {
+	case "ext4":
+		return MkfsExt4(node, label, "")
+	case "vfat":
+		return MkfsVfat(node, label, "")
+	}
+	return fmt.Errorf("cannot create unsupported filesystem %q", filesystem)
+}
@@ -0,0 +1,315 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget_test
+
+import (
+	"errors"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+)
+
+type installSuite struct{}
+
+var _ = Suite(&installSuite{})
+
+func mockInstallVolume() *gadget.PositionedVolume {
+	return &gadget.PositionedVolume{
+		Volume: &gadget.Volume{
+			Schema: "gpt",
+		},
+		Size:       10 * gadget.SizeMiB,
+		SectorSize: 512,
+		PositionedStructure: []gadget.PositionedStructure{
+			{
+				VolumeStructure: &gadget.VolumeStructure{
+					Name: "mbr",
+					Type: "mbr",
+					Size: 440,
+				},
+				StartOffset: 0,
+				Index:       0,
+			}, {
+				VolumeStructure: &gadget.VolumeStructure{
+					Name:       "ubuntu-seed",
+					Label:      "ubuntu-seed",
+					Type:       "EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+					Role:       gadget.SystemBoot,
+					Filesystem: "vfat",
+					Size:       2 * gadget.SizeMiB,
+				},
+				StartOffset: 1 * gadget.SizeMiB,
+				Index:       1,
+			}, {
+				VolumeStructure: &gadget.VolumeStructure{
+					Name:       "ubuntu-save",
+					Label:      "ubuntu-save",
+					Type:       "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+					Role:       gadget.SystemSave,
+					Filesystem: "ext4",
+					Size:       1 * gadget.SizeMiB,
+				},
+				StartOffset: 3 * gadget.SizeMiB,
+				Index:       2,
+			}, {
+				VolumeStructure: &gadget.VolumeStructure{
+					Name:       "ubuntu-data",
+					Type:       "83,0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+					Role:       gadget.SystemData,
+					Filesystem: "ext4",
+					Size:       6 * gadget.SizeMiB,
+					MinSize:    4 * gadget.SizeMiB,
+				},
+				StartOffset: 4 * gadget.SizeMiB,
+				Index:       3,
+			},
+		},
+	}
+}
+
+func (s *installSuite) TestPlanInstallGrowsExpandable(c *C) {
+	pv := mockInstallVolume()
+
+	plan, err := gadget.PlanInstall(pv, "/dev/sda", 100*gadget.SizeMiB, nil)
+	c.Assert(err, IsNil)
+	c.Check(plan.Device, Equals, "/dev/sda")
+	c.Check(plan.Volume.Size, Equals, 100*gadget.SizeMiB)
+	c.Assert(plan.Volume.PositionedStructure, HasLen, 4)
+	// the last structure fills the disk, minus the backup GPT
+	c.Check(plan.Volume.PositionedStructure[3].Size, Equals, 96*gadget.SizeMiB-33*512)
+	// the gadget volume is left alone
+	c.Check(pv.PositionedStructure[3].Size, Equals, 6*gadget.SizeMiB)
+	c.Check(pv.Size, Equals, 10*gadget.SizeMiB)
+
+	// the MBR is not a partition
+	c.Assert(plan.Structures, HasLen, 3)
+	c.Check(plan.Structures[0].Name, Equals, "ubuntu-seed")
+	c.Check(plan.Structures[0].Node, Equals, "/dev/sda1")
+	c.Check(plan.Structures[1].Name, Equals, "ubuntu-save")
+	c.Check(plan.Structures[1].Node, Equals, "/dev/sda2")
+	c.Check(plan.Structures[2].Name, Equals, "ubuntu-data")
+	c.Check(plan.Structures[2].Node, Equals, "/dev/sda3")
+	c.Check(plan.Structures[2].Size, Equals, 96*gadget.SizeMiB-33*512)
+	for _, p := range plan.Structures {
+		c.Check(p.Encrypted, Equals, false)
+		c.Check(p.FilesystemNode(), Equals, p.Node)
+	}
+}
+
+func (s *installSuite) TestPlanInstallMBRUsesWholeDisk(c *C) {
+	pv := mockInstallVolume()
+	pv.Schema = "mbr"
+
+	plan, err := gadget.PlanInstall(pv, "/dev/mmcblk0", 100*gadget.SizeMiB, nil)
+	c.Assert(err, IsNil)
+	c.Check(plan.Volume.PositionedStructure[3].Size, Equals, 96*gadget.SizeMiB)
+	c.Check(plan.Structures[0].Node, Equals, "/dev/mmcblk0p1")
+	c.Check(plan.Structures[2].Node, Equals, "/dev/mmcblk0p3")
+}
+
+func (s *installSuite) TestPlanInstallMBRLogicalPartitions(c *C) {
+	pv := mockInstallVolume()
+	pv.Schema = "mbr"
+	// two more structures, the ones past the third partition are
+	// logical ones and leave a sector for their extended boot record
+	data := pv.PositionedStructure[3]
+	data.Index = 5
+	data.StartOffset = 7 * gadget.SizeMiB
+	pv.PositionedStructure[3] = gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Name: "extra1",
+			Type: "83",
+			Size: 1 * gadget.SizeMiB,
+		},
+		StartOffset: 4 * gadget.SizeMiB,
+		Index:       3,
+	}
+	pv.PositionedStructure = append(pv.PositionedStructure, gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Name: "extra2",
+			Type: "83",
+			Size: 1 * gadget.SizeMiB,
+		},
+		StartOffset: 5*gadget.SizeMiB + 512,
+		Index:       4,
+	}, data)
+
+	plan, err := gadget.PlanInstall(pv, "/dev/sda", 100*gadget.SizeMiB, nil)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Structures, HasLen, 5)
+	var nodes []string
+	for _, p := range plan.Structures {
+		nodes = append(nodes, p.Node)
+	}
+	// the fourth partition is the extended one
+	c.Check(nodes, DeepEquals, []string{"/dev/sda1", "/dev/sda2", "/dev/sda3", "/dev/sda5", "/dev/sda6"})
+
+	// no room for the extended boot record of extra2
+	pv.PositionedStructure[3].Size = 1*gadget.SizeMiB + 512
+	_, err = gadget.PlanInstall(pv, "/dev/sda", 100*gadget.SizeMiB, nil)
+	c.Assert(err, ErrorMatches, `cannot install volume: cannot create logical partition for structure #4 \("extra2"\): no free sector before it for its extended boot record`)
+}
+
+func (s *installSuite) TestPlanInstallEncrypted(c *C) {
+	pv := mockInstallVolume()
+
+	plan, err := gadget.PlanInstall(pv, "/dev/nvme0n1", 100*gadget.SizeMiB, &gadget.InstallOptions{Encrypt: true})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Structures, HasLen, 3)
+	c.Check(plan.Structures[0].Encrypted, Equals, false)
+	c.Check(plan.Structures[0].FilesystemNode(), Equals, "/dev/nvme0n1p1")
+	c.Check(plan.Structures[1].Encrypted, Equals, true)
+	c.Check(plan.Structures[1].MapperName, Equals, "ubuntu-save")
+	c.Check(plan.Structures[1].FilesystemNode(), Equals, "/dev/mapper/ubuntu-save")
+	c.Check(plan.Structures[2].Encrypted, Equals, true)
+	c.Check(plan.Structures[2].MapperName, Equals, "ubuntu-data")
+	c.Check(plan.Structures[2].FilesystemNode(), Equals, "/dev/mapper/ubuntu-data")
+}
+
+func (s *installSuite) TestPlanInstallDiskTooSmall(c *C) {
+	pv := mockInstallVolume()
+
+	// enough for the gadget size, but not for the backup GPT
+	_, err := gadget.PlanInstall(pv, "/dev/sda", 8*gadget.SizeMiB, nil)
+	c.Assert(err, ErrorMatches, `cannot install volume: disk too small for structure #3 \("ubuntu-data"\), need at least 8388608 bytes`)
+
+	// the expandable structure may shrink down to its min-size
+	plan, err := gadget.PlanInstall(pv, "/dev/sda", 8*gadget.SizeMiB+33*512, nil)
+	c.Assert(err, IsNil)
+	c.Check(plan.Volume.PositionedStructure[3].Size, Equals, 4*gadget.SizeMiB)
+
+	// structures that are not expandable must fit
+	pv.PositionedStructure[3].MinSize = 0
+	_, err = gadget.PlanInstall(pv, "/dev/sda", 8*gadget.SizeMiB+33*512, nil)
+	c.Assert(err, ErrorMatches, `cannot install volume: structure #3 \("ubuntu-data"\) does not fit on the disk`)
+}
+
+func (s *installSuite) TestPlanInstallErrors(c *C) {
+	pv := mockInstallVolume()
+
+	_, err := gadget.PlanInstall(pv, "", 100*gadget.SizeMiB, nil)
+	c.Assert(err, ErrorMatches, "internal error: device path is unset")
+
+	pv.SectorSize = 4096
+	_, err = gadget.PlanInstall(pv, "/dev/sda", 100*gadget.SizeMiB, nil)
+	c.Assert(err, ErrorMatches, "cannot use sector size 4096")
+
+	pv = mockInstallVolume()
+	pv.PositionedStructure[3].Filesystem = ""
+	_, err = gadget.PlanInstall(pv, "/dev/sda", 100*gadget.SizeMiB, &gadget.InstallOptions{Encrypt: true})
+	c.Assert(err, ErrorMatches, `cannot encrypt structure #3 \("ubuntu-data"\) without a filesystem`)
+}
+
+type helperCall struct {
+	name  string
+	args  []string
+	stdin string
+}
+
+func mockHelpers(c *C, fail string) (calls *[]helperCall, restore func()) {
+	calls = &[]helperCall{}
+	restore = osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		call := helperCall{name: hc.Name, args: hc.Args}
+		if hc.Stdin != nil {
+			data, err := ioutil.ReadAll(hc.Stdin)
+			c.Assert(err, IsNil)
+			call.stdin = string(data)
+		}
+		*calls = append(*calls, call)
+		if hc.Name == fail {
+			return nil, errors.New("failed")
+		}
+		return nil, nil
+	})
+	return calls, restore
+}
+
+func (s *installSuite) TestInstallEncryptedHappy(c *C) {
+	calls, restore := mockHelpers(c, "")
+	defer restore()
+
+	plan, err := gadget.PlanInstall(mockInstallVolume(), "/dev/sda", 100*gadget.SizeMiB, &gadget.InstallOptions{Encrypt: true})
+	c.Assert(err, IsNil)
+
+	err = gadget.Install(plan, []byte("secret"))
+	c.Assert(err, IsNil)
+
+	c.Assert(*calls, HasLen, 9)
+	c.Check((*calls)[0].name, Equals, "sfdisk")
+	c.Check((*calls)[0].args, DeepEquals, []string{"/dev/sda"})
+	c.Check((*calls)[0].stdin, Equals, `unit: sectors
+label: gpt
+first-lba: 34
+
+start=2048, size=4096, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B, name="ubuntu-seed"
+start=6144, size=2048, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name="ubuntu-save"
+start=8192, size=196575, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name="ubuntu-data"
+`)
+	c.Check((*calls)[1:], DeepEquals, []helperCall{
+		{name: "udevadm", args: []string{"settle", "--timeout=180"}},
+		{name: "mkfs.vfat", args: []string{"-S", "512", "-s", "1", "-F", "32", "-n", "ubuntu-seed", "/dev/sda1"}},
+		{name: "cryptsetup", args: []string{"-q", "luksFormat", "--type", "luks2", "--key-file", "-", "/dev/sda2"}, stdin: "secret"},
+		{name: "cryptsetup", args: []string{"open", "--key-file", "-", "/dev/sda2", "ubuntu-save"}, stdin: "secret"},
+		{name: "fakeroot", args: []string{"mkfs.ext4", "-T", "default", "-O", "-metadata_csum", "-O", "uninit_bg", "-L", "ubuntu-save", "/dev/mapper/ubuntu-save"}},
+		{name: "cryptsetup", args: []string{"-q", "luksFormat", "--type", "luks2", "--key-file", "-", "/dev/sda3"}, stdin: "secret"},
+		{name: "cryptsetup", args: []string{"open", "--key-file", "-", "/dev/sda3", "ubuntu-data"}, stdin: "secret"},
+		{name: "fakeroot", args: []string{"mkfs.ext4", "-T", "default", "-O", "-metadata_csum", "-O", "uninit_bg", "-L", "writable", "/dev/mapper/ubuntu-data"}},
+	})
+}
+
+func (s *installSuite) TestInstallEncryptedNoKey(c *C) {
+	calls, restore := mockHelpers(c, "")
+	defer restore()
+
+	plan, err := gadget.PlanInstall(mockInstallVolume(), "/dev/sda", 100*gadget.SizeMiB, &gadget.InstallOptions{Encrypt: true})
+	c.Assert(err, IsNil)
+
+	err = gadget.Install(plan, nil)
+	c.Assert(err, ErrorMatches, "internal error: encryption key is unset")
+	c.Check(*calls, HasLen, 0)
+}
+
+func (s *installSuite) TestInstallCryptsetupError(c *C) {
+	calls, restore := mockHelpers(c, "cryptsetup")
+	defer restore()
+
+	plan, err := gadget.PlanInstall(mockInstallVolume(), "/dev/sda", 100*gadget.SizeMiB, &gadget.InstallOptions{Encrypt: true})
+	c.Assert(err, IsNil)
+
+	err = gadget.Install(plan, []byte("secret"))
+	c.Assert(err, ErrorMatches, `cannot set up encryption of structure #2 \("ubuntu-save"\): failed`)
+	// partitioned, settled, created the seed filesystem
+	c.Check(*calls, HasLen, 4)
+}
+
+func (s *installSuite) TestInstallPartitionError(c *C) {
+	calls, restore := mockHelpers(c, "sfdisk")
+	defer restore()
+
+	plan, err := gadget.PlanInstall(mockInstallVolume(), "/dev/sda", 100*gadget.SizeMiB, nil)
+	c.Assert(err, IsNil)
+
+	err = gadget.Install(plan, nil)
+	c.Assert(err, ErrorMatches, "cannot partition image using sfdisk: failed")
+	c.Check(*calls, HasLen, 1)
+}
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/strutil"
+)
+
+// filesystemKernelConfigs maps the filesystems that can be used by the
+// structures of gadget volumes to the kernel configuration option
+// enabling their support.
+var filesystemKernelConfigs = map[string]string{
+	"ext4": "CONFIG_EXT4_FS",
+	"vfat": "CONFIG_VFAT_FS",
+}
+
+// KernelFeatures describes the features of a kernel the volumes of a
+// gadget can depend on.
+type KernelFeatures struct {
+	// Filesystems are the filesystems supported by the kernel, either
+	// built-in or as modules.
+	Filesystems []string
+}
+
+// ParseKernelConfig returns the features of a kernel as described by its
+// build configuration, as shipped by kernel snaps in config-<version>.
+func ParseKernelConfig(config []byte) (*KernelFeatures, error) {
+	enabled := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(config))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("cannot parse kernel configuration line %q", line)
+		}
+		enabled[kv[0]] = kv[1] == "y" || kv[1] == "m"
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read kernel configuration: %v", err)
+	}
+
+	features := &KernelFeatures{}
+	for fs, option := range filesystemKernelConfigs {
+		if enabled[option] {
+			features.Filesystems = append(features.Filesystems, fs)
+		}
+	}
+	sort.Strings(features.Filesystems)
+	return features, nil
+}
+
+// ValidateKernelCompatibility checks that the kernel with the given
+// features supports what is used by the volumes of the gadget.
+func ValidateKernelCompatibility(gi *Info, features *KernelFeatures) error {
+	names := make([]string, 0, len(gi.Volumes))
+	for name := range gi.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vol := gi.Volumes[name]
+		for idx, s := range vol.Structure {
+			if s.IsBare() {
+				continue
+			}
+			if !strutil.ListContains(features.Filesystems, s.Filesystem) {
+				return fmt.Errorf("structure %v of volume %q uses filesystem %q not supported by the kernel (%s is not set)",
+					fmtIndexAndName(idx, s.Name), name, s.Filesystem, filesystemKernelConfigs[s.Filesystem])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+)
+
+type kernelSuite struct{}
+
+var _ = Suite(&kernelSuite{})
+
+const kernelConfig = `#
+# Automatically generated file; DO NOT EDIT.
+#
+CONFIG_EXT4_FS=y
+CONFIG_VFAT_FS=m
+# CONFIG_F2FS_FS is not set
+CONFIG_LOCALVERSION=""
+`
+
+func (s *kernelSuite) TestParseKernelConfig(c *C) {
+	features, err := gadget.ParseKernelConfig([]byte(kernelConfig))
+	c.Assert(err, IsNil)
+	c.Check(features, DeepEquals, &gadget.KernelFeatures{
+		Filesystems: []string{"ext4", "vfat"},
+	})
+
+	features, err = gadget.ParseKernelConfig([]byte("CONFIG_EXT4_FS=y\nCONFIG_VFAT_FS=n\n"))
+	c.Assert(err, IsNil)
+	c.Check(features.Filesystems, DeepEquals, []string{"ext4"})
+
+	_, err = gadget.ParseKernelConfig([]byte("CONFIG_EXT4_FS\n"))
+	c.Check(err, ErrorMatches, `cannot parse kernel configuration line "CONFIG_EXT4_FS"`)
+}
+
+func (s *kernelSuite) TestValidateKernelCompatibility(c *C) {
+	gi, err := gadget.InfoFromGadgetYaml(gadgetYamlPC, false)
+	c.Assert(err, IsNil)
+
+	err = gadget.ValidateKernelCompatibility(gi, &gadget.KernelFeatures{
+		Filesystems: []string{"ext4", "vfat"},
+	})
+	c.Check(err, IsNil)
+
+	err = gadget.ValidateKernelCompatibility(gi, &gadget.KernelFeatures{
+		Filesystems: []string{"ext4"},
+	})
+	c.Check(err, ErrorMatches, `structure #2 \("EFI System"\) of volume "pc" uses filesystem "vfat" not supported by the kernel \(CONFIG_VFAT_FS is not set\)`)
+}
@@ -21,8 +21,6 @@ package gadget
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/snapcore/snapd/osutil"
@@ -30,7 +28,7 @@ import (
 
 // MkfsExt4 creates an EXT4 filesystem in given image file, with an optional
 // filesystem label, and populates it with the contents of provided root
-// directory.
+// directory, if any.
 func MkfsExt4(img, label, contentsRootDir string) error {
 	// taken from ubuntu-image
 	mkfsArgs := []string{
@@ -43,27 +41,28 @@ func MkfsExt4(img, label, contentsRootDir string) error {
 		"-O", "-metadata_csum",
 		// allow uninitialized block groups
 		"-O", "uninit_bg",
+	}
+	if contentsRootDir != "" {
 		// mkfs.ext4 can populate the filesystem with contents of given
 		// root directory
 		// TODO: support e2fsprogs 1.42 without -d in Ubuntu 16.04
-		"-d", contentsRootDir,
+		mkfsArgs = append(mkfsArgs, "-d", contentsRootDir)
 	}
 	if label != "" {
 		mkfsArgs = append(mkfsArgs, "-L", label)
 	}
 	mkfsArgs = append(mkfsArgs, img)
 	// run through fakeroot so that files are owned by root
-	cmd := exec.Command("fakeroot", mkfsArgs...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return osutil.OutputErr(out, err)
-	}
-	return nil
+	_, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "fakeroot",
+		Args: mkfsArgs,
+	})
+	return err
 }
 
 // MkfsVfat creates a VFAT filesystem in given image file, with an optional
 // filesystem label, and populates it with the contents of provided root
-// directory.
+// directory, if any.
 func MkfsVfat(img, label, contentsRootDir string) error {
 	// taken from ubuntu-image
 	mkfsArgs := []string{
@@ -79,10 +78,15 @@ func MkfsVfat(img, label, contentsRootDir string) error {
 	}
 	mkfsArgs = append(mkfsArgs, img)
 
-	cmd := exec.Command("mkfs.vfat", mkfsArgs...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return osutil.OutputErr(out, err)
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "mkfs.vfat",
+		Args: mkfsArgs,
+	}); err != nil {
+		return err
+	}
+
+	if contentsRootDir == "" {
+		return nil
 	}
 
 	// mkfs.vfat does not know how to populate the filesystem with contents,
@@ -110,14 +114,14 @@ func MkfsVfat(img, label, contentsRootDir string) error {
 		// place content at the / of the filesystem
 		"::")
 
-	cmd = exec.Command("mcopy", mcopyArgs...)
-	cmd.Env = os.Environ()
-	// skip mtools checks to avoid unnecessary warnings
-	cmd.Env = append(cmd.Env, "MTOOLS_SKIP_CHECK=1")
-
-	out, err = cmd.CombinedOutput()
+	_, err = osutil.RunHelper(&osutil.HelperCommand{
+		Name: "mcopy",
+		Args: mcopyArgs,
+		// skip mtools checks to avoid unnecessary warnings
+		Env: []string{"MTOOLS_SKIP_CHECK=1"},
+	})
 	if err != nil {
-		return fmt.Errorf("cannot populate vfat filesystem with contents: %v", osutil.OutputErr(out, err))
+		return fmt.Errorf("cannot populate vfat filesystem with contents: %v", err)
 	}
 	return nil
 }
@@ -20,11 +20,13 @@
 package gadget_test
 
 import (
+	"fmt"
 	"path/filepath"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -90,6 +92,25 @@ func (m *mkfsSuite) TestMkfsExt4Happy(c *C) {
 	})
 }
 
+func (m *mkfsSuite) TestMkfsExt4NoContents(c *C) {
+	cmd := testutil.MockCommand(c, "fakeroot", "")
+	defer cmd.Restore()
+
+	err := gadget.MkfsExt4("/dev/mapper/ubuntu-data", "writable", "")
+	c.Assert(err, IsNil)
+	c.Check(cmd.Calls(), DeepEquals, [][]string{
+		{
+			"fakeroot",
+			"mkfs.ext4",
+			"-T", "default",
+			"-O", "-metadata_csum",
+			"-O", "uninit_bg",
+			"-L", "writable",
+			"/dev/mapper/ubuntu-data",
+		},
+	})
+}
+
 func (m *mkfsSuite) TestMkfsExt4Error(c *C) {
 	cmd := testutil.MockCommand(c, "fakeroot", "echo 'command failed'; exit 1")
 	defer cmd.Restore()
@@ -188,3 +209,29 @@ func (m *mkfsSuite) TestMkfsVfatErrorInMcopy(c *C) {
 	c.Assert(cmdMkfs.Calls(), HasLen, 1)
 	c.Assert(cmdMcopy.Calls(), HasLen, 1)
 }
+
+func (m *mkfsSuite) TestMkfsVfatHelperTimeout(c *C) {
+	d := c.MkDir()
+	makeSizedFile(c, filepath.Join(d, "foo"), 128, []byte("foo foo foo"))
+
+	var calls []*osutil.HelperCommand
+	restore := osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		calls = append(calls, hc)
+		if hc.Name == "mcopy" {
+			return nil, &osutil.HelperError{
+				Name: hc.Name,
+				Kind: osutil.HelperTimedOut,
+				Err:  fmt.Errorf("exceeded maximum runtime of 10m0s"),
+			}
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	err := gadget.MkfsVfat("foo.img", "my-label", d)
+	c.Assert(err, ErrorMatches, `cannot populate vfat filesystem with contents: helper "mcopy" exceeded maximum runtime of 10m0s`)
+	c.Assert(calls, HasLen, 2)
+	c.Check(calls[0].Name, Equals, "mkfs.vfat")
+	c.Check(calls[1].Name, Equals, "mcopy")
+	c.Check(calls[1].Env, DeepEquals, []string{"MTOOLS_SKIP_CHECK=1"})
+}
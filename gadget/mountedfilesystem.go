@@ -60,6 +60,9 @@ func checkContent(content *VolumeContent) error {
 type MountedFilesystemWriter struct {
 	contentDir string
 	ps         *PositionedStructure
+	// rendered maps the source of template content to the location of its
+	// rendered copy
+	rendered map[string]string
 }
 
 // NewMountedFilesystemWriter returns a writer capable of writing provided
@@ -196,16 +199,16 @@ func (m *MountedFilesystemWriter) writeVolumeContent(volumeRoot string, content
 	if err := checkContent(content); err != nil {
 		return err
 	}
-	realSource := filepath.Join(m.contentDir, content.Source)
+	realSource, err := m.sourcePath(content)
+	if err != nil {
+		return err
+	}
 	realTarget := filepath.Join(volumeRoot, content.Target)
 
 	// filepath trims the trailing /, restore if needed
 	if strings.HasSuffix(content.Target, "/") {
 		realTarget += "/"
 	}
-	if strings.HasSuffix(content.Source, "/") {
-		realSource += "/"
-	}
 
 	if osutil.IsDirectory(realSource) || strings.HasSuffix(content.Source, "/") {
 		// write a directory
@@ -299,8 +302,12 @@ func (f *MountedFilesystemUpdater) entryDestPaths(dstRoot, source, target, backu
 }
 
 // entrySourcePath returns the path of given source entry within the root
-// directory provided during initialization.
+// directory provided during initialization, or the location of the rendered
+// copy if the entry is a template.
 func (f *MountedFilesystemUpdater) entrySourcePath(source string) string {
+	if rendered, ok := f.rendered[source]; ok {
+		return rendered
+	}
 	srcPath := filepath.Join(f.contentDir, source)
 
 	if strings.HasSuffix(source, "/") {
@@ -408,17 +415,42 @@ func (f *MountedFilesystemUpdater) updateOrSkipFile(dstRoot, source, target stri
 			// as there is no backup
 			return fmt.Errorf("missing backup file %q for %v", backupPath+".backup", target)
 		}
+		if migrate := bootConfigMigrations[filepath.Base(dstPath)]; migrate != nil {
+			// the backup is used for rollback as usual
+			return migrateFile(migrate, srcPath, dstPath)
+		}
 	}
 
 	return writeFile(srcPath, dstPath, preserveInDst)
 }
 
+// migrateFile replaces the file at dst with the file at src, once
+// migrated from dst.
+func migrateFile(migrate BootConfigMigrationFunc, src, dst string) error {
+	tmp := dst + ".migrating"
+	if err := osutil.CopyFile(src, tmp, osutil.CopyFlagOverwrite|osutil.CopyFlagSync); err != nil {
+		return fmt.Errorf("cannot copy %s: %v", src, err)
+	}
+	if err := migrate(dst, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot migrate %s: %v", dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot replace %s: %v", dst, err)
+	}
+	return nil
+}
+
 func (f *MountedFilesystemUpdater) updateVolumeContent(volumeRoot string, content *VolumeContent, preserveInDst []string, backupDir string) error {
 	if err := checkContent(content); err != nil {
 		return err
 	}
 
-	srcPath := f.entrySourcePath(content.Source)
+	srcPath, err := f.sourcePath(content)
+	if err != nil {
+		return err
+	}
 
 	if osutil.IsDirectory(srcPath) || strings.HasSuffix(content.Source, "/") {
 		return f.updateDirectory(volumeRoot, content.Source, content.Target, preserveInDst, backupDir)
@@ -635,7 +667,10 @@ func (f *MountedFilesystemUpdater) backupVolumeContent(volumeRoot string, conten
 		return err
 	}
 
-	srcPath := f.entrySourcePath(content.Source)
+	srcPath, err := f.sourcePath(content)
+	if err != nil {
+		return err
+	}
 
 	if err := f.checkpointPrefix(volumeRoot, content.Target, backupDir); err != nil {
 		return err
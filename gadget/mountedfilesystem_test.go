@@ -21,6 +21,7 @@ package gadget_test
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -252,6 +253,133 @@ func (s *mountedfilesystemTestSuite) TestMountedWriterHappy(c *C) {
 	c.Assert(osutil.IsDirectory(filepath.Join(outDir, "empty-dir")), Equals, true)
 }
 
+func (s *mountedfilesystemTestSuite) TestMountedWriterTemplates(c *C) {
+	gd := []gadgetData{
+		{name: "boot-assets/grub.conf", content: "serial={{.Serial}} model={{.Model}} arch={{.Architecture}}"},
+		{name: "boot-assets/plain", content: "{{.Serial}}"},
+	}
+	makeGadgetData(c, s.dir, gd)
+
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Size:       2048,
+			Filesystem: "ext4",
+			Content: []gadget.VolumeContent{
+				{
+					Source:   "boot-assets/grub.conf",
+					Target:   "/EFI/ubuntu/",
+					Template: true,
+				}, {
+					Source: "boot-assets/plain",
+					Target: "/plain",
+				},
+			},
+		},
+	}
+
+	rw, err := gadget.NewMountedFilesystemWriter(s.dir, ps)
+	c.Assert(err, IsNil)
+
+	outDir := c.MkDir()
+	// templates must be rendered first
+	err = rw.Write(outDir, nil)
+	c.Assert(err, ErrorMatches, `cannot write filesystem content of source:boot-assets/grub.conf: internal error: template source:boot-assets/grub.conf was not rendered`)
+
+	err = rw.RenderTemplates(nil, c.MkDir())
+	c.Assert(err, ErrorMatches, `cannot render template source:boot-assets/grub.conf: no template variables provided`)
+
+	workDir := c.MkDir()
+	err = rw.RenderTemplates(&gadget.TemplateVars{
+		Model:        "pc",
+		Serial:       "1234",
+		Architecture: "amd64",
+	}, workDir)
+	c.Assert(err, IsNil)
+
+	err = rw.Write(outDir, nil)
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(outDir, "EFI/ubuntu/grub.conf"), testutil.FileEquals, "serial=1234 model=pc arch=amd64")
+	// content not marked as a template is copied verbatim
+	c.Check(filepath.Join(outDir, "plain"), testutil.FileEquals, "{{.Serial}}")
+	// the gadget data is left untouched
+	c.Check(filepath.Join(s.dir, "boot-assets/grub.conf"), testutil.FileEquals, "serial={{.Serial}} model={{.Model}} arch={{.Architecture}}")
+}
+
+func (s *mountedfilesystemTestSuite) TestMountedWriterTemplateErrors(c *C) {
+	gd := []gadgetData{
+		{name: "unknown", content: "{{.Unknown}}"},
+		{name: "broken", content: "{{.Serial"},
+		{name: "dir/"},
+	}
+	makeGadgetData(c, s.dir, gd)
+
+	for _, tc := range []struct {
+		source string
+		err    string
+	}{
+		{"unknown", `cannot render template source:unknown: cannot render template: .*map has no entry for key "Unknown"`},
+		{"broken", `cannot render template source:broken: cannot parse template: .*`},
+		{"dir", `cannot render template source:dir: source is a directory`},
+		{"missing", `cannot render template source:missing: cannot read template: .*no such file or directory`},
+	} {
+		ps := &gadget.PositionedStructure{
+			VolumeStructure: &gadget.VolumeStructure{
+				Size:       2048,
+				Filesystem: "ext4",
+				Content: []gadget.VolumeContent{
+					{Source: tc.source, Target: "/", Template: true},
+				},
+			},
+		}
+		rw, err := gadget.NewMountedFilesystemWriter(s.dir, ps)
+		c.Assert(err, IsNil)
+
+		err = rw.RenderTemplates(&gadget.TemplateVars{Serial: "1234"}, c.MkDir())
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *mountedfilesystemTestSuite) TestMountedWriterTemplateVars(c *C) {
+	gd := []gadgetData{
+		{name: "cmdline.txt", content: "console=ttyS0 serial={{.Serial}}"},
+	}
+	makeGadgetData(c, s.dir, gd)
+
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Size:       2048,
+			Filesystem: "ext4",
+			Content: []gadget.VolumeContent{
+				{Source: "cmdline.txt", Target: "/", Template: true},
+			},
+		},
+	}
+	rw, err := gadget.NewMountedFilesystemWriter(s.dir, ps)
+	c.Assert(err, IsNil)
+
+	for _, tc := range []struct {
+		vars *gadget.TemplateVars
+		err  string
+	}{
+		// a device that is not registered yet has no serial
+		{&gadget.TemplateVars{Model: "pc"}, `cannot render template source:cmdline.txt: cannot render template: .*map has no entry for key "Serial"`},
+		{&gadget.TemplateVars{Model: "pc", Serial: "1234 init=/bin/sh"}, `cannot render template source:cmdline.txt: invalid serial "1234 init=/bin/sh", expected only letters, digits, '.', '_' or '-'`},
+		{&gadget.TemplateVars{Model: "pc\n", Serial: "1234"}, `cannot render template source:cmdline.txt: invalid model "pc\\n", .*`},
+		{&gadget.TemplateVars{Serial: "1234", Architecture: "amd64;"}, `cannot render template source:cmdline.txt: invalid architecture "amd64;", .*`},
+	} {
+		err = rw.RenderTemplates(tc.vars, c.MkDir())
+		c.Check(err, ErrorMatches, tc.err)
+	}
+
+	err = rw.RenderTemplates(&gadget.TemplateVars{Model: "pc", Serial: "AB-12_3.4"}, c.MkDir())
+	c.Assert(err, IsNil)
+	outDir := c.MkDir()
+	err = rw.Write(outDir, nil)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(outDir, "cmdline.txt"), testutil.FileEquals, "console=ttyS0 serial=AB-12_3.4")
+}
+
 func (s *mountedfilesystemTestSuite) TestMountedWriterNonDirectory(c *C) {
 	gd := []gadgetData{
 		{name: "foo", content: "nested"},
@@ -1203,6 +1331,161 @@ func (s *mountedfilesystemTestSuite) TestMountedUpdaterUpdate(c *C) {
 	verifyWrittenGadgetData(c, outDir, gdWritten)
 }
 
+func (s *mountedfilesystemTestSuite) TestMountedUpdaterUpdateTemplates(c *C) {
+	gd := []gadgetData{
+		{name: "cmdline.txt", content: "serial={{.Serial}}"},
+		{name: "same.txt", content: "model={{.Model}}"},
+	}
+	makeGadgetData(c, s.dir, gd)
+
+	outDir := filepath.Join(c.MkDir(), "out-dir")
+	makeExistingData(c, outDir, []gadgetData{
+		{target: "cmdline.txt", content: "serial=old"},
+		{target: "same.txt", content: "model=pc"},
+	})
+
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Size:       2048,
+			Filesystem: "ext4",
+			Content: []gadget.VolumeContent{
+				{Source: "cmdline.txt", Target: "/", Template: true},
+				{Source: "same.txt", Target: "/", Template: true},
+			},
+			Update: gadget.VolumeUpdate{
+				Edition: 1,
+			},
+		},
+	}
+
+	rw, err := gadget.NewMountedFilesystemUpdater(s.dir, ps, s.backup, func(to *gadget.PositionedStructure) (string, error) {
+		return outDir, nil
+	})
+	c.Assert(err, IsNil)
+
+	err = rw.RenderTemplates(&gadget.TemplateVars{Model: "pc", Serial: "1234"}, filepath.Join(s.backup, "templates"))
+	c.Assert(err, IsNil)
+
+	err = rw.Backup()
+	c.Assert(err, IsNil)
+
+	// the rendered content is compared with the current data
+	c.Check(filepath.Join(s.backup, "struct-0/cmdline.txt.backup"), testutil.FileEquals, "serial=old")
+	c.Check(osutil.FileExists(filepath.Join(s.backup, "struct-0/same.txt.same")), Equals, true)
+
+	err = rw.Update()
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(outDir, "cmdline.txt"), testutil.FileEquals, "serial=1234")
+	c.Check(filepath.Join(outDir, "same.txt"), testutil.FileEquals, "model=pc")
+
+	err = rw.Rollback()
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(outDir, "cmdline.txt"), testutil.FileEquals, "serial=old")
+}
+
+func (s *mountedfilesystemTestSuite) TestMountedUpdaterUpdateMigratesBootConfig(c *C) {
+	restore := gadget.MockBootConfigMigrations(map[string]gadget.BootConfigMigrationFunc{
+		"grubenv": func(current, update string) error {
+			cur, err := ioutil.ReadFile(current)
+			c.Assert(err, IsNil)
+			upd, err := ioutil.ReadFile(update)
+			c.Assert(err, IsNil)
+			return ioutil.WriteFile(update, []byte(string(upd)+" with "+string(cur)), 0644)
+		},
+	})
+	defer restore()
+
+	makeGadgetData(c, s.dir, []gadgetData{
+		{name: "grubenv", target: "EFI/ubuntu/grubenv", content: "new env"},
+		{name: "grub.cfg", target: "EFI/ubuntu/grub.cfg", content: "new config"},
+	})
+	outDir := filepath.Join(c.MkDir(), "out-dir")
+	makeExistingData(c, outDir, []gadgetData{
+		{target: "EFI/ubuntu/grubenv", content: "boot vars"},
+		{target: "EFI/ubuntu/grub.cfg", content: "old config"},
+	})
+
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Size:       2048,
+			Filesystem: "vfat",
+			Content: []gadget.VolumeContent{
+				{Source: "grubenv", Target: "/EFI/ubuntu/grubenv"},
+				{Source: "grub.cfg", Target: "/EFI/ubuntu/grub.cfg"},
+			},
+			Update: gadget.VolumeUpdate{
+				Edition: 1,
+			},
+		},
+	}
+
+	rw, err := gadget.NewMountedFilesystemUpdater(s.dir, ps, s.backup, func(to *gadget.PositionedStructure) (string, error) {
+		return outDir, nil
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(rw.Backup(), IsNil)
+	c.Assert(rw.Update(), IsNil)
+
+	// the environment was migrated, the config replaced
+	verifyWrittenGadgetData(c, outDir, []gadgetData{
+		{target: "EFI/ubuntu/grubenv", content: "new env with boot vars"},
+		{target: "EFI/ubuntu/grub.cfg", content: "new config"},
+	})
+	c.Check(filepath.Join(outDir, "EFI/ubuntu/grubenv.migrating"), testutil.FileAbsent)
+
+	// and both are restored on rollback
+	c.Assert(rw.Rollback(), IsNil)
+	verifyWrittenGadgetData(c, outDir, []gadgetData{
+		{target: "EFI/ubuntu/grubenv", content: "boot vars"},
+		{target: "EFI/ubuntu/grub.cfg", content: "old config"},
+	})
+}
+
+func (s *mountedfilesystemTestSuite) TestMountedUpdaterUpdateMigrateBootConfigFails(c *C) {
+	restore := gadget.MockBootConfigMigrations(map[string]gadget.BootConfigMigrationFunc{
+		"grubenv": func(current, update string) error {
+			return errors.New("boom")
+		},
+	})
+	defer restore()
+
+	makeGadgetData(c, s.dir, []gadgetData{
+		{name: "grubenv", target: "grubenv", content: "new env"},
+	})
+	outDir := filepath.Join(c.MkDir(), "out-dir")
+	makeExistingData(c, outDir, []gadgetData{
+		{target: "grubenv", content: "boot vars"},
+	})
+
+	ps := &gadget.PositionedStructure{
+		VolumeStructure: &gadget.VolumeStructure{
+			Size:       2048,
+			Filesystem: "vfat",
+			Content: []gadget.VolumeContent{
+				{Source: "grubenv", Target: "/grubenv"},
+			},
+			Update: gadget.VolumeUpdate{
+				Edition: 1,
+			},
+		},
+	}
+
+	rw, err := gadget.NewMountedFilesystemUpdater(s.dir, ps, s.backup, func(to *gadget.PositionedStructure) (string, error) {
+		return outDir, nil
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(rw.Backup(), IsNil)
+	err = rw.Update()
+	c.Assert(err, ErrorMatches, `cannot update content: cannot migrate .*/out-dir/grubenv: boom`)
+
+	c.Check(filepath.Join(outDir, "grubenv"), testutil.FileEquals, "boot vars")
+	c.Check(filepath.Join(outDir, "grubenv.migrating"), testutil.FileAbsent)
+}
+
 func (s *mountedfilesystemTestSuite) TestMountedUpdaterUpdateLookupFails(c *C) {
 	makeGadgetData(c, s.dir, []gadgetData{
 		{name: "canary", target: "canary", content: "data"},
@@ -21,7 +21,7 @@ package gadget
 import (
 	"bytes"
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/snapcore/snapd/logger"
@@ -45,6 +45,63 @@ func splitType(maybeHybridType string) (mbrTypeID, gptTypeID string) {
 	return maybeHybridType[:idx], maybeHybridType[idx+1:]
 }
 
+// mbrPrimaryPartitions is the number of partitions an MBR holds. When
+// a volume needs more, the last one is an extended partition holding
+// the remaining ones as logical partitions, which are numbered from 5
+// and preceded each by a sector with their extended boot record.
+const mbrPrimaryPartitions = 4
+
+// partitionStructures returns the structures of the volume that are
+// created as partitions, in order of appearance.
+func partitionStructures(pv *PositionedVolume) []PositionedStructure {
+	var parts []PositionedStructure
+	for _, ps := range pv.PositionedStructure {
+		if ps.Type == "bare" || ps.Type == MBR {
+			continue
+		}
+		parts = append(parts, ps)
+	}
+	return parts
+}
+
+// firstLogicalPartition returns the index, among the partitions of the
+// volume, of the first one created as a logical partition, or the
+// number of partitions if all of them are primary ones.
+func firstLogicalPartition(pv *PositionedVolume, parts []PositionedStructure) int {
+	if pv.EffectiveSchema() != MBR || len(parts) <= mbrPrimaryPartitions {
+		return len(parts)
+	}
+	return mbrPrimaryPartitions - 1
+}
+
+// partitionNumber returns the number of the partition created for the
+// partition with the given index among the partitions of the volume.
+func partitionNumber(idx, firstLogical int) int {
+	if idx < firstLogical {
+		return idx + 1
+	}
+	// the extended partition takes the last primary number
+	return mbrPrimaryPartitions + 1 + idx - firstLogical
+}
+
+// checkLogicalPartitions checks that the sector preceding each logical
+// partition is free to hold its extended boot record.
+func checkLogicalPartitions(pv *PositionedVolume, parts []PositionedStructure, firstLogical int) error {
+	for _, lp := range parts[firstLogical:] {
+		ebr := lp.StartOffset - pv.SectorSize
+		free := lp.StartOffset >= pv.SectorSize
+		for _, ps := range pv.PositionedStructure {
+			if ps.StartOffset < ebr+pv.SectorSize && ps.StartOffset+ps.Size > ebr {
+				free = false
+			}
+		}
+		if !free {
+			return fmt.Errorf("cannot create logical partition for structure %v: no free sector before it for its extended boot record", lp)
+		}
+	}
+	return nil
+}
+
 func Partition(image string, pv *PositionedVolume) error {
 	if image == "" {
 		return fmt.Errorf("internal error: image path is unset")
@@ -73,43 +130,118 @@ func Partition(image string, pv *PositionedVolume) error {
 	}
 	fmt.Fprintf(script, "\n")
 
-	for _, ps := range pv.PositionedStructure {
-		if ps.Type == "bare" || ps.Type == "mbr" {
-			continue
+	parts := partitionStructures(pv)
+	firstLogical := firstLogicalPartition(pv, parts)
+	if err := checkLogicalPartitions(pv, parts, firstLogical); err != nil {
+		return err
+	}
+
+	for idx, ps := range parts {
+		if idx == firstLogical {
+			// the extended partition spans the logical ones
+			// and the extended boot record of the first one
+			last := parts[len(parts)-1]
+			start := asSector(ps.StartOffset) - 1
+			fmt.Fprintf(script, "start=%v, size=%v, type=5\n", start, asSector(last.StartOffset+last.Size)-start)
 		}
+		writePartitionEntry(script, pv, &ps)
+	}
+	return runSfdisk(script.String(), image)
+}
 
-		start := asSector(ps.StartOffset)
-		size := asSector(ps.Size)
-		fmt.Fprintf(script, "start=%v, size=%v", start, size)
+// writePartitionEntry writes the line of the sfdisk script describing
+// the partition of the structure.
+func writePartitionEntry(script *bytes.Buffer, pv *PositionedVolume, ps *PositionedStructure) {
+	asSector := func(v Size) Size {
+		return v / pv.SectorSize
+	}
 
-		mbrType, gptType := splitType(ps.Type)
-		pType := mbrType
-		if pv.EffectiveSchema() == GPT {
-			pType = gptType
-		}
-		if pType != "" {
-			fmt.Fprintf(script, ", type=%v", pType)
-		}
+	fmt.Fprintf(script, "start=%v, size=%v", asSector(ps.StartOffset), asSector(ps.Size))
 
-		if pv.EffectiveSchema() == GPT && ps.Name != "" {
-			fmt.Fprintf(script, ", name=%q", ps.Name)
-		}
-		if pv.EffectiveSchema() == MBR && ps.EffectiveRole() == SystemBoot {
-			fmt.Fprintf(script, ", bootable")
+	mbrType, gptType := splitType(ps.Type)
+	pType := mbrType
+	if pv.EffectiveSchema() == GPT {
+		pType = gptType
+	}
+	if pType != "" {
+		fmt.Fprintf(script, ", type=%v", pType)
+	}
+
+	if pv.EffectiveSchema() == GPT && ps.Name != "" {
+		fmt.Fprintf(script, ", name=%q", ps.Name)
+	}
+	if pv.EffectiveSchema() == MBR && ps.EffectiveRole() == SystemBoot {
+		fmt.Fprintf(script, ", bootable")
+	}
+
+	fmt.Fprintf(script, "\n")
+}
+
+// PartitionEntry describes the partition of a structure of a volume, as
+// created by Partition.
+type PartitionEntry struct {
+	// Number is the number of the partition.
+	Number int `json:"number"`
+	// Script describes the partition in the sfdisk script format.
+	Script string `json:"script"`
+}
+
+// PartitionEntryForStructure returns the partition Partition creates for
+// the structure with the given index in the volume.
+func PartitionEntryForStructure(pv *PositionedVolume, structureIndex int) (*PartitionEntry, error) {
+	if pv.SectorSize != 512 {
+		return nil, fmt.Errorf("cannot use sector size %v", pv.SectorSize)
+	}
+	parts := partitionStructures(pv)
+	firstLogical := firstLogicalPartition(pv, parts)
+	for idx, ps := range parts {
+		if ps.Index != structureIndex {
+			continue
 		}
+		script := &bytes.Buffer{}
+		writePartitionEntry(script, pv, &ps)
+		return &PartitionEntry{
+			Number: partitionNumber(idx, firstLogical),
+			Script: script.String(),
+		}, nil
+	}
+	return nil, fmt.Errorf("structure #%d of the volume has no partition", structureIndex)
+}
 
-		fmt.Fprintf(script, "\n")
+// ReplacePartition creates the partition described by the entry on the
+// disk anew, in place of the partition with the same number. The other
+// partitions of the disk are left alone. It returns the device node of
+// the partition.
+func ReplacePartition(disk string, entry *PartitionEntry) (string, error) {
+	num := strconv.Itoa(entry.Number)
+	// the disk is in use, only the partition is re-read
+	if err := runSfdisk(entry.Script, "--no-reread", "-N", num, disk); err != nil {
+		return "", err
+	}
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "partx",
+		Args: []string{"--update", "--nr", num, disk},
+	}); err != nil {
+		return "", fmt.Errorf("cannot update partition %s of %s: %v", num, disk, err)
+	}
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "udevadm",
+		Args: []string{"settle", "--timeout=180"},
+	}); err != nil {
+		return "", fmt.Errorf("cannot wait for partition: %v", err)
 	}
-	return runSfdisk(image, script.String())
+	return partitionNode(disk, entry.Number), nil
 }
 
-func runSfdisk(image string, script string) error {
-	cmd := exec.Command("sfdisk", image)
-	cmd.Stdin = bytes.NewBufferString(script)
-	out, err := cmd.CombinedOutput()
+func runSfdisk(script string, args ...string) error {
+	_, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:  "sfdisk",
+		Args:  args,
+		Stdin: bytes.NewBufferString(script),
+	})
 	if err != nil {
 		logger.Noticef("failed sfdisk script:\n%v", script)
-		return fmt.Errorf("cannot partition image using sfdisk: %v", osutil.OutputErr(out, err))
+		return fmt.Errorf("cannot partition image using sfdisk: %v", err)
 	}
 	return nil
 }
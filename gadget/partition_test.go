@@ -173,6 +173,55 @@ start=264192, size=24576
 	})
 }
 
+func mockMBRVolumeWithPartitions(n int, gap gadget.Size) *gadget.PositionedVolume {
+	pv := &gadget.PositionedVolume{
+		Volume: &gadget.Volume{
+			Schema: "mbr",
+		},
+		SectorSize: 512,
+	}
+	offset := 1 * gadget.SizeMiB
+	for i := 0; i < n; i++ {
+		pv.PositionedStructure = append(pv.PositionedStructure, gadget.PositionedStructure{
+			VolumeStructure: &gadget.VolumeStructure{
+				Size: 1 * gadget.SizeMiB,
+				Name: fmt.Sprintf("part%d", i),
+				Type: "83",
+			},
+			StartOffset: offset,
+			Index:       i,
+		})
+		offset += 1*gadget.SizeMiB + gap
+	}
+	pv.Size = offset
+	return pv
+}
+
+func (s *partitionSuite) TestMBRLogicalPartitions(c *C) {
+	pv := mockMBRVolumeWithPartitions(5, 1*gadget.SizeMiB)
+
+	err := gadget.Partition("foo", pv)
+	c.Assert(err, IsNil)
+	c.Assert(s.input(c), Equals, `unit: sectors
+label: dos
+
+start=2048, size=2048, type=83
+start=6144, size=2048, type=83
+start=10240, size=2048, type=83
+start=14335, size=6145, type=5
+start=14336, size=2048, type=83
+start=18432, size=2048, type=83
+`)
+}
+
+func (s *partitionSuite) TestMBRLogicalPartitionsNoRoomForEBR(c *C) {
+	pv := mockMBRVolumeWithPartitions(5, 0)
+
+	err := gadget.Partition("foo", pv)
+	c.Assert(err, ErrorMatches, `cannot create logical partition for structure #3 \("part3"\): no free sector before it for its extended boot record`)
+	c.Assert(s.sfdisk.Calls(), HasLen, 0)
+}
+
 func (s *partitionSuite) TestHybridType(c *C) {
 	ps := gadget.PositionedStructure{
 		VolumeStructure: &gadget.VolumeStructure{
@@ -271,3 +320,70 @@ func (s *partitionSuite) TestCommandError(c *C) {
 	c.Assert(err, ErrorMatches, "cannot partition image using sfdisk: failed")
 	c.Assert(s.sfdisk.Calls(), HasLen, 0)
 }
+
+func (s *partitionSuite) TestReplacePartition(c *C) {
+	pv := &gadget.PositionedVolume{
+		Volume: &gadget.Volume{
+			Schema: "gpt",
+		},
+		Size:       32 * gadget.SizeMiB,
+		SectorSize: 512,
+		PositionedStructure: []gadget.PositionedStructure{
+			{
+				VolumeStructure: &gadget.VolumeStructure{
+					Size: 128 * gadget.SizeKiB,
+					Name: "not-visible",
+					Type: "bare",
+				},
+				StartOffset: 128 * gadget.SizeKiB,
+				Index:       0,
+			}, {
+				VolumeStructure: &gadget.VolumeStructure{
+					Size: 4 * gadget.SizeMiB,
+					Name: "ubuntu-seed",
+					Type: "21686148-6449-6E6F-744E-656564454649",
+					Role: "system-seed",
+				},
+				StartOffset: 1 * gadget.SizeMiB,
+				Index:       1,
+			}, {
+				VolumeStructure: &gadget.VolumeStructure{
+					Size: 8 * gadget.SizeMiB,
+					Name: "ubuntu-save",
+					Type: "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+					Role: "system-save",
+				},
+				StartOffset: 5 * gadget.SizeMiB,
+				Index:       2,
+			},
+		},
+	}
+
+	_, err := gadget.PartitionEntryForStructure(pv, 0)
+	c.Check(err, ErrorMatches, `structure #0 of the volume has no partition`)
+	entry, err := gadget.PartitionEntryForStructure(pv, 2)
+	c.Assert(err, IsNil)
+	c.Check(entry, DeepEquals, &gadget.PartitionEntry{
+		Number: 2,
+		Script: "start=10240, size=16384, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name=\"ubuntu-save\"\n",
+	})
+
+	partx := testutil.MockCommand(c, "partx", "")
+	defer partx.Restore()
+	udevadm := testutil.MockCommand(c, "udevadm", "")
+	defer udevadm.Restore()
+
+	node, err := gadget.ReplacePartition("/dev/mmcblk0", entry)
+	c.Assert(err, IsNil)
+	c.Check(node, Equals, "/dev/mmcblk0p2")
+	c.Check(s.input(c), Equals, entry.Script)
+	c.Check(s.sfdisk.Calls(), DeepEquals, [][]string{
+		{"sfdisk", "--no-reread", "-N", "2", "/dev/mmcblk0"},
+	})
+	c.Check(partx.Calls(), DeepEquals, [][]string{
+		{"partx", "--update", "--nr", "2", "/dev/mmcblk0"},
+	})
+	c.Check(udevadm.Calls(), DeepEquals, [][]string{
+		{"udevadm", "settle", "--timeout=180"},
+	})
+}
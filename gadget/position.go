@@ -154,6 +154,7 @@ func PositionVolume(gadgetRootDir string, volume *Volume, constraints Positionin
 	sort.Sort(byStartOffset(structures))
 
 	previousEnd = Size(0)
+	var offsetWriteTargets []offsetWriteTarget
 	for idx, ps := range structures {
 		if ps.StartOffset < previousEnd {
 			return nil, fmt.Errorf("cannot position volume, structure %v overlaps with preceding structure %v", ps, structures[idx-1])
@@ -166,8 +167,14 @@ func PositionVolume(gadgetRootDir string, volume *Volume, constraints Positionin
 		}
 		structures[idx].PositionedOffsetWrite = offsetWrite
 
-		if offsetWrite != nil && *offsetWrite > fartherstOffsetWrite {
-			fartherstOffsetWrite = *offsetWrite
+		if offsetWrite != nil {
+			if *offsetWrite > fartherstOffsetWrite {
+				fartherstOffsetWrite = *offsetWrite
+			}
+			offsetWriteTargets = append(offsetWriteTargets, offsetWriteTarget{
+				offset: *offsetWrite,
+				what:   fmt.Sprintf("structure %v", ps),
+			})
 		}
 
 		content, err := positionStructureContent(gadgetRootDir, &structures[idx], structuresByName)
@@ -176,14 +183,25 @@ func PositionVolume(gadgetRootDir string, volume *Volume, constraints Positionin
 		}
 
 		for _, c := range content {
-			if c.PositionedOffsetWrite != nil && *c.PositionedOffsetWrite > fartherstOffsetWrite {
+			if c.PositionedOffsetWrite == nil {
+				continue
+			}
+			if *c.PositionedOffsetWrite > fartherstOffsetWrite {
 				fartherstOffsetWrite = *c.PositionedOffsetWrite
 			}
+			offsetWriteTargets = append(offsetWriteTargets, offsetWriteTarget{
+				offset: *c.PositionedOffsetWrite,
+				what:   fmt.Sprintf("structure %v, content %v", ps, fmtIndexAndName(c.Index, c.Image)),
+			})
 		}
 
 		structures[idx].PositionedContent = content
 	}
 
+	if err := validateOffsetWriteTargets(structures, offsetWriteTargets); err != nil {
+		return nil, fmt.Errorf("cannot position volume, %v", err)
+	}
+
 	volumeSize := farthestEnd
 	if fartherstOffsetWrite+SizeLBA48Pointer > farthestEnd {
 		volumeSize = fartherstOffsetWrite + SizeLBA48Pointer
@@ -957,6 +957,62 @@ func (p *positioningTestSuite) TestVolumePositionOffsetWriteBadRelativeTo(c *C)
 	c.Check(err, ErrorMatches, `cannot resolve offset-write of structure #0 \("foo"\) content "foo.img": refers to an unknown structure "bar"`)
 }
 
+func (p *positioningTestSuite) TestVolumePositionOffsetWriteOverlapsOnceShifted(c *C) {
+	// the offset-write target is past the end of the structure as
+	// validated, but the structure is moved to 1MB when positioned
+	var gadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+      - name: foo
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 1M
+        offset-write: 1049100
+`
+	vol := mustParseVolume(c, gadgetYaml, "pc")
+
+	v, err := gadget.PositionVolume(p.dir, vol, defaultConstraints)
+	c.Check(v, IsNil)
+	c.Check(err, ErrorMatches, `cannot position volume, offset-write of structure #1 \("foo"\) at 0x10020c overlaps with structure #1 \("foo"\) with a filesystem`)
+}
+
+func (p *positioningTestSuite) TestVolumePositionOffsetWriteContentOverlap(c *C) {
+	var gadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: mbr
+        type: mbr
+        size: 440
+      - name: foo
+        type: DA,21686148-6449-6E6F-744E-656564454649
+        size: 1M
+        offset: 1M
+        content:
+          - image: foo.img
+            offset-write: mbr+92
+          - image: bar.img
+            offset-write: mbr+93
+`
+	makeSizedFile(c, filepath.Join(p.dir, "foo.img"), 200*gadget.SizeKiB, []byte(""))
+	makeSizedFile(c, filepath.Join(p.dir, "bar.img"), 150*gadget.SizeKiB, []byte(""))
+
+	// bypass validation, which would have caught it
+	var gi gadget.Info
+	c.Assert(yaml.Unmarshal([]byte(gadgetYaml), &gi), IsNil)
+	vol := gi.Volumes["pc"]
+
+	v, err := gadget.PositionVolume(p.dir, &vol, defaultConstraints)
+	c.Check(v, IsNil)
+	c.Check(err, ErrorMatches, `cannot position volume, offset-write of structure #1 \("foo"\), content #1 \("bar.img"\) at 0x5d overlaps with offset-write of structure #1 \("foo"\), content #0 \("foo.img"\) at 0x5c`)
+}
+
 func (p *positioningTestSuite) TestVolumePositionOffsetWriteEnlargesVolume(c *C) {
 	var gadgetYamlStructure = `
 volumes:
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package gadget
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// TemplateVars holds the values available to filesystem content marked as a
+// template, the content is rendered using Go text/template syntax, eg.
+// {{.Serial}}.
+type TemplateVars struct {
+	// Model is the name of the device model
+	Model string
+	// Serial is the serial of the device, empty when the device is not
+	// registered yet
+	Serial string
+	// Architecture is the architecture of the device model
+	Architecture string
+}
+
+// validTemplateValue matches the values that are safe to substitute into
+// configuration files, such as the boot config, without escaping.
+var validTemplateValue = regexp.MustCompile(`^[a-zA-Z0-9._-]*$`)
+
+// values returns the variables that are set, after checking that they are
+// safe to use.
+func (v *TemplateVars) values() (map[string]string, error) {
+	values := make(map[string]string, 3)
+	for _, kv := range []struct{ name, value string }{
+		{"Model", v.Model},
+		{"Serial", v.Serial},
+		{"Architecture", v.Architecture},
+	} {
+		if !validTemplateValue.MatchString(kv.value) {
+			return nil, fmt.Errorf("invalid %s %q, expected only letters, digits, '.', '_' or '-'", strings.ToLower(kv.name), kv.value)
+		}
+		if kv.value != "" {
+			values[kv.name] = kv.value
+		}
+	}
+	return values, nil
+}
+
+// renderTemplate renders the template file at src using provided variables
+// and writes the result to dst. Referencing an unknown variable, or one that
+// is not set, eg. the serial of a device that is not registered yet, is an
+// error.
+func renderTemplate(src, dst string, vars *TemplateVars) error {
+	values, err := vars.values()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("cannot read template: %v", err)
+	}
+	tmpl, err := template.New(filepath.Base(src)).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("cannot parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return fmt.Errorf("cannot render template: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("cannot create prefix directory: %v", err)
+	}
+	return osutil.AtomicWriteFile(dst, buf.Bytes(), 0644, 0)
+}
+
+// RenderTemplates renders the content of the structure that is marked as a
+// template using provided variables. The rendered files are placed inside
+// the work directory and are used in place of the original sources by
+// subsequent calls to Write(), and for updaters, Backup(), Update() and
+// Rollback().
+func (m *MountedFilesystemWriter) RenderTemplates(vars *TemplateVars, workDir string) error {
+	for _, c := range m.ps.Content {
+		if !c.Template {
+			continue
+		}
+		if vars == nil {
+			return fmt.Errorf("cannot render template %s: no template variables provided", c)
+		}
+		src := filepath.Join(m.contentDir, c.Source)
+		if osutil.IsDirectory(src) || strings.HasSuffix(c.Source, "/") {
+			return fmt.Errorf("cannot render template %s: source is a directory", c)
+		}
+		dst := filepath.Join(workDir, fmt.Sprintf("struct-%v", m.ps.Index), c.Source)
+		if err := renderTemplate(src, dst, vars); err != nil {
+			return fmt.Errorf("cannot render template %s: %v", c, err)
+		}
+		if m.rendered == nil {
+			m.rendered = make(map[string]string)
+		}
+		m.rendered[c.Source] = dst
+	}
+	return nil
+}
+
+// sourcePath returns the location of given content source, for templates
+// this is the rendered copy.
+func (m *MountedFilesystemWriter) sourcePath(content *VolumeContent) (string, error) {
+	if content.Template {
+		rendered, ok := m.rendered[content.Source]
+		if !ok {
+			return "", fmt.Errorf("internal error: template %s was not rendered", content)
+		}
+		return rendered, nil
+	}
+	realSource := filepath.Join(m.contentDir, content.Source)
+	// filepath trims the trailing /, restore if needed
+	if strings.HasSuffix(content.Source, "/") {
+		realSource += "/"
+	}
+	return realSource, nil
+}
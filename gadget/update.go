@@ -21,6 +21,7 @@ package gadget
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 
 	"github.com/snapcore/snapd/logger"
 )
@@ -43,6 +44,9 @@ type GadgetData struct {
 	Info *Info
 	// RootDir is the root directory of gadget snap data
 	RootDir string
+	// TemplateVars holds the values used for rendering template content,
+	// may be nil when there is no template content
+	TemplateVars *TemplateVars
 }
 
 // Update applies the gadget update given the gadget information and data from
@@ -57,45 +61,105 @@ type GadgetData struct {
 // rollback directory. Should the apply step fail, the modified data is
 // recovered.
 func Update(old, new GadgetData, rollbackDirPath string) error {
-	oldVol, newVol, err := resolveVolume(old.Info, new.Info)
+	updates, violations, err := checkUpdate(old, new)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 && violations[0].Structure == nil {
+		return violations[0]
+	}
+	if len(updates) == 0 {
+		// nothing to update
+		return ErrNoUpdate
+	}
+	if len(violations) > 0 {
+		return violations[0]
+	}
+
+	return applyUpdates(new, updates, rollbackDirPath)
+}
+
+// Rollback restores the data modified by a successful Update from the old
+// to the new gadget, using the backup Update kept in the rollback
+// directory. It errors out if the backup is not there anymore. When
+// Update had nothing to update, a special error ErrNoUpdate is returned.
+func Rollback(old, new GadgetData, rollbackDirPath string) error {
+	updates, violations, err := checkUpdate(old, new)
 	if err != nil {
 		return err
 	}
+	if len(violations) > 0 {
+		return violations[0]
+	}
+	if len(updates) == 0 {
+		return ErrNoUpdate
+	}
+
+	updaters, err := updatersFor(new, updates, rollbackDirPath)
+	if err != nil {
+		return err
+	}
+
+	var rollbackErr error
+	for i := len(updaters) - 1; i >= 0; i-- {
+		if err := updaters[i].Rollback(); err != nil {
+			logger.Noticef("cannot rollback volume structure %v update: %v", updates[i].to, err)
+			if rollbackErr == nil {
+				rollbackErr = fmt.Errorf("cannot rollback volume structure %v: %v", updates[i].to, err)
+			}
+		}
+	}
+	return rollbackErr
+}
+
+// UpdateViolations returns all the violations of the gadget update policy
+// that would block updating from the old to the new gadget, so that they
+// can be addressed in one pass. Like Update, it only considers the
+// structures with a higher value of Edition field in the new gadget
+// definition. An error is returned when the gadgets cannot be checked
+// at all.
+func UpdateViolations(old, new GadgetData) ([]*UpdateViolation, error) {
+	_, violations, err := checkUpdate(old, new)
+	return violations, err
+}
+
+// checkUpdate lays out the old and new volumes and checks them, and the
+// structures to update, against the update policy.
+func checkUpdate(old, new GadgetData) (updates []updatePair, violations []*UpdateViolation, err error) {
+	oldVol, newVol, err := resolveVolume(old.Info, new.Info)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// layout old
 	pOld, err := PositionVolume(old.RootDir, oldVol, defaultConstraints)
 	if err != nil {
-		return fmt.Errorf("cannot lay out the old volume: %v", err)
+		return nil, nil, fmt.Errorf("cannot lay out the old volume: %v", err)
 	}
 
 	// layout new
 	pNew, err := PositionVolume(new.RootDir, newVol, defaultConstraints)
 	if err != nil {
-		return fmt.Errorf("cannot lay out the new volume: %v", err)
+		return nil, nil, fmt.Errorf("cannot lay out the new volume: %v", err)
 	}
 
-	if err := canUpdateVolume(pOld, pNew); err != nil {
-		return fmt.Errorf("cannot apply update to volume: %v", err)
+	if violations := volumeUpdateViolations(pOld, pNew); len(violations) > 0 {
+		// structures cannot be matched up
+		return nil, violations, nil
 	}
 
 	// now we know which structure is which, find which ones need an update
-	updates, err := resolveUpdate(pOld, pNew)
+	updates, err = resolveUpdate(pOld, pNew)
 	if err != nil {
-		return err
-	}
-	if len(updates) == 0 {
-		// nothing to update
-		return ErrNoUpdate
+		return nil, nil, err
 	}
 
 	// can update old layout to new layout
 	for _, update := range updates {
-		if err := canUpdateStructure(update.from, update.to); err != nil {
-			return fmt.Errorf("cannot update volume structure %v: %v", update.to, err)
-		}
+		violations = append(violations, structureUpdateViolations(update.from, update.to)...)
 	}
 
-	return applyUpdates(new, updates, rollbackDirPath)
+	return updates, violations, nil
 }
 
 func resolveVolume(old *Info, new *Info) (oldVol, newVol *Volume, err error) {
@@ -145,61 +209,166 @@ func isLegacyMBRTransition(from *PositionedStructure, to *PositionedStructure) b
 	return from.Type == MBR && to.EffectiveRole() == MBR
 }
 
-func canUpdateStructure(from *PositionedStructure, to *PositionedStructure) error {
-	if from.Size != to.Size {
-		return fmt.Errorf("cannot change structure size from %v to %v", from.Size, to.Size)
-	}
-	if !isSameOffset(from.Offset, to.Offset) {
-		return fmt.Errorf("cannot change structure offset from %v to %v", from.Offset, to.Offset)
-	}
-	if from.StartOffset != to.StartOffset {
-		return fmt.Errorf("cannot change structure start offset from %v to %v", from.StartOffset, to.StartOffset)
-	}
+// structureUpdateRule describes a single constraint that an update of a
+// volume structure must satisfy.
+type structureUpdateRule struct {
+	// name identifies the rule
+	name string
+	// check returns an error describing the violation, if any
+	check func(from, to *PositionedStructure) error
+}
+
+// volumeUpdateRule describes a single constraint that an update of a
+// volume must satisfy.
+type volumeUpdateRule struct {
+	name  string
+	check func(from, to *PositionedVolume) error
+}
+
+// structureUpdateRules is the policy for updating a volume structure, all
+// rules are checked.
+var structureUpdateRules = []structureUpdateRule{
+	{"size", func(from, to *PositionedStructure) error {
+		if from.Size != to.Size {
+			return fmt.Errorf("cannot change structure size from %v to %v", from.Size, to.Size)
+		}
+		return nil
+	}},
+	{"offset", func(from, to *PositionedStructure) error {
+		if !isSameOffset(from.Offset, to.Offset) {
+			return fmt.Errorf("cannot change structure offset from %v to %v", from.Offset, to.Offset)
+		}
+		return nil
+	}},
+	{"start-offset", func(from, to *PositionedStructure) error {
+		if from.StartOffset != to.StartOffset {
+			return fmt.Errorf("cannot change structure start offset from %v to %v", from.StartOffset, to.StartOffset)
+		}
+		return nil
+	}},
 	// TODO: should this limitation be lifted?
-	if !isSameRelativeOffset(from.OffsetWrite, to.OffsetWrite) {
-		return fmt.Errorf("cannot change structure offset-write from %v to %v", from.OffsetWrite, to.OffsetWrite)
-	}
-	if from.EffectiveRole() != to.EffectiveRole() {
-		return fmt.Errorf("cannot change structure role from %q to %q", from.EffectiveRole(), to.EffectiveRole())
-	}
-	if from.Type != to.Type {
-		if !isLegacyMBRTransition(from, to) {
+	{"offset-write", func(from, to *PositionedStructure) error {
+		if !isSameRelativeOffset(from.OffsetWrite, to.OffsetWrite) {
+			return fmt.Errorf("cannot change structure offset-write from %v to %v", from.OffsetWrite, to.OffsetWrite)
+		}
+		return nil
+	}},
+	{"role", func(from, to *PositionedStructure) error {
+		if from.EffectiveRole() != to.EffectiveRole() {
+			return fmt.Errorf("cannot change structure role from %q to %q", from.EffectiveRole(), to.EffectiveRole())
+		}
+		return nil
+	}},
+	{"type", func(from, to *PositionedStructure) error {
+		if from.Type != to.Type && !isLegacyMBRTransition(from, to) {
 			return fmt.Errorf("cannot change structure type from %q to %q", from.Type, to.Type)
 		}
-	}
-	if from.ID != to.ID {
-		return fmt.Errorf("cannot change structure ID from %q to %q", from.ID, to.ID)
-	}
-	if !to.IsBare() {
-		if from.IsBare() {
+		return nil
+	}},
+	{"id", func(from, to *PositionedStructure) error {
+		if from.ID != to.ID {
+			return fmt.Errorf("cannot change structure ID from %q to %q", from.ID, to.ID)
+		}
+		return nil
+	}},
+	{"bare", func(from, to *PositionedStructure) error {
+		if !to.IsBare() && from.IsBare() {
 			return fmt.Errorf("cannot change a bare structure to filesystem one")
 		}
-		if from.Filesystem != to.Filesystem {
+		if to.IsBare() && !from.IsBare() {
+			return fmt.Errorf("cannot change a filesystem structure to a bare one")
+		}
+		return nil
+	}},
+	// the filesystem rules only apply when both structures have one
+	{"filesystem", func(from, to *PositionedStructure) error {
+		if !from.IsBare() && !to.IsBare() && from.Filesystem != to.Filesystem {
 			return fmt.Errorf("cannot change filesystem from %q to %q",
 				from.Filesystem, to.Filesystem)
 		}
-		if from.EffectiveFilesystemLabel() != to.EffectiveFilesystemLabel() {
+		return nil
+	}},
+	{"filesystem-label", func(from, to *PositionedStructure) error {
+		if !from.IsBare() && !to.IsBare() && from.EffectiveFilesystemLabel() != to.EffectiveFilesystemLabel() {
 			return fmt.Errorf("cannot change filesystem label from %q to %q",
 				from.Label, to.Label)
 		}
-	} else {
-		if !from.IsBare() {
-			return fmt.Errorf("cannot change a filesystem structure to a bare one")
+		return nil
+	}},
+}
+
+// volumeUpdateRules is the policy for updating a volume, the structures
+// are only checked when all the volume rules are satisfied.
+var volumeUpdateRules = []volumeUpdateRule{
+	{"id", func(from, to *PositionedVolume) error {
+		if from.ID != to.ID {
+			return fmt.Errorf("cannot change volume ID from %q to %q", from.ID, to.ID)
+		}
+		return nil
+	}},
+	{"schema", func(from, to *PositionedVolume) error {
+		if from.EffectiveSchema() != to.EffectiveSchema() {
+			return fmt.Errorf("cannot change volume schema from %q to %q", from.EffectiveSchema(), to.EffectiveSchema())
+		}
+		return nil
+	}},
+	{"structure-count", func(from, to *PositionedVolume) error {
+		if len(from.PositionedStructure) != len(to.PositionedStructure) {
+			return fmt.Errorf("cannot change the number of structures within volume from %v to %v", len(from.PositionedStructure), len(to.PositionedStructure))
+		}
+		return nil
+	}},
+}
+
+// UpdateViolation describes a rule of the gadget update policy that is
+// violated by an update.
+type UpdateViolation struct {
+	// Structure is the updated structure the violation applies to, or
+	// nil when the violation concerns the whole volume.
+	Structure *PositionedStructure
+	// Rule is the name of the violated rule.
+	Rule string
+	// Err describes the violation.
+	Err error
+}
+
+func (v *UpdateViolation) Error() string {
+	if v.Structure == nil {
+		return fmt.Sprintf("cannot apply update to volume: %v", v.Err)
+	}
+	return fmt.Sprintf("cannot update volume structure %v: %v", v.Structure, v.Err)
+}
+
+func structureUpdateViolations(from, to *PositionedStructure) []*UpdateViolation {
+	var violations []*UpdateViolation
+	for _, rule := range structureUpdateRules {
+		if err := rule.check(from, to); err != nil {
+			violations = append(violations, &UpdateViolation{Structure: to, Rule: rule.name, Err: err})
 		}
 	}
+	return violations
+}
+
+func volumeUpdateViolations(from, to *PositionedVolume) []*UpdateViolation {
+	var violations []*UpdateViolation
+	for _, rule := range volumeUpdateRules {
+		if err := rule.check(from, to); err != nil {
+			violations = append(violations, &UpdateViolation{Rule: rule.name, Err: err})
+		}
+	}
+	return violations
+}
 
+func canUpdateStructure(from *PositionedStructure, to *PositionedStructure) error {
+	if violations := structureUpdateViolations(from, to); len(violations) > 0 {
+		return violations[0].Err
+	}
 	return nil
 }
 
 func canUpdateVolume(from *PositionedVolume, to *PositionedVolume) error {
-	if from.ID != to.ID {
-		return fmt.Errorf("cannot change volume ID from %q to %q", from.ID, to.ID)
-	}
-	if from.EffectiveSchema() != to.EffectiveSchema() {
-		return fmt.Errorf("cannot change volume schema from %q to %q", from.EffectiveSchema(), to.EffectiveSchema())
-	}
-	if len(from.PositionedStructure) != len(to.PositionedStructure) {
-		return fmt.Errorf("cannot change the number of structures within volume from %v to %v", len(from.PositionedStructure), len(to.PositionedStructure))
+	if violations := volumeUpdateViolations(from, to); len(violations) > 0 {
+		return violations[0].Err
 	}
 	return nil
 }
@@ -239,16 +408,24 @@ type Updater interface {
 	Rollback() error
 }
 
-func applyUpdates(new GadgetData, updates []updatePair, rollbackDir string) error {
+func updatersFor(new GadgetData, updates []updatePair, rollbackDir string) ([]Updater, error) {
 	updaters := make([]Updater, len(updates))
 
 	for i, one := range updates {
-		up, err := updaterForStructure(one.to, new.RootDir, rollbackDir)
+		up, err := updaterForStructure(one.to, new.RootDir, rollbackDir, new.TemplateVars)
 		if err != nil {
-			return fmt.Errorf("cannot prepare update for volume structure %v: %v", one.to, err)
+			return nil, fmt.Errorf("cannot prepare update for volume structure %v: %v", one.to, err)
 		}
 		updaters[i] = up
 	}
+	return updaters, nil
+}
+
+func applyUpdates(new GadgetData, updates []updatePair, rollbackDir string) error {
+	updaters, err := updatersFor(new, updates, rollbackDir)
+	if err != nil {
+		return err
+	}
 
 	for i, one := range updaters {
 		if err := one.Backup(); err != nil {
@@ -286,13 +463,17 @@ func applyUpdates(new GadgetData, updates []updatePair, rollbackDir string) erro
 
 var updaterForStructure = updaterForStructureImpl
 
-func updaterForStructureImpl(ps *PositionedStructure, newRootDir, rollbackDir string) (Updater, error) {
-	var updater Updater
-	var err error
+func updaterForStructureImpl(ps *PositionedStructure, newRootDir, rollbackDir string, vars *TemplateVars) (Updater, error) {
 	if ps.IsBare() {
-		updater, err = NewRawStructureUpdater(newRootDir, ps, rollbackDir, FindDeviceForStructureWithFallback)
-	} else {
-		updater, err = NewMountedFilesystemUpdater(newRootDir, ps, rollbackDir, FindMountPointForStructure)
+		return NewRawStructureUpdater(newRootDir, ps, rollbackDir, FindDeviceForStructureWithFallback)
+	}
+	updater, err := NewMountedFilesystemUpdater(newRootDir, ps, rollbackDir, FindMountPointForStructure)
+	if err != nil {
+		return nil, err
+	}
+	// rendered templates are kept along with the rollback data
+	if err := updater.RenderTemplates(vars, filepath.Join(rollbackDir, "templates")); err != nil {
+		return nil, err
 	}
-	return updater, err
+	return updater, nil
 }
@@ -671,13 +671,15 @@ func (u *updateTestSuite) TestUpdateApplyHappy(c *C) {
 	// update two structs
 	newData.Info.Volumes["foo"].Structure[0].Update.Edition = 1
 	newData.Info.Volumes["foo"].Structure[1].Update.Edition = 1
+	newData.TemplateVars = &gadget.TemplateVars{Model: "pc", Serial: "1234"}
 
 	updaterForStructureCalls := 0
 	updateCalls := make(map[string]bool)
 	backupCalls := make(map[string]bool)
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		c.Assert(psRootDir, Equals, newData.RootDir)
 		c.Assert(psRollbackDir, Equals, rollbackDir)
+		c.Assert(vars, Equals, newData.TemplateVars)
 
 		switch updaterForStructureCalls {
 		case 0:
@@ -736,6 +738,64 @@ func (u *updateTestSuite) TestUpdateApplyHappy(c *C) {
 	c.Assert(updaterForStructureCalls, Equals, 2)
 }
 
+func (u *updateTestSuite) TestRollbackHappy(c *C) {
+	oldData, newData, rollbackDir := updateDataSet(c)
+	// two structs were updated
+	newData.Info.Volumes["foo"].Structure[0].Update.Edition = 1
+	newData.Info.Volumes["foo"].Structure[1].Update.Edition = 1
+
+	var rollbackCalls []string
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
+		c.Assert(psRootDir, Equals, newData.RootDir)
+		c.Assert(psRollbackDir, Equals, rollbackDir)
+		return &mockUpdater{
+			backupCb: func() error {
+				c.Fatalf("unexpected call")
+				return errors.New("not called")
+			},
+			updateCb: func() error {
+				c.Fatalf("unexpected call")
+				return errors.New("not called")
+			},
+			rollbackCb: func() error {
+				rollbackCalls = append(rollbackCalls, ps.Name)
+				return nil
+			},
+		}, nil
+	})
+	defer restore()
+
+	err := gadget.Rollback(oldData, newData, rollbackDir)
+	c.Assert(err, IsNil)
+	// in the reverse order of the update
+	c.Check(rollbackCalls, DeepEquals, []string{"second", "first"})
+}
+
+func (u *updateTestSuite) TestRollbackErrors(c *C) {
+	oldData, newData, rollbackDir := updateDataSet(c)
+
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
+		return &mockUpdater{
+			rollbackCb: func() error {
+				if ps.Name == "first" {
+					return errors.New("failed")
+				}
+				return nil
+			},
+		}, nil
+	})
+	defer restore()
+
+	// nothing was updated
+	err := gadget.Rollback(oldData, newData, rollbackDir)
+	c.Assert(err, Equals, gadget.ErrNoUpdate)
+
+	newData.Info.Volumes["foo"].Structure[0].Update.Edition = 1
+	newData.Info.Volumes["foo"].Structure[1].Update.Edition = 1
+	err = gadget.Rollback(oldData, newData, rollbackDir)
+	c.Assert(err, ErrorMatches, `cannot rollback volume structure #0 \("first"\): failed`)
+}
+
 func (u *updateTestSuite) TestUpdateApplyOnlyWhenNeeded(c *C) {
 	oldData, newData, rollbackDir := updateDataSet(c)
 	// first structure is updated
@@ -749,7 +809,7 @@ func (u *updateTestSuite) TestUpdateApplyOnlyWhenNeeded(c *C) {
 	newData.Info.Volumes["foo"].Structure[2].Update.Edition = 3
 
 	updaterForStructureCalls := 0
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		c.Assert(psRootDir, Equals, newData.RootDir)
 		c.Assert(psRollbackDir, Equals, rollbackDir)
 
@@ -924,6 +984,70 @@ func (u *updateTestSuite) TestUpdateApplyErrorIllegalStructureUpdate(c *C) {
 	c.Assert(err, ErrorMatches, `cannot update volume structure #0 \("foo"\): cannot change a bare structure to filesystem one`)
 }
 
+func (u *updateTestSuite) TestUpdateViolationsAll(c *C) {
+	oldStruct := gadget.VolumeStructure{
+		Name: "foo",
+		Type: "0C",
+		Size: 5 * gadget.SizeMiB,
+		Content: []gadget.VolumeContent{
+			{Image: "first.img"},
+		},
+	}
+	newStruct := gadget.VolumeStructure{
+		Name:       "foo",
+		Type:       "83",
+		Role:       gadget.SystemData,
+		Filesystem: "ext4",
+		Size:       10 * gadget.SizeMiB,
+		Content: []gadget.VolumeContent{
+			{Source: "/", Target: "/"},
+		},
+		Update: gadget.VolumeUpdate{Edition: 5},
+	}
+	oldData := gadget.GadgetData{Info: &gadget.Info{
+		Volumes: map[string]gadget.Volume{
+			"foo": {Bootloader: "grub", Schema: gadget.MBR, Structure: []gadget.VolumeStructure{oldStruct}},
+		},
+	}, RootDir: c.MkDir()}
+	newData := gadget.GadgetData{Info: &gadget.Info{
+		Volumes: map[string]gadget.Volume{
+			"foo": {Bootloader: "grub", Schema: gadget.MBR, Structure: []gadget.VolumeStructure{newStruct}},
+		},
+	}, RootDir: c.MkDir()}
+
+	makeSizedFile(c, filepath.Join(oldData.RootDir, "first.img"), gadget.SizeMiB, nil)
+
+	violations, err := gadget.UpdateViolations(oldData, newData)
+	c.Assert(err, IsNil)
+	var rules []string
+	for _, v := range violations {
+		c.Check(v.Structure, NotNil)
+		rules = append(rules, v.Rule)
+	}
+	c.Check(rules, DeepEquals, []string{"size", "role", "type", "bare"})
+	c.Check(violations[0], ErrorMatches, `cannot update volume structure #0 \("foo"\): cannot change structure size from 5242880 to 10485760`)
+	c.Check(violations[3], ErrorMatches, `cannot update volume structure #0 \("foo"\): cannot change a bare structure to filesystem one`)
+
+	// Update reports the first one
+	err = gadget.Update(oldData, newData, c.MkDir())
+	c.Assert(err, ErrorMatches, `cannot update volume structure #0 \("foo"\): cannot change structure size .*`)
+
+	// volume level violations prevent checking the structures
+	newData.Info.Volumes["foo"] = gadget.Volume{Bootloader: "grub", Schema: gadget.GPT, Structure: []gadget.VolumeStructure{newStruct, newStruct}}
+	violations, err = gadget.UpdateViolations(oldData, newData)
+	c.Assert(err, IsNil)
+	c.Assert(violations, HasLen, 2)
+	c.Check(violations[0].Structure, IsNil)
+	c.Check(violations[0].Rule, Equals, "schema")
+	c.Check(violations[1].Rule, Equals, "structure-count")
+	c.Check(violations[1], ErrorMatches, `cannot apply update to volume: cannot change the number of structures within volume from 1 to 2`)
+
+	// no violations
+	violations, err = gadget.UpdateViolations(oldData, oldData)
+	c.Assert(err, IsNil)
+	c.Check(violations, HasLen, 0)
+}
+
 func (u *updateTestSuite) TestUpdateApplyErrorDifferentVolume(c *C) {
 	// prepare the stage
 	bareStruct := gadget.VolumeStructure{
@@ -953,7 +1077,7 @@ func (u *updateTestSuite) TestUpdateApplyErrorDifferentVolume(c *C) {
 	newData := gadget.GadgetData{Info: newInfo, RootDir: c.MkDir()}
 	rollbackDir := c.MkDir()
 
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		c.Fatalf("unexpected call")
 		return &mockUpdater{}, nil
 	})
@@ -997,7 +1121,7 @@ func (u *updateTestSuite) TestUpdateApplyUpdatesAreOptIn(c *C) {
 
 	rollbackDir := c.MkDir()
 
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		c.Fatalf("unexpected call")
 		return &mockUpdater{}, nil
 	})
@@ -1015,7 +1139,7 @@ func (u *updateTestSuite) TestUpdateApplyBackupFails(c *C) {
 	newData.Info.Volumes["foo"].Structure[2].Update.Edition = 3
 
 	updaterForStructureCalls := 0
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		updater := &mockUpdater{
 			updateCb: func() error {
 				c.Fatalf("unexpected update call")
@@ -1053,7 +1177,7 @@ func (u *updateTestSuite) TestUpdateApplyUpdateFailsThenRollback(c *C) {
 	backupCalls := make(map[string]bool)
 	rollbackCalls := make(map[string]bool)
 	updaterForStructureCalls := 0
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		updater := &mockUpdater{
 			backupCb: func() error {
 				backupCalls[ps.Name] = true
@@ -1116,7 +1240,7 @@ func (u *updateTestSuite) TestUpdateApplyUpdateErrorRollbackFail(c *C) {
 	backupCalls := make(map[string]bool)
 	rollbackCalls := make(map[string]bool)
 	updaterForStructureCalls := 0
-	restore = gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore = gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		updater := &mockUpdater{
 			backupCb: func() error {
 				backupCalls[ps.Name] = true
@@ -1184,7 +1308,7 @@ func (u *updateTestSuite) TestUpdateApplyBadUpdater(c *C) {
 	newData.Info.Volumes["foo"].Structure[1].Update.Edition = 2
 	newData.Info.Volumes["foo"].Structure[2].Update.Edition = 3
 
-	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string) (gadget.Updater, error) {
+	restore := gadget.MockUpdaterForStructure(func(ps *gadget.PositionedStructure, psRootDir, psRollbackDir string, vars *gadget.TemplateVars) (gadget.Updater, error) {
 		return nil, errors.New("bad updater for structure")
 	})
 	defer restore()
@@ -1205,7 +1329,7 @@ func (u *updateTestSuite) TestUpdaterForStructure(c *C) {
 		},
 		StartOffset: 1 * gadget.SizeMiB,
 	}
-	updater, err := gadget.UpdaterForStructure(psBare, rootDir, rollbackDir)
+	updater, err := gadget.UpdaterForStructure(psBare, rootDir, rollbackDir, nil)
 	c.Assert(err, IsNil)
 	c.Assert(updater, FitsTypeOf, &gadget.RawStructureUpdater{})
 
@@ -1216,16 +1340,30 @@ func (u *updateTestSuite) TestUpdaterForStructure(c *C) {
 		},
 		StartOffset: 1 * gadget.SizeMiB,
 	}
-	updater, err = gadget.UpdaterForStructure(psFs, rootDir, rollbackDir)
+	updater, err = gadget.UpdaterForStructure(psFs, rootDir, rollbackDir, nil)
 	c.Assert(err, IsNil)
 	c.Assert(updater, FitsTypeOf, &gadget.MountedFilesystemUpdater{})
 
 	// trigger errors
-	updater, err = gadget.UpdaterForStructure(psBare, rootDir, "")
+	updater, err = gadget.UpdaterForStructure(psBare, rootDir, "", nil)
 	c.Assert(err, ErrorMatches, "internal error: backup directory cannot be unset")
 	c.Assert(updater, IsNil)
 
-	updater, err = gadget.UpdaterForStructure(psFs, "", rollbackDir)
+	updater, err = gadget.UpdaterForStructure(psFs, "", rollbackDir, nil)
 	c.Assert(err, ErrorMatches, "internal error: gadget content directory cannot be unset")
 	c.Assert(updater, IsNil)
+
+	// templates are rendered into the rollback directory
+	makeSizedFile(c, filepath.Join(rootDir, "serial.txt"), 0, []byte("{{.Serial}}"))
+	psFs.Content = []gadget.VolumeContent{
+		{Source: "serial.txt", Target: "/", Template: true},
+	}
+	updater, err = gadget.UpdaterForStructure(psFs, rootDir, rollbackDir, &gadget.TemplateVars{Serial: "1234"})
+	c.Assert(err, IsNil)
+	c.Assert(updater, FitsTypeOf, &gadget.MountedFilesystemUpdater{})
+	c.Check(filepath.Join(rollbackDir, "templates/struct-0/serial.txt"), testutil.FileEquals, "1234")
+
+	updater, err = gadget.UpdaterForStructure(psFs, rootDir, rollbackDir, nil)
+	c.Assert(err, ErrorMatches, "cannot render template source:serial.txt: no template variables provided")
+	c.Assert(updater, IsNil)
 }
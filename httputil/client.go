@@ -31,6 +31,18 @@ type ClientOptions struct {
 	TLSConfig  *tls.Config
 	MayLogBody bool
 	Proxy      func(*http.Request) (*url.URL, error)
+
+	// MaxIdleConnsPerHost is how many idle connections to each host
+	// are kept for reuse, the http package default is used if zero
+	MaxIdleConnsPerHost int
+	// AttemptHTTP2 makes the client try to use HTTP/2, which lets
+	// concurrent requests share a single connection
+	AttemptHTTP2 bool
+
+	// Transport, if set, is used instead of a new transport, for
+	// example to fake the network in tests; TLSConfig, Proxy,
+	// MaxIdleConnsPerHost and AttemptHTTP2 are ignored then
+	Transport http.RoundTripper
 }
 
 // NewHTTPCLient returns a new http.Client with a LoggedTransport, a
@@ -40,10 +52,20 @@ func NewHTTPClient(opts *ClientOptions) *http.Client {
 		opts = &ClientOptions{}
 	}
 
-	transport := newDefaultTransport()
-	transport.TLSClientConfig = opts.TLSConfig
-	if opts.Proxy != nil {
-		transport.Proxy = opts.Proxy
+	transport := opts.Transport
+	if transport == nil {
+		tr := newDefaultTransport()
+		tr.TLSClientConfig = opts.TLSConfig
+		if opts.Proxy != nil {
+			tr.Proxy = opts.Proxy
+		}
+		if opts.MaxIdleConnsPerHost > 0 {
+			tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		}
+		if opts.AttemptHTTP2 {
+			attemptHTTP2(tr)
+		}
+		transport = tr
 	}
 
 	return &http.Client{
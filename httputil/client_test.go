@@ -60,3 +60,30 @@ func (s *clientSuite) TestClientOptionsWithProxy(c *check.C) {
 	c.Check(err, check.IsNil)
 	c.Check(url.String(), check.Equals, "http://some-proxy:3128")
 }
+
+func (s *clientSuite) TestClientOptionsMaxIdleConnsPerHost(c *check.C) {
+	cli := httputil.NewHTTPClient(&httputil.ClientOptions{
+		MaxIdleConnsPerHost: 8,
+	})
+	c.Assert(cli, check.NotNil)
+
+	trans := cli.Transport.(*httputil.LoggedTransport).Transport.(*http.Transport)
+	c.Check(trans.MaxIdleConnsPerHost, check.Equals, 8)
+}
+
+func (s *clientSuite) TestClientOptionsTransport(c *check.C) {
+	fake := &fakeTransport{
+		rsp: &http.Response{StatusCode: 418, Body: http.NoBody},
+	}
+	cli := httputil.NewHTTPClient(&httputil.ClientOptions{
+		Transport: fake,
+	})
+	c.Assert(cli, check.NotNil)
+	c.Check(cli.Transport.(*httputil.LoggedTransport).Transport, check.Equals, fake)
+
+	rsp, err := cli.Get("http://example.com")
+	c.Assert(err, check.IsNil)
+	c.Check(rsp.StatusCode, check.Equals, 418)
+	c.Assert(fake.req, check.NotNil)
+	c.Check(fake.req.URL.String(), check.Equals, "http://example.com")
+}
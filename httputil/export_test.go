@@ -19,6 +19,13 @@
 
 package httputil
 
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
 var (
 	GetFlags              = (*LoggedTransport).getFlags
 	StripUnsafeRunes      = stripUnsafeRunes
@@ -32,3 +39,47 @@ func MockUserAgent(mock string) (restore func()) {
 		userAgent = old
 	}
 }
+
+func MockPACLookupHost(f func(host string) ([]string, error)) (restore func()) {
+	old := pacLookupHost
+	pacLookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return f(host)
+	}
+	return func() {
+		pacLookupHost = old
+	}
+}
+
+func MockPACTimeout(timeout time.Duration) (restore func()) {
+	old := pacTimeout
+	pacTimeout = timeout
+	return func() {
+		pacTimeout = old
+	}
+}
+
+func MockPACInterfaceAddrs(f func() ([]net.Addr, error)) (restore func()) {
+	old := pacInterfaceAddrs
+	pacInterfaceAddrs = f
+	return func() {
+		pacInterfaceAddrs = old
+	}
+}
+
+func MockPACClient(cli *http.Client) (restore func()) {
+	old := pacClient
+	pacClient = cli
+	return func() {
+		pacClient = old
+	}
+}
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+var UseProxy = useProxy
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NetplanDirs returns the directories of the netplan configuration
+// under the given root directory, in the order netplan reads them.
+func NetplanDirs(rootDir string) []string {
+	return []string{
+		filepath.Join(rootDir, "/lib/netplan"),
+		filepath.Join(rootDir, "/etc/netplan"),
+		filepath.Join(rootDir, "/run/netplan"),
+	}
+}
+
+// netplanFiles returns the netplan configuration files in the given
+// directories, in the order netplan merges them: by name, a file
+// shadowing the ones with the same name in the earlier directories.
+func netplanFiles(dirs []string) []string {
+	byName := make(map[string]string)
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		for _, path := range matches {
+			byName[filepath.Base(path)] = path
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = byName[name]
+	}
+	return files
+}
+
+// netplanSignature returns a string that changes whenever the given
+// netplan configuration files do.
+func netplanSignature(files []string) string {
+	var sig bytes.Buffer
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sig, "%s:%d:%d\n", path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return sig.String()
+}
+
+// netplanPACURL returns the proxy auto-config URL of the netplan
+// configuration. Netplan has no proxy settings of its own, the ones of
+// NetworkManager are set with passthrough settings of the devices:
+//
+//	network:
+//	  ethernets:
+//	    eth0:
+//	      networkmanager:
+//	        passthrough:
+//	          proxy.method: auto
+//	          proxy.pac-url: https://example.com/proxy.pac
+//
+// With several such devices, the URL of the first one by device type and
+// name is used, later files overriding the settings of earlier ones.
+func netplanPACURL(files []string) (string, error) {
+	devices := make(map[string]map[string]map[string]interface{})
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		var config struct {
+			Network map[string]interface{} `yaml:"network"`
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("cannot parse %s: %v", path, err)
+		}
+		for kind, v := range config.Network {
+			kindDevices, ok := v.(map[interface{}]interface{})
+			if !ok {
+				// e.g. version or renderer
+				continue
+			}
+			for name, dev := range kindDevices {
+				passthrough := netplanPassthrough(dev)
+				if passthrough == nil {
+					continue
+				}
+				if devices[kind] == nil {
+					devices[kind] = make(map[string]map[string]interface{})
+				}
+				key := fmt.Sprint(name)
+				if devices[kind][key] == nil {
+					devices[kind][key] = make(map[string]interface{})
+				}
+				for k, v := range passthrough {
+					devices[kind][key][k] = v
+				}
+			}
+		}
+	}
+
+	kinds := make([]string, 0, len(devices))
+	for kind := range devices {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		names := make([]string, 0, len(devices[kind]))
+		for name := range devices[kind] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			passthrough := devices[kind][name]
+			if fmt.Sprint(passthrough["proxy.method"]) != "auto" {
+				continue
+			}
+			if pacURL, ok := passthrough["proxy.pac-url"].(string); ok && pacURL != "" {
+				return pacURL, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// netplanPassthrough returns the NetworkManager passthrough settings of
+// the given netplan device, if any.
+func netplanPassthrough(dev interface{}) map[string]interface{} {
+	get := func(v interface{}, key string) interface{} {
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		return m[key]
+	}
+	m, ok := get(get(dev, "networkmanager"), "passthrough").(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	passthrough := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		passthrough[fmt.Sprint(k)] = v
+	}
+	return passthrough
+}
@@ -0,0 +1,1220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PAC is a parsed proxy auto-config file.
+//
+// Only the subset of JavaScript commonly found in PAC files is
+// supported: function declarations, var declarations and assignments of
+// plain variables, if/else and return statements, and expressions made
+// of string, number and boolean literals, null, variables, function
+// calls, string concatenation and addition with +, the ==, ===, !=, !==,
+// <, <=, > and >= comparisons and the !, && and || logical operators.
+// Strings have the length property and the toLowerCase(), toUpperCase(),
+// indexOf(search), charAt(index) and substring(start[, end]) methods,
+// which count characters as Unicode code points. All the standard PAC
+// helpers except the date and time ones are available.
+//
+// Anything else, e.g. loops, arithmetic other than addition, object,
+// array or regexp literals, or other properties and methods, is
+// rejected when parsing or evaluating the file.
+//
+// None of the dependencies of snapd provides a JavaScript engine, and a
+// full one would be a much larger attack surface than what PAC files
+// need. As functions can still call each other many times over, each
+// evaluation is bounded by a number of function and method calls, by
+// the length of the strings it makes and the memory they take in total,
+// and by a timeout, which also covers the DNS lookups of the helpers.
+// PAC files are further only read from local files or fetched over
+// https, see SystemProxy.
+type PAC struct {
+	funcs   map[string]*pacFunc
+	globals map[string]pacValue
+}
+
+// pacValue is one of string, float64, bool or nil (for null and
+// undefined).
+type pacValue interface{}
+
+type pacFunc struct {
+	params []string
+	body   pacStmt
+}
+
+// pacEval is the state of one evaluation of a proxy auto-config.
+type pacEval struct {
+	ctx       context.Context
+	calls     int
+	allocated int
+	globals   map[string]pacValue
+}
+
+// step accounts for a function or method call of the evaluation.
+func (e *pacEval) step() error {
+	e.calls++
+	if e.calls > pacMaxCalls {
+		return fmt.Errorf("too many function calls")
+	}
+	if e.ctx.Err() != nil {
+		return fmt.Errorf("evaluation timed out")
+	}
+	return nil
+}
+
+// alloc accounts for a string of n bytes made by the evaluation.
+func (e *pacEval) alloc(n int) error {
+	if n > pacMaxStringLen {
+		return fmt.Errorf("string too long")
+	}
+	e.allocated += n
+	if e.allocated > pacMaxAlloc {
+		return fmt.Errorf("too much memory used")
+	}
+	if e.ctx.Err() != nil {
+		return fmt.Errorf("evaluation timed out")
+	}
+	return nil
+}
+
+// allocString accounts for the given string made by the evaluation.
+func (e *pacEval) allocString(str string) (pacValue, error) {
+	if err := e.alloc(len(str)); err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+type pacScope struct {
+	pac    *PAC
+	eval   *pacEval
+	vars   map[string]pacValue
+	parent *pacScope
+	depth  int
+}
+
+func (s *pacScope) lookup(name string) (pacValue, bool) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if v, ok := sc.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *pacScope) assign(name string, v pacValue) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			sc.vars[name] = v
+			return
+		}
+	}
+	s.vars[name] = v
+}
+
+type pacExpr func(*pacScope) (pacValue, error)
+
+// pacStmt runs a statement, reporting whether it returned.
+type pacStmt func(*pacScope) (v pacValue, returned bool, err error)
+
+const (
+	// maximum depth of nested function calls
+	pacMaxDepth = 64
+	// maximum number of function calls of an evaluation
+	pacMaxCalls = 10000
+	// maximum length in bytes of the strings made by an evaluation
+	pacMaxStringLen = 64 * 1024
+	// maximum total length in bytes of the strings made by an
+	// evaluation
+	pacMaxAlloc = 16 * 1024 * 1024
+)
+
+// maximum duration of an evaluation
+var pacTimeout = 5 * time.Second
+
+// newPACEval returns the global scope of a new evaluation of the given
+// proxy auto-config, with the given global variables, and a function to
+// call once it is done.
+func newPACEval(pac *PAC, globals map[string]pacValue) (*pacScope, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), pacTimeout)
+	eval := &pacEval{ctx: ctx, globals: globals}
+	return &pacScope{pac: pac, eval: eval, vars: globals}, cancel
+}
+
+// ParsePAC parses the given proxy auto-config file, and evaluates its
+// global statements.
+func ParsePAC(src []byte) (*PAC, error) {
+	toks, err := pacTokenize(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse proxy auto-config: %v", err)
+	}
+	p := &pacParser{toks: toks}
+	pac := &PAC{
+		funcs:   make(map[string]*pacFunc),
+		globals: make(map[string]pacValue),
+	}
+	var inits []pacStmt
+	for !p.atEnd() {
+		if p.accept("function") {
+			name, f, err := p.function()
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse proxy auto-config: %v", err)
+			}
+			pac.funcs[name] = f
+			continue
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse proxy auto-config: %v", err)
+		}
+		inits = append(inits, stmt)
+	}
+	if _, ok := pac.funcs["FindProxyForURL"]; !ok {
+		return nil, fmt.Errorf("cannot parse proxy auto-config: FindProxyForURL is not defined")
+	}
+	global, done := newPACEval(pac, pac.globals)
+	defer done()
+	for _, stmt := range inits {
+		if _, _, err := stmt(global); err != nil {
+			return nil, fmt.Errorf("cannot evaluate proxy auto-config: %v", err)
+		}
+	}
+	return pac, nil
+}
+
+// FindProxyForURL evaluates the proxy auto-config for the given URL,
+// returning its result as is, e.g. "PROXY proxy.example.com:3128; DIRECT".
+// It can be called concurrently, each evaluation has its own copy of the
+// global variables.
+//
+// As browsers do, the URL given to the proxy auto-config has no
+// credentials nor fragment, and https URLs are further stripped of their
+// path and query, which would otherwise leak to whoever serves the file.
+func (pac *PAC) FindProxyForURL(u *url.URL) (string, error) {
+	globals := make(map[string]pacValue, len(pac.globals))
+	for name, v := range pac.globals {
+		globals[name] = v
+	}
+	global, done := newPACEval(pac, globals)
+	defer done()
+	v, err := global.call("FindProxyForURL", []pacValue{pacURL(u), u.Hostname()})
+	if err != nil {
+		return "", fmt.Errorf("cannot evaluate proxy auto-config: %v", err)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("cannot evaluate proxy auto-config: FindProxyForURL returned %s instead of a string", pacTypeOf(v))
+	}
+	return s, nil
+}
+
+// pacURL returns the URL given to FindProxyForURL for u.
+func pacURL(u *url.URL) string {
+	stripped := url.URL{
+		Scheme:   u.Scheme,
+		Opaque:   u.Opaque,
+		Host:     u.Host,
+		Path:     u.Path,
+		RawPath:  u.RawPath,
+		RawQuery: u.RawQuery,
+	}
+	if u.Scheme == "https" {
+		stripped.Opaque = ""
+		stripped.Path = "/"
+		stripped.RawPath = ""
+		stripped.RawQuery = ""
+	}
+	return stripped.String()
+}
+
+// ParsePACResult parses the result of a proxy auto-config evaluation,
+// returning the proxies to try in order; DIRECT connections are
+// represented by nil.
+func ParsePACResult(result string) ([]*url.URL, error) {
+	var proxies []*url.URL
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("invalid proxy auto-config result %q", result)
+			}
+			proxies = append(proxies, nil)
+			continue
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			return nil, fmt.Errorf("invalid proxy auto-config result %q: unsupported proxy type %q", result, fields[0])
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid proxy auto-config result %q", result)
+		}
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid proxy auto-config result %q: %v", result, err)
+		}
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: fields[1]})
+	}
+	if len(proxies) == 0 {
+		// an empty result means a direct connection
+		proxies = append(proxies, nil)
+	}
+	return proxies, nil
+}
+
+func (s *pacScope) call(name string, args []pacValue) (pacValue, error) {
+	if err := s.eval.step(); err != nil {
+		return nil, err
+	}
+	if f, ok := s.pac.funcs[name]; ok {
+		if s.depth >= pacMaxDepth {
+			return nil, fmt.Errorf("too many nested calls")
+		}
+		local := &pacScope{
+			pac:    s.pac,
+			eval:   s.eval,
+			vars:   make(map[string]pacValue, len(f.params)),
+			parent: &pacScope{pac: s.pac, eval: s.eval, vars: s.eval.globals},
+			depth:  s.depth + 1,
+		}
+		for i, param := range f.params {
+			var v pacValue
+			if i < len(args) {
+				v = args[i]
+			}
+			local.vars[param] = v
+		}
+		v, _, err := f.body(local)
+		return v, err
+	}
+	if builtin, ok := pacBuiltins[name]; ok {
+		return builtin(s.eval.ctx, args)
+	}
+	return nil, fmt.Errorf("%s is not defined", name)
+}
+
+var (
+	pacLookupHost     = net.DefaultResolver.LookupHost
+	pacInterfaceAddrs = net.InterfaceAddrs
+)
+
+func pacArgs(name string, args []pacValue, n int) ([]string, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, n, len(args))
+	}
+	strs := make([]string, n)
+	for i := range strs {
+		strs[i] = pacToString(args[i])
+	}
+	return strs, nil
+}
+
+func pacResolve(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	addrs, err := pacLookupHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+func pacShExpMatch(str, shexp string) bool {
+	var re bytes.Buffer
+	re.WriteString("^")
+	for _, r := range shexp {
+		switch r {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	matched, err := regexp.MatchString(re.String(), str)
+	return err == nil && matched
+}
+
+var pacBuiltins = map[string]func(ctx context.Context, args []pacValue) (pacValue, error){
+	"isPlainHostName": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("isPlainHostName", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return !strings.Contains(a[0], "."), nil
+	},
+	"dnsDomainIs": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("dnsDomainIs", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(strings.ToLower(a[0]), strings.ToLower(a[1])), nil
+	},
+	"localHostOrDomainIs": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("localHostOrDomainIs", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		host, hostdom := strings.ToLower(a[0]), strings.ToLower(a[1])
+		if host == hostdom {
+			return true, nil
+		}
+		return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+	},
+	"isResolvable": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("isResolvable", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return pacResolve(ctx, a[0]) != nil, nil
+	},
+	"isInNet": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("isInNet", args, 3)
+		if err != nil {
+			return nil, err
+		}
+		pattern := net.ParseIP(a[1]).To4()
+		mask := net.ParseIP(a[2]).To4()
+		if pattern == nil || mask == nil {
+			return nil, fmt.Errorf("isInNet: invalid pattern %q or mask %q", a[1], a[2])
+		}
+		ip := pacResolve(ctx, a[0]).To4()
+		if ip == nil {
+			return false, nil
+		}
+		return ip.Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask))), nil
+	},
+	"dnsResolve": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("dnsResolve", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if ip := pacResolve(ctx, a[0]); ip != nil {
+			return ip.String(), nil
+		}
+		return nil, nil
+	},
+	"myIpAddress": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		addrs, err := pacInterfaceAddrs()
+		if err == nil {
+			for _, addr := range addrs {
+				ipnet, ok := addr.(*net.IPNet)
+				if ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+					return ipnet.IP.String(), nil
+				}
+			}
+		}
+		return "127.0.0.1", nil
+	},
+	"dnsDomainLevels": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("dnsDomainLevels", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return float64(strings.Count(a[0], ".")), nil
+	},
+	"shExpMatch": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		a, err := pacArgs("shExpMatch", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return pacShExpMatch(a[0], a[1]), nil
+	},
+	"alert": func(ctx context.Context, args []pacValue) (pacValue, error) {
+		return nil, nil
+	},
+}
+
+// member returns the value of the given property of v, or calls the
+// given method of v if args is not nil.
+func (s *pacScope) member(v pacValue, name string, args []pacValue) (pacValue, error) {
+	str, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot read property %s of %s", name, pacTypeOf(v))
+	}
+	runes := []rune(str)
+	if args == nil {
+		if name == "length" {
+			return float64(len(runes)), nil
+		}
+		return nil, fmt.Errorf("unsupported string property %s", name)
+	}
+	if err := s.eval.step(); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "toLowerCase":
+		return s.eval.allocString(strings.ToLower(str))
+	case "toUpperCase":
+		return s.eval.allocString(strings.ToUpper(str))
+	case "indexOf":
+		a, err := pacArgs(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		search := []rune(a[0])
+		for i := 0; i+len(search) <= len(runes); i++ {
+			if string(runes[i:i+len(search)]) == a[0] {
+				return float64(i), nil
+			}
+		}
+		return float64(-1), nil
+	case "charAt":
+		idx, err := pacIndexArgs(name, args, 1, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		if idx[0] >= len(runes) {
+			return "", nil
+		}
+		return string(runes[idx[0]]), nil
+	case "substring":
+		if len(args) == 1 {
+			args = append(args, float64(len(runes)))
+		}
+		idx, err := pacIndexArgs(name, args, 2, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		start, end := idx[0], idx[1]
+		if start > end {
+			start, end = end, start
+		}
+		return s.eval.allocString(string(runes[start:end]))
+	}
+	return nil, fmt.Errorf("unsupported string method %s", name)
+}
+
+// pacIndexArgs returns the first n arguments as indexes clamped to [0, max].
+func pacIndexArgs(name string, args []pacValue, n, max int) ([]int, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, n, len(args))
+	}
+	idx := make([]int, n)
+	for i := range idx {
+		f, ok := args[i].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a number, got %s", name, pacTypeOf(args[i]))
+		}
+		switch {
+		case f != f || f < 0:
+			// NaN or negative
+			idx[i] = 0
+		case f > float64(max):
+			idx[i] = max
+		default:
+			idx[i] = int(f)
+		}
+	}
+	return idx, nil
+}
+
+func pacTypeOf(v pacValue) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "undefined"
+	}
+}
+
+func pacToString(v pacValue) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		return "undefined"
+	}
+}
+
+func pacTruthy(v pacValue) bool {
+	switch x := v.(type) {
+	case string:
+		return x != ""
+	case float64:
+		return x != 0
+	case bool:
+		return x
+	default:
+		return false
+	}
+}
+
+func pacEqual(a, b pacValue) bool {
+	return a == b
+}
+
+// tokenizing and parsing
+
+type pacTokenKind int
+
+const (
+	pacIdent pacTokenKind = iota
+	pacString
+	pacNumber
+	pacPunct
+)
+
+type pacToken struct {
+	kind pacTokenKind
+	text string
+	line int
+}
+
+var pacPuncts = []string{
+	"===", "!==",
+	"==", "!=", "&&", "||", "<=", ">=",
+	"{", "}", "(", ")", ";", ",", ".", "+", "!", "=", "<", ">",
+}
+
+func isPACIdentRune(r byte, first bool) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == '$':
+		return true
+	case r >= '0' && r <= '9':
+		return !first
+	}
+	return false
+}
+
+func pacTokenize(src string) ([]pacToken, error) {
+	var toks []pacToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var s bytes.Buffer
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						s.WriteByte('\n')
+					case 't':
+						s.WriteByte('\t')
+					default:
+						s.WriteByte(src[j])
+					}
+					continue
+				}
+				s.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, pacToken{kind: pacString, text: s.String(), line: line})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, pacToken{kind: pacNumber, text: src[i:j], line: line})
+			i = j
+		case isPACIdentRune(c, true):
+			j := i
+			for j < len(src) && isPACIdentRune(src[j], false) {
+				j++
+			}
+			toks = append(toks, pacToken{kind: pacIdent, text: src[i:j], line: line})
+			i = j
+		default:
+			found := false
+			for _, punct := range pacPuncts {
+				if strings.HasPrefix(src[i:], punct) {
+					toks = append(toks, pacToken{kind: pacPunct, text: punct, line: line})
+					i += len(punct)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+		}
+	}
+	return toks, nil
+}
+
+type pacParser struct {
+	toks []pacToken
+	pos  int
+}
+
+func (p *pacParser) atEnd() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *pacParser) peek() pacToken {
+	if p.atEnd() {
+		return pacToken{kind: pacPunct, text: "<end>"}
+	}
+	return p.toks[p.pos]
+}
+
+// accept consumes the next token if it is the given punctuation or keyword.
+func (p *pacParser) accept(text string) bool {
+	tok := p.peek()
+	if !p.atEnd() && (tok.kind == pacPunct || tok.kind == pacIdent) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pacParser) errorf(format string, args ...interface{}) error {
+	tok := p.peek()
+	if p.atEnd() && len(p.toks) > 0 {
+		tok.line = p.toks[len(p.toks)-1].line
+	}
+	return fmt.Errorf("line %d: %s", tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *pacParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q, got %q", text, p.peek().text)
+	}
+	return nil
+}
+
+var pacKeywords = map[string]bool{
+	"function": true,
+	"if":       true,
+	"else":     true,
+	"return":   true,
+	"var":      true,
+	"true":     true,
+	"false":    true,
+	"null":     true,
+}
+
+func (p *pacParser) ident() (string, error) {
+	tok := p.peek()
+	if p.atEnd() || tok.kind != pacIdent || pacKeywords[tok.text] {
+		return "", p.errorf("expected identifier, got %q", tok.text)
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+func (p *pacParser) function() (string, *pacFunc, error) {
+	name, err := p.ident()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return "", nil, err
+	}
+	var params []string
+	for !p.accept(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return "", nil, err
+			}
+		}
+		param, err := p.ident()
+		if err != nil {
+			return "", nil, err
+		}
+		params = append(params, param)
+	}
+	if p.peek().text != "{" {
+		return "", nil, p.errorf("expected %q, got %q", "{", p.peek().text)
+	}
+	body, err := p.statement()
+	if err != nil {
+		return "", nil, err
+	}
+	return name, &pacFunc{params: params, body: body}, nil
+}
+
+func (p *pacParser) endStatement() {
+	// semicolons are optional at the end of statements
+	p.accept(";")
+}
+
+func (p *pacParser) statement() (pacStmt, error) {
+	switch {
+	case p.accept(";"):
+		return func(*pacScope) (pacValue, bool, error) { return nil, false, nil }, nil
+	case p.accept("{"):
+		var stmts []pacStmt
+		for !p.accept("}") {
+			if p.atEnd() {
+				return nil, p.errorf("expected %q", "}")
+			}
+			stmt, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, stmt)
+		}
+		return func(s *pacScope) (pacValue, bool, error) {
+			for _, stmt := range stmts {
+				if v, returned, err := stmt(s); returned || err != nil {
+					return v, returned, err
+				}
+			}
+			return nil, false, nil
+		}, nil
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		var otherwise pacStmt
+		if p.accept("else") {
+			otherwise, err = p.statement()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return func(s *pacScope) (pacValue, bool, error) {
+			c, err := cond(s)
+			if err != nil {
+				return nil, false, err
+			}
+			if pacTruthy(c) {
+				return then(s)
+			}
+			if otherwise != nil {
+				return otherwise(s)
+			}
+			return nil, false, nil
+		}, nil
+	case p.accept("return"):
+		var value pacExpr
+		if p.peek().text != ";" && p.peek().text != "}" {
+			var err error
+			value, err = p.expr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.endStatement()
+		return func(s *pacScope) (pacValue, bool, error) {
+			if value == nil {
+				return nil, true, nil
+			}
+			v, err := value(s)
+			return v, true, err
+		}, nil
+	case p.accept("var"):
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		var value pacExpr
+		if p.accept("=") {
+			value, err = p.expr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.endStatement()
+		return func(s *pacScope) (pacValue, bool, error) {
+			var v pacValue
+			if value != nil {
+				var err error
+				if v, err = value(s); err != nil {
+					return nil, false, err
+				}
+			}
+			s.vars[name] = v
+			return nil, false, nil
+		}, nil
+	}
+
+	// assignment or expression statement
+	if tok := p.peek(); tok.kind == pacIdent && !pacKeywords[tok.text] && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "=" {
+		p.pos += 2
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.endStatement()
+		return func(s *pacScope) (pacValue, bool, error) {
+			v, err := value(s)
+			if err != nil {
+				return nil, false, err
+			}
+			s.assign(tok.text, v)
+			return nil, false, nil
+		}, nil
+	}
+	value, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.endStatement()
+	return func(s *pacScope) (pacValue, bool, error) {
+		_, err := value(s)
+		return nil, false, err
+	}, nil
+}
+
+func (p *pacParser) expr() (pacExpr, error) {
+	return p.or()
+}
+
+func (p *pacParser) or() (pacExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		l := left
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = func(s *pacScope) (pacValue, error) {
+			v, err := l(s)
+			if err != nil || pacTruthy(v) {
+				return v, err
+			}
+			return r(s)
+		}
+	}
+	return left, nil
+}
+
+func (p *pacParser) and() (pacExpr, error) {
+	left, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		l := left
+		r, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		left = func(s *pacScope) (pacValue, error) {
+			v, err := l(s)
+			if err != nil || !pacTruthy(v) {
+				return v, err
+			}
+			return r(s)
+		}
+	}
+	return left, nil
+}
+
+func (p *pacParser) comparison() (pacExpr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		switch op {
+		case "==", "===", "!=", "!==", "<", "<=", ">", ">=":
+		default:
+			return left, nil
+		}
+		p.pos++
+		l := left
+		r, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		left = func(s *pacScope) (pacValue, error) {
+			a, err := l(s)
+			if err != nil {
+				return nil, err
+			}
+			b, err := r(s)
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case "==", "===":
+				return pacEqual(a, b), nil
+			case "!=", "!==":
+				return !pacEqual(a, b), nil
+			}
+			an, aok := a.(float64)
+			bn, bok := b.(float64)
+			if !aok || !bok {
+				return nil, fmt.Errorf("cannot compare %s and %s with %s", pacTypeOf(a), pacTypeOf(b), op)
+			}
+			switch op {
+			case "<":
+				return an < bn, nil
+			case "<=":
+				return an <= bn, nil
+			case ">":
+				return an > bn, nil
+			default:
+				return an >= bn, nil
+			}
+		}
+	}
+}
+
+func (p *pacParser) additive() (pacExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("+") {
+		l := left
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = func(s *pacScope) (pacValue, error) {
+			a, err := l(s)
+			if err != nil {
+				return nil, err
+			}
+			b, err := r(s)
+			if err != nil {
+				return nil, err
+			}
+			an, aok := a.(float64)
+			bn, bok := b.(float64)
+			if aok && bok {
+				return an + bn, nil
+			}
+			as, bs := pacToString(a), pacToString(b)
+			if err := s.eval.alloc(len(as) + len(bs)); err != nil {
+				return nil, err
+			}
+			return as + bs, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *pacParser) unary() (pacExpr, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *pacScope) (pacValue, error) {
+			v, err := operand(s)
+			if err != nil {
+				return nil, err
+			}
+			return !pacTruthy(v), nil
+		}, nil
+	}
+	return p.postfix()
+}
+
+func (p *pacParser) postfix() (pacExpr, error) {
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		var args []pacExpr
+		call := p.accept("(")
+		if call {
+			if args, err = p.args(); err != nil {
+				return nil, err
+			}
+		}
+		object := e
+		e = func(s *pacScope) (pacValue, error) {
+			v, err := object(s)
+			if err != nil {
+				return nil, err
+			}
+			if !call {
+				return s.member(v, name, nil)
+			}
+			values, err := evalArgs(s, args)
+			if err != nil {
+				return nil, err
+			}
+			return s.member(v, name, values)
+		}
+	}
+	return e, nil
+}
+
+// args parses the arguments of a call, after its opening parenthesis.
+func (p *pacParser) args() ([]pacExpr, error) {
+	var args []pacExpr
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// evalArgs evaluates the arguments of a call, returning a non-nil slice.
+func evalArgs(s *pacScope, args []pacExpr) ([]pacValue, error) {
+	values := make([]pacValue, len(args))
+	for i, arg := range args {
+		v, err := arg(s)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (p *pacParser) primary() (pacExpr, error) {
+	if p.accept("(") {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	tok := p.peek()
+	if p.atEnd() {
+		return nil, p.errorf("unexpected end of input")
+	}
+	switch tok.kind {
+	case pacString:
+		p.pos++
+		return func(*pacScope) (pacValue, error) { return tok.text, nil }, nil
+	case pacNumber:
+		p.pos++
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return func(*pacScope) (pacValue, error) { return n, nil }, nil
+	case pacIdent:
+		switch tok.text {
+		case "true", "false":
+			p.pos++
+			b := tok.text == "true"
+			return func(*pacScope) (pacValue, error) { return b, nil }, nil
+		case "null":
+			p.pos++
+			return func(*pacScope) (pacValue, error) { return nil, nil }, nil
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept("(") {
+			return func(s *pacScope) (pacValue, error) {
+				v, ok := s.lookup(name)
+				if !ok {
+					return nil, fmt.Errorf("%s is not defined", name)
+				}
+				return v, nil
+			}, nil
+		}
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *pacScope) (pacValue, error) {
+			values, err := evalArgs(s, args)
+			if err != nil {
+				return nil, err
+			}
+			return s.call(name, values)
+		}, nil
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
@@ -0,0 +1,400 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+var (
+	// how often a proxy auto-config is fetched again
+	pacRefreshInterval = 1 * time.Hour
+	// how long to wait before trying to fetch a proxy auto-config again
+	// after failing to
+	pacRetryInterval = 1 * time.Minute
+)
+
+// systemProxySettings are the proxy settings from an environment file.
+type systemProxySettings struct {
+	http      string
+	https     string
+	noProxy   string
+	autoProxy string
+}
+
+// SystemProxy finds the proxy to use for requests from the system-wide
+// proxy settings in an environment file like /etc/environment, as
+// maintained by snapd from the core.proxy options, and from the netplan
+// configuration.
+//
+// The file is read again whenever it changes, so that changes to the
+// settings are picked up without restarting; settings missing from it are
+// taken from the process environment. If a proxy auto-config URL is set
+// via auto_proxy, or else by the netplan configuration, it is fetched and
+// evaluated, falling back to the static settings if that fails.
+//
+// Proxy auto-configs are fetched and evaluated without holding the lock
+// of the SystemProxy, so that slow servers or DNS lookups only delay the
+// requests that need them.
+type SystemProxy struct {
+	envFile     string
+	netplanDirs []string
+
+	mu         sync.Mutex
+	modTime    time.Time
+	netplanSig string
+	settings   systemProxySettings
+	netplanPAC string
+	// autoConfigURL overrides auto_proxy from the settings
+	autoConfigURL string
+
+	pac        *PAC
+	pacURL     string
+	pacFetched time.Time
+	pacFailed  time.Time
+	// pacFetching is closed once the ongoing fetch of pacURL is done
+	pacFetching chan struct{}
+}
+
+// NewSystemProxy returns a SystemProxy using the settings from the given
+// environment file, and the proxy auto-config URL from the netplan
+// configuration in the given directories, if any, e.g. /lib/netplan,
+// /etc/netplan and /run/netplan.
+func NewSystemProxy(envFile string, netplanDirs ...string) *SystemProxy {
+	return &SystemProxy{envFile: envFile, netplanDirs: netplanDirs}
+}
+
+// SetAutoConfigURL sets the URL of the proxy auto-config to use,
+// overriding the one from the environment file. An empty URL removes the
+// override.
+func (p *SystemProxy) SetAutoConfigURL(pacURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.autoConfigURL = pacURL
+}
+
+// Proxy returns the proxy to use for the given request, nil meaning a
+// direct connection. It can be used as http.Transport.Proxy.
+func (p *SystemProxy) Proxy(req *http.Request) (*url.URL, error) {
+	p.mu.Lock()
+	settings, netplanPAC := p.load()
+	pacURL := p.autoConfigURL
+	p.mu.Unlock()
+
+	host := req.URL.Hostname()
+	if !useProxy(host, settings.noProxy) {
+		return nil, nil
+	}
+
+	if pacURL == "" {
+		pacURL = settings.autoProxy
+	}
+	if pacURL == "" {
+		pacURL = netplanPAC
+	}
+	if pacURL != "" {
+		if pac := p.autoConfig(pacURL); pac != nil {
+			proxy, err := proxyFromPAC(pac, req.URL)
+			if err == nil {
+				return proxy, nil
+			}
+			logger.Noticef("Cannot use proxy auto-config from %s: %v", pacURL, err)
+		}
+	}
+
+	var proxy string
+	switch req.URL.Scheme {
+	case "https":
+		proxy = settings.https
+	case "http":
+		proxy = settings.http
+	}
+	if proxy == "" {
+		return nil, nil
+	}
+	return parseProxy(proxy)
+}
+
+func proxyFromPAC(pac *PAC, u *url.URL) (*url.URL, error) {
+	result, err := pac.FindProxyForURL(u)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := ParsePACResult(result)
+	if err != nil {
+		return nil, err
+	}
+	// only one proxy can be used, take the preferred one
+	return proxies[0], nil
+}
+
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// like http.ProxyFromEnvironment, take a bare host:port to be
+		// a http proxy
+		if !strings.Contains(proxy, "://") {
+			if u, err := url.Parse("http://" + proxy); err == nil && u.Host != "" {
+				return u, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid proxy address %q", proxy)
+	}
+	return u, nil
+}
+
+// load returns the current settings and proxy auto-config URL from the
+// netplan configuration, reading them again if they changed.
+func (p *SystemProxy) load() (systemProxySettings, string) {
+	var modTime time.Time
+	if fi, err := os.Stat(p.envFile); err == nil {
+		modTime = fi.ModTime()
+	}
+	if p.modTime.IsZero() || !modTime.Equal(p.modTime) {
+		p.settings = p.loadEnvironment()
+		p.modTime = modTime
+	}
+
+	files := netplanFiles(p.netplanDirs)
+	if sig := netplanSignature(files); sig != p.netplanSig {
+		pacURL, err := netplanPACURL(files)
+		if err != nil {
+			logger.Noticef("Cannot read proxy settings from netplan configuration: %v", err)
+		}
+		p.netplanPAC = pacURL
+		p.netplanSig = sig
+	}
+	return p.settings, p.netplanPAC
+}
+
+func (p *SystemProxy) loadEnvironment() systemProxySettings {
+	env, err := readEnvironmentFile(p.envFile)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Noticef("Cannot read proxy settings from %s: %v", p.envFile, err)
+	}
+	get := func(key string) string {
+		if v, ok := env[key]; ok {
+			return v
+		}
+		if v, ok := env[strings.ToUpper(key)]; ok {
+			return v
+		}
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return os.Getenv(strings.ToUpper(key))
+	}
+	return systemProxySettings{
+		http:      get("http_proxy"),
+		https:     get("https_proxy"),
+		noProxy:   get("no_proxy"),
+		autoProxy: get("auto_proxy"),
+	}
+}
+
+func readEnvironmentFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l := strings.SplitN(line, "=", 2)
+		if len(l) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(l[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(l[0])] = value
+	}
+	return env, scanner.Err()
+}
+
+// pacClient fetches proxy auto-configs. They decide where all the
+// traffic of snapd goes, so they are only fetched over https, from
+// servers trusted by the system.
+var pacClient = &http.Client{
+	Timeout: 30 * time.Second,
+	// the proxy auto-config itself is always fetched directly
+	Transport: &http.Transport{Proxy: nil},
+}
+
+func fetchPAC(pacURL string) (*PAC, error) {
+	u, err := url.Parse(pacURL)
+	if err != nil {
+		return nil, err
+	}
+	var src []byte
+	switch u.Scheme {
+	case "file":
+		src, err = ioutil.ReadFile(u.Path)
+		if err != nil {
+			return nil, err
+		}
+	case "https":
+		resp, err := pacClient.Get(pacURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		// proxy auto-config files are small
+		src, err = ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		// plain http would let anyone on the path pick the proxy
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return ParsePAC(src)
+}
+
+// autoConfig returns the proxy auto-config from the given URL, fetching
+// it again when it is stale. nil is returned if it cannot be fetched.
+// While it is being fetched, other callers keep using the stale one, or
+// wait for the fetch if there is none.
+func (p *SystemProxy) autoConfig(pacURL string) *PAC {
+	p.mu.Lock()
+	now := timeNow()
+	if pacURL != p.pacURL {
+		p.pac = nil
+		p.pacURL = pacURL
+		p.pacFetched = time.Time{}
+		p.pacFailed = time.Time{}
+		p.pacFetching = nil
+	}
+	if p.pac != nil && now.Sub(p.pacFetched) < pacRefreshInterval {
+		defer p.mu.Unlock()
+		return p.pac
+	}
+	if !p.pacFailed.IsZero() && now.Sub(p.pacFailed) < pacRetryInterval {
+		// keep using the stale one, if any
+		defer p.mu.Unlock()
+		return p.pac
+	}
+	if fetching := p.pacFetching; fetching != nil {
+		pac := p.pac
+		p.mu.Unlock()
+		if pac != nil {
+			return pac
+		}
+		<-fetching
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.pacURL != pacURL {
+			return nil
+		}
+		return p.pac
+	}
+	fetching := make(chan struct{})
+	p.pacFetching = fetching
+	p.mu.Unlock()
+
+	pac, err := fetchPAC(pacURL)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(fetching)
+	if p.pacFetching != fetching {
+		// the URL changed meanwhile
+		return pac
+	}
+	p.pacFetching = nil
+	if err != nil {
+		logger.Noticef("Cannot fetch proxy auto-config from %s: %v", pacURL, err)
+		p.pacFailed = now
+		return p.pac
+	}
+	p.pac = pac
+	p.pacFetched = now
+	p.pacFailed = time.Time{}
+	return pac
+}
+
+var timeNow = time.Now
+
+// useProxy reports whether requests to host should go through a proxy
+// given the no_proxy setting.
+func useProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return false
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return false
+		}
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipnet.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if ip != nil && ip.Equal(net.ParseIP(entry)) {
+			return false
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return false
+			}
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,350 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package httputil_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/httputil"
+)
+
+type proxySuite struct {
+	envFile string
+	now     time.Time
+	restore func()
+}
+
+var _ = Suite(&proxySuite{})
+
+func (s *proxySuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "environment")
+	s.now = time.Now()
+	s.restore = httputil.MockTimeNow(func() time.Time { return s.now })
+	// isolate from the proxy settings of the environment running
+	// the tests
+	for _, key := range []string{"http_proxy", "https_proxy", "no_proxy", "auto_proxy"} {
+		for _, k := range []string{key, strings.ToUpper(key)} {
+			if old, ok := os.LookupEnv(k); ok {
+				os.Unsetenv(k)
+				restore := s.restore
+				k := k
+				s.restore = func() {
+					os.Setenv(k, old)
+					restore()
+				}
+			}
+		}
+	}
+}
+
+func (s *proxySuite) TearDownTest(c *C) {
+	s.restore()
+}
+
+// writeEnv writes the environment file, making sure its modification
+// time changes.
+func (s *proxySuite) writeEnv(c *C, content string) {
+	c.Assert(ioutil.WriteFile(s.envFile, []byte(content), 0644), IsNil)
+	s.now = s.now.Add(time.Second)
+	c.Assert(os.Chtimes(s.envFile, s.now, s.now), IsNil)
+}
+
+func (s *proxySuite) proxyFor(c *C, p *httputil.SystemProxy, rawurl string) *url.URL {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	c.Assert(err, IsNil)
+	proxy, err := p.Proxy(req)
+	c.Assert(err, IsNil)
+	return proxy
+}
+
+func (s *proxySuite) TestNoSettings(c *C) {
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), IsNil)
+}
+
+func (s *proxySuite) TestSettingsFromFile(c *C) {
+	s.writeEnv(c, `PATH="/usr/bin:/bin"
+http_proxy=http://proxy.example.com:3128
+HTTPS_PROXY="secure.example.com:3129"
+no_proxy=".internal,10.0.0.0/8"
+`)
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "http://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "proxy.example.com:3128"})
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "secure.example.com:3129"})
+	c.Check(s.proxyFor(c, p, "https://store.internal"), IsNil)
+	c.Check(s.proxyFor(c, p, "https://10.1.2.3"), IsNil)
+	c.Check(s.proxyFor(c, p, "https://localhost:8080"), IsNil)
+
+	// changes are picked up
+	s.writeEnv(c, "https_proxy=http://other.example.com:3128\n")
+	c.Check(s.proxyFor(c, p, "http://api.snapcraft.io"), IsNil)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "other.example.com:3128"})
+	c.Check(s.proxyFor(c, p, "https://store.internal"), DeepEquals, &url.URL{Scheme: "http", Host: "other.example.com:3128"})
+}
+
+func (s *proxySuite) TestSettingsFromProcessEnvironment(c *C) {
+	os.Setenv("HTTPS_PROXY", "http://env.example.com:3128")
+	defer os.Unsetenv("HTTPS_PROXY")
+
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "env.example.com:3128"})
+
+	// the file takes precedence
+	s.writeEnv(c, "https_proxy=http://file.example.com:3128\n")
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "file.example.com:3128"})
+}
+
+func (s *proxySuite) TestInvalidProxy(c *C) {
+	s.writeEnv(c, "https_proxy=http://\n")
+	p := httputil.NewSystemProxy(s.envFile)
+	req, err := http.NewRequest("GET", "https://api.snapcraft.io", nil)
+	c.Assert(err, IsNil)
+	_, err = p.Proxy(req)
+	c.Check(err, ErrorMatches, `invalid proxy address "http://"`)
+}
+
+const proxyTestPAC = `function FindProxyForURL(url, host) {
+	if (dnsDomainIs(host, ".snapcraft.io"))
+		return "PROXY pac-proxy.example.com:8080; DIRECT";
+	return "DIRECT";
+}`
+
+func (s *proxySuite) TestAutoConfigFromFile(c *C) {
+	pacFile := filepath.Join(c.MkDir(), "proxy.pac")
+	c.Assert(ioutil.WriteFile(pacFile, []byte(proxyTestPAC), 0644), IsNil)
+	s.writeEnv(c, fmt.Sprintf("auto_proxy=file://%s\nhttps_proxy=http://static.example.com:3128\n", pacFile))
+
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+	c.Check(s.proxyFor(c, p, "https://example.com"), IsNil)
+
+	// changes to the proxy auto-config are picked up once it is stale,
+	// but a broken one does not replace the last good one
+	c.Assert(ioutil.WriteFile(pacFile, []byte("function {"), 0644), IsNil)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+	s.now = s.now.Add(2 * time.Hour)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+
+	// without a good one the static settings are used
+	p = httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+}
+
+func (s *proxySuite) TestSetAutoConfigURL(c *C) {
+	pacFile := filepath.Join(c.MkDir(), "proxy.pac")
+	c.Assert(ioutil.WriteFile(pacFile, []byte(proxyTestPAC), 0644), IsNil)
+	s.writeEnv(c, "auto_proxy=file:///does/not/exist.pac\nhttps_proxy=http://static.example.com:3128\n")
+
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+
+	p.SetAutoConfigURL("file://" + pacFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+
+	p.SetAutoConfigURL("")
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+}
+
+func (s *proxySuite) TestAutoConfigFromServer(c *C) {
+	n := 0
+	pac := proxyTestPAC
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if pac == "" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprint(w, pac)
+	}))
+	defer srv.Close()
+	restore := httputil.MockPACClient(srv.Client())
+	defer restore()
+	s.writeEnv(c, fmt.Sprintf("auto_proxy=%s/proxy.pac\n", srv.URL))
+
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+	c.Check(s.proxyFor(c, p, "https://example.com"), IsNil)
+	c.Check(n, Equals, 1)
+
+	// fetched again once stale
+	pac = `function FindProxyForURL(url, host) { return "PROXY new-proxy.example.com:8080" }`
+	s.now = s.now.Add(2 * time.Hour)
+	c.Check(s.proxyFor(c, p, "https://example.com"), DeepEquals, &url.URL{Scheme: "http", Host: "new-proxy.example.com:8080"})
+	c.Check(n, Equals, 2)
+
+	// failing to fetch it keeps the last good one, without retrying
+	// right away
+	pac = ""
+	s.now = s.now.Add(2 * time.Hour)
+	c.Check(s.proxyFor(c, p, "https://example.com"), DeepEquals, &url.URL{Scheme: "http", Host: "new-proxy.example.com:8080"})
+	c.Check(s.proxyFor(c, p, "https://example.com"), DeepEquals, &url.URL{Scheme: "http", Host: "new-proxy.example.com:8080"})
+	c.Check(n, Equals, 3)
+	s.now = s.now.Add(2 * time.Minute)
+	c.Check(s.proxyFor(c, p, "https://example.com"), DeepEquals, &url.URL{Scheme: "http", Host: "new-proxy.example.com:8080"})
+	c.Check(n, Equals, 4)
+}
+
+func (s *proxySuite) TestAutoConfigFetchedWithoutLock(c *C) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fetching)
+		<-release
+		fmt.Fprint(w, proxyTestPAC)
+	}))
+	defer srv.Close()
+	restore := httputil.MockPACClient(srv.Client())
+	defer restore()
+	s.writeEnv(c, fmt.Sprintf("auto_proxy=%s/proxy.pac\n", srv.URL))
+
+	p := httputil.NewSystemProxy(s.envFile)
+	done := make(chan *url.URL)
+	go func() {
+		done <- s.proxyFor(c, p, "https://api.snapcraft.io")
+	}()
+	<-fetching
+
+	// other requests and settings changes are not held up by the
+	// fetch
+	unblocked := make(chan struct{})
+	go func() {
+		c.Check(s.proxyFor(c, p, "http://localhost"), IsNil)
+		p.SetAutoConfigURL("")
+		close(unblocked)
+	}()
+	select {
+	case <-unblocked:
+	case <-time.After(5 * time.Second):
+		c.Fatal("blocked by the fetch of the proxy auto-config")
+	}
+
+	close(release)
+	c.Check(<-done, DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+}
+
+func (s *proxySuite) TestAutoConfigFromNetplan(c *C) {
+	root := c.MkDir()
+	pacFile := filepath.Join(c.MkDir(), "proxy.pac")
+	c.Assert(ioutil.WriteFile(pacFile, []byte(proxyTestPAC), 0644), IsNil)
+	writeNetplan := func(dir, name, content string) {
+		path := filepath.Join(root, dir, name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+		s.now = s.now.Add(time.Second)
+		c.Assert(os.Chtimes(path, s.now, s.now), IsNil)
+	}
+	s.writeEnv(c, "https_proxy=http://static.example.com:3128\n")
+	writeNetplan("/etc/netplan", "50-cloud-init.yaml", `
+network:
+  version: 2
+  renderer: NetworkManager
+  ethernets:
+    eth0:
+      dhcp4: true
+`)
+
+	p := httputil.NewSystemProxy(s.envFile, httputil.NetplanDirs(root)...)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+
+	// the NetworkManager proxy settings are picked up when they change
+	writeNetplan("/etc/netplan", "90-proxy.yaml", fmt.Sprintf(`
+network:
+  ethernets:
+    eth0:
+      networkmanager:
+        passthrough:
+          proxy.method: auto
+          proxy.pac-url: file://%s
+`, pacFile))
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+
+	// files in /run/netplan shadow the ones with the same name
+	writeNetplan("/run/netplan", "90-proxy.yaml", `
+network:
+  ethernets:
+    eth0:
+      networkmanager:
+        passthrough:
+          proxy.method: none
+`)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+
+	// auto_proxy takes precedence
+	writeNetplan("/run/netplan", "90-proxy.yaml", fmt.Sprintf(`
+network:
+  wifis:
+    wlan0:
+      networkmanager:
+        passthrough:
+          proxy.method: auto
+          proxy.pac-url: file://%s
+`, pacFile))
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "pac-proxy.example.com:8080"})
+	s.writeEnv(c, "auto_proxy=file:///does/not/exist.pac\nhttps_proxy=http://static.example.com:3128\n")
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+}
+
+func (s *proxySuite) TestAutoConfigNotOverHTTP(c *C) {
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		fmt.Fprint(w, proxyTestPAC)
+	}))
+	defer srv.Close()
+	s.writeEnv(c, fmt.Sprintf("auto_proxy=%s/proxy.pac\nhttps_proxy=http://static.example.com:3128\n", srv.URL))
+
+	p := httputil.NewSystemProxy(s.envFile)
+	c.Check(s.proxyFor(c, p, "https://api.snapcraft.io"), DeepEquals, &url.URL{Scheme: "http", Host: "static.example.com:3128"})
+	c.Check(n, Equals, 0)
+}
+
+func (s *proxySuite) TestUseProxy(c *C) {
+	for _, t := range []struct {
+		host, noProxy string
+		use           bool
+	}{
+		{"api.snapcraft.io", "", true},
+		{"localhost", "", false},
+		{"127.0.0.1", "", false},
+		{"::1", "", false},
+		{"api.snapcraft.io", "*", false},
+		{"api.snapcraft.io", "snapcraft.io", false},
+		{"api.snapcraft.io", ".snapcraft.io", false},
+		{"snapcraft.io", ".snapcraft.io", false},
+		{"api.snapcraft.io", "*.snapcraft.io", false},
+		{"api.snapcraft.io", " example.com , API.snapcraft.io:443", false},
+		{"notsnapcraft.io", "snapcraft.io", true},
+		{"10.1.2.3", "10.0.0.0/8", false},
+		{"11.1.2.3", "10.0.0.0/8", true},
+		{"192.168.1.1", "192.168.1.1", false},
+	} {
+		c.Check(httputil.UseProxy(t.host, t.noProxy), Equals, t.use, Commentf("%q %q", t.host, t.noProxy))
+	}
+}
@@ -179,6 +179,10 @@ func (tac toolingStoreContext) ProxyStoreParams(defaultURL *url.URL) (proxyStore
 	return "", defaultURL, nil
 }
 
+func (tac toolingStoreContext) AssertionsProxyURL() (*url.URL, error) {
+	return nil, nil
+}
+
 func (tac toolingStoreContext) StoreID(fallback string) (string, error) {
 	return fallback, nil
 }
@@ -28,6 +28,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/snapasserts"
@@ -35,6 +36,7 @@ import (
 	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
@@ -56,6 +58,24 @@ type Options struct {
 	ModelFile       string
 	GadgetUnpackDir string
 
+	// OnDemandSnaps lists snaps, among the ones added to the image,
+	// that are put into the seed but installed only on demand instead
+	// of on first boot.
+	OnDemandSnaps []string
+	// ExtraAssertions lists files with additional assertions, e.g.
+	// validation sets, to put into the seed together with their
+	// prerequisites.
+	ExtraAssertions []string
+
+	// SourceDateEpoch, if set, is the time the timestamps of the
+	// prepared files are clamped to, so that identical inputs produce
+	// identical images.
+	SourceDateEpoch time.Time
+	// ExpectedDigest, if set, is the digest the prepared image must
+	// have, as reported by an earlier build, to verify that the build
+	// is reproducible.
+	ExpectedDigest string
+
 	// Architecture to use if none is specified by the model,
 	// useful only for classic mode. If set must match the model otherwise.
 	Architecture string
@@ -180,6 +200,12 @@ func classicHasSnaps(model *asserts.Model, opts *Options) bool {
 }
 
 func Prepare(opts *Options) error {
+	if opts.ExpectedDigest != "" && opts.SourceDateEpoch.IsZero() {
+		// without clamping the timestamps no two builds have the
+		// same digest
+		return fmt.Errorf("cannot check the digest of the image without --source-date-epoch")
+	}
+
 	model, err := decodeModelAssertion(opts)
 	if err != nil {
 		return err
@@ -368,6 +394,56 @@ func makeFetcher(tsto *ToolingStore, dlOpts *DownloadOptions, db *asserts.Databa
 
 }
 
+// onDemandSnaps returns the set of snaps that are to be installed on
+// demand, checking that none of them is needed to boot or required by
+// the model.
+func onDemandSnaps(model *asserts.Model, opts *Options, local *localInfos, baseName string) (map[string]bool, error) {
+	onDemand := make(map[string]bool, len(opts.OnDemandSnaps))
+	for _, snapName := range opts.OnDemandSnaps {
+		name := local.Name(snapName)
+		if !local.hasName(opts.Snaps, name) {
+			return nil, fmt.Errorf("cannot install snap %q on demand without adding it to the image", name)
+		}
+		switch name {
+		case "snapd", "core", baseName, model.Kernel(), model.Gadget():
+			return nil, fmt.Errorf("cannot install snap %q on demand, it is needed to boot", name)
+		}
+		if strutil.ListContains(model.RequiredSnaps(), name) {
+			return nil, fmt.Errorf("cannot install snap %q on demand, it is required by the model", name)
+		}
+		onDemand[name] = true
+	}
+	return onDemand, nil
+}
+
+// addExtraAssertions adds the assertions from the given file, along with
+// their prerequisites, to the ones put into the seed.
+func addExtraAssertions(f asserts.Fetcher, fn string) error {
+	r, err := os.Open(fn)
+	if err != nil {
+		return fmt.Errorf("cannot read extra assertions: %v", err)
+	}
+	defer r.Close()
+
+	dec := asserts.NewDecoder(r)
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot decode extra assertions from %q: %v", fn, err)
+		}
+		if a.Type() == asserts.ModelType {
+			return fmt.Errorf("cannot add a model assertion from %q to the seed", fn)
+		}
+		if err := f.Save(a); err != nil {
+			return fmt.Errorf("cannot add extra assertions from %q: %v", fn, err)
+		}
+	}
+	return nil
+}
+
 func installCloudConfig(gadgetDir string) error {
 	cloudConfig := filepath.Join(gadgetDir, "cloud.conf")
 	if !osutil.FileExists(cloudConfig) {
@@ -527,6 +603,11 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 		}
 	}
 
+	onDemand, err := onDemandSnaps(model, opts, local, baseName)
+	if err != nil {
+		return err
+	}
+
 	seen := make(map[string]bool)
 	var locals []string
 	downloadedSnapsInfoForBootConfig := map[string]*snap.Info{}
@@ -567,12 +648,23 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 		if err := hasBase(info, local, snaps); err != nil {
 			return err
 		}
+		if info.GetType() == snap.TypeKernel && !opts.Classic {
+			if err := checkKernelSupportsGadget(fn, info, opts.GadgetUnpackDir); err != nil {
+				return err
+			}
+		}
 		// warn about missing default providers
 		for _, dp := range neededDefaultProviders(info) {
 			if !local.hasName(snaps, dp) {
 				// TODO: have a way to ignore this issue on a snap by snap basis?
 				return fmt.Errorf("cannot use snap %q without its default content provider %q being added explicitly", info.InstanceName(), dp)
 			}
+			if onDemand[dp] && !onDemand[name] {
+				return fmt.Errorf("cannot use snap %q with its default content provider %q being installed on demand", info.InstanceName(), dp)
+			}
+		}
+		if onDemand[info.Base] && !onDemand[name] {
+			return fmt.Errorf("cannot use snap %q with its base %q being installed on demand", info.InstanceName(), info.Base)
 		}
 
 		seen[name] = true
@@ -636,7 +728,8 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 			Classic: needsClassic,
 			Contact: info.Contact,
 			// no assertions for this snap were put in the seed
-			Unasserted: info.SnapID == "",
+			Unasserted:      info.SnapID == "",
+			InstallOnDemand: onDemand[name],
 		})
 	}
 	if len(locals) > 0 {
@@ -653,6 +746,12 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 		}
 	}
 
+	for _, fn := range opts.ExtraAssertions {
+		if err := addExtraAssertions(f, fn); err != nil {
+			return err
+		}
+	}
+
 	for _, aRef := range f.addedRefs {
 		var afn string
 		// the names don't matter in practice as long as they don't conflict
@@ -697,7 +796,7 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 			return err
 		}
 
-		if err := setBootvars(downloadedSnapsInfoForBootConfig, model); err != nil {
+		if err := setBootvars(downloadedSnapsInfoForBootConfig, model, opts.GadgetUnpackDir); err != nil {
 			return err
 		}
 
@@ -707,10 +806,37 @@ func setupSeed(tsto *ToolingStore, model *asserts.Model, opts *Options, local *l
 		}
 	}
 
+	return finishReproducible(opts)
+}
+
+// checkKernelSupportsGadget checks that the kernel in the given snap file
+// supports what the volumes of the unpacked gadget need.
+func checkKernelSupportsGadget(kernelFn string, kernelInfo *snap.Info, gadgetUnpackDir string) error {
+	container, err := snap.Open(kernelFn)
+	if err != nil {
+		return err
+	}
+	features, err := snap.ReadKernelFeatures(container)
+	if err != nil {
+		return err
+	}
+	if features == nil {
+		// nothing to check against
+		return nil
+	}
+
+	const classic = false
+	gi, err := gadget.ReadInfo(gadgetUnpackDir, classic)
+	if err != nil {
+		return err
+	}
+	if err := gadget.ValidateKernelCompatibility(gi, features); err != nil {
+		return fmt.Errorf("cannot use kernel %q with the gadget: %v", kernelInfo.InstanceName(), err)
+	}
 	return nil
 }
 
-func setBootvars(downloadedSnapsInfoForBootConfig map[string]*snap.Info, model *asserts.Model) error {
+func setBootvars(downloadedSnapsInfoForBootConfig map[string]*snap.Info, model *asserts.Model, gadgetUnpackDir string) error {
 	if len(downloadedSnapsInfoForBootConfig) != 2 {
 		return fmt.Errorf("setBootvars can only be called with exactly one kernel and exactly one core/base boot info: %v", downloadedSnapsInfoForBootConfig)
 	}
@@ -765,6 +891,29 @@ func setBootvars(downloadedSnapsInfoForBootConfig map[string]*snap.Info, model *
 			m[bootvar] = name
 		}
 	}
+
+	// boot with the kernel command line of the gadget right away
+	cmdline, err := gadget.ReadKernelCmdline(gadgetUnpackDir, model.KernelCmdlineAllow())
+	if err != nil {
+		return err
+	}
+	cmdlineVars, err := boot.KernelCmdlineBootVars(loader, cmdline)
+	if err != nil {
+		return err
+	}
+	for k, v := range cmdlineVars {
+		m[k] = v
+	}
+
+	// boot the kernel from the active slot on boards with A/B slots
+	slotVars, err := boot.KernelSlotsBootVars(loader, m["snap_kernel"])
+	if err != nil {
+		return err
+	}
+	for k, v := range slotVars {
+		m[k] = v
+	}
+
 	if err := loader.SetBootVars(m); err != nil {
 		return err
 	}
@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -702,6 +703,121 @@ func (s *imageSuite) TestSetupSeed(c *C) {
 	c.Check(s.stderr.String(), Equals, "")
 }
 
+func (s *imageSuite) setupSeedWithGadgetFiles(c *C, files map[string]string) error {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+
+	rootdir := filepath.Join(c.MkDir(), "imageroot")
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+	for name, content := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(gadgetUnpackDir, name)), 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(gadgetUnpackDir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	opts := &image.Options{
+		RootDir:         rootdir,
+		GadgetUnpackDir: gadgetUnpackDir,
+	}
+	local, err := image.LocalSnaps(s.tsto, opts)
+	c.Assert(err, IsNil)
+
+	return image.SetupSeed(s.tsto, s.model, opts, local)
+}
+
+const cmdlineGadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+kernel-cmdline:
+  allow:
+    - console
+    - quiet
+`
+
+func (s *imageSuite) TestSetupSeedGadgetKernelCmdline(c *C) {
+	err := s.setupSeedWithGadgetFiles(c, map[string]string{
+		"meta/gadget.yaml": cmdlineGadgetYaml,
+		"cmdline.extra":    "console=ttyS0 quiet\n",
+	})
+	c.Assert(err, IsNil)
+
+	m, err := s.bootloader.GetBootVars("snap_kernel", "snapd_extra_cmdline_args", "snapd_full_cmdline_args")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_kernel":              "pc-kernel_2.snap",
+		"snapd_extra_cmdline_args": "console=ttyS0 quiet",
+		"snapd_full_cmdline_args":  "",
+	})
+}
+
+func (s *imageSuite) TestSetupSeedGadgetKernelCmdlineNotAllowed(c *C) {
+	err := s.setupSeedWithGadgetFiles(c, map[string]string{
+		"meta/gadget.yaml": cmdlineGadgetYaml,
+		"cmdline.full":     "init=/bin/sh",
+	})
+	c.Check(err, ErrorMatches, `invalid cmdline.full: argument "init=/bin/sh" is not allowed`)
+}
+
+func (s *imageSuite) TestSetupSeedGadgetKernelCmdlineModelAllow(c *C) {
+	s.model = s.brands.Model("my-brand", "my-model", map[string]interface{}{
+		"display-name":         "my display name",
+		"architecture":         "amd64",
+		"gadget":               "pc",
+		"kernel":               "pc-kernel",
+		"required-snaps":       []interface{}{"required-snap1"},
+		"kernel-cmdline-allow": []interface{}{"panic"},
+	})
+
+	// the model allows what the gadget does not
+	err := s.setupSeedWithGadgetFiles(c, map[string]string{
+		"meta/gadget.yaml": cmdlineGadgetYaml,
+		"cmdline.full":     "panic=-1",
+	})
+	c.Assert(err, IsNil)
+	m, err := s.bootloader.GetBootVars("snapd_full_cmdline_args")
+	c.Assert(err, IsNil)
+	c.Check(m["snapd_full_cmdline_args"], Equals, "panic=-1")
+
+	// and replaces what the gadget allows
+	err = s.setupSeedWithGadgetFiles(c, map[string]string{
+		"meta/gadget.yaml": cmdlineGadgetYaml,
+		"cmdline.full":     "quiet",
+	})
+	c.Check(err, ErrorMatches, `invalid cmdline.full: argument "quiet" is not allowed`)
+}
+
+func (s *imageSuite) TestSetupSeedGadgetKernelCmdlineUnsupported(c *C) {
+	s.bootloader.KernelCmdlineUnsupported = true
+	err := s.setupSeedWithGadgetFiles(c, map[string]string{
+		"meta/gadget.yaml": cmdlineGadgetYaml,
+		"cmdline.extra":    "console=ttyS0",
+	})
+	c.Check(err, ErrorMatches, `cannot set extra kernel command line: not supported by grub`)
+}
+
+func (s *imageSuite) TestSetupSeedKernelSlots(c *C) {
+	// the androidboot.conf of the gadget declares A/B kernel slots
+	s.bootloader.BootVars["snap_ab_slot"] = "a"
+	err := s.setupSeedWithGadgetFiles(c, nil)
+	c.Assert(err, IsNil)
+
+	m, err := s.bootloader.GetBootVars("snap_kernel", "snap_ab_slot", "snap_ab_a_kernel", "snap_ab_a_successful", "snap_ab_b_kernel")
+	c.Assert(err, IsNil)
+	c.Check(m, DeepEquals, map[string]string{
+		"snap_kernel":          "pc-kernel_2.snap",
+		"snap_ab_slot":         "a",
+		"snap_ab_a_kernel":     "pc-kernel_2.snap",
+		"snap_ab_a_successful": "1",
+		"snap_ab_b_kernel":     "",
+	})
+}
+
 func (s *imageSuite) TestSetupSeedLocalCoreBrandKernel(c *C) {
 	restore := image.MockTrusted(s.storeSigning.Trusted)
 	defer restore()
@@ -924,6 +1040,45 @@ func (s *imageSuite) TestSetupSeedWithClassicSnapFails(c *C) {
 	c.Assert(err, ErrorMatches, `cannot use classic snap "classic-snap" in a core system`)
 }
 
+func (s *imageSuite) TestSetupSeedKernelDoesNotSupportGadget(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+
+	rootdir := filepath.Join(c.MkDir(), "imageroot")
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+	err := os.MkdirAll(filepath.Join(gadgetUnpackDir, "meta"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(gadgetUnpackDir, "meta/gadget.yaml"), []byte(`
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: EFI System
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 50M
+`), 0644)
+	c.Assert(err, IsNil)
+	// a kernel without vfat support
+	s.downloadedSnaps["pc-kernel"] = snaptest.MakeTestSnapWithFiles(c, packageKernel, [][]string{
+		{"config-4.15.0-1-generic", "CONFIG_EXT4_FS=y\n# CONFIG_VFAT_FS is not set\n"},
+	})
+
+	opts := &image.Options{
+		RootDir:         rootdir,
+		GadgetUnpackDir: gadgetUnpackDir,
+	}
+	local, err := image.LocalSnaps(s.tsto, opts)
+	c.Assert(err, IsNil)
+
+	err = image.SetupSeed(s.tsto, s.model, opts, local)
+	c.Assert(err, ErrorMatches, `cannot use kernel "pc-kernel" with the gadget: structure #0 \("EFI System"\) of volume "pc" uses filesystem "vfat" not supported by the kernel \(CONFIG_VFAT_FS is not set\)`)
+}
+
 func (s *imageSuite) TestSetupSeedWithBase(c *C) {
 	restore := image.MockTrusted(s.storeSigning.Trusted)
 	defer restore()
@@ -1006,6 +1161,51 @@ func (s *imageSuite) TestSetupSeedWithBase(c *C) {
 	c.Check(s.stderr.String(), Equals, "")
 }
 
+func (s *imageSuite) TestSetupSeedReproducible(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+
+	epoch := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	prepare := func(expectedDigest string) (string, error) {
+		rootdir := filepath.Join(c.MkDir(), "imageroot")
+		opts := &image.Options{
+			RootDir:         rootdir,
+			GadgetUnpackDir: gadgetUnpackDir,
+			SourceDateEpoch: epoch,
+			ExpectedDigest:  expectedDigest,
+		}
+		local, err := image.LocalSnaps(s.tsto, opts)
+		c.Assert(err, IsNil)
+		if err := image.SetupSeed(s.tsto, s.model, opts, local); err != nil {
+			return "", err
+		}
+
+		fi, err := os.Stat(filepath.Join(rootdir, "var/lib/snapd/seed/seed.yaml"))
+		c.Assert(err, IsNil)
+		c.Check(fi.ModTime().Equal(epoch), Equals, true)
+
+		return image.Digest(rootdir, gadgetUnpackDir)
+	}
+
+	digest, err := prepare("")
+	c.Assert(err, IsNil)
+	c.Check(s.stdout.String(), Matches, `(?ms).*^Image digest: `+regexp.QuoteMeta(digest)+`$`)
+
+	// preparing the same image again yields the same digest
+	digest2, err := prepare(digest)
+	c.Assert(err, IsNil)
+	c.Check(digest2, Equals, digest)
+
+	_, err = prepare("sha3-384-other")
+	c.Check(err, ErrorMatches, `prepared image is not reproducible: got digest .*, expected sha3-384-other`)
+}
+
 func (s *imageSuite) TestSetupSeedKernelPublisherMismatch(c *C) {
 	restore := image.MockTrusted(s.storeSigning.Trusted)
 	defer restore()
@@ -1312,6 +1512,18 @@ func (s *imageSuite) TestPrepareInvalidChannel(c *C) {
 	c.Assert(err, ErrorMatches, `cannot use channel: channel name has too many components: x/x/x/x`)
 }
 
+func (s *imageSuite) TestPrepareExpectedDigestWithoutSourceDateEpoch(c *C) {
+	fn := filepath.Join(c.MkDir(), "model.assertion")
+	err := ioutil.WriteFile(fn, asserts.Encode(s.model), 0644)
+	c.Assert(err, IsNil)
+
+	err = image.Prepare(&image.Options{
+		ModelFile:      fn,
+		ExpectedDigest: "sha3-384-digest",
+	})
+	c.Assert(err, ErrorMatches, "cannot check the digest of the image without --source-date-epoch")
+}
+
 func (s *imageSuite) TestPrepareClassicModeNoClassicModel(c *C) {
 	fn := filepath.Join(c.MkDir(), "model.assertion")
 	err := ioutil.WriteFile(fn, asserts.Encode(s.model), 0644)
@@ -1873,6 +2085,172 @@ func (s *imageSuite) TestSetupSeedStoreAssertionFetched(c *C) {
 	c.Check(osutil.FileExists(p), Equals, true)
 }
 
+func (s *imageSuite) TestSetupSeedOnDemandSnaps(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+	model := s.brands.Model("my-brand", "my-model", map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+	})
+
+	rootdir := filepath.Join(c.MkDir(), "imageroot")
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"core":      "canonical",
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+	opts := &image.Options{
+		RootDir:         rootdir,
+		GadgetUnpackDir: gadgetUnpackDir,
+		Snaps:           []string{"required-snap1"},
+		SnapChannels:    map[string]string{"required-snap1": "edge"},
+		OnDemandSnaps:   []string{"required-snap1"},
+	}
+	local, err := image.LocalSnaps(s.tsto, opts)
+	c.Assert(err, IsNil)
+	err = image.SetupSeed(s.tsto, model, opts, local)
+	c.Assert(err, IsNil)
+
+	seed, err := snap.ReadSeedYaml(filepath.Join(rootdir, "var/lib/snapd/seed/seed.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(seed.Snaps, HasLen, 4)
+	for _, sn := range seed.Snaps[:3] {
+		c.Check(sn.InstallOnDemand, Equals, false)
+	}
+	c.Check(seed.Snaps[3], DeepEquals, &snap.SeedSnap{
+		Name:            "required-snap1",
+		SnapID:          "required-snap1-Id",
+		Channel:         "edge",
+		File:            "required-snap1_3.snap",
+		Contact:         "foo@example.com",
+		InstallOnDemand: true,
+	})
+}
+
+func (s *imageSuite) TestSetupSeedOnDemandSnapsErrors(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+	model := s.brands.Model("my-brand", "my-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"gadget":         "pc",
+		"kernel":         "pc-kernel",
+		"required-snaps": []interface{}{"required-snap1"},
+	})
+
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"core":      "canonical",
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+
+	for _, tc := range []struct {
+		snaps    []string
+		onDemand []string
+		err      string
+	}{
+		{nil, []string{"other-base"}, `cannot install snap "other-base" on demand without adding it to the image`},
+		{[]string{"pc"}, []string{"pc"}, `cannot install snap "pc" on demand, it is needed to boot`},
+		{[]string{"core"}, []string{"core"}, `cannot install snap "core" on demand, it is needed to boot`},
+		{[]string{"required-snap1"}, []string{"required-snap1"}, `cannot install snap "required-snap1" on demand, it is required by the model`},
+		{[]string{"snap-req-other-base", "other-base"}, []string{"other-base"}, `cannot use snap "snap-req-other-base" with its base "other-base" being installed on demand`},
+	} {
+		opts := &image.Options{
+			RootDir:         filepath.Join(c.MkDir(), "imageroot"),
+			GadgetUnpackDir: gadgetUnpackDir,
+			Snaps:           tc.snaps,
+			OnDemandSnaps:   tc.onDemand,
+		}
+		local, err := image.LocalSnaps(s.tsto, opts)
+		c.Assert(err, IsNil)
+		err = image.SetupSeed(s.tsto, model, opts, local)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
+func (s *imageSuite) TestSetupSeedExtraAssertions(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+	model := s.brands.Model("my-brand", "my-model", map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+	})
+
+	extraAcct := assertstest.NewAccount(s.storeSigning, "extra", map[string]interface{}{
+		"account-id": "extraid",
+	}, "")
+	extraFn := filepath.Join(c.MkDir(), "extra.assert")
+	err := ioutil.WriteFile(extraFn, asserts.Encode(extraAcct), 0644)
+	c.Assert(err, IsNil)
+
+	rootdir := filepath.Join(c.MkDir(), "imageroot")
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"core":      "canonical",
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+	opts := &image.Options{
+		RootDir:         rootdir,
+		GadgetUnpackDir: gadgetUnpackDir,
+		ExtraAssertions: []string{extraFn},
+	}
+	local, err := image.LocalSnaps(s.tsto, opts)
+	c.Assert(err, IsNil)
+	err = image.SetupSeed(s.tsto, model, opts, local)
+	c.Assert(err, IsNil)
+
+	// the extra assertion was put into the seed
+	p := filepath.Join(rootdir, "var/lib/snapd/seed/assertions", "extraid.account")
+	c.Check(osutil.FileExists(p), Equals, true)
+}
+
+func (s *imageSuite) TestSetupSeedExtraAssertionsErrors(c *C) {
+	restore := image.MockTrusted(s.storeSigning.Trusted)
+	defer restore()
+	model := s.brands.Model("my-brand", "my-model", map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+	})
+
+	gadgetUnpackDir := c.MkDir()
+	s.setupSnaps(c, gadgetUnpackDir, map[string]string{
+		"core":      "canonical",
+		"pc":        "canonical",
+		"pc-kernel": "canonical",
+	})
+
+	garbageFn := filepath.Join(c.MkDir(), "garbage.assert")
+	err := ioutil.WriteFile(garbageFn, []byte("garbage"), 0644)
+	c.Assert(err, IsNil)
+	modelFn := filepath.Join(c.MkDir(), "model.assert")
+	err = ioutil.WriteFile(modelFn, asserts.Encode(model), 0644)
+	c.Assert(err, IsNil)
+
+	for _, tc := range []struct {
+		fn  string
+		err string
+	}{
+		{garbageFn, `cannot decode extra assertions from ".*/garbage.assert": .*`},
+		{modelFn, `cannot add a model assertion from ".*/model.assert" to the seed`},
+		{"/does/not/exist", `cannot read extra assertions: open /does/not/exist: no such file or directory`},
+	} {
+		opts := &image.Options{
+			RootDir:         filepath.Join(c.MkDir(), "imageroot"),
+			GadgetUnpackDir: gadgetUnpackDir,
+			ExtraAssertions: []string{tc.fn},
+		}
+		local, err := image.LocalSnaps(s.tsto, opts)
+		c.Assert(err, IsNil)
+		err = image.SetupSeed(s.tsto, model, opts, local)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
+
 func (s *imageSuite) TestSetupSeedSnapReqBaseFromLocal(c *C) {
 	restore := image.MockTrusted(s.storeSigning.Trusted)
 	defer restore()
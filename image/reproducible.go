@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package image
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// clampTimestamps sets the access and modification times of the files and
+// directories under the given roots that are newer than epoch to epoch, so
+// that the times do not depend on when the image was prepared. Symlinks are
+// left alone as their own times cannot be changed.
+func clampTimestamps(epoch time.Time, roots ...string) error {
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 || !fi.ModTime().After(epoch) {
+				return nil
+			}
+			return os.Chtimes(path, epoch, epoch)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot clamp timestamps: %v", err)
+		}
+	}
+	return nil
+}
+
+// Digest computes a digest over the directories and files under the given
+// roots, covering their relative paths, types, permissions, modification
+// times, contents and symlink targets. Images prepared from identical inputs
+// and with the same Options.SourceDateEpoch have the same digest.
+func Digest(roots ...string) (string, error) {
+	h := crypto.SHA3_384.New()
+	for i, root := range roots {
+		// filepath.Walk visits the entries in lexical order
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			var content []byte
+			mtime := fi.ModTime().Unix()
+			switch {
+			case fi.Mode().IsRegular():
+				content, _, err = osutil.FileDigest(path, crypto.SHA3_384)
+			case fi.Mode()&os.ModeSymlink != 0:
+				var target string
+				target, err = os.Readlink(path)
+				content = []byte(target)
+				mtime = 0
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%d %q %v %d %x\n", i, rel, fi.Mode(), mtime, content)
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("cannot compute image digest: %v", err)
+		}
+	}
+	return asserts.EncodeDigest(crypto.SHA3_384, h.Sum(nil))
+}
+
+// imageRoots returns the directories prepared for the image described by
+// opts. It must be called with dirs set up for opts.RootDir.
+func imageRoots(opts *Options) []string {
+	if opts.Classic || opts.RootDir == "" {
+		// only the seed is ours in a classic root
+		return []string{dirs.SnapSeedDir}
+	}
+	return []string{dirs.GlobalRootDir, opts.GadgetUnpackDir}
+}
+
+// finishReproducible clamps the timestamps of the prepared image and
+// reports its digest if a source date epoch is set, and checks the digest
+// against the expected one, if any.
+func finishReproducible(opts *Options) error {
+	if opts.SourceDateEpoch.IsZero() && opts.ExpectedDigest == "" {
+		return nil
+	}
+	roots := imageRoots(opts)
+	if !opts.SourceDateEpoch.IsZero() {
+		if err := clampTimestamps(opts.SourceDateEpoch, roots...); err != nil {
+			return err
+		}
+	}
+	digest, err := Digest(roots...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "Image digest: %s\n", digest)
+	if opts.ExpectedDigest != "" && digest != opts.ExpectedDigest {
+		return fmt.Errorf("prepared image is not reproducible: got digest %s, expected %s", digest, opts.ExpectedDigest)
+	}
+	return nil
+}
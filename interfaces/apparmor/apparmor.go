@@ -35,6 +35,7 @@ import (
 	"strings"
 
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snapdenv"
 )
 
 // ValidateNoAppArmorRegexp will check that the given string does not
@@ -63,13 +64,36 @@ const (
 //
 // If no such profiles were previously loaded then they are simply added to the kernel.
 // If there were some profiles with the same name before, those profiles are replaced.
+//
+// When preseeding the profiles are only compiled into the cache, for the
+// kernel features described by the file named by
+// SNAPD_APPARMOR_FEATURES_FILE if set, so that they can be loaded on
+// first boot.
+//
+// Otherwise, unless the cache is to be skipped, the compiled form of the
+// profiles is first restored from the compiled profile cache into
+// cacheDir, and the compiled form of all the profiles is added to it once
+// they are loaded.
 func loadProfiles(fnames []string, cacheDir string, flags aaParserFlags) error {
 	if len(fnames) == 0 {
 		return nil
 	}
 
+	if !snapdenv.Preseeding() && flags&skipReadCache == 0 {
+		fnames = loadCompiledProfiles(fnames)
+		if len(fnames) == 0 {
+			return nil
+		}
+	}
+
 	// Use no-expr-simplify since expr-simplify is actually slower on armhf (LP: #1383858)
 	args := []string{"--replace", "--write-cache", "-O", "no-expr-simplify", fmt.Sprintf("--cache-loc=%s", cacheDir)}
+	if snapdenv.Preseeding() {
+		args[0] = "--skip-kernel-load"
+		if featuresFile := os.Getenv("SNAPD_APPARMOR_FEATURES_FILE"); featuresFile != "" {
+			args = append(args, fmt.Sprintf("--features-file=%s", featuresFile))
+		}
+	}
 	if flags&skipReadCache != 0 {
 		args = append(args, "--skip-read-cache")
 	}
@@ -82,6 +106,9 @@ func loadProfiles(fnames []string, cacheDir string, flags aaParserFlags) error {
 	if err != nil {
 		return fmt.Errorf("cannot load apparmor profiles: %s\napparmor_parser output:\n%s", err, string(output))
 	}
+	if !snapdenv.Preseeding() {
+		storeCompiledProfiles(fnames, cacheDir)
+	}
 	return nil
 }
 
@@ -97,6 +124,10 @@ func unloadProfiles(names []string, cacheDir string) error {
 		return nil
 	}
 
+	if err := removeCompiledProfiles(names); err != nil {
+		return err
+	}
+
 	/* TODO: uncomment when no lingering snap processes is guaranteed
 	// By the time this function is called, all the profiles (names) have
 	// been removed from dirs.SnapAppArmorDir, so to unload the profiles
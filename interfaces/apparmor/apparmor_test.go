@@ -31,6 +31,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -106,6 +107,25 @@ func (s *appArmorSuite) TestLoadProfilesRunsAppArmorParserReplaceWithSnapdDebug(
 	})
 }
 
+func (s *appArmorSuite) TestLoadProfilesPreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	cmd := testutil.MockCommand(c, "apparmor_parser", "")
+	defer cmd.Restore()
+	err := apparmor.LoadProfiles([]string{"/path/to/snap.samba.smbd"}, dirs.AppArmorCacheDir, 0)
+	c.Assert(err, IsNil)
+
+	os.Setenv("SNAPD_APPARMOR_FEATURES_FILE", "/path/to/features")
+	defer os.Unsetenv("SNAPD_APPARMOR_FEATURES_FILE")
+	err = apparmor.LoadProfiles([]string{"/path/to/snap.samba.smbd"}, dirs.AppArmorCacheDir, 0)
+	c.Assert(err, IsNil)
+
+	c.Assert(cmd.Calls(), DeepEquals, [][]string{
+		{"apparmor_parser", "--skip-kernel-load", "--write-cache", "-O", "no-expr-simplify", "--cache-loc=/var/cache/apparmor", "--quiet", "/path/to/snap.samba.smbd"},
+		{"apparmor_parser", "--skip-kernel-load", "--write-cache", "-O", "no-expr-simplify", "--cache-loc=/var/cache/apparmor", "--features-file=/path/to/features", "--quiet", "/path/to/snap.samba.smbd"},
+	})
+}
+
 // Tests for Profile.Unload()
 
 func (s *appArmorSuite) TestUnloadProfilesMany(c *C) {
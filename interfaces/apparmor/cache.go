@@ -0,0 +1,244 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package apparmor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/release"
+)
+
+// The compiled profile cache keeps the binary policy produced by
+// apparmor_parser for each profile, keyed by the hash of the profile text,
+// of the files it includes, of the parser features and of the kernel
+// features. The cache of apparmor_parser relies on the mtime of the
+// profiles and is discarded whenever the profiles or the parser change,
+// which happens on every refresh and whenever the security profiles are
+// regenerated. Profiles whose compiled form is in the cache are loaded
+// from it directly, skipping their costly compilation.
+//
+// The cache holds at most one compiled form per profile, stored as
+// <dirs.SnapAppArmorCompiledCacheDir>/<profile>/<key>/<path>, where <path>
+// is the location of the compiled form within the apparmor_parser cache.
+
+var parserMtime = release.AppArmorParserMtime
+
+// includeRegexp matches the include rules of profiles, e.g.
+// "#include <tunables/global>" or "include if exists <local/foo>".
+var includeRegexp = regexp.MustCompile(`(?m)^\s*#?include\s+(?:if\s+exists\s+)?(?:<([^>]+)>|"([^"]+)")`)
+
+// hashIncludes writes the path and content of the files included by the
+// given profile text into h, recursively. Included directories stand for
+// all the files in them.
+func hashIncludes(h io.Writer, text []byte, seen map[string]bool) {
+	for _, m := range includeRegexp.FindAllSubmatch(text, -1) {
+		path := string(m[2])
+		if len(m[1]) != 0 {
+			path = filepath.Join(dirs.SystemApparmorDir, string(m[1]))
+		}
+		paths := []string{path}
+		if osutil.IsDirectory(path) {
+			paths, _ = filepath.Glob(filepath.Join(path, "*"))
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			included, err := ioutil.ReadFile(path)
+			if err != nil {
+				// a missing file is part of the key too
+				fmt.Fprintf(h, "\x00include:%s:missing", path)
+				continue
+			}
+			fmt.Fprintf(h, "\x00include:%s:%d\x00", path, len(included))
+			h.Write(included)
+			hashIncludes(h, included, seen)
+		}
+	}
+}
+
+// compiledProfileKey returns the key of the compiled form of the given
+// profile text with the current includes, parser and kernel.
+func compiledProfileKey(text []byte) (string, error) {
+	kFeatures, err := kernelFeatures()
+	if err != nil {
+		return "", err
+	}
+	pFeatures, err := parserFeatures()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(text)
+	hashIncludes(h, text, make(map[string]bool))
+	fmt.Fprintf(h, "\x00kernel:%s\x00parser:%s\x00parser-mtime:%d",
+		strings.Join(kFeatures, ","), strings.Join(pFeatures, ","), parserMtime())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compiledProfileDir returns the directory holding the compiled form of
+// the given profile file with its current text.
+func compiledProfileDir(fname string) (string, error) {
+	text, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	key, err := compiledProfileKey(text)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.SnapAppArmorCompiledCacheDir, filepath.Base(fname), key), nil
+}
+
+// loadCompiledProfiles loads the compiled form of the given profiles that
+// is in the compiled profile cache into the kernel and returns the
+// profiles that are left to compile. Failing to do so is not an error,
+// the profiles are then compiled by apparmor_parser.
+func loadCompiledProfiles(fnames []string) []string {
+	var binaries, left []string
+	for _, fname := range fnames {
+		dir, err := compiledProfileDir(fname)
+		if err != nil {
+			left = append(left, fname)
+			continue
+		}
+		binary := newestParserCacheFile(dir, filepath.Base(fname))
+		if binary == "" {
+			left = append(left, fname)
+			continue
+		}
+		binaries = append(binaries, binary)
+	}
+	if len(binaries) == 0 {
+		return fnames
+	}
+
+	args := []string{"--replace", "--binary"}
+	if !osutil.GetenvBool("SNAPD_DEBUG") {
+		args = append(args, "--quiet")
+	}
+	args = append(args, binaries...)
+	if output, err := exec.Command("apparmor_parser", args...).CombinedOutput(); err != nil {
+		logger.Noticef("cannot load compiled apparmor profiles: %s\napparmor_parser output:\n%s", err, string(output))
+		return fnames
+	}
+	return left
+}
+
+// storeCompiledProfiles copies the compiled form of the given profiles,
+// which were just loaded by apparmor_parser, from its cache into the
+// compiled profile cache. Failing to do so is not an error, the profiles
+// are compiled again the next time they are loaded.
+func storeCompiledProfiles(fnames []string, cacheDir string) {
+	for _, fname := range fnames {
+		dir, err := compiledProfileDir(fname)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(fname)
+		binary := newestParserCacheFile(cacheDir, name)
+		if binary == "" || !newerThan(binary, fname) {
+			// the compiled form is missing or may come from an
+			// older text of the profile
+			continue
+		}
+		rel, err := filepath.Rel(cacheDir, binary)
+		if err != nil {
+			continue
+		}
+		target := filepath.Join(dir, rel)
+		if osutil.FileExists(target) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Dir(dir)); err != nil {
+			logger.Noticef("cannot remove stale compiled apparmor profile %q: %v", name, err)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			logger.Noticef("cannot cache compiled apparmor profile %q: %v", name, err)
+			continue
+		}
+		if err := osutil.CopyFile(binary, target, osutil.CopyFlagOverwrite); err != nil {
+			logger.Noticef("cannot cache compiled apparmor profile %q: %v", name, err)
+		}
+	}
+}
+
+// newestParserCacheFile returns the most recently written file of the
+// apparmor_parser cache for the profile with the given name. AppArmor 2.13
+// and higher has a cache forest, with a subdirectory for each set of kernel
+// features, while 2.12 and lower has a flat directory.
+func newestParserCacheFile(cacheDir, name string) string {
+	candidates, _ := filepath.Glob(filepath.Join(cacheDir, "*", name))
+	candidates = append(candidates, filepath.Join(cacheDir, name))
+	var newest string
+	var newestFi os.FileInfo
+	for _, candidate := range candidates {
+		fi, err := os.Stat(candidate)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if newestFi == nil || fi.ModTime().After(newestFi.ModTime()) {
+			newest, newestFi = candidate, fi
+		}
+	}
+	return newest
+}
+
+// newerThan returns whether the file at path a was modified no earlier than
+// the file at path b.
+func newerThan(a, b string) bool {
+	aFi, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bFi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return !aFi.ModTime().Before(bFi.ModTime())
+}
+
+// removeCompiledProfiles removes the compiled form of the named profiles
+// from the compiled profile cache.
+func removeCompiledProfiles(names []string) error {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dirs.SnapAppArmorCompiledCacheDir, name)); err != nil {
+			return fmt.Errorf("cannot remove compiled apparmor profile: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package apparmor_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snapdenv"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type compiledCacheSuite struct {
+	testutil.BaseTest
+	parserCmd *testutil.MockCmd
+	profile   string
+}
+
+var _ = Suite(&compiledCacheSuite{})
+
+// mockParserScript pretends to compile the profiles into the cache forest of
+// apparmor_parser, or to load their compiled form, which fails if
+// $MOCK_PARSER_FAIL_BINARY is set.
+const mockParserScript = `
+for arg in "$@"; do
+	case "$arg" in
+		--binary)
+			[ -z "$MOCK_PARSER_FAIL_BINARY" ]
+			exit $?
+			;;
+		--cache-loc=*)
+			loc="${arg#--cache-loc=}"
+			;;
+	esac
+done
+mkdir -p "$loc/deadbeef.0"
+echo compiled > "$loc/deadbeef.0/snap.samba.smbd"
+`
+
+func (s *compiledCacheSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.AddCleanup(apparmor.MockKernelFeatures(func() ([]string, error) { return []string{"network"}, nil }))
+	s.AddCleanup(apparmor.MockParserFeatures(func() ([]string, error) { return []string{"unsafe"}, nil }))
+	s.AddCleanup(apparmor.MockParserMtime(func() int64 { return 42 }))
+
+	s.parserCmd = testutil.MockCommand(c, "apparmor_parser", mockParserScript)
+	s.AddCleanup(s.parserCmd.Restore)
+
+	c.Assert(os.MkdirAll(dirs.SnapAppArmorDir, 0755), IsNil)
+	s.profile = filepath.Join(dirs.SnapAppArmorDir, "snap.samba.smbd")
+	c.Assert(ioutil.WriteFile(s.profile, []byte("profile text"), 0644), IsNil)
+}
+
+func (s *compiledCacheSuite) loadCall(extra ...string) []string {
+	call := []string{"apparmor_parser", "--replace", "--write-cache", "-O", "no-expr-simplify", fmt.Sprintf("--cache-loc=%s", dirs.AppArmorCacheDir)}
+	call = append(call, extra...)
+	return append(call, "--quiet", s.profile)
+}
+
+func (s *compiledCacheSuite) binaryLoadCall(binary string) []string {
+	return []string{"apparmor_parser", "--replace", "--binary", "--quiet", binary}
+}
+
+func (s *compiledCacheSuite) compiledProfiles(c *C) []string {
+	compiled, err := filepath.Glob(filepath.Join(dirs.SnapAppArmorCompiledCacheDir, "snap.samba.smbd", "*", "deadbeef.0", "snap.samba.smbd"))
+	c.Assert(err, IsNil)
+	return compiled
+}
+
+// discardParserCache removes the apparmor_parser cache, as is done when
+// the core snap is refreshed.
+func (s *compiledCacheSuite) discardParserCache(c *C) {
+	c.Assert(os.RemoveAll(dirs.AppArmorCacheDir), IsNil)
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesUsesCompiledCache(c *C) {
+	// the profile is compiled the first time and its compiled form is
+	// cached
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall()})
+	compiled := s.compiledProfiles(c)
+	c.Assert(compiled, HasLen, 1)
+	c.Check(compiled[0], testutil.FileEquals, "compiled\n")
+
+	// it is loaded directly afterwards, even when the apparmor_parser
+	// cache was discarded and the profile was rewritten with the same
+	// text
+	s.discardParserCache(c)
+	c.Assert(ioutil.WriteFile(s.profile, []byte("profile text"), 0644), IsNil)
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.binaryLoadCall(compiled[0])})
+	c.Check(s.compiledProfiles(c), DeepEquals, compiled)
+
+	// a different text is compiled again and replaces the cached one
+	c.Assert(ioutil.WriteFile(s.profile, []byte("new profile text"), 0644), IsNil)
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall()})
+	newCompiled := s.compiledProfiles(c)
+	c.Assert(newCompiled, HasLen, 1)
+	c.Check(newCompiled[0], Not(Equals), compiled[0])
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesCompiledCacheKeyedByIncludes(c *C) {
+	abstractions := filepath.Join(dirs.SystemApparmorDir, "abstractions")
+	c.Assert(os.MkdirAll(abstractions, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(abstractions, "base"), []byte("#include <abstractions/nameservice>\n"), 0644), IsNil)
+	nameservice := filepath.Join(abstractions, "nameservice")
+	c.Assert(ioutil.WriteFile(nameservice, []byte("nameservice"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(s.profile, []byte("#include <tunables/global>\nprofile text {\n  #include <abstractions/base>\n}\n"), 0644), IsNil)
+
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	compiled := s.compiledProfiles(c)
+	c.Assert(compiled, HasLen, 1)
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.binaryLoadCall(compiled[0])})
+
+	// a change of a file included indirectly invalidates the compiled
+	// form
+	c.Assert(ioutil.WriteFile(nameservice, []byte("new nameservice"), 0644), IsNil)
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall()})
+
+	// and so does one that was missing
+	tunables := filepath.Join(dirs.SystemApparmorDir, "tunables")
+	c.Assert(os.MkdirAll(tunables, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(tunables, "global"), nil, 0644), IsNil)
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall()})
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesCompilesWhenCompiledFormFailsToLoad(c *C) {
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	compiled := s.compiledProfiles(c)
+	c.Assert(compiled, HasLen, 1)
+
+	os.Setenv("MOCK_PARSER_FAIL_BINARY", "1")
+	defer os.Unsetenv("MOCK_PARSER_FAIL_BINARY")
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.binaryLoadCall(compiled[0]), s.loadCall()})
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesSkipReadCache(c *C) {
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Assert(s.compiledProfiles(c), HasLen, 1)
+
+	// the compiled profile cache is not read either
+	s.parserCmd.ForgetCalls()
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, apparmor.SkipReadCache), IsNil)
+	c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall("--skip-read-cache")})
+	c.Check(s.compiledProfiles(c), HasLen, 1)
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesCompiledCacheKeyedByFeatures(c *C) {
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Assert(s.compiledProfiles(c), HasLen, 1)
+
+	for _, restore := range []func(){
+		apparmor.MockKernelFeatures(func() ([]string, error) { return []string{"network", "dbus"}, nil }),
+		apparmor.MockParserFeatures(func() ([]string, error) { return []string{"unsafe", "cap-audit-read"}, nil }),
+		apparmor.MockParserMtime(func() int64 { return 43 }),
+	} {
+		s.parserCmd.ForgetCalls()
+		c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+		c.Check(s.parserCmd.Calls(), DeepEquals, [][]string{s.loadCall()})
+		restore()
+	}
+}
+
+func (s *compiledCacheSuite) TestLoadProfilesPreseedingSkipsCompiledCache(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Check(s.compiledProfiles(c), HasLen, 0)
+}
+
+func (s *compiledCacheSuite) TestUnloadProfilesRemovesCompiledProfile(c *C) {
+	c.Assert(apparmor.LoadProfiles([]string{s.profile}, dirs.AppArmorCacheDir, 0), IsNil)
+	c.Assert(s.compiledProfiles(c), HasLen, 1)
+
+	c.Assert(apparmor.UnloadProfiles([]string{"snap.samba.smbd"}, dirs.AppArmorCacheDir), IsNil)
+	c.Check(osutil.IsDirectory(filepath.Join(dirs.SnapAppArmorCompiledCacheDir, "snap.samba.smbd")), Equals, false)
+}
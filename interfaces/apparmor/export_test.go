@@ -35,6 +35,8 @@ var (
 	SetupSnapConfineReexec     = setupSnapConfineReexec
 )
 
+const SkipReadCache = skipReadCache
+
 // MockIsRootWritableOverlay mocks the real implementation of osutil.IsRootWritableOverlay
 func MockIsRootWritableOverlay(new func() (string, error)) (restore func()) {
 	old := isRootWritableOverlay
@@ -99,3 +101,11 @@ func MockParserFeatures(f func() ([]string, error)) (resture func()) {
 		parserFeatures = old
 	}
 }
+
+func MockParserMtime(f func() int64) (restore func()) {
+	old := parserMtime
+	parserMtime = f
+	return func() {
+		parserMtime = old
+	}
+}
@@ -22,12 +22,14 @@ package backends
 import (
 	"fmt"
 
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/dbus"
 	"github.com/snapcore/snapd/interfaces/kmod"
 	"github.com/snapcore/snapd/interfaces/mount"
 	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/selinux"
 	"github.com/snapcore/snapd/interfaces/systemd"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/release"
@@ -70,5 +72,12 @@ func backends() []interfaces.SecurityBackend {
 	case release.PartialAppArmor, release.FullAppArmor:
 		all = append(all, &apparmor.Backend{})
 	}
+
+	// Enable the SELinux backend when SELinux is enabled and snaps are
+	// to be confined with the policy modules it generates. Changes of
+	// the selinux-policy feature take effect when snapd restarts.
+	if release.SELinuxLevel() != release.NoSELinux && features.SELinuxPolicy.IsEnabled() {
+		all = append(all, &selinux.Backend{})
+	}
 	return all
 }
@@ -18,10 +18,14 @@
 package backends_test
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/interfaces/backends"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/testutil"
@@ -55,6 +59,40 @@ func (s *backendsSuite) TestIsAppArmorEnabled(c *C) {
 	}
 }
 
+func (s *backendsSuite) TestIsSELinuxEnabled(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	defer dirs.SetRootDir("")
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), IsNil)
+
+	for _, t := range []struct {
+		selinux, feature bool
+	}{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		restore := release.MockSELinuxIsEnabled(func() (bool, error) { return t.selinux, nil })
+		defer restore()
+		if t.feature {
+			c.Assert(ioutil.WriteFile(features.SELinuxPolicy.ControlFile(), nil, 0644), IsNil)
+		} else {
+			c.Assert(os.RemoveAll(features.SELinuxPolicy.ControlFile()), IsNil)
+		}
+
+		all := backends.Backends()
+		names := make([]string, len(all))
+		for i, backend := range all {
+			names[i] = string(backend.Name())
+		}
+		if t.selinux && t.feature {
+			c.Check(names, testutil.Contains, "selinux", Commentf("%+v", t))
+		} else {
+			c.Check(names, Not(testutil.Contains), "selinux", Commentf("%+v", t))
+		}
+	}
+}
+
 func (s *backendsSuite) TestEssentialOrdering(c *C) {
 	restore := release.MockAppArmorLevel(release.FullAppArmor)
 	defer restore()
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+const audioPlaybackSummary = `allows audio playback via supporting services`
+
+const audioPlaybackBaseDeclarationSlots = `
+  audio-playback:
+    allow-installation:
+      slot-snap-type:
+        - app
+        - core
+    deny-connection:
+      on-classic: false
+`
+
+const audioPlaybackConnectedPlugAppArmor = `
+# Allow communicating with the audio service. Note that its socket gives
+# access to everything the audio service provides, recording included:
+# audio-playback does not keep clients from recording.
+
+# PulseAudio
+/{run,dev}/shm/pulse-shm-* mrwk,
+
+owner /{,var/}run/pulse/ r,
+owner /{,var/}run/pulse/native rwk,
+owner /{,var/}run/pulse/pid r,
+owner /{,var/}run/user/[0-9]*/ r,
+owner /{,var/}run/user/[0-9]*/pulse/ r,
+
+# PipeWire
+owner /{,var/}run/user/[0-9]*/pipewire-[0-9] rw,
+
+/run/udev/data/c116:[0-9]* r,
+/run/udev/data/+sound:card[0-9]* r,
+`
+
+const audioPlaybackConnectedPlugAppArmorDesktop = `
+# Only on desktop do we need access to /etc/pulse for any PulseAudio client
+# to read available client side configuration settings. On an Ubuntu Core
+# device those things will be stored inside the snap directory.
+/etc/pulse/ r,
+/etc/pulse/** r,
+
+# PulseAudio authenticates its clients with a cookie.
+owner @{HOME}/.pulse-cookie rk,
+owner @{HOME}/.config/pulse/cookie rk,
+owner /{,var/}run/user/*/pulse/ r,
+owner /{,var/}run/user/*/pulse/native rwk,
+owner /{,var/}run/user/*/pulse/pid r,
+`
+
+const audioPlaybackConnectedPlugSecComp = `
+shmctl
+`
+
+const audioPlaybackPermanentSlotAppArmor = `
+# When running PulseAudio in system mode it will switch to the at
+# build time configured user/group on startup.
+capability setuid,
+capability setgid,
+
+capability sys_nice,
+capability sys_resource,
+
+owner @{PROC}/@{pid}/exe r,
+/etc/machine-id r,
+
+# Audio related
+@{PROC}/asound/devices r,
+@{PROC}/asound/card** r,
+
+# Should use the alsa interface instead
+/dev/snd/pcm* rw,
+/dev/snd/control* rw,
+/dev/snd/timer r,
+
+/sys/**/sound/** r,
+
+# For udev
+network netlink raw,
+/sys/devices/virtual/dmi/id/sys_vendor r,
+/sys/devices/virtual/dmi/id/bios_vendor r,
+# FIXME: use udev queries to make this more specific
+/run/udev/data/** r,
+
+owner /{,var/}run/pulse/ rw,
+owner /{,var/}run/pulse/** rwk,
+owner /{,var/}run/user/[0-9]*/ r,
+owner /{,var/}run/user/[0-9]*/pulse/ rw,
+owner /{,var/}run/user/[0-9]*/pipewire-[0-9] rwk,
+owner /{,var/}run/user/[0-9]*/pipewire-[0-9].lock rwk,
+
+# Shared memory based communication with clients
+/{run,dev}/shm/pulse-shm-* mrwk,
+
+/usr/share/applications/ r,
+`
+
+const audioPlaybackConnectedSlotAppArmor = `
+# Allow the audio service to communicate with its clients. Finding out
+# their security label is only allowed through audio-record.
+unix (receive, send) type=stream peer=(label=###PLUG_SECURITY_TAGS###),
+`
+
+const audioPlaybackPermanentSlotSecComp = `
+# The following are needed for UNIX sockets
+personality
+setpriority
+bind
+listen
+accept
+accept4
+shmctl
+# Needed to set root as group for different state dirs
+# pulseaudio creates on startup.
+setgroups
+setgroups32
+# libudev
+socket AF_NETLINK - NETLINK_KOBJECT_UEVENT
+`
+
+type audioPlaybackInterface struct{}
+
+func (iface *audioPlaybackInterface) Name() string {
+	return "audio-playback"
+}
+
+func (iface *audioPlaybackInterface) StaticInfo() interfaces.StaticInfo {
+	return interfaces.StaticInfo{
+		Summary:              audioPlaybackSummary,
+		ImplicitOnClassic:    true,
+		BaseDeclarationSlots: audioPlaybackBaseDeclarationSlots,
+	}
+}
+
+func (iface *audioPlaybackInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	spec.AddSnippet(audioPlaybackConnectedPlugAppArmor)
+	if release.OnClassic {
+		spec.AddSnippet(audioPlaybackConnectedPlugAppArmorDesktop)
+	}
+	return nil
+}
+
+func (iface *audioPlaybackInterface) AppArmorConnectedSlot(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	// the audio service on classic is not confined
+	if !implicitSystemConnectedSlot(slot) {
+		old := "###PLUG_SECURITY_TAGS###"
+		new := plugAppLabelExpr(plug)
+		spec.AddSnippet(strings.Replace(audioPlaybackConnectedSlotAppArmor, old, new, -1))
+	}
+	return nil
+}
+
+func (iface *audioPlaybackInterface) UDevPermanentSlot(spec *udev.Specification, slot *snap.SlotInfo) error {
+	spec.TagDevice(`KERNEL=="controlC[0-9]*"`)
+	spec.TagDevice(`KERNEL=="pcmC[0-9]*D[0-9]*[cp]"`)
+	spec.TagDevice(`KERNEL=="timer"`)
+	return nil
+}
+
+func (iface *audioPlaybackInterface) AppArmorPermanentSlot(spec *apparmor.Specification, slot *snap.SlotInfo) error {
+	spec.AddSnippet(audioPlaybackPermanentSlotAppArmor)
+	return nil
+}
+
+func (iface *audioPlaybackInterface) SecCompConnectedPlug(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	spec.AddSnippet(audioPlaybackConnectedPlugSecComp)
+	return nil
+}
+
+func (iface *audioPlaybackInterface) SecCompPermanentSlot(spec *seccomp.Specification, slot *snap.SlotInfo) error {
+	spec.AddSnippet(audioPlaybackPermanentSlotSecComp)
+	return nil
+}
+
+func (iface *audioPlaybackInterface) AutoConnect(*snap.PlugInfo, *snap.SlotInfo) bool {
+	return true
+}
+
+func init() {
+	registerIface(&audioPlaybackInterface{})
+}
@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type AudioPlaybackInterfaceSuite struct {
+	iface           interfaces.Interface
+	coreSlotInfo    *snap.SlotInfo
+	coreSlot        *interfaces.ConnectedSlot
+	classicSlotInfo *snap.SlotInfo
+	classicSlot     *interfaces.ConnectedSlot
+	plugInfo        *snap.PlugInfo
+	plug            *interfaces.ConnectedPlug
+}
+
+var _ = Suite(&AudioPlaybackInterfaceSuite{
+	iface: builtin.MustInterface("audio-playback"),
+})
+
+const audioPlaybackMockPlugSnapInfoYaml = `name: consumer
+version: 1.0
+apps:
+ app:
+  command: foo
+  plugs: [audio-playback]
+`
+
+// an audio-playback slot on an audio service snap (as installed on a
+// core/all-snap system)
+const audioPlaybackMockCoreSlotSnapInfoYaml = `name: audio-service
+version: 1.0
+apps:
+ app1:
+  command: foo
+  slots: [audio-playback]
+`
+
+// an audio-playback slot on the core snap (as automatically added on classic)
+const audioPlaybackMockClassicSlotSnapInfoYaml = `name: core
+version: 0
+type: os
+slots:
+ audio-playback:
+  interface: audio-playback
+`
+
+func (s *AudioPlaybackInterfaceSuite) SetUpTest(c *C) {
+	snapInfo := snaptest.MockInfo(c, audioPlaybackMockCoreSlotSnapInfoYaml, nil)
+	s.coreSlotInfo = snapInfo.Slots["audio-playback"]
+	s.coreSlot = interfaces.NewConnectedSlot(s.coreSlotInfo, nil, nil)
+	snapInfo = snaptest.MockInfo(c, audioPlaybackMockClassicSlotSnapInfoYaml, nil)
+	s.classicSlotInfo = snapInfo.Slots["audio-playback"]
+	s.classicSlot = interfaces.NewConnectedSlot(s.classicSlotInfo, nil, nil)
+	snapInfo = snaptest.MockInfo(c, audioPlaybackMockPlugSnapInfoYaml, nil)
+	s.plugInfo = snapInfo.Plugs["audio-playback"]
+	s.plug = interfaces.NewConnectedPlug(s.plugInfo, nil, nil)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestName(c *C) {
+	c.Assert(s.iface.Name(), Equals, "audio-playback")
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestSanitizeSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.coreSlotInfo), IsNil)
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.classicSlotInfo), IsNil)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestSanitizePlug(c *C) {
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestAppArmorOnClassic(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.classicSlot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "owner /{,var/}run/pulse/native rwk,\n")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "owner /{,var/}run/user/[0-9]*/pipewire-[0-9] rw,\n")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "owner @{HOME}/.config/pulse/cookie rk,\n")
+
+	// the audio service on classic is not confined
+	spec = &apparmor.Specification{}
+	c.Assert(spec.AddConnectedSlot(s.iface, s.plug, s.classicSlot), IsNil)
+	c.Assert(spec.SecurityTags(), HasLen, 0)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestAppArmorOnAllSnaps(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.coreSlot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "owner /{,var/}run/pulse/native rwk,\n")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), Not(testutil.Contains), "/etc/pulse/ r,\n")
+
+	spec = &apparmor.Specification{}
+	c.Assert(spec.AddConnectedSlot(s.iface, s.plug, s.coreSlot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.audio-service.app1"})
+	c.Check(spec.SnippetForTag("snap.audio-service.app1"), testutil.Contains, `unix (receive, send) type=stream peer=(label="snap.consumer.app"),`)
+	// identifying clients is left to audio-record
+	c.Check(spec.SnippetForTag("snap.audio-service.app1"), Not(testutil.Contains), "getattr")
+
+	spec = &apparmor.Specification{}
+	c.Assert(spec.AddPermanentSlot(s.iface, s.coreSlotInfo), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.audio-service.app1"})
+	c.Check(spec.SnippetForTag("snap.audio-service.app1"), testutil.Contains, "owner /{,var/}run/pulse/** rwk,\n")
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestSecCompOnAllSnaps(c *C) {
+	seccompSpec := &seccomp.Specification{}
+	err := seccompSpec.AddPermanentSlot(s.iface, s.coreSlotInfo)
+	c.Assert(err, IsNil)
+	err = seccompSpec.AddConnectedPlug(s.iface, s.plug, s.coreSlot)
+	c.Assert(err, IsNil)
+	c.Assert(seccompSpec.SecurityTags(), DeepEquals, []string{"snap.audio-service.app1", "snap.consumer.app"})
+	c.Check(seccompSpec.SnippetForTag("snap.audio-service.app1"), testutil.Contains, "listen\n")
+	c.Check(seccompSpec.SnippetForTag("snap.consumer.app"), testutil.Contains, "shmctl\n")
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestUDev(c *C) {
+	spec := &udev.Specification{}
+	c.Assert(spec.AddPermanentSlot(s.iface, s.coreSlotInfo), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 4)
+	c.Check(spec.Snippets(), testutil.Contains, `# audio-playback
+KERNEL=="pcmC[0-9]*D[0-9]*[cp]", TAG+="snap_audio-service_app1"`)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestStaticInfo(c *C) {
+	si := interfaces.StaticInfoOf(s.iface)
+	c.Check(si.ImplicitOnCore, Equals, false)
+	c.Check(si.ImplicitOnClassic, Equals, true)
+	c.Check(si.Summary, Equals, `allows audio playback via supporting services`)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestAutoConnect(c *C) {
+	c.Check(s.iface.AutoConnect(s.plugInfo, s.coreSlotInfo), Equals, true)
+}
+
+func (s *AudioPlaybackInterfaceSuite) TestInterfaces(c *C) {
+	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
+}
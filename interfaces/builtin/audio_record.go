@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/snap"
+)
+
+const audioRecordSummary = `allows audio recording via supporting services`
+
+const audioRecordBaseDeclarationSlots = `
+  audio-record:
+    allow-installation:
+      slot-snap-type:
+        - app
+        - core
+    deny-connection:
+      on-classic: false
+    deny-auto-connection: true
+`
+
+const audioRecordConnectedPlugAppArmor = `
+# Access for communication with the audio service is done via the
+# audio-playback interface, which alone does not restrict recording.
+`
+
+// An audio service provided by a snap can only get the security label
+// of the clients connected to its audio-record slot, which it may use
+// to decide whom to let record. Nothing here enforces that, and the
+// audio service of a classic system is not confined at all.
+const audioRecordConnectedSlotAppArmor = `
+# Allow the audio service to find out the security label of the clients
+# that may record, see audio-playback.
+unix (getattr, getopt) type=stream peer=(label=###PLUG_SECURITY_TAGS###),
+`
+
+type audioRecordInterface struct{}
+
+func (iface *audioRecordInterface) Name() string {
+	return "audio-record"
+}
+
+func (iface *audioRecordInterface) StaticInfo() interfaces.StaticInfo {
+	return interfaces.StaticInfo{
+		Summary:              audioRecordSummary,
+		ImplicitOnClassic:    true,
+		BaseDeclarationSlots: audioRecordBaseDeclarationSlots,
+	}
+}
+
+func (iface *audioRecordInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	spec.AddSnippet(audioRecordConnectedPlugAppArmor)
+	return nil
+}
+
+func (iface *audioRecordInterface) AppArmorConnectedSlot(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	// the audio service on classic is not confined
+	if !implicitSystemConnectedSlot(slot) {
+		old := "###PLUG_SECURITY_TAGS###"
+		new := plugAppLabelExpr(plug)
+		spec.AddSnippet(strings.Replace(audioRecordConnectedSlotAppArmor, old, new, -1))
+	}
+	return nil
+}
+
+func (iface *audioRecordInterface) AutoConnect(*snap.PlugInfo, *snap.SlotInfo) bool {
+	return true
+}
+
+func init() {
+	registerIface(&audioRecordInterface{})
+}
@@ -56,6 +56,15 @@ var cameraConnectedPlugUDev = []string{
 	`KERNEL=="vchiq"`,
 }
 
+const cameraConnectedPlugSELinux = `
+; Description: Can access video cameras.
+(allow ###DOMAIN### v4l_device_t (chr_file (getattr open read write ioctl map)))
+(allow ###DOMAIN### device_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (file (getattr open read)))
+(allow ###DOMAIN### sysfs_t (lnk_file (getattr read)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "camera",
@@ -65,6 +74,7 @@ func init() {
 		baseDeclarationSlots:  cameraBaseDeclarationSlots,
 		connectedPlugAppArmor: cameraConnectedPlugAppArmor,
 		connectedPlugUDev:     cameraConnectedPlugUDev,
+		connectedPlugSELinux:  cameraConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
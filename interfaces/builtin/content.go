@@ -30,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/mount"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 const contentSummary = `allows sharing code and data with other snaps`
@@ -106,6 +107,28 @@ func (iface *contentInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
 			return fmt.Errorf("content interface path is not clean: %q", p)
 		}
 	}
+
+	if version, ok := slot.Attrs["version"]; ok {
+		if s, ok := version.(string); !ok || s == "" {
+			return fmt.Errorf(`content "version" attribute must be a non-empty string`)
+		}
+		if _, ok := slot.Attrs["versions"]; ok {
+			return fmt.Errorf(`content "version" and "versions" attributes cannot be used together`)
+		}
+	}
+	if versions, ok := slot.Attrs["versions"]; ok {
+		if !isNonEmptyStringList(versions) {
+			return fmt.Errorf(`content "versions" attribute must be a non-empty list of strings`)
+		}
+	}
+	if singleWriter, ok := slot.Attrs["single-writer"]; ok {
+		if _, ok := singleWriter.(bool); !ok {
+			return fmt.Errorf(`content "single-writer" attribute must be a boolean`)
+		}
+	}
+	if err := validatePropagation(slot.Attrs); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -126,9 +149,250 @@ func (iface *contentInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
 		return fmt.Errorf("content interface target path is not clean: %q", target)
 	}
 
+	if versions, ok := plug.Attrs["versions"]; ok {
+		if !isNonEmptyStringList(versions) {
+			return fmt.Errorf(`content "versions" attribute must be a non-empty list of strings`)
+		}
+		for _, v := range versions.([]interface{}) {
+			if err := validateVersionRange(v.(string)); err != nil {
+				return err
+			}
+		}
+	}
+	if readOnly, ok := plug.Attrs["read-only"]; ok {
+		if _, ok := readOnly.(bool); !ok {
+			return fmt.Errorf(`content "read-only" attribute must be a boolean`)
+		}
+	}
+	if err := validatePropagation(plug.Attrs); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// propagationLevels orders the mount propagation modes that can be used
+// for the content mounts by how much they share with the slot.
+var propagationLevels = map[string]int{
+	"rslave":  1,
+	"rshared": 2,
+}
+
+func validatePropagation(attrs map[string]interface{}) error {
+	propagation, ok := attrs["propagation"]
+	if !ok {
+		return nil
+	}
+	if s, ok := propagation.(string); !ok || propagationLevels[s] == 0 {
+		return fmt.Errorf(`content "propagation" attribute must be "rslave" or "rshared"`)
+	}
+	return nil
+}
+
+func isNonEmptyStringList(value interface{}) bool {
+	l, ok := value.([]interface{})
+	if !ok || len(l) == 0 {
+		return false
+	}
+	for _, v := range l {
+		if s, ok := v.(string); !ok || s == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// validateVersionRange checks an entry of the "versions" attribute of the
+// plug. An entry is either a version, matched literally, or an inclusive
+// range of versions written as "<min>..<max>" where either bound can be
+// omitted.
+func validateVersionRange(r string) error {
+	min, max, isRange := splitVersionRange(r)
+	if !isRange {
+		return nil
+	}
+	if min == "" && max == "" {
+		return fmt.Errorf(`content version range %q must have at least one bound`, r)
+	}
+	for _, bound := range []string{min, max} {
+		if bound != "" && !strutil.VersionIsValid(bound) {
+			return fmt.Errorf(`content version range %q has invalid bound %q`, r, bound)
+		}
+	}
+	if min != "" && max != "" {
+		if cmp, _ := strutil.VersionCompare(min, max); cmp > 0 {
+			return fmt.Errorf(`content version range %q is empty`, r)
+		}
+	}
+	return nil
+}
+
+func splitVersionRange(r string) (min, max string, isRange bool) {
+	idx := strings.Index(r, "..")
+	if idx < 0 {
+		return "", "", false
+	}
+	return r[:idx], r[idx+2:], true
+}
+
+// versionInRange returns whether the given version matches an entry of the
+// "versions" attribute of the plug.
+func versionInRange(version, r string) bool {
+	min, max, isRange := splitVersionRange(r)
+	if !isRange {
+		return version == r
+	}
+	if !strutil.VersionIsValid(version) {
+		return false
+	}
+	if min != "" {
+		if cmp, _ := strutil.VersionCompare(version, min); cmp < 0 {
+			return false
+		}
+	}
+	if max != "" {
+		if cmp, _ := strutil.VersionCompare(version, max); cmp > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// slotVersions returns the versions of the content provided by the slot,
+// either the single "version" or the list of "versions".
+func slotVersions(slot interfaces.Attrer) []string {
+	var version string
+	if err := slot.Attr("version", &version); err == nil {
+		return []string{version}
+	}
+	var versions []interface{}
+	if err := slot.Attr("versions", &versions); err != nil {
+		return nil
+	}
+	out := make([]string, 0, len(versions))
+	for _, v := range versions {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ContentVersion negotiates the version of the content shared through a
+// connection of the content interface. Among the versions provided by the
+// slot it selects the highest one that the plug supports. An empty version
+// is returned if the slot does not declare any version and the plug accepts
+// any version. An error is returned if the plug lists the versions it
+// supports and none of the versions of the slot is among them.
+func ContentVersion(plug interfaces.Attrer, slot interfaces.Attrer) (string, error) {
+	provided := slotVersions(slot)
+	var ranges []interface{}
+	if err := plug.Attr("versions", &ranges); err != nil {
+		// any version is fine
+		ranges = nil
+	}
+
+	var selected string
+	for _, version := range provided {
+		if ranges != nil && !versionSupported(version, ranges) {
+			continue
+		}
+		if selected == "" {
+			selected = version
+			continue
+		}
+		if cmp, err := strutil.VersionCompare(version, selected); err == nil && cmp > 0 {
+			selected = version
+		}
+	}
+	if selected != "" || ranges == nil {
+		return selected, nil
+	}
+
+	switch len(provided) {
+	case 0:
+		return "", fmt.Errorf("content version is not declared by the slot, plug supports versions %s", versionsList(ranges))
+	case 1:
+		return "", fmt.Errorf("content version %q is not supported by the plug, plug supports versions %s", provided[0], versionsList(ranges))
+	default:
+		l := make([]interface{}, len(provided))
+		for i, v := range provided {
+			l[i] = v
+		}
+		return "", fmt.Errorf("content versions %s are not supported by the plug, plug supports versions %s", versionsList(l), versionsList(ranges))
+	}
+}
+
+func versionSupported(version string, ranges []interface{}) bool {
+	for _, r := range ranges {
+		if s, ok := r.(string); ok && versionInRange(version, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func versionsList(versions []interface{}) string {
+	l := make([]string, len(versions))
+	for i, v := range versions {
+		l[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(l, ", ")
+}
+
+// BeforeConnect negotiates the version of the content and whether the plug
+// gets write access to the writable paths of the slot. The plug gets write
+// access unless it asks for "read-only" or the slot is "single-writer" and
+// another connected plug has write access already. The mount propagation
+// asked for by the plug must be allowed by the "propagation" attribute of
+// the slot. The outcome is recorded in the "content-version" and "writable"
+// dynamic attributes of the plug.
+func (iface *contentInterface) BeforeConnect(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot, slotConns []*interfaces.Connection) error {
+	version, err := ContentVersion(plug, slot)
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		if err := plug.SetAttr("content-version", version); err != nil {
+			return err
+		}
+	}
+
+	var propagation, allowedPropagation string
+	_ = plug.Attr("propagation", &propagation)
+	if propagation != "" {
+		_ = slot.Attr("propagation", &allowedPropagation)
+		if propagationLevels[propagation] > propagationLevels[allowedPropagation] {
+			return fmt.Errorf("content slot does not allow %q mount propagation", propagation)
+		}
+	}
+
+	if len(iface.path(slot, "write")) == 0 {
+		return nil
+	}
+	var readOnly, singleWriter bool
+	_ = plug.Attr("read-only", &readOnly)
+	_ = slot.Attr("single-writer", &singleWriter)
+	writable := !readOnly
+	if writable && singleWriter {
+		for _, conn := range slotConns {
+			if writableConnectedPlug(conn.Plug) {
+				writable = false
+				break
+			}
+		}
+	}
+	return plug.SetAttr("writable", writable)
+}
+
+// writableConnectedPlug returns whether the plug was granted write access
+// to the writable paths of the slot. Connections made before the access
+// was negotiated are writable.
+func writableConnectedPlug(plug *interfaces.ConnectedPlug) bool {
+	writable, ok := plug.DynamicAttrs()["writable"].(bool)
+	return !ok || writable
+}
+
 // path is an internal helper that extract the "read" and "write" attribute
 // of the slot
 func (iface *contentInterface) path(attrs interfaces.Attrer, name string) []string {
@@ -199,6 +463,14 @@ func sourceTarget(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot
 	return source, target
 }
 
+// connectedPropagation returns the mount propagation the plug asked for, if
+// any. It was checked against the slot by BeforeConnect.
+func connectedPropagation(plug *interfaces.ConnectedPlug) string {
+	var propagation string
+	_ = plug.Attr("propagation", &propagation)
+	return propagation
+}
+
 func mountEntry(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot, relSrc string, extraOptions ...string) osutil.MountEntry {
 	options := make([]string, 0, len(extraOptions)+1)
 	options = append(options, "bind")
@@ -214,6 +486,13 @@ func mountEntry(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot,
 func (iface *contentInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	contentSnippet := bytes.NewBuffer(nil)
 	writePaths := iface.path(slot, "write")
+	readPaths := iface.path(slot, "read")
+	propagation := connectedPropagation(plug)
+	if !writableConnectedPlug(plug) {
+		// the writable paths are shared read-only with this plug
+		readPaths = append(readPaths, writePaths...)
+		writePaths = nil
+	}
 	if len(writePaths) > 0 {
 		fmt.Fprintf(contentSnippet, `
 # In addition to the bind mount, add any AppArmor rules so that
@@ -229,6 +508,9 @@ func (iface *contentInterface) AppArmorConnectedPlug(spec *apparmor.Specificatio
 			var buf bytes.Buffer
 			fmt.Fprintf(&buf, "  # Read-write content sharing %s -> %s (w#%d)\n", plug.Ref(), slot.Ref(), i)
 			fmt.Fprintf(&buf, "  mount options=(bind, rw) %s/ -> %s/,\n", source, target)
+			if propagation != "" {
+				fmt.Fprintf(&buf, "  mount options=(rw, %s) -> %s/,\n", propagation, target)
+			}
 			fmt.Fprintf(&buf, "  umount %s/,\n", target)
 			// TODO: The assumed prefix depth could be optimized to be more
 			// precise since content sharing can only take place in a fixed
@@ -240,7 +522,6 @@ func (iface *contentInterface) AppArmorConnectedPlug(spec *apparmor.Specificatio
 		}
 	}
 
-	readPaths := iface.path(slot, "read")
 	if len(readPaths) > 0 {
 		fmt.Fprintf(contentSnippet, `
 # In addition to the bind mount, add any AppArmor rules so that
@@ -256,6 +537,9 @@ func (iface *contentInterface) AppArmorConnectedPlug(spec *apparmor.Specificatio
 			fmt.Fprintf(&buf, "  # Read-only content sharing %s -> %s (r#%d)\n", plug.Ref(), slot.Ref(), i)
 			fmt.Fprintf(&buf, "  mount options=(bind) %s/ -> %s/,\n", source, target)
 			fmt.Fprintf(&buf, "  remount options=(bind, ro) %s/,\n", target)
+			if propagation != "" {
+				fmt.Fprintf(&buf, "  mount options=(rw, %s) -> %s/,\n", propagation, target)
+			}
 			fmt.Fprintf(&buf, "  umount %s/,\n", target)
 			// Look at the TODO comment above.
 			apparmor.WritableProfile(&buf, source, 1)
@@ -270,7 +554,10 @@ func (iface *contentInterface) AppArmorConnectedPlug(spec *apparmor.Specificatio
 
 func (iface *contentInterface) AppArmorConnectedSlot(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	contentSnippet := bytes.NewBuffer(nil)
-	writePaths := iface.path(slot, "write")
+	var writePaths []string
+	if writableConnectedPlug(plug) {
+		writePaths = iface.path(slot, "write")
+	}
 	if len(writePaths) > 0 {
 		fmt.Fprintf(contentSnippet, `
 # When the content interface is writable, allow this slot
@@ -290,21 +577,32 @@ func (iface *contentInterface) AppArmorConnectedSlot(spec *apparmor.Specificatio
 }
 
 func (iface *contentInterface) AutoConnect(plug *snap.PlugInfo, slot *snap.SlotInfo) bool {
-	// allow what declarations allowed
-	return true
+	// allow what declarations allowed, as long as the versions are
+	// compatible
+	_, err := ContentVersion(plug, slot)
+	return err == nil
 }
 
 // Interactions with the mount backend.
 
 func (iface *contentInterface) MountConnectedPlug(spec *mount.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	var propagationOptions []string
+	if propagation := connectedPropagation(plug); propagation != "" {
+		propagationOptions = append(propagationOptions, propagation)
+	}
 	for _, r := range iface.path(slot, "read") {
-		err := spec.AddMountEntry(mountEntry(plug, slot, r, "ro"))
+		err := spec.AddMountEntry(mountEntry(plug, slot, r, append([]string{"ro"}, propagationOptions...)...))
 		if err != nil {
 			return err
 		}
 	}
+	var extraOptions []string
+	if !writableConnectedPlug(plug) {
+		extraOptions = append(extraOptions, "ro")
+	}
+	extraOptions = append(extraOptions, propagationOptions...)
 	for _, w := range iface.path(slot, "write") {
-		err := spec.AddMountEntry(mountEntry(plug, slot, w))
+		err := spec.AddMountEntry(mountEntry(plug, slot, w, extraOptions...))
 		if err != nil {
 			return err
 		}
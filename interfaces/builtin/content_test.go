@@ -1092,3 +1092,376 @@ apps:
 `
 	c.Assert(apparmorSpec.SnippetForTag("snap.producer.app"), Equals, expected)
 }
+
+func (s *ContentSuite) TestSanitizeVersionsAndAccess(c *C) {
+	for _, t := range []struct {
+		attrs string
+		err   string
+	}{
+		{`version: "2"`, ""},
+		{"version: 2", `content "version" attribute must be a non-empty string`},
+		{"version: ''", `content "version" attribute must be a non-empty string`},
+		{"version: [2]", `content "version" attribute must be a non-empty string`},
+		{`versions: ["2", "2.1"]`, ""},
+		{"versions: []", `content "versions" attribute must be a non-empty list of strings`},
+		{"versions: [2]", `content "versions" attribute must be a non-empty list of strings`},
+		{"version: '2'\n  versions: ['2']", `content "version" and "versions" attributes cannot be used together`},
+		{"single-writer: true", ""},
+		{"single-writer: yes-please", `content "single-writer" attribute must be a boolean`},
+		{"propagation: rshared", ""},
+		{"propagation: shared", `content "propagation" attribute must be "rslave" or "rshared"`},
+	} {
+		slot := MockSlot(c, `name: producer
+version: 0
+slots:
+ content:
+  write: [export]
+  `+t.attrs+`
+`, nil, "content")
+		err := interfaces.BeforePrepareSlot(s.iface, slot)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf(t.attrs))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf(t.attrs))
+		}
+	}
+
+	for _, t := range []struct {
+		attrs string
+		err   string
+	}{
+		{`versions: ["1", "2"]`, ""},
+		{"versions: [1, 2]", `content "versions" attribute must be a non-empty list of strings`},
+		{"versions: []", `content "versions" attribute must be a non-empty list of strings`},
+		{"versions: 2", `content "versions" attribute must be a non-empty list of strings`},
+		{"versions: ['1', '']", `content "versions" attribute must be a non-empty list of strings`},
+		{`versions: ["1.2..2", "3..", "..0.9"]`, ""},
+		{`versions: [".."]`, `content version range ".." must have at least one bound`},
+		{`versions: ["1..1-2-3"]`, `content version range "1..1-2-3" has invalid bound "1-2-3"`},
+		{`versions: ["2..1"]`, `content version range "2..1" is empty`},
+		{"read-only: true", ""},
+		{"read-only: 1", `content "read-only" attribute must be a boolean`},
+		{"propagation: rslave", ""},
+		{"propagation: [rslave]", `content "propagation" attribute must be "rslave" or "rshared"`},
+	} {
+		plug := MockPlug(c, `name: consumer
+version: 0
+plugs:
+ content:
+  target: import
+  `+t.attrs+`
+`, nil, "content")
+		err := interfaces.BeforePreparePlug(s.iface, plug)
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf(t.attrs))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf(t.attrs))
+		}
+	}
+}
+
+func (s *ContentSuite) TestAutoConnectVersions(c *C) {
+	slot := MockSlot(c, `name: producer
+version: 0
+slots:
+ content:
+  read: [export]
+  version: "2"
+`, nil, "content")
+	unversionedSlot := MockSlot(c, `name: producer
+version: 0
+slots:
+ content:
+  read: [export]
+`, nil, "content")
+	anyPlug := MockPlug(c, `name: consumer
+version: 0
+plugs:
+ content:
+  target: import
+`, nil, "content")
+	plug := MockPlug(c, `name: consumer
+version: 0
+plugs:
+ content:
+  target: import
+  versions: ["1", "2"]
+`, nil, "content")
+	oldPlug := MockPlug(c, `name: consumer
+version: 0
+plugs:
+ content:
+  target: import
+  versions: ["1"]
+`, nil, "content")
+
+	c.Check(s.iface.AutoConnect(anyPlug, slot), Equals, true)
+	c.Check(s.iface.AutoConnect(anyPlug, unversionedSlot), Equals, true)
+	c.Check(s.iface.AutoConnect(plug, slot), Equals, true)
+	c.Check(s.iface.AutoConnect(plug, unversionedSlot), Equals, false)
+	c.Check(s.iface.AutoConnect(oldPlug, slot), Equals, false)
+}
+
+func (s *ContentSuite) TestContentVersion(c *C) {
+	for _, t := range []struct {
+		plugAttrs string
+		slotAttrs string
+		version   string
+		err       string
+	}{
+		{"", "", "", ""},
+		{"", `version: "2"`, "2", ""},
+		{"", `versions: ["2", "10", "3"]`, "10", ""},
+		{`versions: ["1", "2"]`, `version: "2"`, "2", ""},
+		{`versions: ["1..2"]`, `version: "1.5"`, "1.5", ""},
+		{`versions: ["1..2"]`, `versions: ["1.5", "1.9", "2.1"]`, "1.9", ""},
+		{`versions: ["3.."]`, `versions: ["2", "10"]`, "10", ""},
+		{`versions: ["..2"]`, `versions: ["1", "2", "3"]`, "2", ""},
+		{`versions: ["1", "3.."]`, `versions: ["1", "2"]`, "1", ""},
+		{`versions: ["1"]`, "", "", `content version is not declared by the slot, plug supports versions "1"`},
+		{`versions: ["1..2"]`, `version: "3"`, "", `content version "3" is not supported by the plug, plug supports versions "1..2"`},
+		{`versions: ["1..2"]`, `versions: ["3", "4"]`, "", `content versions "3", "4" are not supported by the plug, plug supports versions "1..2"`},
+	} {
+		plug := MockPlug(c, `name: consumer
+version: 0
+plugs:
+ content:
+  target: import
+  `+t.plugAttrs+`
+`, nil, "content")
+		slot := MockSlot(c, `name: producer
+version: 0
+slots:
+ content:
+  read: [export]
+  `+t.slotAttrs+`
+`, nil, "content")
+		comment := Commentf("plug: %s, slot: %s", t.plugAttrs, t.slotAttrs)
+		version, err := builtin.ContentVersion(plug, slot)
+		if t.err == "" {
+			c.Check(err, IsNil, comment)
+			c.Check(version, Equals, t.version, comment)
+			c.Check(s.iface.AutoConnect(plug, slot), Equals, true, comment)
+		} else {
+			c.Check(err, ErrorMatches, t.err, comment)
+			c.Check(s.iface.AutoConnect(plug, slot), Equals, false, comment)
+		}
+	}
+}
+
+func (s *ContentSuite) TestConnectNegotiation(c *C) {
+	repo := interfaces.NewRepository()
+	c.Assert(repo.AddInterface(s.iface), IsNil)
+
+	const producerYaml = `name: producer
+version: 0
+slots:
+ content:
+  write: [export]
+  version: "2"
+  single-writer: true
+`
+	c.Assert(repo.AddSnap(snaptest.MockInfo(c, producerYaml, nil)), IsNil)
+	for _, consumer := range []string{
+		"{name: consumer1, version: 0, plugs: {content: {target: import, versions: ['1', '2']}}}",
+		"{name: consumer2, version: 0, plugs: {content: {target: import}}}",
+		"{name: consumer3, version: 0, plugs: {content: {target: import, versions: ['1']}}}",
+		"{name: consumer4, version: 0, plugs: {content: {target: import, read-only: true}}}",
+	} {
+		c.Assert(repo.AddSnap(snaptest.MockInfo(c, consumer, nil)), IsNil)
+	}
+
+	policyCheck := func(*interfaces.ConnectedPlug, *interfaces.ConnectedSlot) (bool, error) { return true, nil }
+	connect := func(consumer string) (*interfaces.Connection, error) {
+		connRef := interfaces.NewConnRef(repo.Plug(consumer, "content"), repo.Slot("producer", "content"))
+		return repo.Connect(connRef, nil, nil, nil, nil, policyCheck)
+	}
+
+	// the first writer gets write access
+	conn, err := connect("consumer1")
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{
+		"content-version": "2",
+		"writable":        true,
+	})
+
+	// the slot allows only one writer
+	conn, err = connect("consumer2")
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{
+		"content-version": "2",
+		"writable":        false,
+	})
+
+	_, err = connect("consumer3")
+	c.Check(err, ErrorMatches, `cannot connect plug "content" of snap "consumer3" to slot "content" of snap "producer": content version "2" is not supported by the plug, plug supports versions "1"`)
+
+	// once the writer is gone another plug can get write access
+	c.Assert(repo.Disconnect("consumer1", "content", "producer", "content"), IsNil)
+	conn, err = connect("consumer4")
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{
+		"content-version": "2",
+		"writable":        false,
+	})
+	conn, err = connect("consumer1")
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs()["writable"], Equals, true)
+}
+
+func (s *ContentSuite) TestConnectedPlugNegotiatedReadOnly(c *C) {
+	const consumerYaml = `name: consumer
+version: 0
+plugs:
+ content:
+  target: $SNAP_DATA/import
+apps:
+ app:
+  command: foo
+`
+	consumerInfo := snaptest.MockInfo(c, consumerYaml, &snap.SideInfo{Revision: snap.R(7)})
+	plug := interfaces.NewConnectedPlug(consumerInfo.Plugs["content"], nil, map[string]interface{}{"writable": false})
+	const producerYaml = `name: producer
+version: 0
+slots:
+ content:
+  write:
+   - $SNAP_DATA/export
+apps:
+ app:
+  command: bar
+`
+	producerInfo := snaptest.MockInfo(c, producerYaml, &snap.SideInfo{Revision: snap.R(5)})
+	slot := interfaces.NewConnectedSlot(producerInfo.Slots["content"], nil, nil)
+
+	spec := &mount.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, plug, slot), IsNil)
+	c.Assert(spec.MountEntries(), DeepEquals, []osutil.MountEntry{{
+		Name:    "/var/snap/producer/5/export",
+		Dir:     "/var/snap/consumer/7/import",
+		Options: []string{"bind", "ro"},
+	}})
+
+	apparmorSpec := &apparmor.Specification{}
+	c.Assert(apparmorSpec.AddConnectedPlug(s.iface, plug, slot), IsNil)
+	c.Check(apparmorSpec.SnippetForTag("snap.consumer.app"), Equals, `
+# In addition to the bind mount, add any AppArmor rules so that
+# snaps may directly access the slot implementation's files
+# read-only.
+/var/snap/producer/5/export/** mrkix,
+`)
+	c.Check(apparmorSpec.UpdateNS(), DeepEquals, []string{`  # Read-only content sharing consumer:content -> producer:content (r#0)
+  mount options=(bind) /var/snap/producer/5/export/ -> /var/snap/consumer/7/import/,
+  remount options=(bind, ro) /var/snap/consumer/7/import/,
+  umount /var/snap/consumer/7/import/,
+  # Writable directory /var/snap/producer/5/export
+  /var/snap/producer/5/export/ rw,
+  /var/snap/producer/5/ rw,
+  /var/snap/producer/ rw,
+  # Writable directory /var/snap/consumer/7/import
+  /var/snap/consumer/7/import/ rw,
+  /var/snap/consumer/7/ rw,
+  /var/snap/consumer/ rw,
+`})
+
+	// the slot does not get access to the read-only mountpoint
+	apparmorSpec = &apparmor.Specification{}
+	c.Assert(apparmorSpec.AddConnectedSlot(s.iface, plug, slot), IsNil)
+	c.Check(apparmorSpec.SnippetForTag("snap.producer.app"), Equals, "")
+}
+
+func (s *ContentSuite) TestConnectPropagation(c *C) {
+	repo := interfaces.NewRepository()
+	c.Assert(repo.AddInterface(s.iface), IsNil)
+
+	for _, producer := range []string{
+		"{name: producer1, version: 0, slots: {content: {read: [export]}}}",
+		"{name: producer2, version: 0, slots: {content: {read: [export], propagation: rslave}}}",
+		"{name: producer3, version: 0, slots: {content: {read: [export], propagation: rshared}}}",
+	} {
+		c.Assert(repo.AddSnap(snaptest.MockInfo(c, producer, nil)), IsNil)
+	}
+	for _, consumer := range []string{
+		"{name: consumer1, version: 0, plugs: {content: {target: import}}}",
+		"{name: consumer2, version: 0, plugs: {content: {target: import, propagation: rslave}}}",
+		"{name: consumer3, version: 0, plugs: {content: {target: import, propagation: rshared}}}",
+	} {
+		c.Assert(repo.AddSnap(snaptest.MockInfo(c, consumer, nil)), IsNil)
+	}
+
+	policyCheck := func(*interfaces.ConnectedPlug, *interfaces.ConnectedSlot) (bool, error) { return true, nil }
+	for _, t := range []struct {
+		consumer, producer string
+		err                string
+	}{
+		{"consumer1", "producer1", ""},
+		{"consumer2", "producer1", `.*: content slot does not allow "rslave" mount propagation`},
+		{"consumer2", "producer2", ""},
+		{"consumer2", "producer3", ""},
+		{"consumer3", "producer2", `.*: content slot does not allow "rshared" mount propagation`},
+		{"consumer3", "producer3", ""},
+	} {
+		comment := Commentf("%s -> %s", t.consumer, t.producer)
+		connRef := interfaces.NewConnRef(repo.Plug(t.consumer, "content"), repo.Slot(t.producer, "content"))
+		_, err := repo.Connect(connRef, nil, nil, nil, nil, policyCheck)
+		if t.err == "" {
+			c.Check(err, IsNil, comment)
+			c.Check(repo.Disconnect(t.consumer, "content", t.producer, "content"), IsNil, comment)
+		} else {
+			c.Check(err, ErrorMatches, t.err, comment)
+		}
+	}
+}
+
+func (s *ContentSuite) TestConnectedPlugPropagation(c *C) {
+	const consumerYaml = `name: consumer
+version: 0
+plugs:
+ content:
+  target: $SNAP_DATA/import
+  propagation: rslave
+apps:
+ app:
+  command: foo
+`
+	consumerInfo := snaptest.MockInfo(c, consumerYaml, &snap.SideInfo{Revision: snap.R(7)})
+	plug := interfaces.NewConnectedPlug(consumerInfo.Plugs["content"], nil, map[string]interface{}{"writable": false})
+	const producerYaml = `name: producer
+version: 0
+slots:
+ content:
+  write:
+   - $SNAP_DATA/export
+  propagation: rshared
+apps:
+ app:
+  command: bar
+`
+	producerInfo := snaptest.MockInfo(c, producerYaml, &snap.SideInfo{Revision: snap.R(5)})
+	slot := interfaces.NewConnectedSlot(producerInfo.Slots["content"], nil, nil)
+
+	spec := &mount.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, plug, slot), IsNil)
+	c.Assert(spec.MountEntries(), DeepEquals, []osutil.MountEntry{{
+		Name:    "/var/snap/producer/5/export",
+		Dir:     "/var/snap/consumer/7/import",
+		Options: []string{"bind", "ro", "rslave"},
+	}})
+
+	apparmorSpec := &apparmor.Specification{}
+	c.Assert(apparmorSpec.AddConnectedPlug(s.iface, plug, slot), IsNil)
+	c.Check(apparmorSpec.UpdateNS(), DeepEquals, []string{`  # Read-only content sharing consumer:content -> producer:content (r#0)
+  mount options=(bind) /var/snap/producer/5/export/ -> /var/snap/consumer/7/import/,
+  remount options=(bind, ro) /var/snap/consumer/7/import/,
+  mount options=(rw, rslave) -> /var/snap/consumer/7/import/,
+  umount /var/snap/consumer/7/import/,
+  # Writable directory /var/snap/producer/5/export
+  /var/snap/producer/5/export/ rw,
+  /var/snap/producer/5/ rw,
+  /var/snap/producer/ rw,
+  # Writable directory /var/snap/consumer/7/import
+  /var/snap/consumer/7/import/ rw,
+  /var/snap/consumer/7/ rw,
+  /var/snap/consumer/ rw,
+`})
+}
@@ -148,6 +148,18 @@ socket AF_NETLINK - NETLINK_KOBJECT_UEVENT
 bind
 `
 
+const hardwareObserveConnectedPlugSELinux = `
+; Description: Can get hardware information from the OS.
+(allow ###DOMAIN### sysfs_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (file (getattr open read)))
+(allow ###DOMAIN### sysfs_t (lnk_file (getattr read)))
+(allow ###DOMAIN### device_t (dir (getattr open read search)))
+(allow ###DOMAIN### udev_var_run_t (dir (getattr open read search)))
+(allow ###DOMAIN### udev_var_run_t (file (getattr open read)))
+(allow ###DOMAIN### self (netlink_kobject_uevent_socket (create bind getattr setopt read)))
+(allow ###DOMAIN### self (capability (sys_rawio)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "hardware-observe",
@@ -157,6 +169,7 @@ func init() {
 		baseDeclarationSlots:  hardwareObserveBaseDeclarationSlots,
 		connectedPlugAppArmor: hardwareObserveConnectedPlugAppArmor,
 		connectedPlugSecComp:  hardwareObserveConnectedPlugSecComp,
+		connectedPlugSELinux:  hardwareObserveConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
 	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/selinux"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
@@ -98,6 +99,14 @@ func (s *HardwareObserveInterfaceSuite) TestUsedSecuritySystems(c *C) {
 	c.Assert(seccompSpec.SecurityTags(), DeepEquals, []string{"snap.other.app2"})
 	c.Check(seccompSpec.SnippetForTag("snap.other.app2"), testutil.Contains, "iopl\n")
 	c.Check(seccompSpec.SnippetForTag("snap.other.app2"), testutil.Contains, "socket AF_NETLINK - NETLINK_KOBJECT_UEVENT\n")
+
+	// connected plugs have a non-nil security snippet for selinux
+	selinuxSpec := &selinux.Specification{}
+	err = selinuxSpec.AddConnectedPlug(s.iface, s.plug, s.slot)
+	c.Assert(err, IsNil)
+	c.Assert(selinuxSpec.SecurityTags(), DeepEquals, []string{"snap.other.app2"})
+	c.Check(selinuxSpec.SnippetForTag("snap.other.app2"), testutil.Contains, "(allow snap_other__app2_t sysfs_t (file (getattr open read)))\n")
+	c.Check(selinuxSpec.MissingPolicyForTag("snap.other.app2"), HasLen, 0)
 }
 
 func (s *HardwareObserveInterfaceSuite) TestInterfaces(c *C) {
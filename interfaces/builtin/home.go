@@ -120,6 +120,17 @@ func (iface *homeInterface) AppArmorConnectedPlug(spec *apparmor.Specification,
 	return nil
 }
 
+// Unlike the AppArmor policy, SELinux cannot tell hidden files apart so
+// access to them is not restricted.
+const homeConnectedPlugSELinux = `
+; Description: Can access files in user's $HOME.
+(allow ###DOMAIN### home_root_t (dir (getattr search)))
+(allow ###DOMAIN### user_home_dir_t (dir (getattr open read search write add_name remove_name)))
+(allow ###DOMAIN### user_home_t (dir (getattr open read search write add_name remove_name create rmdir rename setattr)))
+(allow ###DOMAIN### user_home_t (file (getattr open read write append create unlink rename setattr lock map)))
+(allow ###DOMAIN### user_home_t (lnk_file (getattr read)))
+`
+
 func init() {
 	registerIface(&homeInterface{commonInterface{
 		name:                 "home",
@@ -127,6 +138,7 @@ func init() {
 		implicitOnCore:       true,
 		implicitOnClassic:    true,
 		baseDeclarationSlots: homeBaseDeclarationSlots,
+		connectedPlugSELinux: homeConnectedPlugSELinux,
 		reservedForOS:        true,
 	}})
 }
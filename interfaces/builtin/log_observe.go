@@ -61,6 +61,15 @@ const logObserveConnectedPlugAppArmor = `
 capability dac_override,
 `
 
+const logObserveConnectedPlugSELinux = `
+; Description: Can read system logs.
+(allow ###DOMAIN### var_log_t (dir (getattr open read search)))
+(allow ###DOMAIN### var_log_t (file (getattr open read)))
+(allow ###DOMAIN### var_log_t (lnk_file (getattr read)))
+(allow ###DOMAIN### syslogd_var_run_t (dir (getattr open read search)))
+(allow ###DOMAIN### syslogd_var_run_t (file (getattr open read map)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "log-observe",
@@ -69,6 +78,7 @@ func init() {
 		implicitOnClassic:     true,
 		baseDeclarationSlots:  logObserveBaseDeclarationSlots,
 		connectedPlugAppArmor: logObserveConnectedPlugAppArmor,
+		connectedPlugSELinux:  logObserveConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
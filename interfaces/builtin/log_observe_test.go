@@ -25,6 +25,7 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/apparmor"
 	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/selinux"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
@@ -87,6 +88,14 @@ func (s *LogObserveInterfaceSuite) TestUsedSecuritySystems(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(apparmorSpec.SecurityTags(), DeepEquals, []string{"snap.other.app"})
 	c.Assert(apparmorSpec.SnippetForTag("snap.other.app"), testutil.Contains, "/var/log/")
+
+	// connected plugs have a non-nil security snippet for selinux
+	selinuxSpec := &selinux.Specification{}
+	err = selinuxSpec.AddConnectedPlug(s.iface, s.plug, s.slot)
+	c.Assert(err, IsNil)
+	c.Assert(selinuxSpec.SecurityTags(), DeepEquals, []string{"snap.other.app"})
+	c.Check(selinuxSpec.SnippetForTag("snap.other.app"), testutil.Contains, "(allow snap_other__app_t var_log_t (file (getattr open read)))\n")
+	c.Check(selinuxSpec.MissingPolicyForTag("snap.other.app"), HasLen, 0)
 }
 
 func (s *LogObserveInterfaceSuite) TestInterfaces(c *C) {
@@ -78,6 +78,15 @@ socket AF_NETLINK - NETLINK_ROUTE
 socket AF_CONN
 `
 
+const networkConnectedPlugSELinux = `
+; Description: Can access the network as a client.
+(allow ###DOMAIN### self (tcp_socket (create connect getattr getopt setopt read write shutdown)))
+(allow ###DOMAIN### self (udp_socket (create connect getattr getopt setopt read write)))
+(allow ###DOMAIN### self (netlink_route_socket (create bind getattr read write nlmsg_read)))
+(allow ###DOMAIN### port_type (tcp_socket (name_connect)))
+(allow ###DOMAIN### net_conf_t (file (getattr open read)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "network",
@@ -87,6 +96,7 @@ func init() {
 		baseDeclarationSlots:  networkBaseDeclarationSlots,
 		connectedPlugAppArmor: networkConnectedPlugAppArmor,
 		connectedPlugSecComp:  networkConnectedPlugSecComp,
+		connectedPlugSELinux:  networkConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
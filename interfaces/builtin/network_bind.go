@@ -94,6 +94,17 @@ listen
 socket AF_NETLINK - NETLINK_ROUTE
 `
 
+const networkBindConnectedPlugSELinux = `
+; Description: Can access the network as a server.
+(allow ###DOMAIN### self (tcp_socket (create bind listen accept getattr getopt setopt read write shutdown)))
+(allow ###DOMAIN### self (udp_socket (create bind getattr getopt setopt read write)))
+(allow ###DOMAIN### port_type (tcp_socket (name_bind)))
+(allow ###DOMAIN### port_type (udp_socket (name_bind)))
+(allow ###DOMAIN### node_t (tcp_socket (node_bind)))
+(allow ###DOMAIN### node_t (udp_socket (node_bind)))
+(allow ###DOMAIN### net_conf_t (file (getattr open read)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "network-bind",
@@ -103,6 +114,7 @@ func init() {
 		baseDeclarationSlots:  networkBindBaseDeclarationSlots,
 		connectedPlugAppArmor: networkBindConnectedPlugAppArmor,
 		connectedPlugSecComp:  networkBindConnectedPlugSecComp,
+		connectedPlugSELinux:  networkBindConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
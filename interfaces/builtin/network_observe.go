@@ -150,6 +150,17 @@ socket AF_NETLINK - NETLINK_GENERIC
 socket AF_NETLINK - NETLINK_KOBJECT_UEVENT
 `
 
+const networkObserveConnectedPlugSELinux = `
+; Description: Can query network status information.
+(allow ###DOMAIN### self (netlink_route_socket (create bind getattr read write nlmsg_read)))
+(allow ###DOMAIN### proc_net_t (dir (getattr open read search)))
+(allow ###DOMAIN### proc_net_t (file (getattr open read)))
+(allow ###DOMAIN### proc_net_t (lnk_file (getattr read)))
+(allow ###DOMAIN### sysfs_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (file (getattr open read)))
+(allow ###DOMAIN### sysfs_t (lnk_file (getattr read)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "network-observe",
@@ -159,6 +170,7 @@ func init() {
 		baseDeclarationSlots:  networkObserveBaseDeclarationSlots,
 		connectedPlugAppArmor: networkObserveConnectedPlugAppArmor,
 		connectedPlugSecComp:  networkObserveConnectedPlugSecComp,
+		connectedPlugSELinux:  networkObserveConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
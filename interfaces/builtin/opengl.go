@@ -123,6 +123,14 @@ var openglConnectedPlugUDev = []string{
 	`KERNEL=="nvmap"`,
 }
 
+const openglConnectedPlugSELinux = `
+; Description: Can access opengl.
+(allow ###DOMAIN### device_t (dir (getattr open read search)))
+(allow ###DOMAIN### dri_device_t (chr_file (getattr open read write ioctl map)))
+; nvidia
+(allow ###DOMAIN### xserver_misc_device_t (chr_file (getattr open read write ioctl map)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "opengl",
@@ -132,6 +140,7 @@ func init() {
 		baseDeclarationSlots:  openglBaseDeclarationSlots,
 		connectedPlugAppArmor: openglConnectedPlugAppArmor,
 		connectedPlugUDev:     openglConnectedPlugUDev,
+		connectedPlugSELinux:  openglConnectedPlugSELinux,
 		reservedForOS:         true,
 	})
 }
@@ -19,13 +19,25 @@
 
 package builtin
 
-const rawusbSummary = `allows raw access to all USB devices`
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snap"
+)
+
+const rawusbSummary = `allows raw access to all or specific USB devices`
 
 const rawusbBaseDeclarationSlots = `
   raw-usb:
     allow-installation:
       slot-snap-type:
         - core
+        - gadget
     deny-auto-connection: true
 `
 
@@ -56,13 +68,114 @@ const rawusbConnectedPlugSecComp = `
 socket AF_NETLINK - NETLINK_KOBJECT_UEVENT
 `
 
+const rawusbDevicesConnectedPlugAppArmor = `
+# Description: Allow raw access to the USB devices listed by the slot.
+# The device cgroup, which snap-confine applies whenever the udev rules
+# tag devices for the app, even if none is plugged in, restricts the
+# device nodes down to the listed devices.
+/dev/bus/usb/[0-9][0-9][0-9]/[0-9][0-9][0-9] rw,
+/dev/tty{USB,ACM}[0-9]* rw,
+
+# Allow detection of usb devices. Leaks plugged in USB device info
+/sys/bus/usb/devices/ r,
+/sys/devices/pci**/usb[0-9]** r,
+/sys/devices/platform/soc/*.usb/usb[0-9]** r,
+
+/run/udev/data/c16[67]:[0-9] r, # ACM USB modems
+/run/udev/data/c18[089]:* r, # various USB character devices: USB serial converters, etc.
+/run/udev/data/+usb:* r,
+`
+
 var rawusbConnectedPlugUDev = []string{
 	`SUBSYSTEM=="usb"`,
 	`SUBSYSTEM=="tty", ENV{ID_BUS}=="usb"`,
 }
 
+// Pattern of the entries of the usb-devices slot attribute, the vendor
+// and product identifiers as shown by lsusb
+var rawusbDevicePattern = regexp.MustCompile("^[0-9a-f]{4}:[0-9a-f]{4}$")
+
+// rawusbInterface is the type of the raw-usb interface. Its slots can
+// narrow down the access to the USB devices listed in their usb-devices
+// attribute, slots of gadget snaps must do so.
+type rawusbInterface struct {
+	commonInterface
+}
+
+// usbDevices returns the vendor and product identifiers of the USB
+// devices the slot grants access to, or nil if it grants access to all of
+// them.
+func (iface *rawusbInterface) usbDevices(attrs interfaces.Attrer) ([][2]string, error) {
+	v, ok := attrs.Lookup("usb-devices")
+	if !ok {
+		return nil, nil
+	}
+	entries, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf(`raw-usb "usb-devices" attribute must be a list of strings`)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf(`raw-usb "usb-devices" attribute cannot be empty`)
+	}
+	devices := make([][2]string, 0, len(entries))
+	for _, entry := range entries {
+		device, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf(`raw-usb "usb-devices" attribute must be a list of strings`)
+		}
+		if !rawusbDevicePattern.MatchString(device) {
+			return nil, fmt.Errorf(`raw-usb "usb-devices" entry %q is not of the form "vendor:product" with lowercase hexadecimal identifiers`, device)
+		}
+		ids := strings.SplitN(device, ":", 2)
+		devices = append(devices, [2]string{ids[0], ids[1]})
+	}
+	return devices, nil
+}
+
+// BeforePrepareSlot checks validity of the defined slot
+func (iface *rawusbInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
+	if err := sanitizeSlotReservedForOSOrGadget(iface, slot); err != nil {
+		return err
+	}
+	devices, err := iface.usbDevices(slot)
+	if err != nil {
+		return err
+	}
+	if devices == nil && slot.Snap.GetType() == snap.TypeGadget {
+		return fmt.Errorf(`raw-usb slots of gadget snaps must list the USB devices they grant access to in the "usb-devices" attribute`)
+	}
+	return nil
+}
+
+func (iface *rawusbInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	devices, err := iface.usbDevices(slot)
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		return iface.commonInterface.AppArmorConnectedPlug(spec, plug, slot)
+	}
+	spec.AddSnippet(rawusbDevicesConnectedPlugAppArmor)
+	return nil
+}
+
+func (iface *rawusbInterface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	devices, err := iface.usbDevices(slot)
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		return iface.commonInterface.UDevConnectedPlug(spec, plug, slot)
+	}
+	for _, ids := range devices {
+		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="usb", ATTR{idVendor}=="%s", ATTR{idProduct}=="%s"`, ids[0], ids[1]))
+		spec.TagDevice(fmt.Sprintf(`SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="%s", ATTRS{idProduct}=="%s"`, ids[0], ids[1]))
+	}
+	return nil
+}
+
 func init() {
-	registerIface(&commonInterface{
+	registerIface(&rawusbInterface{commonInterface{
 		name:                  "raw-usb",
 		summary:               rawusbSummary,
 		implicitOnCore:        true,
@@ -71,6 +184,5 @@ func init() {
 		connectedPlugAppArmor: rawusbConnectedPlugAppArmor,
 		connectedPlugSecComp:  rawusbConnectedPlugSecComp,
 		connectedPlugUDev:     rawusbConnectedPlugUDev,
-		reservedForOS:         true,
-	})
+	}})
 }
@@ -20,6 +20,8 @@
 package builtin_test
 
 import (
+	"strings"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/interfaces"
@@ -28,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/seccomp"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -57,6 +60,14 @@ slots:
   raw-usb:
 `
 
+const rawusbGadgetYaml = `name: gadget
+version: 0
+type: gadget
+slots:
+  raw-usb:
+    usb-devices: ["0403:6001", "04d8:000a"]
+`
+
 func (s *RawUsbInterfaceSuite) SetUpTest(c *C) {
 	s.plug, s.plugInfo = MockConnectedPlug(c, rawusbConsumerYaml, nil, "raw-usb")
 	s.slot, s.slotInfo = MockConnectedSlot(c, rawusbCoreYaml, nil, "raw-usb")
@@ -74,7 +85,38 @@ func (s *RawUsbInterfaceSuite) TestSanitizeSlot(c *C) {
 		Interface: "raw-usb",
 	}
 	c.Assert(interfaces.BeforePrepareSlot(s.iface, slot), ErrorMatches,
-		"raw-usb slots are reserved for the core snap")
+		"raw-usb slots are reserved for the core and gadget snaps")
+}
+
+func (s *RawUsbInterfaceSuite) TestSanitizeGadgetSlot(c *C) {
+	_, slotInfo := MockConnectedSlot(c, rawusbGadgetYaml, nil, "raw-usb")
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, slotInfo), IsNil)
+
+	const mockSnapYaml = `name: gadget
+version: 0
+type: gadget
+slots:
+ raw-usb:
+  $t
+`
+	var testCases = []struct {
+		inp    string
+		errStr string
+	}{
+		{`interface: raw-usb`, `raw-usb slots of gadget snaps must list the USB devices they grant access to in the "usb-devices" attribute`},
+		{`usb-devices: []`, `raw-usb "usb-devices" attribute cannot be empty`},
+		{`usb-devices: 0403:6001`, `raw-usb "usb-devices" attribute must be a list of strings`},
+		{`usb-devices: [ 1234 ]`, `raw-usb "usb-devices" attribute must be a list of strings`},
+		{`usb-devices: [ "0403" ]`, `raw-usb "usb-devices" entry "0403" is not of the form "vendor:product" with lowercase hexadecimal identifiers`},
+		{`usb-devices: [ "0403:6001:1" ]`, `raw-usb "usb-devices" entry "0403:6001:1" is not .*`},
+		{`usb-devices: [ "04D8:000A" ]`, `raw-usb "usb-devices" entry "04D8:000A" is not .*`},
+	}
+	for _, t := range testCases {
+		yml := strings.Replace(mockSnapYaml, "$t", t.inp, -1)
+		info := snaptest.MockInfo(c, yml, nil)
+		slot := info.Slots["raw-usb"]
+		c.Check(interfaces.BeforePrepareSlot(s.iface, slot), ErrorMatches, t.errStr, Commentf("unexpected error for %q", t.inp))
+	}
 }
 
 func (s *RawUsbInterfaceSuite) TestSanitizePlug(c *C) {
@@ -86,6 +128,16 @@ func (s *RawUsbInterfaceSuite) TestAppArmorSpec(c *C) {
 	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
 	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
 	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, `/sys/bus/usb/devices/`)
+	c.Assert(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, `Allow raw access to all connected USB devices`)
+}
+
+func (s *RawUsbInterfaceSuite) TestAppArmorSpecUSBDevices(c *C) {
+	slot, _ := MockConnectedSlot(c, rawusbGadgetYaml, nil, "raw-usb")
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, slot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, `Allow raw access to the USB devices listed by the slot`)
+	c.Check(spec.SnippetForTag("snap.consumer.app"), Not(testutil.Contains), `/run/udev/data/b180:*`)
 }
 
 func (s *RawUsbInterfaceSuite) TestSecCompSpec(c *C) {
@@ -106,11 +158,28 @@ SUBSYSTEM=="tty", ENV{ID_BUS}=="usb", TAG+="snap_consumer_app"`)
 	c.Assert(spec.Snippets(), testutil.Contains, `TAG=="snap_consumer_app", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_consumer_app $devpath $major:$minor"`)
 }
 
+func (s *RawUsbInterfaceSuite) TestUDevSpecUSBDevices(c *C) {
+	slot, _ := MockConnectedSlot(c, rawusbGadgetYaml, nil, "raw-usb")
+	spec := &udev.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, slot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 5)
+	c.Check(spec.Snippets(), testutil.Contains, `# raw-usb
+SUBSYSTEM=="usb", ATTR{idVendor}=="0403", ATTR{idProduct}=="6001", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), testutil.Contains, `# raw-usb
+SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="0403", ATTRS{idProduct}=="6001", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), testutil.Contains, `# raw-usb
+SUBSYSTEM=="usb", ATTR{idVendor}=="04d8", ATTR{idProduct}=="000a", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), testutil.Contains, `# raw-usb
+SUBSYSTEM=="tty", SUBSYSTEMS=="usb", ATTRS{idVendor}=="04d8", ATTRS{idProduct}=="000a", TAG+="snap_consumer_app"`)
+	c.Check(spec.Snippets(), Not(testutil.Contains), `# raw-usb
+SUBSYSTEM=="usb", TAG+="snap_consumer_app"`)
+}
+
 func (s *RawUsbInterfaceSuite) TestStaticInfo(c *C) {
 	si := interfaces.StaticInfoOf(s.iface)
 	c.Assert(si.ImplicitOnCore, Equals, true)
 	c.Assert(si.ImplicitOnClassic, Equals, true)
-	c.Assert(si.Summary, Equals, `allows raw access to all USB devices`)
+	c.Assert(si.Summary, Equals, `allows raw access to all or specific USB devices`)
 	c.Assert(si.BaseDeclarationSlots, testutil.Contains, "raw-usb")
 }
 
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/snap"
+)
+
+const seccompOverridesSummary = `allows using reviewed syscalls denied by the default seccomp policy`
+
+// The plug is granted per snap by the store, possibly constraining the
+// "syscalls" and "deny" attributes. The overrides of a plug are only
+// applied to its apps and hooks while it is connected, which the system
+// administrator does explicitly for each snap, and once the system
+// administrator enabled experimental.seccomp-overrides.
+const seccompOverridesBaseDeclarationPlugs = `
+  seccomp-overrides:
+    allow-installation: false
+    deny-auto-connection: true
+`
+
+const seccompOverridesBaseDeclarationSlots = `
+  seccomp-overrides:
+    allow-installation:
+      slot-snap-type:
+        - core
+    deny-auto-connection: true
+`
+
+type seccompOverridesInterface struct {
+	commonInterface
+}
+
+// seccompOverridesAttr returns the syscalls listed in the given attribute
+// of a seccomp-overrides plug.
+func seccompOverridesAttr(attrs interfaces.Attrer, name string) ([]string, error) {
+	value, ok := attrs.Lookup(name)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%q must be a list of strings", name)
+	}
+	syscalls := make([]string, 0, len(list))
+	for _, v := range list {
+		syscall, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q must be a list of strings", name)
+		}
+		syscalls = append(syscalls, syscall)
+	}
+	return syscalls, nil
+}
+
+func (iface *seccompOverridesInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	syscalls, err := seccompOverridesAttr(plug, "syscalls")
+	if err != nil {
+		return fmt.Errorf("cannot add seccomp-overrides plug: %v", err)
+	}
+	denials, err := seccompOverridesAttr(plug, "deny")
+	if err != nil {
+		return fmt.Errorf("cannot add seccomp-overrides plug: %v", err)
+	}
+	if len(syscalls) == 0 && len(denials) == 0 {
+		return fmt.Errorf(`cannot add seccomp-overrides plug: "syscalls" or "deny" must list at least one syscall`)
+	}
+	for _, syscall := range syscalls {
+		if err := seccomp.ValidateOverride(syscall); err != nil {
+			return fmt.Errorf("cannot add seccomp-overrides plug: %v", err)
+		}
+	}
+	for _, syscall := range denials {
+		if err := seccomp.ValidateDenial(syscall); err != nil {
+			return fmt.Errorf("cannot add seccomp-overrides plug: %v", err)
+		}
+	}
+	return nil
+}
+
+func (iface *seccompOverridesInterface) SecCompConnectedPlug(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	syscalls, err := seccompOverridesAttr(plug, "syscalls")
+	if err != nil {
+		return fmt.Errorf("cannot connect plug %s: %v", plug.Name(), err)
+	}
+	denials, err := seccompOverridesAttr(plug, "deny")
+	if err != nil {
+		return fmt.Errorf("cannot connect plug %s: %v", plug.Name(), err)
+	}
+	for _, syscall := range syscalls {
+		if err := spec.AddOverride(syscall); err != nil {
+			return fmt.Errorf("cannot connect plug %s: %v", plug.Name(), err)
+		}
+	}
+	for _, syscall := range denials {
+		if err := spec.AddDenial(syscall); err != nil {
+			return fmt.Errorf("cannot connect plug %s: %v", plug.Name(), err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerIface(&seccompOverridesInterface{commonInterface{
+		name:                 "seccomp-overrides",
+		summary:              seccompOverridesSummary,
+		implicitOnCore:       true,
+		implicitOnClassic:    true,
+		baseDeclarationPlugs: seccompOverridesBaseDeclarationPlugs,
+		baseDeclarationSlots: seccompOverridesBaseDeclarationSlots,
+		reservedForOS:        true,
+	}})
+}
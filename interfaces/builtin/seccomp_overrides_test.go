@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin_test
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/builtin"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type seccompOverridesInterfaceSuite struct {
+	iface    interfaces.Interface
+	slot     *interfaces.ConnectedSlot
+	slotInfo *snap.SlotInfo
+	plug     *interfaces.ConnectedPlug
+	plugInfo *snap.PlugInfo
+}
+
+var _ = Suite(&seccompOverridesInterfaceSuite{
+	iface: builtin.MustInterface("seccomp-overrides"),
+})
+
+const seccompOverridesConsumerYaml = `name: consumer
+version: 0
+plugs:
+ seccomp-overrides:
+  syscalls: [userfaultfd, kcmp]
+  deny: [ptrace]
+apps:
+ app:
+  plugs: [seccomp-overrides]
+`
+
+const seccompOverridesCoreYaml = `name: core
+version: 0
+type: os
+slots:
+  seccomp-overrides:
+`
+
+func (s *seccompOverridesInterfaceSuite) SetUpTest(c *C) {
+	s.plug, s.plugInfo = MockConnectedPlug(c, seccompOverridesConsumerYaml, nil, "seccomp-overrides")
+	s.slot, s.slotInfo = MockConnectedSlot(c, seccompOverridesCoreYaml, nil, "seccomp-overrides")
+}
+
+func (s *seccompOverridesInterfaceSuite) TestName(c *C) {
+	c.Assert(s.iface.Name(), Equals, "seccomp-overrides")
+}
+
+func (s *seccompOverridesInterfaceSuite) TestSanitizeSlot(c *C) {
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, s.slotInfo), IsNil)
+	slot := &snap.SlotInfo{
+		Snap:      &snap.Info{SuggestedName: "some-snap"},
+		Name:      "seccomp-overrides",
+		Interface: "seccomp-overrides",
+	}
+	c.Assert(interfaces.BeforePrepareSlot(s.iface, slot), ErrorMatches,
+		"seccomp-overrides slots are reserved for the core snap")
+}
+
+func (s *seccompOverridesInterfaceSuite) TestSanitizePlug(c *C) {
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+}
+
+func (s *seccompOverridesInterfaceSuite) TestSanitizePlugUnhappy(c *C) {
+	const mockSnapYaml = `name: consumer
+version: 0
+plugs:
+ seccomp-overrides:
+  $t
+`
+	var testCases = []struct {
+		inp    string
+		errStr string
+	}{
+		{`syscalls: []`, `"syscalls" or "deny" must list at least one syscall`},
+		{`syscalls: kcmp`, `"syscalls" must be a list of strings`},
+		{`syscalls: [ 123 ]`, `"syscalls" must be a list of strings`},
+		{`syscalls: [ kcmp, ptrace ]`, `"ptrace" is not a reviewed seccomp override`},
+		{`deny: ptrace`, `"deny" must be a list of strings`},
+		{`deny: [ "ptrace 0" ]`, `invalid syscall name "ptrace 0"`},
+		{`foo: bar`, `"syscalls" or "deny" must list at least one syscall`},
+	}
+
+	for _, t := range testCases {
+		yml := strings.Replace(mockSnapYaml, "$t", t.inp, -1)
+		info := snaptest.MockInfo(c, yml, nil)
+		plug := info.Plugs["seccomp-overrides"]
+
+		c.Check(interfaces.BeforePreparePlug(s.iface, plug), ErrorMatches, "cannot add seccomp-overrides plug: "+t.errStr, Commentf("unexpected error for %q", t.inp))
+	}
+}
+
+func (s *seccompOverridesInterfaceSuite) TestSecCompSpec(c *C) {
+	spec := &seccomp.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Check(spec.OverridesForTag("snap.consumer.app"), DeepEquals, []string{"kcmp", "userfaultfd"})
+	c.Check(spec.DenialsForTag("snap.consumer.app"), DeepEquals, []string{"ptrace"})
+	// the overrides are not regular snippets
+	c.Check(spec.SecurityTags(), HasLen, 0)
+}
+
+func (s *seccompOverridesInterfaceSuite) TestStaticInfo(c *C) {
+	si := interfaces.StaticInfoOf(s.iface)
+	c.Assert(si.ImplicitOnCore, Equals, true)
+	c.Assert(si.ImplicitOnClassic, Equals, true)
+	c.Assert(si.Summary, Equals, `allows using reviewed syscalls denied by the default seccomp policy`)
+	c.Assert(si.BaseDeclarationPlugs, testutil.Contains, "seccomp-overrides")
+	c.Assert(si.BaseDeclarationSlots, testutil.Contains, "seccomp-overrides")
+}
+
+func (s *seccompOverridesInterfaceSuite) TestInterfaces(c *C) {
+	c.Check(builtin.Interfaces(), testutil.DeepContains, s.iface)
+}
@@ -230,6 +230,12 @@ func (iface *serialPortInterface) HotplugDeviceDetected(di *hotplug.HotplugDevic
 	return &slot, nil
 }
 
+// HotplugWatchedAttributes returns the attributes the proposed slot is made
+// of, the slot is updated when udev reports a change of any of them.
+func (iface *serialPortInterface) HotplugWatchedAttributes() []string {
+	return []string{"DEVNAME", "ID_VENDOR_ID", "ID_MODEL_ID"}
+}
+
 func slotDeviceAttrEqual(di *hotplug.HotplugDeviceInfo, devinfoAttribute string, slotAttributeValue int64) bool {
 	var attr string
 	var ok bool
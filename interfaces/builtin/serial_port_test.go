@@ -570,6 +570,11 @@ func (s *SerialPortInterfaceSuite) TestHotplugDeviceDetectedNotSerialPort(c *C)
 	c.Assert(proposedSlot, IsNil)
 }
 
+func (s *SerialPortInterfaceSuite) TestHotplugWatchedAttributes(c *C) {
+	watcher := s.iface.(hotplug.AttributeWatcher)
+	c.Check(watcher.HotplugWatchedAttributes(), DeepEquals, []string{"DEVNAME", "ID_VENDOR_ID", "ID_MODEL_ID"})
+}
+
 func (s *SerialPortInterfaceSuite) TestHotplugHandledByGadget(c *C) {
 	byGadgetPred := s.iface.(hotplug.HandledByGadgetPredicate)
 	di, err := hotplug.NewHotplugDeviceInfo(map[string]string{"DEVPATH": "/sys/foo/bar", "DEVNAME": "/dev/ttyXRUSB0", "ACTION": "add", "SUBSYSTEM": "tty", "ID_BUS": "usb"})
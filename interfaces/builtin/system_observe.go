@@ -125,6 +125,17 @@ const systemObserveConnectedPlugSecComp = `
 #@deny ptrace
 `
 
+const systemObserveConnectedPlugSELinux = `
+; Description: Can query system status information.
+(allow ###DOMAIN### domain (dir (getattr open read search)))
+(allow ###DOMAIN### domain (file (getattr open read)))
+(allow ###DOMAIN### domain (lnk_file (getattr read)))
+(allow ###DOMAIN### domain (process (getattr)))
+(allow ###DOMAIN### sysfs_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (file (getattr open read)))
+(allow ###DOMAIN### sysfs_t (lnk_file (getattr read)))
+`
+
 func init() {
 	registerIface(&commonInterface{
 		name:                  "system-observe",
@@ -134,6 +145,7 @@ func init() {
 		baseDeclarationSlots:  systemObserveBaseDeclarationSlots,
 		connectedPlugAppArmor: systemObserveConnectedPlugAppArmor,
 		connectedPlugSecComp:  systemObserveConnectedPlugSecComp,
+		connectedPlugSELinux:  systemObserveConnectedPlugSELinux,
 		reservedForOS:         true,
 		suppressPtraceTrace:   true,
 	})
@@ -19,6 +19,16 @@
 
 package builtin
 
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snap"
+)
+
 const timeControlSummary = `allows setting system date and time`
 
 const timeControlBaseDeclarationSlots = `
@@ -30,10 +40,10 @@ const timeControlBaseDeclarationSlots = `
 `
 
 const timeControlConnectedPlugAppArmor = `
-# Description: Can set time and date via systemd' timedated D-Bus interface.
-# Can read all properties of /org/freedesktop/timedate1 D-Bus object; see
-# https://www.freedesktop.org/wiki/Software/systemd/timedated/; This also
-# gives full access to the RTC device nodes and relevant parts of sysfs.
+# Description: Can read all properties of /org/freedesktop/timedate1 D-Bus
+# object; see https://www.freedesktop.org/wiki/Software/systemd/timedated/.
+# Unless the plug has 'read-only: true', it can also set time and date via
+# systemd' timedated D-Bus interface.
 
 #include <abstractions/dbus-strict>
 
@@ -45,13 +55,6 @@ dbus (send)
     interface=org.freedesktop.DBus.Introspectable
     member=Introspect,
 
-dbus (send)
-    bus=system
-    path=/org/freedesktop/timedate1
-    interface=org.freedesktop.timedate1
-    member="Set{Time,LocalRTC}"
-    peer=(label=unconfined),
-
 # Read all properties from timedate1
 # do not use peer=(label=unconfined) here since this is DBus activated
 dbus (send)
@@ -70,8 +73,8 @@ dbus (receive)
 
 # As the core snap ships the timedatectl utility we can also allow
 # clients to use it now that they have access to the relevant
-# D-Bus methods for setting the time via timedatectl's set-time and
-# set-local-rtc commands.
+# D-Bus properties. Setting the time via timedatectl's set-time and
+# set-local-rtc commands is not possible with 'read-only: true'.
 /usr/bin/timedatectl{,.real} ixr,
 
 # Silence this noisy denial. systemd utilities look at /proc/1/environ to see
@@ -80,6 +83,19 @@ dbus (receive)
 # that allowing this triggers a 'ptrace trace peer=unconfined' denial, which we
 # want to avoid.
 deny @{PROC}/1/environ r,
+`
+
+const timeControlSetTimeConnectedPlugAppArmor = `
+# Description: Can set time and date via systemd' timedated D-Bus interface.
+# This also gives full access to the RTC device nodes and relevant parts of
+# sysfs.
+
+dbus (send)
+    bus=system
+    path=/org/freedesktop/timedate1
+    interface=org.freedesktop.timedate1
+    member="Set{Time,LocalRTC}"
+    peer=(label=unconfined),
 
 # Allow write access to system real-time clock
 # See 'man 4 rtc' for details.
@@ -102,11 +118,10 @@ capability sys_time,
 /sbin/hwclock ixr,
 `
 
-const timeControlConnectedPlugSecComp = `
+const timeControlSetTimeConnectedPlugSecComp = `
 # Description: Can set time and date via systemd' timedated D-Bus interface.
-# Can read all properties of /org/freedesktop/timedate1 D-Bus object; see
-# https://www.freedesktop.org/wiki/Software/systemd/timedated/; This also
-# gives full access to the RTC device nodes and relevant parts of sysfs.
+# This also gives full access to the RTC device nodes and relevant parts of
+# sysfs.
 
 settimeofday
 adjtimex
@@ -118,18 +133,62 @@ bind
 socket AF_NETLINK - NETLINK_AUDIT
 `
 
-var timeControlConnectedPlugUDev = []string{`SUBSYSTEM=="rtc"`}
+var timeControlSetTimeConnectedPlugUDev = []string{`SUBSYSTEM=="rtc"`}
+
+type timeControlInterface struct {
+	commonInterface
+}
+
+func (iface *timeControlInterface) BeforePreparePlug(plug *snap.PlugInfo) error {
+	if v, ok := plug.Attrs["read-only"]; ok {
+		if _, ok = v.(bool); !ok {
+			return fmt.Errorf("time-control plug requires bool with 'read-only'")
+		}
+	}
+	return nil
+}
+
+// canSetTime returns whether the plug can set the time, which is the
+// case unless it opted into read-only access. Only the static attribute
+// is considered so that the plug cannot change its permissions from an
+// interface hook.
+func (iface *timeControlInterface) canSetTime(plug *interfaces.ConnectedPlug) bool {
+	var readOnly bool
+	_ = plug.StaticAttr("read-only", &readOnly)
+	return !readOnly
+}
+
+func (iface *timeControlInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	spec.AddSnippet(timeControlConnectedPlugAppArmor)
+	if iface.canSetTime(plug) {
+		spec.AddSnippet(timeControlSetTimeConnectedPlugAppArmor)
+	}
+	return nil
+}
+
+func (iface *timeControlInterface) SecCompConnectedPlug(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if iface.canSetTime(plug) {
+		spec.AddSnippet(timeControlSetTimeConnectedPlugSecComp)
+	}
+	return nil
+}
+
+func (iface *timeControlInterface) UDevConnectedPlug(spec *udev.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if iface.canSetTime(plug) {
+		for _, rule := range timeControlSetTimeConnectedPlugUDev {
+			spec.TagDevice(rule)
+		}
+	}
+	return nil
+}
 
 func init() {
-	registerIface(&commonInterface{
-		name:                  "time-control",
-		summary:               timeControlSummary,
-		implicitOnCore:        true,
-		implicitOnClassic:     true,
-		baseDeclarationSlots:  timeControlBaseDeclarationSlots,
-		connectedPlugAppArmor: timeControlConnectedPlugAppArmor,
-		connectedPlugSecComp:  timeControlConnectedPlugSecComp,
-		connectedPlugUDev:     timeControlConnectedPlugUDev,
-		reservedForOS:         true,
-	})
+	registerIface(&timeControlInterface{commonInterface{
+		name:                 "time-control",
+		summary:              timeControlSummary,
+		implicitOnCore:       true,
+		implicitOnClassic:    true,
+		baseDeclarationSlots: timeControlBaseDeclarationSlots,
+		reservedForOS:        true,
+	}})
 }
@@ -28,15 +28,18 @@ import (
 	"github.com/snapcore/snapd/interfaces/seccomp"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
 )
 
 type TimeControlInterfaceSuite struct {
-	iface    interfaces.Interface
-	slotInfo *snap.SlotInfo
-	slot     *interfaces.ConnectedSlot
-	plugInfo *snap.PlugInfo
-	plug     *interfaces.ConnectedPlug
+	iface            interfaces.Interface
+	slotInfo         *snap.SlotInfo
+	slot             *interfaces.ConnectedSlot
+	plugInfo         *snap.PlugInfo
+	plug             *interfaces.ConnectedPlug
+	readOnlyPlugInfo *snap.PlugInfo
+	readOnlyPlug     *interfaces.ConnectedPlug
 }
 
 var _ = Suite(&TimeControlInterfaceSuite{
@@ -50,6 +53,16 @@ apps:
   plugs: [time-control]
 `
 
+const timectlReadOnlyConsumerYaml = `name: clock
+version: 0
+plugs:
+ time-control:
+  read-only: true
+apps:
+ app:
+  plugs: [time-control]
+`
+
 const timectlCoreYaml = `name: core
 version: 0
 type: os
@@ -59,6 +72,7 @@ slots:
 
 func (s *TimeControlInterfaceSuite) SetUpTest(c *C) {
 	s.plug, s.plugInfo = MockConnectedPlug(c, timectlConsumerYaml, nil, "time-control")
+	s.readOnlyPlug, s.readOnlyPlugInfo = MockConnectedPlug(c, timectlReadOnlyConsumerYaml, nil, "time-control")
 	s.slot, s.slotInfo = MockConnectedSlot(c, timectlCoreYaml, nil, "time-control")
 }
 
@@ -79,6 +93,20 @@ func (s *TimeControlInterfaceSuite) TestSanitizeSlot(c *C) {
 
 func (s *TimeControlInterfaceSuite) TestSanitizePlug(c *C) {
 	c.Assert(interfaces.BeforePreparePlug(s.iface, s.plugInfo), IsNil)
+	c.Assert(interfaces.BeforePreparePlug(s.iface, s.readOnlyPlugInfo), IsNil)
+}
+
+func (s *TimeControlInterfaceSuite) TestSanitizePlugReadOnlyNotBool(c *C) {
+	const mockSnapYaml = `name: consumer
+version: 0
+plugs:
+ time-control:
+  read-only: "yes"
+`
+	info := snaptest.MockInfo(c, mockSnapYaml, nil)
+	plug := info.Plugs["time-control"]
+	c.Assert(interfaces.BeforePreparePlug(s.iface, plug), ErrorMatches,
+		"time-control plug requires bool with 'read-only'")
 }
 
 func (s *TimeControlInterfaceSuite) TestAppArmorSpec(c *C) {
@@ -86,6 +114,28 @@ func (s *TimeControlInterfaceSuite) TestAppArmorSpec(c *C) {
 	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
 	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.consumer.app"})
 	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "org/freedesktop/timedate1")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "member=Get{,All}")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "Set{Time,LocalRTC}")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "capability sys_time")
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "/dev/rtc[0-9]* rw,")
+}
+
+func (s *TimeControlInterfaceSuite) TestAppArmorSpecReadOnly(c *C) {
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.readOnlyPlug, s.slot), IsNil)
+	c.Assert(spec.SecurityTags(), DeepEquals, []string{"snap.clock.app"})
+	c.Check(spec.SnippetForTag("snap.clock.app"), testutil.Contains, "member=Get{,All}")
+	c.Check(spec.SnippetForTag("snap.clock.app"), Not(testutil.Contains), "Set{Time,LocalRTC}")
+	c.Check(spec.SnippetForTag("snap.clock.app"), Not(testutil.Contains), "capability sys_time")
+	c.Check(spec.SnippetForTag("snap.clock.app"), Not(testutil.Contains), "/dev/rtc")
+}
+
+func (s *TimeControlInterfaceSuite) TestAppArmorSpecReadOnlyFromDynamicAttr(c *C) {
+	// only the static attribute counts
+	plug := interfaces.NewConnectedPlug(s.plugInfo, nil, map[string]interface{}{"read-only": true})
+	spec := &apparmor.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, plug, s.slot), IsNil)
+	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "Set{Time,LocalRTC}")
 }
 
 func (s *TimeControlInterfaceSuite) TestSecCompSpec(c *C) {
@@ -97,6 +147,12 @@ func (s *TimeControlInterfaceSuite) TestSecCompSpec(c *C) {
 	c.Check(spec.SnippetForTag("snap.consumer.app"), testutil.Contains, "socket AF_NETLINK - NETLINK_AUDIT")
 }
 
+func (s *TimeControlInterfaceSuite) TestSecCompSpecReadOnly(c *C) {
+	spec := &seccomp.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.readOnlyPlug, s.slot), IsNil)
+	c.Assert(spec.SecurityTags(), HasLen, 0)
+}
+
 func (s *TimeControlInterfaceSuite) TestUDevSpec(c *C) {
 	spec := &udev.Specification{}
 	c.Assert(spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
@@ -106,6 +162,12 @@ SUBSYSTEM=="rtc", TAG+="snap_consumer_app"`)
 	c.Assert(spec.Snippets(), testutil.Contains, `TAG=="snap_consumer_app", RUN+="/usr/lib/snapd/snap-device-helper $env{ACTION} snap_consumer_app $devpath $major:$minor"`)
 }
 
+func (s *TimeControlInterfaceSuite) TestUDevSpecReadOnly(c *C) {
+	spec := &udev.Specification{}
+	c.Assert(spec.AddConnectedPlug(s.iface, s.readOnlyPlug, s.slot), IsNil)
+	c.Assert(spec.Snippets(), HasLen, 0)
+}
+
 func (s *TimeControlInterfaceSuite) TestStaticInfo(c *C) {
 	si := interfaces.StaticInfoOf(s.iface)
 	c.Assert(si.ImplicitOnCore, Equals, true)
@@ -238,6 +238,8 @@ const (
 	SecurityKMod SecuritySystem = "kmod"
 	// SecuritySystemd identifies the systemd services security system
 	SecuritySystemd SecuritySystem = "systemd"
+	// SecuritySELinux identifies the SELinux security system
+	SecuritySELinux SecuritySystem = "selinux"
 )
 
 var isValidBusName = regexp.MustCompile(`^[a-zA-Z_-][a-zA-Z0-9_-]*(\.[a-zA-Z_-][a-zA-Z0-9_-]*)+$`).MatchString
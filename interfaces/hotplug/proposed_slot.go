@@ -36,6 +36,22 @@ type HotplugKeyHandler interface {
 	HotplugKey(di *HotplugDeviceInfo) (snap.HotplugKey, error)
 }
 
+// HotplugKeyPolicy can be implemented by interfaces that need the default
+// hotplug key of their devices computed from a non-standard set of udev
+// attributes, e.g. to leave out attributes that change when the device is
+// re-enumerated.
+type HotplugKeyPolicy interface {
+	// HotplugKeyAttributes returns groups of udev attributes, the first non-empty attribute of every group goes into the key.
+	// Returning no groups falls back to the default attributes.
+	HotplugKeyAttributes() [][]string
+}
+
+// AttributeWatcher can be implemented by hotplug interfaces that need devices to be re-evaluated when some of their udev attributes change.
+type AttributeWatcher interface {
+	// HotplugWatchedAttributes returns the udev attributes whose change makes the hotplug subsystem re-evaluate the device.
+	HotplugWatchedAttributes() []string
+}
+
 // HandledByGadgetPredicate can be implemented by hotplug interfaces to decide whether a device is already handled by given gadget slot.
 type HandledByGadgetPredicate interface {
 	HandledByGadget(di *HotplugDeviceInfo, slot *snap.SlotInfo) bool
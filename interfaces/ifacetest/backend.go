@@ -20,6 +20,8 @@
 package ifacetest
 
 import (
+	"sync"
+
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/timings"
@@ -28,6 +30,8 @@ import (
 // TestSecurityBackend is a security backend intended for testing.
 type TestSecurityBackend struct {
 	BackendName interfaces.SecuritySystem
+	// mu protects SetupCalls as Setup may be called concurrently
+	mu sync.Mutex
 	// SetupCalls stores information about all calls to Setup
 	SetupCalls []TestSetupCall
 	// RemoveCalls stores information about all calls to Remove
@@ -60,7 +64,9 @@ func (b *TestSecurityBackend) Name() interfaces.SecuritySystem {
 
 // Setup records information about the call and calls the setup callback if one is defined.
 func (b *TestSecurityBackend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository, tm timings.Measurer) error {
+	b.mu.Lock()
 	b.SetupCalls = append(b.SetupCalls, TestSetupCall{SnapInfo: snapInfo, Options: opts})
+	b.mu.Unlock()
 	if b.SetupCallback == nil {
 		return nil
 	}
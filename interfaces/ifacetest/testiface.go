@@ -27,6 +27,7 @@ import (
 	"github.com/snapcore/snapd/interfaces/kmod"
 	"github.com/snapcore/snapd/interfaces/mount"
 	"github.com/snapcore/snapd/interfaces/seccomp"
+	"github.com/snapcore/snapd/interfaces/selinux"
 	"github.com/snapcore/snapd/interfaces/systemd"
 	"github.com/snapcore/snapd/interfaces/udev"
 	"github.com/snapcore/snapd/snap"
@@ -47,6 +48,7 @@ type TestInterface struct {
 
 	BeforeConnectPlugCallback func(plug *interfaces.ConnectedPlug) error
 	BeforeConnectSlotCallback func(slot *interfaces.ConnectedSlot) error
+	BeforeConnectCallback     func(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot, slotConns []*interfaces.Connection) error
 
 	// Support for interacting with the test backend.
 
@@ -97,6 +99,13 @@ type TestInterface struct {
 	DBusPermanentPlugCallback func(spec *dbus.Specification, plug *snap.PlugInfo) error
 	DBusPermanentSlotCallback func(spec *dbus.Specification, slot *snap.SlotInfo) error
 
+	// Support for interacting with the selinux backend.
+
+	SELinuxConnectedPlugCallback func(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error
+	SELinuxConnectedSlotCallback func(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error
+	SELinuxPermanentPlugCallback func(spec *selinux.Specification, plug *snap.PlugInfo) error
+	SELinuxPermanentSlotCallback func(spec *selinux.Specification, slot *snap.SlotInfo) error
+
 	// Support for interacting with the systemd backend.
 
 	SystemdConnectedPlugCallback func(spec *systemd.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error
@@ -115,6 +124,8 @@ type TestHotplugInterface struct {
 	HotplugKeyCallback            func(deviceInfo *hotplug.HotplugDeviceInfo) (snap.HotplugKey, error)
 	HandledByGadgetCallback       func(deviceInfo *hotplug.HotplugDeviceInfo, slot *snap.SlotInfo) bool
 	HotplugDeviceDetectedCallback func(deviceInfo *hotplug.HotplugDeviceInfo) (*hotplug.ProposedSlot, error)
+	HotplugKeyAttributesCallback  func() [][]string
+	HotplugWatchedAttrsCallback   func() []string
 }
 
 // String() returns the same value as Name().
@@ -161,6 +172,13 @@ func (t *TestInterface) BeforeConnectSlot(slot *interfaces.ConnectedSlot) error
 	return nil
 }
 
+func (t *TestInterface) BeforeConnect(plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot, slotConns []*interfaces.Connection) error {
+	if t.BeforeConnectCallback != nil {
+		return t.BeforeConnectCallback(plug, slot, slotConns)
+	}
+	return nil
+}
+
 // AutoConnect returns whether plug and slot should be implicitly
 // auto-connected assuming they will be an unambiguous connection
 // candidate.
@@ -352,6 +370,36 @@ func (t *TestInterface) KModPermanentSlot(spec *kmod.Specification, slot *snap.S
 	return nil
 }
 
+// Support for interacting with the selinux backend.
+
+func (t *TestInterface) SELinuxConnectedPlug(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if t.SELinuxConnectedPlugCallback != nil {
+		return t.SELinuxConnectedPlugCallback(spec, plug, slot)
+	}
+	return nil
+}
+
+func (t *TestInterface) SELinuxConnectedSlot(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	if t.SELinuxConnectedSlotCallback != nil {
+		return t.SELinuxConnectedSlotCallback(spec, plug, slot)
+	}
+	return nil
+}
+
+func (t *TestInterface) SELinuxPermanentPlug(spec *selinux.Specification, plug *snap.PlugInfo) error {
+	if t.SELinuxPermanentPlugCallback != nil {
+		return t.SELinuxPermanentPlugCallback(spec, plug)
+	}
+	return nil
+}
+
+func (t *TestInterface) SELinuxPermanentSlot(spec *selinux.Specification, slot *snap.SlotInfo) error {
+	if t.SELinuxPermanentSlotCallback != nil {
+		return t.SELinuxPermanentSlotCallback(spec, slot)
+	}
+	return nil
+}
+
 // Support for interacting with the dbus backend.
 
 func (t *TestInterface) DBusConnectedPlug(spec *dbus.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
@@ -428,6 +476,20 @@ func (t *TestHotplugInterface) HotplugDeviceDetected(deviceInfo *hotplug.Hotplug
 	return nil, nil
 }
 
+func (t *TestHotplugInterface) HotplugKeyAttributes() [][]string {
+	if t.HotplugKeyAttributesCallback != nil {
+		return t.HotplugKeyAttributesCallback()
+	}
+	return nil
+}
+
+func (t *TestHotplugInterface) HotplugWatchedAttributes() []string {
+	if t.HotplugWatchedAttrsCallback != nil {
+		return t.HotplugWatchedAttrsCallback()
+	}
+	return nil
+}
+
 func (t *TestHotplugInterface) HandledByGadget(deviceInfo *hotplug.HotplugDeviceInfo, slot *snap.SlotInfo) bool {
 	if t.HandledByGadgetCallback != nil {
 		return t.HandledByGadgetCallback(deviceInfo, slot)
@@ -22,6 +22,7 @@ package kmod_test
 import (
 	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/interfaces/kmod"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 	. "gopkg.in/check.v1"
 )
@@ -54,3 +55,13 @@ func (s *kmodSuite) TestModprobeCall(c *C) {
 		{"modprobe", "--syslog", "module2"},
 	})
 }
+
+func (s *kmodSuite) TestModprobeCallPreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	cmd := testutil.MockCommand(c, "modprobe", "")
+	defer cmd.Restore()
+
+	kmod.LoadModules([]string{"module1"})
+	c.Check(cmd.Calls(), HasLen, 0)
+}
@@ -152,6 +152,7 @@ func (s *baseDeclSuite) TestAutoConnection(c *C) {
 
 	// these simply auto-connect, anything else doesn't
 	autoconnect := map[string]bool{
+		"audio-playback":          true,
 		"browser-support":         true,
 		"desktop":                 true,
 		"desktop-legacy":          true,
@@ -579,6 +580,8 @@ var (
 	slotInstallation = map[string][]string{
 		// other
 		"adb-support":             {"core"},
+		"audio-playback":          {"app", "core"},
+		"audio-record":            {"app", "core"},
 		"autopilot-introspection": {"core"},
 		"avahi-control":           {"app", "core"},
 		"avahi-observe":           {"app", "core"},
@@ -611,6 +614,7 @@ var (
 		"online-accounts-service": {"app"},
 		"ppp":         {"core"},
 		"pulseaudio":  {"app", "core"},
+		"raw-usb":     {"core", "gadget"},
 		"serial-port": {"core", "gadget"},
 		"spi":         {"core", "gadget"},
 		"storage-framework-service": {"app"},
@@ -707,6 +711,7 @@ func (s *baseDeclSuite) TestPlugInstallation(c *C) {
 		"multipass-support":     true,
 		"packagekit-control":    true,
 		"personal-files":        true,
+		"seccomp-overrides":     true,
 		"snapd-control":         true,
 		"system-files":          true,
 		"unity8":                true,
@@ -790,6 +795,8 @@ func (s *baseDeclSuite) TestConnectionOnClassic(c *C) {
 	// connecting with these interfaces needs to be allowed on
 	// case-by-case basis when not on classic
 	noconnect := map[string]bool{
+		"audio-playback":  true,
+		"audio-record":    true,
 		"modem-manager":   true,
 		"network-manager": true,
 		"ofono":           true,
@@ -837,6 +844,7 @@ func (s *baseDeclSuite) TestSanity(c *C) {
 		"multipass-support":     true,
 		"packagekit-control":    true,
 		"personal-files":        true,
+		"seccomp-overrides":     true,
 		"snapd-control":         true,
 		"system-files":          true,
 		"udisks2":               true,
@@ -607,6 +607,14 @@ type plugValidator interface {
 	BeforeConnectPlug(plug *ConnectedPlug) error
 }
 
+// connValidator can be implemented by Interfaces that need to validate the
+// plug and the slot together, possibly negotiating dynamic attributes of the
+// connection, before the security is lifted. slotConns are the other
+// connections of the slot.
+type connValidator interface {
+	BeforeConnect(plug *ConnectedPlug, slot *ConnectedSlot, slotConns []*Connection) error
+}
+
 type PolicyFunc func(*ConnectedPlug, *ConnectedSlot) (bool, error)
 
 // Connect establishes a connection between a plug and a slot.
@@ -663,6 +671,18 @@ func (r *Repository) Connect(ref *ConnRef, plugStaticAttrs, plugDynamicAttrs, sl
 		if err != nil || !ok {
 			return nil, err
 		}
+
+		if i, ok := iface.(connValidator); ok {
+			var slotConns []*Connection
+			for otherPlug, conn := range r.slotPlugs[slot] {
+				if otherPlug != plug {
+					slotConns = append(slotConns, conn)
+				}
+			}
+			if err := i.BeforeConnect(cplug, cslot, slotConns); err != nil {
+				return nil, fmt.Errorf("cannot connect plug %q of snap %q to slot %q of snap %q: %s", plug.Name, plug.Snap.InstanceName(), slot.Name, slot.Snap.InstanceName(), err)
+			}
+		}
 	}
 
 	// Connect the plug
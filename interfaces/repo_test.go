@@ -21,6 +21,7 @@ package interfaces_test
 
 import (
 	"fmt"
+	"strings"
 
 	. "gopkg.in/check.v1"
 
@@ -2364,6 +2365,55 @@ func (s *RepositorySuite) TestBeforeConnectValidationPolicyCheckFailure(c *C) {
 	c.Assert(conn, IsNil)
 }
 
+func (s *RepositorySuite) TestBeforeConnectNegotiation(c *C) {
+	var seen []string
+	err := s.emptyRepo.AddInterface(&ifacetest.TestInterface{
+		InterfaceName: "iface2",
+		BeforeConnectCallback: func(plug *ConnectedPlug, slot *ConnectedSlot, slotConns []*Connection) error {
+			seen = nil
+			for _, conn := range slotConns {
+				seen = append(seen, conn.Plug.Snap().InstanceName())
+			}
+			if len(slotConns) > 0 {
+				return fmt.Errorf("slot already in use")
+			}
+			return plug.SetAttr("negotiated", slot.Name())
+		},
+	})
+	c.Assert(err, IsNil)
+
+	s1 := snaptest.MockInfo(c, ifacehooksSnap1, nil)
+	c.Assert(s.emptyRepo.AddSnap(s1), IsNil)
+	s2 := snaptest.MockInfo(c, ifacehooksSnap2, nil)
+	c.Assert(s.emptyRepo.AddSnap(s2), IsNil)
+	s3 := snaptest.MockInfo(c, strings.Replace(ifacehooksSnap1, "name: s1", "name: s3", 1), nil)
+	c.Assert(s.emptyRepo.AddSnap(s3), IsNil)
+
+	policyCheck := func(plug *ConnectedPlug, slot *ConnectedSlot) (bool, error) { return true, nil }
+	connRef := &ConnRef{PlugRef: PlugRef{Snap: "s1", Name: "consumer"}, SlotRef: SlotRef{Snap: "s2", Name: "producer"}}
+	conn, err := s.emptyRepo.Connect(connRef, nil, nil, nil, nil, policyCheck)
+	c.Assert(err, IsNil)
+	c.Check(seen, HasLen, 0)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{"negotiated": "producer"})
+
+	// connecting again the same plug does not see itself
+	_, err = s.emptyRepo.Connect(connRef, nil, nil, nil, nil, policyCheck)
+	c.Assert(err, IsNil)
+	c.Check(seen, HasLen, 0)
+
+	// the other connections of the slot are considered
+	connRef = &ConnRef{PlugRef: PlugRef{Snap: "s3", Name: "consumer"}, SlotRef: SlotRef{Snap: "s2", Name: "producer"}}
+	conn, err = s.emptyRepo.Connect(connRef, nil, nil, nil, nil, policyCheck)
+	c.Assert(err, ErrorMatches, `cannot connect plug "consumer" of snap "s3" to slot "producer" of snap "s2": slot already in use`)
+	c.Check(conn, IsNil)
+	c.Check(seen, DeepEquals, []string{"s1"})
+
+	// not invoked when connections are reloaded
+	conn, err = s.emptyRepo.Connect(connRef, nil, map[string]interface{}{"negotiated": "producer"}, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{"negotiated": "producer"})
+}
+
 func (s *RepositorySuite) TestConnection(c *C) {
 	c.Assert(s.testRepo.AddPlug(s.plug), IsNil)
 	c.Assert(s.testRepo.AddSlot(s.slot), IsNil)
@@ -43,6 +43,7 @@ import (
 	"github.com/snapcore/snapd/arch"
 	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/release"
@@ -241,6 +242,9 @@ func (b *Backend) deriveContent(spec *Specification, opts interfaces.Confinement
 	// Some base snaps and systems require the socketcall() in the default
 	// template
 	addSocketcall := requiresSocketcall(snapInfo.Base)
+	// Overrides requested by the snap are only applied once allowed by
+	// the system administrator
+	overridesEnabled := features.SeccompOverrides.IsEnabled()
 
 	for _, hookInfo := range snapInfo.Hooks {
 		if content == nil {
@@ -250,7 +254,7 @@ func (b *Backend) deriveContent(spec *Specification, opts interfaces.Confinement
 
 		path := securityTag + ".src"
 		content[path] = &osutil.FileState{
-			Content: generateContent(opts, spec.SnippetForTag(securityTag), addSocketcall, b.versionInfo),
+			Content: b.profileContent(spec, securityTag, opts, addSocketcall, overridesEnabled),
 			Mode:    0644,
 		}
 	}
@@ -261,7 +265,7 @@ func (b *Backend) deriveContent(spec *Specification, opts interfaces.Confinement
 		securityTag := appInfo.SecurityTag()
 		path := securityTag + ".src"
 		content[path] = &osutil.FileState{
-			Content: generateContent(opts, spec.SnippetForTag(securityTag), addSocketcall, b.versionInfo),
+			Content: b.profileContent(spec, securityTag, opts, addSocketcall, overridesEnabled),
 			Mode:    0644,
 		}
 	}
@@ -269,6 +273,60 @@ func (b *Backend) deriveContent(spec *Specification, opts interfaces.Confinement
 	return content, nil
 }
 
+// profileContent returns the seccomp profile of the given security tag,
+// with the seccomp overrides added for it applied when they are enabled.
+func (b *Backend) profileContent(spec *Specification, securityTag string, opts interfaces.ConfinementOptions, addSocketcall, overridesEnabled bool) []byte {
+	overrides := spec.OverridesForTag(securityTag)
+	denials := spec.DenialsForTag(securityTag)
+	snippet := spec.SnippetForTag(securityTag) + overridesSnippet(overrides, denials, overridesEnabled)
+	content := generateContent(opts, snippet, addSocketcall, b.versionInfo)
+	if overridesEnabled {
+		content = denySyscalls(content, denials)
+	}
+	return content
+}
+
+// overridesSnippet returns the rules of the given seccomp overrides, or
+// only a note about them when they are not enabled.
+func overridesSnippet(overrides, denials []string, enabled bool) string {
+	if len(overrides) == 0 && len(denials) == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	if !enabled {
+		fmt.Fprintf(&buffer, "# seccomp overrides not applied, experimental.seccomp-overrides is disabled: %s\n", strings.Join(append(overrides, denials...), " "))
+		return buffer.String()
+	}
+	if len(overrides) != 0 {
+		buffer.WriteString("# reviewed seccomp overrides\n")
+		for _, syscall := range overrides {
+			buffer.WriteString(reviewedOverrides[syscall])
+			buffer.WriteRune('\n')
+		}
+	}
+	if len(denials) != 0 {
+		fmt.Fprintf(&buffer, "# denied by seccomp overrides: %s\n", strings.Join(denials, " "))
+	}
+	return buffer.String()
+}
+
+// denySyscalls drops the rules allowing the given syscalls from the
+// profile content.
+func denySyscalls(content []byte, denials []string) []byte {
+	if len(denials) == 0 {
+		return content
+	}
+	var buffer bytes.Buffer
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 0 && strutil.ListContains(denials, fields[0]) {
+			continue
+		}
+		buffer.WriteString(line)
+	}
+	return buffer.Bytes()
+}
+
 func generateContent(opts interfaces.ConfinementOptions, snippetForTag string, addSocketcall bool, versionInfo string) []byte {
 	var buffer bytes.Buffer
 
@@ -314,9 +372,9 @@ func (b *Backend) NewSpecification() interfaces.Specification {
 // SandboxFeatures returns the list of seccomp features supported by the kernel
 // and userspace.
 func (b *Backend) SandboxFeatures() []string {
-	features := kernelFeatures()
-	tags := make([]string, 0, len(features)+1)
-	for _, feature := range features {
+	kfeatures := kernelFeatures()
+	tags := make([]string, 0, len(kfeatures)+1)
+	for _, feature := range kfeatures {
 		// Prepend "kernel:" to apparmor kernel features to namespace
 		// them.
 		tags = append(tags, "kernel:"+feature)
@@ -327,6 +385,12 @@ func (b *Backend) SandboxFeatures() []string {
 		tags = append(tags, "bpf-actlog")
 	}
 
+	if features.SeccompOverrides.IsEnabled() {
+		for _, syscall := range ReviewedOverrides() {
+			tags = append(tags, "override:"+syscall)
+		}
+	}
+
 	return tags
 }
 
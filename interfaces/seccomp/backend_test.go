@@ -30,6 +30,7 @@ import (
 
 	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/interfaces/seccomp"
@@ -418,6 +419,66 @@ func (s *backendSuite) TestSocketcallIsNotAddedWhenNotRequired(c *C) {
 	c.Assert(profile+".src", Not(testutil.FileContains), "\nsocketcall\n")
 }
 
+func (s *backendSuite) TestOverridesAppliedWhenEnabled(c *C) {
+	restore := release.MockForcedDevmode(false)
+	defer restore()
+	restore = seccomp.MockRequiresSocketcall(func(string) bool { return false })
+	defer restore()
+	restore = seccomp.MockTemplate([]byte("default\n"))
+	defer restore()
+
+	s.Iface.SecCompPermanentSlotCallback = func(spec *seccomp.Specification, slot *snap.SlotInfo) error {
+		c.Assert(spec.AddOverride("userfaultfd"), IsNil)
+		c.Assert(spec.AddOverride("kcmp"), IsNil)
+		return spec.AddOverride("kcmp")
+	}
+
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+
+	// the overrides are not applied unless the feature is enabled
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Check(profile+".src", testutil.FileEquals, s.profileHeader+"default\n"+
+		"# seccomp overrides not applied, experimental.seccomp-overrides is disabled: kcmp userfaultfd\n")
+	s.RemoveSnap(c, snapInfo)
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(features.SeccompOverrides.ControlFile(), nil, 0644), IsNil)
+
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Check(profile+".src", testutil.FileEquals, s.profileHeader+"default\n"+
+		"# reviewed seccomp overrides\nkcmp\nuserfaultfd\n")
+}
+
+func (s *backendSuite) TestOverridesDeny(c *C) {
+	restore := release.MockForcedDevmode(false)
+	defer restore()
+	restore = seccomp.MockRequiresSocketcall(func(string) bool { return false })
+	defer restore()
+	restore = seccomp.MockTemplate([]byte("default\nptrace\nbind\n# ptrace is fine\n"))
+	defer restore()
+
+	s.Iface.SecCompPermanentSlotCallback = func(spec *seccomp.Specification, slot *snap.SlotInfo) error {
+		spec.AddSnippet("ptrace 0")
+		return spec.AddDenial("ptrace")
+	}
+
+	profile := filepath.Join(dirs.SnapSeccompDir, "snap.samba.smbd")
+
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Check(profile+".src", testutil.FileEquals, s.profileHeader+"default\nptrace\nbind\n# ptrace is fine\nptrace 0\n"+
+		"# seccomp overrides not applied, experimental.seccomp-overrides is disabled: ptrace\n")
+	s.RemoveSnap(c, snapInfo)
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(features.SeccompOverrides.ControlFile(), nil, 0644), IsNil)
+
+	// the rules allowing the syscall are dropped, whether from the
+	// template or from interfaces
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Check(profile+".src", testutil.FileEquals, s.profileHeader+"default\nbind\n# ptrace is fine\n"+
+		"# denied by seccomp overrides: ptrace\n")
+}
+
 const ClassicYamlV1 = `
 name: test-classic
 version: 1
@@ -486,6 +547,24 @@ fi`)
 	c.Assert(s.Backend.SandboxFeatures(), DeepEquals, []string{"kernel:foo", "kernel:bar", "bpf-argument-filtering", "bpf-actlog"})
 }
 
+func (s *backendSuite) TestSandboxFeaturesOverrides(c *C) {
+	restore := seccomp.MockKernelFeatures(func() []string { return []string{"foo"} })
+	defer restore()
+
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(features.SeccompOverrides.ControlFile(), nil, 0644), IsNil)
+
+	c.Assert(s.Backend.SandboxFeatures(), DeepEquals, []string{
+		"kernel:foo",
+		"bpf-argument-filtering",
+		"override:kcmp",
+		"override:migrate_pages",
+		"override:move_pages",
+		"override:name_to_handle_at",
+		"override:userfaultfd",
+	})
+}
+
 func (s *backendSuite) TestRequiresSocketcallByNotNeededArch(c *C) {
 	testArchs := []string{"amd64", "armhf", "arm64", "powerpc", "ppc64el", "unknownDefault"}
 	for _, arch := range testArchs {
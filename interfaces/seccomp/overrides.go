@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package seccomp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// reviewedOverrides maps the syscalls that can be granted to a snap on
+// top of the default template, through the seccomp-overrides interface,
+// to the rules allowing them. Each entry was reviewed not to allow
+// escaping confinement on its own, anything else needs a dedicated
+// interface.
+var reviewedOverrides = map[string]string{
+	// comparing processes, used by checkpoint/restore tools and some
+	// debuggers
+	"kcmp": "kcmp",
+	// file handles are only useful with open_by_handle_at(), which
+	// stays denied
+	"name_to_handle_at": "name_to_handle_at",
+	// used by some garbage collectors and live migration
+	"userfaultfd": "userfaultfd",
+	// NUMA page migration of the calling process only
+	"migrate_pages": "migrate_pages 0",
+	"move_pages":    "move_pages 0",
+}
+
+// ValidateOverride checks that the given syscall is in the reviewed set
+// of seccomp overrides.
+func ValidateOverride(syscall string) error {
+	if _, ok := reviewedOverrides[syscall]; !ok {
+		return fmt.Errorf("%q is not a reviewed seccomp override", syscall)
+	}
+	return nil
+}
+
+var validDenial = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ValidateDenial checks that the given syscall can be denied by a seccomp
+// override. Denying a syscall only takes away from the profile, so any
+// syscall name is accepted.
+func ValidateDenial(syscall string) error {
+	if !validDenial.MatchString(syscall) {
+		return fmt.Errorf("invalid syscall name %q", syscall)
+	}
+	return nil
+}
+
+// ReviewedOverrides returns the sorted list of syscalls in the reviewed
+// set of seccomp overrides.
+func ReviewedOverrides() []string {
+	syscalls := make([]string, 0, len(reviewedOverrides))
+	for syscall := range reviewedOverrides {
+		syscalls = append(syscalls, syscall)
+	}
+	sort.Strings(syscalls)
+	return syscalls
+}
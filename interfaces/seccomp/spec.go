@@ -25,12 +25,16 @@ import (
 
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 // Specification keeps all the seccomp snippets.
 type Specification struct {
 	// Snippets are indexed by security tag.
-	snippets     map[string][]string
+	snippets map[string][]string
+	// Overrides and denials are indexed by security tag.
+	overrides    map[string][]string
+	denials      map[string][]string
 	securityTags []string
 }
 
@@ -47,6 +51,63 @@ func (spec *Specification) AddSnippet(snippet string) {
 	}
 }
 
+// AddOverride adds a syscall from the reviewed set of seccomp overrides.
+// Overrides are only applied when the seccomp-overrides feature is
+// enabled.
+func (spec *Specification) AddOverride(syscall string) error {
+	if err := ValidateOverride(syscall); err != nil {
+		return err
+	}
+	if len(spec.securityTags) == 0 {
+		return nil
+	}
+	if spec.overrides == nil {
+		spec.overrides = make(map[string][]string)
+	}
+	for _, tag := range spec.securityTags {
+		if !strutil.ListContains(spec.overrides[tag], syscall) {
+			spec.overrides[tag] = append(spec.overrides[tag], syscall)
+		}
+	}
+	return nil
+}
+
+// AddDenial adds a syscall that a seccomp override denies, whether the
+// default template or an interface allows it.
+func (spec *Specification) AddDenial(syscall string) error {
+	if err := ValidateDenial(syscall); err != nil {
+		return err
+	}
+	if len(spec.securityTags) == 0 {
+		return nil
+	}
+	if spec.denials == nil {
+		spec.denials = make(map[string][]string)
+	}
+	for _, tag := range spec.securityTags {
+		if !strutil.ListContains(spec.denials[tag], syscall) {
+			spec.denials[tag] = append(spec.denials[tag], syscall)
+		}
+	}
+	return nil
+}
+
+// DenialsForTag returns the sorted syscalls denied by seccomp overrides
+// for given security tag.
+func (spec *Specification) DenialsForTag(tag string) []string {
+	denials := append([]string(nil), spec.denials[tag]...)
+	sort.Strings(denials)
+	return denials
+}
+
+// OverridesForTag returns the sorted seccomp overrides added for given
+// security tag.
+func (spec *Specification) OverridesForTag(tag string) []string {
+	overrides := append([]string(nil), spec.overrides[tag]...)
+	sort.Strings(overrides)
+	return overrides
+}
+
 // Snippets returns a deep copy of all the added snippets.
 func (spec *Specification) Snippets() map[string][]string {
 	result := make(map[string][]string, len(spec.snippets))
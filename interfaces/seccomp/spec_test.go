@@ -103,3 +103,38 @@ func (s *specSuite) TestSpecificationIface(c *C) {
 
 	c.Assert(s.spec.SnippetForTag("non-existing"), Equals, "")
 }
+
+func (s *specSuite) TestAddOverride(c *C) {
+	iface := &ifacetest.TestInterface{
+		InterfaceName: "test",
+		SecCompConnectedPlugCallback: func(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			c.Assert(spec.AddOverride("userfaultfd"), IsNil)
+			c.Assert(spec.AddOverride("kcmp"), IsNil)
+			c.Assert(spec.AddOverride("userfaultfd"), IsNil)
+			return spec.AddOverride("ptrace")
+		},
+	}
+	err := s.spec.AddConnectedPlug(iface, s.plug, s.slot)
+	c.Assert(err, ErrorMatches, `"ptrace" is not a reviewed seccomp override`)
+	c.Check(s.spec.OverridesForTag("snap.snap1.app1"), DeepEquals, []string{"kcmp", "userfaultfd"})
+	c.Check(s.spec.OverridesForTag("snap.snap2.app2"), HasLen, 0)
+	// overrides are not snippets
+	c.Check(s.spec.SecurityTags(), HasLen, 0)
+}
+
+func (s *specSuite) TestAddDenial(c *C) {
+	iface := &ifacetest.TestInterface{
+		InterfaceName: "test",
+		SecCompConnectedPlugCallback: func(spec *seccomp.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			c.Assert(spec.AddDenial("ptrace"), IsNil)
+			c.Assert(spec.AddDenial("bind"), IsNil)
+			c.Assert(spec.AddDenial("ptrace"), IsNil)
+			return spec.AddDenial("ptrace 0")
+		},
+	}
+	err := s.spec.AddConnectedPlug(iface, s.plug, s.slot)
+	c.Assert(err, ErrorMatches, `invalid syscall name "ptrace 0"`)
+	c.Check(s.spec.DenialsForTag("snap.snap1.app1"), DeepEquals, []string{"bind", "ptrace"})
+	c.Check(s.spec.DenialsForTag("snap.snap2.app2"), HasLen, 0)
+	c.Check(s.spec.SecurityTags(), HasLen, 0)
+}
@@ -0,0 +1,226 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package selinux implements integration between snappy and SELinux.
+//
+// Snappy creates one SELinux policy module per snap, written in the Common
+// Intermediate Language (CIL), that defines a domain for each application and
+// hook of the snap. The domain is granted the base policy of the template
+// and the policy snippets provided by the interfaces connected to the snap,
+// much like the AppArmor profiles of the snap. The modules are stored in
+// /var/lib/snapd/selinux/modules and installed with semodule.
+//
+// Interfaces that grant AppArmor policy but have no SELinux policy yet make
+// the domains they apply to permissive, so that the SELinux policy never
+// takes away access the AppArmor profile of the snap grants.
+//
+// snap-confine switches to the domain of the application or hook it runs
+// when the domain is defined by the loaded policy. Snaps are otherwise run
+// unconfined.
+//
+// The backend is only active when the selinux-policy feature is enabled.
+package selinux
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/timings"
+)
+
+// modulePriority is the priority the policy modules of the snaps are
+// installed with, above the default priority of the distribution modules.
+const modulePriority = "300"
+
+// Snap names, application and hook names and instance keys only contain
+// letters, digits and single hyphens between them, so mapping each of the
+// separators of security tags to a distinct number of underscores keeps the
+// names of the domains and modules unique.
+var nameEscaper = strings.NewReplacer("-", "_", ".", "__", "_", "___")
+
+// DomainName returns the name of the SELinux domain of the snap application
+// or hook with the given security tag.
+//
+// snap-confine computes the same name to switch to the domain.
+func DomainName(securityTag string) string {
+	return "snap_" + nameEscaper.Replace(strings.TrimPrefix(securityTag, "snap.")) + "_t"
+}
+
+// moduleName returns the name of the SELinux policy module of the snap.
+func moduleName(snapName string) string {
+	return "snap_" + nameEscaper.Replace(snapName)
+}
+
+// Backend is responsible for maintaining SELinux policy modules for snaps.
+type Backend struct{}
+
+// Initialize does nothing.
+func (b *Backend) Initialize() error {
+	return nil
+}
+
+// Name returns the name of the backend.
+func (b *Backend) Name() interfaces.SecuritySystem {
+	return interfaces.SecuritySELinux
+}
+
+// Setup creates and installs the SELinux policy module of the given snap.
+// In devmode and classic confinement the domains of the snap are
+// permissive.
+//
+// If the selinux-policy feature is disabled then the policy module of the
+// snap, if any, is removed instead.
+//
+// If the method fails it should be re-tried (with a sensible strategy) by the caller.
+func (b *Backend) Setup(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository, tm timings.Measurer) error {
+	snapName := snapInfo.InstanceName()
+	if !features.SELinuxPolicy.IsEnabled() {
+		return b.Remove(snapName)
+	}
+
+	spec, err := repo.SnapSpecification(b.Name(), snapName)
+	if err != nil {
+		return fmt.Errorf("cannot obtain SELinux specification for snap %q: %s", snapName, err)
+	}
+
+	content := deriveContent(spec.(*Specification), snapInfo, opts)
+	dir := dirs.SnapSELinuxPolicyDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory for SELinux policy modules %q: %s", dir, err)
+	}
+	changed, removed, err := osutil.EnsureDirState(dir, moduleFileName(snapName), content)
+	if err != nil {
+		return err
+	}
+	for _, fname := range changed {
+		if err := installModule(filepath.Join(dir, fname)); err != nil {
+			return err
+		}
+	}
+	for range removed {
+		if err := removeModule(moduleName(snapName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes and uninstalls the SELinux policy module of the given snap.
+//
+// If the method fails it should be re-tried (with a sensible strategy) by the caller.
+func (b *Backend) Remove(snapName string) error {
+	_, removed, err := osutil.EnsureDirState(dirs.SnapSELinuxPolicyDir, moduleFileName(snapName), nil)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		return removeModule(moduleName(snapName))
+	}
+	return nil
+}
+
+func moduleFileName(snapName string) string {
+	return moduleName(snapName) + ".cil"
+}
+
+// deriveContent returns the policy module of the snap, defining a domain
+// for each of its applications and hooks.
+func deriveContent(spec *Specification, snapInfo *snap.Info, opts interfaces.ConfinementOptions) map[string]*osutil.FileState {
+	var tags []string
+	for _, appInfo := range snapInfo.Apps {
+		tags = append(tags, appInfo.SecurityTag())
+	}
+	for _, hookInfo := range snapInfo.Hooks {
+		tags = append(tags, hookInfo.SecurityTag())
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	sort.Strings(tags)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("; This file is automatically generated.\n")
+	for _, tag := range tags {
+		buffer.WriteString(domainPolicy(tag, spec, opts))
+	}
+	return map[string]*osutil.FileState{
+		moduleFileName(snapInfo.InstanceName()): {
+			Content: buffer.Bytes(),
+			Mode:    0644,
+		},
+	}
+}
+
+func domainPolicy(securityTag string, spec *Specification, opts interfaces.ConfinementOptions) string {
+	policy := domainTemplate
+	if snippet := spec.SnippetForTag(securityTag); snippet != "" {
+		policy += "\n" + snippet + "\n"
+	}
+	permissive := (opts.DevMode || opts.Classic) && !opts.JailMode
+	if missing := spec.MissingPolicyForTag(securityTag); len(missing) > 0 {
+		policy += fmt.Sprintf("\n; no SELinux policy for interfaces: %s\n", strings.Join(missing, " "))
+		permissive = true
+	}
+	if permissive {
+		policy += permissiveTemplate
+	}
+	return strings.NewReplacer(
+		"###SECURITY_TAG###", securityTag,
+		"###DOMAIN###", DomainName(securityTag),
+	).Replace(policy)
+}
+
+func installModule(path string) error {
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "semodule",
+		Args: []string{"-X", modulePriority, "-i", path},
+	}); err != nil {
+		return fmt.Errorf("cannot install SELinux policy module %q: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func removeModule(name string) error {
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "semodule",
+		Args: []string{"-X", modulePriority, "-r", name},
+	}); err != nil {
+		return fmt.Errorf("cannot remove SELinux policy module %q: %v", name, err)
+	}
+	return nil
+}
+
+// NewSpecification returns a new SELinux specification.
+func (b *Backend) NewSpecification() interfaces.Specification {
+	return &Specification{}
+}
+
+// SandboxFeatures returns the list of features supported by snapd for SELinux.
+func (b *Backend) SandboxFeatures() []string {
+	return []string{"policy-modules:cil"}
+}
@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selinux_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/interfaces/selinux"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+	"github.com/snapcore/snapd/timings"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type backendSuite struct {
+	ifacetest.BackendSuite
+	semoduleCmd *testutil.MockCmd
+	meas        *timings.Span
+}
+
+var _ = Suite(&backendSuite{})
+
+var testedConfinementOpts = []interfaces.ConfinementOptions{
+	{},
+	{DevMode: true},
+	{JailMode: true},
+	{Classic: true},
+}
+
+func (s *backendSuite) SetUpTest(c *C) {
+	s.Backend = &selinux.Backend{}
+	s.BackendSuite.SetUpTest(c)
+	c.Assert(s.Repo.AddBackend(s.Backend), IsNil)
+	s.semoduleCmd = testutil.MockCommand(c, "semodule", "")
+
+	perf := timings.New(nil)
+	s.meas = perf.StartSpan("", "")
+
+	s.enableFeature(c)
+	// a snippet so that the interface is visible in the policy
+	s.Iface.SELinuxPermanentSlotCallback = func(spec *selinux.Specification, slot *snap.SlotInfo) error {
+		spec.AddSnippet("(allow ###DOMAIN### samba_share_t (file (getattr open read)))")
+		return nil
+	}
+}
+
+func (s *backendSuite) TearDownTest(c *C) {
+	s.semoduleCmd.Restore()
+	s.BackendSuite.TearDownTest(c)
+}
+
+func (s *backendSuite) enableFeature(c *C) {
+	c.Assert(os.MkdirAll(dirs.FeaturesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(features.SELinuxPolicy.ControlFile(), nil, 0644), IsNil)
+}
+
+func (s *backendSuite) TestName(c *C) {
+	c.Check(s.Backend.Name(), Equals, interfaces.SecuritySELinux)
+}
+
+func (s *backendSuite) TestDomainName(c *C) {
+	c.Check(selinux.DomainName("snap.samba.smbd"), Equals, "snap_samba__smbd_t")
+	c.Check(selinux.DomainName("snap.samba.hook.configure"), Equals, "snap_samba__hook__configure_t")
+	c.Check(selinux.DomainName("snap.foo-bar.some-app"), Equals, "snap_foo_bar__some_app_t")
+	c.Check(selinux.DomainName("snap.foo_bar.app"), Equals, "snap_foo___bar__app_t")
+}
+
+func (s *backendSuite) TestInstallingSnapWritesAndInstallsModule(c *C) {
+	path := filepath.Join(dirs.SnapSELinuxPolicyDir, "snap_samba.cil")
+	for _, opts := range testedConfinementOpts {
+		s.semoduleCmd.ForgetCalls()
+		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+
+		c.Assert(path, testutil.FileContains, "(type snap_samba__smbd_t)\n")
+		c.Check(path, testutil.FileContains, "(allow snappy_confine_t snap_samba__smbd_t (process (transition noatsecure rlimitinh siginh)))\n")
+		c.Check(path, testutil.FileContains, "(allow snap_samba__smbd_t samba_share_t (file (getattr open read)))\n")
+		// the base policy matches the AppArmor template
+		c.Check(path, testutil.FileContains, "(allow snap_samba__smbd_t tmpfs_t (file ")
+		c.Check(path, testutil.FileContains, "(allow snap_samba__smbd_t user_tmp_t (dir ")
+		c.Check(path, testutil.FileContains, "(allow snap_samba__smbd_t sysfs_t (file (getattr open read)))\n")
+		if (opts.DevMode || opts.Classic) && !opts.JailMode {
+			c.Check(path, testutil.FileContains, "(typepermissive snap_samba__smbd_t)\n")
+		} else {
+			c.Check(path, Not(testutil.FileContains), "typepermissive")
+		}
+		c.Check(s.semoduleCmd.Calls(), DeepEquals, [][]string{
+			{"semodule", "-X", "300", "-i", path},
+		})
+		s.RemoveSnap(c, snapInfo)
+	}
+}
+
+func (s *backendSuite) TestInstallingSnapWithHookAndInstanceKey(c *C) {
+	const snapYaml = `name: samba
+version: 1
+apps:
+    smbd:
+hooks:
+    configure:
+`
+	s.InstallSnap(c, interfaces.ConfinementOptions{}, "samba_foo", snapYaml, 0)
+	path := filepath.Join(dirs.SnapSELinuxPolicyDir, "snap_samba___foo.cil")
+	c.Check(path, testutil.FileContains, "(type snap_samba___foo__smbd_t)\n")
+	c.Check(path, testutil.FileContains, "(type snap_samba___foo__hook__configure_t)\n")
+}
+
+func (s *backendSuite) TestMissingPolicyMakesDomainPermissive(c *C) {
+	s.Iface.SELinuxPermanentSlotCallback = nil
+	s.Iface.AppArmorPermanentSlotCallback = func(spec *apparmor.Specification, slot *snap.SlotInfo) error {
+		spec.AddSnippet("/srv/samba/** r,")
+		return nil
+	}
+
+	path := filepath.Join(dirs.SnapSELinuxPolicyDir, "snap_samba.cil")
+	for _, opts := range testedConfinementOpts {
+		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+		c.Check(path, testutil.FileContains, "\n; no SELinux policy for interfaces: iface\n")
+		c.Check(path, testutil.FileContains, "(typepermissive snap_samba__smbd_t)\n")
+		s.RemoveSnap(c, snapInfo)
+	}
+}
+
+func (s *backendSuite) TestRemovingSnapRemovesModule(c *C) {
+	path := filepath.Join(dirs.SnapSELinuxPolicyDir, "snap_samba.cil")
+	for _, opts := range testedConfinementOpts {
+		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+		c.Assert(osutil.FileExists(path), Equals, true)
+		s.semoduleCmd.ForgetCalls()
+		s.RemoveSnap(c, snapInfo)
+		c.Check(osutil.FileExists(path), Equals, false)
+		c.Check(s.semoduleCmd.Calls(), DeepEquals, [][]string{
+			{"semodule", "-X", "300", "-r", "snap_samba"},
+		})
+	}
+}
+
+func (s *backendSuite) TestSecurityIsStable(c *C) {
+	for _, opts := range testedConfinementOpts {
+		snapInfo := s.InstallSnap(c, opts, "", ifacetest.SambaYamlV1, 0)
+		s.semoduleCmd.ForgetCalls()
+		err := s.Backend.Setup(snapInfo, opts, s.Repo, s.meas)
+		c.Assert(err, IsNil)
+		// the module is not re-installed when nothing changes
+		c.Check(s.semoduleCmd.Calls(), HasLen, 0)
+		s.RemoveSnap(c, snapInfo)
+	}
+}
+
+func (s *backendSuite) TestFeatureDisabled(c *C) {
+	path := filepath.Join(dirs.SnapSELinuxPolicyDir, "snap_samba.cil")
+	snapInfo := s.InstallSnap(c, interfaces.ConfinementOptions{}, "", ifacetest.SambaYamlV1, 0)
+	c.Assert(osutil.FileExists(path), Equals, true)
+
+	// the module is removed once the feature is disabled
+	c.Assert(os.Remove(features.SELinuxPolicy.ControlFile()), IsNil)
+	s.semoduleCmd.ForgetCalls()
+	err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo, s.meas)
+	c.Assert(err, IsNil)
+	c.Check(osutil.FileExists(path), Equals, false)
+	c.Check(s.semoduleCmd.Calls(), DeepEquals, [][]string{
+		{"semodule", "-X", "300", "-r", "snap_samba"},
+	})
+
+	// and no module is written while it is disabled
+	s.semoduleCmd.ForgetCalls()
+	err = s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo, s.meas)
+	c.Assert(err, IsNil)
+	c.Check(osutil.FileExists(path), Equals, false)
+	c.Check(s.semoduleCmd.Calls(), HasLen, 0)
+}
+
+func (s *backendSuite) TestInstallModuleError(c *C) {
+	cmd := testutil.MockCommand(c, "semodule", "echo failure; exit 1")
+	defer cmd.Restore()
+
+	snapInfo := snaptest.MockInfo(c, ifacetest.SambaYamlV1, nil)
+	err := s.Backend.Setup(snapInfo, interfaces.ConfinementOptions{}, s.Repo, s.meas)
+	c.Assert(err, ErrorMatches, `cannot install SELinux policy module "snap_samba.cil": failure`)
+}
+
+func (s *backendSuite) TestSandboxFeatures(c *C) {
+	c.Check(s.Backend.SandboxFeatures(), DeepEquals, []string{"policy-modules:cil"})
+}
@@ -0,0 +1,219 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selinux
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// Specification assists in collecting SELinux policy associated with an
+// interface.
+//
+// Unlike the Backend itself (which is stateless and non-persistent) this type
+// holds internal state that is used by the SELinux backend during the
+// interface setup process.
+type Specification struct {
+	// scope for various Add{...}Snippet functions
+	securityTags []string
+
+	// snippets are indexed by security tag and hold CIL policy statements
+	// granted to the domain of the snap application or hook with that tag.
+	snippets map[string][]string
+
+	// missing are indexed by security tag and hold the names of the
+	// interfaces granting AppArmor policy, but no SELinux policy, to the
+	// snap application or hook with that tag.
+	missing map[string][]string
+}
+
+// setScope sets the scope of subsequent AddSnippet family functions.
+// The returned function resets the scope to an empty scope.
+func (spec *Specification) setScope(securityTags []string) (restore func()) {
+	spec.securityTags = securityTags
+	return func() {
+		spec.securityTags = nil
+	}
+}
+
+// AddSnippet adds a new CIL policy snippet to all applications and hooks
+// using the interface. The snippet refers to the domain of each of them as
+// ###DOMAIN###.
+func (spec *Specification) AddSnippet(snippet string) {
+	if len(spec.securityTags) == 0 {
+		return
+	}
+	if spec.snippets == nil {
+		spec.snippets = make(map[string][]string)
+	}
+	for _, tag := range spec.securityTags {
+		spec.snippets[tag] = append(spec.snippets[tag], snippet)
+		sort.Strings(spec.snippets[tag])
+	}
+}
+
+// Snippets returns a deep copy of all the added snippets.
+func (spec *Specification) Snippets() map[string][]string {
+	result := make(map[string][]string, len(spec.snippets))
+	for k, v := range spec.snippets {
+		vCopy := make([]string, len(v))
+		copy(vCopy, v)
+		result[k] = vCopy
+	}
+	return result
+}
+
+// SnippetForTag returns a combined snippet for given security tag with
+// individual snippets joined with newline character and the domain of the
+// security tag substituted. Empty string is returned for non-existing
+// security tag.
+func (spec *Specification) SnippetForTag(tag string) string {
+	snippet := strings.Join(spec.snippets[tag], "\n")
+	return strings.Replace(snippet, "###DOMAIN###", DomainName(tag), -1)
+}
+
+// MissingPolicyForTag returns the sorted names of the interfaces that grant
+// AppArmor policy, but no SELinux policy, to the given security tag.
+func (spec *Specification) MissingPolicyForTag(tag string) []string {
+	missing := append([]string(nil), spec.missing[tag]...)
+	sort.Strings(missing)
+	return missing
+}
+
+// checkPolicy records the interface as missing SELinux policy for the
+// security tags that addAppArmor grants AppArmor policy to, and that got
+// no snippet since the given counts were taken with snippetCounts.
+func (spec *Specification) checkPolicy(ifaceName string, counts map[string]int, addAppArmor func(*apparmor.Specification) error) error {
+	var aaSpec apparmor.Specification
+	if err := addAppArmor(&aaSpec); err != nil {
+		return err
+	}
+	for _, tag := range aaSpec.SecurityTags() {
+		if len(spec.snippets[tag]) > counts[tag] {
+			continue
+		}
+		if spec.missing == nil {
+			spec.missing = make(map[string][]string)
+		}
+		if !strutil.ListContains(spec.missing[tag], ifaceName) {
+			spec.missing[tag] = append(spec.missing[tag], ifaceName)
+		}
+	}
+	return nil
+}
+
+// snippetCounts returns the number of snippets of the security tags in
+// scope.
+func (spec *Specification) snippetCounts() map[string]int {
+	counts := make(map[string]int, len(spec.securityTags))
+	for _, tag := range spec.securityTags {
+		counts[tag] = len(spec.snippets[tag])
+	}
+	return counts
+}
+
+// SecurityTags returns a list of security tags which have a snippet.
+func (spec *Specification) SecurityTags() []string {
+	var tags []string
+	for t := range spec.snippets {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Implementation of methods required by interfaces.Specification
+
+// AddConnectedPlug records SELinux-specific side-effects of having a connected plug.
+func (spec *Specification) AddConnectedPlug(iface interfaces.Interface, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	type definer interface {
+		SELinuxConnectedPlug(spec *Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error
+	}
+	restore := spec.setScope(plug.SecurityTags())
+	defer restore()
+	counts := spec.snippetCounts()
+	if iface, ok := iface.(definer); ok {
+		if err := iface.SELinuxConnectedPlug(spec, plug, slot); err != nil {
+			return err
+		}
+	}
+	return spec.checkPolicy(iface.Name(), counts, func(aaSpec *apparmor.Specification) error {
+		return aaSpec.AddConnectedPlug(iface, plug, slot)
+	})
+}
+
+// AddConnectedSlot records SELinux-specific side-effects of having a connected slot.
+func (spec *Specification) AddConnectedSlot(iface interfaces.Interface, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+	type definer interface {
+		SELinuxConnectedSlot(spec *Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error
+	}
+	restore := spec.setScope(slot.SecurityTags())
+	defer restore()
+	counts := spec.snippetCounts()
+	if iface, ok := iface.(definer); ok {
+		if err := iface.SELinuxConnectedSlot(spec, plug, slot); err != nil {
+			return err
+		}
+	}
+	return spec.checkPolicy(iface.Name(), counts, func(aaSpec *apparmor.Specification) error {
+		return aaSpec.AddConnectedSlot(iface, plug, slot)
+	})
+}
+
+// AddPermanentPlug records SELinux-specific side-effects of having a plug.
+func (spec *Specification) AddPermanentPlug(iface interfaces.Interface, plug *snap.PlugInfo) error {
+	type definer interface {
+		SELinuxPermanentPlug(spec *Specification, plug *snap.PlugInfo) error
+	}
+	restore := spec.setScope(plug.SecurityTags())
+	defer restore()
+	counts := spec.snippetCounts()
+	if iface, ok := iface.(definer); ok {
+		if err := iface.SELinuxPermanentPlug(spec, plug); err != nil {
+			return err
+		}
+	}
+	return spec.checkPolicy(iface.Name(), counts, func(aaSpec *apparmor.Specification) error {
+		return aaSpec.AddPermanentPlug(iface, plug)
+	})
+}
+
+// AddPermanentSlot records SELinux-specific side-effects of having a slot.
+func (spec *Specification) AddPermanentSlot(iface interfaces.Interface, slot *snap.SlotInfo) error {
+	type definer interface {
+		SELinuxPermanentSlot(spec *Specification, slot *snap.SlotInfo) error
+	}
+	restore := spec.setScope(slot.SecurityTags())
+	defer restore()
+	counts := spec.snippetCounts()
+	if iface, ok := iface.(definer); ok {
+		if err := iface.SELinuxPermanentSlot(spec, slot); err != nil {
+			return err
+		}
+	}
+	return spec.checkPolicy(iface.Name(), counts, func(aaSpec *apparmor.Specification) error {
+		return aaSpec.AddPermanentSlot(iface, slot)
+	})
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selinux_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/apparmor"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/interfaces/selinux"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+)
+
+type specSuite struct {
+	iface    *ifacetest.TestInterface
+	spec     *selinux.Specification
+	plugInfo *snap.PlugInfo
+	plug     *interfaces.ConnectedPlug
+	slotInfo *snap.SlotInfo
+	slot     *interfaces.ConnectedSlot
+}
+
+var _ = Suite(&specSuite{
+	iface: &ifacetest.TestInterface{
+		InterfaceName: "test",
+		SELinuxConnectedPlugCallback: func(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			spec.AddSnippet("(allow ###DOMAIN### connected_plug_t (file (read)))")
+			return nil
+		},
+		SELinuxConnectedSlotCallback: func(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			spec.AddSnippet("(allow ###DOMAIN### connected_slot_t (file (read)))")
+			return nil
+		},
+		SELinuxPermanentPlugCallback: func(spec *selinux.Specification, plug *snap.PlugInfo) error {
+			spec.AddSnippet("(allow ###DOMAIN### permanent_plug_t (file (read)))")
+			return nil
+		},
+		SELinuxPermanentSlotCallback: func(spec *selinux.Specification, slot *snap.SlotInfo) error {
+			spec.AddSnippet("(allow ###DOMAIN### permanent_slot_t (file (read)))")
+			return nil
+		},
+	},
+})
+
+func (s *specSuite) SetUpSuite(c *C) {
+	info1 := snaptest.MockInfo(c, `name: snap1
+version: 0
+plugs:
+    name:
+        interface: test
+apps:
+    app1:
+        command: app1
+`, nil)
+	info2 := snaptest.MockInfo(c, `name: snap2
+version: 0
+slots:
+    name:
+        interface: test
+apps:
+    app2:
+        command: app2
+`, nil)
+	s.plugInfo = info1.Plugs["name"]
+	s.slotInfo = info2.Slots["name"]
+}
+
+func (s *specSuite) SetUpTest(c *C) {
+	s.spec = &selinux.Specification{}
+	s.plug = interfaces.NewConnectedPlug(s.plugInfo, nil, nil)
+	s.slot = interfaces.NewConnectedSlot(s.slotInfo, nil, nil)
+}
+
+// The selinux.Specification can be used through the interfaces.Specification interface
+func (s *specSuite) TestSpecificationIface(c *C) {
+	var r interfaces.Specification = s.spec
+	c.Assert(r.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Assert(r.AddConnectedSlot(s.iface, s.plug, s.slot), IsNil)
+	c.Assert(r.AddPermanentPlug(s.iface, s.plugInfo), IsNil)
+	c.Assert(r.AddPermanentSlot(s.iface, s.slotInfo), IsNil)
+	c.Assert(s.spec.Snippets(), DeepEquals, map[string][]string{
+		"snap.snap1.app1": {
+			"(allow ###DOMAIN### connected_plug_t (file (read)))",
+			"(allow ###DOMAIN### permanent_plug_t (file (read)))",
+		},
+		"snap.snap2.app2": {
+			"(allow ###DOMAIN### connected_slot_t (file (read)))",
+			"(allow ###DOMAIN### permanent_slot_t (file (read)))",
+		},
+	})
+	c.Check(s.spec.SecurityTags(), DeepEquals, []string{"snap.snap1.app1", "snap.snap2.app2"})
+	c.Check(s.spec.SnippetForTag("snap.snap1.app1"), Equals,
+		"(allow snap_snap1__app1_t connected_plug_t (file (read)))\n"+
+			"(allow snap_snap1__app1_t permanent_plug_t (file (read)))")
+	c.Check(s.spec.SnippetForTag("snap.snap1.other"), Equals, "")
+}
+
+// Interfaces granting AppArmor policy but no SELinux policy are recorded
+func (s *specSuite) TestMissingPolicy(c *C) {
+	iface := &ifacetest.TestInterface{
+		InterfaceName: "apparmor-only",
+		AppArmorConnectedPlugCallback: func(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			spec.AddSnippet("/srv/** r,")
+			return nil
+		},
+		AppArmorConnectedSlotCallback: func(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			spec.AddSnippet("/srv/** r,")
+			return nil
+		},
+		SELinuxConnectedSlotCallback: func(spec *selinux.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
+			spec.AddSnippet("(allow ###DOMAIN### var_t (file (read)))")
+			return nil
+		},
+	}
+	c.Assert(s.spec.AddConnectedPlug(iface, s.plug, s.slot), IsNil)
+	c.Assert(s.spec.AddConnectedSlot(iface, s.plug, s.slot), IsNil)
+	// the test interface grants SELinux policy as well
+	c.Assert(s.spec.AddConnectedPlug(s.iface, s.plug, s.slot), IsNil)
+	c.Check(s.spec.MissingPolicyForTag("snap.snap1.app1"), DeepEquals, []string{"apparmor-only"})
+	c.Check(s.spec.MissingPolicyForTag("snap.snap2.app2"), HasLen, 0)
+}
+
+// AddSnippet outside of the scope of an interface does nothing
+func (s *specSuite) TestAddSnippetWithoutScope(c *C) {
+	s.spec.AddSnippet("(allow ###DOMAIN### foo_t (file (read)))")
+	c.Check(s.spec.Snippets(), HasLen, 0)
+}
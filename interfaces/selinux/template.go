@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package selinux
+
+// domainTemplate is the base policy of the domain of each snap application
+// and hook, in the Common Intermediate Language (CIL). It mirrors the
+// default AppArmor template: the snap can run the programs and read the
+// files of the snap and of its base, read and write its own data and use
+// basic process, terminal and local IPC facilities. Anything else is granted
+// by the connected interfaces.
+//
+// The types referred to by the template are defined by the snappy policy
+// module and the base policy of the distribution.
+const domainTemplate = `
+; domain of ###SECURITY_TAG###
+(type ###DOMAIN###)
+(roletype system_r ###DOMAIN###)
+(roletype unconfined_r ###DOMAIN###)
+
+; snap-confine switches to the domain when executing snap-exec
+(allow snappy_confine_t ###DOMAIN### (process (transition noatsecure rlimitinh siginh)))
+(allow ###DOMAIN### snappy_confine_t (fd (use)))
+(allow ###DOMAIN### snappy_confine_t (process (sigchld)))
+(allow ###DOMAIN### snappy_exec_t (file (entrypoint getattr open read execute map)))
+(allow ###DOMAIN### snappy_snap_t (file (entrypoint)))
+
+; the process itself
+(allow ###DOMAIN### self (process (fork sigchld sigkill sigstop signull signal getsched setsched getpgid setpgid getcap getattr getrlimit setrlimit)))
+(allow ###DOMAIN### self (fifo_file (getattr open read write ioctl append)))
+(allow ###DOMAIN### self (unix_stream_socket (create bind listen accept connect getattr getopt setopt read write shutdown)))
+(allow ###DOMAIN### self (unix_dgram_socket (create bind connect getattr getopt setopt read write sendto)))
+(allow ###DOMAIN### self (dir (getattr open read search)))
+(allow ###DOMAIN### self (file (getattr open read)))
+(allow ###DOMAIN### self (lnk_file (getattr read)))
+(allow ###DOMAIN### proc_t (dir (getattr search)))
+(allow ###DOMAIN### proc_t (file (getattr open read)))
+
+; the snap and its base
+(allow ###DOMAIN### snappy_snap_t (dir (getattr open read search)))
+(allow ###DOMAIN### snappy_snap_t (file (getattr open read execute execute_no_trans map)))
+(allow ###DOMAIN### snappy_snap_t (lnk_file (getattr read)))
+
+; system configuration
+(allow ###DOMAIN### etc_t (dir (getattr open read search)))
+(allow ###DOMAIN### etc_t (file (getattr open read map)))
+(allow ###DOMAIN### etc_t (lnk_file (getattr read)))
+
+; the data of the snap, in /var/snap and in the home directory, and the
+; private /tmp
+(allow ###DOMAIN### snappy_var_t (dir (getattr open read search write add_name remove_name create rmdir setattr)))
+(allow ###DOMAIN### snappy_var_t (file (getattr open read write append create unlink rename setattr lock map)))
+(allow ###DOMAIN### snappy_var_t (lnk_file (getattr read create unlink)))
+(allow ###DOMAIN### snappy_home_t (dir (getattr open read search write add_name remove_name create rmdir setattr)))
+(allow ###DOMAIN### snappy_home_t (file (getattr open read write append create unlink rename setattr lock map)))
+(allow ###DOMAIN### snappy_home_t (lnk_file (getattr read create unlink)))
+(allow ###DOMAIN### tmp_t (dir (getattr open read search write add_name remove_name create rmdir setattr)))
+(allow ###DOMAIN### tmp_t (file (getattr open read write append create unlink rename setattr lock map)))
+
+; files in /dev/shm, for shm_open() and sem_open(), and the snap-specific
+; XDG_RUNTIME_DIR in /run/user, the labels of these are not specific to the
+; snap unlike the paths AppArmor allows
+(allow ###DOMAIN### tmpfs_t (dir (getattr open read search write add_name remove_name create rmdir)))
+(allow ###DOMAIN### tmpfs_t (file (getattr open read write append create unlink rename setattr lock map)))
+(allow ###DOMAIN### user_tmp_t (dir (getattr open read search write add_name remove_name create rmdir setattr)))
+(allow ###DOMAIN### user_tmp_t (file (getattr open read write append create unlink rename setattr lock map execute)))
+(allow ###DOMAIN### user_tmp_t (lnk_file (getattr read create unlink)))
+(allow ###DOMAIN### user_tmp_t (sock_file (getattr create unlink write)))
+
+; read-only system information in /sys, like the CPU and memory topology
+(allow ###DOMAIN### sysfs_t (dir (getattr open read search)))
+(allow ###DOMAIN### sysfs_t (file (getattr open read)))
+(allow ###DOMAIN### sysfs_t (lnk_file (getattr read)))
+
+; basic devices and the terminal
+(allow ###DOMAIN### null_device_t (chr_file (getattr open read write ioctl append)))
+(allow ###DOMAIN### zero_device_t (chr_file (getattr open read map)))
+(allow ###DOMAIN### random_device_t (chr_file (getattr open read)))
+(allow ###DOMAIN### urandom_device_t (chr_file (getattr open read)))
+(allow ###DOMAIN### user_devpts_t (chr_file (getattr read write ioctl append)))
+(allow ###DOMAIN### devpts_t (dir (getattr search)))
+`
+
+// permissiveTemplate makes violations of the policy of the domain non-fatal,
+// they are only logged.
+const permissiveTemplate = `
+(typepermissive ###DOMAIN###)
+`
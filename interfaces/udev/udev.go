@@ -22,6 +22,8 @@ package udev
 import (
 	"fmt"
 	"os/exec"
+
+	"github.com/snapcore/snapd/snapdenv"
 )
 
 // ReloadRules runs three commands that reload udev rule database.
@@ -32,7 +34,13 @@ import (
 // and optionally trigger other subsystems as defined in the interfaces. Eg:
 //                   udevadm trigger --subsystem-match=input
 //                   udevadm trigger --property-match=ID_INPUT_JOYSTICK=1
+//
+// Nothing is done when preseeding, the rules are picked up on first boot.
 func ReloadRules(subsystemTriggers []string) error {
+	if snapdenv.Preseeding() {
+		return nil
+	}
+
 	output, err := exec.Command("udevadm", "control", "--reload-rules").CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("cannot reload udev rules: %s\nudev output:\n%s", err, string(output))
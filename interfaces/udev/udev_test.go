@@ -25,6 +25,7 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/interfaces/udev"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -53,6 +54,16 @@ func (s *uDevSuite) TestReloadUDevRulesRunsUDevAdm(c *C) {
 	})
 }
 
+func (s *uDevSuite) TestReloadUDevRulesPreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	cmd := testutil.MockCommand(c, "udevadm", "")
+	defer cmd.Restore()
+	err := udev.ReloadRules([]string{"input"})
+	c.Assert(err, IsNil)
+	c.Check(cmd.Calls(), HasLen, 0)
+}
+
 func (s *uDevSuite) TestReloadUDevRulesReportsErrorsFromReloadRules(c *C) {
 	cmd := testutil.MockCommand(c, "udevadm", `
 if [ "$1" = "control" ]; then
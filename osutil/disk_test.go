@@ -0,0 +1,41 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil_test
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+type diskSuite struct{}
+
+var _ = Suite(&diskSuite{})
+
+func (diskSuite) TestFreeSpace(c *C) {
+	free, err := osutil.FreeSpace(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Check(free > 0, Equals, true)
+
+	_, err = osutil.FreeSpace(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, ErrorMatches, "no such file or directory")
+}
@@ -155,3 +155,11 @@ func MockUname(f func(*syscall.Utsname) error) (restore func()) {
 		syscallUname = old
 	}
 }
+
+func MockPasswdFiles(passwd, group []string) (restore func()) {
+	oldPasswd, oldGroup := passwdFiles, groupFiles
+	passwdFiles, groupFiles = passwd, group
+	return func() {
+		passwdFiles, groupFiles = oldPasswd, oldGroup
+	}
+}
@@ -19,36 +19,24 @@
 
 package osutil
 
-import (
-	"os/user"
-	"strconv"
-)
-
-// TODO: the builtin os/user functions only look at /etc/passwd and /etc/group
-// which is fine for our purposes today. In the future we may want to support
-// lookups in extrausers, which is configured via nsswitch.conf. Since snapd
-// does not support being built with cgo itself, when we want to support
-// extrausers here, we can convert these to do the equivalent of:
-//
-//   getent passwd <user> | cut -d : -f 3
-//   getent group <group> | cut -d : -f 3
-
-// FindUid returns the identifier of the given UNIX user name.
+// FindUid returns the identifier of the given UNIX user name, looking
+// in both /etc/passwd and extrausers.
 func FindUid(username string) (uint64, error) {
-	user, err := user.Lookup(username)
+	pw, err := LookupPasswd(username)
 	if err != nil {
 		return 0, err
 	}
 
-	return strconv.ParseUint(user.Uid, 10, 64)
+	return uint64(pw.Uid), nil
 }
 
-// FindGid returns the identifier of the given UNIX group name.
+// FindGid returns the identifier of the given UNIX group name, looking
+// in both /etc/group and extrausers.
 func FindGid(groupname string) (uint64, error) {
-	group, err := user.LookupGroup(groupname)
+	gr, err := LookupGroup(groupname)
 	if err != nil {
 		return 0, err
 	}
 
-	return strconv.ParseUint(group.Gid, 10, 64)
+	return uint64(gr.Gid), nil
 }
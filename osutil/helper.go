@@ -0,0 +1,188 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// DefaultHelperTimeout is the maximum runtime of a helper binary when
+// the HelperCommand does not specify one.
+var DefaultHelperTimeout = 10 * time.Minute
+
+// HelperCommand describes an invocation of a helper binary, such as
+// mkfs, sfdisk or mount.
+type HelperCommand struct {
+	// Name is the name or path of the helper binary.
+	Name string
+	// Args are the arguments passed to the helper.
+	Args []string
+	// Env holds additional environment entries, added to os.Environ.
+	Env []string
+	// Stdin is fed to the standard input of the helper, if set.
+	Stdin io.Reader
+	// Stdout receives the standard output of the helper, if set,
+	// which is then not part of the output returned by RunHelper.
+	Stdout io.Writer
+	// Timeout is the maximum runtime of the helper, after which it
+	// is killed. DefaultHelperTimeout is used when unset.
+	Timeout time.Duration
+}
+
+// HelperErrorKind classifies the failure of a helper binary.
+type HelperErrorKind int
+
+const (
+	// HelperFailed is used when the helper exited with a non-zero
+	// status or was killed by a signal.
+	HelperFailed HelperErrorKind = iota
+	// HelperNotFound is used when the helper binary does not exist.
+	HelperNotFound
+	// HelperCannotRun is used when the helper could not be started
+	// for any other reason.
+	HelperCannotRun
+	// HelperTimedOut is used when the helper was killed after
+	// exceeding its maximum runtime.
+	HelperTimedOut
+)
+
+// HelperError is returned by RunHelper when a helper binary fails.
+type HelperError struct {
+	// Name is the name of the helper binary.
+	Name string
+	Kind HelperErrorKind
+	// ExitCode is the exit status of a helper that failed, or -1
+	// if it was not available.
+	ExitCode int
+	// Output is the combined output of the helper.
+	Output []byte
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *HelperError) Error() string {
+	switch e.Kind {
+	case HelperNotFound:
+		return fmt.Sprintf("cannot find helper %q", e.Name)
+	case HelperCannotRun:
+		return fmt.Sprintf("cannot run helper %q: %v", e.Name, e.Err)
+	case HelperTimedOut:
+		msg := fmt.Sprintf("helper %q %v", e.Name, e.Err)
+		if len(bytes.TrimSpace(e.Output)) > 0 {
+			return fmt.Sprintf("%s: %v", msg, OutputErr(e.Output, nil))
+		}
+		return msg
+	}
+	return OutputErr(e.Output, e.Err).Error()
+}
+
+var runHelper = runHelperImpl
+
+// RunHelper runs the given helper binary, waiting for it to complete
+// or to exceed its timeout, and returns its combined standard output
+// and standard error. Failures are reported as *HelperError.
+func RunHelper(hc *HelperCommand) ([]byte, error) {
+	return runHelper(hc)
+}
+
+// MockRunHelper replaces the function used to run helper binaries with
+// the given one, for tests.
+func MockRunHelper(f func(hc *HelperCommand) ([]byte, error)) (restore func()) {
+	old := runHelper
+	runHelper = f
+	return func() {
+		runHelper = old
+	}
+}
+
+func isNotFound(err error) bool {
+	if execErr, ok := err.(*exec.Error); ok {
+		return execErr.Err == exec.ErrNotFound
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == syscall.ENOENT
+	}
+	return false
+}
+
+func runHelperImpl(hc *HelperCommand) ([]byte, error) {
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHelperTimeout
+	}
+
+	cmd := exec.Command(hc.Name, hc.Args...)
+	if len(hc.Env) > 0 {
+		cmd.Env = append(os.Environ(), hc.Env...)
+	}
+	cmd.Stdin = hc.Stdin
+	// use a process group so that helpers spawning children (like
+	// fakeroot) can be killed as a whole on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	if hc.Stdout != nil {
+		cmd.Stdout = hc.Stdout
+	}
+	cmd.Stderr = &buf
+
+	if err := cmd.Start(); err != nil {
+		kind := HelperCannotRun
+		if isNotFound(err) {
+			kind = HelperNotFound
+		}
+		return nil, &HelperError{Name: hc.Name, Kind: kind, ExitCode: -1, Err: err}
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitCh:
+		if err != nil {
+			exitCode, exitErr := ExitCode(err)
+			if exitErr != nil {
+				exitCode = -1
+			}
+			return buf.Bytes(), &HelperError{Name: hc.Name, Kind: HelperFailed, ExitCode: exitCode, Output: buf.Bytes(), Err: err}
+		}
+		return buf.Bytes(), nil
+	case <-time.After(timeout):
+	}
+
+	if err := KillProcessGroup(cmd); err != nil {
+		return nil, &HelperError{Name: hc.Name, Kind: HelperTimedOut, ExitCode: -1, Err: fmt.Errorf("exceeded maximum runtime of %s and cannot be killed: %v", timeout, err)}
+	}
+	select {
+	case <-waitCh:
+	case <-time.After(cmdWaitTimeout):
+		// the output buffer may still be written to, do not use it
+		return nil, &HelperError{Name: hc.Name, Kind: HelperTimedOut, ExitCode: -1, Err: fmt.Errorf("exceeded maximum runtime of %s, but did not stop", timeout)}
+	}
+	return buf.Bytes(), &HelperError{Name: hc.Name, Kind: HelperTimedOut, ExitCode: -1, Output: buf.Bytes(), Err: fmt.Errorf("exceeded maximum runtime of %s", timeout)}
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type helperSuite struct{}
+
+var _ = Suite(&helperSuite{})
+
+func (s *helperSuite) TestRunHelperHappy(c *C) {
+	out, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:  "sh",
+		Args:  []string{"-c", "echo $FOO; cat; echo err >&2"},
+		Env:   []string{"FOO=42"},
+		Stdin: bytes.NewBufferString("input\n"),
+	})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "42\ninput\nerr\n")
+}
+
+func (s *helperSuite) TestRunHelperStdout(c *C) {
+	var stdout bytes.Buffer
+	out, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:   "sh",
+		Args:   []string{"-c", "echo out; echo err >&2"},
+		Stdout: &stdout,
+	})
+	c.Assert(err, IsNil)
+	c.Check(stdout.String(), Equals, "out\n")
+	c.Check(string(out), Equals, "err\n")
+}
+
+func (s *helperSuite) TestRunHelperFailed(c *C) {
+	cmd := testutil.MockCommand(c, "mkfs.foo", "echo 'bad things'; exit 3")
+	defer cmd.Restore()
+
+	out, err := osutil.RunHelper(&osutil.HelperCommand{Name: "mkfs.foo", Args: []string{"img"}})
+	c.Assert(err, ErrorMatches, "bad things")
+	c.Check(string(out), Equals, "bad things\n")
+	herr, ok := err.(*osutil.HelperError)
+	c.Assert(ok, Equals, true)
+	c.Check(herr.Kind, Equals, osutil.HelperFailed)
+	c.Check(herr.ExitCode, Equals, 3)
+	c.Check(herr.Name, Equals, "mkfs.foo")
+	c.Check(cmd.Calls(), DeepEquals, [][]string{{"mkfs.foo", "img"}})
+}
+
+func (s *helperSuite) TestRunHelperFailedNoOutput(c *C) {
+	_, err := osutil.RunHelper(&osutil.HelperCommand{Name: "false"})
+	c.Assert(err, ErrorMatches, "exit status 1")
+	c.Check(err.(*osutil.HelperError).ExitCode, Equals, 1)
+}
+
+func (s *helperSuite) TestRunHelperNotFound(c *C) {
+	_, err := osutil.RunHelper(&osutil.HelperCommand{Name: "no-such-helper-really"})
+	c.Assert(err, ErrorMatches, `cannot find helper "no-such-helper-really"`)
+	c.Check(err.(*osutil.HelperError).Kind, Equals, osutil.HelperNotFound)
+
+	_, err = osutil.RunHelper(&osutil.HelperCommand{Name: filepath.Join(c.MkDir(), "missing")})
+	c.Assert(err, ErrorMatches, `cannot find helper ".*/missing"`)
+	c.Check(err.(*osutil.HelperError).Kind, Equals, osutil.HelperNotFound)
+}
+
+func (s *helperSuite) TestRunHelperCannotRun(c *C) {
+	notExec := filepath.Join(c.MkDir(), "not-exec")
+	c.Assert(ioutil.WriteFile(notExec, nil, 0644), IsNil)
+
+	_, err := osutil.RunHelper(&osutil.HelperCommand{Name: notExec})
+	c.Assert(err, ErrorMatches, `cannot run helper ".*/not-exec": .*permission denied`)
+	c.Check(err.(*osutil.HelperError).Kind, Equals, osutil.HelperCannotRun)
+}
+
+func (s *helperSuite) TestRunHelperTimeout(c *C) {
+	out, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:    "sh",
+		Args:    []string{"-c", "echo started; sleep 10"},
+		Timeout: 100 * time.Millisecond,
+	})
+	c.Assert(err, ErrorMatches, `helper "sh" exceeded maximum runtime of 100ms: started`)
+	c.Check(string(out), Equals, "started\n")
+	c.Check(err.(*osutil.HelperError).Kind, Equals, osutil.HelperTimedOut)
+}
+
+func (s *helperSuite) TestMockRunHelper(c *C) {
+	var calls []*osutil.HelperCommand
+	restore := osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		calls = append(calls, hc)
+		return []byte("mocked"), nil
+	})
+	defer restore()
+
+	out, err := osutil.RunHelper(&osutil.HelperCommand{Name: "mount", Args: []string{"/dev/foo", "/mnt"}})
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "mocked")
+	c.Assert(calls, HasLen, 1)
+	c.Check(calls[0].Name, Equals, "mount")
+	c.Check(calls[0].Args, DeepEquals, []string{"/dev/foo", "/mnt"})
+
+	restore()
+	_, err = osutil.RunHelper(&osutil.HelperCommand{Name: "no-such-helper-really"})
+	c.Check(err, ErrorMatches, "cannot find helper .*")
+}
@@ -212,6 +212,10 @@ func MountOptsToCommonFlags(opts []string) (flags int, unparsed []string) {
 			flags |= syscall.MS_SHARED
 		case "rshared":
 			flags |= syscall.MS_SHARED | syscall.MS_REC
+		case "unbindable":
+			flags |= syscall.MS_UNBINDABLE
+		case "runbindable":
+			flags |= syscall.MS_UNBINDABLE | syscall.MS_REC
 		case "relatime":
 			flags |= syscall.MS_RELATIME
 		case "strictatime":
@@ -200,6 +200,9 @@ func (s *entrySuite) TestMountOptsToCommonFlags(c *C) {
 	flags, unparsed = osutil.MountOptsToCommonFlags([]string{"ro", "nodev", "nosuid"})
 	c.Assert(flags, Equals, syscall.MS_RDONLY|syscall.MS_NODEV|syscall.MS_NOSUID)
 	c.Assert(unparsed, HasLen, 0)
+	flags, unparsed = osutil.MountOptsToCommonFlags([]string{"bind", "runbindable"})
+	c.Assert(flags, Equals, syscall.MS_BIND|syscall.MS_UNBINDABLE|syscall.MS_REC)
+	c.Assert(unparsed, HasLen, 0)
 	flags, unparsed = osutil.MountOptsToCommonFlags([]string{"bogus"})
 	c.Assert(flags, Equals, 0)
 	c.Assert(unparsed, DeepEquals, []string{"bogus"})
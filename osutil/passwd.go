@@ -0,0 +1,272 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/osutil/sys"
+)
+
+// The user and group databases are read directly from these files, in
+// order, without going through NSS. This works the same in early boot
+// and in statically linked helpers, and covers the users created with
+// --extrausers on Ubuntu Core.
+var (
+	passwdFiles = []string{"/etc/passwd", "/var/lib/extrausers/passwd"}
+	groupFiles  = []string{"/etc/group", "/var/lib/extrausers/group"}
+)
+
+// PasswdEntry is an entry of the user database, see passwd(5).
+type PasswdEntry struct {
+	Name  string
+	Uid   sys.UserID
+	Gid   sys.GroupID
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// GroupEntry is an entry of the group database, see group(5).
+type GroupEntry struct {
+	Name    string
+	Gid     sys.GroupID
+	Members []string
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err
+}
+
+func parsePasswdLine(fields []string) (interface{}, error) {
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("expected 7 fields, got %d", len(fields))
+	}
+	uid, err := parseID(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q", fields[2])
+	}
+	gid, err := parseID(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q", fields[3])
+	}
+	return &PasswdEntry{
+		Name:  fields[0],
+		Uid:   sys.UserID(uid),
+		Gid:   sys.GroupID(gid),
+		Gecos: fields[4],
+		Home:  fields[5],
+		Shell: fields[6],
+	}, nil
+}
+
+func parseGroupLine(fields []string) (interface{}, error) {
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("expected 4 fields, got %d", len(fields))
+	}
+	gid, err := parseID(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q", fields[2])
+	}
+	var members []string
+	if fields[3] != "" {
+		members = strings.Split(fields[3], ",")
+	}
+	return &GroupEntry{
+		Name:    fields[0],
+		Gid:     sys.GroupID(gid),
+		Members: members,
+	}, nil
+}
+
+// readDatabaseFile reads the entries of a colon separated database
+// file. A missing file has no entries. NIS compat entries, starting with
+// "+" or "-", are skipped, and so are malformed lines, so that one bad
+// line does not hide all the other entries.
+func readDatabaseFile(fn string, parse func([]string) (interface{}, error)) ([]interface{}, error) {
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		entry, err := parse(strings.Split(line, ":"))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fn, err)
+	}
+	return entries, nil
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	entries []interface{}
+}
+
+// databaseCache keeps the parsed entries of database files until they
+// change on disk.
+type databaseCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedFile
+}
+
+func (c *databaseCache) entries(fns []string, parse func([]string) (interface{}, error)) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files == nil {
+		c.files = make(map[string]*cachedFile)
+	}
+
+	var all []interface{}
+	for _, fn := range fns {
+		fi, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			delete(c.files, fn)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cached := c.files[fn]
+		if cached == nil || !cached.modTime.Equal(fi.ModTime()) || cached.size != fi.Size() {
+			entries, err := readDatabaseFile(fn, parse)
+			if err != nil {
+				return nil, err
+			}
+			cached = &cachedFile{modTime: fi.ModTime(), size: fi.Size(), entries: entries}
+			c.files[fn] = cached
+		}
+		all = append(all, cached.entries...)
+	}
+	return all, nil
+}
+
+var (
+	passwdCache databaseCache
+	groupCache  databaseCache
+)
+
+func findPasswd(match func(*PasswdEntry) bool) (*PasswdEntry, error) {
+	entries, err := passwdCache.entries(passwdFiles, parsePasswdLine)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if pw := e.(*PasswdEntry); match(pw) {
+			return pw, nil
+		}
+	}
+	return nil, nil
+}
+
+func findGroup(match func(*GroupEntry) bool) (*GroupEntry, error) {
+	entries, err := groupCache.entries(groupFiles, parseGroupLine)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if gr := e.(*GroupEntry); match(gr) {
+			return gr, nil
+		}
+	}
+	return nil, nil
+}
+
+// LookupPasswd returns the entry of the given user name, from
+// /etc/passwd or from extrausers. If there is no such user the error
+// is a user.UnknownUserError.
+func LookupPasswd(name string) (*PasswdEntry, error) {
+	pw, err := findPasswd(func(pw *PasswdEntry) bool { return pw.Name == name })
+	if err != nil {
+		return nil, err
+	}
+	if pw == nil {
+		return nil, user.UnknownUserError(name)
+	}
+	return pw, nil
+}
+
+// LookupPasswdByUid returns the entry of the given user id, from
+// /etc/passwd or from extrausers. If there is no such user the error
+// is a user.UnknownUserIdError.
+func LookupPasswdByUid(uid sys.UserID) (*PasswdEntry, error) {
+	pw, err := findPasswd(func(pw *PasswdEntry) bool { return pw.Uid == uid })
+	if err != nil {
+		return nil, err
+	}
+	if pw == nil {
+		return nil, user.UnknownUserIdError(int(uid))
+	}
+	return pw, nil
+}
+
+// LookupGroup returns the entry of the given group name, from
+// /etc/group or from extrausers. If there is no such group the error
+// is a user.UnknownGroupError.
+func LookupGroup(name string) (*GroupEntry, error) {
+	gr, err := findGroup(func(gr *GroupEntry) bool { return gr.Name == name })
+	if err != nil {
+		return nil, err
+	}
+	if gr == nil {
+		return nil, user.UnknownGroupError(name)
+	}
+	return gr, nil
+}
+
+// LookupGroupByGid returns the entry of the given group id, from
+// /etc/group or from extrausers. If there is no such group the error
+// is a user.UnknownGroupIdError.
+func LookupGroupByGid(gid sys.GroupID) (*GroupEntry, error) {
+	gr, err := findGroup(func(gr *GroupEntry) bool { return gr.Gid == gid })
+	if err != nil {
+		return nil, err
+	}
+	if gr == nil {
+		return nil, user.UnknownGroupIdError(strconv.FormatUint(uint64(gid), 10))
+	}
+	return gr, nil
+}
@@ -0,0 +1,219 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/sys"
+)
+
+type passwdSuite struct {
+	etcPasswd, etcGroup     string
+	extraPasswd, extraGroup string
+	restore                 func()
+}
+
+var _ = Suite(&passwdSuite{})
+
+const etcPasswd = `root:x:0:0:root:/root:/bin/bash
+# a comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+
+snap_daemon:x:584788:584788::/nonexistent:/bin/false
+`
+
+const etcGroup = `root:x:0:
+daemon:x:1:
+sudo:x:27:
+snap_daemon:x:584788:
+`
+
+const extraPasswd = `ubuntu:x:1000:1000:Ubuntu User,,,:/home/ubuntu:/bin/bash
+`
+
+const extraGroup = `ubuntu:x:1000:
+sudo:x:27:ubuntu,other
+`
+
+func (s *passwdSuite) SetUpTest(c *C) {
+	d := c.MkDir()
+	s.etcPasswd = filepath.Join(d, "etc-passwd")
+	s.etcGroup = filepath.Join(d, "etc-group")
+	s.extraPasswd = filepath.Join(d, "extra-passwd")
+	s.extraGroup = filepath.Join(d, "extra-group")
+	c.Assert(ioutil.WriteFile(s.etcPasswd, []byte(etcPasswd), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(s.etcGroup, []byte(etcGroup), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(s.extraPasswd, []byte(extraPasswd), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(s.extraGroup, []byte(extraGroup), 0644), IsNil)
+
+	s.restore = osutil.MockPasswdFiles([]string{s.etcPasswd, s.extraPasswd}, []string{s.etcGroup, s.extraGroup})
+}
+
+func (s *passwdSuite) TearDownTest(c *C) {
+	s.restore()
+}
+
+func (s *passwdSuite) TestLookupPasswd(c *C) {
+	pw, err := osutil.LookupPasswd("snap_daemon")
+	c.Assert(err, IsNil)
+	c.Check(pw, DeepEquals, &osutil.PasswdEntry{
+		Name:  "snap_daemon",
+		Uid:   584788,
+		Gid:   584788,
+		Home:  "/nonexistent",
+		Shell: "/bin/false",
+	})
+
+	// extrausers
+	pw, err = osutil.LookupPasswd("ubuntu")
+	c.Assert(err, IsNil)
+	c.Check(pw, DeepEquals, &osutil.PasswdEntry{
+		Name:  "ubuntu",
+		Uid:   1000,
+		Gid:   1000,
+		Gecos: "Ubuntu User,,,",
+		Home:  "/home/ubuntu",
+		Shell: "/bin/bash",
+	})
+
+	_, err = osutil.LookupPasswd("nobody")
+	c.Check(err, FitsTypeOf, user.UnknownUserError(""))
+	c.Check(err, ErrorMatches, "user: unknown user nobody")
+}
+
+func (s *passwdSuite) TestLookupPasswdByUid(c *C) {
+	pw, err := osutil.LookupPasswdByUid(1)
+	c.Assert(err, IsNil)
+	c.Check(pw.Name, Equals, "daemon")
+
+	pw, err = osutil.LookupPasswdByUid(1000)
+	c.Assert(err, IsNil)
+	c.Check(pw.Name, Equals, "ubuntu")
+
+	_, err = osutil.LookupPasswdByUid(4242)
+	c.Check(err, FitsTypeOf, user.UnknownUserIdError(0))
+}
+
+func (s *passwdSuite) TestLookupGroup(c *C) {
+	gr, err := osutil.LookupGroup("daemon")
+	c.Assert(err, IsNil)
+	c.Check(gr, DeepEquals, &osutil.GroupEntry{Name: "daemon", Gid: 1})
+
+	// the first entry wins
+	gr, err = osutil.LookupGroup("sudo")
+	c.Assert(err, IsNil)
+	c.Check(gr, DeepEquals, &osutil.GroupEntry{Name: "sudo", Gid: 27})
+
+	gr, err = osutil.LookupGroup("ubuntu")
+	c.Assert(err, IsNil)
+	c.Check(gr.Gid, Equals, sys.GroupID(1000))
+
+	_, err = osutil.LookupGroup("nogroup")
+	c.Check(err, FitsTypeOf, user.UnknownGroupError(""))
+}
+
+func (s *passwdSuite) TestLookupGroupByGid(c *C) {
+	gr, err := osutil.LookupGroupByGid(584788)
+	c.Assert(err, IsNil)
+	c.Check(gr.Name, Equals, "snap_daemon")
+
+	_, err = osutil.LookupGroupByGid(4242)
+	c.Check(err, FitsTypeOf, user.UnknownGroupIdError(""))
+	c.Check(err, ErrorMatches, "group: unknown groupid 4242")
+}
+
+func (s *passwdSuite) TestGroupMembers(c *C) {
+	restore := osutil.MockPasswdFiles(nil, []string{s.extraGroup})
+	defer restore()
+
+	gr, err := osutil.LookupGroup("sudo")
+	c.Assert(err, IsNil)
+	c.Check(gr.Members, DeepEquals, []string{"ubuntu", "other"})
+}
+
+func (s *passwdSuite) TestMissingFiles(c *C) {
+	c.Assert(os.Remove(s.extraPasswd), IsNil)
+
+	uid, err := osutil.FindUid("root")
+	c.Assert(err, IsNil)
+	c.Check(uid, Equals, uint64(0))
+
+	_, err = osutil.FindUid("ubuntu")
+	c.Check(err, ErrorMatches, "user: unknown user ubuntu")
+}
+
+func (s *passwdSuite) TestFindUidGid(c *C) {
+	uid, err := osutil.FindUid("ubuntu")
+	c.Assert(err, IsNil)
+	c.Check(uid, Equals, uint64(1000))
+
+	gid, err := osutil.FindGid("snap_daemon")
+	c.Assert(err, IsNil)
+	c.Check(gid, Equals, uint64(584788))
+}
+
+func (s *passwdSuite) TestCacheNoticesChanges(c *C) {
+	_, err := osutil.LookupPasswd("ubuntu")
+	c.Assert(err, IsNil)
+
+	c.Assert(ioutil.WriteFile(s.extraPasswd, []byte("other:x:1001:1001::/home/other:/bin/sh\n"), 0644), IsNil)
+	// make sure the change is noticed even on coarse timestamps
+	future := time.Now().Add(time.Minute)
+	c.Assert(os.Chtimes(s.extraPasswd, future, future), IsNil)
+
+	_, err = osutil.LookupPasswd("ubuntu")
+	c.Check(err, ErrorMatches, "user: unknown user ubuntu")
+	pw, err := osutil.LookupPasswd("other")
+	c.Assert(err, IsNil)
+	c.Check(pw.Uid, Equals, sys.UserID(1001))
+}
+
+func (s *passwdSuite) TestInvalidEntries(c *C) {
+	c.Assert(ioutil.WriteFile(s.extraPasswd, []byte("broken:x:nope:0::/:/bin/sh\nother:x:1001:1001::/home/other:/bin/sh\n"), 0644), IsNil)
+	pw, err := osutil.LookupPasswd("other")
+	c.Assert(err, IsNil)
+	c.Check(pw.Uid, Equals, sys.UserID(1001))
+	_, err = osutil.LookupPasswd("broken")
+	c.Check(err, ErrorMatches, "user: unknown user broken")
+
+	c.Assert(ioutil.WriteFile(s.etcGroup, []byte("root:x:0\nadm:x:4:ubuntu\n"), 0644), IsNil)
+	gr, err := osutil.LookupGroup("adm")
+	c.Assert(err, IsNil)
+	c.Check(gr.Gid, Equals, sys.GroupID(4))
+	_, err = osutil.LookupGroup("root")
+	c.Check(err, ErrorMatches, "group: unknown group root")
+}
+
+func (s *passwdSuite) TestNISCompatEntries(c *C) {
+	c.Assert(ioutil.WriteFile(s.extraPasswd, []byte("+other\n-someone\n+::::::\nother:x:1001:1001::/home/other:/bin/sh\n"), 0644), IsNil)
+	pw, err := osutil.LookupPasswd("other")
+	c.Assert(err, IsNil)
+	c.Check(pw.Uid, Equals, sys.UserID(1001))
+	_, err = osutil.LookupPasswd("+other")
+	c.Check(err, ErrorMatches, "user: unknown user \\+other")
+}
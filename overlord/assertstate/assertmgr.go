@@ -21,6 +21,7 @@ package assertstate
 
 import (
 	"fmt"
+	"time"
 
 	"gopkg.in/tomb.v2"
 
@@ -80,6 +81,13 @@ func DB(s *state.State) asserts.RODatabase {
 	return cachedDB(s)
 }
 
+// SetEarliestTime sets the time the system assertion database assumes
+// the current time to be at least when checking key expiration, see
+// asserts.Database.SetEarliestTime. The state must be locked.
+func SetEarliestTime(s *state.State, earliest time.Time) {
+	cachedDB(s).SetEarliestTime(earliest)
+}
+
 // doValidateSnap fetches the relevant assertions for the snap being installed and cross checks them with the snap.
 func doValidateSnap(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
@@ -25,21 +25,24 @@ package assertstate
 import (
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/snapasserts"
 	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 // Add the given assertion to the system assertion database.
 func Add(s *state.State, a asserts.Assertion) error {
 	// TODO: deal together with asserts itself with (cascading) side effects of possible assertion updates
-	return cachedDB(s).Add(a)
+	return countCommit(s, addCounted(cachedDB(s), a, metrics(s)))
 }
 
 // Batch allows to accumulate a set of assertions possibly out of prerequisite order and then add them in one go to the system assertion database.
@@ -65,7 +68,9 @@ func (b *Batch) committing() error {
 	return nil
 }
 
-// Add one assertion to the batch.
+// Add one assertion to the batch. It returns an
+// *asserts.UnsupportedFormatError if the assertion format is not
+// supported.
 func (b *Batch) Add(a asserts.Assertion) error {
 	if err := b.committing(); err != nil {
 		return err
@@ -80,6 +85,7 @@ func (b *Batch) Add(a asserts.Assertion) error {
 				// we already got something more recent
 				return nil
 			}
+			return &RevisionConflictError{Ref: a.Ref(), Revision: revErr.Used, Current: revErr.Current}
 		}
 		return err
 	}
@@ -117,7 +123,7 @@ func (b *Batch) AddStream(r io.Reader) ([]*asserts.Ref, error) {
 	return refs, nil
 }
 
-func (b *Batch) commitTo(db *asserts.Database) error {
+func (b *Batch) commitTo(db *asserts.Database, m *Metrics) error {
 	if err := b.linearize(db); err != nil {
 		return err
 	}
@@ -125,7 +131,7 @@ func (b *Batch) commitTo(db *asserts.Database) error {
 	// TODO: trigger w. caller a global sanity check if something is revoked
 	// (but try to save as much possible still),
 	// or err is a check error
-	return commitTo(db, b.linearized)
+	return commitTo(db, b.linearized, m)
 }
 
 func (b *Batch) linearize(db *asserts.Database) error {
@@ -139,8 +145,11 @@ func (b *Batch) linearize(db *asserts.Database) error {
 			// fallback to pre-existing assertions
 			a, err = ref.Resolve(db.Find)
 		}
+		if asserts.IsNotFound(err) {
+			return nil, &PrerequisiteError{Ref: ref}
+		}
 		if err != nil {
-			return nil, findError("cannot find %s", ref, err)
+			return nil, &PrerequisiteError{Ref: ref, Err: err}
 		}
 		return a, nil
 	}
@@ -158,10 +167,13 @@ func (b *Batch) linearize(db *asserts.Database) error {
 }
 
 // Commit adds the batch of assertions to the system assertion database.
+// A *PrerequisiteError is returned if assertions needed to add the
+// batch are missing, a *CommitError if some assertions could not be
+// added.
 func (b *Batch) Commit(st *state.State) error {
 	db := cachedDB(st)
 
-	return b.commitTo(db)
+	return countCommit(st, b.commitTo(db, metrics(st)))
 }
 
 // Precheck pre-checks whether adding the batch of assertions to the system assertion database should fully succeed.
@@ -169,7 +181,7 @@ func (b *Batch) Precheck(st *state.State) error {
 	db := cachedDB(st)
 	db = db.WithStackedBackstore(asserts.NewMemoryBackstore())
 
-	return b.commitTo(db)
+	return b.commitTo(db, nil)
 }
 
 func findError(format string, ref *asserts.Ref, err error) error {
@@ -180,6 +192,10 @@ func findError(format string, ref *asserts.Ref, err error) error {
 	}
 }
 
+// snapDeclarationsGroupSize is the number of snap declarations fetched
+// together, the groups are fetched concurrently.
+var snapDeclarationsGroupSize = 10
+
 // RefreshSnapDeclarations refetches all the current snap declarations and their prerequisites.
 func RefreshSnapDeclarations(s *state.State, userID int) error {
 	deviceCtx, err := snapstate.DevicePastSeeding(s, nil)
@@ -192,34 +208,61 @@ func RefreshSnapDeclarations(s *state.State, userID int) error {
 	if err != nil {
 		return nil
 	}
-	fetching := func(f asserts.Fetcher) error {
-		for _, snapst := range snapStates {
-			info, err := snapst.CurrentInfo()
-			if err != nil {
-				return err
-			}
-			if info.SnapID == "" {
-				continue
-			}
-			if err := snapasserts.FetchSnapDeclaration(f, info.SnapID); err != nil {
-				if notRetried, ok := err.(*httputil.PerstistentNetworkError); ok {
-					return notRetried
+	var snapIDs []string
+	snapNames := make(map[string]string)
+	for _, snapst := range snapStates {
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return err
+		}
+		if info.SnapID == "" {
+			continue
+		}
+		snapIDs = append(snapIDs, info.SnapID)
+		snapNames[info.SnapID] = info.InstanceName()
+	}
+	sort.Strings(snapIDs)
+
+	fetchDecls := func(snapIDs []string) func(asserts.Fetcher) error {
+		return func(f asserts.Fetcher) error {
+			for _, snapID := range snapIDs {
+				if err := snapasserts.FetchSnapDeclaration(f, snapID); err != nil {
+					if notRetried, ok := err.(*httputil.PerstistentNetworkError); ok {
+						return notRetried
+					}
+					return fmt.Errorf("cannot refresh snap-declaration for %q: %v", snapNames[snapID], err)
 				}
-				return fmt.Errorf("cannot refresh snap-declaration for %q: %v", info.InstanceName(), err)
 			}
+			return nil
 		}
+	}
 
-		// fetch store assertion if available
-		if modelAs.Store() != "" {
+	// the declarations are fetched concurrently in groups
+	var fetchings []func(asserts.Fetcher) error
+	for len(snapIDs) > 0 {
+		n := snapDeclarationsGroupSize
+		if n > len(snapIDs) {
+			n = len(snapIDs)
+		}
+		fetchings = append(fetchings, fetchDecls(snapIDs[:n]))
+		snapIDs = snapIDs[n:]
+	}
+
+	// fetch store assertion if available
+	if modelAs.Store() != "" {
+		fetchings = append(fetchings, func(f asserts.Fetcher) error {
 			err := snapasserts.FetchStore(f, modelAs.Store())
 			if err != nil && !asserts.IsNotFound(err) {
 				return err
 			}
-		}
-
+			return nil
+		})
+	}
+	if len(fetchings) == 0 {
 		return nil
 	}
-	return doFetch(s, userID, deviceCtx, fetching)
+
+	return doConcurrentFetch(s, userID, deviceCtx, fetchings)
 }
 
 type refreshControlError struct {
@@ -237,42 +280,103 @@ func (e *refreshControlError) Error() string {
 	return fmt.Sprintf("refresh control errors:%s", strings.Join(l, "\n - "))
 }
 
+// IgnoredRefreshGating returns the set of gating snap-ids whose
+// refresh control should be ignored when validating refreshes, as
+// configured with the core refresh.ignore-gating option. This is
+// meant for devices gated by snaps whose publishers never publish
+// validations.
+func IgnoredRefreshGating(s *state.State) (map[string]bool, error) {
+	_, ignored, err := ignoredRefreshGating(s)
+	return ignored, err
+}
+
+func ignoredRefreshGating(s *state.State) (ignoreGating string, ignored map[string]bool, err error) {
+	tr := config.NewTransaction(s)
+	if err := tr.Get("core", "refresh.ignore-gating", &ignoreGating); err != nil && !config.IsNoOption(err) {
+		return "", nil, err
+	}
+	ignored = make(map[string]bool)
+	for _, gatingID := range strutil.CommaSeparatedList(ignoreGating) {
+		ignored[gatingID] = true
+	}
+	return ignoreGating, ignored, nil
+}
+
+// ignoredGatingWarnings records the refresh controls, as gated and
+// gating snap-id pairs, that were warned about being ignored with the
+// given value of refresh.ignore-gating, so that each is warned about
+// once per change of the option rather than on every refresh.
+type ignoredGatingWarnings struct {
+	IgnoreGating string   `json:"ignore-gating"`
+	Warned       []string `json:"warned,omitempty"`
+}
+
 // ValidateRefreshes validates the refresh candidate revisions represented by
 // the snapInfos, looking for the needed refresh control validation assertions,
 // it returns a validated subset in validated and a summary error if not all
 // candidates validated. ignoreValidation is a set of snap-instance-names that
-// should not be gated.
+// should not be gated. The refresh control of the gating snaps configured
+// with refresh.ignore-gating is skipped, recording a warning the first
+// time it is skipped with the current value of the option.
 func ValidateRefreshes(s *state.State, snapInfos []*snap.Info, ignoreValidation map[string]bool, userID int, deviceCtx snapstate.DeviceContext) (validated []*snap.Info, err error) {
 	// maps gated snap-ids to gating snap-ids
 	controlled := make(map[string][]string)
 	// maps gating snap-ids to their snap names
 	gatingNames := make(map[string]string)
 
-	db := DB(s)
+	ignoreGating, ignoredGating, err := ignoredRefreshGating(s)
+	if err != nil {
+		return nil, err
+	}
+	var gatingWarnings ignoredGatingWarnings
+	if err := s.Get("ignored-gating-warnings", &gatingWarnings); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if gatingWarnings.IgnoreGating != ignoreGating {
+		// the option changed, warn anew
+		gatingWarnings = ignoredGatingWarnings{IgnoreGating: ignoreGating}
+		s.Set("ignored-gating-warnings", gatingWarnings)
+	}
+	warnedGating := make(map[string]bool, len(gatingWarnings.Warned))
+	for _, pair := range gatingWarnings.Warned {
+		warnedGating[pair] = true
+	}
+
+	db := cachedDB(s)
 	snapStates, err := snapstate.All(s)
 	if err != nil {
 		return nil, err
 	}
+	// look up the declarations of all the installed snaps at once
+	var instanceNames []string
+	var declHeaders []map[string]string
+	seen := make(map[string]bool)
 	for instanceName, snapst := range snapStates {
 		info, err := snapst.CurrentInfo()
 		if err != nil {
 			return nil, err
 		}
-		if info.SnapID == "" {
+		if info.SnapID == "" || seen[info.SnapID] {
 			continue
 		}
-		gatingID := info.SnapID
-		if gatingNames[gatingID] != "" {
-			continue
-		}
-		a, err := db.Find(asserts.SnapDeclarationType, map[string]string{
+		seen[info.SnapID] = true
+		instanceNames = append(instanceNames, instanceName)
+		declHeaders = append(declHeaders, map[string]string{
 			"series":  release.Series,
-			"snap-id": gatingID,
+			"snap-id": info.SnapID,
 		})
-		if err != nil {
+	}
+	decls, err := db.FindManyBatch(asserts.SnapDeclarationType, declHeaders)
+	if err != nil {
+		return nil, err
+	}
+	for i, instanceName := range instanceNames {
+		if len(decls[i]) == 0 {
+			err := &asserts.NotFoundError{Type: asserts.SnapDeclarationType, Headers: declHeaders[i]}
 			return nil, fmt.Errorf("internal error: cannot find snap declaration for installed snap %q: %v", instanceName, err)
 		}
-		decl := a.(*asserts.SnapDeclaration)
+		decl := decls[i][0].(*asserts.SnapDeclaration)
+		gatingID := decl.SnapID()
 		control := decl.RefreshControl()
 		if len(control) == 0 {
 			continue
@@ -290,7 +394,20 @@ func ValidateRefreshes(s *state.State, snapInfos []*snap.Info, ignoreValidation
 			continue
 		}
 		gatedID := candInfo.SnapID
-		gating := controlled[gatedID]
+		var gating []string
+		for _, gatingID := range controlled[gatedID] {
+			if ignoredGating[gatingID] {
+				pair := gatedID + ":" + gatingID
+				if !warnedGating[pair] {
+					s.WarnfWithSeverity(state.WarningSeverityInfo, "refresh control of %q by %q is ignored as configured with refresh.ignore-gating", candInfo.InstanceName(), gatingNames[gatingID])
+					warnedGating[pair] = true
+					gatingWarnings.Warned = append(gatingWarnings.Warned, pair)
+					s.Set("ignored-gating-warnings", gatingWarnings)
+				}
+				continue
+			}
+			gating = append(gating, gatingID)
+		}
 		if len(gating) == 0 { // easy case, no refresh control
 			validated = append(validated, candInfo)
 			continue
@@ -444,6 +561,18 @@ func delayedCrossMgrInit() {
 	snapstate.AutoRefreshAssertions = AutoRefreshAssertions
 	// hook retrieving auto-aliases into snapstate logic
 	snapstate.AutoAliases = AutoAliases
+	// hook verification of installed snaps into snapstate
+	snapstate.VerifySnapFile = VerifySnapFile
+}
+
+// VerifySnapFile checks the installed snap file at snapPath against its
+// snap-revision assertion in the system assertion database. It must be
+// called without the state lock held as it recomputes the file digest.
+func VerifySnapFile(s *state.State, instanceName, snapPath string, si *snap.SideInfo) error {
+	s.Lock()
+	db := DB(s)
+	s.Unlock()
+	return snapasserts.VerifySnapFile(instanceName, snapPath, si, db)
 }
 
 // AutoRefreshAssertions tries to refresh all assertions
@@ -22,9 +22,12 @@ package assertstate_test
 import (
 	"bytes"
 	"crypto"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,6 +42,7 @@ import (
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
@@ -72,6 +76,8 @@ type fakeStore struct {
 	storetest.Store
 	state *state.State
 	db    asserts.RODatabase
+
+	assertionHook func(ref *asserts.Ref)
 }
 
 func (sto *fakeStore) pokeStateLock() {
@@ -84,6 +90,9 @@ func (sto *fakeStore) pokeStateLock() {
 func (sto *fakeStore) Assertion(assertType *asserts.AssertionType, key []string, _ *auth.UserState) (asserts.Assertion, error) {
 	sto.pokeStateLock()
 	ref := &asserts.Ref{Type: assertType, PrimaryKey: key}
+	if sto.assertionHook != nil {
+		sto.assertionHook(ref)
+	}
 	return ref.Resolve(sto.db.Find)
 }
 
@@ -125,6 +134,8 @@ func (s *assertMgrSuite) SetUpTest(c *C) {
 	s.trivialDeviceCtx = &snapstatetest.TrivialDeviceContext{
 		CtxStore: s.fakeStore,
 	}
+	// no device model by default
+	s.AddCleanup(snapstatetest.MockDeviceModel(nil))
 }
 
 func (s *assertMgrSuite) TestDB(c *C) {
@@ -353,6 +364,9 @@ func (s *assertMgrSuite) TestBatchCommitPartial(c *C) {
 
 	err = batch.Commit(s.state)
 	c.Check(err, ErrorMatches, `(?ms).*validity.*`)
+	commitErr, ok := err.(*assertstate.CommitError)
+	c.Assert(ok, Equals, true)
+	c.Check(commitErr.Errors, HasLen, 1)
 
 	// snap-declaration was added anyway
 	_, err = assertstate.DB(s.state).Find(asserts.SnapDeclarationType, map[string]string{
@@ -362,6 +376,23 @@ func (s *assertMgrSuite) TestBatchCommitPartial(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *assertMgrSuite) TestBatchCommitMissingPrerequisite(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	batch := assertstate.NewBatch()
+	err := batch.Add(s.dev1Acct)
+	c.Assert(err, IsNil)
+
+	// the store key is missing
+	err = batch.Commit(s.state)
+	c.Assert(err, ErrorMatches, `cannot find account-key.*`)
+	prereqErr, ok := err.(*assertstate.PrerequisiteError)
+	c.Assert(ok, Equals, true)
+	c.Check(prereqErr.Ref, DeepEquals, s.storeSigning.StoreAccountKey("").Ref())
+	c.Check(prereqErr.Err, IsNil)
+}
+
 func (s *assertMgrSuite) TestBatchPrecheckPartial(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -557,6 +588,57 @@ func (s *assertMgrSuite) TestFetchIdempotent(c *C) {
 	c.Check(snapRev.(*asserts.SnapRevision).SnapRevision(), Equals, 11)
 }
 
+func (s *assertMgrSuite) TestMetrics(c *C) {
+	s.prereqSnapAssertions(c, 10)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	m, err := assertstate.CollectMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(m.Fetches, Equals, 0)
+	c.Check(m.DBSize["snap-revision"], Equals, 0)
+
+	// missing prerequisite store key
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, NotNil)
+
+	ref := &asserts.Ref{
+		Type:       asserts.SnapRevisionType,
+		PrimaryKey: []string{makeDigest(10)},
+	}
+	err = assertstate.DoFetch(s.state, 0, s.trivialDeviceCtx, func(f asserts.Fetcher) error {
+		return f.Fetch(ref)
+	})
+	c.Assert(err, IsNil)
+	m, err = assertstate.CollectMetrics(s.state)
+	c.Assert(err, IsNil)
+	redundant := m.Redundant
+
+	// the same again, nothing new is committed
+	err = assertstate.DoFetch(s.state, 0, s.trivialDeviceCtx, func(f asserts.Fetcher) error {
+		return f.Fetch(ref)
+	})
+	c.Assert(err, IsNil)
+
+	err = assertstate.DoFetch(s.state, 0, s.trivialDeviceCtx, func(f asserts.Fetcher) error {
+		return errors.New("boom")
+	})
+	c.Assert(err, ErrorMatches, "boom")
+
+	m, err = assertstate.CollectMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(m.Fetches, Equals, 3)
+	c.Check(m.FetchFailures, Equals, 1)
+	c.Check(m.CommitFailures, Equals, 1)
+	c.Check(m.Superseded, Equals, 0)
+	// the snap revision, the snap declaration, the account and the
+	// account key of the developer
+	c.Check(m.Redundant, Equals, redundant+4)
+	c.Check(m.DBSize["snap-revision"], Equals, 1)
+	c.Check(m.DBSize["snap-declaration"], Equals, 1)
+}
+
 func (s *assertMgrSuite) settle(c *C) {
 	err := s.o.Settle(5 * time.Second)
 	c.Assert(err, IsNil)
@@ -990,6 +1072,114 @@ func (s *assertMgrSuite) TestRefreshSnapDeclarationsNoStore(c *C) {
 	c.Check(a.(*asserts.SnapDeclaration).Revision(), Equals, 1)
 }
 
+func (s *assertMgrSuite) TestRefreshSnapDeclarationsConcurrently(c *C) {
+	restore := assertstate.MockSnapDeclarationsGroupSize(2)
+	defer restore()
+	restore = assertstate.MockMaxConcurrentFetches(2)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setModel(sysdb.GenericClassicModel())
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+
+	names := []string{"a", "b", "c", "d", "e", "f", "g"}
+	for _, name := range names {
+		snapDecl := s.snapDecl(c, name, nil)
+		s.stateFromDecl(c, snapDecl, "", snap.R(1))
+		c.Assert(assertstate.Add(s.state, snapDecl), IsNil)
+
+		// a changed assertion in the store
+		snapDecl1 := s.snapDecl(c, name, map[string]interface{}{
+			"snap-name": name + "-new",
+			"revision":  "1",
+		})
+		c.Assert(snapDecl1.Revision(), Equals, 1)
+	}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight, retrieved := 0, 0, 0
+	s.fakeStore.(*fakeStore).assertionHook = func(*asserts.Ref) {
+		mu.Lock()
+		inFlight++
+		retrieved++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+	defer func() { s.fakeStore.(*fakeStore).assertionHook = nil }()
+
+	m, err := assertstate.CollectMetrics(s.state)
+	c.Assert(err, IsNil)
+	fetches, commitFailures, superseded := m.Fetches, m.CommitFailures, m.Superseded
+
+	err = assertstate.RefreshSnapDeclarations(s.state, 0)
+	c.Assert(err, IsNil)
+
+	for _, name := range names {
+		a, err := assertstate.DB(s.state).Find(asserts.SnapDeclarationType, map[string]string{
+			"series":  "16",
+			"snap-id": name + "-id",
+		})
+		c.Assert(err, IsNil)
+		c.Check(a.(*asserts.SnapDeclaration).SnapName(), Equals, name+"-new")
+	}
+	// the declarations, the publisher account and account-key are
+	// fetched by each of the four groups
+	c.Check(retrieved, Equals, len(names)+4*2)
+	c.Check(maxInFlight <= 2, Equals, true)
+
+	// one fetch and one successful commit
+	m, err = assertstate.CollectMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(m.Fetches, Equals, fetches+1)
+	c.Check(m.CommitFailures, Equals, commitFailures)
+	// the new revisions of the declarations replaced the old ones
+	c.Check(m.Superseded, Equals, superseded+len(names))
+}
+
+func (s *assertMgrSuite) TestRefreshSnapDeclarationsConcurrentlyError(c *C) {
+	restore := assertstate.MockSnapDeclarationsGroupSize(1)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setModel(sysdb.GenericClassicModel())
+
+	for _, name := range []string{"a", "b"} {
+		snapDecl := s.snapDecl(c, name, nil)
+		s.stateFromDecl(c, snapDecl, "", snap.R(1))
+	}
+	snapstate.Set(s.state, "unknown", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "unknown", SnapID: "unknown-id", Revision: snap.R(1)},
+		},
+		Current: snap.R(1),
+	})
+
+	err := assertstate.RefreshSnapDeclarations(s.state, 0)
+	c.Assert(err, ErrorMatches, `cannot refresh snap-declaration for "unknown": .*not found`)
+
+	// nothing was committed
+	_, err = assertstate.DB(s.state).Find(asserts.SnapDeclarationType, map[string]string{
+		"series":  "16",
+		"snap-id": "a-id",
+	})
+	c.Check(asserts.IsNotFound(err), Equals, true)
+}
+
 func (s *assertMgrSuite) TestRefreshSnapDeclarationsWithStore(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1154,6 +1344,88 @@ func (s *assertMgrSuite) TestValidateRefreshesMissingValidation(c *C) {
 	c.Check(validated, HasLen, 0)
 }
 
+func (s *assertMgrSuite) TestValidateRefreshesIgnoredGating(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapDeclFoo := s.snapDecl(c, "foo", nil)
+	snapDeclBar := s.snapDecl(c, "bar", map[string]interface{}{
+		"refresh-control": []interface{}{"foo-id"},
+	})
+	snapDeclBaz := s.snapDecl(c, "baz", map[string]interface{}{
+		"refresh-control": []interface{}{"foo-id"},
+	})
+	s.stateFromDecl(c, snapDeclFoo, "", snap.R(7))
+	s.stateFromDecl(c, snapDeclBar, "", snap.R(3))
+	s.stateFromDecl(c, snapDeclBaz, "", snap.R(1))
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclFoo)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclBar)
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, snapDeclBaz)
+	c.Assert(err, IsNil)
+
+	fooRefresh := &snap.Info{
+		SideInfo: snap.SideInfo{RealName: "foo", SnapID: "foo-id", Revision: snap.R(9)},
+	}
+
+	// only the refresh control by bar is ignored
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.ignore-gating", "bar-id")
+	tr.Commit()
+
+	validated, err := assertstate.ValidateRefreshes(s.state, []*snap.Info{fooRefresh}, nil, 0, s.trivialDeviceCtx)
+	c.Assert(err, ErrorMatches, `cannot refresh "foo" to revision 9: no validation by "baz"`)
+	c.Check(validated, HasLen, 0)
+
+	tr = config.NewTransaction(s.state)
+	tr.Set("core", "refresh.ignore-gating", "bar-id,baz-id")
+	tr.Commit()
+
+	ignored, err := assertstate.IgnoredRefreshGating(s.state)
+	c.Assert(err, IsNil)
+	c.Check(ignored, DeepEquals, map[string]bool{"bar-id": true, "baz-id": true})
+
+	validated, err = assertstate.ValidateRefreshes(s.state, []*snap.Info{fooRefresh}, nil, 0, s.trivialDeviceCtx)
+	c.Assert(err, IsNil)
+	c.Check(validated, DeepEquals, []*snap.Info{fooRefresh})
+
+	var msgs []string
+	for _, w := range s.state.AllWarnings() {
+		c.Check(w.Severity(), Equals, state.WarningSeverityInfo)
+		msgs = append(msgs, w.String())
+	}
+	c.Check(msgs, testutil.Contains, `refresh control of "foo" by "bar" is ignored as configured with refresh.ignore-gating`)
+	c.Check(msgs, testutil.Contains, `refresh control of "foo" by "baz" is ignored as configured with refresh.ignore-gating`)
+
+	// the ignored refresh controls are not warned about again on
+	// further refreshes
+	warned, err := json.Marshal(s.state.AllWarnings())
+	c.Assert(err, IsNil)
+	validated, err = assertstate.ValidateRefreshes(s.state, []*snap.Info{fooRefresh}, nil, 0, s.trivialDeviceCtx)
+	c.Assert(err, IsNil)
+	c.Check(validated, DeepEquals, []*snap.Info{fooRefresh})
+	rewarned, err := json.Marshal(s.state.AllWarnings())
+	c.Assert(err, IsNil)
+	c.Check(string(rewarned), Equals, string(warned))
+
+	// but they are once the option changes
+	tr = config.NewTransaction(s.state)
+	tr.Set("core", "refresh.ignore-gating", "baz-id,bar-id")
+	tr.Commit()
+
+	_, err = assertstate.ValidateRefreshes(s.state, []*snap.Info{fooRefresh}, nil, 0, s.trivialDeviceCtx)
+	c.Assert(err, IsNil)
+	rewarned, err = json.Marshal(s.state.AllWarnings())
+	c.Assert(err, IsNil)
+	c.Check(string(rewarned), Not(Equals), string(warned))
+}
+
 func (s *assertMgrSuite) TestParallelInstanceValidateRefreshesMissingValidation(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertstate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+)
+
+// bundleTypes are the assertion types that can be carried by an
+// offline assertion bundle: the snap assertions proper plus the
+// account and account-key assertions needed to verify them.
+var bundleTypes = map[*asserts.AssertionType]bool{
+	asserts.SnapRevisionType:    true,
+	asserts.SnapDeclarationType: true,
+	asserts.ValidationType:      true,
+	asserts.AccountType:         true,
+	asserts.AccountKeyType:      true,
+}
+
+// BundleInfo describes an offline assertion bundle applied to the
+// system assertion database.
+type BundleInfo struct {
+	// Applied is the time the bundle was applied.
+	Applied time.Time `json:"applied"`
+	// SnapIDs are the snap-ids covered by the snap assertions in
+	// the bundle.
+	SnapIDs []string `json:"snap-ids,omitempty"`
+	// Assertions holds the unique references of the assertions from
+	// the bundle that were new to the system assertion database (or
+	// newer revisions of ones already there).
+	Assertions []string `json:"assertions,omitempty"`
+}
+
+var timeNow = time.Now
+
+// bundleSeries returns the series the snap assertions of a bundle
+// must be for, the one of the device model if there is one already.
+func bundleSeries(st *state.State) (string, error) {
+	deviceCtx, err := snapstate.DeviceCtx(st, nil, nil)
+	if err == state.ErrNoState {
+		return release.Series, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return deviceCtx.Model().Series(), nil
+}
+
+// bundleNewRefs returns the references of the assertions in the batch
+// that are not yet in the system assertion database, or only in an
+// older revision. A bundle carrying an older revision of an assertion
+// than the one in the database is stale and refused.
+func bundleNewRefs(st *state.State, batch *Batch) ([]*asserts.Ref, error) {
+	db := cachedDB(st)
+	var added []*asserts.Ref
+	for _, ref := range batch.refs {
+		a, err := batch.bs.Get(ref.Type, ref.PrimaryKey, ref.Type.MaxSupportedFormat())
+		if err != nil {
+			return nil, err
+		}
+		cur, err := ref.Resolve(db.Find)
+		if err != nil && !asserts.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			if cur.Revision() > a.Revision() {
+				return nil, &RevisionConflictError{Ref: ref, Revision: a.Revision(), Current: cur.Revision()}
+			}
+			if cur.Revision() == a.Revision() {
+				continue
+			}
+		}
+		added = append(added, ref)
+	}
+	return added, nil
+}
+
+// ApplyBundle reads a store-produced bundle of signed assertions
+// (snap-revision, snap-declaration and validation assertions together
+// with their account and account-key prerequisites) from r, verifies
+// that it is meant for the series of the device model and not older
+// than what the system already has, and adds it to the system assertion database in one go. This
+// lets air-gapped systems get all the assertions needed for later
+// sideloaded refreshes to be treated as asserted without acking them
+// one by one. A record of the applied bundle is kept in the state and
+// returned.
+func ApplyBundle(st *state.State, r io.Reader) (*BundleInfo, error) {
+	series, err := bundleSeries(st)
+	if err != nil {
+		return nil, err
+	}
+	batch := NewBatch()
+	snapIDs := make(map[string]bool)
+
+	dec := asserts.NewDecoder(r)
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read assertion bundle: %v", err)
+		}
+		if !bundleTypes[a.Type()] {
+			return nil, fmt.Errorf("cannot apply assertion bundle: unexpected %q assertion", a.Type().Name)
+		}
+		switch x := a.(type) {
+		case *asserts.SnapRevision:
+			snapIDs[x.SnapID()] = true
+		case *asserts.SnapDeclaration:
+			if x.Series() != series {
+				return nil, fmt.Errorf("cannot apply assertion bundle: snap-declaration for %q is for series %q, not %q", x.SnapID(), x.Series(), series)
+			}
+			snapIDs[x.SnapID()] = true
+		case *asserts.Validation:
+			if x.Series() != series {
+				return nil, fmt.Errorf("cannot apply assertion bundle: validation for %q is for series %q, not %q", x.ApprovedSnapID(), x.Series(), series)
+			}
+			snapIDs[x.ApprovedSnapID()] = true
+		}
+		if err := batch.Add(a); err != nil {
+			return nil, fmt.Errorf("cannot apply assertion bundle: %v", err)
+		}
+	}
+
+	if len(batch.refs) == 0 {
+		return nil, fmt.Errorf("cannot apply empty assertion bundle")
+	}
+
+	// verify everything before touching the system database so that
+	// a bundle is applied either fully or not at all
+	if err := batch.Precheck(st); err != nil {
+		return nil, fmt.Errorf("cannot apply assertion bundle: %v", err)
+	}
+	added, err := bundleNewRefs(st, batch)
+	if err != nil {
+		return nil, fmt.Errorf("cannot apply assertion bundle: %v", err)
+	}
+	if err := batch.Commit(st); err != nil {
+		return nil, fmt.Errorf("cannot apply assertion bundle: %v", err)
+	}
+
+	info := &BundleInfo{
+		Applied: timeNow(),
+	}
+	for snapID := range snapIDs {
+		info.SnapIDs = append(info.SnapIDs, snapID)
+	}
+	sort.Strings(info.SnapIDs)
+	for _, ref := range added {
+		info.Assertions = append(info.Assertions, ref.Unique())
+	}
+
+	bundles, err := AppliedBundles(st)
+	if err != nil {
+		return nil, err
+	}
+	st.Set("assertion-bundles", append(bundles, info))
+
+	return info, nil
+}
+
+// AppliedBundles returns the records of the offline assertion bundles
+// applied so far, oldest first.
+func AppliedBundles(st *state.State) ([]*BundleInfo, error) {
+	var bundles []*BundleInfo
+	err := st.Get("assertion-bundles", &bundles)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return bundles, nil
+}
@@ -0,0 +1,254 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertstate_test
+
+import (
+	"bytes"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+)
+
+func (s *assertMgrSuite) bundle(c *C, as ...asserts.Assertion) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	enc := asserts.NewEncoder(b)
+	for _, a := range as {
+		err := enc.Encode(a)
+		c.Assert(err, IsNil)
+	}
+	return b
+}
+
+func (s *assertMgrSuite) snapAssertionsForBundle(c *C, rev int) (asserts.Assertion, asserts.Assertion) {
+	s.prereqSnapAssertions(c, rev)
+
+	snapDecl, err := s.storeSigning.Find(asserts.SnapDeclarationType, map[string]string{
+		"series":  "16",
+		"snap-id": "snap-id-1",
+	})
+	c.Assert(err, IsNil)
+	snapRev, err := s.storeSigning.Find(asserts.SnapRevisionType, map[string]string{
+		"snap-sha3-384": makeDigest(rev),
+	})
+	c.Assert(err, IsNil)
+	return snapDecl, snapRev
+}
+
+func (s *assertMgrSuite) TestApplyBundle(c *C) {
+	snapDecl, snapRev := s.snapAssertionsForBundle(c, 10)
+
+	now := time.Date(2019, 7, 10, 12, 0, 0, 0, time.UTC)
+	defer assertstate.MockTimeNow(now)()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// wrong order is ok
+	b := s.bundle(c, snapRev, snapDecl, s.dev1Acct, s.storeSigning.StoreAccountKey(""))
+	info, err := assertstate.ApplyBundle(s.state, b)
+	c.Assert(err, IsNil)
+	c.Check(info.Applied.Equal(now), Equals, true)
+	c.Check(info.SnapIDs, DeepEquals, []string{"snap-id-1"})
+	c.Check(info.Assertions, DeepEquals, []string{
+		snapRev.Ref().Unique(),
+		snapDecl.Ref().Unique(),
+		s.dev1Acct.Ref().Unique(),
+		s.storeSigning.StoreAccountKey("").Ref().Unique(),
+	})
+
+	_, err = snapRev.Ref().Resolve(assertstate.DB(s.state).Find)
+	c.Check(err, IsNil)
+
+	bundles, err := assertstate.AppliedBundles(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(bundles, HasLen, 1)
+	c.Check(bundles[0].SnapIDs, DeepEquals, info.SnapIDs)
+	c.Check(bundles[0].Assertions, DeepEquals, info.Assertions)
+}
+
+func (s *assertMgrSuite) TestApplyBundleAlreadyPresent(c *C) {
+	snapDecl, snapRev := s.snapAssertionsForBundle(c, 10)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+
+	b := s.bundle(c, s.dev1Acct, s.storeSigning.StoreAccountKey(""), snapDecl, snapRev)
+	info, err := assertstate.ApplyBundle(s.state, b)
+	c.Assert(err, IsNil)
+	// only what the bundle effectively added is recorded
+	c.Check(info.Assertions, DeepEquals, []string{
+		snapDecl.Ref().Unique(),
+		snapRev.Ref().Unique(),
+	})
+
+	// applying it again adds nothing
+	b = s.bundle(c, s.dev1Acct, s.storeSigning.StoreAccountKey(""), snapDecl, snapRev)
+	info, err = assertstate.ApplyBundle(s.state, b)
+	c.Assert(err, IsNil)
+	c.Check(info.SnapIDs, DeepEquals, []string{"snap-id-1"})
+	c.Check(info.Assertions, HasLen, 0)
+
+	bundles, err := assertstate.AppliedBundles(s.state)
+	c.Assert(err, IsNil)
+	c.Check(bundles, HasLen, 2)
+}
+
+func (s *assertMgrSuite) TestApplyBundleUnexpectedType(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	model, err := s.dev1Signing.Sign(asserts.ModelType, map[string]interface{}{
+		"authority-id": s.dev1Acct.AccountID(),
+		"series":       "16",
+		"brand-id":     s.dev1Acct.AccountID(),
+		"model":        "my-model",
+		"architecture": "amd64",
+		"gadget":       "gadget",
+		"kernel":       "krnl",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	b := s.bundle(c, s.dev1Acct, model)
+	_, err = assertstate.ApplyBundle(s.state, b)
+	c.Check(err, ErrorMatches, `cannot apply assertion bundle: unexpected "model" assertion`)
+
+	// nothing was added
+	_, err = s.dev1Acct.Ref().Resolve(assertstate.DB(s.state).Find)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+}
+
+func (s *assertMgrSuite) TestApplyBundleEmpty(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := assertstate.ApplyBundle(s.state, &bytes.Buffer{})
+	c.Check(err, ErrorMatches, `cannot apply empty assertion bundle`)
+}
+
+func (s *assertMgrSuite) TestApplyBundleAllOrNothing(c *C) {
+	snapDecl, snapRev := s.snapAssertionsForBundle(c, 10)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the developer account is missing so the snap-declaration
+	// and snap-revision cannot be verified
+	b := s.bundle(c, s.storeSigning.StoreAccountKey(""), snapDecl, snapRev)
+	_, err := assertstate.ApplyBundle(s.state, b)
+	c.Check(err, ErrorMatches, `cannot apply assertion bundle: cannot find account.*`)
+
+	// the store key was not added either
+	_, err = s.storeSigning.StoreAccountKey("").Ref().Resolve(assertstate.DB(s.state).Find)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+
+	bundles, err := assertstate.AppliedBundles(s.state)
+	c.Assert(err, IsNil)
+	c.Check(bundles, HasLen, 0)
+}
+
+func (s *assertMgrSuite) TestApplyBundleWrongSeries(c *C) {
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "14",
+		"snap-id":      "snap-id-1",
+		"snap-name":    "foo",
+		"publisher-id": s.dev1Acct.AccountID(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	b := s.bundle(c, s.storeSigning.StoreAccountKey(""), s.dev1Acct, snapDecl)
+	_, err = assertstate.ApplyBundle(s.state, b)
+	c.Check(err, ErrorMatches, `cannot apply assertion bundle: snap-declaration for "snap-id-1" is for series "14", not "16"`)
+
+	// nothing was added
+	_, err = s.dev1Acct.Ref().Resolve(assertstate.DB(s.state).Find)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+}
+
+func (s *assertMgrSuite) TestApplyBundleModelSeries(c *C) {
+	snapDecl, snapRev := s.snapAssertionsForBundle(c, 10)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the device model is for another series
+	model := assertstest.FakeAssertion(map[string]interface{}{
+		"type":         "model",
+		"authority-id": "my-brand",
+		"series":       "18",
+		"brand-id":     "my-brand",
+		"model":        "my-model",
+		"architecture": "amd64",
+		"gadget":       "gadget",
+		"kernel":       "krnl",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}).(*asserts.Model)
+	defer snapstatetest.MockDeviceModel(model)()
+
+	b := s.bundle(c, snapRev, snapDecl, s.dev1Acct, s.storeSigning.StoreAccountKey(""))
+	_, err := assertstate.ApplyBundle(s.state, b)
+	c.Check(err, ErrorMatches, `cannot apply assertion bundle: snap-declaration for "snap-id-1" is for series "16", not "18"`)
+}
+
+func (s *assertMgrSuite) TestApplyBundleStale(c *C) {
+	snapDecl, snapRev := s.snapAssertionsForBundle(c, 10)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	err := assertstate.Add(s.state, s.storeSigning.StoreAccountKey(""))
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, s.dev1Acct)
+	c.Assert(err, IsNil)
+
+	// the system has a newer revision of the snap-declaration
+	headers := snapDecl.Headers()
+	headers["revision"] = "1"
+	headers["timestamp"] = time.Now().Format(time.RFC3339)
+	newDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, headers, nil, "")
+	c.Assert(err, IsNil)
+	err = assertstate.Add(s.state, newDecl)
+	c.Assert(err, IsNil)
+
+	b := s.bundle(c, s.dev1Acct, s.storeSigning.StoreAccountKey(""), snapDecl, snapRev)
+	_, err = assertstate.ApplyBundle(s.state, b)
+	c.Check(err, ErrorMatches, `cannot apply assertion bundle: cannot add snap-declaration.*: revision 0 is older than current revision 1`)
+
+	// the snap-revision was not added
+	_, err = snapRev.Ref().Resolve(assertstate.DB(s.state).Find)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+	bundles, err := assertstate.AppliedBundles(s.state)
+	c.Assert(err, IsNil)
+	c.Check(bundles, HasLen, 0)
+}
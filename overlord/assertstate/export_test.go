@@ -19,7 +19,29 @@
 
 package assertstate
 
+import (
+	"time"
+)
+
 // expose for testing
 var (
 	DoFetch = doFetch
 )
+
+func MockTimeNow(t time.Time) (restore func()) {
+	old := timeNow
+	timeNow = func() time.Time { return t }
+	return func() { timeNow = old }
+}
+
+func MockSnapDeclarationsGroupSize(n int) (restore func()) {
+	old := snapDeclarationsGroupSize
+	snapDeclarationsGroupSize = n
+	return func() { snapDeclarationsGroupSize = old }
+}
+
+func MockMaxConcurrentFetches(n int) (restore func()) {
+	old := maxConcurrentFetches
+	maxConcurrentFetches = n
+	return func() { maxConcurrentFetches = old }
+}
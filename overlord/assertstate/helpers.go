@@ -22,6 +22,7 @@ package assertstate
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/logger"
@@ -57,23 +58,59 @@ func newAccumFetcher(db *asserts.Database, retrieve func(*asserts.Ref) (asserts.
 	return f
 }
 
-type commitError struct {
-	errs []error
+// CommitError is returned when some of the assertions could not be
+// added to the system assertion database. Errors holds the individual
+// failures, which can be of type *RevisionConflictError,
+// *asserts.UnsupportedFormatError or others.
+type CommitError struct {
+	Errors []error
 }
 
-func (e *commitError) Error() string {
+func (e *CommitError) Error() string {
 	l := []string{""}
-	for _, e := range e.errs {
+	for _, e := range e.Errors {
 		l = append(l, e.Error())
 	}
 	return fmt.Sprintf("cannot add some assertions to the system database:%s", strings.Join(l, "\n - "))
 }
 
+// RevisionConflictError is returned when an assertion cannot be added
+// because of a conflict with the revision of the same assertion
+// already present.
+type RevisionConflictError struct {
+	Ref      *asserts.Ref
+	Revision int
+	Current  int
+}
+
+func (e *RevisionConflictError) Error() string {
+	revErr := &asserts.RevisionError{Used: e.Revision, Current: e.Current}
+	return fmt.Sprintf("cannot add %s: %v", e.Ref, revErr)
+}
+
+// PrerequisiteError is returned when an assertion needed to add the
+// assertions, usually one of their prerequisites, cannot be found.
+type PrerequisiteError struct {
+	// Ref is the reference of the missing assertion.
+	Ref *asserts.Ref
+	// Err is set if finding it failed for reasons other than not
+	// being found.
+	Err error
+}
+
+func (e *PrerequisiteError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cannot find %s: %v", e.Ref, e.Err)
+	}
+	return fmt.Sprintf("cannot find %s", e.Ref)
+}
+
 // commitTo does a best effort of adding all the fetched assertions to the system database.
-func commitTo(db *asserts.Database, assertions []asserts.Assertion) error {
+// What the commit pruned is counted in the given metrics, if not nil.
+func commitTo(db *asserts.Database, assertions []asserts.Assertion, m *Metrics) error {
 	var errs []error
 	for _, a := range assertions {
-		err := db.Add(a)
+		err := addCounted(db, a, m)
 		if asserts.IsUnaccceptedUpdate(err) {
 			if _, ok := err.(*asserts.UnsupportedFormatError); ok {
 				// we kept the old one, but log the issue
@@ -83,17 +120,32 @@ func commitTo(db *asserts.Database, assertions []asserts.Assertion) error {
 			// system db has already the same or newer
 			continue
 		}
+		if revErr, ok := err.(*asserts.RevisionError); ok {
+			err = &RevisionConflictError{Ref: a.Ref(), Revision: revErr.Used, Current: revErr.Current}
+		}
 		if err != nil {
 			errs = append(errs, err)
 		}
 	}
 	if len(errs) != 0 {
-		return &commitError{errs: errs}
+		return &CommitError{Errors: errs}
 	}
 	return nil
 }
 
 func doFetch(s *state.State, userID int, deviceCtx snapstate.DeviceContext, fetching func(asserts.Fetcher) error) error {
+	return doConcurrentFetch(s, userID, deviceCtx, []func(asserts.Fetcher) error{fetching})
+}
+
+// maxConcurrentFetches is the maximum number of fetching functions run
+// concurrently by doConcurrentFetch.
+var maxConcurrentFetches = 4
+
+// doConcurrentFetch runs the fetching functions concurrently, at most
+// maxConcurrentFetches at a time, each with its own fetcher, and then
+// commits all the fetched assertions to the system database in one go.
+// The first error of the fetching functions in order is returned.
+func doConcurrentFetch(s *state.State, userID int, deviceCtx snapstate.DeviceContext, fetchings []func(asserts.Fetcher) error) error {
 	// TODO: once we have a bulk assertion retrieval endpoint this approach will change
 
 	user, err := userFromUserID(s, userID)
@@ -109,17 +161,57 @@ func doFetch(s *state.State, userID int, deviceCtx snapstate.DeviceContext, fetc
 	}
 
 	db := cachedDB(s)
-	f := newAccumFetcher(db, retrieve)
+	fetchers := make([]*accumFetcher, len(fetchings))
+	for i := range fetchings {
+		fetchers[i] = newAccumFetcher(db, retrieve)
+	}
 
+	m := metrics(s)
+	m.Fetches++
 	s.Unlock()
-	err = fetching(f)
+	errs := make([]error, len(fetchings))
+	if len(fetchings) == 1 {
+		errs[0] = fetchings[0](fetchers[0])
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, maxConcurrentFetches)
+		for i := range fetchings {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = fetchings[i](fetchers[i])
+				<-sem
+			}(i)
+		}
+		wg.Wait()
+	}
 	s.Lock()
-	if err != nil {
-		return err
+	for _, err := range errs {
+		if err != nil {
+			m.FetchFailures++
+			return err
+		}
+	}
+
+	// the fetchers are independent, so they might have fetched the
+	// same prerequisites, keep only the first occurrence of each
+	// assertion, which comes before anything depending on it
+	var fetched []asserts.Assertion
+	seen := make(map[string]bool)
+	for _, f := range fetchers {
+		for _, a := range f.fetched {
+			u := a.Ref().Unique()
+			if seen[u] {
+				continue
+			}
+			seen[u] = true
+			fetched = append(fetched, a)
+		}
 	}
 
 	// TODO: trigger w. caller a global sanity check if a is revoked
 	// (but try to save as much possible still),
 	// or err is a check error
-	return commitTo(db, f.fetched)
+	return countCommit(s, commitTo(db, fetched, metrics(s)))
 }
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertstate
+
+import (
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// Metrics holds counters about the activity of the assertion manager
+// since snapd started, together with the current size of the system
+// assertion database, for introspection.
+type Metrics struct {
+	// Fetches is the number of attempts to fetch assertions (and
+	// their prerequisites) from the store.
+	Fetches int `json:"fetches"`
+	// FetchFailures is the number of such attempts that failed.
+	FetchFailures int `json:"fetch-failures"`
+	// CommitFailures is the number of times adding assertions to the
+	// system assertion database failed.
+	CommitFailures int `json:"commit-failures"`
+	// Superseded is the number of assertions whose previous
+	// revision was pruned from the system assertion database by
+	// adding a newer one.
+	Superseded int `json:"superseded"`
+	// Redundant is the number of assertions pruned from commits
+	// because the system assertion database already had the same or
+	// a newer revision of them.
+	Redundant int `json:"redundant"`
+	// DBSize maps assertion type names to the number of assertions
+	// of that type in the system assertion database.
+	DBSize map[string]int `json:"db-size,omitempty"`
+}
+
+type cachedMetricsKey struct{}
+
+// metrics returns the live counters, the state must be locked.
+func metrics(st *state.State) *Metrics {
+	m, _ := st.Cached(cachedMetricsKey{}).(*Metrics)
+	if m == nil {
+		m = &Metrics{}
+		st.Cache(cachedMetricsKey{}, m)
+	}
+	return m
+}
+
+func countCommit(st *state.State, err error) error {
+	if err != nil {
+		metrics(st).CommitFailures++
+	}
+	return err
+}
+
+// addCounted adds the assertion to the database, counting in the given
+// metrics, if any, whether it superseded a previous revision or was
+// redundant.
+func addCounted(db *asserts.Database, a asserts.Assertion, m *Metrics) error {
+	if m == nil {
+		return db.Add(a)
+	}
+	_, findErr := a.Ref().Resolve(db.Find)
+	err := db.Add(a)
+	switch {
+	case err == nil && findErr == nil:
+		m.Superseded++
+	case asserts.IsUnaccceptedUpdate(err):
+		m.Redundant++
+	}
+	return err
+}
+
+// CollectMetrics returns a snapshot of the assertion manager metrics.
+func CollectMetrics(st *state.State) (*Metrics, error) {
+	m := *metrics(st)
+	m.DBSize = make(map[string]int)
+	db := cachedDB(st)
+	for _, name := range asserts.TypeNames() {
+		as, err := db.FindMany(asserts.Type(name), nil)
+		if asserts.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.DBSize[name] = len(as)
+	}
+	return &m, nil
+}
@@ -161,7 +161,8 @@ func SetSnapConfig(st *state.State, snapName string, snapcfg *json.RawMessage) e
 		config[snapName] = snapcfg
 	}
 	st.Set("config", config)
-	return nil
+	// the provenance of the replaced values is unknown
+	return setSnapOrigins(st, snapName, nil)
 }
 
 // SaveRevisionConfig makes a copy of config -> snapSnape configuration into the versioned config.
@@ -196,9 +197,53 @@ func SaveRevisionConfig(st *state.State, snapName string, rev snap.Revision) err
 	cfgs[rev.String()] = snapcfg
 	revisionConfig[snapName] = cfgs
 	st.Set("revision-config", revisionConfig)
+
+	// save the origins of the configuration values along
+	origins, err := configOrigins(st)
+	if err != nil {
+		return err
+	}
+	revisionOrigins, err := revisionConfigOrigins(st)
+	if err != nil {
+		return err
+	}
+	revOrigins := revisionOrigins[snapName]
+	if len(origins[snapName]) == 0 {
+		if _, ok := revOrigins[rev.String()]; !ok {
+			return nil
+		}
+		delete(revOrigins, rev.String())
+	} else {
+		if revOrigins == nil {
+			revOrigins = make(map[string]map[string]string)
+		}
+		revOrigins[rev.String()] = origins[snapName]
+	}
+	setRevisionConfigOrigins(st, revisionOrigins, snapName, revOrigins)
 	return nil
 }
 
+func revisionConfigOrigins(st *state.State) (map[string]map[string]map[string]string, error) {
+	var revisionOrigins map[string]map[string]map[string]string // snap => revision => key => origin
+	err := st.Get("revision-config-origins", &revisionOrigins)
+	if err != nil && err != state.ErrNoState {
+		return nil, fmt.Errorf("internal error: cannot unmarshal revision-config-origins: %v", err)
+	}
+	return revisionOrigins, nil
+}
+
+func setRevisionConfigOrigins(st *state.State, revisionOrigins map[string]map[string]map[string]string, snapName string, revOrigins map[string]map[string]string) {
+	if len(revOrigins) == 0 {
+		delete(revisionOrigins, snapName)
+	} else {
+		if revisionOrigins == nil {
+			revisionOrigins = make(map[string]map[string]map[string]string)
+		}
+		revisionOrigins[snapName] = revOrigins
+	}
+	st.Set("revision-config-origins", revisionOrigins)
+}
+
 // RestoreRevisionConfig restores a given revision of snap configuration into config -> snapName.
 // If no configuration exists for given revision it does nothing (no error).
 // The caller is responsible for locking the state.
@@ -224,6 +269,14 @@ func RestoreRevisionConfig(st *state.State, snapName string, rev snap.Revision)
 		if revCfg, ok := cfg[rev.String()]; ok {
 			config[snapName] = revCfg
 			st.Set("config", config)
+
+			// restore the origins of the configuration values
+			// of the revision as well
+			revisionOrigins, err := revisionConfigOrigins(st)
+			if err != nil {
+				return err
+			}
+			return setSnapOrigins(st, snapName, revisionOrigins[snapName][rev.String()])
 		}
 	}
 
@@ -251,6 +304,15 @@ func DiscardRevisionConfig(st *state.State, snapName string, rev snap.Revision)
 		}
 		st.Set("revision-config", revisionConfig)
 	}
+
+	revisionOrigins, err := revisionConfigOrigins(st)
+	if err != nil {
+		return err
+	}
+	if revOrigins, ok := revisionOrigins[snapName][rev.String()]; ok && revOrigins != nil {
+		delete(revisionOrigins[snapName], rev.String())
+		setRevisionConfigOrigins(st, revisionOrigins, snapName, revisionOrigins[snapName])
+	}
 	return nil
 }
 
@@ -268,9 +330,84 @@ func DeleteSnapConfig(st *state.State, snapName string) error {
 		delete(config, snapName)
 		st.Set("config", config)
 	}
+
+	return setSnapOrigins(st, snapName, nil)
+}
+
+// configOrigins returns the recorded origins of the configuration
+// values of all snaps.
+func configOrigins(st *state.State) (map[string]map[string]string, error) {
+	var origins map[string]map[string]string // snap => key => origin
+	err := st.Get("config-origins", &origins)
+	if err != nil && err != state.ErrNoState {
+		return nil, fmt.Errorf("internal error: cannot unmarshal configuration origins: %v", err)
+	}
+	return origins, nil
+}
+
+// setSnapOrigins replaces the recorded origins of the configuration
+// values of the given snap.
+func setSnapOrigins(st *state.State, snapName string, snapOrigins map[string]string) error {
+	origins, err := configOrigins(st)
+	if err != nil {
+		return err
+	}
+	if len(snapOrigins) == 0 {
+		if _, ok := origins[snapName]; !ok {
+			return nil
+		}
+		delete(origins, snapName)
+	} else {
+		if origins == nil {
+			origins = make(map[string]map[string]string)
+		}
+		origins[snapName] = snapOrigins
+	}
+	st.Set("config-origins", origins)
 	return nil
 }
 
+// resolveOrigin returns the origin of key out of the recorded origins
+// of a snap: the one of the key itself or of its closest parent key,
+// unless some of its subkeys have a different origin in which case
+// the value is of mixed provenance and "" is returned.
+func resolveOrigin(snapOrigins map[string]string, key string) string {
+	subkeys := strings.Split(key, ".")
+	origin := ""
+	for i := len(subkeys); i > 0; i-- {
+		if o, ok := snapOrigins[strings.Join(subkeys[:i], ".")]; ok {
+			origin = o
+			break
+		}
+	}
+	for k, o := range snapOrigins {
+		if strings.HasPrefix(k, key+".") && o != origin {
+			return ""
+		}
+	}
+	return origin
+}
+
+// Origin returns the recorded origin (e.g. "gadget") of the value of
+// the given snap's configuration key, looking also at the origins of
+// its parent keys. It returns "" if no origin was recorded, which
+// means the value was set explicitly or is not set at all, or if
+// parts of the value have different origins.
+func Origin(st *state.State, snapName, key string) (string, error) {
+	if _, err := ParseKey(key); err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", nil
+	}
+
+	origins, err := configOrigins(st)
+	if err != nil {
+		return "", err
+	}
+	return resolveOrigin(origins[snapName], key), nil
+}
+
 // Conf is an interface describing both state and transaction.
 type Conf interface {
 	Get(snapName, key string, result interface{}) error
@@ -279,6 +416,18 @@ type Conf interface {
 	State() *state.State
 }
 
+// CoreCfg returns the configuration value for the core snap.
+func CoreCfg(tr Conf, key string) (result string, err error) {
+	var v interface{} = ""
+	if err := tr.Get("core", key, &v); err != nil && !IsNoOption(err) {
+		return "", err
+	}
+	// TODO: we could have a fully typed approach but at the
+	// moment we also always use "" to mean unset as well, this is
+	// the smallest change
+	return fmt.Sprintf("%v", v), nil
+}
+
 // GetFeatureFlag returns the value of a given feature flag.
 func GetFeatureFlag(tr Conf, feature features.SnapdFeature) (bool, error) {
 	var isEnabled interface{}
@@ -93,6 +93,50 @@ func (s *configHelpersSuite) TestConfigSnapshot(c *C) {
 	c.Check(value, Equals, "b")
 }
 
+func (s *configHelpersSuite) TestConfigSnapshotOrigins(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("snap1", "foo", "a"), IsNil)
+	c.Assert(tr.SetOrigin("snap1", "foo", "gadget"), IsNil)
+	tr.Commit()
+	c.Assert(config.SaveRevisionConfig(s.state, "snap1", snap.R(1)), IsNil)
+
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Set("snap1", "foo", "b"), IsNil)
+	tr.Commit()
+	c.Assert(config.SaveRevisionConfig(s.state, "snap1", snap.R(2)), IsNil)
+
+	// the origins follow the restored configuration
+	c.Assert(config.RestoreRevisionConfig(s.state, "snap1", snap.R(1)), IsNil)
+	origin, err := config.Origin(s.state, "snap1", "foo")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "gadget")
+
+	c.Assert(config.RestoreRevisionConfig(s.state, "snap1", snap.R(2)), IsNil)
+	origin, err = config.Origin(s.state, "snap1", "foo")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "")
+
+	c.Assert(config.DiscardRevisionConfig(s.state, "snap1", snap.R(1)), IsNil)
+	var revOrigins map[string]interface{}
+	c.Assert(s.state.Get("revision-config-origins", &revOrigins), IsNil)
+	c.Check(revOrigins, HasLen, 0)
+
+	// replacing the whole configuration drops the origins
+	c.Assert(config.RestoreRevisionConfig(s.state, "snap1", snap.R(1)), IsNil)
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Set("snap1", "foo", "a"), IsNil)
+	c.Assert(tr.SetOrigin("snap1", "foo", "gadget"), IsNil)
+	tr.Commit()
+	cfg := json.RawMessage(`{"foo":"a"}`)
+	c.Assert(config.SetSnapConfig(s.state, "snap1", &cfg), IsNil)
+	origin, err = config.Origin(s.state, "snap1", "foo")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "")
+}
+
 func (s *configHelpersSuite) TestDiscardRevisionConfig(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -204,6 +248,27 @@ func (s *configHelpersSuite) TestGetFeatureFlag(c *C) {
 	c.Assert(err, ErrorMatches, `layouts can only be set to 'true' or 'false', got "banana"`)
 }
 
+func (s *configHelpersSuite) TestCoreCfg(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	tr := config.NewTransaction(s.state)
+
+	// Unset options are empty.
+	v, err := config.CoreCfg(tr, "foo")
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, "")
+
+	// Options of any type are returned as strings.
+	c.Assert(tr.Set("core", "foo", "bar"), IsNil)
+	c.Assert(tr.Set("core", "num", 42), IsNil)
+	v, err = config.CoreCfg(tr, "foo")
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, "bar")
+	v, err = config.CoreCfg(tr, "num")
+	c.Assert(err, IsNil)
+	c.Check(v, Equals, "42")
+}
+
 func (s *configHelpersSuite) TestPatchInvalidConfig(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -42,6 +42,7 @@ type Transaction struct {
 	state    *state.State
 	pristine map[string]map[string]*json.RawMessage // snap => key => value
 	changes  map[string]map[string]interface{}
+	origins  map[string]map[string]string // snap => key => origin
 }
 
 // NewTransaction creates a new configuration transaction initialized with the given state.
@@ -50,6 +51,7 @@ type Transaction struct {
 func NewTransaction(st *state.State) *Transaction {
 	transaction := &Transaction{state: st}
 	transaction.changes = make(map[string]map[string]interface{})
+	transaction.origins = make(map[string]map[string]string)
 
 	// Record the current state of the map containing the config of every snap
 	// in the system. We'll use it for this transaction.
@@ -141,6 +143,38 @@ func (t *Transaction) Set(instanceName, key string, value interface{}) error {
 	}
 
 	t.changes[instanceName] = config
+	t.setOrigin(instanceName, key, "")
+	return nil
+}
+
+func (t *Transaction) setOrigin(instanceName, key, origin string) {
+	origins, ok := t.origins[instanceName]
+	if !ok {
+		origins = make(map[string]string)
+		t.origins[instanceName] = origins
+	}
+	// the value replaces the ones of any subkey set before
+	for k := range origins {
+		if strings.HasPrefix(k, key+".") {
+			delete(origins, k)
+		}
+	}
+	origins[key] = origin
+}
+
+// SetOrigin records origin as the provenance of the value last set
+// for the provided snap's configuration key, for example "gadget" for
+// values coming from gadget defaults. On Commit the origin is stored
+// alongside the configuration, while keys set without an origin have
+// any previously recorded origin cleared.
+func (t *Transaction) SetOrigin(instanceName, key, origin string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := ParseKey(key); err != nil {
+		return err
+	}
+	t.setOrigin(instanceName, key, origin)
 	return nil
 }
 
@@ -261,9 +295,61 @@ func (t *Transaction) Commit() {
 	}
 
 	t.state.Set("config", t.pristine)
+	t.commitOrigins()
 
 	// The cache has been flushed, reset it.
 	t.changes = make(map[string]map[string]interface{})
+	t.origins = make(map[string]map[string]string)
+}
+
+func (t *Transaction) commitOrigins() {
+	origins, err := configOrigins(t.state)
+	if err != nil {
+		panic(err)
+	}
+
+	changed := false
+	for instanceName, snapOrigins := range t.origins {
+		recorded := origins[instanceName]
+		if recorded == nil {
+			recorded = make(map[string]string)
+		}
+		keys := make([]string, 0, len(snapOrigins))
+		for key := range snapOrigins {
+			keys = append(keys, key)
+		}
+		// parent keys first
+		sort.Strings(keys)
+		for _, key := range keys {
+			// the new value replaces the key and its subkeys
+			for k := range recorded {
+				if k == key || strings.HasPrefix(k, key+".") {
+					delete(recorded, k)
+					changed = true
+				}
+			}
+			// the origin recorded for a parent key still applies
+			// to the untouched subkeys of it, so record an
+			// overriding origin (possibly "") only for the key
+			// itself if needed
+			origin := snapOrigins[key]
+			if origin != resolveOrigin(recorded, key) {
+				recorded[key] = origin
+				changed = true
+			}
+		}
+		if len(recorded) == 0 {
+			delete(origins, instanceName)
+			continue
+		}
+		if origins == nil {
+			origins = make(map[string]map[string]string)
+		}
+		origins[instanceName] = recorded
+	}
+	if changed {
+		t.state.Set("config-origins", origins)
+	}
 }
 
 func applyChanges(config map[string]*json.RawMessage, changes map[string]interface{}) {
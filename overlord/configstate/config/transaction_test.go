@@ -469,3 +469,85 @@ func (s *transactionSuite) TestPristineIsNotTainted(c *C) {
 	c.Assert(json.Unmarshal([]byte(*pristine["test-snap"]["foo"]), &data), IsNil)
 	c.Assert(data, DeepEquals, map[string]interface{}{"a": map[string]interface{}{"a": "a"}})
 }
+
+func (s *transactionSuite) TestOrigins(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("test-snap", "foo", map[string]interface{}{"a": 1}), IsNil)
+	c.Assert(tr.SetOrigin("test-snap", "foo", "gadget"), IsNil)
+	c.Assert(tr.Set("test-snap", "bar", "x"), IsNil)
+	c.Assert(tr.SetOrigin("test-snap", "bar", "gadget"), IsNil)
+	c.Assert(tr.Set("test-snap", "baz", "y"), IsNil)
+
+	// nothing recorded before commit
+	origin, err := config.Origin(s.state, "test-snap", "foo")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "")
+
+	tr.Commit()
+
+	for _, t := range []struct {
+		key    string
+		origin string
+	}{
+		{"foo", "gadget"},
+		{"foo.a", "gadget"},
+		{"bar", "gadget"},
+		{"baz", ""},
+		{"other", ""},
+	} {
+		origin, err := config.Origin(s.state, "test-snap", t.key)
+		c.Assert(err, IsNil)
+		c.Check(origin, Equals, t.origin, Commentf("%s", t.key))
+	}
+
+	// setting a value explicitly clears the origin of it, its
+	// untouched siblings keep theirs while the parent value is now
+	// of mixed provenance
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Set("test-snap", "foo.b", 2), IsNil)
+	c.Assert(tr.Set("other-snap", "bar", 2), IsNil)
+	tr.Commit()
+
+	for _, t := range []struct {
+		key    string
+		origin string
+	}{
+		{"foo", ""},
+		{"foo.a", "gadget"},
+		{"foo.b", ""},
+		{"bar", "gadget"},
+	} {
+		origin, err := config.Origin(s.state, "test-snap", t.key)
+		c.Assert(err, IsNil)
+		c.Check(origin, Equals, t.origin, Commentf("%s", t.key))
+	}
+
+	// setting the parent again from the gadget restores a single
+	// origin for all of it
+	tr = config.NewTransaction(s.state)
+	c.Assert(tr.Set("test-snap", "foo", map[string]interface{}{"a": 1, "b": 1}), IsNil)
+	c.Assert(tr.SetOrigin("test-snap", "foo", "gadget"), IsNil)
+	tr.Commit()
+
+	var origins map[string]map[string]string
+	c.Assert(s.state.Get("config-origins", &origins), IsNil)
+	c.Check(origins, DeepEquals, map[string]map[string]string{
+		"test-snap": {"foo": "gadget", "bar": "gadget"},
+	})
+
+	c.Assert(config.DeleteSnapConfig(s.state, "test-snap"), IsNil)
+	origin, err = config.Origin(s.state, "test-snap", "bar")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "")
+}
+
+func (s *transactionSuite) TestSetOriginInvalidKey(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Check(tr.SetOrigin("test-snap", "foo..bar", "gadget"), ErrorMatches, `invalid option name: ""`)
+}
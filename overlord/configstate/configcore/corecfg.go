@@ -33,16 +33,7 @@ var (
 )
 
 // coreCfg returns the configuration value for the core snap.
-func coreCfg(tr config.Conf, key string) (result string, err error) {
-	var v interface{} = ""
-	if err := tr.Get("core", key, &v); err != nil && !config.IsNoOption(err) {
-		return "", err
-	}
-	// TODO: we could have a fully typed approach but at the
-	// moment we also always use "" to mean unset as well, this is
-	// the smallest change
-	return fmt.Sprintf("%v", v), nil
-}
+var coreCfg = config.CoreCfg
 
 // supportedConfigurations contains a set of handled configuration keys.
 // The actual values are populated by `init()` functions in each module.
@@ -73,12 +64,18 @@ func Run(tr config.Conf) error {
 	if err := validateProxyStore(tr); err != nil {
 		return err
 	}
+	if err := validateProxyAutoConfig(tr); err != nil {
+		return err
+	}
 	if err := validateRefreshSchedule(tr); err != nil {
 		return err
 	}
 	if err := validateRefreshRateLimit(tr); err != nil {
 		return err
 	}
+	if err := validateRefreshReportMetrics(tr); err != nil {
+		return err
+	}
 	if err := validateExperimentalSettings(tr); err != nil {
 		return err
 	}
@@ -91,6 +88,24 @@ func Run(tr config.Conf) error {
 	if err := validateAutomaticSnapshotsExpiration(tr); err != nil {
 		return err
 	}
+	if err := validateScheduledSnapshots(tr); err != nil {
+		return err
+	}
+	if err := validateSnapshotsEncryption(tr); err != nil {
+		return err
+	}
+	if err := validateHookLimits(tr); err != nil {
+		return err
+	}
+	if err := validateSystemSettings(tr); err != nil {
+		return err
+	}
+	if err := validateStoreSettings(tr); err != nil {
+		return err
+	}
+	if err := validateRegistrationAttestation(tr); err != nil {
+		return err
+	}
 	// FIXME: ensure the user cannot set "core seed.loaded"
 
 	// capture cloud information
@@ -98,6 +113,11 @@ func Run(tr config.Conf) error {
 		return err
 	}
 
+	// experimental.seccomp-overrides
+	if err := handleSeccompOverrides(tr); err != nil {
+		return err
+	}
+
 	// Export experimental.* flags to a place easily accessible from snapd helpers.
 	if err := handleExperimentalFlags(tr); err != nil {
 		return err
@@ -124,7 +144,7 @@ func Run(tr config.Conf) error {
 	if err := handlePiConfiguration(tr); err != nil {
 		return err
 	}
-	// proxy.{http,https,ftp}
+	// proxy.{http,https,ftp,no-proxy,pac-url}
 	if err := handleProxyConfiguration(tr); err != nil {
 		return err
 	}
@@ -136,6 +156,10 @@ func Run(tr config.Conf) error {
 	if err := handleNetworkConfiguration(tr); err != nil {
 		return err
 	}
+	// system.{hostname,timezone,locale}
+	if err := handleSystemSettings(tr); err != nil {
+		return err
+	}
 
 	return nil
 }
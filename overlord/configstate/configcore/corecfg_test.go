@@ -27,7 +27,9 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/overlord/configstate/configcore"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/systemd"
 )
@@ -107,6 +109,9 @@ func (s *configcoreSuite) TearDownSuite(c *C) {
 func (s *configcoreSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(c.MkDir())
 	s.state = state.New(nil)
+	s.state.Lock()
+	ifacerepo.Replace(s.state, interfaces.NewRepository())
+	s.state.Unlock()
 }
 
 func (s *configcoreSuite) TearDownTest(c *C) {
@@ -20,13 +20,19 @@
 package configcore
 
 import (
+	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
 )
 
 func init() {
@@ -70,3 +76,85 @@ func handleExperimentalFlags(tr config.Conf) error {
 	_, _, err := osutil.EnsureDirState(dir, "*", content)
 	return err
 }
+
+// handleSeccompOverrides queues the regeneration of the security profiles
+// of the snaps with a connected seccomp-overrides plug when
+// experimental.seccomp-overrides changes, as the seccomp backend only
+// applies the overrides while the feature is enabled. It must run before
+// handleExperimentalFlags updates the exported feature file.
+func handleSeccompOverrides(tr config.Conf) error {
+	enabled, err := config.GetFeatureFlag(tr, features.SeccompOverrides)
+	if err != nil {
+		return err
+	}
+	if enabled == features.SeccompOverrides.IsEnabled() {
+		return nil
+	}
+
+	st := tr.State()
+	st.Lock()
+	defer st.Unlock()
+
+	snapNames, err := snapsWithSeccompOverrides(st)
+	if err != nil {
+		return err
+	}
+	var tasks []*state.Task
+	for _, snapName := range snapNames {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, snapName, &snapst); err != nil {
+			return err
+		}
+		if !snapst.Active {
+			// profiles are set up again when the snap is enabled
+			continue
+		}
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return err
+		}
+		snapsup := &snapstate.SnapSetup{
+			SideInfo:    snapst.CurrentSideInfo(),
+			Flags:       snapst.Flags.ForSnapSetup(),
+			Type:        info.GetType(),
+			PlugsOnly:   len(info.Slots) == 0,
+			InstanceKey: snapst.InstanceKey,
+		}
+		setupProfiles := st.NewTask("setup-profiles", fmt.Sprintf(i18n.G("Setup snap %q (%s) security profiles"), snapName, snapst.Current))
+		setupProfiles.Set("snap-setup", snapsup)
+		tasks = append(tasks, setupProfiles)
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	chg := st.NewChange("regenerate-security-profiles", i18n.G("Regenerate security profiles of snaps using seccomp overrides"))
+	chg.AddAll(state.NewTaskSet(tasks...))
+	st.EnsureBefore(0)
+	return nil
+}
+
+// snapsWithSeccompOverrides returns the sorted names of the snaps with a
+// connected seccomp-overrides plug.
+func snapsWithSeccompOverrides(st *state.State) ([]string, error) {
+	repo := ifacerepo.Get(st)
+	seen := make(map[string]bool)
+	var snapNames []string
+	for _, plug := range repo.AllPlugs("seccomp-overrides") {
+		snapName := plug.Snap.InstanceName()
+		if seen[snapName] {
+			continue
+		}
+		conns, err := repo.Connected(snapName, plug.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(conns) == 0 {
+			continue
+		}
+		seen[snapName] = true
+		snapNames = append(snapNames, snapName)
+	}
+	sort.Strings(snapNames)
+	return snapNames, nil
+}
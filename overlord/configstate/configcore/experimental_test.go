@@ -25,7 +25,13 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/overlord/configstate/configcore"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -77,3 +83,92 @@ func (s *experimentalSuite) TestExportedFeatures(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(features.PerUserMountNamespace.ControlFile(), testutil.FilePresent)
 }
+
+func (s *experimentalSuite) mockSnapWithPlug(c *C, name string, rev snap.Revision) *snap.Info {
+	si := &snap.SideInfo{RealName: name, Revision: rev}
+	info := snaptest.MockSnap(c, fmt.Sprintf(`name: %s
+version: 1
+plugs:
+  seccomp-overrides:
+`, name), si)
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapstate.Set(s.state, name, &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  rev,
+		SnapType: "app",
+	})
+	return info
+}
+
+func (s *experimentalSuite) TestSeccompOverridesRegeneratesProfiles(c *C) {
+	restore := snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {})
+	defer restore()
+
+	repo := interfaces.NewRepository()
+	c.Assert(repo.AddInterface(&ifacetest.TestInterface{InterfaceName: "seccomp-overrides"}), IsNil)
+	core := snaptest.MockInfo(c, `name: core
+version: 1
+type: os
+slots:
+  seccomp-overrides:
+`, nil)
+	c.Assert(repo.AddSnap(core), IsNil)
+	connected := s.mockSnapWithPlug(c, "connected", snap.R(7))
+	c.Assert(repo.AddSnap(connected), IsNil)
+	_, err := repo.Connect(interfaces.NewConnRef(connected.Plugs["seccomp-overrides"], core.Slots["seccomp-overrides"]), nil, nil, nil, nil, nil)
+	c.Assert(err, IsNil)
+	disconnected := s.mockSnapWithPlug(c, "disconnected", snap.R(3))
+	c.Assert(repo.AddSnap(disconnected), IsNil)
+	s.state.Lock()
+	ifacerepo.Replace(s.state, repo)
+	s.state.Unlock()
+
+	checkRegeneration := func(n int) {
+		s.state.Lock()
+		defer s.state.Unlock()
+		chgs := s.state.Changes()
+		c.Assert(chgs, HasLen, n)
+		chg := chgs[n-1]
+		c.Check(chg.Kind(), Equals, "regenerate-security-profiles")
+		tasks := chg.Tasks()
+		c.Assert(tasks, HasLen, 1)
+		c.Check(tasks[0].Kind(), Equals, "setup-profiles")
+		snapsup, err := snapstate.TaskSnapSetup(tasks[0])
+		c.Assert(err, IsNil)
+		c.Check(snapsup.InstanceName(), Equals, "connected")
+		c.Check(snapsup.Revision(), Equals, snap.R(7))
+	}
+
+	conf := &mockConf{
+		state: s.state,
+		conf:  map[string]interface{}{featureConf(features.SeccompOverrides): true},
+	}
+	c.Assert(configcore.Run(conf), IsNil)
+	c.Check(features.SeccompOverrides.ControlFile(), testutil.FilePresent)
+	checkRegeneration(1)
+
+	// no change of the flag, nothing to regenerate
+	c.Assert(configcore.Run(conf), IsNil)
+	s.state.Lock()
+	c.Check(s.state.Changes(), HasLen, 1)
+	s.state.Unlock()
+
+	conf.conf[featureConf(features.SeccompOverrides)] = false
+	c.Assert(configcore.Run(conf), IsNil)
+	c.Check(features.SeccompOverrides.ControlFile(), testutil.FileAbsent)
+	checkRegeneration(2)
+}
+
+func (s *experimentalSuite) TestSeccompOverridesNoAffectedSnaps(c *C) {
+	conf := &mockConf{
+		state: s.state,
+		conf:  map[string]interface{}{featureConf(features.SeccompOverrides): true},
+	}
+	c.Assert(configcore.Run(conf), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(s.state.Changes(), HasLen, 0)
+}
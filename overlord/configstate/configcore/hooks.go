@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/snap"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.hooks.limits.cpu"] = true
+	supportedConfigurations["core.hooks.limits.memory"] = true
+	supportedConfigurations["core.hooks.limits.timeout"] = true
+}
+
+func validateHookLimits(tr config.Conf) error {
+	var values [3]string
+	for i, key := range []string{"hooks.limits.cpu", "hooks.limits.memory", "hooks.limits.timeout"} {
+		value, err := coreCfg(tr, key)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	if _, err := snap.ParseHookLimits(values[0], values[1], values[2]); err != nil {
+		return fmt.Errorf("cannot set default hook limits: %v", err)
+	}
+	return nil
+}
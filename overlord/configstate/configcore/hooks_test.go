@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type hooksSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&hooksSuite{})
+
+func (s *hooksSuite) TestConfigureHookLimitsHappy(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"hooks.limits.cpu":     "50%",
+			"hooks.limits.memory":  "256MB",
+			"hooks.limits.timeout": "5m",
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *hooksSuite) TestConfigureHookLimitsInvalid(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"hooks.limits.cpu", "half", `cannot set default hook limits: invalid cpu limit "half": .*`},
+		{"hooks.limits.memory", "lots", `cannot set default hook limits: invalid memory limit: .*`},
+		{"hooks.limits.timeout", "soon", `cannot set default hook limits: invalid timeout limit: .*`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				t.key: t.value,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf("%s=%s", t.key, t.value))
+	}
+}
@@ -22,6 +22,7 @@ package configcore
 import (
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -37,6 +38,7 @@ var proxyConfigKeys = map[string]bool{
 	"https_proxy": true,
 	"ftp_proxy":   true,
 	"no_proxy":    true,
+	"auto_proxy":  true,
 }
 
 func init() {
@@ -46,6 +48,7 @@ func init() {
 	supportedConfigurations["core.proxy.ftp"] = true
 	supportedConfigurations["core.proxy.no-proxy"] = true
 	supportedConfigurations["core.proxy.store"] = true
+	supportedConfigurations["core.proxy.pac-url"] = true
 }
 
 func etcEnvironment() string {
@@ -86,6 +89,12 @@ func handleProxyConfiguration(tr config.Conf) error {
 		return err
 	}
 	config["no_proxy"] = output
+	// proxy auto-config
+	output, err = coreCfg(tr, "proxy.pac-url")
+	if err != nil {
+		return err
+	}
+	config["auto_proxy"] = output
 
 	if err := updateEtcEnvironmentConfig(etcEnvironment(), config); err != nil {
 		return err
@@ -94,6 +103,34 @@ func handleProxyConfiguration(tr config.Conf) error {
 	return nil
 }
 
+func validateProxyAutoConfig(tr config.Conf) error {
+	pacURL, err := coreCfg(tr, "proxy.pac-url")
+	if err != nil {
+		return err
+	}
+	if pacURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(pacURL)
+	if err != nil {
+		return fmt.Errorf("cannot parse proxy.pac-url %q: %v", pacURL, err)
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return fmt.Errorf("proxy.pac-url %q has no host", pacURL)
+		}
+	case "file":
+		if !filepath.IsAbs(u.Path) {
+			return fmt.Errorf("proxy.pac-url %q must refer to an absolute path", pacURL)
+		}
+	default:
+		return fmt.Errorf("proxy.pac-url %q must be a https or file URL", pacURL)
+	}
+	return nil
+}
+
 func validateProxyStore(tr config.Conf) error {
 	proxyStore, err := coreCfg(tr, "proxy.store")
 	if err != nil {
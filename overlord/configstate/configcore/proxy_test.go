@@ -125,6 +125,67 @@ PATH="/usr/bin"
 no_proxy=example.com,bar.com`)
 }
 
+func (s *proxySuite) TestConfigureProxyAutoConfig(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.makeMockEtcEnvironment(c)
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"proxy.pac-url": "https://wpad.example.com/proxy.pac",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.mockEtcEnvironment, testutil.FileEquals, `
+PATH="/usr/bin"
+auto_proxy=https://wpad.example.com/proxy.pac`)
+
+	// and unset again
+	err = configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"proxy.pac-url": "",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.mockEtcEnvironment, testutil.FileEquals, `
+PATH="/usr/bin"
+#auto_proxy=https://wpad.example.com/proxy.pac`)
+}
+
+func (s *proxySuite) TestConfigureProxyAutoConfigValidation(c *C) {
+	for _, pacURL := range []string{
+		"https://wpad.example.com/proxy.pac",
+		"file:///etc/proxy.pac",
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf:  map[string]interface{}{"proxy.pac-url": pacURL},
+		})
+		c.Check(err, IsNil, Commentf(pacURL))
+	}
+
+	for _, t := range []struct {
+		pacURL, err string
+	}{
+		{"ftp://wpad.example.com/proxy.pac", `proxy.pac-url "ftp://wpad.example.com/proxy.pac" must be a https or file URL`},
+		{"http://wpad.example.com/proxy.pac", `proxy.pac-url "http://wpad.example.com/proxy.pac" must be a https or file URL`},
+		{"wpad.example.com/proxy.pac", `proxy.pac-url "wpad.example.com/proxy.pac" must be a https or file URL`},
+		{"https:///proxy.pac", `proxy.pac-url "https:///proxy.pac" has no host`},
+		{"file:proxy.pac", `proxy.pac-url "file:proxy.pac" must refer to an absolute path`},
+		{"http://%zz", `cannot parse proxy.pac-url "http://%zz": .*`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf:  map[string]interface{}{"proxy.pac-url": t.pacURL},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf(t.pacURL))
+	}
+}
+
 func (s *proxySuite) TestConfigureProxyStore(c *C) {
 	// set to ""
 	err := configcore.Run(&mockConf{
@@ -21,7 +21,9 @@ package configcore
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/overlord/configstate/config"
@@ -37,8 +39,18 @@ func init() {
 	supportedConfigurations["core.refresh.metered"] = true
 	supportedConfigurations["core.refresh.retain"] = true
 	supportedConfigurations["core.refresh.rate-limit"] = true
+	supportedConfigurations["core.refresh.ignore-gating"] = true
+	supportedConfigurations["core.refresh.max-parallel-downloads"] = true
+	supportedConfigurations["core.refresh.max-hook-hold"] = true
+	supportedConfigurations["core.refresh.report-metrics"] = true
 }
 
+// the pre-refresh-download hook of a snap cannot hold its
+// auto-refreshes for longer than this
+const maxHookHold = 7 * 24 * time.Hour
+
+var validSnapID = regexp.MustCompile("^[a-z0-9A-Z]{32}$")
+
 func validateRefreshSchedule(tr config.Conf) error {
 	refreshRetainStr, err := coreCfg(tr, "refresh.retain")
 	if err != nil {
@@ -50,6 +62,38 @@ func validateRefreshSchedule(tr config.Conf) error {
 		}
 	}
 
+	maxParallelDownloadsStr, err := coreCfg(tr, "refresh.max-parallel-downloads")
+	if err != nil {
+		return err
+	}
+	if maxParallelDownloadsStr != "" {
+		if n, err := strconv.ParseUint(maxParallelDownloadsStr, 10, 8); err != nil || (n < 1 || n > 10) {
+			return fmt.Errorf("max-parallel-downloads must be a number between 1 and 10, not %q", maxParallelDownloadsStr)
+		}
+	}
+
+	maxHookHoldStr, err := coreCfg(tr, "refresh.max-hook-hold")
+	if err != nil {
+		return err
+	}
+	if maxHookHoldStr != "" {
+		if d, err := time.ParseDuration(maxHookHoldStr); err != nil || d < 0 || d > maxHookHold {
+			return fmt.Errorf("max-hook-hold must be a duration between 0 and %s, not %q", maxHookHold, maxHookHoldStr)
+		}
+	}
+
+	ignoreGatingStr, err := coreCfg(tr, "refresh.ignore-gating")
+	if err != nil {
+		return err
+	}
+	if ignoreGatingStr != "" {
+		for _, gatingID := range strings.Split(ignoreGatingStr, ",") {
+			if !validSnapID.MatchString(strings.TrimSpace(gatingID)) {
+				return fmt.Errorf("ignore-gating must be a comma separated list of snap-ids, not %q", ignoreGatingStr)
+			}
+		}
+	}
+
 	refreshHoldStr, err := coreCfg(tr, "refresh.hold")
 	if err != nil {
 		return err
@@ -118,6 +162,10 @@ func validateRefreshSchedule(tr config.Conf) error {
 	return err
 }
 
+func validateRefreshReportMetrics(tr config.Conf) error {
+	return validateBoolFlag(tr, "refresh.report-metrics")
+}
+
 func validateRefreshRateLimit(tr config.Conf) error {
 	refreshRateLimit, err := coreCfg(tr, "refresh.rate-limit")
 	if err != nil {
@@ -20,6 +20,8 @@
 package configcore_test
 
 import (
+	"fmt"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/overlord/configstate/configcore"
@@ -167,3 +169,103 @@ func (s *refreshSuite) TestConfigureRefreshRetainInvalid(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `retain must be a number between 2 and 20, not "invalid"`)
 }
+
+func (s *refreshSuite) TestConfigureRefreshMaxParallelDownloadsHappy(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"refresh.max-parallel-downloads": "5",
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *refreshSuite) TestConfigureRefreshMaxParallelDownloadsInvalid(c *C) {
+	for _, v := range []string{"0", "11", "invalid"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.max-parallel-downloads": v,
+			},
+		})
+		c.Check(err, ErrorMatches, fmt.Sprintf(`max-parallel-downloads must be a number between 1 and 10, not %q`, v))
+	}
+}
+
+func (s *refreshSuite) TestConfigureRefreshMaxHookHoldHappy(c *C) {
+	for _, v := range []string{"0s", "12h", "168h"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.max-hook-hold": v,
+			},
+		})
+		c.Check(err, IsNil, Commentf(v))
+	}
+}
+
+func (s *refreshSuite) TestConfigureRefreshMaxHookHoldInvalid(c *C) {
+	for _, v := range []string{"-1h", "169h", "invalid"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.max-hook-hold": v,
+			},
+		})
+		c.Check(err, ErrorMatches, fmt.Sprintf(`max-hook-hold must be a duration between 0 and 168h0m0s, not %q`, v))
+	}
+}
+
+func (s *refreshSuite) TestConfigureRefreshIgnoreGatingHappy(c *C) {
+	for _, v := range []string{
+		"",
+		"bar0123456789012345678901234567a",
+		"bar0123456789012345678901234567a, BAZ0123456789012345678901234567b",
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.ignore-gating": v,
+			},
+		})
+		c.Check(err, IsNil, Commentf(v))
+	}
+}
+
+func (s *refreshSuite) TestConfigureRefreshIgnoreGatingInvalid(c *C) {
+	for _, v := range []string{
+		"bar-id",
+		"bar0123456789012345678901234567a,",
+		"bar0123456789012345678901234567a,,baz0123456789012345678901234567b",
+		"bar0123456789012345678901234567a;baz0123456789012345678901234567b",
+		"bar0123456789012345678901234567a0",
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.ignore-gating": v,
+			},
+		})
+		c.Check(err, ErrorMatches, fmt.Sprintf(`ignore-gating must be a comma separated list of snap-ids, not %q`, v))
+	}
+}
+
+func (s *refreshSuite) TestConfigureRefreshReportMetrics(c *C) {
+	for _, v := range []interface{}{true, false, "true"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"refresh.report-metrics": v,
+			},
+		})
+		c.Check(err, IsNil, Commentf("%v", v))
+	}
+
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"refresh.report-metrics": "sometimes",
+		},
+	})
+	c.Check(err, ErrorMatches, `refresh.report-metrics can only be set to 'true' or 'false'`)
+}
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.registration.attestation-helper"] = true
+	supportedConfigurations["core.registration.attestation-type"] = true
+}
+
+func validateRegistrationAttestation(tr config.Conf) error {
+	helper, err := coreCfg(tr, "registration.attestation-helper")
+	if err != nil {
+		return err
+	}
+	attestationType, err := coreCfg(tr, "registration.attestation-type")
+	if err != nil {
+		return err
+	}
+	if helper != "" && !filepath.IsAbs(helper) {
+		return fmt.Errorf("registration.attestation-helper must be an absolute path, not %q", helper)
+	}
+	if (helper == "") != (attestationType == "") {
+		return fmt.Errorf("registration.attestation-helper and registration.attestation-type must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type registrationSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&registrationSuite{})
+
+func (s *registrationSuite) TestConfigureAttestationHelper(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"registration.attestation-helper": "/usr/lib/vendor/attest",
+			"registration.attestation-type":   "vendor-hsm",
+		},
+	})
+	c.Check(err, IsNil)
+}
+
+func (s *registrationSuite) TestConfigureAttestationHelperErrors(c *C) {
+	for _, t := range []struct {
+		helper, attestationType string
+		err                     string
+	}{
+		{"usr/lib/vendor/attest", "vendor-hsm", `registration.attestation-helper must be an absolute path, not "usr/lib/vendor/attest"`},
+		{"/usr/lib/vendor/attest", "", `registration.attestation-helper and registration.attestation-type must be set together`},
+		{"", "vendor-hsm", `registration.attestation-helper and registration.attestation-type must be set together`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"registration.attestation-helper": t.helper,
+				"registration.attestation-type":   t.attestationType,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf("%v", t))
+	}
+}
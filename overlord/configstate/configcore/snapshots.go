@@ -21,14 +21,22 @@ package configcore
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/timeutil"
 )
 
 func init() {
 	// add supported configuration of this module
 	supportedConfigurations["core.snapshots.automatic.retention"] = true
+	supportedConfigurations["core.snapshots.schedule"] = true
+	supportedConfigurations["core.snapshots.scheduled.snaps"] = true
+	supportedConfigurations["core.snapshots.scheduled.keep"] = true
+	supportedConfigurations["core.snapshots.scheduled.max-size"] = true
+	supportedConfigurations["core.snapshots.encrypt"] = true
 }
 
 func validateAutomaticSnapshotsExpiration(tr config.Conf) error {
@@ -47,3 +55,38 @@ func validateAutomaticSnapshotsExpiration(tr config.Conf) error {
 	}
 	return nil
 }
+
+func validateScheduledSnapshots(tr config.Conf) error {
+	scheduleStr, err := coreCfg(tr, "snapshots.schedule")
+	if err != nil {
+		return err
+	}
+	if scheduleStr != "" {
+		if _, err := timeutil.ParseSchedule(scheduleStr); err != nil {
+			return fmt.Errorf("snapshots.schedule cannot be parsed: %v", err)
+		}
+	}
+	keepStr, err := coreCfg(tr, "snapshots.scheduled.keep")
+	if err != nil {
+		return err
+	}
+	if keepStr != "" {
+		if keep, err := strconv.Atoi(keepStr); err != nil || keep < 1 {
+			return fmt.Errorf("snapshots.scheduled.keep must be a number greater than zero, not %q", keepStr)
+		}
+	}
+	maxSizeStr, err := coreCfg(tr, "snapshots.scheduled.max-size")
+	if err != nil {
+		return err
+	}
+	if maxSizeStr != "" {
+		if _, err := strutil.ParseByteSize(maxSizeStr); err != nil {
+			return fmt.Errorf("snapshots.scheduled.max-size cannot be parsed: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateSnapshotsEncryption(tr config.Conf) error {
+	return validateBoolFlag(tr, "snapshots.encrypt")
+}
@@ -70,3 +70,55 @@ func (s *refreshSuite) TestConfigureAutomaticSnapshotsExpirationInvalid(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `snapshots.automatic.retention cannot be parsed:.*`)
 }
+
+func (s *snapshotsSuite) TestConfigureScheduledSnapshotsHappy(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.schedule":           "mon,02:00",
+			"snapshots.scheduled.snaps":    "foo,bar",
+			"snapshots.scheduled.keep":     "5",
+			"snapshots.scheduled.max-size": "2GB",
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *snapshotsSuite) TestConfigureScheduledSnapshotsInvalid(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"snapshots.schedule", "invalid", `snapshots.schedule cannot be parsed:.*`},
+		{"snapshots.scheduled.keep", "0", `snapshots.scheduled.keep must be a number greater than zero, not "0"`},
+		{"snapshots.scheduled.keep", "many", `snapshots.scheduled.keep must be a number greater than zero, not "many"`},
+		{"snapshots.scheduled.max-size", "big", `snapshots.scheduled.max-size cannot be parsed:.*`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				t.key: t.value,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf("%s=%s", t.key, t.value))
+	}
+}
+
+func (s *snapshotsSuite) TestConfigureSnapshotsEncrypt(c *C) {
+	for _, value := range []string{"true", "false"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"snapshots.encrypt": value,
+			},
+		})
+		c.Check(err, IsNil, Commentf(value))
+	}
+
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.encrypt": "maybe",
+		},
+	})
+	c.Check(err, ErrorMatches, `snapshots.encrypt can only be set to 'true' or 'false'`)
+}
@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.store.local-repository"] = true
+	supportedConfigurations["core.store.lan-peers.interface"] = true
+}
+
+// network interface names are at most IFNAMSIZ-1 bytes long
+var validInterfaceName = regexp.MustCompile(`^[a-zA-Z0-9_.:-]{1,15}$`)
+
+func validateStoreSettings(tr config.Conf) error {
+	repo, err := coreCfg(tr, "store.local-repository")
+	if err != nil {
+		return err
+	}
+	// the directory may be on removable media that is not present yet
+	if repo != "" && !filepath.IsAbs(repo) {
+		return fmt.Errorf("store.local-repository must be an absolute path, not %q", repo)
+	}
+
+	// the interface may not be up yet
+	iface, err := coreCfg(tr, "store.lan-peers.interface")
+	if err != nil {
+		return err
+	}
+	if iface != "" && !validInterfaceName.MatchString(iface) {
+		return fmt.Errorf("store.lan-peers.interface must be a network interface name, not %q", iface)
+	}
+	return nil
+}
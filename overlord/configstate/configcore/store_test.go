@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type storeSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&storeSuite{})
+
+func (s *storeSuite) TestConfigureLocalRepository(c *C) {
+	for _, dir := range []string{"", "/media/usb/snaps"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"store.local-repository": dir,
+			},
+		})
+		c.Check(err, IsNil)
+	}
+}
+
+func (s *storeSuite) TestConfigureLocalRepositoryRelative(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"store.local-repository": "media/usb",
+		},
+	})
+	c.Assert(err, ErrorMatches, `store.local-repository must be an absolute path, not "media/usb"`)
+}
+
+func (s *storeSuite) TestConfigureLANPeersInterface(c *C) {
+	for _, iface := range []string{"", "eth0", "enp0s31f6", "wlan0.1"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"store.lan-peers.interface": iface,
+			},
+		})
+		c.Check(err, IsNil, Commentf(iface))
+	}
+
+	for _, iface := range []string{"eth 0", "../eth0", "averyveryverylongname"} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"store.lan-peers.interface": iface,
+			},
+		})
+		c.Check(err, ErrorMatches, `store.lan-peers.interface must be a network interface name, not .*`, Commentf(iface))
+	}
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/strutil"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core.system.hostname"] = true
+	supportedConfigurations["core.system.timezone"] = true
+	supportedConfigurations["core.system.locale"] = true
+	supportedConfigurations["core.system.recovery-systems.retain"] = true
+}
+
+var (
+	// hostnames are made of RFC 1123 labels separated by dots
+	validHostname = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+	validTimezone = regexp.MustCompile(`^[a-zA-Z0-9_+-]+(/[a-zA-Z0-9_+-]+)*$`)
+	validLocale   = regexp.MustCompile(`^([a-z]{2,3}(_[A-Z]{2})?|C|POSIX)(\.[a-zA-Z0-9-]+)?(@[a-z]+)?$`)
+)
+
+// maxHostnameLen is HOST_NAME_MAX on Linux
+const maxHostnameLen = 64
+
+func zoneinfoDir() string {
+	return filepath.Join(dirs.GlobalRootDir, "/usr/share/zoneinfo")
+}
+
+func validateSystemSettings(tr config.Conf) error {
+	hostname, err := coreCfg(tr, "system.hostname")
+	if err != nil {
+		return err
+	}
+	if hostname != "" && (len(hostname) > maxHostnameLen || !validHostname.MatchString(hostname)) {
+		return fmt.Errorf("cannot set hostname %q: invalid hostname", hostname)
+	}
+
+	timezone, err := coreCfg(tr, "system.timezone")
+	if err != nil {
+		return err
+	}
+	if timezone != "" {
+		if !validTimezone.MatchString(timezone) {
+			return fmt.Errorf("cannot set timezone %q: invalid timezone", timezone)
+		}
+		if !osutil.FileExists(filepath.Join(zoneinfoDir(), timezone)) {
+			return fmt.Errorf("cannot set timezone %q: unknown timezone", timezone)
+		}
+	}
+
+	locale, err := coreCfg(tr, "system.locale")
+	if err != nil {
+		return err
+	}
+	if locale != "" && !validLocale.MatchString(locale) {
+		return fmt.Errorf("cannot set locale %q: invalid locale", locale)
+	}
+
+	retainStr, err := coreCfg(tr, "system.recovery-systems.retain")
+	if err != nil {
+		return err
+	}
+	if retainStr != "" {
+		if n, err := strconv.ParseUint(retainStr, 10, 8); err != nil || (n < 1 || n > 10) {
+			return fmt.Errorf("recovery-systems.retain must be a number between 1 and 10, not %q", retainStr)
+		}
+	}
+
+	return nil
+}
+
+// handleSystemSettings applies the hostname, timezone and locale via
+// hostnamed, timedated and localed respectively, which persist them
+// in the places the system reads them from, so they are only applied
+// when they change.
+func handleSystemSettings(tr config.Conf) error {
+	changes := tr.Changes()
+	for _, setting := range []struct {
+		key  string
+		args func(value string) []string
+	}{
+		{"system.hostname", func(v string) []string { return []string{"hostnamectl", "set-hostname", v} }},
+		{"system.timezone", func(v string) []string { return []string{"timedatectl", "set-timezone", v} }},
+		{"system.locale", func(v string) []string { return []string{"localectl", "set-locale", "LANG=" + v} }},
+	} {
+		if !strutil.ListContains(changes, "core."+setting.key) {
+			continue
+		}
+		value, err := coreCfg(tr, setting.key)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			// the last applied setting is kept
+			continue
+		}
+		args := setting.args(value)
+		if _, err := osutil.RunHelper(&osutil.HelperCommand{Name: args[0], Args: args[1:]}); err != nil {
+			return fmt.Errorf("cannot set %s: %v", setting.key, err)
+		}
+	}
+	return nil
+}
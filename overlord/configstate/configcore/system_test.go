@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type systemSettingsSuite struct {
+	configcoreSuite
+
+	restores        []func()
+	mockHostnamectl *testutil.MockCmd
+	mockTimedatectl *testutil.MockCmd
+	mockLocalectl   *testutil.MockCmd
+}
+
+var _ = Suite(&systemSettingsSuite{})
+
+func (s *systemSettingsSuite) SetUpTest(c *C) {
+	s.configcoreSuite.SetUpTest(c)
+	s.restores = append(s.restores, release.MockOnClassic(false))
+
+	s.mockHostnamectl = testutil.MockCommand(c, "hostnamectl", "")
+	s.mockTimedatectl = testutil.MockCommand(c, "timedatectl", "")
+	s.mockLocalectl = testutil.MockCommand(c, "localectl", "")
+	s.restores = append(s.restores, s.mockHostnamectl.Restore, s.mockTimedatectl.Restore, s.mockLocalectl.Restore)
+
+	zoneinfo := filepath.Join(dirs.GlobalRootDir, "/usr/share/zoneinfo/Europe")
+	c.Assert(os.MkdirAll(zoneinfo, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(zoneinfo, "London"), nil, 0644), IsNil)
+}
+
+func (s *systemSettingsSuite) TearDownTest(c *C) {
+	for _, f := range s.restores {
+		f()
+	}
+	s.restores = nil
+	s.configcoreSuite.TearDownTest(c)
+}
+
+func (s *systemSettingsSuite) TestConfigureSystemSettings(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.hostname": "my-device.example.com",
+			"system.timezone": "Europe/London",
+			"system.locale":   "en_GB.UTF-8",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.mockHostnamectl.Calls(), DeepEquals, [][]string{
+		{"hostnamectl", "set-hostname", "my-device.example.com"},
+	})
+	c.Check(s.mockTimedatectl.Calls(), DeepEquals, [][]string{
+		{"timedatectl", "set-timezone", "Europe/London"},
+	})
+	c.Check(s.mockLocalectl.Calls(), DeepEquals, [][]string{
+		{"localectl", "set-locale", "LANG=en_GB.UTF-8"},
+	})
+}
+
+func (s *systemSettingsSuite) TestConfigureSystemSettingsUnchanged(c *C) {
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"system.hostname": "my-device",
+			"system.timezone": "Europe/London",
+			"system.locale":   "C.UTF-8",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(s.mockHostnamectl.Calls(), HasLen, 0)
+	c.Check(s.mockTimedatectl.Calls(), HasLen, 0)
+	c.Check(s.mockLocalectl.Calls(), HasLen, 0)
+}
+
+func (s *systemSettingsSuite) TestConfigureSystemSettingsClassic(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.hostname": "my-device",
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(s.mockHostnamectl.Calls(), HasLen, 0)
+}
+
+func (s *systemSettingsSuite) TestConfigureSystemSettingsInvalid(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"system.hostname", "-foo", `cannot set hostname "-foo": invalid hostname`},
+		{"system.hostname", "foo..bar", `cannot set hostname "foo..bar": invalid hostname`},
+		{"system.hostname", "foo_bar", `cannot set hostname "foo_bar": invalid hostname`},
+		{"system.hostname", "a.b.c.d.e.f.g.h.i.j.k.l.m.n.o.p.q.r.s.t.u.v.w.x.y.z.a.b.c.d.e.f.g", `cannot set hostname ".*": invalid hostname`},
+		{"system.timezone", "../../etc/passwd", `cannot set timezone "../../etc/passwd": invalid timezone`},
+		{"system.timezone", "Europe/Narnia", `cannot set timezone "Europe/Narnia": unknown timezone`},
+		{"system.locale", "en_GB.UTF-8; rm -rf /", `cannot set locale "en_GB.UTF-8; rm -rf /": invalid locale`},
+		{"system.locale", "english", `cannot set locale "english": invalid locale`},
+		{"system.recovery-systems.retain", "0", `recovery-systems.retain must be a number between 1 and 10, not "0"`},
+		{"system.recovery-systems.retain", "11", `recovery-systems.retain must be a number between 1 and 10, not "11"`},
+		{"system.recovery-systems.retain", "all", `recovery-systems.retain must be a number between 1 and 10, not "all"`},
+	} {
+		err := configcore.Run(&mockConf{
+			state: s.state,
+			changes: map[string]interface{}{
+				t.key: t.value,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf("%s=%q", t.key, t.value))
+	}
+	c.Check(s.mockHostnamectl.Calls(), HasLen, 0)
+	c.Check(s.mockTimedatectl.Calls(), HasLen, 0)
+	c.Check(s.mockLocalectl.Calls(), HasLen, 0)
+}
+
+func (s *systemSettingsSuite) TestConfigureSystemSettingsError(c *C) {
+	mockHostnamectl := testutil.MockCommand(c, "hostnamectl", "echo 'Could not set property: Access denied'; exit 1")
+	defer mockHostnamectl.Restore()
+
+	err := configcore.Run(&mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"system.hostname": "my-device",
+		},
+	})
+	c.Assert(err, ErrorMatches, "cannot set system.hostname: Could not set property: Access denied")
+}
@@ -87,12 +87,20 @@ func canConfigure(st *state.State, snapName string) error {
 
 // ConfigureInstalled returns a taskset to apply the given
 // configuration patch for an installed snap. It returns
-// snap.NotInstalledError if the snap is not installed.
+// snap.NotInstalledError if the snap is not installed, and
+// InvalidConfigError if the patched configuration does not match the
+// configuration schema of the snap.
 func ConfigureInstalled(st *state.State, snapName string, patch map[string]interface{}, flags int) (*state.TaskSet, error) {
 	if err := canConfigure(st, snapName); err != nil {
 		return nil, err
 	}
 
+	if snapName != "core" {
+		if err := validateConfig(st, snapName, patch); err != nil {
+			return nil, err
+		}
+	}
+
 	taskset := Configure(st, snapName, patch, flags)
 	return taskset, nil
 }
@@ -113,11 +121,15 @@ func Configure(st *state.State, snapName string, patch map[string]interface{}, f
 	var contextData map[string]interface{}
 	if flags&snapstate.UseConfigDefaults != 0 {
 		contextData = map[string]interface{}{"use-defaults": true}
+	} else if flags&snapstate.UseGadgetVolumeDefaults != 0 {
+		contextData = map[string]interface{}{"use-volume-defaults": true}
 	} else if len(patch) > 0 {
 		contextData = map[string]interface{}{"patch": patch}
 	}
 
-	if hooksup.Optional {
+	if flags&snapstate.UseGadgetVolumeDefaults != 0 {
+		summary = i18n.G("Apply system configuration defaults from the gadget")
+	} else if hooksup.Optional {
 		summary = fmt.Sprintf(i18n.G("Run configure hook of %q snap if present"), snapName)
 	}
 
@@ -144,6 +144,35 @@ func (s *tasksetsSuite) TestConfigureInstalled(c *C) {
 	}
 }
 
+func (s *tasksetsSuite) TestConfigureUseGadgetVolumeDefaults(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	taskset := configstate.Configure(s.state, "core", nil, snapstate.UseGadgetVolumeDefaults)
+	tasks := taskset.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	task := tasks[0]
+	c.Check(task.Kind(), Equals, "run-hook")
+	c.Check(task.Summary(), Equals, "Apply system configuration defaults from the gadget")
+
+	var hooksup hookstate.HookSetup
+	c.Assert(task.Get("hook-setup", &hooksup), IsNil)
+	c.Check(hooksup.Snap, Equals, "core")
+	c.Check(hooksup.Optional, Equals, true)
+
+	context, err := hookstate.NewContext(task, task.State(), &hooksup, nil, "")
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+	defer s.state.Lock()
+
+	var useVolumeDefaults bool
+	context.Lock()
+	defer context.Unlock()
+	c.Check(context.Get("use-volume-defaults", &useVolumeDefaults), IsNil)
+	c.Check(useVolumeDefaults, Equals, true)
+	c.Check(context.Get("use-defaults", &useVolumeDefaults), Equals, state.ErrNoState)
+}
+
 func (s *tasksetsSuite) TestConfigureInstalledConflict(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
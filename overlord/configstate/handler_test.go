@@ -30,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/asserts/assertstest"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -198,6 +199,14 @@ hooks:
 	var fl float64
 	c.Check(tr.Get("test-snap", "num", &fl), IsNil)
 	c.Check(fl, Equals, 1.305)
+
+	// the values are recorded as coming from the gadget
+	s.state.Lock()
+	defer s.state.Unlock()
+	tr.Commit()
+	origin, err := config.Origin(s.state, "test-snap", "bar")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "gadget")
 }
 
 func (s *configureHandlerSuite) TestBeforeUseDefaultsMissingHook(c *C) {
@@ -256,3 +265,91 @@ volumes:
 	err = s.handler.Before()
 	c.Check(err, ErrorMatches, `cannot apply gadget config defaults for snap "test-snap", no configure hook`)
 }
+
+func (s *configureHandlerSuite) TestBeforeUseVolumeDefaults(c *C) {
+	r := release.MockOnClassic(false)
+	defer r()
+
+	const mockGadgetSnapYaml = `
+name: canonical-pc
+type: gadget
+`
+	var mockGadgetYaml = []byte(`
+volumes:
+    volume-id:
+        bootloader: grub
+        defaults:
+            refresh.timer: fri
+            service.ssh.disable: true
+            watchdog.runtime-timeout: 10m
+`)
+
+	info := snaptest.MockSnap(c, mockGadgetSnapYaml, &snap.SideInfo{Revision: snap.R(1)})
+	err := ioutil.WriteFile(filepath.Join(info.MountDir(), "meta", "gadget.yaml"), mockGadgetYaml, 0644)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	snapstate.Set(s.state, "canonical-pc", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "canonical-pc", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "gadget",
+	})
+
+	r = snapstatetest.MockDeviceModel(makeModel(map[string]interface{}{
+		"gadget": "canonical-pc",
+	}))
+	defer r()
+
+	// refresh.timer was set explicitly, the watchdog timeout by an
+	// older revision of the gadget
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "refresh.timer", "mon"), IsNil)
+	c.Assert(tr.Set("core", "watchdog.runtime-timeout", "5m"), IsNil)
+	c.Assert(tr.SetOrigin("core", "watchdog.runtime-timeout", "gadget"), IsNil)
+	tr.Commit()
+
+	task := s.state.NewTask("test-task", "my test task")
+	setup := &hookstate.HookSetup{Snap: "core", Revision: snap.R(1), Hook: "configure"}
+	context, err := hookstate.NewContext(task, task.State(), setup, hooktest.NewMockHandler(), "")
+	c.Assert(err, IsNil)
+	s.state.Unlock()
+
+	context.Lock()
+	context.Set("use-volume-defaults", true)
+	context.Unlock()
+
+	handler := configstate.NewConfigureHandler(context)
+	c.Assert(handler.Before(), IsNil)
+
+	context.Lock()
+	tr = configstate.ContextTransaction(context)
+	tr.Commit()
+	context.Unlock()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr = config.NewTransaction(s.state)
+	var timer, timeout string
+	var sshDisabled bool
+	c.Check(tr.Get("core", "refresh.timer", &timer), IsNil)
+	c.Check(timer, Equals, "mon")
+	c.Check(tr.Get("core", "watchdog.runtime-timeout", &timeout), IsNil)
+	c.Check(timeout, Equals, "10m")
+	c.Check(tr.Get("core", "service.ssh.disable", &sshDisabled), IsNil)
+	c.Check(sshDisabled, Equals, true)
+
+	origin, err := config.Origin(s.state, "core", "service.ssh.disable")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "gadget")
+	origin, err = config.Origin(s.state, "core", "refresh.timer")
+	c.Assert(err, IsNil)
+	c.Check(origin, Equals, "")
+
+	c.Check(task.Log(), HasLen, 2)
+	c.Check(task.Log()[0], Matches, `.* Applied gadget defaults for system configuration: service.ssh.disable, watchdog.runtime-timeout`)
+	c.Check(task.Log()[1], Matches, `.* Kept explicitly set system configuration: refresh.timer`)
+}
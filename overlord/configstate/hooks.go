@@ -21,6 +21,8 @@ package configstate
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
@@ -77,6 +79,14 @@ func (h *configureHandler) Before() error {
 	// Initialize the transaction if there's a patch provided in the
 	// context or useDefaults is set in which case gadget defaults are used.
 
+	var useVolumeDefaults bool
+	if err := h.context.Get("use-volume-defaults", &useVolumeDefaults); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if useVolumeDefaults {
+		return h.applyVolumeDefaults(tr)
+	}
+
 	var patch map[string]interface{}
 	var useDefaults bool
 	if err := h.context.Get("use-defaults", &useDefaults); err != nil && err != state.ErrNoState {
@@ -116,8 +126,77 @@ func (h *configureHandler) Before() error {
 		if err := tr.Set(instanceName, key, value); err != nil {
 			return err
 		}
+		if useDefaults {
+			// record that the value comes from the gadget
+			if err := tr.SetOrigin(instanceName, key, "gadget"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyVolumeDefaults sets the system configuration defaults declared by
+// the volumes of the gadget. Values that were set explicitly, rather than
+// coming from the gadget, are left alone.
+func (h *configureHandler) applyVolumeDefaults(tr *config.Transaction) error {
+	st := h.context.State()
+	task, _ := h.context.Task()
+	deviceCtx, err := snapstate.DeviceCtx(st, task, nil)
+	if err != nil {
+		return err
+	}
+
+	defaults, err := snapstate.GadgetVolumeDefaults(st, deviceCtx)
+	if err == state.ErrNoState {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	instanceName := h.context.InstanceName()
+	var applied, kept []string
+	for _, key := range keys {
+		var current interface{}
+		err := tr.Get(instanceName, key, &current)
+		if err != nil && !config.IsNoOption(err) {
+			return err
+		}
+		if err == nil {
+			origin, err := config.Origin(st, instanceName, key)
+			if err != nil {
+				return err
+			}
+			if origin != "gadget" {
+				kept = append(kept, key)
+				continue
+			}
+		}
+		if err := tr.Set(instanceName, key, defaults[key]); err != nil {
+			return err
+		}
+		if err := tr.SetOrigin(instanceName, key, "gadget"); err != nil {
+			return err
+		}
+		applied = append(applied, key)
 	}
 
+	if task != nil {
+		if len(applied) > 0 {
+			task.Logf("Applied gadget defaults for system configuration: %s", strings.Join(applied, ", "))
+		}
+		if len(kept) > 0 {
+			task.Logf("Kept explicitly set system configuration: %s", strings.Join(kept, ", "))
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,182 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/snapcore/snapd/jsonutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// Kinds of ConfigChange.
+const (
+	ConfigAdded   = "added"
+	ConfigRemoved = "removed"
+	ConfigChanged = "changed"
+)
+
+// A ConfigChange describes how a configuration option of a snap
+// changes when importing configuration.
+type ConfigChange struct {
+	Snap string      `json:"snap"`
+	Key  string      `json:"key"`
+	Kind string      `json:"kind"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ExportConfig returns the full configuration documents of the given
+// snaps, keyed by snap name. Snaps without configuration get an empty
+// document. If no snaps are given, the documents of all the snaps
+// with some configuration are returned.
+func ExportConfig(st *state.State, snapNames []string) (map[string]map[string]interface{}, error) {
+	if len(snapNames) == 0 {
+		var cfg map[string]*json.RawMessage
+		if err := st.Get("config", &cfg); err != nil && err != state.ErrNoState {
+			return nil, err
+		}
+		for snapName := range cfg {
+			snapNames = append(snapNames, snapName)
+		}
+	}
+
+	docs := make(map[string]map[string]interface{}, len(snapNames))
+	for _, snapName := range snapNames {
+		raw, err := config.GetSnapConfig(st, snapName)
+		if err != nil {
+			return nil, err
+		}
+		doc := make(map[string]interface{})
+		if raw != nil {
+			if err := jsonutil.DecodeWithNumber(bytes.NewReader(*raw), &doc); err != nil {
+				return nil, fmt.Errorf("internal error: cannot decode configuration of snap %q: %v", snapName, err)
+			}
+		}
+		docs[snapName] = doc
+	}
+	return docs, nil
+}
+
+// DiffConfig returns the changes to the options of the snap going from
+// the old to the new configuration document, sorted by key. Nested
+// documents are compared option by option.
+func DiffConfig(snapName string, old, new map[string]interface{}) []ConfigChange {
+	changes := diffConfig(snapName, "", old, new)
+	sort.Sort(byConfigChangeKey(changes))
+	return changes
+}
+
+type byConfigChangeKey []ConfigChange
+
+func (s byConfigChangeKey) Len() int           { return len(s) }
+func (s byConfigChangeKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byConfigChangeKey) Less(i, j int) bool { return s[i].Key < s[j].Key }
+
+func diffConfig(snapName, prefix string, old, new map[string]interface{}) []ConfigChange {
+	var changes []ConfigChange
+	for k, newValue := range new {
+		key := prefix + k
+		oldValue, ok := old[k]
+		if !ok {
+			changes = append(changes, ConfigChange{Snap: snapName, Key: key, Kind: ConfigAdded, New: newValue})
+			continue
+		}
+		oldDoc, oldIsDoc := oldValue.(map[string]interface{})
+		newDoc, newIsDoc := newValue.(map[string]interface{})
+		if oldIsDoc && newIsDoc {
+			changes = append(changes, diffConfig(snapName, key+".", oldDoc, newDoc)...)
+			continue
+		}
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, ConfigChange{Snap: snapName, Key: key, Kind: ConfigChanged, Old: oldValue, New: newValue})
+		}
+	}
+	for k, oldValue := range old {
+		if _, ok := new[k]; !ok {
+			changes = append(changes, ConfigChange{Snap: snapName, Key: prefix + k, Kind: ConfigRemoved, Old: oldValue})
+		}
+	}
+	return changes
+}
+
+// importPatch returns the patch replacing the current configuration
+// of a snap with the given document.
+func importPatch(current, doc map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for k, v := range doc {
+		if !reflect.DeepEqual(current[k], v) {
+			patch[k] = v
+		}
+	}
+	for k := range current {
+		if _, ok := doc[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// ImportConfig returns the task sets to replace the configuration of
+// the snaps with the given documents, keyed by snap name, together
+// with the changes to their options this implies. Snaps whose
+// configuration would not change get no task set. If dryRun is set
+// only the changes are returned, after checking that the snaps can be
+// configured as requested.
+func ImportConfig(st *state.State, docs map[string]map[string]interface{}, dryRun bool) ([]*state.TaskSet, []ConfigChange, error) {
+	snapNames := make([]string, 0, len(docs))
+	for snapName := range docs {
+		snapNames = append(snapNames, snapName)
+	}
+	sort.Strings(snapNames)
+
+	current, err := ExportConfig(st, snapNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tss []*state.TaskSet
+	var changes []ConfigChange
+	for _, snapName := range snapNames {
+		patch := importPatch(current[snapName], docs[snapName])
+		if len(patch) == 0 {
+			continue
+		}
+		if err := canConfigure(st, snapName); err != nil {
+			return nil, nil, err
+		}
+		if snapName != "core" {
+			if err := validateConfig(st, snapName, patch); err != nil {
+				return nil, nil, err
+			}
+		}
+		changes = append(changes, DiffConfig(snapName, current[snapName], docs[snapName])...)
+		if dryRun {
+			continue
+		}
+		tss = append(tss, Configure(st, snapName, patch, 0))
+	}
+	return tss, changes, nil
+}
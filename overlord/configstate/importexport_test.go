@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configstate_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type importExportSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&importExportSuite{})
+
+func (s *importExportSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	s.state = state.New(nil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	for _, name := range []string{"foo", "bar"} {
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			Sequence: []*snap.SideInfo{
+				{RealName: name, Revision: snap.R(1)},
+			},
+			Current:  snap.R(1),
+			Active:   true,
+			SnapType: "app",
+		})
+	}
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("foo", "port", 80), IsNil)
+	c.Assert(tr.Set("foo", "server.host", "example.com"), IsNil)
+	c.Assert(tr.Set("foo", "server.debug", true), IsNil)
+	tr.Commit()
+}
+
+func (s *importExportSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("/")
+}
+
+var fooConfig = map[string]interface{}{
+	"port": json.Number("80"),
+	"server": map[string]interface{}{
+		"host":  "example.com",
+		"debug": true,
+	},
+}
+
+func (s *importExportSuite) TestExportConfig(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	docs, err := configstate.ExportConfig(s.state, []string{"foo", "bar"})
+	c.Assert(err, IsNil)
+	c.Check(docs, DeepEquals, map[string]map[string]interface{}{
+		"foo": fooConfig,
+		"bar": {},
+	})
+
+	// only snaps with configuration by default
+	docs, err = configstate.ExportConfig(s.state, nil)
+	c.Assert(err, IsNil)
+	c.Check(docs, DeepEquals, map[string]map[string]interface{}{
+		"foo": fooConfig,
+	})
+}
+
+func (s *importExportSuite) TestDiffConfig(c *C) {
+	changes := configstate.DiffConfig("foo", fooConfig, map[string]interface{}{
+		"port": json.Number("8080"),
+		"server": map[string]interface{}{
+			"host": "example.com",
+			"user": "frank",
+		},
+		"mode": "fast",
+	})
+	c.Check(changes, DeepEquals, []configstate.ConfigChange{
+		{Snap: "foo", Key: "mode", Kind: configstate.ConfigAdded, New: "fast"},
+		{Snap: "foo", Key: "port", Kind: configstate.ConfigChanged, Old: json.Number("80"), New: json.Number("8080")},
+		{Snap: "foo", Key: "server.debug", Kind: configstate.ConfigRemoved, Old: true},
+		{Snap: "foo", Key: "server.user", Kind: configstate.ConfigAdded, New: "frank"},
+	})
+
+	c.Check(configstate.DiffConfig("foo", fooConfig, fooConfig), HasLen, 0)
+}
+
+func (s *importExportSuite) TestImportConfig(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	docs := map[string]map[string]interface{}{
+		// unchanged
+		"foo": fooConfig,
+		"bar": {"name": "frank"},
+	}
+	tss, changes, err := configstate.ImportConfig(s.state, docs, false)
+	c.Assert(err, IsNil)
+	c.Check(changes, DeepEquals, []configstate.ConfigChange{
+		{Snap: "bar", Key: "name", Kind: configstate.ConfigAdded, New: "frank"},
+	})
+	c.Assert(tss, HasLen, 1)
+	task := tss[0].Tasks()[0]
+	var hooksup hookstate.HookSetup
+	c.Assert(task.Get("hook-setup", &hooksup), IsNil)
+	c.Check(hooksup.Snap, Equals, "bar")
+	var context map[string]interface{}
+	c.Assert(task.Get("hook-context", &context), IsNil)
+	c.Check(context["patch"], DeepEquals, map[string]interface{}{"name": "frank"})
+}
+
+func (s *importExportSuite) TestImportConfigReplaces(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	docs := map[string]map[string]interface{}{
+		"foo": {"port": json.Number("80")},
+	}
+	tss, changes, err := configstate.ImportConfig(s.state, docs, false)
+	c.Assert(err, IsNil)
+	c.Check(changes, DeepEquals, []configstate.ConfigChange{
+		{Snap: "foo", Key: "server", Kind: configstate.ConfigRemoved, Old: fooConfig["server"]},
+	})
+	c.Assert(tss, HasLen, 1)
+	var context map[string]interface{}
+	c.Assert(tss[0].Tasks()[0].Get("hook-context", &context), IsNil)
+	c.Check(context["patch"], DeepEquals, map[string]interface{}{"server": nil})
+}
+
+func (s *importExportSuite) TestImportConfigDryRun(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	docs := map[string]map[string]interface{}{
+		"bar": {"name": "frank"},
+	}
+	tss, changes, err := configstate.ImportConfig(s.state, docs, true)
+	c.Assert(err, IsNil)
+	c.Check(tss, HasLen, 0)
+	c.Check(changes, HasLen, 1)
+	c.Check(s.state.Tasks(), HasLen, 0)
+}
+
+func (s *importExportSuite) TestImportConfigNotInstalled(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	docs := map[string]map[string]interface{}{
+		"baz": {"name": "frank"},
+	}
+	_, _, err := configstate.ImportConfig(s.state, docs, true)
+	c.Check(err, ErrorMatches, `snap "baz" is not installed`)
+}
@@ -23,17 +23,26 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
 
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/state"
 )
 
 type ProxySettings struct {
 	st *state.State
+	// system is used when no proxy is set explicitly for the scheme,
+	// it follows the system-wide settings including proxy auto-config
+	system *httputil.SystemProxy
 }
 
 func New(st *state.State) *ProxySettings {
-	return &ProxySettings{st: st}
+	return &ProxySettings{
+		st:     st,
+		system: httputil.NewSystemProxy(filepath.Join(dirs.GlobalRootDir, "/etc/environment"), httputil.NetplanDirs(dirs.GlobalRootDir)...),
+	}
 }
 
 func (p *ProxySettings) Conf(req *http.Request) (*url.URL, error) {
@@ -41,10 +50,16 @@ func (p *ProxySettings) Conf(req *http.Request) (*url.URL, error) {
 	tr := config.NewTransaction(p.st)
 	p.st.Unlock()
 
+	var pacURL string
+	if err := tr.Get("core", "proxy.pac-url", &pacURL); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+	p.system.SetAutoConfigURL(pacURL)
+
 	var proxy string
 	err := tr.Get("core", fmt.Sprintf("proxy.%s", req.URL.Scheme), &proxy)
 	if proxy == "" || config.IsNoOption(err) {
-		return http.ProxyFromEnvironment(req)
+		return p.system.Proxy(req)
 	}
 	if err != nil {
 		return nil, err
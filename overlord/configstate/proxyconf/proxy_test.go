@@ -20,12 +20,16 @@
 package proxyconf_test
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/configstate/proxyconf"
 	"github.com/snapcore/snapd/overlord/state"
@@ -37,6 +41,14 @@ type proxyconfSuite struct{}
 
 var _ = Suite(&proxyconfSuite{})
 
+func (s *proxyconfSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+}
+
+func (s *proxyconfSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("/")
+}
+
 func (s *proxyconfSuite) TestProxySettingsNoSetting(c *C) {
 	st := state.New(nil)
 
@@ -72,3 +84,27 @@ func (s *proxyconfSuite) TestProxySettings(c *C) {
 		Host:   "some-proxy:3128",
 	})
 }
+
+func (s *proxyconfSuite) TestProxySettingsFromSystem(c *C) {
+	st := state.New(nil)
+
+	req, err := http.NewRequest("GET", "https://example.com", nil)
+	c.Assert(err, IsNil)
+
+	proxyConf := proxyconf.New(st)
+	proxy, err := proxyConf.Conf(req)
+	c.Assert(err, IsNil)
+	c.Check(proxy, IsNil)
+
+	// system-wide settings are picked up without recreating it
+	envFile := filepath.Join(dirs.GlobalRootDir, "/etc/environment")
+	c.Assert(os.MkdirAll(filepath.Dir(envFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(envFile, []byte("https_proxy=http://system-proxy:3128\n"), 0644), IsNil)
+
+	proxy, err = proxyConf.Conf(req)
+	c.Assert(err, IsNil)
+	c.Check(proxy, DeepEquals, &url.URL{
+		Scheme: "http",
+		Host:   "system-proxy:3128",
+	})
+}
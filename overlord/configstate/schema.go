@@ -0,0 +1,307 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/snapcore/snapd/jsonutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// ConfigSchema is the subset of JSON Schema that snaps can use in
+// meta/config-schema.json to describe their configuration.
+type ConfigSchema struct {
+	Type                 string                   `json:"type,omitempty"`
+	Properties           map[string]*ConfigSchema `json:"properties,omitempty"`
+	Required             []string                 `json:"required,omitempty"`
+	AdditionalProperties *bool                    `json:"additionalProperties,omitempty"`
+	Items                *ConfigSchema            `json:"items,omitempty"`
+	Enum                 []interface{}            `json:"enum,omitempty"`
+	Minimum              *float64                 `json:"minimum,omitempty"`
+	Maximum              *float64                 `json:"maximum,omitempty"`
+	MinLength            *int                     `json:"minLength,omitempty"`
+	MaxLength            *int                     `json:"maxLength,omitempty"`
+	Pattern              string                   `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var validSchemaTypes = map[string]bool{
+	"":        true,
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"integer": true,
+	"number":  true,
+	"boolean": true,
+	"null":    true,
+}
+
+// ParseConfigSchema parses and checks the given configuration schema.
+func ParseConfigSchema(data []byte) (*ConfigSchema, error) {
+	var schema ConfigSchema
+	if err := jsonutil.DecodeWithNumber(bytes.NewReader(data), &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.check(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *ConfigSchema) check() error {
+	if !validSchemaTypes[s.Type] {
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for name, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("property %q has no schema", name)
+		}
+		if err := prop.check(); err != nil {
+			return fmt.Errorf("property %q: %v", name, err)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.check(); err != nil {
+			return fmt.Errorf("items: %v", err)
+		}
+	}
+	return nil
+}
+
+// A FieldError describes why the value of a configuration field does
+// not match the configuration schema of the snap.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// InvalidConfigError is returned when the configuration of a snap does
+// not match its configuration schema.
+type InvalidConfigError struct {
+	Snap   string
+	Errors []FieldError
+}
+
+func (e *InvalidConfigError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fmt.Sprintf("%q %s", fe.Field, fe.Message)
+	}
+	return fmt.Sprintf("invalid configuration for snap %q: %s", e.Snap, strings.Join(msgs, "; "))
+}
+
+// Validate checks the given configuration document against the schema,
+// returning the problems found, if any.
+func (s *ConfigSchema) Validate(value interface{}) []FieldError {
+	var errs []FieldError
+	s.validate("", value, &errs)
+	return errs
+}
+
+func joinField(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func (s *ConfigSchema) validate(field string, value interface{}, errs *[]FieldError) {
+	fail := func(format string, v ...interface{}) {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, v...)})
+	}
+
+	if !s.hasType(value) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of the allowed values")
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Field: joinField(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, FieldError{Field: joinField(field, name), Message: "is not allowed"})
+				}
+				continue
+			}
+			prop.validate(joinField(field, name), v[name], errs)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", field, i), item, errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %q", s.Pattern)
+		}
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	}
+}
+
+func (s *ConfigSchema) hasType(value interface{}) bool {
+	switch s.Type {
+	case "":
+		return true
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+// configSchemaFile returns the path of the configuration schema shipped
+// by the given revision of the snap.
+func configSchemaFile(instanceName string, rev snap.Revision) string {
+	return filepath.Join(snap.MountDir(instanceName, rev), "meta", "config-schema.json")
+}
+
+// readConfigSchema reads the configuration schema of the current
+// revision of the snap, it returns nil if the snap does not ship one.
+func readConfigSchema(st *state.State, instanceName string) (*ConfigSchema, error) {
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, instanceName, &snapst); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(configSchemaFile(instanceName, snapst.Current))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	schema, err := ParseConfigSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use configuration schema of snap %q: %v", instanceName, err)
+	}
+	return schema, nil
+}
+
+// validateConfig checks the configuration of the snap, as it would be
+// with the patch applied, against the configuration schema of the snap,
+// if it ships one.
+func validateConfig(st *state.State, instanceName string, patch map[string]interface{}) error {
+	schema, err := readConfigSchema(st, instanceName)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	tr := config.NewTransaction(st)
+	for key, value := range patch {
+		if err := tr.Set(instanceName, key, value); err != nil {
+			return err
+		}
+	}
+	var cfg interface{} = map[string]interface{}{}
+	if err := tr.Get(instanceName, "", &cfg); err != nil && !config.IsNoOption(err) {
+		return err
+	}
+
+	if errs := schema.Validate(cfg); len(errs) > 0 {
+		return &InvalidConfigError{Snap: instanceName, Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configstate_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type schemaSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&schemaSuite{})
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+		"mode": {"enum": ["fast", "slow"]},
+		"debug": {"type": "boolean"},
+		"servers": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {"host": {"type": "string"}},
+				"required": ["host"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["port"]
+}`
+
+func (s *schemaSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	s.state = state.New(nil)
+}
+
+func (s *schemaSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("/")
+}
+
+func (s *schemaSuite) TestParseConfigSchemaErrors(c *C) {
+	for _, t := range []struct {
+		schema, err string
+	}{
+		{`{"type": "foo"}`, `unsupported type "foo"`},
+		{`{"properties": {"a": {"pattern": "["}}}`, `property "a": invalid pattern "\[": .*`},
+		{`{"items": {"type": "bar"}}`, `items: unsupported type "bar"`},
+		{`{"properties": {"a": null}}`, `property "a" has no schema`},
+		{`[]`, `json: cannot unmarshal array .*`},
+	} {
+		_, err := configstate.ParseConfigSchema([]byte(t.schema))
+		c.Check(err, ErrorMatches, t.err, Commentf(t.schema))
+	}
+}
+
+func decodeConfig(c *C, cfg string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(cfg))
+	dec.UseNumber()
+	var v interface{}
+	c.Assert(dec.Decode(&v), IsNil)
+	return v
+}
+
+func (s *schemaSuite) TestValidate(c *C) {
+	schema, err := configstate.ParseConfigSchema([]byte(testSchema))
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		cfg  string
+		errs []configstate.FieldError
+	}{
+		{`{"port": 80}`, nil},
+		{`{"port": 80, "name": "foo", "mode": "fast", "debug": true, "servers": [{"host": "a"}], "other": 1}`, nil},
+		{`{}`, []configstate.FieldError{{Field: "port", Message: "is required"}}},
+		{`{"port": "80"}`, []configstate.FieldError{{Field: "port", Message: "must be of type integer"}}},
+		{`{"port": 8.5}`, []configstate.FieldError{{Field: "port", Message: "must be of type integer"}}},
+		{`{"port": 0}`, []configstate.FieldError{{Field: "port", Message: "must be at least 1"}}},
+		{`{"port": 65536}`, []configstate.FieldError{{Field: "port", Message: "must be at most 65535"}}},
+		{`{"port": 80, "name": ""}`, []configstate.FieldError{{Field: "name", Message: "must be at least 1 characters long"}, {Field: "name", Message: `must match "^[a-z]+$"`}}},
+		{`{"port": 80, "name": "abcdefghi"}`, []configstate.FieldError{{Field: "name", Message: "must be at most 8 characters long"}}},
+		{`{"port": 80, "name": "FOO"}`, []configstate.FieldError{{Field: "name", Message: `must match "^[a-z]+$"`}}},
+		{`{"port": 80, "mode": "medium"}`, []configstate.FieldError{{Field: "mode", Message: "must be one of the allowed values"}}},
+		{`{"port": 80, "debug": "yes"}`, []configstate.FieldError{{Field: "debug", Message: "must be of type boolean"}}},
+		{`{"port": 80, "servers": [{"host": "a"}, {"port": 1}]}`, []configstate.FieldError{{Field: "servers[1].host", Message: "is required"}, {Field: "servers[1].port", Message: "is not allowed"}}},
+	} {
+		c.Check(schema.Validate(decodeConfig(c, t.cfg)), DeepEquals, t.errs, Commentf(t.cfg))
+	}
+}
+
+func (s *schemaSuite) mockSnapWithSchema(c *C, schema string) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapstate.Set(s.state, "test-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "test-snap", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		Active:   true,
+		SnapType: "app",
+	})
+	if schema != "" {
+		metaDir := filepath.Join(snap.MountDir("test-snap", snap.R(1)), "meta")
+		c.Assert(os.MkdirAll(metaDir, 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(metaDir, "config-schema.json"), []byte(schema), 0644), IsNil)
+	}
+}
+
+func (s *schemaSuite) TestConfigureInstalledValidatesAgainstSchema(c *C) {
+	s.mockSnapWithSchema(c, testSchema)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the current configuration is taken into account
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("test-snap", "port", 80), IsNil)
+	tr.Commit()
+
+	ts, err := configstate.ConfigureInstalled(s.state, "test-snap", map[string]interface{}{"name": "foo"}, 0)
+	c.Assert(err, IsNil)
+	c.Check(ts.Tasks(), HasLen, 1)
+
+	_, err = configstate.ConfigureInstalled(s.state, "test-snap", map[string]interface{}{
+		"name": "FOO",
+		"port": nil,
+	}, 0)
+	c.Assert(err, FitsTypeOf, &configstate.InvalidConfigError{})
+	c.Check(err.(*configstate.InvalidConfigError).Errors, DeepEquals, []configstate.FieldError{
+		{Field: "port", Message: "is required"},
+		{Field: "name", Message: `must match "^[a-z]+$"`},
+	})
+	c.Check(err, ErrorMatches, `invalid configuration for snap "test-snap": "port" is required; "name" must match "\^\[a-z\]\+\$"`)
+
+	// nested keys are validated too
+	_, err = configstate.ConfigureInstalled(s.state, "test-snap", map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{"host": 1}},
+	}, 0)
+	c.Check(err, ErrorMatches, `invalid configuration for snap "test-snap": "servers\[0\].host" must be of type string`)
+}
+
+func (s *schemaSuite) TestConfigureInstalledNoSchema(c *C) {
+	s.mockSnapWithSchema(c, "")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := configstate.ConfigureInstalled(s.state, "test-snap", map[string]interface{}{"port": "anything"}, 0)
+	c.Check(err, IsNil)
+}
+
+func (s *schemaSuite) TestConfigureInstalledBrokenSchema(c *C) {
+	s.mockSnapWithSchema(c, `{"type": "foo"}`)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := configstate.ConfigureInstalled(s.state, "test-snap", map[string]interface{}{"port": 80}, 0)
+	c.Check(err, ErrorMatches, `cannot use configuration schema of snap "test-snap": unsupported type "foo"`)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
 )
 
 // ProblemReportsDisabled returns true if the problem reports are disabled
@@ -39,3 +40,31 @@ func ProblemReportsDisabled(st *state.State) bool {
 
 	return disableProblemReports
 }
+
+// DefaultHookLimits returns the resource limits hooks run with, as set
+// via the "core.hooks.limits.*" settings. Snaps can only ask for lower
+// limits for their hooks.
+//
+// The state must be locked when this is called.
+func DefaultHookLimits(st *state.State) snap.HookLimits {
+	var cpu, memory, timeout string
+
+	tr := config.NewTransaction(st)
+	for key, value := range map[string]*string{
+		"hooks.limits.cpu":     &cpu,
+		"hooks.limits.memory":  &memory,
+		"hooks.limits.timeout": &timeout,
+	} {
+		if err := tr.GetMaybe("core", key, value); err != nil {
+			logger.Noticef("cannot get default hook limits setting: %v", err)
+			return snap.HookLimits{}
+		}
+	}
+	limits, err := snap.ParseHookLimits(cpu, memory, timeout)
+	if err != nil {
+		logger.Noticef("cannot use default hook limits: %v", err)
+		return snap.HookLimits{}
+	}
+
+	return *limits
+}
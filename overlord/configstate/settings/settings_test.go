@@ -21,12 +21,14 @@ package settings_test
 
 import (
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/configstate/settings"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
 )
 
 func TestT(t *testing.T) { TestingT(t) }
@@ -58,3 +60,27 @@ func (s *settingsSuite) TestSettingProblemReportsDisable(c *C) {
 
 	c.Check(settings.ProblemReportsDisabled(s.state), Equals, true)
 }
+
+func (s *settingsSuite) TestSettingDefaultHookLimitsDefault(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(settings.DefaultHookLimits(s.state), Equals, snap.HookLimits{})
+}
+
+func (s *settingsSuite) TestSettingDefaultHookLimits(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "hooks.limits.cpu", "25%")
+	tr.Set("core", "hooks.limits.memory", "64MB")
+	tr.Set("core", "hooks.limits.timeout", "2m")
+	tr.Commit()
+
+	c.Check(settings.DefaultHookLimits(s.state), Equals, snap.HookLimits{
+		CPUQuota: 25,
+		Memory:   64 * 1000 * 1000,
+		Timeout:  2 * time.Minute,
+	})
+}
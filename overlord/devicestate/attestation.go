@@ -0,0 +1,230 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/hookstate"
+)
+
+// SerialRequestSigner produces attestations for serial-requests of
+// devices of a given brand, binding them to a hardware-rooted identity
+// held for example in a TPM or a vendor HSM.
+type SerialRequestSigner interface {
+	// AttestationType names the format of the produced attestations.
+	AttestationType() string
+	// Attest produces an attestation over the given challenge for
+	// a device of the given brand and model.
+	Attest(brandID, model string, challenge []byte) ([]byte, error)
+}
+
+var (
+	serialRequestSignersLock sync.Mutex
+	serialRequestSigners     = make(map[string]SerialRequestSigner)
+)
+
+// RegisterSerialRequestSigner registers the signer used to attest the
+// serial-requests of devices of the given brand. It returns a function
+// to unregister it.
+func RegisterSerialRequestSigner(brandID string, signer SerialRequestSigner) (unregister func()) {
+	serialRequestSignersLock.Lock()
+	defer serialRequestSignersLock.Unlock()
+	if _, ok := serialRequestSigners[brandID]; ok {
+		panic(fmt.Sprintf("internal error: serial-request signer for brand %q already registered", brandID))
+	}
+	serialRequestSigners[brandID] = signer
+	return func() {
+		serialRequestSignersLock.Lock()
+		defer serialRequestSignersLock.Unlock()
+		delete(serialRequestSigners, brandID)
+	}
+}
+
+func serialRequestSigner(brandID string) SerialRequestSigner {
+	serialRequestSignersLock.Lock()
+	defer serialRequestSignersLock.Unlock()
+	return serialRequestSigners[brandID]
+}
+
+// helperSerialRequestSigner attests serial-requests by running the
+// helper set with the registration.attestation-helper system option,
+// for example vendor tooling talking to a TPM or an HSM. The helper
+// gets the brand, the model and the file to write the attestation to
+// as arguments, and the challenge on its standard input.
+type helperSerialRequestSigner struct {
+	helper          string
+	attestationType string
+}
+
+func (h *helperSerialRequestSigner) AttestationType() string {
+	return h.attestationType
+}
+
+func (h *helperSerialRequestSigner) Attest(brandID, model string, challenge []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "snapd-attestation-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	attestationFile := filepath.Join(dir, "attestation")
+	_, err = osutil.RunHelper(&osutil.HelperCommand{
+		Name:    h.helper,
+		Args:    []string{brandID, model, attestationFile},
+		Stdin:   bytes.NewReader(challenge),
+		Timeout: attestationHelperTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := ioutil.ReadFile(attestationFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read attestation produced by %q: %v", h.helper, err)
+	}
+	if len(attestation) == 0 {
+		return nil, fmt.Errorf("helper %q produced an empty attestation", h.helper)
+	}
+	return attestation, nil
+}
+
+var attestationHelperTimeout = 2 * time.Minute
+
+// attestSerialRequest adds the attestation headers to the serial-request
+// headers if a signer is registered for the brand of the device, or
+// an attestation helper is configured.
+func attestSerialRequest(headers map[string]interface{}, device *auth.DeviceState, requestID string, deviceKey asserts.PublicKey, cfg *serialRequestConfig) error {
+	signer := serialRequestSigner(device.Brand)
+	if signer == nil && cfg.attestationHelper != "" {
+		signer = &helperSerialRequestSigner{
+			helper:          cfg.attestationHelper,
+			attestationType: cfg.attestationHelperType,
+		}
+	}
+	if signer == nil {
+		// otherwise use the attestation of the attest-device hook
+		// of the gadget, if it was produced for this request-id
+		if cfg.attestationType != "" {
+			if cfg.requestID == "" || cfg.requestID != requestID {
+				logger.Noticef("Ignoring attestation of the device not bound to the request-id of the serial-request")
+				return nil
+			}
+			headers["attestation-type"] = cfg.attestationType
+			headers["attestation"] = cfg.attestation
+		}
+		return nil
+	}
+	challenge := asserts.SerialRequestAttestationChallenge(requestID, deviceKey)
+	attestation, err := signer.Attest(device.Brand, device.Model, challenge)
+	if err != nil {
+		return fmt.Errorf("cannot attest serial request: %v", err)
+	}
+	headers["attestation-type"] = signer.AttestationType()
+	headers["attestation"] = base64.StdEncoding.EncodeToString(attestation)
+	return nil
+}
+
+// attestDeviceHandler handles the attest-device hook of the gadget.
+// The hook runs once the device key is generated and before the
+// serial is requested. It gets the challenge to attest, hex encoded,
+// from the registration.attestation-challenge option and sets the
+// registration.attestation-type and the base64 encoded
+// registration.attestation options. The challenge binds the device
+// key and the request-id obtained from the device service just before
+// the hook runs, which is then used for the serial-request.
+type attestDeviceHandler struct {
+	context *hookstate.Context
+	m       *DeviceManager
+}
+
+func (m *DeviceManager) newAttestDeviceHandler(context *hookstate.Context) hookstate.Handler {
+	return &attestDeviceHandler{context: context, m: m}
+}
+
+func (h *attestDeviceHandler) Before() error {
+	h.context.Lock()
+	defer h.context.Unlock()
+
+	privKey, err := h.m.keyPair()
+	if err != nil {
+		return fmt.Errorf("cannot find the device key to attest: %v", err)
+	}
+	task, _ := h.context.Task()
+	var requestID string
+	if err := task.Change().Get("serial-request-id", &requestID); err != nil {
+		return fmt.Errorf("cannot find the request-id to attest: %v", err)
+	}
+	challenge := asserts.SerialRequestAttestationChallenge(requestID, privKey.PublicKey())
+
+	gadget := h.context.InstanceName()
+	tr := config.NewTransaction(h.context.State())
+	if err := tr.Set(gadget, "registration.attestation-challenge", hex.EncodeToString(challenge)); err != nil {
+		return err
+	}
+	// drop the attestation from any previous attempt
+	for _, key := range []string{"registration.attestation-type", "registration.attestation"} {
+		if err := tr.Set(gadget, key, nil); err != nil {
+			return err
+		}
+	}
+	tr.Commit()
+	return nil
+}
+
+func (h *attestDeviceHandler) Done() error {
+	return nil
+}
+
+func (h *attestDeviceHandler) Error(err error) error {
+	return nil
+}
+
+// gadgetAttestation returns the attestation set by the attest-device
+// hook of the given gadget, if any.
+func gadgetAttestation(tr *config.Transaction, gadget string) (attestationType, attestation string, err error) {
+	if err := tr.GetMaybe(gadget, "registration.attestation-type", &attestationType); err != nil {
+		return "", "", err
+	}
+	if err := tr.GetMaybe(gadget, "registration.attestation", &attestation); err != nil {
+		return "", "", err
+	}
+	if (attestationType == "") != (attestation == "") {
+		return "", "", fmt.Errorf("cannot use attestation of the device: registration.attestation-type and registration.attestation must be both set")
+	}
+	if attestation != "" {
+		if _, err := base64.StdEncoding.DecodeString(attestation); err != nil {
+			return "", "", fmt.Errorf("cannot use attestation of the device: registration.attestation must be base64 encoded: %v", err)
+		}
+	}
+	return attestationType, attestation, nil
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/secboot"
+)
+
+var (
+	gadgetBootAssetsDigests = gadget.BootAssetsDigests
+	secbootResealKey        = secboot.ResealKey
+)
+
+// TrackedBootAssets returns the sha3-384 digests of the boot assets,
+// keyed by their path, as recorded when they were last written by a
+// gadget update. They can be checked with boot.CheckBootAssets to
+// detect out-of-band modifications.
+func TrackedBootAssets(st *state.State) (map[string]string, error) {
+	var digests map[string]string
+	err := st.Get("boot-assets", &digests)
+	if err == state.ErrNoState {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func setTrackedBootAssets(st *state.State, digests map[string]string) {
+	if len(digests) == 0 {
+		st.Set("boot-assets", nil)
+		return
+	}
+	st.Set("boot-assets", digests)
+}
+
+// resealDataKey binds the key of the encrypted data partition, if
+// there is one, to the model, the tracked boot assets and the kernel
+// command line set up in the bootloader, so that it can be unsealed
+// once booted with them. The key is currently bound to the boot assets
+// with the given digests and to the given kernel command line.
+func resealDataKey(st *state.State, current map[string]string, currentCmdline *gadget.KernelCmdline) error {
+	keyFile := secboot.DataKeyFile()
+	if !osutil.FileExists(keyFile) {
+		// no encrypted data partition
+		return nil
+	}
+	model, err := findModel(st)
+	if err != nil {
+		return err
+	}
+	digests, err := TrackedBootAssets(st)
+	if err != nil {
+		return err
+	}
+	cmdline, err := boot.CurrentKernelCmdline()
+	if err != nil {
+		return err
+	}
+	currentParams := &secboot.SealParams{
+		BrandID:       model.BrandID(),
+		Model:         model.Model(),
+		BootAssets:    current,
+		KernelCmdline: currentCmdline,
+	}
+	params := &secboot.SealParams{
+		BrandID:       model.BrandID(),
+		Model:         model.Model(),
+		BootAssets:    digests,
+		KernelCmdline: cmdline,
+	}
+
+	// the key protector may need to reach out to the network
+	st.Unlock()
+	defer st.Lock()
+	return secbootResealKey(keyFile, currentParams, params)
+}
+
+// signKMSRequest authenticates the device to key management services
+// with a device-session-request for the given nonce, signed by the
+// device key.
+func (m *DeviceManager) signKMSRequest(nonce string) ([]byte, error) {
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	serial, err := findSerial(st, nil)
+	if err == state.ErrNoState {
+		return nil, fmt.Errorf("cannot authenticate to the key management service without a serial")
+	}
+	if err != nil {
+		return nil, err
+	}
+	req, err := storeContextBackend{m}.SignDeviceSessionRequest(serial, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return asserts.Encode(req), nil
+}
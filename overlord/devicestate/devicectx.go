@@ -21,6 +21,7 @@ package devicestate
 
 import (
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/overlord/devicestate/internal"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 )
@@ -34,11 +35,16 @@ func DeviceCtx(st *state.State, task *state.Task, providedDeviceCtx snapstate.De
 	if err != nil {
 		return nil, err
 	}
-	return modelDeviceContext{model: modelAs}, nil
+	device, err := internal.Device(st)
+	if err != nil {
+		return nil, err
+	}
+	return modelDeviceContext{model: modelAs, serial: device.Serial}, nil
 }
 
 type modelDeviceContext struct {
-	model *asserts.Model
+	model  *asserts.Model
+	serial string
 }
 
 // sanity
@@ -55,3 +61,7 @@ func (dc modelDeviceContext) Store() snapstate.StoreService {
 func (dc modelDeviceContext) ForRemodeling() bool {
 	return false
 }
+
+func (dc modelDeviceContext) Serial() (string, error) {
+	return dc.serial, nil
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/i18n"
@@ -39,6 +40,8 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
 	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -56,8 +59,12 @@ type DeviceManager struct {
 
 	ensureSeedInConfigRan bool
 
+	modelConformanceChecked bool
+
 	lastBecomeOperationalAttempt time.Time
 	becomeOperationalBackoff     time.Duration
+	lastSeedAttempt              time.Time
+	seedBackoff                  time.Duration
 	registered                   bool
 	reg                          chan struct{}
 }
@@ -88,24 +95,39 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, runner *state.T
 	}
 
 	hookManager.Register(regexp.MustCompile("^prepare-device$"), newPrepareDeviceHandler)
+	hookManager.Register(regexp.MustCompile("^attest-device$"), m.newAttestDeviceHandler)
 
 	runner.AddHandler("generate-device-key", m.doGenerateDeviceKey, nil)
+	runner.AddHandler("request-serial-id", m.doRequestSerialID, nil)
 	runner.AddHandler("request-serial", m.doRequestSerial, nil)
+	// stop polling for the serial after a couple of hours, the device
+	// will try to become operational again, see
+	// ensureOperationalShouldBackoff
+	runner.SetRetryPolicy("request-serial", state.RetryPolicy{
+		MaxRetries: 120,
+	})
 	runner.AddHandler("mark-seeded", m.doMarkSeeded, nil)
+	runner.AddHandler("mark-preseeded", m.doMarkPreseeded, nil)
 	runner.AddHandler("prepare-remodeling", m.doPrepareRemodeling, nil)
 	runner.AddCleanup("prepare-remodeling", m.cleanupRemodel)
 	// this *must* always run last and finalizes a remodel
 	runner.AddHandler("set-model", m.doSetModel, nil)
 	runner.AddCleanup("set-model", m.cleanupRemodel)
+	runner.AddHandler("prepare-factory-reset", m.doPrepareFactoryReset, nil)
+	runner.AddHandler("create-recovery-system", m.doCreateRecoverySystem, nil)
 	// There is no undo for successful gadget updates. The system is
 	// rebooted during update, if it boots up to the point where snapd runs
 	// we deem the new assets (be it bootloader or firmware) functional. The
 	// deployed boot assets must be backward compatible with reverted kernel
 	// or gadget snaps. There are no further changes to the boot assets,
 	// unless a new gadget update is deployed.
-	runner.AddHandler("update-gadget-assets", m.doUpdateGadgetAssets, nil)
+	runner.AddHandler("update-gadget-assets", m.doUpdateGadgetAssets, m.undoUpdateGadgetAssets)
+	runner.AddCleanup("update-gadget-assets", m.cleanupUpdateGadgetAssets)
 
 	runner.AddBlocked(gadgetUpdateBlocked)
+	snapstate.AddTaskResources("update-gadget-assets", gadgetUpdateResources)
+
+	secboot.SetKMSRequestSigner(m.signKMSRequest)
 
 	return m, nil
 }
@@ -168,6 +190,16 @@ func gadgetUpdateBlocked(cand *state.Task, running []*state.Task) bool {
 	return false
 }
 
+// gadgetUpdateResources returns the locks on the boot configuration
+// and bootloader environment that updating the gadget assets may
+// replace.
+func gadgetUpdateResources(t *state.Task) ([]snapstate.ResourceLock, error) {
+	return []snapstate.ResourceLock{
+		{Resource: snapstate.ResourceBootConfig, Exclusive: true},
+		{Resource: snapstate.ResourceBootloaderEnv, Exclusive: true},
+	}, nil
+}
+
 type prepareDeviceHandler struct{}
 
 func newPrepareDeviceHandler(context *hookstate.Context) hookstate.Handler {
@@ -211,6 +243,30 @@ func ensureOperationalAttempts(st *state.State) int {
 	return cur
 }
 
+// seedRetryInterval is the initial interval before a failed seed change is
+// retried automatically.
+var seedRetryInterval = 5 * time.Minute
+
+// ensureSeedShouldBackoff returns whether we should wait before retrying
+// a failed seed change. The first failure seen starts the backoff.
+func (m *DeviceManager) ensureSeedShouldBackoff(now time.Time) bool {
+	if m.lastSeedAttempt.IsZero() {
+		m.lastSeedAttempt = now
+		m.seedBackoff = seedRetryInterval
+		return true
+	}
+	if m.lastSeedAttempt.Add(m.seedBackoff).After(now) {
+		return true
+	}
+	newBackoff := m.seedBackoff * 2
+	if newBackoff > (12 * time.Hour) {
+		newBackoff = 24 * time.Hour
+	}
+	m.seedBackoff = newBackoff
+	m.lastSeedAttempt = now
+	return false
+}
+
 // ensureOperationalShouldBackoff returns whether we should abstain from
 // further become-operational tentatives while its backoff interval is
 // not expired.
@@ -291,6 +347,14 @@ func (m *DeviceManager) ensureOperational() error {
 		}
 	}
 
+	restored, err := m.restoreFactoryResetIdentity(device)
+	if err != nil {
+		return err
+	}
+	if restored {
+		return nil
+	}
+
 	if m.changeInFlight("become-operational") {
 		return nil
 	}
@@ -320,7 +384,7 @@ func (m *DeviceManager) ensureOperational() error {
 		}
 	}
 
-	var hasPrepareDeviceHook bool
+	var hasPrepareDeviceHook, hasAttestDeviceHook bool
 	// if there's a gadget specified wait for it
 	if gadget != "" {
 		// if have a gadget wait until seeded to proceed
@@ -336,6 +400,7 @@ func (m *DeviceManager) ensureOperational() error {
 			return err
 		}
 		hasPrepareDeviceHook = (gadgetInfo.Hooks["prepare-device"] != nil)
+		hasAttestDeviceHook = (gadgetInfo.Hooks["attest-device"] != nil)
 	}
 
 	// have some backoff between full retries
@@ -370,8 +435,28 @@ func (m *DeviceManager) ensureOperational() error {
 		genKey.WaitFor(prepareDevice)
 	}
 	tasks = append(tasks, genKey)
+	var attestDevice *state.Task
+	if hasAttestDeviceHook {
+		// the attestation is bound to a request-id issued by the
+		// device service, so that it cannot be replayed
+		requestSerialID := m.state.NewTask("request-serial-id", i18n.G("Request device serial request-id"))
+		requestSerialID.WaitFor(genKey)
+		tasks = append(tasks, requestSerialID)
+
+		summary := i18n.G("Run attest-device hook")
+		hooksup := &hookstate.HookSetup{
+			Snap: gadget,
+			Hook: "attest-device",
+		}
+		attestDevice = hookstate.HookTask(m.state, summary, hooksup, nil)
+		attestDevice.WaitFor(requestSerialID)
+		tasks = append(tasks, attestDevice)
+	}
 	requestSerial := m.state.NewTask("request-serial", i18n.G("Request device serial"))
 	requestSerial.WaitFor(genKey)
+	if attestDevice != nil {
+		requestSerial.WaitFor(attestDevice)
+	}
 	tasks = append(tasks, requestSerial)
 
 	chg := m.state.NewChange("become-operational", i18n.G("Initialize device"))
@@ -405,14 +490,29 @@ func (m *DeviceManager) ensureSeedYaml() error {
 	if m.changeInFlight("seed") {
 		return nil
 	}
+	// a failed seed change is retried with backoff, its error is kept
+	// around for reporting until seeding is set up again
+	seedErr := ""
+	if chg := lastSeedChange(m.state); chg != nil && chg.Status() == state.ErrorStatus {
+		if seedingFailed(m.state) && m.ensureSeedShouldBackoff(time.Now()) {
+			return nil
+		}
+		seedErr = seedChangeError(chg)
+	}
 
 	var tsAll []*state.TaskSet
 	timings.Run(perfTimings, "state-from-seed", "populate state from seed", func(tm timings.Measurer) {
 		tsAll, err = populateStateFromSeed(m.state, tm)
 	})
 	if err != nil {
+		m.state.Set(seedErrorKey, err.Error())
 		return err
 	}
+	if seedErr != "" {
+		m.state.Set(seedErrorKey, seedErr)
+	} else {
+		m.state.Set(seedErrorKey, nil)
+	}
 	if len(tsAll) == 0 {
 		return nil
 	}
@@ -442,7 +542,7 @@ func (m *DeviceManager) ensureBootOk() error {
 		if err != nil {
 			return fmt.Errorf(i18n.G("cannot mark boot successful: %s"), err)
 		}
-		if err := bootloader.MarkBootSuccessful(loader); err != nil {
+		if err := boot.MarkBootSuccessful(loader); err != nil {
 			return err
 		}
 		m.bootOkRan = true
@@ -522,19 +622,33 @@ func (e *ensureError) Error() string {
 func (m *DeviceManager) Ensure() error {
 	var errs []error
 
-	if err := m.ensureSeedYaml(); err != nil {
-		errs = append(errs, err)
-	}
-	if err := m.ensureOperational(); err != nil {
+	// this must happen before any assertion is checked
+	if err := m.ensureTimeSanity(); err != nil {
 		errs = append(errs, err)
 	}
 
-	if err := m.ensureBootOk(); err != nil {
+	if err := m.ensureSeedYaml(); err != nil {
 		errs = append(errs, err)
 	}
 
-	if err := m.ensureSeedInConfig(); err != nil {
-		errs = append(errs, err)
+	// while preseeding there is neither a device to register nor a
+	// boot to mark as successful
+	if !snapdenv.Preseeding() {
+		if err := m.ensureOperational(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := m.ensureBootOk(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := m.ensureSeedInConfig(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := m.ensureModelConformance(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	if len(errs) > 0 {
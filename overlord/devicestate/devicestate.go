@@ -27,9 +27,11 @@ import (
 	"sync"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/netutil"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate/config"
@@ -39,6 +41,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store"
 )
 
 var (
@@ -138,6 +141,31 @@ func canAutoRefresh(st *state.State) (bool, error) {
 	return true, nil
 }
 
+// reportRefreshMetrics sends the refresh metrics to where the store of
+// the device is configured to take them, without identifying the device.
+// It is called without the state lock held.
+func reportRefreshMetrics(st *state.State, metrics []*snapstate.RefreshMetric) error {
+	st.Lock()
+	sto := snapstate.Store(st, nil)
+	st.Unlock()
+
+	storeMetrics := make([]*store.RefreshMetric, len(metrics))
+	for i, m := range metrics {
+		storeMetrics[i] = &store.RefreshMetric{
+			Snap:         m.Snap,
+			FromRevision: m.FromRevision,
+			ToRevision:   m.ToRevision,
+			Auto:         m.Auto,
+			Success:      m.Success,
+			ErrorClass:   m.ErrorClass,
+			Duration:     m.Duration.Seconds(),
+			Delta:        m.Delta,
+			Time:         m.Time,
+		}
+	}
+	return sto.ReportRefreshMetrics(context.TODO(), storeMetrics)
+}
+
 func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags snapstate.Flags, deviceCtx snapstate.DeviceContext) error {
 	kind := ""
 	var snapType snap.Type
@@ -175,6 +203,12 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags sn
 		logger.Noticef("installing unasserted %s %q", kind, snapInfo.InstanceName())
 	}
 
+	if deviceCtx.ForRemodeling() {
+		if err := checkGadgetKernelCompatibility(st, snapInfo, model); err != nil {
+			return err
+		}
+	}
+
 	found, err := snapstate.HasSnapOfType(st, snapType)
 	if err != nil {
 		return fmt.Errorf("cannot detect original %s snap: %v", kind, err)
@@ -201,6 +235,66 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags sn
 	return nil
 }
 
+// checkGadgetKernelCompatibility checks that the kernel supports what the
+// volumes of the gadget need when remodeling, the snap being installed
+// being either of them and the other one being the one of the new model,
+// if already installed. Otherwise the check happens when the other one
+// is installed.
+func checkGadgetKernelCompatibility(st *state.State, snapInfo *snap.Info, model *asserts.Model) error {
+	var gadgetInfo, kernelInfo *snap.Info
+	var err error
+	switch snapInfo.GetType() {
+	case snap.TypeGadget:
+		gadgetInfo = snapInfo
+		kernelInfo, err = snapstate.CurrentInfo(st, model.Kernel())
+	case snap.TypeKernel:
+		kernelInfo = snapInfo
+		gadgetInfo, err = snapstate.CurrentInfo(st, model.Gadget())
+	}
+	if _, ok := err.(*snap.NotInstalledError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !osutil.FileExists(gadgetInfo.MountFile()) || !osutil.FileExists(kernelInfo.MountFile()) {
+		// nothing to check
+		return nil
+	}
+
+	kernelSnap, err := snap.Open(kernelInfo.MountFile())
+	if err != nil {
+		return err
+	}
+	features, err := snap.ReadKernelFeatures(kernelSnap)
+	if err != nil {
+		return err
+	}
+	if features == nil {
+		// the kernel does not say what it supports
+		return nil
+	}
+
+	gadgetSnap, err := snap.Open(gadgetInfo.MountFile())
+	if err != nil {
+		return err
+	}
+	gadgetYaml, err := gadgetSnap.ReadFile("meta/gadget.yaml")
+	if err != nil {
+		return fmt.Errorf("cannot read gadget snap details: %v", err)
+	}
+	const classic = false
+	gi, err := gadget.InfoFromGadgetYaml(gadgetYaml, classic)
+	if err != nil {
+		return fmt.Errorf("cannot read gadget snap details: %v", err)
+	}
+
+	if err := gadget.ValidateKernelCompatibility(gi, features); err != nil {
+		return fmt.Errorf("cannot remodel to use kernel %q with gadget %q: %v", kernelInfo.InstanceName(), gadgetInfo.InstanceName(), err)
+	}
+	return nil
+}
+
 var once sync.Once
 
 func delayedCrossMgrInit() {
@@ -212,6 +306,7 @@ func delayedCrossMgrInit() {
 	snapstate.IsOnMeteredConnection = netutil.IsOnMeteredConnection
 	snapstate.DeviceCtx = DeviceCtx
 	snapstate.Remodeling = Remodeling
+	snapstate.ReportRefreshMetrics = reportRefreshMetrics
 }
 
 // proxyStore returns the store assertion for the proxy store if one is set.
@@ -337,15 +432,44 @@ func extractDownloadInstallEdgesFromTs(ts *state.TaskSet) (firstDl, lastDl, firs
 func remodelTasks(ctx context.Context, st *state.State, current, new *asserts.Model, deviceCtx snapstate.DeviceContext, fromChange string) ([]*state.TaskSet, error) {
 	userID := 0
 
-	// adjust kernel track
+	// switch the gadget or adjust its track, this happens before
+	// the kernel is updated so that the boot assets the new kernel
+	// may depend on are in place
 	var tss []*state.TaskSet
+	var gadgetTs, kernelTs *state.TaskSet
+	if current.Gadget() != new.Gadget() {
+		ts, err := snapstateInstallWithDeviceContext(ctx, st, new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, userID, snapstate.Flags{}, deviceCtx, fromChange)
+		if err != nil {
+			return nil, err
+		}
+		// the gadget of the current model is removed once the
+		// new model is set
+		gadgetTs = ts
+		tss = append(tss, ts)
+	} else if current.GadgetTrack() != new.GadgetTrack() {
+		ts, err := snapstateUpdateWithDeviceContext(st, new.Gadget(), &snapstate.RevisionOptions{Channel: new.GadgetTrack()}, userID, snapstate.Flags{NoReRefresh: true}, deviceCtx, fromChange)
+		if err != nil {
+			return nil, err
+		}
+		gadgetTs = ts
+		tss = append(tss, ts)
+	}
+	// adjust kernel track
 	if current.KernelTrack() != new.KernelTrack() {
 		ts, err := snapstateUpdateWithDeviceContext(st, new.Kernel(), &snapstate.RevisionOptions{Channel: new.KernelTrack()}, userID, snapstate.Flags{NoReRefresh: true}, deviceCtx, fromChange)
 		if err != nil {
 			return nil, err
 		}
+		kernelTs = ts
 		tss = append(tss, ts)
 	}
+	if gadgetTs != nil && kernelTs != nil {
+		// reboot only once into the new kernel and gadget assets,
+		// and have both reverted if the kernel fails to boot
+		if err := snapstate.PairKernelAndGadget(kernelTs, gadgetTs); err != nil {
+			return nil, fmt.Errorf("cannot remodel: %v", err)
+		}
+	}
 	// add new required-snaps, no longer required snaps will be cleaned
 	// in "set-model"
 	for _, snapName := range new.RequiredSnaps() {
@@ -480,13 +604,10 @@ func Remodel(st *state.State, new *asserts.Model) (*state.Change, error) {
 		return nil, fmt.Errorf("cannot remodel to different bases yet")
 	}
 	// FIXME: we need to support this soon but right now only a single
-	// snap of type "gadget/kernel" is allowed so this needs work
+	// snap of type "kernel" is allowed so this needs work
 	if current.Kernel() != new.Kernel() {
 		return nil, fmt.Errorf("cannot remodel to different kernels yet")
 	}
-	if current.Gadget() != new.Gadget() {
-		return nil, fmt.Errorf("cannot remodel to different gadgets yet")
-	}
 
 	// TODO: should we run a remodel only while no other change is
 	// running?  do we add a task upfront that waits for that to be
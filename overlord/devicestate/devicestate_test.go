@@ -21,6 +21,8 @@ package devicestate_test
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -30,6 +32,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -57,14 +61,18 @@ import (
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
 	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
 	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
 	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/snapdenv"
+	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/store/storetest"
 	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/timings"
@@ -104,6 +112,8 @@ type fakeStore struct {
 
 	state *state.State
 	db    asserts.RODatabase
+
+	refreshMetrics []*store.RefreshMetric
 }
 
 func (sto *fakeStore) pokeStateLock() {
@@ -119,6 +129,12 @@ func (sto *fakeStore) Assertion(assertType *asserts.AssertionType, key []string,
 	return ref.Resolve(sto.db.Find)
 }
 
+func (sto *fakeStore) ReportRefreshMetrics(ctx context.Context, metrics []*store.RefreshMetric) error {
+	sto.pokeStateLock()
+	sto.refreshMetrics = metrics
+	return nil
+}
+
 var (
 	brandPrivKey, _  = assertstest.GenerateKey(752)
 	brandPrivKey2, _ = assertstest.GenerateKey(752)
@@ -362,37 +378,50 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappy(c *C) {
 	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithProxy(c *C) {
+type fakeSerialRequestSigner struct {
+	challenges [][]byte
+	err        error
+}
+
+func (f *fakeSerialRequestSigner) AttestationType() string {
+	return "fake-tpm"
+}
+
+func (f *fakeSerialRequestSigner) Attest(brandID, model string, challenge []byte) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.challenges = append(f.challenges, challenge)
+	return []byte(fmt.Sprintf("attested %s/%s", brandID, model)), nil
+}
+
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithAttestation(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	signer := &fakeSerialRequestSigner{}
+	r2 := devicestate.RegisterSerialRequestSigner("canonical", signer)
+	defer r2()
+
+	checked := 0
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			checked++
+			c.Check(serialReq.AttestationType(), Equals, "fake-tpm")
+			c.Check(string(serialReq.Attestation()), Equals, "attested canonical/pc")
+			c.Assert(signer.challenges, HasLen, 1)
+			c.Check(signer.challenges[0], DeepEquals, asserts.SerialRequestAttestationChallenge("REQID-1", serialReq.DeviceKey()))
+		},
+	})
 	defer mockServer.Close()
 
-	// as core.proxy.store is set, should not need to do this but just in case
-	r2 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baaad/")
-	defer r2()
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r3()
 
 	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	tr := config.NewTransaction(s.state)
-	c.Assert(tr.Set("core", "proxy.store", "foo"), IsNil)
-	tr.Commit()
-	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
-
-	// have a store assertion.
-	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
-		"store":       "foo",
-		"url":         mockServer.URL,
-		"operator-id": operatorAcct.AccountID(),
-		"timestamp":   time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-
-	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
-
 	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
@@ -405,7 +434,7 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithProxy(c *C) {
 	})
 
 	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-	// mark as seeded
+	// mark it as seeded
 	s.state.Set("seeded", true)
 
 	// runs the whole device registration process
@@ -418,68 +447,52 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithProxy(c *C) {
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
 	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(checked, Equals, 1)
 
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
-	c.Check(device.Brand, Equals, "canonical")
-	c.Check(device.Model, Equals, "pc")
 	c.Check(device.Serial, Equals, "9999")
-
-	ok := false
-	select {
-	case <-s.mgr.Registered():
-		ok = true
-	case <-time.After(5 * time.Second):
-		c.Fatal("should have been marked registered")
-	}
-	c.Check(ok, Equals, true)
-
-	a, err := s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "canonical",
-		"model":    "pc",
-		"serial":   "9999",
-	})
-	c.Assert(err, IsNil)
-	serial := a.(*asserts.Serial)
-
-	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
-	c.Assert(err, IsNil)
-	c.Check(privKey, NotNil)
-
-	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyClassicNoGadget(c *C) {
-	restore := release.MockOnClassic(true)
-	defer restore()
-
+func (s *deviceMgrSuite) TestFullDeviceRegistrationAttestationError(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	r2 := devicestate.RegisterSerialRequestSigner("canonical", &fakeSerialRequestSigner{
+		err: errors.New("TPM unavailable"),
+	})
+	defer r2()
+
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			c.Fatal("unexpected serial request")
+		},
+	})
 	defer mockServer.Close()
 
-	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer r2()
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r3()
 
 	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	s.makeModelAssertionInState(c, "canonical", "classic-alt-store", map[string]interface{}{
-		"classic": "true",
-		"store":   "alt-store",
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
 	})
 
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
-		Model: "classic-alt-store",
+		Model: "pc",
 	})
 
-	// avoid full seeding
-	s.seeding()
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark it as seeded
+	s.state.Set("seeded", true)
 
-	// runs the whole device registration process
+	// try the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
@@ -488,183 +501,180 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyClassicNoGadget(c *C) {
 	c.Assert(becomeOperational, NotNil)
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
-	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot attest serial request: TPM unavailable.*`)
 
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
-	c.Check(device.Brand, Equals, "canonical")
-	c.Check(device.Model, Equals, "classic-alt-store")
-	c.Check(device.Serial, Equals, "9999")
-
-	a, err := s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "canonical",
-		"model":    "classic-alt-store",
-		"serial":   "9999",
-	})
-	c.Assert(err, IsNil)
-	serial := a.(*asserts.Serial)
-
-	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
-	c.Assert(err, IsNil)
-	c.Check(privKey, NotNil)
-
-	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
+	c.Check(device.Serial, Equals, "")
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyClassicFallback(c *C) {
-	restore := release.MockOnClassic(true)
-	defer restore()
-
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithAttestationHelper(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	var challenges [][]byte
+	r2 := osutil.MockRunHelper(func(hc *osutil.HelperCommand) ([]byte, error) {
+		c.Check(hc.Name, Equals, "/usr/lib/vendor/attest")
+		c.Assert(hc.Args, HasLen, 3)
+		c.Check(hc.Args[:2], DeepEquals, []string{"canonical", "pc"})
+		challenge, err := ioutil.ReadAll(hc.Stdin)
+		c.Assert(err, IsNil)
+		challenges = append(challenges, challenge)
+		return nil, ioutil.WriteFile(hc.Args[2], []byte("hsm attestation"), 0600)
+	})
+	defer r2()
+
+	checked := 0
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			checked++
+			c.Check(serialReq.AttestationType(), Equals, "vendor-hsm")
+			c.Check(string(serialReq.Attestation()), Equals, "hsm attestation")
+			c.Check(challenges, DeepEquals, [][]byte{asserts.SerialRequestAttestationChallenge("REQID-1", serialReq.DeviceKey())})
+		},
+	})
 	defer mockServer.Close()
 
-	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer r2()
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r3()
 
-	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	// in this case is just marked seeded without snaps
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "registration.attestation-helper", "/usr/lib/vendor/attest"), IsNil)
+	c.Assert(tr.Set("core", "registration.attestation-type", "vendor-hsm"), IsNil)
+	tr.Commit()
 	s.state.Set("seeded", true)
 
-	// not started without some installation happening or happened
-	s.state.Unlock()
-	s.se.Ensure()
-	s.state.Lock()
-
-	becomeOperational := s.findBecomeOperationalChange()
-	c.Check(becomeOperational, IsNil)
-
-	// have a in-progress installation
-	inst := s.state.NewChange("install", "...")
-	task := s.state.NewTask("mount-snap", "...")
-	inst.AddTask(task)
-
-	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
 
-	becomeOperational = s.findBecomeOperationalChange()
+	becomeOperational := s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
-
-	c.Check(becomeOperational.Status().Ready(), Equals, true)
 	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(checked, Equals, 1)
+}
 
-	device, err := devicestatetest.Device(s.state)
-	c.Assert(err, IsNil)
-	c.Check(device.Brand, Equals, "generic")
-	c.Check(device.Model, Equals, "generic-classic")
-	c.Check(device.Serial, Equals, "9999")
-
-	// model was installed
-	_, err = s.db.Find(asserts.ModelType, map[string]string{
-		"series":   "16",
-		"brand-id": "generic",
-		"model":    "generic-classic",
-		"classic":  "true",
-	})
-	c.Assert(err, IsNil)
-
-	a, err := s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "generic",
-		"model":    "generic-classic",
-		"serial":   "9999",
+func (s *deviceMgrSuite) mockGadgetWithAttestDeviceHook(c *C, attestationType, attestation string) (challenges *[]string, restore func()) {
+	sideInfoGadget := &snap.SideInfo{
+		RealName: "pc",
+		Revision: snap.R(2),
+	}
+	snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: gadget\nhooks:\n  attest-device:\n", sideInfoGadget)
+	snapstate.Set(s.state, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{sideInfoGadget},
+		Current:  sideInfoGadget.Revision,
 	})
-	c.Assert(err, IsNil)
-	serial := a.(*asserts.Serial)
 
-	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
-	c.Assert(err, IsNil)
-	c.Check(privKey, NotNil)
+	challenges = new([]string)
+	restore = hookstate.MockRunHook(func(ctx *hookstate.Context, _ *tomb.Tomb) ([]byte, error) {
+		c.Assert(ctx.HookName(), Equals, "attest-device")
 
-	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
+		stdout, _, err := ctlcmd.Run(ctx, []string{"get", "registration.attestation-challenge"}, 0)
+		c.Assert(err, IsNil)
+		*challenges = append(*challenges, strings.TrimSpace(string(stdout)))
 
-	// auto-refreshes are possible
-	ok, err := devicestate.CanAutoRefresh(s.state)
-	c.Assert(err, IsNil)
-	c.Check(ok, Equals, true)
+		_, _, err = ctlcmd.Run(ctx, []string{"set",
+			"registration.attestation-type=" + attestationType,
+			"registration.attestation=" + attestation,
+		}, 0)
+		c.Assert(err, IsNil)
+		return nil, nil
+	})
+	return challenges, restore
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationAltBrandHappy(c *C) {
-	c.Skip("not yet supported")
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithAttestDeviceHook(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	var challenges *[]string
+	checked := 0
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			checked++
+			c.Check(serialReq.AttestationType(), Equals, "tpm2-quote")
+			c.Check(string(serialReq.Attestation()), Equals, "quote")
+			// the challenge binds the device key and the request-id
+			c.Check(serialReq.RequestID(), Equals, "REQID-1")
+			challenge := asserts.SerialRequestAttestationChallenge("REQID-1", serialReq.DeviceKey())
+			c.Check(*challenges, DeepEquals, []string{hex.EncodeToString(challenge)})
+		},
+	})
 	defer mockServer.Close()
 
 	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
 	defer r2()
 
-	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	s.makeModelAssertionInState(c, "my-brand", "my-model", map[string]interface{}{
-		"classic": "true",
-		"store":   "alt-store",
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
 	})
-
-	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
-
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "my-brand",
-		Model: "my-model",
+		Brand: "canonical",
+		Model: "pc",
 	})
 
-	// avoid full seeding
-	s.seeding()
+	var r3 func()
+	challenges, r3 = s.mockGadgetWithAttestDeviceHook(c, "tpm2-quote", base64.StdEncoding.EncodeToString([]byte("quote")))
+	defer r3()
+	s.state.Set("seeded", true)
 
-	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
 
 	becomeOperational := s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
-
-	c.Check(becomeOperational.Status().Ready(), Equals, true)
 	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(checked, Equals, 1)
+
+	var kinds []string
+	for _, t := range becomeOperational.Tasks() {
+		kinds = append(kinds, t.Kind())
+	}
+	c.Check(kinds, DeepEquals, []string{"generate-device-key", "request-serial-id", "run-hook", "request-serial"})
 
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
-	c.Check(device.Brand, Equals, "my-brand")
-	c.Check(device.Model, Equals, "my-model")
 	c.Check(device.Serial, Equals, "9999")
-
-	a, err := s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "my-brand",
-		"model":    "my-model",
-		"serial":   "9999",
-	})
-	c.Assert(err, IsNil)
-	serial := a.(*asserts.Serial)
-
-	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
-	c.Assert(err, IsNil)
-	c.Check(privKey, NotNil)
-
-	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterAddSerial(c *C) {
-	privKey, _ := assertstest.GenerateKey(testKeyLength)
+func (s *deviceMgrSuite) TestFullDeviceRegistrationIgnoresUnboundAttestation(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	checked := 0
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			checked++
+			c.Check(serialReq.AttestationType(), Equals, "")
+			c.Check(serialReq.Attestation(), HasLen, 0)
+		},
+	})
 	defer mockServer.Close()
 
-	restore := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer restore()
-
-	restore = devicestate.MockRepeatRequestSerial("after-add-serial")
-	defer restore()
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
 
-	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
 
@@ -673,81 +683,44 @@ func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterAddSerial(c *C) {
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
 	})
-
-	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
-		KeyID: privKey.PublicKey().ID(),
-	})
-	devicestate.KeypairManager(s.mgr).Put(privKey)
-
-	t := s.state.NewTask("request-serial", "test")
-	chg := s.state.NewChange("become-operational", "...")
-	chg.AddTask(t)
-
-	// avoid full seeding
-	s.seeding()
-
-	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
-	s.state.Lock()
-
-	c.Check(chg.Status(), Equals, state.DoingStatus)
-	c.Check(chg.Err(), IsNil)
-	device, err := devicestatetest.Device(s.state)
-	c.Check(err, IsNil)
-	_, err = s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "canonical",
-		"model":    "pc",
-		"serial":   "9999",
 	})
-	c.Assert(err, IsNil)
 
-	ok := false
-	select {
-	case <-s.mgr.Registered():
-	default:
-		ok = true
-	}
-	c.Check(ok, Equals, true)
+	// the gadget has no attest-device hook anymore, the attestation
+	// left from an earlier attempt is not bound to a request-id
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("pc", "registration.attestation-type", "tpm2-quote"), IsNil)
+	c.Assert(tr.Set("pc", "registration.attestation", base64.StdEncoding.EncodeToString([]byte("quote"))), IsNil)
+	tr.Commit()
+	s.state.Set("seeded", true)
 
 	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
+	s.settle(c)
 	s.state.Lock()
 
-	// Repeated handler run but set original serial.
-	c.Check(chg.Status(), Equals, state.DoneStatus)
-	device, err = devicestatetest.Device(s.state)
-	c.Check(err, IsNil)
-	c.Check(device.Serial, Equals, "9999")
-
-	ok = false
-	select {
-	case <-s.mgr.Registered():
-		ok = true
-	case <-time.After(5 * time.Second):
-		c.Fatal("should have been marked registered")
-	}
-	c.Check(ok, Equals, true)
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(checked, Equals, 1)
 }
 
-func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterGotSerial(c *C) {
-	privKey, _ := assertstest.GenerateKey(testKeyLength)
+func (s *deviceMgrSuite) TestFullDeviceRegistrationAttestDeviceHookInvalid(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
 
-	mockServer := s.mockServer(c, "REQID-1", nil)
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			c.Fatal("unexpected serial request")
+		},
+	})
 	defer mockServer.Close()
 
-	restore := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer restore()
-
-	restore = devicestate.MockRepeatRequestSerial("after-got-serial")
-	defer restore()
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
 
-	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
 
@@ -756,119 +729,45 @@ func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterGotSerial(c *C) {
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
 	})
-
-	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
-		KeyID: privKey.PublicKey().ID(),
 	})
-	devicestate.KeypairManager(s.mgr).Put(privKey)
-
-	t := s.state.NewTask("request-serial", "test")
-	chg := s.state.NewChange("become-operational", "...")
-	chg.AddTask(t)
-
-	// avoid full seeding
-	s.seeding()
-
-	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
-	s.state.Lock()
 
-	c.Check(chg.Status(), Equals, state.DoingStatus)
-	device, err := devicestatetest.Device(s.state)
-	c.Check(err, IsNil)
-	_, err = s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "canonical",
-		"model":    "pc",
-		"serial":   "9999",
-	})
-	c.Assert(asserts.IsNotFound(err), Equals, true)
+	_, r3 := s.mockGadgetWithAttestDeviceHook(c, "tpm2-quote", "not-base64!")
+	defer r3()
+	s.state.Set("seeded", true)
 
 	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
+	s.settle(c)
 	s.state.Lock()
 
-	// Repeated handler run but set original serial.
-	c.Check(chg.Status(), Equals, state.DoneStatus)
-	c.Check(chg.Err(), IsNil)
-	device, err = devicestatetest.Device(s.state)
-	c.Check(err, IsNil)
-	c.Check(device.Serial, Equals, "9999")
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot use attestation of the device: registration.attestation must be base64 encoded: .*`)
 }
 
-func (s *deviceMgrSuite) TestDoRequestSerialErrorsOnNoHost(c *C) {
-	if os.Getenv("http_proxy") != "" {
-		c.Skip("cannot run test when http proxy is in use, the error pattern is different")
-	}
-
-	const nonexistent_host = "nowhere.nowhere.test"
-
-	// check internet access
-	_, err := net.LookupHost(nonexistent_host)
-	if netErr, ok := err.(net.Error); !ok || netErr.Temporary() {
-		c.Skip("cannot run test with no internet access, the error pattern is different")
-	}
-
-	privKey, _ := assertstest.GenerateKey(testKeyLength)
-
-	nowhere := "http://" + nonexistent_host
-
-	restore := devicestate.MockBaseStoreURL(nowhere)
-	defer restore()
-
-	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
-	s.state.Lock()
-	defer s.state.Unlock()
+func (s *deviceMgrSuite) TestFullDeviceRegistrationSignerOverridesAttestDeviceHook(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
 
-	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
+	signer := &fakeSerialRequestSigner{}
+	r2 := devicestate.RegisterSerialRequestSigner("canonical", signer)
+	defer r2()
 
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc",
-		KeyID: privKey.PublicKey().ID(),
+	checked := 0
+	mockServer := s.mockServer(c, "REQID-1", &devicestatetest.DeviceServiceBehavior{
+		CheckSerialRequest: func(c *C, bhv *devicestatetest.DeviceServiceBehavior, serialReq *asserts.SerialRequest) {
+			checked++
+			c.Check(serialReq.AttestationType(), Equals, "fake-tpm")
+			c.Check(string(serialReq.Attestation()), Equals, "attested canonical/pc")
+		},
 	})
-	devicestate.KeypairManager(s.mgr).Put(privKey)
-
-	t := s.state.NewTask("request-serial", "test")
-	chg := s.state.NewChange("become-operational", "...")
-	chg.AddTask(t)
-
-	// avoid full seeding
-	s.seeding()
-
-	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
-	s.state.Lock()
-
-	c.Check(chg.Status(), Equals, state.ErrorStatus)
-}
-
-func (s *deviceMgrSuite) TestDoRequestSerialMaxTentatives(c *C) {
-	privKey, _ := assertstest.GenerateKey(testKeyLength)
-
-	// immediate
-	r := devicestate.MockRetryInterval(0)
-	defer r()
-
-	r = devicestate.MockMaxTentatives(2)
-	defer r()
-
-	mockServer := s.mockServer(c, devicestatetest.ReqIDFailID501, nil)
 	defer mockServer.Close()
 
-	restore := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer restore()
-
-	restore = devicestate.MockRepeatRequestSerial("after-add-serial")
-	defer restore()
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r3()
 
-	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
 
@@ -877,57 +776,56 @@ func (s *deviceMgrSuite) TestDoRequestSerialMaxTentatives(c *C) {
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
 	})
-
-	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
-		KeyID: privKey.PublicKey().ID(),
 	})
-	devicestate.KeypairManager(s.mgr).Put(privKey)
-
-	t := s.state.NewTask("request-serial", "test")
-	chg := s.state.NewChange("become-operational", "...")
-	chg.AddTask(t)
-
-	// avoid full seeding
-	s.seeding()
-
-	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
-	s.state.Lock()
 
-	c.Check(chg.Status(), Equals, state.DoingStatus)
+	_, r4 := s.mockGadgetWithAttestDeviceHook(c, "tpm2-quote", base64.StdEncoding.EncodeToString([]byte("quote")))
+	defer r4()
+	s.state.Set("seeded", true)
 
 	s.state.Unlock()
-	s.se.Ensure()
-	s.se.Wait()
+	s.settle(c)
 	s.state.Lock()
 
-	c.Check(chg.Status(), Equals, state.ErrorStatus)
-	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot retrieve request-id for making a request for a serial: unexpected status 501.*`)
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	c.Check(becomeOperational.Err(), IsNil)
+	c.Check(checked, Equals, 1)
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithProxy(c *C) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	mockServer := s.mockServer(c, devicestatetest.ReqIDPoll, nil)
+	mockServer := s.mockServer(c, "REQID-1", nil)
 	defer mockServer.Close()
 
-	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	// as core.proxy.store is set, should not need to do this but just in case
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baaad/")
 	defer r2()
 
-	// immediately
-	r3 := devicestate.MockRetryInterval(0)
-	defer r3()
-
 	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "proxy.store", "foo"), IsNil)
+	tr.Commit()
+	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
+
+	// have a store assertion.
+	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "foo",
+		"url":         mockServer.URL,
+		"operator-id": operatorAcct.AccountID(),
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
+
 	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
@@ -943,7 +841,7 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	// mark as seeded
 	s.state.Set("seeded", true)
 
-	// runs the whole device registration process with polling
+	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
@@ -951,13 +849,6 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	becomeOperational := s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
 
-	// needs 3 more Retry passes of polling
-	for i := 0; i < 3; i++ {
-		s.state.Unlock()
-		s.settle(c)
-		s.state.Lock()
-	}
-
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
 	c.Check(becomeOperational.Err(), IsNil)
 
@@ -965,12 +856,21 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(device.Brand, Equals, "canonical")
 	c.Check(device.Model, Equals, "pc")
-	c.Check(device.Serial, Equals, "10002")
+	c.Check(device.Serial, Equals, "9999")
+
+	ok := false
+	select {
+	case <-s.mgr.Registered():
+		ok = true
+	case <-time.After(5 * time.Second):
+		c.Fatal("should have been marked registered")
+	}
+	c.Check(ok, Equals, true)
 
 	a, err := s.db.Find(asserts.SerialType, map[string]string{
 		"brand-id": "canonical",
 		"model":    "pc",
-		"serial":   "10002",
+		"serial":   "9999",
 	})
 	c.Assert(err, IsNil)
 	serial := a.(*asserts.Serial)
@@ -982,76 +882,42 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
 	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C) {
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyClassicNoGadget(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	bhv := &devicestatetest.DeviceServiceBehavior{
-		RequestIDURLPath: "/svc/request-id",
-		SerialURLPath:    "/svc/serial",
-	}
-	bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
-		c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
-	}
-
-	mockServer := s.mockServer(c, "REQID-1", bhv)
+	mockServer := s.mockServer(c, "REQID-1", nil)
 	defer mockServer.Close()
 
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
 	// setup state as will be done by first-boot
-	// & have a gadget with a prepare-device hook
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	pDBhv := &devicestatetest.PrepareDeviceBehavior{
-		DeviceSvcURL: mockServer.URL + "/svc/",
-		Headers: map[string]string{
-			"x-extra-header": "extra",
-		},
-		RegBody: map[string]string{
-			"mac": "00:00:00:00:ff:00",
-		},
-		ProposedSerial: "Y9999",
-	}
-
-	r2 := devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), pDBhv)
-	defer r2()
-
-	// as device-service.url is set, should not need to do this but just in case
-	r3 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baad/")
-	defer r3()
-
-	s.makeModelAssertionInState(c, "canonical", "pc2", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "gadget",
+	s.makeModelAssertionInState(c, "canonical", "classic-alt-store", map[string]interface{}{
+		"classic": "true",
+		"store":   "alt-store",
 	})
 
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
-		Model: "pc2",
+		Model: "classic-alt-store",
 	})
 
 	// avoid full seeding
 	s.seeding()
 
-	// runs the whole device registration process, note that the
-	// device is not seeded yet
+	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
 
-	// without a seeded device, there is no become-operational change
 	becomeOperational := s.findBecomeOperationalChange()
-	c.Assert(becomeOperational, IsNil)
-
-	// now mark it as seeded
-	s.state.Set("seeded", true)
-	// and run the device registration again
-	s.state.Unlock()
-	s.settle(c)
-	s.state.Lock()
-
-	becomeOperational = s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
@@ -1060,25 +926,17 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C)
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
 	c.Check(device.Brand, Equals, "canonical")
-	c.Check(device.Model, Equals, "pc2")
-	c.Check(device.Serial, Equals, "Y9999")
+	c.Check(device.Model, Equals, "classic-alt-store")
+	c.Check(device.Serial, Equals, "9999")
 
 	a, err := s.db.Find(asserts.SerialType, map[string]string{
 		"brand-id": "canonical",
-		"model":    "pc2",
-		"serial":   "Y9999",
+		"model":    "classic-alt-store",
+		"serial":   "9999",
 	})
 	c.Assert(err, IsNil)
 	serial := a.(*asserts.Serial)
 
-	var details map[string]interface{}
-	err = yaml.Unmarshal(serial.Body(), &details)
-	c.Assert(err, IsNil)
-
-	c.Check(details, DeepEquals, map[string]interface{}{
-		"mac": "00:00:00:00:ff:00",
-	})
-
 	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
 	c.Assert(err, IsNil)
 	c.Check(privKey, NotNil)
@@ -1086,102 +944,45 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C)
 	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithHookAndNewProxy(c *C) {
-	s.testFullDeviceRegistrationHappyWithHookAndProxy(c, true)
-}
-
-func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithHookAndOldProxy(c *C) {
-	s.testFullDeviceRegistrationHappyWithHookAndProxy(c, false)
-}
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyClassicFallback(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
 
-func (s *deviceMgrSuite) testFullDeviceRegistrationHappyWithHookAndProxy(c *C, newEnough bool) {
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	var reqID string
-	var storeVersion string
-	head := func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Snap-Store-Version", storeVersion)
-	}
-	bhv := &devicestatetest.DeviceServiceBehavior{
-		Head: head,
-	}
-	svcPath := "/svc/"
-	if newEnough {
-		reqID = "REQID-42"
-		storeVersion = "6"
-		bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
-			c.Check(r.Header.Get("X-Snap-Device-Service-URL"), Matches, "http://[^/]*/bad/svc/")
-			c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
-		}
-		svcPath = "/bad/svc/"
-	} else {
-		reqID = "REQID-41"
-		storeVersion = "5"
-		bhv.RequestIDURLPath = "/svc/request-id"
-		bhv.SerialURLPath = "/svc/serial"
-		bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
-			c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
-		}
-	}
-
-	mockServer := s.mockServer(c, reqID, bhv)
+	mockServer := s.mockServer(c, "REQID-1", nil)
 	defer mockServer.Close()
 
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
 	// setup state as will be done by first-boot
-	// & have a gadget with a prepare-device hook
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	pDBhv := &devicestatetest.PrepareDeviceBehavior{
-		DeviceSvcURL: mockServer.URL + svcPath,
-		Headers: map[string]string{
-			"x-extra-header": "extra",
-		},
-	}
-	r2 := devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), pDBhv)
-	defer r2()
-
-	// as device-service.url is set, should not need to do this but just in case
-	r3 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baad/")
-	defer r3()
-
-	tr := config.NewTransaction(s.state)
-	c.Assert(tr.Set("core", "proxy.store", "foo"), IsNil)
-	tr.Commit()
-	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
-
-	// have a store assertion.
-	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
-		"store":       "foo",
-		"url":         mockServer.URL,
-		"operator-id": operatorAcct.AccountID(),
-		"timestamp":   time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-
-	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
+	// in this case is just marked seeded without snaps
+	s.state.Set("seeded", true)
 
-	s.makeModelAssertionInState(c, "canonical", "pc2", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "gadget",
-	})
+	// not started without some installation happening or happened
+	s.state.Unlock()
+	s.se.Ensure()
+	s.state.Lock()
 
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc2",
-	})
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Check(becomeOperational, IsNil)
 
-	// mark it as seeded
-	s.state.Set("seeded", true)
+	// have a in-progress installation
+	inst := s.state.NewChange("install", "...")
+	task := s.state.NewTask("mount-snap", "...")
+	inst.AddTask(task)
 
 	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
 
-	becomeOperational := s.findBecomeOperationalChange()
+	becomeOperational = s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
@@ -1189,13 +990,22 @@ func (s *deviceMgrSuite) testFullDeviceRegistrationHappyWithHookAndProxy(c *C, n
 
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
-	c.Check(device.Brand, Equals, "canonical")
-	c.Check(device.Model, Equals, "pc2")
+	c.Check(device.Brand, Equals, "generic")
+	c.Check(device.Model, Equals, "generic-classic")
 	c.Check(device.Serial, Equals, "9999")
 
+	// model was installed
+	_, err = s.db.Find(asserts.ModelType, map[string]string{
+		"series":   "16",
+		"brand-id": "generic",
+		"model":    "generic-classic",
+		"classic":  "true",
+	})
+	c.Assert(err, IsNil)
+
 	a, err := s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "canonical",
-		"model":    "pc2",
+		"brand-id": "generic",
+		"model":    "generic-classic",
 		"serial":   "9999",
 	})
 	c.Assert(err, IsNil)
@@ -1206,14 +1016,19 @@ func (s *deviceMgrSuite) testFullDeviceRegistrationHappyWithHookAndProxy(c *C, n
 	c.Check(privKey, NotNil)
 
 	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
+
+	// auto-refreshes are possible
+	ok, err := devicestate.CanAutoRefresh(s.state)
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
 }
 
-func (s *deviceMgrSuite) TestFullDeviceRegistrationErrorBackoff(c *C) {
+func (s *deviceMgrSuite) TestFullDeviceRegistrationAltBrandHappy(c *C) {
+	c.Skip("not yet supported")
 	r1 := devicestate.MockKeyLength(testKeyLength)
 	defer r1()
 
-	bhv := &devicestatetest.DeviceServiceBehavior{}
-	mockServer := s.mockServer(c, devicestatetest.ReqIDBadRequest, bhv)
+	mockServer := s.mockServer(c, "REQID-1", nil)
 	defer mockServer.Close()
 
 	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
@@ -1223,1398 +1038,2727 @@ func (s *deviceMgrSuite) TestFullDeviceRegistrationErrorBackoff(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	// sanity
-	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 0)
-
-	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
+	s.makeModelAssertionInState(c, "my-brand", "my-model", map[string]interface{}{
+		"classic": "true",
+		"store":   "alt-store",
 	})
 
+	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
+
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc",
+		Brand: "my-brand",
+		Model: "my-model",
 	})
 
-	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-	// mark as seeded
-	s.state.Set("seeded", true)
+	// avoid full seeding
+	s.seeding()
 
-	// try the whole device registration process
+	// runs the whole device registration process
 	s.state.Unlock()
 	s.settle(c)
 	s.state.Lock()
 
 	becomeOperational := s.findBecomeOperationalChange()
 	c.Assert(becomeOperational, NotNil)
-	firstTryID := becomeOperational.ID()
 
 	c.Check(becomeOperational.Status().Ready(), Equals, true)
-	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot deliver device serial request: bad serial-request.*`)
+	c.Check(becomeOperational.Err(), IsNil)
 
 	device, err := devicestatetest.Device(s.state)
 	c.Assert(err, IsNil)
-	c.Check(device.KeyID, Not(Equals), "")
-	keyID := device.KeyID
+	c.Check(device.Brand, Equals, "my-brand")
+	c.Check(device.Model, Equals, "my-model")
+	c.Check(device.Serial, Equals, "9999")
 
-	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, time.Now()), Equals, true)
-	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, time.Now().Add(6*time.Minute)), Equals, false)
-	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 1)
+	a, err := s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "my-brand",
+		"model":    "my-model",
+		"serial":   "9999",
+	})
+	c.Assert(err, IsNil)
+	serial := a.(*asserts.Serial)
 
-	// try again the whole device registration process
-	bhv.ReqID = "REQID-1"
-	devicestate.SetLastBecomeOperationalAttempt(s.mgr, time.Now().Add(-15*time.Minute))
-	s.state.Unlock()
-	s.settle(c)
-	s.state.Lock()
+	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
+	c.Assert(err, IsNil)
+	c.Check(privKey, NotNil)
 
-	becomeOperational = s.findBecomeOperationalChange(firstTryID)
-	c.Assert(becomeOperational, NotNil)
+	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
+}
 
-	c.Check(becomeOperational.Status().Ready(), Equals, true)
-	c.Check(becomeOperational.Err(), IsNil)
-
-	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 2)
-
-	device, err = devicestatetest.Device(s.state)
-	c.Assert(err, IsNil)
-	c.Check(device.KeyID, Equals, keyID)
-	c.Check(device.Serial, Equals, "10000")
-}
-
-func (s *deviceMgrSuite) TestEnsureBecomeOperationalShouldBackoff(c *C) {
-	t0 := time.Now()
-	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t0), Equals, false)
-	c.Check(devicestate.BecomeOperationalBackoff(s.mgr), Equals, 5*time.Minute)
-
-	backoffs := []time.Duration{5, 10, 20, 40, 80, 160, 320, 640, 1440, 1440}
-	t1 := t0
-	for _, m := range backoffs {
-		c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t1.Add(time.Duration(m-1)*time.Minute)), Equals, true)
-
-		t1 = t1.Add(time.Duration(m+1) * time.Minute)
-		c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t1), Equals, false)
-		m *= 2
-		if m > (12 * 60) {
-			m = 24 * 60
-		}
-		c.Check(devicestate.BecomeOperationalBackoff(s.mgr), Equals, m*time.Minute)
-	}
-}
-
-func (s *deviceMgrSuite) TestFullDeviceRegistrationMismatchedSerial(c *C) {
-	r1 := devicestate.MockKeyLength(testKeyLength)
-	defer r1()
+func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterAddSerial(c *C) {
+	privKey, _ := assertstest.GenerateKey(testKeyLength)
 
-	mockServer := s.mockServer(c, devicestatetest.ReqIDSerialWithBadModel, nil)
+	mockServer := s.mockServer(c, "REQID-1", nil)
 	defer mockServer.Close()
 
-	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer r2()
+	restore := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer restore()
 
-	// setup state as will be done by first-boot
+	restore = devicestate.MockRepeatRequestSerial("after-add-serial")
+	defer restore()
+
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	// sanity
-	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 0)
-
-	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
-
 	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
-		"gadget":       "gadget",
+		"gadget":       "pc",
 	})
 
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
+		KeyID: privKey.PublicKey().ID(),
 	})
+	devicestate.KeypairManager(s.mgr).Put(privKey)
 
-	// mark as seeded
-	s.state.Set("seeded", true)
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(t)
+
+	// avoid full seeding
+	s.seeding()
 
-	// try the whole device registration process
 	s.state.Unlock()
-	s.settle(c)
+	s.se.Ensure()
+	s.se.Wait()
 	s.state.Lock()
 
-	becomeOperational := s.findBecomeOperationalChange()
-	c.Assert(becomeOperational, NotNil)
+	c.Check(chg.Status(), Equals, state.DoingStatus)
+	c.Check(chg.Err(), IsNil)
+	device, err := devicestatetest.Device(s.state)
+	c.Check(err, IsNil)
+	_, err = s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "canonical",
+		"model":    "pc",
+		"serial":   "9999",
+	})
+	c.Assert(err, IsNil)
 
-	c.Check(becomeOperational.Status().Ready(), Equals, true)
-	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*obtained serial assertion does not match provided device identity information.*`)
+	ok := false
+	select {
+	case <-s.mgr.Registered():
+	default:
+		ok = true
+	}
+	c.Check(ok, Equals, true)
+
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
+
+	// Repeated handler run but set original serial.
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	device, err = devicestatetest.Device(s.state)
+	c.Check(err, IsNil)
+	c.Check(device.Serial, Equals, "9999")
+
+	ok = false
+	select {
+	case <-s.mgr.Registered():
+		ok = true
+	case <-time.After(5 * time.Second):
+		c.Fatal("should have been marked registered")
+	}
+	c.Check(ok, Equals, true)
 }
 
-func (s *deviceMgrSuite) TestModelAndSerial(c *C) {
+func (s *deviceMgrSuite) TestDoRequestSerialIdempotentAfterGotSerial(c *C) {
+	privKey, _ := assertstest.GenerateKey(testKeyLength)
+
+	mockServer := s.mockServer(c, "REQID-1", nil)
+	defer mockServer.Close()
+
+	restore := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer restore()
+
+	restore = devicestate.MockRepeatRequestSerial("after-got-serial")
+	defer restore()
+
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
-	// nothing in the state
-	_, err := s.mgr.Model()
-	c.Check(err, Equals, state.ErrNoState)
-	_, err = s.mgr.Serial()
-	c.Check(err, Equals, state.ErrNoState)
 
-	// just brand and model
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
+		KeyID: privKey.PublicKey().ID(),
 	})
-	_, err = s.mgr.Model()
-	c.Check(err, Equals, state.ErrNoState)
-	_, err = s.mgr.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	devicestate.KeypairManager(s.mgr).Put(privKey)
 
-	// have a model assertion
-	model := s.brands.Model("canonical", "pc", map[string]interface{}{
-		"gadget":       "pc",
-		"kernel":       "kernel",
-		"architecture": "amd64",
-	})
-	assertstatetest.AddMany(s.state, model)
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(t)
 
-	mod, err := s.mgr.Model()
-	c.Assert(err, IsNil)
-	c.Assert(mod.BrandID(), Equals, "canonical")
+	// avoid full seeding
+	s.seeding()
 
-	_, err = s.mgr.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
 
-	// have a serial as well
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:  "canonical",
-		Model:  "pc",
-		Serial: "8989",
+	c.Check(chg.Status(), Equals, state.DoingStatus)
+	device, err := devicestatetest.Device(s.state)
+	c.Check(err, IsNil)
+	_, err = s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "canonical",
+		"model":    "pc",
+		"serial":   "9999",
 	})
-	_, err = s.mgr.Model()
-	c.Assert(err, IsNil)
-	_, err = s.mgr.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	c.Assert(asserts.IsNotFound(err), Equals, true)
 
-	// have a serial assertion
-	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
 
-	_, err = s.mgr.Model()
-	c.Assert(err, IsNil)
-	ser, err := s.mgr.Serial()
-	c.Assert(err, IsNil)
-	c.Check(ser.Serial(), Equals, "8989")
+	// Repeated handler run but set original serial.
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(chg.Err(), IsNil)
+	device, err = devicestatetest.Device(s.state)
+	c.Check(err, IsNil)
+	c.Check(device.Serial, Equals, "9999")
 }
 
-func (s *deviceMgrSuite) TestStoreContextBackendSetDevice(c *C) {
-	s.state.Lock()
-	defer s.state.Unlock()
+func (s *deviceMgrSuite) TestDoRequestSerialErrorsOnNoHost(c *C) {
+	if os.Getenv("http_proxy") != "" {
+		c.Skip("cannot run test when http proxy is in use, the error pattern is different")
+	}
 
-	scb := s.mgr.StoreContextBackend()
+	const nonexistent_host = "nowhere.nowhere.test"
 
-	device, err := scb.Device()
-	c.Check(err, IsNil)
-	c.Check(device, DeepEquals, &auth.DeviceState{})
+	// check internet access
+	_, err := net.LookupHost(nonexistent_host)
+	if netErr, ok := err.(net.Error); !ok || netErr.Temporary() {
+		c.Skip("cannot run test with no internet access, the error pattern is different")
+	}
 
-	err = scb.SetDevice(&auth.DeviceState{Brand: "some-brand"})
-	c.Check(err, IsNil)
-	device, err = scb.Device()
-	c.Check(err, IsNil)
-	c.Check(device, DeepEquals, &auth.DeviceState{Brand: "some-brand"})
-}
+	privKey, _ := assertstest.GenerateKey(testKeyLength)
 
-func (s *deviceMgrSuite) TestStoreContextBackendModelAndSerial(c *C) {
+	nowhere := "http://" + nonexistent_host
+
+	restore := devicestate.MockBaseStoreURL(nowhere)
+	defer restore()
+
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	scb := s.mgr.StoreContextBackend()
-
-	// nothing in the state
-	_, err := scb.Model()
-	c.Check(err, Equals, state.ErrNoState)
-	_, err = scb.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
 
-	// just brand and model
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
+		KeyID: privKey.PublicKey().ID(),
 	})
-	_, err = scb.Model()
-	c.Check(err, Equals, state.ErrNoState)
-	_, err = scb.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	devicestate.KeypairManager(s.mgr).Put(privKey)
 
-	// have a model assertion
-	model := s.brands.Model("canonical", "pc", map[string]interface{}{
-		"gadget":       "pc",
-		"kernel":       "kernel",
-		"architecture": "amd64",
-	})
-	assertstatetest.AddMany(s.state, model)
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(t)
 
-	mod, err := scb.Model()
-	c.Assert(err, IsNil)
-	c.Assert(mod.BrandID(), Equals, "canonical")
+	// avoid full seeding
+	s.seeding()
 
-	_, err = scb.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
 
-	// have a serial as well
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:  "canonical",
-		Model:  "pc",
-		Serial: "8989",
-	})
-	_, err = scb.Model()
-	c.Assert(err, IsNil)
-	_, err = scb.Serial()
-	c.Check(err, Equals, state.ErrNoState)
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+}
 
-	// have a serial assertion
-	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
+func (s *deviceMgrSuite) TestDoRequestSerialMaxTentatives(c *C) {
+	privKey, _ := assertstest.GenerateKey(testKeyLength)
 
-	_, err = scb.Model()
-	c.Assert(err, IsNil)
-	ser, err := scb.Serial()
-	c.Assert(err, IsNil)
-	c.Check(ser.Serial(), Equals, "8989")
-}
+	// immediate
+	r := devicestate.MockRetryInterval(0)
+	defer r()
 
-var (
-	devKey, _ = assertstest.GenerateKey(testKeyLength)
-)
+	r = devicestate.MockMaxTentatives(2)
+	defer r()
 
-func (s *deviceMgrSuite) TestStoreContextBackendDeviceSessionRequestParams(c *C) {
-	s.state.Lock()
-	defer s.state.Unlock()
+	mockServer := s.mockServer(c, devicestatetest.ReqIDFailID501, nil)
+	defer mockServer.Close()
 
-	scb := s.mgr.StoreContextBackend()
+	restore := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer restore()
 
-	// nothing there
-	_, err := scb.SignDeviceSessionRequest(nil, "NONCE-1")
-	c.Check(err, ErrorMatches, "internal error: cannot sign a session request without a serial")
+	restore = devicestate.MockRepeatRequestSerial("after-add-serial")
+	defer restore()
 
-	// setup state as done by device initialisation
-	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
-	c.Check(err, IsNil)
-	seriala, err := s.storeSigning.Sign(asserts.SerialType, map[string]interface{}{
-		"brand-id":            "canonical",
-		"model":               "pc",
-		"serial":              "8989",
-		"device-key":          string(encDevKey),
-		"device-key-sha3-384": devKey.PublicKey().ID(),
-		"timestamp":           time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-	assertstatetest.AddMany(s.state, seriala)
-	serial := seriala.(*asserts.Serial)
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
+	s.state.Lock()
+	defer s.state.Unlock()
 
-	_, err = scb.SignDeviceSessionRequest(serial, "NONCE-1")
-	c.Check(err, ErrorMatches, "internal error: inconsistent state with serial but no device key")
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
 
-	// have a key
-	devicestate.KeypairManager(s.mgr).Put(devKey)
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
 
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:  "canonical",
-		Model:  "pc",
-		Serial: "8989",
-		KeyID:  devKey.PublicKey().ID(),
+		Brand: "canonical",
+		Model: "pc",
+		KeyID: privKey.PublicKey().ID(),
 	})
+	devicestate.KeypairManager(s.mgr).Put(privKey)
 
-	sessReq, err := scb.SignDeviceSessionRequest(serial, "NONCE-1")
-	c.Assert(err, IsNil)
-
-	// correctly signed with device key
-	err = asserts.SignatureCheck(sessReq, devKey.PublicKey())
-	c.Check(err, IsNil)
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("become-operational", "...")
+	chg.AddTask(t)
 
-	c.Check(sessReq.BrandID(), Equals, "canonical")
-	c.Check(sessReq.Model(), Equals, "pc")
-	c.Check(sessReq.Serial(), Equals, "8989")
-	c.Check(sessReq.Nonce(), Equals, "NONCE-1")
-}
+	// avoid full seeding
+	s.seeding()
 
-func (s *deviceMgrSuite) TestStoreContextBackendProxyStore(c *C) {
-	mockServer := s.mockServer(c, "", nil)
-	defer mockServer.Close()
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
 	s.state.Lock()
-	defer s.state.Unlock()
-
-	scb := s.mgr.StoreContextBackend()
 
-	// nothing in the state
-	_, err := scb.ProxyStore()
-	c.Check(err, Equals, state.ErrNoState)
+	c.Check(chg.Status(), Equals, state.DoingStatus)
 
-	// have a store referenced
-	tr := config.NewTransaction(s.state)
-	err = tr.Set("core", "proxy.store", "foo")
-	tr.Commit()
-	c.Assert(err, IsNil)
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
 
-	_, err = scb.ProxyStore()
-	c.Check(err, Equals, state.ErrNoState)
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot retrieve request-id for making a request for a serial: unexpected status 501.*`)
+}
 
-	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
+func (s *deviceMgrSuite) TestFullDeviceRegistrationPollHappy(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
 
-	// have a store assertion.
-	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
-		"store":       "foo",
-		"operator-id": operatorAcct.AccountID(),
-		"url":         mockServer.URL,
-		"timestamp":   time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
+	mockServer := s.mockServer(c, devicestatetest.ReqIDPoll, nil)
+	defer mockServer.Close()
 
-	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
 
-	sto, err := scb.ProxyStore()
-	c.Assert(err, IsNil)
-	c.Assert(sto.Store(), Equals, "foo")
-	c.Assert(sto.URL().String(), Equals, mockServer.URL)
-}
+	// immediately
+	r3 := devicestate.MockRetryInterval(0)
+	defer r3()
 
-func (s *deviceMgrSuite) TestInitialRegistrationContext(c *C) {
+	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	// have a model assertion
-	model, err := s.storeSigning.Sign(asserts.ModelType, map[string]interface{}{
-		"series":       "16",
-		"brand-id":     "canonical",
-		"model":        "pc",
-		"gadget":       "pc-gadget",
-		"kernel":       "kernel",
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
-		"timestamp":    time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-	err = assertstate.Add(s.state, model)
-	c.Assert(err, IsNil)
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
 	})
 
-	// TODO: will need to pass in a task later
-	regCtx, err := devicestate.RegistrationCtx(s.mgr, nil)
-	c.Assert(err, IsNil)
-	c.Assert(regCtx, NotNil)
-
-	c.Check(regCtx.ForRemodeling(), Equals, false)
-
-	device, err := regCtx.Device()
-	c.Check(err, IsNil)
-	c.Check(device, DeepEquals, &auth.DeviceState{
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
 	})
 
-	c.Check(regCtx.GadgetForSerialRequestConfig(), Equals, "pc-gadget")
-	c.Check(regCtx.SerialRequestExtraHeaders(), HasLen, 0)
-	c.Check(regCtx.SerialRequestAncillaryAssertions(), HasLen, 0)
-
-}
-
-func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlAlreadySeeded(c *C) {
-	s.state.Lock()
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
 	s.state.Set("seeded", true)
+
+	// runs the whole device registration process with polling
 	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
 
-	called := false
-	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
-		called = true
-		return nil, nil
-	})
-	defer restore()
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
 
-	err := devicestate.EnsureSeedYaml(s.mgr)
-	c.Assert(err, IsNil)
-	c.Assert(called, Equals, false)
-}
+	// needs 3 more Retry passes of polling
+	for i := 0; i < 3; i++ {
+		s.state.Unlock()
+		s.settle(c)
+		s.state.Lock()
+	}
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlChangeInFlight(c *C) {
-	s.state.Lock()
-	chg := s.state.NewChange("seed", "just for testing")
-	chg.AddTask(s.state.NewTask("test-task", "the change needs a task"))
-	s.state.Unlock()
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
 
-	called := false
-	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
-		called = true
-		return nil, nil
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Brand, Equals, "canonical")
+	c.Check(device.Model, Equals, "pc")
+	c.Check(device.Serial, Equals, "10002")
+
+	a, err := s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "canonical",
+		"model":    "pc",
+		"serial":   "10002",
 	})
-	defer restore()
+	c.Assert(err, IsNil)
+	serial := a.(*asserts.Serial)
 
-	err := devicestate.EnsureSeedYaml(s.mgr)
+	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
 	c.Assert(err, IsNil)
-	c.Assert(called, Equals, false)
+	c.Check(privKey, NotNil)
+
+	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlAlsoOnClassic(c *C) {
-	release.OnClassic = true
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyPrepareDeviceHook(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
 
-	called := false
-	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
-		called = true
-		return nil, nil
-	})
-	defer restore()
-
-	err := devicestate.EnsureSeedYaml(s.mgr)
-	c.Assert(err, IsNil)
-	c.Assert(called, Equals, true)
-}
-
-func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlHappy(c *C) {
-	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) (ts []*state.TaskSet, err error) {
-		t := s.state.NewTask("test-task", "a random task")
-		ts = append(ts, state.NewTaskSet(t))
-		return ts, nil
-	})
-	defer restore()
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		RequestIDURLPath: "/svc/request-id",
+		SerialURLPath:    "/svc/serial",
+	}
+	bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
+	}
 
-	err := devicestate.EnsureSeedYaml(s.mgr)
-	c.Assert(err, IsNil)
+	mockServer := s.mockServer(c, "REQID-1", bhv)
+	defer mockServer.Close()
 
+	// setup state as will be done by first-boot
+	// & have a gadget with a prepare-device hook
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	c.Check(s.state.Changes(), HasLen, 1)
-}
+	pDBhv := &devicestatetest.PrepareDeviceBehavior{
+		DeviceSvcURL: mockServer.URL + "/svc/",
+		Headers: map[string]string{
+			"x-extra-header": "extra",
+		},
+		RegBody: map[string]string{
+			"mac": "00:00:00:00:ff:00",
+		},
+		ProposedSerial: "Y9999",
+	}
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkSkippedOnClassic(c *C) {
-	release.OnClassic = true
+	r2 := devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), pDBhv)
+	defer r2()
 
-	err := devicestate.EnsureBootOk(s.mgr)
-	c.Assert(err, IsNil)
-}
+	// as device-service.url is set, should not need to do this but just in case
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baad/")
+	defer r3()
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkBootloaderHappy(c *C) {
-	s.bootloader.SetBootVars(map[string]string{
-		"snap_mode":     "trying",
-		"snap_try_core": "core_1.snap",
+	s.makeModelAssertionInState(c, "canonical", "pc2", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "gadget",
 	})
 
-	s.state.Lock()
-	defer s.state.Unlock()
-	siCore1 := &snap.SideInfo{RealName: "core", Revision: snap.R(1)}
-	snapstate.Set(s.state, "core", &snapstate.SnapState{
-		SnapType: "os",
-		Active:   true,
-		Sequence: []*snap.SideInfo{siCore1},
-		Current:  siCore1.Revision,
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc2",
 	})
 
+	// avoid full seeding
+	s.seeding()
+
+	// runs the whole device registration process, note that the
+	// device is not seeded yet
 	s.state.Unlock()
-	err := devicestate.EnsureBootOk(s.mgr)
+	s.settle(c)
 	s.state.Lock()
-	c.Assert(err, IsNil)
-
-	m, err := s.bootloader.GetBootVars("snap_mode")
-	c.Assert(err, IsNil)
-	c.Assert(m, DeepEquals, map[string]string{"snap_mode": ""})
-}
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkUpdateBootRevisionsHappy(c *C) {
-	// simulate that we have a new core_2, tried to boot it but that failed
-	s.bootloader.SetBootVars(map[string]string{
-		"snap_mode":     "",
-		"snap_kernel":   "kernel_1.snap",
-		"snap_try_core": "core_2.snap",
-		"snap_core":     "core_1.snap",
-	})
+	// without a seeded device, there is no become-operational change
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, IsNil)
 
+	// now mark it as seeded
+	s.state.Set("seeded", true)
+	// and run the device registration again
+	s.state.Unlock()
+	s.settle(c)
 	s.state.Lock()
-	defer s.state.Unlock()
-	siKernel1 := &snap.SideInfo{RealName: "kernel", Revision: snap.R(1)}
-	snapstate.Set(s.state, "kernel", &snapstate.SnapState{
-		SnapType: "kernel",
-		Active:   true,
-		Sequence: []*snap.SideInfo{siKernel1},
-		Current:  siKernel1.Revision,
-	})
 
-	siCore1 := &snap.SideInfo{RealName: "core", Revision: snap.R(1)}
-	siCore2 := &snap.SideInfo{RealName: "core", Revision: snap.R(2)}
-	snapstate.Set(s.state, "core", &snapstate.SnapState{
-		SnapType: "os",
-		Active:   true,
-		Sequence: []*snap.SideInfo{siCore1, siCore2},
-		Current:  siCore2.Revision,
-	})
+	becomeOperational = s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
 
-	s.state.Unlock()
-	err := devicestate.EnsureBootOk(s.mgr)
-	s.state.Lock()
-	c.Assert(err, IsNil)
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
 
-	c.Check(s.state.Changes(), HasLen, 1)
-	c.Check(s.state.Changes()[0].Kind(), Equals, "update-revisions")
-}
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Brand, Equals, "canonical")
+	c.Check(device.Model, Equals, "pc2")
+	c.Check(device.Serial, Equals, "Y9999")
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkNotRunAgain(c *C) {
-	s.bootloader.SetBootVars(map[string]string{
-		"snap_mode":     "trying",
-		"snap_try_core": "core_1.snap",
+	a, err := s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "canonical",
+		"model":    "pc2",
+		"serial":   "Y9999",
 	})
-	s.bootloader.SetErr = fmt.Errorf("ensure bootloader is not used")
-
-	devicestate.SetBootOkRan(s.mgr, true)
+	c.Assert(err, IsNil)
+	serial := a.(*asserts.Serial)
 
-	err := devicestate.EnsureBootOk(s.mgr)
+	var details map[string]interface{}
+	err = yaml.Unmarshal(serial.Body(), &details)
 	c.Assert(err, IsNil)
-}
 
-func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkError(c *C) {
-	s.state.Lock()
-	// seeded
-	s.state.Set("seeded", true)
-	// has serial
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:  "canonical",
-		Model:  "pc",
-		Serial: "8989",
+	c.Check(details, DeepEquals, map[string]interface{}{
+		"mac": "00:00:00:00:ff:00",
 	})
-	s.state.Unlock()
-
-	s.bootloader.GetErr = fmt.Errorf("bootloader err")
 
-	devicestate.SetBootOkRan(s.mgr, false)
+	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
+	c.Assert(err, IsNil)
+	c.Check(privKey, NotNil)
 
-	err := s.mgr.Ensure()
-	c.Assert(err, ErrorMatches, "devicemgr: bootloader err")
+	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) setupBrands(c *C) {
-	assertstatetest.AddMany(s.state, s.brands.AccountsAndKeys("my-brand")...)
-	otherAcct := assertstest.NewAccount(s.storeSigning, "other-brand", map[string]interface{}{
-		"account-id": "other-brand",
-	}, "")
-	assertstatetest.AddMany(s.state, otherAcct)
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithHookAndNewProxy(c *C) {
+	s.testFullDeviceRegistrationHappyWithHookAndProxy(c, true)
 }
 
-func (s *deviceMgrSuite) setupSnapDecl(c *C, info *snap.Info, publisherID string) {
-	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
-		"series":       "16",
-		"snap-name":    info.SnapName(),
-		"snap-id":      info.SnapID,
-		"publisher-id": publisherID,
-		"timestamp":    time.Now().UTC().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-	assertstatetest.AddMany(s.state, snapDecl)
+func (s *deviceMgrSuite) TestFullDeviceRegistrationHappyWithHookAndOldProxy(c *C) {
+	s.testFullDeviceRegistrationHappyWithHookAndProxy(c, false)
 }
 
-func fakeMyModel(extra map[string]interface{}) *asserts.Model {
-	model := map[string]interface{}{
-		"type":         "model",
-		"authority-id": "my-brand",
-		"series":       "16",
-		"brand-id":     "my-brand",
-		"model":        "my-model",
+func (s *deviceMgrSuite) testFullDeviceRegistrationHappyWithHookAndProxy(c *C, newEnough bool) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	var reqID string
+	var storeVersion string
+	head := func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Snap-Store-Version", storeVersion)
+	}
+	bhv := &devicestatetest.DeviceServiceBehavior{
+		Head: head,
+	}
+	svcPath := "/svc/"
+	if newEnough {
+		reqID = "REQID-42"
+		storeVersion = "6"
+		bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
+			c.Check(r.Header.Get("X-Snap-Device-Service-URL"), Matches, "http://[^/]*/bad/svc/")
+			c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
+		}
+		svcPath = "/bad/svc/"
+	} else {
+		reqID = "REQID-41"
+		storeVersion = "5"
+		bhv.RequestIDURLPath = "/svc/request-id"
+		bhv.SerialURLPath = "/svc/serial"
+		bhv.PostPreflight = func(c *C, bhv *devicestatetest.DeviceServiceBehavior, w http.ResponseWriter, r *http.Request) {
+			c.Check(r.Header.Get("X-Extra-Header"), Equals, "extra")
+		}
 	}
-	return assertstest.FakeAssertion(model, extra).(*asserts.Model)
-}
 
-func (s *deviceMgrSuite) TestCheckGadget(c *C) {
+	mockServer := s.mockServer(c, reqID, bhv)
+	defer mockServer.Close()
+
+	// setup state as will be done by first-boot
+	// & have a gadget with a prepare-device hook
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: other-gadget, version: 0}", nil)
-
-	s.setupBrands(c)
-	// model assertion in device context
-	model := fakeMyModel(map[string]interface{}{
-		"architecture": "amd64",
-		"gadget":       "gadget",
-		"kernel":       "krnl",
-	})
-	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+	pDBhv := &devicestatetest.PrepareDeviceBehavior{
+		DeviceSvcURL: mockServer.URL + svcPath,
+		Headers: map[string]string{
+			"x-extra-header": "extra",
+		},
+	}
+	r2 := devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), pDBhv)
+	defer r2()
 
-	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install gadget "other-gadget", model assertion requests "gadget"`)
+	// as device-service.url is set, should not need to do this but just in case
+	r3 := devicestate.MockBaseStoreURL(mockServer.URL + "/direct/baad/")
+	defer r3()
 
-	// brand gadget
-	brandGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	brandGadgetInfo.SnapID = "brand-gadget-id"
-	s.setupSnapDecl(c, brandGadgetInfo, "my-brand")
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "proxy.store", "foo"), IsNil)
+	tr.Commit()
+	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
 
-	// canonical gadget
-	canonicalGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	canonicalGadgetInfo.SnapID = "canonical-gadget-id"
-	s.setupSnapDecl(c, canonicalGadgetInfo, "canonical")
+	// have a store assertion.
+	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "foo",
+		"url":         mockServer.URL,
+		"operator-id": operatorAcct.AccountID(),
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
 
-	// other gadget
-	otherGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	otherGadgetInfo.SnapID = "other-gadget-id"
-	s.setupSnapDecl(c, otherGadgetInfo, "other-brand")
+	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
 
-	// install brand gadget ok
-	err = devicestate.CheckGadgetOrKernel(s.state, brandGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
+	s.makeModelAssertionInState(c, "canonical", "pc2", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "gadget",
+	})
 
-	// install canonical gadget ok
-	err = devicestate.CheckGadgetOrKernel(s.state, canonicalGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc2",
+	})
 
-	// install other gadget fails
-	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install gadget "gadget" published by "other-brand" for model by "my-brand"`)
+	// mark it as seeded
+	s.state.Set("seeded", true)
 
-	// unasserted installation of other works
-	otherGadgetInfo.SnapID = ""
-	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
+	// runs the whole device registration process
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
 
-	// parallel install fails
-	otherGadgetInfo.InstanceKey = "foo"
-	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install "gadget_foo", parallel installation of kernel or gadget snaps is not supported`)
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Brand, Equals, "canonical")
+	c.Check(device.Model, Equals, "pc2")
+	c.Check(device.Serial, Equals, "9999")
+
+	a, err := s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "canonical",
+		"model":    "pc2",
+		"serial":   "9999",
+	})
+	c.Assert(err, IsNil)
+	serial := a.(*asserts.Serial)
+
+	privKey, err := devicestate.KeypairManager(s.mgr).Get(serial.DeviceKey().ID())
+	c.Assert(err, IsNil)
+	c.Check(privKey, NotNil)
+
+	c.Check(device.KeyID, Equals, privKey.PublicKey().ID())
 }
 
-func (s *deviceMgrSuite) TestCheckGadgetOnClassic(c *C) {
-	release.OnClassic = true
+func (s *deviceMgrSuite) TestFullDeviceRegistrationErrorBackoff(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	bhv := &devicestatetest.DeviceServiceBehavior{}
+	mockServer := s.mockServer(c, devicestatetest.ReqIDBadRequest, bhv)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
 
+	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: other-gadget, version: 0}", nil)
+	// sanity
+	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 0)
 
-	s.setupBrands(c)
-	// model assertion in device context
-	model := fakeMyModel(map[string]interface{}{
-		"classic": "true",
-		"gadget":  "gadget",
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
 	})
-	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
 
-	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install gadget "other-gadget", model assertion requests "gadget"`)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
 
-	// brand gadget
-	brandGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	brandGadgetInfo.SnapID = "brand-gadget-id"
-	s.setupSnapDecl(c, brandGadgetInfo, "my-brand")
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+	// mark as seeded
+	s.state.Set("seeded", true)
 
-	// canonical gadget
-	canonicalGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	canonicalGadgetInfo.SnapID = "canonical-gadget-id"
-	s.setupSnapDecl(c, canonicalGadgetInfo, "canonical")
+	// try the whole device registration process
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
 
-	// other gadget
-	otherGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
-	otherGadgetInfo.SnapID = "other-gadget-id"
-	s.setupSnapDecl(c, otherGadgetInfo, "other-brand")
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
+	firstTryID := becomeOperational.ID()
 
-	// install brand gadget ok
-	err = devicestate.CheckGadgetOrKernel(s.state, brandGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*cannot deliver device serial request: bad serial-request.*`)
 
-	// install canonical gadget ok
-	err = devicestate.CheckGadgetOrKernel(s.state, canonicalGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.KeyID, Not(Equals), "")
+	keyID := device.KeyID
 
-	// install other gadget fails
-	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install gadget "gadget" published by "other-brand" for model by "my-brand"`)
+	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, time.Now()), Equals, true)
+	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, time.Now().Add(6*time.Minute)), Equals, false)
+	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 1)
 
-	// unasserted installation of other works
-	otherGadgetInfo.SnapID = ""
-	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
-}
+	// try again the whole device registration process
+	bhv.ReqID = "REQID-1"
+	devicestate.SetLastBecomeOperationalAttempt(s.mgr, time.Now().Add(-15*time.Minute))
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
 
-func (s *deviceMgrSuite) TestCheckGadgetOnClassicGadgetNotSpecified(c *C) {
-	release.OnClassic = true
+	becomeOperational = s.findBecomeOperationalChange(firstTryID)
+	c.Assert(becomeOperational, NotNil)
 
-	s.state.Lock()
-	defer s.state.Unlock()
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), IsNil)
 
-	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 2)
 
-	s.setupBrands(c)
-	// model assertion in device context
-	model := fakeMyModel(map[string]interface{}{
-		"classic": "true",
-	})
-	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+	device, err = devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.KeyID, Equals, keyID)
+	c.Check(device.Serial, Equals, "10000")
+}
 
-	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install gadget snap on classic if not requested by the model`)
+func (s *deviceMgrSuite) TestEnsureBecomeOperationalShouldBackoff(c *C) {
+	t0 := time.Now()
+	c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t0), Equals, false)
+	c.Check(devicestate.BecomeOperationalBackoff(s.mgr), Equals, 5*time.Minute)
+
+	backoffs := []time.Duration{5, 10, 20, 40, 80, 160, 320, 640, 1440, 1440}
+	t1 := t0
+	for _, m := range backoffs {
+		c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t1.Add(time.Duration(m-1)*time.Minute)), Equals, true)
+
+		t1 = t1.Add(time.Duration(m+1) * time.Minute)
+		c.Check(devicestate.EnsureOperationalShouldBackoff(s.mgr, t1), Equals, false)
+		m *= 2
+		if m > (12 * 60) {
+			m = 24 * 60
+		}
+		c.Check(devicestate.BecomeOperationalBackoff(s.mgr), Equals, m*time.Minute)
+	}
 }
 
-func (s *deviceMgrSuite) TestCheckKernel(c *C) {
+func (s *deviceMgrSuite) TestFullDeviceRegistrationMismatchedSerial(c *C) {
+	r1 := devicestate.MockKeyLength(testKeyLength)
+	defer r1()
+
+	mockServer := s.mockServer(c, devicestatetest.ReqIDSerialWithBadModel, nil)
+	defer mockServer.Close()
+
+	r2 := devicestate.MockBaseStoreURL(mockServer.URL)
+	defer r2()
+
+	// setup state as will be done by first-boot
 	s.state.Lock()
 	defer s.state.Unlock()
-	kernelInfo := snaptest.MockInfo(c, "{type: kernel, name: lnrk, version: 0}", nil)
 
-	// not on classic
-	release.OnClassic = true
-	err := devicestate.CheckGadgetOrKernel(s.state, kernelInfo, nil, snapstate.Flags{}, nil)
-	c.Check(err, ErrorMatches, `cannot install a kernel snap on classic`)
-	release.OnClassic = false
+	// sanity
+	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 0)
 
-	s.setupBrands(c)
-	// model assertion in device context
-	model := fakeMyModel(map[string]interface{}{
+	devicestatetest.MockGadget(c, s.state, "gadget", snap.R(2), nil)
+
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
+		"kernel":       "pc-kernel",
 		"gadget":       "gadget",
-		"kernel":       "krnl",
 	})
-	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
 
-	err = devicestate.CheckGadgetOrKernel(s.state, kernelInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install kernel "lnrk", model assertion requests "krnl"`)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
 
-	// brand kernel
-	brandKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
-	brandKrnlInfo.SnapID = "brand-krnl-id"
-	s.setupSnapDecl(c, brandKrnlInfo, "my-brand")
+	// mark as seeded
+	s.state.Set("seeded", true)
 
-	// canonical kernel
-	canonicalKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
-	canonicalKrnlInfo.SnapID = "canonical-krnl-id"
-	s.setupSnapDecl(c, canonicalKrnlInfo, "canonical")
+	// try the whole device registration process
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
 
-	// other kernel
-	otherKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
-	otherKrnlInfo.SnapID = "other-krnl-id"
-	s.setupSnapDecl(c, otherKrnlInfo, "other-brand")
-
-	// install brand kernel ok
-	err = devicestate.CheckGadgetOrKernel(s.state, brandKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
-
-	// install canonical kernel ok
-	err = devicestate.CheckGadgetOrKernel(s.state, canonicalKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
-
-	// install other kernel fails
-	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install kernel "krnl" published by "other-brand" for model by "my-brand"`)
-
-	// unasserted installation of other works
-	otherKrnlInfo.SnapID = ""
-	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, IsNil)
-
-	// parallel install fails
-	otherKrnlInfo.InstanceKey = "foo"
-	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
-	c.Check(err, ErrorMatches, `cannot install "krnl_foo", parallel installation of kernel or gadget snaps is not supported`)
-}
-
-func (s *deviceMgrSuite) makeModelAssertionInState(c *C, brandID, model string, extras map[string]interface{}) {
-	modelAs := s.brands.Model(brandID, model, extras)
-
-	s.setupBrands(c)
-	assertstatetest.AddMany(s.state, modelAs)
-}
-
-func makeSerialAssertionInState(c *C, brands *assertstest.SigningAccounts, st *state.State, brandID, model, serialN string) *asserts.Serial {
-	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
-	c.Assert(err, IsNil)
-	serial, err := brands.Signing(brandID).Sign(asserts.SerialType, map[string]interface{}{
-		"brand-id":            brandID,
-		"model":               model,
-		"serial":              serialN,
-		"device-key":          string(encDevKey),
-		"device-key-sha3-384": devKey.PublicKey().ID(),
-		"timestamp":           time.Now().Format(time.RFC3339),
-	}, nil, "")
-	c.Assert(err, IsNil)
-	err = assertstate.Add(st, serial)
-	c.Assert(err, IsNil)
-	return serial.(*asserts.Serial)
-}
+	becomeOperational := s.findBecomeOperationalChange()
+	c.Assert(becomeOperational, NotNil)
 
-func (s *deviceMgrSuite) makeSerialAssertionInState(c *C, brandID, model, serialN string) {
-	makeSerialAssertionInState(c, s.brands, s.state, brandID, model, serialN)
+	c.Check(becomeOperational.Status().Ready(), Equals, true)
+	c.Check(becomeOperational.Err(), ErrorMatches, `(?s).*obtained serial assertion does not match provided device identity information.*`)
 }
 
-func (s *deviceMgrSuite) TestCanAutoRefreshOnCore(c *C) {
+func (s *deviceMgrSuite) TestModelAndSerial(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
+	// nothing in the state
+	_, err := s.mgr.Model()
+	c.Check(err, Equals, state.ErrNoState)
+	_, err = s.mgr.Serial()
+	c.Check(err, Equals, state.ErrNoState)
 
-	canAutoRefresh := func() bool {
-		ok, err := devicestate.CanAutoRefresh(s.state)
-		c.Assert(err, IsNil)
-		return ok
-	}
-
-	// not seeded, no model, no serial -> no auto-refresh
-	s.state.Set("seeded", false)
-	c.Check(canAutoRefresh(), Equals, false)
-
-	// seeded, model, no serial -> no auto-refresh
-	s.state.Set("seeded", true)
+	// just brand and model
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
 	})
-	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
+	_, err = s.mgr.Model()
+	c.Check(err, Equals, state.ErrNoState)
+	_, err = s.mgr.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a model assertion
+	model := s.brands.Model("canonical", "pc", map[string]interface{}{
 		"gadget":       "pc",
+		"kernel":       "kernel",
+		"architecture": "amd64",
 	})
-	c.Check(canAutoRefresh(), Equals, false)
+	assertstatetest.AddMany(s.state, model)
 
-	// seeded, model, serial -> auto-refresh
+	mod, err := s.mgr.Model()
+	c.Assert(err, IsNil)
+	c.Assert(mod.BrandID(), Equals, "canonical")
+
+	_, err = s.mgr.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a serial as well
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand:  "canonical",
 		Model:  "pc",
 		Serial: "8989",
 	})
+	_, err = s.mgr.Model()
+	c.Assert(err, IsNil)
+	_, err = s.mgr.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a serial assertion
 	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
-	c.Check(canAutoRefresh(), Equals, true)
 
-	// not seeded, model, serial -> no auto-refresh
-	s.state.Set("seeded", false)
-	c.Check(canAutoRefresh(), Equals, false)
+	_, err = s.mgr.Model()
+	c.Assert(err, IsNil)
+	ser, err := s.mgr.Serial()
+	c.Assert(err, IsNil)
+	c.Check(ser.Serial(), Equals, "8989")
 }
 
-func (s *deviceMgrSuite) TestCanAutoRefreshNoSerialFallback(c *C) {
+func (s *deviceMgrSuite) TestStoreContextBackendSetDevice(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	canAutoRefresh := func() bool {
-		ok, err := devicestate.CanAutoRefresh(s.state)
-		c.Assert(err, IsNil)
-		return ok
-	}
+	scb := s.mgr.StoreContextBackend()
 
-	// seeded, model, no serial, two attempts at getting serial
-	// -> no auto-refresh
-	devicestate.IncEnsureOperationalAttempts(s.state)
-	devicestate.IncEnsureOperationalAttempts(s.state)
-	s.state.Set("seeded", true)
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc",
-	})
-	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
-	})
-	c.Check(canAutoRefresh(), Equals, false)
+	device, err := scb.Device()
+	c.Check(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{})
 
-	// third attempt ongoing, or done
-	// fallback, try auto-refresh
-	devicestate.IncEnsureOperationalAttempts(s.state)
-	// sanity
-	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 3)
-	c.Check(canAutoRefresh(), Equals, true)
+	err = scb.SetDevice(&auth.DeviceState{Brand: "some-brand"})
+	c.Check(err, IsNil)
+	device, err = scb.Device()
+	c.Check(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{Brand: "some-brand"})
 }
 
-func (s *deviceMgrSuite) TestCanAutoRefreshOnClassic(c *C) {
-	release.OnClassic = true
-
+func (s *deviceMgrSuite) TestStoreContextBackendModelAndSerial(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	canAutoRefresh := func() bool {
-		ok, err := devicestate.CanAutoRefresh(s.state)
-		c.Assert(err, IsNil)
-		return ok
-	}
-
-	// not seeded, no model, no serial -> no auto-refresh
-	s.state.Set("seeded", false)
-	c.Check(canAutoRefresh(), Equals, false)
+	scb := s.mgr.StoreContextBackend()
 
-	// seeded, no model -> auto-refresh
-	s.state.Set("seeded", true)
-	c.Check(canAutoRefresh(), Equals, false)
+	// nothing in the state
+	_, err := scb.Model()
+	c.Check(err, Equals, state.ErrNoState)
+	_, err = scb.Serial()
+	c.Check(err, Equals, state.ErrNoState)
 
-	// seeded, model, no serial -> no auto-refresh
+	// just brand and model
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
 		Model: "pc",
 	})
-	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
-		"classic": "true",
+	_, err = scb.Model()
+	c.Check(err, Equals, state.ErrNoState)
+	_, err = scb.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a model assertion
+	model := s.brands.Model("canonical", "pc", map[string]interface{}{
+		"gadget":       "pc",
+		"kernel":       "kernel",
+		"architecture": "amd64",
 	})
-	c.Check(canAutoRefresh(), Equals, false)
+	assertstatetest.AddMany(s.state, model)
 
-	// seeded, model, serial -> auto-refresh
+	mod, err := scb.Model()
+	c.Assert(err, IsNil)
+	c.Assert(mod.BrandID(), Equals, "canonical")
+
+	_, err = scb.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a serial as well
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand:  "canonical",
 		Model:  "pc",
 		Serial: "8989",
 	})
+	_, err = scb.Model()
+	c.Assert(err, IsNil)
+	_, err = scb.Serial()
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a serial assertion
 	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
-	c.Check(canAutoRefresh(), Equals, true)
 
-	// not seeded, model, serial -> no auto-refresh
-	s.state.Set("seeded", false)
-	c.Check(canAutoRefresh(), Equals, false)
+	_, err = scb.Model()
+	c.Assert(err, IsNil)
+	ser, err := scb.Serial()
+	c.Assert(err, IsNil)
+	c.Check(ser.Serial(), Equals, "8989")
 }
 
-func makeInstalledMockCoreSnapWithSnapdControl(c *C, st *state.State) *snap.Info {
-	sideInfoCore11 := &snap.SideInfo{RealName: "core", Revision: snap.R(11), SnapID: "core-id"}
-	snapstate.Set(st, "core", &snapstate.SnapState{
-		Active:   true,
-		Sequence: []*snap.SideInfo{sideInfoCore11},
-		Current:  sideInfoCore11.Revision,
-		SnapType: "os",
-	})
-	core11 := snaptest.MockSnap(c, `
-name: core
-version: 1.0
-slots:
- snapd-control:
-`, sideInfoCore11)
-	c.Assert(core11.Slots, HasLen, 1)
+var (
+	devKey, _ = assertstest.GenerateKey(testKeyLength)
+)
 
-	return core11
-}
+func (s *deviceMgrSuite) TestStoreContextBackendDeviceSessionRequestParams(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
 
-var snapWithSnapdControlRefreshScheduleManagedYAML = `
-name: snap-with-snapd-control
-version: 1.0
-plugs:
- snapd-control:
-  refresh-schedule: managed
-`
+	scb := s.mgr.StoreContextBackend()
 
-var snapWithSnapdControlOnlyYAML = `
-name: snap-with-snapd-control
-version: 1.0
-plugs:
- snapd-control:
-`
+	// nothing there
+	_, err := scb.SignDeviceSessionRequest(nil, "NONCE-1")
+	c.Check(err, ErrorMatches, "internal error: cannot sign a session request without a serial")
 
-func makeInstalledMockSnap(c *C, st *state.State, yml string) *snap.Info {
-	sideInfo11 := &snap.SideInfo{RealName: "snap-with-snapd-control", Revision: snap.R(11), SnapID: "snap-with-snapd-control-id"}
-	snapstate.Set(st, "snap-with-snapd-control", &snapstate.SnapState{
-		Active:   true,
-		Sequence: []*snap.SideInfo{sideInfo11},
-		Current:  sideInfo11.Revision,
-		SnapType: "app",
-	})
-	info11 := snaptest.MockSnap(c, yml, sideInfo11)
-	c.Assert(info11.Plugs, HasLen, 1)
+	// setup state as done by device initialisation
+	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
+	c.Check(err, IsNil)
+	seriala, err := s.storeSigning.Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            "canonical",
+		"model":               "pc",
+		"serial":              "8989",
+		"device-key":          string(encDevKey),
+		"device-key-sha3-384": devKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	assertstatetest.AddMany(s.state, seriala)
+	serial := seriala.(*asserts.Serial)
 
-	return info11
-}
+	_, err = scb.SignDeviceSessionRequest(serial, "NONCE-1")
+	c.Check(err, ErrorMatches, "internal error: inconsistent state with serial but no device key")
 
-func makeMockRepoWithConnectedSnaps(c *C, st *state.State, info11, core11 *snap.Info, ifname string) {
-	repo := interfaces.NewRepository()
-	for _, iface := range builtin.Interfaces() {
-		err := repo.AddInterface(iface)
-		c.Assert(err, IsNil)
-	}
-	err := repo.AddSnap(info11)
-	c.Assert(err, IsNil)
-	err = repo.AddSnap(core11)
-	c.Assert(err, IsNil)
-	_, err = repo.Connect(&interfaces.ConnRef{
-		PlugRef: interfaces.PlugRef{Snap: info11.InstanceName(), Name: ifname},
-		SlotRef: interfaces.SlotRef{Snap: core11.InstanceName(), Name: ifname},
-	}, nil, nil, nil, nil, nil)
-	c.Assert(err, IsNil)
-	conns, err := repo.Connected("snap-with-snapd-control", "snapd-control")
+	// have a key
+	devicestate.KeypairManager(s.mgr).Put(devKey)
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "8989",
+		KeyID:  devKey.PublicKey().ID(),
+	})
+
+	sessReq, err := scb.SignDeviceSessionRequest(serial, "NONCE-1")
 	c.Assert(err, IsNil)
-	c.Assert(conns, HasLen, 1)
-	ifacerepo.Replace(st, repo)
+
+	// correctly signed with device key
+	err = asserts.SignatureCheck(sessReq, devKey.PublicKey())
+	c.Check(err, IsNil)
+
+	c.Check(sessReq.BrandID(), Equals, "canonical")
+	c.Check(sessReq.Model(), Equals, "pc")
+	c.Check(sessReq.Serial(), Equals, "8989")
+	c.Check(sessReq.Nonce(), Equals, "NONCE-1")
 }
 
-func (s *deviceMgrSuite) TestCanManageRefreshes(c *C) {
-	st := s.state
-	st.Lock()
-	defer st.Unlock()
+func (s *deviceMgrSuite) TestStoreContextBackendProxyStore(c *C) {
+	mockServer := s.mockServer(c, "", nil)
+	defer mockServer.Close()
+	s.state.Lock()
+	defer s.state.Unlock()
 
-	// not possbile to manage by default
-	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+	scb := s.mgr.StoreContextBackend()
 
-	// not possible with just a snap with "snapd-control" plug with the
-	// right attribute
-	info11 := makeInstalledMockSnap(c, st, snapWithSnapdControlRefreshScheduleManagedYAML)
-	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+	// nothing in the state
+	_, err := scb.ProxyStore()
+	c.Check(err, Equals, state.ErrNoState)
 
-	// not possible with a core snap with snapd control
-	core11 := makeInstalledMockCoreSnapWithSnapdControl(c, st)
-	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+	// have a store referenced
+	tr := config.NewTransaction(s.state)
+	err = tr.Set("core", "proxy.store", "foo")
+	tr.Commit()
+	c.Assert(err, IsNil)
 
-	// not possible even with connected interfaces
-	makeMockRepoWithConnectedSnaps(c, st, info11, core11, "snapd-control")
-	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+	_, err = scb.ProxyStore()
+	c.Check(err, Equals, state.ErrNoState)
 
-	// if all of the above plus a snap declaration are in place we can
-	// manage schedules
-	s.setupSnapDecl(c, info11, "canonical")
-	c.Check(devicestate.CanManageRefreshes(st), Equals, true)
+	operatorAcct := assertstest.NewAccount(s.storeSigning, "foo-operator", nil, "")
 
-	// works if the snap is not active as well (to fix race when a
-	// snap is refreshed)
-	var sideInfo11 snapstate.SnapState
-	err := snapstate.Get(st, "snap-with-snapd-control", &sideInfo11)
+	// have a store assertion.
+	stoAs, err := s.storeSigning.Sign(asserts.StoreType, map[string]interface{}{
+		"store":       "foo",
+		"operator-id": operatorAcct.AccountID(),
+		"url":         mockServer.URL,
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}, nil, "")
 	c.Assert(err, IsNil)
-	sideInfo11.Active = false
-	snapstate.Set(st, "snap-with-snapd-control", &sideInfo11)
-	c.Check(devicestate.CanManageRefreshes(st), Equals, true)
-}
-
-func (s *deviceMgrSuite) TestCanManageRefreshesNoRefreshScheduleManaged(c *C) {
-	st := s.state
-	st.Lock()
-	defer st.Unlock()
 
-	// just having a connected "snapd-control" interface is not enough
-	// for setting refresh.schedule=managed
-	info11 := makeInstalledMockSnap(c, st, snapWithSnapdControlOnlyYAML)
-	core11 := makeInstalledMockCoreSnapWithSnapdControl(c, st)
-	makeMockRepoWithConnectedSnaps(c, st, info11, core11, "snapd-control")
-	s.setupSnapDecl(c, info11, "canonical")
+	assertstatetest.AddMany(s.state, operatorAcct, stoAs)
 
-	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+	sto, err := scb.ProxyStore()
+	c.Assert(err, IsNil)
+	c.Assert(sto.Store(), Equals, "foo")
+	c.Assert(sto.URL().String(), Equals, mockServer.URL)
 }
 
-func (s *deviceMgrSuite) TestReloadRegistered(c *C) {
-	st := state.New(nil)
+func (s *deviceMgrSuite) TestInitialRegistrationContext(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
 
-	runner1 := state.NewTaskRunner(st)
-	hookMgr1, err := hookstate.Manager(st, runner1)
+	// have a model assertion
+	model, err := s.storeSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     "canonical",
+		"model":        "pc",
+		"gadget":       "pc-gadget",
+		"kernel":       "kernel",
+		"architecture": "amd64",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
 	c.Assert(err, IsNil)
-	mgr1, err := devicestate.Manager(st, hookMgr1, runner1, nil)
+	err = assertstate.Add(s.state, model)
 	c.Assert(err, IsNil)
-
-	ok := false
-	select {
-	case <-mgr1.Registered():
-	default:
-		ok = true
-	}
-	c.Check(ok, Equals, true)
-
-	st.Lock()
-	devicestatetest.SetDevice(st, &auth.DeviceState{
-		Brand:  "canonical",
-		Model:  "pc",
-		Serial: "serial",
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
 	})
-	st.Unlock()
 
-	runner2 := state.NewTaskRunner(st)
-	hookMgr2, err := hookstate.Manager(st, runner2)
-	c.Assert(err, IsNil)
-	mgr2, err := devicestate.Manager(st, hookMgr2, runner2, nil)
+	// TODO: will need to pass in a task later
+	regCtx, err := devicestate.RegistrationCtx(s.mgr, nil)
 	c.Assert(err, IsNil)
+	c.Assert(regCtx, NotNil)
 
-	ok = false
-	select {
-	case <-mgr2.Registered():
-		ok = true
-	case <-time.After(5 * time.Second):
-		c.Fatal("should have been marked registered")
-	}
-	c.Check(ok, Equals, true)
-}
-
-func (s *deviceMgrSuite) TestMarkSeededInConfig(c *C) {
-	st := s.state
-	st.Lock()
-	defer st.Unlock()
+	c.Check(regCtx.ForRemodeling(), Equals, false)
 
-	// avoid device registration
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Serial: "123",
+	device, err := regCtx.Device()
+	c.Check(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
 	})
 
-	// avoid full seeding
-	s.seeding()
-
-	// not seeded -> no config is set
-	s.state.Unlock()
-	s.mgr.Ensure()
-	s.state.Lock()
+	c.Check(regCtx.GadgetForSerialRequestConfig(), Equals, "pc-gadget")
+	c.Check(regCtx.SerialRequestExtraHeaders(), HasLen, 0)
+	c.Check(regCtx.SerialRequestAncillaryAssertions(), HasLen, 0)
 
-	var seedLoaded bool
-	tr := config.NewTransaction(st)
-	tr.Get("core", "seed.loaded", &seedLoaded)
-	c.Check(seedLoaded, Equals, false)
+}
 
-	// pretend we are seeded now
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlAlreadySeeded(c *C) {
+	s.state.Lock()
 	s.state.Set("seeded", true)
-
-	// seeded -> config got updated
 	s.state.Unlock()
-	s.mgr.Ensure()
-	s.state.Lock()
 
-	tr = config.NewTransaction(st)
-	tr.Get("core", "seed.loaded", &seedLoaded)
-	c.Check(seedLoaded, Equals, true)
+	called := false
+	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
+		called = true
+		return nil, nil
+	})
+	defer restore()
 
-	// only the fake seeding change is in the state, no further
-	// changes
-	c.Check(s.state.Changes(), HasLen, 1)
+	err := devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, false)
 }
 
-func (s *deviceMgrSuite) TestNewEnoughProxyParse(c *C) {
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlChangeInFlight(c *C) {
 	s.state.Lock()
-	defer s.state.Unlock()
+	chg := s.state.NewChange("seed", "just for testing")
+	chg.AddTask(s.state.NewTask("test-task", "the change needs a task"))
+	s.state.Unlock()
 
-	log, restore := logger.MockLogger()
+	called := false
+	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
+		called = true
+		return nil, nil
+	})
 	defer restore()
-	os.Setenv("SNAPD_DEBUG", "1")
-	defer os.Unsetenv("SNAPD_DEBUG")
 
-	badURL := &url.URL{Opaque: "%a"} // url.Parse(badURL.String()) needs to fail, which isn't easy :-)
-	c.Check(devicestate.NewEnoughProxy(s.state, badURL, http.DefaultClient), Equals, false)
-	c.Check(log.String(), Matches, "(?m).* DEBUG: Cannot check whether proxy store supports a custom serial vault: parse .*")
+	err := devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, false)
 }
 
-func (s *deviceMgrSuite) TestNewEnoughProxy(c *C) {
-	s.state.Lock()
-	defer s.state.Unlock()
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlAlsoOnClassic(c *C) {
+	release.OnClassic = true
 
-	expectedUserAgent := httputil.UserAgent()
-	log, restore := logger.MockLogger()
+	called := false
+	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) ([]*state.TaskSet, error) {
+		called = true
+		return nil, nil
+	})
 	defer restore()
-	os.Setenv("SNAPD_DEBUG", "1")
-	defer os.Unsetenv("SNAPD_DEBUG")
-
-	expecteds := []string{
-		`Head http://\S+: EOF`,
-		`Head request returned 403 Forbidden.`,
-		`Bogus Snap-Store-Version header "5pre1".`,
-		``,
-	}
-
-	n := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c.Check(r.Header.Get("User-Agent"), Equals, expectedUserAgent)
-		n++
-		switch n {
-		case 1:
-			conn, _, err := w.(http.Hijacker).Hijack()
-			c.Assert(err, IsNil)
-			conn.Close()
-		case 2:
-			w.WriteHeader(403)
-		case 3:
-			w.Header().Set("Snap-Store-Version", "5pre1")
-			w.WriteHeader(200)
-		case 4:
-			w.Header().Set("Snap-Store-Version", "5")
-			w.WriteHeader(200)
-		case 5:
-			w.Header().Set("Snap-Store-Version", "6")
-			w.WriteHeader(200)
-		default:
-			c.Errorf("expected %d results, now on %d", len(expecteds), n)
-		}
-	}))
-	defer server.Close()
 
-	u, err := url.Parse(server.URL)
+	err := devicestate.EnsureSeedYaml(s.mgr)
 	c.Assert(err, IsNil)
-	for _, expected := range expecteds {
-		log.Reset()
-		c.Check(devicestate.NewEnoughProxy(s.state, u, http.DefaultClient), Equals, false)
-		if len(expected) > 0 {
-			expected = "(?m).* DEBUG: Cannot check whether proxy store supports a custom serial vault: " + expected
-		}
-		c.Check(log.String(), Matches, expected)
-	}
-	c.Check(n, Equals, len(expecteds))
-
-	// and success at last
-	log.Reset()
-	c.Check(devicestate.NewEnoughProxy(s.state, u, http.DefaultClient), Equals, true)
-	c.Check(log.String(), Equals, "")
-	c.Check(n, Equals, len(expecteds)+1)
+	c.Assert(called, Equals, true)
 }
 
-func (s *deviceMgrSuite) TestDevicemgrCanStandby(c *C) {
-	st := state.New(nil)
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlHappy(c *C) {
+	restore := devicestate.MockPopulateStateFromSeed(func(*state.State, timings.Measurer) (ts []*state.TaskSet, err error) {
+		t := s.state.NewTask("test-task", "a random task")
+		ts = append(ts, state.NewTaskSet(t))
+		return ts, nil
+	})
+	defer restore()
 
-	runner := state.NewTaskRunner(st)
-	hookMgr, err := hookstate.Manager(st, runner)
-	c.Assert(err, IsNil)
-	mgr, err := devicestate.Manager(st, hookMgr, runner, nil)
+	err := devicestate.EnsureSeedYaml(s.mgr)
 	c.Assert(err, IsNil)
 
-	st.Lock()
-	defer st.Unlock()
-	c.Check(mgr.CanStandby(), Equals, false)
+	s.state.Lock()
+	defer s.state.Unlock()
 
-	st.Set("seeded", true)
-	c.Check(mgr.CanStandby(), Equals, true)
+	c.Check(s.state.Changes(), HasLen, 1)
 }
 
-type testModel struct {
-	brand, model               string
-	arch, base, kernel, gadget string
-}
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlFailedAndRetried(c *C) {
+	calls := 0
+	restore := devicestate.MockPopulateStateFromSeed(func(st *state.State, _ timings.Measurer) ([]*state.TaskSet, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("cannot read seed: boom")
+		}
+		t1 := st.NewTask("prerequisites", "Ensure prerequisites")
+		t2 := st.NewTask("mark-seeded", "Mark system seeded")
+		t2.WaitFor(t1)
+		return []*state.TaskSet{state.NewTaskSet(t1, t2)}, nil
+	})
+	defer restore()
 
-func (s *deviceMgrSuite) TestRemodelUnhappyNotSeeded(c *C) {
-	s.state.Lock()
-	defer s.state.Unlock()
-	s.state.Set("seeded", false)
+	// the error setting up seeding is reported
+	err := devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, ErrorMatches, "cannot read seed: boom")
 
-	newModel := s.brands.Model("canonical", "pc", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
+	s.state.Lock()
+	seeding, err := devicestate.Seeding(s.state)
+	c.Assert(err, IsNil)
+	c.Check(seeding, DeepEquals, &devicestate.SeedingStatus{
+		Error: "cannot read seed: boom",
 	})
-	_, err := devicestate.Remodel(s.state, newModel)
-	c.Assert(err, ErrorMatches, "cannot remodel until fully seeded")
-}
+	s.state.Unlock()
+
+	err = devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
 
-func (s *deviceMgrSuite) TestRemodelUnhappy(c *C) {
 	s.state.Lock()
-	defer s.state.Unlock()
-	s.state.Set("seeded", true)
+	c.Assert(s.state.Changes(), HasLen, 1)
+	chg := s.state.Changes()[0]
+	tasks := chg.Tasks()
+	tasks[0].SetStatus(state.ErrorStatus)
+	tasks[0].Errorf("cannot install snap: boom")
+	tasks[1].SetStatus(state.HoldStatus)
+	c.Assert(chg.Status(), Equals, state.ErrorStatus)
+	s.state.Unlock()
 
-	// set a model assertion
-	cur := map[string]string{
-		"brand":        "canonical",
-		"model":        "pc-model",
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
-		"base":         "core18",
-	}
-	s.makeModelAssertionInState(c, cur["brand"], cur["model"], map[string]interface{}{
-		"architecture": cur["architecture"],
-		"kernel":       cur["kernel"],
-		"gadget":       cur["gadget"],
-		"base":         cur["base"],
-	})
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: cur["brand"],
-		Model: cur["model"],
-	})
+	// the failed change is kept while backing off
+	err = devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 2)
 
-	// ensure all error cases are checked
-	for _, t := range []struct {
-		new    map[string]string
-		errStr string
-	}{
-		{map[string]string{"architecture": "pdp-7"}, "cannot remodel to different architectures yet"},
-		{map[string]string{"base": "core20"}, "cannot remodel to different bases yet"},
-		{map[string]string{"kernel": "other-kernel"}, "cannot remodel to different kernels yet"},
-		{map[string]string{"gadget": "other-gadget"}, "cannot remodel to different gadgets yet"},
-	} {
-		// copy current model unless new model test data is different
-		for k, v := range cur {
-			if t.new[k] != "" {
-				continue
-			}
-			t.new[k] = v
-		}
-		new := s.brands.Model(t.new["brand"], t.new["model"], map[string]interface{}{
-			"architecture": t.new["architecture"],
-			"kernel":       t.new["kernel"],
-			"gadget":       t.new["gadget"],
-			"base":         t.new["base"],
-		})
-		chg, err := devicestate.Remodel(s.state, new)
-		c.Check(chg, IsNil)
-		c.Check(err, ErrorMatches, t.errStr)
-	}
-}
+	s.state.Lock()
+	seeding, err = devicestate.Seeding(s.state)
+	c.Assert(err, IsNil)
+	c.Check(seeding.Seeded, Equals, false)
+	c.Check(seeding.Change, Equals, chg.ID())
+	c.Check(seeding.Status, Equals, "Error")
+	c.Check(seeding.Tasks, HasLen, 2)
+	c.Check(seeding.FailedTask, DeepEquals, &devicestate.SeedingTask{
+		ID:      tasks[0].ID(),
+		Kind:    "prerequisites",
+		Summary: "Ensure prerequisites",
+		Status:  "Error",
+	})
+	c.Check(seeding.Error, Matches, ".*ERROR cannot install snap: boom")
+
+	c.Assert(devicestate.RetrySeeding(s.state), IsNil)
+	s.state.Unlock()
+
+	err = devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 3)
 
-func (s *deviceMgrSuite) TestRemodelTasksSmoke(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
-	s.state.Set("refresh-privacy-key", "some-privacy-key")
-
-	var testDeviceCtx snapstate.DeviceContext
+	c.Check(s.state.Changes(), HasLen, 2)
+	c.Check(devicestate.RetrySeeding(s.state), ErrorMatches, "cannot retry seeding: seeding is in progress in change .*")
 
-	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, true)
-		c.Check(deviceCtx, Equals, testDeviceCtx)
-		c.Check(fromChange, Equals, "99")
+	s.state.Set("seeded", true)
+	c.Check(devicestate.RetrySeeding(s.state), ErrorMatches, "cannot retry seeding: system is already seeded")
+}
 
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
-		tInstall.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
-	})
+func (s *deviceMgrSuite) TestDeviceManagerEnsureSeedYamlFailedRetriedAutomatically(c *C) {
+	restore := devicestate.MockSeedRetryInterval(0)
 	defer restore()
 
-	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, false)
-		c.Check(flags.NoReRefresh, Equals, true)
-		c.Check(deviceCtx, Equals, testDeviceCtx)
-		c.Check(fromChange, Equals, "99")
-
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s to track %s", name, opts.Channel))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tUpdate := s.state.NewTask("fake-update", fmt.Sprintf("Update %s to track %s", name, opts.Channel))
-		tUpdate.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tUpdate)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
+	calls := 0
+	restore = devicestate.MockPopulateStateFromSeed(func(st *state.State, _ timings.Measurer) ([]*state.TaskSet, error) {
+		calls++
+		t := st.NewTask("prerequisites", "Ensure prerequisites")
+		return []*state.TaskSet{state.NewTaskSet(t)}, nil
 	})
 	defer restore()
 
-	// set a model assertion
-	current := s.brands.Model("canonical", "pc-model", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
-		"base":         "core18",
-	})
-	err := assertstate.Add(s.state, current)
+	err := devicestate.EnsureSeedYaml(s.mgr)
 	c.Assert(err, IsNil)
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc-model",
-	})
 
-	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
-		"architecture":   "amd64",
-		"kernel":         "pc-kernel=18",
-		"gadget":         "pc",
-		"base":           "core18",
-		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
-		"revision":       "1",
-	})
+	s.state.Lock()
+	c.Assert(s.state.Changes(), HasLen, 1)
+	chg := s.state.Changes()[0]
+	t := chg.Tasks()[0]
+	t.SetStatus(state.ErrorStatus)
+	t.Errorf("cannot install snap: boom")
+	s.state.Unlock()
 
-	testDeviceCtx = &snapstatetest.TrivialDeviceContext{Remodeling: true}
+	// the first failure starts the backoff
+	err = devicestate.EnsureSeedYaml(s.mgr)
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 1)
 
-	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99")
+	// seeding is retried once the backoff expired
+	err = devicestate.EnsureSeedYaml(s.mgr)
 	c.Assert(err, IsNil)
-	// 2 snaps, plus one track switch plus the remodel task, the
-	// wait chain is tested in TestRemodel*
-	c.Assert(tss, HasLen, 4)
-}
+	c.Check(calls, Equals, 2)
 
-func (s *deviceMgrSuite) TestRemodelRequiredSnaps(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
-	s.state.Set("refresh-privacy-key", "some-privacy-key")
+	c.Check(s.state.Changes(), HasLen, 2)
+	// the previous failure is still reported
+	seeding, err := devicestate.Seeding(s.state)
+	c.Assert(err, IsNil)
+	c.Check(seeding.Change, Not(Equals), chg.ID())
+	c.Check(seeding.Status, Equals, "Do")
+	c.Check(seeding.Error, Matches, ".*ERROR cannot install snap: boom")
+}
 
-	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, true)
-		c.Check(deviceCtx, NotNil)
-		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkSkippedOnClassic(c *C) {
+	release.OnClassic = true
 
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
-		tInstall.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
-	})
-	defer restore()
+	err := devicestate.EnsureBootOk(s.mgr)
+	c.Assert(err, IsNil)
+}
 
-	// set a model assertion
-	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkBootloaderHappy(c *C) {
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_mode":     "trying",
+		"snap_try_core": "core_1.snap",
+	})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	siCore1 := &snap.SideInfo{RealName: "core", Revision: snap.R(1)}
+	snapstate.Set(s.state, "core", &snapstate.SnapState{
+		SnapType: "os",
+		Active:   true,
+		Sequence: []*snap.SideInfo{siCore1},
+		Current:  siCore1.Revision,
+	})
+
+	s.state.Unlock()
+	err := devicestate.EnsureBootOk(s.mgr)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	m, err := s.bootloader.GetBootVars("snap_mode")
+	c.Assert(err, IsNil)
+	c.Assert(m, DeepEquals, map[string]string{"snap_mode": ""})
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkUpdateBootRevisionsHappy(c *C) {
+	// simulate that we have a new core_2, tried to boot it but that failed
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_mode":     "",
+		"snap_kernel":   "kernel_1.snap",
+		"snap_try_core": "core_2.snap",
+		"snap_core":     "core_1.snap",
+	})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	siKernel1 := &snap.SideInfo{RealName: "kernel", Revision: snap.R(1)}
+	snapstate.Set(s.state, "kernel", &snapstate.SnapState{
+		SnapType: "kernel",
+		Active:   true,
+		Sequence: []*snap.SideInfo{siKernel1},
+		Current:  siKernel1.Revision,
+	})
+
+	siCore1 := &snap.SideInfo{RealName: "core", Revision: snap.R(1)}
+	siCore2 := &snap.SideInfo{RealName: "core", Revision: snap.R(2)}
+	snapstate.Set(s.state, "core", &snapstate.SnapState{
+		SnapType: "os",
+		Active:   true,
+		Sequence: []*snap.SideInfo{siCore1, siCore2},
+		Current:  siCore2.Revision,
+	})
+
+	s.state.Unlock()
+	err := devicestate.EnsureBootOk(s.mgr)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	var kinds []string
+	for _, chg := range s.state.Changes() {
+		kinds = append(kinds, chg.Kind())
+	}
+	sort.Strings(kinds)
+	c.Check(kinds, DeepEquals, []string{"report-boot-failures", "update-revisions"})
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkNotRunAgain(c *C) {
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_mode":     "trying",
+		"snap_try_core": "core_1.snap",
+	})
+	s.bootloader.SetErr = fmt.Errorf("ensure bootloader is not used")
+
+	devicestate.SetBootOkRan(s.mgr, true)
+
+	err := devicestate.EnsureBootOk(s.mgr)
+	c.Assert(err, IsNil)
+}
+
+func (s *deviceMgrSuite) TestDeviceManagerEnsureBootOkError(c *C) {
+	s.state.Lock()
+	// seeded
+	s.state.Set("seeded", true)
+	// has serial
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "8989",
+	})
+	s.state.Unlock()
+
+	s.bootloader.GetErr = fmt.Errorf("bootloader err")
+
+	devicestate.SetBootOkRan(s.mgr, false)
+
+	err := s.mgr.Ensure()
+	c.Assert(err, ErrorMatches, "devicemgr: bootloader err")
+}
+
+func (s *deviceMgrSuite) setupBrands(c *C) {
+	assertstatetest.AddMany(s.state, s.brands.AccountsAndKeys("my-brand")...)
+	otherAcct := assertstest.NewAccount(s.storeSigning, "other-brand", map[string]interface{}{
+		"account-id": "other-brand",
+	}, "")
+	assertstatetest.AddMany(s.state, otherAcct)
+}
+
+func (s *deviceMgrSuite) setupSnapDecl(c *C, info *snap.Info, publisherID string) {
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-name":    info.SnapName(),
+		"snap-id":      info.SnapID,
+		"publisher-id": publisherID,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	assertstatetest.AddMany(s.state, snapDecl)
+}
+
+func fakeMyModel(extra map[string]interface{}) *asserts.Model {
+	model := map[string]interface{}{
+		"type":         "model",
+		"authority-id": "my-brand",
+		"series":       "16",
+		"brand-id":     "my-brand",
+		"model":        "my-model",
+	}
+	return assertstest.FakeAssertion(model, extra).(*asserts.Model)
+}
+
+func (s *deviceMgrSuite) TestCheckGadget(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: other-gadget, version: 0}", nil)
+
+	s.setupBrands(c)
+	// model assertion in device context
+	model := fakeMyModel(map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "gadget",
+		"kernel":       "krnl",
+	})
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+
+	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install gadget "other-gadget", model assertion requests "gadget"`)
+
+	// brand gadget
+	brandGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	brandGadgetInfo.SnapID = "brand-gadget-id"
+	s.setupSnapDecl(c, brandGadgetInfo, "my-brand")
+
+	// canonical gadget
+	canonicalGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	canonicalGadgetInfo.SnapID = "canonical-gadget-id"
+	s.setupSnapDecl(c, canonicalGadgetInfo, "canonical")
+
+	// other gadget
+	otherGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	otherGadgetInfo.SnapID = "other-gadget-id"
+	s.setupSnapDecl(c, otherGadgetInfo, "other-brand")
+
+	// install brand gadget ok
+	err = devicestate.CheckGadgetOrKernel(s.state, brandGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install canonical gadget ok
+	err = devicestate.CheckGadgetOrKernel(s.state, canonicalGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install other gadget fails
+	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install gadget "gadget" published by "other-brand" for model by "my-brand"`)
+
+	// unasserted installation of other works
+	otherGadgetInfo.SnapID = ""
+	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// parallel install fails
+	otherGadgetInfo.InstanceKey = "foo"
+	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install "gadget_foo", parallel installation of kernel or gadget snaps is not supported`)
+}
+
+func (s *deviceMgrSuite) TestCheckGadgetOnClassic(c *C) {
+	release.OnClassic = true
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: other-gadget, version: 0}", nil)
+
+	s.setupBrands(c)
+	// model assertion in device context
+	model := fakeMyModel(map[string]interface{}{
+		"classic": "true",
+		"gadget":  "gadget",
+	})
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+
+	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install gadget "other-gadget", model assertion requests "gadget"`)
+
+	// brand gadget
+	brandGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	brandGadgetInfo.SnapID = "brand-gadget-id"
+	s.setupSnapDecl(c, brandGadgetInfo, "my-brand")
+
+	// canonical gadget
+	canonicalGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	canonicalGadgetInfo.SnapID = "canonical-gadget-id"
+	s.setupSnapDecl(c, canonicalGadgetInfo, "canonical")
+
+	// other gadget
+	otherGadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+	otherGadgetInfo.SnapID = "other-gadget-id"
+	s.setupSnapDecl(c, otherGadgetInfo, "other-brand")
+
+	// install brand gadget ok
+	err = devicestate.CheckGadgetOrKernel(s.state, brandGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install canonical gadget ok
+	err = devicestate.CheckGadgetOrKernel(s.state, canonicalGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install other gadget fails
+	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install gadget "gadget" published by "other-brand" for model by "my-brand"`)
+
+	// unasserted installation of other works
+	otherGadgetInfo.SnapID = ""
+	err = devicestate.CheckGadgetOrKernel(s.state, otherGadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+}
+
+func (s *deviceMgrSuite) TestCheckGadgetOnClassicGadgetNotSpecified(c *C) {
+	release.OnClassic = true
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	gadgetInfo := snaptest.MockInfo(c, "{type: gadget, name: gadget, version: 0}", nil)
+
+	s.setupBrands(c)
+	// model assertion in device context
+	model := fakeMyModel(map[string]interface{}{
+		"classic": "true",
+	})
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+
+	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install gadget snap on classic if not requested by the model`)
+}
+
+func (s *deviceMgrSuite) TestCheckGadgetRemodelKernelCompatibility(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	mockSnapFile := func(info *snap.Info, snapYaml string, files [][]string) {
+		snapFile := snaptest.MakeTestSnapWithFiles(c, snapYaml, files)
+		c.Assert(os.MkdirAll(filepath.Dir(info.MountFile()), 0755), IsNil)
+		c.Assert(osutil.CopyFile(snapFile, info.MountFile(), osutil.CopyFlagOverwrite), IsNil)
+	}
+
+	// the current kernel, lacking vfat support
+	const kernelYaml = "name: krnl\nversion: 1\ntype: kernel\n"
+	kernelSideInfo := &snap.SideInfo{RealName: "krnl", Revision: snap.R(1)}
+	kernelInfo := snaptest.MockSnap(c, kernelYaml, kernelSideInfo)
+	snapstate.Set(s.state, "krnl", &snapstate.SnapState{
+		SnapType: "kernel",
+		Active:   true,
+		Sequence: []*snap.SideInfo{kernelSideInfo},
+		Current:  kernelSideInfo.Revision,
+	})
+	mockSnapFile(kernelInfo, kernelYaml, [][]string{
+		{"config-5.4.0-1-generic", "CONFIG_EXT4_FS=y\n"},
+	})
+
+	// the new gadget, using vfat
+	const gadgetYaml = "name: gadget\nversion: 1\ntype: gadget\n"
+	gadgetInfo := snaptest.MockInfo(c, gadgetYaml, &snap.SideInfo{Revision: snap.R(2)})
+	mockSnapFile(gadgetInfo, gadgetYaml, [][]string{
+		{"meta/gadget.yaml", `
+volumes:
+  pc:
+    bootloader: grub
+    structure:
+      - name: EFI System
+        type: EF,C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+        filesystem: vfat
+        size: 50M
+`},
+	})
+
+	model := fakeMyModel(map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "gadget",
+		"kernel":       "krnl",
+	})
+
+	// only checked when remodeling
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+	err := devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	deviceCtx.Remodeling = true
+	err = devicestate.CheckGadgetOrKernel(s.state, gadgetInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot remodel to use kernel "krnl" with gadget "gadget": structure #0 \("EFI System"\) of volume "pc" uses filesystem "vfat" not supported by the kernel \(CONFIG_VFAT_FS is not set\)`)
+}
+
+func (s *deviceMgrSuite) TestCheckKernel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	kernelInfo := snaptest.MockInfo(c, "{type: kernel, name: lnrk, version: 0}", nil)
+
+	// not on classic
+	release.OnClassic = true
+	err := devicestate.CheckGadgetOrKernel(s.state, kernelInfo, nil, snapstate.Flags{}, nil)
+	c.Check(err, ErrorMatches, `cannot install a kernel snap on classic`)
+	release.OnClassic = false
+
+	s.setupBrands(c)
+	// model assertion in device context
+	model := fakeMyModel(map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "gadget",
+		"kernel":       "krnl",
+	})
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+
+	err = devicestate.CheckGadgetOrKernel(s.state, kernelInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install kernel "lnrk", model assertion requests "krnl"`)
+
+	// brand kernel
+	brandKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
+	brandKrnlInfo.SnapID = "brand-krnl-id"
+	s.setupSnapDecl(c, brandKrnlInfo, "my-brand")
+
+	// canonical kernel
+	canonicalKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
+	canonicalKrnlInfo.SnapID = "canonical-krnl-id"
+	s.setupSnapDecl(c, canonicalKrnlInfo, "canonical")
+
+	// other kernel
+	otherKrnlInfo := snaptest.MockInfo(c, "{type: kernel, name: krnl, version: 0}", nil)
+	otherKrnlInfo.SnapID = "other-krnl-id"
+	s.setupSnapDecl(c, otherKrnlInfo, "other-brand")
+
+	// install brand kernel ok
+	err = devicestate.CheckGadgetOrKernel(s.state, brandKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install canonical kernel ok
+	err = devicestate.CheckGadgetOrKernel(s.state, canonicalKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// install other kernel fails
+	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install kernel "krnl" published by "other-brand" for model by "my-brand"`)
+
+	// unasserted installation of other works
+	otherKrnlInfo.SnapID = ""
+	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, IsNil)
+
+	// parallel install fails
+	otherKrnlInfo.InstanceKey = "foo"
+	err = devicestate.CheckGadgetOrKernel(s.state, otherKrnlInfo, nil, snapstate.Flags{}, deviceCtx)
+	c.Check(err, ErrorMatches, `cannot install "krnl_foo", parallel installation of kernel or gadget snaps is not supported`)
+}
+
+func (s *deviceMgrSuite) makeModelAssertionInState(c *C, brandID, model string, extras map[string]interface{}) {
+	modelAs := s.brands.Model(brandID, model, extras)
+
+	s.setupBrands(c)
+	assertstatetest.AddMany(s.state, modelAs)
+}
+
+func makeSerialAssertionInState(c *C, brands *assertstest.SigningAccounts, st *state.State, brandID, model, serialN string) *asserts.Serial {
+	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
+	c.Assert(err, IsNil)
+	serial, err := brands.Signing(brandID).Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            brandID,
+		"model":               model,
+		"serial":              serialN,
+		"device-key":          string(encDevKey),
+		"device-key-sha3-384": devKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	err = assertstate.Add(st, serial)
+	c.Assert(err, IsNil)
+	return serial.(*asserts.Serial)
+}
+
+func (s *deviceMgrSuite) makeSerialAssertionInState(c *C, brandID, model, serialN string) {
+	makeSerialAssertionInState(c, s.brands, s.state, brandID, model, serialN)
+}
+
+func (s *deviceMgrSuite) TestCanAutoRefreshOnCore(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	canAutoRefresh := func() bool {
+		ok, err := devicestate.CanAutoRefresh(s.state)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	// not seeded, no model, no serial -> no auto-refresh
+	s.state.Set("seeded", false)
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// seeded, model, no serial -> no auto-refresh
+	s.state.Set("seeded", true)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// seeded, model, serial -> auto-refresh
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "8989",
+	})
+	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
+	c.Check(canAutoRefresh(), Equals, true)
+
+	// not seeded, model, serial -> no auto-refresh
+	s.state.Set("seeded", false)
+	c.Check(canAutoRefresh(), Equals, false)
+}
+
+func (s *deviceMgrSuite) TestCanAutoRefreshNoSerialFallback(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	canAutoRefresh := func() bool {
+		ok, err := devicestate.CanAutoRefresh(s.state)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	// seeded, model, no serial, two attempts at getting serial
+	// -> no auto-refresh
+	devicestate.IncEnsureOperationalAttempts(s.state)
+	devicestate.IncEnsureOperationalAttempts(s.state)
+	s.state.Set("seeded", true)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// third attempt ongoing, or done
+	// fallback, try auto-refresh
+	devicestate.IncEnsureOperationalAttempts(s.state)
+	// sanity
+	c.Check(devicestate.EnsureOperationalAttempts(s.state), Equals, 3)
+	c.Check(canAutoRefresh(), Equals, true)
+}
+
+func (s *deviceMgrSuite) TestReportRefreshMetrics(c *C) {
+	now := time.Now()
+	metrics := []*snapstate.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true, Duration: 90 * time.Second, Time: now, Reported: true},
+	}
+
+	// called without the state lock held, the device needs not be
+	// registered as it is not identified
+	err := devicestate.ReportRefreshMetrics(s.state, metrics)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	sto := snapstate.Store(s.state, nil).(*fakeStore)
+	s.state.Unlock()
+	c.Check(sto.refreshMetrics, DeepEquals, []*store.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true, Duration: 90, Time: now},
+	})
+}
+
+func (s *deviceMgrSuite) TestCanAutoRefreshOnClassic(c *C) {
+	release.OnClassic = true
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	canAutoRefresh := func() bool {
+		ok, err := devicestate.CanAutoRefresh(s.state)
+		c.Assert(err, IsNil)
+		return ok
+	}
+
+	// not seeded, no model, no serial -> no auto-refresh
+	s.state.Set("seeded", false)
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// seeded, no model -> auto-refresh
+	s.state.Set("seeded", true)
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// seeded, model, no serial -> no auto-refresh
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc",
+	})
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
+		"classic": "true",
+	})
+	c.Check(canAutoRefresh(), Equals, false)
+
+	// seeded, model, serial -> auto-refresh
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "8989",
+	})
+	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
+	c.Check(canAutoRefresh(), Equals, true)
+
+	// not seeded, model, serial -> no auto-refresh
+	s.state.Set("seeded", false)
+	c.Check(canAutoRefresh(), Equals, false)
+}
+
+func makeInstalledMockCoreSnapWithSnapdControl(c *C, st *state.State) *snap.Info {
+	sideInfoCore11 := &snap.SideInfo{RealName: "core", Revision: snap.R(11), SnapID: "core-id"}
+	snapstate.Set(st, "core", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{sideInfoCore11},
+		Current:  sideInfoCore11.Revision,
+		SnapType: "os",
+	})
+	core11 := snaptest.MockSnap(c, `
+name: core
+version: 1.0
+slots:
+ snapd-control:
+`, sideInfoCore11)
+	c.Assert(core11.Slots, HasLen, 1)
+
+	return core11
+}
+
+var snapWithSnapdControlRefreshScheduleManagedYAML = `
+name: snap-with-snapd-control
+version: 1.0
+plugs:
+ snapd-control:
+  refresh-schedule: managed
+`
+
+var snapWithSnapdControlOnlyYAML = `
+name: snap-with-snapd-control
+version: 1.0
+plugs:
+ snapd-control:
+`
+
+func makeInstalledMockSnap(c *C, st *state.State, yml string) *snap.Info {
+	sideInfo11 := &snap.SideInfo{RealName: "snap-with-snapd-control", Revision: snap.R(11), SnapID: "snap-with-snapd-control-id"}
+	snapstate.Set(st, "snap-with-snapd-control", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{sideInfo11},
+		Current:  sideInfo11.Revision,
+		SnapType: "app",
+	})
+	info11 := snaptest.MockSnap(c, yml, sideInfo11)
+	c.Assert(info11.Plugs, HasLen, 1)
+
+	return info11
+}
+
+func makeMockRepoWithConnectedSnaps(c *C, st *state.State, info11, core11 *snap.Info, ifname string) {
+	repo := interfaces.NewRepository()
+	for _, iface := range builtin.Interfaces() {
+		err := repo.AddInterface(iface)
+		c.Assert(err, IsNil)
+	}
+	err := repo.AddSnap(info11)
+	c.Assert(err, IsNil)
+	err = repo.AddSnap(core11)
+	c.Assert(err, IsNil)
+	_, err = repo.Connect(&interfaces.ConnRef{
+		PlugRef: interfaces.PlugRef{Snap: info11.InstanceName(), Name: ifname},
+		SlotRef: interfaces.SlotRef{Snap: core11.InstanceName(), Name: ifname},
+	}, nil, nil, nil, nil, nil)
+	c.Assert(err, IsNil)
+	conns, err := repo.Connected("snap-with-snapd-control", "snapd-control")
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	ifacerepo.Replace(st, repo)
+}
+
+func (s *deviceMgrSuite) TestCanManageRefreshes(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// not possbile to manage by default
+	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+
+	// not possible with just a snap with "snapd-control" plug with the
+	// right attribute
+	info11 := makeInstalledMockSnap(c, st, snapWithSnapdControlRefreshScheduleManagedYAML)
+	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+
+	// not possible with a core snap with snapd control
+	core11 := makeInstalledMockCoreSnapWithSnapdControl(c, st)
+	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+
+	// not possible even with connected interfaces
+	makeMockRepoWithConnectedSnaps(c, st, info11, core11, "snapd-control")
+	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+
+	// if all of the above plus a snap declaration are in place we can
+	// manage schedules
+	s.setupSnapDecl(c, info11, "canonical")
+	c.Check(devicestate.CanManageRefreshes(st), Equals, true)
+
+	// works if the snap is not active as well (to fix race when a
+	// snap is refreshed)
+	var sideInfo11 snapstate.SnapState
+	err := snapstate.Get(st, "snap-with-snapd-control", &sideInfo11)
+	c.Assert(err, IsNil)
+	sideInfo11.Active = false
+	snapstate.Set(st, "snap-with-snapd-control", &sideInfo11)
+	c.Check(devicestate.CanManageRefreshes(st), Equals, true)
+}
+
+func (s *deviceMgrSuite) TestCanManageRefreshesNoRefreshScheduleManaged(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// just having a connected "snapd-control" interface is not enough
+	// for setting refresh.schedule=managed
+	info11 := makeInstalledMockSnap(c, st, snapWithSnapdControlOnlyYAML)
+	core11 := makeInstalledMockCoreSnapWithSnapdControl(c, st)
+	makeMockRepoWithConnectedSnaps(c, st, info11, core11, "snapd-control")
+	s.setupSnapDecl(c, info11, "canonical")
+
+	c.Check(devicestate.CanManageRefreshes(st), Equals, false)
+}
+
+func (s *deviceMgrSuite) TestReloadRegistered(c *C) {
+	st := state.New(nil)
+
+	runner1 := state.NewTaskRunner(st)
+	hookMgr1, err := hookstate.Manager(st, runner1)
+	c.Assert(err, IsNil)
+	mgr1, err := devicestate.Manager(st, hookMgr1, runner1, nil)
+	c.Assert(err, IsNil)
+
+	ok := false
+	select {
+	case <-mgr1.Registered():
+	default:
+		ok = true
+	}
+	c.Check(ok, Equals, true)
+
+	st.Lock()
+	devicestatetest.SetDevice(st, &auth.DeviceState{
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "serial",
+	})
+	st.Unlock()
+
+	runner2 := state.NewTaskRunner(st)
+	hookMgr2, err := hookstate.Manager(st, runner2)
+	c.Assert(err, IsNil)
+	mgr2, err := devicestate.Manager(st, hookMgr2, runner2, nil)
+	c.Assert(err, IsNil)
+
+	ok = false
+	select {
+	case <-mgr2.Registered():
+		ok = true
+	case <-time.After(5 * time.Second):
+		c.Fatal("should have been marked registered")
+	}
+	c.Check(ok, Equals, true)
+}
+
+func (s *deviceMgrSuite) TestMarkSeededInConfig(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// avoid device registration
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Serial: "123",
+	})
+
+	// avoid full seeding
+	s.seeding()
+
+	// not seeded -> no config is set
+	s.state.Unlock()
+	s.mgr.Ensure()
+	s.state.Lock()
+
+	var seedLoaded bool
+	tr := config.NewTransaction(st)
+	tr.Get("core", "seed.loaded", &seedLoaded)
+	c.Check(seedLoaded, Equals, false)
+
+	// pretend we are seeded now
+	s.state.Set("seeded", true)
+
+	// seeded -> config got updated
+	s.state.Unlock()
+	s.mgr.Ensure()
+	s.state.Lock()
+
+	tr = config.NewTransaction(st)
+	tr.Get("core", "seed.loaded", &seedLoaded)
+	c.Check(seedLoaded, Equals, true)
+
+	// only the fake seeding change is in the state, no further
+	// changes
+	c.Check(s.state.Changes(), HasLen, 1)
+}
+
+func (s *deviceMgrSuite) TestNewEnoughProxyParse(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	log, restore := logger.MockLogger()
+	defer restore()
+	os.Setenv("SNAPD_DEBUG", "1")
+	defer os.Unsetenv("SNAPD_DEBUG")
+
+	badURL := &url.URL{Opaque: "%a"} // url.Parse(badURL.String()) needs to fail, which isn't easy :-)
+	c.Check(devicestate.NewEnoughProxy(s.state, badURL, http.DefaultClient), Equals, false)
+	c.Check(log.String(), Matches, "(?m).* DEBUG: Cannot check whether proxy store supports a custom serial vault: parse .*")
+}
+
+func (s *deviceMgrSuite) TestNewEnoughProxy(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	expectedUserAgent := httputil.UserAgent()
+	log, restore := logger.MockLogger()
+	defer restore()
+	os.Setenv("SNAPD_DEBUG", "1")
+	defer os.Unsetenv("SNAPD_DEBUG")
+
+	expecteds := []string{
+		`Head http://\S+: EOF`,
+		`Head request returned 403 Forbidden.`,
+		`Bogus Snap-Store-Version header "5pre1".`,
+		``,
+	}
+
+	n := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("User-Agent"), Equals, expectedUserAgent)
+		n++
+		switch n {
+		case 1:
+			conn, _, err := w.(http.Hijacker).Hijack()
+			c.Assert(err, IsNil)
+			conn.Close()
+		case 2:
+			w.WriteHeader(403)
+		case 3:
+			w.Header().Set("Snap-Store-Version", "5pre1")
+			w.WriteHeader(200)
+		case 4:
+			w.Header().Set("Snap-Store-Version", "5")
+			w.WriteHeader(200)
+		case 5:
+			w.Header().Set("Snap-Store-Version", "6")
+			w.WriteHeader(200)
+		default:
+			c.Errorf("expected %d results, now on %d", len(expecteds), n)
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	c.Assert(err, IsNil)
+	for _, expected := range expecteds {
+		log.Reset()
+		c.Check(devicestate.NewEnoughProxy(s.state, u, http.DefaultClient), Equals, false)
+		if len(expected) > 0 {
+			expected = "(?m).* DEBUG: Cannot check whether proxy store supports a custom serial vault: " + expected
+		}
+		c.Check(log.String(), Matches, expected)
+	}
+	c.Check(n, Equals, len(expecteds))
+
+	// and success at last
+	log.Reset()
+	c.Check(devicestate.NewEnoughProxy(s.state, u, http.DefaultClient), Equals, true)
+	c.Check(log.String(), Equals, "")
+	c.Check(n, Equals, len(expecteds)+1)
+}
+
+func (s *deviceMgrSuite) TestDevicemgrCanStandby(c *C) {
+	st := state.New(nil)
+
+	runner := state.NewTaskRunner(st)
+	hookMgr, err := hookstate.Manager(st, runner)
+	c.Assert(err, IsNil)
+	mgr, err := devicestate.Manager(st, hookMgr, runner, nil)
+	c.Assert(err, IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(mgr.CanStandby(), Equals, false)
+
+	st.Set("seeded", true)
+	c.Check(mgr.CanStandby(), Equals, true)
+}
+
+type testModel struct {
+	brand, model               string
+	arch, base, kernel, gadget string
+}
+
+func (s *deviceMgrSuite) TestRemodelUnhappyNotSeeded(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", false)
+
+	newModel := s.brands.Model("canonical", "pc", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+	_, err := devicestate.Remodel(s.state, newModel)
+	c.Assert(err, ErrorMatches, "cannot remodel until fully seeded")
+}
+
+func (s *deviceMgrSuite) TestRemodelUnhappy(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+
+	// set a model assertion
+	cur := map[string]string{
+		"brand":        "canonical",
+		"model":        "pc-model",
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	}
+	s.makeModelAssertionInState(c, cur["brand"], cur["model"], map[string]interface{}{
+		"architecture": cur["architecture"],
+		"kernel":       cur["kernel"],
+		"gadget":       cur["gadget"],
+		"base":         cur["base"],
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: cur["brand"],
+		Model: cur["model"],
+	})
+
+	// ensure all error cases are checked
+	for _, t := range []struct {
+		new    map[string]string
+		errStr string
+	}{
+		{map[string]string{"architecture": "pdp-7"}, "cannot remodel to different architectures yet"},
+		{map[string]string{"base": "core20"}, "cannot remodel to different bases yet"},
+		{map[string]string{"kernel": "other-kernel"}, "cannot remodel to different kernels yet"},
+	} {
+		// copy current model unless new model test data is different
+		for k, v := range cur {
+			if t.new[k] != "" {
+				continue
+			}
+			t.new[k] = v
+		}
+		new := s.brands.Model(t.new["brand"], t.new["model"], map[string]interface{}{
+			"architecture": t.new["architecture"],
+			"kernel":       t.new["kernel"],
+			"gadget":       t.new["gadget"],
+			"base":         t.new["base"],
+		})
+		chg, err := devicestate.Remodel(s.state, new)
+		c.Check(chg, IsNil)
+		c.Check(err, ErrorMatches, t.errStr)
+	}
+}
+
+func (s *deviceMgrSuite) TestRemodelTasksSmoke(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	var testDeviceCtx snapstate.DeviceContext
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, true)
+		c.Check(deviceCtx, Equals, testDeviceCtx)
+		c.Check(fromChange, Equals, "99")
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, false)
+		c.Check(flags.NoReRefresh, Equals, true)
+		c.Check(deviceCtx, Equals, testDeviceCtx)
+		c.Check(fromChange, Equals, "99")
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s to track %s", name, opts.Channel))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tUpdate := s.state.NewTask("fake-update", fmt.Sprintf("Update %s to track %s", name, opts.Channel))
+		tUpdate.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tUpdate)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	current := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	err := assertstate.Add(s.state, current)
+	c.Assert(err, IsNil)
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel=18",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
+		"revision":       "1",
+	})
+
+	testDeviceCtx = &snapstatetest.TrivialDeviceContext{Remodeling: true}
+
+	tss, err := devicestate.RemodelTasks(context.Background(), s.state, current, new, testDeviceCtx, "99")
+	c.Assert(err, IsNil)
+	// 2 snaps, plus one track switch plus the remodel task, the
+	// wait chain is tested in TestRemodel*
+	c.Assert(tss, HasLen, 4)
+}
+
+func (s *deviceMgrSuite) TestRemodelRequiredSnaps(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, true)
+		c.Check(deviceCtx, NotNil)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
+		"revision":       "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
+
+	tl := chg.Tasks()
+	// 2 snaps,
+	c.Assert(tl, HasLen, 2*3+1)
+
+	remodCtx, err := devicestate.RemodelCtxFromTask(tl[0])
+	c.Assert(err, IsNil)
+	c.Check(remodCtx.ForRemodeling(), Equals, true)
+	c.Check(remodCtx.Kind(), Equals, devicestate.UpdateRemodel)
+	c.Check(remodCtx.Model(), DeepEquals, new)
+	c.Check(remodCtx.Store(), IsNil)
+
+	// check the tasks
+	tDownloadSnap1 := tl[0]
+	tValidateSnap1 := tl[1]
+	tInstallSnap1 := tl[2]
+	tDownloadSnap2 := tl[3]
+	tValidateSnap2 := tl[4]
+	tInstallSnap2 := tl[5]
+	tSetModel := tl[6]
+
+	// check the tasks
+	c.Assert(tDownloadSnap1.Kind(), Equals, "fake-download")
+	c.Assert(tDownloadSnap1.Summary(), Equals, "Download new-required-snap-1")
+	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
+	c.Assert(tValidateSnap1.Kind(), Equals, "validate-snap")
+	c.Assert(tValidateSnap1.Summary(), Equals, "Validate new-required-snap-1")
+	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
+	c.Assert(tDownloadSnap2.Kind(), Equals, "fake-download")
+	c.Assert(tDownloadSnap2.Summary(), Equals, "Download new-required-snap-2")
+	// check the ordering, download/validate everything first, then install
+
+	// snap2 downloads wait for the downloads of snap1
+	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
+	c.Assert(tValidateSnap1.WaitTasks(), DeepEquals, []*state.Task{
+		tDownloadSnap1,
+	})
+	c.Assert(tDownloadSnap2.WaitTasks(), DeepEquals, []*state.Task{
+		tValidateSnap1,
+	})
+	c.Assert(tValidateSnap2.WaitTasks(), DeepEquals, []*state.Task{
+		tDownloadSnap2,
+	})
+	c.Assert(tInstallSnap1.WaitTasks(), DeepEquals, []*state.Task{
+		// wait for own check-snap
+		tValidateSnap1,
+		// and also the last check-snap of the download chain
+		tValidateSnap2,
+	})
+	c.Assert(tInstallSnap2.WaitTasks(), DeepEquals, []*state.Task{
+		// last snap of the download chain
+		tValidateSnap2,
+		// previous install chain
+		tInstallSnap1,
+	})
+
+	c.Assert(tSetModel.Kind(), Equals, "set-model")
+	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
+	// setModel waits for everything in the change
+	c.Assert(tSetModel.WaitTasks(), DeepEquals, []*state.Task{tDownloadSnap1, tValidateSnap1, tInstallSnap1, tDownloadSnap2, tValidateSnap2, tInstallSnap2})
+}
+
+func (s *deviceMgrSuite) TestRemodelSwitchKernelTrack(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, true)
+		c.Check(deviceCtx, NotNil)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, false)
+		c.Check(flags.NoReRefresh, Equals, true)
+		c.Check(deviceCtx, NotNil)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s to track %s", name, opts.Channel))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tUpdate := s.state.NewTask("fake-update", fmt.Sprintf("Update %s to track %s", name, opts.Channel))
+		tUpdate.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tUpdate)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel=18",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"new-required-snap-1"},
+		"revision":       "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 2*3+1)
+
+	tDownloadKernel := tl[0]
+	tValidateKernel := tl[1]
+	tUpdateKernel := tl[2]
+	tDownloadSnap1 := tl[3]
+	tValidateSnap1 := tl[4]
+	tInstallSnap1 := tl[5]
+	tSetModel := tl[6]
+
+	c.Assert(tDownloadKernel.Kind(), Equals, "fake-download")
+	c.Assert(tDownloadKernel.Summary(), Equals, "Download pc-kernel to track 18")
+	c.Assert(tValidateKernel.Kind(), Equals, "validate-snap")
+	c.Assert(tValidateKernel.Summary(), Equals, "Validate pc-kernel")
+	c.Assert(tUpdateKernel.Kind(), Equals, "fake-update")
+	c.Assert(tUpdateKernel.Summary(), Equals, "Update pc-kernel to track 18")
+	c.Assert(tDownloadSnap1.Kind(), Equals, "fake-download")
+	c.Assert(tDownloadSnap1.Summary(), Equals, "Download new-required-snap-1")
+	c.Assert(tValidateSnap1.Kind(), Equals, "validate-snap")
+	c.Assert(tValidateSnap1.Summary(), Equals, "Validate new-required-snap-1")
+	c.Assert(tInstallSnap1.Kind(), Equals, "fake-install")
+	c.Assert(tInstallSnap1.Summary(), Equals, "Install new-required-snap-1")
+
+	c.Assert(tSetModel.Kind(), Equals, "set-model")
+	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
+
+	// check the ordering
+	c.Assert(tDownloadSnap1.WaitTasks(), DeepEquals, []*state.Task{
+		// previous download finished
+		tValidateKernel,
+	})
+	c.Assert(tInstallSnap1.WaitTasks(), DeepEquals, []*state.Task{
+		// last download in the chain finished
+		tValidateSnap1,
+		// and kernel got updated
+		tUpdateKernel,
+	})
+	c.Assert(tUpdateKernel.WaitTasks(), DeepEquals, []*state.Task{
+		// kernel is valid
+		tValidateKernel,
+		// and last download in the chain finished
+		tValidateSnap1,
+	})
+}
+
+func (s *deviceMgrSuite) TestRemodelSwitchGadget(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(deviceCtx, NotNil)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s from track %s", name, opts.Channel))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Fatalf("unexpected update of %q", name)
+		return nil, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "other-pc=18",
+		"base":         "core18",
+		"revision":     "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 3+1)
+
+	tDownloadGadget := tl[0]
+	tValidateGadget := tl[1]
+	tInstallGadget := tl[2]
+	tSetModel := tl[3]
+
+	c.Assert(tDownloadGadget.Kind(), Equals, "fake-download")
+	c.Assert(tDownloadGadget.Summary(), Equals, "Download other-pc from track 18")
+	c.Assert(tValidateGadget.Kind(), Equals, "validate-snap")
+	c.Assert(tValidateGadget.Summary(), Equals, "Validate other-pc")
+	c.Assert(tInstallGadget.Kind(), Equals, "fake-install")
+	c.Assert(tInstallGadget.Summary(), Equals, "Install other-pc")
+	c.Assert(tSetModel.Kind(), Equals, "set-model")
+	c.Assert(tSetModel.WaitTasks(), DeepEquals, []*state.Task{
+		tDownloadGadget,
+		tValidateGadget,
+		tInstallGadget,
+	})
+}
+
+func (s *deviceMgrSuite) TestRemodelSwitchGadgetAndKernelTrack(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	restore := devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.NoReRefresh, Equals, true)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		typ := snap.TypeKernel
+		if name == "pc" {
+			typ = snap.TypeGadget
+		}
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s to track %s", name, opts.Channel))
+		tDownload.Set("snap-setup", &snapstate.SnapSetup{
+			SideInfo: &snap.SideInfo{RealName: name, Revision: snap.R(2)},
+			Type:     typ,
+		})
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.Set("snap-setup-task", tDownload.ID())
+		tValidate.WaitFor(tDownload)
+		tLink := s.state.NewTask("link-snap", fmt.Sprintf("Make %s available", name))
+		tLink.Set("snap-setup-task", tDownload.ID())
+		tLink.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tLink)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel=18",
+		"gadget":       "pc=18",
+		"base":         "core18",
+		"revision":     "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 2*3+1)
+
+	tDownloadGadget := tl[0]
+	tLinkGadget := tl[2]
+	tDownloadKernel := tl[3]
+	tLinkKernel := tl[5]
+	c.Assert(tDownloadGadget.Summary(), Equals, "Download pc to track 18")
+	c.Assert(tDownloadKernel.Summary(), Equals, "Download pc-kernel to track 18")
+	c.Assert(tl[6].Kind(), Equals, "set-model")
+
+	// the gadget goes first and the kernel is linked after it
+	c.Check(tLinkKernel.WaitTasks(), DeepEquals, []*state.Task{
+		tl[4],
+		tLinkGadget,
+	})
+
+	// both are part of a single paired update
+	expected := &snapstate.PairedUpdate{
+		Kernel:         "pc-kernel",
+		KernelRevision: snap.R(2),
+		Gadget:         "pc",
+		GadgetRevision: snap.R(2),
+	}
+	for _, t := range []*state.Task{tLinkGadget, tLinkKernel} {
+		snapsup, err := snapstate.TaskSnapSetup(t)
+		c.Assert(err, IsNil)
+		c.Check(snapsup.PairedUpdate, DeepEquals, expected)
+	}
+	// and are only in the default lane, the whole remodel is undone
+	// on failure
+	for _, t := range tl {
+		c.Check(t.Lanes(), DeepEquals, []int{0})
+	}
+}
+
+func (s *deviceMgrSuite) TestRemodelSwitchToNewGadgetAndKernelTrack(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	fakeTs := func(name, action string, typ snap.Type, opts *snapstate.RevisionOptions) *state.TaskSet {
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s %s track %s", name, action, opts.Channel))
+		tDownload.Set("snap-setup", &snapstate.SnapSetup{
+			SideInfo: &snap.SideInfo{RealName: name, Revision: snap.R(2)},
+			Type:     typ,
+		})
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.Set("snap-setup-task", tDownload.ID())
+		tValidate.WaitFor(tDownload)
+		tLink := s.state.NewTask("link-snap", fmt.Sprintf("Make %s available", name))
+		tLink.Set("snap-setup-task", tDownload.ID())
+		tLink.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tLink)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts
+	}
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(name, Equals, "other-pc")
+		return fakeTs(name, "from", snap.TypeGadget, opts), nil
+	})
+	defer restore()
+
+	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(name, Equals, "pc-kernel")
+		return fakeTs(name, "to", snap.TypeKernel, opts), nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel=18",
+		"gadget":       "other-pc=18",
+		"base":         "core18",
+		"revision":     "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 2*3+1)
+
+	tLinkGadget := tl[2]
+	tLinkKernel := tl[5]
+	c.Assert(tl[0].Summary(), Equals, "Download other-pc from track 18")
+	c.Assert(tl[3].Summary(), Equals, "Download pc-kernel to track 18")
+	c.Assert(tl[6].Kind(), Equals, "set-model")
+
+	// the new gadget goes first and the kernel is linked after it
+	c.Check(tLinkKernel.WaitTasks(), DeepEquals, []*state.Task{
+		tl[4],
+		tLinkGadget,
+	})
+
+	// both are part of a single paired update
+	expected := &snapstate.PairedUpdate{
+		Kernel:         "pc-kernel",
+		KernelRevision: snap.R(2),
+		Gadget:         "other-pc",
+		GadgetRevision: snap.R(2),
+	}
+	for _, t := range []*state.Task{tLinkGadget, tLinkKernel} {
+		snapsup, err := snapstate.TaskSnapSetup(t)
+		c.Assert(err, IsNil)
+		c.Check(snapsup.PairedUpdate, DeepEquals, expected)
+	}
+}
+
+func (s *deviceMgrSuite) TestRemodelLessRequiredSnaps(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"some-required-snap"},
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+		"revision":     "1",
+	})
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 1)
+	tSetModel := tl[0]
+	c.Assert(tSetModel.Kind(), Equals, "set-model")
+	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
+}
+
+type freshSessionStore struct {
+	storetest.Store
+
+	ensureDeviceSession int
+}
+
+func (sto *freshSessionStore) EnsureDeviceSession() (*auth.DeviceState, error) {
+	sto.ensureDeviceSession += 1
+	return nil, nil
+}
+
+func (s *deviceMgrSuite) TestRemodelStoreSwitch(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
+	s.state.Set("refresh-privacy-key", "some-privacy-key")
+
+	var testStore snapstate.StoreService
+
+	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
+		c.Check(flags.Required, Equals, true)
+		c.Check(deviceCtx, NotNil)
+		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+
+		c.Check(deviceCtx.Store(), Equals, testStore)
+
+		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
+		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
+		tValidate.WaitFor(tDownload)
+		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
+		tInstall.WaitFor(tValidate)
+		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
+		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
+		return ts, nil
+	})
+	defer restore()
+
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
@@ -2630,588 +3774,880 @@ func (s *deviceMgrSuite) TestRemodelRequiredSnaps(c *C) {
 		"kernel":         "pc-kernel",
 		"gadget":         "pc",
 		"base":           "core18",
+		"store":          "switched-store",
 		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
 		"revision":       "1",
 	})
+
+	freshStore := &freshSessionStore{}
+	testStore = freshStore
+
+	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
+		mod, err := devBE.Model()
+		c.Check(err, IsNil)
+		if err == nil {
+			c.Check(mod, DeepEquals, new)
+		}
+		return testStore
+	}
+
 	chg, err := devicestate.Remodel(s.state, new)
 	c.Assert(err, IsNil)
 	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
 
+	c.Check(freshStore.ensureDeviceSession, Equals, 1)
+
 	tl := chg.Tasks()
-	// 2 snaps,
+	// 2 snaps * 3 tasks (from the mock install above) +
+	// 1 "set-model" task at the end
 	c.Assert(tl, HasLen, 2*3+1)
 
 	remodCtx, err := devicestate.RemodelCtxFromTask(tl[0])
 	c.Assert(err, IsNil)
 	c.Check(remodCtx.ForRemodeling(), Equals, true)
-	c.Check(remodCtx.Kind(), Equals, devicestate.UpdateRemodel)
+	c.Check(remodCtx.Kind(), Equals, devicestate.StoreSwitchRemodel)
 	c.Check(remodCtx.Model(), DeepEquals, new)
-	c.Check(remodCtx.Store(), IsNil)
-
-	// check the tasks
-	tDownloadSnap1 := tl[0]
-	tValidateSnap1 := tl[1]
-	tInstallSnap1 := tl[2]
-	tDownloadSnap2 := tl[3]
-	tValidateSnap2 := tl[4]
-	tInstallSnap2 := tl[5]
-	tSetModel := tl[6]
+	c.Check(remodCtx.Store(), Equals, testStore)
+}
 
-	// check the tasks
-	c.Assert(tDownloadSnap1.Kind(), Equals, "fake-download")
-	c.Assert(tDownloadSnap1.Summary(), Equals, "Download new-required-snap-1")
-	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
-	c.Assert(tValidateSnap1.Kind(), Equals, "validate-snap")
-	c.Assert(tValidateSnap1.Summary(), Equals, "Validate new-required-snap-1")
-	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
-	c.Assert(tDownloadSnap2.Kind(), Equals, "fake-download")
-	c.Assert(tDownloadSnap2.Summary(), Equals, "Download new-required-snap-2")
-	// check the ordering, download/validate everything first, then install
+func (s *deviceMgrSuite) TestRemodelRereg(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.state.Set("seeded", true)
 
-	// snap2 downloads wait for the downloads of snap1
-	c.Assert(tDownloadSnap1.WaitTasks(), HasLen, 0)
-	c.Assert(tValidateSnap1.WaitTasks(), DeepEquals, []*state.Task{
-		tDownloadSnap1,
-	})
-	c.Assert(tDownloadSnap2.WaitTasks(), DeepEquals, []*state.Task{
-		tValidateSnap1,
-	})
-	c.Assert(tValidateSnap2.WaitTasks(), DeepEquals, []*state.Task{
-		tDownloadSnap2,
+	// set a model assertion
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
 	})
-	c.Assert(tInstallSnap1.WaitTasks(), DeepEquals, []*state.Task{
-		// wait for own check-snap
-		tValidateSnap1,
-		// and also the last check-snap of the download chain
-		tValidateSnap2,
+	s.makeSerialAssertionInState(c, "canonical", "pc-model", "orig-serial")
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:           "canonical",
+		Model:           "pc-model",
+		Serial:          "orig-serial",
+		SessionMacaroon: "old-session",
 	})
-	c.Assert(tInstallSnap2.WaitTasks(), DeepEquals, []*state.Task{
-		// last snap of the download chain
-		tValidateSnap2,
-		// previous install chain
-		tInstallSnap1,
+
+	new := s.brands.Model("canonical", "rereg-model", map[string]interface{}{
+		"architecture":   "amd64",
+		"kernel":         "pc-kernel",
+		"gadget":         "pc",
+		"base":           "core18",
+		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
 	})
 
-	c.Assert(tSetModel.Kind(), Equals, "set-model")
-	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
-	// setModel waits for everything in the change
-	c.Assert(tSetModel.WaitTasks(), DeepEquals, []*state.Task{tDownloadSnap1, tValidateSnap1, tInstallSnap1, tDownloadSnap2, tValidateSnap2, tInstallSnap2})
+	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
+		mod, err := devBE.Model()
+		c.Check(err, IsNil)
+		if err == nil {
+			c.Check(mod, DeepEquals, new)
+		}
+		return nil
+	}
+
+	chg, err := devicestate.Remodel(s.state, new)
+	c.Assert(err, IsNil)
+
+	c.Assert(chg.Summary(), Equals, "Remodel device to canonical/rereg-model (0)")
+
+	tl := chg.Tasks()
+	c.Assert(tl, HasLen, 2)
+
+	// check the tasks
+	tRequestSerial := tl[0]
+	tPrepareRemodeling := tl[1]
+
+	// check the tasks
+	c.Assert(tRequestSerial.Kind(), Equals, "request-serial")
+	c.Assert(tRequestSerial.Summary(), Equals, "Request new device serial")
+	c.Assert(tRequestSerial.WaitTasks(), HasLen, 0)
+
+	c.Assert(tPrepareRemodeling.Kind(), Equals, "prepare-remodeling")
+	c.Assert(tPrepareRemodeling.Summary(), Equals, "Prepare remodeling")
+	c.Assert(tPrepareRemodeling.WaitTasks(), DeepEquals, []*state.Task{tRequestSerial})
 }
 
-func (s *deviceMgrSuite) TestRemodelSwitchKernelTrack(c *C) {
+func (s *deviceMgrSuite) TestRemodeling(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
-	s.state.Set("refresh-privacy-key", "some-privacy-key")
 
-	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, true)
-		c.Check(deviceCtx, NotNil)
-		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+	// no changes
+	c.Check(devicestate.Remodeling(s.state), Equals, false)
 
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
-		tInstall.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
-	})
-	defer restore()
+	// other change
+	s.state.NewChange("other", "...")
+	c.Check(devicestate.Remodeling(s.state), Equals, false)
 
-	restore = devicestate.MockSnapstateUpdateWithDeviceContext(func(st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, false)
-		c.Check(flags.NoReRefresh, Equals, true)
-		c.Check(deviceCtx, NotNil)
-		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+	// remodel change
+	chg := s.state.NewChange("remodel", "...")
+	c.Check(devicestate.Remodeling(s.state), Equals, true)
 
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s to track %s", name, opts.Channel))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tUpdate := s.state.NewTask("fake-update", fmt.Sprintf("Update %s to track %s", name, opts.Channel))
-		tUpdate.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tUpdate)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
-	})
+	// done
+	chg.SetStatus(state.DoneStatus)
+	c.Check(devicestate.Remodeling(s.state), Equals, false)
+}
+
+func (s *deviceMgrSuite) TestDoRequestSerialReregistration(c *C) {
+	mockServer := s.mockServer(c, "REQID-1", nil)
+	defer mockServer.Close()
+
+	restore := devicestate.MockBaseStoreURL(mockServer.URL)
 	defer restore()
 
-	// set a model assertion
-	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+	assertstest.AddMany(s.storeSigning, s.brands.AccountsAndKeys("rereg-brand")...)
+
+	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "my-brand", "my-model", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
-		"base":         "core18",
 	})
+
+	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
+
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc-model",
+		Brand:  "my-brand",
+		Model:  "my-model",
+		KeyID:  devKey.PublicKey().ID(),
+		Serial: "9999",
 	})
+	devicestate.KeypairManager(s.mgr).Put(devKey)
 
-	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
-		"architecture":   "amd64",
-		"kernel":         "pc-kernel=18",
-		"gadget":         "pc",
-		"base":           "core18",
-		"required-snaps": []interface{}{"new-required-snap-1"},
-		"revision":       "1",
+	// have a serial assertion
+	s.makeSerialAssertionInState(c, "my-brand", "my-model", "9999")
+
+	new := s.brands.Model("rereg-brand", "rereg-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
 	})
-	chg, err := devicestate.Remodel(s.state, new)
+	cur, err := s.mgr.Model()
 	c.Assert(err, IsNil)
-	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
 
-	tl := chg.Tasks()
-	c.Assert(tl, HasLen, 2*3+1)
+	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
+		mod, err := devBE.Model()
+		c.Check(err, IsNil)
+		if err == nil {
+			c.Check(mod, DeepEquals, new)
+		}
+		return nil
+	}
 
-	tDownloadKernel := tl[0]
-	tValidateKernel := tl[1]
-	tUpdateKernel := tl[2]
-	tDownloadSnap1 := tl[3]
-	tValidateSnap1 := tl[4]
-	tInstallSnap1 := tl[5]
-	tSetModel := tl[6]
+	remodCtx, err := devicestate.RemodelCtx(s.state, cur, new)
+	c.Assert(err, IsNil)
+	c.Check(remodCtx.Kind(), Equals, devicestate.ReregRemodel)
 
-	c.Assert(tDownloadKernel.Kind(), Equals, "fake-download")
-	c.Assert(tDownloadKernel.Summary(), Equals, "Download pc-kernel to track 18")
-	c.Assert(tValidateKernel.Kind(), Equals, "validate-snap")
-	c.Assert(tValidateKernel.Summary(), Equals, "Validate pc-kernel")
-	c.Assert(tUpdateKernel.Kind(), Equals, "fake-update")
-	c.Assert(tUpdateKernel.Summary(), Equals, "Update pc-kernel to track 18")
-	c.Assert(tDownloadSnap1.Kind(), Equals, "fake-download")
-	c.Assert(tDownloadSnap1.Summary(), Equals, "Download new-required-snap-1")
-	c.Assert(tValidateSnap1.Kind(), Equals, "validate-snap")
-	c.Assert(tValidateSnap1.Summary(), Equals, "Validate new-required-snap-1")
-	c.Assert(tInstallSnap1.Kind(), Equals, "fake-install")
-	c.Assert(tInstallSnap1.Summary(), Equals, "Install new-required-snap-1")
+	t := s.state.NewTask("request-serial", "test")
+	chg := s.state.NewChange("remodel", "...")
+	// associate with context
+	remodCtx.Init(chg)
+	chg.AddTask(t)
 
-	c.Assert(tSetModel.Kind(), Equals, "set-model")
-	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
+	// sanity
+	regCtx, err := devicestate.RegistrationCtx(s.mgr, t)
+	c.Assert(err, IsNil)
+	c.Check(regCtx, Equals, remodCtx.(devicestate.RegistrationContext))
 
-	// check the ordering
-	c.Assert(tDownloadSnap1.WaitTasks(), DeepEquals, []*state.Task{
-		// previous download finished
-		tValidateKernel,
-	})
-	c.Assert(tInstallSnap1.WaitTasks(), DeepEquals, []*state.Task{
-		// last download in the chain finished
-		tValidateSnap1,
-		// and kernel got updated
-		tUpdateKernel,
-	})
-	c.Assert(tUpdateKernel.WaitTasks(), DeepEquals, []*state.Task{
-		// kernel is valid
-		tValidateKernel,
-		// and last download in the chain finished
-		tValidateSnap1,
+	// avoid full seeding
+	s.seeding()
+
+	s.state.Unlock()
+	s.se.Ensure()
+	s.se.Wait()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.DoneStatus, Commentf("%s", t.Log()))
+	c.Check(chg.Err(), IsNil)
+	device, err := devicestatetest.Device(s.state)
+	c.Check(err, IsNil)
+	c.Check(device.Serial, Equals, "9999")
+	_, err = s.db.Find(asserts.SerialType, map[string]string{
+		"brand-id": "rereg-brand",
+		"model":    "rereg-model",
+		"serial":   "9999",
 	})
+	c.Assert(err, IsNil)
 }
 
-func (s *deviceMgrSuite) TestRemodelLessRequiredSnaps(c *C) {
+func (s *deviceMgrSuite) TestDeviceCtxNoTask(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
-	s.state.Set("refresh-privacy-key", "some-privacy-key")
+	// nothing in the state
 
-	// set a model assertion
-	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
-		"architecture":   "amd64",
-		"kernel":         "pc-kernel",
-		"gadget":         "pc",
-		"base":           "core18",
-		"required-snaps": []interface{}{"some-required-snap"},
+	_, err := devicestate.DeviceCtx(s.state, nil, nil)
+	c.Check(err, Equals, state.ErrNoState)
+
+	// have a model assertion
+	model := s.brands.Model("canonical", "pc", map[string]interface{}{
+		"gadget":       "pc",
+		"kernel":       "kernel",
+		"architecture": "amd64",
 	})
+	assertstatetest.AddMany(s.state, model)
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
 		Brand: "canonical",
-		Model: "pc-model",
+		Model: "pc",
+	})
+
+	deviceCtx, err := devicestate.DeviceCtx(s.state, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(deviceCtx.Model().BrandID(), Equals, "canonical")
+}
+
+func (s *deviceMgrSuite) TestDeviceCtxProvided(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	model := assertstest.FakeAssertion(map[string]interface{}{
+		"type":         "model",
+		"authority-id": "canonical",
+		"series":       "16",
+		"brand-id":     "canonical",
+		"model":        "pc",
+		"gadget":       "pc",
+		"kernel":       "kernel",
+		"architecture": "amd64",
+	}).(*asserts.Model)
+
+	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+
+	deviceCtx1, err := devicestate.DeviceCtx(s.state, nil, deviceCtx)
+	c.Assert(err, IsNil)
+	c.Assert(deviceCtx1, Equals, deviceCtx)
+}
+
+var snapYaml = `
+name: foo-gadget
+type: gadget
+`
+
+var gadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+kernel-cmdline:
+  allow:
+    - console
+    - isolcpus
+`
+
+func setupGadgetUpdate(c *C, st *state.State) (chg *state.Change, tsk *state.Task) {
+	return setupGadgetUpdateWithFiles(c, st, nil)
+}
+
+func setupGadgetUpdateWithFiles(c *C, st *state.State, updateFiles [][]string) (chg *state.Change, tsk *state.Task) {
+	siCurrent := &snap.SideInfo{
+		RealName: "foo-gadget",
+		Revision: snap.R(33),
+		SnapID:   "foo-id",
+	}
+	si := &snap.SideInfo{
+		RealName: "foo-gadget",
+		Revision: snap.R(34),
+		SnapID:   "foo-id",
+	}
+	snaptest.MockSnapWithFiles(c, snapYaml, siCurrent, [][]string{
+		{"meta/gadget.yaml", gadgetYaml},
+	})
+	snaptest.MockSnapWithFiles(c, snapYaml, si, append([][]string{
+		{"meta/gadget.yaml", gadgetYaml},
+	}, updateFiles...))
+
+	st.Lock()
+
+	snapstate.Set(st, "foo-gadget", &snapstate.SnapState{
+		SnapType: "gadget",
+		Sequence: []*snap.SideInfo{siCurrent},
+		Current:  siCurrent.Revision,
+		Active:   true,
+	})
+
+	tsk = st.NewTask("update-gadget-assets", "update gadget")
+	tsk.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: si,
+		Type:     snap.TypeGadget,
 	})
+	chg = st.NewChange("dummy", "...")
+	chg.AddTask(tsk)
 
-	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
-		"base":         "core18",
-		"revision":     "1",
-	})
-	chg, err := devicestate.Remodel(s.state, new)
-	c.Assert(err, IsNil)
-	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
+	st.Unlock()
 
-	tl := chg.Tasks()
-	c.Assert(tl, HasLen, 1)
-	tSetModel := tl[0]
-	c.Assert(tSetModel.Kind(), Equals, "set-model")
-	c.Assert(tSetModel.Summary(), Equals, "Set new model assertion")
+	return chg, tsk
 }
 
-type freshSessionStore struct {
-	storetest.Store
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreSimple(c *C) {
+	var updateCalled bool
+	var passedRollbackDir string
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		updateCalled = true
+		passedRollbackDir = path
+		st, err := os.Stat(path)
+		c.Assert(err, IsNil)
+		m := st.Mode()
+		c.Assert(m.IsDir(), Equals, true)
+		c.Check(m.Perm(), Equals, os.FileMode(0750))
+		return nil
+	})
+	defer restore()
 
-	ensureDeviceSession int
-}
+	chg, t := setupGadgetUpdate(c, s.state)
 
-func (sto *freshSessionStore) EnsureDeviceSession() (*auth.DeviceState, error) {
-	sto.ensureDeviceSession += 1
-	return nil, nil
-}
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-func (s *deviceMgrSuite) TestRemodelStoreSwitch(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
-	s.state.Set("refresh-privacy-key", "some-privacy-key")
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(updateCalled, Equals, true)
+	rollbackDir := filepath.Join(dirs.SnapRollbackDir, "foo-gadget_34")
+	c.Check(rollbackDir, Equals, passedRollbackDir)
+	// should have been removed once the change is done
+	c.Check(osutil.IsDirectory(rollbackDir), Equals, false)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+}
 
-	var testStore snapstate.StoreService
+func (s *deviceMgrSuite) TestUpdateGadgetOnCorePairedDefersRestart(c *C) {
+	var updateCalled bool
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		updateCalled = true
+		return nil
+	})
+	defer restore()
 
-	restore := devicestate.MockSnapstateInstallWithDeviceContext(func(ctx context.Context, st *state.State, name string, opts *snapstate.RevisionOptions, userID int, flags snapstate.Flags, deviceCtx snapstate.DeviceContext, fromChange string) (*state.TaskSet, error) {
-		c.Check(flags.Required, Equals, true)
-		c.Check(deviceCtx, NotNil)
-		c.Check(deviceCtx.ForRemodeling(), Equals, true)
+	chg, t := setupGadgetUpdate(c, s.state)
+	s.state.Lock()
+	snapsup, err := snapstate.TaskSnapSetup(t)
+	c.Assert(err, IsNil)
+	snapsup.PairedUpdate = &snapstate.PairedUpdate{
+		Kernel:         "pc-kernel",
+		KernelRevision: snap.R(2),
+		Gadget:         "foo-gadget",
+		GadgetRevision: snap.R(34),
+	}
+	t.Set("snap-setup", snapsup)
+	s.state.Unlock()
 
-		c.Check(deviceCtx.Store(), Equals, testStore)
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-		tDownload := s.state.NewTask("fake-download", fmt.Sprintf("Download %s", name))
-		tValidate := s.state.NewTask("validate-snap", fmt.Sprintf("Validate %s", name))
-		tValidate.WaitFor(tDownload)
-		tInstall := s.state.NewTask("fake-install", fmt.Sprintf("Install %s", name))
-		tInstall.WaitFor(tValidate)
-		ts := state.NewTaskSet(tDownload, tValidate, tInstall)
-		ts.MarkEdge(tValidate, snapstate.DownloadAndChecksDoneEdge)
-		return ts, nil
-	})
-	defer restore()
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(updateCalled, Equals, true)
+	// the kernel update takes care of rebooting
+	c.Check(s.restartRequests, HasLen, 0)
+	c.Check(strings.Join(t.Log(), ""), Matches, `.*Restart deferred to the update of kernel "pc-kernel"`)
+	// the backup of the assets is kept until the kernel booted
+	c.Check(osutil.IsDirectory(filepath.Join(dirs.SnapRollbackDir, "foo-gadget_34")), Equals, true)
+}
 
-	// set a model assertion
-	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
-		"base":         "core18",
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreUndoRollsBackAssets(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
 	})
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc-model",
+	defer restore()
+	var rollbackCalls []string
+	restore = devicestate.MockGadgetRollback(func(current, update gadget.GadgetData, path string) error {
+		c.Check(current.RootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/33"))
+		c.Check(update.RootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/34"))
+		c.Check(osutil.IsDirectory(path), Equals, true)
+		rollbackCalls = append(rollbackCalls, path)
+		return nil
 	})
+	defer restore()
 
-	new := s.brands.Model("canonical", "pc-model", map[string]interface{}{
-		"architecture":   "amd64",
-		"kernel":         "pc-kernel",
-		"gadget":         "pc",
-		"base":           "core18",
-		"store":          "switched-store",
-		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
-		"revision":       "1",
+	// the old gadget uses the default kernel command line
+	chg, t := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.extra", "console=ttyS0"},
 	})
+	s.state.Lock()
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(t)
+	chg.AddTask(terr)
+	s.state.Unlock()
 
-	freshStore := &freshSessionStore{}
-	testStore = freshStore
-
-	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
-		mod, err := devBE.Model()
-		c.Check(err, IsNil)
-		if err == nil {
-			c.Check(mod, DeepEquals, new)
-		}
-		return testStore
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
 	}
 
-	chg, err := devicestate.Remodel(s.state, new)
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), ErrorMatches, "(?s).*provoking total undo.*")
+	c.Check(t.Status(), Equals, state.UndoneStatus)
+	rollbackDir := filepath.Join(dirs.SnapRollbackDir, "foo-gadget_34")
+	c.Check(rollbackCalls, DeepEquals, []string{rollbackDir})
+	c.Check(s.bootloader.BootVars["snapd_extra_cmdline_args"], Equals, "")
+	// once for the update, once for the undo
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem, state.RestartSystem})
+	// removed once the change is done
+	c.Check(osutil.IsDirectory(rollbackDir), Equals, false)
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreRevertPairedRollsBackAssets(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("unexpected call")
+	})
+	defer restore()
+	var rollbackCalls []string
+	restore = devicestate.MockGadgetRollback(func(old, new gadget.GadgetData, path string) error {
+		// the update from the gadget reverted to is rolled back
+		c.Check(old.RootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/34"))
+		c.Check(new.RootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/33"))
+		rollbackCalls = append(rollbackCalls, path)
+		return nil
+	})
+	defer restore()
+
+	chg, t := setupGadgetUpdate(c, s.state)
+	// the assets of revision 33 were updated paired with a kernel
+	// that failed to boot, revert to revision 34
+	pairedRollbackDir := filepath.Join(dirs.SnapRollbackDir, "foo-gadget_33")
+	c.Assert(os.MkdirAll(pairedRollbackDir, 0750), IsNil)
+	s.state.Lock()
+	snapsup, err := snapstate.TaskSnapSetup(t)
 	c.Assert(err, IsNil)
-	c.Assert(chg.Summary(), Equals, "Refresh model assertion from revision 0 to 1")
+	snapsup.Flags.Revert = true
+	t.Set("snap-setup", snapsup)
+	s.state.Unlock()
 
-	c.Check(freshStore.ensureDeviceSession, Equals, 1)
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-	tl := chg.Tasks()
-	// 2 snaps * 3 tasks (from the mock install above) +
-	// 1 "set-model" task at the end
-	c.Assert(tl, HasLen, 2*3+1)
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(rollbackCalls, DeepEquals, []string{pairedRollbackDir})
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+	// the backup was used up
+	c.Check(osutil.IsDirectory(pairedRollbackDir), Equals, false)
+	c.Check(osutil.IsDirectory(filepath.Join(dirs.SnapRollbackDir, "foo-gadget_34")), Equals, false)
+}
 
-	remodCtx, err := devicestate.RemodelCtxFromTask(tl[0])
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreRevertPairedDefersRestart(c *C) {
+	restore := devicestate.MockGadgetRollback(func(old, new gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+
+	chg, t := setupGadgetUpdate(c, s.state)
+	pairedRollbackDir := filepath.Join(dirs.SnapRollbackDir, "foo-gadget_33")
+	c.Assert(os.MkdirAll(pairedRollbackDir, 0750), IsNil)
+	s.state.Lock()
+	snapsup, err := snapstate.TaskSnapSetup(t)
 	c.Assert(err, IsNil)
-	c.Check(remodCtx.ForRemodeling(), Equals, true)
-	c.Check(remodCtx.Kind(), Equals, devicestate.StoreSwitchRemodel)
-	c.Check(remodCtx.Model(), DeepEquals, new)
-	c.Check(remodCtx.Store(), Equals, testStore)
-}
+	snapsup.Flags.Revert = true
+	// reverted together with the kernel that failed to boot
+	snapsup.PairedUpdate = &snapstate.PairedUpdate{
+		Kernel:         "pc-kernel",
+		KernelRevision: snap.R(1),
+		Gadget:         "foo-gadget",
+		GadgetRevision: snap.R(34),
+	}
+	t.Set("snap-setup", snapsup)
+	s.state.Unlock()
+
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-func (s *deviceMgrSuite) TestRemodelRereg(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
-	s.state.Set("seeded", true)
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	// the revert of the kernel reboots into both
+	c.Check(s.restartRequests, HasLen, 0)
+	c.Check(strings.Join(t.Log(), "\n"), Matches, `(?s).*Restart deferred to the revert of kernel "pc-kernel".*`)
+}
 
-	// set a model assertion
-	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreTemplateVars(c *C) {
+	var passedVars *gadget.TemplateVars
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		passedVars = update.TemplateVars
+		return nil
+	})
+	defer restore()
+
+	s.state.Lock()
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
-		"base":         "core18",
 	})
-	s.makeSerialAssertionInState(c, "canonical", "pc-model", "orig-serial")
+	s.makeSerialAssertionInState(c, "canonical", "pc", "8989")
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:           "canonical",
-		Model:           "pc-model",
-		Serial:          "orig-serial",
-		SessionMacaroon: "old-session",
+		Brand:  "canonical",
+		Model:  "pc",
+		Serial: "8989",
 	})
+	s.state.Unlock()
 
-	new := s.brands.Model("canonical", "rereg-model", map[string]interface{}{
-		"architecture":   "amd64",
-		"kernel":         "pc-kernel",
-		"gadget":         "pc",
-		"base":           "core18",
-		"required-snaps": []interface{}{"new-required-snap-1", "new-required-snap-2"},
-	})
+	chg, _ := setupGadgetUpdate(c, s.state)
 
-	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
-		mod, err := devBE.Model()
-		c.Check(err, IsNil)
-		if err == nil {
-			c.Check(mod, DeepEquals, new)
-		}
-		return nil
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
 	}
 
-	chg, err := devicestate.Remodel(s.state, new)
-	c.Assert(err, IsNil)
-
-	c.Assert(chg.Summary(), Equals, "Remodel device to canonical/rereg-model (0)")
-
-	tl := chg.Tasks()
-	c.Assert(tl, HasLen, 2)
-
-	// check the tasks
-	tRequestSerial := tl[0]
-	tPrepareRemodeling := tl[1]
-
-	// check the tasks
-	c.Assert(tRequestSerial.Kind(), Equals, "request-serial")
-	c.Assert(tRequestSerial.Summary(), Equals, "Request new device serial")
-	c.Assert(tRequestSerial.WaitTasks(), HasLen, 0)
-
-	c.Assert(tPrepareRemodeling.Kind(), Equals, "prepare-remodeling")
-	c.Assert(tPrepareRemodeling.Summary(), Equals, "Prepare remodeling")
-	c.Assert(tPrepareRemodeling.WaitTasks(), DeepEquals, []*state.Task{tRequestSerial})
-}
-
-func (s *deviceMgrSuite) TestRemodeling(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(passedVars, DeepEquals, &gadget.TemplateVars{
+		Model:        "pc",
+		Serial:       "8989",
+		Architecture: "amd64",
+	})
+}
 
-	// no changes
-	c.Check(devicestate.Remodeling(s.state), Equals, false)
-
-	// other change
-	s.state.NewChange("other", "...")
-	c.Check(devicestate.Remodeling(s.state), Equals, false)
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreTracksBootAssets(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+	var rootDir string
+	restore = devicestate.MockGadgetBootAssetsDigests(func(gd gadget.GadgetData) (map[string]string, error) {
+		rootDir = gd.RootDir
+		return map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"}, nil
+	})
+	defer restore()
 
-	// remodel change
-	chg := s.state.NewChange("remodel", "...")
-	c.Check(devicestate.Remodeling(s.state), Equals, true)
+	s.state.Lock()
+	tracked, err := devicestate.TrackedBootAssets(s.state)
+	s.state.Unlock()
+	c.Assert(err, IsNil)
+	c.Check(tracked, IsNil)
 
-	// done
-	chg.SetStatus(state.DoneStatus)
-	c.Check(devicestate.Remodeling(s.state), Equals, false)
-}
+	chg, _ := setupGadgetUpdate(c, s.state)
 
-func (s *deviceMgrSuite) TestDoRequestSerialReregistration(c *C) {
-	mockServer := s.mockServer(c, "REQID-1", nil)
-	defer mockServer.Close()
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-	restore := devicestate.MockBaseStoreURL(mockServer.URL)
-	defer restore()
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	// digests of the assets of the new gadget
+	c.Check(rootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/34"))
+	tracked, err = devicestate.TrackedBootAssets(s.state)
+	c.Assert(err, IsNil)
+	c.Check(tracked, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"})
+}
 
-	assertstest.AddMany(s.storeSigning, s.brands.AccountsAndKeys("rereg-brand")...)
+func (s *deviceMgrSuite) mockEncryptedDataKey(c *C) {
+	keyFile := secboot.DataKeyFile()
+	c.Assert(os.MkdirAll(filepath.Dir(keyFile), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, []byte(`{"protector":"mock","data":""}`), 0600), IsNil)
 
-	// setup state as done by first-boot/Ensure/doGenerateDeviceKey
 	s.state.Lock()
 	defer s.state.Unlock()
-
-	s.makeModelAssertionInState(c, "my-brand", "my-model", map[string]interface{}{
+	s.makeModelAssertionInState(c, "canonical", "pc", map[string]interface{}{
 		"architecture": "amd64",
 		"kernel":       "pc-kernel",
 		"gadget":       "pc",
 	})
-
-	devicestatetest.MockGadget(c, s.state, "pc", snap.R(2), nil)
-
 	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand:  "my-brand",
-		Model:  "my-model",
-		KeyID:  devKey.PublicKey().ID(),
-		Serial: "9999",
+		Brand: "canonical",
+		Model: "pc",
 	})
-	devicestate.KeypairManager(s.mgr).Put(devKey)
+}
 
-	// have a serial assertion
-	s.makeSerialAssertionInState(c, "my-brand", "my-model", "9999")
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreReseals(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+	restore = devicestate.MockGadgetBootAssetsDigests(func(gd gadget.GadgetData) (map[string]string, error) {
+		return map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"}, nil
+	})
+	defer restore()
+	var resealKeyFile string
+	var resealParams *secboot.SealParams
+	restore = devicestate.MockSecbootResealKey(func(keyFile string, current, params *secboot.SealParams) error {
+		resealKeyFile = keyFile
+		resealParams = params
+		return nil
+	})
+	defer restore()
+	s.mockEncryptedDataKey(c)
 
-	new := s.brands.Model("rereg-brand", "rereg-model", map[string]interface{}{
-		"architecture": "amd64",
-		"kernel":       "pc-kernel",
-		"gadget":       "pc",
+	chg, _ := setupGadgetUpdate(c, s.state)
+
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(resealKeyFile, Equals, secboot.DataKeyFile())
+	c.Check(resealParams, DeepEquals, &secboot.SealParams{
+		BrandID:    "canonical",
+		Model:      "pc",
+		BootAssets: map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"},
 	})
-	cur, err := s.mgr.Model()
-	c.Assert(err, IsNil)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+}
 
-	s.newFakeStore = func(devBE storecontext.DeviceBackend) snapstate.StoreService {
-		mod, err := devBE.Model()
-		c.Check(err, IsNil)
-		if err == nil {
-			c.Check(mod, DeepEquals, new)
-		}
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreResealsKernelCmdline(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return gadget.ErrNoUpdate
+	})
+	defer restore()
+	var currentParams, resealParams []*secboot.SealParams
+	restore = devicestate.MockSecbootResealKey(func(keyFile string, current, params *secboot.SealParams) error {
+		currentParams = append(currentParams, current)
+		resealParams = append(resealParams, params)
 		return nil
-	}
+	})
+	defer restore()
+	s.mockEncryptedDataKey(c)
 
-	remodCtx, err := devicestate.RemodelCtx(s.state, cur, new)
-	c.Assert(err, IsNil)
-	c.Check(remodCtx.Kind(), Equals, devicestate.ReregRemodel)
+	s.bootloader.SetBootVars(map[string]string{
+		"snapd_extra_cmdline_args": "console=ttyS0",
+	})
+	chg, t := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.full", "console=tty1"},
+	})
+	s.state.Lock()
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(t)
+	chg.AddTask(terr)
+	s.state.Unlock()
 
-	t := s.state.NewTask("request-serial", "test")
-	chg := s.state.NewChange("remodel", "...")
-	// associate with context
-	remodCtx.Init(chg)
-	chg.AddTask(t)
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-	// sanity
-	regCtx, err := devicestate.RegistrationCtx(s.mgr, t)
-	c.Assert(err, IsNil)
-	c.Check(regCtx, Equals, remodCtx.(devicestate.RegistrationContext))
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(t.Status(), Equals, state.UndoneStatus)
+	// only the kernel command line changed, the key is bound to the
+	// new one and then back to the previous one
+	c.Assert(resealParams, HasLen, 2)
+	c.Check(currentParams[0].KernelCmdline, DeepEquals, &gadget.KernelCmdline{Extra: "console=ttyS0"})
+	c.Check(resealParams[0].KernelCmdline, DeepEquals, &gadget.KernelCmdline{Full: "console=tty1"})
+	c.Check(currentParams[1].KernelCmdline, DeepEquals, &gadget.KernelCmdline{Full: "console=tty1"})
+	c.Check(resealParams[1].KernelCmdline, IsNil)
+}
 
-	// avoid full seeding
-	s.seeding()
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdlineModelAllow(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return gadget.ErrNoUpdate
+	})
+	defer restore()
 
+	s.state.Lock()
+	s.makeModelAssertionInState(c, "canonical", "pc-allow", map[string]interface{}{
+		"architecture":         "amd64",
+		"kernel":               "pc-kernel",
+		"gadget":               "pc",
+		"kernel-cmdline-allow": []interface{}{"panic"},
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-allow",
+	})
 	s.state.Unlock()
+
+	// the model allows what the gadget does not, and nothing else
+	chg, _ := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.extra", "panic=-1"},
+	})
 	s.se.Ensure()
 	s.se.Wait()
 	s.state.Lock()
-
-	c.Check(chg.Status(), Equals, state.DoneStatus, Commentf("%s", t.Log()))
 	c.Check(chg.Err(), IsNil)
-	device, err := devicestatetest.Device(s.state)
-	c.Check(err, IsNil)
-	c.Check(device.Serial, Equals, "9999")
-	_, err = s.db.Find(asserts.SerialType, map[string]string{
-		"brand-id": "rereg-brand",
-		"model":    "rereg-model",
-		"serial":   "9999",
-	})
-	c.Assert(err, IsNil)
-}
+	c.Check(s.bootloader.BootVars["snapd_extra_cmdline_args"], Equals, "panic=-1")
+	s.state.Unlock()
 
-func (s *deviceMgrSuite) TestDeviceCtxNoTask(c *C) {
+	chg, _ = setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.extra", "console=ttyS0"},
+	})
+	s.se.Ensure()
+	s.se.Wait()
 	s.state.Lock()
 	defer s.state.Unlock()
-	// nothing in the state
-
-	_, err := devicestate.DeviceCtx(s.state, nil, nil)
-	c.Check(err, Equals, state.ErrNoState)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*argument "console=ttyS0" is not allowed.*`)
+}
 
-	// have a model assertion
-	model := s.brands.Model("canonical", "pc", map[string]interface{}{
-		"gadget":       "pc",
-		"kernel":       "kernel",
-		"architecture": "amd64",
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreResealError(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
 	})
-	assertstatetest.AddMany(s.state, model)
-	devicestatetest.SetDevice(s.state, &auth.DeviceState{
-		Brand: "canonical",
-		Model: "pc",
+	defer restore()
+	restore = devicestate.MockSecbootResealKey(func(keyFile string, current, params *secboot.SealParams) error {
+		return fmt.Errorf("boom")
 	})
+	defer restore()
+	s.mockEncryptedDataKey(c)
 
-	deviceCtx, err := devicestate.DeviceCtx(s.state, nil, nil)
-	c.Assert(err, IsNil)
-	c.Assert(deviceCtx.Model().BrandID(), Equals, "canonical")
-}
+	chg, t := setupGadgetUpdate(c, s.state)
+
+	s.se.Ensure()
+	s.se.Wait()
 
-func (s *deviceMgrSuite) TestDeviceCtxProvided(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), ErrorMatches, "(?s).*cannot reseal the key of the encrypted data partition: boom.*")
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(s.restartRequests, HasLen, 0)
+}
 
-	model := assertstest.FakeAssertion(map[string]interface{}{
-		"type":         "model",
-		"authority-id": "canonical",
-		"series":       "16",
-		"brand-id":     "canonical",
-		"model":        "pc",
-		"gadget":       "pc",
-		"kernel":       "kernel",
-		"architecture": "amd64",
-	}).(*asserts.Model)
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNoResealWithoutEncryption(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+	restore = devicestate.MockSecbootResealKey(func(keyFile string, current, params *secboot.SealParams) error {
+		c.Fatalf("unexpected reseal")
+		return nil
+	})
+	defer restore()
 
-	deviceCtx := &snapstatetest.TrivialDeviceContext{DeviceModel: model}
+	chg, _ := setupGadgetUpdate(c, s.state)
 
-	deviceCtx1, err := devicestate.DeviceCtx(s.state, nil, deviceCtx)
-	c.Assert(err, IsNil)
-	c.Assert(deviceCtx1, Equals, deviceCtx)
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
 }
 
-var snapYaml = `
-name: foo-gadget
-type: gadget
-`
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreTrackBootAssetsError(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+	restore = devicestate.MockGadgetBootAssetsDigests(func(gd gadget.GadgetData) (map[string]string, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
 
-var gadgetYaml = `
-volumes:
-  pc:
-    bootloader: grub
-`
+	s.state.Lock()
+	s.state.Set("boot-assets", map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	s.state.Unlock()
 
-func setupGadgetUpdate(c *C, st *state.State) (chg *state.Change, tsk *state.Task) {
-	siCurrent := &snap.SideInfo{
-		RealName: "foo-gadget",
-		Revision: snap.R(33),
-		SnapID:   "foo-id",
-	}
-	si := &snap.SideInfo{
-		RealName: "foo-gadget",
-		Revision: snap.R(34),
-		SnapID:   "foo-id",
+	chg, t := setupGadgetUpdate(c, s.state)
+
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
 	}
-	snaptest.MockSnapWithFiles(c, snapYaml, siCurrent, [][]string{
-		{"meta/gadget.yaml", gadgetYaml},
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	// the update itself succeeded
+	c.Assert(chg.Err(), IsNil)
+	c.Check(strings.Join(t.Log(), ""), Matches, `.* cannot track boot assets: boom`)
+	// the old digests are kept
+	tracked, err := devicestate.TrackedBootAssets(s.state)
+	c.Assert(err, IsNil)
+	c.Check(tracked, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreUndoRestoresBootAssets(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
 	})
-	snaptest.MockSnapWithFiles(c, snapYaml, si, [][]string{
-		{"meta/gadget.yaml", gadgetYaml},
+	defer restore()
+	restore = devicestate.MockGadgetBootAssetsDigests(func(gd gadget.GadgetData) (map[string]string, error) {
+		return map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"}, nil
+	})
+	defer restore()
+	var currentParams, resealParams []*secboot.SealParams
+	restore = devicestate.MockSecbootResealKey(func(keyFile string, current, params *secboot.SealParams) error {
+		currentParams = append(currentParams, current)
+		resealParams = append(resealParams, params)
+		return nil
 	})
+	defer restore()
+	s.mockEncryptedDataKey(c)
 
-	st.Lock()
-
-	snapstate.Set(st, "foo-gadget", &snapstate.SnapState{
-		SnapType: "gadget",
-		Sequence: []*snap.SideInfo{siCurrent},
-		Current:  siCurrent.Revision,
-		Active:   true,
-	})
+	s.state.Lock()
+	s.state.Set("boot-assets", map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	s.state.Unlock()
 
-	tsk = st.NewTask("update-gadget-assets", "update gadget")
-	tsk.Set("snap-setup", &snapstate.SnapSetup{
-		SideInfo: si,
-		Type:     snap.TypeGadget,
-	})
-	chg = st.NewChange("dummy", "...")
-	chg.AddTask(tsk)
+	chg, t := setupGadgetUpdate(c, s.state)
+	s.state.Lock()
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(t)
+	chg.AddTask(terr)
+	s.state.Unlock()
 
-	st.Unlock()
+	for i := 0; i < 6; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
 
-	return chg, tsk
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), ErrorMatches, "(?s).*provoking total undo.*")
+	c.Check(t.Status(), Equals, state.UndoneStatus)
+	// the digests of the previous assets are tracked again
+	tracked, err := devicestate.TrackedBootAssets(s.state)
+	c.Assert(err, IsNil)
+	c.Check(tracked, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	// and the key is bound to them
+	c.Assert(resealParams, HasLen, 2)
+	c.Check(resealParams[1].BootAssets, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	// from the ones of the update it was bound to before
+	c.Check(currentParams[0].BootAssets, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	c.Check(currentParams[1].BootAssets, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"})
 }
 
-func (s *deviceMgrSuite) TestUpdateGadgetOnCoreSimple(c *C) {
-	var updateCalled bool
-	var passedRollbackDir string
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreUndoRollbackFailedKeepsBootAssets(c *C) {
 	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
-		updateCalled = true
-		passedRollbackDir = path
-		st, err := os.Stat(path)
-		c.Assert(err, IsNil)
-		m := st.Mode()
-		c.Assert(m.IsDir(), Equals, true)
-		c.Check(m.Perm(), Equals, os.FileMode(0750))
 		return nil
 	})
 	defer restore()
+	restore = devicestate.MockGadgetRollback(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("rollback failed")
+	})
+	defer restore()
+	restore = devicestate.MockGadgetBootAssetsDigests(func(gd gadget.GadgetData) (map[string]string, error) {
+		return map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"}, nil
+	})
+	defer restore()
+
+	s.state.Lock()
+	s.state.Set("boot-assets", map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "old-digest"})
+	s.state.Unlock()
 
 	chg, t := setupGadgetUpdate(c, s.state)
+	s.state.Lock()
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(t)
+	chg.AddTask(terr)
+	s.state.Unlock()
 
 	for i := 0; i < 6; i++ {
 		s.se.Ensure()
@@ -3221,14 +4657,12 @@ func (s *deviceMgrSuite) TestUpdateGadgetOnCoreSimple(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 	c.Assert(chg.IsReady(), Equals, true)
-	c.Check(chg.Err(), IsNil)
-	c.Check(t.Status(), Equals, state.DoneStatus)
-	c.Check(updateCalled, Equals, true)
-	rollbackDir := filepath.Join(dirs.SnapRollbackDir, "foo-gadget_34")
-	c.Check(rollbackDir, Equals, passedRollbackDir)
-	// should have been removed right after update
-	c.Check(osutil.IsDirectory(rollbackDir), Equals, false)
-	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+	c.Check(chg.Err(), ErrorMatches, "(?s).*cannot rollback gadget assets: rollback failed.*")
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	// the assets of the update are still in place, and so are their digests
+	tracked, err := devicestate.TrackedBootAssets(s.state)
+	c.Assert(err, IsNil)
+	c.Check(tracked, DeepEquals, map[string]string{"/boot/efi/EFI/boot/grubx64.efi": "digest"})
 }
 
 func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNoUpdateNeeded(c *C) {
@@ -3255,6 +4689,78 @@ func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNoUpdateNeeded(c *C) {
 	c.Check(s.restartRequests, HasLen, 0)
 }
 
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdline(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return nil
+	})
+	defer restore()
+
+	chg, t := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.extra", "console=ttyS0 isolcpus=1\n"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.bootloader.BootVars["snapd_extra_cmdline_args"], Equals, "console=ttyS0 isolcpus=1")
+	c.Check(s.bootloader.BootVars["snapd_full_cmdline_args"], Equals, "")
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreOnlyKernelCmdlineChanged(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return gadget.ErrNoUpdate
+	})
+	defer restore()
+
+	chg, t := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.full", "console=tty1"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Assert(t.Log(), HasLen, 1)
+	c.Check(t.Log()[0], Matches, ".* INFO Updated kernel command line")
+	c.Check(s.bootloader.BootVars["snapd_full_cmdline_args"], Equals, "console=tty1")
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdlineNotAllowed(c *C) {
+	var called bool
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		called = true
+		return nil
+	})
+	defer restore()
+
+	chg, t := setupGadgetUpdateWithFiles(c, s.state, [][]string{
+		{"cmdline.extra", "init=/bin/sh"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*argument "init=/bin/sh" is not allowed.*`)
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(called, Equals, false)
+	c.Check(s.bootloader.BootVars["snapd_extra_cmdline_args"], Equals, "")
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
 func (s *deviceMgrSuite) TestUpdateGadgetOnCoreRollbackDirCreateFailed(c *C) {
 	if os.Geteuid() == 0 {
 		c.Skip("this test cannot run as root (permissions are not honored)")
@@ -3308,12 +4814,7 @@ func (s *deviceMgrSuite) TestUpdateGadgetOnCoreUpdateFailed(c *C) {
 	c.Check(s.restartRequests, HasLen, 0)
 }
 
-func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNotDuringFirstboot(c *C) {
-	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
-		return errors.New("unexpected call")
-	})
-	defer restore()
-
+func (s *deviceMgrSuite) setupGadgetUpdateDuringFirstboot(c *C, files [][]string) (chg *state.Change, tsk *state.Task) {
 	// simulate first-boot/seeding, there is no existing snap state information
 
 	si := &snap.SideInfo{
@@ -3321,21 +4822,31 @@ func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNotDuringFirstboot(c *C) {
 		Revision: snap.R(34),
 		SnapID:   "foo-id",
 	}
-	snaptest.MockSnapWithFiles(c, snapYaml, si, [][]string{
+	snaptest.MockSnapWithFiles(c, snapYaml, si, append([][]string{
 		{"meta/gadget.yaml", gadgetYaml},
-	})
+	}, files...))
 
 	s.state.Lock()
+	defer s.state.Unlock()
 
-	t := s.state.NewTask("update-gadget-assets", "update gadget")
-	t.Set("snap-setup", &snapstate.SnapSetup{
+	tsk = s.state.NewTask("update-gadget-assets", "update gadget")
+	tsk.Set("snap-setup", &snapstate.SnapSetup{
 		SideInfo: si,
 		Type:     snap.TypeGadget,
 	})
-	chg := s.state.NewChange("dummy", "...")
-	chg.AddTask(t)
+	chg = s.state.NewChange("dummy", "...")
+	chg.AddTask(tsk)
 
-	s.state.Unlock()
+	return chg, tsk
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNotDuringFirstboot(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("unexpected call")
+	})
+	defer restore()
+
+	chg, t := s.setupGadgetUpdateDuringFirstboot(c, nil)
 
 	for i := 0; i < 6; i++ {
 		s.se.Ensure()
@@ -3352,6 +4863,78 @@ func (s *deviceMgrSuite) TestUpdateGadgetOnCoreNotDuringFirstboot(c *C) {
 	c.Check(s.restartRequests, HasLen, 0)
 }
 
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdlineDuringFirstboot(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("unexpected call")
+	})
+	defer restore()
+
+	chg, t := s.setupGadgetUpdateDuringFirstboot(c, [][]string{
+		{"cmdline.extra", "console=ttyS0"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.bootloader.BootVars["snapd_extra_cmdline_args"], Equals, "console=ttyS0")
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdlineSetInImage(c *C) {
+	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("unexpected call")
+	})
+	defer restore()
+
+	// the image was prepared with the gadget kernel command line
+	s.bootloader.SetBootVars(map[string]string{
+		"snapd_extra_cmdline_args": "console=ttyS0",
+	})
+	chg, t := s.setupGadgetUpdateDuringFirstboot(c, [][]string{
+		{"cmdline.extra", "console=ttyS0"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
+func (s *deviceMgrSuite) TestUpdateGadgetOnCoreKernelCmdlineWhilePreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	restore = devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
+		return errors.New("unexpected call")
+	})
+	defer restore()
+
+	chg, t := s.setupGadgetUpdateDuringFirstboot(c, [][]string{
+		{"cmdline.full", "console=tty1"},
+	})
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.IsReady(), Equals, true)
+	c.Check(chg.Err(), IsNil)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.bootloader.BootVars["snapd_full_cmdline_args"], Equals, "console=tty1")
+	// the preseeded image boots with it in the first place
+	c.Check(s.restartRequests, HasLen, 0)
+}
+
 func (s *deviceMgrSuite) TestUpdateGadgetOnCoreBadGadgetYaml(c *C) {
 	restore := devicestate.MockGadgetUpdate(func(current, update gadget.GadgetData, path string) error {
 		return errors.New("unexpected call")
@@ -3521,6 +5104,9 @@ volumes:
 					Bootloader: "grub",
 				},
 			},
+			KernelCmdline: gadget.KernelCmdlineConstraints{
+				Allow: []string{"console", "isolcpus"},
+			},
 		},
 		RootDir: ci.MountDir(),
 	})
@@ -3536,6 +5122,57 @@ volumes:
 		RootDir: ui.MountDir(),
 	})
 }
+func (s *deviceMgrSuite) TestCurrentAndUpdateInfoRemodelDifferentGadget(c *C) {
+	siCurrent := &snap.SideInfo{
+		RealName: "foo-gadget",
+		Revision: snap.R(33),
+		SnapID:   "foo-id",
+	}
+	si := &snap.SideInfo{
+		RealName: "new-gadget",
+		Revision: snap.R(1),
+		SnapID:   "new-gadget-id",
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "foo-gadget",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+
+	snapstate.Set(s.state, "foo-gadget", &snapstate.SnapState{
+		SnapType: "gadget",
+		Sequence: []*snap.SideInfo{siCurrent},
+		Current:  siCurrent.Revision,
+		Active:   true,
+	})
+	snaptest.MockSnapWithFiles(c, snapYaml, siCurrent, [][]string{
+		{"meta/gadget.yaml", gadgetYaml},
+	})
+	ui := snaptest.MockSnapWithFiles(c, "name: new-gadget\ntype: gadget\n", si, [][]string{
+		{"meta/gadget.yaml", gadgetYaml},
+	})
+
+	snapsup := &snapstate.SnapSetup{
+		SideInfo: si,
+		Type:     snap.TypeGadget,
+	}
+
+	// the assets of the gadget of the current model get updated
+	current, update, err := devicestate.GadgetCurrentAndUpdate(s.state, snapsup)
+	c.Assert(err, IsNil)
+	c.Assert(current, NotNil)
+	c.Check(current.RootDir, Equals, filepath.Join(dirs.SnapMountDir, "foo-gadget/33"))
+	c.Assert(update, NotNil)
+	c.Check(update.RootDir, Equals, ui.MountDir())
+}
 
 func (s *deviceMgrSuite) TestGadgetUpdateBlocksWhenOtherTasks(c *C) {
 	restore := release.MockOnClassic(true)
@@ -3582,3 +5219,43 @@ func (s *deviceMgrSuite) TestGadgetUpdateBlocksOtherTasks(c *C) {
 	// not blocking without gadget update task
 	c.Assert(devicestate.GadgetUpdateBlocked(t1, []*state.Task{t2}), Equals, false)
 }
+
+func (s *deviceMgrSuite) TestDoMarkPreseeded(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	s.state.Lock()
+	chg := s.state.NewChange("seed", "...")
+	t := s.state.NewTask("mark-preseeded", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	runner := s.o.TaskRunner()
+	for i := 0; i < 2; i++ {
+		runner.Ensure()
+		runner.Wait()
+	}
+
+	s.state.Lock()
+	c.Check(t.Status(), Equals, state.DoingStatus)
+	var preseeded bool
+	c.Assert(s.state.Get("preseeded", &preseeded), IsNil)
+	c.Check(preseeded, Equals, true)
+	var preseedTime time.Time
+	c.Assert(s.state.Get("preseed-time", &preseedTime), IsNil)
+	c.Check(preseedTime.IsZero(), Equals, false)
+	// only requested once
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartDaemon})
+	s.state.Unlock()
+
+	// first boot
+	restore = snapdenv.MockPreseeding(false)
+	defer restore()
+	runner.Ensure()
+	runner.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.restartRequests, HasLen, 1)
+}
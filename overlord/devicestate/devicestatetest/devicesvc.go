@@ -43,6 +43,8 @@ type DeviceServiceBehavior struct {
 	Head          func(c *C, bhv *DeviceServiceBehavior, w http.ResponseWriter, r *http.Request)
 	PostPreflight func(c *C, bhv *DeviceServiceBehavior, w http.ResponseWriter, r *http.Request)
 
+	CheckSerialRequest func(c *C, bhv *DeviceServiceBehavior, serialReq *asserts.SerialRequest)
+
 	SignSerial func(c *C, bhv *DeviceServiceBehavior, headers map[string]interface{}, body []byte) (asserts.Assertion, error)
 }
 
@@ -127,6 +129,9 @@ func MockDeviceService(c *C, bhv *DeviceServiceBehavior) *httptest.Server {
 			}
 			err = asserts.SignatureCheck(serialReq, serialReq.DeviceKey())
 			c.Assert(err, IsNil)
+			if bhv.CheckSerialRequest != nil {
+				bhv.CheckSerialRequest(c, bhv, serialReq)
+			}
 			brandID := serialReq.BrandID()
 			model := serialReq.Model()
 			reqID := serialReq.RequestID()
@@ -28,6 +28,7 @@ import (
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
+	"github.com/snapcore/snapd/secboot"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -59,6 +60,14 @@ func MockRetryInterval(interval time.Duration) (restore func()) {
 	}
 }
 
+func MockSeedRetryInterval(interval time.Duration) (restore func()) {
+	old := seedRetryInterval
+	seedRetryInterval = interval
+	return func() {
+		seedRetryInterval = old
+	}
+}
+
 func MockMaxTentatives(max int) (restore func()) {
 	old := maxTentatives
 	maxTentatives = max
@@ -121,6 +130,10 @@ func MockPopulateStateFromSeed(f func(*state.State, timings.Measurer) ([]*state.
 	}
 }
 
+func EnsureOperational(m *DeviceManager) error {
+	return m.ensureOperational()
+}
+
 func EnsureBootOk(m *DeviceManager) error {
 	return m.ensureBootOk()
 }
@@ -129,8 +142,14 @@ func SetBootOkRan(m *DeviceManager, b bool) {
 	m.bootOkRan = b
 }
 
+func EnsureModelConformance(m *DeviceManager) error {
+	return m.ensureModelConformance()
+}
+
 type RegistrationContext = registrationContext
 
+type FactoryResetFilesystem = factoryResetFilesystem
+
 func RegistrationCtx(m *DeviceManager, t *state.Task) (registrationContext, error) {
 	return m.registrationCtx(t)
 }
@@ -145,6 +164,7 @@ var (
 	ImportAssertionsFromSeed = importAssertionsFromSeed
 	CheckGadgetOrKernel      = checkGadgetOrKernel
 	CanAutoRefresh           = canAutoRefresh
+	ReportRefreshMetrics     = reportRefreshMetrics
 	NewEnoughProxy           = newEnoughProxy
 
 	IncEnsureOperationalAttempts = incEnsureOperationalAttempts
@@ -168,3 +188,95 @@ func MockGadgetUpdate(mock func(current, update gadget.GadgetData, path string)
 		gadgetUpdate = old
 	}
 }
+
+func MockGadgetRollback(mock func(current, update gadget.GadgetData, path string) error) (restore func()) {
+	old := gadgetRollback
+	gadgetRollback = mock
+	return func() {
+		gadgetRollback = old
+	}
+}
+
+func MockSecbootResealKey(mock func(keyFile string, current, params *secboot.SealParams) error) (restore func()) {
+	old := secbootResealKey
+	secbootResealKey = mock
+	return func() {
+		secbootResealKey = old
+	}
+}
+
+func MockGadgetBootAssetsDigests(mock func(gd gadget.GadgetData) (map[string]string, error)) (restore func()) {
+	old := gadgetBootAssetsDigests
+	gadgetBootAssetsDigests = mock
+	return func() {
+		gadgetBootAssetsDigests = old
+	}
+}
+
+func MockSystemDataDir(f func(*state.State) (string, error)) (restore func()) {
+	old := systemDataDir
+	systemDataDir = f
+	return func() {
+		systemDataDir = old
+	}
+}
+
+func MockFactoryResetFilesystems(f func(*state.State) ([]factoryResetFilesystem, error)) (restore func()) {
+	old := factoryResetFilesystems
+	factoryResetFilesystems = f
+	return func() {
+		factoryResetFilesystems = old
+	}
+}
+
+func MockGadgetMkfs(f func(filesystem, node, label string) error) (restore func()) {
+	old := gadgetMkfs
+	gadgetMkfs = f
+	return func() {
+		gadgetMkfs = old
+	}
+}
+
+func MockGadgetReplacePartition(f func(disk string, entry *gadget.PartitionEntry) (string, error)) (restore func()) {
+	old := gadgetReplacePartition
+	gadgetReplacePartition = f
+	return func() {
+		gadgetReplacePartition = old
+	}
+}
+
+func MockBootID(f func() (string, error)) (restore func()) {
+	old := osutilBootID
+	osutilBootID = f
+	return func() {
+		osutilBootID = old
+	}
+}
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func MockTimeSynchronized(f func() bool) (restore func()) {
+	old := timeSynchronized
+	timeSynchronized = f
+	return func() {
+		timeSynchronized = old
+	}
+}
+
+func MockMinSaneTime(t time.Time) (restore func()) {
+	old := minSaneTime
+	minSaneTime = t
+	return func() {
+		minSaneTime = old
+	}
+}
+
+func EnsureTimeSanity(m *DeviceManager) error {
+	return m.ensureTimeSanity()
+}
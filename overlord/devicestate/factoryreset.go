@@ -0,0 +1,623 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+// FactoryResetOptions carries the options of a factory reset.
+type FactoryResetOptions struct {
+	// RegenerateIdentity drops the device key and serial so that the
+	// device registers anew after the reset, instead of keeping its
+	// current identity.
+	RegenerateIdentity bool `json:"regenerate-identity,omitempty"`
+}
+
+// factoryResetRequest is written to dirs.SnapFactoryResetFile by the
+// prepare-factory-reset task and carried out early during the next boot
+// by snapd.factory-reset.service, before the services of the snaps start.
+type factoryResetRequest struct {
+	// SystemData is where the system-data filesystem is mounted.
+	SystemData string `json:"system-data"`
+	// Preserve lists the paths, relative to SystemData, that
+	// survive the reset.
+	Preserve []string `json:"preserve"`
+	// BootID is the boot id at the time of the request, the reset is
+	// carried out only once the system was rebooted.
+	BootID string `json:"boot-id"`
+	// Recreate lists the partitions and filesystems, as laid out by
+	// the gadget, that are created anew.
+	Recreate []factoryResetFilesystem `json:"recreate,omitempty"`
+	// Identity is the device identity to restore after the reset,
+	// if any.
+	Identity *factoryResetIdentity `json:"identity,omitempty"`
+	// Performed is set once the reset was attempted.
+	Performed bool `json:"performed,omitempty"`
+	// Error is set when the reset could not be carried out fully.
+	Error string `json:"error,omitempty"`
+}
+
+type factoryResetFilesystem struct {
+	// Disk is the disk holding the partition of the filesystem.
+	Disk string `json:"disk,omitempty"`
+	// Partition is the partition of the filesystem as laid out by
+	// the gadget, it is created anew on Disk.
+	Partition *gadget.PartitionEntry `json:"partition,omitempty"`
+	// Node is the device node of the partition at the time of the
+	// request.
+	Node       string `json:"node"`
+	Filesystem string `json:"filesystem"`
+	Label      string `json:"label"`
+}
+
+type factoryResetIdentity struct {
+	Brand  string `json:"brand"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	KeyID  string `json:"key-id"`
+	// SerialAssertion is the encoded serial assertion of the device.
+	SerialAssertion string `json:"serial-assertion"`
+}
+
+var osutilBootID = osutil.BootID
+
+// FactoryReset creates a change that resets the device to the state it
+// was in when it was first seeded: all the system and user data is
+// wiped and the device reboots into the base and kernel snaps of the
+// seed, which is then seeded again. The device identity is kept unless
+// asked otherwise.
+func FactoryReset(st *state.State, opts *FactoryResetOptions) (*state.Change, error) {
+	if release.OnClassic {
+		return nil, fmt.Errorf("cannot factory reset a classic system")
+	}
+
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if !seeded {
+		return nil, fmt.Errorf("cannot factory reset until fully seeded")
+	}
+
+	for _, chg := range st.Changes() {
+		if !chg.IsReady() {
+			return nil, fmt.Errorf("cannot factory reset while change %q is in progress", chg.ID())
+		}
+	}
+
+	if opts == nil {
+		opts = &FactoryResetOptions{}
+	}
+
+	prepare := st.NewTask("prepare-factory-reset", i18n.G("Prepare factory reset"))
+	prepare.Set("factory-reset-options", opts)
+
+	chg := st.NewChange("factory-reset", i18n.G("Reset device to its factory state"))
+	chg.AddTask(prepare)
+
+	return chg, nil
+}
+
+func (m *DeviceManager) doPrepareFactoryReset(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var opts FactoryResetOptions
+	if err := t.Get("factory-reset-options", &opts); err != nil && err != state.ErrNoState {
+		return err
+	}
+
+	model, err := findModel(st)
+	if err != nil {
+		return err
+	}
+
+	systemData, err := systemDataDir(st)
+	if err != nil {
+		return err
+	}
+	recreate, err := factoryResetFilesystems(st)
+	if err != nil {
+		return err
+	}
+
+	bootID, err := osutilBootID()
+	if err != nil {
+		return err
+	}
+
+	req := &factoryResetRequest{
+		SystemData: systemData,
+		Preserve: []string{
+			systemDataRelative(dirs.SnapSeedDir),
+			systemDataRelative(dirs.SnapFactoryResetFile),
+		},
+		BootID:   bootID,
+		Recreate: recreate,
+	}
+
+	// the seeded base and kernel need to be in place to boot into
+	// them, they might have been removed by refreshes since
+	baseName := model.Base()
+	if baseName == "" {
+		baseName = "core"
+	}
+	seed, err := snap.ReadSeedYaml(filepath.Join(dirs.SnapSeedDir, "seed.yaml"))
+	if err != nil {
+		return err
+	}
+	baseInfo, _, err := installSeedBootSnap(st, seed, baseName)
+	if err != nil {
+		return err
+	}
+	kernelInfo, kernelFile, err := installSeedBootSnap(st, seed, model.Kernel())
+	if err != nil {
+		return err
+	}
+	if err := boot.ExtractKernelAssets(kernelInfo, kernelFile); err != nil {
+		return err
+	}
+	req.Preserve = append(req.Preserve,
+		systemDataRelative(baseInfo.MountFile()),
+		systemDataRelative(kernelInfo.MountFile()))
+
+	if !opts.RegenerateIdentity {
+		identity, err := m.factoryResetIdentity()
+		if err != nil {
+			return err
+		}
+		if identity != nil {
+			req.Identity = identity
+			req.Preserve = append(req.Preserve, systemDataRelative(dirs.SnapDeviceDir))
+		}
+	}
+
+	if err := writeFactoryResetRequest(req); err != nil {
+		return err
+	}
+
+	if err := boot.SetBootSnaps(filepath.Base(baseInfo.MountFile()), filepath.Base(kernelInfo.MountFile())); err != nil {
+		os.Remove(dirs.SnapFactoryResetFile)
+		return err
+	}
+
+	t.Logf("System data of %s will be reset after the reboot", systemData)
+	t.SetStatus(state.DoneStatus)
+
+	st.RequestRestart(state.RestartSystem)
+
+	return nil
+}
+
+// installSeedBootSnap makes sure the file of the given boot snap of the
+// seed is in place, under the name seeding installs it with.
+func installSeedBootSnap(st *state.State, seed *snap.Seed, name string) (*snap.Info, snap.Container, error) {
+	var sn *snap.SeedSnap
+	for _, seedSnap := range seed.Snaps {
+		if seedSnap.Name == name {
+			sn = seedSnap
+			break
+		}
+	}
+	if sn == nil {
+		return nil, nil, fmt.Errorf("cannot find %q in the seed", name)
+	}
+
+	seedPath := filepath.Join(dirs.SnapSeedDir, "snaps", sn.File)
+	// seeding installs unasserted snaps with the first local revision
+	sideInfo := &snap.SideInfo{RealName: sn.Name, Revision: snap.R(-1)}
+	if !sn.Unasserted {
+		si, err := snapasserts.DeriveSideInfo(seedPath, assertstate.DB(st))
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot find signatures with metadata for seed snap %q: %v", name, err)
+		}
+		sideInfo = si
+	}
+
+	snapf, err := snap.Open(seedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := snap.ReadInfoFromSnapFile(snapf, sideInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !osutil.FileExists(info.MountFile()) {
+		if err := os.MkdirAll(dirs.SnapBlobDir, 0755); err != nil {
+			return nil, nil, err
+		}
+		if err := osutil.CopyFile(seedPath, info.MountFile(), osutil.CopyFlagPreserveAll|osutil.CopyFlagSync); err != nil {
+			return nil, nil, fmt.Errorf("cannot restore seed snap %q: %v", name, err)
+		}
+	}
+
+	return info, snapf, nil
+}
+
+func modelGadgetSnapInfo(st *state.State) (*snap.Info, error) {
+	deviceCtx, err := DeviceCtx(st, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return snapstate.GadgetInfo(st, deviceCtx)
+}
+
+func modelGadgetInfo(st *state.State) (*gadget.Info, error) {
+	gadgetInfo, err := modelGadgetSnapInfo(st)
+	if err != nil {
+		return nil, err
+	}
+	const onClassic = false
+	return snap.ReadGadgetInfo(gadgetInfo, onClassic)
+}
+
+// factoryResetConstraints match the ones of ubuntu-image, that laid out
+// the volumes of the gadget.
+var factoryResetConstraints = gadget.PositioningConstraints{
+	NonMBRStartOffset: 1 * gadget.SizeMiB,
+	SectorSize:        512,
+}
+
+// factoryResetFilesystems returns the filesystems of the gadget layout
+// that carry no factory data, whose partitions and filesystems are
+// created anew by a reset. The system-data filesystem holds the seed,
+// it is wiped in place instead.
+var factoryResetFilesystems = func(st *state.State) ([]factoryResetFilesystem, error) {
+	gadgetInfo, err := modelGadgetSnapInfo(st)
+	if err != nil {
+		return nil, err
+	}
+	const onClassic = false
+	gi, err := snap.ReadGadgetInfo(gadgetInfo, onClassic)
+	if err != nil {
+		return nil, err
+	}
+
+	var recreate []factoryResetFilesystem
+	for name, vol := range gi.Volumes {
+		var pv *gadget.PositionedVolume
+		for i := range vol.Structure {
+			vs := vol.Structure[i]
+			if vs.EffectiveRole() != gadget.SystemSave || vs.IsBare() {
+				continue
+			}
+			if pv == nil {
+				pv, err = gadget.PositionVolume(gadgetInfo.MountDir(), &vol, factoryResetConstraints)
+				if err != nil {
+					return nil, fmt.Errorf("cannot position volume %q: %v", name, err)
+				}
+			}
+			ps := &pv.PositionedStructure[i]
+			vs.Label = vs.EffectiveFilesystemLabel()
+			node, err := gadget.FindDeviceForStructure(&gadget.PositionedStructure{VolumeStructure: &vs, Index: i})
+			if err != nil {
+				return nil, fmt.Errorf("cannot find the device of structure %v: %v", ps, err)
+			}
+			disk, err := gadget.ParentDisk(node)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find the disk of structure %v: %v", ps, err)
+			}
+			entry, err := gadget.PartitionEntryForStructure(pv, i)
+			if err != nil {
+				return nil, err
+			}
+			recreate = append(recreate, factoryResetFilesystem{
+				Disk:       disk,
+				Partition:  entry,
+				Node:       node,
+				Filesystem: vs.Filesystem,
+				Label:      vs.Label,
+			})
+		}
+	}
+	return recreate, nil
+}
+
+// systemDataDir returns where the system-data filesystem, as laid out
+// by the gadget, is mounted.
+var systemDataDir = func(st *state.State) (string, error) {
+	gi, err := modelGadgetInfo(st)
+	if err != nil {
+		return "", err
+	}
+
+	for _, vol := range gi.Volumes {
+		for i := range vol.Structure {
+			vs := vol.Structure[i]
+			if vs.EffectiveRole() != gadget.SystemData {
+				continue
+			}
+			vs.Label = vs.EffectiveFilesystemLabel()
+			ps := &gadget.PositionedStructure{VolumeStructure: &vs, Index: i}
+			mnt, err := gadget.FindMountPointForStructure(ps)
+			if err != nil {
+				return "", fmt.Errorf("cannot find the system-data mount: %v", err)
+			}
+			return mnt, nil
+		}
+	}
+
+	// older gadgets do not declare the system-data structure, it is
+	// then always mounted at /writable
+	return filepath.Join(dirs.GlobalRootDir, "/writable"), nil
+}
+
+// systemDataRelative returns the path within the system-data
+// filesystem of the given system path.
+func systemDataRelative(p string) string {
+	return filepath.Join("system-data", strings.TrimPrefix(p, dirs.GlobalRootDir))
+}
+
+func (m *DeviceManager) factoryResetIdentity() (*factoryResetIdentity, error) {
+	device, err := m.device()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := findSerial(m.state, device)
+	if err == state.ErrNoState {
+		// not registered yet, nothing to keep
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &factoryResetIdentity{
+		Brand:           device.Brand,
+		Model:           device.Model,
+		Serial:          device.Serial,
+		KeyID:           device.KeyID,
+		SerialAssertion: string(asserts.Encode(serial)),
+	}, nil
+}
+
+func readFactoryResetRequest() (*factoryResetRequest, error) {
+	data, err := ioutil.ReadFile(dirs.SnapFactoryResetFile)
+	if err != nil {
+		return nil, err
+	}
+	var req factoryResetRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("cannot decode factory reset request: %v", err)
+	}
+	return &req, nil
+}
+
+func writeFactoryResetRequest(req *factoryResetRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dirs.SnapFactoryResetFile), 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(dirs.SnapFactoryResetFile, data, 0600, 0)
+}
+
+// PerformPendingFactoryReset wipes the system data as requested by a
+// factory-reset change, once the system was rebooted into the seeded
+// base and kernel. It is run by snapd.factory-reset.service early during
+// boot, once the snaps, snapd included, are mounted but before any of
+// their services start. The directories are kept in place as they might
+// be bind-mounted into the running system, everything else not
+// preserved is removed. The partitions of the gadget layout that carry
+// no factory data, and their filesystems, are created anew.
+//
+// The reset is attempted only once: on failure what could not be reset
+// is left in place, the error is recorded in the request and snapd
+// starts anyway.
+func PerformPendingFactoryReset() error {
+	req, err := readFactoryResetRequest()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if req.Performed {
+		return nil
+	}
+
+	bootID, err := osutilBootID()
+	if err != nil {
+		return err
+	}
+	if bootID == req.BootID {
+		// the reboot did not happen yet
+		return nil
+	}
+
+	logger.Noticef("resetting system data in %s", req.SystemData)
+	resetErr := wipeDir(req.SystemData, req.Preserve)
+	for _, fs := range req.Recreate {
+		if resetErr != nil {
+			break
+		}
+		node := fs.Node
+		if fs.Partition != nil {
+			logger.Noticef("creating partition %d of %s", fs.Partition.Number, fs.Disk)
+			node, resetErr = gadgetReplacePartition(fs.Disk, fs.Partition)
+			if resetErr != nil {
+				break
+			}
+		}
+		logger.Noticef("creating %s filesystem %q on %s", fs.Filesystem, fs.Label, node)
+		resetErr = gadgetMkfs(fs.Filesystem, node, fs.Label)
+	}
+
+	if resetErr == nil && req.Identity == nil {
+		return os.Remove(dirs.SnapFactoryResetFile)
+	}
+	// keep the request around until the identity is restored, or to
+	// report the failure
+	req.Performed = true
+	if resetErr != nil {
+		req.Error = resetErr.Error()
+		logger.Noticef("cannot reset system data: %v", resetErr)
+	}
+	if err := writeFactoryResetRequest(req); err != nil {
+		return err
+	}
+	if resetErr != nil {
+		return fmt.Errorf("cannot reset system data: %v", resetErr)
+	}
+	return nil
+}
+
+var (
+	gadgetMkfs             = gadget.Mkfs
+	gadgetReplacePartition = gadget.ReplacePartition
+)
+
+// wipeDir removes everything but directories and the given paths,
+// relative to dir, from dir. It does not cross into other filesystems
+// mounted below dir. It carries on past errors, the first one is
+// returned.
+func wipeDir(dir string, preserve []string) error {
+	keep := make(map[string]bool, len(preserve))
+	for _, p := range preserve {
+		keep[filepath.Clean(p)] = true
+	}
+	var dev uint64
+	var firstErr error
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("cannot stat %s", path)
+		}
+		if path == dir {
+			dev = uint64(st.Dev)
+			return nil
+		}
+		if uint64(st.Dev) != dev {
+			// mount point of another filesystem
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if keep[rel] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// restoreFactoryResetIdentity restores the device identity kept across
+// a factory reset once seeding has set the model again. It returns
+// whether the identity was restored, failing that the device registers
+// anew.
+func (m *DeviceManager) restoreFactoryResetIdentity(device *auth.DeviceState) (bool, error) {
+	req, err := readFactoryResetRequest()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !req.Performed {
+		return false, nil
+	}
+	if req.Error != "" {
+		logger.Noticef("system data was not fully reset: %s", req.Error)
+	}
+	if req.Identity == nil {
+		os.Remove(dirs.SnapFactoryResetFile)
+		return false, nil
+	}
+	// the identity is given only one chance to be restored
+	defer os.Remove(dirs.SnapFactoryResetFile)
+
+	id := req.Identity
+	if id.Brand != device.Brand || id.Model != device.Model {
+		logger.Noticef("cannot restore device identity of %s/%s after factory reset: model is %s/%s", id.Brand, id.Model, device.Brand, device.Model)
+		return false, nil
+	}
+
+	a, err := asserts.Decode([]byte(id.SerialAssertion))
+	if err == nil {
+		err = assertstate.Add(m.state, a)
+	}
+	if err != nil && !asserts.IsUnaccceptedUpdate(err) {
+		logger.Noticef("cannot restore device identity after factory reset: %v", err)
+		return false, nil
+	}
+
+	device.KeyID = id.KeyID
+	device.Serial = id.Serial
+	if err := m.setDevice(device); err != nil {
+		return false, err
+	}
+	logger.Noticef("restored device identity %s/%s/%s after factory reset", id.Brand, id.Model, id.Serial)
+	return true, nil
+}
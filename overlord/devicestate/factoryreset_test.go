@@ -0,0 +1,501 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *deviceMgrSuite) setupFactoryReset(c *C) (systemData string, restore func()) {
+	s.makeModelAssertionInState(c, "my-brand", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand:  "my-brand",
+		Model:  "pc-model",
+		Serial: "serialserial",
+		KeyID:  "key-id",
+	})
+	s.makeSerialAssertionInState(c, "my-brand", "pc-model", "serialserial")
+	s.state.Set("seeded", true)
+
+	seedSnapsDir := filepath.Join(dirs.SnapSeedDir, "snaps")
+	c.Assert(os.MkdirAll(seedSnapsDir, 0755), IsNil)
+	seed := &snap.Seed{}
+	for name, yaml := range map[string]string{
+		"core18":    "name: core18\nversion: 1.0\ntype: base",
+		"pc-kernel": "name: pc-kernel\nversion: 1.0\ntype: kernel",
+	} {
+		snapFile := snaptest.MakeTestSnapWithFiles(c, yaml, nil)
+		c.Assert(os.Rename(snapFile, filepath.Join(seedSnapsDir, name+".snap")), IsNil)
+		seed.Snaps = append(seed.Snaps, &snap.SeedSnap{
+			Name:       name,
+			File:       name + ".snap",
+			Unasserted: true,
+		})
+	}
+	c.Assert(seed.Write(filepath.Join(dirs.SnapSeedDir, "seed.yaml")), IsNil)
+
+	systemData = c.MkDir()
+	r1 := devicestate.MockSystemDataDir(func(*state.State) (string, error) {
+		return systemData, nil
+	})
+	r2 := devicestate.MockBootID(func() (string, error) {
+		return "boot-id-0", nil
+	})
+	r3 := devicestate.MockFactoryResetFilesystems(func(*state.State) ([]devicestate.FactoryResetFilesystem, error) {
+		return []devicestate.FactoryResetFilesystem{{
+			Node:       "/dev/sda4",
+			Filesystem: "ext4",
+			Label:      "ubuntu-save",
+		}}, nil
+	})
+	return systemData, func() {
+		r1()
+		r2()
+		r3()
+	}
+}
+
+func readFactoryResetRequest(c *C) map[string]interface{} {
+	data, err := ioutil.ReadFile(dirs.SnapFactoryResetFile)
+	c.Assert(err, IsNil)
+	var req map[string]interface{}
+	c.Assert(json.Unmarshal(data, &req), IsNil)
+	return req
+}
+
+func (s *deviceMgrSuite) TestFactoryResetUnhappy(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := devicestate.FactoryReset(s.state, nil)
+	c.Check(err, ErrorMatches, "cannot factory reset until fully seeded")
+
+	s.state.Set("seeded", true)
+	chg := s.state.NewChange("install", "...")
+	chg.SetStatus(state.DoingStatus)
+	_, err = devicestate.FactoryReset(s.state, nil)
+	c.Check(err, ErrorMatches, `cannot factory reset while change "1" is in progress`)
+
+	restore := release.MockOnClassic(true)
+	defer restore()
+	_, err = devicestate.FactoryReset(s.state, nil)
+	c.Check(err, ErrorMatches, "cannot factory reset a classic system")
+}
+
+func (s *deviceMgrSuite) TestFactoryResetKeepIdentity(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	systemData, restore := s.setupFactoryReset(c)
+	defer restore()
+
+	chg, err := devicestate.FactoryReset(s.state, nil)
+	c.Assert(err, IsNil)
+	c.Check(chg.Kind(), Equals, "factory-reset")
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Kind(), Equals, "prepare-factory-reset")
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+
+	// the seeded boot snaps are in place and used for the next boot
+	c.Check(filepath.Join(dirs.SnapBlobDir, "core18_x1.snap"), testutil.FilePresent)
+	c.Check(filepath.Join(dirs.SnapBlobDir, "pc-kernel_x1.snap"), testutil.FilePresent)
+	c.Check(s.bootloader.BootVars["snap_core"], Equals, "core18_x1.snap")
+	c.Check(s.bootloader.BootVars["snap_kernel"], Equals, "pc-kernel_x1.snap")
+	c.Check(s.bootloader.BootVars["snap_mode"], Equals, "")
+	c.Assert(s.bootloader.ExtractKernelAssetsCalls, HasLen, 1)
+	c.Check(s.bootloader.ExtractKernelAssetsCalls[0].InstanceName(), Equals, "pc-kernel")
+
+	serial, err := s.mgr.Serial()
+	c.Assert(err, IsNil)
+	req := readFactoryResetRequest(c)
+	c.Check(req, DeepEquals, map[string]interface{}{
+		"system-data": systemData,
+		"preserve": []interface{}{
+			"system-data/var/lib/snapd/seed",
+			"system-data/var/lib/snapd/factory-reset.json",
+			"system-data/var/lib/snapd/snaps/core18_x1.snap",
+			"system-data/var/lib/snapd/snaps/pc-kernel_x1.snap",
+			"system-data/var/lib/snapd/device",
+		},
+		"boot-id": "boot-id-0",
+		"recreate": []interface{}{
+			map[string]interface{}{
+				"node":       "/dev/sda4",
+				"filesystem": "ext4",
+				"label":      "ubuntu-save",
+			},
+		},
+		"identity": map[string]interface{}{
+			"brand":            "my-brand",
+			"model":            "pc-model",
+			"serial":           "serialserial",
+			"key-id":           "key-id",
+			"serial-assertion": string(asserts.Encode(serial)),
+		},
+	})
+}
+
+func (s *deviceMgrSuite) TestFactoryResetRegenerateIdentity(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, restore := s.setupFactoryReset(c)
+	defer restore()
+
+	chg, err := devicestate.FactoryReset(s.state, &devicestate.FactoryResetOptions{
+		RegenerateIdentity: true,
+	})
+	c.Assert(err, IsNil)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Check(s.restartRequests, DeepEquals, []state.RestartType{state.RestartSystem})
+
+	req := readFactoryResetRequest(c)
+	c.Check(req["identity"], IsNil)
+	c.Check(req["preserve"], DeepEquals, []interface{}{
+		"system-data/var/lib/snapd/seed",
+		"system-data/var/lib/snapd/factory-reset.json",
+		"system-data/var/lib/snapd/snaps/core18_x1.snap",
+		"system-data/var/lib/snapd/snaps/pc-kernel_x1.snap",
+	})
+}
+
+func (s *deviceMgrSuite) TestFactoryResetSeedMissingBootSnap(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, restore := s.setupFactoryReset(c)
+	defer restore()
+	c.Assert(os.Remove(filepath.Join(dirs.SnapSeedDir, "seed.yaml")), IsNil)
+	seed := &snap.Seed{Snaps: []*snap.SeedSnap{{Name: "core18", File: "core18.snap", Unasserted: true}}}
+	c.Assert(seed.Write(filepath.Join(dirs.SnapSeedDir, "seed.yaml")), IsNil)
+
+	chg, err := devicestate.FactoryReset(s.state, nil)
+	c.Assert(err, IsNil)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot find "pc-kernel" in the seed.*`)
+	c.Check(s.restartRequests, HasLen, 0)
+	c.Check(dirs.SnapFactoryResetFile, testutil.FileAbsent)
+}
+
+func writeFactoryResetRequest(c *C, req map[string]interface{}) {
+	data, err := json.Marshal(req)
+	c.Assert(err, IsNil)
+	c.Assert(os.MkdirAll(filepath.Dir(dirs.SnapFactoryResetFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(dirs.SnapFactoryResetFile, data, 0600), IsNil)
+}
+
+func (s *deviceMgrSuite) TestPerformPendingFactoryReset(c *C) {
+	systemData := c.MkDir()
+	for _, p := range []string{
+		"system-data/var/lib/snapd/state.json",
+		"system-data/var/lib/snapd/seed/seed.yaml",
+		"system-data/var/lib/snapd/snaps/core18_x1.snap",
+		"system-data/var/lib/snapd/snaps/core18_2.snap",
+		"system-data/var/lib/snapd/device/private-keys-v1/key-id",
+		"system-data/etc/hostname",
+		"user-data/user/secret",
+	} {
+		c.Assert(os.MkdirAll(filepath.Join(systemData, filepath.Dir(p)), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(systemData, p), nil, 0644), IsNil)
+	}
+	writeFactoryResetRequest(c, map[string]interface{}{
+		"system-data": systemData,
+		"preserve": []string{
+			"system-data/var/lib/snapd/seed",
+			"system-data/var/lib/snapd/snaps/core18_x1.snap",
+			"system-data/var/lib/snapd/device",
+		},
+		"boot-id": "boot-id-0",
+		"recreate": []map[string]interface{}{{
+			"disk": "/dev/sda",
+			"partition": map[string]interface{}{
+				"number": 4,
+				"script": "start=2048, size=32768, name=\"ubuntu-save\"\n",
+			},
+			"node":       "/dev/sda4",
+			"filesystem": "ext4",
+			"label":      "ubuntu-save",
+		}},
+		"identity": map[string]interface{}{
+			"brand":  "my-brand",
+			"model":  "pc-model",
+			"serial": "serialserial",
+		},
+	})
+
+	bootID := "boot-id-0"
+	restore := devicestate.MockBootID(func() (string, error) {
+		return bootID, nil
+	})
+	defer restore()
+	var mkfsCalls [][]string
+	restore = devicestate.MockGadgetMkfs(func(filesystem, node, label string) error {
+		mkfsCalls = append(mkfsCalls, []string{filesystem, node, label})
+		return nil
+	})
+	defer restore()
+
+	var partitionCalls []string
+	restore = devicestate.MockGadgetReplacePartition(func(disk string, entry *gadget.PartitionEntry) (string, error) {
+		partitionCalls = append(partitionCalls, fmt.Sprintf("%s %d %s", disk, entry.Number, entry.Script))
+		return "/dev/sda4-new", nil
+	})
+	defer restore()
+
+	// nothing happens until the reboot
+	c.Assert(devicestate.PerformPendingFactoryReset(), IsNil)
+	c.Check(filepath.Join(systemData, "system-data/var/lib/snapd/state.json"), testutil.FilePresent)
+	c.Check(mkfsCalls, HasLen, 0)
+	c.Check(partitionCalls, HasLen, 0)
+
+	bootID = "boot-id-1"
+	c.Assert(devicestate.PerformPendingFactoryReset(), IsNil)
+
+	for _, p := range []string{
+		"system-data/var/lib/snapd/seed/seed.yaml",
+		"system-data/var/lib/snapd/snaps/core18_x1.snap",
+		"system-data/var/lib/snapd/device/private-keys-v1/key-id",
+	} {
+		c.Check(filepath.Join(systemData, p), testutil.FilePresent)
+	}
+	for _, p := range []string{
+		"system-data/var/lib/snapd/state.json",
+		"system-data/var/lib/snapd/snaps/core18_2.snap",
+		"system-data/etc/hostname",
+		"user-data/user/secret",
+	} {
+		c.Check(filepath.Join(systemData, p), testutil.FileAbsent)
+	}
+	// directories are kept
+	c.Check(osutil.IsDirectory(filepath.Join(systemData, "user-data/user")), Equals, true)
+	// filesystems are created anew
+	c.Check(partitionCalls, DeepEquals, []string{"/dev/sda 4 start=2048, size=32768, name=\"ubuntu-save\"\n"})
+	c.Check(mkfsCalls, DeepEquals, [][]string{{"ext4", "/dev/sda4-new", "ubuntu-save"}})
+
+	// the request is kept to restore the identity
+	req := readFactoryResetRequest(c)
+	c.Check(req["performed"], Equals, true)
+	c.Check(req["identity"].(map[string]interface{})["serial"], Equals, "serialserial")
+
+	// and is performed only once
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "system-data/etc/hostname"), nil, 0644), IsNil)
+	c.Assert(devicestate.PerformPendingFactoryReset(), IsNil)
+	c.Check(filepath.Join(systemData, "system-data/etc/hostname"), testutil.FilePresent)
+}
+
+func (s *deviceMgrSuite) TestPerformPendingFactoryResetNoIdentity(c *C) {
+	systemData := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(systemData, "system-data/etc"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "system-data/etc/hostname"), nil, 0644), IsNil)
+	writeFactoryResetRequest(c, map[string]interface{}{
+		"system-data": systemData,
+		"preserve":    []string{"system-data/var/lib/snapd/factory-reset.json"},
+		"boot-id":     "boot-id-0",
+	})
+
+	restore := devicestate.MockBootID(func() (string, error) {
+		return "boot-id-1", nil
+	})
+	defer restore()
+
+	c.Assert(devicestate.PerformPendingFactoryReset(), IsNil)
+	c.Check(filepath.Join(systemData, "system-data/etc/hostname"), testutil.FileAbsent)
+	c.Check(dirs.SnapFactoryResetFile, testutil.FileAbsent)
+}
+
+func (s *deviceMgrSuite) TestPerformPendingFactoryResetMkfsFails(c *C) {
+	systemData := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(systemData, "system-data/etc"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "system-data/etc/hostname"), nil, 0644), IsNil)
+	writeFactoryResetRequest(c, map[string]interface{}{
+		"system-data": systemData,
+		"preserve":    []string{"system-data/var/lib/snapd/factory-reset.json"},
+		"boot-id":     "boot-id-0",
+		"recreate": []map[string]interface{}{{
+			"node":       "/dev/sda4",
+			"filesystem": "ext4",
+			"label":      "ubuntu-save",
+		}},
+	})
+
+	restore := devicestate.MockBootID(func() (string, error) {
+		return "boot-id-1", nil
+	})
+	defer restore()
+	restore = devicestate.MockGadgetMkfs(func(filesystem, node, label string) error {
+		return errors.New("boom")
+	})
+	defer restore()
+
+	c.Assert(devicestate.PerformPendingFactoryReset(), ErrorMatches, "cannot reset system data: boom")
+	c.Check(filepath.Join(systemData, "system-data/etc/hostname"), testutil.FileAbsent)
+
+	// the failure is recorded and the reset is not attempted again
+	req := readFactoryResetRequest(c)
+	c.Check(req["performed"], Equals, true)
+	c.Check(req["error"], Equals, "boom")
+	c.Assert(devicestate.PerformPendingFactoryReset(), IsNil)
+}
+
+func (s *deviceMgrSuite) TestPerformPendingFactoryResetNothingPending(c *C) {
+	c.Check(devicestate.PerformPendingFactoryReset(), IsNil)
+}
+
+func (s *deviceMgrSuite) signedSerial(c *C, serialN string) asserts.Assertion {
+	encDevKey, err := asserts.EncodePublicKey(devKey.PublicKey())
+	c.Assert(err, IsNil)
+	serial, err := s.brands.Signing("my-brand").Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            "my-brand",
+		"model":               "pc-model",
+		"serial":              serialN,
+		"device-key":          string(encDevKey),
+		"device-key-sha3-384": devKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	return serial
+}
+
+func (s *deviceMgrSuite) TestRestoreIdentityAfterFactoryReset(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupBrands(c)
+	serial := s.signedSerial(c, "serialserial")
+	writeFactoryResetRequest(c, map[string]interface{}{
+		"system-data": "/writable",
+		"performed":   true,
+		"identity": map[string]interface{}{
+			"brand":            "my-brand",
+			"model":            "pc-model",
+			"serial":           "serialserial",
+			"key-id":           devKey.PublicKey().ID(),
+			"serial-assertion": string(asserts.Encode(serial)),
+		},
+	})
+
+	// as set by seeding
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "my-brand",
+		Model: "pc-model",
+	})
+
+	s.state.Unlock()
+	c.Assert(devicestate.EnsureOperational(s.mgr), IsNil)
+	s.state.Lock()
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device, DeepEquals, &auth.DeviceState{
+		Brand:  "my-brand",
+		Model:  "pc-model",
+		Serial: "serialserial",
+		KeyID:  devKey.PublicKey().ID(),
+	})
+	a, err := assertstate.DB(s.state).Find(asserts.SerialType, map[string]string{
+		"brand-id": "my-brand",
+		"model":    "pc-model",
+		"serial":   "serialserial",
+	})
+	c.Assert(err, IsNil)
+	c.Check(a.(*asserts.Serial).Serial(), Equals, "serialserial")
+	c.Check(dirs.SnapFactoryResetFile, testutil.FileAbsent)
+	// no registration
+	c.Check(s.findBecomeOperationalChange(), IsNil)
+}
+
+func (s *deviceMgrSuite) TestRestoreIdentityAfterFactoryResetOtherModel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	writeFactoryResetRequest(c, map[string]interface{}{
+		"system-data": "/writable",
+		"performed":   true,
+		"identity": map[string]interface{}{
+			"brand":  "my-brand",
+			"model":  "other-model",
+			"serial": "serialserial",
+		},
+	})
+
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "my-brand",
+		Model: "pc-model",
+	})
+	// registration waits for seeding
+	s.makeModelAssertionInState(c, "my-brand", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+	})
+
+	s.state.Unlock()
+	c.Assert(devicestate.EnsureOperational(s.mgr), IsNil)
+	s.state.Lock()
+
+	device, err := devicestatetest.Device(s.state)
+	c.Assert(err, IsNil)
+	c.Check(device.Serial, Equals, "")
+	c.Check(dirs.SnapFactoryResetFile, testutil.FileAbsent)
+}
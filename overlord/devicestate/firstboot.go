@@ -38,6 +38,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -81,9 +82,67 @@ func trivialSeeding(st *state.State, markSeeded *state.Task) []*state.TaskSet {
 	// not used at all we put system configuration there)
 	configTs := snapstate.ConfigureSnap(st, "core", 0)
 	markSeeded.WaitAll(configTs)
+	if snapdenv.Preseeding() {
+		// nothing to preseed, the configure hook runs on first boot
+		markPreseeded := st.NewTask("mark-preseeded", i18n.G("Mark system pre-seeded"))
+		configTs.WaitFor(markPreseeded)
+		return []*state.TaskSet{state.NewTaskSet(markPreseeded), configTs, state.NewTaskSet(markSeeded)}
+	}
 	return []*state.TaskSet{configTs, state.NewTaskSet(markSeeded)}
 }
 
+// preseedSplit splits the tasks of the given task set into the ones
+// that can run while preseeding and the ones, starting with the first
+// hook or services start, that need the running system.
+func preseedSplit(ts *state.TaskSet) (pre, post *state.TaskSet) {
+	pre = state.NewTaskSet()
+	post = state.NewTaskSet()
+	inPost := false
+	for _, t := range ts.Tasks() {
+		switch t.Kind() {
+		case "run-hook", "start-snap-services":
+			inPost = true
+		}
+		if inPost {
+			post.AddTask(t)
+		} else {
+			pre.AddTask(t)
+		}
+	}
+	return pre, post
+}
+
+// chainPreseedTs chains the given task sets in order like chainTs, except
+// that the tasks that can run while preseeding run first for all of them
+// and then, after markPreseeded, the ones that need the running system.
+func chainPreseedTs(tsAll []*state.TaskSet, markPreseeded *state.Task) {
+	var prevPre, prevPost *state.TaskSet
+	for _, ts := range tsAll {
+		pre, post := preseedSplit(ts)
+		if len(pre.Tasks()) != 0 {
+			if prevPre != nil {
+				pre.WaitAll(prevPre)
+			}
+			markPreseeded.WaitAll(pre)
+			prevPre = pre
+		}
+		if len(post.Tasks()) != 0 {
+			post.WaitFor(markPreseeded)
+			if prevPost != nil {
+				post.WaitAll(prevPost)
+			}
+			prevPost = post
+		}
+	}
+}
+
+// chainTs makes each of the given task sets wait for the previous one.
+func chainTs(tsAll []*state.TaskSet) {
+	for i := 1; i < len(tsAll); i++ {
+		tsAll[i].WaitAll(tsAll[i-1])
+	}
+}
+
 func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.TaskSet, error) {
 	// check that the state is empty
 	var seeded bool
@@ -135,59 +194,54 @@ func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.T
 		baseSnap = model.Base()
 	}
 
-	installSeedEssential := func(snapName string, last int) (*snap.Info, error) {
+	installSeedEssential := func(snapName string) (*snap.Info, error) {
 		seedSnap := seeding[snapName]
 		if seedSnap == nil {
 			return nil, fmt.Errorf("cannot proceed without seeding %q", snapName)
 		}
+		if seedSnap.InstallOnDemand {
+			return nil, fmt.Errorf("cannot install %q on demand, it is needed to seed the system", snapName)
+		}
 		ts, info, err := installSeedSnap(st, seedSnap, snapstate.Flags{SkipConfigure: true, Required: true}, tm)
 		if err != nil {
 			return nil, err
 		}
-		if last >= 0 {
-			ts.WaitAll(tsAll[last])
-		}
 		tsAll = append(tsAll, ts)
 		alreadySeeded[snapName] = true
 		return info, nil
 	}
 
-	last := -1
+	// the task sets are collected in the order in which they need to
+	// run and chained together at the end
+
 	// if there are snaps to seed, core/base needs to be seeded too
 	if len(seed.Snaps) != 0 {
 		// ensure "snapd" snap is installed first
 		if model.Base() != "" {
-			if _, err := installSeedEssential("snapd", last); err != nil {
+			if _, err := installSeedEssential("snapd"); err != nil {
 				return nil, err
 			}
-			last++
 		}
-		if _, err := installSeedEssential(baseSnap, last); err != nil {
+		if _, err := installSeedEssential(baseSnap); err != nil {
 			return nil, err
 		}
 		// we *always* configure "core" here even if bases are used
 		// for booting. "core" if where the system config lives.
 		configTss = append(configTss, snapstate.ConfigureSnap(st, "core", snapstate.UseConfigDefaults))
-		last++
 	}
 
-	lastConf := 0
 	if kernelName := model.Kernel(); kernelName != "" {
-		if _, err := installSeedEssential(kernelName, last); err != nil {
+		if _, err := installSeedEssential(kernelName); err != nil {
 			return nil, err
 		}
 		configTs := snapstate.ConfigureSnap(st, kernelName, snapstate.UseConfigDefaults)
-		// wait for the previous configTss
-		configTs.WaitAll(configTss[lastConf])
 		configTss = append(configTss, configTs)
-		last++
-		lastConf++
 	}
 
 	// FIXME: ensure that any base is ordered before the gadget so that
 	//        the gadget can use bases that are not the model base
 	if gadgetName := model.Gadget(); gadgetName != "" {
-		info, err := installSeedEssential(gadgetName, last)
+		info, err := installSeedEssential(gadgetName)
 		if err != nil {
 			return nil, err
 		}
@@ -200,23 +254,12 @@ func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.T
 		}
 
 		configTs := snapstate.ConfigureSnap(st, gadgetName, snapstate.UseConfigDefaults)
-		// wait for the previous configTss
-		configTs.WaitAll(configTss[lastConf])
 		configTss = append(configTss, configTs)
-		last++
-		//If we use lastConf again we need to enable this. It is
-		//commented out because go vet complains about an ineffectual
-		// assignment.
-		//lastConf++
 	}
 
 	// chain together configuring core, kernel, and gadget after
 	// installing them so that defaults are availabble from gadget
-	if len(configTss) > 0 {
-		configTss[0].WaitAll(tsAll[last])
-		tsAll = append(tsAll, configTss...)
-		last += len(configTss)
-	}
+	tsAll = append(tsAll, configTss...)
 
 	// ensure we install in the right order
 	infoToTs := make(map[*snap.Info]*state.TaskSet, len(seed.Snaps))
@@ -231,6 +274,13 @@ func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.T
 		if required[sn.Name] {
 			flags.Required = true
 		}
+		if sn.InstallOnDemand {
+			if flags.Required {
+				return nil, fmt.Errorf("cannot install %q on demand, it is required by the model", sn.Name)
+			}
+			// installed later through InstallOnDemandSnap
+			continue
+		}
 
 		ts, info, err := installSeedSnap(st, sn, flags, tm)
 		if err != nil {
@@ -244,16 +294,21 @@ func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.T
 	// only have tasksets that we did not already seeded
 	sort.Stable(snap.ByType(infos))
 	for _, info := range infos {
-		ts := infoToTs[info]
-		ts.WaitAll(tsAll[last])
-		tsAll = append(tsAll, ts)
-		last++
+		tsAll = append(tsAll, infoToTs[info])
 	}
 
 	if len(tsAll) == 0 {
 		return nil, fmt.Errorf("cannot proceed, no snaps to seed")
 	}
 
+	var markPreseeded *state.Task
+	if snapdenv.Preseeding() {
+		markPreseeded = st.NewTask("mark-preseeded", i18n.G("Mark system pre-seeded"))
+		chainPreseedTs(tsAll, markPreseeded)
+	} else {
+		chainTs(tsAll)
+	}
+
 	ts := tsAll[len(tsAll)-1]
 	endTs := state.NewTaskSet()
 	if model.Gadget() != "" {
@@ -266,11 +321,53 @@ func populateStateFromSeedImpl(st *state.State, tm timings.Measurer) ([]*state.T
 	}
 	markSeeded.WaitAll(ts)
 	endTs.AddTask(markSeeded)
+	if markPreseeded != nil {
+		markSeeded.WaitFor(markPreseeded)
+		endTs.AddTask(markPreseeded)
+	}
 	tsAll = append(tsAll, endTs)
 
 	return tsAll, nil
 }
 
+// InstallOnDemandSnap installs a snap that was put into the seed to be
+// installed on demand instead of on first boot.
+func InstallOnDemandSnap(st *state.State, name string) (*state.TaskSet, error) {
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if !seeded {
+		return nil, fmt.Errorf("cannot install snap %q on demand until the system is seeded", name)
+	}
+
+	var snapst snapstate.SnapState
+	err = snapstate.Get(st, name, &snapst)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if snapst.IsInstalled() {
+		return nil, fmt.Errorf("snap %q is already installed", name)
+	}
+
+	seed, err := snap.ReadSeedYaml(filepath.Join(dirs.SnapSeedDir, "seed.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, sn := range seed.Snaps {
+		if sn.Name != name {
+			continue
+		}
+		if !sn.InstallOnDemand {
+			break
+		}
+		ts, _, err := installSeedSnap(st, sn, snapstate.Flags{}, timings.New(nil))
+		return ts, err
+	}
+	return nil, fmt.Errorf("cannot find snap %q to install on demand in the seed", name)
+}
+
 func readAsserts(fn string, batch *assertstate.Batch) ([]*asserts.Ref, error) {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -52,6 +52,7 @@ import (
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/systemd"
 	"github.com/snapcore/snapd/testutil"
 	"github.com/snapcore/snapd/timings"
@@ -973,6 +974,114 @@ snaps:
 	c.Check(seeded, Equals, true)
 }
 
+func waitsFor(t, other *state.Task) bool {
+	seen := make(map[*state.Task]bool)
+	todo := t.WaitTasks()
+	for len(todo) > 0 {
+		w := todo[0]
+		todo = todo[1:]
+		if w == other {
+			return true
+		}
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		todo = append(todo, w.WaitTasks()...)
+	}
+	return false
+}
+
+func (s *FirstBootTestSuite) TestPopulateFromSeedPreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	coreFname, kernelFname, gadgetFname := s.makeCoreSnaps(c, "")
+
+	devAcct := assertstest.NewAccount(s.storeSigning, "developer", map[string]interface{}{
+		"account-id": "developerid",
+	}, "")
+	devAcctFn := filepath.Join(dirs.SnapSeedDir, "assertions", "developer.account")
+	err := ioutil.WriteFile(devAcctFn, asserts.Encode(devAcct), 0644)
+	c.Assert(err, IsNil)
+
+	files := [][]string{{"meta/hooks/configure", ""}, {"bin/svc", ""}}
+	snapYaml := `name: foo
+version: 1.0
+apps:
+ svc:
+  command: bin/svc
+  daemon: simple`
+	fooFname, fooDecl, fooRev := s.makeAssertedSnap(c, snapYaml, files, snap.R(128), "developerid")
+	writeAssertionsToFile("foo.asserts", []asserts.Assertion{fooDecl, fooRev})
+
+	assertsChain := s.makeModelAssertionChain(c, "my-model", nil, "foo")
+	for i, as := range assertsChain {
+		fn := filepath.Join(dirs.SnapSeedDir, "assertions", strconv.Itoa(i))
+		err := ioutil.WriteFile(fn, asserts.Encode(as), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	content := []byte(fmt.Sprintf(`
+snaps:
+ - name: core
+   file: %s
+ - name: pc-kernel
+   file: %s
+ - name: pc
+   file: %s
+ - name: foo
+   file: %s
+`, coreFname, kernelFname, gadgetFname, fooFname))
+	err = ioutil.WriteFile(filepath.Join(dirs.SnapSeedDir, "seed.yaml"), content, 0644)
+	c.Assert(err, IsNil)
+
+	st := s.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	tsAll, err := devicestate.PopulateStateFromSeedImpl(st, s.perfTimings)
+	c.Assert(err, IsNil)
+
+	var all []*state.Task
+	var markPreseeded, markSeeded *state.Task
+	for _, ts := range tsAll {
+		for _, t := range ts.Tasks() {
+			all = append(all, t)
+			switch t.Kind() {
+			case "mark-preseeded":
+				markPreseeded = t
+			case "mark-seeded":
+				markSeeded = t
+			}
+		}
+	}
+	c.Assert(markPreseeded, NotNil)
+	c.Assert(markSeeded, NotNil)
+	c.Check(waitsFor(markSeeded, markPreseeded), Equals, true)
+
+	var linked, hooks, services int
+	for _, t := range all {
+		switch t.Kind() {
+		case "link-snap", "setup-profiles":
+			// done while preseeding
+			c.Check(waitsFor(markPreseeded, t), Equals, true, Commentf("%s: %s", t.Kind(), t.Summary()))
+			linked++
+		case "run-hook", "start-snap-services":
+			// need the running system
+			c.Check(waitsFor(t, markPreseeded), Equals, true, Commentf("%s: %s", t.Kind(), t.Summary()))
+			c.Check(waitsFor(markPreseeded, t), Equals, false, Commentf("%s: %s", t.Kind(), t.Summary()))
+			if t.Kind() == "run-hook" {
+				hooks++
+			} else {
+				services++
+			}
+		}
+	}
+	c.Check(linked, Equals, 2*4)
+	c.Check(hooks > 0, Equals, true)
+	c.Check(services, Equals, 4)
+}
+
 func (s *FirstBootTestSuite) TestPopulateFromSeedGadgetConnectHappy(c *C) {
 	loader := boottest.NewMockBootloader("mock", c.MkDir())
 	bootloader.Force(loader)
@@ -1516,6 +1625,95 @@ snaps:
 	c.Check(tSnap.WaitTasks(), testutil.Contains, tOtherBase)
 }
 
+func (s *FirstBootTestSuite) writeInstallOnDemandSeed(c *C, reqSnaps ...string) {
+	devAcct := assertstest.NewAccount(s.storeSigning, "developer", map[string]interface{}{
+		"account-id": "developerid",
+	}, "")
+
+	// add a model assertion and its chain
+	assertsChain := s.makeModelAssertionChain(c, "my-model", nil, reqSnaps...)
+	for i, as := range assertsChain {
+		fn := filepath.Join(dirs.SnapSeedDir, "assertions", strconv.Itoa(i))
+		err := ioutil.WriteFile(fn, asserts.Encode(as), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	coreFname, kernelFname, gadgetFname := s.makeCoreSnaps(c, "")
+
+	snapYaml := `name: foo
+version: 1.0
+`
+	fooFname, fooDecl, fooRev := s.makeAssertedSnap(c, snapYaml, nil, snap.R(128), "developerid")
+	writeAssertionsToFile("foo.asserts", []asserts.Assertion{devAcct, fooRev, fooDecl})
+
+	// create a seed.yaml
+	content := []byte(fmt.Sprintf(`
+snaps:
+ - name: core
+   file: %s
+ - name: pc-kernel
+   file: %s
+ - name: pc
+   file: %s
+ - name: foo
+   channel: 1.0/edge
+   install-on-demand: true
+   file: %s
+`, coreFname, kernelFname, gadgetFname, fooFname))
+	err := ioutil.WriteFile(filepath.Join(dirs.SnapSeedDir, "seed.yaml"), content, 0644)
+	c.Assert(err, IsNil)
+}
+
+func (s *FirstBootTestSuite) TestPopulateFromSeedInstallOnDemand(c *C) {
+	s.writeInstallOnDemandSeed(c)
+
+	st := s.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	tsAll, err := devicestate.PopulateStateFromSeedImpl(st, s.perfTimings)
+	c.Assert(err, IsNil)
+
+	// foo is not installed on first boot
+	for _, ts := range tsAll {
+		for _, t := range ts.Tasks() {
+			if snapsup, err := snapstate.TaskSnapSetup(t); err == nil {
+				c.Check(snapsup.InstanceName(), Not(Equals), "foo")
+			}
+		}
+	}
+
+	_, err = devicestate.InstallOnDemandSnap(st, "foo")
+	c.Assert(err, ErrorMatches, `cannot install snap "foo" on demand until the system is seeded`)
+
+	st.Set("seeded", true)
+
+	// but it can be installed later from the seed, from its channel
+	ts, err := devicestate.InstallOnDemandSnap(st, "foo")
+	c.Assert(err, IsNil)
+	snapsup, err := snapstate.TaskSnapSetup(ts.Tasks()[0])
+	c.Assert(err, IsNil)
+	c.Check(snapsup.InstanceName(), Equals, "foo")
+	c.Check(snapsup.Revision(), Equals, snap.R(128))
+	c.Check(snapsup.Channel, Equals, "1.0/edge")
+	c.Check(filepath.Dir(snapsup.SnapPath), Equals, filepath.Join(dirs.SnapSeedDir, "snaps"))
+
+	// only snaps marked as such can be installed on demand
+	_, err = devicestate.InstallOnDemandSnap(st, "pc")
+	c.Assert(err, ErrorMatches, `cannot find snap "pc" to install on demand in the seed`)
+	_, err = devicestate.InstallOnDemandSnap(st, "bar")
+	c.Assert(err, ErrorMatches, `cannot find snap "bar" to install on demand in the seed`)
+}
+
+func (s *FirstBootTestSuite) TestPopulateFromSeedInstallOnDemandRequired(c *C) {
+	s.writeInstallOnDemandSeed(c, "foo")
+
+	st := s.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	_, err := devicestate.PopulateStateFromSeedImpl(st, s.perfTimings)
+	c.Assert(err, ErrorMatches, `cannot install "foo" on demand, it is required by the model`)
+}
+
 func (s *FirstBootTestSuite) TestFirstbootGadgetBaseModelBaseMismatch(c *C) {
 	devAcct := assertstest.NewAccount(s.storeSigning, "developer", map[string]interface{}{
 		"account-id": "developerid",
@@ -36,9 +36,11 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/assertstate"
@@ -49,6 +51,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -65,6 +68,33 @@ func (m *DeviceManager) doMarkSeeded(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+// doMarkPreseeded marks the system as preseeded and stops snapd when
+// preseeding, all the tasks of the seeding that need the running system
+// wait for it. On first boot it completes and seeding carries on.
+func (m *DeviceManager) doMarkPreseeded(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	if !snapdenv.Preseeding() {
+		return nil
+	}
+
+	var preseeded bool
+	err := st.Get("preseeded", &preseeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !preseeded {
+		st.Set("preseed-time", time.Now())
+		st.Set("preseeded", true)
+		t.Logf("System pre-seeded.")
+		// snap-preseed is done once snapd is stopped
+		st.RequestRestart(state.RestartDaemon)
+	}
+	return &state.Retry{Reason: "mark-preseeded completes on first boot"}
+}
+
 func isSameAssertsRevision(err error) bool {
 	if e, ok := err.(*asserts.RevisionError); ok {
 		if e.Used == e.Current {
@@ -85,6 +115,11 @@ func (m *DeviceManager) doSetModel(t *state.Task, _ *tomb.Tomb) error {
 	}
 	new := remodCtx.Model()
 
+	current, err := findModel(st)
+	if err != nil {
+		return err
+	}
+
 	err = assertstate.Add(st, new)
 	if err != nil && !isSameAssertsRevision(err) {
 		return err
@@ -117,7 +152,27 @@ func (m *DeviceManager) doSetModel(t *state.Task, _ *tomb.Tomb) error {
 		//       bootable base snap.
 	}
 
-	return remodCtx.Finish()
+	if err := remodCtx.Finish(); err != nil {
+		return err
+	}
+
+	if current.Gadget() != "" && current.Gadget() != new.Gadget() {
+		removeOldGadget(st, current.Gadget())
+	}
+	return nil
+}
+
+// removeOldGadget removes the gadget of the previous model, switched
+// away from by a remodel, in a change of its own. Failing to do so
+// does not fail the remodel as the gadget is merely left behind.
+func removeOldGadget(st *state.State, name string) {
+	ts, err := snapstate.Remove(st, name, snap.R(0), nil)
+	if err != nil {
+		logger.Noticef("cannot remove gadget %q of the previous model: %v", name, err)
+		return
+	}
+	chg := st.NewChange("remove-snap", fmt.Sprintf(i18n.G("Remove gadget %q of the previous model"), name))
+	chg.AddAll(ts)
 }
 
 func (m *DeviceManager) cleanupRemodel(t *state.Task, _ *tomb.Tomb) error {
@@ -386,21 +441,10 @@ func retryBadStatus(t *state.Task, nTentatives int, reason string, resp *http.Re
 	return fmt.Errorf("%s: unexpected status %d", reason, resp.StatusCode)
 }
 
-func prepareSerialRequest(t *state.Task, regCtx registrationContext, privKey asserts.PrivateKey, device *auth.DeviceState, client *http.Client, cfg *serialRequestConfig) (string, error) {
-	// limit tentatives starting from scratch before going to
-	// slower full retries
-	var nTentatives int
-	err := t.Get("pre-poll-tentatives", &nTentatives)
-	if err != nil && err != state.ErrNoState {
-		return "", err
-	}
-	nTentatives++
-	t.Set("pre-poll-tentatives", nTentatives)
-
-	st := t.State()
-	st.Unlock()
-	defer st.Lock()
-
+// fetchRequestID obtains a request-id to make a request for a serial
+// with from the device service. It must be called with the state
+// unlocked.
+func fetchRequestID(t *state.Task, nTentatives int, client *http.Client, cfg *serialRequestConfig) (string, error) {
 	req, err := http.NewRequest("POST", cfg.requestIDURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("internal error: cannot create request-id request %q", cfg.requestIDURL)
@@ -428,6 +472,31 @@ func prepareSerialRequest(t *state.Task, regCtx registrationContext, privKey ass
 	if err != nil { // assume broken i/o
 		return "", retryErr(t, nTentatives, "cannot read response with request-id for making a request for a serial: %v", err)
 	}
+	return requestID.RequestID, nil
+}
+
+func prepareSerialRequest(t *state.Task, regCtx registrationContext, privKey asserts.PrivateKey, device *auth.DeviceState, client *http.Client, cfg *serialRequestConfig) (string, error) {
+	// limit tentatives starting from scratch before going to
+	// slower full retries
+	var nTentatives int
+	err := t.Get("pre-poll-tentatives", &nTentatives)
+	if err != nil && err != state.ErrNoState {
+		return "", err
+	}
+	nTentatives++
+	t.Set("pre-poll-tentatives", nTentatives)
+
+	st := t.State()
+	st.Unlock()
+	defer st.Lock()
+
+	requestID := cfg.requestID
+	if requestID == "" {
+		requestID, err = fetchRequestID(t, nTentatives, client, cfg)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	encodedPubKey, err := asserts.EncodePublicKey(privKey.PublicKey())
 	if err != nil {
@@ -438,7 +507,7 @@ func prepareSerialRequest(t *state.Task, regCtx registrationContext, privKey ass
 	headers := map[string]interface{}{
 		"brand-id":   device.Brand,
 		"model":      device.Model,
-		"request-id": requestID.RequestID,
+		"request-id": requestID,
 		"device-key": string(encodedPubKey),
 	}
 	if cfg.proposedSerial != "" {
@@ -449,6 +518,10 @@ func prepareSerialRequest(t *state.Task, regCtx registrationContext, privKey ass
 		headers[k] = v
 	}
 
+	if err := attestSerialRequest(headers, device, requestID, privKey.PublicKey(), cfg); err != nil {
+		return "", err
+	}
+
 	serialReq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, cfg.body, privKey)
 	if err != nil {
 		return "", err
@@ -597,6 +670,17 @@ type serialRequestConfig struct {
 	headers          map[string]string
 	proposedSerial   string
 	body             []byte
+	// attestationType and attestation are set by the attest-device
+	// hook of the gadget
+	attestationType string
+	attestation     string
+	// requestID is the request-id obtained before running the
+	// attest-device hook, the attestation is bound to it
+	requestID string
+	// attestationHelper and attestationHelperType are set with the
+	// registration.attestation-* system options
+	attestationHelper     string
+	attestationHelperType string
 }
 
 func (cfg *serialRequestConfig) applyHeaders(req *http.Request) {
@@ -659,6 +743,24 @@ func getSerialRequestConfig(t *state.Task, regCtx registrationContext, client *h
 		if err != nil {
 			return nil, err
 		}
+
+		cfg.attestationType, cfg.attestation, err = gadgetAttestation(tr, gadgetName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tr.GetMaybe("core", "registration.attestation-helper", &cfg.attestationHelper); err != nil {
+		return nil, err
+	}
+	if err := tr.GetMaybe("core", "registration.attestation-type", &cfg.attestationHelperType); err != nil {
+		return nil, err
+	}
+
+	if chg := t.Change(); chg != nil {
+		if err := chg.Get("serial-request-id", &cfg.requestID); err != nil && err != state.ErrNoState {
+			return nil, err
+		}
 	}
 
 	if proxyURL != nil && svcURL != nil && !newEnoughProxy(st, proxyURL, client) {
@@ -671,6 +773,48 @@ func getSerialRequestConfig(t *state.Task, regCtx registrationContext, client *h
 	return &cfg, nil
 }
 
+// doRequestSerialID obtains the request-id the attest-device hook of
+// the gadget binds its attestation to, it is then used for the
+// serial-request of the change.
+func (m *DeviceManager) doRequestSerialID(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	regCtx, err := m.registrationCtx(t)
+	if err != nil {
+		return err
+	}
+
+	var nTentatives int
+	err = t.Get("pre-poll-tentatives", &nTentatives)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	nTentatives++
+	t.Set("pre-poll-tentatives", nTentatives)
+
+	proxyConf := proxyconf.New(st)
+	client := httputil.NewHTTPClient(&httputil.ClientOptions{
+		Timeout:    30 * time.Second,
+		MayLogBody: true,
+		Proxy:      proxyConf.Conf,
+	})
+	cfg, err := getSerialRequestConfig(t, regCtx, client)
+	if err != nil {
+		return err
+	}
+
+	st.Unlock()
+	requestID, err := fetchRequestID(t, nTentatives, client, cfg)
+	st.Lock()
+	if err != nil {
+		return err
+	}
+	t.Change().Set("serial-request-id", requestID)
+	return nil
+}
+
 func (m *DeviceManager) doRequestSerial(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
@@ -907,6 +1051,20 @@ func gadgetCurrentAndUpdate(st *state.State, snapsup *snapstate.SnapSetup) (curr
 	if err != nil {
 		return nil, nil, err
 	}
+	if !snapst.IsInstalled() {
+		// when remodeling to a different gadget, its assets
+		// update the ones of the gadget of the current model
+		model, err := findModel(st)
+		if err != nil && err != state.ErrNoState {
+			return nil, nil, err
+		}
+		if model != nil && model.Gadget() != snapsup.InstanceName() {
+			snapst, err = snapState(st, model.Gadget())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
 
 	currentData, err := currentGadgetInfo(snapst)
 	if err != nil {
@@ -925,14 +1083,65 @@ func gadgetCurrentAndUpdate(st *state.State, snapsup *snapstate.SnapSetup) (curr
 	return currentData, newData, nil
 }
 
+// gadgetTemplateVars returns the variables used for rendering the gadget
+// content templates, or nil if the device has no model yet. The serial is
+// left unset until the device is registered, so that updating content
+// templated on it fails rather than renders an empty serial.
+func (m *DeviceManager) gadgetTemplateVars() (*gadget.TemplateVars, error) {
+	model, err := m.Model()
+	if err == state.ErrNoState {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars := &gadget.TemplateVars{
+		Model:        model.Model(),
+		Architecture: model.Architecture(),
+	}
+	serial, err := m.Serial()
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if serial != nil {
+		vars.Serial = serial.Serial()
+	}
+	return vars, nil
+}
+
 var (
-	gadgetUpdate = nopGadgetOp
+	gadgetUpdate   = nopGadgetOp
+	gadgetRollback = nopGadgetOp
 )
 
 func nopGadgetOp(current, update gadget.GadgetData, rollbackRootDir string) error {
 	return nil
 }
 
+// readKernelCmdline reads the kernel command line provided by the gadget
+// with the given root directory, as allowed by the model.
+func readKernelCmdline(st *state.State, gadgetSnapRootDir string) (*gadget.KernelCmdline, error) {
+	var allow []string
+	model, err := findModel(st)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if model != nil {
+		allow = model.KernelCmdlineAllow()
+	}
+	return gadget.ReadKernelCmdline(gadgetSnapRootDir, allow)
+}
+
+// updateKernelCmdline applies the kernel command line provided by the
+// gadget, returning whether it changed.
+func updateKernelCmdline(cmdline *gadget.KernelCmdline) (changed bool, err error) {
+	changed, err = boot.SetKernelCmdline(cmdline)
+	if err != nil {
+		return false, fmt.Errorf("cannot update kernel command line: %v", err)
+	}
+	return changed, nil
+}
+
 func (m *DeviceManager) doUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
 	if release.OnClassic {
 		return fmt.Errorf("cannot run update gadget assets task on a classic system")
@@ -952,31 +1161,122 @@ func (m *DeviceManager) doUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error
 		return err
 	}
 	if currentData == nil {
-		// no updates during first boot & seeding
+		// no updates during first boot & seeding, but the kernel
+		// command line of the gadget being seeded still applies
+		cmdline, err := readKernelCmdline(st, snapsup.MountDir())
+		if err != nil {
+			return err
+		}
+		changed, err := updateKernelCmdline(cmdline)
+		if err != nil {
+			return err
+		}
+		if changed && !snapdenv.Preseeding() {
+			t.Logf("Updated kernel command line")
+			t.SetStatus(state.DoneStatus)
+			st.RequestRestart(state.RestartSystem)
+		}
 		return nil
 	}
 
+	updateData.TemplateVars, err = m.gadgetTemplateVars()
+	if err != nil {
+		return fmt.Errorf("cannot prepare gadget template variables: %v", err)
+	}
+
+	// refuse a bad kernel command line before touching the assets
+	cmdline, err := readKernelCmdline(st, updateData.RootDir)
+	if err != nil {
+		return err
+	}
+	// the key of the encrypted data partition is bound to the kernel
+	// command line set so far
+	boundCmdline, err := boot.CurrentKernelCmdline()
+	if err != nil {
+		return err
+	}
+
+	// the update of the assets of a paired gadget keeps their
+	// backup until the paired kernel booted, reverting the gadget
+	// then restores them from it
+	pairedRollbackDir := gadgetRollbackDir(currentData.RootDir)
+	if snapsup.Revert && pairedRollbackDir != "" && osutil.IsDirectory(pairedRollbackDir) {
+		return m.rollbackPairedGadgetAssets(t, snapsup, currentData, updateData, cmdline, boundCmdline, pairedRollbackDir)
+	}
+
 	snapRollbackDir, err := makeRollbackDir(fmt.Sprintf("%v_%v", snapsup.InstanceName(), snapsup.SideInfo.Revision))
 	if err != nil {
 		return fmt.Errorf("cannot prepare update rollback directory: %v", err)
 	}
+	// the backup is needed until the change is done, for undo
+	t.Set("rollback-dir", snapRollbackDir)
+	if snapsup.PairedUpdate != nil && !snapsup.Revert {
+		t.Set("keep-rollback-dir", true)
+	}
 
 	st.Unlock()
 	err = gadgetUpdate(*currentData, *updateData, snapRollbackDir)
+	var digests map[string]string
+	var digestsErr error
+	if err == nil {
+		// track the boot assets as written by the update
+		digests, digestsErr = gadgetBootAssetsDigests(*updateData)
+	}
 	st.Lock()
+	if err != nil && err != gadget.ErrNoUpdate {
+		return err
+	}
+	assetsUpdated := err == nil
+	if assetsUpdated {
+		t.Set("gadget-assets-updated", true)
+	}
+
+	// the kernel command line of the gadget is applied even if its
+	// assets did not change
+	cmdlineChanged, err := updateKernelCmdline(cmdline)
 	if err != nil {
-		if err == gadget.ErrNoUpdate {
+		return err
+	}
+	if cmdlineChanged {
+		t.Set("kernel-cmdline-updated", true)
+	}
+
+	// the key of the encrypted data partition is bound to the boot
+	// assets tracked so far
+	boundDigests, err := TrackedBootAssets(st)
+	if err != nil {
+		return err
+	}
+
+	if !assetsUpdated {
+		if !cmdlineChanged {
 			// no update needed
 			t.Logf("No gadget assets update needed")
 			return nil
 		}
-		return err
+		t.Logf("Updated kernel command line")
+	} else if digestsErr != nil {
+		// the previously tracked digests are kept, so the
+		// modified boot assets will be detected as such
+		t.Logf("cannot track boot assets: %v", digestsErr)
+		logger.Noticef("cannot track boot assets: %v", digestsErr)
+	} else {
+		// keep the digests of the previous assets for undo
+		t.Set("old-boot-assets", boundDigests)
+		t.Set("boot-assets-tracked", true)
+		setTrackedBootAssets(st, digests)
+	}
+
+	if err := resealDataKey(st, boundDigests, boundCmdline); err != nil {
+		return fmt.Errorf("cannot reseal the key of the encrypted data partition: %v", err)
 	}
 
 	t.SetStatus(state.DoneStatus)
 
-	if err := os.RemoveAll(snapRollbackDir); err != nil && !os.IsNotExist(err) {
-		logger.Noticef("failed to remove gadget update rollback directory %q: %v", snapRollbackDir, err)
+	if snapsup.PairedUpdate != nil {
+		// the paired kernel update, or revert, reboots into both
+		t.Logf("Restart deferred to the update of kernel %q", snapsup.PairedUpdate.Kernel)
+		return nil
 	}
 
 	// TODO: consider having the option to do this early via recovery in
@@ -985,3 +1285,185 @@ func (m *DeviceManager) doUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error
 
 	return nil
 }
+
+// rollbackPairedGadgetAssets restores the gadget assets backed up when
+// updating to the current gadget, as part of a paired update whose
+// kernel failed to boot, when reverting to the previous gadget.
+func (m *DeviceManager) rollbackPairedGadgetAssets(t *state.Task, snapsup *snapstate.SnapSetup, currentData, revertData *gadget.GadgetData, cmdline, boundCmdline *gadget.KernelCmdline, rollbackDir string) error {
+	st := t.State()
+
+	var err error
+	currentData.TemplateVars, err = m.gadgetTemplateVars()
+	if err != nil {
+		return fmt.Errorf("cannot prepare gadget template variables: %v", err)
+	}
+
+	// the rollback recomputes the update from the gadget reverted
+	// to, to the current one
+	st.Unlock()
+	err = gadgetRollback(*revertData, *currentData, rollbackDir)
+	st.Lock()
+	if err != nil && err != gadget.ErrNoUpdate {
+		return fmt.Errorf("cannot rollback gadget assets: %v", err)
+	}
+	if _, err := updateKernelCmdline(cmdline); err != nil {
+		return err
+	}
+	t.Logf("Restored the assets of gadget %q", snapsup.InstanceName())
+
+	// the digests of the restored assets are tracked anew
+	boundDigests, err := TrackedBootAssets(st)
+	if err != nil {
+		return err
+	}
+	digests, err := gadgetBootAssetsDigests(*revertData)
+	if err != nil {
+		t.Logf("cannot track boot assets: %v", err)
+		logger.Noticef("cannot track boot assets: %v", err)
+	} else {
+		setTrackedBootAssets(st, digests)
+	}
+	if err := resealDataKey(st, boundDigests, boundCmdline); err != nil {
+		return fmt.Errorf("cannot reseal the key of the encrypted data partition: %v", err)
+	}
+	// the backup was used up
+	t.Set("rollback-dir", rollbackDir)
+
+	t.SetStatus(state.DoneStatus)
+
+	if snapsup.PairedUpdate != nil {
+		// the revert of the paired kernel reboots into both
+		t.Logf("Restart deferred to the revert of kernel %q", snapsup.PairedUpdate.Kernel)
+		return nil
+	}
+	st.RequestRestart(state.RestartSystem)
+	return nil
+}
+
+// gadgetRollbackDir returns the rollback directory of the update to
+// the gadget mounted at the given root directory, if it is a revision
+// of a snap.
+func gadgetRollbackDir(rootDir string) string {
+	rev := filepath.Base(rootDir)
+	name := filepath.Base(filepath.Dir(rootDir))
+	if _, err := snap.ParseRevision(rev); err != nil {
+		return ""
+	}
+	return filepath.Join(dirs.SnapRollbackDir, fmt.Sprintf("%v_%v", name, rev))
+}
+
+func (m *DeviceManager) undoUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var assetsUpdated, cmdlineUpdated bool
+	if err := t.Get("gadget-assets-updated", &assetsUpdated); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if err := t.Get("kernel-cmdline-updated", &cmdlineUpdated); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !assetsUpdated && !cmdlineUpdated {
+		return nil
+	}
+
+	snapsup, err := snapstate.TaskSnapSetup(t)
+	if err != nil {
+		return err
+	}
+	currentData, updateData, err := gadgetCurrentAndUpdate(st, snapsup)
+	if err != nil {
+		return err
+	}
+	if currentData == nil {
+		return fmt.Errorf("internal error: cannot find the gadget to restore the assets of")
+	}
+
+	boundDigests, err := TrackedBootAssets(st)
+	if err != nil {
+		return err
+	}
+	if assetsUpdated {
+		var rollbackDir string
+		if err := t.Get("rollback-dir", &rollbackDir); err != nil {
+			return err
+		}
+		updateData.TemplateVars, err = m.gadgetTemplateVars()
+		if err != nil {
+			return fmt.Errorf("cannot prepare gadget template variables: %v", err)
+		}
+		st.Unlock()
+		err = gadgetRollback(*currentData, *updateData, rollbackDir)
+		st.Lock()
+		if err != nil {
+			return fmt.Errorf("cannot rollback gadget assets: %v", err)
+		}
+
+		// the digests of the previous assets are tracked again only
+		// once the assets themselves are restored, so that both match
+		var tracked bool
+		if err := t.Get("boot-assets-tracked", &tracked); err != nil && err != state.ErrNoState {
+			return err
+		}
+		if tracked {
+			var oldDigests map[string]string
+			if err := t.Get("old-boot-assets", &oldDigests); err != nil && err != state.ErrNoState {
+				return err
+			}
+			setTrackedBootAssets(st, oldDigests)
+		}
+	}
+	boundCmdline, err := boot.CurrentKernelCmdline()
+	if err != nil {
+		return err
+	}
+	if cmdlineUpdated {
+		cmdline, err := readKernelCmdline(st, currentData.RootDir)
+		if err != nil {
+			return err
+		}
+		if _, err := updateKernelCmdline(cmdline); err != nil {
+			return err
+		}
+	}
+
+	if err := resealDataKey(st, boundDigests, boundCmdline); err != nil {
+		return fmt.Errorf("cannot reseal the key of the encrypted data partition: %v", err)
+	}
+
+	t.SetStatus(state.UndoneStatus)
+	st.RequestRestart(state.RestartSystem)
+	return nil
+}
+
+func (m *DeviceManager) cleanupUpdateGadgetAssets(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var rollbackDir string
+	if err := t.Get("rollback-dir", &rollbackDir); err != nil {
+		if err == state.ErrNoState {
+			return nil
+		}
+		return err
+	}
+	var keep bool
+	if err := t.Get("keep-rollback-dir", &keep); err != nil && err != state.ErrNoState {
+		return err
+	}
+	switch {
+	case t.Status() == state.ErrorStatus:
+		// left for inspection
+		return nil
+	case keep && t.Status() == state.DoneStatus:
+		// removed once the paired kernel booted, see
+		// snapstate.PairedUpdate
+		return nil
+	}
+	if err := os.RemoveAll(rollbackDir); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("failed to remove gadget update rollback directory %q: %v", rollbackDir, err)
+	}
+	return nil
+}
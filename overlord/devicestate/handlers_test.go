@@ -33,6 +33,8 @@ import (
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
 )
 
 // TODO: should we move this into a new handlers suite?
@@ -252,6 +254,73 @@ func (s *deviceMgrSuite) TestSetModelHandlerRereg(c *C) {
 	})
 }
 
+func (s *deviceMgrSuite) TestSetModelHandlerSwitchGadgetRemovesOldGadget(c *C) {
+	s.state.Lock()
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "canonical",
+		Model: "pc-model",
+	})
+	s.makeModelAssertionInState(c, "canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"revision":     "1",
+	})
+	for _, name := range []string{"pc", "other-pc"} {
+		si := &snap.SideInfo{
+			RealName: name,
+			Revision: snap.R(1),
+		}
+		snaptest.MockSnap(c, fmt.Sprintf("name: %s\ntype: gadget\nversion: gadget\n", name), si)
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			SnapType: "gadget",
+			Active:   true,
+			Sequence: []*snap.SideInfo{si},
+			Current:  si.Revision,
+		})
+	}
+	s.state.Unlock()
+
+	newModel := s.brands.Model("canonical", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "other-pc",
+		"revision":     "2",
+	})
+
+	s.state.Lock()
+	t := s.state.NewTask("set-model", "set-model test")
+	chg := s.state.NewChange("dummy", "...")
+	chg.Set("new-model", string(asserts.Encode(newModel)))
+	chg.AddTask(t)
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(chg.Err(), IsNil)
+
+	m, err := s.mgr.Model()
+	c.Assert(err, IsNil)
+	c.Assert(m, DeepEquals, newModel)
+
+	// the gadget of the previous model is removed
+	var removeChg *state.Change
+	for _, chg := range s.state.Changes() {
+		if chg.Kind() == "remove-snap" {
+			removeChg = chg
+		}
+	}
+	c.Assert(removeChg, NotNil)
+	c.Check(removeChg.Summary(), Equals, `Remove gadget "pc" of the previous model`)
+	snapsup, err := snapstate.TaskSnapSetup(removeChg.Tasks()[0])
+	c.Assert(err, IsNil)
+	c.Check(snapsup.InstanceName(), Equals, "pc")
+}
+
 func (s *deviceMgrSuite) TestDoPrepareRemodeling(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
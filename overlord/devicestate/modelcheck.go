@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// modelConformance records the outcome of checking that the installed
+// and booted kernel, gadget and base match the model.
+type modelConformance struct {
+	// Brand and Model identify the model that was checked against
+	Brand string `json:"brand"`
+	Model string `json:"model"`
+	// Drift lists the ways in which the system does not match the
+	// model, if any
+	Drift []string `json:"drift,omitempty"`
+	// Checked is when the check happened
+	Checked time.Time `json:"checked"`
+}
+
+// deviceSnapRequirement is a snap of the model the device cannot work
+// without.
+type deviceSnapRequirement struct {
+	which string
+	// names are the acceptable names of the snap, the first one being
+	// the one declared by the model
+	names []string
+	types []snap.Type
+	// bootType is the type used to find the booted revision of the
+	// snap, if it is booted
+	bootType snap.Type
+}
+
+func deviceSnapRequirements(model *asserts.Model) []deviceSnapRequirement {
+	// models without a base use the core snap, older systems may
+	// still be using ubuntu-core
+	bases := []string{model.Base()}
+	if model.Base() == "" {
+		bases = []string{"core", "ubuntu-core"}
+	}
+	return []deviceSnapRequirement{
+		{which: "kernel", names: []string{model.Kernel()}, types: []snap.Type{snap.TypeKernel}, bootType: snap.TypeKernel},
+		{which: "gadget", names: []string{model.Gadget()}, types: []snap.Type{snap.TypeGadget}},
+		{which: "base", names: bases, types: []snap.Type{snap.TypeBase, snap.TypeOS}, bootType: snap.TypeBase},
+	}
+}
+
+func hasType(types []snap.Type, typ snap.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// modelDrift returns the ways in which the kernel, gadget and base that
+// are installed and booted do not match the given model. Other kernel and
+// gadget snaps, like the ones left over by a remodel, are not drift as
+// long as they are not the ones in use.
+func modelDrift(st *state.State, model *asserts.Model) ([]string, error) {
+	snapStates, err := snapstate.All(st)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for _, req := range deviceSnapRequirements(model) {
+		if req.names[0] == "" {
+			continue
+		}
+
+		found := false
+		for _, name := range req.names {
+			snapst := snapStates[name]
+			if snapst == nil || !snapst.IsInstalled() {
+				continue
+			}
+			typ, err := snapst.Type()
+			if err != nil {
+				return nil, err
+			}
+			if !hasType(req.types, typ) {
+				drift = append(drift, fmt.Sprintf("%s snap %q required by the model has type %q", req.which, name, typ))
+			}
+			found = true
+			break
+		}
+		if !found {
+			drift = append(drift, fmt.Sprintf("%s snap %q required by the model is not installed", req.which, req.names[0]))
+		}
+
+		if req.bootType == "" {
+			continue
+		}
+		booted, err := boot.GetCurrentBoot(req.bootType)
+		if err == boot.ErrBootNameAndRevisionAgain {
+			// a new revision is being tried, check on the next boot
+			continue
+		}
+		if err != nil {
+			logger.Noticef("cannot check the booted %s against the model: %v", req.which, err)
+			continue
+		}
+		if !strutil.ListContains(req.names, booted.Name) {
+			drift = append(drift, fmt.Sprintf("booted %s snap %q is not the %s snap %q required by the model", req.which, booted.Name, req.which, req.names[0]))
+		}
+	}
+
+	return drift, nil
+}
+
+// ensureModelConformance checks once per boot that the installed and
+// booted kernel, gadget and base match the model, as they might not if
+// for example an unrelated kernel was sideloaded in recovery. Any drift
+// is reported with a warning and recorded in the state.
+func (m *DeviceManager) ensureModelConformance() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	if release.OnClassic || m.modelConformanceChecked {
+		return nil
+	}
+
+	var seeded bool
+	err := m.state.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !seeded {
+		return nil
+	}
+	if m.changeInFlight("remodel") {
+		// the system is expected not to match the model while
+		// remodeling, check again on the next boot
+		m.modelConformanceChecked = true
+		return nil
+	}
+
+	model, err := findModel(m.state)
+	if err == state.ErrNoState {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	drift, err := modelDrift(m.state, model)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		logger.Noticef("System does not match model %q of brand %q: %s", model.Model(), model.BrandID(), d)
+		m.state.WarnfWithSeverity(state.WarningSeverityCritical, "system does not match model %q of brand %q: %s", model.Model(), model.BrandID(), d)
+	}
+	m.state.Set("model-conformance", &modelConformance{
+		Brand:   model.BrandID(),
+		Model:   model.Model(),
+		Drift:   drift,
+		Checked: timeNow(),
+	})
+	m.modelConformanceChecked = true
+	return nil
+}
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type modelConformanceRecord struct {
+	Brand string   `json:"brand"`
+	Model string   `json:"model"`
+	Drift []string `json:"drift"`
+}
+
+func (s *deviceMgrSuite) setupModelConformance(c *C, snaps map[string]snap.Type) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.state.Set("seeded", true)
+	s.makeModelAssertionInState(c, "my-brand", "my-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "my-brand",
+		Model: "my-model",
+	})
+	for name, typ := range snaps {
+		si := &snap.SideInfo{RealName: name, Revision: snap.R(1)}
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			SnapType: string(typ),
+			Active:   true,
+			Sequence: []*snap.SideInfo{si},
+			Current:  si.Revision,
+		})
+	}
+}
+
+func (s *deviceMgrSuite) modelConformance(c *C) *modelConformanceRecord {
+	s.state.Lock()
+	defer s.state.Unlock()
+	var mc modelConformanceRecord
+	c.Assert(s.state.Get("model-conformance", &mc), IsNil)
+	return &mc
+}
+
+func (s *deviceMgrSuite) warnings() []string {
+	s.state.Lock()
+	defer s.state.Unlock()
+	var msgs []string
+	for _, w := range s.state.AllWarnings() {
+		msgs = append(msgs, w.String())
+	}
+	return msgs
+}
+
+func (s *deviceMgrSuite) TestModelConformanceHappy(c *C) {
+	s.setupModelConformance(c, map[string]snap.Type{
+		"pc-kernel": snap.TypeKernel,
+		"pc":        snap.TypeGadget,
+		"core18":    snap.TypeBase,
+	})
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_kernel": "pc-kernel_1.snap",
+		"snap_core":   "core18_1.snap",
+	})
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	c.Check(s.modelConformance(c), DeepEquals, &modelConformanceRecord{
+		Brand: "my-brand",
+		Model: "my-model",
+	})
+	c.Check(s.warnings(), HasLen, 0)
+}
+
+func (s *deviceMgrSuite) TestModelConformanceDrift(c *C) {
+	// an unrelated kernel was sideloaded and booted, and the gadget is
+	// gone
+	s.setupModelConformance(c, map[string]snap.Type{
+		"pc-kernel":    snap.TypeKernel,
+		"other-kernel": snap.TypeKernel,
+		"core18":       snap.TypeBase,
+	})
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_kernel": "other-kernel_1.snap",
+		"snap_core":   "core18_1.snap",
+	})
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	drift := []string{
+		`booted kernel snap "other-kernel" is not the kernel snap "pc-kernel" required by the model`,
+		`gadget snap "pc" required by the model is not installed`,
+	}
+	c.Check(s.modelConformance(c).Drift, DeepEquals, drift)
+	warnings := s.warnings()
+	c.Assert(warnings, HasLen, len(drift))
+	for _, d := range drift {
+		c.Check(warnings, testutil.Contains, `system does not match model "my-model" of brand "my-brand": `+d)
+	}
+	s.state.Lock()
+	for _, w := range s.state.AllWarnings() {
+		c.Check(w.Severity(), Equals, state.WarningSeverityCritical)
+	}
+	s.state.Unlock()
+}
+
+func (s *deviceMgrSuite) TestModelConformanceLeftoversOfRemodel(c *C) {
+	// the kernel and gadget of the previous model are still installed
+	s.setupModelConformance(c, map[string]snap.Type{
+		"pc-kernel":  snap.TypeKernel,
+		"pc":         snap.TypeGadget,
+		"core18":     snap.TypeBase,
+		"old-kernel": snap.TypeKernel,
+		"old-pc":     snap.TypeGadget,
+	})
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_kernel": "pc-kernel_1.snap",
+		"snap_core":   "core18_1.snap",
+	})
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	c.Check(s.modelConformance(c).Drift, HasLen, 0)
+	c.Check(s.warnings(), HasLen, 0)
+}
+
+func (s *deviceMgrSuite) TestModelConformanceWrongType(c *C) {
+	s.setupModelConformance(c, map[string]snap.Type{
+		"pc-kernel": snap.TypeKernel,
+		"pc":        snap.TypeApp,
+		"core18":    snap.TypeBase,
+	})
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_mode":   "trying",
+		"snap_kernel": "pc-kernel_1.snap",
+		"snap_core":   "core18_1.snap",
+	})
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	// the booted snaps are not checked while trying new revisions
+	c.Check(s.modelConformance(c).Drift, DeepEquals, []string{
+		`gadget snap "pc" required by the model has type "app"`,
+	})
+}
+
+func (s *deviceMgrSuite) TestModelConformanceCheckedOncePerBoot(c *C) {
+	s.setupModelConformance(c, nil)
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+	c.Check(s.modelConformance(c).Drift, HasLen, 3)
+
+	s.state.Lock()
+	s.state.Set("model-conformance", nil)
+	s.state.Unlock()
+
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+	s.state.Lock()
+	defer s.state.Unlock()
+	var mc modelConformanceRecord
+	c.Check(s.state.Get("model-conformance", &mc), Equals, state.ErrNoState)
+}
+
+func (s *deviceMgrSuite) TestModelConformanceSkipped(c *C) {
+	s.setupModelConformance(c, nil)
+
+	s.state.Lock()
+	chg := s.state.NewChange("remodel", "...")
+	chg.SetStatus(state.DoingStatus)
+	s.state.Unlock()
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	release.OnClassic = true
+	c.Assert(devicestate.EnsureModelConformance(s.mgr), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var mc modelConformanceRecord
+	c.Check(s.state.Get("model-conformance", &mc), Equals, state.ErrNoState)
+	c.Check(s.state.AllWarnings(), HasLen, 0)
+}
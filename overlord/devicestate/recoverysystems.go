@@ -0,0 +1,394 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+// RecoverySystem describes a recovery system created on the device.
+type RecoverySystem struct {
+	Label string `json:"label"`
+	Brand string `json:"brand"`
+	Model string `json:"model"`
+	// Created is when the recovery system was created.
+	Created time.Time `json:"created"`
+}
+
+const (
+	recoverySystemFile = "system.json"
+	// defaultRecoverySystemsRetain is how many recovery systems are
+	// kept unless system.recovery-systems.retain says otherwise
+	defaultRecoverySystemsRetain = 3
+	maxRecoverySystemLabelLen    = 64
+)
+
+var validRecoverySystemLabel = regexp.MustCompile(`^[a-z0-9](?:-?[a-z0-9])*$`)
+
+// ValidateRecoverySystemLabel checks that the given label can be used
+// for a recovery system.
+func ValidateRecoverySystemLabel(label string) error {
+	if len(label) > maxRecoverySystemLabelLen || !validRecoverySystemLabel.MatchString(label) {
+		return fmt.Errorf("invalid recovery system label %q", label)
+	}
+	return nil
+}
+
+func recoverySystemDir(label string) string {
+	return filepath.Join(dirs.SnapSeedSystemsDir, label)
+}
+
+// RecoverySystems returns the recovery systems created on the device,
+// oldest first.
+func RecoverySystems() ([]*RecoverySystem, error) {
+	entries, err := ioutil.ReadDir(dirs.SnapSeedSystemsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var systems []*RecoverySystem
+	for _, entry := range entries {
+		if !entry.IsDir() || ValidateRecoverySystemLabel(entry.Name()) != nil {
+			// not a recovery system, or one being created
+			continue
+		}
+		system, err := readRecoverySystem(entry.Name())
+		if err != nil {
+			logger.Noticef("cannot read recovery system %q: %v", entry.Name(), err)
+			continue
+		}
+		systems = append(systems, system)
+	}
+	sort.SliceStable(systems, func(i, j int) bool {
+		return systems[i].Created.Before(systems[j].Created)
+	})
+	return systems, nil
+}
+
+func readRecoverySystem(label string) (*RecoverySystem, error) {
+	data, err := ioutil.ReadFile(filepath.Join(recoverySystemDir(label), recoverySystemFile))
+	if err != nil {
+		return nil, err
+	}
+	var system RecoverySystem
+	if err := json.Unmarshal(data, &system); err != nil {
+		return nil, err
+	}
+	if system.Label != label {
+		return nil, fmt.Errorf("label mismatch, %q recorded", system.Label)
+	}
+	return &system, nil
+}
+
+// CreateRecoverySystem creates a change that creates a new recovery
+// system with the given label, or one derived from the current time if
+// empty, out of the currently installed snaps and their assertions.
+// The oldest recovery systems are pruned afterwards as per the
+// system.recovery-systems.retain setting.
+func CreateRecoverySystem(st *state.State, label string) (*state.Change, error) {
+	if release.OnClassic {
+		return nil, fmt.Errorf("cannot create recovery systems on a classic system")
+	}
+
+	if label == "" {
+		label = timeNow().UTC().Format("20060102-150405")
+	}
+	if err := ValidateRecoverySystemLabel(label); err != nil {
+		return nil, err
+	}
+
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if !seeded {
+		return nil, fmt.Errorf("cannot create recovery system until fully seeded")
+	}
+
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "create-recovery-system" && !chg.IsReady() {
+			return nil, fmt.Errorf("cannot create recovery system while change %q is creating another one", chg.ID())
+		}
+	}
+
+	if osutil.FileExists(recoverySystemDir(label)) {
+		return nil, fmt.Errorf("recovery system %q already exists", label)
+	}
+
+	create := st.NewTask("create-recovery-system", fmt.Sprintf(i18n.G("Create recovery system %q"), label))
+	create.Set("recovery-system-label", label)
+
+	chg := st.NewChange("create-recovery-system", fmt.Sprintf(i18n.G("Create recovery system %q"), label))
+	chg.AddTask(create)
+
+	return chg, nil
+}
+
+func (m *DeviceManager) doCreateRecoverySystem(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var label string
+	if err := t.Get("recovery-system-label", &label); err != nil {
+		return err
+	}
+
+	model, err := findModel(st)
+	if err != nil {
+		return err
+	}
+
+	if err := createRecoverySystem(st, label, model); err != nil {
+		return fmt.Errorf("cannot create recovery system %q: %v", label, err)
+	}
+	t.Logf("Created recovery system %q", label)
+
+	// the new recovery system is in place, failing to prune the old
+	// ones must not fail the task as it cannot be undone
+	retain := defaultRecoverySystemsRetain
+	if err := config.NewTransaction(st).GetMaybe("core", "system.recovery-systems.retain", &retain); err != nil {
+		t.Errorf("cannot prune old recovery systems: %v", err)
+		return nil
+	}
+	pruned, err := pruneRecoverySystems(retain)
+	for _, label := range pruned {
+		t.Logf("Removed recovery system %q", label)
+	}
+	if err != nil {
+		t.Errorf("cannot prune old recovery systems: %v", err)
+	}
+
+	return nil
+}
+
+// recoverySystemSnap is an installed snap to put in a recovery system.
+type recoverySystemSnap struct {
+	info   *snap.Info
+	snapst *snapstate.SnapState
+	digest string
+}
+
+// createRecoverySystem writes the recovery system with the given label
+// made of the installed snaps. The system is put together in a
+// temporary directory that is only renamed into place when complete.
+// It is called with the state locked, the lock is released while the
+// snaps are hashed and copied.
+func createRecoverySystem(st *state.State, label string, model *asserts.Model) error {
+	systemDir := recoverySystemDir(label)
+	if osutil.FileExists(systemDir) {
+		return fmt.Errorf("recovery system already exists")
+	}
+	tmpDir := systemDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	snapsDir := filepath.Join(tmpDir, "snaps")
+	assertsDir := filepath.Join(tmpDir, "assertions")
+	for _, d := range []string{snapsDir, assertsDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := assertstate.DB(st)
+	var added []asserts.Assertion
+	retrieve := func(ref *asserts.Ref) (asserts.Assertion, error) {
+		return ref.Resolve(db.Find)
+	}
+	save := func(a asserts.Assertion) error {
+		added = append(added, a)
+		return nil
+	}
+	f := asserts.NewFetcher(db, retrieve, save)
+
+	if err := f.Save(model); err != nil {
+		return fmt.Errorf("cannot find prerequisites of the model assertion: %v", err)
+	}
+	if model.Store() != "" {
+		err := snapasserts.FetchStore(f, model.Store())
+		if err != nil {
+			if nfe, ok := err.(*asserts.NotFoundError); !ok || nfe.Type != asserts.StoreType {
+				return err
+			}
+		}
+	}
+
+	all, err := snapstate.All(st)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	snaps := make([]*recoverySystemSnap, 0, len(names))
+	have := make(map[string]bool, len(names))
+	for _, name := range names {
+		snapst := all[name]
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return err
+		}
+		snaps = append(snaps, &recoverySystemSnap{info: info, snapst: snapst})
+		have[info.InstanceName()] = true
+	}
+
+	// the system must be able to boot and seed the model
+	base := model.Base()
+	if base == "" {
+		base = "core"
+	}
+	for _, name := range append([]string{base, model.Kernel(), model.Gadget()}, model.RequiredSnaps()...) {
+		if name != "" && !have[name] {
+			return fmt.Errorf("snap %q required by the model is not installed", name)
+		}
+	}
+
+	// hashing and copying the snaps takes a while, do not hold the
+	// state meanwhile
+	st.Unlock()
+	err = copyRecoverySystemSnaps(snaps, snapsDir)
+	st.Lock()
+	if err != nil {
+		return err
+	}
+
+	var seed snap.Seed
+	for _, sn := range snaps {
+		info := sn.info
+		if sn.digest != "" {
+			if err := snapasserts.FetchSnapAssertions(f, sn.digest); err != nil {
+				return fmt.Errorf("cannot find assertions of snap %q: %v", info.InstanceName(), err)
+			}
+		}
+		seed.Snaps = append(seed.Snaps, &snap.SeedSnap{
+			Name:       info.InstanceName(),
+			SnapID:     info.SnapID,
+			Channel:    sn.snapst.Channel,
+			DevMode:    sn.snapst.DevMode,
+			Classic:    sn.snapst.Classic,
+			Contact:    info.Contact,
+			Unasserted: info.SnapID == "",
+			File:       filepath.Base(info.MountFile()),
+		})
+	}
+
+	for _, a := range added {
+		var afn string
+		ref := a.Ref()
+		if ref.Type == asserts.ModelType {
+			afn = "model"
+		} else {
+			afn = fmt.Sprintf("%s.%s", strings.Join(ref.PrimaryKey, ","), ref.Type.Name)
+		}
+		if err := ioutil.WriteFile(filepath.Join(assertsDir, afn), asserts.Encode(a), 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := seed.Write(filepath.Join(tmpDir, "seed.yaml")); err != nil {
+		return fmt.Errorf("cannot write seed.yaml: %v", err)
+	}
+
+	data, err := json.Marshal(&RecoverySystem{
+		Label:   label,
+		Brand:   model.BrandID(),
+		Model:   model.Model(),
+		Created: timeNow(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := osutil.AtomicWriteFile(filepath.Join(tmpDir, recoverySystemFile), data, 0644, 0); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpDir, systemDir)
+}
+
+// copyRecoverySystemSnaps copies the files of the given snaps to
+// snapsDir, computing the digests of the asserted ones on the way. It
+// does not use the state.
+func copyRecoverySystemSnaps(snaps []*recoverySystemSnap, snapsDir string) error {
+	for _, sn := range snaps {
+		snapPath := sn.info.MountFile()
+		if sn.info.SnapID != "" {
+			digest, _, err := asserts.SnapFileSHA3_384(snapPath)
+			if err != nil {
+				return err
+			}
+			sn.digest = digest
+		}
+		if err := osutil.CopyFile(snapPath, filepath.Join(snapsDir, filepath.Base(snapPath)), osutil.CopyFlagPreserveAll|osutil.CopyFlagSync); err != nil {
+			return fmt.Errorf("cannot copy snap %q: %v", sn.info.InstanceName(), err)
+		}
+	}
+	return nil
+}
+
+// pruneRecoverySystems removes the oldest recovery systems so that at
+// most retain of them are left, returning the labels of the removed
+// ones.
+func pruneRecoverySystems(retain int) ([]string, error) {
+	systems, err := RecoverySystems()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for len(systems) > retain {
+		label := systems[0].Label
+		if err := os.RemoveAll(recoverySystemDir(label)); err != nil {
+			return pruned, fmt.Errorf("cannot remove recovery system %q: %v", label, err)
+		}
+		pruned = append(pruned, label)
+		systems = systems[1:]
+	}
+	return pruned, nil
+}
@@ -0,0 +1,297 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/assertstate/assertstatetest"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *deviceMgrSuite) mockInstalledSnap(c *C, snapYaml string, si *snap.SideInfo) *snap.Info {
+	info := snaptest.MockSnap(c, snapYaml, si)
+	snapFile := snaptest.MakeTestSnapWithFiles(c, snapYaml, nil)
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	c.Assert(os.Rename(snapFile, info.MountFile()), IsNil)
+	snapstate.Set(s.state, info.InstanceName(), &snapstate.SnapState{
+		SnapType: string(info.GetType()),
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+		Active:   true,
+		Channel:  si.Channel,
+	})
+	return info
+}
+
+func (s *deviceMgrSuite) setupRecoverySystem(c *C) {
+	s.makeModelAssertionInState(c, "my-brand", "pc-model", map[string]interface{}{
+		"architecture": "amd64",
+		"kernel":       "pc-kernel",
+		"gadget":       "pc",
+		"base":         "core18",
+	})
+	devicestatetest.SetDevice(s.state, &auth.DeviceState{
+		Brand: "my-brand",
+		Model: "pc-model",
+	})
+	s.state.Set("seeded", true)
+
+	s.mockInstalledSnap(c, "name: core18\nversion: 1.0\ntype: base", &snap.SideInfo{
+		RealName: "core18",
+		Revision: snap.R(-1),
+	})
+	s.mockInstalledSnap(c, "name: pc-kernel\nversion: 1.0\ntype: kernel", &snap.SideInfo{
+		RealName: "pc-kernel",
+		Revision: snap.R(-1),
+	})
+	gadgetInfo := s.mockInstalledSnap(c, "name: pc\nversion: 1.0\ntype: gadget", &snap.SideInfo{
+		RealName: "pc",
+		SnapID:   "pc-id",
+		Revision: snap.R(3),
+		Channel:  "18/stable",
+	})
+
+	// booted into the installed snaps
+	s.bootloader.SetBootVars(map[string]string{
+		"snap_core":   "core18_x1.snap",
+		"snap_kernel": "pc-kernel_x1.snap",
+	})
+
+	s.setupSnapDecl(c, gadgetInfo, "my-brand")
+	digest, size, err := asserts.SnapFileSHA3_384(gadgetInfo.MountFile())
+	c.Assert(err, IsNil)
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": digest,
+		"snap-size":     fmt.Sprintf("%d", size),
+		"snap-id":       "pc-id",
+		"snap-revision": "3",
+		"developer-id":  "my-brand",
+		"timestamp":     time.Now().UTC().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	assertstatetest.AddMany(s.state, snapRev)
+}
+
+func (s *deviceMgrSuite) createRecoverySystem(c *C, label string) *state.Change {
+	chg, err := devicestate.CreateRecoverySystem(s.state, label)
+	c.Assert(err, IsNil)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	return chg
+}
+
+func (s *deviceMgrSuite) TestCreateRecoverySystemUnhappy(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := devicestate.CreateRecoverySystem(s.state, "1234")
+	c.Check(err, ErrorMatches, "cannot create recovery system until fully seeded")
+
+	s.state.Set("seeded", true)
+	for _, label := range []string{"-1234", "UPPER", "a--b", "a.tmp", "a_b"} {
+		_, err = devicestate.CreateRecoverySystem(s.state, label)
+		c.Check(err, ErrorMatches, `invalid recovery system label ".*"`)
+	}
+
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapSeedSystemsDir, "1234"), 0755), IsNil)
+	_, err = devicestate.CreateRecoverySystem(s.state, "1234")
+	c.Check(err, ErrorMatches, `recovery system "1234" already exists`)
+
+	chg := s.state.NewChange("create-recovery-system", "...")
+	chg.SetStatus(state.DoingStatus)
+	_, err = devicestate.CreateRecoverySystem(s.state, "5678")
+	c.Check(err, ErrorMatches, `cannot create recovery system while change "1" is creating another one`)
+
+	restore := release.MockOnClassic(true)
+	defer restore()
+	_, err = devicestate.CreateRecoverySystem(s.state, "5678")
+	c.Check(err, ErrorMatches, "cannot create recovery systems on a classic system")
+}
+
+func (s *deviceMgrSuite) TestCreateRecoverySystem(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRecoverySystem(c)
+	now := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	restore := devicestate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	chg := s.createRecoverySystem(c, "")
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Kind(), Equals, "create-recovery-system")
+	c.Check(chg.Summary(), Equals, `Create recovery system "20200304-050607"`)
+
+	systemDir := filepath.Join(dirs.SnapSeedSystemsDir, "20200304-050607")
+	for _, fn := range []string{"core18_x1.snap", "pc-kernel_x1.snap", "pc_3.snap"} {
+		c.Check(filepath.Join(systemDir, "snaps", fn), testutil.FilePresent)
+	}
+	c.Check(filepath.Join(systemDir+".tmp"), testutil.FileAbsent)
+
+	seed, err := snap.ReadSeedYaml(filepath.Join(systemDir, "seed.yaml"))
+	c.Assert(err, IsNil)
+	c.Check(seed.Snaps, DeepEquals, []*snap.SeedSnap{
+		{Name: "core18", Unasserted: true, File: "core18_x1.snap"},
+		{Name: "pc", SnapID: "pc-id", Channel: "18/stable", File: "pc_3.snap"},
+		{Name: "pc-kernel", Unasserted: true, File: "pc-kernel_x1.snap"},
+	})
+
+	// the model, the gadget assertions and their prerequisites
+	assertsDir := filepath.Join(systemDir, "assertions")
+	fis, err := ioutil.ReadDir(assertsDir)
+	c.Assert(err, IsNil)
+	types := make(map[string]int)
+	for _, fi := range fis {
+		data, err := ioutil.ReadFile(filepath.Join(assertsDir, fi.Name()))
+		c.Assert(err, IsNil)
+		a, err := asserts.Decode(data)
+		c.Assert(err, IsNil)
+		types[a.Type().Name]++
+	}
+	c.Check(types, DeepEquals, map[string]int{
+		"model":            1,
+		"account":          1,
+		"account-key":      2,
+		"snap-declaration": 1,
+		"snap-revision":    1,
+	})
+	c.Check(filepath.Join(assertsDir, "model"), testutil.FilePresent)
+
+	systems, err := devicestate.RecoverySystems()
+	c.Assert(err, IsNil)
+	c.Check(systems, DeepEquals, []*devicestate.RecoverySystem{{
+		Label:   "20200304-050607",
+		Brand:   "my-brand",
+		Model:   "pc-model",
+		Created: now,
+	}})
+}
+
+func (s *deviceMgrSuite) TestCreateRecoverySystemMissingModelSnap(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRecoverySystem(c)
+	snapstate.Set(s.state, "pc-kernel", nil)
+
+	chg := s.createRecoverySystem(c, "1234")
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot create recovery system "1234": snap "pc-kernel" required by the model is not installed.*`)
+	c.Check(filepath.Join(dirs.SnapSeedSystemsDir, "1234"), testutil.FileAbsent)
+	c.Check(filepath.Join(dirs.SnapSeedSystemsDir, "1234.tmp"), testutil.FileAbsent)
+}
+
+func (s *deviceMgrSuite) TestCreateRecoverySystemPrunes(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRecoverySystem(c)
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "system.recovery-systems.retain", 2), IsNil)
+	tr.Commit()
+
+	now := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	restore := devicestate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	for _, label := range []string{"first", "second", "third"} {
+		chg := s.createRecoverySystem(c, label)
+		c.Assert(chg.Err(), IsNil)
+		now = now.Add(time.Hour)
+	}
+
+	systems, err := devicestate.RecoverySystems()
+	c.Assert(err, IsNil)
+	c.Assert(systems, HasLen, 2)
+	c.Check(systems[0].Label, Equals, "second")
+	c.Check(systems[1].Label, Equals, "third")
+	c.Check(filepath.Join(dirs.SnapSeedSystemsDir, "first"), testutil.FileAbsent)
+}
+
+func (s *deviceMgrSuite) TestCreateRecoverySystemPruneFailureIsNotFatal(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupRecoverySystem(c)
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "system.recovery-systems.retain", "many"), IsNil)
+	tr.Commit()
+
+	chg := s.createRecoverySystem(c, "1234")
+	c.Assert(chg.Err(), IsNil)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+
+	// the new system is kept
+	systems, err := devicestate.RecoverySystems()
+	c.Assert(err, IsNil)
+	c.Assert(systems, HasLen, 1)
+	c.Check(systems[0].Label, Equals, "1234")
+
+	// and the failure to prune is logged on the task
+	t := chg.Tasks()[0]
+	c.Check(strings.Join(t.Log(), "\n"), Matches, `(?s).* ERROR cannot prune old recovery systems: .*`)
+}
+
+func (s *deviceMgrSuite) TestRecoverySystemsSkipsBroken(c *C) {
+	systems, err := devicestate.RecoverySystems()
+	c.Assert(err, IsNil)
+	c.Check(systems, HasLen, 0)
+
+	for label, created := range map[string]time.Time{
+		"newer": time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+		"older": time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	} {
+		data, err := json.Marshal(&devicestate.RecoverySystem{Label: label, Created: created})
+		c.Assert(err, IsNil)
+		c.Assert(os.MkdirAll(filepath.Join(dirs.SnapSeedSystemsDir, label), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapSeedSystemsDir, label, "system.json"), data, 0644), IsNil)
+	}
+	// incomplete or not a system
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapSeedSystemsDir, "broken"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapSeedSystemsDir, "other.tmp"), 0755), IsNil)
+
+	systems, err = devicestate.RecoverySystems()
+	c.Assert(err, IsNil)
+	c.Assert(systems, HasLen, 2)
+	c.Check(systems[0].Label, Equals, "older")
+	c.Check(systems[1].Label, Equals, "newer")
+}
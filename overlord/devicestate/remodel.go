@@ -135,7 +135,7 @@ func remodelCtx(st *state.State, oldModel, newModel *asserts.Model) (remodelCont
 	switch kind := ClassifyRemodel(oldModel, newModel); kind {
 	case UpdateRemodel:
 		// simple context for the simple case
-		remodCtx = &updateRemodelContext{baseRemodelContext{newModel}}
+		remodCtx = &updateRemodelContext{baseRemodelContext: baseRemodelContext{newModel}}
 	case StoreSwitchRemodel:
 		remodCtx = newNewStoreRemodelContext(st, devMgr, newModel)
 	case ReregRemodel:
@@ -230,6 +230,8 @@ func (rc baseRemodelContext) init(chg *state.Change) {
 // (no change to brand/model or store)
 type updateRemodelContext struct {
 	baseRemodelContext
+
+	serial string
 }
 
 func (rc *updateRemodelContext) Kind() RemodelKind {
@@ -246,10 +248,20 @@ func (rc *updateRemodelContext) Init(chg *state.Change) {
 	rc.associate(chg)
 }
 
+func (rc *updateRemodelContext) initialDevice(device *auth.DeviceState) error {
+	// the device identity is unchanged
+	rc.serial = device.Serial
+	return nil
+}
+
 func (rc *updateRemodelContext) Store() snapstate.StoreService {
 	return nil
 }
 
+func (rc *updateRemodelContext) Serial() (string, error) {
+	return rc.serial, nil
+}
+
 func (rc *updateRemodelContext) Finish() error {
 	// nothing more to do
 	return nil
@@ -314,6 +326,14 @@ func (rc *newStoreRemodelContext) Store() snapstate.StoreService {
 	return rc.store
 }
 
+func (rc *newStoreRemodelContext) Serial() (string, error) {
+	device, err := rc.device()
+	if err != nil {
+		return "", err
+	}
+	return device.Serial, nil
+}
+
 func (rc *newStoreRemodelContext) device() (*auth.DeviceState, error) {
 	var err error
 	var device auth.DeviceState
@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// SeedingTask describes a task of seeding the system.
+type SeedingTask struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+}
+
+// SeedingStatus describes the progress of seeding the system.
+type SeedingStatus struct {
+	Seeded bool `json:"seeded"`
+	// Change is the id of the most recent seed change, if any.
+	Change string `json:"change,omitempty"`
+	// Status is the status of the seed change.
+	Status string        `json:"status,omitempty"`
+	Tasks  []SeedingTask `json:"tasks,omitempty"`
+	// FailedTask is the task seeding failed at, if any.
+	FailedTask *SeedingTask `json:"failed-task,omitempty"`
+	// Error explains why seeding failed, either in FailedTask or
+	// before the seed change could be created.
+	Error string `json:"error,omitempty"`
+}
+
+// seedErrorKey is the state key under which the error from setting up the
+// seed change is kept until seeding is attempted again.
+const seedErrorKey = "seed-error"
+
+// lastSeedChange returns the most recently spawned seed change, if any.
+func lastSeedChange(st *state.State) *state.Change {
+	var last *state.Change
+	for _, chg := range st.Changes() {
+		if chg.Kind() != "seed" {
+			continue
+		}
+		if last == nil || chg.SpawnTime().After(last.SpawnTime()) {
+			last = chg
+		}
+	}
+	return last
+}
+
+// seedChangeError returns the error the given seed change failed with.
+func seedChangeError(chg *state.Change) string {
+	for _, t := range chg.Tasks() {
+		if t.Status() != state.ErrorStatus {
+			continue
+		}
+		if log := t.Log(); len(log) > 0 {
+			return log[len(log)-1]
+		}
+	}
+	return ""
+}
+
+// seedingFailed returns whether the last seed change failed and was not
+// explicitly asked to be retried with RetrySeeding.
+func seedingFailed(st *state.State) bool {
+	chg := lastSeedChange(st)
+	if chg == nil || chg.Status() != state.ErrorStatus {
+		return false
+	}
+	var retry bool
+	chg.Get("retry", &retry)
+	return !retry
+}
+
+// Seeding returns the progress of seeding the system, and why it failed if
+// it did.
+func Seeding(st *state.State) (*SeedingStatus, error) {
+	var status SeedingStatus
+	if err := st.Get("seeded", &status.Seeded); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if err := st.Get(seedErrorKey, &status.Error); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+
+	chg := lastSeedChange(st)
+	if chg == nil {
+		return &status, nil
+	}
+	status.Change = chg.ID()
+	status.Status = chg.Status().String()
+	for _, t := range chg.Tasks() {
+		task := SeedingTask{
+			ID:      t.ID(),
+			Kind:    t.Kind(),
+			Summary: t.Summary(),
+			Status:  t.Status().String(),
+		}
+		status.Tasks = append(status.Tasks, task)
+		if t.Status() == state.ErrorStatus && status.FailedTask == nil {
+			status.FailedTask = &task
+			if log := t.Log(); len(log) > 0 {
+				status.Error = log[len(log)-1]
+			}
+		}
+	}
+	return &status, nil
+}
+
+// RetrySeeding asks for seeding to be attempted again right away after it
+// failed. A failed seed change is otherwise retried with backoff.
+func RetrySeeding(st *state.State) error {
+	var seeded bool
+	if err := st.Get("seeded", &seeded); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if seeded {
+		return fmt.Errorf("cannot retry seeding: system is already seeded")
+	}
+	chg := lastSeedChange(st)
+	if chg != nil && !chg.Status().Ready() {
+		return fmt.Errorf("cannot retry seeding: seeding is in progress in change %s", chg.ID())
+	}
+	if chg != nil && chg.Status() == state.ErrorStatus {
+		chg.Set("retry", true)
+	}
+	st.EnsureBefore(0)
+	return nil
+}
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var (
+	timeNow = time.Now
+
+	// minSaneTime is a time the system clock cannot possibly be
+	// before, it is bumped with releases
+	minSaneTime = time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	// lastKnownGoodInterval is how often the last known good time
+	// is recorded in the state while the clock is synchronized
+	lastKnownGoodInterval = 24 * time.Hour
+
+	timeSynchronized = timeSynchronizedImpl
+)
+
+// TIME_ERROR as returned by adjtimex(2) when the clock is not
+// synchronized
+const adjtimexTimeError = 5
+
+func timeSynchronizedImpl() bool {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return false
+	}
+	return state != adjtimexTimeError
+}
+
+// timeSanity records the decisions taken about the system clock.
+type timeSanity struct {
+	// LastKnownGood is the last time the clock was seen
+	// synchronized, the clock cannot be before it
+	LastKnownGood time.Time `json:"last-known-good,omitempty"`
+	// ClockBehind is set when the clock was found to be before
+	// Earliest, i.e. obviously wrong
+	ClockBehind bool `json:"clock-behind,omitempty"`
+	// Synchronized reflects whether the clock was synchronized
+	Synchronized bool `json:"synchronized,omitempty"`
+	// Earliest is the time assumed to be the earliest the current
+	// time can be when checking assertions
+	Earliest time.Time `json:"earliest"`
+	// Decided is when the current decision was taken
+	Decided time.Time `json:"decided"`
+}
+
+// ensureTimeSanity detects obviously wrong system clocks, as found
+// on devices with dead RTCs before time synchronization happens. As
+// long as the clock is before the earliest possible time the
+// assertion checks assume that time instead, so that keys are not
+// considered not yet valid, but only the last known good time is
+// trusted, so keys expired by then are still rejected. The decision
+// is recorded in the state.
+func (m *DeviceManager) ensureTimeSanity() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	var ts timeSanity
+	err := m.state.Get("time-sanity", &ts)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+
+	now := timeNow()
+	synchronized := timeSynchronized()
+
+	earliest := minSaneTime
+	if ts.LastKnownGood.After(earliest) {
+		earliest = ts.LastKnownGood
+	}
+	clockBehind := now.Before(earliest)
+
+	changed := false
+	if synchronized && !clockBehind && now.Sub(ts.LastKnownGood) >= lastKnownGoodInterval {
+		// only a synchronized clock is trusted to move the
+		// earliest time forward
+		ts.LastKnownGood = now
+		changed = true
+	}
+	if clockBehind != ts.ClockBehind || synchronized != ts.Synchronized || !earliest.Equal(ts.Earliest) {
+		if clockBehind && !ts.ClockBehind {
+			logger.Noticef("System clock %s is before the earliest possible time %s, deferring to it for assertion checks until time synchronization.", now.Format(time.RFC3339), earliest.Format(time.RFC3339))
+		}
+		if !clockBehind && ts.ClockBehind {
+			logger.Noticef("System clock is sane again.")
+		}
+		ts.ClockBehind = clockBehind
+		ts.Synchronized = synchronized
+		ts.Earliest = earliest
+		ts.Decided = now
+		changed = true
+	}
+	if changed {
+		m.state.Set("time-sanity", &ts)
+	}
+
+	assertstate.SetEarliestTime(m.state, earliest)
+	return nil
+}
@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package devicestate_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/devicestate"
+)
+
+type timeSanityRecord struct {
+	LastKnownGood time.Time `json:"last-known-good"`
+	ClockBehind   bool      `json:"clock-behind"`
+	Synchronized  bool      `json:"synchronized"`
+	Earliest      time.Time `json:"earliest"`
+	Decided       time.Time `json:"decided"`
+}
+
+func (s *deviceMgrSuite) timeSanity(c *C) *timeSanityRecord {
+	s.state.Lock()
+	defer s.state.Unlock()
+	var ts timeSanityRecord
+	c.Assert(s.state.Get("time-sanity", &ts), IsNil)
+	return &ts
+}
+
+func (s *deviceMgrSuite) TestTimeSanityClockBehind(c *C) {
+	minSane := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	defer devicestate.MockMinSaneTime(minSane)()
+	now := time.Date(1970, 1, 1, 0, 5, 0, 0, time.UTC)
+	defer devicestate.MockTimeNow(func() time.Time { return now })()
+	defer devicestate.MockTimeSynchronized(func() bool { return false })()
+
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+
+	ts := s.timeSanity(c)
+	c.Check(ts.ClockBehind, Equals, true)
+	c.Check(ts.Synchronized, Equals, false)
+	c.Check(ts.Earliest.Equal(minSane), Equals, true)
+	c.Check(ts.Decided.Equal(now), Equals, true)
+	c.Check(ts.LastKnownGood.IsZero(), Equals, true)
+
+	// time gets synchronized
+	synced := minSane.Add(48 * time.Hour)
+	now = synced
+	restore := devicestate.MockTimeSynchronized(func() bool { return true })
+	defer restore()
+
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+
+	ts = s.timeSanity(c)
+	c.Check(ts.ClockBehind, Equals, false)
+	c.Check(ts.Synchronized, Equals, true)
+	c.Check(ts.LastKnownGood.Equal(synced), Equals, true)
+	c.Check(ts.Earliest.Equal(minSane), Equals, true)
+
+	// after a reboot with a dead clock again the last known good
+	// time is the earliest possible one
+	now = time.Date(1970, 1, 1, 0, 5, 0, 0, time.UTC)
+	restore()
+	defer devicestate.MockTimeSynchronized(func() bool { return false })()
+
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+
+	ts = s.timeSanity(c)
+	c.Check(ts.ClockBehind, Equals, true)
+	c.Check(ts.Synchronized, Equals, false)
+	c.Check(ts.Earliest.Equal(synced), Equals, true)
+}
+
+func (s *deviceMgrSuite) TestTimeSanityLastKnownGoodOnlyWhenSynchronized(c *C) {
+	minSane := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	defer devicestate.MockMinSaneTime(minSane)()
+	now := minSane.Add(72 * time.Hour)
+	defer devicestate.MockTimeNow(func() time.Time { return now })()
+	synchronized := false
+	defer devicestate.MockTimeSynchronized(func() bool { return synchronized })()
+
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+
+	ts := s.timeSanity(c)
+	c.Check(ts.ClockBehind, Equals, false)
+	c.Check(ts.LastKnownGood.IsZero(), Equals, true)
+
+	synchronized = true
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+	ts = s.timeSanity(c)
+	c.Check(ts.LastKnownGood.Equal(now), Equals, true)
+
+	// not recorded again until a day has passed
+	first := now
+	now = now.Add(time.Hour)
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+	ts = s.timeSanity(c)
+	c.Check(ts.LastKnownGood.Equal(first), Equals, true)
+	c.Check(ts.Earliest.Equal(first), Equals, true)
+
+	now = now.Add(24 * time.Hour)
+	c.Assert(devicestate.EnsureTimeSanity(s.mgr), IsNil)
+	ts = s.timeSanity(c)
+	c.Check(ts.LastKnownGood.Equal(now), Equals, true)
+}
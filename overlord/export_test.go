@@ -25,6 +25,7 @@ import (
 	"github.com/snapcore/snapd/overlord/configstate"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
 	"github.com/snapcore/snapd/store"
 )
@@ -80,3 +81,28 @@ func MockConfigstateInit(new func(hookmgr *hookstate.HookManager)) (restore func
 		configstateInit = configstate.Init
 	}
 }
+
+type LANPeersService = lanPeersService
+
+// NewLANPeersManager returns the manager of the sharing of downloaded
+// snaps with the local network, with the given service.
+func NewLANPeersManager(st *state.State, service LANPeersService) StateManager {
+	return &lanPeersManager{state: st, service: service}
+}
+
+type LocalRepository = localRepository
+
+// NewLocalRepoManager returns the manager of the local repository
+// configuration, with the given repository.
+func NewLocalRepoManager(st *state.State, repo LocalRepository) StateManager {
+	return &localRepoManager{state: st, repo: repo}
+}
+
+// MockOptionalManagers clears the registered optional managers for tests.
+func MockOptionalManagers() (restore func()) {
+	old := optionalManagers
+	optionalManagers = nil
+	return func() {
+		optionalManagers = old
+	}
+}
@@ -142,7 +142,55 @@ func (h *healthHandler) Done() error {
 		}
 	}
 
-	return h.appendHealth(&health)
+	if err := h.appendHealth(&health); err != nil {
+		return err
+	}
+	if health.Status == ErrorStatus {
+		return h.revertUnhealthy(&health)
+	}
+	return nil
+}
+
+// revertUnhealthy fails the health check of a snap that reported being
+// unhealthy after a refresh, so that the refresh is undone, reverting
+// to the previous revision.
+func (h *healthHandler) revertUnhealthy(health *HealthState) error {
+	st := h.context.State()
+	st.Lock()
+	defer st.Unlock()
+
+	oldRev := refreshedFrom(h.context)
+	if oldRev.Unset() {
+		// not a refresh, there is nothing to revert to
+		return nil
+	}
+
+	snapName := h.context.InstanceName()
+	st.Warnf("snap %q reported being unhealthy after refresh to revision %s, reverting to revision %s: %s", snapName, health.Revision, oldRev, health.Message)
+	return fmt.Errorf("snap %q reported being unhealthy: %s", snapName, health.Message)
+}
+
+// refreshedFrom returns the revision the snap of the hook was refreshed
+// from by the change the hook is part of, if any.
+func refreshedFrom(ctx *hookstate.Context) snap.Revision {
+	task, ok := ctx.Task()
+	if !ok || task.Change() == nil {
+		return snap.Revision{}
+	}
+	for _, t := range task.Change().Tasks() {
+		if t.Kind() != "link-snap" {
+			continue
+		}
+		snapsup, err := snapstate.TaskSnapSetup(t)
+		if err != nil || snapsup.InstanceName() != ctx.InstanceName() {
+			continue
+		}
+		var oldCurrent snap.Revision
+		if err := t.Get("old-current", &oldCurrent); err == nil {
+			return oldCurrent
+		}
+	}
+	return snap.Revision{}
 }
 
 func (h *healthHandler) Error(err error) error {
@@ -23,10 +23,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord"
@@ -253,3 +255,72 @@ func (s *healthSuite) TestSetFromHookContextEmpty(c *check.C) {
 	// no health in the context -> no health in state
 	c.Check(s.state.Get("health", &hs), check.Equals, state.ErrNoState)
 }
+
+func (s *healthSuite) testUnhealthy(c *check.C, oldCurrent snap.Revision) (*state.Task, []*state.Warning) {
+	hookFn := filepath.Join(s.info.MountDir(), "meta", "hooks", "check-health")
+	c.Assert(os.MkdirAll(filepath.Dir(hookFn), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(hookFn, nil, 0755), check.IsNil)
+
+	restore := hookstate.MockRunHook(func(ctx *hookstate.Context, _ *tomb.Tomb) ([]byte, error) {
+		ctx.Lock()
+		defer ctx.Unlock()
+		ctx.Set("health", &healthstate.HealthState{
+			Revision:  snap.R(42),
+			Timestamp: time.Now(),
+			Status:    healthstate.ErrorStatus,
+			Message:   "database is gone",
+		})
+		return nil, nil
+	})
+	defer restore()
+
+	s.state.Lock()
+	change := s.state.NewChange("refresh-snap", "...")
+	// the link-snap of the refresh, done already
+	link := s.state.NewTask("link-snap", "...")
+	link.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{RealName: "test-snap", Revision: snap.R(42)},
+	})
+	link.Set("old-current", oldCurrent)
+	link.SetStatus(state.DoneStatus)
+	change.AddTask(link)
+	task := healthstate.Hook(s.state, "test-snap", snap.R(42))
+	change.AddTask(task)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	health, err := healthstate.Get(s.state, "test-snap")
+	c.Assert(err, check.IsNil)
+	c.Check(health.Status, check.Equals, healthstate.ErrorStatus)
+	c.Check(health.Message, check.Equals, "database is gone")
+
+	return task, s.state.AllWarnings()
+}
+
+func (s *healthSuite) TestUnhealthyAfterRefreshReverts(c *check.C) {
+	task, warnings := s.testUnhealthy(c, snap.R(41))
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(task.Status(), check.Equals, state.ErrorStatus)
+	c.Check(strings.Join(task.Log(), "\n"), testutil.Contains, `snap "test-snap" reported being unhealthy: database is gone`)
+	// the refresh is undone
+	c.Check(task.Change().Tasks()[0].Status(), check.Equals, state.UndoStatus)
+
+	c.Assert(warnings, check.HasLen, 1)
+	c.Check(warnings[0].String(), check.Equals, `snap "test-snap" reported being unhealthy after refresh to revision 42, reverting to revision 41: database is gone`)
+}
+
+func (s *healthSuite) TestUnhealthyAfterInstall(c *check.C) {
+	task, warnings := s.testUnhealthy(c, snap.Revision{})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	// nothing to revert to
+	c.Check(task.Status(), check.Equals, state.DoneStatus)
+	c.Check(warnings, check.HasLen, 0)
+}
@@ -43,6 +43,7 @@ type Context struct {
 	setup   *HookSetup
 	id      string
 	handler Handler
+	limits  snap.HookLimits
 
 	cache  map[interface{}]interface{}
 	onDone []func() error
@@ -93,7 +94,15 @@ func (c *Context) HookName() string {
 
 // Timeout returns the maximum time this hook can run
 func (c *Context) Timeout() time.Duration {
-	return c.setup.Timeout
+	if c.setup.Timeout != 0 {
+		return c.setup.Timeout
+	}
+	return c.limits.Timeout
+}
+
+// Limits returns the limits on the resources this hook can use.
+func (c *Context) Limits() snap.HookLimits {
+	return c.limits
 }
 
 // ID returns the ID of the context.
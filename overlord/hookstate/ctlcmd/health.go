@@ -56,7 +56,9 @@ status can be one of:
   configured); the message must be sufficient to point the user in the right
   direction.
 
-- error: something is broken; the message must explain what.
+- error: something is broken; the message must explain what. When reported
+  by the 'check-health' hook run after a refresh, the snap is reverted to its
+  previous revision.
 `)
 )
 
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+var (
+	shortRefreshHelp = i18n.G("Hold or proceed with the refresh of the snap")
+	longRefreshHelp  = i18n.G(`
+The refresh command tells, from the pre-refresh-download hook, whether the
+pending auto-refresh of the snap should be held or proceed. The refresh
+proceeds unless the hook asks for it to be held, and is only held for as
+long as the system allows.`)
+)
+
+func init() {
+	addCommand("refresh", shortRefreshHelp, longRefreshHelp, func() command { return &refreshCommand{} })
+}
+
+type refreshCommand struct {
+	baseCommand
+	Hold    bool `long:"hold" description:"Hold the pending refresh of the snap"`
+	Proceed bool `long:"proceed" description:"Let the pending refresh of the snap proceed"`
+}
+
+func (c *refreshCommand) Execute(args []string) error {
+	ctx := c.context()
+	if ctx == nil {
+		return fmt.Errorf(i18n.G("cannot %s without a context"), "refresh")
+	}
+	if ctx.IsEphemeral() || ctx.HookName() != "pre-refresh-download" {
+		return fmt.Errorf(i18n.G("can only hold or proceed with a refresh from the pre-refresh-download hook"))
+	}
+	if c.Hold == c.Proceed {
+		return fmt.Errorf(i18n.G("either --hold or --proceed must be given"))
+	}
+
+	ctx.Lock()
+	defer ctx.Unlock()
+	ctx.Set("refresh-hold", c.Hold)
+	return nil
+}
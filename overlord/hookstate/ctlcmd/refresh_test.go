@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ctlcmd_test
+
+import (
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
+	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type refreshSuite struct {
+	state       *state.State
+	mockHandler *hooktest.MockHandler
+}
+
+var _ = check.Suite(&refreshSuite{})
+
+func (s *refreshSuite) SetUpTest(c *check.C) {
+	s.mockHandler = hooktest.NewMockHandler()
+	s.state = state.New(nil)
+}
+
+func (s *refreshSuite) mockContext(c *check.C, hook string) *hookstate.Context {
+	s.state.Lock()
+	defer s.state.Unlock()
+	task := s.state.NewTask("test-task", "my test task")
+	setup := &hookstate.HookSetup{Snap: "test-snap", Revision: snap.R(1), Hook: hook}
+
+	ctx, err := hookstate.NewContext(task, s.state, setup, s.mockHandler, "")
+	c.Assert(err, check.IsNil)
+	return ctx
+}
+
+func (s *refreshSuite) TestBadArgs(c *check.C) {
+	ctx := s.mockContext(c, "pre-refresh-download")
+	for _, args := range [][]string{
+		{"refresh"},
+		{"refresh", "--hold", "--proceed"},
+	} {
+		_, _, err := ctlcmd.Run(ctx, args, 0)
+		c.Check(err, check.ErrorMatches, "either --hold or --proceed must be given")
+	}
+
+	_, _, err := ctlcmd.Run(nil, []string{"refresh", "--hold"}, 0)
+	c.Check(err, check.ErrorMatches, "cannot refresh without a context")
+}
+
+func (s *refreshSuite) TestOnlyFromPreRefreshDownloadHook(c *check.C) {
+	ctx := s.mockContext(c, "configure")
+	_, _, err := ctlcmd.Run(ctx, []string{"refresh", "--hold"}, 0)
+	c.Check(err, check.ErrorMatches, "can only hold or proceed with a refresh from the pre-refresh-download hook")
+
+	s.state.Lock()
+	ctx, err = hookstate.NewContext(nil, s.state, &hookstate.HookSetup{Snap: "test-snap"}, nil, "")
+	s.state.Unlock()
+	c.Assert(err, check.IsNil)
+	_, _, err = ctlcmd.Run(ctx, []string{"refresh", "--hold"}, 0)
+	c.Check(err, check.ErrorMatches, "can only hold or proceed with a refresh from the pre-refresh-download hook")
+}
+
+func (s *refreshSuite) TestHoldAndProceed(c *check.C) {
+	ctx := s.mockContext(c, "pre-refresh-download")
+
+	for _, t := range []struct {
+		flag string
+		hold bool
+	}{
+		{"--hold", true},
+		{"--proceed", false},
+	} {
+		_, _, err := ctlcmd.Run(ctx, []string{"refresh", t.flag}, 0)
+		c.Assert(err, check.IsNil)
+
+		ctx.Lock()
+		var hold bool
+		c.Check(ctx.Get("refresh-hold", &hold), check.IsNil)
+		ctx.Unlock()
+		c.Check(hold, check.Equals, t.hold)
+	}
+}
@@ -22,11 +22,13 @@ package hookstate
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gopkg.in/tomb.v2"
@@ -39,6 +41,7 @@ import (
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 type hijackFunc func(ctx *Context) error
@@ -132,6 +135,7 @@ func Manager(s *state.State, runner *state.TaskRunner) (*HookManager, error) {
 	setupHooks(manager)
 
 	snapstate.AddAffectedSnapsByAttr("hook-setup", manager.hookAffectedSnaps)
+	snapstate.AddTaskResources("run-hook", manager.hookResources)
 
 	return manager, nil
 }
@@ -180,6 +184,20 @@ func (m *HookManager) hookAffectedSnaps(t *state.Task) ([]string, error) {
 	return []string{hooksup.Snap}, nil
 }
 
+// hookResources returns shared locks on the snaps affected by the hook,
+// so that it does not run while other changes are replacing them.
+func (m *HookManager) hookResources(t *state.Task) ([]snapstate.ResourceLock, error) {
+	snaps, err := m.hookAffectedSnaps(t)
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]snapstate.ResourceLock, 0, len(snaps))
+	for _, snapName := range snaps {
+		locks = append(locks, snapstate.ResourceLock{Resource: snapstate.SnapResource(snapName)})
+	}
+	return locks, nil
+}
+
 func (m *HookManager) ephemeralContext(cookieID string) (context *Context, err error) {
 	var contexts map[string]string
 	m.state.Lock()
@@ -285,6 +303,7 @@ func (m *HookManager) undoRunHook(task *state.Task, tomb *tomb.Tomb) error {
 func (m *HookManager) runHook(task *state.Task, tomb *tomb.Tomb, snapst *snapstate.SnapState, hooksup *HookSetup) error {
 	mustHijack := m.hijacked(hooksup.Hook, hooksup.Snap) != nil
 	hookExists := false
+	var hookLimits *snap.HookLimits
 	if !mustHijack {
 		// not hijacked, snap must be installed
 		if !snapst.IsInstalled() {
@@ -296,10 +315,14 @@ func (m *HookManager) runHook(task *state.Task, tomb *tomb.Tomb, snapst *snapsta
 			return fmt.Errorf("cannot read %q snap details: %v", hooksup.Snap, err)
 		}
 
-		hookExists = info.Hooks[hooksup.Hook] != nil
+		hookInfo := info.Hooks[hooksup.Hook]
+		hookExists = hookInfo != nil
 		if !hookExists && !hooksup.Optional {
 			return fmt.Errorf("snap %q has no %q hook", hooksup.Snap, hooksup.Hook)
 		}
+		if hookExists {
+			hookLimits = hookInfo.Limits
+		}
 	}
 
 	if hookExists || mustHijack {
@@ -321,6 +344,9 @@ func (m *HookManager) runHook(task *state.Task, tomb *tomb.Tomb, snapst *snapsta
 	if err != nil {
 		return err
 	}
+	task.State().Lock()
+	context.limits = settings.DefaultHookLimits(task.State()).Restrict(hookLimits)
+	task.State().Unlock()
 
 	// Obtain a handler for this hook. The repository returns a list since it's
 	// possible for regular expressions to overlap, but multiple handlers is an
@@ -364,6 +390,11 @@ func (m *HookManager) runHook(task *state.Task, tomb *tomb.Tomb, snapst *snapsta
 		output, err = runHook(context, tomb)
 	}
 	if err != nil {
+		if violation := hookLimitViolation(context.Limits(), err); violation != "" {
+			task.State().Lock()
+			task.Errorf("hook %q %s", hooksup.Hook, violation)
+			task.State().Unlock()
+		}
 		if hooksup.TrackError {
 			trackHookError(context, output, err)
 		}
@@ -395,7 +426,7 @@ func (m *HookManager) runHook(task *state.Task, tomb *tomb.Tomb, snapst *snapsta
 }
 
 func runHookImpl(c *Context, tomb *tomb.Tomb) ([]byte, error) {
-	return runHookAndWait(c.InstanceName(), c.SnapRevision(), c.HookName(), c.ID(), c.Timeout(), tomb)
+	return runHookAndWait(c.InstanceName(), c.SnapRevision(), c.HookName(), c.ID(), c.Timeout(), c.Limits(), tomb)
 }
 
 var runHook = runHookImpl
@@ -434,8 +465,42 @@ func snapCmd() string {
 
 var defaultHookTimeout = 10 * time.Minute
 
-func runHookAndWait(snapName string, revision snap.Revision, hookName, hookContext string, timeout time.Duration, tomb *tomb.Tomb) ([]byte, error) {
+// hookScopeCmd returns the command that runs the hook in a transient
+// systemd scope, for systemd to enforce its cpu and memory limits.
+func hookScopeCmd(snapName, hookName string, limits snap.HookLimits) []string {
+	unit := fmt.Sprintf("snap.%s.hook.%s-%s.scope", snapName, hookName, strutil.MakeRandomString(8))
+	cmd := []string{"systemd-run", "--quiet", "--scope", "--unit=" + unit}
+	if limits.CPUQuota != 0 {
+		cmd = append(cmd, fmt.Sprintf("--property=CPUQuota=%d%%", limits.CPUQuota))
+	}
+	if limits.Memory != 0 {
+		cmd = append(cmd, fmt.Sprintf("--property=MemoryMax=%d", limits.Memory))
+	}
+	return append(cmd, "--")
+}
+
+// hookLimitViolation returns which of its limits the hook that failed
+// with the given error most likely violated, if any. Hooks running out
+// of time are already reported as such by the error.
+func hookLimitViolation(limits snap.HookLimits, err error) string {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || limits.Memory == 0 {
+		return ""
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		return ""
+	}
+	// the kernel kills processes going over the memory limit of
+	// their cgroup
+	return fmt.Sprintf("was killed, most likely for exceeding its memory limit of %s", strutil.SizeToStr(limits.Memory))
+}
+
+func runHookAndWait(snapName string, revision snap.Revision, hookName, hookContext string, timeout time.Duration, limits snap.HookLimits, tomb *tomb.Tomb) ([]byte, error) {
 	argv := []string{snapCmd(), "run", "--hook", hookName, "-r", revision.String(), snapName}
+	if limits.CPUQuota != 0 || limits.Memory != 0 {
+		argv = append(hookScopeCmd(snapName, hookName, limits), argv...)
+	}
 	if timeout == 0 {
 		timeout = defaultHookTimeout
 	}
@@ -20,6 +20,7 @@ package hookstate
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -29,6 +30,7 @@ import (
 func init() {
 	snapstate.SetupInstallHook = SetupInstallHook
 	snapstate.SetupPreRefreshHook = SetupPreRefreshHook
+	snapstate.SetupPreRefreshDownloadHook = SetupPreRefreshDownloadHook
 	snapstate.SetupPostRefreshHook = SetupPostRefreshHook
 	snapstate.SetupRemoveHook = SetupRemoveHook
 }
@@ -70,6 +72,25 @@ func SetupPreRefreshHook(st *state.State, snapName string) *state.Task {
 	return task
 }
 
+// SetupPreRefreshDownloadHook returns a task running the
+// pre-refresh-download hook of the snap, if it has one, before an
+// auto-refresh of it is downloaded. The hook holds the refresh with
+// "snapctl refresh --hold", unless it cannot hold it any longer. A failing
+// hook does not hold the refresh.
+func SetupPreRefreshDownloadHook(st *state.State, snapName string, canHold bool) *state.Task {
+	hooksup := &HookSetup{
+		Snap:        snapName,
+		Hook:        "pre-refresh-download",
+		Optional:    true,
+		IgnoreError: true,
+	}
+
+	summary := fmt.Sprintf(i18n.G("Run pre-refresh-download hook of %q snap if present"), hooksup.Snap)
+	task := HookTask(st, summary, hooksup, map[string]interface{}{"can-hold": canHold})
+
+	return task
+}
+
 type snapHookHandler struct {
 }
 
@@ -85,6 +106,88 @@ func (h *snapHookHandler) Error(err error) error {
 	return nil
 }
 
+// preRefreshDownloadHandler holds the auto-refresh of a snap when its
+// pre-refresh-download hook asks for it, keeping track of when the hook
+// started holding its auto-refreshes.
+type preRefreshDownloadHandler struct {
+	context *Context
+}
+
+func (h *preRefreshDownloadHandler) Before() error {
+	return nil
+}
+
+func (h *preRefreshDownloadHandler) Done() error {
+	h.context.Lock()
+	defer h.context.Unlock()
+
+	var hold, canHold bool
+	if err := h.context.Get("refresh-hold", &hold); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if err := h.context.Get("can-hold", &canHold); err != nil && err != state.ErrNoState {
+		return err
+	}
+	task, _ := h.context.Task()
+	if hold && !canHold {
+		task.Logf("snap %q cannot hold its refresh any longer", h.context.InstanceName())
+		hold = false
+	}
+	if err := h.setHeld(hold); err != nil {
+		return err
+	}
+	if hold {
+		task.Logf("refresh held by snap %q", h.context.InstanceName())
+		holdHaltTasks(task)
+	}
+	return nil
+}
+
+func (h *preRefreshDownloadHandler) Error(err error) error {
+	return nil
+}
+
+// setHeld records when the snap started holding its refreshes, or
+// clears it once the snap no longer holds them. It must be called with
+// the state locked.
+func (h *preRefreshDownloadHandler) setHeld(held bool) error {
+	st := h.context.State()
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, h.context.InstanceName(), &snapst); err != nil {
+		return err
+	}
+	switch {
+	case held && snapst.RefreshHeldTime == nil:
+		now := time.Now()
+		snapst.RefreshHeldTime = &now
+	case !held && snapst.RefreshHeldTime != nil:
+		snapst.RefreshHeldTime = nil
+	default:
+		return nil
+	}
+	snapstate.Set(st, h.context.InstanceName(), &snapst)
+	return nil
+}
+
+// holdHaltTasks puts the tasks that wait, directly or not, for the given
+// task on hold, so that the rest of the refresh does not run.
+func holdHaltTasks(t *state.Task) {
+	seen := make(map[string]bool)
+	pending := t.HaltTasks()
+	for len(pending) > 0 {
+		ht := pending[0]
+		pending = pending[1:]
+		if seen[ht.ID()] {
+			continue
+		}
+		seen[ht.ID()] = true
+		if ht.Status() == state.DoStatus {
+			ht.SetStatus(state.HoldStatus)
+		}
+		pending = append(pending, ht.HaltTasks()...)
+	}
+}
+
 func SetupRemoveHook(st *state.State, snapName string) *state.Task {
 	hooksup := &HookSetup{
 		Snap:        snapName,
@@ -107,5 +210,8 @@ func setupHooks(hookMgr *HookManager) {
 	hookMgr.Register(regexp.MustCompile("^install$"), handlerGenerator)
 	hookMgr.Register(regexp.MustCompile("^post-refresh$"), handlerGenerator)
 	hookMgr.Register(regexp.MustCompile("^pre-refresh$"), handlerGenerator)
+	hookMgr.Register(regexp.MustCompile("^pre-refresh-download$"), func(context *Context) Handler {
+		return &preRefreshDownloadHandler{context: context}
+	})
 	hookMgr.Register(regexp.MustCompile("^remove$"), handlerGenerator)
 }
@@ -490,6 +490,110 @@ func (s *hookManagerSuite) TestHookTaskEnforcesDefaultTimeout(c *C) {
 	checkTaskLogContains(c, s.task, `.*exceeded maximum runtime of 150ms`)
 }
 
+var snapYamlWithLimits = `
+name: test-snap
+version: 1.0
+hooks:
+    configure:
+        limits:
+            cpu: 50%
+            memory: 1MB
+`
+
+func (s *hookManagerSuite) TestHookTaskRunsWithLimits(c *C) {
+	sideInfo := &snap.SideInfo{RealName: "test-snap", SnapID: "some-snap-id", Revision: snap.R(1)}
+	snaptest.MockSnapInstance(c, "test-snap", snapYamlWithLimits, sideInfo)
+
+	cmd := testutil.MockCommand(c, "systemd-run", "")
+	defer cmd.Restore()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(s.task.Status(), Equals, state.DoneStatus)
+	c.Check(s.command.Calls(), HasLen, 0)
+	calls := cmd.Calls()
+	c.Assert(calls, HasLen, 1)
+	c.Check(calls[0][3], Matches, `--unit=snap\.test-snap\.hook\.configure-[a-zA-Z0-9]{8}\.scope`)
+	calls[0][3] = "--unit=..."
+	c.Check(calls[0], DeepEquals, []string{
+		"systemd-run", "--quiet", "--scope", "--unit=...",
+		"--property=CPUQuota=50%", "--property=MemoryMax=1000000", "--",
+		"snap", "run", "--hook", "configure", "-r", "1", "test-snap",
+	})
+}
+
+func (s *hookManagerSuite) TestHookTaskLimitsCappedBySystem(c *C) {
+	sideInfo := &snap.SideInfo{RealName: "test-snap", SnapID: "some-snap-id", Revision: snap.R(1)}
+	snaptest.MockSnapInstance(c, "test-snap", snapYamlWithLimits, sideInfo)
+
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "hooks.limits.cpu", "20%")
+	tr.Set("core", "hooks.limits.memory", "2MB")
+	tr.Commit()
+	s.state.Unlock()
+
+	cmd := testutil.MockCommand(c, "systemd-run", "")
+	defer cmd.Restore()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(s.task.Status(), Equals, state.DoneStatus)
+	calls := cmd.Calls()
+	c.Assert(calls, HasLen, 1)
+	// the snap cannot raise the cpu limit but can lower the memory one
+	c.Check(calls[0][4:6], DeepEquals, []string{"--property=CPUQuota=20%", "--property=MemoryMax=1000000"})
+}
+
+func (s *hookManagerSuite) TestHookTaskReportsMemoryLimitViolation(c *C) {
+	sideInfo := &snap.SideInfo{RealName: "test-snap", SnapID: "some-snap-id", Revision: snap.R(1)}
+	snaptest.MockSnapInstance(c, "test-snap", snapYamlWithLimits, sideInfo)
+
+	// as the kernel does when the cgroup runs out of memory
+	cmd := testutil.MockCommand(c, "systemd-run", "kill -9 $$")
+	defer cmd.Restore()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(s.mockHandler.ErrorCalled, Equals, true)
+	c.Check(s.task.Status(), Equals, state.ErrorStatus)
+	checkTaskLogContains(c, s.task, `.*hook "configure" was killed, most likely for exceeding its memory limit of 1MB`)
+}
+
+func (s *hookManagerSuite) TestHookTaskEnforcesDefaultLimits(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "hooks.limits.timeout", "150ms")
+	tr.Commit()
+	s.state.Unlock()
+
+	// Force the snap command to hang
+	cmd := testutil.MockCommand(c, "snap", "while true; do sleep 1; done")
+	defer cmd.Restore()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(s.mockHandler.ErrorCalled, Equals, true)
+	c.Check(s.mockHandler.Err, ErrorMatches, `.*exceeded maximum runtime of 150ms.*`)
+	c.Check(s.task.Status(), Equals, state.ErrorStatus)
+}
+
 func (s *hookManagerSuite) TestHookTaskEnforcedTimeoutWithIgnoreError(c *C) {
 	var hooksup hookstate.HookSetup
 
@@ -521,6 +625,129 @@ func (s *hookManagerSuite) TestHookTaskEnforcedTimeoutWithIgnoreError(c *C) {
 	checkTaskLogContains(c, s.task, `.*ignoring failure in hook.*exceeded maximum runtime of 200ms`)
 }
 
+var snapYamlWithPreRefreshDownload = `
+name: test-snap
+version: 1.0
+hooks:
+    pre-refresh-download:
+`
+
+func (s *hookManagerSuite) runPreRefreshDownloadHook(c *C, canHold bool) (hook, download *state.Task) {
+	sideInfo := &snap.SideInfo{RealName: "test-snap", SnapID: "some-snap-id", Revision: snap.R(1)}
+	snaptest.MockSnapInstance(c, "test-snap", snapYamlWithPreRefreshDownload, sideInfo)
+
+	s.state.Lock()
+	// only run the hook below
+	s.task.SetStatus(state.DoneStatus)
+	hook = hookstate.SetupPreRefreshDownloadHook(s.state, "test-snap", canHold)
+	// the rest of the refresh, left out of the change not to run it
+	download = s.state.NewTask("download-snap", "...")
+	download.WaitFor(hook)
+	chg := s.state.NewChange("auto-refresh", "...")
+	chg.AddTask(hook)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	return hook, download
+}
+
+func (s *hookManagerSuite) mockPreRefreshDownloadHold(hold bool) {
+	s.manager.RegisterHijack("pre-refresh-download", "test-snap", func(ctx *hookstate.Context) error {
+		ctx.Lock()
+		defer ctx.Unlock()
+		ctx.Set("refresh-hold", hold)
+		return nil
+	})
+}
+
+func (s *hookManagerSuite) TestPreRefreshDownloadHookHolds(c *C) {
+	s.mockPreRefreshDownloadHold(true)
+
+	hook, download := s.runPreRefreshDownloadHook(c, true)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the hook does not fail, the rest of the refresh is held
+	c.Check(hook.Status(), Equals, state.DoneStatus)
+	c.Check(download.Status(), Equals, state.HoldStatus)
+	checkTaskLogContains(c, hook, `.*refresh held by snap "test-snap"`)
+
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	c.Assert(snapst.RefreshHeldTime, NotNil)
+	c.Check(time.Since(*snapst.RefreshHeldTime) < time.Minute, Equals, true)
+}
+
+func (s *hookManagerSuite) TestPreRefreshDownloadHookFailureDoesNotHold(c *C) {
+	cmd := testutil.MockCommand(c, "snap", ">&2 echo 'busy'; exit 1")
+	defer cmd.Restore()
+
+	hook, download := s.runPreRefreshDownloadHook(c, true)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(hook.Status(), Equals, state.DoneStatus)
+	c.Check(download.Status(), Equals, state.DoStatus)
+	checkTaskLogContains(c, hook, `.*ignoring failure in hook "pre-refresh-download".*`)
+
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	c.Check(snapst.RefreshHeldTime, IsNil)
+}
+
+func (s *hookManagerSuite) TestPreRefreshDownloadHookHeldTooLong(c *C) {
+	heldTime := time.Now().Add(-72 * time.Hour)
+	s.state.Lock()
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	snapst.RefreshHeldTime = &heldTime
+	snapstate.Set(s.state, "test-snap", &snapst)
+	s.state.Unlock()
+
+	s.mockPreRefreshDownloadHold(true)
+
+	hook, download := s.runPreRefreshDownloadHook(c, false)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(hook.Status(), Equals, state.DoneStatus)
+	c.Check(download.Status(), Equals, state.DoStatus)
+	checkTaskLogContains(c, hook, `.*snap "test-snap" cannot hold its refresh any longer`)
+
+	// the hold is over
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	c.Check(snapst.RefreshHeldTime, IsNil)
+}
+
+func (s *hookManagerSuite) TestPreRefreshDownloadHookProceeds(c *C) {
+	heldTime := time.Now().Add(-time.Hour)
+	s.state.Lock()
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	snapst.RefreshHeldTime = &heldTime
+	snapstate.Set(s.state, "test-snap", &snapst)
+	s.state.Unlock()
+
+	hook, download := s.runPreRefreshDownloadHook(c, true)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(hook.Status(), Equals, state.DoneStatus)
+	c.Check(download.Status(), Equals, state.DoStatus)
+	c.Check(s.command.Calls(), DeepEquals, [][]string{{
+		"snap", "run", "--hook", "pre-refresh-download", "-r", "unset", "test-snap",
+	}})
+
+	c.Assert(snapstate.Get(s.state, "test-snap", &snapst), IsNil)
+	c.Check(snapst.RefreshHeldTime, IsNil)
+}
+
 func (s *hookManagerSuite) TestHookTaskCanKillHook(c *C) {
 	// Force the snap command to hang
 	cmd := testutil.MockCommand(c, "snap", "while true; do sleep 1; done")
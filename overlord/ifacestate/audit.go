@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// maxAuditLogSize is the size beyond which the audit log is rotated,
+// only one rotated log is kept.
+var maxAuditLogSize int64 = 8 * 1024 * 1024
+
+// AuditInitiator identifies who asked for a connection or a
+// disconnection.
+type AuditInitiator struct {
+	// UID is the uid of the process that made the request.
+	UID uint32 `json:"uid"`
+	// Username is the snapd user making the request, if logged in.
+	Username string `json:"username,omitempty"`
+}
+
+// SetChangeInitiator records who asked for the change, so that the
+// connections and disconnections it does are attributed to them in the
+// interfaces audit log.
+func SetChangeInitiator(chg *state.Change, initiator *AuditInitiator) {
+	chg.Set("initiator", initiator)
+}
+
+// AuditEntry records one connection or disconnection of an interface.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Action is either "connect" or "disconnect".
+	Action    string             `json:"action"`
+	Interface string             `json:"interface"`
+	Plug      interfaces.PlugRef `json:"plug"`
+	Slot      interfaces.SlotRef `json:"slot"`
+	// Auto is set for connections done automatically by snapd and for
+	// the disconnections done when a snap is removed.
+	Auto     bool `json:"auto,omitempty"`
+	ByGadget bool `json:"by-gadget,omitempty"`
+	// Undo is set when the entry reverts an earlier one because the
+	// change it was part of failed.
+	Undo      bool                   `json:"undo,omitempty"`
+	PlugAttrs map[string]interface{} `json:"plug-attrs,omitempty"`
+	SlotAttrs map[string]interface{} `json:"slot-attrs,omitempty"`
+	// Change is the id of the change that did it.
+	Change string `json:"change,omitempty"`
+	// Initiator is who asked for the change, if known.
+	Initiator *AuditInitiator `json:"initiator,omitempty"`
+}
+
+// AuditFilter selects audit log entries.
+type AuditFilter struct {
+	// Snap selects the entries whose plug or slot belongs to the snap.
+	Snap string
+	// Interface selects the entries for the interface.
+	Interface string
+	// Since selects the entries recorded at or after the time.
+	Since time.Time
+}
+
+func (f *AuditFilter) matches(e *AuditEntry) bool {
+	if f == nil {
+		return true
+	}
+	if f.Snap != "" && e.Plug.Snap != f.Snap && e.Slot.Snap != f.Snap {
+		return false
+	}
+	if f.Interface != "" && e.Interface != f.Interface {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+func mergedAttrs(static, dynamic map[string]interface{}) map[string]interface{} {
+	if len(static) == 0 && len(dynamic) == 0 {
+		return nil
+	}
+	attrs := make(map[string]interface{}, len(static)+len(dynamic))
+	for k, v := range static {
+		attrs[k] = v
+	}
+	for k, v := range dynamic {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// auditConnection records the connection or disconnection done by the
+// task in the audit log. Failing to do so is logged but does not fail
+// the task.
+func auditConnection(task *state.Task, action string, connRef *interfaces.ConnRef, conn *connState, undo bool) {
+	entry := &AuditEntry{
+		Time:      timeNow(),
+		Action:    action,
+		Interface: conn.Interface,
+		Plug:      connRef.PlugRef,
+		Slot:      connRef.SlotRef,
+		Auto:      conn.Auto,
+		ByGadget:  conn.ByGadget,
+		Undo:      undo,
+		PlugAttrs: mergedAttrs(conn.StaticPlugAttrs, conn.DynamicPlugAttrs),
+		SlotAttrs: mergedAttrs(conn.StaticSlotAttrs, conn.DynamicSlotAttrs),
+	}
+	if action == "disconnect" && !undo {
+		// the auto flag of the connection tells how it was made,
+		// what matters here is why it is going away
+		var autoDisconnect bool
+		task.Get("auto-disconnect", &autoDisconnect)
+		entry.Auto = autoDisconnect
+	}
+	if chg := task.Change(); chg != nil {
+		entry.Change = chg.ID()
+		var initiator AuditInitiator
+		if err := chg.Get("initiator", &initiator); err == nil {
+			entry.Initiator = &initiator
+		}
+	}
+	if err := appendAuditEntry(entry); err != nil {
+		logger.Noticef("cannot record %s of %s to the interfaces audit log: %v", action, connRef, err)
+	}
+}
+
+func appendAuditEntry(entry *AuditEntry) error {
+	if err := os.MkdirAll(filepath.Dir(dirs.SnapInterfacesAuditFile), 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dirs.SnapInterfacesAuditFile); err == nil && fi.Size() >= maxAuditLogSize {
+		if err := os.Rename(dirs.SnapInterfacesAuditFile, dirs.SnapInterfacesAuditFile+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(dirs.SnapInterfacesAuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entry)
+}
+
+// AuditLog returns the entries of the interfaces audit log that match
+// the filter, oldest first.
+func AuditLog(filter *AuditFilter) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	for _, fn := range []string{dirs.SnapInterfacesAuditFile + ".1", dirs.SnapInterfacesAuditFile} {
+		f, err := os.Open(fn)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// skip entries cut short, e.g. by a crash
+				continue
+			}
+			if filter.matches(&entry) {
+				entries = append(entries, &entry)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
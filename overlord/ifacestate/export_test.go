@@ -83,7 +83,7 @@ func MockHotplugRetryTimeout(d time.Duration) (restore func()) {
 	return func() { hotplugRetryTimeout = old }
 }
 
-func MockCreateUDevMonitor(new func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface) (restore func()) {
+func MockCreateUDevMonitor(new func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.DeviceChangedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface) (restore func()) {
 	old := createUDevMonitor
 	createUDevMonitor = new
 	return func() {
@@ -153,6 +153,28 @@ func MockWriteSystemKey(fn func() error) func() {
 	return func() { writeSystemKey = old }
 }
 
+// MockProfileRegenerationWorkers mocks the number of snaps for which
+// profiles are regenerated in parallel.
+func MockProfileRegenerationWorkers(n int) (restore func()) {
+	old := profileRegenerationWorkers
+	profileRegenerationWorkers = n
+	return func() { profileRegenerationWorkers = old }
+}
+
 func (m *InterfaceManager) TransitionConnectionsCoreMigration(st *state.State, oldName, newName string) error {
 	return m.transitionConnectionsCoreMigration(st, oldName, newName)
 }
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() { timeNow = old }
+}
+
+var AppendAuditEntry = appendAuditEntry
+
+func MockMaxAuditLogSize(size int64) (restore func()) {
+	old := maxAuditLogSize
+	maxAuditLogSize = size
+	return func() { maxAuditLogSize = old }
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -401,6 +402,10 @@ func (m *InterfaceManager) doConnect(task *state.Task, _ *tomb.Tomb) error {
 	if err := task.Get("by-gadget", &byGadget); err != nil && err != state.ErrNoState {
 		return err
 	}
+	var expiry time.Time
+	if err := task.Get("expiry", &expiry); err != nil && err != state.ErrNoState {
+		return err
+	}
 
 	deviceCtx, err := snapstate.DeviceCtx(st, task, nil)
 	if err != nil {
@@ -485,7 +490,7 @@ func (m *InterfaceManager) doConnect(task *state.Task, _ *tomb.Tomb) error {
 		return err
 	}
 
-	conns[connRef.ID()] = &connState{
+	cstate := &connState{
 		Interface:        conn.Interface(),
 		StaticPlugAttrs:  conn.Plug.StaticAttrs(),
 		DynamicPlugAttrs: conn.Plug.DynamicAttrs(),
@@ -495,7 +500,15 @@ func (m *InterfaceManager) doConnect(task *state.Task, _ *tomb.Tomb) error {
 		ByGadget:         byGadget,
 		HotplugKey:       slot.HotplugKey,
 	}
+	if !expiry.IsZero() {
+		cstate.Expiry = &expiry
+		// make sure the manager gets to disconnect it in time
+		st.EnsureBefore(expiry.Sub(timeNow()))
+	}
+	conns[connRef.ID()] = cstate
 	setConns(st, conns)
+	notifyConnection(st, connRef, conn.Interface(), true)
+	auditConnection(task, "connect", connRef, cstate, false)
 
 	// the dynamic attributes might have been updated by the interface's BeforeConnectPlug/Slot code,
 	// so we need to update the task for connect-plug- and connect-slot- hooks to see new values.
@@ -566,6 +579,8 @@ func (m *InterfaceManager) doDisconnect(task *state.Task, _ *tomb.Tomb) error {
 		return fmt.Errorf("internal error: failed to read 'auto-disconnect' flag: %s", err)
 	}
 
+	auditConnection(task, "disconnect", &cref, conn, false)
+
 	// "by-hotplug" flag indicates it's a disconnect triggered by hotplug remove event;
 	// we want to keep information of the connection and just mark it as hotplug-gone.
 	var byHotplug bool
@@ -588,6 +603,7 @@ func (m *InterfaceManager) doDisconnect(task *state.Task, _ *tomb.Tomb) error {
 		delete(conns, cref.ID())
 	}
 	setConns(st, conns)
+	notifyConnection(st, &cref, conn.Interface, false)
 
 	return nil
 }
@@ -655,6 +671,8 @@ func (m *InterfaceManager) undoDisconnect(task *state.Task, _ *tomb.Tomb) error
 
 	conns[connRef.ID()] = &oldconn
 	setConns(st, conns)
+	notifyConnection(st, connRef, oldconn.Interface, true)
+	auditConnection(task, "connect", connRef, &oldconn, true)
 
 	return nil
 }
@@ -673,8 +691,13 @@ func (m *InterfaceManager) undoConnect(task *state.Task, _ *tomb.Tomb) error {
 	if err != nil {
 		return err
 	}
+	conn := conns[connRef.ID()]
 	delete(conns, connRef.ID())
 	setConns(st, conns)
+	if conn != nil {
+		notifyConnection(st, &connRef, conn.Interface, false)
+		auditConnection(task, "disconnect", &connRef, conn, true)
+	}
 	return nil
 }
 
@@ -896,6 +919,60 @@ func waitChainSearch(startT, searchT *state.Task) bool {
 }
 
 // doAutoConnect creates task(s) to connect the given snap to viable candidates.
+// splitAtFirstHook splits the tasks of a connection into the ones
+// before its first interface hook and the ones from that hook on.
+func splitAtFirstHook(ts *state.TaskSet) (pre, post *state.TaskSet) {
+	pre = state.NewTaskSet()
+	post = state.NewTaskSet()
+	inPost := false
+	for _, t := range ts.Tasks() {
+		if t.Kind() == "run-hook" {
+			inPost = true
+		}
+		if inPost {
+			post.AddTask(t)
+		} else {
+			pre.AddTask(t)
+		}
+	}
+	return pre, post
+}
+
+// deferToFirstBoot adds the given tasks to the seeding change of the
+// auto-connect task so that they run on first boot, once
+// mark-preseeded completes and before the system is marked seeded.
+func deferToFirstBoot(task *state.Task, ts *state.TaskSet) error {
+	chg := task.Change()
+	if chg == nil {
+		return fmt.Errorf("internal error: auto-connect task %s has no change", task.ID())
+	}
+	var markPreseeded, markSeeded *state.Task
+	for _, t := range chg.Tasks() {
+		switch t.Kind() {
+		case "mark-preseeded":
+			markPreseeded = t
+		case "mark-seeded":
+			markSeeded = t
+		}
+	}
+	if markPreseeded == nil {
+		return fmt.Errorf("internal error: cannot find mark-preseeded task in change %s", chg.ID())
+	}
+
+	for _, l := range task.Lanes() {
+		if l != 0 {
+			ts.JoinLane(l)
+		}
+	}
+	ts.WaitFor(task)
+	ts.WaitFor(markPreseeded)
+	if markSeeded != nil {
+		markSeeded.WaitAll(ts)
+	}
+	chg.AddAll(ts)
+	return nil
+}
+
 func (m *InterfaceManager) doAutoConnect(task *state.Task, _ *tomb.Tomb) error {
 	st := task.State()
 	st.Lock()
@@ -1069,14 +1146,27 @@ func (m *InterfaceManager) doAutoConnect(task *state.Task, _ *tomb.Tomb) error {
 	}
 
 	// Create connect tasks and interface hooks
+	preseeding := snapdenv.Preseeding()
+	deferred := state.NewTaskSet()
 	for _, conn := range newconns {
 		ts, err := connect(st, conn.PlugRef.Snap, conn.PlugRef.Name, conn.SlotRef.Snap, conn.SlotRef.Name, connectOpts{AutoConnect: true})
 		if err != nil {
 			return fmt.Errorf("internal error: auto-connect of %q failed: %s", conn, err)
 		}
+		if preseeding {
+			// interface hooks cannot run while preseeding
+			var post *state.TaskSet
+			ts, post = splitAtFirstHook(ts)
+			deferred.AddAll(post)
+		}
 		autots.AddAll(ts)
 	}
 
+	if len(deferred.Tasks()) > 0 {
+		if err := deferToFirstBoot(task, deferred); err != nil {
+			return err
+		}
+	}
 	if len(autots.Tasks()) > 0 {
 		snapstate.InjectTasks(task, autots)
 
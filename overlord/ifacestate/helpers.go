@@ -24,8 +24,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/dirs"
@@ -110,6 +113,50 @@ func profilesNeedRegenerationImpl() bool {
 var profilesNeedRegeneration = profilesNeedRegenerationImpl
 var writeSystemKey = interfaces.WriteSystemKey
 
+// profileRegenerationWorkers is the maximum number of snaps for which a
+// security backend regenerates profiles at the same time.
+var profileRegenerationWorkers = runtime.NumCPU()
+
+// runConcurrently calls f with each of the given indices using at most
+// the given number of goroutines, and waits for all the calls to finish.
+func runConcurrently(indices []int, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(indices) {
+		workers = len(indices)
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				f(i)
+			}
+		}()
+	}
+	for _, i := range indices {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// syncMeasurer is a timings.Measurer that can be used from multiple
+// goroutines at the same time.
+type syncMeasurer struct {
+	mu   sync.Mutex
+	meas timings.Measurer
+}
+
+func (m *syncMeasurer) StartSpan(label, summary string) *timings.Span {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.meas.StartSpan(label, summary)
+}
+
 // regenerateAllSecurityProfiles will regenerate all security profiles.
 func (m *InterfaceManager) regenerateAllSecurityProfiles(tm timings.Measurer) error {
 	// Get all the security backends
@@ -138,33 +185,59 @@ func (m *InterfaceManager) regenerateAllSecurityProfiles(tm timings.Measurer) er
 	shouldWriteSystemKey := true
 	os.Remove(dirs.SnapSystemKeyFile)
 
-	// For each snap:
-	for _, snapInfo := range snaps {
+	// Compute the confinement options of each snap
+	opts := make([]interfaces.ConfinementOptions, len(snaps))
+	for i, snapInfo := range snaps {
 		snapName := snapInfo.InstanceName()
 		// Get the state of the snap so we can compute the confinement option
 		var snapst snapstate.SnapState
 		if err := snapstate.Get(m.state, snapName, &snapst); err != nil {
 			logger.Noticef("cannot get state of snap %q: %s", snapName, err)
 		}
+		opts[i] = confinementOptions(snapst.Flags)
+	}
+
+	// The profiles of the system snaps (snapd and core) touch files
+	// shared with the profiles of all other snaps, so they are set up
+	// first, and only then the remaining snaps are set up in parallel.
+	var systemSnaps, otherSnaps []int
+	for i, snapInfo := range snaps {
+		switch snapInfo.GetType() {
+		case snap.TypeSnapd, snap.TypeOS:
+			systemSnaps = append(systemSnaps, i)
+		default:
+			otherSnaps = append(otherSnaps, i)
+		}
+	}
 
-		// Compute confinement options
-		opts := confinementOptions(snapst.Flags)
+	// Spans are started concurrently by the workers below.
+	stm := &syncMeasurer{meas: tm}
+	var mu sync.Mutex
 
-		// For each backend:
-		for _, backend := range securityBackends {
-			if backend.Name() == "" {
-				continue // Test backends have no name, skip them to simplify testing.
-			}
+	// Setup all snaps, start with the most important security backend
+	// and run it for all snaps. See LP: 1802581
+	for _, backend := range securityBackends {
+		if backend.Name() == "" {
+			continue // Test backends have no name, skip them to simplify testing.
+		}
+		setup := func(i int) {
+			snapInfo := snaps[i]
 			// Refresh security of this snap and backend
-			timings.Run(tm, "setup-security-backend", fmt.Sprintf("setup security backend %q for snap %q", backend.Name(), snapInfo.InstanceName()), func(nesttm timings.Measurer) {
-				if err := backend.Setup(snapInfo, opts, m.repo, nesttm); err != nil {
+			timings.Run(stm, "setup-security-backend", fmt.Sprintf("setup security backend %q for snap %q", backend.Name(), snapInfo.InstanceName()), func(nesttm timings.Measurer) {
+				if err := backend.Setup(snapInfo, opts[i], m.repo, nesttm); err != nil {
 					// Let's log this but carry on without writing the system key.
 					logger.Noticef("cannot regenerate %s profile for snap %q: %s",
-						backend.Name(), snapName, err)
+						backend.Name(), snapInfo.InstanceName(), err)
+					mu.Lock()
 					shouldWriteSystemKey = false
+					mu.Unlock()
 				}
 			})
 		}
+		for _, i := range systemSnaps {
+			setup(i)
+		}
+		runConcurrently(otherSnaps, profileRegenerationWorkers, setup)
 	}
 
 	if shouldWriteSystemKey {
@@ -244,6 +317,26 @@ var removeStaleConnections = func(st *state.State) error {
 	return nil
 }
 
+// withContentVersion returns the dynamic attributes of a content plug
+// recording the given negotiated version of the content, and whether they
+// differ from the given attributes. The attributes are copied before being
+// modified.
+func withContentVersion(attrs map[string]interface{}, version string) (map[string]interface{}, bool) {
+	if current, _ := attrs["content-version"].(string); current == version {
+		return attrs, false
+	}
+	newAttrs := make(map[string]interface{}, len(attrs)+1)
+	for k, v := range attrs {
+		newAttrs[k] = v
+	}
+	if version == "" {
+		delete(newAttrs, "content-version")
+	} else {
+		newAttrs["content-version"] = version
+	}
+	return newAttrs, true
+}
+
 // reloadConnections reloads connections stored in the state in the repository.
 // Using non-empty snapName the operation can be scoped to connections
 // affecting a given snap.
@@ -286,9 +379,10 @@ func (m *InterfaceManager) reloadConnections(snapName string) ([]string, error)
 			continue
 		}
 
-		var updateStaticAttrs bool
+		var updateStaticAttrs, updateDynamicAttrs bool
 		staticPlugAttrs := connState.StaticPlugAttrs
 		staticSlotAttrs := connState.StaticSlotAttrs
+		dynamicPlugAttrs := connState.DynamicPlugAttrs
 
 		// XXX: Refresh the copy of the static connection attributes for "content" interface as long
 		// as its "content" attribute
@@ -302,13 +396,30 @@ func (m *InterfaceManager) reloadConnections(snapName string) ([]string, error)
 				staticPlugAttrs = utils.NormalizeInterfaceAttributes(plugInfo.Attrs).(map[string]interface{})
 				staticSlotAttrs = utils.NormalizeInterfaceAttributes(slotInfo.Attrs).(map[string]interface{})
 				updateStaticAttrs = true
+
+				// The versions of the content provided by the slot or
+				// supported by the plug may have changed with the
+				// refreshed attributes, negotiate the version again and
+				// drop a connection that is no longer compatible so that
+				// it is not reported as connected anymore, warning about
+				// it as the consumer loses the content.
+				version, err := builtin.ContentVersion(plugInfo, slotInfo)
+				if err != nil {
+					m.state.Warnf("removed content connection %q, it is no longer compatible: %v", connId, err)
+					delete(conns, connId)
+					connStateChanged = true
+					affected[connRef.PlugRef.Snap] = true
+					affected[connRef.SlotRef.Snap] = true
+					continue
+				}
+				dynamicPlugAttrs, updateDynamicAttrs = withContentVersion(dynamicPlugAttrs, version)
 			} else {
 				logger.Noticef("cannot refresh static attributes of the connection %q", connId)
 			}
 		}
 
 		// Note: reloaded connections are not checked against policy again, and also we don't call BeforeConnect* methods on them.
-		if _, err := m.repo.Connect(connRef, staticPlugAttrs, connState.DynamicPlugAttrs, staticSlotAttrs, connState.DynamicSlotAttrs, nil); err != nil {
+		if _, err := m.repo.Connect(connRef, staticPlugAttrs, dynamicPlugAttrs, staticSlotAttrs, connState.DynamicSlotAttrs, nil); err != nil {
 			logger.Noticef("%s", err)
 		} else {
 			// If the connection succeeded update the connection state and keep
@@ -321,6 +432,10 @@ func (m *InterfaceManager) reloadConnections(snapName string) ([]string, error)
 				connState.StaticSlotAttrs = staticSlotAttrs
 				connStateChanged = true
 			}
+			if updateDynamicAttrs {
+				connState.DynamicPlugAttrs = dynamicPlugAttrs
+				connStateChanged = true
+			}
 		}
 	}
 	if connStateChanged {
@@ -463,6 +578,9 @@ type connState struct {
 	// slots.
 	HotplugGone bool            `json:"hotplug-gone,omitempty"`
 	HotplugKey  snap.HotplugKey `json:"hotplug-key,omitempty"`
+	// Expiry is set for temporary connections, which the interface
+	// manager disconnects once the expiry time has passed.
+	Expiry *time.Time `json:"expiry,omitempty"`
 }
 
 type autoConnectChecker struct {
@@ -679,6 +797,24 @@ func setConns(st *state.State, conns map[string]*connState) {
 	st.Set("conns", remapped)
 }
 
+// ConnectionUpdate is the data of "connection" notifications, sent when
+// a connection is made or removed.
+type ConnectionUpdate struct {
+	Plug      string `json:"plug"`
+	Slot      string `json:"slot"`
+	Interface string `json:"interface"`
+	Connected bool   `json:"connected"`
+}
+
+func notifyConnection(st *state.State, connRef *interfaces.ConnRef, iface string, connected bool) {
+	st.Notify("connection", &ConnectionUpdate{
+		Plug:      connRef.PlugRef.String(),
+		Slot:      connRef.SlotRef.String(),
+		Interface: iface,
+		Connected: connected,
+	})
+}
+
 // snapsWithSecurityProfiles returns all snaps that have active
 // security profiles: these are either snaps that are active, or about
 // to be active (pending link-snap) with a done setup-profiles
@@ -25,7 +25,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -417,6 +420,80 @@ apps:
 	c.Check(osutil.FileExists(dirs.SnapSystemKeyFile), Equals, false)
 }
 
+func (s *helpersSuite) TestProfileRegenerationParallel(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	defer dirs.SetRootDir("")
+
+	restore := ifacestate.MockProfileRegenerationWorkers(2)
+	defer restore()
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	var setupOrder []string
+	backend := &ifacetest.TestSecurityBackend{
+		BackendName: "fake",
+		SetupCallback: func(snapInfo *snap.Info, opts interfaces.ConfinementOptions, repo *interfaces.Repository) error {
+			mu.Lock()
+			setupOrder = append(setupOrder, snapInfo.InstanceName())
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return nil
+		},
+	}
+	restore = ifacestate.MockSecurityBackends([]interfaces.SecurityBackend{backend})
+	defer restore()
+
+	ovld := overlord.Mock()
+	st := ovld.State()
+
+	st.Lock()
+	names := []string{"core", "app-a", "app-b", "app-c", "app-d", "app-e"}
+	for _, name := range names {
+		typ := snap.TypeApp
+		if name == "core" {
+			typ = snap.TypeOS
+		}
+		si := &snap.SideInfo{Revision: snap.R(1), RealName: name}
+		snapInfo := snaptest.MockSnap(c, fmt.Sprintf("name: %s\nversion: 1\ntype: %s\n", name, typ), si)
+		snapstate.Set(st, snapInfo.InstanceName(), &snapstate.SnapState{
+			SnapType: string(typ),
+			Sequence: []*snap.SideInfo{si},
+			Active:   true,
+			Current:  snap.R(1),
+		})
+	}
+	st.Unlock()
+
+	restore = ifacestate.MockProfilesNeedRegeneration(func() bool { return true })
+	defer restore()
+	restore = ifacestate.MockWriteSystemKey(func() error { return nil })
+	defer restore()
+
+	mgr, err := ifacestate.Manager(st, nil, ovld.TaskRunner(), nil, nil)
+	c.Assert(err, IsNil)
+	err = mgr.StartUp()
+	c.Assert(err, IsNil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(setupOrder, HasLen, len(names))
+	// the system snap is set up before any other snap
+	c.Check(setupOrder[0], Equals, "core")
+	sort.Strings(setupOrder)
+	sortedNames := append([]string(nil), names...)
+	sort.Strings(sortedNames)
+	c.Check(setupOrder, DeepEquals, sortedNames)
+	c.Check(maxInFlight <= 2, Equals, true)
+	c.Check(backend.SetupCalls, HasLen, len(names))
+}
+
 func (s *helpersSuite) TestIsHotplugChange(c *C) {
 	s.st.Lock()
 	defer s.st.Unlock()
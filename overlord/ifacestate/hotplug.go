@@ -36,7 +36,8 @@ import (
 )
 
 // deviceKey determines a key for given device and hotplug interface. Every interface may provide a custom HotplugDeviceKey method
-// to compute device key - if it doesn't, we fall back to defaultDeviceKey.
+// to compute device key, or a HotplugKeyAttributes method to choose the attributes the key is computed from - if it doesn't,
+// we fall back to defaultDeviceKey.
 func deviceKey(device *hotplug.HotplugDeviceInfo, iface interfaces.Interface, defaultDeviceKey snap.HotplugKey) (deviceKey snap.HotplugKey, err error) {
 	if keyhandler, ok := iface.(hotplug.HotplugKeyHandler); ok {
 		deviceKey, err = keyhandler.HotplugKey(device)
@@ -47,6 +48,11 @@ func deviceKey(device *hotplug.HotplugDeviceInfo, iface interfaces.Interface, de
 			return deviceKey, nil
 		}
 	}
+	if policy, ok := iface.(hotplug.HotplugKeyPolicy); ok {
+		if groups := policy.HotplugKeyAttributes(); len(groups) > 0 {
+			return deviceKeyFromAttributes(device, deviceKeyVersion, groups), nil
+		}
+	}
 	return defaultDeviceKey, nil
 }
 
@@ -81,12 +87,20 @@ var deviceKeyVersion = len(attrGroups) - 1
 // <version><checksum> where checksum is the sha256 checksum computed over
 // select attributes of the device.
 func defaultDeviceKey(devinfo *hotplug.HotplugDeviceInfo, keyVersion int) (snap.HotplugKey, error) {
-	found := 0
-	key := sha256.New()
 	if keyVersion >= 16 || keyVersion >= len(attrGroups) {
 		return "", fmt.Errorf("internal error: invalid key version %d", keyVersion)
 	}
-	for _, group := range attrGroups[keyVersion] {
+	return deviceKeyFromAttributes(devinfo, keyVersion, attrGroups[keyVersion]), nil
+}
+
+// deviceKeyFromAttributes computes device key from the given groups of
+// attributes, the first non-empty attribute within each group goes into the
+// key. Empty string is returned if fewer than two groups have a non-empty
+// attribute.
+func deviceKeyFromAttributes(devinfo *hotplug.HotplugDeviceInfo, keyVersion int, groups [][]string) snap.HotplugKey {
+	found := 0
+	key := sha256.New()
+	for _, group := range groups {
 		for _, attr := range group {
 			if val, ok := devinfo.Attribute(attr); ok && val != "" {
 				key.Write([]byte(attr))
@@ -99,9 +113,9 @@ func defaultDeviceKey(devinfo *hotplug.HotplugDeviceInfo, keyVersion int) (snap.
 		}
 	}
 	if found < 2 {
-		return "", nil
+		return ""
 	}
-	return snap.HotplugKey(fmt.Sprintf("%x%x", keyVersion, key.Sum(nil))), nil
+	return snap.HotplugKey(fmt.Sprintf("%x%x", keyVersion, key.Sum(nil)))
 }
 
 // hotplugDeviceAdded gets called when a device is added to the system.
@@ -110,6 +124,14 @@ func (m *InterfaceManager) hotplugDeviceAdded(devinfo *hotplug.HotplugDeviceInfo
 	st.Lock()
 	defer st.Unlock()
 
+	m.addHotplugDevice(devinfo, m.repo.AllHotplugInterfaces())
+}
+
+// addHotplugDevice creates the slots that the given hotplug interfaces
+// propose for the device. The state must be locked by the caller.
+func (m *InterfaceManager) addHotplugDevice(devinfo *hotplug.HotplugDeviceInfo, hotplugIfaces map[string]interfaces.Interface) {
+	st := m.state
+
 	if _, err := systemSnapInfo(st); err != nil {
 		logger.Noticef("system snap not available, hotplug events ignored")
 		return
@@ -137,7 +159,6 @@ func (m *InterfaceManager) hotplugDeviceAdded(devinfo *hotplug.HotplugDeviceInfo
 		logger.Noticef("internal error: cannot get gadget information: %v", err)
 	}
 
-	hotplugIfaces := m.repo.AllHotplugInterfaces()
 	gadgetSlotsByInterface := make(map[string][]*snap.SlotInfo)
 	if gadget != nil {
 		for _, gadgetSlot := range gadget.Slots {
@@ -218,6 +239,7 @@ InterfacesLoop:
 		// We may have different interfaces at same paths (e.g. a "foo-observe" and "foo-control" interfaces), therefore use lists.
 		// Duplicates are not expected here because if a device is plugged twice, there will be an udev "remove" event between the adds
 		// and hotplugDeviceRemoved() will remove affected path from hotplugDevicePaths.
+		m.forgetStaleDevicePaths(devPath, iface.Name(), key)
 		m.hotplugDevicePaths[devPath] = append(m.hotplugDevicePaths[devPath], deviceData{hotplugKey: key, ifaceName: iface.Name()})
 
 		hotplugAdd := st.NewTask("hotplug-add-slot", fmt.Sprintf("Create slot for device %s with hotplug key %q", devinfo.ShortString(), key.ShortString()))
@@ -274,17 +296,132 @@ func (m *InterfaceManager) hotplugDeviceRemoved(devinfo *hotplug.HotplugDeviceIn
 
 		logger.Debugf("removing hotplug device %s for interface %q, hotplug key %q", devinfo, ifaceName, hotplugKey)
 
-		seq, err := allocHotplugSeq(st)
-		if err != nil {
-			logger.Noticef("internal error: cannot handle removal of hotplug device %s, hotplug key %q: %v", devinfo, hotplugKey, err)
+		if m.removeHotplugSlot(devinfo, ifaceName, hotplugKey) {
+			changed = true
+		}
+	}
+
+	if changed {
+		st.EnsureBefore(0)
+	}
+}
+
+// removeHotplugSlot creates a change that disconnects and removes the slot
+// of the given interface created for the device with the given hotplug key.
+// It returns false if the change could not be created.
+func (m *InterfaceManager) removeHotplugSlot(devinfo *hotplug.HotplugDeviceInfo, ifaceName string, hotplugKey snap.HotplugKey) bool {
+	st := m.state
+
+	seq, err := allocHotplugSeq(st)
+	if err != nil {
+		logger.Noticef("internal error: cannot handle removal of hotplug device %s, hotplug key %q: %v", devinfo, hotplugKey, err)
+		return false
+	}
+
+	ts := removeDevice(st, ifaceName, hotplugKey)
+	chg := st.NewChange(fmt.Sprintf("hotplug-remove-%s", ifaceName), fmt.Sprintf("Remove hotplug connections and slots of device %s with interface %q", devinfo.ShortString(), ifaceName))
+	chg.AddAll(ts)
+	addHotplugSeqWaitTask(chg, hotplugKey, seq)
+	return true
+}
+
+// forgetStaleDevicePaths drops the given interface and hotplug key from the
+// observed device paths other than devPath. This happens when a device gets
+// re-enumerated under a new path before the removal of the old one is
+// reported; its slot is then updated in place and must not be removed
+// along with the stale path.
+func (m *InterfaceManager) forgetStaleDevicePaths(devPath, ifaceName string, hotplugKey snap.HotplugKey) {
+	for path, devs := range m.hotplugDevicePaths {
+		if path == devPath {
 			continue
 		}
+		var kept []deviceData
+		for _, dev := range devs {
+			if dev.ifaceName == ifaceName && dev.hotplugKey == hotplugKey {
+				logger.Debugf("hotplug key %q of interface %q moved from device path %s to %s", hotplugKey, ifaceName, path, devPath)
+				continue
+			}
+			kept = append(kept, dev)
+		}
+		if len(kept) == 0 {
+			delete(m.hotplugDevicePaths, path)
+		} else {
+			m.hotplugDevicePaths[path] = kept
+		}
+	}
+}
 
-		ts := removeDevice(st, ifaceName, hotplugKey)
-		chg := st.NewChange(fmt.Sprintf("hotplug-remove-%s", ifaceName), fmt.Sprintf("Remove hotplug connections and slots of device %s with interface %q", devinfo.ShortString(), ifaceName))
-		chg.AddAll(ts)
-		addHotplugSeqWaitTask(chg, hotplugKey, seq)
-		changed = true
+// hotplugDeviceChanged gets called when udev reports a change of a device
+// that is present in the system. The device is re-evaluated by the hotplug
+// interfaces that watch any of the attributes that changed.
+func (m *InterfaceManager) hotplugDeviceChanged(old, devinfo *hotplug.HotplugDeviceInfo) {
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	ifaces := make(map[string]interfaces.Interface)
+	for name, iface := range m.repo.AllHotplugInterfaces() {
+		watcher, ok := iface.(hotplug.AttributeWatcher)
+		if ok && attributesChanged(old, devinfo, watcher.HotplugWatchedAttributes()) {
+			ifaces[name] = iface
+		}
+	}
+	if len(ifaces) == 0 {
+		return
+	}
+
+	hotplugFeature, err := m.hotplugEnabled()
+	if err != nil {
+		logger.Noticef("internal error: cannot get hotplug feature flag: %s", err.Error())
+		return
+	}
+	if !hotplugFeature {
+		logger.Noticef("hotplug device change event ignored, enable experimental.hotplug")
+		return
+	}
+
+	// forget what the re-evaluating interfaces made of the device so far,
+	// slots whose hotplug key is still the same get updated in place.
+	devPath := devinfo.DevicePath()
+	var previous, kept []deviceData
+	for _, dev := range m.hotplugDevicePaths[devPath] {
+		if _, ok := ifaces[dev.ifaceName]; ok {
+			previous = append(previous, dev)
+		} else {
+			kept = append(kept, dev)
+		}
+	}
+	if len(kept) == 0 {
+		delete(m.hotplugDevicePaths, devPath)
+	} else {
+		m.hotplugDevicePaths[devPath] = kept
+	}
+
+	logger.Debugf("re-evaluating changed hotplug device %s", devinfo)
+	m.addHotplugDevice(devinfo, ifaces)
+
+	current := make(map[deviceData]bool)
+	for _, dev := range m.hotplugDevicePaths[devPath] {
+		current[dev] = true
+	}
+	var changed bool
+	for _, dev := range previous {
+		if current[dev] {
+			continue
+		}
+		// the device doesn't get the same slot anymore
+		slot, err := m.repo.SlotForHotplugKey(dev.ifaceName, dev.hotplugKey)
+		if err != nil {
+			logger.Noticef("internal error: cannot obtain slot for hotplug interface %q, hotplug key %q: %v", dev.ifaceName, dev.hotplugKey, err)
+			continue
+		}
+		if slot == nil {
+			continue
+		}
+		logger.Debugf("removing hotplug slot %q of changed device %s for interface %q, hotplug key %q", slot.Name, devinfo, dev.ifaceName, dev.hotplugKey)
+		if m.removeHotplugSlot(devinfo, dev.ifaceName, dev.hotplugKey) {
+			changed = true
+		}
 	}
 
 	if changed {
@@ -292,6 +429,19 @@ func (m *InterfaceManager) hotplugDeviceRemoved(devinfo *hotplug.HotplugDeviceIn
 	}
 }
 
+// attributesChanged returns true if any of the given attributes differs
+// between the two device infos.
+func attributesChanged(old, devinfo *hotplug.HotplugDeviceInfo, attrs []string) bool {
+	for _, attr := range attrs {
+		oldVal, _ := old.Attribute(attr)
+		newVal, _ := devinfo.Attribute(attr)
+		if oldVal != newVal {
+			return true
+		}
+	}
+	return false
+}
+
 // hotplugEnumerationDone gets called when initial enumeration on startup is finished.
 func (m *InterfaceManager) hotplugEnumerationDone() {
 	st := m.state
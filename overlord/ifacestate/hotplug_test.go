@@ -56,6 +56,7 @@ type hotplugSuite struct {
 
 	udevMon               *udevMonitorMock
 	mgr                   *ifacestate.InterfaceManager
+	hotplugMgr            *ifacestate.HotplugManager
 	handledByGadgetCalled int
 
 	ifaceTestAAutoConnect bool
@@ -149,9 +150,10 @@ func (s *hotplugSuite) SetUpTest(c *C) {
 	s.BaseTest.AddCleanup(restoreTimeout)
 
 	s.udevMon = &udevMonitorMock{}
-	restoreCreate := ifacestate.MockCreateUDevMonitor(func(add udevmonitor.DeviceAddedFunc, remove udevmonitor.DeviceRemovedFunc, done udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
+	restoreCreate := ifacestate.MockCreateUDevMonitor(func(add udevmonitor.DeviceAddedFunc, remove udevmonitor.DeviceRemovedFunc, change udevmonitor.DeviceChangedFunc, done udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
 		s.udevMon.AddDevice = add
 		s.udevMon.RemoveDevice = remove
+		s.udevMon.ChangeDevice = change
 		s.udevMon.EnumerationDone = done
 		return s.udevMon
 	})
@@ -182,6 +184,8 @@ func (s *hotplugSuite) SetUpTest(c *C) {
 	c.Assert(err, IsNil)
 
 	s.o.AddManager(s.mgr)
+	s.hotplugMgr = ifacestate.NewHotplugManager(s.mgr)
+	s.o.AddManager(s.hotplugMgr)
 	s.o.AddManager(s.o.TaskRunner())
 
 	// startup
@@ -260,8 +264,9 @@ func (s *hotplugSuite) SetUpTest(c *C) {
 		s.AddCleanup(builtin.MockInterface(iface))
 	}
 
-	// single Ensure to have udev monitor created and wired up by interface manager
-	c.Assert(s.mgr.Ensure(), IsNil)
+	// single Ensure to have udev monitor created and wired up by the
+	// hotplug manager
+	c.Assert(s.hotplugMgr.Ensure(), IsNil)
 }
 
 func (s *hotplugSuite) TearDownTest(c *C) {
@@ -792,6 +797,172 @@ func (s *hotplugSuite) TestHotplugDeviceUpdate(c *C) {
 		"hotplug-gone": false})
 }
 
+func (s *hotplugSuite) TestHotplugDeviceReenumerated(c *C) {
+	s.MockModel(c, nil)
+
+	di, err := hotplug.NewHotplugDeviceInfo(map[string]string{"DEVPATH": "a/path", "ACTION": "add", "SUBSYSTEM": "foo"})
+	c.Assert(err, IsNil)
+	s.udevMon.AddDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	// the device shows up under a new path before its removal from the
+	// old path is reported
+	di, err = hotplug.NewHotplugDeviceInfo(map[string]string{"DEVPATH": "b/path", "ACTION": "add", "SUBSYSTEM": "foo"})
+	c.Assert(err, IsNil)
+	s.udevMon.AddDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	di, err = hotplug.NewHotplugDeviceInfo(map[string]string{"DEVPATH": "a/path", "ACTION": "remove", "SUBSYSTEM": "foo"})
+	c.Assert(err, IsNil)
+	s.udevMon.RemoveDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	var hp hotplugTasksWitness
+	hp.checkTasks(c, st)
+	c.Check(hp.seenHotplugRemoveKeys, HasLen, 0)
+
+	// the slots are still there, with a single slot per interface
+	repo := s.mgr.Repository()
+	c.Check(repo.AllSlots("test-a"), HasLen, 1)
+	c.Check(repo.AllSlots("test-b"), HasLen, 1)
+	slot, err := repo.SlotForHotplugKey("test-a", "key-1")
+	c.Assert(err, IsNil)
+	c.Assert(slot, NotNil)
+	c.Check(slot.Attrs["path"], Equals, filepath.Join(dirs.SysfsDir, "b/path"))
+
+	// removal of the device from its current path removes the slots
+	st.Unlock()
+	di, err = hotplug.NewHotplugDeviceInfo(map[string]string{"DEVPATH": "b/path", "ACTION": "remove", "SUBSYSTEM": "foo"})
+	c.Assert(err, IsNil)
+	s.udevMon.RemoveDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+	st.Lock()
+
+	c.Check(repo.AllSlots("test-a"), HasLen, 0)
+	c.Check(repo.AllSlots("test-b"), HasLen, 0)
+}
+
+func (s *hotplugSuite) TestHotplugKeyPolicy(c *C) {
+	s.MockModel(c, nil)
+
+	iface := &ifacetest.TestHotplugInterface{
+		TestInterface: ifacetest.TestInterface{InterfaceName: "test-e"},
+		HotplugKeyAttributesCallback: func() [][]string {
+			return [][]string{{"ID_VENDOR_ID"}, {"ID_SERIAL_SHORT", "ID_SERIAL"}}
+		},
+		HotplugDeviceDetectedCallback: func(deviceInfo *hotplug.HotplugDeviceInfo) (*hotplug.ProposedSlot, error) {
+			return &hotplug.ProposedSlot{Name: "hotplugslot-e"}, nil
+		},
+	}
+	c.Assert(s.mgr.Repository().AddInterface(iface), IsNil)
+	defer builtin.MockInterface(iface)()
+
+	di, err := hotplug.NewHotplugDeviceInfo(map[string]string{
+		"DEVPATH":         "a/path",
+		"ACTION":          "add",
+		"SUBSYSTEM":       "foo",
+		"NAME":            "ttyUSB0",
+		"ID_VENDOR_ID":    "vendor",
+		"ID_MODEL_ID":     "model",
+		"ID_SERIAL_SHORT": "serial",
+	})
+	c.Assert(err, IsNil)
+	s.udevMon.AddDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// the key of test-e is computed from the attributes it asked for,
+	// test-d still gets the default key
+	repo := s.mgr.Repository()
+	slots := repo.AllSlots("test-e")
+	c.Assert(slots, HasLen, 1)
+	c.Check(slots[0].HotplugKey, Equals, keyHelper("ID_VENDOR_ID\x00vendor\x00ID_SERIAL_SHORT\x00serial\x00"))
+	slots = repo.AllSlots("test-d")
+	c.Assert(slots, HasLen, 1)
+	c.Check(slots[0].HotplugKey, Equals, keyHelper("NAME\x00ttyUSB0\x00ID_VENDOR_ID\x00vendor\x00ID_MODEL_ID\x00model\x00ID_SERIAL_SHORT\x00serial\x00"))
+}
+
+func (s *hotplugSuite) TestHotplugDeviceChanged(c *C) {
+	s.MockModel(c, nil)
+
+	iface := &ifacetest.TestHotplugInterface{
+		TestInterface: ifacetest.TestInterface{InterfaceName: "test-e"},
+		HotplugKeyCallback: func(deviceInfo *hotplug.HotplugDeviceInfo) (snap.HotplugKey, error) {
+			serial, _ := deviceInfo.Attribute("ID_SERIAL")
+			return snap.HotplugKey("key-" + serial), nil
+		},
+		HotplugWatchedAttrsCallback: func() []string {
+			return []string{"ID_SERIAL"}
+		},
+		HotplugDeviceDetectedCallback: func(deviceInfo *hotplug.HotplugDeviceInfo) (*hotplug.ProposedSlot, error) {
+			return &hotplug.ProposedSlot{Name: "hotplugslot-e"}, nil
+		},
+	}
+	c.Assert(s.mgr.Repository().AddInterface(iface), IsNil)
+	defer builtin.MockInterface(iface)()
+
+	devinfo := func(attrs map[string]string) *hotplug.HotplugDeviceInfo {
+		env := map[string]string{"DEVPATH": "a/path", "SUBSYSTEM": "foo"}
+		for k, v := range attrs {
+			env[k] = v
+		}
+		di, err := hotplug.NewHotplugDeviceInfo(env)
+		c.Assert(err, IsNil)
+		return di
+	}
+
+	di := devinfo(map[string]string{"ACTION": "add", "ID_SERIAL": "one", "ID_REVISION": "1"})
+	s.udevMon.AddDevice(di)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	st := s.state
+	st.Lock()
+	numChanges := len(st.Changes())
+	st.Unlock()
+
+	// a change of an attribute that is not watched does nothing
+	newdi := devinfo(map[string]string{"ACTION": "change", "ID_SERIAL": "one", "ID_REVISION": "2"})
+	s.udevMon.ChangeDevice(di, newdi)
+	di = newdi
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	st.Lock()
+	c.Check(st.Changes(), HasLen, numChanges)
+	st.Unlock()
+
+	// a change of a watched attribute gets the device re-evaluated
+	newdi = devinfo(map[string]string{"ACTION": "change", "ID_SERIAL": "two", "ID_REVISION": "2"})
+	s.udevMon.ChangeDevice(di, newdi)
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+
+	var hp hotplugTasksWitness
+	hp.checkTasks(c, st)
+	c.Check(hp.seenHotplugAddKeys["key-two"], Equals, "test-e")
+	c.Check(hp.seenHotplugRemoveKeys, DeepEquals, map[snap.HotplugKey]string{"key-one": "test-e"})
+
+	repo := s.mgr.Repository()
+	slots := repo.AllSlots("test-e")
+	c.Assert(slots, HasLen, 1)
+	c.Check(slots[0].HotplugKey, Equals, snap.HotplugKey("key-two"))
+
+	// removal of the device removes the re-evaluated slot
+	st.Unlock()
+	s.udevMon.RemoveDevice(devinfo(map[string]string{"ACTION": "remove"}))
+	c.Assert(s.o.Settle(5*time.Second), IsNil)
+	st.Lock()
+	c.Check(repo.AllSlots("test-e"), HasLen, 0)
+}
+
 func keyHelper(input string) snap.HotplugKey {
 	return snap.HotplugKey(fmt.Sprintf("0%x", sha256.Sum256([]byte(input))))
 }
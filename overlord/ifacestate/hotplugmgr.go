@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+// HotplugManager watches udev for devices being added, changed and
+// removed, and has the interface manager create, update and remove the
+// slots of the hotplug interfaces on the system snap for them.
+//
+// It is an optional subsystem of snapd, so it is a state manager of its
+// own, see overlord.RegisterOptionalManager.
+type HotplugManager struct {
+	ifaceMgr *InterfaceManager
+}
+
+// NewHotplugManager returns the hotplug manager feeding the given
+// interface manager.
+func NewHotplugManager(ifaceMgr *InterfaceManager) *HotplugManager {
+	return &HotplugManager{ifaceMgr: ifaceMgr}
+}
+
+// Ensure implements StateManager.Ensure. It starts the udev monitor
+// once the system snap is present.
+func (m *HotplugManager) Ensure() error {
+	return m.ifaceMgr.ensureUDevMonitor()
+}
+
+// Stop implements StateStopper. It stops the udev monitor, if
+// running.
+func (m *HotplugManager) Stop() {
+	m.ifaceMgr.stopUDevMonitor()
+}
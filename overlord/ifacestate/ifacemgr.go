@@ -20,15 +20,18 @@
 package ifacestate
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/interfaces/backends"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/ifacestate/udevmonitor"
+	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/timings"
@@ -55,6 +58,8 @@ type InterfaceManager struct {
 	enumerationDone      bool
 	// maps sysfs path -> [(interface name, device key)...]
 	hotplugDevicePaths map[string][]deviceData
+	// when an ensure was last requested to disconnect expired connections
+	expiryEnsure time.Time
 
 	// extras
 	extraInterfaces []interfaces.Interface
@@ -109,6 +114,12 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, runner *state.T
 	// helper for ubuntu-core -> core
 	addHandler("transition-ubuntu-core", m.doTransitionUbuntuCore, m.undoTransitionUbuntuCore)
 
+	// writing the security profiles must not happen alongside
+	// other changes touching them
+	for _, kind := range []string{"setup-profiles", "remove-profiles"} {
+		snapstate.AddTaskResources(kind, securityProfilesResources)
+	}
+
 	// interface tasks might touch more than the immediate task target snap, serialize them
 	runner.AddBlocked(func(t *state.Task, running []*state.Task) bool {
 		if !taskKinds[t.Kind()] {
@@ -127,6 +138,10 @@ func Manager(s *state.State, hookManager *hookstate.HookManager, runner *state.T
 	return m, nil
 }
 
+func securityProfilesResources(t *state.Task) ([]snapstate.ResourceLock, error) {
+	return []snapstate.ResourceLock{{Resource: snapstate.ResourceSecurityProfiles, Exclusive: true}}, nil
+}
+
 // StartUp implements StateStarterUp.Startup.
 func (m *InterfaceManager) StartUp() error {
 	s := m.state
@@ -179,6 +194,78 @@ func (m *InterfaceManager) StartUp() error {
 
 // Ensure implements StateManager.Ensure.
 func (m *InterfaceManager) Ensure() error {
+	if err := m.disconnectExpiredConnections(); err != nil {
+		logger.Noticef("Cannot disconnect expired connections: %v", err)
+	}
+	return nil
+}
+
+// disconnectExpiredConnections creates changes to disconnect the
+// temporary connections whose expiry time has passed. Connections of
+// snaps with other changes in progress are left for a later Ensure.
+func (m *InterfaceManager) disconnectExpiredConnections() error {
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	conns, err := getConns(st)
+	if err != nil {
+		return err
+	}
+
+	now := timeNow()
+	var next time.Time
+	for id, cstate := range conns {
+		if cstate.Expiry == nil || cstate.Undesired || cstate.HotplugGone {
+			continue
+		}
+		if cstate.Expiry.After(now) {
+			if next.IsZero() || cstate.Expiry.Before(next) {
+				next = *cstate.Expiry
+			}
+			continue
+		}
+
+		connRef, err := interfaces.ParseConnRef(id)
+		if err != nil {
+			return err
+		}
+		conn, err := m.repo.Connection(connRef)
+		if err != nil {
+			// the snaps may be gone already, leave it alone
+			logger.Noticef("Cannot disconnect expired connection %q: %v", id, err)
+			continue
+		}
+		affected := []string{connRef.PlugRef.Snap, connRef.SlotRef.Snap}
+		if err := snapstate.CheckChangeConflictMany(st, affected, ""); err != nil {
+			if _, ok := err.(*snapstate.ChangeConflictError); ok {
+				// try again later
+				if next.IsZero() || now.Add(expiredConnectionRetryTimeout).Before(next) {
+					next = now.Add(expiredConnectionRetryTimeout)
+				}
+				continue
+			}
+			return err
+		}
+		ts, err := disconnectTasks(st, conn, disconnectOpts{})
+		if err != nil {
+			return err
+		}
+		summary := fmt.Sprintf(i18n.G("Disconnect expired connection %s:%s from %s:%s"),
+			connRef.PlugRef.Snap, connRef.PlugRef.Name, connRef.SlotRef.Snap, connRef.SlotRef.Name)
+		chg := st.NewChange("disconnect", summary)
+		chg.AddAll(ts)
+	}
+
+	// avoid requesting again an ensure that is already due earlier
+	if !next.IsZero() && !(m.expiryEnsure.After(now) && !m.expiryEnsure.After(next)) {
+		m.expiryEnsure = next
+		st.EnsureBefore(next.Sub(now))
+	}
+	return nil
+}
+
+func (m *InterfaceManager) ensureUDevMonitor() error {
 	if m.udevMonitorDisabled {
 		return nil
 	}
@@ -207,9 +294,8 @@ func (m *InterfaceManager) Ensure() error {
 	return nil
 }
 
-// Stop implements StateStopper. It stops the udev monitor,
-// if running.
-func (m *InterfaceManager) Stop() {
+// stopUDevMonitor stops the udev monitor, if running.
+func (m *InterfaceManager) stopUDevMonitor() {
 	m.udevMonMu.Lock()
 	udevMon := m.udevMon
 	m.udevMonMu.Unlock()
@@ -251,6 +337,9 @@ type ConnectionState struct {
 	StaticSlotAttrs  map[string]interface{}
 	DynamicSlotAttrs map[string]interface{}
 	HotplugGone      bool
+	// Expiry is the time after which a temporary connection is
+	// disconnected, it's zero for regular connections
+	Expiry time.Time
 }
 
 // ConnectionStates return the state of connections tracked by the manager
@@ -264,7 +353,7 @@ func (m *InterfaceManager) ConnectionStates() (connStateByRef map[string]Connect
 
 	connStateByRef = make(map[string]ConnectionState, len(states))
 	for cref, cstate := range states {
-		connStateByRef[cref] = ConnectionState{
+		cs := ConnectionState{
 			Auto:             cstate.Auto,
 			ByGadget:         cstate.ByGadget,
 			Interface:        cstate.Interface,
@@ -275,6 +364,10 @@ func (m *InterfaceManager) ConnectionStates() (connStateByRef map[string]Connect
 			DynamicSlotAttrs: cstate.DynamicSlotAttrs,
 			HotplugGone:      cstate.HotplugGone,
 		}
+		if cstate.Expiry != nil {
+			cs.Expiry = *cstate.Expiry
+		}
+		connStateByRef[cref] = cs
 	}
 	return connStateByRef, nil
 }
@@ -294,10 +387,13 @@ func (m *InterfaceManager) DisableUDevMonitor() {
 var (
 	udevInitRetryTimeout = time.Minute * 5
 	createUDevMonitor    = udevmonitor.New
+
+	expiredConnectionRetryTimeout = time.Minute
+	timeNow                       = time.Now
 )
 
 func (m *InterfaceManager) initUDevMonitor() error {
-	mon := createUDevMonitor(m.hotplugDeviceAdded, m.hotplugDeviceRemoved, m.hotplugEnumerationDone)
+	mon := createUDevMonitor(m.hotplugDeviceAdded, m.hotplugDeviceRemoved, m.hotplugDeviceChanged, m.hotplugEnumerationDone)
 	if err := mon.Connect(); err != nil {
 		return err
 	}
@@ -77,6 +77,9 @@ func findSymmetricAutoconnectTask(st *state.State, plugSnap, slotSnap string, in
 type connectOpts struct {
 	ByGadget    bool
 	AutoConnect bool
+	// Expiry, if set, is the time after which the connection is
+	// automatically disconnected.
+	Expiry time.Time
 }
 
 // Connect returns a set of tasks for connecting an interface.
@@ -89,6 +92,17 @@ func Connect(st *state.State, plugSnap, plugName, slotSnap, slotName string) (*s
 	return connect(st, plugSnap, plugName, slotSnap, slotName, connectOpts{})
 }
 
+// ConnectTemporarily returns a set of tasks for connecting an interface
+// until the given expiry time, after which the interface manager
+// disconnects it again.
+func ConnectTemporarily(st *state.State, plugSnap, plugName, slotSnap, slotName string, expiry time.Time) (*state.TaskSet, error) {
+	if err := snapstate.CheckChangeConflictMany(st, []string{plugSnap, slotSnap}, ""); err != nil {
+		return nil, err
+	}
+
+	return connect(st, plugSnap, plugName, slotSnap, slotName, connectOpts{Expiry: expiry})
+}
+
 func connect(st *state.State, plugSnap, plugName, slotSnap, slotName string, flags connectOpts) (*state.TaskSet, error) {
 	// TODO: Store the intent-to-connect in the state so that we automatically
 	// try to reconnect on reboot (reconnection can fail or can connect with
@@ -198,6 +212,9 @@ func connect(st *state.State, plugSnap, plugName, slotSnap, slotName string, fla
 	if flags.ByGadget {
 		connectInterface.Set("by-gadget", true)
 	}
+	if !flags.Expiry.IsZero() {
+		connectInterface.Set("expiry", flags.Expiry)
+	}
 
 	// Expose a copy of all plug and slot attributes coming from yaml to interface hooks. The hooks will be able
 	// to modify them but all attributes will be checked against assertions after the hooks are run.
@@ -53,6 +53,7 @@ import (
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 	"github.com/snapcore/snapd/timings"
 )
@@ -797,6 +798,131 @@ func (s *interfaceManagerSuite) TestAutoconnectDoesntConflictOnInstallingDiffere
 	c.Assert(auto, Equals, true)
 }
 
+func (s *interfaceManagerSuite) TestAutoConnectHooksDeferredWhilePreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	s.MockModel(c, nil)
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, `
+name: producerconsumer
+version: 1
+slots:
+ slot:
+  interface: test
+plugs:
+ plug:
+  interface: test
+hooks:
+ connect-plug-plug:
+ disconnect-plug-plug:
+ connect-slot-slot:
+ disconnect-slot-slot:
+`)
+	s.manager(c)
+
+	s.state.Lock()
+
+	sup := &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			Revision: snap.R(1),
+			RealName: "producerconsumer"},
+	}
+
+	// the tail of a preseeded seeding change
+	chg := s.state.NewChange("seed", "...")
+	t := s.state.NewTask("auto-connect", "...")
+	t.Set("snap-setup", sup)
+	chg.AddTask(t)
+	markPreseeded := s.state.NewTask("mark-preseeded", "...")
+	markPreseeded.WaitFor(t)
+	chg.AddTask(markPreseeded)
+	markSeeded := s.state.NewTask("mark-seeded", "...")
+	markSeeded.WaitFor(markPreseeded)
+	chg.AddTask(markSeeded)
+
+	s.state.Unlock()
+
+	for i := 0; i < 3; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the connection is made while preseeding
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	var connect *state.Task
+	var hooks []string
+	for _, tk := range chg.Tasks() {
+		switch tk.Kind() {
+		case "connect":
+			connect = tk
+		case "run-hook":
+			var hsup hookstate.HookSetup
+			c.Assert(tk.Get("hook-setup", &hsup), IsNil)
+			hooks = append(hooks, hsup.Hook)
+			// the hooks run on first boot, before seeding completes
+			c.Check(tk.Status(), Equals, state.DoStatus)
+			c.Check(tk.WaitTasks(), testutil.Contains, markPreseeded)
+			c.Check(markSeeded.WaitTasks(), testutil.Contains, tk)
+			// and do not hold back the preseeding
+			c.Check(markPreseeded.WaitTasks(), Not(testutil.Contains), tk)
+		}
+	}
+	c.Assert(connect, NotNil)
+	c.Check(connect.Status(), Equals, state.DoneStatus)
+	c.Check(hooks, DeepEquals, []string{"connect-slot-slot", "connect-plug-plug"})
+}
+
+func (s *interfaceManagerSuite) TestAutoConnectAllDeferredWithPrepareHooksWhilePreseeding(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	s.MockModel(c, nil)
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, selfconnectSnapYaml)
+	s.manager(c)
+
+	s.state.Lock()
+
+	sup := &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			Revision: snap.R(1),
+			RealName: "producerconsumer"},
+	}
+
+	chg := s.state.NewChange("seed", "...")
+	t := s.state.NewTask("auto-connect", "...")
+	t.Set("snap-setup", sup)
+	chg.AddTask(t)
+	markPreseeded := s.state.NewTask("mark-preseeded", "...")
+	markPreseeded.WaitFor(t)
+	chg.AddTask(markPreseeded)
+
+	s.state.Unlock()
+
+	for i := 0; i < 3; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	// the connection waits for its prepare hooks, on first boot
+	var connects int
+	for _, tk := range chg.Tasks() {
+		if tk.Kind() == "connect" {
+			connects++
+			c.Check(tk.Status(), Equals, state.DoStatus)
+		}
+	}
+	c.Check(connects, Equals, 1)
+}
+
 func (s *interfaceManagerSuite) createAutoconnectChange(c *C, conflictingTask *state.Task) error {
 	s.mockSnap(c, consumerYaml)
 	s.mockSnap(c, producerYaml)
@@ -2460,6 +2586,90 @@ slots:
 	c.Check(secBackend.SetupCalls, HasLen, 2)
 }
 
+func (s *interfaceManagerSuite) TestReloadingConnectionsRenegotiatesContentVersion(c *C) {
+	// Put connections in the state that were made when the producer only
+	// provided version "1" of the content.
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{
+			"interface":    "content",
+			"plug-static":  map[string]interface{}{"content": "foo", "versions": []interface{}{"1.."}},
+			"slot-static":  map[string]interface{}{"content": "foo", "version": "1"},
+			"plug-dynamic": map[string]interface{}{"content-version": "1"},
+		},
+		"consumer2:plug producer:slot": map[string]interface{}{
+			"interface":    "content",
+			"plug-static":  map[string]interface{}{"content": "foo", "versions": []interface{}{"1"}},
+			"slot-static":  map[string]interface{}{"content": "foo", "version": "1"},
+			"plug-dynamic": map[string]interface{}{"content-version": "1"},
+		},
+	})
+	s.state.Unlock()
+
+	// The producer now provides versions "2" and "3" of the content, the
+	// first consumer supports them and the second one does not.
+	const consumerYaml = `
+name: consumer
+version: 1
+plugs:
+ plug:
+  interface: content
+  content: foo
+  versions: ["1.."]
+`
+	const consumer2Yaml = `
+name: consumer2
+version: 1
+plugs:
+ plug:
+  interface: content
+  content: foo
+  versions: ["1"]
+`
+	const producerYaml = `
+name: producer
+version: 1
+slots:
+ slot:
+  interface: content
+  content: foo
+  versions: ["2", "3"]
+`
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, consumer2Yaml)
+
+	mgr := s.manager(c)
+
+	// The highest version supported by the plug is selected.
+	repo := mgr.Repository()
+	conn, err := repo.Connection(&interfaces.ConnRef{
+		PlugRef: interfaces.PlugRef{Snap: "consumer", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"}})
+	c.Assert(err, IsNil)
+	c.Check(conn.Plug.DynamicAttrs(), DeepEquals, map[string]interface{}{"content-version": "3"})
+
+	// The incompatible connection is not reloaded.
+	_, err = repo.Connection(&interfaces.ConnRef{
+		PlugRef: interfaces.PlugRef{Snap: "consumer2", Name: "plug"},
+		SlotRef: interfaces.SlotRef{Snap: "producer", Name: "slot"}})
+	c.Check(err, NotNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var conns map[string]interface{}
+	c.Assert(s.state.Get("conns", &conns), IsNil)
+	c.Check(conns["consumer:plug producer:slot"].(map[string]interface{})["plug-dynamic"], DeepEquals, map[string]interface{}{"content-version": "3"})
+	// The incompatible connection is removed from the state as well.
+	c.Check(conns, HasLen, 1)
+	c.Check(conns["consumer2:plug producer:slot"], IsNil)
+
+	// and a warning tells about it
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].String(), Matches, `removed content connection "consumer2:plug producer:slot", it is no longer compatible: .*`)
+}
+
 // LP:#1825883; make sure static attributes in conns state are updated from the snap yaml on snap refresh (content interface only)
 func (s *interfaceManagerSuite) testDoSetupProfilesUpdatesStaticAttributes(c *C, snapNameToSetup string) {
 	// Put a connection in the state. The connection binds the two snaps we are
@@ -3226,6 +3436,168 @@ func (s *interfaceManagerSuite) TestConnectTracksConnectionsInState(c *C) {
 	})
 }
 
+func (s *interfaceManagerSuite) TestConnectDisconnectNotify(c *C) {
+	s.MockModel(c, nil)
+
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	var updates []*ifacestate.ConnectionUpdate
+	s.state.AddObserver(func(n *state.Notification) {
+		if n.Type == "connection" {
+			updates = append(updates, n.Data.(*ifacestate.ConnectionUpdate))
+		}
+	})
+
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	ts.Tasks()[2].Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "consumer",
+		},
+	})
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	c.Assert(change.Err(), IsNil)
+	c.Check(updates, DeepEquals, []*ifacestate.ConnectionUpdate{
+		{Plug: "consumer:plug", Slot: "producer:slot", Interface: "test", Connected: true},
+	})
+
+	conn := s.getConnection(c, "consumer", "plug", "producer", "slot")
+	ts, err = ifacestate.Disconnect(s.state, conn)
+	c.Assert(err, IsNil)
+	ts.Tasks()[0].Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "consumer",
+		},
+	})
+	change = s.state.NewChange("disconnect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(change.Err(), IsNil)
+	c.Check(updates, DeepEquals, []*ifacestate.ConnectionUpdate{
+		{Plug: "consumer:plug", Slot: "producer:slot", Interface: "test", Connected: true},
+		{Plug: "consumer:plug", Slot: "producer:slot", Interface: "test", Connected: false},
+	})
+}
+
+func (s *interfaceManagerSuite) TestConnectDisconnectAudit(c *C) {
+	s.MockModel(c, nil)
+
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	_ = s.manager(c)
+
+	t0 := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	restore := ifacestate.MockTimeNow(func() time.Time { return t0 })
+	defer restore()
+
+	s.state.Lock()
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	ts.Tasks()[2].Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "consumer",
+		},
+	})
+	connectChg := s.state.NewChange("connect", "")
+	connectChg.AddAll(ts)
+	ifacestate.SetChangeInitiator(connectChg, &ifacestate.AuditInitiator{UID: 1000, Username: "kiosk-admin"})
+	s.state.Unlock()
+
+	s.settle(c)
+
+	restore = ifacestate.MockTimeNow(func() time.Time { return t0.Add(time.Hour) })
+	defer restore()
+
+	s.state.Lock()
+	c.Assert(connectChg.Err(), IsNil)
+	conn := s.getConnection(c, "consumer", "plug", "producer", "slot")
+	ts, err = ifacestate.Disconnect(s.state, conn)
+	c.Assert(err, IsNil)
+	ts.Tasks()[0].Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "consumer",
+		},
+	})
+	disconnectChg := s.state.NewChange("disconnect", "")
+	disconnectChg.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	c.Assert(disconnectChg.Err(), IsNil)
+	s.state.Unlock()
+
+	entries, err := ifacestate.AuditLog(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	c.Check(entries[0].Time.Equal(t0), Equals, true)
+	c.Check(entries[0].Action, Equals, "connect")
+	c.Check(entries[0].Interface, Equals, "test")
+	c.Check(entries[0].Plug, Equals, interfaces.PlugRef{Snap: "consumer", Name: "plug"})
+	c.Check(entries[0].Slot, Equals, interfaces.SlotRef{Snap: "producer", Name: "slot"})
+	c.Check(entries[0].Auto, Equals, false)
+	c.Check(entries[0].Change, Equals, connectChg.ID())
+	c.Check(entries[0].Initiator, DeepEquals, &ifacestate.AuditInitiator{UID: 1000, Username: "kiosk-admin"})
+
+	c.Check(entries[1].Action, Equals, "disconnect")
+	c.Check(entries[1].Interface, Equals, "test")
+	c.Check(entries[1].Change, Equals, disconnectChg.ID())
+	c.Check(entries[1].Initiator, IsNil)
+
+	// the log can be filtered
+	entries, err = ifacestate.AuditLog(&ifacestate.AuditFilter{Since: t0.Add(time.Minute)})
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Action, Equals, "disconnect")
+
+	entries, err = ifacestate.AuditLog(&ifacestate.AuditFilter{Snap: "producer", Interface: "test"})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+
+	entries, err = ifacestate.AuditLog(&ifacestate.AuditFilter{Snap: "other"})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+	entries, err = ifacestate.AuditLog(&ifacestate.AuditFilter{Interface: "test2"})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
+
+func (s *interfaceManagerSuite) TestAuditLogRotation(c *C) {
+	restore := ifacestate.MockMaxAuditLogSize(1)
+	defer restore()
+
+	for _, iface := range []string{"one", "two", "three"} {
+		c.Assert(ifacestate.AppendAuditEntry(&ifacestate.AuditEntry{Action: "connect", Interface: iface}), IsNil)
+	}
+
+	// only one rotated log is kept
+	entries, err := ifacestate.AuditLog(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].Interface, Equals, "two")
+	c.Check(entries[1].Interface, Equals, "three")
+}
+
 func (s *interfaceManagerSuite) TestConnectSetsUpSecurity(c *C) {
 	s.MockModel(c, nil)
 
@@ -5302,6 +5674,7 @@ type udevMonitorMock struct {
 	ConnectCalls, RunCalls, StopCalls, DisconnectCalls int
 	AddDevice                                          udevmonitor.DeviceAddedFunc
 	RemoveDevice                                       udevmonitor.DeviceRemovedFunc
+	ChangeDevice                                       udevmonitor.DeviceChangedFunc
 	EnumerationDone                                    udevmonitor.EnumerationDoneFunc
 }
 
@@ -5342,7 +5715,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInit(c *C) {
 	restoreTimeout := ifacestate.MockUDevInitRetryTimeout(0 * time.Second)
 	defer restoreTimeout()
 
-	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
+	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.DeviceChangedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
 		return &u
 	})
 	defer restoreCreate()
@@ -5350,6 +5723,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInit(c *C) {
 	mgr, err := ifacestate.Manager(s.state, nil, s.o.TaskRunner(), nil, nil)
 	c.Assert(err, IsNil)
 	s.o.AddManager(mgr)
+	s.o.AddManager(ifacestate.NewHotplugManager(mgr))
 	c.Assert(s.o.StartUp(), IsNil)
 
 	// succesfull initialization should result in exactly 1 connect and run call
@@ -5382,7 +5756,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInitErrors(c *C) {
 	restoreTimeout := ifacestate.MockUDevInitRetryTimeout(0 * time.Second)
 	defer restoreTimeout()
 
-	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
+	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.DeviceChangedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
 		return &u
 	})
 	defer restoreCreate()
@@ -5390,6 +5764,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInitErrors(c *C) {
 	mgr, err := ifacestate.Manager(s.state, nil, s.o.TaskRunner(), nil, nil)
 	c.Assert(err, IsNil)
 	s.o.AddManager(mgr)
+	s.o.AddManager(ifacestate.NewHotplugManager(mgr))
 	c.Assert(s.o.StartUp(), IsNil)
 
 	c.Assert(s.se.Ensure(), ErrorMatches, ".*Connect failed.*")
@@ -5418,7 +5793,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInitWaitsForCore(c *C) {
 	defer restoreTimeout()
 
 	var udevMonitorCreated bool
-	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
+	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.DeviceChangedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
 		udevMonitorCreated = true
 		return &udevMonitorMock{}
 	})
@@ -5427,6 +5802,7 @@ func (s *interfaceManagerSuite) TestUDevMonitorInitWaitsForCore(c *C) {
 	mgr, err := ifacestate.Manager(s.state, nil, s.o.TaskRunner(), nil, nil)
 	c.Assert(err, IsNil)
 	s.o.AddManager(mgr)
+	s.o.AddManager(ifacestate.NewHotplugManager(mgr))
 	c.Assert(s.o.StartUp(), IsNil)
 
 	for i := 0; i < 5; i++ {
@@ -5452,6 +5828,40 @@ func (s *interfaceManagerSuite) TestUDevMonitorInitWaitsForCore(c *C) {
 	c.Assert(udevMonitorCreated, Equals, true)
 }
 
+func (s *interfaceManagerSuite) TestUDevMonitorNeedsHotplugManager(c *C) {
+	st := s.state
+	st.Lock()
+	snapstate.Set(s.state, "core", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "core", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "os",
+	})
+	st.Unlock()
+
+	restoreTimeout := ifacestate.MockUDevInitRetryTimeout(0 * time.Second)
+	defer restoreTimeout()
+
+	restoreCreate := ifacestate.MockCreateUDevMonitor(func(udevmonitor.DeviceAddedFunc, udevmonitor.DeviceRemovedFunc, udevmonitor.DeviceChangedFunc, udevmonitor.EnumerationDoneFunc) udevmonitor.Interface {
+		c.Fatalf("unexpected udev monitor")
+		return nil
+	})
+	defer restoreCreate()
+
+	// hotplug support is left out
+	mgr, err := ifacestate.Manager(s.state, nil, s.o.TaskRunner(), nil, nil)
+	c.Assert(err, IsNil)
+	s.o.AddManager(mgr)
+	c.Assert(s.o.StartUp(), IsNil)
+
+	for i := 0; i < 5; i++ {
+		c.Assert(s.se.Ensure(), IsNil)
+	}
+	s.se.Stop()
+}
+
 func (s *interfaceManagerSuite) TestAttributesRestoredFromConns(c *C) {
 	slotSnap := s.mockSnap(c, producer2Yaml)
 	plugSnap := s.mockSnap(c, consumerYaml)
@@ -6758,3 +7168,128 @@ func (s *interfaceManagerSuite) TestTransitionConnectionsCoreMigration(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(repoConns, HasLen, 0)
 }
+
+func (s *interfaceManagerSuite) TestConnectTemporarily(c *C) {
+	s.MockModel(c, nil)
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	_ = s.manager(c)
+
+	expiry := time.Now().Add(time.Hour).UTC()
+
+	s.state.Lock()
+	ts, err := ifacestate.ConnectTemporarily(s.state, "consumer", "plug", "producer", "slot", expiry)
+	c.Assert(err, IsNil)
+	task := findKind(ts, "connect")
+	c.Assert(task, NotNil)
+	var taskExpiry time.Time
+	c.Assert(task.Get("expiry", &taskExpiry), IsNil)
+	c.Check(taskExpiry.Equal(expiry), Equals, true)
+
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	c.Assert(change.Err(), IsNil)
+	c.Check(change.Status(), Equals, state.DoneStatus)
+
+	var conns map[string]map[string]interface{}
+	c.Assert(s.state.Get("conns", &conns), IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns["consumer:plug producer:slot"]["expiry"], Equals, expiry.Format(time.RFC3339Nano))
+	s.state.Unlock()
+
+	// the connection has not expired yet, so it is left alone
+	c.Assert(s.manager(c).Ensure(), IsNil)
+	s.state.Lock()
+	c.Check(s.state.Changes(), HasLen, 1)
+	s.state.Unlock()
+
+	cstates, err := s.manager(c).ConnectionStates()
+	c.Assert(err, IsNil)
+	c.Check(cstates["consumer:plug producer:slot"].Expiry.Equal(expiry), Equals, true)
+}
+
+func (s *interfaceManagerSuite) TestEnsureDisconnectsExpiredConnections(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, consumer2Yaml)
+	s.mockSnap(c, producerYaml)
+
+	now := time.Date(2019, 10, 16, 12, 0, 0, 0, time.UTC)
+	restore := ifacestate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{
+			"interface": "test",
+			"expiry":    now.Add(-time.Minute).Format(time.RFC3339),
+		},
+		"consumer2:plug producer:slot": map[string]interface{}{
+			"interface": "test",
+			"expiry":    now.Add(time.Minute).Format(time.RFC3339),
+		},
+	})
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+	c.Assert(mgr.Ensure(), IsNil)
+
+	s.state.Lock()
+	changes := s.state.Changes()
+	c.Assert(changes, HasLen, 1)
+	chg := changes[0]
+	c.Check(chg.Kind(), Equals, "disconnect")
+	c.Check(chg.Summary(), Equals, "Disconnect expired connection consumer:plug from producer:slot")
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(chg.Err(), IsNil)
+	var conns map[string]interface{}
+	c.Assert(s.state.Get("conns", &conns), IsNil)
+	c.Check(conns, HasLen, 1)
+	c.Check(conns["consumer2:plug producer:slot"], NotNil)
+}
+
+func (s *interfaceManagerSuite) TestEnsureExpiredConnectionsConflict(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{
+			"interface": "test",
+			"expiry":    time.Now().Add(-time.Minute).Format(time.RFC3339),
+		},
+	})
+	chg := s.state.NewChange("other", "...")
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "producer"},
+	})
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	mgr := s.manager(c)
+	c.Assert(mgr.Ensure(), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the disconnect is left for later
+	c.Check(s.state.Changes(), HasLen, 1)
+	var conns map[string]interface{}
+	c.Assert(s.state.Get("conns", &conns), IsNil)
+	c.Check(conns, HasLen, 1)
+}
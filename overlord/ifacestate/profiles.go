@@ -0,0 +1,278 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// ConnectionProfile is a named set of connections that are made, and
+// of connections that are broken, together in a single change when
+// the profile is applied. Reverting the profile does the opposite.
+type ConnectionProfile struct {
+	Name       string
+	Connect    []*interfaces.ConnRef
+	Disconnect []*interfaces.ConnRef
+}
+
+// profileState is how a connection profile is kept in the state, with
+// connections in the same form as the keys of "conns".
+type profileState struct {
+	Connect    []string `json:"connect,omitempty"`
+	Disconnect []string `json:"disconnect,omitempty"`
+}
+
+// ErrNoConnectionProfile is returned when a connection profile is not
+// defined.
+type ErrNoConnectionProfile struct {
+	Name string
+}
+
+func (e *ErrNoConnectionProfile) Error() string {
+	return fmt.Sprintf("no connection profile named %q", e.Name)
+}
+
+var validProfileName = regexp.MustCompile("^[a-z0-9](?:-?[a-z0-9])*$")
+
+func validateConnectionProfile(profile *ConnectionProfile) error {
+	if !validProfileName.MatchString(profile.Name) {
+		return fmt.Errorf("invalid connection profile name %q", profile.Name)
+	}
+	if len(profile.Connect) == 0 && len(profile.Disconnect) == 0 {
+		return fmt.Errorf("connection profile %q has no connections", profile.Name)
+	}
+	seen := make(map[string]bool, len(profile.Connect)+len(profile.Disconnect))
+	for _, refs := range [][]*interfaces.ConnRef{profile.Connect, profile.Disconnect} {
+		for _, ref := range refs {
+			if ref.PlugRef.Snap == "" || ref.PlugRef.Name == "" || ref.SlotRef.Snap == "" || ref.SlotRef.Name == "" {
+				return fmt.Errorf("connection profile %q has incomplete connection %q", profile.Name, ref.ID())
+			}
+			if seen[ref.ID()] {
+				return fmt.Errorf("connection profile %q lists connection %q more than once", profile.Name, ref.ID())
+			}
+			seen[ref.ID()] = true
+		}
+	}
+	return nil
+}
+
+func parseConnRefs(refs []string) ([]*interfaces.ConnRef, error) {
+	var crefs []*interfaces.ConnRef
+	for _, id := range refs {
+		cref, err := interfaces.ParseConnRef(id)
+		if err != nil {
+			return nil, err
+		}
+		cref.PlugRef.Snap = RemapSnapFromState(cref.PlugRef.Snap)
+		cref.SlotRef.Snap = RemapSnapFromState(cref.SlotRef.Snap)
+		crefs = append(crefs, cref)
+	}
+	return crefs, nil
+}
+
+func connRefIDs(crefs []*interfaces.ConnRef) []string {
+	var ids []string
+	for _, cref := range crefs {
+		remapped := *cref
+		remapped.PlugRef.Snap = RemapSnapToState(cref.PlugRef.Snap)
+		remapped.SlotRef.Snap = RemapSnapToState(cref.SlotRef.Snap)
+		ids = append(ids, remapped.ID())
+	}
+	return ids
+}
+
+func getProfiles(st *state.State) (map[string]*profileState, error) {
+	var profiles map[string]*profileState
+	err := st.Get("connection-profiles", &profiles)
+	if err != nil && err != state.ErrNoState {
+		return nil, fmt.Errorf("cannot obtain connection profiles: %v", err)
+	}
+	if profiles == nil {
+		profiles = make(map[string]*profileState)
+	}
+	return profiles, nil
+}
+
+// ConnectionProfiles returns the connection profiles defined in the
+// system, sorted by name.
+func ConnectionProfiles(st *state.State) ([]*ConnectionProfile, error) {
+	profiles, err := getProfiles(st)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*ConnectionProfile, 0, len(names))
+	for _, name := range names {
+		profile, err := GetConnectionProfile(st, name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, profile)
+	}
+	return result, nil
+}
+
+// GetConnectionProfile returns the connection profile with the given
+// name.
+func GetConnectionProfile(st *state.State, name string) (*ConnectionProfile, error) {
+	profiles, err := getProfiles(st)
+	if err != nil {
+		return nil, err
+	}
+	pstate, ok := profiles[name]
+	if !ok {
+		return nil, &ErrNoConnectionProfile{Name: name}
+	}
+	profile := &ConnectionProfile{Name: name}
+	if profile.Connect, err = parseConnRefs(pstate.Connect); err != nil {
+		return nil, fmt.Errorf("cannot obtain connection profile %q: %v", name, err)
+	}
+	if profile.Disconnect, err = parseConnRefs(pstate.Disconnect); err != nil {
+		return nil, fmt.Errorf("cannot obtain connection profile %q: %v", name, err)
+	}
+	return profile, nil
+}
+
+// SetConnectionProfile defines the given connection profile, replacing
+// any profile with the same name.
+func SetConnectionProfile(st *state.State, profile *ConnectionProfile) error {
+	if err := validateConnectionProfile(profile); err != nil {
+		return err
+	}
+	profiles, err := getProfiles(st)
+	if err != nil {
+		return err
+	}
+	profiles[profile.Name] = &profileState{
+		Connect:    connRefIDs(profile.Connect),
+		Disconnect: connRefIDs(profile.Disconnect),
+	}
+	st.Set("connection-profiles", profiles)
+	return nil
+}
+
+// RemoveConnectionProfile removes the connection profile with the given
+// name.
+func RemoveConnectionProfile(st *state.State, name string) error {
+	profiles, err := getProfiles(st)
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles[name]; !ok {
+		return &ErrNoConnectionProfile{Name: name}
+	}
+	delete(profiles, name)
+	st.Set("connection-profiles", profiles)
+	return nil
+}
+
+func profileSnaps(profile *ConnectionProfile) []string {
+	m := make(map[string]bool)
+	for _, refs := range [][]*interfaces.ConnRef{profile.Connect, profile.Disconnect} {
+		for _, ref := range refs {
+			m[ref.PlugRef.Snap] = true
+			m[ref.SlotRef.Snap] = true
+		}
+	}
+	snaps := make([]string, 0, len(m))
+	for name := range m {
+		snaps = append(snaps, name)
+	}
+	sort.Strings(snaps)
+	return snaps
+}
+
+// ApplyConnectionProfile returns the tasks for making the connections
+// of the named profile and for breaking the ones it disconnects, or the
+// other way around when reverting it. Connections that are already in
+// the wanted state are left alone. All the tasks are in the same lane,
+// so that when any of them fails all the others are undone and the
+// profile is applied atomically. The snaps
+// that are affected are returned as well.
+func ApplyConnectionProfile(st *state.State, name string, revert bool) (tasksets []*state.TaskSet, affected []string, err error) {
+	profile, err := GetConnectionProfile(st, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	affected = profileSnaps(profile)
+	if err := snapstate.CheckChangeConflictMany(st, affected, ""); err != nil {
+		return nil, nil, err
+	}
+
+	toConnect, toDisconnect := profile.Connect, profile.Disconnect
+	if revert {
+		toConnect, toDisconnect = toDisconnect, toConnect
+	}
+
+	conns, err := getConns(st)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo := ifacerepo.Get(st)
+	lane := st.NewLane()
+	// connections are broken before the new ones are made, one after
+	// the other, as they may involve the same plugs and slots
+	addTaskSet := func(ts *state.TaskSet) {
+		if len(tasksets) > 0 {
+			ts.WaitAll(tasksets[len(tasksets)-1])
+		}
+		ts.JoinLane(lane)
+		tasksets = append(tasksets, ts)
+	}
+	for _, ref := range toDisconnect {
+		if cstate, ok := conns[ref.ID()]; !ok || cstate.Undesired || cstate.HotplugGone {
+			continue
+		}
+		conn, err := repo.Connection(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		ts, err := disconnectTasks(st, conn, disconnectOpts{})
+		if err != nil {
+			return nil, nil, err
+		}
+		addTaskSet(ts)
+	}
+	for _, ref := range toConnect {
+		ts, err := connect(st, ref.PlugRef.Snap, ref.PlugRef.Name, ref.SlotRef.Snap, ref.SlotRef.Name, connectOpts{})
+		if _, ok := err.(*ErrAlreadyConnected); ok {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		addTaskSet(ts)
+	}
+	return tasksets, affected, nil
+}
@@ -0,0 +1,217 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ifacestate_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
+	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func connRef(c *C, id string) *interfaces.ConnRef {
+	cref, err := interfaces.ParseConnRef(id)
+	c.Assert(err, IsNil)
+	return cref
+}
+
+func (s *interfaceManagerSuite) TestConnectionProfilesRoundtrip(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	profiles, err := ifacestate.ConnectionProfiles(s.state)
+	c.Assert(err, IsNil)
+	c.Check(profiles, HasLen, 0)
+
+	kiosk := &ifacestate.ConnectionProfile{
+		Name:       "kiosk",
+		Connect:    []*interfaces.ConnRef{connRef(c, "consumer:plug producer:slot")},
+		Disconnect: []*interfaces.ConnRef{connRef(c, "consumer:otherplug producer:slot")},
+	}
+	maintenance := &ifacestate.ConnectionProfile{
+		Name:    "maintenance",
+		Connect: []*interfaces.ConnRef{connRef(c, "consumer:otherplug producer:slot")},
+	}
+	c.Assert(ifacestate.SetConnectionProfile(s.state, maintenance), IsNil)
+	c.Assert(ifacestate.SetConnectionProfile(s.state, kiosk), IsNil)
+
+	profiles, err = ifacestate.ConnectionProfiles(s.state)
+	c.Assert(err, IsNil)
+	c.Check(profiles, DeepEquals, []*ifacestate.ConnectionProfile{kiosk, maintenance})
+
+	var raw map[string]interface{}
+	c.Assert(s.state.Get("connection-profiles", &raw), IsNil)
+	c.Check(raw["kiosk"], DeepEquals, map[string]interface{}{
+		"connect":    []interface{}{"consumer:plug producer:slot"},
+		"disconnect": []interface{}{"consumer:otherplug producer:slot"},
+	})
+
+	c.Assert(ifacestate.RemoveConnectionProfile(s.state, "kiosk"), IsNil)
+	_, err = ifacestate.GetConnectionProfile(s.state, "kiosk")
+	c.Check(err, FitsTypeOf, &ifacestate.ErrNoConnectionProfile{})
+	c.Check(err, ErrorMatches, `no connection profile named "kiosk"`)
+
+	err = ifacestate.RemoveConnectionProfile(s.state, "kiosk")
+	c.Check(err, ErrorMatches, `no connection profile named "kiosk"`)
+}
+
+func (s *interfaceManagerSuite) TestSetConnectionProfileValidation(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	cref := connRef(c, "consumer:plug producer:slot")
+	for _, t := range []struct {
+		profile *ifacestate.ConnectionProfile
+		err     string
+	}{
+		{&ifacestate.ConnectionProfile{Name: "Kiosk", Connect: []*interfaces.ConnRef{cref}}, `invalid connection profile name "Kiosk"`},
+		{&ifacestate.ConnectionProfile{Name: "kiosk-", Connect: []*interfaces.ConnRef{cref}}, `invalid connection profile name "kiosk-"`},
+		{&ifacestate.ConnectionProfile{Name: "kiosk"}, `connection profile "kiosk" has no connections`},
+		{&ifacestate.ConnectionProfile{Name: "kiosk", Connect: []*interfaces.ConnRef{{PlugRef: interfaces.PlugRef{Snap: "consumer"}, SlotRef: cref.SlotRef}}}, `connection profile "kiosk" has incomplete connection "consumer: producer:slot"`},
+		{&ifacestate.ConnectionProfile{Name: "kiosk", Connect: []*interfaces.ConnRef{cref}, Disconnect: []*interfaces.ConnRef{cref}}, `connection profile "kiosk" lists connection "consumer:plug producer:slot" more than once`},
+	} {
+		c.Check(ifacestate.SetConnectionProfile(s.state, t.profile), ErrorMatches, t.err)
+	}
+}
+
+func (s *interfaceManagerSuite) TestApplyConnectionProfile(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+	s.mockSnap(c, consumer2Yaml)
+
+	s.state.Lock()
+	s.state.Set("conns", map[string]interface{}{
+		"consumer:plug producer:slot": map[string]interface{}{"interface": "test"},
+	})
+	s.state.Unlock()
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(ifacestate.SetConnectionProfile(s.state, &ifacestate.ConnectionProfile{
+		Name:       "kiosk",
+		Connect:    []*interfaces.ConnRef{connRef(c, "consumer2:plug producer:slot")},
+		Disconnect: []*interfaces.ConnRef{connRef(c, "consumer:plug producer:slot")},
+	}), IsNil)
+
+	tss, affected, err := ifacestate.ApplyConnectionProfile(s.state, "kiosk", false)
+	c.Assert(err, IsNil)
+	c.Check(affected, DeepEquals, []string{"consumer", "consumer2", "producer"})
+	c.Assert(tss, HasLen, 2)
+
+	lanes := tss[0].Tasks()[0].Lanes()
+	c.Assert(lanes, HasLen, 1)
+	var kinds []string
+	for _, ts := range tss {
+		for _, t := range ts.Tasks() {
+			c.Check(t.Lanes(), DeepEquals, lanes)
+			kinds = append(kinds, t.Kind())
+		}
+	}
+	c.Check(kinds, testutil.DeepContains, "disconnect")
+	c.Check(kinds, testutil.DeepContains, "connect")
+
+	// the disconnection comes first
+	var plug interfaces.PlugRef
+	disconnect := findKind(tss[0], "disconnect")
+	c.Assert(disconnect, NotNil)
+	c.Assert(disconnect.Get("plug", &plug), IsNil)
+	c.Check(plug, Equals, interfaces.PlugRef{Snap: "consumer", Name: "plug"})
+	connect := findKind(tss[1], "connect")
+	c.Assert(connect, NotNil)
+	c.Assert(connect.Get("plug", &plug), IsNil)
+	c.Check(plug, Equals, interfaces.PlugRef{Snap: "consumer2", Name: "plug"})
+	c.Check(tss[1].Tasks()[0].WaitTasks(), Not(HasLen), 0)
+}
+
+func (s *interfaceManagerSuite) TestRevertConnectionProfile(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(ifacestate.SetConnectionProfile(s.state, &ifacestate.ConnectionProfile{
+		Name:       "kiosk",
+		Disconnect: []*interfaces.ConnRef{connRef(c, "consumer:plug producer:slot")},
+	}), IsNil)
+
+	// applying it has nothing to do, the connection is not there
+	tss, _, err := ifacestate.ApplyConnectionProfile(s.state, "kiosk", false)
+	c.Assert(err, IsNil)
+	c.Check(tss, HasLen, 0)
+
+	// reverting it makes the connection
+	tss, affected, err := ifacestate.ApplyConnectionProfile(s.state, "kiosk", true)
+	c.Assert(err, IsNil)
+	c.Check(affected, DeepEquals, []string{"consumer", "producer"})
+	c.Assert(tss, HasLen, 1)
+	c.Check(findKind(tss[0], "connect"), NotNil)
+}
+
+func (s *interfaceManagerSuite) TestApplyConnectionProfileErrors(c *C) {
+	s.mockIfaces(c, &ifacetest.TestInterface{InterfaceName: "test"}, &ifacetest.TestInterface{InterfaceName: "test2"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	_ = s.manager(c)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, _, err := ifacestate.ApplyConnectionProfile(s.state, "kiosk", false)
+	c.Check(err, ErrorMatches, `no connection profile named "kiosk"`)
+
+	c.Assert(ifacestate.SetConnectionProfile(s.state, &ifacestate.ConnectionProfile{
+		Name:    "kiosk",
+		Connect: []*interfaces.ConnRef{connRef(c, "consumer:plug producer:slot")},
+	}), IsNil)
+
+	chg := s.state.NewChange("other", "...")
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "producer"},
+	})
+	chg.AddTask(t)
+
+	_, _, err = ifacestate.ApplyConnectionProfile(s.state, "kiosk", false)
+	c.Check(err, ErrorMatches, `snap "producer" has "other" change in progress`)
+}
+
+func findKind(ts *state.TaskSet, kind string) *state.Task {
+	for _, t := range ts.Tasks() {
+		if t.Kind() == kind {
+			return t
+		}
+	}
+	return nil
+}
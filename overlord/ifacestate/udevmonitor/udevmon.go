@@ -38,6 +38,7 @@ type Interface interface {
 
 type DeviceAddedFunc func(device *hotplug.HotplugDeviceInfo)
 type DeviceRemovedFunc func(device *hotplug.HotplugDeviceInfo)
+type DeviceChangedFunc func(old, device *hotplug.HotplugDeviceInfo)
 type EnumerationDoneFunc func()
 
 // Monitor monitors kernel uevents making it possible to find hotpluggable devices.
@@ -45,6 +46,7 @@ type Monitor struct {
 	tomb            tomb.Tomb
 	deviceAdded     DeviceAddedFunc
 	deviceRemoved   DeviceRemovedFunc
+	deviceChanged   DeviceChangedFunc
 	enumerationDone func()
 	netlinkConn     *netlink.UEventConn
 	// channels used by netlink connection and monitor
@@ -59,16 +61,19 @@ type Monitor struct {
 	// guaranteed to be unique and stable till device gets
 	// removed.  the lookup is not persisted and gets populated
 	// and updated in response to enumeration and hotplug events.
-	seen map[string]bool
+	// the last known information about every device is kept so
+	// that change events can be reported along with it.
+	seen map[string]*hotplug.HotplugDeviceInfo
 }
 
-func New(added DeviceAddedFunc, removed DeviceRemovedFunc, enumerationDone EnumerationDoneFunc) Interface {
+func New(added DeviceAddedFunc, removed DeviceRemovedFunc, changed DeviceChangedFunc, enumerationDone EnumerationDoneFunc) Interface {
 	m := &Monitor{
 		deviceAdded:     added,
 		deviceRemoved:   removed,
+		deviceChanged:   changed,
 		enumerationDone: enumerationDone,
 		netlinkConn:     &netlink.UEventConn{},
-		seen:            make(map[string]bool),
+		seen:            make(map[string]*hotplug.HotplugDeviceInfo),
 	}
 
 	m.netlinkEvents = make(chan netlink.UEvent)
@@ -113,7 +118,7 @@ func (m *Monitor) Disconnect() error {
 }
 
 // Run enumerates existing USB devices and starts a new goroutine that
-// handles hotplug events (devices added or removed). It returns immediately.
+// handles hotplug events (devices added, removed or changed). It returns immediately.
 // The goroutine must be stopped by calling Stop() method.
 func (m *Monitor) Run() error {
 	// Gather devices from udevadm info output (enumeration on startup).
@@ -127,10 +132,10 @@ func (m *Monitor) Run() error {
 		}
 		for _, dev := range devices {
 			devPath := dev.DevicePath()
-			if m.seen[devPath] {
+			if m.seen[devPath] != nil {
 				continue
 			}
-			m.seen[devPath] = true
+			m.seen[devPath] = dev
 			if m.deviceAdded != nil {
 				m.deviceAdded(dev)
 			}
@@ -168,6 +173,8 @@ func (m *Monitor) udevEvent(ev *netlink.UEvent) {
 		m.addDevice(ev.KObj, ev.Env)
 	case netlink.REMOVE:
 		m.removeDevice(ev.KObj, ev.Env)
+	case netlink.CHANGE:
+		m.changeDevice(ev.KObj, ev.Env)
 	default:
 	}
 }
@@ -178,10 +185,10 @@ func (m *Monitor) addDevice(kobj string, env map[string]string) {
 		return
 	}
 	devPath := dev.DevicePath()
-	if m.seen[devPath] {
+	if m.seen[devPath] != nil {
 		return
 	}
-	m.seen[devPath] = true
+	m.seen[devPath] = dev
 	if m.deviceAdded != nil {
 		m.deviceAdded(dev)
 	}
@@ -193,7 +200,7 @@ func (m *Monitor) removeDevice(kobj string, env map[string]string) {
 		return
 	}
 	devPath := dev.DevicePath()
-	if !m.seen[devPath] {
+	if m.seen[devPath] == nil {
 		logger.Debugf("udev monitor observed remove event for unknown device %s", dev)
 		return
 	}
@@ -202,3 +209,20 @@ func (m *Monitor) removeDevice(kobj string, env map[string]string) {
 		m.deviceRemoved(dev)
 	}
 }
+
+func (m *Monitor) changeDevice(kobj string, env map[string]string) {
+	dev, err := hotplug.NewHotplugDeviceInfo(env)
+	if err != nil {
+		return
+	}
+	devPath := dev.DevicePath()
+	old := m.seen[devPath]
+	if old == nil {
+		logger.Debugf("udev monitor observed change event for unknown device %s", dev)
+		return
+	}
+	m.seen[devPath] = dev
+	if m.deviceChanged != nil {
+		m.deviceChanged(old, dev)
+	}
+}
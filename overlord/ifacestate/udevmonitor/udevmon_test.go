@@ -38,7 +38,7 @@ type udevMonitorSuite struct{}
 var _ = Suite(&udevMonitorSuite{})
 
 func (s *udevMonitorSuite) TestSmoke(c *C) {
-	mon := udevmonitor.New(nil, nil, nil)
+	mon := udevmonitor.New(nil, nil, nil, nil)
 	c.Assert(mon, NotNil)
 	c.Assert(mon.Connect(), IsNil)
 	c.Assert(mon.Run(), IsNil)
@@ -94,7 +94,7 @@ __END__
 `)
 	defer cmd.Restore()
 
-	udevmon := udevmonitor.New(added, removed, enumerationFinished).(*udevmonitor.Monitor)
+	udevmon := udevmonitor.New(added, removed, nil, enumerationFinished).(*udevmonitor.Monitor)
 	events := udevmon.EventsChannel()
 
 	c.Assert(udevmon.Run(), IsNil)
@@ -194,3 +194,56 @@ Loop:
 	c.Assert(remInfo.Major(), Equals, "0")
 	c.Assert(remInfo.Minor(), Equals, "3")
 }
+
+func (s *udevMonitorSuite) TestChange(c *C) {
+	type change struct {
+		old, dev *hotplug.HotplugDeviceInfo
+	}
+	var changes []change
+	changed := func(old, inf *hotplug.HotplugDeviceInfo) {
+		changes = append(changes, change{old: old, dev: inf})
+	}
+
+	// events are handled directly, without running the monitor
+	// goroutine which would close the never connected netlink socket
+	udevmon := udevmonitor.New(nil, nil, changed, nil).(*udevmonitor.Monitor)
+
+	// change of an unknown device is ignored
+	udevmon.UdevEvent(&netlink.UEvent{
+		Action: netlink.CHANGE,
+		KObj:   "foo",
+		Env: map[string]string{
+			"DEVPATH":   "other",
+			"SUBSYSTEM": "tty",
+		},
+	})
+	udevmon.UdevEvent(&netlink.UEvent{
+		Action: netlink.ADD,
+		KObj:   "foo",
+		Env: map[string]string{
+			"DEVPATH":   "abc",
+			"SUBSYSTEM": "tty",
+			"DEVNAME":   "ttyUSB0",
+			"ID_SERIAL": "one",
+		},
+	})
+	c.Assert(changes, HasLen, 0)
+	udevmon.UdevEvent(&netlink.UEvent{
+		Action: netlink.CHANGE,
+		KObj:   "foo",
+		Env: map[string]string{
+			"DEVPATH":   "abc",
+			"SUBSYSTEM": "tty",
+			"DEVNAME":   "ttyUSB0",
+			"ID_SERIAL": "two",
+		},
+	})
+
+	c.Assert(changes, HasLen, 1)
+	c.Check(changes[0].old.DevicePath(), Equals, "/sys/abc")
+	serial, _ := changes[0].old.Attribute("ID_SERIAL")
+	c.Check(serial, Equals, "one")
+	c.Check(changes[0].dev.DevicePath(), Equals, "/sys/abc")
+	serial, _ = changes[0].dev.Attribute("ID_SERIAL")
+	c.Check(serial, Equals, "two")
+}
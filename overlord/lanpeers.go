@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord
+
+import (
+	"github.com/snapcore/snapd/features"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// lanPeersService is the part of lanpeers.Service used here.
+type lanPeersService interface {
+	Start(iface string) error
+	Stop() error
+}
+
+// lanPeersManager starts and stops sharing downloaded snaps with the
+// local network as the lan-download-peers feature is turned on and off.
+// Snaps are only shared on the network interface set with
+// store.lan-peers.interface, never on all of them.
+type lanPeersManager struct {
+	state   *state.State
+	service lanPeersService
+}
+
+func (m *lanPeersManager) Ensure() error {
+	m.state.Lock()
+	tr := config.NewTransaction(m.state)
+	enabled, err := config.GetFeatureFlag(tr, features.LANDownloadPeers)
+	if err != nil {
+		m.state.Unlock()
+		return err
+	}
+	var iface string
+	err = tr.GetMaybe("core", "store.lan-peers.interface", &iface)
+	m.state.Unlock()
+	if err != nil {
+		return err
+	}
+	if !enabled || iface == "" {
+		return m.service.Stop()
+	}
+	if err := m.service.Start(iface); err != nil {
+		// not fatal, snaps are then just downloaded from the store
+		logger.Noticef("cannot share downloaded snaps with the local network: %v", err)
+	}
+	return nil
+}
+
+func (m *lanPeersManager) Stop() {
+	if err := m.service.Stop(); err != nil {
+		logger.Noticef("cannot stop sharing downloaded snaps with the local network: %v", err)
+	}
+}
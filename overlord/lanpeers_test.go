@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord_test
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type lanPeersSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&lanPeersSuite{})
+
+func (s *lanPeersSuite) SetUpTest(c *C) {
+	s.state = state.New(nil)
+}
+
+type fakeLANPeersService struct {
+	calls    []string
+	startErr error
+}
+
+func (f *fakeLANPeersService) Start(iface string) error {
+	f.calls = append(f.calls, "start:"+iface)
+	return f.startErr
+}
+
+func (f *fakeLANPeersService) Stop() error {
+	f.calls = append(f.calls, "stop")
+	return nil
+}
+
+func (s *lanPeersSuite) setConf(c *C, key string, value interface{}) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", key, value), IsNil)
+	tr.Commit()
+}
+
+func (s *lanPeersSuite) setFeature(c *C, value interface{}) {
+	s.setConf(c, "experimental.lan-download-peers", value)
+}
+
+func (s *lanPeersSuite) TestEnsureFollowsFeature(c *C) {
+	svc := &fakeLANPeersService{}
+	mgr := overlord.NewLANPeersManager(s.state, svc)
+
+	// disabled by default
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"stop"})
+
+	s.setConf(c, "store.lan-peers.interface", "eth0")
+	s.setFeature(c, true)
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"stop", "start:eth0"})
+
+	s.setFeature(c, false)
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"stop", "start:eth0", "stop"})
+
+	svc.calls = nil
+	mgr.(overlord.StateStopper).Stop()
+	c.Check(svc.calls, DeepEquals, []string{"stop"})
+}
+
+func (s *lanPeersSuite) TestEnsureStartErrorNotFatal(c *C) {
+	svc := &fakeLANPeersService{startErr: errors.New("no multicast here")}
+	mgr := overlord.NewLANPeersManager(s.state, svc)
+
+	s.setConf(c, "store.lan-peers.interface", "eth0")
+	s.setFeature(c, true)
+	c.Check(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"start:eth0"})
+}
+
+func (s *lanPeersSuite) TestEnsureNeedsInterface(c *C) {
+	svc := &fakeLANPeersService{}
+	mgr := overlord.NewLANPeersManager(s.state, svc)
+
+	// snaps are never shared on all the interfaces
+	s.setFeature(c, true)
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"stop"})
+
+	s.setConf(c, "store.lan-peers.interface", "wlan0")
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(svc.calls, DeepEquals, []string{"stop", "start:wlan0"})
+}
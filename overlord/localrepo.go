@@ -0,0 +1,50 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord
+
+import (
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// localRepository is the part of store.LocalRepository used here.
+type localRepository interface {
+	SetDir(dir string)
+}
+
+// localRepoManager points the stores at the local repository configured
+// with the store.local-repository system option.
+type localRepoManager struct {
+	state *state.State
+	repo  localRepository
+}
+
+func (m *localRepoManager) Ensure() error {
+	m.state.Lock()
+	tr := config.NewTransaction(m.state)
+	var dir string
+	err := tr.GetMaybe("core", "store.local-repository", &dir)
+	m.state.Unlock()
+	if err != nil {
+		return err
+	}
+	m.repo.SetDir(dir)
+	return nil
+}
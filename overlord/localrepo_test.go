@@ -0,0 +1,64 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type localRepoSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&localRepoSuite{})
+
+func (s *localRepoSuite) SetUpTest(c *C) {
+	s.state = state.New(nil)
+}
+
+type fakeLocalRepository struct {
+	dirs []string
+}
+
+func (f *fakeLocalRepository) SetDir(dir string) {
+	f.dirs = append(f.dirs, dir)
+}
+
+func (s *localRepoSuite) TestEnsureFollowsConfig(c *C) {
+	repo := &fakeLocalRepository{}
+	mgr := overlord.NewLocalRepoManager(s.state, repo)
+
+	// unset by default
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(repo.dirs, DeepEquals, []string{""})
+
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "store.local-repository", "/media/usb/snaps"), IsNil)
+	tr.Commit()
+	s.state.Unlock()
+
+	c.Assert(mgr.Ensure(), IsNil)
+	c.Check(repo.dirs, DeepEquals, []string{"", "/media/usb/snaps"})
+}
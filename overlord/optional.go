@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OptionalManagerFactory creates the state manager of an optional
+// subsystem. It is given the overlord once all its other managers are
+// created, so that the subsystem can build on them.
+type OptionalManagerFactory func(o *Overlord) (StateManager, error)
+
+type optionalManager struct {
+	capability string
+	factory    OptionalManagerFactory
+}
+
+var optionalManagers []optionalManager
+
+// RegisterOptionalManager registers the factory of the state manager
+// of an optional subsystem, which New will then create and add,
+// advertising the given capability. It is meant to be called from
+// init functions of files built only with the build tags enabling the
+// subsystem, so that it can be left out of snapd without changing New.
+// It returns a function to unregister the factory.
+func RegisterOptionalManager(capability string, factory OptionalManagerFactory) (unregister func()) {
+	for _, om := range optionalManagers {
+		if om.capability == capability {
+			panic(fmt.Sprintf("internal error: optional manager for capability %q already registered", capability))
+		}
+	}
+	optionalManagers = append(optionalManagers, optionalManager{
+		capability: capability,
+		factory:    factory,
+	})
+	return func() {
+		for i, om := range optionalManagers {
+			if om.capability == capability {
+				optionalManagers = append(optionalManagers[:i:i], optionalManagers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (o *Overlord) addOptionalManagers() error {
+	for _, om := range optionalManagers {
+		mgr, err := om.factory(o)
+		if err != nil {
+			return fmt.Errorf("cannot create optional manager for %q: %v", om.capability, err)
+		}
+		o.addOptionalManager(om.capability, mgr)
+	}
+	return nil
+}
+
+func (o *Overlord) addOptionalManager(capability string, mgr StateManager) {
+	if o.optionalMgrs == nil {
+		o.optionalMgrs = make(map[string]StateManager)
+	}
+	o.optionalMgrs[capability] = mgr
+	o.stateEng.AddManager(mgr)
+}
+
+// OptionalManager returns the state manager of the optional subsystem
+// with the given capability, or nil if it was not built in.
+func (o *Overlord) OptionalManager(capability string) StateManager {
+	return o.optionalMgrs[capability]
+}
+
+// Capabilities returns the sorted capabilities of the optional
+// subsystems built in.
+func (o *Overlord) Capabilities() []string {
+	if len(o.optionalMgrs) == 0 {
+		return nil
+	}
+	capabilities := make([]string, 0, len(o.optionalMgrs))
+	for capability := range o.optionalMgrs {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// AddOptionalManager adds the manager of an optional subsystem with the
+// given capability to the overlord created with Mock. For testing.
+func (o *Overlord) AddOptionalManager(capability string, mgr StateManager) {
+	if o.inited {
+		panic("internal error: cannot add managers to a fully initialized Overlord")
+	}
+	o.addOptionalManager(capability, mgr)
+}
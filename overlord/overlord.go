@@ -45,11 +45,13 @@ import (
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/patch"
+	"github.com/snapcore/snapd/overlord/servicestate"
 	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/lanpeers"
 	"github.com/snapcore/snapd/timings"
 )
 
@@ -90,6 +92,12 @@ type Overlord struct {
 	deviceMgr *devicestate.DeviceManager
 	cmdMgr    *cmdstate.CommandManager
 	shotMgr   *snapshotstate.SnapshotManager
+	// optionalMgrs are the managers of optional subsystems by capability
+	optionalMgrs map[string]StateManager
+	// lanPeers shares downloaded snaps with the local network
+	lanPeers *lanpeers.Service
+	// localRepo serves snaps from a local directory instead of the network
+	localRepo *store.LocalRepository
 	// proxyConf mediates the http proxy config
 	proxyConf func(req *http.Request) (*url.URL, error)
 }
@@ -168,6 +176,16 @@ func New(restartBehavior RestartBehavior) (*Overlord, error) {
 
 	o.addManager(cmdstate.Manager(s, o.runner))
 	o.addManager(snapshotstate.Manager(s, o.runner))
+	o.addManager(servicestate.Manager(s, o.runner))
+
+	o.lanPeers = lanpeers.New(dirs.SnapDownloadCacheDir)
+	o.addManager(&lanPeersManager{state: s, service: o.lanPeers})
+	o.localRepo = store.NewLocalRepository()
+	o.addManager(&localRepoManager{state: s, repo: o.localRepo})
+
+	if err := o.addOptionalManagers(); err != nil {
+		return nil, err
+	}
 
 	configstateInit(hookMgr)
 	healthstate.Init(hookMgr)
@@ -285,6 +303,12 @@ func (o *Overlord) newStoreWithContext(storeCtx store.DeviceAndAuthContext) snap
 	cfg.Proxy = o.proxyConf
 	sto := storeNew(cfg, storeCtx)
 	sto.SetCacheDownloads(defaultCachedDownloads)
+	if o.lanPeers != nil {
+		sto.SetLANPeers(o.lanPeers)
+	}
+	if o.localRepo != nil {
+		sto.SetLocalRepository(o.localRepo)
+	}
 	return sto
 }
 
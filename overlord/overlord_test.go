@@ -129,6 +129,69 @@ func (ovs *overlordSuite) TestNewStore(c *C) {
 	c.Check(sto.(*store.Store).CacheDownloads(), Equals, 5)
 }
 
+func (ovs *overlordSuite) TestNewWithOptionalManagers(c *C) {
+	restore := overlord.MockOptionalManagers()
+	defer restore()
+
+	var witness *witnessManager
+	overlord.RegisterOptionalManager("prompting", func(o *overlord.Overlord) (overlord.StateManager, error) {
+		// the other managers are available
+		c.Check(o.HookManager(), NotNil)
+		c.Check(o.InterfaceManager(), NotNil)
+		c.Check(o.TaskRunner(), NotNil)
+		witness = &witnessManager{state: o.State()}
+		return witness, nil
+	})
+	overlord.RegisterOptionalManager("hotplug", func(o *overlord.Overlord) (overlord.StateManager, error) {
+		return &witnessManager{state: o.State()}, nil
+	})
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+
+	c.Check(o.Capabilities(), DeepEquals, []string{"hotplug", "prompting"})
+	c.Assert(witness, NotNil)
+	c.Check(o.OptionalManager("prompting"), Equals, witness)
+	c.Check(o.OptionalManager("fde"), IsNil)
+
+	// the optional managers are started up with the others
+	err = o.StartUp()
+	c.Assert(err, IsNil)
+	c.Check(witness.startedUp, Equals, 1)
+}
+
+func (ovs *overlordSuite) TestNewWithOptionalManagerError(c *C) {
+	restore := overlord.MockOptionalManagers()
+	defer restore()
+
+	overlord.RegisterOptionalManager("fde", func(o *overlord.Overlord) (overlord.StateManager, error) {
+		return nil, fmt.Errorf("boom")
+	})
+
+	_, err := overlord.New(nil)
+	c.Assert(err, ErrorMatches, `cannot create optional manager for "fde": boom`)
+}
+
+func (ovs *overlordSuite) TestRegisterOptionalManagerTwice(c *C) {
+	restore := overlord.MockOptionalManagers()
+	defer restore()
+
+	factory := func(o *overlord.Overlord) (overlord.StateManager, error) {
+		return &witnessManager{state: o.State()}, nil
+	}
+	overlord.RegisterOptionalManager("hotplug", factory)
+	c.Check(func() { overlord.RegisterOptionalManager("hotplug", factory) }, PanicMatches, `internal error: optional manager for capability "hotplug" already registered`)
+}
+
+func (ovs *overlordSuite) TestNewWithoutOptionalManagers(c *C) {
+	restore := overlord.MockOptionalManagers()
+	defer restore()
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+	c.Check(o.Capabilities(), IsNil)
+}
+
 func (ovs *overlordSuite) TestNewWithGoodState(c *C) {
 	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"patch-sublevel":%d,"patch-sublevel-last-version":%q,"some":"data","refresh-privacy-key":"0123456789ABCDEF"},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level, patch.Sublevel, cmd.Version))
 	err := ioutil.WriteFile(dirs.SnapStateFile, fakeState, 0600)
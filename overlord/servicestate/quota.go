@@ -0,0 +1,424 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/cgroup"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/wrappers"
+)
+
+// QuotaResources are the resource limits of a quota group, zero values
+// mean no limit.
+type QuotaResources struct {
+	// MemoryLimit is the maximum memory usage in bytes
+	MemoryLimit uint64 `json:"memory-limit,omitempty"`
+	// CPUPercentage is the maximum CPU time relative to one CPU, 200
+	// being two full CPUs
+	CPUPercentage int `json:"cpu-percentage,omitempty"`
+	// IOWeight is the relative IO weight, between 1 and 10000
+	IOWeight int `json:"io-weight,omitempty"`
+}
+
+// QuotaGroup is a group of snaps whose services share resource limits,
+// enforced with a systemd slice on systems using the unified cgroup
+// hierarchy. Quota groups can be nested, the services of a sub-group are
+// also bounded by the limits of its parent groups.
+type QuotaGroup struct {
+	Name        string   `json:"name"`
+	ParentGroup string   `json:"parent-group,omitempty"`
+	SubGroups   []string `json:"sub-groups,omitempty"`
+	Snaps       []string `json:"snaps,omitempty"`
+	QuotaResources
+}
+
+// QuotaGroupUpdate describes the changes to make to a quota group.
+type QuotaGroupUpdate struct {
+	// AddSnaps are the snaps to add to the group
+	AddSnaps []string
+	// NewResources replaces the resource limits of the group if not nil
+	NewResources *QuotaResources
+}
+
+// the names of quota groups cannot contain dashes as they are used to
+// nest the slices of sub-groups
+var validQuotaGroupName = regexp.MustCompile("^[a-z0-9]{1,24}$")
+
+func checkQuotaGroupsSupported() error {
+	if !cgroup.IsUnified() {
+		return fmt.Errorf("cannot use quota groups: the unified cgroup hierarchy (cgroup v2) is required")
+	}
+	return nil
+}
+
+func validateQuotaResources(resources QuotaResources) error {
+	if resources.CPUPercentage < 0 {
+		return fmt.Errorf("invalid CPU percentage %d", resources.CPUPercentage)
+	}
+	if resources.IOWeight != 0 && (resources.IOWeight < 1 || resources.IOWeight > 10000) {
+		return fmt.Errorf("invalid IO weight %d: must be between 1 and 10000", resources.IOWeight)
+	}
+	return nil
+}
+
+// checkWithinParent checks that the resource limits of a group do not
+// exceed the ones of its parent group.
+func checkWithinParent(grp *QuotaGroup, groups map[string]*QuotaGroup) error {
+	if grp.ParentGroup == "" {
+		return nil
+	}
+	parent := groups[grp.ParentGroup]
+	if parent.MemoryLimit != 0 && grp.MemoryLimit > parent.MemoryLimit {
+		return fmt.Errorf("memory limit of quota group %q exceeds the one of its parent group %q", grp.Name, parent.Name)
+	}
+	if parent.CPUPercentage != 0 && grp.CPUPercentage > parent.CPUPercentage {
+		return fmt.Errorf("CPU percentage of quota group %q exceeds the one of its parent group %q", grp.Name, parent.Name)
+	}
+	return nil
+}
+
+// AllQuotas returns all the quota groups of the system, indexed by name.
+func AllQuotas(st *state.State) (map[string]*QuotaGroup, error) {
+	var groups map[string]*QuotaGroup
+	err := st.Get("quota-groups", &groups)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if groups == nil {
+		groups = make(map[string]*QuotaGroup)
+	}
+	return groups, nil
+}
+
+func quotaGroupOfSnap(groups map[string]*QuotaGroup, snapName string) *QuotaGroup {
+	for _, grp := range groups {
+		if strutil.ListContains(grp.Snaps, snapName) {
+			return grp
+		}
+	}
+	return nil
+}
+
+func checkSnapsCanJoin(st *state.State, groups map[string]*QuotaGroup, snapNames []string) error {
+	for i, snapName := range snapNames {
+		if strutil.ListContains(snapNames[:i], snapName) {
+			return fmt.Errorf("snap %q is listed more than once", snapName)
+		}
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, snapName, &snapst); err != nil {
+			if err == state.ErrNoState {
+				return fmt.Errorf("snap %q is not installed", snapName)
+			}
+			return err
+		}
+		if grp := quotaGroupOfSnap(groups, snapName); grp != nil {
+			return fmt.Errorf("snap %q is already in quota group %q", snapName, grp.Name)
+		}
+	}
+	return nil
+}
+
+// sliceName returns the name of the systemd slice of the given group, the
+// names of the slices of the parent groups being its prefix.
+func sliceName(grp *QuotaGroup, groups map[string]*QuotaGroup) string {
+	path := []string{grp.Name}
+	for parent := grp.ParentGroup; parent != ""; parent = groups[parent].ParentGroup {
+		path = append([]string{parent}, path...)
+	}
+	return "snap-" + strings.Join(path, "-") + ".slice"
+}
+
+// quotaSlices returns the systemd slices of the given groups, with the
+// services of their snaps.
+func quotaSlices(st *state.State, groups map[string]*QuotaGroup) ([]*wrappers.QuotaSlice, error) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	slices := make([]*wrappers.QuotaSlice, 0, len(groups))
+	for _, name := range names {
+		grp := groups[name]
+		slice := &wrappers.QuotaSlice{
+			Name:          sliceName(grp, groups),
+			Description:   fmt.Sprintf("Slice for snap quota group %s", grp.Name),
+			MemoryLimit:   grp.MemoryLimit,
+			CPUPercentage: grp.CPUPercentage,
+			IOWeight:      grp.IOWeight,
+		}
+		for _, snapName := range grp.Snaps {
+			var snapst snapstate.SnapState
+			err := snapstate.Get(st, snapName, &snapst)
+			if err == state.ErrNoState {
+				// the snap was removed, its services are put back
+				// in the group if it is installed again
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			info, err := snapst.CurrentInfo()
+			if err != nil {
+				return nil, err
+			}
+			slice.Services = append(slice.Services, info.Services()...)
+		}
+		slices = append(slices, slice)
+	}
+	return slices, nil
+}
+
+func copyQuotaGroups(groups map[string]*QuotaGroup) map[string]*QuotaGroup {
+	newGroups := make(map[string]*QuotaGroup, len(groups))
+	for name, grp := range groups {
+		grpCopy := *grp
+		grpCopy.SubGroups = append([]string(nil), grp.SubGroups...)
+		grpCopy.Snaps = append([]string(nil), grp.Snaps...)
+		newGroups[name] = &grpCopy
+	}
+	return newGroups
+}
+
+// quotaControlAction is the change to the quota groups made by a
+// quota-control task.
+type quotaControlAction struct {
+	// Action is one of "create", "update" or "remove"
+	Action     string          `json:"action"`
+	QuotaName  string          `json:"quota-name"`
+	ParentName string          `json:"parent-name,omitempty"`
+	AddSnaps   []string        `json:"add-snaps,omitempty"`
+	Resources  *QuotaResources `json:"resources,omitempty"`
+}
+
+// applyQuotaAction returns the quota groups resulting from the given
+// action, the given groups are left untouched.
+func applyQuotaAction(st *state.State, groups map[string]*QuotaGroup, action *quotaControlAction) (map[string]*QuotaGroup, error) {
+	switch action.Action {
+	case "create":
+		return applyCreateQuota(st, groups, action)
+	case "update":
+		return applyUpdateQuota(st, groups, action)
+	case "remove":
+		return applyRemoveQuota(groups, action.QuotaName)
+	}
+	return nil, fmt.Errorf("internal error: unknown quota action %q", action.Action)
+}
+
+func applyCreateQuota(st *state.State, groups map[string]*QuotaGroup, action *quotaControlAction) (map[string]*QuotaGroup, error) {
+	name, parentName := action.QuotaName, action.ParentName
+	if !validQuotaGroupName.MatchString(name) {
+		return nil, fmt.Errorf("invalid quota group name %q: must be up to 24 lowercase letters and digits", name)
+	}
+	var resources QuotaResources
+	if action.Resources != nil {
+		resources = *action.Resources
+	}
+	if err := validateQuotaResources(resources); err != nil {
+		return nil, err
+	}
+	if groups[name] != nil {
+		return nil, fmt.Errorf("quota group %q already exists", name)
+	}
+	if parentName != "" && groups[parentName] == nil {
+		return nil, fmt.Errorf("cannot create quota group %q: parent group %q does not exist", name, parentName)
+	}
+	if err := checkSnapsCanJoin(st, groups, action.AddSnaps); err != nil {
+		return nil, err
+	}
+
+	groups = copyQuotaGroups(groups)
+	grp := &QuotaGroup{
+		Name:           name,
+		ParentGroup:    parentName,
+		Snaps:          append([]string(nil), action.AddSnaps...),
+		QuotaResources: resources,
+	}
+	if err := checkWithinParent(grp, groups); err != nil {
+		return nil, err
+	}
+	groups[name] = grp
+	if parentName != "" {
+		parent := groups[parentName]
+		parent.SubGroups = append(parent.SubGroups, name)
+	}
+	return groups, nil
+}
+
+func applyUpdateQuota(st *state.State, groups map[string]*QuotaGroup, action *quotaControlAction) (map[string]*QuotaGroup, error) {
+	name := action.QuotaName
+	if groups[name] == nil {
+		return nil, fmt.Errorf("quota group %q does not exist", name)
+	}
+	if err := checkSnapsCanJoin(st, groups, action.AddSnaps); err != nil {
+		return nil, err
+	}
+
+	groups = copyQuotaGroups(groups)
+	grp := groups[name]
+	grp.Snaps = append(grp.Snaps, action.AddSnaps...)
+	if action.Resources != nil {
+		if err := validateQuotaResources(*action.Resources); err != nil {
+			return nil, err
+		}
+		grp.QuotaResources = *action.Resources
+		if err := checkWithinParent(grp, groups); err != nil {
+			return nil, err
+		}
+		for _, sub := range grp.SubGroups {
+			if err := checkWithinParent(groups[sub], groups); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return groups, nil
+}
+
+func applyRemoveQuota(groups map[string]*QuotaGroup, name string) (map[string]*QuotaGroup, error) {
+	grp := groups[name]
+	if grp == nil {
+		return nil, fmt.Errorf("quota group %q does not exist", name)
+	}
+	if len(grp.SubGroups) != 0 {
+		return nil, fmt.Errorf("cannot remove quota group %q with sub-groups, remove the sub-groups first", name)
+	}
+
+	groups = copyQuotaGroups(groups)
+	delete(groups, name)
+	if grp.ParentGroup != "" {
+		parent := groups[grp.ParentGroup]
+		subGroups := make([]string, 0, len(parent.SubGroups))
+		for _, sub := range parent.SubGroups {
+			if sub != name {
+				subGroups = append(subGroups, sub)
+			}
+		}
+		parent.SubGroups = subGroups
+	}
+	return groups, nil
+}
+
+// checkQuotaControlConflicts ensures that no other quota group change is
+// in progress, as they all rewrite the slices of all the groups, and that
+// no change is in progress for the given snaps.
+func checkQuotaControlConflicts(st *state.State, snapNames []string) error {
+	for _, t := range st.Tasks() {
+		if t.Kind() != "quota-control" {
+			continue
+		}
+		chg := t.Change()
+		if chg == nil || chg.Status().Ready() {
+			continue
+		}
+		return &snapstate.ChangeConflictError{
+			Message:    "a quota group change is in progress, no other quota group changes allowed until it is done",
+			ChangeKind: chg.Kind(),
+		}
+	}
+	return snapstate.CheckChangeConflictMany(st, snapNames, "")
+}
+
+// quotaControl validates the given action and returns the task set
+// making it.
+func quotaControl(st *state.State, action *quotaControlAction, summary string) (*state.TaskSet, error) {
+	groups, err := AllQuotas(st)
+	if err != nil {
+		return nil, err
+	}
+	newGroups, err := applyQuotaAction(st, groups, action)
+	if err != nil {
+		return nil, err
+	}
+
+	// the snaps whose services change slice
+	var affected []string
+	for _, grps := range []map[string]*QuotaGroup{groups, newGroups} {
+		if grp := grps[action.QuotaName]; grp != nil {
+			for _, snapName := range grp.Snaps {
+				if !strutil.ListContains(affected, snapName) {
+					affected = append(affected, snapName)
+				}
+			}
+		}
+	}
+	sort.Strings(affected)
+	if err := checkQuotaControlConflicts(st, affected); err != nil {
+		return nil, err
+	}
+
+	t := st.NewTask("quota-control", summary)
+	t.Set("quota-control-action", action)
+	t.Set("affected-snaps", affected)
+	return state.NewTaskSet(t), nil
+}
+
+// CreateQuota returns the task set creating a quota group with the given
+// snaps and resource limits, as a sub-group of the given parent group if
+// any. Services of the snaps that are running are only moved to the slice
+// of the group when they are restarted.
+//
+// The state must be locked by the caller.
+func CreateQuota(st *state.State, name, parentName string, snapNames []string, resources QuotaResources) (*state.TaskSet, error) {
+	if err := checkQuotaGroupsSupported(); err != nil {
+		return nil, err
+	}
+	return quotaControl(st, &quotaControlAction{
+		Action:     "create",
+		QuotaName:  name,
+		ParentName: parentName,
+		AddSnaps:   snapNames,
+		Resources:  &resources,
+	}, fmt.Sprintf("Create quota group %q", name))
+}
+
+// UpdateQuota returns the task set updating the snaps and resource limits
+// of the given quota group.
+//
+// The state must be locked by the caller.
+func UpdateQuota(st *state.State, name string, update QuotaGroupUpdate) (*state.TaskSet, error) {
+	if err := checkQuotaGroupsSupported(); err != nil {
+		return nil, err
+	}
+	return quotaControl(st, &quotaControlAction{
+		Action:    "update",
+		QuotaName: name,
+		AddSnaps:  update.AddSnaps,
+		Resources: update.NewResources,
+	}, fmt.Sprintf("Update quota group %q", name))
+}
+
+// RemoveQuota returns the task set removing the given quota group, which
+// must not have sub-groups. Services of its snaps that are running are
+// only moved out of its slice when they are restarted.
+//
+// The state must be locked by the caller.
+func RemoveQuota(st *state.State, name string) (*state.TaskSet, error) {
+	return quotaControl(st, &quotaControlAction{
+		Action:    "remove",
+		QuotaName: name,
+	}, fmt.Sprintf("Remove quota group %q", name))
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate
+
+import (
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/wrappers"
+)
+
+func quotaControlAffectedSnaps(t *state.Task) ([]string, error) {
+	var snapNames []string
+	if err := t.Get("affected-snaps", &snapNames); err != nil {
+		return nil, err
+	}
+	return snapNames, nil
+}
+
+// ensureQuotaGroups writes the slices of the given groups and puts the
+// services of their snaps in them, and then records the groups in the
+// state. The state is unlocked while systemd is reloaded.
+//
+// The state must be locked by the caller.
+func ensureQuotaGroups(st *state.State, groups map[string]*QuotaGroup) error {
+	slices, err := quotaSlices(st, groups)
+	if err != nil {
+		return err
+	}
+
+	st.Unlock()
+	err = wrappers.EnsureQuotaSlices(slices, progress.Null)
+	st.Lock()
+	if err != nil {
+		return err
+	}
+
+	st.Set("quota-groups", groups)
+	return nil
+}
+
+func doQuotaControl(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var action quotaControlAction
+	if err := t.Get("quota-control-action", &action); err != nil {
+		return err
+	}
+	groups, err := AllQuotas(st)
+	if err != nil {
+		return err
+	}
+	newGroups, err := applyQuotaAction(st, groups, &action)
+	if err != nil {
+		return err
+	}
+
+	t.Set("old-quota-groups", groups)
+	return ensureQuotaGroups(st, newGroups)
+}
+
+func undoQuotaControl(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var oldGroups map[string]*QuotaGroup
+	if err := t.Get("old-quota-groups", &oldGroups); err != nil {
+		return err
+	}
+	if oldGroups == nil {
+		oldGroups = make(map[string]*QuotaGroup)
+	}
+	return ensureQuotaGroups(st, oldGroups)
+}
+
+// ensureSnapServicesQuota puts the services of the given snap in the slice
+// of its quota group, if any, it is hooked into snapstate to be called
+// before the snap is linked.
+func ensureSnapServicesQuota(st *state.State, info *snap.Info) error {
+	groups, err := AllQuotas(st)
+	if err != nil {
+		return err
+	}
+	var slice string
+	if grp := quotaGroupOfSnap(groups, info.InstanceName()); grp != nil {
+		slice = sliceName(grp, groups)
+	}
+	return wrappers.EnsureSnapQuotaDropIns(info, slice)
+}
+
+func delayedCrossMgrInit() {
+	snapstate.EnsureSnapServicesQuota = ensureSnapServicesQuota
+}
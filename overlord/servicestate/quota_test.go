@@ -0,0 +1,350 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/cgroup"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type quotaSuite struct {
+	testutil.BaseTest
+
+	state   *state.State
+	se      *overlord.StateEngine
+	sysdLog [][]string
+}
+
+var _ = Suite(&quotaSuite{})
+
+const testYaml = `name: test-snap
+version: 1
+apps:
+  svc:
+    command: bin.sh
+    daemon: simple
+  app:
+    command: bin.sh
+`
+
+func (s *quotaSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+	s.AddCleanup(cgroup.MockVersion(cgroup.V2, nil))
+	s.AddCleanup(snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {}))
+
+	s.sysdLog = nil
+	s.AddCleanup(systemd.MockSystemctl(func(cmd ...string) ([]byte, error) {
+		s.sysdLog = append(s.sysdLog, cmd)
+		return nil, nil
+	}))
+
+	s.state = state.New(nil)
+	s.se = overlord.NewStateEngine(s.state)
+	runner := state.NewTaskRunner(s.state)
+	runner.AddHandler("error-trigger", func(t *state.Task, _ *tomb.Tomb) error {
+		return errors.New("boom")
+	}, nil)
+	s.se.AddManager(servicestate.Manager(s.state, runner))
+	s.se.AddManager(runner)
+	c.Assert(s.se.StartUp(), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	for _, name := range []string{"test-snap", "other-snap"} {
+		yaml := testYaml
+		if name != "test-snap" {
+			yaml = "name: other-snap\nversion: 1\n"
+		}
+		si := &snap.SideInfo{RealName: name, Revision: snap.R(1)}
+		snaptest.MockSnap(c, yaml, si)
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			Active:   true,
+			Sequence: []*snap.SideInfo{si},
+			Current:  si.Revision,
+		})
+	}
+}
+
+// runChange runs a change made of the given task set until it is ready.
+//
+// The state must be locked by the caller.
+func (s *quotaSuite) runChange(c *C, ts *state.TaskSet) *state.Change {
+	chg := s.state.NewChange("quota-control", "...")
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.state.Lock()
+	for i := 0; i < 5; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+		s.state.Lock()
+		ready := chg.Status().Ready()
+		s.state.Unlock()
+		if ready {
+			break
+		}
+	}
+	return chg
+}
+
+func (s *quotaSuite) createQuota(c *C, name, parentName string, snapNames []string, resources servicestate.QuotaResources) {
+	ts, err := servicestate.CreateQuota(s.state, name, parentName, snapNames, resources)
+	c.Assert(err, IsNil)
+	chg := s.runChange(c, ts)
+	c.Assert(chg.Err(), IsNil)
+}
+
+func (s *quotaSuite) updateQuota(c *C, name string, update servicestate.QuotaGroupUpdate) {
+	ts, err := servicestate.UpdateQuota(s.state, name, update)
+	c.Assert(err, IsNil)
+	chg := s.runChange(c, ts)
+	c.Assert(chg.Err(), IsNil)
+}
+
+func (s *quotaSuite) removeQuota(c *C, name string) {
+	ts, err := servicestate.RemoveQuota(s.state, name)
+	c.Assert(err, IsNil)
+	chg := s.runChange(c, ts)
+	c.Assert(chg.Err(), IsNil)
+}
+
+func (s *quotaSuite) TestCreateUpdateRemoveQuota(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	ts, err := servicestate.CreateQuota(st, "db", "", []string{"test-snap"}, servicestate.QuotaResources{
+		MemoryLimit: 1 << 30,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ts.Tasks(), HasLen, 1)
+	c.Check(ts.Tasks()[0].Kind(), Equals, "quota-control")
+	c.Check(ts.Tasks()[0].Summary(), Equals, `Create quota group "db"`)
+	// nothing is done until the task runs
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileAbsent)
+	c.Check(s.sysdLog, HasLen, 0)
+	chg := s.runChange(c, ts)
+	c.Assert(chg.Err(), IsNil)
+
+	s.createQuota(c, "small", "db", []string{"other-snap"}, servicestate.QuotaResources{
+		MemoryLimit: 1 << 20,
+		IOWeight:    10,
+	})
+
+	groups, err := servicestate.AllQuotas(st)
+	c.Assert(err, IsNil)
+	c.Check(groups, DeepEquals, map[string]*servicestate.QuotaGroup{
+		"db": {
+			Name:           "db",
+			SubGroups:      []string{"small"},
+			Snaps:          []string{"test-snap"},
+			QuotaResources: servicestate.QuotaResources{MemoryLimit: 1 << 30},
+		},
+		"small": {
+			Name:           "small",
+			ParentGroup:    "db",
+			Snaps:          []string{"other-snap"},
+			QuotaResources: servicestate.QuotaResources{MemoryLimit: 1 << 20, IOWeight: 10},
+		},
+	})
+
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileContains, "MemoryMax=1073741824\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db-small.slice"), testutil.FileContains, "IOWeight=10\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.test-snap.svc.service.d/snap-quota.conf"), testutil.FileContains, "Slice=snap-db.slice\n")
+	c.Check(s.sysdLog, DeepEquals, [][]string{{"daemon-reload"}, {"daemon-reload"}})
+
+	s.updateQuota(c, "db", servicestate.QuotaGroupUpdate{
+		NewResources: &servicestate.QuotaResources{MemoryLimit: 1 << 31, CPUPercentage: 50},
+	})
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileContains, "MemoryMax=2147483648\nCPUAccounting=true\nCPUQuota=50%\n")
+
+	_, err = servicestate.RemoveQuota(st, "db")
+	c.Assert(err, ErrorMatches, `cannot remove quota group "db" with sub-groups, remove the sub-groups first`)
+
+	s.removeQuota(c, "small")
+	groups, err = servicestate.AllQuotas(st)
+	c.Assert(err, IsNil)
+	c.Check(groups["db"].SubGroups, HasLen, 0)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db-small.slice"), testutil.FileAbsent)
+
+	// the snap can join another group now
+	s.updateQuota(c, "db", servicestate.QuotaGroupUpdate{AddSnaps: []string{"other-snap"}})
+	groups, err = servicestate.AllQuotas(st)
+	c.Assert(err, IsNil)
+	c.Check(groups["db"].Snaps, DeepEquals, []string{"test-snap", "other-snap"})
+
+	s.removeQuota(c, "db")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileAbsent)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.test-snap.svc.service.d"), testutil.FileAbsent)
+}
+
+func (s *quotaSuite) TestCreateQuotaErrors(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	s.createQuota(c, "db", "", []string{"test-snap"}, servicestate.QuotaResources{MemoryLimit: 1 << 20})
+
+	for _, t := range []struct {
+		name      string
+		parent    string
+		snaps     []string
+		resources servicestate.QuotaResources
+		err       string
+	}{
+		{"my-db", "", nil, servicestate.QuotaResources{}, `invalid quota group name "my-db": .*`},
+		{"db", "", nil, servicestate.QuotaResources{}, `quota group "db" already exists`},
+		{"small", "foo", nil, servicestate.QuotaResources{}, `cannot create quota group "small": parent group "foo" does not exist`},
+		{"small", "", []string{"test-snap"}, servicestate.QuotaResources{}, `snap "test-snap" is already in quota group "db"`},
+		{"small", "", []string{"other-snap", "other-snap"}, servicestate.QuotaResources{}, `snap "other-snap" is listed more than once`},
+		{"small", "", []string{"missing-snap"}, servicestate.QuotaResources{}, `snap "missing-snap" is not installed`},
+		{"small", "", nil, servicestate.QuotaResources{IOWeight: 20000}, `invalid IO weight 20000: must be between 1 and 10000`},
+		{"small", "db", nil, servicestate.QuotaResources{MemoryLimit: 1 << 30}, `memory limit of quota group "small" exceeds the one of its parent group "db"`},
+	} {
+		_, err := servicestate.CreateQuota(st, t.name, t.parent, t.snaps, t.resources)
+		c.Check(err, ErrorMatches, t.err)
+	}
+
+	groups, err := servicestate.AllQuotas(st)
+	c.Assert(err, IsNil)
+	c.Check(groups, HasLen, 1)
+}
+
+func (s *quotaSuite) TestQuotaRequiresUnifiedHierarchy(c *C) {
+	restore := cgroup.MockVersion(cgroup.V1, nil)
+	defer restore()
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	_, err := servicestate.CreateQuota(st, "db", "", nil, servicestate.QuotaResources{})
+	c.Assert(err, ErrorMatches, `cannot use quota groups: the unified cgroup hierarchy \(cgroup v2\) is required`)
+	c.Check(s.sysdLog, HasLen, 0)
+}
+
+func (s *quotaSuite) TestQuotaControlConflicts(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	ts, err := servicestate.CreateQuota(st, "db", "", []string{"test-snap"}, servicestate.QuotaResources{})
+	c.Assert(err, IsNil)
+	chg := st.NewChange("quota-control", "...")
+	chg.AddAll(ts)
+
+	// only one quota group change at a time
+	_, err = servicestate.CreateQuota(st, "other", "", nil, servicestate.QuotaResources{})
+	c.Check(err, ErrorMatches, `a quota group change is in progress, no other quota group changes allowed until it is done`)
+	c.Check(err, FitsTypeOf, &snapstate.ChangeConflictError{})
+
+	// the snaps of the group cannot change meanwhile
+	err = snapstate.CheckChangeConflict(st, "test-snap", nil)
+	c.Check(err, ErrorMatches, `snap "test-snap" has "quota-control" change in progress`)
+	c.Check(snapstate.CheckChangeConflict(st, "other-snap", nil), IsNil)
+
+	chg.SetStatus(state.DoneStatus)
+	for _, t := range chg.Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+
+	// nor can the group while a snap of it changes
+	snapChg := st.NewChange("refresh-snap", "...")
+	snapChg.AddTask(st.NewTask("link-snap", "..."))
+	snapChg.Tasks()[0].Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "other-snap"}})
+	_, err = servicestate.CreateQuota(st, "other", "", []string{"other-snap"}, servicestate.QuotaResources{})
+	c.Check(err, ErrorMatches, `snap "other-snap" has "refresh-snap" change in progress`)
+}
+
+func (s *quotaSuite) TestQuotaControlUndo(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	s.createQuota(c, "db", "", []string{"test-snap"}, servicestate.QuotaResources{MemoryLimit: 1 << 20})
+
+	ts, err := servicestate.UpdateQuota(st, "db", servicestate.QuotaGroupUpdate{
+		AddSnaps:     []string{"other-snap"},
+		NewResources: &servicestate.QuotaResources{MemoryLimit: 1 << 30},
+	})
+	c.Assert(err, IsNil)
+	terr := st.NewTask("error-trigger", "provoking undo")
+	terr.WaitAll(ts)
+	ts.AddTask(terr)
+	chg := s.runChange(c, ts)
+	c.Assert(chg.Err(), ErrorMatches, `(?s).*boom.*`)
+	c.Check(ts.Tasks()[0].Status(), Equals, state.UndoneStatus)
+
+	groups, err := servicestate.AllQuotas(st)
+	c.Assert(err, IsNil)
+	c.Check(groups["db"].Snaps, DeepEquals, []string{"test-snap"})
+	c.Check(groups["db"].MemoryLimit, Equals, uint64(1<<20))
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileContains, "MemoryMax=1048576\n")
+}
+
+func (s *quotaSuite) TestEnsureSnapServicesQuota(c *C) {
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	s.createQuota(c, "db", "", []string{"test-snap"}, servicestate.QuotaResources{})
+	dropIn := filepath.Join(dirs.SnapServicesDir, "snap.test-snap.svc.service.d/snap-quota.conf")
+	c.Assert(dropIn, testutil.FileContains, "Slice=snap-db.slice\n")
+
+	// a new revision of the snap has another service
+	si := &snap.SideInfo{RealName: "test-snap", Revision: snap.R(2)}
+	info := snaptest.MockSnap(c, testYaml+`  svc2:
+    command: bin.sh
+    daemon: simple
+`, si)
+
+	// the service manager hooks into snapstate to put the services
+	// in the slice when the snap is linked
+	err := snapstate.EnsureSnapServicesQuota(st, info)
+	c.Assert(err, IsNil)
+	c.Check(dropIn, testutil.FileContains, "Slice=snap-db.slice\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.test-snap.svc2.service.d/snap-quota.conf"), testutil.FileContains, "Slice=snap-db.slice\n")
+
+	// services of snaps outside of groups are not in a slice
+	info = snaptest.MockSnap(c, "name: other-snap\nversion: 1\napps:\n  svc:\n    daemon: simple\n", &snap.SideInfo{RealName: "other-snap", Revision: snap.R(2)})
+	err = snapstate.EnsureSnapServicesQuota(st, info)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.other-snap.svc.service.d"), testutil.FileAbsent)
+}
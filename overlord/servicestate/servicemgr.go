@@ -0,0 +1,180 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/systemd"
+)
+
+// interval between checks of the journal for watchdog timeouts of services
+var watchdogCheckInterval = 5 * time.Minute
+
+// ServiceManager watches over the services of installed snaps and
+// manages their quota groups.
+type ServiceManager struct {
+	state *state.State
+
+	lastWatchdogCheck time.Time
+}
+
+// Manager returns a new ServiceManager.
+func Manager(st *state.State, runner *state.TaskRunner) *ServiceManager {
+	delayedCrossMgrInit()
+
+	runner.AddHandler("quota-control", doQuotaControl, undoQuotaControl)
+	snapstate.AddAffectedSnapsByKind("quota-control", quotaControlAffectedSnaps)
+
+	return &ServiceManager{state: st}
+}
+
+// Ensure implements StateManager.Ensure.
+func (m *ServiceManager) Ensure() error {
+	now := time.Now()
+	if now.Before(m.lastWatchdogCheck.Add(watchdogCheckInterval)) {
+		return nil
+	}
+	m.lastWatchdogCheck = now
+
+	// failing to look at the journal should not hold up the other
+	// managers, try again at the next check
+	if err := m.checkWatchdogTimeouts(); err != nil {
+		logger.Noticef("cannot check for watchdog timeouts of services: %v", err)
+	}
+	return nil
+}
+
+// watchdogServices returns the services of active snaps that declare a
+// watchdog-timeout, by unit name.
+func watchdogServices(st *state.State) (map[string]string, error) {
+	all, err := snapstate.All(st)
+	if err != nil {
+		return nil, err
+	}
+	services := make(map[string]string)
+	for _, snapst := range all {
+		if !snapst.Active {
+			continue
+		}
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range info.Services() {
+			if app.WatchdogTimeout > 0 {
+				services[app.ServiceName()] = app.String()
+			}
+		}
+	}
+	return services, nil
+}
+
+// checkWatchdogTimeouts looks in the journal for the watchdog timeouts of
+// services since the last check and adds a warning for each service that
+// hit one.
+func (m *ServiceManager) checkWatchdogTimeouts() error {
+	st := m.state
+	st.Lock()
+	defer st.Unlock()
+
+	services, err := watchdogServices(st)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	units := make([]string, 0, len(services))
+	for unit := range services {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	var cursor string
+	if err := st.Get("service-watchdog-cursor", &cursor); err != nil && err != state.ErrNoState {
+		return err
+	}
+
+	st.Unlock()
+	timedOut, newCursor, err := watchdogTimeouts(units, cursor)
+	st.Lock()
+	if err != nil {
+		return err
+	}
+
+	for _, unit := range timedOut {
+		if app, ok := services[unit]; ok {
+			st.Warnf("service %q was stopped as its watchdog timed out", app)
+		}
+	}
+	if newCursor != "" {
+		st.Set("service-watchdog-cursor", newCursor)
+	}
+	return nil
+}
+
+// watchdogTimeouts returns the units among the given ones that hit their
+// watchdog timeout according to the journal entries after the given
+// cursor, and the cursor of the last entry. Without a cursor only the
+// cursor of the last entry is returned, so that old timeouts are not
+// reported.
+func watchdogTimeouts(units []string, cursor string) (timedOut []string, lastCursor string, err error) {
+	n := -1
+	if cursor == "" {
+		n = 1
+	}
+	sysd := systemd.New(dirs.GlobalRootDir, systemd.SystemMode, progress.Null)
+	reader, err := sysd.LogReader(units, n, false, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+
+	dec := json.NewDecoder(reader)
+	for {
+		var log systemd.Log
+		if err := dec.Decode(&log); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, "", err
+		}
+		lastCursor = log.Cursor()
+		// the timeouts are logged by systemd itself, which records
+		// the unit the message is about in the UNIT field
+		if cursor != "" && strings.Contains(log.Message(), "Watchdog timeout") {
+			if unit := log["UNIT"]; unit != "" && !strutil.ListContains(timedOut, unit) {
+				timedOut = append(timedOut, unit)
+			}
+		}
+	}
+	return timedOut, lastCursor, nil
+}
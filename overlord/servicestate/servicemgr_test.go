@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type serviceMgrSuite struct {
+	testutil.BaseTest
+
+	state *state.State
+
+	journal     string
+	jctlSvcs    [][]string
+	jctlNs      []int
+	jctlCursors []string
+}
+
+var _ = Suite(&serviceMgrSuite{})
+
+const watchdogYaml = `name: test-snap
+version: 1
+apps:
+  svc:
+    command: bin.sh
+    daemon: notify
+    watchdog-timeout: 10s
+  other:
+    command: bin.sh
+    daemon: simple
+`
+
+func (s *serviceMgrSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+	s.AddCleanup(snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {}))
+
+	s.journal = ""
+	s.jctlSvcs = nil
+	s.jctlNs = nil
+	s.jctlCursors = nil
+	s.AddCleanup(systemd.MockJournalctl(func(svcs []string, n int, follow bool, afterCursor string) (io.ReadCloser, error) {
+		s.jctlSvcs = append(s.jctlSvcs, svcs)
+		s.jctlNs = append(s.jctlNs, n)
+		s.jctlCursors = append(s.jctlCursors, afterCursor)
+		return ioutil.NopCloser(strings.NewReader(s.journal)), nil
+	}))
+
+	s.state = state.New(nil)
+}
+
+func (s *serviceMgrSuite) mockSnap(c *C, yaml string) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	si := &snap.SideInfo{RealName: "test-snap", Revision: snap.R(1)}
+	snaptest.MockSnap(c, yaml, si)
+	snapstate.Set(s.state, "test-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+}
+
+func (s *serviceMgrSuite) TestEnsureWarnsOfWatchdogTimeouts(c *C) {
+	s.mockSnap(c, watchdogYaml)
+
+	// the first check only finds where the journal is at
+	s.journal = `{"__CURSOR": "c1", "MESSAGE": "snap.test-snap.svc.service: Watchdog timeout (limit 10s)!", "UNIT": "snap.test-snap.svc.service"}`
+	c.Assert(servicestate.Manager(s.state, state.NewTaskRunner(s.state)).Ensure(), IsNil)
+
+	s.journal = `{"__CURSOR": "c2", "MESSAGE": "hello", "_SYSTEMD_UNIT": "snap.test-snap.svc.service"}
+{"__CURSOR": "c3", "MESSAGE": "snap.test-snap.svc.service: Watchdog timeout (limit 10s)!", "UNIT": "snap.test-snap.svc.service"}
+{"__CURSOR": "c4", "MESSAGE": "snap.test-snap.svc.service: Watchdog timeout (limit 10s)!", "UNIT": "snap.test-snap.svc.service"}
+`
+	mgr := servicestate.Manager(s.state, state.NewTaskRunner(s.state))
+	c.Assert(mgr.Ensure(), IsNil)
+	// checks are spaced out
+	c.Assert(mgr.Ensure(), IsNil)
+
+	c.Check(s.jctlSvcs, DeepEquals, [][]string{{"snap.test-snap.svc.service"}, {"snap.test-snap.svc.service"}})
+	c.Check(s.jctlNs, DeepEquals, []int{1, -1})
+	c.Check(s.jctlCursors, DeepEquals, []string{"", "c1"})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var cursor string
+	c.Assert(s.state.Get("service-watchdog-cursor", &cursor), IsNil)
+	c.Check(cursor, Equals, "c4")
+	warnings := s.state.AllWarnings()
+	c.Assert(warnings, HasLen, 1)
+	c.Check(warnings[0].String(), Equals, `service "test-snap.svc" was stopped as its watchdog timed out`)
+}
+
+func (s *serviceMgrSuite) TestEnsureNoWatchdogServices(c *C) {
+	s.mockSnap(c, testYaml)
+
+	c.Assert(servicestate.Manager(s.state, state.NewTaskRunner(s.state)).Ensure(), IsNil)
+	c.Check(s.jctlSvcs, HasLen, 0)
+}
@@ -21,6 +21,8 @@ package servicestate
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/client"
@@ -34,6 +36,10 @@ import (
 type Instruction struct {
 	Action string   `json:"action"`
 	Names  []string `json:"names"`
+	// Activators, if not empty, are the sockets, by name, or the
+	// "timer" of the services to operate on instead of the services
+	// themselves
+	Activators []string `json:"activators,omitempty"`
 	client.StartOptions
 	client.StopOptions
 	client.RestartOptions
@@ -41,6 +47,24 @@ type Instruction struct {
 
 type ServiceActionConflictError struct{ error }
 
+// activatorUnits returns the names of the units of the given activators
+// of a service.
+func activatorUnits(app *snap.AppInfo, activators []string) ([]string, error) {
+	units := make([]string, 0, len(activators))
+	for _, name := range activators {
+		if name == "timer" && app.Timer != nil {
+			units = append(units, filepath.Base(app.Timer.File()))
+			continue
+		}
+		sock, ok := app.Sockets[name]
+		if !ok {
+			return nil, fmt.Errorf("service %q has no socket or timer %q", app, name)
+		}
+		units = append(units, filepath.Base(sock.File()))
+	}
+	return units, nil
+}
+
 // Control creates a taskset for starting/stopping/restarting services via systemctl.
 // The appInfos and inst define the services and the command to execute.
 // Context is used to determine change conflicts - we will not conflict with
@@ -61,6 +85,9 @@ func Control(st *state.State, appInfos []*snap.AppInfo, inst *Instruction, conte
 		}
 		ctlcmds = append(ctlcmds, "stop")
 	case inst.Action == "restart":
+		if inst.Reload && len(inst.Activators) > 0 {
+			return nil, fmt.Errorf("cannot reload sockets or timers")
+		}
 		if inst.Reload {
 			ctlcmds = []string{"reload-or-restart"}
 		} else {
@@ -78,9 +105,18 @@ func Control(st *state.State, appInfos []*snap.AppInfo, inst *Instruction, conte
 	lastName := ""
 	names := make([]string, len(appInfos))
 	for i, svc := range appInfos {
-		svcs = append(svcs, svc.ServiceName())
 		snapName := svc.Snap.InstanceName()
 		names[i] = snapName + "." + svc.Name
+		if len(inst.Activators) > 0 {
+			units, err := activatorUnits(svc, inst.Activators)
+			if err != nil {
+				return nil, err
+			}
+			svcs = append(svcs, units...)
+			names[i] += " (" + strings.Join(inst.Activators, ", ") + ")"
+		} else {
+			svcs = append(svcs, svc.ServiceName())
+		}
 		if snapName != lastName {
 			snapNames = append(snapNames, snapName)
 			lastName = snapName
@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type controlSuite struct {
+	testutil.BaseTest
+
+	state *state.State
+	info  *snap.Info
+}
+
+var _ = Suite(&controlSuite{})
+
+const activatedYaml = `name: test-snap
+version: 1
+apps:
+  svc:
+    command: bin.sh
+    daemon: simple
+    timer: 10:00-12:00
+    sockets:
+      sock:
+        listen-stream: $SNAP_COMMON/run.sock
+    plugs: [network-bind]
+`
+
+func (s *controlSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.state = state.New(nil)
+	s.info = snaptest.MockInfo(c, activatedYaml, &snap.SideInfo{Revision: snap.R(1)})
+}
+
+func (s *controlSuite) controlArgvs(c *C, inst *servicestate.Instruction) [][]string {
+	tss, err := servicestate.Control(s.state, []*snap.AppInfo{s.info.Apps["svc"]}, inst, nil)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var argvs [][]string
+	for _, ts := range tss {
+		for _, t := range ts.Tasks() {
+			var argv []string
+			c.Assert(t.Get("argv", &argv), IsNil)
+			argvs = append(argvs, argv)
+		}
+	}
+	return argvs
+}
+
+func (s *controlSuite) TestControlService(c *C) {
+	argvs := s.controlArgvs(c, &servicestate.Instruction{
+		Action:      "stop",
+		Names:       []string{"test-snap.svc"},
+		StopOptions: client.StopOptions{Disable: true},
+	})
+	c.Check(argvs, DeepEquals, [][]string{
+		{"systemctl", "disable", "snap.test-snap.svc.service"},
+		{"systemctl", "stop", "snap.test-snap.svc.service"},
+	})
+}
+
+func (s *controlSuite) TestControlActivators(c *C) {
+	argvs := s.controlArgvs(c, &servicestate.Instruction{
+		Action:      "stop",
+		Names:       []string{"test-snap.svc"},
+		Activators:  []string{"sock"},
+		StopOptions: client.StopOptions{Disable: true},
+	})
+	c.Check(argvs, DeepEquals, [][]string{
+		{"systemctl", "disable", "snap.test-snap.svc.sock.socket"},
+		{"systemctl", "stop", "snap.test-snap.svc.sock.socket"},
+	})
+
+	argvs = s.controlArgvs(c, &servicestate.Instruction{
+		Action:     "start",
+		Names:      []string{"test-snap.svc"},
+		Activators: []string{"timer", "sock"},
+	})
+	c.Check(argvs, DeepEquals, [][]string{
+		{"systemctl", "start", "snap.test-snap.svc.timer", "snap.test-snap.svc.sock.socket"},
+	})
+}
+
+func (s *controlSuite) TestControlActivatorsErrors(c *C) {
+	app := s.info.Apps["svc"]
+	_, err := servicestate.Control(s.state, []*snap.AppInfo{app}, &servicestate.Instruction{
+		Action:     "start",
+		Names:      []string{"test-snap.svc"},
+		Activators: []string{"other-sock"},
+	}, nil)
+	c.Check(err, ErrorMatches, `service "test-snap.svc" has no socket or timer "other-sock"`)
+
+	_, err = servicestate.Control(s.state, []*snap.AppInfo{app}, &servicestate.Instruction{
+		Action:         "restart",
+		Names:          []string{"test-snap.svc"},
+		Activators:     []string{"sock"},
+		RestartOptions: client.RestartOptions{Reload: true},
+	}, nil)
+	c.Check(err, ErrorMatches, `cannot reload sockets or timers`)
+}
@@ -30,6 +30,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/client"
@@ -61,6 +62,8 @@ var (
 // Flags encompasses extra flags for snapshots backend Save.
 type Flags struct {
 	Auto bool
+	// Encrypt the archives with the key bound to the device.
+	Encrypt bool
 }
 
 // Iter loops over all snapshots in the snapshots directory, applying the given
@@ -92,6 +95,11 @@ func Iter(ctx context.Context, f func(*Reader) error) error {
 				break
 			}
 
+			if strings.HasPrefix(name, ".") {
+				// not a snapshot, e.g. an import in progress
+				continue
+			}
+
 			filename := filepath.Join(dirs.SnapshotsDir, name)
 			reader, openError := backendOpen(filename)
 			// reader can be non-nil even when openError is not nil (in
@@ -162,9 +170,10 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 		return nil, err
 	}
 
-	var auto bool
+	var auto, encrypt bool
 	if flags != nil {
 		auto = flags.Auto
+		encrypt = flags.Encrypt
 	}
 
 	snapshot := &client.Snapshot{
@@ -181,6 +190,19 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 		Auto:     auto,
 	}
 
+	var key *archiveKey
+	if encrypt {
+		var err error
+		key, err = deviceArchiveKey()
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Encryption = &client.SnapshotEncryption{
+			Cipher: archiveCipher,
+			KeyID:  key.id,
+		}
+	}
+
 	aw, err := osutil.NewAtomicFile(Filename(snapshot), 0600, 0, osutil.NoChown, osutil.NoChown)
 	if err != nil {
 		return nil, err
@@ -190,7 +212,7 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 
 	w := zip.NewWriter(aw)
 	defer w.Close() // note this does not close the file descriptor (that's done by hand on the atomic writer, above)
-	if err := addDirToZip(ctx, snapshot, w, "root", archiveName, si.DataDir()); err != nil {
+	if err := addDirToZip(ctx, snapshot, w, "root", archiveName, si.DataDir(), key); err != nil {
 		return nil, err
 	}
 
@@ -200,27 +222,14 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 	}
 
 	for _, usr := range users {
-		if err := addDirToZip(ctx, snapshot, w, usr.Username, userArchiveName(usr), si.UserDataDir(usr.HomeDir)); err != nil {
+		if err := addDirToZip(ctx, snapshot, w, usr.Username, userArchiveName(usr), si.UserDataDir(usr.HomeDir), key); err != nil {
 			return nil, err
 		}
 	}
 
-	metaWriter, err := w.Create(metadataName)
-	if err != nil {
-		return nil, err
-	}
-
-	hasher := crypto.SHA3_384.New()
-	enc := json.NewEncoder(io.MultiWriter(metaWriter, hasher))
-	if err := enc.Encode(snapshot); err != nil {
+	if err := writeMeta(w, snapshot); err != nil {
 		return nil, err
 	}
-
-	hashWriter, err := w.Create(metaHashName)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Fprintf(hashWriter, "%x\n", hasher.Sum(nil))
 	if err := w.Close(); err != nil {
 		return nil, err
 	}
@@ -236,9 +245,33 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 	return snapshot, nil
 }
 
+// writeMeta adds the metadata of the snapshot, and its hash, to the
+// snapshot zip file.
+func writeMeta(w *zip.Writer, snapshot *client.Snapshot) error {
+	metaWriter, err := w.Create(metadataName)
+	if err != nil {
+		return err
+	}
+
+	hasher := crypto.SHA3_384.New()
+	enc := json.NewEncoder(io.MultiWriter(metaWriter, hasher))
+	if err := enc.Encode(snapshot); err != nil {
+		return err
+	}
+
+	hashWriter, err := w.Create(metaHashName)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(hashWriter, "%x\n", hasher.Sum(nil))
+	return err
+}
+
 var isTesting = osutil.GetenvBool("SNAPPY_TESTING")
 
-func addDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username string, entry, dir string) error {
+// addDirToZip adds the archive of the data of the snapshot in dir to
+// the zip file, encrypted with key if not nil.
+func addDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username string, entry, dir string, key *archiveKey) error {
 	parent, revdir := filepath.Split(dir)
 	exists, isDir, err := osutil.DirExists(parent)
 	if err != nil {
@@ -301,8 +334,19 @@ func addDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer,
 	var sz sizer
 	hasher := crypto.SHA3_384.New()
 
+	// the hash and size are of the archive as stored
+	var out io.Writer = io.MultiWriter(archiveWriter, hasher, &sz)
+	var encWriter io.WriteCloser
+	if key != nil {
+		encWriter, err = newEncryptingWriter(out, key)
+		if err != nil {
+			return err
+		}
+		out = encWriter
+	}
+
 	cmd := tarAsUser(username, tarArgs...)
-	cmd.Stdout = io.MultiWriter(archiveWriter, hasher, &sz)
+	cmd.Stdout = out
 	matchCounter := &strutil.MatchCounter{N: 1}
 	cmd.Stderr = matchCounter
 	if isTesting {
@@ -316,6 +360,11 @@ func addDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer,
 		}
 		return fmt.Errorf("tar failed: %v", err)
 	}
+	if encWriter != nil {
+		if err := encWriter.Close(); err != nil {
+			return err
+		}
+	}
 
 	snapshot.SHA3_384[entry] = fmt.Sprintf("%x", hasher.Sum(nil))
 	snapshot.Size += sz.size
@@ -0,0 +1,389 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/secboot"
+)
+
+const (
+	// archiveCipher is the cipher used to encrypt the archives.
+	archiveCipher = "aes-256-gcm"
+
+	// the archives are encrypted in chunks of this size, each
+	// sealed on its own, so that they can be streamed
+	encChunkSize = 64 * 1024
+	// each archive starts with a random prefix for the nonces of
+	// its chunks, that end with the chunk counter
+	encNoncePrefixSize = 8
+
+	deviceSecretSize = 32
+)
+
+// the purpose the device secret is used for, to derive the key
+var archiveKeyContext = []byte("snapd snapshot archives")
+
+// An archiveKey is a key the archives of snapshots are encrypted
+// with.
+type archiveKey struct {
+	id  string
+	key []byte
+}
+
+func deviceSecretFile() string {
+	return filepath.Join(dirs.SnapDeviceDir, "snapshots-secret")
+}
+
+func sealedDeviceSecretFile() string {
+	return filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed")
+}
+
+var (
+	secbootTPMAvailable  = secboot.TPMAvailable
+	secbootSealToTPM     = secboot.SealToTPM
+	secbootUnsealFromTPM = secboot.UnsealFromTPM
+)
+
+var (
+	// deviceSecretMu serialises the creation of the device secret
+	// by the snapshot operations running at the same time.
+	deviceSecretMu sync.Mutex
+
+	errDeviceSecretExists = errors.New("device secret for snapshots was stored concurrently")
+)
+
+// deviceSecret returns the device secret, creating it if needed. Where
+// the device has a TPM the secret is only stored sealed with it, so
+// that a copy of the disk is not enough to decrypt snapshots; it is not
+// bound to the boot chain, as snapshots must survive boot updates. A
+// secret stored in the clear before is sealed on first use.
+func deviceSecret() ([]byte, error) {
+	deviceSecretMu.Lock()
+	defer deviceSecretMu.Unlock()
+
+	secret, err := loadOrCreateDeviceSecret()
+	if err == errDeviceSecretExists {
+		// another process stored the secret first, use that one
+		secret, err = loadOrCreateDeviceSecret()
+	}
+	return secret, err
+}
+
+func loadOrCreateDeviceSecret() ([]byte, error) {
+	sealed, err := ioutil.ReadFile(sealedDeviceSecretFile())
+	if err == nil {
+		secret, err := secbootUnsealFromTPM(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unseal device secret for snapshots: %v", err)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read device secret for snapshots: %v", err)
+	}
+
+	secret, err := ioutil.ReadFile(deviceSecretFile())
+	inClear := err == nil
+	if os.IsNotExist(err) {
+		secret = make([]byte, deviceSecretSize)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return nil, fmt.Errorf("cannot create device secret for snapshots: %v", err)
+		}
+		if err := os.MkdirAll(dirs.SnapDeviceDir, 0755); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read device secret for snapshots: %v", err)
+	}
+
+	if secbootTPMAvailable() != nil {
+		if !inClear {
+			if err := storeDeviceSecretFile(deviceSecretFile(), secret); err != nil {
+				return nil, err
+			}
+		}
+		return secret, nil
+	}
+
+	sealed, err = secbootSealToTPM(secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seal device secret for snapshots: %v", err)
+	}
+	if err := storeDeviceSecretFile(sealedDeviceSecretFile(), sealed); err != nil {
+		return nil, err
+	}
+	if inClear {
+		if err := os.Remove(deviceSecretFile()); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove device secret for snapshots stored in the clear: %v", err)
+		}
+	}
+	return secret, nil
+}
+
+// storeDeviceSecretFile creates the given file with the data, failing
+// with errDeviceSecretExists if it exists already. The data is written
+// to a temporary file that is then linked into place, which like
+// O_EXCL fails if the file exists, so that the file is never seen
+// partially written.
+func storeDeviceSecretFile(fname string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname)+".")
+	if err != nil {
+		return fmt.Errorf("cannot store device secret for snapshots: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Link(f.Name(), fname)
+		if os.IsExist(err) {
+			return errDeviceSecretExists
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot store device secret for snapshots: %v", err)
+	}
+	return nil
+}
+
+// deviceArchiveKeyImpl returns the key bound to this device that
+// archives are encrypted with. It is derived from a device secret that
+// is created on first use and never leaves the device, so that
+// encrypted snapshots can only be restored on the device that took
+// them.
+func deviceArchiveKeyImpl() (*archiveKey, error) {
+	secret, err := deviceSecret()
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != deviceSecretSize {
+		return nil, fmt.Errorf("cannot use device secret for snapshots: invalid size %d", len(secret))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(archiveKeyContext)
+	key := mac.Sum(nil)
+
+	hasher := crypto.SHA3_384.New()
+	hasher.Write(key)
+	return &archiveKey{
+		id:  fmt.Sprintf("%.16x", hasher.Sum(nil)),
+		key: key,
+	}, nil
+}
+
+var deviceArchiveKey = deviceArchiveKeyImpl
+
+// archiveKeyFor returns the key the archives of the snapshot are
+// encrypted with, or nil if they are not encrypted.
+func archiveKeyFor(snapshot *client.Snapshot) (*archiveKey, error) {
+	enc := snapshot.Encryption
+	if enc == nil {
+		return nil, nil
+	}
+	if enc.Cipher != archiveCipher {
+		return nil, fmt.Errorf("unsupported snapshot cipher %q", enc.Cipher)
+	}
+	key, err := deviceArchiveKey()
+	if err != nil {
+		return nil, err
+	}
+	if key.id != enc.KeyID {
+		return nil, fmt.Errorf("snapshot is encrypted with key %q which is not available on this device", enc.KeyID)
+	}
+	return key, nil
+}
+
+func newArchiveAEAD(key *archiveKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkData returns the additional data to authenticate for a chunk,
+// which records whether it is the last one, to detect truncation.
+func chunkData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptingWriter returns a writer encrypting what is written to
+// it with the key into w. It must be closed to write out the last
+// chunk.
+func newEncryptingWriter(w io.Writer, key *archiveKey) (io.WriteCloser, error) {
+	aead, err := newArchiveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce[:encNoncePrefixSize]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce[:encNoncePrefixSize]); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (e *encryptingWriter) seal(final bool) error {
+	binary.BigEndian.PutUint32(e.nonce[encNoncePrefixSize:], e.counter)
+	e.counter++
+	if e.counter == 0 {
+		return errors.New("cannot encrypt archive: too big")
+	}
+	if _, err := e.w.Write(e.aead.Seal(nil, e.nonce, e.buf, chunkData(final))); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is sealed only once there is more data,
+		// so that the last chunk is never empty unless all is
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+type decryptingReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+// newDecryptingReader returns a reader decrypting what is read from r
+// with the key.
+func newDecryptingReader(r io.Reader, key *archiveKey) (io.Reader, error) {
+	aead, err := newArchiveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce[:encNoncePrefixSize]); err != nil {
+		return nil, fmt.Errorf("cannot decrypt archive: %v", err)
+	}
+	return &decryptingReader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		nonce: nonce,
+		chunk: make([]byte, encChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptingReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	final := false
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		final = true
+	case io.EOF:
+		return errors.New("cannot decrypt archive: unexpected end of data")
+	default:
+		return err
+	}
+
+	binary.BigEndian.PutUint32(d.nonce[encNoncePrefixSize:], d.counter)
+	d.counter++
+	plain, err := d.aead.Open(d.chunk[:0], d.nonce, d.chunk[:n], chunkData(final))
+	if err != nil {
+		return fmt.Errorf("cannot decrypt archive: %v", err)
+	}
+	d.buf = plain
+	d.done = final
+	return nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
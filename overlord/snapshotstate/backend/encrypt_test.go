@@ -0,0 +1,281 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type encryptSuite struct{}
+
+var _ = check.Suite(&encryptSuite{})
+
+var testKey = bytes.Repeat([]byte{42}, 32)
+
+func encrypt(c *check.C, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := backend.NewEncryptingWriter(&buf, testKey)
+	c.Assert(err, check.IsNil)
+	// write in odd sized bits
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := w.Write(data[:n])
+		c.Assert(err, check.IsNil)
+		data = data[n:]
+	}
+	c.Assert(w.Close(), check.IsNil)
+	return buf.Bytes()
+}
+
+func decrypt(data []byte) ([]byte, error) {
+	r, err := backend.NewDecryptingReader(bytes.NewReader(data), testKey)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (s *encryptSuite) TestRoundtrip(c *check.C) {
+	for _, size := range []int{0, 1, backend.EncChunkSize - 1, backend.EncChunkSize, backend.EncChunkSize + 1, 3*backend.EncChunkSize + 17} {
+		comm := check.Commentf("%d", size)
+		data := bytes.Repeat([]byte("snap"), size/4+1)[:size]
+
+		enc := encrypt(c, data)
+		c.Check(bytes.Contains(enc, []byte("snapsnap")), check.Equals, false, comm)
+
+		dec, err := decrypt(enc)
+		c.Assert(err, check.IsNil, comm)
+		c.Check(dec, check.DeepEquals, data, comm)
+	}
+}
+
+func (s *encryptSuite) TestRandomNonces(c *check.C) {
+	data := []byte("hello")
+	c.Check(encrypt(c, data), check.Not(check.DeepEquals), encrypt(c, data))
+}
+
+func (s *encryptSuite) TestTampered(c *check.C) {
+	enc := encrypt(c, []byte("hello world"))
+	enc[len(enc)-1] ^= 1
+
+	_, err := decrypt(enc)
+	c.Check(err, check.ErrorMatches, "cannot decrypt archive: .*authentication failed")
+}
+
+func (s *encryptSuite) TestTruncated(c *check.C) {
+	enc := encrypt(c, bytes.Repeat([]byte{1}, 2*backend.EncChunkSize+10))
+
+	// dropping whole chunks is detected
+	_, err := decrypt(enc[:len(enc)-10-16])
+	c.Check(err, check.ErrorMatches, "cannot decrypt archive: .*authentication failed")
+
+	_, err = decrypt(enc[:3])
+	c.Check(err, check.ErrorMatches, "cannot decrypt archive: unexpected EOF")
+
+	_, err = decrypt(enc[:8])
+	c.Check(err, check.ErrorMatches, "cannot decrypt archive: unexpected end of data")
+}
+
+func (s *encryptSuite) TestWrongKey(c *check.C) {
+	enc := encrypt(c, []byte("hello world"))
+
+	r, err := backend.NewDecryptingReader(bytes.NewReader(enc), bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, check.IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Check(err, check.ErrorMatches, "cannot decrypt archive: .*authentication failed")
+}
+
+func (s *snapshotSuite) TestEncryptedRoundtrip(c *check.C) {
+	if os.Geteuid() == 0 {
+		c.Skip("this test cannot run as root (runuser will fail)")
+	}
+	logger.SimpleSetup()
+
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.Save(context.TODO(), 12, info, nil, []string{"snapuser"}, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(shw.Encryption, check.NotNil)
+	c.Check(shw.Encryption.Cipher, check.Equals, "aes-256-gcm")
+	c.Check(shw.Encryption.KeyID, check.HasLen, 32)
+
+	secret, err := ioutil.ReadFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"))
+	c.Assert(err, check.IsNil)
+	c.Check(secret, check.HasLen, 32)
+
+	shr, err := backend.Open(backend.Filename(shw))
+	c.Assert(err, check.IsNil)
+	defer shr.Close()
+	c.Check(shr.Encryption, check.DeepEquals, shw.Encryption)
+	// the stored archives can be checked without the key
+	c.Check(shr.Check(context.TODO(), nil), check.IsNil)
+
+	// no plaintext in the snapshot
+	data, err := ioutil.ReadFile(backend.Filename(shw))
+	c.Assert(err, check.IsNil)
+	c.Check(bytes.Contains(data, []byte("canary")), check.Equals, false)
+
+	// same device, new data
+	newroot := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(newroot, "home/snapuser"), 0755), check.IsNil)
+	dirs.SetRootDir(newroot)
+	c.Assert(os.MkdirAll(dirs.SnapDeviceDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"), secret, 0600), check.IsNil)
+
+	rs, err := shr.Restore(context.TODO(), snap.R(0), nil, logger.Debugf)
+	c.Assert(err, check.IsNil)
+	rs.Cleanup()
+	restored, err := ioutil.ReadFile(filepath.Join(info.DataDir(), "foo"))
+	c.Assert(err, check.IsNil)
+	c.Check(string(restored), check.Equals, "versioned system canary\n")
+}
+
+func (s *snapshotSuite) TestEncryptedRestoreOtherDevice(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+
+	shr, err := backend.Open(backend.Filename(shw))
+	c.Assert(err, check.IsNil)
+	defer shr.Close()
+
+	// another device has another secret
+	c.Assert(os.Remove(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret")), check.IsNil)
+
+	_, err = shr.Restore(context.TODO(), snap.R(0), nil, logger.Debugf)
+	c.Check(err, check.ErrorMatches, `cannot restore snapshot ".*": snapshot is encrypted with key ".*" which is not available on this device`)
+}
+
+func mockTPM(c *check.C) func() {
+	return backend.MockSecbootTPM(func() error {
+		return nil
+	}, func(secret []byte) ([]byte, error) {
+		return append([]byte("sealed:"), secret...), nil
+	}, func(data []byte) ([]byte, error) {
+		c.Assert(bytes.HasPrefix(data, []byte("sealed:")), check.Equals, true)
+		return data[len("sealed:"):], nil
+	})
+}
+
+func (s *snapshotSuite) TestEncryptedSecretSealedWithTPM(c *check.C) {
+	defer mockTPM(c)()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+
+	// the secret is only stored sealed
+	c.Check(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"), testutil.FileAbsent)
+	sealed, err := ioutil.ReadFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed"))
+	c.Assert(err, check.IsNil)
+	c.Check(sealed, check.HasLen, len("sealed:")+32)
+
+	// and unsealed when used again
+	shw2, err := backend.Save(context.TODO(), 13, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+	c.Check(shw2.Encryption.KeyID, check.Equals, shw.Encryption.KeyID)
+}
+
+func (s *snapshotSuite) TestEncryptedSecretInClearSealedWithTPM(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+	secret, err := ioutil.ReadFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"))
+	c.Assert(err, check.IsNil)
+
+	// the secret stored in the clear is sealed once there is a TPM
+	defer mockTPM(c)()
+	shw2, err := backend.Save(context.TODO(), 13, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+	c.Check(shw2.Encryption.KeyID, check.Equals, shw.Encryption.KeyID)
+	c.Check(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"), testutil.FileAbsent)
+	c.Check(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed"), testutil.FileEquals, append([]byte("sealed:"), secret...))
+}
+
+func (s *snapshotSuite) TestEncryptedSecretStoredConcurrently(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+	other := bytes.Repeat([]byte("x"), 32)
+	otherSealed := append([]byte("sealed:"), other...)
+
+	restore := backend.MockSecbootTPM(func() error {
+		return nil
+	}, func(secret []byte) ([]byte, error) {
+		// another process stores its secret in the meantime
+		c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed"), otherSealed, 0600), check.IsNil)
+		return append([]byte("sealed:"), secret...), nil
+	}, func(data []byte) ([]byte, error) {
+		c.Assert(bytes.HasPrefix(data, []byte("sealed:")), check.Equals, true)
+		return data[len("sealed:"):], nil
+	})
+	defer restore()
+
+	shw, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+
+	// the secret of the other process is used and kept
+	c.Check(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed"), testutil.FileEquals, otherSealed)
+	matches, err := filepath.Glob(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret*"))
+	c.Assert(err, check.IsNil)
+	c.Check(matches, check.HasLen, 1)
+
+	defer mockTPM(c)()
+	shw2, err := backend.Save(context.TODO(), 13, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Assert(err, check.IsNil)
+	c.Check(shw2.Encryption.KeyID, check.Equals, shw.Encryption.KeyID)
+}
+
+func (s *snapshotSuite) TestEncryptedSecretSealingErrors(c *check.C) {
+	restore := backend.MockSecbootTPM(func() error {
+		return nil
+	}, func([]byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}, func([]byte) ([]byte, error) {
+		return nil, errors.New("bang")
+	})
+	defer restore()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	_, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Check(err, check.ErrorMatches, ".*cannot seal device secret for snapshots: boom")
+	c.Check(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret"), testutil.FileAbsent)
+
+	c.Assert(os.MkdirAll(dirs.SnapDeviceDir, 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapDeviceDir, "snapshots-secret.sealed"), []byte("sealed"), 0600), check.IsNil)
+	_, err = backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{Encrypt: true})
+	c.Check(err, check.ErrorMatches, ".*cannot unseal device secret for snapshots: bang")
+}
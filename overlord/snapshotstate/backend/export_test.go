@@ -20,6 +20,7 @@
 package backend
 
 import (
+	"io"
 	"os"
 	"os/user"
 
@@ -95,3 +96,35 @@ func SetUserWrapper(newUserWrapper string) (restore func()) {
 		userWrapper = oldUserWrapper
 	}
 }
+
+func MockFreeSpace(newFreeSpace func(string) (uint64, error)) (restore func()) {
+	oldFreeSpace := osutilFreeSpace
+	osutilFreeSpace = newFreeSpace
+	return func() {
+		osutilFreeSpace = oldFreeSpace
+	}
+}
+
+const EncChunkSize = encChunkSize
+
+func NewEncryptingWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	return newEncryptingWriter(w, &archiveKey{key: key})
+}
+
+func NewDecryptingReader(r io.Reader, key []byte) (io.Reader, error) {
+	return newDecryptingReader(r, &archiveKey{key: key})
+}
+
+func MockSecbootTPM(available func() error, seal func([]byte) ([]byte, error), unseal func([]byte) ([]byte, error)) func() {
+	oldAvailable := secbootTPMAvailable
+	oldSeal := secbootSealToTPM
+	oldUnseal := secbootUnsealFromTPM
+	secbootTPMAvailable = available
+	secbootSealToTPM = seal
+	secbootUnsealFromTPM = unseal
+	return func() {
+		secbootTPMAvailable = oldAvailable
+		secbootSealToTPM = oldSeal
+		secbootUnsealFromTPM = oldUnseal
+	}
+}
@@ -186,6 +186,11 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 		}
 	}()
 
+	key, err := archiveKeyFor(&r.Snapshot)
+	if err != nil {
+		return rs, fmt.Errorf("cannot restore snapshot %q: %v", r.Name(), err)
+	}
+
 	sort.Strings(usernames)
 	isRoot := sys.Geteuid() == 0
 	si := snap.MinimalPlaceInfo(r.Snap, r.Revision)
@@ -297,7 +302,14 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 
 		expectedHash := r.SHA3_384[entry]
 
-		tr := io.TeeReader(body, io.MultiWriter(hasher, &sz))
+		// the hash and size are of the archive as stored
+		var tr io.Reader = io.TeeReader(body, io.MultiWriter(hasher, &sz))
+		if key != nil {
+			tr, err = newDecryptingReader(tr, key)
+			if err != nil {
+				return rs, err
+			}
+		}
 
 		// resist the temptation of using archive/tar unless it's proven
 		// that calling out to tar has issues -- there are a lot of
@@ -0,0 +1,436 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+const (
+	exportManifestName = "export.json"
+	exportFormat       = 1
+)
+
+var osutilFreeSpace = osutil.FreeSpace
+
+// InvalidExportError is returned by Import when the export cannot be
+// imported because of its contents.
+type InvalidExportError struct {
+	Msg string
+}
+
+func (e *InvalidExportError) Error() string {
+	return e.Msg
+}
+
+func invalidExportf(format string, a ...interface{}) error {
+	return &InvalidExportError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// exportFile describes a snapshot file in an export.
+type exportFile struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	SHA3_384 string `json:"sha3-384"`
+}
+
+// exportManifest is the first member of an export, describing the
+// snapshot files that follow it.
+type exportManifest struct {
+	Format int          `json:"format"`
+	SetID  uint64       `json:"set-id"`
+	Files  []exportFile `json:"files"`
+}
+
+// A SnapshotExport is a snapshot set ready to be exported as a single
+// tar stream, made of a manifest followed by the snapshot files.
+type SnapshotExport struct {
+	paths    []string
+	manifest []byte
+	modTime  time.Time
+	size     int64
+}
+
+// NewSnapshotExport prepares the export of the snapshot set with the
+// given ID, hashing its files for the manifest.
+func NewSnapshotExport(ctx context.Context, setID uint64) (*SnapshotExport, error) {
+	var paths []string
+	err := Iter(ctx, func(r *Reader) error {
+		if r.SetID == setID {
+			paths = append(paths, r.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, client.ErrSnapshotSetNotFound
+	}
+	sort.Strings(paths)
+
+	manifest := exportManifest{
+		Format: exportFormat,
+		SetID:  setID,
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		hasher := crypto.SHA3_384.New()
+		size, err := io.Copy(io.MultiWriter(osutil.ContextWriter(ctx), hasher), f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash snapshot file %q: %v", path, err)
+		}
+		manifest.Files = append(manifest.Files, exportFile{
+			Name:     filepath.Base(path),
+			Size:     size,
+			SHA3_384: fmt.Sprintf("%x", hasher.Sum(nil)),
+		})
+	}
+
+	se := &SnapshotExport{
+		paths:   paths,
+		modTime: time.Now().Truncate(time.Second),
+	}
+	se.manifest, err = json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// the size of the tar stream is known upfront so that the
+	// receiving end can check it has enough space for it
+	size, err := se.entrySize(exportManifestName, int64(len(se.manifest)))
+	if err != nil {
+		return nil, err
+	}
+	se.size += size
+	for _, f := range manifest.Files {
+		size, err := se.entrySize(f.Name, f.Size)
+		if err != nil {
+			return nil, err
+		}
+		se.size += size
+	}
+	// the end of the archive is marked by two zero blocks
+	se.size += 2 * 512
+
+	return se, nil
+}
+
+func (se *SnapshotExport) header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     size,
+		ModTime:  se.modTime,
+	}
+}
+
+// entrySize returns the size of the tar entry for a file of the given
+// name and size, header and padding included.
+func (se *SnapshotExport) entrySize(name string, size int64) (int64, error) {
+	var sz sizer
+	if err := tar.NewWriter(&sz).WriteHeader(se.header(name, size)); err != nil {
+		return 0, err
+	}
+	return sz.size + (size+511)/512*512, nil
+}
+
+// Size returns the size of the export stream.
+func (se *SnapshotExport) Size() int64 {
+	return se.size
+}
+
+// StreamTo writes the export stream to w.
+func (se *SnapshotExport) StreamTo(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(se.header(exportManifestName, int64(len(se.manifest)))); err != nil {
+		return err
+	}
+	if _, err := tw.Write(se.manifest); err != nil {
+		return err
+	}
+	for _, path := range se.paths {
+		if err := se.streamFile(ctx, tw, path); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (se *SnapshotExport) streamFile(ctx context.Context, tw *tar.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(se.header(filepath.Base(path), fi.Size())); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.MultiWriter(tw, osutil.ContextWriter(ctx)), f)
+	return err
+}
+
+// Import imports the snapshot set exported as the tar stream read from
+// r as the set with the given ID, after checking it fits in the
+// snapshots directory and the integrity of its files. It returns the
+// names of the snaps in the set. Problems with the export itself are
+// reported as an InvalidExportError.
+func Import(ctx context.Context, setID uint64, r io.Reader) (snapNames []string, err error) {
+	if err := os.MkdirAll(dirs.SnapshotsDir, 0700); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, invalidExportf("cannot read snapshot export: %v", err)
+	}
+	if hdr.Name != exportManifestName {
+		return nil, invalidExportf("cannot import snapshot: export does not start with a manifest")
+	}
+	var manifest exportManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, invalidExportf("cannot read snapshot export manifest: %v", err)
+	}
+	if manifest.Format != exportFormat {
+		return nil, invalidExportf("cannot import snapshot: unsupported export format %d", manifest.Format)
+	}
+
+	expected := make(map[string]exportFile, len(manifest.Files))
+	var total, largest int64
+	for _, f := range manifest.Files {
+		// the sum must not overflow either
+		if f.Size < 0 || f.Size > math.MaxInt64/2-total {
+			return nil, invalidExportf("cannot import snapshot: invalid size of %q in the export manifest (%d)", f.Name, f.Size)
+		}
+		expected[f.Name] = f
+		total += f.Size
+		if f.Size > largest {
+			largest = f.Size
+		}
+	}
+	// each file is received and then rewritten for its new set ID
+	needed := total + largest
+	free, err := osutilFreeSpace(dirs.SnapshotsDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check free space: %v", err)
+	}
+	if uint64(needed) > free {
+		return nil, invalidExportf("cannot import snapshot: not enough free space in %s (need %s, have %s)", dirs.SnapshotsDir, strutil.SizeToStr(needed), strutil.SizeToStr(int64(free)))
+	}
+
+	var imported []string
+	defer func() {
+		if err != nil {
+			for _, path := range imported {
+				os.Remove(path)
+			}
+		}
+	}()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, invalidExportf("cannot read snapshot export: %v", err)
+		}
+		ef, ok := expected[hdr.Name]
+		if !ok {
+			return nil, invalidExportf("cannot import snapshot: unexpected file %q in export", hdr.Name)
+		}
+		if hdr.Size != ef.Size {
+			return nil, invalidExportf("cannot import snapshot: size of %q (%d) does not match the export manifest (%d)", ef.Name, hdr.Size, ef.Size)
+		}
+		delete(expected, hdr.Name)
+		path, snapName, err := importFile(ctx, setID, tr, &ef)
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, path)
+		snapNames = append(snapNames, snapName)
+	}
+	if len(expected) != 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, invalidExportf("cannot import snapshot: export is missing %s", strutil.Quoted(missing))
+	}
+
+	sort.Strings(snapNames)
+	return snapNames, nil
+}
+
+// importFile receives the snapshot file read from r, checks it against
+// the manifest and its own hashes, and writes it as part of the set
+// with the given ID.
+func importFile(ctx context.Context, setID uint64, r io.Reader, ef *exportFile) (path, snapName string, err error) {
+	tmp, err := ioutil.TempFile(dirs.SnapshotsDir, ".import-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// read one byte more than expected to notice a file that is too
+	// big without filling the disk with it
+	hasher := crypto.SHA3_384.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher, osutil.ContextWriter(ctx)), io.LimitReader(r, ef.Size+1))
+	if err != nil {
+		if err == ctx.Err() {
+			return "", "", err
+		}
+		if _, ok := err.(*os.PathError); ok {
+			return "", "", fmt.Errorf("cannot import snapshot: %v", err)
+		}
+		return "", "", invalidExportf("cannot read snapshot export: %v", err)
+	}
+	if size != ef.Size {
+		return "", "", invalidExportf("cannot import snapshot: size of %q does not match the export manifest (%d)", ef.Name, ef.Size)
+	}
+	if hash := fmt.Sprintf("%x", hasher.Sum(nil)); hash != ef.SHA3_384 {
+		return "", "", invalidExportf("cannot import snapshot: hash of %q (%.7s…) does not match the export manifest (%.7s…)", ef.Name, hash, ef.SHA3_384)
+	}
+
+	reader, err := Open(tmp.Name())
+	if err != nil {
+		return "", "", invalidExportf("cannot import snapshot: %q is broken: %v", ef.Name, err)
+	}
+	defer reader.Close()
+	if err := reader.Check(ctx, nil); err != nil {
+		if err == ctx.Err() {
+			return "", "", err
+		}
+		return "", "", invalidExportf("cannot import snapshot: %q is broken: %v", ef.Name, err)
+	}
+
+	path, err = rewriteWithSetID(reader, setID)
+	if err != nil {
+		if _, ok := err.(*InvalidExportError); ok {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("cannot import snapshot: %v", err)
+	}
+	return path, reader.Snap, nil
+}
+
+// rewriteWithSetID writes a copy of the snapshot as part of the set
+// with the given ID, returning the path of the copy.
+func rewriteWithSetID(reader *Reader, setID uint64) (string, error) {
+	snapshot := reader.Snapshot
+	// the name of the copy is built from these, they come from an
+	// untrusted export
+	if err := snap.ValidateInstanceName(snapshot.Snap); err != nil {
+		return "", invalidExportf("cannot import snapshot: %v", err)
+	}
+	if strings.Contains(snapshot.Version, "/") || strings.Contains(snapshot.Version, "..") {
+		return "", invalidExportf("cannot import snapshot: invalid snap version %q", snapshot.Version)
+	}
+	snapshot.SetID = setID
+	path := Filename(&snapshot)
+	if osutil.FileExists(path) {
+		return "", fmt.Errorf("%q already exists", path)
+	}
+
+	fi, err := reader.Stat()
+	if err != nil {
+		return "", err
+	}
+	zr, err := zip.NewReader(reader.File, fi.Size())
+	if err != nil {
+		return "", err
+	}
+
+	aw, err := osutil.NewAtomicFile(path, 0600, 0, osutil.NoChown, osutil.NoChown)
+	if err != nil {
+		return "", err
+	}
+	// if things worked, we'll commit (and Cancel becomes a NOP)
+	defer aw.Cancel()
+
+	w := zip.NewWriter(aw)
+	defer w.Close()
+	for _, f := range zr.File {
+		if f.Name == metadataName || f.Name == metaHashName {
+			continue
+		}
+		if err := copyZipMember(w, f); err != nil {
+			return "", err
+		}
+	}
+	if err := writeMeta(w, &snapshot); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Commit(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func copyZipMember(w *zip.Writer, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// keep the modification time and mode of the member; the
+	// timestamp extra field is added back by CreateHeader
+	hdr := f.FileHeader
+	hdr.Extra = nil
+	mw, err := w.CreateHeader(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(mw, rc)
+	return err
+}
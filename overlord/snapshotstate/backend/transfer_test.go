@@ -0,0 +1,248 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (s *snapshotSuite) saveForExport(c *check.C) *client.Snapshot {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+	shw, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{})
+	c.Assert(err, check.IsNil)
+	return shw
+}
+
+func (s *snapshotSuite) export(c *check.C, setID uint64) *bytes.Buffer {
+	se, err := backend.NewSnapshotExport(context.TODO(), setID)
+	c.Assert(err, check.IsNil)
+	buf := new(bytes.Buffer)
+	c.Assert(se.StreamTo(context.TODO(), buf), check.IsNil)
+	c.Check(int64(buf.Len()), check.Equals, se.Size())
+	return buf
+}
+
+func (s *snapshotSuite) TestExportImportRoundtrip(c *check.C) {
+	shw := s.saveForExport(c)
+	buf := s.export(c, 12)
+
+	snapNames, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"hello-snap"})
+
+	sets, err := backend.List(context.TODO(), 0, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(sets, check.HasLen, 2)
+	c.Check(sets[0].ID, check.Equals, uint64(12))
+	c.Check(sets[1].ID, check.Equals, uint64(13))
+
+	r, err := backend.Open(filepath.Join(dirs.SnapshotsDir, "13_hello-snap_v1.33_42.zip"))
+	c.Assert(err, check.IsNil)
+	defer r.Close()
+	c.Check(r.SetID, check.Equals, uint64(13))
+	c.Check(r.Snap, check.Equals, shw.Snap)
+	c.Check(r.SHA3_384, check.DeepEquals, shw.SHA3_384)
+	c.Check(r.Check(context.TODO(), nil), check.IsNil)
+}
+
+func (s *snapshotSuite) TestImportBadVersion(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1..33"}
+	_, err := backend.Save(context.TODO(), 12, info, nil, nil, &backend.Flags{})
+	c.Assert(err, check.IsNil)
+	buf := s.export(c, 12)
+
+	_, err = backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: invalid snap version "v1..33"`)
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) TestExportNotFound(c *check.C) {
+	_, err := backend.NewSnapshotExport(context.TODO(), 42)
+	c.Check(err, check.Equals, client.ErrSnapshotSetNotFound)
+}
+
+func (s *snapshotSuite) TestImportNotEnoughSpace(c *check.C) {
+	s.saveForExport(c)
+	buf := s.export(c, 12)
+
+	defer backend.MockFreeSpace(func(path string) (uint64, error) {
+		c.Check(path, check.Equals, dirs.SnapshotsDir)
+		return 10, nil
+	})()
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: not enough free space in .* \(need .*, have 10B\)`)
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) checkOnlySet12(c *check.C) {
+	sets, err := backend.List(context.TODO(), 0, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(sets, check.HasLen, 1)
+	c.Check(sets[0].ID, check.Equals, uint64(12))
+}
+
+// tamper rewrites the export in buf with the contents of the member
+// with the given name passed through f.
+func tamper(c *check.C, buf *bytes.Buffer, name string, f func([]byte) []byte) *bytes.Buffer {
+	out := new(bytes.Buffer)
+	tr := tar.NewReader(buf)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		var content bytes.Buffer
+		_, err = content.ReadFrom(tr)
+		c.Assert(err, check.IsNil)
+		data := content.Bytes()
+		if hdr.Name == name {
+			data = f(data)
+			if data == nil {
+				continue
+			}
+			hdr.Size = int64(len(data))
+		}
+		c.Assert(tw.WriteHeader(hdr), check.IsNil)
+		_, err = tw.Write(data)
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tw.Close(), check.IsNil)
+	return out
+}
+
+func (s *snapshotSuite) TestImportHashMismatch(c *check.C) {
+	s.saveForExport(c)
+	buf := tamper(c, s.export(c, 12), "12_hello-snap_v1.33_42.zip", func(data []byte) []byte {
+		data[len(data)/2] ^= 0xff
+		return data
+	})
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: hash of "12_hello-snap_v1.33_42.zip" .* does not match the export manifest .*`)
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) TestImportMissingFile(c *check.C) {
+	s.saveForExport(c)
+	buf := tamper(c, s.export(c, 12), "12_hello-snap_v1.33_42.zip", func([]byte) []byte {
+		return nil
+	})
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: export is missing "12_hello-snap_v1.33_42.zip"`)
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) TestImportNoManifest(c *check.C) {
+	s.saveForExport(c)
+	buf := tamper(c, s.export(c, 12), "export.json", func([]byte) []byte {
+		return nil
+	})
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: export does not start with a manifest`)
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) TestImportKeepsMemberAttributes(c *check.C) {
+	s.saveForExport(c)
+	// give the members of the snapshot a modification time and a mode
+	fn := filepath.Join(dirs.SnapshotsDir, "12_hello-snap_v1.33_42.zip")
+	mtime := time.Date(2019, 11, 5, 10, 20, 30, 0, time.UTC)
+	zr, err := zip.OpenReader(fn)
+	c.Assert(err, check.IsNil)
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for _, f := range zr.File {
+		hdr := f.FileHeader
+		hdr.Extra = nil
+		hdr.Modified = mtime
+		hdr.SetMode(0640)
+		w, err := zw.CreateHeader(&hdr)
+		c.Assert(err, check.IsNil)
+		rc, err := f.Open()
+		c.Assert(err, check.IsNil)
+		_, err = io.Copy(w, rc)
+		c.Assert(err, check.IsNil)
+		rc.Close()
+	}
+	c.Assert(zw.Close(), check.IsNil)
+	zr.Close()
+	c.Assert(ioutil.WriteFile(fn, out.Bytes(), 0600), check.IsNil)
+
+	_, err = backend.Import(context.TODO(), 13, s.export(c, 12))
+	c.Assert(err, check.IsNil)
+
+	zr, err = zip.OpenReader(filepath.Join(dirs.SnapshotsDir, "13_hello-snap_v1.33_42.zip"))
+	c.Assert(err, check.IsNil)
+	defer zr.Close()
+	var copied []string
+	for _, f := range zr.File {
+		if f.Name == "meta.json" || f.Name == "meta.sha3_384" {
+			continue
+		}
+		copied = append(copied, f.Name)
+		c.Check(f.Modified.Equal(mtime), check.Equals, true, check.Commentf("%s", f.Name))
+		c.Check(f.Mode(), check.Equals, os.FileMode(0640), check.Commentf("%s", f.Name))
+	}
+	c.Check(copied, check.DeepEquals, []string{"archive.tgz"})
+}
+
+func (s *snapshotSuite) TestImportBadSizeInManifest(c *check.C) {
+	s.saveForExport(c)
+	buf := tamper(c, s.export(c, 12), "export.json", func(data []byte) []byte {
+		return regexp.MustCompile(`"size":\d+`).ReplaceAll(data, []byte(`"size":-1`))
+	})
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: invalid size of "12_hello-snap_v1.33_42.zip" in the export manifest \(-1\)`)
+	c.Check(err, check.FitsTypeOf, &backend.InvalidExportError{})
+	s.checkOnlySet12(c)
+}
+
+func (s *snapshotSuite) TestImportFileTooBig(c *check.C) {
+	s.saveForExport(c)
+	buf := tamper(c, s.export(c, 12), "12_hello-snap_v1.33_42.zip", func(data []byte) []byte {
+		return append(data, make([]byte, 1024)...)
+	})
+
+	_, err := backend.Import(context.TODO(), 13, buf)
+	c.Assert(err, check.ErrorMatches, `cannot import snapshot: size of "12_hello-snap_v1.33_42.zip" \(\d+\) does not match the export manifest \(\d+\)`)
+	c.Check(err, check.FitsTypeOf, &backend.InvalidExportError{})
+	s.checkOnlySet12(c)
+}
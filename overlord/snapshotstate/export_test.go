@@ -22,6 +22,7 @@ package snapshotstate
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"time"
 
 	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
@@ -102,6 +103,14 @@ func MockBackendIter(f func(context.Context, func(*backend.Reader) error) error)
 	}
 }
 
+func MockBackendImport(f func(context.Context, uint64, io.Reader) ([]string, error)) (restore func()) {
+	old := backendImport
+	backendImport = f
+	return func() {
+		backendImport = old
+	}
+}
+
 func MockBackendOpen(f func(string) (*backend.Reader, error)) (restore func()) {
 	old := backendOpen
 	backendOpen = f
@@ -162,3 +171,5 @@ func MockConfigSetSnapConfig(f func(*state.State, string, *json.RawMessage) erro
 func (mgr *SnapshotManager) SetLastForgetExpiredSnapshotTime(t time.Time) {
 	mgr.lastForgetExpiredSnapshotTime = t
 }
+
+var DoPruneScheduledSnapshots = doPruneScheduledSnapshots
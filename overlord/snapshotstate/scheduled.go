@@ -0,0 +1,257 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/timeutil"
+)
+
+var (
+	// maxScheduledSnapshotInterval is the longest time between
+	// scheduled snapshots, whatever the schedule
+	maxScheduledSnapshotInterval = 31 * 24 * time.Hour
+
+	// defaultScheduledSnapshotsKeep is how many scheduled snapshot
+	// sets are kept if neither snapshots.scheduled.keep nor
+	// snapshots.scheduled.max-size are set
+	defaultScheduledSnapshotsKeep = 3
+)
+
+// ensureScheduledSnapshot takes a snapshot of the snaps listed in
+// snapshots.scheduled.snaps (all of them if empty) as a
+// scheduled-snapshot change when snapshots.schedule says it is time.
+func (mgr *SnapshotManager) ensureScheduledSnapshot() error {
+	st := mgr.state
+	st.Lock()
+	defer st.Unlock()
+
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !seeded {
+		return nil
+	}
+
+	tr := config.NewTransaction(st)
+	scheduleStr, err := config.CoreCfg(tr, "snapshots.schedule")
+	if err != nil {
+		return err
+	}
+	if scheduleStr == "" {
+		mgr.nextScheduledSnapshot = time.Time{}
+		return nil
+	}
+	schedule, err := timeutil.ParseSchedule(scheduleStr)
+	if err != nil {
+		// validated when set, should not happen
+		return fmt.Errorf("cannot parse snapshots.schedule: %v", err)
+	}
+
+	var last time.Time
+	err = st.Get("last-scheduled-snapshot", &last)
+	if err == state.ErrNoState {
+		// the first scheduled snapshot happens according to the
+		// schedule from now
+		last = time.Now()
+		st.Set("last-scheduled-snapshot", last)
+	} else if err != nil {
+		return err
+	}
+
+	if mgr.nextScheduledSnapshot.IsZero() || scheduleStr != mgr.lastSnapshotSchedule {
+		mgr.nextScheduledSnapshot = time.Now().Add(timeutil.Next(schedule, last, maxScheduledSnapshotInterval))
+		mgr.lastSnapshotSchedule = scheduleStr
+	}
+	if time.Now().Before(mgr.nextScheduledSnapshot) {
+		return nil
+	}
+
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "scheduled-snapshot" && !chg.Status().Ready() {
+			return nil
+		}
+	}
+
+	snapsStr, err := config.CoreCfg(tr, "snapshots.scheduled.snaps")
+	if err != nil {
+		return err
+	}
+	var snapNames []string
+	for _, name := range strings.Split(snapsStr, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			snapNames = append(snapNames, name)
+		}
+	}
+
+	setID, saved, ts, err := Save(st, snapNames, nil)
+	if err != nil {
+		if _, ok := err.(*snapstate.ChangeConflictError); ok {
+			// try again on the next Ensure
+			return nil
+		}
+		logger.Noticef("Cannot take scheduled snapshot: %v", err)
+		st.Set("last-scheduled-snapshot", time.Now())
+		mgr.nextScheduledSnapshot = time.Time{}
+		return nil
+	}
+
+	prune := st.NewTask("prune-scheduled-snapshots", "Remove scheduled snapshot sets beyond retention")
+	prune.WaitAll(ts)
+	ts.AddTask(prune)
+
+	var summary string
+	if len(snapNames) == 0 {
+		summary = fmt.Sprintf("Scheduled snapshot of all snaps in snapshot set #%d", setID)
+	} else {
+		summary = fmt.Sprintf("Scheduled snapshot of snaps %s in snapshot set #%d", strutil.Quoted(saved), setID)
+	}
+	logger.Debugf("Taking scheduled snapshot set #%d.", setID)
+	chg := st.NewChange("scheduled-snapshot", summary)
+	chg.AddAll(ts)
+
+	setIDs, err := scheduledSnapshotSets(st)
+	if err != nil {
+		return err
+	}
+	st.Set("scheduled-snapshot-sets", append(setIDs, setID))
+	st.Set("last-scheduled-snapshot", time.Now())
+	mgr.nextScheduledSnapshot = time.Time{}
+
+	return nil
+}
+
+// scheduledSnapshotSets returns the IDs of the sets taken on schedule,
+// oldest first.
+func scheduledSnapshotSets(st *state.State) ([]uint64, error) {
+	var setIDs []uint64
+	err := st.Get("scheduled-snapshot-sets", &setIDs)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return setIDs, nil
+}
+
+// scheduledSnapshotsRetention returns how many scheduled snapshot sets
+// to keep and their maximum total size, 0 meaning no limit.
+func scheduledSnapshotsRetention(st *state.State) (keep int, maxSize int64, err error) {
+	tr := config.NewTransaction(st)
+	keepStr, err := config.CoreCfg(tr, "snapshots.scheduled.keep")
+	if err != nil {
+		return 0, 0, err
+	}
+	maxSizeStr, err := config.CoreCfg(tr, "snapshots.scheduled.max-size")
+	if err != nil {
+		return 0, 0, err
+	}
+	if keepStr == "" && maxSizeStr == "" {
+		return defaultScheduledSnapshotsKeep, 0, nil
+	}
+	if keepStr != "" {
+		if keep, err = strconv.Atoi(keepStr); err != nil {
+			return 0, 0, fmt.Errorf("cannot parse snapshots.scheduled.keep: %v", err)
+		}
+	}
+	if maxSizeStr != "" {
+		if maxSize, err = strutil.ParseByteSize(maxSizeStr); err != nil {
+			return 0, 0, fmt.Errorf("cannot parse snapshots.scheduled.max-size: %v", err)
+		}
+	}
+	return keep, maxSize, nil
+}
+
+// doPruneScheduledSnapshots removes the oldest scheduled snapshot sets
+// until at most snapshots.scheduled.keep of them are left and they add
+// up to at most snapshots.scheduled.max-size. The newest set is always
+// kept.
+func doPruneScheduledSnapshots(task *state.Task, _ *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	keep, maxSize, err := scheduledSnapshotsRetention(st)
+	if err != nil {
+		return err
+	}
+	setIDs, err := scheduledSnapshotSets(st)
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[uint64]int64, len(setIDs))
+	files := make(map[uint64][]string, len(setIDs))
+	err = backendIter(context.TODO(), func(r *backend.Reader) error {
+		sizes[r.SetID] += r.Size
+		files[r.SetID] = append(files[r.SetID], r.Name())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list snapshots: %v", err)
+	}
+
+	sort.Slice(setIDs, func(i, j int) bool { return setIDs[i] < setIDs[j] })
+	var kept []uint64
+	var total int64
+	// newest first
+	for i := len(setIDs) - 1; i >= 0; i-- {
+		setID := setIDs[i]
+		if len(files[setID]) == 0 {
+			// forgotten by hand or never saved
+			continue
+		}
+		total += sizes[setID]
+		withinLimits := (keep == 0 || len(kept) < keep) && (maxSize == 0 || total <= maxSize)
+		if len(kept) == 0 || withinLimits {
+			kept = append([]uint64{setID}, kept...)
+			continue
+		}
+		// forget needs to conflict with check and restore
+		if err := checkSnapshotTaskConflict(st, setID, "check-snapshot", "restore-snapshot"); err != nil {
+			task.Logf("not removing snapshot set #%d: %v", setID, err)
+			kept = append([]uint64{setID}, kept...)
+			continue
+		}
+		task.Logf("removing scheduled snapshot set #%d", setID)
+		for _, fn := range files[setID] {
+			if err := osRemove(fn); err != nil {
+				return fmt.Errorf("cannot remove snapshot file %q: %v", fn, err)
+			}
+		}
+	}
+	st.Set("scheduled-snapshot-sets", kept)
+
+	return nil
+}
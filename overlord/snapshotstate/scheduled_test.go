@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (snapshotSuite) setUpScheduled(c *check.C, schedule string, last time.Time) (*state.State, *snapshotstate.SnapshotManager) {
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	st.Lock()
+	defer st.Unlock()
+	st.Set("seeded", true)
+	tr := config.NewTransaction(st)
+	c.Assert(tr.Set("core", "snapshots.schedule", schedule), check.IsNil)
+	c.Assert(tr.Set("core", "snapshots.scheduled.snaps", "foo, bar"), check.IsNil)
+	tr.Commit()
+	if !last.IsZero() {
+		st.Set("last-scheduled-snapshot", last)
+	}
+	return st, mgr
+}
+
+func (s snapshotSuite) TestEnsureScheduledSnapshot(c *check.C) {
+	defer snapshotstate.MockSnapstateCheckChangeConflictMany(func(*state.State, []string, string) error {
+		return nil
+	})()
+	st, mgr := s.setUpScheduled(c, "00:00-24:00", time.Now().Add(-40*24*time.Hour))
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	chgs := st.Changes()
+	c.Assert(chgs, check.HasLen, 1)
+	chg := chgs[0]
+	c.Check(chg.Kind(), check.Equals, "scheduled-snapshot")
+	c.Check(chg.Summary(), check.Equals, `Scheduled snapshot of snaps "foo", "bar" in snapshot set #1`)
+	var kinds []string
+	for _, t := range chg.Tasks() {
+		kinds = append(kinds, t.Kind())
+	}
+	sort.Strings(kinds)
+	c.Check(kinds, check.DeepEquals, []string{"prune-scheduled-snapshots", "save-snapshot", "save-snapshot"})
+
+	var setIDs []uint64
+	c.Assert(st.Get("scheduled-snapshot-sets", &setIDs), check.IsNil)
+	c.Check(setIDs, check.DeepEquals, []uint64{1})
+	var last time.Time
+	c.Assert(st.Get("last-scheduled-snapshot", &last), check.IsNil)
+	c.Check(time.Since(last) < time.Minute, check.Equals, true)
+
+	// not scheduled again while one is in progress, nor before it is due
+	st.Set("last-scheduled-snapshot", time.Now().Add(-40*24*time.Hour))
+	st.Unlock()
+	c.Assert(mgr.Ensure(), check.IsNil)
+	st.Lock()
+	c.Check(st.Changes(), check.HasLen, 1)
+}
+
+func (s snapshotSuite) TestEnsureScheduledSnapshotNotDue(c *check.C) {
+	st, mgr := s.setUpScheduled(c, "00:00-24:00", time.Now())
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+}
+
+func (s snapshotSuite) TestEnsureScheduledSnapshotFirstRun(c *check.C) {
+	st, mgr := s.setUpScheduled(c, "00:00-24:00", time.Time{})
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+	var last time.Time
+	c.Assert(st.Get("last-scheduled-snapshot", &last), check.IsNil)
+	c.Check(time.Since(last) < time.Minute, check.Equals, true)
+}
+
+func (s snapshotSuite) TestEnsureScheduledSnapshotUnset(c *check.C) {
+	st, mgr := s.setUpScheduled(c, "", time.Now().Add(-40*24*time.Hour))
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+}
+
+func (snapshotSuite) testPruneScheduledSnapshots(c *check.C, keep, maxSize string, removedSets []uint64) {
+	dir := c.MkDir()
+	var readers []*backend.Reader
+	fileSet := make(map[string]uint64)
+	for _, setID := range []uint64{1, 2, 3, 4} {
+		for _, name := range []string{"foo", "bar"} {
+			f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s_%d.zip", name, setID)))
+			c.Assert(err, check.IsNil)
+			defer f.Close()
+			fileSet[f.Name()] = setID
+			readers = append(readers, &backend.Reader{
+				Snapshot: client.Snapshot{SetID: setID, Snap: name, Size: 100},
+				File:     f,
+			})
+		}
+	}
+	defer snapshotstate.MockBackendIter(func(_ context.Context, f func(*backend.Reader) error) error {
+		for _, r := range readers {
+			if err := f(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})()
+	removed := make(map[uint64]bool)
+	defer snapshotstate.MockOsRemove(func(fn string) error {
+		removed[fileSet[fn]] = true
+		return nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	tr := config.NewTransaction(st)
+	if keep != "" {
+		c.Assert(tr.Set("core", "snapshots.scheduled.keep", keep), check.IsNil)
+	}
+	if maxSize != "" {
+		c.Assert(tr.Set("core", "snapshots.scheduled.max-size", maxSize), check.IsNil)
+	}
+	tr.Commit()
+	// set 5 was forgotten by hand
+	st.Set("scheduled-snapshot-sets", []uint64{1, 2, 3, 4, 5})
+
+	task := st.NewTask("prune-scheduled-snapshots", "...")
+	st.Unlock()
+	err := snapshotstate.DoPruneScheduledSnapshots(task, nil)
+	st.Lock()
+	c.Assert(err, check.IsNil)
+
+	var removedIDs []uint64
+	for setID := range removed {
+		removedIDs = append(removedIDs, setID)
+	}
+	sort.Slice(removedIDs, func(i, j int) bool { return removedIDs[i] < removedIDs[j] })
+	c.Check(removedIDs, check.DeepEquals, removedSets)
+
+	var kept []uint64
+	c.Assert(st.Get("scheduled-snapshot-sets", &kept), check.IsNil)
+	for _, setID := range kept {
+		c.Check(removed[setID], check.Equals, false)
+	}
+	c.Check(len(kept)+len(removedSets), check.Equals, 4)
+}
+
+func (s snapshotSuite) TestPruneScheduledSnapshotsDefaultKeep(c *check.C) {
+	s.testPruneScheduledSnapshots(c, "", "", []uint64{1})
+}
+
+func (s snapshotSuite) TestPruneScheduledSnapshotsKeep(c *check.C) {
+	s.testPruneScheduledSnapshots(c, "1", "", []uint64{1, 2, 3})
+}
+
+func (s snapshotSuite) TestPruneScheduledSnapshotsMaxSize(c *check.C) {
+	// each set is 200 bytes
+	s.testPruneScheduledSnapshots(c, "", "500B", []uint64{1, 2})
+}
+
+func (s snapshotSuite) TestPruneScheduledSnapshotsKeepsNewest(c *check.C) {
+	s.testPruneScheduledSnapshots(c, "", "10B", []uint64{1, 2, 3})
+}
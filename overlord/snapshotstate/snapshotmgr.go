@@ -57,6 +57,9 @@ type SnapshotManager struct {
 	state *state.State
 
 	lastForgetExpiredSnapshotTime time.Time
+
+	lastSnapshotSchedule  string
+	nextScheduledSnapshot time.Time
 }
 
 // Manager returns a new SnapshotManager
@@ -67,6 +70,7 @@ func Manager(st *state.State, runner *state.TaskRunner) *SnapshotManager {
 	runner.AddHandler("forget-snapshot", doForget, nil)
 	runner.AddHandler("check-snapshot", doCheck, nil)
 	runner.AddHandler("restore-snapshot", doRestore, undoRestore)
+	runner.AddHandler("prune-scheduled-snapshots", doPruneScheduledSnapshots, nil)
 	runner.AddCleanup("restore-snapshot", cleanupRestore)
 
 	manager := &SnapshotManager{
@@ -81,9 +85,11 @@ func Manager(st *state.State, runner *state.TaskRunner) *SnapshotManager {
 func (mgr *SnapshotManager) Ensure() error {
 	// process expired snapshots once a day.
 	if time.Now().After(mgr.lastForgetExpiredSnapshotTime.Add(autoExpirationInterval)) {
-		return mgr.forgetExpiredSnapshots()
+		if err := mgr.forgetExpiredSnapshots(); err != nil {
+			return err
+		}
 	}
-	return nil
+	return mgr.ensureScheduledSnapshot()
 }
 
 func (mgr *SnapshotManager) forgetExpiredSnapshots() error {
@@ -154,6 +160,7 @@ type snapshotSetup struct {
 	Filename string        `json:"filename,omitempty"`
 	Current  snap.Revision `json:"current"`
 	Auto     bool          `json:"auto,omitempty"`
+	Encrypt  bool          `json:"encrypt,omitempty"`
 }
 
 func filename(setID uint64, si *snap.Info) string {
@@ -180,6 +187,10 @@ func prepareSave(task *state.Task) (snapshot *snapshotSetup, cur *snap.Info, cfg
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	snapshot.Encrypt, err = snapshotsEncrypted(st)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	// updating snapshot-setup with the filename, for use in undo
 	snapshot.Filename = filename(snapshot.SetID, cur)
 	task.Set("snapshot-setup", &snapshot)
@@ -213,7 +224,7 @@ func doSave(task *state.Task, tomb *tomb.Tomb) error {
 	if err != nil {
 		return err
 	}
-	_, err = backendSave(tomb.Context(nil), snapshot.SetID, cur, cfg, snapshot.Users, &backend.Flags{Auto: snapshot.Auto})
+	_, err = backendSave(tomb.Context(nil), snapshot.SetID, cur, cfg, snapshot.Users, &backend.Flags{Auto: snapshot.Auto, Encrypt: snapshot.Encrypt})
 	if err != nil {
 		st := task.State()
 		st.Lock()
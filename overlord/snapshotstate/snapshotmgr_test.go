@@ -33,6 +33,7 @@ import (
 
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
@@ -51,6 +52,7 @@ func (snapshotSuite) TestManager(c *check.C) {
 	c.Check(kinds, check.DeepEquals, []string{
 		"check-snapshot",
 		"forget-snapshot",
+		"prune-scheduled-snapshots",
 		"restore-snapshot",
 		"save-snapshot",
 	})
@@ -266,6 +268,7 @@ func (snapshotSuite) TestDoSave(c *check.C) {
 		c.Check(cfg, check.DeepEquals, map[string]interface{}{"hello": "there"})
 		c.Check(usernames, check.DeepEquals, []string{"a-user", "b-user"})
 		c.Check(flags.Auto, check.Equals, false)
+		c.Check(flags.Encrypt, check.Equals, false)
 		return nil, nil
 	})()
 
@@ -282,6 +285,79 @@ func (snapshotSuite) TestDoSave(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (snapshotSuite) TestDoSaveEncrypted(c *check.C) {
+	testDoSaveEncrypted(c, true)
+}
+
+func (snapshotSuite) TestDoSaveEncryptedString(c *check.C) {
+	// as set by "snap set core snapshots.encrypt=true"
+	testDoSaveEncrypted(c, "true")
+}
+
+func (snapshotSuite) TestDoSaveEncryptedInvalid(c *check.C) {
+	defer snapshotstate.MockSnapstateCurrentInfo(func(*state.State, string) (*snap.Info, error) {
+		return &snap.Info{SideInfo: snap.SideInfo{RealName: "a-snap", Revision: snap.R(-1)}}, nil
+	})()
+	defer snapshotstate.MockConfigGetSnapConfig(func(*state.State, string) (*json.RawMessage, error) { return nil, nil })()
+	defer snapshotstate.MockBackendSave(func(_ context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, flags *backend.Flags) (*client.Snapshot, error) {
+		c.Fatal("unexpected save")
+		return nil, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "snapshots.encrypt", "yes")
+	tr.Commit()
+	task := st.NewTask("save-snapshot", "...")
+	task.Set("snapshot-setup", map[string]interface{}{
+		"set-id": 42,
+		"snap":   "a-snap",
+	})
+	st.Unlock()
+	err := snapshotstate.DoSave(task, &tomb.Tomb{})
+	c.Assert(err, check.ErrorMatches, `snapshots.encrypt can only be set to 'true' or 'false', got "yes"`)
+}
+
+func testDoSaveEncrypted(c *check.C, value interface{}) {
+	snapInfo := snap.Info{
+		SideInfo: snap.SideInfo{
+			RealName: "a-snap",
+			Revision: snap.R(-1),
+		},
+		Version: "1.33",
+	}
+	defer snapshotstate.MockSnapstateCurrentInfo(func(*state.State, string) (*snap.Info, error) { return &snapInfo, nil })()
+	defer snapshotstate.MockConfigGetSnapConfig(func(*state.State, string) (*json.RawMessage, error) { return nil, nil })()
+	var saved bool
+	defer snapshotstate.MockBackendSave(func(_ context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, flags *backend.Flags) (*client.Snapshot, error) {
+		c.Check(flags.Encrypt, check.Equals, true)
+		saved = true
+		return nil, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "snapshots.encrypt", value)
+	tr.Commit()
+	task := st.NewTask("save-snapshot", "...")
+	task.Set("snapshot-setup", map[string]interface{}{
+		"set-id": 42,
+		"snap":   "a-snap",
+	})
+	st.Unlock()
+	err := snapshotstate.DoSave(task, &tomb.Tomb{})
+	c.Assert(err, check.IsNil)
+	c.Check(saved, check.Equals, true)
+
+	st.Lock()
+	defer st.Unlock()
+	var setup map[string]interface{}
+	c.Assert(task.Get("snapshot-setup", &setup), check.IsNil)
+	c.Check(setup["encrypt"], check.Equals, true)
+}
+
 func (snapshotSuite) TestDoSaveFailsWithNoSnap(c *check.C) {
 	defer snapshotstate.MockSnapstateCurrentInfo(func(*state.State, string) (*snap.Info, error) {
 		return nil, errors.New("bzzt")
@@ -23,6 +23,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
 	"time"
 
@@ -41,6 +42,7 @@ var (
 	snapstateAll                     = snapstate.All
 	snapstateCheckChangeConflictMany = snapstate.CheckChangeConflictMany
 	backendIter                      = backend.Iter
+	backendImport                    = backend.Import
 
 	// Default expiration time for automatic snapshots, if not set by the user
 	defaultAutomaticSnapshotExpiration = time.Hour * 24 * 31
@@ -106,6 +108,26 @@ func AutomaticSnapshotExpiration(st *state.State) (time.Duration, error) {
 	return defaultAutomaticSnapshotExpiration, nil
 }
 
+// snapshotsEncrypted returns whether the archives of new snapshots
+// should be encrypted, as set by the snapshots.encrypt core option.
+func snapshotsEncrypted(st *state.State) (bool, error) {
+	// "snap set" stores the option as a string, while it may also be set
+	// as a proper boolean
+	var encrypt interface{}
+	tr := config.NewTransaction(st)
+	err := tr.Get("core", "snapshots.encrypt", &encrypt)
+	if err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	switch encrypt {
+	case true, "true":
+		return true, nil
+	case false, "false", nil, "":
+		return false, nil
+	}
+	return false, fmt.Errorf("snapshots.encrypt can only be set to 'true' or 'false', got %q", encrypt)
+}
+
 // saveExpiration saves expiration date of the given snapshot set, in the state.
 // The state needs to be locked by the caller.
 func saveExpiration(st *state.State, setID uint64, expiryTime time.Time) error {
@@ -256,6 +278,28 @@ func checkSnapshotTaskConflict(st *state.State, setID uint64, conflictingKinds .
 // Note that the state must be locked by the caller.
 var List = backend.List
 
+// Export prepares the export of a snapshot set.
+var Export = backend.NewSnapshotExport
+
+// Import imports the snapshot set exported as the stream read from r
+// as a new snapshot set, returning its ID and the names of the snaps
+// in it.
+// Note that the state must not be locked by the caller.
+func Import(ctx context.Context, st *state.State, r io.Reader) (setID uint64, snapNames []string, err error) {
+	st.Lock()
+	setID, err = newSnapshotSetID(st)
+	st.Unlock()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	snapNames, err = backendImport(ctx, setID, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return setID, snapNames, nil
+}
+
 // Save creates a taskset for taking snapshots of snaps' data.
 // Note that the state must be locked by the caller.
 func Save(st *state.State, instanceNames []string, users []string) (setID uint64, snapsSaved []string, ts *state.TaskSet, err error) {
@@ -23,6 +23,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
@@ -1477,3 +1478,33 @@ func (snapshotSuite) TestAutomaticSnapshotDefaultUbuntuCore(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(du, check.Equals, time.Duration(0))
 }
+
+func (snapshotSuite) TestImport(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	st.Set("last-snapshot-set-id", 41)
+	st.Unlock()
+
+	export := strings.NewReader("export")
+	defer snapshotstate.MockBackendImport(func(ctx context.Context, setID uint64, r io.Reader) ([]string, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(r, check.Equals, export)
+		return []string{"bar", "foo"}, nil
+	})()
+
+	setID, snapNames, err := snapshotstate.Import(context.TODO(), st, export)
+	c.Assert(err, check.IsNil)
+	c.Check(setID, check.Equals, uint64(42))
+	c.Check(snapNames, check.DeepEquals, []string{"bar", "foo"})
+}
+
+func (snapshotSuite) TestImportError(c *check.C) {
+	st := state.New(nil)
+
+	defer snapshotstate.MockBackendImport(func(context.Context, uint64, io.Reader) ([]string, error) {
+		return nil, errors.New("bzzt")
+	})()
+
+	_, _, err := snapshotstate.Import(context.TODO(), st, strings.NewReader("export"))
+	c.Assert(err, check.ErrorMatches, "bzzt")
+}
@@ -22,8 +22,10 @@ package snapstate
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/snapstate/backend"
@@ -352,6 +354,138 @@ func checkAliasesConflicts(st *state.State, snapName string, candAutoDisabled bo
 	return nil, nil
 }
 
+// seedingAliasesPriority returns the priority given by the model to the
+// aliases of the given snap when resolving conflicts during seeding,
+// lower is preferred: the base, kernel and gadget come first, then the
+// required snaps in order, then any other snap.
+func seedingAliasesPriority(model *asserts.Model, snapName string) int {
+	base := model.Base()
+	if base == "" {
+		base = "core"
+	}
+	order := append([]string{base, model.Kernel(), model.Gadget()}, model.RequiredSnaps()...)
+	for i, name := range order {
+		if name != "" && name == snapName {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// resolveSeedingAliasesConflicts resolves the conflicts of the automatic
+// candAliases of snapName, which would otherwise abort seeding. The snap
+// with the lower seedingAliasesPriority (or name, on a tie) keeps a
+// conflicting alias, which is dropped from the other snap, and the
+// aliases conflicting with snap command namespaces are dropped. Each
+// resolution is reported as a warning. candAliases is modified in
+// place, the aliases dropped from other snaps are returned by snap for
+// undo. Nothing is changed if the conflicts cannot all be resolved.
+func resolveSeedingAliasesConflicts(st *state.State, model *asserts.Model, snapName string, candAutoDisabled bool, candAliases map[string]*AliasTarget, be managerBackend) (dropped map[string]map[string]*AliasTarget, err error) {
+	prio := seedingAliasesPriority(model, snapName)
+	resolved := make(map[string]*AliasTarget, len(candAliases))
+	for alias, target := range candAliases {
+		resolved[alias] = target
+	}
+	// the other snaps losing aliases, only changed once all the
+	// conflicts are resolved
+	changing := make(map[string]*SnapState)
+	var warnings []string
+	for {
+		conflicts, err := checkAliasesConflicts(st, snapName, candAutoDisabled, resolved, changing)
+		if err == nil {
+			break
+		}
+		confErr, ok := err.(*AliasConflictError)
+		if !ok {
+			return nil, err
+		}
+		if len(conflicts) == 0 {
+			// conflict with a snap command namespace
+			if resolved[confErr.Alias].Manual != "" {
+				return nil, err
+			}
+			warnings = append(warnings, fmt.Sprintf("%v, not enabling it during seeding", err))
+			delete(resolved, confErr.Alias)
+			continue
+		}
+
+		others := make([]string, 0, len(conflicts))
+		for other := range conflicts {
+			others = append(others, other)
+		}
+		sort.Strings(others)
+		for _, other := range others {
+			otherSnapst := changing[other]
+			if otherSnapst == nil {
+				otherSnapst = &SnapState{}
+				if err := Get(st, other, otherSnapst); err != nil {
+					return nil, err
+				}
+			}
+			otherPrio := seedingAliasesPriority(model, other)
+			otherWins := otherPrio < prio || (otherPrio == prio && other < snapName)
+			otherAliases := make(map[string]*AliasTarget, len(otherSnapst.Aliases))
+			for alias, target := range otherSnapst.Aliases {
+				otherAliases[alias] = target
+			}
+			for _, alias := range conflicts[other] {
+				if resolved[alias].Manual != "" || otherAliases[alias].Manual != "" {
+					// only automatic aliases are resolved
+					return nil, err
+				}
+				if otherWins {
+					warnings = append(warnings, fmt.Sprintf("cannot enable alias %q for %q, enabled for %q which has priority in the model", alias, snapName, other))
+					delete(resolved, alias)
+				} else {
+					warnings = append(warnings, fmt.Sprintf("cannot enable alias %q for %q, enabled for %q which has priority in the model", alias, other, snapName))
+					if dropped == nil {
+						dropped = make(map[string]map[string]*AliasTarget)
+					}
+					if dropped[other] == nil {
+						dropped[other] = make(map[string]*AliasTarget)
+					}
+					dropped[other][alias] = otherAliases[alias]
+					delete(otherAliases, alias)
+				}
+			}
+			if len(otherAliases) == len(otherSnapst.Aliases) {
+				continue
+			}
+			newSnapst := *otherSnapst
+			newSnapst.Aliases = otherAliases
+			changing[other] = &newSnapst
+		}
+	}
+
+	others := make([]string, 0, len(changing))
+	for other := range changing {
+		others = append(others, other)
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		var otherSnapst SnapState
+		if err := Get(st, other, &otherSnapst); err != nil {
+			return nil, err
+		}
+		if !otherSnapst.AliasesPending {
+			autoDisabled := otherSnapst.AutoAliasesDisabled
+			if _, _, err := applyAliasesChange(other, autoDisabled, otherSnapst.Aliases, autoDisabled, changing[other].Aliases, be, doApply); err != nil {
+				return nil, err
+			}
+		}
+		Set(st, other, changing[other])
+	}
+	for _, warning := range warnings {
+		st.WarnfWithSeverity(state.WarningSeverityInfo, "%s", warning)
+	}
+	for alias := range candAliases {
+		if resolved[alias] == nil {
+			delete(candAliases, alias)
+		}
+	}
+	return dropped, nil
+}
+
 // checkSnapAliasConflict checks whether instanceName and its command
 // namepsace conflicts against installed snap aliases.
 func checkSnapAliasConflict(st *state.State, instanceName string) error {
@@ -702,3 +836,16 @@ func Prefer(st *state.State, name string) (*state.TaskSet, error) {
 
 	return state.NewTaskSet(prefer), nil
 }
+
+// BestEffortAliases marks the alias tasks of the given task set so that
+// failing to perform them is reported on the task, under "alias-error",
+// instead of failing the change. This lets the alias tasks that follow
+// them in the change go through. The failure is also recorded as the
+// "error" of the entry with the given index of the "alias-preferences"
+// in the api-data of the change, if the change has those.
+func BestEffortAliases(ts *state.TaskSet, preference int) {
+	for _, t := range ts.Tasks() {
+		t.Set("best-effort", true)
+		t.Set("alias-preference", preference)
+	}
+}
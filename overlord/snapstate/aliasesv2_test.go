@@ -610,6 +610,64 @@ func (s *snapmgrTestSuite) TestAliasRunThrough(c *C) {
 	})
 }
 
+func (s *snapmgrTestSuite) TestAliasBestEffort(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "alias-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "alias-snap", Revision: snap.R(11)},
+		},
+		Current: snap.R(11),
+		Active:  true,
+	})
+
+	chg := s.state.NewChange("alias", "manual aliases")
+	ts1, err := snapstate.Alias(s.state, "alias-snap", "cmdno", "alias1")
+	c.Assert(err, IsNil)
+	ts2, err := snapstate.Alias(s.state, "alias-snap", "cmd2", "alias2")
+	c.Assert(err, IsNil)
+	ts2.WaitAll(ts1)
+	snapstate.BestEffortAliases(ts1, 0)
+	snapstate.BestEffortAliases(ts2, 1)
+	chg.AddAll(ts1)
+	chg.AddAll(ts2)
+	chg.Set("api-data", map[string]interface{}{
+		"alias-preferences": []map[string]interface{}{
+			{"action": "alias", "snap": "alias-snap", "app": "cmdno", "alias": "alias1"},
+			{"action": "alias", "snap": "alias-snap", "app": "cmd2", "alias": "alias2"},
+		},
+	})
+
+	s.state.Unlock()
+	defer s.se.Stop()
+	s.settle(c)
+	s.state.Lock()
+
+	// the failing alias does not fail the change
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("%v", chg.Err()))
+	failed := ts1.Tasks()[0]
+	var aliasErr string
+	c.Assert(failed.Get("alias-error", &aliasErr), IsNil)
+	c.Check(aliasErr, Equals, `cannot enable alias "alias1" for "alias-snap", target application "cmdno" does not exist`)
+	c.Check(strings.Join(failed.Log(), "\n"), Matches, `(?s).*ERROR cannot enable alias "alias1".*`)
+
+	// and is reported as the result of its preference
+	var data map[string]interface{}
+	c.Assert(chg.Get("api-data", &data), IsNil)
+	c.Check(data["alias-preferences"], DeepEquals, []interface{}{
+		map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "cmdno", "alias": "alias1", "error": aliasErr},
+		map[string]interface{}{"action": "alias", "snap": "alias-snap", "app": "cmd2", "alias": "alias2"},
+	})
+
+	var snapst snapstate.SnapState
+	err = snapstate.Get(s.state, "alias-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, DeepEquals, map[string]*snapstate.AliasTarget{
+		"alias2": {Manual: "cmd2"},
+	})
+}
+
 func (s *snapmgrTestSuite) TestParallelInstanceAliasRunThrough(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -46,6 +46,10 @@ const maxPostponement = 60 * 24 * time.Hour
 // cannot inhibit refreshes for more than maxInhibition
 const maxInhibition = 7 * 24 * time.Hour
 
+// the pre-refresh-download hook of a snap can hold its auto-refreshes
+// for up to defaultMaxRefreshHold unless configured otherwise
+const defaultMaxRefreshHold = 48 * time.Hour
+
 // hooks setup by devicestate
 var (
 	CanAutoRefresh        func(st *state.State) (bool, error)
@@ -72,6 +76,36 @@ func newAutoRefresh(st *state.State) *autoRefresh {
 	}
 }
 
+// RefreshUpdate is the data of the "refresh-start" and "refresh-finish"
+// notifications sent about auto-refreshes.
+type RefreshUpdate struct {
+	ChangeID  string   `json:"change-id"`
+	SnapNames []string `json:"snap-names"`
+	// Status is the final status of the auto-refresh change, only set
+	// when it finished
+	Status string `json:"status,omitempty"`
+}
+
+// notifyRefreshFinished observes the state for auto-refresh changes
+// becoming ready, to notify that they finished.
+func (m *autoRefresh) notifyRefreshFinished(n *state.Notification) {
+	upd, ok := n.Data.(*state.ChangeUpdate)
+	if !ok || upd.Kind != "auto-refresh" || !upd.Ready {
+		return
+	}
+	var snapNames []string
+	if chg := m.state.Change(upd.ID); chg != nil {
+		if err := chg.Get("snap-names", &snapNames); err != nil && err != state.ErrNoState {
+			logger.Noticef("cannot get snaps of auto-refresh change %s: %v", upd.ID, err)
+		}
+	}
+	m.state.Notify("refresh-finish", &RefreshUpdate{
+		ChangeID:  upd.ID,
+		SnapNames: snapNames,
+		Status:    upd.Status,
+	})
+}
+
 // RefreshSchedule will return a user visible string with the current schedule
 // for the automatic refreshes and a flag indicating whether the schedule is a
 // legacy one.
@@ -413,6 +447,10 @@ func (m *autoRefresh) launchAutoRefresh() error {
 	chg.Set("snap-names", updated)
 	chg.Set("api-data", map[string]interface{}{"snap-names": updated})
 	perfTimings.AddTag("change-id", chg.ID())
+	m.state.Notify("refresh-start", &RefreshUpdate{
+		ChangeID:  chg.ID(),
+		SnapNames: updated,
+	})
 
 	return nil
 }
@@ -499,3 +537,31 @@ func inhibitRefresh(st *state.State, snapst *SnapState, info *snap.Info, checker
 	}
 	return nil
 }
+
+// refreshCanBeHeld returns whether the pre-refresh-download hook of the
+// snap can still hold an auto-refresh of it.
+//
+// Hooks can hold refreshes for up to "refresh.max-hook-hold" from when
+// they first held one, beyond that period the refresh will go ahead
+// whatever the hook says.
+func refreshCanBeHeld(st *state.State, snapst *SnapState) (bool, error) {
+	maxHold := defaultMaxRefreshHold
+
+	var maxHoldStr string
+	tr := config.NewTransaction(st)
+	err := tr.Get("core", "refresh.max-hook-hold", &maxHoldStr)
+	if err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	if maxHoldStr != "" {
+		maxHold, err = time.ParseDuration(maxHoldStr)
+		if err != nil {
+			return false, fmt.Errorf("cannot parse refresh.max-hook-hold: %v", err)
+		}
+	}
+
+	if snapst.RefreshHeldTime == nil {
+		return maxHold > 0, nil
+	}
+	return time.Now().Sub(*snapst.RefreshHeldTime) < maxHold, nil
+}
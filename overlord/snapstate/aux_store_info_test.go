@@ -46,26 +46,31 @@ func (s *auxInfoSuite) TestAuxStoreInfoFilename(c *check.C) {
 
 func (s *auxInfoSuite) TestAuxStoreInfoRoundTrip(c *check.C) {
 	media := snap.MediaInfos{{Type: "1-2-3-testing"}}
+	links := map[string][]string{"website": {"https://example.com"}}
 	info := &snap.Info{SuggestedName: "some-snap"}
 	info.SnapID = "some-id"
 	filename := snapstate.AuxStoreInfoFilename(info.SnapID)
 	c.Assert(osutil.FileExists(filename), check.Equals, false)
 	c.Check(snapstate.RetrieveAuxStoreInfo(info), check.IsNil)
 	c.Check(info.Media, check.HasLen, 0)
+	c.Check(info.EditedLinks, check.HasLen, 0)
 
-	c.Assert(snapstate.KeepAuxStoreInfo(info.SnapID, &snapstate.AuxStoreInfo{Media: media}), check.IsNil)
+	c.Assert(snapstate.KeepAuxStoreInfo(info.SnapID, &snapstate.AuxStoreInfo{Media: media, Links: links}), check.IsNil)
 	c.Check(osutil.FileExists(filename), check.Equals, true)
 
 	c.Assert(snapstate.RetrieveAuxStoreInfo(info), check.IsNil)
 	c.Check(info.Media, check.HasLen, 1)
 	c.Check(info.Media, check.DeepEquals, media)
+	c.Check(info.EditedLinks, check.DeepEquals, links)
 	info.Media = nil
+	info.EditedLinks = nil
 
 	c.Assert(snapstate.DiscardAuxStoreInfo(info.SnapID), check.IsNil)
 	c.Assert(osutil.FileExists(filename), check.Equals, false)
 
 	c.Check(snapstate.RetrieveAuxStoreInfo(info), check.IsNil)
 	c.Check(info.Media, check.HasLen, 0)
+	c.Check(info.EditedLinks, check.HasLen, 0)
 
 	c.Check(snapstate.DiscardAuxStoreInfo(info.SnapID), check.IsNil)
 }
@@ -55,6 +55,7 @@ type StoreService interface {
 	ReadyToBuy(*auth.UserState) error
 	ConnectivityCheck() (map[string]bool, error)
 	CreateCohorts(context.Context, []string) (map[string]string, error)
+	ReportRefreshMetrics(ctx context.Context, metrics []*store.RefreshMetric) error
 
 	LoginUser(username, password, otp string) (string, string, error)
 	UserInfo(email string) (userinfo *store.User, err error)
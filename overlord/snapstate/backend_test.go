@@ -132,6 +132,7 @@ type fakeStore struct {
 	storetest.Store
 
 	downloads           []fakeDownload
+	downloadErrors      map[string]error
 	refreshRevnos       map[string]snap.Revision
 	fakeBackend         *fakeSnappyBackend
 	fakeCurrentProgress int
@@ -240,6 +241,8 @@ func (f *fakeStore) snap(spec snapSpec, user *auth.UserState) (*snap.Info, error
 		info.SideInfo.Paid = true
 	case "channel-for-private":
 		info.SideInfo.Private = true
+	case "channel-for-newer-snapd":
+		info.Assumes = []string{"kernel-assets"}
 	case "channel-for-layout":
 		info.Layout = map[string]*snap.Layout{
 			"/usr": {
@@ -563,6 +566,10 @@ func (f *fakeStore) Download(ctx context.Context, name, targetFn string, snapInf
 	})
 	f.fakeBackend.appendOp(&fakeOp{op: "storesvc-download", name: name})
 
+	if err := f.downloadErrors[name]; err != nil {
+		return err
+	}
+
 	pb.SetTotal(float64(f.fakeTotalProgress))
 	pb.Set(float64(f.fakeCurrentProgress))
 
@@ -709,7 +716,7 @@ func (f *fakeSnappyBackend) ReadInfo(name string, si *snap.SideInfo) (*snap.Info
 		info.Epoch = snap.Epoch{}
 	case "some-epoch-snap":
 		info.Epoch = snap.E("13")
-	case "gadget":
+	case "gadget", "brand-gadget":
 		info.SnapType = snap.TypeGadget
 	case "core":
 		info.SnapType = snap.TypeOS
@@ -21,9 +21,14 @@ package snapstate
 
 import (
 	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
@@ -36,7 +41,8 @@ import (
 // still has the "active" version set to "v2" which is
 // misleading. This code will check what kernel/os booted and set
 // those versions active.To do this it creates a Change and kicks
-// start it directly.
+// start it directly. The revisions that failed to boot are recorded,
+// and reported by a change of their own.
 func UpdateBootRevisions(st *state.State) error {
 	const errorPrefix = "cannot update revisions after boot changes: "
 
@@ -62,14 +68,35 @@ func UpdateBootRevisions(st *state.State) error {
 		return fmt.Errorf(errorPrefix+"%s", err)
 	}
 
+	paired, err := pendingPairedUpdate(st)
+	if err != nil {
+		return fmt.Errorf(errorPrefix+"%s", err)
+	}
+
 	var tsAll []*state.TaskSet
+	var reports []*bootFailureReport
 	for _, actual := range []*boot.NameAndRevision{kernel, base} {
 		info, err := CurrentInfo(st, actual.Name)
 		if err != nil {
 			logger.Noticef("cannot get info for %q: %s", actual.Name, err)
 			continue
 		}
+		// the booted revision is good
+		if err := clearBootFailures(st, actual.Name, actual.Revision); err != nil {
+			return fmt.Errorf(errorPrefix+"%s", err)
+		}
 		if actual.Revision != info.SideInfo.Revision {
+			// the bootloader fell back from the revision we
+			// tried to boot, remember so that we do not keep
+			// retrying it
+			if err := recordBootFailure(st, actual.Name, info.SideInfo.Revision); err != nil {
+				return fmt.Errorf(errorPrefix+"%s", err)
+			}
+			reports = append(reports, &bootFailureReport{
+				Snap:       actual.Name,
+				Revision:   info.SideInfo.Revision,
+				RevertedTo: actual.Revision,
+			})
 			// FIXME: check that there is no task
 			//        for this already in progress
 			ts, err := RevertToRevision(st, actual.Name, actual.Revision, Flags{})
@@ -77,6 +104,49 @@ func UpdateBootRevisions(st *state.State) error {
 				return err
 			}
 			tsAll = append(tsAll, ts)
+			if paired != nil && paired.Kernel == actual.Name && paired.KernelRevision == info.SideInfo.Revision {
+				// the gadget was updated for the kernel
+				// that failed to boot, revert it as well
+				gadgetTs, err := revertPairedGadget(st, paired)
+				if err != nil {
+					return err
+				}
+				if gadgetTs != nil {
+					// restore the gadget assets before
+					// the kernel revert, whose reboot
+					// applies both
+					if err := PairKernelAndGadget(ts, gadgetTs); err != nil {
+						return err
+					}
+					tsAll = append(tsAll, gadgetTs)
+					// the gadget revision failed to
+					// boot together with the kernel
+					_, gadgetSetup, err := tasksetSnapSetup(gadgetTs)
+					if err != nil {
+						return err
+					}
+					if err := recordBootFailure(st, paired.Gadget, paired.GadgetRevision); err != nil {
+						return fmt.Errorf(errorPrefix+"%s", err)
+					}
+					reports = append(reports, &bootFailureReport{
+						Snap:       paired.Gadget,
+						Revision:   paired.GadgetRevision,
+						RevertedTo: gadgetSetup.Revision(),
+					})
+				} else {
+					removePairedGadgetRollback(paired)
+				}
+			} else if paired != nil && paired.Kernel == actual.Name {
+				removePairedGadgetRollback(paired)
+			}
+		} else if paired != nil && paired.Kernel == actual.Name {
+			// the paired kernel booted, the gadget assets
+			// stay
+			removePairedGadgetRollback(paired)
+		}
+		if paired != nil && paired.Kernel == actual.Name {
+			// the outcome of the paired update is settled
+			setPendingPairedUpdate(st, nil)
 		}
 	}
 
@@ -84,6 +154,22 @@ func UpdateBootRevisions(st *state.State) error {
 		return nil
 	}
 
+	if len(reports) > 0 {
+		// the failures are reported on their own so that
+		// collecting the logs neither holds up nor fails the
+		// reverts; the logs are found through the current boot id
+		// even if a revert reboots first
+		bootID, err := osutil.BootID()
+		if err != nil {
+			logger.Noticef("cannot get the current boot id: %v", err)
+		}
+		summary := "Report snap revisions that failed to boot"
+		report := st.NewTask("report-boot-failures", summary)
+		report.Set("boot-failures", reports)
+		report.Set("boot-id", bootID)
+		st.NewChange("report-boot-failures", summary).AddTask(report)
+	}
+
 	msg := fmt.Sprintf("Update kernel and core snap revisions")
 	chg := st.NewChange("update-revisions", msg)
 	for _, ts := range tsAll {
@@ -93,3 +179,180 @@ func UpdateBootRevisions(st *state.State) error {
 
 	return nil
 }
+
+// bootFailure records the failed attempts at booting a revision of a
+// boot-critical snap, i.e. the kernel, the base or a gadget whose
+// update was paired with the one of a kernel. A gadget updated on its
+// own is not tried by the bootloader, so there is no fallback to
+// detect for it.
+type bootFailure struct {
+	Revision snap.Revision `json:"revision"`
+	Times    []time.Time   `json:"times"`
+	// Logs holds what was logged by the last failed boot, if
+	// anything could be collected.
+	Logs string `json:"logs,omitempty"`
+}
+
+// bootFailureReport is a revision that failed to boot and is to be
+// reported by a report-boot-failures task.
+type bootFailureReport struct {
+	Snap       string        `json:"snap"`
+	Revision   snap.Revision `json:"revision"`
+	RevertedTo snap.Revision `json:"reverted-to"`
+}
+
+// maxBootFailureTimes bounds the number of recorded failure times per
+// revision.
+const maxBootFailureTimes = 10
+
+// bootFailureLogs collects the warnings and errors logged by the boot
+// before the one with the given id, or before the current one if the
+// id is empty, if the journal of it was persisted.
+var bootFailureLogs = func(bootID string) string {
+	// journalctl takes the id without dashes, and an offset
+	// relative to it
+	boot := strings.Replace(bootID, "-", "", -1) + "-1"
+	output, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name:    "journalctl",
+		Args:    []string{"-b", boot, "--priority=warning..err", "--lines=20", "--no-pager"},
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		// the error carries the output of journalctl, if any
+		return fmt.Sprintf("error: %v", err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func bootFailures(st *state.State) (map[string][]*bootFailure, error) {
+	var failures map[string][]*bootFailure
+	err := st.Get("boot-failures", &failures)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if failures == nil {
+		failures = make(map[string][]*bootFailure)
+	}
+	return failures, nil
+}
+
+func findBootFailure(failures map[string][]*bootFailure, snapName string, rev snap.Revision) *bootFailure {
+	for _, f := range failures[snapName] {
+		if f.Revision == rev {
+			return f
+		}
+	}
+	return nil
+}
+
+// BootFailedRevisions returns the revisions of the given snap that
+// failed to boot and were reverted by the bootloader, and that are
+// thus not auto-refreshed to anymore.
+func BootFailedRevisions(st *state.State, snapName string) ([]snap.Revision, error) {
+	failures, err := bootFailures(st)
+	if err != nil {
+		return nil, err
+	}
+	var revs []snap.Revision
+	for _, f := range failures[snapName] {
+		revs = append(revs, f.Revision)
+	}
+	return revs, nil
+}
+
+// recordBootFailure records that the given revision of a boot-critical
+// snap failed to boot. From then on the revision is not auto-refreshed
+// to anymore, instead of the system going through the failing boot
+// again. The logs of the failed boot are collected later, without
+// holding the state lock, see doReportBootFailures.
+func recordBootFailure(st *state.State, snapName string, rev snap.Revision) error {
+	failures, err := bootFailures(st)
+	if err != nil {
+		return err
+	}
+	failure := findBootFailure(failures, snapName, rev)
+	if failure == nil {
+		failure = &bootFailure{Revision: rev}
+		failures[snapName] = append(failures[snapName], failure)
+	}
+	failure.Times = append(failure.Times, time.Now())
+	if len(failure.Times) > maxBootFailureTimes {
+		failure.Times = failure.Times[len(failure.Times)-maxBootFailureTimes:]
+	}
+	st.Set("boot-failures", failures)
+	return nil
+}
+
+// doReportBootFailures collects the logs of the failed boot, the one
+// before the boot the failures were detected in, and raises a critical
+// warning with them and the failure history of each of the revisions
+// that failed to boot.
+func (m *SnapManager) doReportBootFailures(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	var reports []*bootFailureReport
+	var bootID string
+	err := t.Get("boot-failures", &reports)
+	if err == nil {
+		err = t.Get("boot-id", &bootID)
+	}
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// querying the journal may take a while
+	logs := bootFailureLogs(bootID)
+
+	st.Lock()
+	defer st.Unlock()
+	failures, err := bootFailures(st)
+	if err != nil {
+		return err
+	}
+	for _, report := range reports {
+		failure := findBootFailure(failures, report.Snap, report.Revision)
+		if failure == nil {
+			// the revision booted fine since
+			continue
+		}
+		failure.Logs = logs
+
+		times := make([]string, len(failure.Times))
+		for i, t := range failure.Times {
+			times[i] = t.Format(time.RFC3339)
+		}
+		failureLogs := failure.Logs
+		if failureLogs == "" {
+			failureLogs = "-"
+		}
+		st.WarnfWithSeverity(state.WarningSeverityCritical, "snap %q revision %s failed to boot and was reverted to revision %s (failed boots at: %s); it will not be refreshed to automatically again. Logs of the last failed boot:\n%s",
+			report.Snap, report.Revision, report.RevertedTo, strings.Join(times, ", "), failureLogs)
+	}
+	st.Set("boot-failures", failures)
+	return nil
+}
+
+// clearBootFailures forgets the boot failures of the given revision
+// of the snap, as it has now booted successfully.
+func clearBootFailures(st *state.State, snapName string, rev snap.Revision) error {
+	failures, err := bootFailures(st)
+	if err != nil {
+		return err
+	}
+	snapFailures := failures[snapName]
+	for i, f := range snapFailures {
+		if f.Revision != rev {
+			continue
+		}
+		snapFailures = append(snapFailures[:i], snapFailures[i+1:]...)
+		if len(snapFailures) == 0 {
+			delete(failures, snapName)
+		} else {
+			failures[snapName] = snapFailures
+		}
+		st.Set("boot-failures", failures)
+		break
+	}
+	return nil
+}
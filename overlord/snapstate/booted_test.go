@@ -24,13 +24,16 @@ package snapstate_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/boot/boottest"
 	"github.com/snapcore/snapd/bootloader"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
@@ -74,6 +77,9 @@ func (bs *bootedSuite) SetUpTest(c *C) {
 	bs.fakeBackend = &fakeSnappyBackend{}
 	bs.o = overlord.Mock()
 	bs.state = bs.o.State()
+	bs.state.Lock()
+	bs.state.VerifyReboot("boot-id-0")
+	bs.state.Unlock()
 	bs.snapmgr, err = snapstate.Manager(bs.state, bs.o.TaskRunner())
 	c.Assert(err, IsNil)
 
@@ -89,6 +95,7 @@ func (bs *bootedSuite) SetUpTest(c *C) {
 		return nil, nil
 	}
 	bs.restore = snapstatetest.MockDeviceModel(DefaultModel())
+	bs.AddCleanup(snapstate.MockBootFailureLogs(func(string) string { return "" }))
 }
 
 func (bs *bootedSuite) TearDownTest(c *C) {
@@ -119,8 +126,8 @@ func (bs *bootedSuite) makeInstalledKernelOS(c *C, st *state.State) {
 		Current:  snap.R(2),
 	})
 
-	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: os\nversion: 1", kernelSI1)
-	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: os\nversion: 2", kernelSI2)
+	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: kernel\nversion: 1", kernelSI1)
+	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: kernel\nversion: 2", kernelSI2)
 	snapstate.Set(st, "canonical-pc-linux", &snapstate.SnapState{
 		SnapType: "kernel",
 		Active:   true,
@@ -130,6 +137,19 @@ func (bs *bootedSuite) makeInstalledKernelOS(c *C, st *state.State) {
 
 }
 
+// revisionsChange returns the change reverting the revisions that
+// failed to boot, besides which there is only the one reporting them.
+func revisionsChange(c *C, st *state.State) *state.Change {
+	c.Assert(st.Changes(), HasLen, 2)
+	for _, chg := range st.Changes() {
+		if chg.Kind() == "update-revisions" {
+			return chg
+		}
+	}
+	c.Fatalf("no update-revisions change")
+	return nil
+}
+
 func (bs *bootedSuite) TestUpdateBootRevisionsOSSimple(c *C) {
 	st := bs.state
 	st.Lock()
@@ -145,8 +165,7 @@ func (bs *bootedSuite) TestUpdateBootRevisionsOSSimple(c *C) {
 	bs.settle()
 	st.Lock()
 
-	c.Assert(st.Changes(), HasLen, 1)
-	chg := st.Changes()[0]
+	chg := revisionsChange(c, st)
 	c.Assert(chg.Err(), IsNil)
 	c.Assert(chg.Kind(), Equals, "update-revisions")
 	c.Assert(chg.IsReady(), Equals, true)
@@ -179,8 +198,7 @@ func (bs *bootedSuite) TestUpdateBootRevisionsKernelSimple(c *C) {
 	bs.settle()
 	st.Lock()
 
-	c.Assert(st.Changes(), HasLen, 1)
-	chg := st.Changes()[0]
+	chg := revisionsChange(c, st)
 	c.Assert(chg.Err(), IsNil)
 	c.Assert(chg.Kind(), Equals, "update-revisions")
 	c.Assert(chg.IsReady(), Equals, true)
@@ -198,6 +216,244 @@ func (bs *bootedSuite) TestUpdateBootRevisionsKernelSimple(c *C) {
 	c.Assert(snapst.Active, Equals, true)
 }
 
+func (bs *bootedSuite) TestUpdateBootRevisionsRecordsBootFailures(c *C) {
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledKernelOS(c, st)
+
+	currentBootID, err := osutil.BootID()
+	c.Assert(err, IsNil)
+	logs := "kernel: something went wrong"
+	restore := snapstate.MockBootFailureLogs(func(bootID string) string {
+		// the logs are those of the boot before the one the
+		// failure was detected in
+		c.Check(bootID, Equals, currentBootID)
+		// the logs are collected without holding the state lock
+		locked := make(chan struct{})
+		go func() {
+			st.Lock()
+			st.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			c.Fatalf("boot failure logs collected with the state locked")
+		}
+		return logs
+	})
+	defer restore()
+
+	// the bootloader falls back to revision 1
+	boottest.SetBootKernel("canonical-pc-linux_1.snap", bs.bootloader)
+	err = snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+
+	// a single failure blocks the revision
+	revs, err := snapstate.BootFailedRevisions(st, "canonical-pc-linux")
+	c.Assert(err, IsNil)
+	c.Check(revs, DeepEquals, []snap.Revision{snap.R(2)})
+	revs, err = snapstate.BootFailedRevisions(st, "core")
+	c.Assert(err, IsNil)
+	c.Check(revs, HasLen, 0)
+
+	// the failure is reported independently of the revert
+	changes := map[string]*state.Change{}
+	for _, chg := range st.Changes() {
+		changes[chg.Kind()] = chg
+	}
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes["report-boot-failures"], NotNil)
+	c.Check(changes["report-boot-failures"].Tasks(), HasLen, 1)
+	chg := changes["update-revisions"]
+	c.Assert(chg, NotNil)
+	for _, t := range chg.Tasks() {
+		c.Check(t.Kind(), Not(Equals), "report-boot-failures")
+	}
+
+	st.Unlock()
+	bs.settle()
+	st.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(changes["report-boot-failures"].Err(), IsNil)
+	var snapst snapstate.SnapState
+	err = snapstate.Get(st, "canonical-pc-linux", &snapst)
+	c.Assert(err, IsNil)
+	c.Assert(snapst.Current, Equals, snap.R(1))
+
+	warns := st.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].Severity(), Equals, state.WarningSeverityCritical)
+	c.Check(warns[0].String(), Matches, `(?s)snap "canonical-pc-linux" revision 2 failed to boot and was reverted to revision 1 \(failed boots at: [^,]*\); it will not be refreshed to automatically again. Logs of the last failed boot:\nkernel: something went wrong`)
+
+	// booting revision 2 later on successfully clears the record
+	err = snapstate.Get(st, "canonical-pc-linux", &snapst)
+	c.Assert(err, IsNil)
+	snapst.Current = snap.R(2)
+	snapstate.Set(st, "canonical-pc-linux", &snapst)
+
+	boottest.SetBootKernel("canonical-pc-linux_2.snap", bs.bootloader)
+	err = snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+
+	revs, err = snapstate.BootFailedRevisions(st, "canonical-pc-linux")
+	c.Assert(err, IsNil)
+	c.Check(revs, HasLen, 0)
+	var failures map[string]interface{}
+	err = st.Get("boot-failures", &failures)
+	c.Assert(err, IsNil)
+	c.Check(failures, HasLen, 0)
+}
+
+var gadgetSI1 = &snap.SideInfo{RealName: "pc", Revision: snap.R(1)}
+var gadgetSI2 = &snap.SideInfo{RealName: "pc", Revision: snap.R(2)}
+
+func (bs *bootedSuite) makeInstalledPairedUpdate(c *C, st *state.State) {
+	bs.makeInstalledKernelOS(c, st)
+
+	snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: 1", gadgetSI1)
+	snaptest.MockSnap(c, "name: pc\ntype: gadget\nversion: 2", gadgetSI2)
+	snapstate.Set(st, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{gadgetSI1, gadgetSI2},
+		Current:  snap.R(2),
+	})
+
+	snapstate.SetPendingPairedUpdate(st, &snapstate.PairedUpdate{
+		Kernel:         "canonical-pc-linux",
+		KernelRevision: snap.R(2),
+		Gadget:         "pc",
+		GadgetRevision: snap.R(2),
+	})
+}
+
+func (bs *bootedSuite) TestUpdateBootRevisionsPairedUpdateKernelFailed(c *C) {
+	var gadgetUpdates []snap.Revision
+	bs.o.TaskRunner().AddHandler("update-gadget-assets", func(t *state.Task, _ *tomb.Tomb) error {
+		t.State().Lock()
+		defer t.State().Unlock()
+		snapsup, err := snapstate.TaskSnapSetup(t)
+		c.Assert(err, IsNil)
+		gadgetUpdates = append(gadgetUpdates, snapsup.Revision())
+		return nil
+	}, nil)
+
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledPairedUpdate(c, st)
+
+	// the new kernel failed to boot
+	boottest.SetBootKernel("canonical-pc-linux_1.snap", bs.bootloader)
+	err := snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+
+	st.Unlock()
+	bs.settle()
+	st.Lock()
+
+	chg := revisionsChange(c, st)
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(chg.IsReady(), Equals, true)
+
+	// both the kernel and the gadget were reverted
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(st, "canonical-pc-linux", &snapst), IsNil)
+	c.Check(snapst.Current, Equals, snap.R(1))
+	c.Assert(snapstate.Get(st, "pc", &snapst), IsNil)
+	c.Check(snapst.Current, Equals, snap.R(1))
+	c.Check(gadgetUpdates, DeepEquals, []snap.Revision{snap.R(1)})
+
+	// the gadget revision failed to boot along with the kernel
+	revs, err := snapstate.BootFailedRevisions(st, "pc")
+	c.Assert(err, IsNil)
+	c.Check(revs, DeepEquals, []snap.Revision{snap.R(2)})
+	var warns []string
+	for _, w := range st.AllWarnings() {
+		warns = append(warns, w.String())
+	}
+	c.Check(warns, HasLen, 2)
+	c.Check(strings.Join(warns, "\n"), Matches, `(?s).*snap "pc" revision 2 failed to boot and was reverted to revision 1 .*`)
+
+	// the gadget assets are restored before the kernel revert, which
+	// reboots into both
+	links := map[string]*state.Task{}
+	for _, t := range chg.Tasks() {
+		if t.Kind() != "link-snap" {
+			continue
+		}
+		snapsup, err := snapstate.TaskSnapSetup(t)
+		c.Assert(err, IsNil)
+		links[snapsup.InstanceName()] = t
+	}
+	c.Assert(links, HasLen, 2)
+	c.Check(links["canonical-pc-linux"].WaitTasks(), testutil.Contains, links["pc"])
+	c.Check(strings.Join(links["canonical-pc-linux"].Log(), "\n"), Matches, `.*Requested system restart for the restored assets of gadget "pc".*`)
+
+	paired, err := snapstate.PendingPairedUpdate(st)
+	c.Assert(err, IsNil)
+	c.Check(paired, IsNil)
+}
+
+func (bs *bootedSuite) TestUpdateBootRevisionsPairedUpdateKernelFailedNewGadget(c *C) {
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledPairedUpdate(c, st)
+	// the gadget was installed by a remodel
+	snapstate.Set(st, "pc", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{gadgetSI2},
+		Current:  snap.R(2),
+	})
+	rollbackDir := filepath.Join(dirs.SnapRollbackDir, "pc_2")
+	c.Assert(os.MkdirAll(rollbackDir, 0750), IsNil)
+
+	// the new kernel failed to boot
+	boottest.SetBootKernel("canonical-pc-linux_1.snap", bs.bootloader)
+	err := snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+
+	// only the kernel is reverted, there is no earlier gadget
+	// revision to revert to
+	for _, t := range revisionsChange(c, st).Tasks() {
+		c.Check(t.Kind(), Not(Equals), "update-gadget-assets")
+	}
+	c.Check(osutil.IsDirectory(rollbackDir), Equals, false)
+}
+
+func (bs *bootedSuite) TestUpdateBootRevisionsPairedUpdateKernelBooted(c *C) {
+	st := bs.state
+	st.Lock()
+	defer st.Unlock()
+
+	bs.makeInstalledPairedUpdate(c, st)
+	// the backup of the gadget assets was kept
+	rollbackDir := filepath.Join(dirs.SnapRollbackDir, "pc_2")
+	c.Assert(os.MkdirAll(rollbackDir, 0750), IsNil)
+
+	// the new kernel booted fine
+	err := snapstate.UpdateBootRevisions(st)
+	c.Assert(err, IsNil)
+	c.Check(st.Changes(), HasLen, 0)
+	c.Check(osutil.IsDirectory(rollbackDir), Equals, false)
+
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(st, "pc", &snapst), IsNil)
+	c.Check(snapst.Current, Equals, snap.R(2))
+
+	paired, err := snapstate.PendingPairedUpdate(st)
+	c.Assert(err, IsNil)
+	c.Check(paired, IsNil)
+}
+
 func (bs *bootedSuite) TestUpdateBootRevisionsKernelErrorsEarly(c *C) {
 	st := bs.state
 	st.Lock()
@@ -228,7 +484,7 @@ func (bs *bootedSuite) TestUpdateBootRevisionsOSErrorsLate(c *C) {
 	defer st.Unlock()
 
 	// have a kernel
-	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: os\nversion: 2", kernelSI2)
+	snaptest.MockSnap(c, "name: canonical-pc-linux\ntype: kernel\nversion: 2", kernelSI2)
 	snapstate.Set(st, "canonical-pc-linux", &snapstate.SnapState{
 		SnapType: "kernel",
 		Active:   true,
@@ -254,8 +510,7 @@ func (bs *bootedSuite) TestUpdateBootRevisionsOSErrorsLate(c *C) {
 	bs.settle()
 	st.Lock()
 
-	c.Assert(st.Changes(), HasLen, 1)
-	chg := st.Changes()[0]
+	chg := revisionsChange(c, st)
 	c.Assert(chg.Kind(), Equals, "update-revisions")
 	c.Assert(chg.IsReady(), Equals, true)
 	c.Assert(chg.Err(), ErrorMatches, `(?ms).*Make snap "core" \(1\) available to the system \(fail\).*`)
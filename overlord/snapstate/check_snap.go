@@ -33,6 +33,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
 )
 
 // featureSet contains the flag values that can be listed in assumes entries
@@ -46,14 +47,42 @@ var featureSet = map[string]bool{
 	"command-chain": true,
 }
 
+// newerFeatures maps flag values that can be listed in assumes entries,
+// but which are only provided by newer versions of snapd, to the first
+// version of snapd that provides them.
+var newerFeatures = map[string]string{
+	// Support for kernel assets updated through the gadget update
+	// mechanism
+	"kernel-assets": "2.52",
+}
+
 func checkAssumes(si *snap.Info) error {
 	missing := ([]string)(nil)
+	// the minimum version of snapd providing all the missing
+	// features, if known
+	requiredVersion := ""
 	for _, flag := range si.Assumes {
 		if strings.HasPrefix(flag, "snapd") && checkVersion(flag[5:]) {
 			continue
 		}
-		if !featureSet[flag] {
-			missing = append(missing, flag)
+		if featureSet[flag] {
+			continue
+		}
+		missing = append(missing, flag)
+
+		var version string
+		if strings.HasPrefix(flag, "snapd") && versionExp.FindString(flag[5:]) == flag[5:] {
+			version = flag[5:]
+		} else {
+			version = newerFeatures[flag]
+		}
+		if version == "" {
+			continue
+		}
+		if requiredVersion == "" {
+			requiredVersion = version
+		} else if res, err := strutil.VersionCompare(version, requiredVersion); err == nil && res > 0 {
+			requiredVersion = version
 		}
 	}
 	if len(missing) > 0 {
@@ -61,6 +90,9 @@ func checkAssumes(si *snap.Info) error {
 		if release.OnClassic {
 			hint = "try to update snapd and refresh the core snap"
 		}
+		if requiredVersion != "" {
+			hint = fmt.Sprintf("snapd %s or later is required, %s", requiredVersion, hint)
+		}
 		return fmt.Errorf("snap %q assumes unsupported features: %s (%s)", si.InstanceName(), strings.Join(missing, ", "), hint)
 	}
 	return nil
@@ -340,7 +372,11 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags Fl
 
 	currentSnap, err := infoForDeviceSnap(st, deviceCtx, kind, whichName)
 	if err == state.ErrNoState {
-		// TODO: remodeling logic
+		if deviceCtx.ForRemodeling() && whichName(deviceCtx.Model()) == snapInfo.InstanceName() {
+			// switching to the snap of the new model, the
+			// devicestate checks cover this
+			return nil
+		}
 		return fmt.Errorf("internal error: cannot remodel kernel/gadget yet")
 	}
 	if err != nil {
@@ -150,6 +150,21 @@ var assumesTests = []struct {
 	error:   `.* unsupported features: snapd2\.15\.1 .*`,
 }, {
 	assumes: "[command-chain]",
+}, {
+	assumes: "[kernel-assets]",
+	error:   `snap "foo" assumes unsupported features: kernel-assets \(snapd 2.52 or later is required, try to refresh the core snap\)`,
+}, {
+	assumes: "[kernel-assets, f1]",
+	classic: true,
+	error:   `snap "foo" assumes unsupported features: f1, kernel-assets \(snapd 2.52 or later is required, try to update snapd and refresh the core snap\)`,
+}, {
+	assumes: "[snapd2.16, kernel-assets, snapd2.15.1]",
+	version: "2.15",
+	error:   `snap "foo" assumes unsupported features: kernel-assets, snapd2.15.1, snapd2.16 \(snapd 2.52 or later is required, .*\)`,
+}, {
+	assumes: "[snapd2.60, kernel-assets]",
+	version: "2.15",
+	error:   `snap "foo" assumes unsupported features: kernel-assets, snapd2.60 \(snapd 2.60 or later is required, .*\)`,
 }}
 
 func (s *checkSnapSuite) TestCheckSnapAssumes(c *C) {
@@ -449,6 +464,60 @@ version: 2
 	c.Check(err, ErrorMatches, "cannot replace gadget snap with a different one")
 }
 
+func (s *checkSnapSuite) TestCheckSnapGadgetSwitchOverRemodel(c *C) {
+	reset := release.MockOnClassic(false)
+	defer reset()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	si := &snap.SideInfo{RealName: "gadget", Revision: snap.R(2), SnapID: "gadget-id"}
+	snaptest.MockSnap(c, `
+name: gadget
+type: gadget
+version: 1
+`, si)
+	snapstate.Set(st, "gadget", &snapstate.SnapState{
+		SnapType: "gadget",
+		Active:   true,
+		Sequence: []*snap.SideInfo{si},
+		Current:  si.Revision,
+	})
+
+	const yaml = `name: zgadget
+type: gadget
+version: 2
+`
+
+	info, err := snap.InfoFromSnapYaml([]byte(yaml))
+	info.SnapID = "zgadget-id"
+	c.Assert(err, IsNil)
+
+	var openSnapFile = func(path string, si *snap.SideInfo) (*snap.Info, snap.Container, error) {
+		return info, emptyContainer(c), nil
+	}
+	restore := snapstate.MockOpenSnapFile(openSnapFile)
+	defer restore()
+
+	remodelCtx := &snapstatetest.TrivialDeviceContext{
+		DeviceModel: MakeModel(map[string]interface{}{"gadget": "zgadget"}),
+		Remodeling:  true,
+	}
+
+	st.Unlock()
+	err = snapstate.CheckSnap(st, "snap-path", "zgadget", nil, nil, snapstate.Flags{}, remodelCtx)
+	st.Lock()
+	c.Check(err, IsNil)
+
+	// but only to the gadget of the new model
+	remodelCtx.DeviceModel = MakeModel(map[string]interface{}{"gadget": "other-gadget"})
+	st.Unlock()
+	err = snapstate.CheckSnap(st, "snap-path", "zgadget", nil, nil, snapstate.Flags{}, remodelCtx)
+	st.Lock()
+	c.Check(err, ErrorMatches, "internal error: cannot remodel kernel/gadget yet")
+}
+
 func (s *checkSnapSuite) TestCheckSnapGadgetNoPrior(c *C) {
 	reset := release.MockOnClassic(false)
 	defer reset()
@@ -34,6 +34,10 @@ type DeviceContext interface {
 
 	// ForRemodeling returns whether this context is for use over a remodeling.
 	ForRemodeling() bool
+
+	// Serial returns the serial of the device under this context,
+	// or "" if it is not yet registered.
+	Serial() (string, error)
 }
 
 // Hook setup by devicestate to pick a device context from state,
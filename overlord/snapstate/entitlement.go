@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// snapsWithoutEntitlement returns the snaps whose last download failed
+// for lack of entitlement, they are left out of auto-refreshes.
+func snapsWithoutEntitlement(st *state.State) (map[string]bool, error) {
+	var snaps map[string]bool
+	if err := st.Get("snaps-without-entitlement", &snaps); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+func setNoEntitlement(st *state.State, instanceName string, noEntitlement bool) error {
+	snaps, err := snapsWithoutEntitlement(st)
+	if err != nil {
+		return err
+	}
+	if snaps[instanceName] == noEntitlement {
+		return nil
+	}
+	if noEntitlement {
+		if snaps == nil {
+			snaps = make(map[string]bool)
+		}
+		snaps[instanceName] = true
+	} else {
+		delete(snaps, instanceName)
+	}
+	if len(snaps) == 0 {
+		st.Set("snaps-without-entitlement", nil)
+	} else {
+		st.Set("snaps-without-entitlement", snaps)
+	}
+	return nil
+}
+
+// ResetEntitlements forgets about the snaps the download of which
+// failed for lack of entitlement, so that they are tried again by the
+// next auto-refresh. It is called when a user logs in, as the user might
+// be entitled to them.
+// The caller should be holding the state lock.
+func ResetEntitlements(st *state.State) {
+	st.Set("snaps-without-entitlement", nil)
+}
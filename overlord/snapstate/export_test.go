@@ -87,6 +87,8 @@ var (
 	DefaultContentPlugProviders = defaultContentPlugProviders
 
 	HasOtherInstances = hasOtherInstances
+
+	IsTransientDownloadError = isTransientDownloadError
 )
 
 func PreviousSideInfo(snapst *SnapState) *snap.SideInfo {
@@ -103,6 +105,17 @@ var (
 	SwitchSummary         = switchSummary
 )
 
+// boot failures
+var (
+	RecordBootFailure = recordBootFailure
+)
+
+func MockBootFailureLogs(f func(bootID string) string) (restore func()) {
+	old := bootFailureLogs
+	bootFailureLogs = f
+	return func() { bootFailureLogs = old }
+}
+
 // readme files
 var (
 	WriteSnapReadme = writeSnapReadme
@@ -192,6 +205,14 @@ func MockReRefreshRetryTimeout(d time.Duration) (restore func()) {
 	}
 }
 
+func (m *SnapManager) ObservesRefreshChanges() bool {
+	return m.refreshObserverID != 0
+}
+
+func (m *SnapManager) BlockedTask(cand *state.Task, running []*state.Task) bool {
+	return m.blockedTask(cand, running)
+}
+
 // aux store info
 var (
 	AuxStoreInfoFilename = auxStoreInfoFilename
@@ -201,3 +222,18 @@ var (
 )
 
 type AuxStoreInfo = auxStoreInfo
+
+// resources
+var ResourcesBlocked = resourcesBlocked
+
+func MockReportRefreshMetricsRetryInterval(intv time.Duration) (restore func()) {
+	old := reportRefreshMetricsRetryInterval
+	reportRefreshMetricsRetryInterval = intv
+	return func() { reportRefreshMetricsRetryInterval = old }
+}
+
+var (
+	SetPendingPairedUpdate = setPendingPairedUpdate
+	PendingPairedUpdate    = pendingPairedUpdate
+	ConfirmPairedUpdate    = confirmPairedUpdate
+)
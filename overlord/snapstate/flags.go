@@ -19,6 +19,10 @@
 
 package snapstate
 
+import (
+	"github.com/snapcore/snapd/client"
+)
+
 // Flags are used to pass additional flags to operations and to keep track of snap modes.
 type Flags struct {
 	// DevMode switches confinement to non-enforcing mode.
@@ -68,6 +72,10 @@ type Flags struct {
 
 	// RequireTypeBase is set to mark that a snap needs to be of type: base, otherwise installation fails.
 	RequireTypeBase bool `json:"require-base-type,omitempty"`
+
+	// Transaction is set to client.TransactionAllOrNothing to have a
+	// multi-snap operation undo all the snaps if any of them fails.
+	Transaction client.TransactionType `json:"transaction,omitempty"`
 }
 
 // DevModeAllowed returns whether a snap can be installed with devmode confinement (either set or overridden)
@@ -80,5 +88,6 @@ func (f Flags) ForSnapSetup() Flags {
 	f.SkipConfigure = false
 	f.NoReRefresh = false
 	f.RequireTypeBase = false
+	f.Transaction = ""
 	return f
 }
@@ -21,8 +21,12 @@ package snapstate
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -44,6 +48,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/timings"
@@ -491,6 +496,19 @@ func autoRefreshRateLimited(st *state.State) (rate int64) {
 	return val
 }
 
+// defaultMaxParallelDownloads is how many snaps are downloaded at the
+// same time unless configured otherwise via refresh.max-parallel-downloads.
+const defaultMaxParallelDownloads = 3
+
+func maxParallelDownloads(st *state.State) int {
+	var max int
+	err := config.NewTransaction(st).Get("core", "refresh.max-parallel-downloads", &max)
+	if err != nil || max < 1 {
+		return defaultMaxParallelDownloads
+	}
+	return max
+}
+
 func downloadSnapParams(st *state.State, t *state.Task) (*SnapSetup, StoreService, *auth.UserState, error) {
 	snapsup, err := TaskSnapSetup(t)
 	if err != nil {
@@ -553,6 +571,21 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 			err = theStore.Download(tomb.Context(nil), snapsup.SnapName(), targetFn, snapsup.DownloadInfo, meter, user, dlOpts)
 		})
 	}
+	if _, ok := err.(*store.NoEntitlementError); ok {
+		st.Lock()
+		defer st.Unlock()
+		if snapsup.IsAutoRefresh {
+			st.Warnf("snap %q is no longer refreshed automatically: %v", snapsup.InstanceName(), err)
+		}
+		if serr := setNoEntitlement(st, snapsup.InstanceName(), true); serr != nil {
+			return serr
+		}
+	}
+	if snapsup.IsAutoRefresh && isTransientDownloadError(err) {
+		// auto-refreshes can wait for the store or the network to
+		// be back, changes asked for by the user fail right away
+		return &state.Retry{Reason: fmt.Sprintf("cannot download snap %q: %v", snapsup.SnapName(), err)}
+	}
 	if err != nil {
 		return err
 	}
@@ -562,12 +595,51 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 	// update the snap setup for the follow up tasks
 	st.Lock()
 	t.Set("snap-setup", snapsup)
+	if err := setNoEntitlement(st, snapsup.InstanceName(), false); err != nil {
+		st.Unlock()
+		return err
+	}
 	perfTimings.Save(st)
 	st.Unlock()
 
 	return nil
 }
 
+// isTransientDownloadError returns whether a download failed because of
+// the store or the network, after retrying, such that it is worth trying
+// again later.
+func isTransientDownloadError(err error) bool {
+	switch e := err.(type) {
+	case *store.DownloadError:
+		return e.Code >= 500 || e.Code == 429
+	case *url.Error:
+		// a cancelled download or a certificate that cannot be
+		// verified are not fixed by trying again
+		if e.Err == context.Canceled || e.Err == context.DeadlineExceeded {
+			return false
+		}
+		return !isCertificateError(e.Err)
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// isCertificateError returns whether the error, or one it wraps, is
+// about the TLS handshake or verifying the certificate of the server.
+func isCertificateError(err error) bool {
+	for err != nil {
+		switch err.(type) {
+		case x509.CertificateInvalidError, x509.HostnameError, x509.UnknownAuthorityError, x509.SystemRootsError, tls.RecordHeaderError:
+			return true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
 var (
 	mountPollInterval = 1 * time.Second
 )
@@ -686,6 +758,11 @@ func (m *SnapManager) doMountSnap(t *state.Task, _ *tomb.Tomb) error {
 	st.Lock()
 	// set snapst type for undoMountSnap
 	t.Set("snap-type", snapType)
+	if snapsup.PairedUpdate != nil && snapsup.PairedUpdate.Tentative && snapType == snap.TypeGadget {
+		// only the new gadget tells whether its update is paired
+		// with the one of the kernel
+		confirmPairedUpdate(t, snapsup)
+	}
 	st.Unlock()
 
 	if snapsup.Flags.RemoveSnapPath {
@@ -812,6 +889,12 @@ func (m *SnapManager) undoUnlinkCurrentSnap(t *state.Task, _ *tomb.Tomb) error {
 		return err
 	}
 
+	if EnsureSnapServicesQuota != nil {
+		if err := EnsureSnapServicesQuota(st, oldInfo); err != nil {
+			return err
+		}
+	}
+
 	snapst.Active = true
 	err = m.backend.LinkSnap(oldInfo, model, perfTimings)
 	if err != nil {
@@ -1014,6 +1097,7 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) (err error) {
 		snapst.Required = true
 	}
 	oldRefreshInhibitedTime := snapst.RefreshInhibitedTime
+	oldRefreshHeldTime := snapst.RefreshHeldTime
 	// only set userID if unset or logged out in snapst and if we
 	// actually have an associated user
 	if snapsup.UserID > 0 {
@@ -1042,6 +1126,14 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) (err error) {
 	// record type
 	snapst.SetType(newInfo.GetType())
 
+	// the services of the snap are put in the slice of its quota group
+	// before they are written
+	if EnsureSnapServicesQuota != nil {
+		if err := EnsureSnapServicesQuota(st, newInfo); err != nil {
+			return err
+		}
+	}
+
 	// XXX: this block is slightly ugly, find a pattern when we have more examples
 	model, _ := ModelFromTask(t)
 	err = m.backend.LinkSnap(newInfo, model, perfTimings)
@@ -1078,17 +1170,20 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) (err error) {
 	t.Set("old-current", oldCurrent)
 	t.Set("old-candidate-index", oldCandidateIndex)
 	t.Set("old-refresh-inhibited-time", oldRefreshInhibitedTime)
+	t.Set("old-refresh-held-time", oldRefreshHeldTime)
 	t.Set("old-cohort-key", oldCohortKey)
 
-	// Record the fact that the snap was refreshed successfully.
+	// Record the fact that the snap was refreshed successfully, any
+	// hold of its refreshes is over.
 	snapst.RefreshInhibitedTime = nil
+	snapst.RefreshHeldTime = nil
 
 	// Do at the end so we only preserve the new state if it worked.
 	Set(st, snapsup.InstanceName(), snapst)
 
 	if cand.SnapID != "" {
 		// write the auxiliary store info
-		aux := &auxStoreInfo{Media: snapsup.Media}
+		aux := &auxStoreInfo{Media: snapsup.Media, Links: snapsup.Links}
 		if err := keepAuxStoreInfo(cand.SnapID, aux); err != nil {
 			return err
 		}
@@ -1133,12 +1228,26 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) (err error) {
 		}
 	}
 
+	// the outcome of a paired update is known only after booting
+	// the new kernel
+	if snapsup.PairedUpdate != nil && !snapsup.Revert && newInfo.GetType() == snap.TypeKernel {
+		setPendingPairedUpdate(st, snapsup.PairedUpdate)
+	}
+
 	// Make sure if state commits and snapst is mutated we won't be rerun
 	t.SetStatus(state.DoneStatus)
 
-	// if we just installed a core snap, request a restart
-	// so that we switch executing its snapd
-	maybeRestart(t, newInfo)
+	if snapsup.PairedUpdate != nil && snapsup.Revert && newInfo.GetType() == snap.TypeKernel {
+		// the assets of the paired gadget were restored before and
+		// deferred their reboot to the one of the kernel revert,
+		// which may not need one on its own
+		t.Logf("Requested system restart for the restored assets of gadget %q.", snapsup.PairedUpdate.Gadget)
+		st.RequestRestart(state.RestartSystem)
+	} else {
+		// if we just installed a core snap, request a restart
+		// so that we switch executing its snapd
+		maybeRestart(t, newInfo)
+	}
 
 	return nil
 }
@@ -1146,6 +1255,23 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) (err error) {
 // maybeRestart will schedule a reboot or restart as needed for the
 // just linked snap with info if it's a core or snapd or kernel snap.
 func maybeRestart(t *state.Task, info *snap.Info) {
+	if snapdenv.Preseeding() {
+		// the new snapd or system is used on first boot anyway
+		return
+	}
+
+	restart, reason := restartNeeded(t, info, boot.ChangeRequiresReboot)
+	if restart == state.RestartUnset {
+		return
+	}
+	t.Logf("%s", reason)
+	t.State().RequestRestart(restart)
+}
+
+// restartNeeded returns which restart linking the given snap requires,
+// if any, and why. requiresReboot tells whether the system needs to
+// reboot to boot the snap.
+func restartNeeded(t *state.Task, info *snap.Info, requiresReboot func(*snap.Info) bool) (restart state.RestartType, reason string) {
 	st := t.State()
 
 	if release.OnClassic {
@@ -1153,31 +1279,28 @@ func maybeRestart(t *state.Task, info *snap.Info) {
 		snapdSnapInstalled, _ := isInstalled(st, "snapd")
 		if (info.GetType() == snap.TypeOS && !snapdSnapInstalled) ||
 			info.GetType() == snap.TypeSnapd {
-			t.Logf("Requested daemon restart.")
-			st.RequestRestart(state.RestartDaemon)
+			return state.RestartDaemon, "Requested daemon restart."
 		}
-		return
+		return state.RestartUnset, ""
 	}
 
 	// On a core system we may need a full reboot if
 	// core/base or the kernel changes.
-	if boot.ChangeRequiresReboot(info) {
-		t.Logf("Requested system restart.")
-		st.RequestRestart(state.RestartSystem)
-		return
+	if requiresReboot(info) {
+		return state.RestartSystem, "Requested system restart."
 	}
 
 	// On core systems that use a base snap we need to restart
 	// snapd when the snapd snap changes.
 	model, err := ModelFromTask(t)
 	if err != nil {
-		logger.Noticef("cannot get model assertion: %v", model)
-		return
+		logger.Noticef("cannot get model assertion: %v", err)
+		return state.RestartUnset, ""
 	}
 	if model.Base() != "" && info.GetType() == snap.TypeSnapd {
-		t.Logf("Requested daemon restart (snapd snap).")
-		st.RequestRestart(state.RestartDaemon)
+		return state.RestartDaemon, "Requested daemon restart (snapd snap)."
 	}
+	return state.RestartUnset, ""
 }
 
 func (m *SnapManager) undoLinkSnap(t *state.Task, _ *tomb.Tomb) error {
@@ -1236,6 +1359,10 @@ func (m *SnapManager) undoLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 	if err := t.Get("old-refresh-inhibited-time", &oldRefreshInhibitedTime); err != nil && err != state.ErrNoState {
 		return err
 	}
+	var oldRefreshHeldTime *time.Time
+	if err := t.Get("old-refresh-held-time", &oldRefreshHeldTime); err != nil && err != state.ErrNoState {
+		return err
+	}
 	var oldCohortKey string
 	if err := t.Get("old-cohort-key", &oldCohortKey); err != nil && err != state.ErrNoState {
 		return err
@@ -1283,6 +1410,7 @@ func (m *SnapManager) undoLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 	snapst.JailMode = oldJailMode
 	snapst.Classic = oldClassic
 	snapst.RefreshInhibitedTime = oldRefreshInhibitedTime
+	snapst.RefreshHeldTime = oldRefreshHeldTime
 	snapst.CohortKey = oldCohortKey
 
 	newInfo, err := readInfo(snapsup.InstanceName(), snapsup.SideInfo, 0)
@@ -1290,6 +1418,10 @@ func (m *SnapManager) undoLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 		return err
 	}
 
+	if snapsup.PairedUpdate != nil && !snapsup.Revert && newInfo.GetType() == snap.TypeKernel {
+		setPendingPairedUpdate(st, nil)
+	}
+
 	if len(snapst.Sequence) > 0 {
 		if err = config.RestoreRevisionConfig(st, snapsup.InstanceName(), oldCurrent); err != nil {
 			return err
@@ -1560,7 +1692,7 @@ func (m *SnapManager) doDiscardSnap(t *state.Task, _ *tomb.Tomb) error {
 	err = m.backend.RemoveSnapFiles(snapsup.placeInfo(), typ, pb)
 	if err != nil {
 		t.Errorf("cannot remove snap file %q, will retry in 3 mins: %s", snapsup.InstanceName(), err)
-		return &state.Retry{After: 3 * time.Minute}
+		return &state.Retry{After: 3 * time.Minute, Reason: fmt.Sprintf("cannot remove snap file: %v", err)}
 	}
 	if len(snapst.Sequence) == 0 {
 		// Remove configuration associated with this snap.
@@ -1571,7 +1703,7 @@ func (m *SnapManager) doDiscardSnap(t *state.Task, _ *tomb.Tomb) error {
 		err = m.backend.DiscardSnapNamespace(snapsup.InstanceName())
 		if err != nil {
 			t.Errorf("cannot discard snap namespace %q, will retry in 3 mins: %s", snapsup.InstanceName(), err)
-			return &state.Retry{After: 3 * time.Minute}
+			return &state.Retry{After: 3 * time.Minute, Reason: fmt.Sprintf("cannot discard snap namespace: %v", err)}
 		}
 		if err := m.removeSnapCookie(st, snapsup.InstanceName()); err != nil {
 			return fmt.Errorf("cannot remove snap cookie: %v", err)
@@ -1665,10 +1797,17 @@ func (m *SnapManager) doSetAutoAliases(t *state.Task, _ *tomb.Tomb) error {
 		return err
 	}
 	_, err = checkAliasesConflicts(st, snapName, snapst.AutoAliasesDisabled, newAliases, nil)
+	var otherDropped map[string]map[string]*AliasTarget
+	if _, ok := err.(*AliasConflictError); ok {
+		otherDropped, err = m.resolveSeedingAliasesConflicts(t, snapName, snapst.AutoAliasesDisabled, newAliases, err)
+	}
 	if err != nil {
 		return err
 	}
 
+	if len(otherDropped) != 0 {
+		t.Set("other-dropped-aliases", otherDropped)
+	}
 	t.Set("old-aliases-v2", curAliases)
 	// noop, except on first install where we need to set this here
 	snapst.AliasesPending = true
@@ -1677,6 +1816,28 @@ func (m *SnapManager) doSetAutoAliases(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+// resolveSeedingAliasesConflicts resolves the alias conflicts found
+// when setting the automatic aliases of a snap during seeding, where
+// they would abort the first boot, as prescribed by the model. Outside
+// of seeding it returns conflictErr. The aliases dropped from other
+// snaps are returned by snap.
+func (m *SnapManager) resolveSeedingAliasesConflicts(t *state.Task, snapName string, autoDisabled bool, newAliases map[string]*AliasTarget, conflictErr error) (map[string]map[string]*AliasTarget, error) {
+	st := t.State()
+	var seeded bool
+	err := st.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if seeded {
+		return nil, conflictErr
+	}
+	deviceCtx, err := DeviceCtx(st, t, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resolveSeedingAliasesConflicts(st, deviceCtx.Model(), snapName, autoDisabled, newAliases, m.backend)
+}
+
 func (m *SnapManager) doRemoveAliases(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
@@ -1783,6 +1944,19 @@ func (m *SnapManager) undoRefreshAliases(t *state.Task, _ *tomb.Tomb) error {
 	if err = t.Get("other-disabled-aliases", &otherSnapDisabled); err != nil && err != state.ErrNoState {
 		return err
 	}
+	// automatic aliases dropped from other snaps during seeding
+	var otherSnapDropped map[string]map[string]*AliasTarget
+	if err = t.Get("other-dropped-aliases", &otherSnapDropped); err != nil && err != state.ErrNoState {
+		return err
+	}
+	for otherSnap := range otherSnapDropped {
+		if otherSnapDisabled == nil {
+			otherSnapDisabled = make(map[string]*otherDisabledAliases)
+		}
+		if otherSnapDisabled[otherSnap] == nil {
+			otherSnapDisabled[otherSnap] = &otherDisabledAliases{}
+		}
+	}
 
 	// check if the old states creates conflicts now
 	_, err = checkAliasesConflicts(st, snapName, autoDisabled, oldAliases, nil)
@@ -1829,6 +2003,11 @@ func (m *SnapManager) undoRefreshAliases(t *state.Task, _ *tomb.Tomb) error {
 			autoDisabled = false
 		}
 		otherAliases := reenableAliases(otherCurInfo, otherSnapState.Aliases, otherDisabled.Manual)
+		for alias, target := range otherSnapDropped[otherSnap] {
+			if otherAliases[alias] == nil {
+				otherAliases[alias] = target
+			}
+		}
 		// check for conflicts taking into account
 		// re-enabled aliases
 		conflicts, err := checkAliasesConflicts(st, otherSnap, autoDisabled, otherAliases, newSnapStates)
@@ -1945,6 +2124,64 @@ func aliasesTrace(t *state.Task, added, removed []*backend.Alias) error {
 	return nil
 }
 
+// bestEffortAlias wraps the do handler of an alias task so that a
+// failure of a task marked with BestEffortAliases is logged and recorded
+// on the task rather than returned. As the failing handlers do not change
+// the aliases, there is nothing to undo for such a task.
+func bestEffortAlias(do state.HandlerFunc) state.HandlerFunc {
+	return func(t *state.Task, tomb *tomb.Tomb) error {
+		err := do(t, tomb)
+		if err == nil {
+			return nil
+		}
+		st := t.State()
+		st.Lock()
+		defer st.Unlock()
+		var bestEffort bool
+		if e := t.Get("best-effort", &bestEffort); e != nil && e != state.ErrNoState {
+			return e
+		}
+		if !bestEffort {
+			return err
+		}
+		t.Errorf("%v", err)
+		t.Set("alias-error", err.Error())
+		return aliasPreferenceError(t, err)
+	}
+}
+
+// aliasPreferenceError records err as the error of the alias preference
+// the task performs, if any, in the "alias-preferences" of the api-data
+// of its change.
+func aliasPreferenceError(t *state.Task, aliasErr error) error {
+	var idx int
+	if err := t.Get("alias-preference", &idx); err != nil {
+		if err == state.ErrNoState {
+			return nil
+		}
+		return err
+	}
+	chg := t.Change()
+	var data map[string]interface{}
+	if err := chg.Get("api-data", &data); err != nil && err != state.ErrNoState {
+		return err
+	}
+	prefs, ok := data["alias-preferences"].([]interface{})
+	if !ok {
+		return nil
+	}
+	if idx < 0 || idx >= len(prefs) {
+		return fmt.Errorf("internal error: no alias preference %d in change %s", idx, chg.ID())
+	}
+	pref, _ := prefs[idx].(map[string]interface{})
+	if pref == nil {
+		return fmt.Errorf("internal error: invalid alias preference %d in change %s", idx, chg.ID())
+	}
+	pref["error"] = aliasErr.Error()
+	chg.Set("api-data", data)
+	return nil
+}
+
 func (m *SnapManager) doAlias(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
@@ -20,11 +20,14 @@
 package snapstate_test
 
 import (
+	"sort"
+
 	. "gopkg.in/check.v1"
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
 )
@@ -251,6 +254,199 @@ func (s *snapmgrTestSuite) TestDoSetAutoAliasesConflict(c *C) {
 	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot enable alias "alias4" for "alias-snap", already enabled for "other-snap".*`)
 }
 
+func (s *snapmgrTestSuite) setupSetAutoAliasesConflictSeeding(c *C, fourthAliases map[string]*snapstate.AliasTarget) (*state.Task, func()) {
+	s.state.Set("seeded", nil)
+	r := snapstatetest.MockDeviceModel(MakeModel(map[string]interface{}{
+		"required-snaps": []interface{}{"other-snap", "alias-snap"},
+	}))
+
+	snapstate.AutoAliases = func(st *state.State, info *snap.Info) (map[string]string, error) {
+		c.Check(info.InstanceName(), Equals, "alias-snap")
+		return map[string]string{
+			"alias1":     "cmd1",
+			"alias4":     "cmd4",
+			"alias5":     "cmd5",
+			"third-snap": "cmd3",
+		}, nil
+	}
+
+	snapstate.Set(s.state, "alias-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "alias-snap", Revision: snap.R(11)},
+		},
+		Current:        snap.R(11),
+		Active:         true,
+		AliasesPending: true,
+	})
+	// listed before alias-snap in the model
+	snapstate.Set(s.state, "other-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "other-snap", Revision: snap.R(3)},
+		},
+		Current: snap.R(3),
+		Active:  true,
+		Aliases: map[string]*snapstate.AliasTarget{
+			"alias4": {Auto: "cmd4"},
+		},
+	})
+	// not listed in the model
+	snapstate.Set(s.state, "third-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "third-snap", Revision: snap.R(2)},
+		},
+		Current: snap.R(2),
+		Active:  true,
+		Aliases: map[string]*snapstate.AliasTarget{
+			"alias5": {Auto: "cmd5"},
+		},
+	})
+	if fourthAliases != nil {
+		snapstate.Set(s.state, "fourth-snap", &snapstate.SnapState{
+			Sequence: []*snap.SideInfo{
+				{RealName: "fourth-snap", Revision: snap.R(4)},
+			},
+			Current: snap.R(4),
+			Active:  true,
+			Aliases: fourthAliases,
+		})
+	}
+
+	t := s.state.NewTask("set-auto-aliases", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{RealName: "alias-snap"},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	return t, r
+}
+
+func (s *snapmgrTestSuite) TestDoSetAutoAliasesConflictSeeding(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	t, restore := s.setupSetAutoAliasesConflictSeeding(c, nil)
+	defer restore()
+	chg := t.Change()
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+
+	c.Check(t.Status(), Equals, state.DoneStatus, Commentf("%v", chg.Err()))
+
+	var snapst snapstate.SnapState
+	err := snapstate.Get(s.state, "alias-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, DeepEquals, map[string]*snapstate.AliasTarget{
+		"alias1": {Auto: "cmd1"},
+		"alias5": {Auto: "cmd5"},
+	})
+	err = snapstate.Get(s.state, "other-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, DeepEquals, map[string]*snapstate.AliasTarget{
+		"alias4": {Auto: "cmd4"},
+	})
+	err = snapstate.Get(s.state, "third-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, HasLen, 0)
+
+	c.Check(s.fakeBackend.ops, DeepEquals, fakeOps{
+		{
+			op:        "update-aliases",
+			rmAliases: []*backend.Alias{{Name: "alias5", Target: "third-snap.cmd5"}},
+		},
+	})
+
+	var warns []string
+	for _, w := range s.state.AllWarnings() {
+		c.Check(w.Severity(), Equals, state.WarningSeverityInfo)
+		warns = append(warns, w.String())
+	}
+	sort.Strings(warns)
+	c.Check(warns, DeepEquals, []string{
+		`cannot enable alias "alias4" for "alias-snap", enabled for "other-snap" which has priority in the model`,
+		`cannot enable alias "alias5" for "third-snap", enabled for "alias-snap" which has priority in the model`,
+		`cannot enable alias "third-snap" for "alias-snap", it conflicts with the command namespace of installed snap "third-snap", not enabling it during seeding`,
+	})
+}
+
+func (s *snapmgrTestSuite) TestDoUndoSetAutoAliasesConflictSeeding(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	t, restore := s.setupSetAutoAliasesConflictSeeding(c, nil)
+	defer restore()
+	chg := t.Change()
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(t)
+	chg.AddTask(terr)
+
+	s.state.Unlock()
+
+	for i := 0; i < 3; i++ {
+		s.se.Ensure()
+		s.se.Wait()
+	}
+
+	s.state.Lock()
+
+	c.Check(t.Status(), Equals, state.UndoneStatus, Commentf("%v", chg.Err()))
+
+	// the alias dropped from the other snap is back
+	var snapst snapstate.SnapState
+	err := snapstate.Get(s.state, "third-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, DeepEquals, map[string]*snapstate.AliasTarget{
+		"alias5": {Auto: "cmd5"},
+	})
+
+	c.Check(s.fakeBackend.ops, DeepEquals, fakeOps{
+		{
+			op:        "update-aliases",
+			rmAliases: []*backend.Alias{{Name: "alias5", Target: "third-snap.cmd5"}},
+		},
+		{
+			op:      "update-aliases",
+			aliases: []*backend.Alias{{Name: "alias5", Target: "third-snap.cmd5"}},
+		},
+	})
+}
+
+func (s *snapmgrTestSuite) TestDoSetAutoAliasesConflictSeedingUnresolvable(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// a manual alias conflicting with alias-snap cannot be resolved
+	t, restore := s.setupSetAutoAliasesConflictSeeding(c, map[string]*snapstate.AliasTarget{
+		"alias1": {Manual: "cmd1"},
+	})
+	defer restore()
+	chg := t.Change()
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*alias "alias1" (for "alias-snap", )?already enabled for "fourth-snap".*`)
+
+	// the resolvable conflicts did not change the other snaps either
+	var snapst snapstate.SnapState
+	err := snapstate.Get(s.state, "third-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Aliases, DeepEquals, map[string]*snapstate.AliasTarget{
+		"alias5": {Auto: "cmd5"},
+	})
+	c.Check(s.fakeBackend.ops, HasLen, 0)
+	c.Check(s.state.AllWarnings(), HasLen, 0)
+}
+
 func (s *snapmgrTestSuite) TestDoUndoSetAutoAliasesConflict(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -20,7 +20,16 @@
 package snapstate_test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -295,3 +304,239 @@ func (s *downloadSnapSuite) TestDoDownloadRateLimitedIntegration(c *C) {
 	})
 
 }
+
+func (s *downloadSnapSuite) TestBlockedTaskParallelDownloads(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var running []*state.Task
+	for i := 0; i < 3; i++ {
+		running = append(running, s.state.NewTask("download-snap", "..."))
+	}
+	cand := s.state.NewTask("download-snap", "...")
+
+	// up to 3 downloads by default
+	c.Check(s.snapmgr.BlockedTask(cand, running[:2]), Equals, false)
+	c.Check(s.snapmgr.BlockedTask(cand, running), Equals, true)
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.max-parallel-downloads", 4)
+	tr.Commit()
+	c.Check(s.snapmgr.BlockedTask(cand, running), Equals, false)
+
+	tr = config.NewTransaction(s.state)
+	tr.Set("core", "refresh.max-parallel-downloads", 1)
+	tr.Commit()
+	c.Check(s.snapmgr.BlockedTask(cand, nil), Equals, false)
+	c.Check(s.snapmgr.BlockedTask(cand, running[:1]), Equals, true)
+
+	// other tasks do not count
+	other := s.state.NewTask("mount-snap", "...")
+	c.Check(s.snapmgr.BlockedTask(cand, []*state.Task{other}), Equals, false)
+}
+
+func (s *downloadSnapSuite) TestBlockedTaskSerializedLinkSnap(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	download := s.state.NewTask("download-snap", "...")
+	link := s.state.NewTask("link-snap", "...")
+	cand := s.state.NewTask("link-snap", "...")
+
+	c.Check(s.snapmgr.BlockedTask(cand, []*state.Task{download}), Equals, false)
+	c.Check(s.snapmgr.BlockedTask(cand, []*state.Task{download, link}), Equals, true)
+}
+
+func (s *downloadSnapSuite) TestDoDownloadSnapNoEntitlement(c *C) {
+	s.fakeStore.downloadErrors = map[string]error{
+		"foo": &store.NoEntitlementError{Snap: "foo"},
+	}
+
+	s.state.Lock()
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(2),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+		Flags: snapstate.Flags{IsAutoRefresh: true},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*no entitlement to snap "foo".*`)
+
+	var snaps map[string]bool
+	c.Assert(s.state.Get("snaps-without-entitlement", &snaps), IsNil)
+	c.Check(snaps, DeepEquals, map[string]bool{"foo": true})
+
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].String(), Matches, `snap "foo" is no longer refreshed automatically: no entitlement to snap "foo": .*`)
+
+	// once the download succeeds again the snap is forgotten about
+	s.fakeStore.downloadErrors = nil
+	t = s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(2),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	chg = s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.state.Get("snaps-without-entitlement", &snaps), Equals, state.ErrNoState)
+}
+
+func (s *downloadSnapSuite) TestDoDownloadSnapRetriesTransientErrors(c *C) {
+	s.fakeStore.downloadErrors = map[string]error{
+		"foo": &store.DownloadError{Code: 503, URL: &url.URL{Scheme: "https", Host: "example.com"}},
+	}
+
+	s.state.Lock()
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(2),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+		Flags: snapstate.Flags{IsAutoRefresh: true},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	// the download is tried again later
+	s.state.Lock()
+	c.Check(t.Status(), Equals, state.DoingStatus)
+	c.Check(t.DoingRetries(), Equals, 1)
+	c.Check(t.AtTime().After(time.Now().Add(time.Minute-time.Second)), Equals, true)
+
+	s.fakeStore.downloadErrors = nil
+	t.At(time.Time{})
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.fakeStore.downloads, HasLen, 2)
+}
+
+func (s *downloadSnapSuite) TestDoDownloadSnapPermanentError(c *C) {
+	s.fakeStore.downloadErrors = map[string]error{
+		"foo": &store.DownloadError{Code: 404, URL: &url.URL{Scheme: "https", Host: "example.com"}},
+	}
+
+	s.state.Lock()
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(2),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*unexpected http response code \(404\).*`)
+}
+
+func (s *downloadSnapSuite) TestDoDownloadSnapTransientErrorNotAutoRefresh(c *C) {
+	s.fakeStore.downloadErrors = map[string]error{
+		"foo": &store.DownloadError{Code: 503, URL: &url.URL{Scheme: "https", Host: "example.com"}},
+	}
+
+	s.state.Lock()
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(2),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	// the user is not kept waiting
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(t.DoingRetries(), Equals, 0)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*unexpected http response code \(503\).*`)
+}
+
+func (s *downloadSnapSuite) TestIsTransientDownloadError(c *C) {
+	u := "https://example.com/snap"
+	for _, t := range []struct {
+		err       error
+		transient bool
+	}{
+		{&store.DownloadError{Code: 503}, true},
+		{&store.DownloadError{Code: 429}, true},
+		{&store.DownloadError{Code: 404}, false},
+		{io.ErrUnexpectedEOF, true},
+		{&url.Error{Op: "Get", URL: u, Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{&url.Error{Op: "Get", URL: u, Err: context.Canceled}, false},
+		{&url.Error{Op: "Get", URL: u, Err: context.DeadlineExceeded}, false},
+		{&url.Error{Op: "Get", URL: u, Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Get", URL: u, Err: x509.HostnameError{Host: "example.com"}}, false},
+		{&url.Error{Op: "Get", URL: u, Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, false},
+		{&url.Error{Op: "Get", URL: u, Err: &wrappedError{x509.CertificateInvalidError{}}}, false},
+		{errors.New("some other error"), false},
+	} {
+		c.Check(snapstate.IsTransientDownloadError(t.err), Equals, t.transient, Commentf("%v", t.err))
+	}
+}
+
+type wrappedError struct {
+	err error
+}
+
+func (e *wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
@@ -36,6 +36,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -131,6 +132,45 @@ func (s *linkSnapSuite) TestDoLinkSnapSuccess(c *C) {
 	c.Check(snapstate.AuxStoreInfoFilename("foo-id"), testutil.FilePresent)
 }
 
+func (s *linkSnapSuite) TestDoLinkSnapEnsuresServicesQuota(c *C) {
+	var quotaInfos []*snap.Info
+	oldEnsureSnapServicesQuota := snapstate.EnsureSnapServicesQuota
+	snapstate.EnsureSnapServicesQuota = func(st *state.State, info *snap.Info) error {
+		quotaInfos = append(quotaInfos, info)
+		return fmt.Errorf("boom")
+	}
+	defer func() { snapstate.EnsureSnapServicesQuota = oldEnsureSnapServicesQuota }()
+
+	s.state.Lock()
+	t := s.state.NewTask("link-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			Revision: snap.R(33),
+		},
+	})
+	chg := s.state.NewChange("dummy", "...")
+	chg.AddTask(t)
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Assert(quotaInfos, HasLen, 1)
+	c.Check(quotaInfos[0].InstanceName(), Equals, "foo")
+	c.Check(quotaInfos[0].Revision, Equals, snap.R(33))
+
+	// the snap is not linked
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*boom.*`)
+	var snapst snapstate.SnapState
+	err := snapstate.Get(s.state, "foo", &snapst)
+	c.Assert(err, Equals, state.ErrNoState)
+}
+
 func (s *linkSnapSuite) TestDoLinkSnapSuccessWithCohort(c *C) {
 	// we start without the auxiliary store info
 	c.Check(snapstate.AuxStoreInfoFilename("foo-id"), testutil.FileAbsent)
@@ -440,6 +480,36 @@ func (s *linkSnapSuite) TestDoLinkSnapSuccessCoreRestarts(c *C) {
 	c.Check(t.Log()[0], Matches, `.*INFO Requested daemon restart\.`)
 }
 
+func (s *linkSnapSuite) TestDoLinkSnapSuccessCoreNoRestartPreseeding(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+	restore = snapdenv.MockPreseeding(true)
+	defer restore()
+
+	s.state.Lock()
+	si := &snap.SideInfo{
+		RealName: "core",
+		Revision: snap.R(33),
+	}
+	t := s.state.NewTask("link-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: si,
+	})
+	s.state.NewChange("dummy", "...").AddTask(t)
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(s.stateBackend.restartRequested, HasLen, 0)
+	c.Check(t.Log(), HasLen, 0)
+}
+
 func (s *linkSnapSuite) TestDoLinkSnapSuccessSnapdRestartsOnCoreWithBase(c *C) {
 	restore := release.MockOnClassic(false)
 	defer restore()
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"time"
+
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// heldSnaps returns the snaps whose auto-refreshes are held by the
+// user, with the time they are held until.
+func heldSnaps(st *state.State) (map[string]time.Time, error) {
+	var held map[string]time.Time
+	if err := st.Get("snaps-refresh-hold", &held); err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return held, nil
+}
+
+// HoldRefresh holds the auto-refreshes of the given snap until the
+// given time, or releases them if it is zero, and returns the time they
+// are held until. Unlike with the refresh.hold option the other snaps
+// are still refreshed, and so is the snap when refreshed by hand. A hold
+// until a later time is kept, and holds are capped to the maximum
+// postponement of refreshes from now.
+// The caller should be holding the state lock.
+func HoldRefresh(st *state.State, instanceName string, until time.Time) (time.Time, error) {
+	var snapst SnapState
+	if err := Get(st, instanceName, &snapst); err != nil && err != state.ErrNoState {
+		return time.Time{}, err
+	}
+	if !snapst.IsInstalled() {
+		return time.Time{}, &snap.NotInstalledError{Snap: instanceName}
+	}
+
+	held, err := heldSnaps(st)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	for name, t := range held {
+		if !t.After(now) {
+			delete(held, name)
+		}
+	}
+	if until.IsZero() {
+		delete(held, instanceName)
+	} else {
+		if limit := now.Add(maxPostponement); until.After(limit) {
+			until = limit
+		}
+		if held[instanceName].After(until) {
+			until = held[instanceName]
+		}
+		if held == nil {
+			held = make(map[string]time.Time)
+		}
+		held[instanceName] = until
+	}
+	if len(held) == 0 {
+		st.Set("snaps-refresh-hold", nil)
+	} else {
+		st.Set("snaps-refresh-hold", held)
+	}
+	return until, nil
+}
@@ -0,0 +1,272 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// PairedUpdate describes a kernel and a gadget updated together because
+// their new revisions depend on each other, e.g. new device trees for a
+// new kernel, as declared by the new gadget with paired-kernel. Updating
+// them independently would go through a reboot into an incompatible mix
+// of the two, so instead the gadget assets are updated and both snaps
+// are linked before a single reboot, and both are reverted, again with a
+// single reboot, if the new kernel then fails to boot.
+type PairedUpdate struct {
+	Kernel         string        `json:"kernel"`
+	KernelRevision snap.Revision `json:"kernel-revision"`
+	Gadget         string        `json:"gadget"`
+	GadgetRevision snap.Revision `json:"gadget-revision"`
+	// Tentative is set until the new gadget is mounted and confirms
+	// that it pairs its updates with the ones of the kernel, see
+	// confirmPairedUpdate.
+	Tentative bool `json:"tentative,omitempty"`
+}
+
+// tasksetSnapSetup returns the task of the task set holding its
+// SnapSetup, together with it.
+func tasksetSnapSetup(ts *state.TaskSet) (*state.Task, *SnapSetup, error) {
+	for _, t := range ts.Tasks() {
+		if !t.Has("snap-setup") {
+			continue
+		}
+		var snapsup SnapSetup
+		if err := t.Get("snap-setup", &snapsup); err != nil {
+			return nil, nil, err
+		}
+		return t, &snapsup, nil
+	}
+	return nil, nil, fmt.Errorf("internal error: cannot find snap setup in task set")
+}
+
+// setTasksetPairedUpdate sets the paired update in all the SnapSetups of
+// the task set, as the prerequisites task keeps its own copy.
+func setTasksetPairedUpdate(ts *state.TaskSet, paired *PairedUpdate) error {
+	for _, t := range ts.Tasks() {
+		if !t.Has("snap-setup") {
+			continue
+		}
+		var snapsup SnapSetup
+		if err := t.Get("snap-setup", &snapsup); err != nil {
+			return err
+		}
+		snapsup.PairedUpdate = paired
+		t.Set("snap-setup", &snapsup)
+	}
+	return nil
+}
+
+func tasksetTaskOfKind(ts *state.TaskSet, kind string) *state.Task {
+	for _, t := range ts.Tasks() {
+		if t.Kind() == kind {
+			return t
+		}
+	}
+	return nil
+}
+
+// PairKernelAndGadget arranges for the update tasks of a kernel and a
+// gadget to be performed as a PairedUpdate: both are marked as such and
+// the gadget is completely updated before the kernel is linked, so that
+// the reboot requested for the latter is the only one.
+func PairKernelAndGadget(kernelTs, gadgetTs *state.TaskSet) error {
+	_, kernelSetup, err := tasksetSnapSetup(kernelTs)
+	if err != nil {
+		return err
+	}
+	_, gadgetSetup, err := tasksetSnapSetup(gadgetTs)
+	if err != nil {
+		return err
+	}
+	kernelLink := tasksetTaskOfKind(kernelTs, "link-snap")
+	gadgetLink := tasksetTaskOfKind(gadgetTs, "link-snap")
+	if kernelLink == nil || gadgetLink == nil {
+		return fmt.Errorf("internal error: cannot find link tasks of paired kernel and gadget updates")
+	}
+
+	paired := &PairedUpdate{
+		Kernel:         kernelSetup.InstanceName(),
+		KernelRevision: kernelSetup.Revision(),
+		Gadget:         gadgetSetup.InstanceName(),
+		GadgetRevision: gadgetSetup.Revision(),
+	}
+	if err := setTasksetPairedUpdate(kernelTs, paired); err != nil {
+		return err
+	}
+	if err := setTasksetPairedUpdate(gadgetTs, paired); err != nil {
+		return err
+	}
+
+	kernelLink.WaitFor(gadgetLink)
+
+	return nil
+}
+
+// pairKernelAndGadgetUpdates tentatively pairs the update tasks of a
+// kernel and a gadget, see PairKernelAndGadget. Only the new gadget can
+// declare the pairing, so the latter is confirmed, or dropped, once the
+// new gadget is mounted.
+func pairKernelAndGadgetUpdates(kernelTs, gadgetTs *state.TaskSet) error {
+	if err := PairKernelAndGadget(kernelTs, gadgetTs); err != nil {
+		return err
+	}
+	_, snapsup, err := tasksetSnapSetup(kernelTs)
+	if err != nil {
+		return err
+	}
+	paired := snapsup.PairedUpdate
+	paired.Tentative = true
+	for _, ts := range []*state.TaskSet{kernelTs, gadgetTs} {
+		if err := setTasksetPairedUpdate(ts, paired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gadgetPairsKernel returns whether the given gadget declares its
+// updates paired with the ones of the given kernel.
+func gadgetPairsKernel(info *snap.Info, kernelName string) (bool, error) {
+	const onClassic = false
+	gi, err := snap.ReadGadgetInfo(info, onClassic)
+	if err != nil {
+		return false, err
+	}
+	return gi.PairedKernel == kernelName, nil
+}
+
+// confirmPairedUpdate settles the tentative paired update of the given
+// mounted gadget. If the new gadget declares its updates paired with the
+// ones of the kernel, the update tasks of both are made to fail and undo
+// together, otherwise they are unpaired and go on as independent
+// updates.
+func confirmPairedUpdate(t *state.Task, gadgetSetup *SnapSetup) {
+	st := t.State()
+	paired := *gadgetSetup.PairedUpdate
+
+	pairs := false
+	info, err := readInfo(gadgetSetup.InstanceName(), gadgetSetup.SideInfo, 0)
+	if err == nil {
+		pairs, err = gadgetPairsKernel(info, paired.Kernel)
+	}
+	if err != nil {
+		logger.Noticef("cannot check whether gadget %q pairs its updates with kernel %q: %v", paired.Gadget, paired.Kernel, err)
+	}
+
+	// find all the tasks of the paired update before updating their
+	// snap setups, most tasks refer to the one of their prerequisites
+	var tasks []*state.Task
+	for _, ct := range t.Change().Tasks() {
+		snapsup, err := TaskSnapSetup(ct)
+		if err != nil || snapsup.PairedUpdate == nil || *snapsup.PairedUpdate != paired {
+			continue
+		}
+		tasks = append(tasks, ct)
+	}
+	for _, ct := range tasks {
+		if !ct.Has("snap-setup") {
+			continue
+		}
+		snapsup, err := TaskSnapSetup(ct)
+		if err != nil {
+			continue
+		}
+		if pairs {
+			snapsup.PairedUpdate.Tentative = false
+		} else {
+			snapsup.PairedUpdate = nil
+		}
+		ct.Set("snap-setup", snapsup)
+	}
+	if !pairs {
+		t.Logf("Gadget %q does not pair its updates with kernel %q", paired.Gadget, paired.Kernel)
+		return
+	}
+
+	lane := st.NewLane()
+	for _, ct := range tasks {
+		ct.JoinLane(lane)
+	}
+}
+
+// setPendingPairedUpdate records the paired update whose outcome
+// depends on the next boot, or forgets about it if paired is nil.
+func setPendingPairedUpdate(st *state.State, paired *PairedUpdate) {
+	if paired == nil {
+		st.Set("pending-paired-update", nil)
+		return
+	}
+	st.Set("pending-paired-update", paired)
+}
+
+func pendingPairedUpdate(st *state.State) (*PairedUpdate, error) {
+	var paired PairedUpdate
+	err := st.Get("pending-paired-update", &paired)
+	if err == state.ErrNoState {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &paired, nil
+}
+
+// removePairedGadgetRollback removes the backup of the assets of the
+// gadget of a paired update, kept until the outcome of the latter is
+// settled.
+func removePairedGadgetRollback(paired *PairedUpdate) {
+	rollbackDir := filepath.Join(dirs.SnapRollbackDir, fmt.Sprintf("%s_%s", paired.Gadget, paired.GadgetRevision))
+	if err := os.RemoveAll(rollbackDir); err != nil {
+		logger.Noticef("cannot remove gadget update rollback directory %q: %v", rollbackDir, err)
+	}
+}
+
+// revertPairedGadget returns the tasks to revert the gadget of a paired
+// update whose kernel failed to boot, nil if the gadget is not at the
+// revision of the paired update anymore or has no earlier revision to
+// revert to. The update of the gadget
+// assets keeps their backup until then, so that the revert can restore
+// them.
+func revertPairedGadget(st *state.State, paired *PairedUpdate) (*state.TaskSet, error) {
+	var snapst SnapState
+	if err := Get(st, paired.Gadget, &snapst); err != nil {
+		if err == state.ErrNoState {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if snapst.Current != paired.GadgetRevision {
+		return nil, nil
+	}
+	if snapst.previousSideInfo() == nil {
+		// the gadget was installed by a remodel, undoing the
+		// latter restores the assets of the previous gadget
+		return nil, nil
+	}
+	return Revert(st, paired.Gadget, Flags{})
+}
@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// PlannedTask describes a task of a simulated change.
+type PlannedTask struct {
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+}
+
+// ChangePlan describes what running a change made of given task sets
+// would do, without running it.
+type ChangePlan struct {
+	Tasks []PlannedTask `json:"tasks"`
+	// Downloads lists the snaps that would be downloaded
+	Downloads []string `json:"downloads,omitempty"`
+	// Restart is set if snapd would restart
+	Restart bool `json:"restart,omitempty"`
+	// Reboot is set if the system would reboot
+	Reboot bool `json:"reboot,omitempty"`
+}
+
+// Plan returns the plan of the change that would be made of the given
+// task sets, which must not have been added to a change. Their tasks
+// are never run and are pruned from the state once planned.
+func Plan(st *state.State, tsets []*state.TaskSet) (*ChangePlan, error) {
+	defer func() {
+		for _, ts := range tsets {
+			st.PruneTasks(ts.Tasks())
+		}
+	}()
+
+	plan := &ChangePlan{Tasks: []PlannedTask{}}
+	for _, ts := range tsets {
+		byID := make(map[string]*state.Task, len(ts.Tasks()))
+		for _, t := range ts.Tasks() {
+			byID[t.ID()] = t
+		}
+		for _, t := range ts.Tasks() {
+			plan.Tasks = append(plan.Tasks, PlannedTask{
+				Kind:    t.Kind(),
+				Summary: t.Summary(),
+			})
+			switch t.Kind() {
+			case "download-snap":
+				snapsup, err := plannedSnapSetup(t, byID)
+				if err != nil {
+					return nil, err
+				}
+				plan.Downloads = append(plan.Downloads, snapsup.InstanceName())
+			case "link-snap":
+				snapsup, err := plannedSnapSetup(t, byID)
+				if err != nil {
+					return nil, err
+				}
+				restart, reboot, err := linkImpact(t, snapsup)
+				if err != nil {
+					return nil, err
+				}
+				plan.Restart = plan.Restart || restart
+				plan.Reboot = plan.Reboot || reboot
+			}
+		}
+	}
+	return plan, nil
+}
+
+// plannedSnapSetup is like TaskSnapSetup but for tasks not added to a
+// change, which cannot be looked up in the state.
+func plannedSnapSetup(t *state.Task, byID map[string]*state.Task) (*SnapSetup, error) {
+	var snapsup SnapSetup
+	err := t.Get("snap-setup", &snapsup)
+	if err != state.ErrNoState {
+		return &snapsup, err
+	}
+	var id string
+	if err := t.Get("snap-setup-task", &id); err != nil {
+		return nil, err
+	}
+	setupTask := byID[id]
+	if setupTask == nil {
+		return nil, fmt.Errorf("internal error: task %q refers to unknown snap setup task %q", t.Kind(), id)
+	}
+	if err := setupTask.Get("snap-setup", &snapsup); err != nil {
+		return nil, err
+	}
+	return &snapsup, nil
+}
+
+// linkImpact predicts, as maybeRestart decides when the link-snap task
+// runs, whether linking the snap of snapsup would restart snapd or
+// reboot the system.
+func linkImpact(t *state.Task, snapsup *SnapSetup) (restart, reboot bool, err error) {
+	info := &snap.Info{
+		SideInfo:    *snapsup.SideInfo,
+		SnapType:    snapsup.Type,
+		InstanceKey: snapsup.InstanceKey,
+	}
+	var rebootErr error
+	requiresReboot := func(info *snap.Info) bool {
+		var wouldReboot bool
+		wouldReboot, rebootErr = plannedBootChange(t, info)
+		return wouldReboot
+	}
+	rt, _ := restartNeeded(t, info, requiresReboot)
+	if rebootErr != nil {
+		return false, false, rebootErr
+	}
+	return rt == state.RestartDaemon, rt == state.RestartSystem, nil
+}
+
+// plannedBootChange predicts boot.ChangeRequiresReboot before the snap is
+// linked: linking the kernel or the boot base of the model makes it the
+// one to boot next, which requires a reboot unless its revision is the
+// one in use already.
+func plannedBootChange(t *state.Task, info *snap.Info) (bool, error) {
+	if info.GetType() != snap.TypeKernel && info.GetType() != snap.TypeOS && info.GetType() != snap.TypeBase {
+		return false, nil
+	}
+	model, err := ModelFromTask(t)
+	if err == state.ErrNoState {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	bootBase := "core"
+	if model.Base() != "" {
+		bootBase = model.Base()
+	}
+	if info.InstanceName() != model.Kernel() && info.InstanceName() != bootBase {
+		return false, nil
+	}
+	var snapst SnapState
+	if err := Get(t.State(), info.InstanceName(), &snapst); err != nil && err != state.ErrNoState {
+		return false, err
+	}
+	return snapst.Current != info.Revision, nil
+}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (s *snapmgrTestSuite) TestPlanInstall(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := snapstate.Install(context.Background(), s.state, "some-snap", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	plan, err := snapstate.Plan(s.state, []*state.TaskSet{ts})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Tasks, HasLen, len(ts.Tasks()))
+	for i, t := range ts.Tasks() {
+		c.Check(plan.Tasks[i], Equals, snapstate.PlannedTask{Kind: t.Kind(), Summary: t.Summary()})
+	}
+	c.Check(plan.Downloads, DeepEquals, []string{"some-snap"})
+	c.Check(plan.Restart, Equals, false)
+	c.Check(plan.Reboot, Equals, false)
+
+	// nothing is run, and the tasks are gone
+	c.Check(s.state.Changes(), HasLen, 0)
+	c.Check(s.state.TaskCount(), Equals, 0)
+}
+
+func (s *snapmgrTestSuite) TestPlanInstallSnapdOnClassicRestarts(c *C) {
+	restore := release.MockOnClassic(true)
+	defer restore()
+	restore = snap.MockSnapdSnapID("snapd-id") // id provided by fakeStore
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := snapstate.Install(context.Background(), s.state, "snapd", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	plan, err := snapstate.Plan(s.state, []*state.TaskSet{ts})
+	c.Assert(err, IsNil)
+	c.Check(plan.Downloads, DeepEquals, []string{"snapd"})
+	c.Check(plan.Restart, Equals, true)
+	c.Check(plan.Reboot, Equals, false)
+}
+
+func (s *snapmgrTestSuite) TestPlanInstallKernelOnCoreReboots(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+	restore = snapstatetest.MockDeviceModel(MakeModel(map[string]interface{}{
+		"kernel": "some-kernel",
+	}))
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := snapstate.Install(context.Background(), s.state, "some-kernel", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	plan, err := snapstate.Plan(s.state, []*state.TaskSet{ts})
+	c.Assert(err, IsNil)
+	c.Check(plan.Restart, Equals, false)
+	c.Check(plan.Reboot, Equals, true)
+}
+
+func (s *snapmgrTestSuite) TestPlanInstallOtherKernelOnCoreNoReboot(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// some-kernel is not the kernel of the model, it is never booted
+	ts, err := snapstate.Install(context.Background(), s.state, "some-kernel", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+
+	plan, err := snapstate.Plan(s.state, []*state.TaskSet{ts})
+	c.Assert(err, IsNil)
+	c.Check(plan.Restart, Equals, false)
+	c.Check(plan.Reboot, Equals, false)
+}
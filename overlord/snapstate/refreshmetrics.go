@@ -0,0 +1,339 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store"
+)
+
+// RefreshMetric records the outcome of the refresh of a snap. It carries
+// no information identifying the device or its users.
+type RefreshMetric struct {
+	Snap         string        `json:"snap"`
+	FromRevision snap.Revision `json:"from-revision"`
+	ToRevision   snap.Revision `json:"to-revision"`
+	// Auto is set if the snap was auto-refreshed.
+	Auto bool `json:"auto,omitempty"`
+	// Success is set if the snap was refreshed, otherwise ErrorClass
+	// is one of "download", "prerequisites", "hook" or "install",
+	// after the kind of task that failed, or "aborted" if the refresh
+	// was undone because of another failure in the same change.
+	Success    bool   `json:"success"`
+	ErrorClass string `json:"error-class,omitempty"`
+	// Duration is how long the refresh of the snap took, failed or not.
+	Duration time.Duration `json:"duration"`
+	// Delta is set if the store offered a delta to download instead of
+	// the full snap.
+	Delta bool `json:"delta,omitempty"`
+	// Time is when the refresh of the snap finished.
+	Time time.Time `json:"time"`
+	// Reported is set once the metric was passed to ReportRefreshMetrics.
+	Reported bool `json:"reported,omitempty"`
+}
+
+// maxRefreshMetrics is how many refresh metrics are kept, the oldest
+// ones are dropped first.
+var maxRefreshMetrics = 100
+
+// reportRefreshMetricsRetryInterval is how long to wait to report the
+// refresh metrics again after a failure.
+var reportRefreshMetricsRetryInterval = 6 * time.Hour
+
+// ReportRefreshMetrics, if set, is called without the state lock held
+// with the refresh metrics not reported yet, if the reporting of refresh
+// metrics was opted in with the refresh.report-metrics core option.
+var ReportRefreshMetrics func(st *state.State, metrics []*RefreshMetric) error
+
+// RefreshMetrics returns the metrics of the recent refreshes of snaps,
+// whether they were reported or not.
+func RefreshMetrics(st *state.State) ([]*RefreshMetric, error) {
+	var metrics []*RefreshMetric
+	err := st.Get("refresh-metrics", &metrics)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+type refreshMetrics struct {
+	state *state.State
+
+	lastReportFailure time.Time
+}
+
+func newRefreshMetrics(st *state.State) *refreshMetrics {
+	return &refreshMetrics{state: st}
+}
+
+// recordChange observes the state for refresh changes becoming ready,
+// to record the outcome of the refresh of their snaps.
+func (r *refreshMetrics) recordChange(n *state.Notification) {
+	upd, ok := n.Data.(*state.ChangeUpdate)
+	if !ok || !upd.Ready {
+		return
+	}
+	if upd.Kind != "auto-refresh" && upd.Kind != "refresh-snap" {
+		return
+	}
+	chg := r.state.Change(upd.ID)
+	if chg == nil {
+		return
+	}
+	var recorded bool
+	if err := chg.Get("refresh-metrics-recorded", &recorded); err == nil && recorded {
+		return
+	}
+	chg.Set("refresh-metrics-recorded", true)
+	since, err := r.recordingSince()
+	if err != nil {
+		logger.Noticef("cannot get refresh metrics: %v", err)
+		return
+	}
+	if chg.ReadyTime().Before(since) {
+		// ready before refresh metrics were recorded, e.g. left
+		// over from before snapd was updated
+		return
+	}
+	newMetrics := collectRefreshMetrics(r.state, chg)
+	if len(newMetrics) == 0 {
+		return
+	}
+
+	metrics, err := RefreshMetrics(r.state)
+	if err != nil {
+		logger.Noticef("cannot get refresh metrics: %v", err)
+		return
+	}
+	metrics = append(metrics, newMetrics...)
+	if len(metrics) > maxRefreshMetrics {
+		metrics = metrics[len(metrics)-maxRefreshMetrics:]
+	}
+	r.state.Set("refresh-metrics", metrics)
+}
+
+// recordingSince returns when the recording of refresh metrics started.
+// It starts with the first call.
+func (r *refreshMetrics) recordingSince() (time.Time, error) {
+	var since time.Time
+	err := r.state.Get("refresh-metrics-since", &since)
+	if err == state.ErrNoState {
+		since = time.Now()
+		r.state.Set("refresh-metrics-since", since)
+		return since, nil
+	}
+	return since, err
+}
+
+// refreshMetricsErrorClass classifies the failure of a refresh after the
+// kind of the task that failed.
+func refreshMetricsErrorClass(kind string) string {
+	switch kind {
+	case "download-snap", "validate-snap":
+		return "download"
+	case "prerequisites":
+		return "prerequisites"
+	case "run-hook":
+		return "hook"
+	}
+	return "install"
+}
+
+// taskSnapName returns the instance name of the snap the task operates
+// on, if any.
+func taskSnapName(t *state.Task) string {
+	if t.Kind() == "run-hook" {
+		var hooksup struct {
+			Snap string `json:"snap"`
+		}
+		if err := t.Get("hook-setup", &hooksup); err != nil {
+			return ""
+		}
+		return hooksup.Snap
+	}
+	snapsup, err := TaskSnapSetup(t)
+	if err != nil {
+		return ""
+	}
+	return snapsup.InstanceName()
+}
+
+func collectRefreshMetrics(st *state.State, chg *state.Change) []*RefreshMetric {
+	var names []string
+	tasksByName := make(map[string][]*state.Task)
+	linkTasks := make(map[string]*state.Task)
+	for _, t := range chg.Tasks() {
+		name := taskSnapName(t)
+		if name == "" {
+			continue
+		}
+		if tasksByName[name] == nil {
+			names = append(names, name)
+		}
+		tasksByName[name] = append(tasksByName[name], t)
+		if t.Kind() == "link-snap" {
+			linkTasks[name] = t
+		}
+	}
+
+	var metrics []*RefreshMetric
+	for _, name := range names {
+		linkTask := linkTasks[name]
+		if linkTask == nil {
+			// not refreshing this snap
+			continue
+		}
+		snapsup, err := TaskSnapSetup(linkTask)
+		if err != nil {
+			continue
+		}
+		metric := &RefreshMetric{
+			Snap:       name,
+			ToRevision: snapsup.Revision(),
+			Auto:       snapsup.IsAutoRefresh,
+			Success:    true,
+			Delta:      snapsup.DownloadInfo != nil && len(snapsup.DownloadInfo.Deltas) > 0,
+			Time:       chg.ReadyTime(),
+		}
+
+		var spawnTime, readyTime time.Time
+		for _, t := range tasksByName[name] {
+			if spawnTime.IsZero() || t.SpawnTime().Before(spawnTime) {
+				spawnTime = t.SpawnTime()
+			}
+			if t.ReadyTime().After(readyTime) {
+				readyTime = t.ReadyTime()
+			}
+			switch t.Status() {
+			case state.DoneStatus:
+			case state.ErrorStatus:
+				if metric.ErrorClass == "" || metric.ErrorClass == "aborted" {
+					metric.ErrorClass = refreshMetricsErrorClass(t.Kind())
+				}
+				metric.Success = false
+			default:
+				if metric.ErrorClass == "" {
+					metric.ErrorClass = "aborted"
+				}
+				metric.Success = false
+			}
+		}
+		if metric.Success {
+			metric.ErrorClass = ""
+		}
+		if !readyTime.IsZero() {
+			metric.Duration = readyTime.Sub(spawnTime)
+		}
+
+		if err := linkTask.Get("old-current", &metric.FromRevision); err != nil {
+			var snapst SnapState
+			if err := Get(st, name, &snapst); err == nil {
+				metric.FromRevision = snapst.Current
+			}
+		}
+
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+func (r *refreshMetrics) reportOptedIn() (bool, error) {
+	// the option can be set either as a boolean or as a string
+	var report interface{}
+	tr := config.NewTransaction(r.state)
+	err := tr.Get("core", "refresh.report-metrics", &report)
+	if err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	return fmt.Sprintf("%v", report) == "true", nil
+}
+
+// Ensure starts the recording of refresh metrics, and reports the ones
+// not reported yet if the reporting was opted in.
+func (r *refreshMetrics) Ensure() error {
+	r.state.Lock()
+	defer r.state.Unlock()
+
+	// refresh changes ready from now on are recorded
+	if _, err := r.recordingSince(); err != nil {
+		return err
+	}
+
+	if ReportRefreshMetrics == nil {
+		return nil
+	}
+
+	if !r.lastReportFailure.IsZero() && time.Now().Sub(r.lastReportFailure) < reportRefreshMetricsRetryInterval {
+		return nil
+	}
+	optedIn, err := r.reportOptedIn()
+	if err != nil || !optedIn {
+		return err
+	}
+
+	metrics, err := RefreshMetrics(r.state)
+	if err != nil {
+		return err
+	}
+	var toReport []*RefreshMetric
+	for _, m := range metrics {
+		if !m.Reported {
+			toReport = append(toReport, m)
+		}
+	}
+	if len(toReport) == 0 {
+		return nil
+	}
+
+	r.state.Unlock()
+	err = ReportRefreshMetrics(r.state, toReport)
+	r.state.Lock()
+	if err != nil {
+		r.lastReportFailure = time.Now()
+		if err == store.ErrNoRefreshMetricsURL {
+			logger.Debugf("cannot report refresh metrics: %v", err)
+		} else {
+			logger.Noticef("cannot report refresh metrics: %v", err)
+		}
+		return nil
+	}
+	r.lastReportFailure = time.Time{}
+
+	// metrics may have been recorded meanwhile
+	metrics, err = RefreshMetrics(r.state)
+	if err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		for _, reported := range toReport {
+			if m.Snap == reported.Snap && m.ToRevision == reported.ToRevision && m.Time.Equal(reported.Time) {
+				m.Reported = true
+			}
+		}
+	}
+	r.state.Set("refresh-metrics", metrics)
+	return nil
+}
@@ -0,0 +1,229 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (s *snapmgrTestSuite) refreshSomeSnap(c *C, fail bool) *state.Change {
+	si := snap.SideInfo{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Revision: snap.R(7),
+	}
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Channel:  "stable",
+		Current:  si.Revision,
+		SnapType: "app",
+	})
+
+	chg := s.state.NewChange("refresh-snap", "refresh a snap")
+	ts, err := snapstate.Update(s.state, "some-snap", &snapstate.RevisionOptions{Channel: "some-channel"}, s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	if fail {
+		last := lastWithLane(ts.Tasks())
+		c.Assert(last, NotNil)
+		terr := s.state.NewTask("error-trigger", "provoking total undo")
+		terr.WaitFor(last)
+		terr.JoinLane(last.Lanes()[0])
+		chg.AddTask(terr)
+	}
+
+	s.state.Unlock()
+	defer s.se.Stop()
+	s.settle(c)
+	s.state.Lock()
+
+	return chg
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsRecorded(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chg := s.refreshSomeSnap(c, false)
+	c.Assert(chg.Status(), Equals, state.DoneStatus)
+
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	m := metrics[0]
+	c.Check(m.Snap, Equals, "some-snap")
+	c.Check(m.FromRevision, Equals, snap.R(7))
+	c.Check(m.ToRevision, Equals, snap.R(11))
+	c.Check(m.Success, Equals, true)
+	c.Check(m.ErrorClass, Equals, "")
+	c.Check(m.Auto, Equals, false)
+	c.Check(m.Reported, Equals, false)
+	c.Check(m.Duration >= 0, Equals, true)
+	c.Check(m.Time.Equal(chg.ReadyTime()), Equals, true)
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsRecordedFailure(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chg := s.refreshSomeSnap(c, true)
+	c.Assert(chg.Status(), Equals, state.ErrorStatus)
+
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	m := metrics[0]
+	c.Check(m.Snap, Equals, "some-snap")
+	c.Check(m.FromRevision, Equals, snap.R(7))
+	c.Check(m.ToRevision, Equals, snap.R(11))
+	c.Check(m.Success, Equals, false)
+	// the refresh of the snap itself worked but was undone
+	c.Check(m.ErrorClass, Equals, "aborted")
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsNotRecordedBeforeSince(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// refresh changes that were ready before the recording started,
+	// as happens to those left over from before snapd was updated
+	s.state.Set("refresh-metrics-since", time.Now().Add(time.Hour))
+	chg := s.refreshSomeSnap(c, false)
+	c.Assert(chg.Status(), Equals, state.DoneStatus)
+
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(metrics, HasLen, 0)
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsNotForOtherChanges(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chg := s.state.NewChange("install-snap", "install a snap")
+	ts, err := snapstate.Install(context.Background(), s.state, "some-snap", nil, 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.se.Stop()
+	s.settle(c)
+	s.state.Lock()
+
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	c.Assert(err, IsNil)
+	c.Check(metrics, HasLen, 0)
+}
+
+func (s *snapmgrTestSuite) mockReportRefreshMetrics(c *C, f func(metrics []*snapstate.RefreshMetric) error) {
+	old := snapstate.ReportRefreshMetrics
+	snapstate.ReportRefreshMetrics = func(st *state.State, metrics []*snapstate.RefreshMetric) error {
+		return f(metrics)
+	}
+	s.AddCleanup(func() { snapstate.ReportRefreshMetrics = old })
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsReport(c *C) {
+	var reported []*snapstate.RefreshMetric
+	s.mockReportRefreshMetrics(c, func(metrics []*snapstate.RefreshMetric) error {
+		reported = append(reported, metrics...)
+		return nil
+	})
+
+	now := time.Now()
+	s.state.Lock()
+	s.state.Set("refresh-metrics", []*snapstate.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true, Time: now, Reported: true},
+		{Snap: "bar", FromRevision: snap.R(3), ToRevision: snap.R(4), ErrorClass: "download", Time: now},
+	})
+	s.state.Unlock()
+
+	// not opted in
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	c.Check(reported, HasLen, 0)
+
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.report-metrics", true)
+	tr.Commit()
+	s.state.Unlock()
+
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	c.Assert(reported, HasLen, 1)
+	c.Check(reported[0].Snap, Equals, "bar")
+
+	s.state.Lock()
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	s.state.Unlock()
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 2)
+	c.Check(metrics[0].Reported, Equals, true)
+	c.Check(metrics[1].Reported, Equals, true)
+
+	// nothing left to report
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	c.Check(reported, HasLen, 1)
+}
+
+func (s *snapmgrTestSuite) TestRefreshMetricsReportRetry(c *C) {
+	restore := snapstate.MockReportRefreshMetricsRetryInterval(time.Hour)
+	defer restore()
+
+	reportErr := errors.New("boom")
+	calls := 0
+	s.mockReportRefreshMetrics(c, func(metrics []*snapstate.RefreshMetric) error {
+		calls++
+		return reportErr
+	})
+
+	s.state.Lock()
+	s.state.Set("refresh-metrics", []*snapstate.RefreshMetric{
+		{Snap: "foo", FromRevision: snap.R(1), ToRevision: snap.R(2), Success: true, Time: time.Now()},
+	})
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.report-metrics", "true")
+	tr.Commit()
+	s.state.Unlock()
+
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	c.Check(calls, Equals, 1)
+
+	// not retried right away
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	c.Check(calls, Equals, 1)
+
+	s.state.Lock()
+	metrics, err := snapstate.RefreshMetrics(s.state)
+	s.state.Unlock()
+	c.Assert(err, IsNil)
+	c.Assert(metrics, HasLen, 1)
+	c.Check(metrics[0].Reported, Equals, false)
+}
@@ -0,0 +1,218 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+// Resources that tasks can lock, besides the snaps themselves, see
+// SnapResource.
+const (
+	// ResourceBootConfig is the boot configuration shipped by the
+	// gadget.
+	ResourceBootConfig = "boot-config"
+	// ResourceBootloaderEnv is the bootloader environment holding
+	// the boot variables.
+	ResourceBootloaderEnv = "bootloader-env"
+	// ResourceSecurityProfiles are the directories of the security
+	// profiles of the snaps, like the seccomp one.
+	ResourceSecurityProfiles = "security-profiles"
+)
+
+const snapResourcePrefix = "snap:"
+
+// SnapResource returns the resource representing the installed snap
+// with the given instance name.
+func SnapResource(instanceName string) string {
+	return snapResourcePrefix + instanceName
+}
+
+// A ResourceLock is a lock on a resource a task needs. Tasks of
+// different changes holding a lock on the same resource conflict
+// unless both locks are shared.
+type ResourceLock struct {
+	Resource  string
+	Exclusive bool
+}
+
+func (l ResourceLock) conflicts(other ResourceLock) bool {
+	return l.Resource == other.Resource && (l.Exclusive || other.Exclusive)
+}
+
+// A TaskResourcesFunc returns the resource locks needed by the given
+// supported task.
+type TaskResourcesFunc func(*state.Task) ([]ResourceLock, error)
+
+var taskResourcesByKind = make(map[string]TaskResourcesFunc)
+
+// AddTaskResources registers a TaskResourcesFunc for returning the
+// resource locks needed by tasks of the given kind. Tasks of different
+// changes with conflicting locks are not run at the same time. This
+// only orders the tasks of changes that were let through together,
+// creating changes is still refused on conflicts as checked by
+// CheckChangeConflict.
+func AddTaskResources(kind string, f TaskResourcesFunc) {
+	taskResourcesByKind[kind] = f
+}
+
+func taskResources(t *state.Task) ([]ResourceLock, error) {
+	if f := taskResourcesByKind[t.Kind()]; f != nil {
+		return f(t)
+	}
+	return nil, nil
+}
+
+func init() {
+	// tasks changing which revision of a snap is installed or
+	// active, or its data, need the snap for themselves
+	for _, kind := range []string{"unlink-current-snap", "unlink-snap", "copy-snap-data", "clear-snap", "discard-snap", "switch-snap"} {
+		AddTaskResources(kind, snapSetupResources)
+	}
+	AddTaskResources("link-snap", linkSnapResources)
+}
+
+func snapSetupResources(t *state.Task) ([]ResourceLock, error) {
+	snapsup, err := TaskSnapSetup(t)
+	if err != nil {
+		return nil, fmt.Errorf("internal error: cannot obtain snap setup from task: %s", t.Summary())
+	}
+	return []ResourceLock{{Resource: SnapResource(snapsup.InstanceName()), Exclusive: true}}, nil
+}
+
+func linkSnapResources(t *state.Task) ([]ResourceLock, error) {
+	snapsup, err := TaskSnapSetup(t)
+	if err != nil {
+		return nil, fmt.Errorf("internal error: cannot obtain snap setup from task: %s", t.Summary())
+	}
+	locks := []ResourceLock{{Resource: SnapResource(snapsup.InstanceName()), Exclusive: true}}
+	switch snapsup.Type {
+	case snap.TypeOS, snap.TypeKernel, snap.TypeBase:
+		if !release.OnClassic {
+			// linking these sets the boot variables
+			locks = append(locks, ResourceLock{Resource: ResourceBootloaderEnv, Exclusive: true})
+		}
+	}
+	return locks, nil
+}
+
+// heldResources returns the resource locks held by the change. A change
+// holds a lock on a snap once one of its tasks needing it ran, runs or
+// is being aborted, for as long as one of them still has to run or to be
+// undone, so that the snap is not used by others between being unlinked
+// and linked again. Tasks on hold do not count either way. Locks on
+// other resources are held only while the tasks needing them run.
+func heldResources(chg *state.Change) ([]ResourceLock, error) {
+	seen := make(map[ResourceLock]bool)
+	started := make(map[ResourceLock]bool)
+	unfinished := make(map[ResourceLock]bool)
+	var locks []ResourceLock
+	for _, t := range chg.Tasks() {
+		tlocks, err := taskResources(t)
+		if err != nil {
+			return nil, err
+		}
+		status := t.Status()
+		running := status == state.DoingStatus || status == state.UndoingStatus
+		for _, l := range tlocks {
+			if !seen[l] {
+				seen[l] = true
+				locks = append(locks, l)
+			}
+			if !strings.HasPrefix(l.Resource, snapResourcePrefix) {
+				started[l] = started[l] || running
+				unfinished[l] = unfinished[l] || running
+				continue
+			}
+			switch status {
+			case state.DoStatus:
+				unfinished[l] = true
+			case state.DoingStatus, state.UndoStatus, state.UndoingStatus, state.AbortStatus:
+				started[l] = true
+				unfinished[l] = true
+			case state.DoneStatus, state.UndoneStatus, state.ErrorStatus:
+				started[l] = true
+			}
+		}
+	}
+	held := locks[:0]
+	for _, l := range locks {
+		if started[l] && unfinished[l] {
+			held = append(held, l)
+		}
+	}
+	return held, nil
+}
+
+func conflictingLock(locks, others []ResourceLock) (ResourceLock, bool) {
+	for _, l := range locks {
+		for _, o := range others {
+			if l.conflicts(o) {
+				return l, true
+			}
+		}
+	}
+	return ResourceLock{}, false
+}
+
+// resourcesBlocked blocks tasks needing resources that other changes
+// hold, or that running tasks of other changes need, in a conflicting
+// way.
+func resourcesBlocked(cand *state.Task, running []*state.Task) bool {
+	locks, err := taskResources(cand)
+	if err != nil || len(locks) == 0 {
+		return false
+	}
+	candChg := cand.Change()
+
+	for _, t := range running {
+		if t.Change() == candChg {
+			continue
+		}
+		tlocks, err := taskResources(t)
+		if err != nil {
+			// be conservative
+			return true
+		}
+		if _, ok := conflictingLock(locks, tlocks); ok {
+			return true
+		}
+	}
+
+	for _, chg := range cand.State().Changes() {
+		if chg == candChg || chg.Status().Ready() {
+			continue
+		}
+		held, err := heldResources(chg)
+		if err != nil {
+			return true
+		}
+		if _, ok := conflictingLock(locks, held); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type resourcesSuite struct {
+	state *state.State
+}
+
+var _ = Suite(&resourcesSuite{})
+
+func (s *resourcesSuite) SetUpTest(c *C) {
+	s.state = state.New(nil)
+
+	snapstate.AddTaskResources("use-snap", func(t *state.Task) ([]snapstate.ResourceLock, error) {
+		var name string
+		if err := t.Get("snap-name", &name); err != nil {
+			return nil, err
+		}
+		return []snapstate.ResourceLock{{Resource: snapstate.SnapResource(name)}}, nil
+	})
+	snapstate.AddTaskResources("write-boot-config", func(t *state.Task) ([]snapstate.ResourceLock, error) {
+		return []snapstate.ResourceLock{{Resource: snapstate.ResourceBootConfig, Exclusive: true}}, nil
+	})
+}
+
+func (s *resourcesSuite) newUseSnapTask(name string) *state.Task {
+	t := s.state.NewTask("use-snap", "...")
+	t.Set("snap-name", name)
+	return t
+}
+
+// addRefresh adds a change replacing the snap, as a refresh would.
+func (s *resourcesSuite) addRefresh(name string) (*state.Change, []*state.Task) {
+	snapsup := &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: name}}
+	chg := s.state.NewChange("refresh", "...")
+	var tasks []*state.Task
+	for _, kind := range []string{"download-snap", "unlink-current-snap", "copy-snap-data", "link-snap"} {
+		t := s.state.NewTask(kind, "...")
+		t.Set("snap-setup", snapsup)
+		chg.AddTask(t)
+		tasks = append(tasks, t)
+	}
+	return chg, tasks
+}
+
+func (s *resourcesSuite) TestResourcesBlocked(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, tasks := s.addRefresh("foo")
+	use := s.newUseSnapTask("foo")
+	s.state.NewChange("configure", "...").AddTask(use)
+
+	// the refresh is still downloading
+	tasks[0].SetStatus(state.DoingStatus)
+	c.Check(snapstate.ResourcesBlocked(use, []*state.Task{tasks[0]}), Equals, false)
+
+	// the snap is unlinked
+	tasks[0].SetStatus(state.DoneStatus)
+	tasks[1].SetStatus(state.DoneStatus)
+	c.Check(snapstate.ResourcesBlocked(use, nil), Equals, true)
+
+	// the refresh is done with the snap
+	tasks[2].SetStatus(state.DoneStatus)
+	tasks[3].SetStatus(state.DoneStatus)
+	c.Check(snapstate.ResourcesBlocked(use, nil), Equals, false)
+}
+
+func (s *resourcesSuite) TestResourcesBlockedByRunning(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, tasks := s.addRefresh("foo")
+	use := s.newUseSnapTask("foo")
+	use.SetStatus(state.DoingStatus)
+	s.state.NewChange("configure", "...").AddTask(use)
+
+	// unlinking waits for the snap to be done being used
+	c.Check(snapstate.ResourcesBlocked(tasks[1], []*state.Task{use}), Equals, true)
+	// others do not
+	c.Check(snapstate.ResourcesBlocked(tasks[0], []*state.Task{use}), Equals, false)
+}
+
+func (s *resourcesSuite) TestResourcesBlockedSameChange(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chg, tasks := s.addRefresh("foo")
+	use := s.newUseSnapTask("foo")
+	chg.AddTask(use)
+
+	tasks[0].SetStatus(state.DoneStatus)
+	tasks[1].SetStatus(state.DoneStatus)
+	c.Check(snapstate.ResourcesBlocked(use, nil), Equals, false)
+}
@@ -53,6 +53,12 @@ type SnapManager struct {
 	autoRefresh    *autoRefresh
 	refreshHints   *refreshHints
 	catalogRefresh *catalogRefresh
+	snapsVerifier  *snapsVerifier
+	refreshMetrics *refreshMetrics
+
+	// refreshObserverID is the id of the observer of refresh
+	// changes, if registered
+	refreshObserverID int
 
 	lastUbuntuCoreTransitionAttempt time.Time
 }
@@ -90,6 +96,10 @@ type SnapSetup struct {
 	// InstanceKey is set by the user during installation and differs for
 	// each instance of given snap
 	InstanceKey string `json:"instance-key,omitempty"`
+
+	// PairedUpdate is set when the snap is a kernel or gadget updated
+	// together with the other one, see PairedUpdate.
+	PairedUpdate *PairedUpdate `json:"paired-update,omitempty"`
 }
 
 func (snapsup *SnapSetup) InstanceName() string {
@@ -147,6 +157,12 @@ type SnapState struct {
 	// attempted but inhibited because the snap was busy. This value is
 	// reset on each successful refresh.
 	RefreshInhibitedTime *time.Time `json:"refresh-inhibited-time,omitempty"`
+
+	// RefreshHeldTime is the time when the pre-refresh-download hook of
+	// the snap first held an auto-refresh of it. This value is reset
+	// once the hook lets a refresh go ahead, or can no longer hold it,
+	// and on each successful refresh.
+	RefreshHeldTime *time.Time `json:"refresh-held-time,omitempty"`
 }
 
 // Type returns the type of the snap or an error.
@@ -252,6 +268,11 @@ var snapReadInfo = snap.ReadInfo
 var AutomaticSnapshot func(st *state.State, instanceName string) (ts *state.TaskSet, err error)
 var AutomaticSnapshotExpiration func(st *state.State) (time.Duration, error)
 
+// EnsureSnapServicesQuota allows to hook the service manager's putting of
+// the services of a snap in the slice of its quota group, it is called
+// before the snap is linked.
+var EnsureSnapServicesQuota func(st *state.State, info *snap.Info) error
+
 func readInfo(name string, si *snap.SideInfo, flags int) (*snap.Info, error) {
 	info, err := snapReadInfo(name, si)
 	if err != nil && flags&errorOnBroken != 0 {
@@ -354,6 +375,8 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 		autoRefresh:    newAutoRefresh(st),
 		refreshHints:   newRefreshHints(st),
 		catalogRefresh: newCatalogRefresh(st),
+		snapsVerifier:  newSnapsVerifier(st),
+		refreshMetrics: newRefreshMetrics(st),
 	}
 
 	if err := os.MkdirAll(dirs.SnapCookieDir, 0700); err != nil {
@@ -376,6 +399,13 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 	runner.AddHandler("prerequisites", m.doPrerequisites, nil)
 	runner.AddHandler("prepare-snap", m.doPrepareSnap, m.undoPrepareSnap)
 	runner.AddHandler("download-snap", m.doDownloadSnap, m.undoPrepareSnap)
+	// downloads of auto-refreshes failing because of the store or
+	// the network are resumed with a backoff for a couple of hours
+	runner.SetRetryPolicy("download-snap", state.RetryPolicy{
+		MaxRetries: 10,
+		Backoff:    time.Minute,
+		MaxBackoff: 30 * time.Minute,
+	})
 	runner.AddHandler("mount-snap", m.doMountSnap, m.undoMountSnap)
 	runner.AddHandler("unlink-current-snap", m.doUnlinkCurrentSnap, m.undoUnlinkCurrentSnap)
 	runner.AddHandler("copy-snap-data", m.doCopySnapData, m.undoCopySnapData)
@@ -395,6 +425,15 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 	runner.AddHandler("unlink-snap", m.doUnlinkSnap, nil)
 	runner.AddHandler("clear-snap", m.doClearSnapData, nil)
 	runner.AddHandler("discard-snap", m.doDiscardSnap, nil)
+	// failing to remove the files of a snap is retried with a
+	// backoff for about a day before giving up, leaving them behind
+	// rather than undoing the change
+	runner.SetRetryPolicy("discard-snap", state.RetryPolicy{
+		MaxRetries: 30,
+		Backoff:    3 * time.Minute,
+		MaxBackoff: time.Hour,
+		Optional:   true,
+	})
 
 	// alias related
 	// FIXME: drop the task entirely after a while
@@ -404,16 +443,19 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 	runner.AddHandler("refresh-aliases", m.doRefreshAliases, m.undoRefreshAliases)
 	runner.AddHandler("prune-auto-aliases", m.doPruneAutoAliases, m.undoRefreshAliases)
 	runner.AddHandler("remove-aliases", m.doRemoveAliases, m.doSetupAliases)
-	runner.AddHandler("alias", m.doAlias, m.undoRefreshAliases)
-	runner.AddHandler("unalias", m.doUnalias, m.undoRefreshAliases)
-	runner.AddHandler("disable-aliases", m.doDisableAliases, m.undoRefreshAliases)
-	runner.AddHandler("prefer-aliases", m.doPreferAliases, m.undoRefreshAliases)
+	runner.AddHandler("alias", bestEffortAlias(m.doAlias), m.undoRefreshAliases)
+	runner.AddHandler("unalias", bestEffortAlias(m.doUnalias), m.undoRefreshAliases)
+	runner.AddHandler("disable-aliases", bestEffortAlias(m.doDisableAliases), m.undoRefreshAliases)
+	runner.AddHandler("prefer-aliases", bestEffortAlias(m.doPreferAliases), m.undoRefreshAliases)
 
 	// misc
 	runner.AddHandler("switch-snap", m.doSwitchSnap, nil)
+	runner.AddHandler("verify-installed-snaps", m.doVerifyInstalledSnaps, nil)
+	runner.AddHandler("report-boot-failures", m.doReportBootFailures, nil)
 
 	// control serialisation
 	runner.AddBlocked(m.blockedTask)
+	runner.AddBlocked(resourcesBlocked)
 
 	return m, nil
 }
@@ -469,6 +511,27 @@ func (m *SnapManager) blockedTask(cand *state.Task, running []*state.Task) bool
 		}
 	}
 
+	// Snaps are downloaded in parallel, up to a limit, but linked
+	// one at a time.
+	switch cand.Kind() {
+	case "download-snap":
+		downloads := 0
+		for _, t := range running {
+			if t.Kind() == "download-snap" {
+				downloads++
+			}
+		}
+		if downloads > 0 && downloads >= maxParallelDownloads(cand.State()) {
+			return true
+		}
+	case "link-snap":
+		for _, t := range running {
+			if t.Kind() == "link-snap" {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
@@ -678,6 +741,79 @@ func (m *SnapManager) localInstallCleanup() error {
 	return osutil.UnlinkManyAt(d, filenames)
 }
 
+// refreshChangeKinds are the kinds of the changes the snap manager
+// observes to notify finished auto-refreshes and record refresh metrics.
+var refreshChangeKinds = map[string]bool{
+	"auto-refresh": true,
+	"refresh-snap": true,
+}
+
+// observeRefreshChange handles a refresh change becoming ready, once.
+func (m *SnapManager) observeRefreshChange(n *state.Notification) {
+	upd, ok := n.Data.(*state.ChangeUpdate)
+	if !ok || !upd.Ready || !refreshChangeKinds[upd.Kind] {
+		return
+	}
+	chg := m.state.Change(upd.ID)
+	if chg == nil {
+		return
+	}
+	var observed bool
+	if err := chg.Get("refresh-observed", &observed); err == nil && observed {
+		return
+	}
+	chg.Set("refresh-observed", true)
+	m.autoRefresh.notifyRefreshFinished(n)
+	m.refreshMetrics.recordChange(n)
+}
+
+// ensureRefreshObserver observes the state only while refresh changes
+// are in progress, as observing it makes every change pay the cost of
+// notifications. Refresh changes that became ready unobserved, having
+// started and finished between two ensure passes, are handled here.
+func (m *SnapManager) ensureRefreshObserver() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	inProgress := false
+	for _, chg := range m.state.Changes() {
+		if !refreshChangeKinds[chg.Kind()] {
+			continue
+		}
+		if !chg.IsReady() {
+			inProgress = true
+			continue
+		}
+		if m.refreshObserverID != 0 {
+			// the observer saw it
+			continue
+		}
+		var observed bool
+		if err := chg.Get("refresh-observed", &observed); err == nil && observed {
+			continue
+		}
+		m.observeRefreshChange(&state.Notification{
+			Type: "change-update",
+			Time: time.Now(),
+			Data: &state.ChangeUpdate{
+				ID:     chg.ID(),
+				Kind:   chg.Kind(),
+				Status: chg.Status().String(),
+				Ready:  true,
+			},
+		})
+	}
+
+	switch {
+	case inProgress && m.refreshObserverID == 0:
+		m.refreshObserverID = m.state.AddObserver(m.observeRefreshChange)
+	case !inProgress && m.refreshObserverID != 0:
+		m.state.RemoveObserver(m.refreshObserverID)
+		m.refreshObserverID = 0
+	}
+	return nil
+}
+
 // Ensure implements StateManager.Ensure.
 func (m *SnapManager) Ensure() error {
 	// do not exit right away on error
@@ -691,7 +827,11 @@ func (m *SnapManager) Ensure() error {
 		m.autoRefresh.Ensure(),
 		m.refreshHints.Ensure(),
 		m.catalogRefresh.Ensure(),
+		m.snapsVerifier.Ensure(),
+		m.refreshMetrics.Ensure(),
 		m.localInstallCleanup(),
+		// after autoRefresh.Ensure which can start refreshes
+		m.ensureRefreshObserver(),
 	}
 
 	//FIXME: use firstErr helper
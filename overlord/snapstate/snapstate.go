@@ -32,6 +32,7 @@ import (
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/gadget"
@@ -59,6 +60,7 @@ const (
 	IgnoreHookError = 1 << iota
 	TrackHookError
 	UseConfigDefaults
+	UseGadgetVolumeDefaults
 )
 
 const (
@@ -160,7 +162,20 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 	prepare.Set("snap-setup", snapsup)
 	prepare.WaitFor(prereq)
 
-	tasks := []*state.Task{prereq, prepare}
+	tasks := []*state.Task{prereq}
+	// auto-refreshes can be held by the snap before anything gets
+	// downloaded
+	if fromStore && snapsup.IsAutoRefresh && snapst.IsInstalled() {
+		canHold, err := refreshCanBeHeld(st, snapst)
+		if err != nil {
+			return nil, err
+		}
+		preDownloadHook := SetupPreRefreshDownloadHook(st, snapsup.InstanceName(), canHold)
+		preDownloadHook.WaitFor(prereq)
+		prepare.WaitFor(preDownloadHook)
+		tasks = append(tasks, preDownloadHook)
+	}
+	tasks = append(tasks, prepare)
 	addTask := func(t *state.Task) {
 		t.Set("snap-setup-task", prepare.ID())
 		t.WaitFor(prev)
@@ -346,6 +361,15 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 		ts.AddAll(configSet)
 	}
 
+	if snapsup.Type == snap.TypeGadget {
+		// the system configuration defaults declared by the
+		// gadget volumes are applied on every install and refresh
+		// of the gadget
+		sysConfigSet := ConfigureSnap(st, defaultCoreSnapName, UseGadgetVolumeDefaults)
+		sysConfigSet.WaitAll(ts)
+		ts.AddAll(sysConfigSet)
+	}
+
 	healthCheck := CheckHealthHook(st, snapsup.InstanceName(), snapsup.Revision())
 	healthCheck.WaitAll(ts)
 	ts.AddTask(healthCheck)
@@ -377,6 +401,10 @@ var SetupPreRefreshHook = func(st *state.State, snapName string) *state.Task {
 	panic("internal error: snapstate.SetupPreRefreshHook is unset")
 }
 
+var SetupPreRefreshDownloadHook = func(st *state.State, snapName string, canHold bool) *state.Task {
+	panic("internal error: snapstate.SetupPreRefreshDownloadHook is unset")
+}
+
 var SetupPostRefreshHook = func(st *state.State, snapName string) *state.Task {
 	panic("internal error: snapstate.SetupPostRefreshHook is unset")
 }
@@ -737,6 +765,7 @@ func InstallWithDeviceContext(ctx context.Context, st *state.State, name string,
 		InstanceKey:  info.InstanceKey,
 		auxStoreInfo: auxStoreInfo{
 			Media: info.Media,
+			Links: info.EditedLinks,
 		},
 		CohortKey: opts.CohortKey,
 	}
@@ -744,9 +773,21 @@ func InstallWithDeviceContext(ctx context.Context, st *state.State, name string,
 	return doInstall(st, &snapst, snapsup, 0, fromChange)
 }
 
+// transactionLanes returns a function allocating the lane of each snap of a
+// multi-snap operation: a new lane per snap, or a single lane shared by all
+// the snaps if the operation is all-or-nothing, so that a failure of any
+// of them aborts and undoes all of them.
+func transactionLanes(st *state.State, flags *Flags) func() int {
+	if flags != nil && flags.Transaction == client.TransactionAllOrNothing {
+		lane := st.NewLane()
+		return func() int { return lane }
+	}
+	return st.NewLane
+}
+
 // InstallMany installs everything from the given list of names.
 // Note that the state must be locked by the caller.
-func InstallMany(st *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+func InstallMany(st *state.State, names []string, userID int, flags *Flags) ([]string, []*state.TaskSet, error) {
 	// need to have a model set before trying to talk the store
 	deviceCtx, err := DevicePastSeeding(st, nil)
 	if err != nil {
@@ -781,6 +822,7 @@ func InstallMany(st *state.State, names []string, userID int) ([]string, []*stat
 		return nil, nil, err
 	}
 
+	newLane := transactionLanes(st, flags)
 	tasksets := make([]*state.TaskSet, 0, len(installs))
 	for _, info := range installs {
 		var snapst SnapState
@@ -807,7 +849,7 @@ func InstallMany(st *state.State, names []string, userID int) ([]string, []*stat
 		if err != nil {
 			return nil, nil, err
 		}
-		ts.JoinLane(st.NewLane())
+		ts.JoinLane(newLane())
 		tasksets = append(tasksets, ts)
 	}
 
@@ -946,16 +988,30 @@ func doUpdate(ctx context.Context, st *state.State, names []string, updates []*s
 		reportUpdated[snapName] = true
 	}
 
+	newLane := transactionLanes(st, globalFlags)
+
 	// first snapd, core, bases, then rest
 	sort.Stable(snap.ByType(updates))
 	prereqs := make(map[string]*state.TaskSet)
 	waitPrereq := func(ts *state.TaskSet, prereqName string) {
 		preTs := prereqs[prereqName]
-		if preTs != nil {
-			ts.WaitAll(preTs)
+		if preTs == nil {
+			return
+		}
+		// the snap can be downloaded in parallel with the refresh
+		// of the prereqs, only what follows needs to wait
+		for _, t := range ts.Tasks() {
+			switch t.Kind() {
+			case "prerequisites", "download-snap":
+				continue
+			}
+			t.WaitAll(preTs)
 		}
 	}
 
+	// a kernel and a gadget updated together are paired
+	var kernelTs, gadgetTs *state.TaskSet
+
 	// updates is sorted by kind so this will process first core
 	// and bases and then other snaps
 	for _, update := range updates {
@@ -997,6 +1053,7 @@ func doUpdate(ctx context.Context, st *state.State, names []string, updates []*s
 			InstanceKey:  update.InstanceKey,
 			auxStoreInfo: auxStoreInfo{
 				Media: update.Media,
+				Links: update.EditedLinks,
 			},
 		}
 
@@ -1009,7 +1066,7 @@ func doUpdate(ctx context.Context, st *state.State, names []string, updates []*s
 			}
 			return nil, nil, err
 		}
-		ts.JoinLane(st.NewLane())
+		ts.JoinLane(newLane())
 
 		// because of the sorting of updates we fill prereqs
 		// first (if branch) and only then use it to setup
@@ -1030,10 +1087,23 @@ func doUpdate(ctx context.Context, st *state.State, names []string, updates []*s
 			}
 		}
 
+		switch update.GetType() {
+		case snap.TypeKernel:
+			kernelTs = ts
+		case snap.TypeGadget:
+			gadgetTs = ts
+		}
+
 		scheduleUpdate(update.InstanceName(), ts)
 		tasksets = append(tasksets, ts)
 	}
 
+	if kernelTs != nil && gadgetTs != nil && !release.OnClassic {
+		if err := pairKernelAndGadgetUpdates(kernelTs, gadgetTs); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	if len(newAutoAliases) != 0 {
 		addAutoAliasesTs, err := applyAutoAliasesDelta(st, newAutoAliases, "refresh", refreshAll, fromChange, scheduleUpdate)
 		if err != nil {
@@ -1742,9 +1812,11 @@ func canRemove(st *state.State, si *snap.Info, snapst *SnapState, removeAll bool
 
 	// Gadget snaps should not be removed as they are a key
 	// building block for Gadgets. Do not remove their last
-	// revision left.
+	// revision left, unless a Remodel() switched to a different
+	// gadget.
 	if si.GetType() == snap.TypeGadget {
-		return false
+		model := deviceCtx.Model()
+		return model.Gadget() != "" && model.Gadget() != si.InstanceName()
 	}
 
 	// Allow "ubuntu-core" removals here because we might have two
@@ -2388,7 +2460,8 @@ func coreInfo(st *state.State) (*snap.Info, error) {
 }
 
 // ConfigDefaults returns the configuration defaults for the snap as
-// specified in the gadget for the given device context.
+// specified in the gadget for the given device context, including
+// the ones scoped to its brand, model and serial.
 // If gadget is absent or the snap has no snap-id it returns
 // ErrNoState.
 func ConfigDefaults(st *state.State, deviceCtx DeviceContext, snapName string) (map[string]interface{}, error) {
@@ -2417,25 +2490,65 @@ func ConfigDefaults(st *state.State, deviceCtx DeviceContext, snapName string) (
 		return nil, err
 	}
 
+	// gadget defaults can be scoped to the model and serial
+	brandID := deviceCtx.Model().BrandID()
+	model := deviceCtx.Model().Model()
+	serial, err := deviceCtx.Serial()
+	if err != nil {
+		return nil, err
+	}
+
+	var defaults map[string]interface{}
+	found := false
 	// we support setting core defaults via "system"
 	if isCoreDefaults {
-		if defaults, ok := gadgetInfo.Defaults["system"]; ok {
-			if _, ok := gadgetInfo.Defaults[si.SnapID]; ok && si.SnapID != "" {
+		if defaults, found = gadgetInfo.SnapDefaults("system", brandID, model, serial); found {
+			if _, ok := gadgetInfo.SnapDefaults(si.SnapID, brandID, model, serial); ok && si.SnapID != "" {
 				logger.Noticef("core snap configuration defaults found under both 'system' key and core-snap-id, preferring 'system'")
 			}
+		}
+	}
+	if !found {
+		defaults, found = gadgetInfo.SnapDefaults(si.SnapID, brandID, model, serial)
+	}
 
-			return defaults, nil
+	// the system configuration defaults of the gadget volumes apply
+	// too, the defaults given for the core snap take precedence
+	if volumeDefaults := gadgetInfo.VolumeDefaults(); isCoreDefaults && len(volumeDefaults) > 0 {
+		for k, v := range defaults {
+			volumeDefaults[k] = v
 		}
+		defaults, found = volumeDefaults, true
 	}
 
-	defaults, ok := gadgetInfo.Defaults[si.SnapID]
-	if !ok {
+	if !found {
 		return nil, state.ErrNoState
 	}
 
 	return defaults, nil
 }
 
+// GadgetVolumeDefaults returns the system configuration defaults declared
+// by the volumes of the gadget for the given device context.
+// If gadget is absent or declares no such defaults it returns ErrNoState.
+func GadgetVolumeDefaults(st *state.State, deviceCtx DeviceContext) (map[string]interface{}, error) {
+	gadget, err := GadgetInfo(st, deviceCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	gadgetInfo, err := snap.ReadGadgetInfo(gadget, release.OnClassic)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := gadgetInfo.VolumeDefaults()
+	if len(defaults) == 0 {
+		return nil, state.ErrNoState
+	}
+	return defaults, nil
+}
+
 // GadgetConnections returns the interface connection instructions
 // specified in the gadget for the given device context.
 // If gadget is absent it returns ErrNoState.
@@ -39,6 +39,7 @@ import (
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/boot/boottest"
 	"github.com/snapcore/snapd/bootloader"
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget"
 	"github.com/snapcore/snapd/interfaces"
@@ -109,10 +110,12 @@ func (s *snapmgrTestSuite) SetUpTest(c *C) {
 
 	oldSetupInstallHook := snapstate.SetupInstallHook
 	oldSetupPreRefreshHook := snapstate.SetupPreRefreshHook
+	oldSetupPreRefreshDownloadHook := snapstate.SetupPreRefreshDownloadHook
 	oldSetupPostRefreshHook := snapstate.SetupPostRefreshHook
 	oldSetupRemoveHook := snapstate.SetupRemoveHook
 	snapstate.SetupInstallHook = hookstate.SetupInstallHook
 	snapstate.SetupPreRefreshHook = hookstate.SetupPreRefreshHook
+	snapstate.SetupPreRefreshDownloadHook = hookstate.SetupPreRefreshDownloadHook
 	snapstate.SetupPostRefreshHook = hookstate.SetupPostRefreshHook
 	snapstate.SetupRemoveHook = hookstate.SetupRemoveHook
 
@@ -143,6 +146,7 @@ func (s *snapmgrTestSuite) SetUpTest(c *C) {
 	s.BaseTest.AddCleanup(func() {
 		snapstate.SetupInstallHook = oldSetupInstallHook
 		snapstate.SetupPreRefreshHook = oldSetupPreRefreshHook
+		snapstate.SetupPreRefreshDownloadHook = oldSetupPreRefreshDownloadHook
 		snapstate.SetupPostRefreshHook = oldSetupPostRefreshHook
 		snapstate.SetupRemoveHook = oldSetupRemoveHook
 
@@ -424,6 +428,10 @@ func verifyInstallTasks(c *C, opts, discards int, ts *state.TaskSet, st *state.S
 			"run-hook[configure]",
 		)
 	}
+	if opts&updatesGadget != 0 {
+		// system configuration defaults of the gadget volumes
+		expected = append(expected, "run-hook[configure]")
+	}
 	expected = append(expected,
 		"run-hook[check-health]",
 	)
@@ -482,10 +490,12 @@ func verifyUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *state.St
 			"cleanup",
 		)
 	}
-	expected = append(expected,
-		"run-hook[configure]",
-		"run-hook[check-health]",
-	)
+	expected = append(expected, "run-hook[configure]")
+	if opts&updatesGadget != 0 {
+		// system configuration defaults of the gadget volumes
+		expected = append(expected, "run-hook[configure]")
+	}
+	expected = append(expected, "run-hook[check-health]")
 	if opts&doesReRefresh != 0 {
 		expected = append(expected, "check-rerefresh")
 	}
@@ -623,6 +633,20 @@ func (s *snapmgrTestSuite) TestInstallFailsWhenClassicSnapsAreNotSupported(c *C)
 	c.Assert(err, ErrorMatches, "classic confinement requires snaps under /snap or symlink from /snap to "+dirs.SnapMountDir)
 }
 
+func (s *snapmgrTestSuite) TestInstallFailsEarlyOnUnsupportedAssumes(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	opts := &snapstate.RevisionOptions{Channel: "channel-for-newer-snapd"}
+	_, err := snapstate.Install(context.Background(), s.state, "some-snap", opts, s.user.ID, snapstate.Flags{})
+	c.Assert(err, ErrorMatches, `snap "some-snap" assumes unsupported features: kernel-assets \(snapd 2.52 or later is required, try to refresh the core snap\)`)
+	// nothing was scheduled for download
+	c.Check(s.state.TaskCount(), Equals, 0)
+}
+
 func (s *snapmgrTestSuite) TestInstallTasks(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1078,6 +1102,81 @@ func (s *snapmgrTestSuite) TestUpdateMany(c *C) {
 	checkIsAutoRefresh(c, ts.Tasks(), false)
 }
 
+func (s *snapmgrTestSuite) testUpdateManyAutoRefreshPreDownloadHook(c *C, heldSince time.Duration, canHold bool) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapst := &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "app",
+	}
+	if heldSince != 0 {
+		heldTime := time.Now().Add(-heldSince)
+		snapst.RefreshHeldTime = &heldTime
+	}
+	snapstate.Set(s.state, "some-snap", snapst)
+
+	_, tts, err := snapstate.UpdateMany(context.Background(), s.state, nil, 0, &snapstate.Flags{IsAutoRefresh: true})
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 2)
+
+	tasks := tts[0].Tasks()
+	c.Check(taskKinds(tasks[:3]), DeepEquals, []string{
+		"prerequisites",
+		"run-hook[pre-refresh-download]",
+		"download-snap",
+	})
+	hook := tasks[1]
+	c.Check(hook.WaitTasks(), DeepEquals, []*state.Task{tasks[0]})
+	c.Check(tasks[2].WaitTasks(), testutil.DeepContains, hook)
+
+	var hooksup hookstate.HookSetup
+	c.Assert(hook.Get("hook-setup", &hooksup), IsNil)
+	c.Check(hooksup.Snap, Equals, "some-snap")
+	c.Check(hooksup.Optional, Equals, true)
+	// a failing hook never holds the refresh
+	c.Check(hooksup.IgnoreError, Equals, true)
+	var hookContext map[string]interface{}
+	c.Assert(hook.Get("hook-context", &hookContext), IsNil)
+	c.Check(hookContext["can-hold"], Equals, canHold)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyAutoRefreshPreDownloadHook(c *C) {
+	s.testUpdateManyAutoRefreshPreDownloadHook(c, 0, true)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyAutoRefreshPreDownloadHookStillHolding(c *C) {
+	s.testUpdateManyAutoRefreshPreDownloadHook(c, 47*time.Hour, true)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyAutoRefreshPreDownloadHookHeldTooLong(c *C) {
+	s.testUpdateManyAutoRefreshPreDownloadHook(c, 49*time.Hour, false)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyAutoRefreshPreDownloadHookMaxHold(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.max-hook-hold", "1h")
+	tr.Commit()
+	s.state.Unlock()
+
+	s.testUpdateManyAutoRefreshPreDownloadHook(c, 2*time.Hour, false)
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyAutoRefreshPreDownloadHookCannotHold(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.max-hook-hold", "0s")
+	tr.Commit()
+	s.state.Unlock()
+
+	s.testUpdateManyAutoRefreshPreDownloadHook(c, 0, false)
+}
+
 func (s *snapmgrTestSuite) TestParallelInstanceUpdateMany(c *C) {
 	restore := release.MockOnClassic(false)
 	defer restore()
@@ -1292,7 +1391,14 @@ func (s *snapmgrTestSuite) TestUpdateManyWaitForBasesUC16(c *C) {
 	for i, task := range tts[2].Tasks() {
 		waitTasks := task.WaitTasks()
 		if i == 0 {
-			c.Check(len(waitTasks), Equals, prereqTotal)
+			// the snap is downloaded without waiting for
+			// the prereqs
+			c.Check(task.Kind(), Equals, "prerequisites")
+			c.Check(waitTasks, HasLen, 0)
+		} else if task.Kind() == "download-snap" {
+			c.Check(waitTasks, DeepEquals, []*state.Task{tts[2].Tasks()[0]})
+		} else if i == 2 {
+			c.Check(len(waitTasks), Equals, prereqTotal+1)
 		} else if task.Kind() == "link-snap" {
 			c.Check(len(waitTasks), Equals, prereqTotal+1)
 			for _, pre := range waitTasks {
@@ -1377,7 +1483,14 @@ func (s *snapmgrTestSuite) TestUpdateManyWaitForBasesUC18(c *C) {
 	for i, task := range tts[3].Tasks() {
 		waitTasks := task.WaitTasks()
 		if i == 0 {
-			c.Check(len(waitTasks), Equals, prereqTotal)
+			// the snap is downloaded without waiting for
+			// the prereqs
+			c.Check(task.Kind(), Equals, "prerequisites")
+			c.Check(waitTasks, HasLen, 0)
+		} else if task.Kind() == "download-snap" {
+			c.Check(waitTasks, DeepEquals, []*state.Task{tts[3].Tasks()[0]})
+		} else if i == 2 {
+			c.Check(len(waitTasks), Equals, prereqTotal+1)
 		} else if task.Kind() == "link-snap" {
 			c.Check(len(waitTasks), Equals, prereqTotal+1)
 			for _, pre := range waitTasks {
@@ -1398,6 +1511,163 @@ func (s *snapmgrTestSuite) TestUpdateManyWaitForBasesUC18(c *C) {
 	})
 }
 
+func (s *snapmgrTestSuite) setupKernelAndGadgetForPairing(c *C, gadgetYaml string) {
+	snapstate.Set(s.state, "kernel", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "kernel", SnapID: "kernel-id", Revision: snap.R(7)},
+		},
+		Current:  snap.R(7),
+		SnapType: "kernel",
+	})
+
+	snapstate.Set(s.state, "brand-gadget", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "brand-gadget", SnapID: "brand-gadget-id", Revision: snap.R(7)},
+		},
+		Current:  snap.R(7),
+		SnapType: "gadget",
+	})
+	// only the new gadget declares the pairing
+	info := snaptest.MockSnap(c, "name: brand-gadget\ntype: gadget\nversion: 1", &snap.SideInfo{RealName: "brand-gadget", Revision: snap.R(11)})
+	gadgetYamlPath := filepath.Join(info.MountDir(), "meta/gadget.yaml")
+	c.Assert(ioutil.WriteFile(gadgetYamlPath, []byte(gadgetYaml), 0644), IsNil)
+}
+
+var pairingGadgetYaml = `
+volumes:
+  pc:
+    bootloader: grub
+`
+
+// updateKernelAndGadgetForPairing returns the tasks of the kernel and
+// gadget updates, by kind, with their snap setups.
+func (s *snapmgrTestSuite) updateKernelAndGadgetForPairing(c *C) (*state.Change, map[string]map[string]*state.Task) {
+	_, tts, err := snapstate.UpdateMany(context.Background(), s.state, []string{"kernel", "brand-gadget"}, 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 3)
+	verifyLastTasksetIsReRefresh(c, tts)
+
+	// to make TaskSnapSetup work
+	chg := s.state.NewChange("refresh", "...")
+	for _, ts := range tts {
+		chg.AddAll(ts)
+	}
+
+	tasks := map[string]map[string]*state.Task{}
+	for _, ts := range tts[:2] {
+		for _, t := range ts.Tasks() {
+			snapsup, err := snapstate.TaskSnapSetup(t)
+			if err != nil {
+				// e.g. hooks
+				continue
+			}
+			if tasks[snapsup.InstanceName()] == nil {
+				tasks[snapsup.InstanceName()] = map[string]*state.Task{}
+			}
+			tasks[snapsup.InstanceName()][t.Kind()] = t
+		}
+	}
+	return chg, tasks
+}
+
+func sharesLane(t1, t2 *state.Task) bool {
+	for _, l1 := range t1.Lanes() {
+		for _, l2 := range t2.Lanes() {
+			if l1 == l2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyPairsKernelAndGadgetTentatively(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupKernelAndGadgetForPairing(c, pairingGadgetYaml+"paired-kernel: kernel\n")
+
+	_, tasks := s.updateKernelAndGadgetForPairing(c)
+
+	for _, name := range []string{"kernel", "brand-gadget"} {
+		snapsup, err := snapstate.TaskSnapSetup(tasks[name]["prerequisites"])
+		c.Assert(err, IsNil)
+		c.Check(snapsup.PairedUpdate, DeepEquals, &snapstate.PairedUpdate{
+			Kernel:         "kernel",
+			KernelRevision: snap.R(11),
+			Gadget:         "brand-gadget",
+			GadgetRevision: snap.R(11),
+			Tentative:      true,
+		})
+	}
+
+	// the kernel is linked only after the gadget assets are in place
+	c.Check(tasks["kernel"]["link-snap"].WaitTasks(), testutil.Contains, tasks["brand-gadget"]["link-snap"])
+	// but the updates only fail together once the new gadget
+	// confirmed the pairing
+	c.Check(sharesLane(tasks["kernel"]["prerequisites"], tasks["brand-gadget"]["prerequisites"]), Equals, false)
+}
+
+func (s *snapmgrTestSuite) TestConfirmPairedUpdate(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupKernelAndGadgetForPairing(c, pairingGadgetYaml+"paired-kernel: kernel\n")
+
+	_, tasks := s.updateKernelAndGadgetForPairing(c)
+	mount := tasks["brand-gadget"]["mount-snap"]
+	gadgetSetup, err := snapstate.TaskSnapSetup(mount)
+	c.Assert(err, IsNil)
+	snapstate.ConfirmPairedUpdate(mount, gadgetSetup)
+
+	for _, name := range []string{"kernel", "brand-gadget"} {
+		snapsup, err := snapstate.TaskSnapSetup(tasks[name]["prerequisites"])
+		c.Assert(err, IsNil)
+		c.Check(snapsup.PairedUpdate, DeepEquals, &snapstate.PairedUpdate{
+			Kernel:         "kernel",
+			KernelRevision: snap.R(11),
+			Gadget:         "brand-gadget",
+			GadgetRevision: snap.R(11),
+		})
+	}
+	// both updates share a lane so they fail and undo together
+	c.Check(sharesLane(tasks["kernel"]["prerequisites"], tasks["brand-gadget"]["prerequisites"]), Equals, true)
+	c.Check(sharesLane(tasks["kernel"]["link-snap"], tasks["brand-gadget"]["link-snap"]), Equals, true)
+}
+
+func (s *snapmgrTestSuite) TestConfirmPairedUpdateUndeclared(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupKernelAndGadgetForPairing(c, pairingGadgetYaml+"paired-kernel: other-kernel\n")
+
+	_, tasks := s.updateKernelAndGadgetForPairing(c)
+	mount := tasks["brand-gadget"]["mount-snap"]
+	gadgetSetup, err := snapstate.TaskSnapSetup(mount)
+	c.Assert(err, IsNil)
+	snapstate.ConfirmPairedUpdate(mount, gadgetSetup)
+
+	// the updates go on independently
+	for _, name := range []string{"kernel", "brand-gadget"} {
+		snapsup, err := snapstate.TaskSnapSetup(tasks[name]["prerequisites"])
+		c.Assert(err, IsNil)
+		c.Check(snapsup.PairedUpdate, IsNil)
+	}
+	c.Check(sharesLane(tasks["kernel"]["prerequisites"], tasks["brand-gadget"]["prerequisites"]), Equals, false)
+	c.Check(strings.Join(mount.Log(), "\n"), Matches, `.*Gadget "brand-gadget" does not pair its updates with kernel "kernel"`)
+}
+
 func (s *snapmgrTestSuite) TestUpdateManyValidateRefreshes(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -6226,6 +6496,44 @@ func (s *snapmgrTestSuite) TestAllUpdateBlockedRevision(c *C) {
 	})
 }
 
+func (s *snapmgrTestSuite) TestAllUpdateBlockedBootFailedRevision(c *C) {
+	//  update-all *should* block revisions that failed to boot
+	si7 := snap.SideInfo{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Revision: snap.R(7),
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si7},
+		Current:  si7.Revision,
+	})
+	err := snapstate.RecordBootFailure(s.state, "some-snap", snap.R(11))
+	c.Assert(err, IsNil)
+
+	updates, _, err := snapstate.UpdateMany(context.Background(), s.state, nil, s.user.ID, nil)
+	c.Check(err, IsNil)
+	c.Check(updates, HasLen, 0)
+
+	c.Assert(s.fakeBackend.ops, HasLen, 2)
+	c.Check(s.fakeBackend.ops[0], DeepEquals, fakeOp{
+		op: "storesvc-snap-action",
+		curSnaps: []store.CurrentSnap{{
+			InstanceName:  "some-snap",
+			SnapID:        "some-snap-id",
+			Revision:      snap.R(7),
+			RefreshedDate: fakeRevDateEpoch.AddDate(0, 0, 7),
+			Block:         []snap.Revision{snap.R(11)},
+			Epoch:         snap.E("1*"),
+		}},
+		userID: 1,
+	})
+}
+
 var orthogonalAutoAliasesScenarios = []struct {
 	aliasesBefore map[string][]string
 	names         []string
@@ -7807,44 +8115,44 @@ func (s *snapmgrTestSuite) TestRemoveMissingRevisionRefused(c *C) {
 
 func (s *snapmgrTestSuite) TestRemoveRefused(c *C) {
 	si := snap.SideInfo{
-		RealName: "gadget",
+		RealName: "brand-gadget",
 		Revision: snap.R(7),
 	}
 
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	snapstate.Set(s.state, "gadget", &snapstate.SnapState{
+	snapstate.Set(s.state, "brand-gadget", &snapstate.SnapState{
 		Active:   true,
 		Sequence: []*snap.SideInfo{&si},
 		Current:  si.Revision,
 		SnapType: "app",
 	})
 
-	_, err := snapstate.Remove(s.state, "gadget", snap.R(0), nil)
+	_, err := snapstate.Remove(s.state, "brand-gadget", snap.R(0), nil)
 
-	c.Check(err, ErrorMatches, `snap "gadget" is not removable`)
+	c.Check(err, ErrorMatches, `snap "brand-gadget" is not removable`)
 }
 
 func (s *snapmgrTestSuite) TestRemoveRefusedLastRevision(c *C) {
 	si := snap.SideInfo{
-		RealName: "gadget",
+		RealName: "brand-gadget",
 		Revision: snap.R(7),
 	}
 
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	snapstate.Set(s.state, "gadget", &snapstate.SnapState{
+	snapstate.Set(s.state, "brand-gadget", &snapstate.SnapState{
 		Active:   false,
 		Sequence: []*snap.SideInfo{&si},
 		Current:  si.Revision,
 		SnapType: "app",
 	})
 
-	_, err := snapstate.Remove(s.state, "gadget", snap.R(7), nil)
+	_, err := snapstate.Remove(s.state, "brand-gadget", snap.R(7), nil)
 
-	c.Check(err, ErrorMatches, `snap "gadget" is not removable`)
+	c.Check(err, ErrorMatches, `snap "brand-gadget" is not removable`)
 }
 
 func (s *snapmgrTestSuite) TestRemoveDeletesConfigOnLastRevision(c *C) {
@@ -9980,6 +10288,220 @@ func (s *snapmgrTestSuite) TestEnsureRefreshesWithUpdate(c *C) {
 	checkIsAutoRefresh(c, chg.Tasks(), true)
 }
 
+func (s *snapmgrTestSuite) TestEnsureRefreshesNotifies(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapstate.CanAutoRefresh = func(*state.State) (bool, error) { return true, nil }
+
+	makeTestRefreshConfig(s.state)
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "app",
+	})
+
+	var refreshUpdates []*snapstate.RefreshUpdate
+	var types []string
+	s.state.AddObserver(func(n *state.Notification) {
+		if upd, ok := n.Data.(*snapstate.RefreshUpdate); ok {
+			types = append(types, n.Type)
+			refreshUpdates = append(refreshUpdates, upd)
+		}
+	})
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+
+	c.Assert(s.state.Changes(), HasLen, 1)
+	chg := s.state.Changes()[0]
+	c.Check(types, DeepEquals, []string{"refresh-start"})
+	c.Check(refreshUpdates, DeepEquals, []*snapstate.RefreshUpdate{
+		{ChangeID: chg.ID(), SnapNames: []string{"some-snap"}},
+	})
+
+	for _, t := range chg.Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+	c.Check(types, DeepEquals, []string{"refresh-start", "refresh-finish"})
+	c.Check(refreshUpdates[1], DeepEquals, &snapstate.RefreshUpdate{
+		ChangeID:  chg.ID(),
+		SnapNames: []string{"some-snap"},
+		Status:    "Done",
+	})
+}
+
+func (s *snapmgrTestSuite) TestEnsureObservesOnlyWhileRefreshing(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	c.Check(s.snapmgr.ObservesRefreshChanges(), Equals, false)
+
+	snapstate.CanAutoRefresh = func(*state.State) (bool, error) { return true, nil }
+	makeTestRefreshConfig(s.state)
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "app",
+	})
+
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	c.Assert(s.state.Changes(), HasLen, 1)
+	c.Check(s.snapmgr.ObservesRefreshChanges(), Equals, true)
+
+	for _, t := range s.state.Changes()[0].Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+	s.state.Unlock()
+	s.snapmgr.Ensure()
+	s.state.Lock()
+	c.Check(s.snapmgr.ObservesRefreshChanges(), Equals, false)
+}
+
+func (s *snapmgrTestSuite) TestEnsureHandlesRefreshReadyUnobserved(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var types []string
+	s.state.AddObserver(func(n *state.Notification) {
+		if _, ok := n.Data.(*snapstate.RefreshUpdate); ok {
+			types = append(types, n.Type)
+		}
+	})
+
+	// an auto-refresh that started and finished between two passes
+	chg := s.state.NewChange("auto-refresh", "...")
+	chg.Set("snap-names", []string{"some-snap"})
+	t := s.state.NewTask("nop", "...")
+	chg.AddTask(t)
+	t.SetStatus(state.DoneStatus)
+	c.Check(types, HasLen, 0)
+
+	for i := 0; i < 2; i++ {
+		s.state.Unlock()
+		s.snapmgr.Ensure()
+		s.state.Lock()
+		c.Check(types, DeepEquals, []string{"refresh-finish"})
+	}
+	c.Check(s.snapmgr.ObservesRefreshChanges(), Equals, false)
+}
+
+func (s *snapmgrTestSuite) TestAutoRefreshSkipsSnapsWithoutEntitlement(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(1)},
+		},
+		Current:  snap.R(1),
+		SnapType: "app",
+	})
+	// access to some-snap was lost
+	s.state.Set("snaps-without-entitlement", map[string]bool{"some-snap": true})
+
+	updates, _, err := snapstate.AutoRefresh(context.Background(), s.state)
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+
+	// a manual refresh checks again
+	updates, _, err = snapstate.UpdateMany(context.Background(), s.state, []string{"some-snap"}, 0, nil)
+	c.Assert(err, IsNil)
+	c.Check(updates, DeepEquals, []string{"some-snap"})
+
+	// as do auto-refreshes once a user logged in
+	snapstate.ResetEntitlements(s.state)
+	updates, _, err = snapstate.AutoRefresh(context.Background(), s.state)
+	c.Assert(err, IsNil)
+	c.Check(updates, DeepEquals, []string{"some-snap"})
+}
+
+func (s *snapmgrTestSuite) TestAutoRefreshSkipsHeldSnaps(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, name := range []string{"some-snap", "services-snap"} {
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			Active: true,
+			Sequence: []*snap.SideInfo{
+				{RealName: name, SnapID: name + "-id", Revision: snap.R(1)},
+			},
+			Current:  snap.R(1),
+			SnapType: "app",
+		})
+	}
+
+	until, err := snapstate.HoldRefresh(s.state, "some-snap", time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Check(until.After(time.Now()), Equals, true)
+
+	// only the held snap is left out
+	updates, _, err := snapstate.AutoRefresh(context.Background(), s.state)
+	c.Assert(err, IsNil)
+	c.Check(updates, DeepEquals, []string{"services-snap"})
+
+	// a manual refresh is not held
+	updates, _, err = snapstate.UpdateMany(context.Background(), s.state, []string{"some-snap"}, 0, nil)
+	c.Assert(err, IsNil)
+	c.Check(updates, DeepEquals, []string{"some-snap"})
+
+	// releasing the hold
+	until, err = snapstate.HoldRefresh(s.state, "some-snap", time.Time{})
+	c.Assert(err, IsNil)
+	c.Check(until.IsZero(), Equals, true)
+	updates, _, err = snapstate.AutoRefresh(context.Background(), s.state)
+	c.Assert(err, IsNil)
+	sort.Strings(updates)
+	c.Check(updates, DeepEquals, []string{"services-snap", "some-snap"})
+}
+
+func (s *snapmgrTestSuite) TestHoldRefresh(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := snapstate.HoldRefresh(s.state, "some-snap", time.Now().Add(time.Hour))
+	c.Assert(err, FitsTypeOf, &snap.NotInstalledError{})
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{{RealName: "some-snap", Revision: snap.R(1)}},
+		Current:  snap.R(1),
+	})
+
+	// holds are capped
+	until, err := snapstate.HoldRefresh(s.state, "some-snap", time.Now().Add(365*24*time.Hour))
+	c.Assert(err, IsNil)
+	c.Check(until.Before(time.Now().Add(61*24*time.Hour)), Equals, true)
+
+	// a later hold is kept
+	later := until
+	until, err = snapstate.HoldRefresh(s.state, "some-snap", time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Check(until.Equal(later), Equals, true)
+
+	// expired holds are dropped
+	s.state.Set("snaps-refresh-hold", map[string]time.Time{"gone-snap": time.Now().Add(-time.Hour)})
+	_, err = snapstate.HoldRefresh(s.state, "some-snap", time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+	var held map[string]time.Time
+	c.Assert(s.state.Get("snaps-refresh-hold", &held), IsNil)
+	c.Check(held, HasLen, 1)
+	c.Check(held["some-snap"].IsZero(), Equals, false)
+}
+
 func (s *snapmgrTestSuite) TestEnsureRefreshesImmediateWithUpdate(c *C) {
 	r := release.MockOnClassic(false)
 	defer r()
@@ -10985,11 +11507,22 @@ func (s *canRemoveSuite) TestLastGadgetsAreNotOK(c *C) {
 	info := &snap.Info{
 		SnapType: snap.TypeGadget,
 	}
-	info.RealName = "foo"
+	// this gadget part of the model
+	info.RealName = "brand-gadget"
 
 	c.Check(snapstate.CanRemove(s.st, info, &snapstate.SnapState{}, true, s.deviceCtx), Equals, false)
 }
 
+func (s *canRemoveSuite) TestLastGadgetNotInModelOK(c *C) {
+	info := &snap.Info{
+		SnapType: snap.TypeGadget,
+	}
+	// e.g. the gadget of the model before a remodel
+	info.RealName = "other-gadget"
+
+	c.Check(snapstate.CanRemove(s.st, info, &snapstate.SnapState{}, true, s.deviceCtx), Equals, true)
+}
+
 func (s *canRemoveSuite) TestLastOSAndKernelAreNotOK(c *C) {
 	os := &snap.Info{
 		SnapType: snap.TypeOS,
@@ -11674,7 +12207,7 @@ func (s *snapmgrTestSuite) TestInstallMany(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0)
+	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, nil)
 	c.Assert(err, IsNil)
 	c.Assert(tts, HasLen, 2)
 	c.Check(installed, DeepEquals, []string{"one", "two"})
@@ -11690,13 +12223,75 @@ func (s *snapmgrTestSuite) TestInstallMany(c *C) {
 	}
 }
 
+func (s *snapmgrTestSuite) TestInstallManyAllOrNothing(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	flags := &snapstate.Flags{Transaction: client.TransactionAllOrNothing}
+	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, flags)
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 2)
+	c.Check(installed, DeepEquals, []string{"one", "two"})
+
+	for _, ts := range tts {
+		verifyInstallTasks(c, 0, 0, ts, s.state)
+		// check that tasksets are all in the same lane
+		for _, t := range ts.Tasks() {
+			c.Assert(t.Lanes(), DeepEquals, []int{1})
+		}
+	}
+}
+
+func (s *snapmgrTestSuite) TestInstallManyAllOrNothingUndoRunThrough(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	flags := &snapstate.Flags{Transaction: client.TransactionAllOrNothing}
+	_, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, flags)
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 2)
+
+	chg := s.state.NewChange("install", "install two snaps")
+	for _, ts := range tts {
+		chg.AddAll(ts)
+	}
+
+	// make only the installation of the second snap fail
+	last := lastWithLane(tts[1].Tasks())
+	c.Assert(last, NotNil)
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(last)
+	terr.JoinLane(last.Lanes()[0])
+	chg.AddTask(terr)
+
+	s.state.Unlock()
+	defer s.se.Stop()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	// both snaps were undone, tasks without undo handlers stay done
+	for i, ts := range tts {
+		for _, t := range ts.Tasks() {
+			switch t.Kind() {
+			case "mount-snap", "link-snap":
+				c.Check(t.Status(), Equals, state.UndoneStatus, Commentf("%s of snap %d", t.Kind(), i))
+			}
+		}
+	}
+	for _, name := range []string{"one", "two"} {
+		var snapst snapstate.SnapState
+		c.Check(snapstate.Get(s.state, name, &snapst), Equals, state.ErrNoState)
+	}
+}
+
 func (s *snapmgrTestSuite) TestInstallManyTooEarly(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
 	s.state.Set("seeded", nil)
 
-	_, _, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0)
+	_, _, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, nil)
 	c.Check(err, FitsTypeOf, &snapstate.ChangeConflictError{})
 	c.Assert(err, ErrorMatches, `too early for operation, device not yet seeded or device model not acknowledged`)
 }
@@ -11705,11 +12300,11 @@ func (s *snapmgrTestSuite) TestInstallManyChecksPreconditions(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	_, _, err := snapstate.InstallMany(s.state, []string{"some-snap-now-classic"}, 0)
+	_, _, err := snapstate.InstallMany(s.state, []string{"some-snap-now-classic"}, 0, nil)
 	c.Assert(err, NotNil)
 	c.Check(err, DeepEquals, &snapstate.SnapNeedsClassicError{Snap: "some-snap-now-classic"})
 
-	_, _, err = snapstate.InstallMany(s.state, []string{"some-snap_foo"}, 0)
+	_, _, err = snapstate.InstallMany(s.state, []string{"some-snap_foo"}, 0, nil)
 	c.Assert(err, ErrorMatches, "experimental feature disabled - test it by setting 'experimental.parallel-instances' to true")
 }
 
@@ -11914,6 +12509,57 @@ defaults:
 	c.Assert(defls, DeepEquals, map[string]interface{}{"foo": "bar"})
 }
 
+func (s *snapmgrTestSuite) TestConfigDefaultsSystemVolumeDefaults(c *C) {
+	r := release.MockOnClassic(false)
+	defer r()
+
+	// using MockSnapReadInfo, we want to read the bits on disk
+	snapstate.MockSnapReadInfo(snap.ReadInfo)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.prepareGadget(c, `        defaults:
+            foo: volume-bar
+            baz: volume-baz
+defaults:
+    system:
+        foo: bar
+`)
+
+	deviceCtx := deviceWithGadgetContext("the-gadget")
+
+	makeInstalledMockCoreSnap(c)
+
+	// the defaults for the system take precedence over the volume ones
+	defls, err := snapstate.ConfigDefaults(s.state, deviceCtx, "core")
+	c.Assert(err, IsNil)
+	c.Assert(defls, DeepEquals, map[string]interface{}{"foo": "bar", "baz": "volume-baz"})
+
+	defls, err = snapstate.GadgetVolumeDefaults(s.state, deviceCtx)
+	c.Assert(err, IsNil)
+	c.Assert(defls, DeepEquals, map[string]interface{}{"foo": "volume-bar", "baz": "volume-baz"})
+}
+
+func (s *snapmgrTestSuite) TestGadgetVolumeDefaultsNone(c *C) {
+	r := release.MockOnClassic(false)
+	defer r()
+
+	// using MockSnapReadInfo, we want to read the bits on disk
+	snapstate.MockSnapReadInfo(snap.ReadInfo)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.prepareGadget(c)
+
+	_, err := snapstate.GadgetVolumeDefaults(s.state, deviceWithGadgetContext("the-gadget"))
+	c.Assert(err, Equals, state.ErrNoState)
+
+	_, err = snapstate.GadgetVolumeDefaults(s.state, deviceWithoutGadgetContext())
+	c.Assert(err, Equals, state.ErrNoState)
+}
+
 func (s *snapmgrTestSuite) TestConfigDefaultsSystemConflictsCoreSnapId(c *C) {
 	r := release.MockOnClassic(false)
 	defer r()
@@ -11952,6 +12598,69 @@ defaults:
 	c.Assert(defls, DeepEquals, map[string]interface{}{"foo": "bar"})
 }
 
+func (s *snapmgrTestSuite) TestConfigDefaultsScoped(c *C) {
+	r := release.MockOnClassic(false)
+	defer r()
+
+	// using MockSnapReadInfo, we want to read the bits on disk
+	snapstate.MockSnapReadInfo(snap.ReadInfo)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.prepareGadget(c, `
+scoped-defaults:
+    - brand-id: brand
+      model: baz-3000
+      defaults:
+          some-snap-ididididididididididid:
+              other-key: model-value
+    - brand-id: other-brand
+      model: baz-3000
+      defaults:
+          some-snap-ididididididididididid:
+              other-key: other-brand-value
+    - brand-id: brand
+      model: other-model
+      defaults:
+          some-snap-ididididididididididid:
+              other-key: other-model-value
+    - brand-id: brand
+      serial: serial-1
+      defaults:
+          some-snap-ididididididididididid:
+              key: serial-value
+`)
+
+	deviceCtx := deviceWithGadgetContext("the-gadget")
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", Revision: snap.R(11), SnapID: "some-snap-ididididididididididid"},
+		},
+		Current:  snap.R(11),
+		SnapType: "app",
+	})
+	makeInstalledMockCoreSnap(c)
+
+	// not yet registered
+	defls, err := snapstate.ConfigDefaults(s.state, deviceCtx, "some-snap")
+	c.Assert(err, IsNil)
+	c.Assert(defls, DeepEquals, map[string]interface{}{
+		"key":       "value",
+		"other-key": "model-value",
+	})
+
+	deviceCtx.(*snapstatetest.TrivialDeviceContext).DeviceSerial = "serial-1"
+	defls, err = snapstate.ConfigDefaults(s.state, deviceCtx, "some-snap")
+	c.Assert(err, IsNil)
+	c.Assert(defls, DeepEquals, map[string]interface{}{
+		"key":       "serial-value",
+		"other-key": "model-value",
+	})
+}
+
 func (s *snapmgrTestSuite) TestGadgetDefaultsAreNormalizedForConfigHook(c *C) {
 	var mockGadgetSnapYaml = `
 name: canonical-pc
@@ -14584,10 +15293,10 @@ func (s *snapmgrTestSuite) TestInstallValidatesInstanceNames(c *C) {
 	_, err = snapstate.Install(context.Background(), s.state, "foo_123_456", nil, 0, snapstate.Flags{})
 	c.Assert(err, ErrorMatches, `invalid instance name: invalid instance key: "123_456"`)
 
-	_, _, err = snapstate.InstallMany(s.state, []string{"foo--invalid"}, 0)
+	_, _, err = snapstate.InstallMany(s.state, []string{"foo--invalid"}, 0, nil)
 	c.Assert(err, ErrorMatches, `invalid instance name: invalid snap name: "foo--invalid"`)
 
-	_, _, err = snapstate.InstallMany(s.state, []string{"foo_123_456"}, 0)
+	_, _, err = snapstate.InstallMany(s.state, []string{"foo_123_456"}, 0, nil)
 	c.Assert(err, ErrorMatches, `invalid instance name: invalid instance key: "123_456"`)
 
 	mockSnap := makeTestSnap(c, `name: some-snap
@@ -14655,6 +15364,7 @@ func (s *snapmgrTestSuite) TestForSnapSetupResetsFlags(c *C) {
 		IsAutoRefresh:    true,
 		NoReRefresh:      true,
 		RequireTypeBase:  true,
+		Transaction:      client.TransactionAllOrNothing,
 	}
 	flags = flags.ForSnapSetup()
 
@@ -14674,5 +15384,6 @@ func (s *snapmgrTestSuite) TestForSnapSetupResetsFlags(c *C) {
 		IsAutoRefresh:    true,
 		NoReRefresh:      false,
 		RequireTypeBase:  false,
+		Transaction:      "",
 	})
 }
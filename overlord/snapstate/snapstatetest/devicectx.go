@@ -30,6 +30,8 @@ type TrivialDeviceContext struct {
 	DeviceModel *asserts.Model
 	Remodeling  bool
 	CtxStore    snapstate.StoreService
+
+	DeviceSerial string
 }
 
 func (dc *TrivialDeviceContext) Model() *asserts.Model {
@@ -44,6 +46,10 @@ func (dc *TrivialDeviceContext) ForRemodeling() bool {
 	return dc.Remodeling
 }
 
+func (dc *TrivialDeviceContext) Serial() (string, error) {
+	return dc.DeviceSerial, nil
+}
+
 func MockDeviceModel(model *asserts.Model) (restore func()) {
 	var deviceCtx snapstate.DeviceContext
 	if model != nil {
@@ -23,6 +23,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/auth"
@@ -346,6 +347,22 @@ func refreshCandidates(ctx context.Context, st *state.State, names []string, use
 		fallbackID = user.ID
 	}
 
+	failedBoots, err := bootFailures(st)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	withoutEntitlement, err := snapsWithoutEntitlement(st)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	held, err := heldSnaps(st)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+
 	actionsByUserID := make(map[int][]*store.SnapAction)
 	stateByInstanceName := make(map[string]*SnapState, len(snapStates))
 	ignoreValidationByInstanceName := make(map[string]bool)
@@ -363,6 +380,17 @@ func refreshCandidates(ctx context.Context, st *state.State, names []string, use
 			return
 		}
 
+		if opts.IsAutoRefresh && withoutEntitlement[installed.InstanceName] {
+			// no auto-refresh of snaps we lost access to,
+			// until a user logs in
+			return
+		}
+
+		if opts.IsAutoRefresh && held[installed.InstanceName].After(now) {
+			// the user held its auto-refreshes
+			return
+		}
+
 		if len(names) > 0 && !strutil.SortedListContains(names, installed.InstanceName) {
 			return
 		}
@@ -371,6 +399,11 @@ func refreshCandidates(ctx context.Context, st *state.State, names []string, use
 
 		if len(names) == 0 {
 			installed.Block = snapst.Block()
+			// also never auto-refresh to revisions that
+			// failed to boot before
+			for _, f := range failedBoots[installed.InstanceName] {
+				installed.Block = append(installed.Block, f.Revision)
+			}
 		}
 
 		userID := snapst.UserID
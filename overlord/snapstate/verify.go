@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+// VerifySnapFile checks the installed snap file at snapPath against its
+// assertions, it is called without the state lock held. It is set by
+// assertstate.
+var VerifySnapFile func(st *state.State, instanceName, snapPath string, si *snap.SideInfo) error
+
+// verifySnapsInterval is how often the files of the installed snaps are
+// verified against their assertions.
+var verifySnapsInterval = 7 * 24 * time.Hour
+
+type snapsVerifier struct {
+	state *state.State
+}
+
+func newSnapsVerifier(st *state.State) *snapsVerifier {
+	return &snapsVerifier{state: st}
+}
+
+// Ensure schedules the periodic verification of the installed snaps.
+func (v *snapsVerifier) Ensure() error {
+	v.state.Lock()
+	defer v.state.Unlock()
+
+	if VerifySnapFile == nil {
+		return nil
+	}
+
+	var seeded bool
+	err := v.state.Get("seeded", &seeded)
+	if err != nil && err != state.ErrNoState {
+		return err
+	}
+	if !seeded {
+		return nil
+	}
+
+	now := time.Now()
+	var last time.Time
+	err = v.state.Get("last-snaps-verification", &last)
+	if err == state.ErrNoState {
+		// first verification happens one interval from now
+		v.state.Set("last-snaps-verification", now)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if now.Sub(last) < verifySnapsInterval {
+		return nil
+	}
+
+	for _, chg := range v.state.Changes() {
+		if chg.Kind() == "verify-installed-snaps" && !chg.Status().Ready() {
+			return nil
+		}
+	}
+
+	logger.Debugf("Scheduling verification of the installed snaps.")
+	t := v.state.NewTask("verify-installed-snaps", "Verify installed snaps against their assertions")
+	chg := v.state.NewChange("verify-installed-snaps", t.Summary())
+	chg.AddTask(t)
+	v.state.Set("last-snaps-verification", now)
+
+	return nil
+}
+
+type snapToVerify struct {
+	instanceName string
+	path         string
+	sideInfo     snap.SideInfo
+}
+
+func (m *SnapManager) doVerifyInstalledSnaps(t *state.Task, tb *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	snapStates, err := All(st)
+	st.Unlock()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(snapStates))
+	for instanceName := range snapStates {
+		names = append(names, instanceName)
+	}
+	sort.Strings(names)
+
+	var toVerify []snapToVerify
+	for _, instanceName := range names {
+		si := snapStates[instanceName].CurrentSideInfo()
+		if si == nil || si.SnapID == "" {
+			// unasserted snaps have nothing to be verified against
+			continue
+		}
+		toVerify = append(toVerify, snapToVerify{
+			instanceName: instanceName,
+			path:         snap.MountFile(instanceName, si.Revision),
+			sideInfo:     *si,
+		})
+	}
+
+	for _, sv := range toVerify {
+		select {
+		case <-tb.Dying():
+			// stopping, the task runner retries the task later
+			return tomb.ErrDying
+		default:
+		}
+		err := VerifySnapFile(st, sv.instanceName, sv.path, &sv.sideInfo)
+		st.Lock()
+		if err != nil {
+			t.Logf("%v", err)
+			st.WarnfWithSeverity(state.WarningSeverityCritical, "installed snap %q failed verification: %v", sv.instanceName, err)
+		} else {
+			t.Logf("snap %q revision %s verified", sv.instanceName, sv.sideInfo.Revision)
+		}
+		st.Unlock()
+	}
+
+	return nil
+}
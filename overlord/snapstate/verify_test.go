@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (s *snapmgrTestSuite) mockVerifySnapFile(c *C, f func(instanceName, snapPath string, si *snap.SideInfo) error) {
+	old := snapstate.VerifySnapFile
+	snapstate.VerifySnapFile = func(st *state.State, instanceName, snapPath string, si *snap.SideInfo) error {
+		return f(instanceName, snapPath, si)
+	}
+	s.AddCleanup(func() { snapstate.VerifySnapFile = old })
+}
+
+func (s *snapmgrTestSuite) verifyChanges() []*state.Change {
+	var chgs []*state.Change
+	for _, chg := range s.state.Changes() {
+		if chg.Kind() == "verify-installed-snaps" {
+			chgs = append(chgs, chg)
+		}
+	}
+	return chgs
+}
+
+func (s *snapmgrTestSuite) TestVerifyInstalledSnapsScheduling(c *C) {
+	s.mockVerifySnapFile(c, func(string, string, *snap.SideInfo) error { return nil })
+
+	// the first verification happens one interval after the first run
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	s.state.Lock()
+	var last time.Time
+	c.Assert(s.state.Get("last-snaps-verification", &last), IsNil)
+	c.Check(s.verifyChanges(), HasLen, 0)
+
+	// not yet due
+	s.state.Set("last-snaps-verification", time.Now().Add(-24*time.Hour))
+	s.state.Unlock()
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	s.state.Lock()
+	c.Check(s.verifyChanges(), HasLen, 0)
+
+	// due
+	s.state.Set("last-snaps-verification", time.Now().Add(-8*24*time.Hour))
+	s.state.Unlock()
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	s.state.Lock()
+	chgs := s.verifyChanges()
+	c.Assert(chgs, HasLen, 1)
+	c.Check(chgs[0].Summary(), Equals, "Verify installed snaps against their assertions")
+	c.Assert(s.state.Get("last-snaps-verification", &last), IsNil)
+	c.Check(time.Since(last) < time.Minute, Equals, true)
+
+	// not scheduled again while one is in progress
+	s.state.Set("last-snaps-verification", time.Now().Add(-8*24*time.Hour))
+	s.state.Unlock()
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(s.verifyChanges(), HasLen, 1)
+}
+
+func (s *snapmgrTestSuite) TestVerifyInstalledSnapsNotSeeded(c *C) {
+	s.mockVerifySnapFile(c, func(string, string, *snap.SideInfo) error { return nil })
+
+	s.state.Lock()
+	s.state.Set("seeded", nil)
+	s.state.Unlock()
+
+	c.Assert(s.snapmgr.Ensure(), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	var last time.Time
+	c.Check(s.state.Get("last-snaps-verification", &last), Equals, state.ErrNoState)
+}
+
+func (s *snapmgrTestSuite) TestVerifyInstalledSnapsTask(c *C) {
+	var verified []string
+	s.mockVerifySnapFile(c, func(instanceName, snapPath string, si *snap.SideInfo) error {
+		verified = append(verified, fmt.Sprintf("%s:%s:%s", instanceName, snapPath, si.Revision))
+		if instanceName == "some-snap" {
+			return fmt.Errorf(`snap "some-snap" revision 7 file does not match its snap-revision assertion (corrupted or tampered)`)
+		}
+		return nil
+	})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(7)},
+		},
+		Current: snap.R(7),
+	})
+	snapstate.Set(s.state, "other-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "other-snap", SnapID: "other-snap-id", Revision: snap.R(3)},
+		},
+		Current: snap.R(3),
+	})
+	// unasserted, not verified
+	snapstate.Set(s.state, "local-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: []*snap.SideInfo{
+			{RealName: "local-snap", Revision: snap.R(-1)},
+		},
+		Current: snap.R(-1),
+	})
+
+	t := s.state.NewTask("verify-installed-snaps", "...")
+	chg := s.state.NewChange("verify-installed-snaps", "...")
+	chg.AddTask(t)
+
+	s.state.Unlock()
+	s.settle(c)
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Check(verified, DeepEquals, []string{
+		fmt.Sprintf("other-snap:%s:3", snap.MountFile("other-snap", snap.R(3))),
+		fmt.Sprintf("some-snap:%s:7", snap.MountFile("some-snap", snap.R(7))),
+	})
+
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].Severity(), Equals, state.WarningSeverityCritical)
+	c.Check(warns[0].String(), Equals, `installed snap "some-snap" failed verification: snap "some-snap" revision 7 file does not match its snap-revision assertion (corrupted or tampered)`)
+	c.Check(t.Log(), HasLen, 2)
+}
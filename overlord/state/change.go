@@ -305,6 +305,7 @@ func (c *Change) SetStatus(s Status) {
 	if s.Ready() {
 		c.markReady()
 	}
+	c.notifyUpdate()
 }
 
 func (c *Change) markReady() {
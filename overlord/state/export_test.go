@@ -48,6 +48,7 @@ func MockTaskTimes(t *Task, spawnTime, readyTime time.Time) {
 func (s *State) AddWarning(message string, lastAdded, lastShown time.Time, expireAfter, repeatAfter time.Duration) {
 	s.addWarning(Warning{
 		message:     message,
+		severity:    WarningSeverityWarning,
 		lastShown:   lastShown,
 		expireAfter: expireAfter,
 		repeatAfter: repeatAfter,
@@ -66,6 +67,18 @@ func (t *Task) AccumulateUndoingTime(duration time.Duration) {
 	t.accumulateUndoingTime(duration)
 }
 
+func (t *Task) CountRetry() int {
+	return t.countRetry()
+}
+
+func MockRetryJitter(f func(max time.Duration) time.Duration) (restore func()) {
+	old := randDuration
+	randDuration = f
+	return func() {
+		randDuration = old
+	}
+}
+
 var (
 	ErrNoWarningMessage     = errNoWarningMessage
 	ErrBadWarningMessage    = errBadWarningMessage
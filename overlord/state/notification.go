@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"time"
+)
+
+// Notification is an event about the system delivered to the observers
+// of the state as it happens.
+type Notification struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// ChangeUpdate is the data of "change-update" notifications, sent when
+// the status of a task of a change, and possibly of the change, changes.
+type ChangeUpdate struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Ready  bool   `json:"ready"`
+}
+
+// AddObserver registers a function called with every notification,
+// returning an id to remove it with RemoveObserver later. The function is
+// called with the state locked and must not block.
+func (s *State) AddObserver(observer func(*Notification)) int {
+	// observers are not persisted
+	s.reading()
+	if s.observers == nil {
+		s.observers = make(map[int]func(*Notification))
+	}
+	s.lastObserverID++
+	s.observers[s.lastObserverID] = observer
+	return s.lastObserverID
+}
+
+// RemoveObserver unregisters the observer with the given id.
+func (s *State) RemoveObserver(id int) {
+	s.reading()
+	delete(s.observers, id)
+}
+
+// Notify delivers a notification of the given type and data to all the
+// observers of the state.
+func (s *State) Notify(typ string, data interface{}) {
+	s.reading()
+	if len(s.observers) == 0 {
+		return
+	}
+	n := &Notification{
+		Type: typ,
+		Time: timeNow(),
+		Data: data,
+	}
+	// observers can notify in turn, and add or remove observers
+	observers := make([]func(*Notification), 0, len(s.observers))
+	for _, observer := range s.observers {
+		observers = append(observers, observer)
+	}
+	for _, observer := range observers {
+		observer(n)
+	}
+}
+
+func (c *Change) notifyUpdate() {
+	if len(c.state.observers) == 0 {
+		// avoid computing the status of the change for nothing
+		return
+	}
+	c.state.Notify("change-update", &ChangeUpdate{
+		ID:     c.id,
+		Kind:   c.kind,
+		Status: c.Status().String(),
+		Ready:  c.IsReady(),
+	})
+}
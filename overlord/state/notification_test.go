@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type notificationSuite struct{}
+
+var _ = Suite(&notificationSuite{})
+
+func (notificationSuite) TestObservers(c *C) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	restore := state.MockTime(now)
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "...")
+	t2 := st.NewTask("link", "...")
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+
+	var notified []*state.Notification
+	id := st.AddObserver(func(n *state.Notification) {
+		notified = append(notified, n)
+	})
+
+	t1.SetStatus(state.DoneStatus)
+	// no change, no notification
+	t1.SetStatus(state.DoneStatus)
+	t2.SetStatus(state.DoneStatus)
+	st.Notify("custom", "data")
+
+	c.Check(notified, DeepEquals, []*state.Notification{{
+		Type: "change-update",
+		Time: now,
+		Data: &state.ChangeUpdate{ID: chg.ID(), Kind: "install", Status: "Do"},
+	}, {
+		Type: "change-update",
+		Time: now,
+		Data: &state.ChangeUpdate{ID: chg.ID(), Kind: "install", Status: "Done", Ready: true},
+	}, {
+		Type: "custom",
+		Time: now,
+		Data: "data",
+	}})
+
+	notified = nil
+	st.Warnf("hello")
+	c.Assert(notified, HasLen, 1)
+	c.Check(notified[0].Type, Equals, "warning")
+	c.Check(notified[0].Data.(*state.Warning).String(), Equals, "hello")
+
+	notified = nil
+	st.RemoveObserver(id)
+	st.Warnf("hello again")
+	c.Check(notified, HasLen, 0)
+}
+
+func (notificationSuite) TestObserverNotifies(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	var types []string
+	st.AddObserver(func(n *state.Notification) {
+		types = append(types, n.Type)
+		if n.Type == "first" {
+			st.Notify("second", nil)
+		}
+	})
+	st.Notify("first", nil)
+	c.Check(types, DeepEquals, []string{"first", "second"})
+}
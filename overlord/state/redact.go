@@ -0,0 +1,119 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Redacted is what secrets are replaced with in a redacted export of
+// the state.
+const Redacted = "<redacted>"
+
+// secretKeys are the keys of the entries, anywhere in the state, that
+// hold credentials: the user and store macaroons and their discharges,
+// the device session macaroon and the key used to mint macaroons. The
+// key keeping refreshes anonymous is also kept secret.
+var secretKeys = map[string]bool{
+	"macaroon":            true,
+	"discharges":          true,
+	"store-macaroon":      true,
+	"store-discharges":    true,
+	"session-macaroon":    true,
+	"macaroon-key":        true,
+	"refresh-privacy-key": true,
+}
+
+// ExportRedacted returns the state serialized like it is checkpointed,
+// but with the secrets it holds redacted, so that it can be shared
+// safely, e.g. when reporting a problem. The state must be locked.
+func (s *State) ExportRedacted() ([]byte, error) {
+	data, err := s.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return Redact(data)
+}
+
+// Redact takes serialized state, e.g. as read from the state file, and
+// returns it with the secrets it holds redacted. The structure of the
+// state is preserved: secrets are replaced by Redacted, lists of
+// secrets keep their length, and passwords in URLs become "redacted".
+func Redact(data []byte) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	// keep the markers readable instead of HTML escaped
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(redactValue(v, false)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func redactValue(v interface{}, secret bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = redactValue(value, secret || secretKeys[key])
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value, secret)
+		}
+		return v
+	case string:
+		if secret {
+			if v == "" {
+				return v
+			}
+			return Redacted
+		}
+		return redactURLPassword(v)
+	default:
+		return v
+	}
+}
+
+// redactURLPassword redacts the password of URLs with user info, such
+// as those of authenticated proxies.
+func redactURLPassword(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), "redacted")
+	return u.String()
+}
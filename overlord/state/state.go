@@ -111,6 +111,9 @@ type State struct {
 
 	cache map[interface{}]interface{}
 
+	observers      map[int]func(*Notification)
+	lastObserverID int
+
 	restarting RestartType
 	restartLck sync.Mutex
 	bootID     string
@@ -429,6 +432,30 @@ func (s *State) TaskCount() int {
 	return len(s.tasks)
 }
 
+// PruneTasks removes right away the given tasks, which were never added
+// to a change, instead of leaving them for Prune to remove later. It is
+// meant for tasks created only to be inspected. Tasks that belong to a
+// change are left alone.
+func (s *State) PruneTasks(tasks []*Task) {
+	s.writing()
+	for _, t := range tasks {
+		if t.Change() != nil {
+			continue
+		}
+		for _, tid := range t.waitTasks {
+			if other := s.tasks[tid]; other != nil {
+				other.haltTasks = removeOnce(other.haltTasks, t.id)
+			}
+		}
+		for _, tid := range t.haltTasks {
+			if other := s.tasks[tid]; other != nil {
+				other.waitTasks = removeOnce(other.waitTasks, t.id)
+			}
+		}
+		delete(s.tasks, t.id)
+	}
+}
+
 func (s *State) tasksIn(tids []string) []*Task {
 	res := make([]*Task, len(tids))
 	for i, tid := range tids {
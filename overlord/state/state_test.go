@@ -818,6 +818,27 @@ func (ss *stateSuite) TestPruneEmptyChange(c *C) {
 	c.Assert(st.Change(chg.ID()), IsNil)
 }
 
+func (ss *stateSuite) TestPruneTasks(c *C) {
+	st := state.New(&fakeStateBackend{})
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("foo", "...")
+	chg.AddTask(t1)
+	t2 := st.NewTask("bar", "...")
+	t2.WaitFor(t1)
+	t3 := st.NewTask("baz", "...")
+	t3.WaitFor(t2)
+	c.Check(st.TaskCount(), Equals, 3)
+
+	// tasks of a change are left alone
+	st.PruneTasks([]*state.Task{t1, t2, t3})
+	c.Check(st.TaskCount(), Equals, 1)
+	c.Check(st.Task(t1.ID()), Equals, t1)
+	c.Check(t1.HaltTasks(), HasLen, 0)
+}
+
 func (ss *stateSuite) TestPruneMaxChangesHappy(c *C) {
 	st := state.New(&fakeStateBackend{})
 	st.Lock()
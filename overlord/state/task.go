@@ -56,11 +56,13 @@ type Task struct {
 	readyTime time.Time
 
 	// TODO: add:
-	// {,Un}DoingRetries - number of retries
 	// Retry{,Un}DoingTimes - time spend to figure out a retry is needed
 	doingTime   time.Duration
 	undoingTime time.Duration
 
+	doingRetries   int
+	undoingRetries int
+
 	atTime time.Time
 }
 
@@ -96,6 +98,9 @@ type marshalledTask struct {
 	DoingTime   time.Duration `json:"doing-time,omitempty"`
 	UndoingTime time.Duration `json:"undoing-time,omitempty"`
 
+	DoingRetries   int `json:"doing-retries,omitempty"`
+	UndoingRetries int `json:"undoing-retries,omitempty"`
+
 	AtTime *time.Time `json:"at-time,omitempty"`
 }
 
@@ -130,6 +135,9 @@ func (t *Task) MarshalJSON() ([]byte, error) {
 		DoingTime:   t.doingTime,
 		UndoingTime: t.undoingTime,
 
+		DoingRetries:   t.doingRetries,
+		UndoingRetries: t.undoingRetries,
+
 		AtTime: atTime,
 	})
 }
@@ -169,6 +177,8 @@ func (t *Task) UnmarshalJSON(data []byte) error {
 	}
 	t.doingTime = unmarshalled.DoingTime
 	t.undoingTime = unmarshalled.UndoingTime
+	t.doingRetries = unmarshalled.DoingRetries
+	t.undoingRetries = unmarshalled.UndoingRetries
 	return nil
 }
 
@@ -207,6 +217,9 @@ func (t *Task) SetStatus(new Status) {
 	chg := t.Change()
 	if chg != nil {
 		chg.taskStatusChanged(t, old, new)
+		if old != new {
+			chg.notifyUpdate()
+		}
 	}
 }
 
@@ -310,6 +323,32 @@ func (t *Task) UndoingTime() time.Duration {
 	return t.undoingTime
 }
 
+// countRetry records a retry of the task in its current status and
+// returns the number of retries in that status so far.
+func (t *Task) countRetry() int {
+	t.state.writing()
+	switch t.status {
+	case UndoStatus, UndoingStatus:
+		t.undoingRetries++
+		return t.undoingRetries
+	default:
+		t.doingRetries++
+		return t.doingRetries
+	}
+}
+
+// DoingRetries returns the number of times doing the task was retried.
+func (t *Task) DoingRetries() int {
+	t.state.reading()
+	return t.doingRetries
+}
+
+// UndoingRetries returns the number of times undoing the task was retried.
+func (t *Task) UndoingRetries() int {
+	t.state.reading()
+	return t.undoingRetries
+}
+
 const (
 	// Messages logged in tasks are guaranteed to use the time formatted
 	// per RFC3339 plus the following strings as a prefix, so these may
@@ -400,6 +439,15 @@ func addOnce(set []string, s string) []string {
 	return append(set, s)
 }
 
+func removeOnce(set []string, s string) []string {
+	for i, cur := range set {
+		if s == cur {
+			return append(set[:i:i], set[i+1:]...)
+		}
+	}
+	return set
+}
+
 // WaitFor registers another task as a requirement for t to make progress.
 func (t *Task) WaitFor(another *Task) {
 	t.state.writing()
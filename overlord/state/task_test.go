@@ -20,6 +20,7 @@
 package state_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 
@@ -280,6 +281,38 @@ func (ts *taskSuite) TestTaskMarshalsDoingUndoingTime(c *C) {
 	c.Assert(string(d), testutil.Contains, `"undoing-time":654321`)
 }
 
+func (ts *taskSuite) TestTaskMarshalsRetries(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	c.Check(t.CountRetry(), Equals, 1)
+	c.Check(t.CountRetry(), Equals, 2)
+	t.SetStatus(state.UndoingStatus)
+	c.Check(t.CountRetry(), Equals, 1)
+
+	d, err := t.MarshalJSON()
+	c.Assert(err, IsNil)
+	c.Assert(string(d), testutil.Contains, `"doing-retries":2`)
+	c.Assert(string(d), testutil.Contains, `"undoing-retries":1`)
+
+	// the retries survive a round trip through the state
+	buf, err := json.Marshal(st)
+	c.Assert(err, IsNil)
+	st2, err := state.ReadState(nil, bytes.NewReader(buf))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+
+	t2 := st2.Task(t.ID())
+	c.Assert(t2, NotNil)
+	c.Check(t2.DoingRetries(), Equals, 2)
+	c.Check(t2.UndoingRetries(), Equals, 1)
+}
+
 func (ts *taskSuite) TestTaskWaitFor(c *C) {
 	st := state.New(nil)
 	st.Lock()
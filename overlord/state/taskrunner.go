@@ -20,6 +20,8 @@
 package state
 
 import (
+	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -46,6 +48,54 @@ func (r *Retry) Error() string {
 	return "task should be retried"
 }
 
+// RetryPolicy bounds the retries of the tasks of a kind, see
+// TaskRunner.SetRetryPolicy. The zero value puts no bounds.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after which doing or
+	// undoing a task fails, aborting its lanes, or its whole change
+	// if it has none. Zero means no limit.
+	MaxRetries int
+	// Backoff is the minimum delay before the first retry. It doubles
+	// with every further retry, up to MaxBackoff or an hour if that
+	// is not set, and a random jitter of up to a quarter of it is
+	// added. The delay asked for by the handler is used if longer.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Optional marks tasks whose work may be left undone, such as
+	// cleaning up after a change. When their retries run out they
+	// are considered done, with a warning, instead of failing and
+	// undoing the rest of the change.
+	Optional bool
+}
+
+const defaultMaxBackoff = time.Hour
+
+var randDuration = func(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// delay returns the minimum delay before the given retry.
+func (p *RetryPolicy) delay(retries int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	delay := p.Backoff
+	for i := 1; i < retries && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay + randDuration(delay/4)
+}
+
 type blockedFunc func(t *Task, running []*Task) bool
 
 // TaskRunner controls the running of goroutines to execute known task kinds.
@@ -62,6 +112,8 @@ type TaskRunner struct {
 	blocked     []blockedFunc
 	someBlocked bool
 
+	retryPolicies map[string]RetryPolicy
+
 	// go-routines lifecycle
 	tombs map[string]*tomb.Tomb
 }
@@ -82,6 +134,8 @@ func NewTaskRunner(s *State) *TaskRunner {
 		handlers: make(map[string]handlerPair),
 		cleanups: make(map[string]HandlerFunc),
 		tombs:    make(map[string]*tomb.Tomb),
+
+		retryPolicies: make(map[string]RetryPolicy),
 	}
 }
 
@@ -142,6 +196,56 @@ func (r *TaskRunner) AddCleanup(kind string, cleanup HandlerFunc) {
 	r.cleanups[kind] = cleanup
 }
 
+// SetRetryPolicy sets the policy bounding the retries of the tasks of the
+// given kind. The number of retries of each task and the time of its next
+// retry are kept in the state, so the budget and backoff hold across
+// restarts. Retries asked for while the runner is
+// stopping do not count.
+func (r *TaskRunner) SetRetryPolicy(kind string, policy RetryPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.retryPolicies[kind] = policy
+}
+
+type retriesExhaustedError struct {
+	retries int
+	reason  string
+}
+
+func (e *retriesExhaustedError) Error() string {
+	return fmt.Sprintf("cannot complete task after %d retries, last retry reason: %s", e.retries, e.reason)
+}
+
+// budgetRetry counts the retry of the task and applies the retry policy of
+// its kind, returning the retry with its delay adjusted or an error if the
+// task ran out of retries. An optional task that ran out of retries is
+// given up on, returning nil. Retries are only counted, and so written to
+// the state, for kinds with a policy that bounds or backs off retries.
+func (r *TaskRunner) budgetRetry(t *Task, retry *Retry) error {
+	policy, ok := r.retryPolicies[t.Kind()]
+	if !ok || (policy.MaxRetries <= 0 && policy.Backoff <= 0) {
+		return retry
+	}
+	retries := t.countRetry()
+	if policy.MaxRetries > 0 && retries > policy.MaxRetries {
+		reason := retry.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		if policy.Optional {
+			t.Logf("Giving up after %d retries, last retry reason: %s", policy.MaxRetries, reason)
+			r.state.Warnf("cannot complete %q task of change %q after %d retries, last retry reason: %s", t.Kind(), t.Change().Summary(), policy.MaxRetries, reason)
+			return nil
+		}
+		return &retriesExhaustedError{retries: policy.MaxRetries, reason: reason}
+	}
+	if after := policy.delay(retries); after > retry.After {
+		return &Retry{After: after, Reason: retry.Reason}
+	}
+	return retry
+}
+
 // SetBlocked sets a predicate function to decide whether to block a task from running based on the current running tasks. It can be used to control task serialisation.
 func (r *TaskRunner) SetBlocked(pred func(t *Task, running []*Task) bool) {
 	r.mu.Lock()
@@ -211,11 +315,14 @@ func (r *TaskRunner) run(t *Task) {
 		}
 
 		err := tomb.Err()
-		switch err.(type) {
+		switch x := err.(type) {
 		case nil:
 			// we are ok
 		case *Retry:
-			// preserve
+			// preserve, counting it unless we are shutting down
+			if !r.stopped && t.Status() != AbortStatus {
+				err = r.budgetRetry(t, x)
+			}
 		default:
 			if r.stopped {
 				// we are shutting down, errors might be due
@@ -255,6 +362,14 @@ func (r *TaskRunner) run(t *Task) {
 			if len(next) > 0 {
 				r.state.EnsureBefore(0)
 			}
+		case *retriesExhaustedError:
+			if len(t.Lanes()) == 0 {
+				r.abortChange(t.Change())
+			} else {
+				r.abortLanes(t.Change(), t.Lanes())
+			}
+			t.SetStatus(ErrorStatus)
+			t.Errorf("%s", err)
 		default:
 			r.abortLanes(t.Change(), t.Lanes())
 			t.SetStatus(ErrorStatus)
@@ -301,6 +416,16 @@ func (r *TaskRunner) clean(t *Task) {
 
 func (r *TaskRunner) abortLanes(chg *Change, lanes []int) {
 	chg.AbortLanes(lanes)
+	r.stopAborted(chg)
+}
+
+func (r *TaskRunner) abortChange(chg *Change) {
+	chg.Abort()
+	r.stopAborted(chg)
+}
+
+// stopAborted stops the running tasks of the change that were aborted.
+func (r *TaskRunner) stopAborted(chg *Change) {
 	ensureScheduled := false
 	for _, t := range chg.Tasks() {
 		status := t.Status()
@@ -20,6 +20,8 @@
 package state_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
@@ -584,6 +586,216 @@ func (ts *taskRunnerSuite) TestRetryAfterDuration(c *C) {
 	c.Check(t.AtTime().IsZero(), Equals, true)
 }
 
+func (ts *taskRunnerSuite) TestRetryPolicy(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	restore := state.MockRetryJitter(func(max time.Duration) time.Duration { return max })
+	defer restore()
+
+	ask := 0
+	r.AddHandler("ask-for-retry", func(t *state.Task, _ *tomb.Tomb) error {
+		ask++
+		return &state.Retry{After: time.Second, Reason: "store unavailable"}
+	}, nil)
+	r.SetRetryPolicy("ask-for-retry", state.RetryPolicy{
+		MaxRetries: 2,
+		Backoff:    time.Minute,
+	})
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("ask-for-retry", "...")
+	t2 := st.NewTask("ask-for-retry", "...")
+	t2.WaitFor(t1)
+	chg.AddAll(state.NewTaskSet(t1, t2))
+	st.Unlock()
+
+	now := time.Now()
+	restore = state.MockTime(now)
+	defer restore()
+
+	// the delay before the retries doubles, with a jitter
+	for i, delay := range []time.Duration{75 * time.Second, 150 * time.Second} {
+		r.Ensure()
+		r.Wait()
+
+		st.Lock()
+		c.Check(ask, Equals, i+1)
+		c.Check(t1.Status(), Equals, state.DoingStatus)
+		c.Check(t1.DoingRetries(), Equals, i+1)
+		c.Check(t1.AtTime().Equal(now.Add(delay)), Equals, true, Commentf("retry #%d", i+1))
+		now = t1.AtTime()
+		state.MockTime(now)
+		st.Unlock()
+	}
+
+	// the budget is exhausted and the change aborted
+	r.Ensure()
+	r.Wait()
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(ask, Equals, 3)
+	c.Check(t1.Status(), Equals, state.ErrorStatus)
+	c.Check(strings.Join(t1.Log(), ""), Matches, `.*cannot complete task after 2 retries, last retry reason: store unavailable`)
+	c.Check(t2.Status(), Equals, state.HoldStatus)
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyOptional(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	ask := 0
+	r.AddHandler("ask-for-retry", func(t *state.Task, _ *tomb.Tomb) error {
+		ask++
+		return &state.Retry{Reason: "files busy"}
+	}, nil)
+	r.AddHandler("do", func(t *state.Task, _ *tomb.Tomb) error {
+		return nil
+	}, nil)
+	r.SetRetryPolicy("ask-for-retry", state.RetryPolicy{
+		MaxRetries: 1,
+		Optional:   true,
+	})
+
+	st.Lock()
+	chg := st.NewChange("refresh", "refresh snap")
+	t1 := st.NewTask("do", "...")
+	t2 := st.NewTask("ask-for-retry", "...")
+	t2.WaitFor(t1)
+	chg.AddAll(state.NewTaskSet(t1, t2))
+	st.Unlock()
+
+	for i := 0; i < 3; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	// running out of retries does not undo the change
+	st.Lock()
+	defer st.Unlock()
+	c.Check(ask, Equals, 2)
+	c.Check(t1.Status(), Equals, state.DoneStatus)
+	c.Check(t2.Status(), Equals, state.DoneStatus)
+	c.Check(strings.Join(t2.Log(), ""), Matches, `.*Giving up after 1 retries, last retry reason: files busy`)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	warnings := st.AllWarnings()
+	c.Assert(warnings, HasLen, 1)
+	c.Check(warnings[0].String(), Equals, `cannot complete "ask-for-retry" task of change "refresh snap" after 1 retries, last retry reason: files busy`)
+}
+
+func (ts *taskRunnerSuite) TestRetryWithoutPolicyNotCounted(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	r.AddHandler("ask-for-retry", func(t *state.Task, _ *tomb.Tomb) error {
+		return &state.Retry{}
+	}, nil)
+	r.AddHandler("ask-for-retry-optional", func(t *state.Task, _ *tomb.Tomb) error {
+		return &state.Retry{}
+	}, nil)
+	// a policy that neither bounds nor backs off retries
+	r.SetRetryPolicy("ask-for-retry-optional", state.RetryPolicy{
+		Optional: true,
+	})
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("ask-for-retry", "...")
+	t2 := st.NewTask("ask-for-retry-optional", "...")
+	chg.AddAll(state.NewTaskSet(t1, t2))
+	st.Unlock()
+
+	for i := 0; i < 3; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(t1.Status(), Equals, state.DoingStatus)
+	c.Check(t1.DoingRetries(), Equals, 0)
+	c.Check(t2.Status(), Equals, state.DoingStatus)
+	c.Check(t2.DoingRetries(), Equals, 0)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyAcrossRestart(c *C) {
+	restore := state.MockRetryJitter(func(max time.Duration) time.Duration { return 0 })
+	defer restore()
+	now := time.Now()
+	restore = state.MockTime(now)
+	defer restore()
+
+	newRunner := func(st *state.State, ask *int) *state.TaskRunner {
+		r := state.NewTaskRunner(st)
+		r.AddHandler("ask-for-retry", func(t *state.Task, _ *tomb.Tomb) error {
+			*ask++
+			return &state.Retry{Reason: "store unavailable"}
+		}, nil)
+		r.SetRetryPolicy("ask-for-retry", state.RetryPolicy{
+			MaxRetries: 5,
+			Backoff:    time.Minute,
+		})
+		return r
+	}
+
+	st := state.New(nil)
+	ask := 0
+	r := newRunner(st, &ask)
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("ask-for-retry", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+	r.Stop()
+	c.Check(ask, Equals, 1)
+
+	// restart from the saved state
+	st.Lock()
+	data, err := json.Marshal(st)
+	st.Unlock()
+	c.Assert(err, IsNil)
+	st, err = state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	ask = 0
+	r = newRunner(st, &ask)
+	defer r.Stop()
+
+	st.Lock()
+	t = st.Task(t.ID())
+	c.Check(t.DoingRetries(), Equals, 1)
+	c.Check(t.AtTime().Equal(now.Add(time.Minute)), Equals, true)
+	st.Unlock()
+
+	// the retry still waits for its backoff
+	r.Ensure()
+	r.Wait()
+	c.Check(ask, Equals, 0)
+
+	// and then continues where it left off
+	state.MockTime(now.Add(time.Minute))
+	r.Ensure()
+	r.Wait()
+	c.Check(ask, Equals, 1)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(t.DoingRetries(), Equals, 2)
+	c.Check(t.AtTime().Equal(now.Add(3*time.Minute)), Equals, true)
+}
+
 func (ts *taskRunnerSuite) testTaskSerialization(c *C, setupBlocked func(r *state.TaskRunner)) {
 	ensureBeforeTick := make(chan bool, 1)
 	sb := &stateBackend{
@@ -20,6 +20,8 @@
 package state
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -39,10 +41,46 @@ var (
 	errNoWarningFirstAdded  = errors.New("warning has no first-added timestamp")
 	errNoWarningExpireAfter = errors.New("warning has no expire-after duration")
 	errNoWarningRepeatAfter = errors.New("warning has no repeat-after duration")
+
+	// ErrNoWarning is returned by OkayWarning when there is no warning
+	// with the given key.
+	ErrNoWarning = errors.New("no such warning")
+)
+
+// WarningSeverity is the severity of a warning.
+type WarningSeverity string
+
+const (
+	WarningSeverityInfo     WarningSeverity = "info"
+	WarningSeverityWarning  WarningSeverity = "warning"
+	WarningSeverityCritical WarningSeverity = "critical"
 )
 
+var warningSeverityLevels = map[WarningSeverity]int{
+	WarningSeverityInfo:     0,
+	WarningSeverityWarning:  1,
+	WarningSeverityCritical: 2,
+}
+
+// ParseWarningSeverity parses the given warning severity.
+func ParseWarningSeverity(severity string) (WarningSeverity, error) {
+	sev := WarningSeverity(severity)
+	if _, ok := warningSeverityLevels[sev]; !ok {
+		return "", fmt.Errorf("invalid warning severity %q", severity)
+	}
+	return sev, nil
+}
+
+// AtLeast returns whether the severity is the same as, or higher than,
+// the other one.
+func (sev WarningSeverity) AtLeast(other WarningSeverity) bool {
+	return warningSeverityLevels[sev] >= warningSeverityLevels[other]
+}
+
 type jsonWarning struct {
+	Key         string     `json:"key,omitempty"`
 	Message     string     `json:"message"`
+	Severity    string     `json:"severity,omitempty"`
 	FirstAdded  time.Time  `json:"first-added"`
 	LastAdded   time.Time  `json:"last-added"`
 	LastShown   *time.Time `json:"last-shown,omitempty"`
@@ -53,6 +91,8 @@ type jsonWarning struct {
 type Warning struct {
 	// the warning text itself. Only one of these in the system at a time.
 	message string
+	// how important the warning is
+	severity WarningSeverity
 	// the first time one of these messages was created
 	firstAdded time.Time
 	// the last time one of these was created
@@ -69,9 +109,23 @@ func (w *Warning) String() string {
 	return w.message
 }
 
+// Key returns a short identifier of the warning, derived from its
+// message, that can be used to acknowledge it individually.
+func (w *Warning) Key() string {
+	h := sha256.Sum256([]byte(w.message))
+	return hex.EncodeToString(h[:8])
+}
+
+// Severity returns the severity of the warning.
+func (w *Warning) Severity() WarningSeverity {
+	return w.severity
+}
+
 func (w *Warning) MarshalJSON() ([]byte, error) {
 	jw := jsonWarning{
+		Key:         w.Key(),
 		Message:     w.message,
+		Severity:    string(w.severity),
 		FirstAdded:  w.firstAdded,
 		LastAdded:   w.lastAdded,
 		ExpireAfter: w.expireAfter.String(),
@@ -91,6 +145,11 @@ func (w *Warning) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	w.message = jw.Message
+	w.severity = WarningSeverity(jw.Severity)
+	if w.severity == "" {
+		// warnings from before severities were introduced
+		w.severity = WarningSeverityWarning
+	}
 	w.firstAdded = jw.FirstAdded
 	w.lastAdded = jw.LastAdded
 	if jw.LastShown != nil {
@@ -119,6 +178,9 @@ func (w *Warning) validate() (e error) {
 	if strings.TrimSpace(w.message) != w.message {
 		return errBadWarningMessage
 	}
+	if _, ok := warningSeverityLevels[w.severity]; !ok {
+		return fmt.Errorf("invalid warning severity %q", w.severity)
+	}
 	if w.firstAdded.IsZero() {
 		return errNoWarningFirstAdded
 	}
@@ -178,6 +240,12 @@ func (s *State) unflattenWarnings(flat []*Warning) {
 // current time), otherwise the existing one will have its lastAdded
 // updated.
 func (s *State) Warnf(template string, args ...interface{}) {
+	s.WarnfWithSeverity(WarningSeverityWarning, template, args...)
+}
+
+// WarnfWithSeverity records a warning like Warnf, with the given
+// severity. A warning that recurs takes the severity it recurred with.
+func (s *State) WarnfWithSeverity(severity WarningSeverity, template string, args ...interface{}) {
 	var message string
 	if len(args) > 0 {
 		message = fmt.Sprintf(template, args...)
@@ -186,6 +254,7 @@ func (s *State) Warnf(template string, args ...interface{}) {
 	}
 	s.addWarning(Warning{
 		message:     message,
+		severity:    severity,
 		expireAfter: DefaultExpireAfter,
 		repeatAfter: DefaultRepeatAfter,
 	}, time.Now().UTC())
@@ -204,6 +273,11 @@ func (s *State) addWarning(w Warning, t time.Time) {
 		s.warnings[w.message] = &w
 	}
 	s.warnings[w.message].lastAdded = t
+	s.warnings[w.message].severity = w.severity
+
+	// observers get a copy as the warning can keep changing
+	notified := *s.warnings[w.message]
+	s.Notify("warning", &notified)
 }
 
 type byLastAdded []*Warning
@@ -239,6 +313,23 @@ func (s *State) OkayWarnings(t time.Time) int {
 	return n
 }
 
+// OkayWarning marks the warning with the given key as shown at the
+// given time, whether it was showable or not. It returns ErrNoWarning
+// if there is no such warning.
+func (s *State) OkayWarning(key string, t time.Time) error {
+	t = t.UTC()
+	s.writing()
+
+	for _, w := range s.warnings {
+		if w.Key() == key {
+			w.lastShown = t
+			return nil
+		}
+	}
+
+	return ErrNoWarning
+}
+
 // PendingWarnings returns the list of warnings to show the user, sorted by
 // lastAdded, and a timestamp than can be used to refer to these warnings.
 //
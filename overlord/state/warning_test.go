@@ -40,7 +40,7 @@ func (stateSuite) testMarshalWarning(shown bool, c *check.C) {
 	st.Warnf("hello")
 	now := time.Now()
 
-	expectedNumKeys := 5
+	expectedNumKeys := 7
 	if shown {
 		expectedNumKeys++ // last-shown
 		st.OkayWarnings(now)
@@ -57,6 +57,8 @@ func (stateSuite) testMarshalWarning(shown bool, c *check.C) {
 	c.Assert(v, check.HasLen, 1)
 	c.Check(v[0], check.HasLen, expectedNumKeys)
 	c.Check(v[0]["message"], check.DeepEquals, "hello")
+	c.Check(v[0]["key"], check.Equals, ws[0].Key())
+	c.Check(v[0]["severity"], check.Equals, "warning")
 	c.Check(v[0]["expire-after"], check.Equals, state.DefaultExpireAfter.String())
 	c.Check(v[0]["repeat-after"], check.Equals, state.DefaultRepeatAfter.String())
 	c.Check(v[0]["first-added"], check.Equals, v[0]["last-added"])
@@ -114,6 +116,7 @@ func (stateSuite) TestUnmarshalErrors(c *check.C) {
 	type T2 struct{ b, e string }
 
 	for _, t := range []T2{
+		{`{"message": "x", "severity": "meh", "first-added": "2006-01-02T15:04:05Z", "expire-after": "1h", "repeat-after": "1h"}`, `invalid warning severity "meh"`},
 		// some bogus values
 		{`{"message": " ", "first-added": "2006-01-02T15:04:05Z", "expire-after": "1h", "repeat-after": "1h"}`, "malformed warning message"},
 		{`{"message": "x", "first-added": "2006",                 "expire-after": "1h", "repeat-after": "1h"}`, "parsing time .* cannot parse .*"},
@@ -265,3 +268,71 @@ func (stateSuite) TestShowAndOkayWithRepeats(c *check.C) {
 	c.Check(ws, check.HasLen, 1)
 	c.Check(fmt.Sprintf("%q", ws), check.Equals, `["hello"]`)
 }
+
+func (stateSuite) TestUnmarshalWithoutSeverity(c *check.C) {
+	var w state.Warning
+	err := json.Unmarshal([]byte(`{"message": "x", "first-added": "2006-01-02T15:04:05Z", "expire-after": "1h", "repeat-after": "1h"}`), &w)
+	c.Assert(err, check.IsNil)
+	c.Check(w.Severity(), check.Equals, state.WarningSeverityWarning)
+}
+
+func (stateSuite) TestWarningSeverity(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	st.Warnf("hello")
+	st.WarnfWithSeverity(state.WarningSeverityCritical, "disk %s is failing", "sda")
+
+	ws := st.AllWarnings()
+	c.Assert(ws, check.HasLen, 2)
+	c.Check(ws[0].String(), check.Equals, "hello")
+	c.Check(ws[0].Severity(), check.Equals, state.WarningSeverityWarning)
+	c.Check(ws[1].String(), check.Equals, "disk sda is failing")
+	c.Check(ws[1].Severity(), check.Equals, state.WarningSeverityCritical)
+
+	// a recurring warning takes the new severity
+	st.WarnfWithSeverity(state.WarningSeverityInfo, "hello")
+	ws = st.AllWarnings()
+	c.Assert(ws, check.HasLen, 2)
+	c.Check(ws[1].String(), check.Equals, "hello")
+	c.Check(ws[1].Severity(), check.Equals, state.WarningSeverityInfo)
+}
+
+func (stateSuite) TestParseWarningSeverity(c *check.C) {
+	for _, sev := range []state.WarningSeverity{state.WarningSeverityInfo, state.WarningSeverityWarning, state.WarningSeverityCritical} {
+		parsed, err := state.ParseWarningSeverity(string(sev))
+		c.Check(err, check.IsNil)
+		c.Check(parsed, check.Equals, sev)
+	}
+
+	_, err := state.ParseWarningSeverity("bad")
+	c.Check(err, check.ErrorMatches, `invalid warning severity "bad"`)
+
+	c.Check(state.WarningSeverityCritical.AtLeast(state.WarningSeverityWarning), check.Equals, true)
+	c.Check(state.WarningSeverityWarning.AtLeast(state.WarningSeverityWarning), check.Equals, true)
+	c.Check(state.WarningSeverityInfo.AtLeast(state.WarningSeverityWarning), check.Equals, false)
+}
+
+func (stateSuite) TestOkayWarningByKey(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	st.Warnf("number one")
+	st.Warnf("number two")
+	ws, _ := st.PendingWarnings()
+	c.Assert(ws, check.HasLen, 2)
+	c.Check(ws[0].Key(), check.HasLen, 16)
+	c.Check(ws[0].Key(), check.Not(check.Equals), ws[1].Key())
+
+	err := st.OkayWarning(ws[1].Key(), time.Now())
+	c.Assert(err, check.IsNil)
+
+	ws, _ = st.PendingWarnings()
+	c.Assert(ws, check.HasLen, 1)
+	c.Check(fmt.Sprintf("%q", ws), check.Equals, `["number one"]`)
+
+	err = st.OkayWarning("0123456789abcdef", time.Now())
+	c.Check(err, check.Equals, state.ErrNoWarning)
+}
@@ -246,6 +246,24 @@ func (sc *storeContext) ProxyStoreParams(defaultURL *url.URL) (proxyStoreID stri
 	return "", defaultURL, nil
 }
 
+// AssertionsProxyURL returns the URL of the assertions proxy set by
+// the proxy store assertion, if any.
+func (sc *storeContext) AssertionsProxyURL() (*url.URL, error) {
+	sc.state.Lock()
+	defer sc.state.Unlock()
+
+	sto, err := sc.proxyStoreer.ProxyStore()
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+
+	if sto != nil {
+		return sto.AssertionsURL(), nil
+	}
+
+	return nil, nil
+}
+
 // CloudInfo returns the cloud instance information (if available).
 func (sc *storeContext) CloudInfo() (*auth.CloudInfo, error) {
 	sc.state.Lock()
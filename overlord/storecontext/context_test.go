@@ -282,6 +282,7 @@ authority-id: canonical
 store: foo
 operator-id: foo-operator
 url: http://foo.internal
+assertions-url: http://assertions.foo.internal
 timestamp: 2017-11-01T10:00:00Z
 sign-key-sha3-384: Jv8_JiHiIzJVcO9M55pPdqSDWUvuhfDIBJUS-3VW7F_idjix7Ffn5qMxB21ZQuij
 
@@ -370,6 +371,10 @@ func (s *storeCtxSuite) TestMissingDeviceAssertions(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(proxyStoreID, Equals, "")
 	c.Check(proxyStoreURL, Equals, s.defURL)
+
+	assertionsProxyURL, err := storeCtx.AssertionsProxyURL()
+	c.Assert(err, IsNil)
+	c.Check(assertionsProxyURL, IsNil)
 }
 
 func (s *storeCtxSuite) TestWithDeviceAssertions(c *C) {
@@ -406,6 +411,14 @@ func (s *storeCtxSuite) TestWithDeviceAssertions(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(proxyStoreID, Equals, "foo")
 	c.Check(proxyStoreURL, DeepEquals, fooURL)
+
+	// assertions proxy
+	assertionsURL, err := url.Parse("http://assertions.foo.internal")
+	c.Assert(err, IsNil)
+
+	assertionsProxyURL, err := storeCtx.AssertionsProxyURL()
+	c.Assert(err, IsNil)
+	c.Check(assertionsProxyURL, DeepEquals, assertionsURL)
 }
 
 func (s *storeCtxSuite) TestWithDeviceAssertionsGenericClassicModel(c *C) {
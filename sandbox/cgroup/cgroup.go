@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/snapcore/snapd/dirs"
+)
+
+const (
+	// From golang.org/x/sys/unix
+	cgroup2SuperMagic = 0x63677270
+
+	// V1 is the version of the legacy or hybrid cgroup hierarchy
+	V1 = 1
+	// V2 is the version of the unified cgroup hierarchy
+	V2 = 2
+)
+
+var (
+	cgroupMountPoint = "/sys/fs/cgroup"
+	fsTypeForPath    = fsTypeForPathImpl
+	probeVersion     = probeVersionImpl
+)
+
+func fsTypeForPathImpl(path string) (int64, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return 0, fmt.Errorf("cannot statfs path: %v", err)
+	}
+	// The type of statfs.Type is int32 on 386, int64 on amd64
+	return int64(statfs.Type), nil
+}
+
+func probeVersionImpl() (int, error) {
+	typ, err := fsTypeForPath(filepath.Join(dirs.GlobalRootDir, cgroupMountPoint))
+	if err != nil {
+		return 0, err
+	}
+	if typ == cgroup2SuperMagic {
+		return V2, nil
+	}
+	return V1, nil
+}
+
+// ProbeVersion returns the version of the cgroup hierarchy mounted on the
+// system, V2 only when the unified hierarchy is mounted as the root of
+// the cgroup file system.
+func ProbeVersion() (int, error) {
+	return probeVersion()
+}
+
+// IsUnified returns whether the unified cgroup hierarchy is in use.
+func IsUnified() bool {
+	version, err := ProbeVersion()
+	return err == nil && version == V2
+}
+
+// SnapNameFromPid returns the name of the snap the given process
+// belongs to, based on the freezer cgroup it was put in.
+func SnapNameFromPid(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("%s/proc/%d/cgroup", dirs.GlobalRootDir, pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// we need to find a string like:
+		//   ...
+		//   7:freezer:/snap.hello-world
+		//   ...
+		// See cgroup(7) for details about the /proc/[pid]/cgroup
+		// format.
+		l := strings.Split(scanner.Text(), ":")
+		if len(l) < 3 {
+			continue
+		}
+		controllerList := l[1]
+		cgroupPath := l[2]
+		if !strings.Contains(controllerList, "freezer") {
+			continue
+		}
+		if strings.HasPrefix(cgroupPath, "/snap.") {
+			snap := strings.SplitN(filepath.Base(cgroupPath), ".", 2)[1]
+			return snap, nil
+		}
+	}
+	if scanner.Err() != nil {
+		return "", scanner.Err()
+	}
+
+	return "", fmt.Errorf("cannot find a snap for pid %v", pid)
+}
+
+// MockVersion mocks the version of the cgroup hierarchy reported by
+// ProbeVersion.
+func MockVersion(version int, err error) (restore func()) {
+	old := probeVersion
+	probeVersion = func() (int, error) { return version, err }
+	return func() {
+		probeVersion = old
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018-2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cgroup_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/sandbox/cgroup"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type cgroupSuite struct{}
+
+var _ = Suite(&cgroupSuite{})
+
+func (s *cgroupSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+}
+
+var mockCgroup = []byte(`
+10:devices:/user.slice
+9:cpuset:/
+8:net_cls,net_prio:/
+7:freezer:/snap.hello-world
+6:perf_event:/
+5:pids:/user.slice/user-1000.slice/user@1000.service
+4:cpu,cpuacct:/
+3:memory:/
+2:blkio:/
+1:name=systemd:/user.slice/user-1000.slice/user@1000.service/gnome-terminal-server.service
+0::/user.slice/user-1000.slice/user@1000.service/gnome-terminal-server.service
+`)
+
+func (s *cgroupSuite) TestSnapNameFromPid(c *C) {
+	root := c.MkDir()
+	dirs.SetRootDir(root)
+
+	err := os.MkdirAll(filepath.Join(root, "proc/333"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(root, "proc/333/cgroup"), mockCgroup, 0755)
+	c.Assert(err, IsNil)
+
+	snap, err := cgroup.SnapNameFromPid(333)
+	c.Assert(err, IsNil)
+	c.Check(snap, Equals, "hello-world")
+}
+
+func (s *cgroupSuite) TestSnapNameFromPidNotASnap(c *C) {
+	root := c.MkDir()
+	dirs.SetRootDir(root)
+
+	err := os.MkdirAll(filepath.Join(root, "proc/333"), 0755)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(root, "proc/333/cgroup"), []byte("7:freezer:/\n"), 0755)
+	c.Assert(err, IsNil)
+
+	_, err = cgroup.SnapNameFromPid(333)
+	c.Assert(err, ErrorMatches, "cannot find a snap for pid 333")
+}
+
+func (s *cgroupSuite) TestProbeVersion(c *C) {
+	for _, t := range []struct {
+		fsType    int64
+		version   int
+		isUnified bool
+	}{
+		{cgroup.Cgroup2SuperMagic, cgroup.V2, true},
+		// tmpfs, as mounted for the legacy and hybrid hierarchies
+		{0x01021994, cgroup.V1, false},
+	} {
+		restore := cgroup.MockFsTypeForPath(func(path string) (int64, error) {
+			c.Check(path, Equals, "/sys/fs/cgroup")
+			return t.fsType, nil
+		})
+		version, err := cgroup.ProbeVersion()
+		c.Check(err, IsNil)
+		c.Check(version, Equals, t.version)
+		c.Check(cgroup.IsUnified(), Equals, t.isUnified)
+		restore()
+	}
+}
+
+func (s *cgroupSuite) TestProbeVersionError(c *C) {
+	restore := cgroup.MockFsTypeForPath(func(path string) (int64, error) {
+		return 0, errors.New("boom")
+	})
+	defer restore()
+
+	_, err := cgroup.ProbeVersion()
+	c.Check(err, ErrorMatches, "boom")
+	c.Check(cgroup.IsUnified(), Equals, false)
+}
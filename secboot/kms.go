@@ -0,0 +1,240 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/httputil"
+)
+
+// KMSRequestSigner returns an encoded device-session-request assertion,
+// signed with the device key, with the given nonce.
+type KMSRequestSigner func(nonce string) ([]byte, error)
+
+var (
+	kmsSignerMu sync.Mutex
+	kmsSigner   KMSRequestSigner
+)
+
+// SetKMSRequestSigner sets the signer authenticating the device to key
+// management services, see kmsProtector.
+func SetKMSRequestSigner(signer KMSRequestSigner) {
+	kmsSignerMu.Lock()
+	defer kmsSignerMu.Unlock()
+	kmsSigner = signer
+}
+
+func kmsRequestSigner() KMSRequestSigner {
+	kmsSignerMu.Lock()
+	defer kmsSignerMu.Unlock()
+	return kmsSigner
+}
+
+// kmsProtector protects keys by having them wrapped by a remote key
+// management service, so that the encrypted data partitions can only
+// be unlocked while the service agrees to unwrap them.
+//
+// The service is reached over https and authenticated by the sha256
+// digest of its certificate, as set with the server-cert-sha256 option,
+// rather than by the system certificate authorities. The device
+// authenticates its requests with a device-session-request assertion
+// signed with its device key, whose nonce is the hex encoded sha3-384
+// digest of the request body, passed base64 encoded in the
+// Snap-Device-Session-Request header.
+//
+// The service is expected to implement the following, with keys and
+// wrapped keys encoded in base64 and binding being the hex encoded
+// digest of the parameters the key is bound to:
+//   - POST <url>/wrap, taking {"brand-id": ..., "model": ...,
+//     "boot-assets": ..., "binding": ..., "key": ...} and returning
+//     {"wrapped": ...}
+//   - POST <url>/unwrap, taking {"wrapped": ..., "binding": ...} and
+//     returning {"key": ...}
+//   - POST <url>/rewrap, taking {"wrapped": ..., "binding": ...,
+//     "brand-id": ..., "model": ..., "boot-assets": ...,
+//     "new-binding": ...} and returning {"wrapped": ...}, so that keys
+//     are not sent again when resealed
+type kmsProtector struct {
+	url    string
+	client *http.Client
+}
+
+func newKMSProtector(options map[string]string) (KeyProtector, error) {
+	u, err := url.Parse(options["url"])
+	if err != nil || u.Host == "" || u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid key management service URL %q", options["url"])
+	}
+	pin, err := hex.DecodeString(options["server-cert-sha256"])
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("invalid key management service certificate digest %q", options["server-cert-sha256"])
+	}
+	tlsConfig := &tls.Config{
+		// the server certificate is checked against the pinned
+		// digest instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("key management service sent no certificate")
+			}
+			digest := sha256.Sum256(rawCerts[0])
+			if subtle.ConstantTimeCompare(digest[:], pin) != 1 {
+				return fmt.Errorf("key management service certificate does not match the pinned digest")
+			}
+			return nil
+		},
+	}
+	return &kmsProtector{
+		url: strings.TrimSuffix(u.String(), "/"),
+		client: httputil.NewHTTPClient(&httputil.ClientOptions{
+			Timeout:   30 * time.Second,
+			TLSConfig: tlsConfig,
+		}),
+	}, nil
+}
+
+func (kp *kmsProtector) Available() error {
+	if kmsRequestSigner() == nil {
+		return fmt.Errorf("no device key to authenticate to the key management service")
+	}
+	return nil
+}
+
+type kmsRequest struct {
+	BrandID    string            `json:"brand-id,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	BootAssets map[string]string `json:"boot-assets,omitempty"`
+	Binding    string            `json:"binding"`
+	NewBinding string            `json:"new-binding,omitempty"`
+	Key        []byte            `json:"key,omitempty"`
+	Wrapped    []byte            `json:"wrapped,omitempty"`
+}
+
+func (req *kmsRequest) setParams(params *SealParams) {
+	if params == nil {
+		return
+	}
+	req.BrandID = params.BrandID
+	req.Model = params.Model
+	req.BootAssets = params.BootAssets
+}
+
+type kmsResponse struct {
+	Key     []byte `json:"key"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+func (kp *kmsProtector) do(op string, req *kmsRequest) (*kmsResponse, error) {
+	signer := kmsRequestSigner()
+	if signer == nil {
+		return nil, fmt.Errorf("no device key to authenticate to the key management service")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	h := crypto.SHA3_384.New()
+	h.Write(body)
+	sessionRequest, err := signer(hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign key management service request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", kp.url+"/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Snap-Device-Session-Request", base64.StdEncoding.EncodeToString(sessionRequest))
+	rsp, err := kp.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach key management service: %v", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key management service refused to %s key: %s", op, rsp.Status)
+	}
+	var kmsRsp kmsResponse
+	if err := json.NewDecoder(rsp.Body).Decode(&kmsRsp); err != nil {
+		return nil, fmt.Errorf("cannot decode key management service response: %v", err)
+	}
+	return &kmsRsp, nil
+}
+
+func (kp *kmsProtector) Protect(key []byte, params *SealParams) ([]byte, error) {
+	req := &kmsRequest{
+		Binding: hex.EncodeToString(params.digest()),
+		Key:     key,
+	}
+	req.setParams(params)
+	rsp, err := kp.do("wrap", req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Wrapped) == 0 {
+		return nil, fmt.Errorf("key management service returned no wrapped key")
+	}
+	return rsp.Wrapped, nil
+}
+
+func (kp *kmsProtector) Unprotect(data []byte, params *SealParams) ([]byte, error) {
+	rsp, err := kp.do("unwrap", &kmsRequest{
+		Binding: hex.EncodeToString(params.digest()),
+		Wrapped: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Key) == 0 {
+		return nil, fmt.Errorf("key management service returned no key")
+	}
+	return rsp.Key, nil
+}
+
+func (kp *kmsProtector) Reseal(data []byte, current, params *SealParams) ([]byte, error) {
+	req := &kmsRequest{
+		Binding:    hex.EncodeToString(current.digest()),
+		NewBinding: hex.EncodeToString(params.digest()),
+		Wrapped:    data,
+	}
+	req.setParams(params)
+	rsp, err := kp.do("rewrap", req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rsp.Wrapped) == 0 {
+		return nil, fmt.Errorf("key management service returned no wrapped key")
+	}
+	return rsp.Wrapped, nil
+}
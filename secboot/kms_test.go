@@ -0,0 +1,212 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/secboot"
+)
+
+type kmsSuite struct {
+	baseSecbootSuite
+
+	server *httptest.Server
+	denied bool
+	reqs   []map[string]interface{}
+	params *secboot.SealParams
+}
+
+var _ = Suite(&kmsSuite{})
+
+func (s *kmsSuite) SetUpTest(c *C) {
+	s.baseSecbootSuite.SetUpTest(c)
+	s.denied = false
+	s.reqs = nil
+	s.params = &secboot.SealParams{
+		BrandID:    "my-brand",
+		Model:      "my-model",
+		BootAssets: map[string]string{"/boot/grub/grubx64.efi": "digest"},
+	}
+
+	secboot.SetKMSRequestSigner(func(nonce string) ([]byte, error) {
+		return []byte("device-session-request with nonce " + nonce), nil
+	})
+	s.restore = append(s.restore, func() { secboot.SetKMSRequestSigner(nil) })
+
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		body, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		// the request is signed by the device
+		sessionRequest, err := base64.StdEncoding.DecodeString(r.Header.Get("Snap-Device-Session-Request"))
+		c.Assert(err, IsNil)
+		digest := sha3.Sum384(body)
+		c.Check(string(sessionRequest), Equals, "device-session-request with nonce "+hex.EncodeToString(digest[:]))
+
+		var req map[string]interface{}
+		c.Assert(json.Unmarshal(body, &req), IsNil)
+		if s.denied {
+			w.WriteHeader(403)
+			return
+		}
+		s.reqs = append(s.reqs, req)
+		// the test service just "wraps" by prefixing the key with
+		// the binding
+		switch r.URL.Path {
+		case "/v1/wrap":
+			json.NewEncoder(w).Encode(map[string]interface{}{"wrapped": []byte(fmt.Sprintf("%s:%s", req["binding"], req["key"]))})
+		case "/v1/unwrap":
+			var wrapped []byte
+			c.Assert(json.Unmarshal([]byte(fmt.Sprintf("%q", req["wrapped"])), &wrapped), IsNil)
+			parts := strings.SplitN(string(wrapped), ":", 2)
+			if parts[0] != req["binding"] {
+				w.WriteHeader(403)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"key": parts[1]})
+		case "/v1/rewrap":
+			var wrapped []byte
+			c.Assert(json.Unmarshal([]byte(fmt.Sprintf("%q", req["wrapped"])), &wrapped), IsNil)
+			parts := strings.SplitN(string(wrapped), ":", 2)
+			c.Assert(parts[0], Equals, req["binding"])
+			json.NewEncoder(w).Encode(map[string]interface{}{"wrapped": []byte(fmt.Sprintf("%s:%s", req["new-binding"], parts[1]))})
+		default:
+			c.Errorf("unexpected path %q", r.URL.Path)
+		}
+	}))
+}
+
+func (s *kmsSuite) TearDownTest(c *C) {
+	s.server.Close()
+	s.baseSecbootSuite.TearDownTest(c)
+}
+
+func (s *kmsSuite) kpc() *gadget.KeyProtector {
+	digest := sha256.Sum256(s.server.Certificate().Raw)
+	return &gadget.KeyProtector{
+		Type: "kms",
+		Options: map[string]string{
+			"url":                s.server.URL + "/v1/",
+			"server-cert-sha256": hex.EncodeToString(digest[:]),
+		},
+	}
+}
+
+func (s *kmsSuite) TestHappy(c *C) {
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), s.kpc(), s.params, keyFile)
+	c.Assert(err, IsNil)
+	c.Assert(s.reqs, HasLen, 1)
+	binding := s.reqs[0]["binding"]
+	c.Check(binding, HasLen, 96)
+	c.Check(s.reqs[0], DeepEquals, map[string]interface{}{
+		"brand-id":    "my-brand",
+		"model":       "my-model",
+		"boot-assets": map[string]interface{}{"/boot/grub/grubx64.efi": "digest"},
+		"binding":     binding,
+		// base64 of "secret"
+		"key": "c2VjcmV0",
+	})
+
+	key, err := secboot.UnsealKey(keyFile, s.params)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+}
+
+func (s *kmsSuite) TestResealDoesNotSendKey(c *C) {
+	keyFile := secboot.DataKeyFile()
+	err := secboot.SealKey([]byte("secret"), s.kpc(), s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	newParams := &secboot.SealParams{
+		BrandID:    "my-brand",
+		Model:      "my-model",
+		BootAssets: map[string]string{"/boot/grub/grubx64.efi": "new-digest"},
+	}
+	err = secboot.ResealKey(keyFile, s.params, newParams)
+	c.Assert(err, IsNil)
+	c.Assert(s.reqs, HasLen, 2)
+	c.Check(s.reqs[1]["key"], IsNil)
+	c.Check(s.reqs[1]["binding"], Equals, s.reqs[0]["binding"])
+	c.Check(s.reqs[1]["new-binding"], Not(Equals), s.reqs[0]["binding"])
+	c.Check(s.reqs[1]["boot-assets"], DeepEquals, map[string]interface{}{"/boot/grub/grubx64.efi": "new-digest"})
+
+	_, err = secboot.UnsealKey(keyFile, newParams)
+	c.Assert(err, IsNil)
+	_, err = secboot.UnsealKey(keyFile, s.params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "kms": key management service refused to unwrap key: 403 Forbidden`)
+}
+
+func (s *kmsSuite) TestDenied(c *C) {
+	keyFile := secboot.DataKeyFile()
+	err := secboot.SealKey([]byte("secret"), s.kpc(), s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	s.denied = true
+	_, err = secboot.UnsealKey(keyFile, s.params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "kms": key management service refused to unwrap key: 403 Forbidden`)
+}
+
+func (s *kmsSuite) TestCertificateMismatch(c *C) {
+	kpc := s.kpc()
+	kpc.Options["server-cert-sha256"] = hex.EncodeToString(make([]byte, 32))
+
+	err := secboot.SealKey([]byte("secret"), kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot protect key with "kms": cannot reach key management service: .*certificate does not match the pinned digest`)
+	c.Check(s.reqs, HasLen, 0)
+}
+
+func (s *kmsSuite) TestNoSigner(c *C) {
+	secboot.SetKMSRequestSigner(nil)
+
+	err := secboot.SealKey([]byte("secret"), s.kpc(), s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `key protector "kms" is not available: no device key to authenticate to the key management service`)
+}
+
+func (s *kmsSuite) TestInvalidOptions(c *C) {
+	for _, u := range []string{"", "ftp://kms.example.com", "/relative", "http://kms.example.com"} {
+		err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{
+			Type:    "kms",
+			Options: map[string]string{"url": u},
+		}, s.params, secboot.DataKeyFile())
+		c.Check(err, ErrorMatches, `cannot use key protector "kms": invalid key management service URL ".*"`)
+	}
+	for _, digest := range []string{"", "abcd", "not-hex"} {
+		err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{
+			Type:    "kms",
+			Options: map[string]string{"url": "https://kms.example.com", "server-cert-sha256": digest},
+		}, s.params, secboot.DataKeyFile())
+		c.Check(err, ErrorMatches, `cannot use key protector "kms": invalid key management service certificate digest ".*"`)
+	}
+}
@@ -0,0 +1,289 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package secboot implements the protection of the keys of encrypted
+// data partitions with pluggable key protectors, e.g. a TPM, a file on
+// a removable token or a remote key management service, as selected by
+// the gadget.
+package secboot
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	_ "golang.org/x/crypto/sha3" // for the binding digest
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// SealParams holds what a key is bound to: it can only be recovered
+// given the same parameters, i.e. on a device of the same model booted
+// with the same boot assets.
+type SealParams struct {
+	// BrandID and Model identify the model of the device.
+	BrandID string
+	Model   string
+	// BootAssets are the sha3-384 digests of the boot assets, keyed
+	// by their path.
+	BootAssets map[string]string
+	// KernelCmdline is the kernel command line provided by the
+	// gadget, if any.
+	KernelCmdline *gadget.KernelCmdline
+}
+
+// digest returns the sha3-384 digest of the parameters, which the key
+// protectors bind keys to.
+func (p *SealParams) digest() []byte {
+	h := crypto.SHA3_384.New()
+	if p == nil {
+		return h.Sum(nil)
+	}
+	fmt.Fprintf(h, "brand-id: %s\nmodel: %s\n", p.BrandID, p.Model)
+	paths := make([]string, 0, len(p.BootAssets))
+	for path := range p.BootAssets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(h, "boot-asset: %s %s\n", path, p.BootAssets[path])
+	}
+	// left out when unset so that keys bound before the command line
+	// was part of the parameters still match
+	if cmdline := p.KernelCmdline; cmdline != nil {
+		if cmdline.Extra != "" {
+			fmt.Fprintf(h, "kernel-cmdline-extra: %s\n", cmdline.Extra)
+		}
+		if cmdline.Full != "" {
+			fmt.Fprintf(h, "kernel-cmdline-full: %s\n", cmdline.Full)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// KeyProtector protects encryption keys.
+type KeyProtector interface {
+	// Available returns an error if the protector cannot be used on
+	// this device, e.g. because the hardware it needs is missing.
+	Available() error
+	// Protect protects the key, binding it to the given parameters,
+	// and returns the data to recover it from.
+	Protect(key []byte, params *SealParams) ([]byte, error)
+	// Unprotect recovers the key from the data returned by Protect,
+	// given the parameters it was bound to.
+	Unprotect(data []byte, params *SealParams) ([]byte, error)
+}
+
+// Resealer is implemented by key protectors that can bind protected
+// keys to new parameters without the key leaving their custody, e.g. a
+// remote service. Keys of other protectors are resealed by
+// recovering and protecting them again.
+type Resealer interface {
+	Reseal(data []byte, current, params *SealParams) ([]byte, error)
+}
+
+// KeyProtectorFactory creates a key protector with the given options,
+// as set for it in the gadget.
+type KeyProtectorFactory func(options map[string]string) (KeyProtector, error)
+
+var (
+	protectorsMu sync.Mutex
+	protectors   = map[string]KeyProtectorFactory{
+		"tpm2":  newTPM2Protector,
+		"token": newTokenProtector,
+		"kms":   newKMSProtector,
+	}
+)
+
+// RegisterKeyProtector registers the factory of the key protectors of
+// the given type. It panics if the type is already registered.
+func RegisterKeyProtector(protectorType string, factory KeyProtectorFactory) (unregister func()) {
+	protectorsMu.Lock()
+	defer protectorsMu.Unlock()
+	if _, ok := protectors[protectorType]; ok {
+		panic(fmt.Sprintf("key protector %q is already registered", protectorType))
+	}
+	protectors[protectorType] = factory
+	return func() {
+		protectorsMu.Lock()
+		defer protectorsMu.Unlock()
+		delete(protectors, protectorType)
+	}
+}
+
+// KeyProtectorTypes returns the sorted types of the registered key
+// protectors.
+func KeyProtectorTypes() []string {
+	protectorsMu.Lock()
+	defer protectorsMu.Unlock()
+	types := make([]string, 0, len(protectors))
+	for protectorType := range protectors {
+		types = append(types, protectorType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func newKeyProtector(protectorType string, options map[string]string) (KeyProtector, error) {
+	protectorsMu.Lock()
+	factory := protectors[protectorType]
+	protectorsMu.Unlock()
+	if factory == nil {
+		return nil, fmt.Errorf("unknown key protector %q", protectorType)
+	}
+	kp, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use key protector %q: %v", protectorType, err)
+	}
+	return kp, nil
+}
+
+// SelectKeyProtector returns the first of the given key protectors, as
+// listed in the gadget, that is available on this device.
+func SelectKeyProtector(enc *gadget.Encryption) (*gadget.KeyProtector, error) {
+	if enc == nil || len(enc.KeyProtectors) == 0 {
+		return nil, fmt.Errorf("no key protectors")
+	}
+	for i := range enc.KeyProtectors {
+		kpc := &enc.KeyProtectors[i]
+		kp, err := newKeyProtector(kpc.Type, kpc.Options)
+		if err != nil {
+			// the gadget may list protectors that are only
+			// supported by some devices
+			continue
+		}
+		if kp.Available() == nil {
+			return kpc, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the key protectors is available")
+}
+
+// sealedKey is the on-disk representation of a protected key.
+type sealedKey struct {
+	Protector string            `json:"protector"`
+	Options   map[string]string `json:"options,omitempty"`
+	Data      []byte            `json:"data"`
+}
+
+// DataKeyFile returns the path of the file holding the protected key of
+// the encrypted data partition.
+func DataKeyFile() string {
+	return filepath.Join(dirs.SnapFDEDir, "data.sealed-key")
+}
+
+func readSealedKey(keyFile string) (*sealedKey, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var sk sealedKey
+	if err := json.Unmarshal(content, &sk); err != nil {
+		return nil, fmt.Errorf("cannot decode sealed key: %v", err)
+	}
+	return &sk, nil
+}
+
+func writeSealedKey(keyFile string, sk *sealedKey) error {
+	content, err := json.Marshal(sk)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(keyFile, content, 0600, 0)
+}
+
+// SealKey protects the key with the given key protector, binding it to
+// the given parameters if supported, and writes the result to keyFile.
+func SealKey(key []byte, kpc *gadget.KeyProtector, params *SealParams, keyFile string) error {
+	kp, err := newKeyProtector(kpc.Type, kpc.Options)
+	if err != nil {
+		return err
+	}
+	if err := kp.Available(); err != nil {
+		return fmt.Errorf("key protector %q is not available: %v", kpc.Type, err)
+	}
+	data, err := kp.Protect(key, params)
+	if err != nil {
+		return fmt.Errorf("cannot protect key with %q: %v", kpc.Type, err)
+	}
+	return writeSealedKey(keyFile, &sealedKey{
+		Protector: kpc.Type,
+		Options:   kpc.Options,
+		Data:      data,
+	})
+}
+
+// UnsealKey recovers the key written to keyFile by SealKey, given the
+// parameters it was bound to.
+func UnsealKey(keyFile string, params *SealParams) ([]byte, error) {
+	sk, err := readSealedKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read sealed key: %v", err)
+	}
+	kp, err := newKeyProtector(sk.Protector, sk.Options)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kp.Unprotect(sk.Data, params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unprotect key with %q: %v", sk.Protector, err)
+	}
+	return key, nil
+}
+
+// ResealKey binds the key written to keyFile by SealKey, currently bound
+// to the current parameters, to the given ones, e.g. after the boot
+// assets were updated.
+func ResealKey(keyFile string, current, params *SealParams) error {
+	sk, err := readSealedKey(keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read sealed key: %v", err)
+	}
+	kp, err := newKeyProtector(sk.Protector, sk.Options)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if resealer, ok := kp.(Resealer); ok {
+		data, err = resealer.Reseal(sk.Data, current, params)
+	} else {
+		var key []byte
+		key, err = kp.Unprotect(sk.Data, current)
+		if err == nil {
+			data, err = kp.Protect(key, params)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot reseal key with %q: %v", sk.Protector, err)
+	}
+
+	sk.Data = data
+	return writeSealedKey(keyFile, sk)
+}
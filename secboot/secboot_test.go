@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+// baseSecbootSuite sets up the common test environment
+type baseSecbootSuite struct {
+	restore []func()
+}
+
+func (s *baseSecbootSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	s.restore = nil
+}
+
+func (s *baseSecbootSuite) TearDownTest(c *C) {
+	for _, restore := range s.restore {
+		restore()
+	}
+	dirs.SetRootDir("")
+}
+
+// mockProtector protects keys by prefixing them, recording the
+// parameters they were bound to and checking them when unprotecting.
+type mockProtector struct {
+	options   map[string]string
+	available error
+	params    *secboot.SealParams
+}
+
+func (mp *mockProtector) Available() error { return mp.available }
+
+func (mp *mockProtector) Protect(key []byte, params *secboot.SealParams) ([]byte, error) {
+	mp.params = params
+	return append([]byte(mp.options["prefix"]), key...), nil
+}
+
+func (mp *mockProtector) Unprotect(data []byte, params *secboot.SealParams) ([]byte, error) {
+	if !reflect.DeepEqual(params, mp.params) {
+		return nil, fmt.Errorf("bad params")
+	}
+	if !bytes.HasPrefix(data, []byte(mp.options["prefix"])) {
+		return nil, fmt.Errorf("bad prefix")
+	}
+	return data[len(mp.options["prefix"]):], nil
+}
+
+type mockResealer struct {
+	mockProtector
+	resealed int
+}
+
+func (mr *mockResealer) Reseal(data []byte, current, params *secboot.SealParams) ([]byte, error) {
+	if !reflect.DeepEqual(current, mr.params) {
+		return nil, fmt.Errorf("bad params")
+	}
+	mr.params = params
+	mr.resealed++
+	return append(data, '!'), nil
+}
+
+func (s *baseSecbootSuite) register(c *C, protectorType string, kp secboot.KeyProtector) {
+	s.restore = append(s.restore, secboot.RegisterKeyProtector(protectorType, func(options map[string]string) (secboot.KeyProtector, error) {
+		if options["fail"] != "" {
+			return nil, fmt.Errorf("%s", options["fail"])
+		}
+		if mp, ok := kp.(*mockProtector); ok {
+			mp.options = options
+		}
+		return kp, nil
+	}))
+}
+
+type secbootSuite struct {
+	baseSecbootSuite
+}
+
+var _ = Suite(&secbootSuite{})
+
+func (s *secbootSuite) TestRegisterKeyProtector(c *C) {
+	c.Check(secboot.KeyProtectorTypes(), DeepEquals, []string{"kms", "token", "tpm2"})
+
+	s.register(c, "other", &mockProtector{})
+	c.Check(secboot.KeyProtectorTypes(), DeepEquals, []string{"kms", "other", "token", "tpm2"})
+
+	c.Check(func() { s.register(c, "tpm2", &mockProtector{}) }, PanicMatches, `key protector "tpm2" is already registered`)
+}
+
+func (s *secbootSuite) TestSelectKeyProtector(c *C) {
+	s.register(c, "hsm", &mockProtector{available: fmt.Errorf("no HSM")})
+	s.register(c, "other", &mockProtector{})
+
+	enc := &gadget.Encryption{
+		KeyProtectors: []gadget.KeyProtector{
+			{Type: "hsm"},
+			{Type: "unknown"},
+			{Type: "other", Options: map[string]string{"fail": "bad options"}},
+			{Type: "other", Options: map[string]string{"prefix": "x"}},
+		},
+	}
+	kpc, err := secboot.SelectKeyProtector(enc)
+	c.Assert(err, IsNil)
+	c.Check(kpc, Equals, &enc.KeyProtectors[3])
+
+	_, err = secboot.SelectKeyProtector(&gadget.Encryption{
+		KeyProtectors: enc.KeyProtectors[:3],
+	})
+	c.Check(err, ErrorMatches, "none of the key protectors is available")
+
+	_, err = secboot.SelectKeyProtector(nil)
+	c.Check(err, ErrorMatches, "no key protectors")
+}
+
+func (s *secbootSuite) TestSealUnsealResealKey(c *C) {
+	mp := &mockProtector{}
+	s.register(c, "mock", mp)
+	keyFile := secboot.DataKeyFile()
+	c.Check(keyFile, Equals, filepath.Join(dirs.SnapDeviceDir, "fde", "data.sealed-key"))
+
+	kpc := &gadget.KeyProtector{Type: "mock", Options: map[string]string{"prefix": "mock:"}}
+	params := &secboot.SealParams{
+		BrandID:    "my-brand",
+		Model:      "my-model",
+		BootAssets: map[string]string{"/boot/grub/grub.cfg": "digest"},
+	}
+	err := secboot.SealKey([]byte("secret"), kpc, params, keyFile)
+	c.Assert(err, IsNil)
+	c.Check(keyFile, testutil.FileContains, `"protector":"mock","options":{"prefix":"mock:"}`)
+	c.Check(mp.params, DeepEquals, params)
+
+	key, err := secboot.UnsealKey(keyFile, params)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	newParams := &secboot.SealParams{
+		BrandID: "my-brand",
+		Model:   "my-model",
+		BootAssets: map[string]string{
+			"/boot/grub/grub.cfg": "new-digest",
+			"/boot/grub/grubx64":  "digest",
+		},
+	}
+	err = secboot.ResealKey(keyFile, params, newParams)
+	c.Assert(err, IsNil)
+	c.Check(mp.params, DeepEquals, newParams)
+
+	key, err = secboot.UnsealKey(keyFile, newParams)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	_, err = secboot.UnsealKey(keyFile, params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "mock": bad params`)
+}
+
+func (s *secbootSuite) TestResealKeyWithResealer(c *C) {
+	mr := &mockResealer{}
+	s.register(c, "mock", mr)
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{Type: "mock"}, &secboot.SealParams{}, keyFile)
+	c.Assert(err, IsNil)
+
+	err = secboot.ResealKey(keyFile, &secboot.SealParams{}, &secboot.SealParams{Model: "new"})
+	c.Assert(err, IsNil)
+	c.Check(mr.resealed, Equals, 1)
+
+	key, err := secboot.UnsealKey(keyFile, &secboot.SealParams{Model: "new"})
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret!")
+}
+
+func (s *secbootSuite) TestSealKeyErrors(c *C) {
+	s.register(c, "unavailable", &mockProtector{available: fmt.Errorf("no hardware")})
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{Type: "unavailable"}, &secboot.SealParams{}, keyFile)
+	c.Check(err, ErrorMatches, `key protector "unavailable" is not available: no hardware`)
+
+	err = secboot.SealKey([]byte("secret"), &gadget.KeyProtector{Type: "unknown"}, &secboot.SealParams{}, keyFile)
+	c.Check(err, ErrorMatches, `unknown key protector "unknown"`)
+
+	_, err = secboot.UnsealKey(keyFile, &secboot.SealParams{})
+	c.Check(err, ErrorMatches, "cannot read sealed key: .* no such file or directory")
+
+	err = secboot.ResealKey(keyFile, &secboot.SealParams{}, &secboot.SealParams{})
+	c.Check(err, ErrorMatches, "cannot read sealed key: .* no such file or directory")
+}
@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+const tokenKeySize = 32
+
+// tokenProtector protects keys by encrypting them with a wrapping key
+// kept in a file on a removable token, so that the encrypted data
+// partitions can only be unlocked while the token is plugged in. The
+// wrapping key is provisioned on the token beforehand, it is never
+// created on the device.
+type tokenProtector struct {
+	path string
+}
+
+func newTokenProtector(options map[string]string) (KeyProtector, error) {
+	path := options["path"]
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("token path must be absolute, not %q", path)
+	}
+	return &tokenProtector{path: filepath.Clean(path)}, nil
+}
+
+// tokenMount returns the mount entry of the filesystem holding the
+// given path.
+func tokenMount(path string) (*osutil.MountInfoEntry, error) {
+	mountInfo, err := osutil.LoadMountInfo(filepath.Join(dirs.GlobalRootDir, osutil.ProcSelfMountInfo))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read mount info: %v", err)
+	}
+	var mount *osutil.MountInfoEntry
+	for _, entry := range mountInfo {
+		if entry.MountDir != "/" && path != entry.MountDir && !strings.HasPrefix(path, entry.MountDir+"/") {
+			continue
+		}
+		// the last of the longest matches is the visible one
+		if mount == nil || len(entry.MountDir) >= len(mount.MountDir) {
+			mount = entry
+		}
+	}
+	if mount == nil {
+		return nil, fmt.Errorf("cannot find mount of %q", path)
+	}
+	return mount, nil
+}
+
+// isRemovable returns whether the block device with the given numbers,
+// or the disk it is a partition of, is removable.
+func isRemovable(major, minor int) (bool, error) {
+	devPath, err := filepath.EvalSymlinks(filepath.Join(dirs.SysfsDir, "dev/block", fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return false, err
+	}
+	if osutil.FileExists(filepath.Join(devPath, "partition")) {
+		devPath = filepath.Dir(devPath)
+	}
+	removable, err := ioutil.ReadFile(filepath.Join(devPath, "removable"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(removable)) == "1", nil
+}
+
+func (tp *tokenProtector) Available() error {
+	mount, err := tokenMount(filepath.Dir(tp.path))
+	if err != nil {
+		return err
+	}
+	if mount.MountDir == "/" {
+		return fmt.Errorf("token not mounted at %q", filepath.Dir(tp.path))
+	}
+	removable, err := isRemovable(mount.DevMajor, mount.DevMinor)
+	if err != nil {
+		return fmt.Errorf("cannot check token device: %v", err)
+	}
+	if !removable {
+		return fmt.Errorf("token at %q is not a removable device", mount.MountDir)
+	}
+	if !osutil.FileExists(tp.path) {
+		return fmt.Errorf("token has no key at %q", tp.path)
+	}
+	return nil
+}
+
+func (tp *tokenProtector) wrappingKey() ([]byte, error) {
+	if err := tp.Available(); err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(tp.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token key: %v", err)
+	}
+	if len(key) != tokenKeySize {
+		return nil, fmt.Errorf("invalid token key")
+	}
+	return key, nil
+}
+
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (tp *tokenProtector) Protect(key []byte, params *SealParams) ([]byte, error) {
+	wrappingKey, err := tp.wrappingKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newTokenAEAD(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, params.digest()), nil
+}
+
+func (tp *tokenProtector) Unprotect(data []byte, params *SealParams) ([]byte, error) {
+	wrappingKey, err := tp.wrappingKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newTokenAEAD(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("protected key too short")
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], params.digest())
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt key with the token: %v", err)
+	}
+	return key, nil
+}
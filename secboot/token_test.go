@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/secboot"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type tokenSuite struct {
+	baseSecbootSuite
+
+	tokenDir string
+	kpc      *gadget.KeyProtector
+	params   *secboot.SealParams
+}
+
+var _ = Suite(&tokenSuite{})
+
+func (s *tokenSuite) SetUpTest(c *C) {
+	s.baseSecbootSuite.SetUpTest(c)
+
+	s.tokenDir = c.MkDir()
+	s.kpc = &gadget.KeyProtector{
+		Type:    "token",
+		Options: map[string]string{"path": filepath.Join(s.tokenDir, "fde-key")},
+	}
+	s.params = &secboot.SealParams{
+		BrandID:    "my-brand",
+		Model:      "my-model",
+		BootAssets: map[string]string{"/boot/grub/grubx64.efi": "digest"},
+	}
+
+	// the token is the first partition of a removable disk
+	s.mockBlockDevice(c, "8:17", "sdb", "sdb1", "1")
+	s.mockMountInfo(c, "8:17", s.tokenDir)
+	// provisioned with a wrapping key
+	c.Assert(ioutil.WriteFile(filepath.Join(s.tokenDir, "fde-key"), []byte("0123456789abcdef0123456789abcdef"), 0600), IsNil)
+}
+
+func (s *tokenSuite) mockBlockDevice(c *C, majMin, disk, part, removable string) {
+	diskDir := filepath.Join(dirs.SysfsDir, "devices/pci0000:00/usb1", disk)
+	partDir := filepath.Join(diskDir, part)
+	c.Assert(os.MkdirAll(partDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(partDir, "partition"), []byte("1\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(diskDir, "removable"), []byte(removable+"\n"), 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SysfsDir, "dev/block"), 0755), IsNil)
+	c.Assert(os.Symlink(partDir, filepath.Join(dirs.SysfsDir, "dev/block", majMin)), IsNil)
+}
+
+func (s *tokenSuite) mockMountInfo(c *C, majMin, mountDir string) {
+	mountInfo := filepath.Join(dirs.GlobalRootDir, osutil.ProcSelfMountInfo)
+	c.Assert(os.MkdirAll(filepath.Dir(mountInfo), 0755), IsNil)
+	content := "26 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw\n"
+	if mountDir != "" {
+		content += fmt.Sprintf("100 26 %s / %s rw,relatime shared:50 - vfat /dev/sdb1 rw\n", majMin, mountDir)
+	}
+	c.Assert(ioutil.WriteFile(mountInfo, []byte(content), 0644), IsNil)
+}
+
+func (s *tokenSuite) TestHappy(c *C) {
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	key, err := secboot.UnsealKey(keyFile, s.params)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	newParams := &secboot.SealParams{
+		BrandID:    "my-brand",
+		Model:      "my-model",
+		BootAssets: map[string]string{"/boot/grub/grubx64.efi": "new-digest"},
+	}
+	err = secboot.ResealKey(keyFile, s.params, newParams)
+	c.Assert(err, IsNil)
+
+	key, err = secboot.UnsealKey(keyFile, newParams)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	// the key is bound to the parameters
+	_, err = secboot.UnsealKey(keyFile, s.params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "token": cannot decrypt key with the token: .*`)
+}
+
+func (s *tokenSuite) TestBoundToKernelCmdline(c *C) {
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	// the default kernel command line binds like no command line
+	withDefault := *s.params
+	withDefault.KernelCmdline = &gadget.KernelCmdline{}
+	key, err := secboot.UnsealKey(keyFile, &withDefault)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	withCmdline := *s.params
+	withCmdline.KernelCmdline = &gadget.KernelCmdline{Full: "console=tty1"}
+	_, err = secboot.UnsealKey(keyFile, &withCmdline)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "token": cannot decrypt key with the token: .*`)
+
+	err = secboot.ResealKey(keyFile, s.params, &withCmdline)
+	c.Assert(err, IsNil)
+	key, err = secboot.UnsealKey(keyFile, &withCmdline)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	// extra arguments bind differently from a full command line
+	withExtra := *s.params
+	withExtra.KernelCmdline = &gadget.KernelCmdline{Extra: "console=tty1"}
+	_, err = secboot.UnsealKey(keyFile, &withExtra)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "token": cannot decrypt key with the token: .*`)
+}
+
+func (s *tokenSuite) TestTokenMissing(c *C) {
+	keyFile := secboot.DataKeyFile()
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	// the token is unplugged, leaving its empty mountpoint
+	s.mockMountInfo(c, "", "")
+
+	_, err = secboot.UnsealKey(keyFile, s.params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "token": token not mounted at ".*"`)
+
+	enc := &gadget.Encryption{KeyProtectors: []gadget.KeyProtector{*s.kpc}}
+	_, err = secboot.SelectKeyProtector(enc)
+	c.Check(err, ErrorMatches, "none of the key protectors is available")
+}
+
+func (s *tokenSuite) TestNoWrappingKeyNotCreated(c *C) {
+	c.Assert(os.Remove(filepath.Join(s.tokenDir, "fde-key")), IsNil)
+
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `key protector "token" is not available: token has no key at ".*/fde-key"`)
+	c.Check(filepath.Join(s.tokenDir, "fde-key"), testutil.FileAbsent)
+}
+
+func (s *tokenSuite) TestNotRemovable(c *C) {
+	dir := c.MkDir()
+	s.mockBlockDevice(c, "259:2", "nvme0n1", "nvme0n1p2", "0")
+	s.mockMountInfo(c, "259:2", dir)
+
+	err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{
+		Type:    "token",
+		Options: map[string]string{"path": filepath.Join(dir, "fde-key")},
+	}, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `key protector "token" is not available: token at ".*" is not a removable device`)
+}
+
+func (s *tokenSuite) TestWrongToken(c *C) {
+	keyFile := secboot.DataKeyFile()
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, keyFile)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(s.tokenDir, "fde-key"), make([]byte, 32), 0600)
+	c.Assert(err, IsNil)
+
+	_, err = secboot.UnsealKey(keyFile, s.params)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "token": cannot decrypt key with the token: .*`)
+}
+
+func (s *tokenSuite) TestRelativePath(c *C) {
+	err := secboot.SealKey([]byte("secret"), &gadget.KeyProtector{
+		Type:    "token",
+		Options: map[string]string{"path": "fde-key"},
+	}, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot use key protector "token": token path must be absolute, not "fde-key"`)
+}
@@ -0,0 +1,289 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// tpm2Device is the TPM resource manager device.
+const tpm2Device = "/dev/tpmrm0"
+
+// tpm2Protector protects keys by sealing them in the TPM, under the
+// primary key of its owner hierarchy, with a policy requiring the PCRs
+// measuring the boot chain to have the values they will have once the
+// device is booted with the boot assets the key is bound to. The key
+// can only be unsealed by the TPM of the device, booted that way.
+//
+// The PCRs are given by the "pcrs" option, the boot manager code and
+// secure boot policy ones by default. The "boot-chain" option lists the
+// EFI images among the boot assets loaded by the firmware, in order,
+// e.g. "EFI/boot/bootx64.efi,EFI/boot/grubx64.efi"; without it keys are
+// bound to the boot chain of the current boot.
+//
+// Keys are resealed by unsealing them, which only works while booted
+// with the boot chain they are bound to, and sealing them again with a
+// policy for the new boot chain.
+//
+// It uses the tpm2-tools helpers.
+type tpm2Protector struct {
+	pcrs      []int
+	bootChain string
+}
+
+func newTPM2Protector(options map[string]string) (KeyProtector, error) {
+	pcrsOption := options["pcrs"]
+	if pcrsOption == "" {
+		pcrsOption = tpm2DefaultPCRs
+	}
+	pcrs, err := parseTPM2PCRs(pcrsOption)
+	if err != nil {
+		return nil, err
+	}
+	return &tpm2Protector{pcrs: pcrs, bootChain: options["boot-chain"]}, nil
+}
+
+func (tp *tpm2Protector) Available() error {
+	if !osutil.FileExists(filepath.Join(dirs.GlobalRootDir, tpm2Device)) {
+		return fmt.Errorf("no TPM found at %q", tpm2Device)
+	}
+	return nil
+}
+
+// tpm2SealedObject holds the parts of a sealed object, as returned by
+// tpm2_create, which are encrypted by the TPM, and the selection of
+// the PCRs of its policy.
+type tpm2SealedObject struct {
+	Public  []byte `json:"public"`
+	Private []byte `json:"private"`
+	PCRs    string `json:"pcrs"`
+}
+
+// tpm2Session runs tpm2-tools helpers in a temporary directory.
+type tpm2Session struct {
+	dir string
+}
+
+func newTPM2Session() (*tpm2Session, error) {
+	dir, err := ioutil.TempDir("", "snapd-tpm2-")
+	if err != nil {
+		return nil, err
+	}
+	return &tpm2Session{dir: dir}, nil
+}
+
+func (s *tpm2Session) Close() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *tpm2Session) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *tpm2Session) command(name string, args ...string) *osutil.HelperCommand {
+	return &osutil.HelperCommand{
+		Name: name,
+		Args: args,
+		Env:  []string{"TPM2TOOLS_TCTI=device:" + filepath.Join(dirs.GlobalRootDir, tpm2Device)},
+	}
+}
+
+func (s *tpm2Session) run(stdin []byte, name string, args ...string) error {
+	hc := s.command(name, args...)
+	if stdin != nil {
+		hc.Stdin = bytes.NewReader(stdin)
+	}
+	_, err := osutil.RunHelper(hc)
+	return err
+}
+
+// unseal unseals the loaded object, the unsealed data is read from the
+// standard output of tpm2_unseal so that it is never written to disk.
+func (s *tpm2Session) unseal(args ...string) ([]byte, error) {
+	var key bytes.Buffer
+	hc := s.command("tpm2_unseal", append([]string{"-c", s.path("sealed.ctx")}, args...)...)
+	hc.Stdout = &key
+	if _, err := osutil.RunHelper(hc); err != nil {
+		return nil, err
+	}
+	return key.Bytes(), nil
+}
+
+// createPrimary recreates the primary key of the owner hierarchy, it
+// is derived from the seed of the TPM so it is always the same.
+func (s *tpm2Session) createPrimary() error {
+	return s.run(nil, "tpm2_createprimary", "-C", "o", "-c", s.path("primary.ctx"))
+}
+
+// load loads the sealed object into the TPM.
+func (s *tpm2Session) load(obj *tpm2SealedObject) error {
+	if err := ioutil.WriteFile(s.path("sealed.pub"), obj.Public, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.path("sealed.priv"), obj.Private, 0600); err != nil {
+		return err
+	}
+	return s.run(nil, "tpm2_load", "-C", s.path("primary.ctx"), "-u", s.path("sealed.pub"), "-r", s.path("sealed.priv"), "-c", s.path("sealed.ctx"))
+}
+
+func decodeTPM2SealedObject(data []byte) (*tpm2SealedObject, error) {
+	var obj tpm2SealedObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("cannot decode sealed object: %v", err)
+	}
+	return &obj, nil
+}
+
+func (tp *tpm2Protector) Protect(key []byte, params *SealParams) ([]byte, error) {
+	bootChain, err := tpm2BootChainDigests(tp.bootChain, params)
+	if err != nil {
+		return nil, err
+	}
+	pcrValues, err := tpm2PredictPCRs(tp.pcrs, bootChain)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newTPM2Session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := ioutil.WriteFile(s.path("pcrs"), pcrValues, 0600); err != nil {
+		return nil, err
+	}
+	pcrs := tpm2PCRSelection(tp.pcrs)
+	if err := s.run(nil, "tpm2_createpolicy", "--policy-pcr", "-l", pcrs, "-f", s.path("pcrs"), "-L", s.path("policy")); err != nil {
+		return nil, err
+	}
+	if err := s.createPrimary(); err != nil {
+		return nil, err
+	}
+	// without the userwithauth attribute the object can only be
+	// unsealed by satisfying its policy
+	if err := s.run(key, "tpm2_create", "-C", s.path("primary.ctx"), "-L", s.path("policy"), "-a", "fixedtpm|fixedparent", "-i", "-", "-u", s.path("sealed.pub"), "-r", s.path("sealed.priv")); err != nil {
+		return nil, err
+	}
+	obj := tpm2SealedObject{PCRs: pcrs}
+	if obj.Public, err = ioutil.ReadFile(s.path("sealed.pub")); err != nil {
+		return nil, err
+	}
+	if obj.Private, err = ioutil.ReadFile(s.path("sealed.priv")); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&obj)
+}
+
+// Unprotect unseals the key with a policy session on the current
+// values of the PCRs, the parameters are not used.
+func (tp *tpm2Protector) Unprotect(data []byte, params *SealParams) ([]byte, error) {
+	obj, err := decodeTPM2SealedObject(data)
+	if err != nil {
+		return nil, err
+	}
+	if obj.PCRs == "" {
+		return nil, fmt.Errorf("sealed object has no PCR policy")
+	}
+	s, err := newTPM2Session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := s.createPrimary(); err != nil {
+		return nil, err
+	}
+	if err := s.load(obj); err != nil {
+		return nil, err
+	}
+	return s.unseal("-p", "pcr:"+obj.PCRs)
+}
+
+// TPMAvailable returns an error if the device has no TPM to seal
+// secrets with.
+func TPMAvailable() error {
+	return (&tpm2Protector{}).Available()
+}
+
+// SealToTPM seals the secret in the TPM of the device, under the
+// primary key of its owner hierarchy, without a policy: unlike keys
+// protected with the "tpm2" key protector it is not bound to the boot
+// chain, and can be unsealed whatever the device booted, but only by
+// its TPM.
+func SealToTPM(secret []byte) ([]byte, error) {
+	if err := TPMAvailable(); err != nil {
+		return nil, err
+	}
+	s, err := newTPM2Session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := s.createPrimary(); err != nil {
+		return nil, err
+	}
+	// with the userwithauth attribute and an empty authorization
+	// value the object can be unsealed by whoever can use the TPM
+	if err := s.run(secret, "tpm2_create", "-C", s.path("primary.ctx"), "-a", "fixedtpm|fixedparent|userwithauth", "-i", "-", "-u", s.path("sealed.pub"), "-r", s.path("sealed.priv")); err != nil {
+		return nil, err
+	}
+	var obj tpm2SealedObject
+	if obj.Public, err = ioutil.ReadFile(s.path("sealed.pub")); err != nil {
+		return nil, err
+	}
+	if obj.Private, err = ioutil.ReadFile(s.path("sealed.priv")); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&obj)
+}
+
+// UnsealFromTPM unseals a secret sealed with SealToTPM.
+func UnsealFromTPM(data []byte) ([]byte, error) {
+	obj, err := decodeTPM2SealedObject(data)
+	if err != nil {
+		return nil, err
+	}
+	if obj.PCRs != "" {
+		return nil, fmt.Errorf("sealed object has a PCR policy")
+	}
+	s, err := newTPM2Session()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := s.createPrimary(); err != nil {
+		return nil, err
+	}
+	if err := s.load(obj); err != nil {
+		return nil, err
+	}
+	return s.unseal()
+}
@@ -0,0 +1,338 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/dirs"
+)
+
+// tpm2EventLog is the log of the measurements made by the firmware
+// while booting.
+const tpm2EventLog = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+const (
+	tpm2AlgSHA256 = 0x000b
+
+	tpm2EvNoAction                   = 0x00000003
+	tpm2EvEFIBootServicesApplication = 0x80000003
+
+	tpm2SpecIDEventSignature = "Spec ID Event03\x00"
+	tpm2MaxEventSize         = 1 << 20
+
+	// the index of the certificate table in the data directories of
+	// EFI images
+	authenticodeCertTableDirectory = 4
+)
+
+// tpm2BootManagerCodePCR is the PCR the firmware measures the EFI
+// images it loads to.
+const tpm2BootManagerCodePCR = 4
+
+// tpm2DefaultPCRs are the PCRs keys are sealed against by default: the
+// boot manager code and the secure boot policy.
+const tpm2DefaultPCRs = "4,7"
+
+// tpm2Event is a measurement of the event log, with its sha256 digest.
+type tpm2Event struct {
+	PCR    int
+	Type   uint32
+	Digest []byte
+}
+
+// parseTPM2PCRs parses a comma separated list of PCR indices.
+func parseTPM2PCRs(s string) ([]int, error) {
+	var pcrs []int
+	for _, field := range strings.Split(s, ",") {
+		pcr, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid PCR %q", field)
+		}
+		pcrs = append(pcrs, pcr)
+	}
+	sort.Ints(pcrs)
+	return pcrs, nil
+}
+
+// tpm2PCRSelection returns the selection of the given PCRs of the
+// sha256 bank, as taken by tpm2-tools.
+func tpm2PCRSelection(pcrs []int) string {
+	strs := make([]string, len(pcrs))
+	for i, pcr := range pcrs {
+		strs[i] = strconv.Itoa(pcr)
+	}
+	return "sha256:" + strings.Join(strs, ",")
+}
+
+// readTPM2EventLog reads the sha256 measurements of the crypto agile
+// event log of the firmware, see the TCG PC Client Platform Firmware
+// Profile specification.
+func readTPM2EventLog(r io.Reader) ([]tpm2Event, error) {
+	br := bufio.NewReader(r)
+	le := binary.LittleEndian
+
+	// the first event is in the SHA1 format and describes the
+	// digests of the following ones
+	var hdr struct {
+		PCR    uint32
+		Type   uint32
+		Digest [20]byte
+		Size   uint32
+	}
+	if err := binary.Read(br, le, &hdr); err != nil {
+		return nil, fmt.Errorf("cannot read event log header: %v", err)
+	}
+	if hdr.Type != tpm2EvNoAction || hdr.Size > tpm2MaxEventSize {
+		return nil, fmt.Errorf("invalid event log header")
+	}
+	specID := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(br, specID); err != nil {
+		return nil, fmt.Errorf("cannot read event log header: %v", err)
+	}
+	if len(specID) < 28 || string(specID[:16]) != tpm2SpecIDEventSignature {
+		return nil, fmt.Errorf("event log is not in the crypto agile format")
+	}
+	numAlgs := le.Uint32(specID[24:28])
+	if uint64(len(specID)) < 28+4*uint64(numAlgs) {
+		return nil, fmt.Errorf("invalid event log header")
+	}
+	digestSizes := make(map[uint16]int, numAlgs)
+	for i := uint32(0); i < numAlgs; i++ {
+		alg := specID[28+4*i:]
+		digestSizes[le.Uint16(alg)] = int(le.Uint16(alg[2:]))
+	}
+	if digestSizes[tpm2AlgSHA256] != sha256.Size {
+		return nil, fmt.Errorf("event log has no sha256 measurements")
+	}
+
+	var events []tpm2Event
+	for {
+		var evHdr struct {
+			PCR   uint32
+			Type  uint32
+			Count uint32
+		}
+		err := binary.Read(br, le, &evHdr)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read event: %v", err)
+		}
+		ev := tpm2Event{PCR: int(evHdr.PCR), Type: evHdr.Type}
+		for i := uint32(0); i < evHdr.Count; i++ {
+			var alg uint16
+			if err := binary.Read(br, le, &alg); err != nil {
+				return nil, fmt.Errorf("cannot read event: %v", err)
+			}
+			size, ok := digestSizes[alg]
+			if !ok {
+				return nil, fmt.Errorf("cannot read event: unknown digest algorithm %#x", alg)
+			}
+			digest := make([]byte, size)
+			if _, err := io.ReadFull(br, digest); err != nil {
+				return nil, fmt.Errorf("cannot read event: %v", err)
+			}
+			if alg == tpm2AlgSHA256 {
+				ev.Digest = digest
+			}
+		}
+		var size uint32
+		if err := binary.Read(br, le, &size); err != nil {
+			return nil, fmt.Errorf("cannot read event: %v", err)
+		}
+		if size > tpm2MaxEventSize {
+			return nil, fmt.Errorf("cannot read event: invalid size %d", size)
+		}
+		if _, err := io.CopyN(ioutil.Discard, br, int64(size)); err != nil {
+			return nil, fmt.Errorf("cannot read event: %v", err)
+		}
+		if ev.Type == tpm2EvNoAction || ev.Digest == nil {
+			// not extended into the PCRs
+			continue
+		}
+		events = append(events, ev)
+	}
+}
+
+// authenticodeDigest returns the sha256 Authenticode digest of the
+// given EFI image, which is what the firmware measures when it loads
+// it, see the Windows Authenticode Portable Executable Signature
+// Format specification.
+func authenticodeDigest(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read EFI image %q: %v", path, err)
+	}
+	defer img.Close()
+
+	var sizeOfHeaders uint32
+	var dataDirs []pe.DataDirectory
+	var dataDirsOffset int
+	optOffset := int(binary.LittleEndian.Uint32(data[0x3c:])) + 4 + 20
+	var numDataDirs uint32
+	switch oh := img.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		sizeOfHeaders, dataDirs, numDataDirs, dataDirsOffset = oh.SizeOfHeaders, oh.DataDirectory[:], oh.NumberOfRvaAndSizes, optOffset+96
+	case *pe.OptionalHeader64:
+		sizeOfHeaders, dataDirs, numDataDirs, dataDirsOffset = oh.SizeOfHeaders, oh.DataDirectory[:], oh.NumberOfRvaAndSizes, optOffset+112
+	default:
+		return nil, fmt.Errorf("cannot read EFI image %q: no optional header", path)
+	}
+	if numDataDirs <= authenticodeCertTableDirectory {
+		return nil, fmt.Errorf("cannot read EFI image %q: no certificate table directory", path)
+	}
+	checksumOffset := optOffset + 64
+	certDirOffset := dataDirsOffset + 8*authenticodeCertTableDirectory
+	if int(sizeOfHeaders) > len(data) || certDirOffset+8 > int(sizeOfHeaders) {
+		return nil, fmt.Errorf("cannot read EFI image %q: invalid headers", path)
+	}
+
+	h := sha256.New()
+	// the headers, without the checksum and the entry of the
+	// certificate table
+	h.Write(data[:checksumOffset])
+	h.Write(data[checksumOffset+4 : certDirOffset])
+	h.Write(data[certDirOffset+8 : sizeOfHeaders])
+
+	sections := make([]*pe.Section, len(img.Sections))
+	copy(sections, img.Sections)
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Offset < sections[j].Offset })
+	hashed := uint64(sizeOfHeaders)
+	for _, s := range sections {
+		if s.Size == 0 {
+			continue
+		}
+		end := uint64(s.Offset) + uint64(s.Size)
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("cannot read EFI image %q: section %q is truncated", path, s.Name)
+		}
+		h.Write(data[s.Offset:end])
+		if end > hashed {
+			hashed = end
+		}
+	}
+
+	// what follows the sections, except for the certificate table
+	certSize := uint64(dataDirs[authenticodeCertTableDirectory].Size)
+	if end := uint64(len(data)) - certSize; certSize <= uint64(len(data)) && end > hashed {
+		h.Write(data[hashed:end])
+	}
+	return h.Sum(nil), nil
+}
+
+// tpm2BootChainDigests returns the Authenticode digests of the EFI
+// images of the boot chain listed in the given option, in the order
+// they are loaded, among the boot assets of the given parameters.
+func tpm2BootChainDigests(bootChain string, params *SealParams) ([][]byte, error) {
+	if bootChain == "" {
+		return nil, nil
+	}
+	var digests [][]byte
+	for _, image := range strings.Split(bootChain, ",") {
+		image = strings.TrimSpace(image)
+		var found string
+		if params != nil {
+			for path := range params.BootAssets {
+				if strings.HasSuffix(path, "/"+image) {
+					found = path
+					break
+				}
+			}
+		}
+		if found == "" {
+			return nil, fmt.Errorf("boot chain image %q is not a boot asset", image)
+		}
+		digest, err := authenticodeDigest(found)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// tpm2PredictPCRs returns the values the given PCRs will have once the
+// device is booted with the given boot chain, concatenated in the
+// order of the PCRs as taken by tpm2_createpolicy. The measurements of
+// the current boot are replayed from the event log, with the ones of
+// the EFI images loaded as boot manager code replaced, in order, by
+// the given digests. Images loaded after the boot chain, e.g. the
+// kernel, are expected to be the same as in the current boot.
+func tpm2PredictPCRs(pcrs []int, bootChain [][]byte) ([]byte, error) {
+	f, err := os.Open(filepath.Join(dirs.GlobalRootDir, tpm2EventLog))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read event log: %v", err)
+	}
+	defer f.Close()
+	events, err := readTPM2EventLog(f)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[int][]byte, len(pcrs))
+	for _, pcr := range pcrs {
+		values[pcr] = make([]byte, sha256.Size)
+	}
+	loaded := 0
+	for _, ev := range events {
+		value, ok := values[ev.PCR]
+		if !ok {
+			continue
+		}
+		digest := ev.Digest
+		if ev.PCR == tpm2BootManagerCodePCR && ev.Type == tpm2EvEFIBootServicesApplication {
+			if loaded < len(bootChain) {
+				digest = bootChain[loaded]
+			}
+			loaded++
+		}
+		h := sha256.New()
+		h.Write(value)
+		h.Write(digest)
+		values[ev.PCR] = h.Sum(nil)
+	}
+	if loaded < len(bootChain) {
+		return nil, fmt.Errorf("cannot predict PCR values: the current boot loaded %d EFI images, fewer than the %d of the boot chain", loaded, len(bootChain))
+	}
+
+	var buf bytes.Buffer
+	for _, pcr := range pcrs {
+		buf.Write(values[pcr])
+	}
+	return buf.Bytes(), nil
+}
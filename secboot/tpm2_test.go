@@ -0,0 +1,513 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secboot_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/gadget"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/secboot"
+)
+
+const (
+	evNoAction                   = 0x00000003
+	evSeparator                  = 0x00000004
+	evEFIVariableDriverConfig    = 0x80000001
+	evEFIBootServicesApplication = 0x80000003
+	evEFIAction                  = 0x80000007
+)
+
+const (
+	sha1AlgID   uint16 = 0x0004
+	sha256AlgID uint16 = 0x000b
+)
+
+// mockEFIImage returns a minimal PE32+ image with a single section
+// holding the given content, and a certificate table if not empty.
+func mockEFIImage(content, certs []byte) []byte {
+	const sizeOfHeaders = 512
+	const sectionSize = 512
+
+	var buf bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: 240,
+	})
+	oh := pe.OptionalHeader64{
+		Magic:               0x20b,
+		SizeOfHeaders:       sizeOfHeaders,
+		CheckSum:            uint32(len(certs)),
+		NumberOfRvaAndSizes: 16,
+	}
+	if len(certs) != 0 {
+		oh.DataDirectory[4] = pe.DataDirectory{
+			VirtualAddress: sizeOfHeaders + sectionSize,
+			Size:           uint32(len(certs)),
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, oh)
+	sh := pe.SectionHeader32{
+		VirtualSize:      sectionSize,
+		VirtualAddress:   0x1000,
+		SizeOfRawData:    sectionSize,
+		PointerToRawData: sizeOfHeaders,
+	}
+	copy(sh.Name[:], ".text")
+	binary.Write(&buf, binary.LittleEndian, sh)
+	buf.Write(make([]byte, sizeOfHeaders-buf.Len()))
+
+	section := make([]byte, sectionSize)
+	copy(section, content)
+	buf.Write(section)
+	buf.Write(certs)
+	return buf.Bytes()
+}
+
+type mockEvent struct {
+	pcr    int
+	typ    uint32
+	digest []byte
+}
+
+func digestOf(s string) []byte {
+	d := sha256.Sum256([]byte(s))
+	return d[:]
+}
+
+// mockEventLog returns a crypto agile event log with the given events,
+// which have a sha1 digest along with the sha256 one.
+func mockEventLog(events []mockEvent) []byte {
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+
+	var specID bytes.Buffer
+	specID.WriteString("Spec ID Event03\x00")
+	binary.Write(&specID, le, []uint32{0, 0})
+	binary.Write(&specID, le, uint32(2))
+	binary.Write(&specID, le, []uint16{sha1AlgID, 20, sha256AlgID, 32})
+	specID.WriteByte(0)
+	binary.Write(&buf, le, []uint32{0, evNoAction})
+	buf.Write(make([]byte, 20))
+	binary.Write(&buf, le, uint32(specID.Len()))
+	buf.Write(specID.Bytes())
+
+	for _, ev := range events {
+		binary.Write(&buf, le, []uint32{uint32(ev.pcr), ev.typ, 2})
+		binary.Write(&buf, le, sha1AlgID)
+		buf.Write(make([]byte, 20))
+		binary.Write(&buf, le, sha256AlgID)
+		buf.Write(ev.digest)
+		binary.Write(&buf, le, uint32(3))
+		buf.WriteString("foo")
+	}
+	return buf.Bytes()
+}
+
+// replay returns the values of the PCRs once the given events are
+// measured.
+func replay(events []mockEvent) map[int][]byte {
+	pcrs := make(map[int][]byte)
+	for _, ev := range events {
+		if ev.typ == evNoAction {
+			continue
+		}
+		value := pcrs[ev.pcr]
+		if value == nil {
+			value = make([]byte, 32)
+		}
+		h := sha256.New()
+		h.Write(value)
+		h.Write(ev.digest)
+		pcrs[ev.pcr] = h.Sum(nil)
+	}
+	return pcrs
+}
+
+type tpm2Suite struct {
+	baseSecbootSuite
+
+	calls  []string
+	params *secboot.SealParams
+	kpc    *gadget.KeyProtector
+
+	// pcrs are the current values of the PCRs of the fake TPM
+	pcrs map[int][]byte
+}
+
+var _ = Suite(&tpm2Suite{})
+
+func (s *tpm2Suite) bootEvents(c *C, shim, grub string) []mockEvent {
+	var events []mockEvent
+	events = append(events,
+		mockEvent{0, evSeparator, digestOf("separator")},
+		mockEvent{7, evEFIVariableDriverConfig, digestOf("secure-boot")},
+		mockEvent{4, evEFIAction, digestOf("Calling EFI Application from Boot Option")},
+		mockEvent{4, evSeparator, digestOf("separator")},
+		mockEvent{4, evNoAction, digestOf("ignored")},
+	)
+	for _, path := range []string{shim, grub} {
+		digest, err := secboot.AuthenticodeDigest(path)
+		c.Assert(err, IsNil)
+		events = append(events, mockEvent{4, evEFIBootServicesApplication, digest})
+	}
+	// the kernel, loaded after the boot chain of the gadget
+	return append(events, mockEvent{4, evEFIBootServicesApplication, digestOf("kernel")})
+}
+
+// boot fakes booting the device with the given boot chain.
+func (s *tpm2Suite) boot(c *C, shim, grub string) {
+	events := s.bootEvents(c, shim, grub)
+	log := filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements")
+	c.Assert(os.MkdirAll(filepath.Dir(log), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(log, mockEventLog(events), 0644), IsNil)
+	s.pcrs = replay(events)
+}
+
+func (s *tpm2Suite) writeAsset(c *C, name string, content []byte) string {
+	path := filepath.Join(dirs.GlobalRootDir, "/boot/efi/EFI/boot", name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, content, 0644), IsNil)
+	return path
+}
+
+func (s *tpm2Suite) SetUpTest(c *C) {
+	s.baseSecbootSuite.SetUpTest(c)
+	s.calls = nil
+
+	shim := s.writeAsset(c, "bootx64.efi", mockEFIImage([]byte("shim"), nil))
+	grub := s.writeAsset(c, "grubx64.efi", mockEFIImage([]byte("grub"), nil))
+	s.params = &secboot.SealParams{
+		BrandID: "my-brand",
+		Model:   "my-model",
+		BootAssets: map[string]string{
+			shim: "shim-digest",
+			grub: "grub-digest",
+		},
+	}
+	s.kpc = &gadget.KeyProtector{
+		Type:    "tpm2",
+		Options: map[string]string{"boot-chain": "EFI/boot/bootx64.efi,EFI/boot/grubx64.efi"},
+	}
+	s.boot(c, shim, grub)
+
+	tpmDevice := filepath.Join(dirs.GlobalRootDir, "/dev/tpmrm0")
+	c.Assert(os.MkdirAll(filepath.Dir(tpmDevice), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(tpmDevice, nil, 0600), IsNil)
+
+	s.restore = append(s.restore, osutil.MockRunHelper(s.fakeTPM2Tools(c)))
+}
+
+// pcrPolicy returns the digest of the policy on the given values of
+// the selected PCRs of the sha256 bank, as made by the fake TPM.
+func pcrPolicy(selection string, values []byte) string {
+	return "policy " + selection + " " + hex.EncodeToString(values)
+}
+
+// fakeTPM2Tools fakes the tpm2-tools helpers with a TPM whose private
+// parts of sealed objects hold their policy and data in the clear.
+func (s *tpm2Suite) fakeTPM2Tools(c *C) func(hc *osutil.HelperCommand) ([]byte, error) {
+	return func(hc *osutil.HelperCommand) ([]byte, error) {
+		c.Check(hc.Env, DeepEquals, []string{"TPM2TOOLS_TCTI=device:" + filepath.Join(dirs.GlobalRootDir, "/dev/tpmrm0")})
+		s.calls = append(s.calls, hc.Name)
+		opts := make(map[string]string)
+		for i := 0; i+1 < len(hc.Args); i++ {
+			if strings.HasPrefix(hc.Args[i], "-") {
+				opts[hc.Args[i]] = hc.Args[i+1]
+			}
+		}
+		switch hc.Name {
+		case "tpm2_createpolicy":
+			c.Check(hc.Args[0], Equals, "--policy-pcr")
+			values, err := ioutil.ReadFile(opts["-f"])
+			c.Assert(err, IsNil)
+			return nil, ioutil.WriteFile(opts["-L"], []byte(pcrPolicy(opts["-l"], values)), 0600)
+		case "tpm2_createprimary":
+			return nil, ioutil.WriteFile(opts["-c"], []byte("primary"), 0600)
+		case "tpm2_create":
+			c.Check(opts["-p"], Equals, "")
+			policy := []byte("none")
+			if opts["-L"] == "" {
+				c.Check(opts["-a"], Equals, "fixedtpm|fixedparent|userwithauth")
+			} else {
+				c.Check(opts["-a"], Equals, "fixedtpm|fixedparent")
+				var err error
+				policy, err = ioutil.ReadFile(opts["-L"])
+				c.Assert(err, IsNil)
+			}
+			data, err := ioutil.ReadAll(hc.Stdin)
+			c.Assert(err, IsNil)
+			c.Assert(ioutil.WriteFile(opts["-u"], []byte("public"), 0600), IsNil)
+			return nil, ioutil.WriteFile(opts["-r"], append(append(policy, '\n'), data...), 0600)
+		case "tpm2_load":
+			priv, err := ioutil.ReadFile(opts["-r"])
+			c.Assert(err, IsNil)
+			return nil, ioutil.WriteFile(opts["-c"], priv, 0600)
+		case "tpm2_unseal":
+			// the unsealed data is only written to the standard output
+			c.Check(opts["-o"], Equals, "")
+			c.Assert(hc.Stdout, NotNil)
+			obj, err := ioutil.ReadFile(opts["-c"])
+			c.Assert(err, IsNil)
+			parts := strings.SplitN(string(obj), "\n", 2)
+			if parts[0] == "none" {
+				c.Check(opts["-p"], Equals, "")
+				_, err := hc.Stdout.Write([]byte(parts[1]))
+				return nil, err
+			}
+			c.Assert(strings.HasPrefix(opts["-p"], "pcr:"), Equals, true)
+			selection := strings.TrimPrefix(opts["-p"], "pcr:")
+			c.Assert(selection, Equals, "sha256:4,7")
+			current := append(append([]byte(nil), s.pcrs[4]...), s.pcrs[7]...)
+			if parts[0] != pcrPolicy(selection, current) {
+				return nil, &osutil.HelperError{Name: hc.Name, Output: []byte("policy check failed"), Err: fmt.Errorf("exit status 1")}
+			}
+			_, err = hc.Stdout.Write([]byte(parts[1]))
+			return nil, err
+		}
+		c.Fatalf("unexpected helper %q", hc.Name)
+		return nil, nil
+	}
+}
+
+func (s *tpm2Suite) TestHappy(c *C) {
+	keyFile := secboot.DataKeyFile()
+
+	err := secboot.SealKey([]byte("secret"), s.kpc, s.params, keyFile)
+	c.Assert(err, IsNil)
+	c.Check(s.calls, DeepEquals, []string{"tpm2_createpolicy", "tpm2_createprimary", "tpm2_create"})
+
+	s.calls = nil
+	key, err := secboot.UnsealKey(keyFile, s.params)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+	c.Check(s.calls, DeepEquals, []string{"tpm2_createprimary", "tpm2_load", "tpm2_unseal"})
+
+	// the boot chain is updated
+	oldGrub := s.writeAsset(c, "grubx64.efi.old", mockEFIImage([]byte("grub"), nil))
+	newGrub := s.writeAsset(c, "grubx64.efi", mockEFIImage([]byte("new grub"), nil))
+	shim := filepath.Join(dirs.GlobalRootDir, "/boot/efi/EFI/boot/bootx64.efi")
+	newParams := &secboot.SealParams{
+		BrandID: "my-brand",
+		Model:   "my-model",
+		BootAssets: map[string]string{
+			shim:    "shim-digest",
+			newGrub: "new-grub-digest",
+		},
+	}
+	s.calls = nil
+	err = secboot.ResealKey(keyFile, s.params, newParams)
+	c.Assert(err, IsNil)
+	c.Check(s.calls, DeepEquals, []string{
+		"tpm2_createprimary", "tpm2_load", "tpm2_unseal",
+		"tpm2_createpolicy", "tpm2_createprimary", "tpm2_create",
+	})
+
+	// the key is bound to the new boot chain
+	_, err = secboot.UnsealKey(keyFile, newParams)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "tpm2": policy check failed`)
+	s.boot(c, shim, newGrub)
+	key, err = secboot.UnsealKey(keyFile, newParams)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	// and only to it, whatever the parameters given
+	s.boot(c, shim, oldGrub)
+	_, err = secboot.UnsealKey(keyFile, newParams)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "tpm2": policy check failed`)
+}
+
+func (s *tpm2Suite) TestBoundToCurrentBootChain(c *C) {
+	// without a boot chain the key is bound to the current boot
+	kpc := &gadget.KeyProtector{Type: "tpm2"}
+	err := secboot.SealKey([]byte("secret"), kpc, nil, secboot.DataKeyFile())
+	c.Assert(err, IsNil)
+	key, err := secboot.UnsealKey(secboot.DataKeyFile(), nil)
+	c.Assert(err, IsNil)
+	c.Check(string(key), Equals, "secret")
+
+	// another OS is booted
+	other := s.writeAsset(c, "other.efi", mockEFIImage([]byte("other"), nil))
+	s.boot(c, other, other)
+	_, err = secboot.UnsealKey(secboot.DataKeyFile(), nil)
+	c.Check(err, ErrorMatches, `cannot unprotect key with "tpm2": policy check failed`)
+}
+
+func (s *tpm2Suite) TestSealErrors(c *C) {
+	kpc := &gadget.KeyProtector{Type: "tpm2", Options: map[string]string{"boot-chain": "EFI/boot/missing.efi"}}
+	err := secboot.SealKey([]byte("secret"), kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot protect key with "tpm2": boot chain image "EFI/boot/missing.efi" is not a boot asset`)
+
+	kpc = &gadget.KeyProtector{Type: "tpm2", Options: map[string]string{"pcrs": "4,foo"}}
+	err = secboot.SealKey([]byte("secret"), kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot use key protector "tpm2": invalid PCR "foo"`)
+
+	// the current boot loaded fewer images than the boot chain
+	shim := filepath.Join(dirs.GlobalRootDir, "/boot/efi/EFI/boot/bootx64.efi")
+	log := filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements")
+	events := s.bootEvents(c, shim, shim)
+	c.Assert(ioutil.WriteFile(log, mockEventLog(events[:len(events)-2]), 0644), IsNil)
+	err = secboot.SealKey([]byte("secret"), s.kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot protect key with "tpm2": cannot predict PCR values: the current boot loaded 1 EFI images, fewer than the 2 of the boot chain`)
+
+	c.Assert(ioutil.WriteFile(log, []byte("garbage"), 0644), IsNil)
+	err = secboot.SealKey([]byte("secret"), s.kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `cannot protect key with "tpm2": cannot read event log header: unexpected EOF`)
+	c.Check(s.calls, HasLen, 0)
+}
+
+func (s *tpm2Suite) TestSealToTPM(c *C) {
+	sealed, err := secboot.SealToTPM([]byte("secret"))
+	c.Assert(err, IsNil)
+	c.Check(s.calls, DeepEquals, []string{"tpm2_createprimary", "tpm2_create"})
+
+	s.calls = nil
+	secret, err := secboot.UnsealFromTPM(sealed)
+	c.Assert(err, IsNil)
+	c.Check(string(secret), Equals, "secret")
+	c.Check(s.calls, DeepEquals, []string{"tpm2_createprimary", "tpm2_load", "tpm2_unseal"})
+
+	// the secret is not bound to the boot chain
+	other := s.writeAsset(c, "other.efi", mockEFIImage([]byte("other"), nil))
+	s.boot(c, other, other)
+	secret, err = secboot.UnsealFromTPM(sealed)
+	c.Assert(err, IsNil)
+	c.Check(string(secret), Equals, "secret")
+}
+
+func (s *tpm2Suite) TestAuthenticodeDigest(c *C) {
+	path := s.writeAsset(c, "image.efi", mockEFIImage([]byte("image"), nil))
+	digest, err := secboot.AuthenticodeDigest(path)
+	c.Assert(err, IsNil)
+
+	// the checksum and the certificate table are not measured
+	signed := s.writeAsset(c, "signed.efi", mockEFIImage([]byte("image"), []byte("signatures")))
+	signedDigest, err := secboot.AuthenticodeDigest(signed)
+	c.Assert(err, IsNil)
+	c.Check(signedDigest, DeepEquals, digest)
+
+	// the code is
+	other := s.writeAsset(c, "other.efi", mockEFIImage([]byte("other"), nil))
+	otherDigest, err := secboot.AuthenticodeDigest(other)
+	c.Assert(err, IsNil)
+	c.Check(otherDigest, Not(DeepEquals), digest)
+
+	notPE := s.writeAsset(c, "not-pe.efi", []byte("garbage"))
+	_, err = secboot.AuthenticodeDigest(notPE)
+	c.Check(err, ErrorMatches, `cannot read EFI image ".*/not-pe.efi": .*`)
+}
+
+func (s *tpm2Suite) TestAuthenticodeDigestSignedImage(c *C) {
+	digest, err := secboot.AuthenticodeDigest(filepath.Join("test-data", "ev-signed-file.exe"))
+	c.Assert(err, IsNil)
+	// the digest signed by signtool
+	c.Check(hex.EncodeToString(digest), Equals, "338540aca4a45d4a9951a2b20a87d30d332945988fd5fac8f5af5aac6297e5d7")
+}
+
+func decodeHex(c *C, s string) []byte {
+	b, err := hex.DecodeString(s)
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (s *tpm2Suite) TestPredictPCRsFromEventLogs(c *C) {
+	for _, t := range []struct {
+		log        string
+		pcr4, pcr7 string
+		bootChain  []string
+	}{{
+		log:  "ubuntu-2104-no-secure-boot.bin",
+		pcr4: "ebc7ae25d0347868250995c9a8fff16bf79e048453262d0ef2756e213c76181c",
+		pcr7: "0d8847bc5eca06452df10e2f214363845c7ac11d47525a5474e225e72ce25dfe",
+		// shim and grub
+		bootChain: []string{
+			"6265b732b005b3f330bcd1843374e5ec6ec5aef27cdb97a23daeb8580abbf526",
+			"b0a836fec2faf4a9bea0e1a5f1945bc86ddc03ac98ce0ae172ed9b1e536d7595",
+		},
+	}, {
+		log:  "arch-linux-workstation.bin",
+		pcr4: "925d453d3dfef4ac0c72c957402163d45fa95d05e6d53f047263a3a60b598325",
+		pcr7: "3b4a4db44b7a872524055364e62e897ae678e0d47ab0809f65c3a4ed77f66ab9",
+	}} {
+		data, err := ioutil.ReadFile(filepath.Join("test-data", t.log))
+		c.Assert(err, IsNil)
+		log := filepath.Join(dirs.GlobalRootDir, "/sys/kernel/security/tpm0/binary_bios_measurements")
+		c.Assert(ioutil.WriteFile(log, data, 0644), IsNil)
+
+		// replaying the log gives the values read from the TPM
+		expected := append(decodeHex(c, t.pcr4), decodeHex(c, t.pcr7)...)
+		values, err := secboot.TPM2PredictPCRs([]int{4, 7}, nil)
+		c.Assert(err, IsNil, Commentf(t.log))
+		c.Check(hex.EncodeToString(values), Equals, hex.EncodeToString(expected), Commentf(t.log))
+
+		if t.bootChain == nil {
+			continue
+		}
+		// so does booting the images the log measured
+		var bootChain [][]byte
+		for _, digest := range t.bootChain {
+			bootChain = append(bootChain, decodeHex(c, digest))
+		}
+		values, err = secboot.TPM2PredictPCRs([]int{4, 7}, bootChain)
+		c.Assert(err, IsNil, Commentf(t.log))
+		c.Check(hex.EncodeToString(values), Equals, hex.EncodeToString(expected), Commentf(t.log))
+
+		// but not booting other ones, which only changes PCR 4
+		bootChain[1] = digestOf("other")
+		values, err = secboot.TPM2PredictPCRs([]int{4, 7}, bootChain)
+		c.Assert(err, IsNil, Commentf(t.log))
+		c.Check(values[:32], Not(DeepEquals), expected[:32], Commentf(t.log))
+		c.Check(values[32:], DeepEquals, expected[32:], Commentf(t.log))
+	}
+}
+
+func (s *tpm2Suite) TestNoTPM(c *C) {
+	c.Assert(os.Remove(filepath.Join(dirs.GlobalRootDir, "/dev/tpmrm0")), IsNil)
+
+	enc := &gadget.Encryption{KeyProtectors: []gadget.KeyProtector{{Type: "tpm2"}}}
+	_, err := secboot.SelectKeyProtector(enc)
+	c.Check(err, ErrorMatches, "none of the key protectors is available")
+
+	err = secboot.SealKey([]byte("secret"), s.kpc, s.params, secboot.DataKeyFile())
+	c.Check(err, ErrorMatches, `key protector "tpm2" is not available: no TPM found at "/dev/tpmrm0"`)
+
+	c.Check(secboot.TPMAvailable(), ErrorMatches, `no TPM found at "/dev/tpmrm0"`)
+	_, err = secboot.SealToTPM([]byte("secret"))
+	c.Check(err, ErrorMatches, `no TPM found at "/dev/tpmrm0"`)
+	c.Check(s.calls, HasLen, 0)
+}
@@ -21,6 +21,8 @@ package snap
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/snapcore/snapd/gadget"
 )
@@ -41,3 +43,40 @@ func ReadGadgetInfo(info *Info, classic bool) (*gadget.Info, error) {
 	}
 	return gi, nil
 }
+
+// ReadKernelFeatures reads the features of the kernel in the given kernel
+// snap from its build configuration. Kernel snaps are not required to ship
+// one, in which case nil is returned.
+func ReadKernelFeatures(container Container) (*gadget.KernelFeatures, error) {
+	const errorFormat = "cannot read kernel snap features: %s"
+
+	entries, err := container.ListDir(".")
+	if err != nil {
+		return nil, fmt.Errorf(errorFormat, err)
+	}
+	var configs []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "config-") {
+			configs = append(configs, entry)
+		}
+	}
+	switch len(configs) {
+	case 0:
+		return nil, nil
+	case 1:
+		// expected
+	default:
+		sort.Strings(configs)
+		return nil, fmt.Errorf(errorFormat, fmt.Sprintf("more than one configuration: %s", strings.Join(configs, ", ")))
+	}
+
+	config, err := container.ReadFile(configs[0])
+	if err != nil {
+		return nil, fmt.Errorf(errorFormat, err)
+	}
+	features, err := gadget.ParseKernelConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf(errorFormat, err)
+	}
+	return features, nil
+}
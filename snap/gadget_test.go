@@ -79,3 +79,37 @@ func (s *gadgetYamlTestSuite) TestReadGadgetYamlOnClassicEmptyIsValid(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(ginfo, DeepEquals, &gadget.Info{})
 }
+
+func (s *gadgetYamlTestSuite) TestReadKernelFeatures(c *C) {
+	kernelYaml := "name: pc-kernel\nversion: 1\ntype: kernel\n"
+	mockContainer := func(files [][]string) snap.Container {
+		dir := c.MkDir()
+		snaptest.PopulateDir(dir, files)
+		container, err := snap.Open(dir)
+		c.Assert(err, IsNil)
+		return container
+	}
+	container := mockContainer([][]string{
+		{"meta/snap.yaml", kernelYaml},
+		{"config-5.4.0-1-generic", "CONFIG_EXT4_FS=y\n# CONFIG_VFAT_FS is not set\n"},
+	})
+	features, err := snap.ReadKernelFeatures(container)
+	c.Assert(err, IsNil)
+	c.Check(features, DeepEquals, &gadget.KernelFeatures{Filesystems: []string{"ext4"}})
+
+	// kernels do not have to ship their configuration
+	container = mockContainer([][]string{
+		{"meta/snap.yaml", kernelYaml},
+	})
+	features, err = snap.ReadKernelFeatures(container)
+	c.Assert(err, IsNil)
+	c.Check(features, IsNil)
+
+	container = mockContainer([][]string{
+		{"meta/snap.yaml", kernelYaml},
+		{"config-5.4.0-1-generic", ""},
+		{"config-5.4.0-2-generic", ""},
+	})
+	_, err = snap.ReadKernelFeatures(container)
+	c.Check(err, ErrorMatches, `cannot read kernel snap features: more than one configuration: config-5.4.0-1-generic, config-5.4.0-2-generic`)
+}
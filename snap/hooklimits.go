@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/strutil"
+)
+
+// HookLimits holds the limits on the resources a hook can use while
+// it runs. Zero values mean no limit.
+type HookLimits struct {
+	// CPUQuota is the share of a single CPU the hook can use, in percent.
+	CPUQuota int
+	// Memory is the memory the hook can use, in bytes.
+	Memory int64
+	// Timeout is how long the hook can run for.
+	Timeout time.Duration
+}
+
+// IsZero returns whether no limit is set.
+func (l HookLimits) IsZero() bool {
+	return l == HookLimits{}
+}
+
+// Restrict returns the limits with the ones set in other replacing
+// them where they are lower, or where no limit is set. The limits can
+// only be tightened this way, never raised.
+func (l HookLimits) Restrict(other *HookLimits) HookLimits {
+	if other == nil {
+		return l
+	}
+	if other.CPUQuota != 0 && (l.CPUQuota == 0 || other.CPUQuota < l.CPUQuota) {
+		l.CPUQuota = other.CPUQuota
+	}
+	if other.Memory != 0 && (l.Memory == 0 || other.Memory < l.Memory) {
+		l.Memory = other.Memory
+	}
+	if other.Timeout != 0 && (l.Timeout == 0 || other.Timeout < l.Timeout) {
+		l.Timeout = other.Timeout
+	}
+	return l
+}
+
+// ParseHookLimits parses the given hook limits, as found in snap.yaml
+// or in the system configuration, e.g. "50%", "128MB" and "5m". Empty
+// strings mean no limit.
+func ParseHookLimits(cpu, memory, timeout string) (*HookLimits, error) {
+	var limits HookLimits
+	if cpu != "" {
+		quota, err := strconv.Atoi(strings.TrimSuffix(cpu, "%"))
+		if err != nil || !strings.HasSuffix(cpu, "%") || quota <= 0 {
+			return nil, fmt.Errorf("invalid cpu limit %q: must be a percentage greater than zero", cpu)
+		}
+		limits.CPUQuota = quota
+	}
+	if memory != "" {
+		size, err := strutil.ParseByteSize(memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %v", err)
+		}
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid memory limit %q: must be greater than zero", memory)
+		}
+		limits.Memory = size
+	}
+	if timeout != "" {
+		dur, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout limit: %v", err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("invalid timeout limit %q: must be greater than zero", timeout)
+		}
+		limits.Timeout = dur
+	}
+	return &limits, nil
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+type hookLimitsSuite struct{}
+
+var _ = Suite(&hookLimitsSuite{})
+
+func (s *hookLimitsSuite) TestParseHookLimits(c *C) {
+	limits, err := snap.ParseHookLimits("150%", "1GB", "90s")
+	c.Assert(err, IsNil)
+	c.Check(limits, DeepEquals, &snap.HookLimits{
+		CPUQuota: 150,
+		Memory:   1000 * 1000 * 1000,
+		Timeout:  90 * time.Second,
+	})
+
+	limits, err = snap.ParseHookLimits("", "", "")
+	c.Assert(err, IsNil)
+	c.Check(limits.IsZero(), Equals, true)
+}
+
+func (s *hookLimitsSuite) TestParseHookLimitsErrors(c *C) {
+	for _, t := range []struct {
+		cpu, memory, timeout string
+		err                  string
+	}{
+		{"50", "", "", `invalid cpu limit "50": must be a percentage greater than zero`},
+		{"0%", "", "", `invalid cpu limit "0%": must be a percentage greater than zero`},
+		{"x%", "", "", `invalid cpu limit "x%": must be a percentage greater than zero`},
+		{"", "128", "", `invalid memory limit: cannot parse "128": need a number with a unit as input`},
+		{"", "0MB", "", `invalid memory limit "0MB": must be greater than zero`},
+		{"", "", "soon", `invalid timeout limit: .*`},
+		{"", "", "-1s", `invalid timeout limit "-1s": must be greater than zero`},
+	} {
+		_, err := snap.ParseHookLimits(t.cpu, t.memory, t.timeout)
+		c.Check(err, ErrorMatches, t.err, Commentf("%v", t))
+	}
+}
+
+func (s *hookLimitsSuite) TestRestrict(c *C) {
+	defaults := snap.HookLimits{CPUQuota: 50, Timeout: time.Minute}
+	c.Check(defaults.Restrict(nil), Equals, defaults)
+	c.Check(defaults.Restrict(&snap.HookLimits{Memory: 10, Timeout: time.Second}), Equals, snap.HookLimits{
+		CPUQuota: 50,
+		Memory:   10,
+		Timeout:  time.Second,
+	})
+	// the limits cannot be raised
+	c.Check(defaults.Restrict(&snap.HookLimits{CPUQuota: 80, Timeout: time.Hour}), Equals, defaults)
+	c.Check(defaults.Restrict(&snap.HookLimits{CPUQuota: 20, Timeout: time.Hour}), Equals, snap.HookLimits{
+		CPUQuota: 20,
+		Timeout:  time.Minute,
+	})
+}
@@ -25,9 +25,11 @@ import (
 
 var supportedHooks = []*HookType{
 	NewHookType(regexp.MustCompile("^prepare-device$")),
+	NewHookType(regexp.MustCompile("^attest-device$")),
 	NewHookType(regexp.MustCompile("^configure$")),
 	NewHookType(regexp.MustCompile("^install$")),
 	NewHookType(regexp.MustCompile("^pre-refresh$")),
+	NewHookType(regexp.MustCompile("^pre-refresh-download$")),
 	NewHookType(regexp.MustCompile("^post-refresh$")),
 	NewHookType(regexp.MustCompile("^remove$")),
 	NewHookType(regexp.MustCompile("^prepare-(?:plug|slot)-[-a-z0-9]+$")),
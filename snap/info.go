@@ -210,6 +210,7 @@ type Info struct {
 	OriginalTitle       string
 	OriginalSummary     string
 	OriginalDescription string
+	OriginalLinks       map[string][]string
 
 	Environment strutil.OrderedMap
 
@@ -244,6 +245,10 @@ type Info struct {
 
 	Media MediaInfos
 
+	// The links to further information about the snap, by kind, as
+	// edited in the store
+	EditedLinks map[string][]string
+
 	// The flattended channel map with $track/$risk
 	Channels map[string]*ChannelSnapInfo
 
@@ -256,6 +261,26 @@ type Info struct {
 	CommonIDs []string
 }
 
+// Links returns the links to further information about the snap, by kind,
+// eg. "website" or "source". Links edited in the store take precedence over
+// the ones from snap.yaml. The contact of the snap is included under
+// "contact".
+func (s *Info) Links() map[string][]string {
+	links := s.EditedLinks
+	if len(links) == 0 {
+		links = s.OriginalLinks
+	}
+	if s.Contact == "" || strutil.ListContains(links["contact"], s.Contact) {
+		return links
+	}
+	withContact := make(map[string][]string, len(links)+1)
+	for k, v := range links {
+		withContact[k] = v
+	}
+	withContact["contact"] = append([]string{s.Contact}, links["contact"]...)
+	return withContact
+}
+
 // StoreAccount holds information about a store account, for example
 // of snap publisher.
 type StoreAccount struct {
@@ -734,6 +759,12 @@ type AppInfo struct {
 	RefreshMode     string
 	StopMode        StopModeType
 
+	// RestartLimitBurst and RestartLimitInterval bound how many times
+	// the service can be (re)started within the interval, after which
+	// systemd gives up on it
+	RestartLimitBurst    int
+	RestartLimitInterval timeout.Timeout
+
 	// TODO: this should go away once we have more plumbing and can change
 	// things vs refactor
 	// https://github.com/snapcore/snapd/pull/794#discussion_r58688496
@@ -798,6 +829,10 @@ type HookInfo struct {
 	Environment  strutil.OrderedMap
 	CommandChain []string
 
+	// Limits are the resource limits the snap asks for the hook to
+	// run with, if any.
+	Limits *HookLimits
+
 	Explicit bool
 }
 
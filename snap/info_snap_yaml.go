@@ -43,6 +43,7 @@ type snapYaml struct {
 	Description   string                 `yaml:"description"`
 	Summary       string                 `yaml:"summary"`
 	License       string                 `yaml:"license,omitempty"`
+	Links         map[string][]string    `yaml:"links,omitempty"`
 	Epoch         Epoch                  `yaml:"epoch,omitempty"`
 	Base          string                 `yaml:"base,omitempty"`
 	Confinement   ConfinementType        `yaml:"confinement,omitempty"`
@@ -88,6 +89,9 @@ type appYaml struct {
 	SlotNames    []string         `yaml:"slots,omitempty"`
 	PlugNames    []string         `yaml:"plugs,omitempty"`
 
+	RestartLimitBurst    int             `yaml:"restart-limit-burst,omitempty"`
+	RestartLimitInterval timeout.Timeout `yaml:"restart-limit-interval,omitempty"`
+
 	BusName  string `yaml:"bus-name,omitempty"`
 	CommonID string `yaml:"common-id,omitempty"`
 
@@ -108,6 +112,13 @@ type hookYaml struct {
 	SlotNames    []string           `yaml:"slots,omitempty"`
 	Environment  strutil.OrderedMap `yaml:"environment,omitempty"`
 	CommandChain []string           `yaml:"command-chain,omitempty"`
+	Limits       *hookLimitsYaml    `yaml:"limits,omitempty"`
+}
+
+type hookLimitsYaml struct {
+	CPU     string `yaml:"cpu,omitempty"`
+	Memory  string `yaml:"memory,omitempty"`
+	Timeout string `yaml:"timeout,omitempty"`
 }
 
 type layoutYaml struct {
@@ -183,7 +194,9 @@ func infoFromSnapYaml(yamlData []byte, strk *scopedTracker) (*Info, error) {
 	if err := setAppsFromSnapYaml(y, snap, strk); err != nil {
 		return nil, err
 	}
-	setHooksFromSnapYaml(y, snap, strk)
+	if err := setHooksFromSnapYaml(y, snap, strk); err != nil {
+		return nil, err
+	}
 
 	// Bind plugs and slots that are not scoped to all known apps and hooks.
 	bindUnscopedPlugs(snap, strk)
@@ -265,7 +278,8 @@ func infoSkeletonFromSnapYaml(y snapYaml) *Info {
 		OriginalTitle:       y.Title,
 		OriginalDescription: y.Description,
 		OriginalSummary:     y.Summary,
-		License:             y.License,
+		OriginalLinks:       y.Links,
+		License:             NormalizeLicense(y.License),
 		Epoch:               y.Epoch,
 		Confinement:         confinement,
 		Base:                y.Base,
@@ -357,6 +371,9 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 			After:           yApp.After,
 			Autostart:       yApp.Autostart,
 			WatchdogTimeout: yApp.WatchdogTimeout,
+
+			RestartLimitBurst:    yApp.RestartLimitBurst,
+			RestartLimitInterval: yApp.RestartLimitInterval,
 		}
 		if len(y.Plugs) > 0 || len(yApp.PlugNames) > 0 {
 			app.Plugs = make(map[string]*PlugInfo)
@@ -431,18 +448,28 @@ func setAppsFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 	return nil
 }
 
-func setHooksFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) {
+func setHooksFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) error {
 	for hookName, yHook := range y.Hooks {
 		if !IsHookSupported(hookName) {
 			continue
 		}
 
+		var limits *HookLimits
+		if yHook.Limits != nil {
+			var err error
+			limits, err = ParseHookLimits(yHook.Limits.CPU, yHook.Limits.Memory, yHook.Limits.Timeout)
+			if err != nil {
+				return fmt.Errorf("cannot parse limits of hook %q: %v", hookName, err)
+			}
+		}
+
 		// Collect all hooks
 		hook := &HookInfo{
 			Snap:         snap,
 			Name:         hookName,
 			Environment:  yHook.Environment,
 			CommandChain: yHook.CommandChain,
+			Limits:       limits,
 			Explicit:     true,
 		}
 		if len(y.Plugs) > 0 || len(yHook.PlugNames) > 0 {
@@ -495,6 +522,7 @@ func setHooksFromSnapYaml(y snapYaml, snap *Info, strk *scopedTracker) {
 			slot.Hooks[hookName] = hook
 		}
 	}
+	return nil
 }
 
 func bindUnscopedPlugs(snap *Info, strk *scopedTracker) {
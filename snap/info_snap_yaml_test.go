@@ -1328,6 +1328,36 @@ slots:
 		app1.Name: app1, app2.Name: app2})
 }
 
+func (s *YamlSuite) TestSnapYamlLinksAndLicense(c *C) {
+	info, err := snap.InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+license: gpl-3.0  or  mit
+links:
+    website:
+        - https://example.com
+    source:
+        - https://github.com/example/foo
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.License, Equals, "GPL-3.0 OR MIT")
+	c.Check(info.OriginalLinks, DeepEquals, map[string][]string{
+		"website": {"https://example.com"},
+		"source":  {"https://github.com/example/foo"},
+	})
+	c.Check(info.Links(), DeepEquals, info.OriginalLinks)
+}
+
+func (s *YamlSuite) TestSnapYamlLicenseNotNormalized(c *C) {
+	info, err := snap.InfoFromSnapYaml([]byte(`name: foo
+version: 1.0
+license: GPL~3.0
+`))
+	c.Assert(err, IsNil)
+	// left as is, for validation to complain about
+	c.Check(info.License, Equals, "GPL~3.0")
+	c.Check(snap.Validate(info), ErrorMatches, `cannot validate license "GPL~3.0": .*`)
+}
+
 // type and architectures
 
 func (s *YamlSuite) TestSnapYamlTypeDefault(c *C) {
@@ -1804,6 +1834,39 @@ hooks:
 	c.Check(hook.CommandChain, DeepEquals, []string{"hookchain1", "hookchain2"})
 }
 
+func (s *YamlSuite) TestSnapYamlHookLimits(c *C) {
+	y := []byte(`name: wat
+version: 42
+hooks:
+ configure:
+  limits:
+   cpu: 50%
+   memory: 128MB
+   timeout: 5m
+ install:
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	c.Check(info.Hooks["configure"].Limits, DeepEquals, &snap.HookLimits{
+		CPUQuota: 50,
+		Memory:   128 * 1000 * 1000,
+		Timeout:  5 * time.Minute,
+	})
+	c.Check(info.Hooks["install"].Limits, IsNil)
+}
+
+func (s *YamlSuite) TestSnapYamlHookLimitsInvalid(c *C) {
+	y := []byte(`name: wat
+version: 42
+hooks:
+ configure:
+  limits:
+   memory: lots
+`)
+	_, err := snap.InfoFromSnapYaml(y)
+	c.Check(err, ErrorMatches, `cannot parse limits of hook "configure": invalid memory limit: cannot parse "lots": .*`)
+}
+
 func (s *YamlSuite) TestSnapYamlRestartDelay(c *C) {
 	yAutostart := []byte(`name: wat
 version: 42
@@ -1819,3 +1882,21 @@ apps:
 	c.Assert(app, NotNil)
 	c.Check(app.RestartDelay, Equals, timeout.Timeout(12*time.Second))
 }
+
+func (s *YamlSuite) TestSnapYamlRestartLimit(c *C) {
+	y := []byte(`name: wat
+version: 42
+apps:
+ foo:
+  command: bin/foo
+  daemon: simple
+  restart-limit-burst: 5
+  restart-limit-interval: 1m
+`)
+	info, err := snap.InfoFromSnapYaml(y)
+	c.Assert(err, IsNil)
+	app := info.Apps["foo"]
+	c.Assert(app, NotNil)
+	c.Check(app.RestartLimitBurst, Equals, 5)
+	c.Check(app.RestartLimitInterval, Equals, timeout.Timeout(time.Minute))
+}
@@ -1618,6 +1618,43 @@ func (s *infoSuite) TestMedia(c *C) {
 		[]snap.ScreenshotInfo{{Note: snap.ScreenshotsDeprecationNotice}})
 }
 
+func (s *infoSuite) TestLinks(c *C) {
+	info := &snap.Info{}
+	c.Check(info.Links(), HasLen, 0)
+
+	info.OriginalLinks = map[string][]string{
+		"website": {"https://example.com"},
+	}
+	c.Check(info.Links(), DeepEquals, map[string][]string{
+		"website": {"https://example.com"},
+	})
+
+	// links from the store take precedence
+	info.EditedLinks = map[string][]string{
+		"website": {"https://example.com/store"},
+		"source":  {"https://github.com/example/thing"},
+	}
+	c.Check(info.Links(), DeepEquals, map[string][]string{
+		"website": {"https://example.com/store"},
+		"source":  {"https://github.com/example/thing"},
+	})
+
+	// the contact comes first
+	info.Contact = "mailto:alice@example.com"
+	info.EditedLinks["contact"] = []string{"https://example.com/contact"}
+	c.Check(info.Links(), DeepEquals, map[string][]string{
+		"contact": {"mailto:alice@example.com", "https://example.com/contact"},
+		"website": {"https://example.com/store"},
+		"source":  {"https://github.com/example/thing"},
+	})
+	// without modifying the links of the snap
+	c.Check(info.EditedLinks["contact"], DeepEquals, []string{"https://example.com/contact"})
+
+	// and is not repeated
+	info.EditedLinks["contact"] = []string{"mailto:alice@example.com"}
+	c.Check(info.Links()["contact"], DeepEquals, []string{"mailto:alice@example.com"})
+}
+
 func (s *infoSuite) TestSortApps(c *C) {
 	tcs := []struct {
 		err    string
@@ -50,6 +50,9 @@ type SeedSnap struct {
 	// no assertions are available in the seed for this snap
 	Unasserted bool `yaml:"unasserted,omitempty"`
 
+	// the snap is not installed on first boot, only later on demand
+	InstallOnDemand bool `yaml:"install-on-demand,omitempty"`
+
 	File string `yaml:"file"`
 }
 
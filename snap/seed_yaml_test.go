@@ -42,6 +42,11 @@ snaps:
  - name: local
    unasserted: true
    file: local.snap
+ - name: extra
+   snap-id: extrasnapidsnapid
+   channel: 2.0/edge
+   install-on-demand: true
+   file: extra_2.0_all.snap
 `)
 
 func (s *seedYamlTestSuite) TestSimple(c *C) {
@@ -51,7 +56,7 @@ func (s *seedYamlTestSuite) TestSimple(c *C) {
 
 	seed, err := snap.ReadSeedYaml(fn)
 	c.Assert(err, IsNil)
-	c.Assert(seed.Snaps, HasLen, 2)
+	c.Assert(seed.Snaps, HasLen, 3)
 	c.Assert(seed.Snaps[0], DeepEquals, &snap.SeedSnap{
 		File:   "foo_1.0_all.snap",
 		Name:   "foo",
@@ -65,6 +70,14 @@ func (s *seedYamlTestSuite) TestSimple(c *C) {
 		Name:       "local",
 		Unasserted: true,
 	})
+	c.Assert(seed.Snaps[2], DeepEquals, &snap.SeedSnap{
+		File:   "extra_2.0_all.snap",
+		Name:   "extra",
+		SnapID: "extrasnapidsnapid",
+
+		Channel:         "2.0/edge",
+		InstallOnDemand: true,
+	})
 }
 
 var badMockSeedYaml = []byte(`
@@ -22,6 +22,7 @@ package snap
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -143,6 +144,81 @@ func ValidateLicense(license string) error {
 	return nil
 }
 
+// NormalizeLicense returns the canonical form of a valid SPDX expression,
+// or the expression unchanged if it is not valid.
+func NormalizeLicense(license string) string {
+	if normalized, err := spdx.NormalizeLicense(license); err == nil {
+		return normalized
+	}
+	return license
+}
+
+var validLinkKey = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// ValidateLink checks that the link of the given kind points to an http(s)
+// URL, or for contact links, possibly to an email address.
+func ValidateLink(key, link string) error {
+	if !validLinkKey.MatchString(key) {
+		return fmt.Errorf("invalid link key %q", key)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("invalid %s link %q: %v", key, link, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("invalid %s link %q: missing host", key, link)
+		}
+	case "mailto":
+		if key != "contact" {
+			return fmt.Errorf("invalid %s link %q: only contact links can be email addresses", key, link)
+		}
+		if !strings.Contains(u.Opaque, "@") {
+			return fmt.Errorf("invalid %s link %q: invalid email address", key, link)
+		}
+	default:
+		return fmt.Errorf("invalid %s link %q: unsupported scheme %q", key, link, u.Scheme)
+	}
+	return nil
+}
+
+// ValidateLinks checks the links of a snap, by kind.
+func ValidateLinks(links map[string][]string) error {
+	for key, urls := range links {
+		if len(urls) == 0 {
+			return fmt.Errorf("%s links cannot be empty", key)
+		}
+		for _, link := range urls {
+			if err := ValidateLink(key, link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var validMediaTypes = []string{"icon", "screenshot", "banner", "banner-icon", "video"}
+
+// ValidateMedia checks that the media entry is of a known type and points
+// to an http(s) URL.
+func ValidateMedia(media MediaInfo) error {
+	if !strutil.ListContains(validMediaTypes, media.Type) {
+		return fmt.Errorf("invalid media type %q", media.Type)
+	}
+	u, err := url.Parse(media.URL)
+	if err != nil {
+		return fmt.Errorf("invalid %s URL %q: %v", media.Type, media.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s URL %q", media.Type, media.URL)
+	}
+	if media.Width < 0 || media.Height < 0 {
+		return fmt.Errorf("invalid %s size %dx%d", media.Type, media.Width, media.Height)
+	}
+	return nil
+}
+
 // ValidateHook validates the content of the given HookInfo
 func ValidateHook(hook *HookInfo) error {
 	if err := naming.ValidateHook(hook.Name); err != nil {
@@ -305,6 +381,10 @@ func Validate(info *Info) error {
 		}
 	}
 
+	if err := ValidateLinks(info.OriginalLinks); err != nil {
+		return err
+	}
+
 	// validate app entries
 	for _, app := range info.Apps {
 		if err := ValidateApp(app); err != nil {
@@ -575,6 +655,23 @@ func validateAppRestart(app *AppInfo) error {
 	return nil
 }
 
+func validateAppRestartLimit(app *AppInfo) error {
+	if app.RestartLimitBurst == 0 && app.RestartLimitInterval == 0 {
+		return nil
+	}
+
+	if !app.IsService() {
+		return errors.New("restart-limit-burst and restart-limit-interval are only applicable to services")
+	}
+	if app.RestartLimitBurst < 0 {
+		return errors.New("restart-limit-burst cannot be negative")
+	}
+	if app.RestartLimitInterval < 0 {
+		return errors.New("restart-limit-interval cannot be negative")
+	}
+	return nil
+}
+
 // appContentWhitelist is the whitelist of legal chars in the "apps"
 // section of snap.yaml. Do not allow any of [',",`] here or snap-exec
 // will get confused. chainContentWhitelist is the same, but for the
@@ -639,6 +736,9 @@ func ValidateApp(app *AppInfo) error {
 	if err := validateAppRestart(app); err != nil {
 		return err
 	}
+	if err := validateAppRestartLimit(app); err != nil {
+		return err
+	}
 	if err := validateAppOrderNames(app, app.Before); err != nil {
 		return err
 	}
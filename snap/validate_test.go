@@ -130,6 +130,68 @@ func (s *ValidateSuite) TestValidateLicense(c *C) {
 	}
 }
 
+func (s *ValidateSuite) TestNormalizeLicense(c *C) {
+	for _, t := range []struct{ in, out string }{
+		{"mit", "MIT"},
+		{"gpl-3.0  or  mit", "GPL-3.0 OR MIT"},
+		{"GPL-3.0", "GPL-3.0"},
+		// not something that can be normalized, kept as is
+		{"GPL~3.0", "GPL~3.0"},
+		{"", ""},
+	} {
+		c.Check(NormalizeLicense(t.in), Equals, t.out, Commentf("%q", t.in))
+	}
+}
+
+func (s *ValidateSuite) TestValidateLinks(c *C) {
+	c.Check(ValidateLinks(nil), IsNil)
+	c.Check(ValidateLinks(map[string][]string{
+		"website": {"https://example.com", "http://example.com/about"},
+		"contact": {"mailto:alice@example.com", "https://example.com/contact"},
+		"source":  {"https://github.com/example/thing"},
+	}), IsNil)
+
+	for _, t := range []struct {
+		links map[string][]string
+		err   string
+	}{
+		{map[string][]string{"website": nil}, `website links cannot be empty`},
+		{map[string][]string{"Web_Site": {"https://example.com"}}, `invalid link key "Web_Site"`},
+		{map[string][]string{"-website": {"https://example.com"}}, `invalid link key "-website"`},
+		{map[string][]string{"source": {"ftp://example.com"}}, `invalid source link "ftp://example.com": unsupported scheme "ftp"`},
+		{map[string][]string{"source": {"example.com"}}, `invalid source link "example.com": unsupported scheme ""`},
+		{map[string][]string{"website": {"https://"}}, `invalid website link "https://": missing host`},
+		{map[string][]string{"website": {"mailto:alice@example.com"}}, `invalid website link "mailto:alice@example.com": only contact links can be email addresses`},
+		{map[string][]string{"contact": {"mailto:alice"}}, `invalid contact link "mailto:alice": invalid email address`},
+	} {
+		c.Check(ValidateLinks(t.links), ErrorMatches, t.err)
+	}
+}
+
+func (s *ValidateSuite) TestValidateMedia(c *C) {
+	for _, m := range []MediaInfo{
+		{Type: "icon", URL: "https://example.com/icon.png"},
+		{Type: "screenshot", URL: "http://example.com/shot.png", Width: 640, Height: 480},
+		{Type: "banner", URL: "https://example.com/banner.png"},
+		{Type: "banner-icon", URL: "https://example.com/banner-icon.png"},
+		{Type: "video", URL: "https://example.com/video"},
+	} {
+		c.Check(ValidateMedia(m), IsNil, Commentf("%v", m))
+	}
+
+	for _, t := range []struct {
+		media MediaInfo
+		err   string
+	}{
+		{MediaInfo{Type: "hologram", URL: "https://example.com/h"}, `invalid media type "hologram"`},
+		{MediaInfo{Type: "icon", URL: "file:///icon.png"}, `invalid icon URL "file:///icon.png"`},
+		{MediaInfo{Type: "icon", URL: "icon.png"}, `invalid icon URL "icon.png"`},
+		{MediaInfo{Type: "screenshot", URL: "https://example.com/s.png", Width: -1}, `invalid screenshot size -1x0`},
+	} {
+		c.Check(ValidateMedia(t.media), ErrorMatches, t.err)
+	}
+}
+
 func (s *ValidateSuite) TestValidateHook(c *C) {
 	validHooks := []*HookInfo{
 		{Name: "a"},
@@ -1559,3 +1621,45 @@ apps:
 		}
 	}
 }
+
+func (s *ValidateSuite) TestValidateAppRestartLimit(c *C) {
+	meta := []byte(`
+name: foo
+version: 1.0
+`)
+	tcs := []struct {
+		name string
+		desc string
+		err  string
+	}{{
+		name: "all good",
+		desc: "daemon: simple\n    restart-limit-burst: 5\n    restart-limit-interval: 1m",
+	}, {
+		name: "only burst",
+		desc: "daemon: simple\n    restart-limit-burst: 5",
+	}, {
+		name: "not a service",
+		desc: "restart-limit-burst: 5",
+		err:  `restart-limit-burst and restart-limit-interval are only applicable to services`,
+	}, {
+		name: "negative burst",
+		desc: "daemon: simple\n    restart-limit-burst: -1",
+		err:  `restart-limit-burst cannot be negative`,
+	}, {
+		name: "negative interval",
+		desc: "daemon: simple\n    restart-limit-interval: -1m",
+		err:  `restart-limit-interval cannot be negative`,
+	}}
+	for _, tc := range tcs {
+		c.Logf("trying %q", tc.name)
+		info, err := InfoFromSnapYaml(append(meta, []byte("apps:\n  foo:\n    "+tc.desc+"\n")...))
+		c.Assert(err, IsNil)
+
+		err = Validate(info)
+		if tc.err != "" {
+			c.Check(err, ErrorMatches, `invalid definition of application "foo": `+tc.err)
+		} else {
+			c.Check(err, IsNil)
+		}
+	}
+}
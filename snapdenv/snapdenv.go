@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package snapdenv presents the environment snapd is running in.
+package snapdenv
+
+import (
+	"github.com/snapcore/snapd/osutil"
+)
+
+var mockedPreseeding *bool
+
+// Preseeding returns whether snapd is running in preseed mode, that is
+// seeding a target image from a chroot by means of snap-preseed,
+// as signalled by SNAPD_PRESEED=1 in the environment. In this mode no
+// services are started and no security profiles are loaded into the
+// kernel.
+func Preseeding() bool {
+	if mockedPreseeding != nil {
+		return *mockedPreseeding
+	}
+	return osutil.GetenvBool("SNAPD_PRESEED")
+}
+
+// MockPreseeding fakes whether snapd is running in preseed mode.
+func MockPreseeding(preseeding bool) (restore func()) {
+	old := mockedPreseeding
+	mockedPreseeding = &preseeding
+	return func() {
+		mockedPreseeding = old
+	}
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package spdx
+
+import (
+	"bytes"
+	"strings"
+)
+
+func canonicalID(known []string, s string) string {
+	for _, id := range known {
+		if strings.EqualFold(id, s) {
+			return id
+		}
+	}
+	return s
+}
+
+// NormalizeLicense returns the canonical form of the given SPDX License
+// Expression: license and exception identifiers are matched case
+// insensitively and written as listed by SPDX, operators are upper case and
+// whitespace is collapsed.
+//
+// An error is returned if the resulting expression is not valid.
+func NormalizeLicense(license string) (string, error) {
+	s := NewScanner(bytes.NewBufferString(license))
+	var buf bytes.Buffer
+	last := ""
+	for s.Scan() {
+		tok := s.Text()
+		switch {
+		case tok == "(", tok == ")":
+			// nothing to normalize
+		case isOperator(strings.ToUpper(tok)):
+			tok = strings.ToUpper(tok)
+		case last == opWITH:
+			tok = canonicalID(licenseExceptions, tok)
+		case strings.HasSuffix(tok, "+"):
+			tok = canonicalID(allLicenses, tok[:len(tok)-1]) + "+"
+		default:
+			tok = canonicalID(allLicenses, tok)
+		}
+		if buf.Len() > 0 && tok != ")" && last != "(" {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(tok)
+		last = tok
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+
+	normalized := buf.String()
+	if err := ValidateLicense(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
@@ -75,3 +75,24 @@ func (s *spdxSuite) TestParseError(c *C) {
 		c.Check(err, ErrorMatches, t.errStr, Commentf("input: %q", t.inp))
 	}
 }
+
+func (s *spdxSuite) TestNormalizeLicense(c *C) {
+	for _, t := range []struct {
+		inp, out string
+	}{
+		{"GPL-2.0", "GPL-2.0"},
+		{"gpl-2.0+", "GPL-2.0+"},
+		{"mit or apache-2.0", "MIT OR Apache-2.0"},
+		{"GPL-2.0  with gcc-exception-3.1", "GPL-2.0 WITH GCC-exception-3.1"},
+		{"( mit and (bsd-2-clause or 0bsd) )", "(MIT AND (BSD-2-Clause OR 0BSD))"},
+	} {
+		normalized, err := spdx.NormalizeLicense(t.inp)
+		c.Check(err, IsNil, Commentf("input: %q", t.inp))
+		c.Check(normalized, Equals, t.out, Commentf("input: %q", t.inp))
+	}
+
+	_, err := spdx.NormalizeLicense("mit and")
+	c.Check(err, ErrorMatches, "missing license after AND")
+	_, err = spdx.NormalizeLicense("Not-A-License")
+	c.Check(err, ErrorMatches, "unknown license: Not-A-License")
+}
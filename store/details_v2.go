@@ -25,32 +25,35 @@ import (
 	"time"
 
 	"github.com/snapcore/snapd/jsonutil/safejson"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/strutil"
 )
 
 // storeSnap holds the information sent as JSON by the store for a snap.
 type storeSnap struct {
-	Architectures []string           `json:"architectures"`
-	Base          string             `json:"base"`
-	Confinement   string             `json:"confinement"`
-	Contact       string             `json:"contact"`
-	CreatedAt     string             `json:"created-at"` // revision timestamp
-	Description   safejson.Paragraph `json:"description"`
-	Download      storeSnapDownload  `json:"download"`
-	Epoch         snap.Epoch         `json:"epoch"`
-	License       string             `json:"license"`
-	Name          string             `json:"name"`
-	Prices        map[string]string  `json:"prices"` // currency->price,  free: {"USD": "0"}
-	Private       bool               `json:"private"`
-	Publisher     snap.StoreAccount  `json:"publisher"`
-	Revision      int                `json:"revision"` // store revisions are ints starting at 1
-	SnapID        string             `json:"snap-id"`
-	SnapYAML      string             `json:"snap-yaml"` // optional
-	Summary       safejson.String    `json:"summary"`
-	Title         safejson.String    `json:"title"`
-	Type          snap.Type          `json:"type"`
-	Version       string             `json:"version"`
+	Architectures []string            `json:"architectures"`
+	Base          string              `json:"base"`
+	Confinement   string              `json:"confinement"`
+	Contact       string              `json:"contact"`
+	CreatedAt     string              `json:"created-at"` // revision timestamp
+	Description   safejson.Paragraph  `json:"description"`
+	Download      storeSnapDownload   `json:"download"`
+	Epoch         snap.Epoch          `json:"epoch"`
+	License       string              `json:"license"`
+	Links         map[string][]string `json:"links"`
+	Name          string              `json:"name"`
+	Prices        map[string]string   `json:"prices"` // currency->price,  free: {"USD": "0"}
+	Private       bool                `json:"private"`
+	Publisher     snap.StoreAccount   `json:"publisher"`
+	Revision      int                 `json:"revision"` // store revisions are ints starting at 1
+	SnapID        string              `json:"snap-id"`
+	SnapYAML      string              `json:"snap-yaml"` // optional
+	Summary       safejson.String     `json:"summary"`
+	Title         safejson.String     `json:"title"`
+	Type          snap.Type           `json:"type"`
+	Version       string              `json:"version"`
+	Website       string              `json:"website"`
 
 	// TODO: not yet defined: channel map
 
@@ -178,6 +181,9 @@ func copyNonZeroFrom(src, dst *storeSnap) {
 	if src.License != "" {
 		dst.License = src.License
 	}
+	if len(src.Links) > 0 {
+		dst.Links = src.Links
+	}
 	if src.Name != "" {
 		dst.Name = src.Name
 	}
@@ -211,6 +217,9 @@ func copyNonZeroFrom(src, dst *storeSnap) {
 	if src.Version != "" {
 		dst.Version = src.Version
 	}
+	if src.Website != "" {
+		dst.Website = src.Website
+	}
 	if len(src.Media) > 0 {
 		dst.Media = src.Media
 	}
@@ -238,7 +247,8 @@ func infoFromStoreSnap(d *storeSnap) (*snap.Info, error) {
 	info.Epoch = d.Epoch
 	info.Confinement = snap.ConfinementType(d.Confinement)
 	info.Base = d.Base
-	info.License = d.License
+	info.License = snap.NormalizeLicense(d.License)
+	info.EditedLinks = validLinks(d.Links, d.Website)
 	info.Publisher = d.Publisher
 	info.DownloadURL = d.Download.URL
 	info.Size = d.Download.Size
@@ -259,8 +269,10 @@ func infoFromStoreSnap(d *storeSnap) (*snap.Info, error) {
 	}
 	info.CommonIDs = d.CommonIDs
 
-	// fill in the plug/slot data
+	// fill in the plug/slot data and the assumed features, so that they
+	// can be checked before the snap is downloaded
 	if rawYamlInfo, err := snap.InfoFromSnapYaml([]byte(d.SnapYAML)); err == nil {
+		info.Assumes = rawYamlInfo.Assumes
 		if info.Plugs == nil {
 			info.Plugs = make(map[string]*snap.PlugInfo)
 		}
@@ -301,11 +313,48 @@ func addMedia(info *snap.Info, media []storeSnapMedia) {
 	if len(media) == 0 {
 		return
 	}
-	info.Media = make(snap.MediaInfos, len(media))
-	for i, mediaObj := range media {
-		info.Media[i].Type = mediaObj.Type
-		info.Media[i].URL = mediaObj.URL
-		info.Media[i].Width = mediaObj.Width
-		info.Media[i].Height = mediaObj.Height
+	info.Media = make(snap.MediaInfos, 0, len(media))
+	for _, mediaObj := range media {
+		mi := snap.MediaInfo{
+			Type:   mediaObj.Type,
+			URL:    mediaObj.URL,
+			Width:  mediaObj.Width,
+			Height: mediaObj.Height,
+		}
+		if err := snap.ValidateMedia(mi); err != nil {
+			logger.Debugf("Ignoring media of snap %q: %v", info.SnapName(), err)
+			continue
+		}
+		info.Media = append(info.Media, mi)
+	}
+	if len(info.Media) == 0 {
+		info.Media = nil
+	}
+}
+
+// validLinks returns the links of a snap that are valid, folding in the
+// website, if any.
+func validLinks(links map[string][]string, website string) map[string][]string {
+	if website != "" && !strutil.ListContains(links["website"], website) {
+		withWebsite := make(map[string][]string, len(links)+1)
+		for k, v := range links {
+			withWebsite[k] = v
+		}
+		withWebsite["website"] = append([]string{website}, links["website"]...)
+		links = withWebsite
+	}
+	var valid map[string][]string
+	for key, urls := range links {
+		for _, link := range urls {
+			if err := snap.ValidateLink(key, link); err != nil {
+				logger.Debugf("Ignoring snap link: %v", err)
+				continue
+			}
+			if valid == nil {
+				valid = make(map[string][]string, len(links))
+			}
+			valid[key] = append(valid[key], link)
+		}
 	}
+	return valid
 }
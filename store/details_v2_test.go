@@ -108,6 +108,10 @@ const (
      "write": [1]
   },
   "license": "Proprietary",
+  "links": {
+     "source": ["https://github.com/thingy/thingy"],
+     "issues": ["ftp://thingy.com/issues"]
+  },
   "name": "thingy",
   "prices": {"USD": "9.99"},
   "private": false,
@@ -124,8 +128,10 @@ const (
   "title": "This Is The Most Fantastical Snap of Thingy",
   "type": "app",
   "version": "9.50",
+  "website": "https://thingy.com/about",
   "media": [
      {"type": "icon", "url": "https://dashboard.snapcraft.io/site_media/appmedia/2017/12/Thingy.png"},
+     {"type": "hologram", "url": "https://dashboard.snapcraft.io/site_media/appmedia/2018/01/Thingy.holo"},
      {"type": "screenshot", "url": "https://dashboard.snapcraft.io/site_media/appmedia/2018/01/Thingy_01.png"},
      {"type": "screenshot", "url": "https://dashboard.snapcraft.io/site_media/appmedia/2018/01/Thingy_02.png", "width": 600, "height": 200}
   ]
@@ -183,6 +189,17 @@ func (s *detailsV2Suite) TestInfoFromStoreSnapSimple(c *C) {
 	})
 }
 
+func (s *detailsV2Suite) TestInfoFromStoreSnapAssumes(c *C) {
+	var snp storeSnap
+	err := json.Unmarshal([]byte(coreStoreJSON), &snp)
+	c.Assert(err, IsNil)
+	snp.SnapYAML = "name: core\nversion: 16-2.30\nassumes: [snapd2.30, command-chain]\n"
+
+	info, err := infoFromStoreSnap(&snp)
+	c.Assert(err, IsNil)
+	c.Check(info.Assumes, DeepEquals, []string{"command-chain", "snapd2.30"})
+}
+
 func (s *detailsV2Suite) TestInfoFromStoreSnap(c *C) {
 	var snp storeSnap
 	// base, prices, media
@@ -248,6 +265,10 @@ func (s *detailsV2Suite) TestInfoFromStoreSnap(c *C) {
 			{Type: "screenshot", URL: "https://dashboard.snapcraft.io/site_media/appmedia/2018/01/Thingy_01.png"},
 			{Type: "screenshot", URL: "https://dashboard.snapcraft.io/site_media/appmedia/2018/01/Thingy_02.png", Width: 600, Height: 200},
 		},
+		EditedLinks: map[string][]string{
+			"source":  {"https://github.com/thingy/thingy"},
+			"website": {"https://thingy.com/about"},
+		},
 		CommonIDs: []string{"org.thingy"},
 	})
 
@@ -284,6 +305,7 @@ func (s *detailsV2Suite) TestInfoFromStoreSnap(c *C) {
 		"OriginalTitle",
 		"OriginalSummary",
 		"OriginalDescription",
+		"OriginalLinks",
 		"Environment",
 		"LicenseAgreement", // XXX go away?
 		"LicenseVersion",   // XXX go away?
@@ -360,6 +382,8 @@ func fillStruct(a interface{}, c *C) {
 			x = snap.E("1")
 		case map[string]string:
 			x = map[string]string{"foo": "bar"}
+		case map[string][]string:
+			x = map[string][]string{"foo": {"bar"}}
 		case bool:
 			x = true
 		case snap.StoreAccount:
@@ -39,6 +39,9 @@ type DeviceAndAuthContext interface {
 
 	DeviceSessionRequestParams(nonce string) (*DeviceSessionRequestParams, error)
 	ProxyStoreParams(defaultURL *url.URL) (proxyStoreID string, proxySroreURL *url.URL, err error)
+	// AssertionsProxyURL returns the URL of a proxy or mirror
+	// serving assertions separately from the store, or nil.
+	AssertionsProxyURL() (*url.URL, error)
 
 	CloudInfo() (*auth.CloudInfo, error)
 }
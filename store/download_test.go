@@ -63,6 +63,9 @@ func (s *downloadSuite) SetUpTest(c *C) {
 
 	mockXdelta := testutil.MockCommand(c, "xdelta3", "")
 	s.AddCleanup(mockXdelta.Restore)
+
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
 }
 
 func (s *downloadSuite) TestActualDownload(c *C) {
@@ -227,6 +230,25 @@ func (s *downloadSuite) TestActualDownloadNonPurchased402(c *C) {
 	c.Check(n, Equals, 1)
 }
 
+func (s *downloadSuite) TestActualDownloadNoEntitlementAnonymous(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(403)
+		io.WriteString(w, `{"error_list":[{"code":"no-entitlement","message":"not entitled"}]}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := store.New(&store.Config{}, nil)
+	var buf SillyBuffer
+	err := store.Download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
+	c.Assert(err, FitsTypeOf, &store.NoEntitlementError{})
+	c.Check(err.(*store.NoEntitlementError).Snap, Equals, "foo")
+	// without a user there is nothing to refresh
+	c.Check(n, Equals, 1)
+}
+
 func (s *downloadSuite) TestActualDownload404(c *C) {
 	n := 0
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -353,6 +375,77 @@ func (s *downloadSuite) TestActualDownloadResume(c *C) {
 	c.Check(n, Equals, 1)
 }
 
+func (s *downloadSuite) TestActualDownloadResumeIgnoredRange(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=5-")
+		// ignore the range and send everything
+		io.WriteString(w, "some data")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := store.New(&store.Config{}, nil)
+	f, err := os.Create(filepath.Join(c.MkDir(), "partial"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteString("some ")
+	c.Assert(err, IsNil)
+	h := crypto.SHA3_384.New()
+	h.Write([]byte("some data"))
+	sha3 := fmt.Sprintf("%x", h.Sum(nil))
+	err = store.Download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, f, int64(len("some ")), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(f.Name(), testutil.FileEquals, "some data")
+	c.Check(n, Equals, 1)
+}
+
+func (s *downloadSuite) TestActualDownloadReusesConnections(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "response-data")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := store.New(&store.Config{}, nil)
+	for i := 0; i < 3; i++ {
+		var buf SillyBuffer
+		err := store.Download(context.TODO(), "foo", "", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(buf.String(), Equals, "response-data")
+	}
+	c.Check(theStore.DownloadConnectionStats(), DeepEquals, store.DownloadConnectionStats{
+		New:    1,
+		Reused: 2,
+	})
+}
+
+type countingTransport struct {
+	n int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBufferString("response-data")),
+		Request:    req,
+	}, nil
+}
+
+func (s *downloadSuite) TestActualDownloadMockedTransport(c *C) {
+	tr := &countingTransport{}
+	theStore := store.New(&store.Config{}, nil)
+	theStore.MockDownloadTransport(tr)
+
+	var buf SillyBuffer
+	err := store.Download(context.TODO(), "foo", "", "http://example.com/foo.snap", nil, theStore, &buf, 0, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, "response-data")
+	c.Check(tr.n, Equals, 1)
+}
+
 func (s *downloadSuite) TestUseDeltas(c *C) {
 	origPath := os.Getenv("PATH")
 	defer os.Setenv("PATH", origPath)
@@ -429,7 +522,9 @@ type downloadBehaviour []struct {
 var deltaTests = []struct {
 	downloads       downloadBehaviour
 	info            snap.DownloadInfo
+	noDeltaSource   bool
 	expectedContent string
+	expectedStats   store.DeltaStats
 }{{
 	// The full snap is not downloaded, but rather the delta
 	// is downloaded and applied.
@@ -438,11 +533,13 @@ var deltaTests = []struct {
 	},
 	info: snap.DownloadInfo{
 		AnonDownloadURL: "full-snap-url",
+		Sha3_384:        "sha3",
 		Deltas: []snap.DeltaInfo{
-			{AnonDownloadURL: "delta-url", Format: "xdelta3"},
+			{AnonDownloadURL: "delta-url", Format: "xdelta3", Size: 5},
 		},
 	},
 	expectedContent: "snap-content-via-delta",
+	expectedStats:   store.DeltaStats{Hits: 1, BytesSaved: 17},
 }, {
 	// If there is an error during the delta download, the
 	// full snap is downloaded as per normal.
@@ -452,11 +549,13 @@ var deltaTests = []struct {
 	},
 	info: snap.DownloadInfo{
 		AnonDownloadURL: "full-snap-url",
+		Sha3_384:        "sha3",
 		Deltas: []snap.DeltaInfo{
 			{AnonDownloadURL: "delta-url", Format: "xdelta3"},
 		},
 	},
 	expectedContent: "full-snap-url-content",
+	expectedStats:   store.DeltaStats{Failures: 1},
 }, {
 	// If more than one matching delta is returned by the store
 	// we ignore deltas and do the full download.
@@ -465,12 +564,57 @@ var deltaTests = []struct {
 	},
 	info: snap.DownloadInfo{
 		AnonDownloadURL: "full-snap-url",
+		Sha3_384:        "sha3",
 		Deltas: []snap.DeltaInfo{
 			{AnonDownloadURL: "delta-url", Format: "xdelta3"},
 			{AnonDownloadURL: "delta-url-2", Format: "xdelta3"},
 		},
 	},
 	expectedContent: "full-snap-url-content",
+	expectedStats:   store.DeltaStats{Misses: 1},
+}, {
+	// If the snap the delta applies to is gone, the delta is
+	// not even downloaded.
+	downloads: downloadBehaviour{
+		{url: "full-snap-url"},
+	},
+	info: snap.DownloadInfo{
+		AnonDownloadURL: "full-snap-url",
+		Sha3_384:        "sha3",
+		Deltas: []snap.DeltaInfo{
+			{AnonDownloadURL: "delta-url", Format: "xdelta3"},
+		},
+	},
+	noDeltaSource:   true,
+	expectedContent: "full-snap-url-content",
+	expectedStats:   store.DeltaStats{Misses: 1},
+}, {
+	// Nor is it if the result could not be verified.
+	downloads: downloadBehaviour{
+		{url: "full-snap-url"},
+	},
+	info: snap.DownloadInfo{
+		AnonDownloadURL: "full-snap-url",
+		Deltas: []snap.DeltaInfo{
+			{AnonDownloadURL: "delta-url", Format: "xdelta3"},
+		},
+	},
+	expectedContent: "full-snap-url-content",
+	expectedStats:   store.DeltaStats{Misses: 1},
+}, {
+	// Nor is a delta in an unsupported format.
+	downloads: downloadBehaviour{
+		{url: "full-snap-url"},
+	},
+	info: snap.DownloadInfo{
+		AnonDownloadURL: "full-snap-url",
+		Sha3_384:        "sha3",
+		Deltas: []snap.DeltaInfo{
+			{AnonDownloadURL: "delta-url", Format: "bsdiff"},
+		},
+	},
+	expectedContent: "full-snap-url-content",
+	expectedStats:   store.DeltaStats{Misses: 1},
 }}
 
 func (s *downloadSuite) TestDownloadWithDelta(c *C) {
@@ -478,7 +622,15 @@ func (s *downloadSuite) TestDownloadWithDelta(c *C) {
 	defer os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", origUseDeltas)
 	c.Assert(os.Setenv("SNAPD_USE_DELTAS_EXPERIMENTAL", "1"), IsNil)
 
+	deltaSource := filepath.Join(dirs.SnapBlobDir, "foo_0.snap")
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+
 	for _, testCase := range deltaTests {
+		if testCase.noDeltaSource {
+			c.Assert(os.RemoveAll(deltaSource), IsNil)
+		} else {
+			c.Assert(ioutil.WriteFile(deltaSource, nil, 0600), IsNil)
+		}
 		testCase.info.Size = int64(len(testCase.expectedContent))
 		downloadIndex := 0
 		restore := store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
@@ -494,6 +646,7 @@ func (s *downloadSuite) TestDownloadWithDelta(c *C) {
 		defer restore()
 		restore = store.MockApplyDelta(func(name string, deltaPath string, deltaInfo *snap.DeltaInfo, targetPath string, targetSha3_384 string) error {
 			c.Check(deltaInfo, Equals, &testCase.info.Deltas[0])
+			c.Check(targetSha3_384, Equals, "sha3")
 			err := ioutil.WriteFile(targetPath, []byte("snap-content-via-delta"), 0644)
 			c.Assert(err, IsNil)
 			return nil
@@ -507,6 +660,8 @@ func (s *downloadSuite) TestDownloadWithDelta(c *C) {
 		c.Assert(err, IsNil)
 		defer os.Remove(path)
 		c.Assert(path, testutil.FileEquals, testCase.expectedContent)
+		c.Check(downloadIndex, Equals, len(testCase.downloads))
+		c.Check(theStore.DeltaStats(), DeepEquals, testCase.expectedStats)
 	}
 }
 
@@ -531,3 +686,33 @@ func (s *downloadSuite) TestActualDownloadRateLimited(c *C) {
 	c.Check(buf.String(), Equals, canary)
 	c.Check(ratelimitReaderUsed, Equals, true)
 }
+
+func (s *downloadSuite) TestActualDownloadRateLimitedSharedBucket(c *C) {
+	var buckets []*ratelimit.Bucket
+	restore := store.MockRatelimitReader(func(r io.Reader, bucket *ratelimit.Bucket) io.Reader {
+		buckets = append(buckets, bucket)
+		return r
+	})
+	defer restore()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "downloaded data")
+	}))
+	defer ts.Close()
+
+	theStore := store.New(&store.Config{}, nil)
+	for _, limit := range []int64{100, 100, 200} {
+		var buf SillyBuffer
+		err := store.Download(context.TODO(), "example-name", "", ts.URL, nil, theStore, &buf, 0, nil, &store.DownloadOptions{RateLimit: limit})
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(buckets, HasLen, 3)
+	// downloads with the same limit share the bucket, so that
+	// together they do not exceed it
+	c.Check(buckets[0], Equals, buckets[1])
+	c.Check(buckets[0].Rate(), Equals, float64(100))
+	// a new limit comes with a new bucket
+	c.Check(buckets[2], Not(Equals), buckets[0])
+	c.Check(buckets[2].Rate(), Equals, float64(200))
+}
@@ -65,6 +65,9 @@ var (
 
 	// ErrNoUpdateAvailable is returned when an update is attempetd for a snap that has no update available.
 	ErrNoUpdateAvailable = errors.New("snap has no updates available")
+
+	// ErrNoRefreshMetricsURL is returned when refresh metrics are reported but no endpoint to report them to is configured.
+	ErrNoRefreshMetricsURL = errors.New("no endpoint to report refresh metrics to")
 )
 
 // RevisionNotAvailableError is returned when an install is attempted for a snap but the/a revision is not available (given install constraints).
@@ -78,6 +81,16 @@ func (e *RevisionNotAvailableError) Error() string {
 	return "no snap revision available as specified"
 }
 
+// NoEntitlementError is returned when downloading a private or paid
+// snap the user or device is not, or no longer, entitled to.
+type NoEntitlementError struct {
+	Snap string
+}
+
+func (e *NoEntitlementError) Error() string {
+	return fmt.Sprintf("no entitlement to snap %q: it is private or needs to be bought, log in with an account that has access to it", e.Snap)
+}
+
 // DownloadError represents a download error
 type DownloadError struct {
 	Code int
@@ -25,10 +25,12 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/juju/ratelimit"
 	"gopkg.in/retry.v1"
 
+	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/snap"
@@ -85,6 +87,14 @@ func MockConnCheckStrategy(t *testutil.BaseTest, strategy retry.Strategy) {
 	})
 }
 
+func MockAssertionsProxyRetryStrategy(t *testutil.BaseTest, strategy retry.Strategy) {
+	originalAssertionsProxyRetryStrategy := assertionsProxyRetryStrategy
+	assertionsProxyRetryStrategy = strategy
+	t.AddCleanup(func() {
+		assertionsProxyRetryStrategy = originalAssertionsProxyRetryStrategy
+	})
+}
+
 func (cm *CacheManager) CacheDir() string {
 	return cm.cacheDir
 }
@@ -97,6 +107,28 @@ func (cm *CacheManager) Count() int {
 	return cm.count()
 }
 
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func (s *Store) MetadataCacheLen() int {
+	s.metaCache.mu.Lock()
+	defer s.metaCache.mu.Unlock()
+	return len(s.metaCache.entries)
+}
+
+func MockMaxMetadataCacheEntries(n int) (restore func()) {
+	old := maxMetadataCacheEntries
+	maxMetadataCacheEntries = n
+	return func() {
+		maxMetadataCacheEntries = old
+	}
+}
+
 func MockOsRemove(f func(name string) error) func() {
 	oldOsRemove := osRemove
 	osRemove = f
@@ -153,6 +185,14 @@ func (sto *Store) Client() *http.Client {
 	return sto.client
 }
 
+// MockDownloadTransport makes the downloads of the store go through the
+// given transport.
+func (sto *Store) MockDownloadTransport(tr http.RoundTripper) {
+	sto.downloadClient = httputil.NewHTTPClient(&httputil.ClientOptions{
+		Transport: tr,
+	})
+}
+
 func (sto *Store) DetailFields() []string {
 	return sto.detailFields
 }
@@ -191,3 +231,5 @@ func MockRatelimitReader(f func(r io.Reader, bucket *ratelimit.Bucket) io.Reader
 		ratelimitReader = oldRatelimitReader
 	}
 }
+
+var RefreshMetricsURL = refreshMetricsURL
@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lanpeers
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+const (
+	// serviceName is the DNS-SD service snapd advertises its blobs as.
+	serviceName = "_snapd-blobs._tcp.local."
+	// recordTTL is the time to live of the records given out, as
+	// recommended for legacy unicast responses.
+	recordTTL = 10
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// responder answers the multicast DNS queries for the blobs service
+// with the instance of this machine.
+type responder struct {
+	conn     *net.UDPConn
+	instance string
+	port     uint16
+}
+
+func newResponder(ifi *net.Interface, instance string, port uint16) (*responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", ifi, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &responder{
+		conn:     conn,
+		instance: instance + "." + serviceName,
+		port:     port,
+	}, nil
+}
+
+// answer returns the response to the query, or nil if the query is not
+// about the blobs service.
+func (r *responder) answer(query *message) *message {
+	if query.isResponse() {
+		return nil
+	}
+	for _, q := range query.questions {
+		if !strings.EqualFold(q.name, serviceName) || (q.qtype != typePTR && q.qtype != typeSRV) {
+			continue
+		}
+		return &message{
+			id:        query.id,
+			flags:     flagResponse | flagAuthoritative,
+			questions: []question{q},
+			answers: []record{{
+				name:   serviceName,
+				rtype:  typePTR,
+				rclass: classIN,
+				ttl:    recordTTL,
+				target: r.instance,
+			}, {
+				name:   r.instance,
+				rtype:  typeSRV,
+				rclass: classIN | classTopBit,
+				ttl:    recordTTL,
+				// peers use the address the answer comes from
+				// rather than resolving the target
+				target: r.instance,
+				port:   r.port,
+			}, {
+				name:   r.instance,
+				rtype:  typeTXT,
+				rclass: classIN | classTopBit,
+				ttl:    recordTTL,
+				txt:    []string{"txtvers=1"},
+			}},
+		}
+	}
+	return nil
+}
+
+// serve answers queries until the responder is closed.
+func (r *responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		query, err := parseMessage(buf[:n])
+		if err != nil {
+			logger.Debugf("ignoring invalid mDNS message from %v: %v", src, err)
+			continue
+		}
+		resp := r.answer(query)
+		if resp == nil {
+			continue
+		}
+		// queries from other ports than the mDNS one, or asking for
+		// a unicast response, are answered directly
+		dst := mdnsGroup
+		if src.Port != mdnsGroup.Port || resp.questions[0].qclass&classTopBit != 0 {
+			dst = src
+		} else {
+			resp.id = 0
+			resp.questions = nil
+		}
+		if _, err := r.conn.WriteToUDP(resp.pack(), dst); err != nil {
+			logger.Debugf("cannot answer mDNS query from %v: %v", src, err)
+		}
+	}
+}
+
+func (r *responder) close() error {
+	return r.conn.Close()
+}
+
+// peer is an instance of the blobs service found on the network.
+type peer struct {
+	instance string
+	addr     string
+}
+
+// browse asks the network of the local address for the instances of
+// the blobs service and collects the answers until the timeout or the
+// context are done.
+func browse(ctx context.Context, local net.IP, timeout time.Duration) ([]peer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: local})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := &message{
+		questions: []question{{
+			name:   serviceName,
+			qtype:  typePTR,
+			qclass: classIN | classTopBit,
+		}},
+	}
+	if _, err := conn.WriteToUDP(query.pack(), mdnsGroup); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock the read
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	var peers []peer
+	seen := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return peers, nil
+			}
+			return peers, err
+		}
+		resp, err := parseMessage(buf[:n])
+		if err != nil || !resp.isResponse() {
+			continue
+		}
+		for _, rr := range resp.answers {
+			if rr.rtype != typeSRV || !strings.HasSuffix(strings.ToLower(rr.name), "."+serviceName) {
+				continue
+			}
+			p := peer{
+				instance: rr.name[:len(rr.name)-len(serviceName)-1],
+				addr:     net.JoinHostPort(src.IP.String(), strconv.Itoa(int(rr.port))),
+			}
+			if !seen[p.addr] {
+				seen[p.addr] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lanpeers
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type Question = question
+type Record = record
+type Message = message
+
+var (
+	ParseMessage = parseMessage
+	ServiceName  = serviceName
+)
+
+const (
+	TypePTR     = typePTR
+	TypeSRV     = typeSRV
+	TypeTXT     = typeTXT
+	ClassIN     = classIN
+	ClassTopBit = classTopBit
+)
+
+func (m *Message) Pack() []byte {
+	return m.pack()
+}
+
+func NewMessage(id, flags uint16, questions []Question, answers []Record) *Message {
+	return &message{id: id, flags: flags, questions: questions, answers: answers}
+}
+
+func NewQuestion(name string, qtype, qclass uint16) Question {
+	return question{name: name, qtype: qtype, qclass: qclass}
+}
+
+func NewRecord(name string, rtype, rclass uint16, ttl uint32, target string, port uint16, txt []string) Record {
+	return record{name: name, rtype: rtype, rclass: rclass, ttl: ttl, target: target, port: port, txt: txt}
+}
+
+func (m *Message) ID() uint16            { return m.id }
+func (m *Message) Flags() uint16         { return m.flags }
+func (m *Message) Questions() []Question { return m.questions }
+func (m *Message) Answers() []Record     { return m.answers }
+
+func (q Question) Name() string { return q.name }
+
+func (r Record) Name() string   { return r.name }
+func (r Record) Type() uint16   { return r.rtype }
+func (r Record) Target() string { return r.target }
+func (r Record) Port() uint16   { return r.port }
+func (r Record) TXT() []string  { return r.txt }
+
+// Answer returns the answer of a responder for the instance on the port.
+func Answer(instance string, port uint16, query *Message) *Message {
+	r := &responder{instance: instance + "." + serviceName, port: port}
+	return r.answer(query)
+}
+
+type MockPeer struct {
+	Instance string
+	Addr     string
+}
+
+// MockPeers makes the service find the given peers instead of browsing
+// for them, as if it was running as the given instance on the loopback
+// network.
+func (s *Service) MockPeers(instance string, peers []MockPeer) (browses *int) {
+	browses = new(int)
+	s.instance = instance
+	s.running = true
+	s.addr = net.IPv4(127, 0, 0, 1)
+	s.network = &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+	s.peers = nil
+	s.browse = func(ctx context.Context, local net.IP, timeout time.Duration) ([]peer, error) {
+		*browses++
+		var found []peer
+		for _, p := range peers {
+			found = append(found, peer{instance: p.Instance, addr: p.Addr})
+		}
+		return found, nil
+	}
+	return browses
+}
+
+func MockMaxUploads(n int) (restore func()) {
+	old := maxUploads
+	maxUploads = n
+	return func() { maxUploads = old }
+}
+
+var InterfaceNetwork = interfaceNetwork
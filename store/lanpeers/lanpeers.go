@@ -0,0 +1,432 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package lanpeers shares the snap blobs kept in the download cache
+// with the other machines of the local network, and fetches blobs from
+// theirs before going to the store.
+//
+// Machines find each other by advertising and browsing for the
+// _snapd-blobs._tcp service over multicast DNS, and hand out blobs over
+// HTTP by their sha3-384. All of this happens only on the network
+// interface the service is started on, and only with peers from the
+// network of that interface. Only the blobs of revisions the store
+// hands out to anyone, neither private nor paid, are handed out, as
+// told by Share. The digest of a blob fetched from a peer is always
+// checked, so a peer cannot hand out anything but the blob the store
+// said to download, which is in turn checked against its snap-revision
+// assertion before installing it as for any download.
+package lanpeers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "golang.org/x/crypto/sha3" // expected for digests
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/progress"
+)
+
+// ErrNotFound is returned by Fetch when no peer has the blob.
+var ErrNotFound = errors.New("blob not found on the local network")
+
+var (
+	// how long to wait for peers to answer
+	browseTimeout = 1 * time.Second
+	// how long the peers found are remembered
+	peersTTL = 1 * time.Minute
+	// how many blobs are served at once, further requests are
+	// refused so that peers try elsewhere
+	maxUploads = 4
+
+	dialTimeout           = 2 * time.Second
+	responseHeaderTimeout = 5 * time.Second
+)
+
+var blobPath = regexp.MustCompile(`^/v1/blobs/([0-9a-f]{96})$`)
+
+// Service advertises the blobs of a download cache to the local
+// network, and fetches blobs from the ones of its peers.
+type Service struct {
+	cacheDir string
+	client   *http.Client
+
+	mu        sync.Mutex
+	running   bool
+	instance  string
+	iface     string
+	addr      net.IP
+	network   *net.IPNet
+	listener  net.Listener
+	responder *responder
+	uploads   chan struct{}
+	shared    map[string]bool
+	peers     []peer
+	peersTime time.Time
+	// browse is overridden in tests
+	browse func(ctx context.Context, local net.IP, timeout time.Duration) ([]peer, error)
+}
+
+// New returns a Service sharing the blobs in the given download cache
+// directory. It does nothing until started.
+func New(cacheDir string) *Service {
+	return &Service{
+		cacheDir: cacheDir,
+		client: &http.Client{
+			Transport: &http.Transport{
+				// peers are on the local network, never go
+				// through a proxy to reach them
+				Proxy: nil,
+				DialContext: (&net.Dialer{
+					Timeout: dialTimeout,
+				}).DialContext,
+				ResponseHeaderTimeout: responseHeaderTimeout,
+			},
+		},
+		uploads: make(chan struct{}, maxUploads),
+		shared:  make(map[string]bool),
+		browse:  browse,
+	}
+}
+
+func instanceName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	host = strings.Split(host, ".")[0]
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	// labels are at most 63 bytes long
+	if len(host) > 54 {
+		host = host[:54]
+	}
+	return fmt.Sprintf("%s-%x", host, b), nil
+}
+
+// interfaceNetwork returns the IPv4 address of the network interface
+// with the given name, and the network it is on.
+func interfaceNetwork(name string) (net.IP, *net.IPNet, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			return ip4, &net.IPNet{IP: ip4.Mask(ipnet.Mask), Mask: ipnet.Mask}, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("network interface %q has no IPv4 address", name)
+}
+
+// Start starts serving the blobs and advertising them on the network
+// interface with the given name. Starting a Service running on the
+// same interface does nothing, one running on another interface is
+// moved to the given one.
+func (s *Service) Start(iface string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		if s.iface == iface {
+			return nil
+		}
+		if err := s.stop(); err != nil {
+			return err
+		}
+	}
+	if iface == "" {
+		return fmt.Errorf("no network interface to share snap blobs on")
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	addr, network, err := interfaceNetwork(iface)
+	if err != nil {
+		return err
+	}
+	instance, err := instanceName()
+	if err != nil {
+		return err
+	}
+	// only listen on the interface, not on all of them
+	l, err := net.Listen("tcp4", net.JoinHostPort(addr.String(), "0"))
+	if err != nil {
+		return err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	r, err := newResponder(ifi, instance, uint16(port))
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("cannot advertise snap blobs: %v", err)
+	}
+	go http.Serve(l, s)
+	go r.serve()
+
+	s.running = true
+	s.instance = instance
+	s.iface = iface
+	s.addr = addr
+	s.network = network
+	s.listener = l
+	s.responder = r
+	s.peers = nil
+	logger.Noticef("Sharing downloaded snaps with %s on %s as %q on port %d.", network, iface, instance, port)
+	return nil
+}
+
+// Stop stops serving and advertising the blobs. Stopping a stopped
+// Service does nothing.
+func (s *Service) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil
+	}
+	return s.stop()
+}
+
+func (s *Service) stop() error {
+	err := s.listener.Close()
+	if rerr := s.responder.close(); err == nil {
+		err = rerr
+	}
+	s.running = false
+	s.listener = nil
+	s.responder = nil
+	s.instance = ""
+	s.iface = ""
+	s.addr = nil
+	s.network = nil
+	s.peers = nil
+	return err
+}
+
+// fromNetwork returns whether the given host:port address is on the
+// network of the interface the Service runs on.
+func (s *Service) fromNetwork(hostport string) bool {
+	s.mu.Lock()
+	network := s.network
+	s.mu.Unlock()
+	if network == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && network.Contains(ip)
+}
+
+// Share allows handing out the blob with the given sha3-384 to peers.
+// It must only be called for the blobs of revisions the store hands out
+// to anyone, as knowing their digest is then enough to get them.
+func (s *Service) Share(sha3_384 string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shared[sha3_384] = true
+}
+
+func (s *Service) isShared(sha3_384 string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shared[sha3_384]
+}
+
+// ServeHTTP hands out the shared blobs of the download cache by their
+// sha3-384, to peers on the network of the interface the Service runs
+// on.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.fromNetwork(r.RemoteAddr) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	m := blobPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || !s.isShared(m[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case s.uploads <- struct{}{}:
+		defer func() { <-s.uploads }()
+	default:
+		http.Error(w, "too many downloads", http.StatusServiceUnavailable)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(s.cacheDir, m[1]))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// currentPeers returns the peers found on the network, other than this
+// machine, browsing for them again if the ones known are too old.
+func (s *Service) currentPeers(ctx context.Context) ([]peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil, nil
+	}
+	if s.peers != nil && time.Since(s.peersTime) < peersTTL {
+		return s.peers, nil
+	}
+	found, err := s.browse(ctx, s.addr, browseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peers := []peer{}
+	for _, p := range found {
+		if p.instance == s.instance {
+			continue
+		}
+		// answers from elsewhere than the network of the
+		// interface are not from peers
+		host, _, err := net.SplitHostPort(p.addr)
+		if err != nil || !s.network.Contains(net.ParseIP(host)) {
+			logger.Debugf("ignoring snap blobs peer %q at %s outside of %s", p.instance, p.addr, s.network)
+			continue
+		}
+		peers = append(peers, p)
+	}
+	s.peers = peers
+	s.peersTime = time.Now()
+	return peers, nil
+}
+
+// Fetch downloads the blob with the given sha3-384 and size from one of
+// the peers into targetPath, after checking its digest. It returns
+// ErrNotFound if the Service is not running or no peer has the blob.
+func (s *Service) Fetch(ctx context.Context, name, sha3_384 string, size int64, targetPath string, pbar progress.Meter) error {
+	peers, err := s.currentPeers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		return ErrNotFound
+	}
+	if pbar == nil {
+		pbar = progress.Null
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(targetPath), filepath.Base(targetPath)+".lan-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	for _, p := range peers {
+		err := s.fetchFrom(ctx, p.addr, name, sha3_384, size, f, pbar)
+		if err == nil {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			logger.Noticef("Downloaded %s from %s on the local network.", name, p.instance)
+			return os.Rename(f.Name(), targetPath)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != ErrNotFound {
+			logger.Noticef("Cannot download %s from %s on the local network: %v", name, p.instance, err)
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *Service) fetchFrom(ctx context.Context, addr, name, sha3_384 string, size int64, w io.Writer, pbar progress.Meter) error {
+	req, err := http.NewRequest("GET", "http://"+addr+"/v1/blobs/"+sha3_384, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	h := crypto.SHA3_384.New()
+	pbar.Start(name, float64(size))
+	n, err := io.Copy(io.MultiWriter(w, h, pbar), io.LimitReader(resp.Body, size+1))
+	pbar.Finished()
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("got %d bytes instead of %d", n, size)
+	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != sha3_384 {
+		return fmt.Errorf("sha3-384 mismatch: got %s, expected %s", actual, sha3_384)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lanpeers_test
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/progress/progresstest"
+	"github.com/snapcore/snapd/store/lanpeers"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type lanpeersSuite struct {
+	cacheDir  string
+	targetDir string
+}
+
+var _ = Suite(&lanpeersSuite{})
+
+func (s *lanpeersSuite) SetUpTest(c *C) {
+	s.cacheDir = c.MkDir()
+	s.targetDir = c.MkDir()
+}
+
+func sha3_384(content string) string {
+	h := crypto.SHA3_384.New()
+	h.Write([]byte(content))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// peer returns a peer serving the blobs of its own cache
+func (s *lanpeersSuite) peer(c *C, blobs ...string) *httptest.Server {
+	cacheDir := c.MkDir()
+	for _, blob := range blobs {
+		c.Assert(ioutil.WriteFile(filepath.Join(cacheDir, sha3_384(blob)), []byte(blob), 0600), IsNil)
+	}
+	svc := lanpeers.New(cacheDir)
+	for _, blob := range blobs {
+		svc.Share(sha3_384(blob))
+	}
+	return httptest.NewServer(svc)
+}
+
+func (s *lanpeersSuite) TestServeBlob(c *C) {
+	blob := "snap blob"
+	digest := sha3_384(blob)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.cacheDir, digest), []byte(blob), 0600), IsNil)
+	svc := lanpeers.New(s.cacheDir)
+	svc.Share(digest)
+
+	rec := httptest.NewRecorder()
+	svc.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/blobs/"+digest, nil))
+	c.Check(rec.Code, Equals, 200)
+	c.Check(rec.Body.String(), Equals, blob)
+	c.Check(rec.Header().Get("Content-Type"), Equals, "application/octet-stream")
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/blobs/"+digest, nil)
+	req.Header.Set("Range", "bytes=5-")
+	svc.ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 206)
+	c.Check(rec.Body.String(), Equals, "blob")
+
+	rec = httptest.NewRecorder()
+	svc.ServeHTTP(rec, httptest.NewRequest("HEAD", "/v1/blobs/"+digest, nil))
+	c.Check(rec.Code, Equals, 200)
+	c.Check(rec.Body.String(), Equals, "")
+}
+
+func (s *lanpeersSuite) TestServeBlobErrors(c *C) {
+	c.Assert(os.Mkdir(filepath.Join(s.cacheDir, sha3_384("dir")), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.cacheDir, sha3_384("private")), []byte("private"), 0600), IsNil)
+	svc := lanpeers.New(s.cacheDir)
+	svc.Share(sha3_384("missing"))
+	svc.Share(sha3_384("dir"))
+
+	for _, t := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/v1/blobs/" + sha3_384("missing"), 404},
+		{"GET", "/v1/blobs/" + sha3_384("dir"), 404},
+		// in the cache but not shared
+		{"GET", "/v1/blobs/" + sha3_384("private"), 404},
+		{"GET", "/v1/blobs/../state.json", 404},
+		{"GET", "/v1/blobs/" + strings.ToUpper(sha3_384("missing")), 404},
+		{"GET", "/", 404},
+		{"POST", "/v1/blobs/" + sha3_384("missing"), 405},
+	} {
+		rec := httptest.NewRecorder()
+		svc.ServeHTTP(rec, httptest.NewRequest(t.method, t.path, nil))
+		c.Check(rec.Code, Equals, t.code, Commentf("%s %s", t.method, t.path))
+	}
+}
+
+func (s *lanpeersSuite) TestServeBlobBusy(c *C) {
+	restore := lanpeers.MockMaxUploads(0)
+	defer restore()
+	blob := "snap blob"
+	digest := sha3_384(blob)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.cacheDir, digest), []byte(blob), 0600), IsNil)
+	svc := lanpeers.New(s.cacheDir)
+	svc.Share(digest)
+
+	rec := httptest.NewRecorder()
+	svc.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/blobs/"+digest, nil))
+	c.Check(rec.Code, Equals, 503)
+}
+
+func (s *lanpeersSuite) TestFetch(c *C) {
+	blob := "snap blob"
+	digest := sha3_384(blob)
+	empty := s.peer(c)
+	defer empty.Close()
+	full := s.peer(c, blob)
+	defer full.Close()
+
+	svc := lanpeers.New(s.cacheDir)
+	browses := svc.MockPeers("self", []lanpeers.MockPeer{
+		{Instance: "empty", Addr: empty.Listener.Addr().String()},
+		{Instance: "full", Addr: full.Listener.Addr().String()},
+	})
+
+	pbar := &progresstest.Meter{}
+	targetPath := filepath.Join(s.targetDir, "foo_1.snap")
+	err := svc.Fetch(context.Background(), "foo", digest, int64(len(blob)), targetPath, pbar)
+	c.Assert(err, IsNil)
+	c.Check(targetPath, testutil.FileEquals, blob)
+	c.Check(pbar.Labels, DeepEquals, []string{"foo"})
+	c.Check(pbar.Totals, DeepEquals, []float64{float64(len(blob))})
+	c.Check(pbar.Finishes, Equals, 1)
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(s.targetDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 1)
+
+	// the peers are remembered for a while
+	err = svc.Fetch(context.Background(), "foo", digest, int64(len(blob)), targetPath, nil)
+	c.Assert(err, IsNil)
+	c.Check(*browses, Equals, 1)
+}
+
+func (s *lanpeersSuite) TestFetchChecksDigest(c *C) {
+	blob := "snap blob"
+	digest := sha3_384(blob)
+	// a peer with something else than the blob under its digest
+	bogusDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(bogusDir, digest), []byte("evil blob"), 0600), IsNil)
+	bogus := httptest.NewServer(lanpeers.New(bogusDir))
+	defer bogus.Close()
+	// and one with too much of it
+	longDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(longDir, digest), []byte(blob+"and more"), 0600), IsNil)
+	long := httptest.NewServer(lanpeers.New(longDir))
+	defer long.Close()
+
+	svc := lanpeers.New(s.cacheDir)
+	svc.MockPeers("self", []lanpeers.MockPeer{
+		{Instance: "bogus", Addr: bogus.Listener.Addr().String()},
+		{Instance: "long", Addr: long.Listener.Addr().String()},
+	})
+
+	targetPath := filepath.Join(s.targetDir, "foo_1.snap")
+	err := svc.Fetch(context.Background(), "foo", digest, int64(len(blob)), targetPath, nil)
+	c.Check(err, Equals, lanpeers.ErrNotFound)
+	files, err := ioutil.ReadDir(s.targetDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+
+	// a good peer comes along
+	good := s.peer(c, blob)
+	defer good.Close()
+	svc.MockPeers("self", []lanpeers.MockPeer{
+		{Instance: "bogus", Addr: bogus.Listener.Addr().String()},
+		{Instance: "good", Addr: good.Listener.Addr().String()},
+	})
+	err = svc.Fetch(context.Background(), "foo", digest, int64(len(blob)), targetPath, nil)
+	c.Assert(err, IsNil)
+	c.Check(targetPath, testutil.FileEquals, blob)
+}
+
+func (s *lanpeersSuite) TestFetchSkipsSelf(c *C) {
+	blob := "snap blob"
+	self := s.peer(c, blob)
+	defer self.Close()
+
+	svc := lanpeers.New(s.cacheDir)
+	svc.MockPeers("self", []lanpeers.MockPeer{
+		{Instance: "self", Addr: self.Listener.Addr().String()},
+	})
+	err := svc.Fetch(context.Background(), "foo", sha3_384(blob), int64(len(blob)), filepath.Join(s.targetDir, "foo_1.snap"), nil)
+	c.Check(err, Equals, lanpeers.ErrNotFound)
+}
+
+func (s *lanpeersSuite) TestFetchNotRunning(c *C) {
+	svc := lanpeers.New(s.cacheDir)
+	err := svc.Fetch(context.Background(), "foo", sha3_384("blob"), 4, filepath.Join(s.targetDir, "foo_1.snap"), nil)
+	c.Check(err, Equals, lanpeers.ErrNotFound)
+}
+
+func (s *lanpeersSuite) TestFetchSkipsPeersOutsideNetwork(c *C) {
+	blob := "snap blob"
+	svc := lanpeers.New(s.cacheDir)
+	browses := svc.MockPeers("self", []lanpeers.MockPeer{
+		{Instance: "elsewhere", Addr: "192.0.2.1:4242"},
+	})
+	err := svc.Fetch(context.Background(), "foo", sha3_384(blob), int64(len(blob)), filepath.Join(s.targetDir, "foo_1.snap"), nil)
+	c.Check(err, Equals, lanpeers.ErrNotFound)
+	c.Check(*browses, Equals, 1)
+}
+
+func (s *lanpeersSuite) TestServeBlobOnlyToNetwork(c *C) {
+	blob := "snap blob"
+	digest := sha3_384(blob)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.cacheDir, digest), []byte(blob), 0600), IsNil)
+	svc := lanpeers.New(s.cacheDir)
+	svc.Share(digest)
+	// runs on the loopback network
+	svc.MockPeers("self", nil)
+
+	for _, t := range []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"127.0.0.2:4242", 200},
+		{"192.0.2.1:4242", 403},
+		{"bogus", 403},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/blobs/"+digest, nil)
+		req.RemoteAddr = t.remoteAddr
+		svc.ServeHTTP(rec, req)
+		c.Check(rec.Code, Equals, t.code, Commentf(t.remoteAddr))
+	}
+}
+
+func (s *lanpeersSuite) TestInterfaceNetwork(c *C) {
+	_, _, err := lanpeers.InterfaceNetwork("no-such-iface0")
+	c.Check(err, NotNil)
+}
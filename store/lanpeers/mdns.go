@@ -0,0 +1,302 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lanpeers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This is the small subset of DNS (RFC1035) needed to advertise and to
+// browse for a DNS-SD (RFC6763) service over multicast DNS (RFC6762).
+
+const (
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+
+	classIN = 1
+	// the top bit of the class of a question asks for a unicast
+	// response, the one of a record marks it as the only one of its
+	// name and type (a "cache flush")
+	classTopBit = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+
+	// names are limited to 255 bytes, so following more pointers than
+	// this can only be a loop
+	maxNamePointers = 128
+	maxNameLen      = 255
+)
+
+var errTruncated = errors.New("truncated DNS message")
+
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type record struct {
+	name   string
+	rtype  uint16
+	rclass uint16
+	ttl    uint32
+
+	// target is the name pointed to by PTR and SRV records
+	target string
+	// port is the port of SRV records
+	port uint16
+	// txt is the content of TXT records
+	txt []string
+}
+
+type message struct {
+	id        uint16
+	flags     uint16
+	questions []question
+	// answers holds the answer, authority and additional records, the
+	// distinction does not matter here
+	answers []record
+}
+
+func (m *message) isResponse() bool {
+	return m.flags&flagResponse != 0
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendName(b []byte, name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+func (r *record) appendData(b []byte) []byte {
+	switch r.rtype {
+	case typePTR:
+		return appendName(b, r.target)
+	case typeSRV:
+		// priority and weight
+		b = appendUint16(b, 0)
+		b = appendUint16(b, 0)
+		b = appendUint16(b, r.port)
+		return appendName(b, r.target)
+	case typeTXT:
+		if len(r.txt) == 0 {
+			// a TXT record has at least one string
+			return append(b, 0)
+		}
+		for _, s := range r.txt {
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+		return b
+	}
+	return b
+}
+
+// pack returns the wire format of the message, without any name
+// compression.
+func (m *message) pack() []byte {
+	b := make([]byte, 0, 512)
+	b = appendUint16(b, m.id)
+	b = appendUint16(b, m.flags)
+	b = appendUint16(b, uint16(len(m.questions)))
+	b = appendUint16(b, uint16(len(m.answers)))
+	// authority and additional records
+	b = appendUint16(b, 0)
+	b = appendUint16(b, 0)
+	for _, q := range m.questions {
+		b = appendName(b, q.name)
+		b = appendUint16(b, q.qtype)
+		b = appendUint16(b, q.qclass)
+	}
+	for i := range m.answers {
+		r := &m.answers[i]
+		b = appendName(b, r.name)
+		b = appendUint16(b, r.rtype)
+		b = appendUint16(b, r.rclass)
+		b = appendUint32(b, r.ttl)
+		lenOff := len(b)
+		b = appendUint16(b, 0)
+		b = r.appendData(b)
+		binary.BigEndian.PutUint16(b[lenOff:], uint16(len(b)-lenOff-2))
+	}
+	return b
+}
+
+// readName reads the possibly compressed name at off in the message,
+// returning it and the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	// the length of the name on the wire, without compression
+	nameLen := 1
+	for pointers := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errTruncated
+		}
+		l := int(msg[off])
+		switch l & 0xC0 {
+		case 0x00:
+			off++
+			if l == 0 {
+				if end < 0 {
+					end = off
+				}
+				return strings.Join(labels, ".") + ".", end, nil
+			}
+			if off+l > len(msg) {
+				return "", 0, errTruncated
+			}
+			nameLen += 1 + l
+			if nameLen > maxNameLen {
+				return "", 0, fmt.Errorf("DNS name too long")
+			}
+			labels = append(labels, string(msg[off:off+l]))
+			off += l
+		case 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errTruncated
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			pointers++
+			if pointers > maxNamePointers {
+				return "", 0, fmt.Errorf("too many compression pointers in DNS name")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			return "", 0, fmt.Errorf("invalid DNS label length %#x", l)
+		}
+	}
+}
+
+func readUint16(msg []byte, off int) (uint16, int, error) {
+	if off+2 > len(msg) {
+		return 0, 0, errTruncated
+	}
+	return binary.BigEndian.Uint16(msg[off:]), off + 2, nil
+}
+
+func parseRecord(msg []byte, off int) (record, int, error) {
+	var r record
+	var err error
+	r.name, off, err = readName(msg, off)
+	if err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(msg) {
+		return r, 0, errTruncated
+	}
+	r.rtype = binary.BigEndian.Uint16(msg[off:])
+	r.rclass = binary.BigEndian.Uint16(msg[off+2:])
+	r.ttl = binary.BigEndian.Uint32(msg[off+4:])
+	dataLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + dataLen
+	if end > len(msg) {
+		return r, 0, errTruncated
+	}
+	// names in the data must not run past it
+	nameEnd := off
+	switch r.rtype {
+	case typePTR:
+		r.target, nameEnd, err = readName(msg, off)
+	case typeSRV:
+		if dataLen < 6 {
+			return r, 0, errTruncated
+		}
+		r.port = binary.BigEndian.Uint16(msg[off+4:])
+		r.target, nameEnd, err = readName(msg, off+6)
+	case typeTXT:
+		for i := off; i < end; {
+			l := int(msg[i])
+			if i+1+l > end {
+				return r, 0, errTruncated
+			}
+			r.txt = append(r.txt, string(msg[i+1:i+1+l]))
+			i += 1 + l
+		}
+	}
+	if err != nil {
+		return r, 0, err
+	}
+	if nameEnd > end {
+		return r, 0, errTruncated
+	}
+	return r, end, nil
+}
+
+func parseMessage(msg []byte) (*message, error) {
+	if len(msg) < 12 {
+		return nil, errTruncated
+	}
+	m := &message{
+		id:    binary.BigEndian.Uint16(msg[0:]),
+		flags: binary.BigEndian.Uint16(msg[2:]),
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := 0
+	for _, i := range []int{6, 8, 10} {
+		rrcount += int(binary.BigEndian.Uint16(msg[i:]))
+	}
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var q question
+		var err error
+		q.name, off, err = readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if q.qtype, off, err = readUint16(msg, off); err != nil {
+			return nil, err
+		}
+		if q.qclass, off, err = readUint16(msg, off); err != nil {
+			return nil, err
+		}
+		m.questions = append(m.questions, q)
+	}
+	for i := 0; i < rrcount; i++ {
+		r, next, err := parseRecord(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		m.answers = append(m.answers, r)
+		off = next
+	}
+	return m, nil
+}
@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lanpeers_test
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/store/lanpeers"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type mdnsSuite struct{}
+
+var _ = Suite(&mdnsSuite{})
+
+func (s *mdnsSuite) TestPackParseRoundTrip(c *C) {
+	m := lanpeers.NewMessage(42, 0x8400,
+		[]lanpeers.Question{lanpeers.NewQuestion(lanpeers.ServiceName, lanpeers.TypePTR, lanpeers.ClassIN|lanpeers.ClassTopBit)},
+		[]lanpeers.Record{
+			lanpeers.NewRecord(lanpeers.ServiceName, lanpeers.TypePTR, lanpeers.ClassIN, 10, "foo."+lanpeers.ServiceName, 0, nil),
+			lanpeers.NewRecord("foo."+lanpeers.ServiceName, lanpeers.TypeSRV, lanpeers.ClassIN, 10, "foo.local.", 4242, nil),
+			lanpeers.NewRecord("foo."+lanpeers.ServiceName, lanpeers.TypeTXT, lanpeers.ClassIN, 10, "", 0, []string{"txtvers=1", "a=b"}),
+		})
+
+	parsed, err := lanpeers.ParseMessage(m.Pack())
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, m)
+}
+
+func (s *mdnsSuite) TestParseCompressedNames(c *C) {
+	msg := []byte{
+		0, 0, 0x84, 0, // id, flags
+		0, 0, 0, 1, 0, 0, 0, 0, // 1 answer
+		// _snapd-blobs._tcp.local. at offset 12
+		12, '_', 's', 'n', 'a', 'p', 'd', '-', 'b', 'l', 'o', 'b', 's',
+		4, '_', 't', 'c', 'p',
+		5, 'l', 'o', 'c', 'a', 'l',
+		0,
+		0, 12, 0, 1, // PTR, IN
+		0, 0, 0, 10, // ttl
+		0, 6, // length
+		3, 'f', 'o', 'o', 0xC0, 12, // foo + pointer to the service name
+	}
+	m, err := lanpeers.ParseMessage(msg)
+	c.Assert(err, IsNil)
+	c.Assert(m.Answers(), HasLen, 1)
+	c.Check(m.Answers()[0].Name(), Equals, lanpeers.ServiceName)
+	c.Check(m.Answers()[0].Target(), Equals, "foo."+lanpeers.ServiceName)
+}
+
+func (s *mdnsSuite) TestParseErrors(c *C) {
+	for _, t := range []struct {
+		msg []byte
+		err string
+	}{
+		{[]byte{0, 0, 0}, "truncated DNS message"},
+		// a question with a name running past the end
+		{[]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'}, "truncated DNS message"},
+		// a question with a name pointing to itself
+		{[]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 12, 0, 1}, "too many compression pointers in DNS name"},
+		// a bogus label length
+		{[]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 12, 0, 1}, "invalid DNS label length 0x40"},
+		// an answer with its data running past the end
+		{[]byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 12, 0, 1, 0, 0, 0, 10, 0, 6, 3}, "truncated DNS message"},
+	} {
+		_, err := lanpeers.ParseMessage(t.msg)
+		c.Check(err, ErrorMatches, t.err, Commentf("%v", t.msg))
+	}
+}
+
+// headers of messages with one question and one answer respectively,
+// which start at offset 12
+var (
+	questionHeader = []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	answerHeader   = []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+)
+
+func withHeader(header []byte, rest ...byte) []byte {
+	return append(append([]byte(nil), header...), rest...)
+}
+
+func (s *mdnsSuite) TestParseTruncatedCompressedNames(c *C) {
+	for _, msg := range [][]byte{
+		// a pointer missing its second byte
+		withHeader(questionHeader, 0xC0),
+		// a label and a pointer missing its second byte
+		withHeader(questionHeader, 1, 'a', 0xC0),
+		// a pointer past the end of the message
+		withHeader(questionHeader, 0xC0, 0xFF, 0, 12, 0, 1),
+		// a pointer to a label running past the end of the message
+		withHeader(questionHeader, 0xC0, 18, 0, 12, 0, 1, 9, 'a'),
+		// a pointer to a label with no terminating label after it
+		withHeader(questionHeader, 0xC0, 18, 0, 12, 0, 1, 1, 'a'),
+		// a pointer fine on its own, but the question type is missing
+		withHeader(questionHeader, 1, 'a', 0, 0xC0, 12, 0),
+		// a PTR record whose target runs past its data into the
+		// next bytes of the message
+		withHeader(answerHeader, 0, 0, 12, 0, 1, 0, 0, 0, 10, 0, 2, 3, 'f', 'o', 'o', 0),
+		// an SRV record whose target runs past its data
+		withHeader(answerHeader, 0, 0, 33, 0, 1, 0, 0, 0, 10, 0, 7, 0, 0, 0, 0, 0x10, 0x92, 3, 'f', 'o', 'o', 0),
+	} {
+		_, err := lanpeers.ParseMessage(msg)
+		c.Check(err, ErrorMatches, "truncated DNS message", Commentf("%v", msg))
+	}
+}
+
+func (s *mdnsSuite) TestParseLoopingCompressedNames(c *C) {
+	for _, t := range []struct {
+		msg []byte
+		err string
+	}{
+		// two pointers pointing to each other
+		{withHeader(questionHeader, 0xC0, 14, 0xC0, 12, 0, 12, 0, 1), "too many compression pointers in DNS name"},
+		// a pointer to a later pointer back to the first one
+		{withHeader(questionHeader, 0xC0, 18, 0, 12, 0, 1, 0xC0, 12), "too many compression pointers in DNS name"},
+		// a label followed by a pointer back to it, which would make an
+		// endless name
+		{withHeader(questionHeader, 3, 'f', 'o', 'o', 0xC0, 12, 0, 12, 0, 1), "DNS name too long"},
+		// a PTR record whose target loops
+		{withHeader(answerHeader, 0, 0, 12, 0, 1, 0, 0, 0, 10, 0, 2, 0xC0, 23), "too many compression pointers in DNS name"},
+	} {
+		_, err := lanpeers.ParseMessage(t.msg)
+		c.Check(err, ErrorMatches, t.err, Commentf("%v", t.msg))
+	}
+}
+
+func (s *mdnsSuite) TestParseNameTooLong(c *C) {
+	msg := withHeader(questionHeader)
+	// 5 labels of 63 bytes make a 321 bytes long name
+	for i := 0; i < 5; i++ {
+		msg = append(msg, 63)
+		for j := 0; j < 63; j++ {
+			msg = append(msg, 'a')
+		}
+	}
+	msg = append(msg, 0, 0, 12, 0, 1)
+	_, err := lanpeers.ParseMessage(msg)
+	c.Check(err, ErrorMatches, "DNS name too long")
+}
+
+func (s *mdnsSuite) TestAnswer(c *C) {
+	query := lanpeers.NewMessage(7, 0,
+		[]lanpeers.Question{lanpeers.NewQuestion(lanpeers.ServiceName, lanpeers.TypePTR, lanpeers.ClassIN)},
+		nil)
+
+	resp := lanpeers.Answer("host-0a0b0c0d", 4242, query)
+	c.Assert(resp, NotNil)
+	c.Check(resp.ID(), Equals, uint16(7))
+	c.Check(resp.Flags(), Equals, uint16(0x8400))
+	c.Check(resp.Questions(), DeepEquals, query.Questions())
+	answers := resp.Answers()
+	c.Assert(answers, HasLen, 3)
+	c.Check(answers[0].Type(), Equals, uint16(lanpeers.TypePTR))
+	c.Check(answers[0].Target(), Equals, "host-0a0b0c0d."+lanpeers.ServiceName)
+	c.Check(answers[1].Type(), Equals, uint16(lanpeers.TypeSRV))
+	c.Check(answers[1].Name(), Equals, "host-0a0b0c0d."+lanpeers.ServiceName)
+	c.Check(answers[1].Port(), Equals, uint16(4242))
+	c.Check(answers[2].Type(), Equals, uint16(lanpeers.TypeTXT))
+	c.Check(answers[2].TXT(), DeepEquals, []string{"txtvers=1"})
+
+	// the answer goes through the wire
+	parsed, err := lanpeers.ParseMessage(resp.Pack())
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, resp)
+}
+
+func (s *mdnsSuite) TestAnswerIgnoresOtherQueries(c *C) {
+	for _, query := range []*lanpeers.Message{
+		// another service
+		lanpeers.NewMessage(0, 0, []lanpeers.Question{lanpeers.NewQuestion("_http._tcp.local.", lanpeers.TypePTR, lanpeers.ClassIN)}, nil),
+		// another type of record
+		lanpeers.NewMessage(0, 0, []lanpeers.Question{lanpeers.NewQuestion(lanpeers.ServiceName, lanpeers.TypeTXT, lanpeers.ClassIN)}, nil),
+		// a response
+		lanpeers.NewMessage(0, 0x8400, []lanpeers.Question{lanpeers.NewQuestion(lanpeers.ServiceName, lanpeers.TypePTR, lanpeers.ClassIN)}, nil),
+	} {
+		c.Check(lanpeers.Answer("host", 4242, query), IsNil)
+	}
+}
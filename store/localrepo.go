@@ -0,0 +1,470 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// localRepoManifest is the name of the file indexing a local repository.
+const localRepoManifest = "manifest.json"
+
+// LocalRepository resolves snaps and assertions against a directory,
+// possibly on removable media, instead of the network. The directory holds
+// snap files and assertions, indexed by a manifest.json file like:
+//
+//	{
+//	  "snaps": [
+//	    {"file": "foo_12.snap", "snap-id": "<id>", "revision": 12, "channels": ["stable"]}
+//	  ],
+//	  "assertions": ["foo_12.assert", "account-keys.assert"]
+//	}
+//
+// Size and hex encoded sha3-384 of the snap files can be included in the manifest,
+// otherwise they are computed when the manifest is loaded. The manifest is
+// reloaded whenever it changes.
+type LocalRepository struct {
+	mu  sync.Mutex
+	dir string
+
+	loadedModTime time.Time
+	snaps         []*localRepoSnap
+	assertions    []asserts.Assertion
+}
+
+type localRepoSnap struct {
+	File     string   `json:"file"`
+	SnapID   string   `json:"snap-id"`
+	Revision int      `json:"revision"`
+	Channels []string `json:"channels"`
+	Size     int64    `json:"size,omitempty"`
+	Sha3_384 string   `json:"sha3-384,omitempty"`
+
+	info *snap.Info
+}
+
+type localRepoIndex struct {
+	Snaps      []*localRepoSnap `json:"snaps"`
+	Assertions []string         `json:"assertions"`
+}
+
+// NewLocalRepository returns a local repository that is not in use until a
+// directory is set.
+func NewLocalRepository() *LocalRepository {
+	return &LocalRepository{}
+}
+
+// SetDir sets the directory of the repository, an empty directory stops
+// using the repository.
+func (r *LocalRepository) SetDir(dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if dir == r.dir {
+		return
+	}
+	r.dir = dir
+	r.loadedModTime = time.Time{}
+	r.snaps = nil
+	r.assertions = nil
+}
+
+// Dir returns the directory of the repository, empty if it is not in use.
+func (r *LocalRepository) Dir() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dir
+}
+
+// localPath resolves a file name from the manifest within the repository.
+func (r *LocalRepository) localPath(name string) (string, error) {
+	clean := filepath.Clean(name)
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return filepath.Join(r.dir, clean), nil
+}
+
+// load (re)reads the manifest of the repository if it changed since the
+// last time. It must be called with the lock held.
+func (r *LocalRepository) load() error {
+	manifest := filepath.Join(r.dir, localRepoManifest)
+	st, err := os.Stat(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot use local repository %q: %v", r.dir, err)
+	}
+	if st.ModTime().Equal(r.loadedModTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot use local repository %q: %v", r.dir, err)
+	}
+	var idx localRepoIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return fmt.Errorf("cannot decode local repository manifest: %v", err)
+	}
+
+	for _, s := range idx.Snaps {
+		if err := r.loadSnap(s); err != nil {
+			return fmt.Errorf("cannot load snap %q from local repository: %v", s.File, err)
+		}
+	}
+
+	var assertions []asserts.Assertion
+	for _, name := range idx.Assertions {
+		as, err := r.loadAssertions(name)
+		if err != nil {
+			return fmt.Errorf("cannot load assertions %q from local repository: %v", name, err)
+		}
+		assertions = append(assertions, as...)
+	}
+
+	r.snaps = idx.Snaps
+	r.assertions = assertions
+	r.loadedModTime = st.ModTime()
+	logger.Noticef("Loaded %d snaps and %d assertions from local repository %q.", len(r.snaps), len(r.assertions), r.dir)
+	return nil
+}
+
+func (r *LocalRepository) loadSnap(s *localRepoSnap) error {
+	if s.SnapID == "" {
+		return fmt.Errorf("missing snap-id")
+	}
+	if s.Revision <= 0 {
+		return fmt.Errorf("invalid revision %d", s.Revision)
+	}
+	p, err := r.localPath(s.File)
+	if err != nil {
+		return err
+	}
+	container, err := snap.Open(p)
+	if err != nil {
+		return err
+	}
+	info, err := snap.ReadInfoFromSnapFile(container, nil)
+	if err != nil {
+		return err
+	}
+	info.RealName = info.SuggestedName
+	info.SnapID = s.SnapID
+	info.Revision = snap.R(s.Revision)
+
+	if s.Size == 0 || s.Sha3_384 == "" {
+		digest, size, err := osutil.FileDigest(p, crypto.SHA3_384)
+		if err != nil {
+			return err
+		}
+		s.Size = int64(size)
+		s.Sha3_384 = fmt.Sprintf("%x", digest)
+	}
+	info.Size = s.Size
+	info.Sha3_384 = s.Sha3_384
+	s.info = info
+	return nil
+}
+
+func (r *LocalRepository) loadAssertions(name string) ([]asserts.Assertion, error) {
+	p, err := r.localPath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var as []asserts.Assertion
+	dec := asserts.NewDecoder(f)
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			return as, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+}
+
+// normalizeChannel returns the normalized name of the channel, "stable" if
+// none is given.
+func normalizeChannel(channel string) string {
+	if channel == "" {
+		channel = "stable"
+	}
+	ch, err := snap.ParseChannel(channel, "")
+	if err != nil {
+		return channel
+	}
+	return ch.Name
+}
+
+func supportsArchitecture(info *snap.Info, architecture string) bool {
+	return len(info.Architectures) == 0 || strutil.ListContains(info.Architectures, "all") || strutil.ListContains(info.Architectures, architecture)
+}
+
+// find returns the snap matching the given selector at the given revision,
+// or with the highest revision in the given channel. It must be called
+// with the lock held.
+func (r *LocalRepository) find(match func(info *snap.Info) bool, action, channel string, revision snap.Revision, architecture string) (*snap.Info, error) {
+	var found *localRepoSnap
+	var foundChannel string
+	candidates := 0
+	channel = normalizeChannel(channel)
+	for _, s := range r.snaps {
+		if !match(s.info) || !supportsArchitecture(s.info, architecture) {
+			continue
+		}
+		candidates++
+		if !revision.Unset() {
+			if s.info.Revision == revision {
+				found = s
+				break
+			}
+			continue
+		}
+		for _, ch := range s.Channels {
+			if normalizeChannel(ch) == channel && (found == nil || s.info.Revision.N > found.info.Revision.N) {
+				found = s
+				foundChannel = channel
+			}
+		}
+	}
+	if found == nil {
+		if candidates == 0 {
+			return nil, ErrSnapNotFound
+		}
+		e := &RevisionNotAvailableError{Action: action}
+		if revision.Unset() {
+			e.Channel = channel
+		}
+		return nil, e
+	}
+
+	// callers adjust the instance key and channel of the result
+	info := *found.info
+	info.Channel = foundChannel
+	return &info, nil
+}
+
+func (r *LocalRepository) snapInfo(name, architecture string) (*snap.Info, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	info, err := r.find(func(info *snap.Info) bool {
+		return info.SnapName() == name
+	}, "", "stable", snap.Revision{}, architecture)
+	if _, ok := err.(*RevisionNotAvailableError); ok {
+		// the snap is there, just not in the default channel
+		return nil, ErrSnapNotFound
+	}
+	return info, err
+}
+
+func (r *LocalRepository) snapAction(currentSnaps []*CurrentSnap, actions []*SnapAction, architecture string) ([]*snap.Info, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	curSnaps := make(map[string]*CurrentSnap, len(currentSnaps))
+	for _, curSnap := range currentSnaps {
+		curSnaps[curSnap.InstanceName] = curSnap
+	}
+
+	refreshErrors := make(map[string]error)
+	installErrors := make(map[string]error)
+	downloadErrors := make(map[string]error)
+	var snaps []*snap.Info
+	for _, a := range actions {
+		if !isValidAction(a.Action) {
+			return nil, fmt.Errorf("internal error: unsupported action %q", a.Action)
+		}
+		snapName, instanceKey := snap.SplitInstanceName(a.InstanceName)
+		switch a.Action {
+		case "install", "download":
+			info, err := r.find(func(info *snap.Info) bool {
+				return info.SnapName() == snapName
+			}, a.Action, a.Channel, a.Revision, architecture)
+			if err != nil {
+				if a.Action == "install" {
+					installErrors[a.InstanceName] = err
+				} else {
+					downloadErrors[snapName] = err
+				}
+				continue
+			}
+			info.InstanceKey = instanceKey
+			snaps = append(snaps, info)
+		case "refresh":
+			cur := curSnaps[a.InstanceName]
+			if cur == nil {
+				return nil, fmt.Errorf("internal error: refresh of %q without current snap information", a.InstanceName)
+			}
+			channel := a.Channel
+			if channel == "" && a.Revision.Unset() {
+				channel = cur.TrackingChannel
+			}
+			info, err := r.find(func(info *snap.Info) bool {
+				return info.SnapID == cur.SnapID
+			}, a.Action, channel, a.Revision, architecture)
+			if err == nil && (info.Revision == cur.Revision || findRev(info.Revision, cur.Block)) {
+				err = ErrNoUpdateAvailable
+			}
+			if err != nil {
+				refreshErrors[cur.InstanceName] = err
+				continue
+			}
+			info.InstanceKey = instanceKey
+			snaps = append(snaps, info)
+		}
+	}
+
+	if len(refreshErrors)+len(installErrors)+len(downloadErrors) != 0 {
+		// normalize empty maps
+		if len(refreshErrors) == 0 {
+			refreshErrors = nil
+		}
+		if len(installErrors) == 0 {
+			installErrors = nil
+		}
+		if len(downloadErrors) == 0 {
+			downloadErrors = nil
+		}
+		return snaps, &SnapActionError{
+			NoResults: len(snaps) == 0,
+			Refresh:   refreshErrors,
+			Install:   installErrors,
+			Download:  downloadErrors,
+		}
+	}
+	return snaps, nil
+}
+
+// snapPath returns the location of the snap with the given digest.
+func (r *LocalRepository) snapPath(sha3_384 string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return "", err
+	}
+	for _, s := range r.snaps {
+		if s.Sha3_384 == sha3_384 {
+			return r.localPath(s.File)
+		}
+	}
+	return "", fmt.Errorf("cannot find snap with sha3-384 %q in local repository %q", sha3_384, r.dir)
+}
+
+func (r *LocalRepository) download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter) error {
+	src, err := r.snapPath(downloadInfo.Sha3_384)
+	if err != nil {
+		return err
+	}
+	if pbar == nil {
+		pbar = progress.Null
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	f, err := ioutil.TempFile(filepath.Dir(targetPath), filepath.Base(targetPath)+".local-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	h := crypto.SHA3_384.New()
+	pbar.Start(name, float64(downloadInfo.Size))
+	n, err := io.Copy(io.MultiWriter(f, h, pbar), io.LimitReader(in, downloadInfo.Size+1))
+	pbar.Finished()
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if n != downloadInfo.Size {
+		return fmt.Errorf("cannot copy %s from local repository: got %d bytes instead of %d", name, n, downloadInfo.Size)
+	}
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != downloadInfo.Sha3_384 {
+		return HashError{name, actual, downloadInfo.Sha3_384}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), targetPath)
+}
+
+func (r *LocalRepository) assertion(assertType *asserts.AssertionType, primaryKey []string) (asserts.Assertion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	ref := &asserts.Ref{Type: assertType, PrimaryKey: primaryKey}
+	var found asserts.Assertion
+	for _, a := range r.assertions {
+		if a.Ref().Unique() != ref.Unique() {
+			continue
+		}
+		// the repository may carry several revisions
+		if found == nil || a.Revision() > found.Revision() {
+			found = a
+		}
+	}
+	if found == nil {
+		headers, _ := asserts.HeadersFromPrimaryKey(assertType, primaryKey)
+		return nil, &asserts.NotFoundError{
+			Type:    assertType,
+			Headers: headers,
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,252 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type localRepoSuite struct {
+	testutil.BaseTest
+
+	dir       string
+	repo      *store.LocalRepository
+	store     *store.Store
+	manifests int
+}
+
+var _ = Suite(&localRepoSuite{})
+
+func (s *localRepoSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+	s.AddCleanup(snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {}))
+
+	s.dir = c.MkDir()
+	s.repo = store.NewLocalRepository()
+	s.repo.SetDir(s.dir)
+	s.store = store.New(nil, nil)
+	s.store.SetLocalRepository(s.repo)
+}
+
+func (s *localRepoSuite) addSnap(c *C, name, version string) string {
+	p := snaptest.MakeTestSnapWithFiles(c, "name: "+name+"\nversion: "+version+"\n", nil)
+	fname := name + "_" + version + ".snap"
+	c.Assert(os.Rename(p, filepath.Join(s.dir, fname)), IsNil)
+	return fname
+}
+
+func (s *localRepoSuite) writeManifest(c *C, manifest map[string]interface{}) {
+	data, err := json.Marshal(manifest)
+	c.Assert(err, IsNil)
+	p := filepath.Join(s.dir, "manifest.json")
+	c.Assert(ioutil.WriteFile(p, data, 0644), IsNil)
+	// make sure changes are noticed despite coarse timestamps
+	s.manifests++
+	later := time.Now().Add(time.Duration(s.manifests) * time.Second)
+	c.Assert(os.Chtimes(p, later, later), IsNil)
+}
+
+func (s *localRepoSuite) setupRepo(c *C) {
+	foo1 := s.addSnap(c, "foo", "1")
+	foo2 := s.addSnap(c, "foo", "2")
+	bar := s.addSnap(c, "bar", "1")
+	s.writeManifest(c, map[string]interface{}{
+		"snaps": []map[string]interface{}{
+			{"file": foo1, "snap-id": "foo-id", "revision": 1, "channels": []string{"stable"}},
+			{"file": foo2, "snap-id": "foo-id", "revision": 2, "channels": []string{"latest/candidate"}},
+			{"file": bar, "snap-id": "bar-id", "revision": 7, "channels": []string{"edge"}},
+		},
+	})
+}
+
+func (s *localRepoSuite) TestSnapActionInstall(c *C) {
+	s.setupRepo(c)
+
+	infos, err := s.store.SnapAction(context.TODO(), nil, []*store.SnapAction{
+		{Action: "install", InstanceName: "foo"},
+		{Action: "install", InstanceName: "foo_other", Channel: "candidate"},
+	}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Check(infos[0].InstanceName(), Equals, "foo")
+	c.Check(infos[0].SnapID, Equals, "foo-id")
+	c.Check(infos[0].Revision, Equals, snap.R(1))
+	c.Check(infos[0].Version, Equals, "1")
+	c.Check(infos[0].Channel, Equals, "stable")
+	c.Check(infos[1].InstanceName(), Equals, "foo_other")
+	c.Check(infos[1].Revision, Equals, snap.R(2))
+	c.Check(infos[1].Channel, Equals, "candidate")
+
+	digest, size, err := osutil.FileDigest(filepath.Join(s.dir, "foo_1.snap"), crypto.SHA3_384)
+	c.Assert(err, IsNil)
+	c.Check(infos[0].Sha3_384, Equals, fmt.Sprintf("%x", digest))
+	c.Check(infos[0].Size, Equals, int64(size))
+}
+
+func (s *localRepoSuite) TestSnapActionErrors(c *C) {
+	s.setupRepo(c)
+
+	infos, err := s.store.SnapAction(context.TODO(), nil, []*store.SnapAction{
+		{Action: "install", InstanceName: "bar"},
+		{Action: "install", InstanceName: "baz"},
+		{Action: "download", InstanceName: "foo", Revision: snap.R(3)},
+	}, nil, nil)
+	c.Check(infos, HasLen, 0)
+	c.Assert(err, DeepEquals, &store.SnapActionError{
+		NoResults: true,
+		Install: map[string]error{
+			"bar": &store.RevisionNotAvailableError{Action: "install", Channel: "stable"},
+			"baz": store.ErrSnapNotFound,
+		},
+		Download: map[string]error{
+			"foo": &store.RevisionNotAvailableError{Action: "download"},
+		},
+	})
+}
+
+func (s *localRepoSuite) TestSnapActionRefresh(c *C) {
+	s.setupRepo(c)
+
+	current := []*store.CurrentSnap{
+		{InstanceName: "foo", SnapID: "foo-id", Revision: snap.R(1), TrackingChannel: "candidate"},
+		{InstanceName: "bar", SnapID: "bar-id", Revision: snap.R(7), TrackingChannel: "edge"},
+	}
+	infos, err := s.store.SnapAction(context.TODO(), current, []*store.SnapAction{
+		{Action: "refresh", InstanceName: "foo", SnapID: "foo-id"},
+		{Action: "refresh", InstanceName: "bar", SnapID: "bar-id"},
+	}, nil, nil)
+	c.Assert(infos, HasLen, 1)
+	c.Check(infos[0].InstanceName(), Equals, "foo")
+	c.Check(infos[0].Revision, Equals, snap.R(2))
+	c.Assert(err, DeepEquals, &store.SnapActionError{
+		Refresh: map[string]error{
+			"bar": store.ErrNoUpdateAvailable,
+		},
+	})
+}
+
+func (s *localRepoSuite) TestSnapInfo(c *C) {
+	s.setupRepo(c)
+
+	info, err := s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "foo"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.SnapID, Equals, "foo-id")
+	c.Check(info.Revision, Equals, snap.R(1))
+
+	// not available in stable
+	_, err = s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "bar"}, nil)
+	c.Check(err, Equals, store.ErrSnapNotFound)
+}
+
+func (s *localRepoSuite) TestDownload(c *C) {
+	s.setupRepo(c)
+
+	infos, err := s.store.SnapAction(context.TODO(), nil, []*store.SnapAction{
+		{Action: "download", InstanceName: "foo"},
+	}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+
+	snapData, err := ioutil.ReadFile(filepath.Join(s.dir, "foo_1.snap"))
+	c.Assert(err, IsNil)
+
+	target := filepath.Join(c.MkDir(), "foo_1.snap")
+	err = s.store.Download(context.TODO(), "foo", target, &infos[0].DownloadInfo, progress.Null, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(target, testutil.FileEquals, snapData)
+
+	stream, err := s.store.DownloadStream(context.TODO(), "foo", &infos[0].DownloadInfo, nil)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(stream)
+	stream.Close()
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, snapData)
+
+	// the repository content changed under our feet
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "foo_1.snap"), make([]byte, infos[0].Size), 0644), IsNil)
+	err = s.store.Download(context.TODO(), "foo", filepath.Join(c.MkDir(), "foo_1.snap"), &infos[0].DownloadInfo, progress.Null, nil, nil)
+	c.Check(err, FitsTypeOf, store.HashError{})
+
+	err = s.store.Download(context.TODO(), "foo", target, &snap.DownloadInfo{Sha3_384: "unknown", Size: 1}, progress.Null, nil, nil)
+	c.Check(err, ErrorMatches, `cannot find snap with sha3-384 "unknown" in local repository .*`)
+}
+
+func (s *localRepoSuite) TestAssertion(c *C) {
+	storeStack := assertstest.NewStoreStack("canonical", nil)
+	var buf bytes.Buffer
+	enc := asserts.NewEncoder(&buf)
+	c.Assert(enc.Encode(storeStack.TrustedAccount), IsNil)
+	c.Assert(enc.Encode(storeStack.StoreAccountKey("")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "trusted.assert"), buf.Bytes(), 0644), IsNil)
+	s.writeManifest(c, map[string]interface{}{
+		"assertions": []string{"trusted.assert"},
+	})
+
+	a, err := s.store.Assertion(asserts.AccountType, []string{"canonical"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a, DeepEquals, storeStack.TrustedAccount)
+
+	_, err = s.store.Assertion(asserts.AccountType, []string{"other"}, nil)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+}
+
+func (s *localRepoSuite) TestManifestReloaded(c *C) {
+	_, err := s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "foo"}, nil)
+	c.Check(err, ErrorMatches, `cannot use local repository ".*": .*no such file or directory`)
+
+	s.writeManifest(c, map[string]interface{}{})
+	_, err = s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "foo"}, nil)
+	c.Check(err, Equals, store.ErrSnapNotFound)
+
+	s.setupRepo(c)
+	_, err = s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "foo"}, nil)
+	c.Check(err, IsNil)
+
+	// files outside of the repository are refused
+	s.writeManifest(c, map[string]interface{}{
+		"snaps": []map[string]interface{}{
+			{"file": "../foo.snap", "snap-id": "foo-id", "revision": 1},
+		},
+	})
+	_, err = s.store.SnapInfo(context.TODO(), store.SnapSpec{Name: "foo"}, nil)
+	c.Check(err, ErrorMatches, `cannot load snap "../foo.snap" from local repository: invalid file name "../foo.snap"`)
+}
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/overlord/auth"
+)
+
+// defaultMetadataCacheTTL is for how long a cached metadata response
+// is revalidated with the store, unless the store says otherwise via
+// Cache-Control
+const defaultMetadataCacheTTL = 10 * time.Minute
+
+var (
+	// maxMetadataCacheEntries bounds the memory used by the cache
+	maxMetadataCacheEntries = 256
+
+	timeNow = time.Now
+)
+
+// metadataCache keeps the bodies of snap metadata responses together
+// with their ETag, so that they can be revalidated with If-None-Match
+// and served again when the store replies 304 Not Modified.
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*metadataCacheEntry
+}
+
+type metadataCacheEntry struct {
+	etag        string
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		entries: make(map[string]*metadataCacheEntry),
+	}
+}
+
+// metadataCacheKey returns the key for caching the response to the
+// given request, responses can differ per user.
+func metadataCacheKey(reqOptions *requestOptions, user *auth.UserState) string {
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+	return fmt.Sprintf("%d %s %s", userID, reqOptions.Accept, reqOptions.URL)
+}
+
+// get returns the unexpired entry for key, if any.
+func (mc *metadataCache) get(key string) *metadataCacheEntry {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	entry := mc.entries[key]
+	if entry == nil {
+		return nil
+	}
+	if !timeNow().Before(entry.expires) {
+		delete(mc.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// put caches body as the response to key if it came with an ETag and
+// the store does not forbid caching it.
+func (mc *metadataCache) put(key string, resp *http.Response, body []byte) {
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return
+	}
+	ttl, ok := cacheControlTTL(resp.Header.Get("Cache-Control"))
+	if !ok {
+		return
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := timeNow()
+	if len(mc.entries) >= maxMetadataCacheEntries {
+		mc.evict(now)
+	}
+	mc.entries[key] = &metadataCacheEntry{
+		etag:        etag,
+		contentType: resp.Header.Get("Content-Type"),
+		body:        body,
+		expires:     now.Add(ttl),
+	}
+}
+
+// evict drops the expired entries, or the one expiring first if none
+// is.
+func (mc *metadataCache) evict(now time.Time) {
+	var first string
+	for key, entry := range mc.entries {
+		if !now.Before(entry.expires) {
+			delete(mc.entries, key)
+			continue
+		}
+		if first == "" || entry.expires.Before(mc.entries[first].expires) {
+			first = key
+		}
+	}
+	if len(mc.entries) >= maxMetadataCacheEntries {
+		delete(mc.entries, first)
+	}
+}
+
+// cacheControlTTL returns for how long a response may be cached as
+// per its Cache-Control header, and false if it must not be cached.
+func cacheControlTTL(cacheControl string) (time.Duration, bool) {
+	ttl := defaultMetadataCacheTTL
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			ttl = time.Duration(secs) * time.Second
+		}
+	}
+	return ttl, true
+}
@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/store"
+)
+
+func (s *storeTestSuite) TestInfoRevalidatesCachedMetadata(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+	now := time.Now()
+	restore = store.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", infoPathPattern)
+		n++
+		switch n {
+		case 1, 3:
+			c.Check(r.Header.Get("If-None-Match"), Equals, "")
+		case 2:
+			c.Check(r.Header.Get("If-None-Match"), Equals, `"v1"`)
+			w.WriteHeader(304)
+			return
+		default:
+			c.Fatalf("unexpected request %d", n)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.WriteHeader(200)
+		io.WriteString(w, mockInfoJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	spec := store.SnapSpec{Name: "hello-world"}
+	info, err := sto.SnapInfo(s.ctx, spec, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.SnapID, Equals, helloWorldSnapID)
+	c.Check(sto.MetadataCacheLen(), Equals, 1)
+
+	// not modified, served from the cache
+	info, err = sto.SnapInfo(s.ctx, spec, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.InstanceName(), Equals, "hello-world")
+	c.Check(info.SnapID, Equals, helloWorldSnapID)
+	c.Check(info.Revision.N, Equals, 27)
+	c.Check(info.MustBuy, Equals, true)
+
+	// the entry expired, fetched anew
+	now = now.Add(301 * time.Second)
+	info, err = sto.SnapInfo(s.ctx, spec, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.SnapID, Equals, helloWorldSnapID)
+	c.Check(n, Equals, 3)
+}
+
+func (s *storeTestSuite) TestInfoNoStoreNotCached(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Header.Get("If-None-Match"), Equals, "")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.WriteHeader(200)
+		io.WriteString(w, mockInfoJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	for i := 0; i < 2; i++ {
+		_, err := sto.SnapInfo(s.ctx, store.SnapSpec{Name: "hello-world"}, nil)
+		c.Assert(err, IsNil)
+	}
+	c.Check(n, Equals, 2)
+	c.Check(sto.MetadataCacheLen(), Equals, 0)
+}
+
+func (s *storeTestSuite) TestFindRevalidatesCachedMetadata(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", searchPath)
+		n++
+		if n == 2 {
+			c.Check(r.Header.Get("If-None-Match"), Equals, `W/"search-1"`)
+			w.WriteHeader(304)
+			return
+		}
+		c.Check(r.Header.Get("If-None-Match"), Equals, "")
+		w.Header().Set("ETag", fmt.Sprintf(`W/"search-%d"`, n))
+		w.Header().Set("Content-Type", "application/hal+json")
+		w.WriteHeader(200)
+		io.WriteString(w, MockSearchJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	for i := 0; i < 2; i++ {
+		snaps, err := sto.Find(s.ctx, &store.Search{Query: "hello"}, nil)
+		c.Assert(err, IsNil)
+		c.Assert(snaps, HasLen, 1)
+		c.Check(snaps[0].InstanceName(), Equals, "hello-world")
+	}
+	c.Check(n, Equals, 2)
+
+	// other queries are cached separately
+	_, err := sto.Find(s.ctx, &store.Search{Query: "hello", Section: "games"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 3)
+	c.Check(sto.MetadataCacheLen(), Equals, 2)
+}
+
+func (s *storeTestSuite) TestMetadataCacheBounded(c *C) {
+	restore := release.MockOnClassic(false)
+	defer restore()
+	restore = store.MockMaxMetadataCacheEntries(2)
+	defer restore()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("ETag", `"`+name+`"`)
+		w.WriteHeader(200)
+		io.WriteString(w, mockInfoJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		_, err := sto.SnapInfo(s.ctx, store.SnapSpec{Name: name}, nil)
+		c.Assert(err, IsNil)
+	}
+	c.Check(sto.MetadataCacheLen(), Equals, 2)
+}
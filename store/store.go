@@ -29,7 +29,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"os/exec"
@@ -57,6 +59,7 @@ import (
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store/lanpeers"
 	"github.com/snapcore/snapd/strutil"
 )
 
@@ -105,6 +108,19 @@ var connCheckStrategy = retry.LimitCount(3, retry.LimitTime(38*time.Second,
 	},
 ))
 
+// assertionsProxyRetryStrategy is used when fetching assertions from
+// an assertions proxy, it gives up early to fall back to the store
+var assertionsProxyRetryStrategy = retry.LimitCount(2, retry.LimitTime(10*time.Second,
+	retry.Exponential{
+		Initial: 500 * time.Millisecond,
+		Factor:  2,
+	},
+))
+
+// assertionsProxyBackoff is for how long the assertions proxy is not
+// used after it failed
+var assertionsProxyBackoff = 10 * time.Minute
+
 // Config represents the configuration to access the snap store
 type Config struct {
 	// Store API base URLs. The assertions url is only separate because it can
@@ -127,6 +143,11 @@ type Config struct {
 
 	// Proxy returns the HTTP proxy to use when talking to the store
 	Proxy func(*http.Request) (*url.URL, error)
+
+	// RefreshMetricsURL is where refresh metrics are reported, if
+	// anywhere. The store offers no such endpoint, so it is unset
+	// unless given with SNAPPY_FORCE_REFRESH_METRICS_URL.
+	RefreshMetricsURL *url.URL
 }
 
 // setBaseURL updates the store API's base URL in the Config. Must not be used
@@ -164,15 +185,35 @@ type Store struct {
 	deltaFormat  string
 	// reused http client
 	client *http.Client
+	// http client shared by all downloads, so that they reuse its
+	// pool of connections
+	downloadClient *http.Client
+
+	connStatsMu sync.Mutex
+	connStats   DownloadConnectionStats
+	// how the deltas offered fared
+	deltaStatsMu sync.Mutex
+	deltaStats   DeltaStats
 
 	dauthCtx  DeviceAndAuthContext
 	sessionMu sync.Mutex
 
 	mu                sync.Mutex
 	suggestedCurrency string
-
-	cacher downloadCache
-	proxy  func(*http.Request) (*url.URL, error)
+	// when the assertions proxy last failed
+	assertionsProxyFailure time.Time
+	// token bucket shared by the rate limited downloads, and its rate
+	downloadBucket     *ratelimit.Bucket
+	downloadBucketRate int64
+	// sha3-384 of the blobs of revisions that are neither private nor
+	// paid, as learnt from the install/refresh API
+	publicBlobs map[string]bool
+
+	cacher    downloadCache
+	metaCache *metadataCache
+	lanPeers  LANPeers
+	localRepo *LocalRepository
+	proxy     func(*http.Request) (*url.URL, error)
 }
 
 func respToError(resp *http.Response, msg string) error {
@@ -285,6 +326,21 @@ func storeDeveloperURL() string {
 	return defaultStoreDeveloperURL
 }
 
+func refreshMetricsURL() (*url.URL, error) {
+	s := os.Getenv("SNAPPY_FORCE_REFRESH_METRICS_URL")
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNAPPY_FORCE_REFRESH_METRICS_URL: %s", err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid SNAPPY_FORCE_REFRESH_METRICS_URL: %q is not an https URL", s)
+	}
+	return u, nil
+}
+
 var defaultConfig = Config{}
 
 // DefaultConfig returns a copy of the default configuration ready to be adapted.
@@ -305,6 +361,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	defaultConfig.RefreshMetricsURL, err = refreshMetricsURL()
+	if err != nil {
+		panic(err)
+	}
 	defaultConfig.DetailFields = jsonutil.StructFields((*snapDetails)(nil), "snap_yaml_raw")
 	defaultConfig.InfoFields = jsonutil.StructFields((*storeSnap)(nil), "snap-yaml")
 }
@@ -372,6 +432,8 @@ func New(cfg *Config, dauthCtx DeviceAndAuthContext) *Store {
 			MayLogBody: true,
 			Proxy:      cfg.Proxy,
 		}),
+		downloadClient: newDownloadClient(cfg.Proxy),
+		metaCache:      newMetadataCache(),
 	}
 	store.SetCacheDownloads(cfg.CacheDownloads)
 
@@ -430,13 +492,39 @@ func (s *Store) endpointURL(p string, query url.Values) *url.URL {
 	return endpointURL(s.baseURL(s.cfg.StoreBaseURL), p, query)
 }
 
-func (s *Store) assertionsEndpointURL(p string, query url.Values) *url.URL {
+func (s *Store) assertionsBaseURL() *url.URL {
 	defBaseURL := s.cfg.StoreBaseURL
 	// can be overridden separately!
 	if s.cfg.AssertionsBaseURL != nil {
 		defBaseURL = s.cfg.AssertionsBaseURL
 	}
-	return endpointURL(s.baseURL(defBaseURL), path.Join(assertionsPath, p), query)
+	return s.baseURL(defBaseURL)
+}
+
+// assertionsProxyURL returns the base URL of the assertions proxy to
+// use, if one is set and it did not fail recently.
+func (s *Store) assertionsProxyURL() *url.URL {
+	if s.dauthCtx == nil {
+		return nil
+	}
+	s.mu.Lock()
+	lastFailure := s.assertionsProxyFailure
+	s.mu.Unlock()
+	if !lastFailure.IsZero() && time.Since(lastFailure) < assertionsProxyBackoff {
+		return nil
+	}
+	u, err := s.dauthCtx.AssertionsProxyURL()
+	if err != nil {
+		logger.Debugf("cannot get assertions proxy URL from state: %v", err)
+		return nil
+	}
+	return u
+}
+
+func (s *Store) assertionsProxyFailed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assertionsProxyFailure = time.Now()
 }
 
 // LoginUser logs user in the store and returns the authentication macaroons.
@@ -674,6 +762,7 @@ type deviceAuthNeed int
 const (
 	deviceAuthPreferred deviceAuthNeed = iota
 	deviceAuthCustomStoreOnly
+	deviceAuthNever
 )
 
 // requestOptions specifies parameters for store requests.
@@ -691,7 +780,13 @@ type requestOptions struct {
 	//  - deviceAuthPreferred: should be provided if available
 	//  - deviceAuthCustomStoreOnly: should be provided only in case
 	//    of a custom store
+	//  - deviceAuthNever: must not be provided, the request must not
+	//    identify the device
 	DeviceAuthNeed deviceAuthNeed
+
+	// CacheMetadata indicates that the response can be cached and
+	// revalidated using its ETag
+	CacheMetadata bool
 }
 
 func (r *requestOptions) addHeader(k, v string) {
@@ -798,6 +893,9 @@ func decodeJSONBody(resp *http.Response, success interface{}, failure interface{
 
 // retryRequestDecodeJSON calls retryRequest and decodes the response into either success or failure.
 func (s *Store) retryRequestDecodeJSON(ctx context.Context, reqOptions *requestOptions, user *auth.UserState, success interface{}, failure interface{}) (resp *http.Response, err error) {
+	if reqOptions.CacheMetadata && s.metaCache != nil {
+		return s.retryRequestDecodeCachedJSON(ctx, reqOptions, user, success, failure)
+	}
 	return httputil.RetryRequest(reqOptions.URL.String(), func() (*http.Response, error) {
 		return s.doRequest(ctx, s.client, reqOptions, user)
 	}, func(resp *http.Response) error {
@@ -805,6 +903,48 @@ func (s *Store) retryRequestDecodeJSON(ctx context.Context, reqOptions *requestO
 	}, defaultRetryStrategy)
 }
 
+// retryRequestDecodeCachedJSON behaves like retryRequestDecodeJSON but
+// revalidates a previously cached response with the store, decoding the
+// cached body into success if the store replies it was not modified.
+func (s *Store) retryRequestDecodeCachedJSON(ctx context.Context, reqOptions *requestOptions, user *auth.UserState, success interface{}, failure interface{}) (resp *http.Response, err error) {
+	key := metadataCacheKey(reqOptions, user)
+	cached := s.metaCache.get(key)
+	if cached != nil {
+		reqOptions.addHeader("If-None-Match", cached.etag)
+	}
+
+	resp, err = httputil.RetryRequest(reqOptions.URL.String(), func() (*http.Response, error) {
+		return s.doRequest(ctx, s.client, reqOptions, user)
+	}, func(resp *http.Response) error {
+		if resp.StatusCode != 200 {
+			return decodeJSONBody(resp, success, failure)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(body, success); err != nil {
+			return err
+		}
+		s.metaCache.put(key, resp, body)
+		return nil
+	}, defaultRetryStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == 304 && cached != nil {
+		if err := json.Unmarshal(cached.body, success); err != nil {
+			return nil, err
+		}
+		// the callers only care about the status and content
+		// type of the response
+		resp.StatusCode = 200
+		resp.Header.Set("Content-Type", cached.contentType)
+	}
+	return resp, nil
+}
+
 // doRequest does an authenticated request to the store handling a potential macaroon refresh required if needed
 func (s *Store) doRequest(ctx context.Context, client *http.Client, reqOptions *requestOptions, user *auth.UserState) (*http.Response, error) {
 	authRefreshes := 0
@@ -900,7 +1040,7 @@ func (s *Store) newRequest(ctx context.Context, reqOptions *requestOptions, user
 
 	customStore := s.setStoreID(req, reqOptions.APILevel)
 
-	if s.dauthCtx != nil && (customStore || reqOptions.DeviceAuthNeed != deviceAuthCustomStoreOnly) {
+	if s.dauthCtx != nil && reqOptions.DeviceAuthNeed != deviceAuthNever && (customStore || reqOptions.DeviceAuthNeed != deviceAuthCustomStoreOnly) {
 		device, err := s.EnsureDeviceSession()
 		if err != nil && err != ErrNoSerial {
 			return nil, err
@@ -1093,15 +1233,20 @@ type SnapSpec struct {
 
 // SnapInfo returns the snap.Info for the store-hosted snap matching the given spec, or an error.
 func (s *Store) SnapInfo(ctx context.Context, snapSpec SnapSpec, user *auth.UserState) (*snap.Info, error) {
+	if s.useLocalRepository() {
+		return s.localRepo.snapInfo(snapSpec.Name, s.architecture)
+	}
+
 	query := url.Values{}
 	query.Set("fields", strings.Join(s.infoFields, ","))
 	query.Set("architecture", s.architecture)
 
 	u := s.endpointURL(path.Join(snapInfoEndpPath, snapSpec.Name), query)
 	reqOptions := &requestOptions{
-		Method:   "GET",
-		URL:      u,
-		APILevel: apiV2Endps,
+		Method:        "GET",
+		URL:           u,
+		APILevel:      apiV2Endps,
+		CacheMetadata: true,
 	}
 
 	var remote storeInfo
@@ -1199,9 +1344,10 @@ func (s *Store) Find(ctx context.Context, search *Search, user *auth.UserState)
 
 	u := s.endpointURL(searchEndpPath, q)
 	reqOptions := &requestOptions{
-		Method: "GET",
-		URL:    u,
-		Accept: halJsonContentType,
+		Method:        "GET",
+		URL:           u,
+		Accept:        halJsonContentType,
+		CacheMetadata: true,
 	}
 
 	var searchData searchResults
@@ -1337,6 +1483,31 @@ type DownloadOptions struct {
 // The file is saved in temporary storage, and should be removed
 // after use to prevent the disk from running out of space.
 func (s *Store) Download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
+	if err := s.download(ctx, name, targetPath, downloadInfo, pbar, user, dlOpts); err != nil {
+		return err
+	}
+	if s.lanPeers != nil && s.isPublicBlob(downloadInfo.Sha3_384) {
+		s.lanPeers.Share(downloadInfo.Sha3_384)
+	}
+	return nil
+}
+
+func (s *Store) notePublicBlob(sha3_384 string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.publicBlobs == nil {
+		s.publicBlobs = make(map[string]bool)
+	}
+	s.publicBlobs[sha3_384] = true
+}
+
+func (s *Store) isPublicBlob(sha3_384 string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publicBlobs[sha3_384]
+}
+
+func (s *Store) download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 		return err
 	}
@@ -1346,14 +1517,38 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		return nil
 	}
 
+	if s.useLocalRepository() {
+		if err := s.localRepo.download(ctx, name, targetPath, downloadInfo, pbar); err != nil {
+			return err
+		}
+		return s.cacher.Put(downloadInfo.Sha3_384, targetPath)
+	}
+
+	if s.lanPeers != nil && downloadInfo.Size > 0 {
+		err := s.lanPeers.Fetch(ctx, name, downloadInfo.Sha3_384, downloadInfo.Size, targetPath, pbar)
+		if err == nil {
+			return s.cacher.Put(downloadInfo.Sha3_384, targetPath)
+		}
+		if err != lanpeers.ErrNotFound {
+			// We revert to downloading from the store if there is any error.
+			logger.Noticef("Cannot download %s from the local network: %v", name, err)
+		}
+	}
+
 	if useDeltas() {
 		logger.Debugf("Available deltas returned by store: %v", downloadInfo.Deltas)
 
+		err := errNoUsableDelta
 		if len(downloadInfo.Deltas) == 1 {
-			err := s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user)
-			if err == nil {
-				return nil
-			}
+			err = s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user)
+		}
+		s.countDelta(downloadInfo, err)
+		switch err {
+		case nil:
+			return s.cacher.Put(downloadInfo.Sha3_384, targetPath)
+		case errNoUsableDelta:
+			logger.Debugf("No usable delta for %s, downloading it in full.", name)
+		default:
 			// We revert to normal downloads if there is any error.
 			logger.Noticef("Cannot download or apply deltas for %s: %v", name, err)
 		}
@@ -1462,6 +1657,71 @@ func downloadReqOpts(storeURL *url.URL, cdnHeader string, opts *DownloadOptions)
 
 var ratelimitReader = ratelimit.Reader
 
+// rateLimitBucket returns the token bucket for downloads limited to the
+// given rate in bytes per second. The bucket is shared, so that
+// downloads happening in parallel do not exceed the rate together.
+func (s *Store) rateLimitBucket(limit int64) *ratelimit.Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.downloadBucket == nil || s.downloadBucketRate != limit {
+		s.downloadBucket = ratelimit.NewBucketWithRate(float64(limit), 2*limit)
+		s.downloadBucketRate = limit
+	}
+	return s.downloadBucket
+}
+
+// downloadMaxIdleConnsPerHost is how many idle connections to each
+// download host are kept for parallel downloads to reuse
+const downloadMaxIdleConnsPerHost = 10
+
+func newDownloadClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	return httputil.NewHTTPClient(&httputil.ClientOptions{
+		Proxy:               proxy,
+		MaxIdleConnsPerHost: downloadMaxIdleConnsPerHost,
+		AttemptHTTP2:        true,
+	})
+}
+
+// DownloadConnectionStats counts the connections used by downloads.
+type DownloadConnectionStats struct {
+	// New is how many connections were established for downloads.
+	New int `json:"new"`
+	// Reused is how many download requests reused an existing
+	// connection.
+	Reused int `json:"reused"`
+}
+
+// DownloadConnectionStats returns how many download requests used new
+// and reused connections so far.
+func (s *Store) DownloadConnectionStats() DownloadConnectionStats {
+	s.connStatsMu.Lock()
+	defer s.connStatsMu.Unlock()
+	return s.connStats
+}
+
+// withConnectionTrace returns a context that accounts for the
+// connections used by requests made with it.
+func (s *Store) withConnectionTrace(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.connStatsMu.Lock()
+			defer s.connStatsMu.Unlock()
+			if info.Reused {
+				s.connStats.Reused++
+			} else {
+				s.connStats.New++
+			}
+		},
+	})
+}
+
+type truncater interface {
+	Truncate(size int64) error
+}
+
 var download = downloadImpl
 
 // download writes an http.Request showing a progress.Meter
@@ -1482,6 +1742,7 @@ func downloadImpl(ctx context.Context, name, sha3_384, downloadURL string, user
 
 	var finalErr error
 	var dlSize float64
+	entitlementRefreshed := false
 	startTime := time.Now()
 	for attempt := retry.Start(downloadRetryStrategy, nil); attempt.Next(); {
 		reqOptions := downloadReqOpts(storeURL, cdnHeader, dlOpts)
@@ -1509,7 +1770,7 @@ func downloadImpl(ctx context.Context, name, sha3_384, downloadURL string, user
 			return fmt.Errorf("The download has been cancelled: %s", ctx.Err())
 		}
 		var resp *http.Response
-		resp, finalErr = s.doRequest(ctx, httputil.NewHTTPClient(&httputil.ClientOptions{Proxy: s.proxy}), reqOptions, user)
+		resp, finalErr = s.doRequest(s.withConnectionTrace(ctx), s.downloadClient, reqOptions, user)
 
 		if cancelled(ctx) {
 			return fmt.Errorf("The download has been cancelled: %s", ctx.Err())
@@ -1530,9 +1791,35 @@ func downloadImpl(ctx context.Context, name, sha3_384, downloadURL string, user
 
 		switch resp.StatusCode {
 		case 200, 206: // OK, Partial Content
+			if t, ok := w.(truncater); ok && resume > 0 && resp.StatusCode == 200 {
+				// the server ignored the range and sends the
+				// whole snap, start over
+				if err := t.Truncate(0); err != nil {
+					return err
+				}
+				if _, err := w.Seek(0, os.SEEK_SET); err != nil {
+					return err
+				}
+				h.Reset()
+				resume = 0
+			}
 		case 402: // Payment Required
 
 			return fmt.Errorf("please buy %s before installing it.", name)
+		case 401, 403: // Unauthorized, Forbidden
+			if !isNoEntitlementResponse(resp) {
+				return &DownloadError{Code: resp.StatusCode, URL: resp.Request.URL}
+			}
+			// the user authorization may predate the entitlement
+			// to the snap, refresh it once before giving up
+			if !entitlementRefreshed && user.HasStoreAuth() {
+				entitlementRefreshed = true
+				if err := s.refreshAuth(user, authRefreshNeed{user: true}); err == nil {
+					resp.Body.Close()
+					continue
+				}
+			}
+			return &NoEntitlementError{Snap: name}
 		default:
 			return &DownloadError{Code: resp.StatusCode, URL: resp.Request.URL}
 		}
@@ -1546,8 +1833,7 @@ func downloadImpl(ctx context.Context, name, sha3_384, downloadURL string, user
 		var limiter io.Reader
 		limiter = resp.Body
 		if limit := dlOpts.RateLimit; limit > 0 {
-			bucket := ratelimit.NewBucketWithRate(float64(limit), 2*limit)
-			limiter = ratelimitReader(resp.Body, bucket)
+			limiter = ratelimitReader(resp.Body, s.rateLimitBucket(limit))
 		}
 		_, finalErr = io.Copy(mw, limiter)
 		pbar.Finished()
@@ -1602,6 +1888,14 @@ func (s *Store) DownloadStream(ctx context.Context, name string, downloadInfo *s
 		return file, nil
 	}
 
+	if s.useLocalRepository() {
+		path, err := s.localRepo.snapPath(downloadInfo.Sha3_384)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(path)
+	}
+
 	authAvail, err := s.authAvailable(user)
 	if err != nil {
 		return nil, err
@@ -1626,6 +1920,10 @@ func (s *Store) DownloadStream(ctx context.Context, name string, downloadInfo *s
 	if err != nil {
 		return nil, err
 	}
+	if (resp.StatusCode == 401 || resp.StatusCode == 403) && isNoEntitlementResponse(resp) {
+		resp.Body.Close()
+		return nil, &NoEntitlementError{Snap: name}
+	}
 	return resp.Body, nil
 }
 
@@ -1633,7 +1931,7 @@ var doDownloadReq = doDowloadReqImpl
 
 func doDowloadReqImpl(ctx context.Context, storeURL *url.URL, cdnHeader string, s *Store, user *auth.UserState) (*http.Response, error) {
 	reqOptions := downloadReqOpts(storeURL, cdnHeader, nil)
-	return s.doRequest(ctx, httputil.NewHTTPClient(&httputil.ClientOptions{Proxy: s.proxy}), reqOptions, user)
+	return s.doRequest(s.withConnectionTrace(ctx), s.downloadClient, reqOptions, user)
 }
 
 // downloadDelta downloads the delta for the preferred format, returning the path.
@@ -1674,8 +1972,7 @@ func getXdelta3Cmd(args ...string) (*exec.Cmd, error) {
 
 // applyDelta generates a target snap from a previously downloaded snap and a downloaded delta.
 var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo, targetPath string, targetSha3_384 string) error {
-	snapBase := fmt.Sprintf("%s_%d.snap", name, deltaInfo.FromRevision)
-	snapPath := filepath.Join(dirs.SnapBlobDir, snapBase)
+	snapPath := deltaSourcePath(name, deltaInfo)
 
 	if !osutil.FileExists(snapPath) {
 		return fmt.Errorf("snap %q revision %d not found at %s", name, deltaInfo.FromRevision, snapPath)
@@ -1723,10 +2020,66 @@ var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo,
 	return nil
 }
 
+// errNoUsableDelta is returned by downloadAndApplyDelta when the delta
+// offered cannot be used, before downloading anything.
+var errNoUsableDelta = errors.New("no usable delta")
+
+// DeltaStats counts how the deltas offered by the store fared.
+type DeltaStats struct {
+	// Hits is how many snaps were obtained by applying a delta.
+	Hits int `json:"hits"`
+	// Misses is how many snaps were downloaded in full because no
+	// usable delta was offered for them.
+	Misses int `json:"misses"`
+	// Failures is how many deltas could not be downloaded, applied or
+	// verified, the snaps then being downloaded in full.
+	Failures int `json:"failures"`
+	// BytesSaved is how much less was downloaded thanks to deltas.
+	BytesSaved int64 `json:"bytes-saved"`
+}
+
+// DeltaStats returns how the deltas offered by the store fared so far.
+func (s *Store) DeltaStats() DeltaStats {
+	s.deltaStatsMu.Lock()
+	defer s.deltaStatsMu.Unlock()
+	return s.deltaStats
+}
+
+func (s *Store) countDelta(downloadInfo *snap.DownloadInfo, err error) {
+	s.deltaStatsMu.Lock()
+	defer s.deltaStatsMu.Unlock()
+	switch err {
+	case nil:
+		s.deltaStats.Hits++
+		s.deltaStats.BytesSaved += downloadInfo.Size - downloadInfo.Deltas[0].Size
+	case errNoUsableDelta:
+		s.deltaStats.Misses++
+	default:
+		s.deltaStats.Failures++
+	}
+}
+
+// deltaSourcePath returns the path of the snap the delta applies to.
+func deltaSourcePath(name string, deltaInfo *snap.DeltaInfo) string {
+	return filepath.Join(dirs.SnapBlobDir, fmt.Sprintf("%s_%d.snap", name, deltaInfo.FromRevision))
+}
+
 // downloadAndApplyDelta downloads and then applies the delta to the current snap.
 func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
 	deltaInfo := &downloadInfo.Deltas[0]
 
+	// check what can be before spending bandwidth on the delta
+	if deltaInfo.Format != s.deltaFormat {
+		return errNoUsableDelta
+	}
+	if downloadInfo.Sha3_384 == "" {
+		// the result could not be verified
+		return errNoUsableDelta
+	}
+	if !osutil.FileExists(deltaSourcePath(name, deltaInfo)) {
+		return errNoUsableDelta
+	}
+
 	deltaPath := fmt.Sprintf("%s.%s-%d-to-%d.partial", targetPath, deltaInfo.Format, deltaInfo.FromRevision, deltaInfo.ToRevision)
 	deltaName := fmt.Sprintf(i18n.G("%s (delta)"), name)
 
@@ -1763,15 +2116,39 @@ type assertionSvcError struct {
 }
 
 // Assertion retrivies the assertion for the given type and primary key.
+// If an assertions proxy is set it is tried first, falling back to the
+// store if the assertion cannot be retrieved from it. The proxy is a
+// third party, it is never sent the user or device credentials.
 func (s *Store) Assertion(assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState) (asserts.Assertion, error) {
+	if s.useLocalRepository() {
+		return s.localRepo.assertion(assertType, primaryKey)
+	}
+	if proxyURL := s.assertionsProxyURL(); proxyURL != nil {
+		a, err := s.assertion(proxyURL, assertType, primaryKey, nil, deviceAuthNever, assertionsProxyRetryStrategy)
+		if err == nil {
+			return a, nil
+		}
+		if asserts.IsNotFound(err) {
+			// the proxy might not mirror everything
+			logger.Debugf("assertion not found via assertions proxy, falling back to the store: %v", err)
+		} else {
+			logger.Noticef("Cannot fetch assertion via assertions proxy, not using it for %v: %v", assertionsProxyBackoff, err)
+			s.assertionsProxyFailed()
+		}
+	}
+	return s.assertion(s.assertionsBaseURL(), assertType, primaryKey, user, deviceAuthPreferred, defaultRetryStrategy)
+}
+
+func (s *Store) assertion(baseURL *url.URL, assertType *asserts.AssertionType, primaryKey []string, user *auth.UserState, dauthNeed deviceAuthNeed, retryStrategy retry.Strategy) (asserts.Assertion, error) {
 	v := url.Values{}
 	v.Set("max-format", strconv.Itoa(assertType.MaxSupportedFormat()))
-	u := s.assertionsEndpointURL(path.Join(assertType.Name, path.Join(primaryKey...)), v)
+	u := endpointURL(baseURL, path.Join(assertionsPath, assertType.Name, path.Join(primaryKey...)), v)
 
 	reqOptions := &requestOptions{
-		Method: "GET",
-		URL:    u,
-		Accept: asserts.MediaType,
+		Method:         "GET",
+		URL:            u,
+		Accept:         asserts.MediaType,
+		DeviceAuthNeed: dauthNeed,
 	}
 
 	var asrt asserts.Assertion
@@ -1804,7 +2181,7 @@ func (s *Store) Assertion(assertType *asserts.AssertionType, primaryKey []string
 			}
 		}
 		return e
-	}, defaultRetryStrategy)
+	}, retryStrategy)
 
 	if err != nil {
 		return nil, err
@@ -1862,6 +2239,20 @@ func (s *storeErrors) Error() string {
 	return s.Errors[0].Error()
 }
 
+// noEntitlementErrorCode is the code of the error the store returns
+// when downloading a snap the user or device is not entitled to.
+const noEntitlementErrorCode = "no-entitlement"
+
+// isNoEntitlementResponse returns whether the given error response of
+// the store is about the entitlement to the snap, its body is consumed.
+func isNoEntitlementResponse(resp *http.Response) bool {
+	var errorInfo storeErrors
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&errorInfo); err != nil {
+		return false
+	}
+	return errorInfo.Code() == noEntitlementErrorCode
+}
+
 func buyOptionError(message string) (*client.BuyResult, error) {
 	return nil, fmt.Errorf("cannot buy snap: %s", message)
 }
@@ -1992,6 +2383,35 @@ func (s *Store) ReadyToBuy(user *auth.UserState) error {
 	}
 }
 
+// LANPeers fetches the blobs of snaps from other machines of the local
+// network.
+type LANPeers interface {
+	// Fetch downloads the blob with the given sha3-384 and size into
+	// targetPath, returning lanpeers.ErrNotFound if no machine has it.
+	Fetch(ctx context.Context, name, sha3_384 string, size int64, targetPath string, pbar progress.Meter) error
+	// Share allows handing out the blob with the given sha3-384 to
+	// the other machines, it is only called for the blobs of
+	// revisions that are neither private nor paid.
+	Share(sha3_384 string)
+}
+
+// SetLANPeers makes the store fetch the snaps it downloads from the
+// given local network peers when they have them.
+func (s *Store) SetLANPeers(peers LANPeers) {
+	s.lanPeers = peers
+}
+
+// SetLocalRepository makes the store resolve snaps and assertions against
+// the given local repository instead of the network, whenever a directory
+// is set for it.
+func (s *Store) SetLocalRepository(repo *LocalRepository) {
+	s.localRepo = repo
+}
+
+func (s *Store) useLocalRepository() bool {
+	return s.localRepo != nil && s.localRepo.Dir() != ""
+}
+
 func (s *Store) CacheDownloads() int {
 	return s.cfg.CacheDownloads
 }
@@ -2128,6 +2548,10 @@ func (s *Store) SnapAction(ctx context.Context, currentSnaps []*CurrentSnap, act
 		return nil, &SnapActionError{NoResults: true}
 	}
 
+	if s.useLocalRepository() {
+		return s.localRepo.snapAction(currentSnaps, actions, s.architecture)
+	}
+
 	authRefreshes := 0
 	for {
 		snaps, err := s.snapAction(ctx, currentSnaps, actions, user, opts)
@@ -2382,6 +2806,9 @@ func (s *Store) snapAction(ctx context.Context, currentSnaps []*CurrentSnap, act
 		if err != nil {
 			return nil, fmt.Errorf("unexpected invalid install/refresh API result: %v", err)
 		}
+		if !snapInfo.Private && !snapInfo.Paid && snapInfo.Sha3_384 != "" {
+			s.notePublicBlob(snapInfo.Sha3_384)
+		}
 
 		snapInfo.Channel = res.EffectiveChannel
 
@@ -2567,3 +2994,54 @@ func (s *Store) CreateCohorts(ctx context.Context, snaps []string) (map[string]s
 
 	return remote.CohortKeys, nil
 }
+
+// RefreshMetric is the outcome of the refresh of a snap as reported by
+// ReportRefreshMetrics.
+type RefreshMetric struct {
+	Snap         string        `json:"snap"`
+	FromRevision snap.Revision `json:"from-revision"`
+	ToRevision   snap.Revision `json:"to-revision"`
+	Auto         bool          `json:"auto"`
+	Success      bool          `json:"success"`
+	ErrorClass   string        `json:"error-class,omitempty"`
+	// Duration is in seconds.
+	Duration float64   `json:"duration"`
+	Delta    bool      `json:"delta"`
+	Time     time.Time `json:"time"`
+}
+
+// ReportRefreshMetrics sends the given refresh metrics to the configured
+// RefreshMetricsURL. The request carries no device or user
+// authentication. It returns ErrNoRefreshMetricsURL if there is nowhere
+// to report them to.
+func (s *Store) ReportRefreshMetrics(ctx context.Context, metrics []*RefreshMetric) error {
+	if s.cfg.RefreshMetricsURL == nil {
+		return ErrNoRefreshMetricsURL
+	}
+	jsonData, err := json.Marshal(struct {
+		Metrics []*RefreshMetric `json:"metrics"`
+	}{Metrics: metrics})
+	if err != nil {
+		return err
+	}
+
+	reqOptions := &requestOptions{
+		Method:         "POST",
+		URL:            s.cfg.RefreshMetricsURL,
+		APILevel:       apiV2Endps,
+		ContentType:    jsonContentType,
+		Data:           jsonData,
+		DeviceAuthNeed: deviceAuthNever,
+	}
+
+	resp, err := s.retryRequestDecodeJSON(ctx, reqOptions, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case 200, 202, 204:
+		return nil
+	default:
+		return respToError(resp, "report refresh metrics")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"context"
 	"crypto"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -58,6 +59,7 @@ import (
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/lanpeers"
 	"github.com/snapcore/snapd/testutil"
 )
 
@@ -151,6 +153,7 @@ const (
 	snapActionPath  = "/v2/snaps/refresh"
 	infoPathPattern = "/v2/snaps/info/.*"
 	cohortsPath     = "/v2/cohorts"
+	metricsPath     = "/v2/snaps/refresh-metrics"
 )
 
 // Build details path for a snap name.
@@ -249,6 +252,8 @@ type testDauthContext struct {
 	proxyStoreID  string
 	proxyStoreURL *url.URL
 
+	assertionsProxyURL *url.URL
+
 	storeID string
 
 	cloudInfo *auth.CloudInfo
@@ -321,6 +326,10 @@ func (dac *testDauthContext) ProxyStoreParams(defaultURL *url.URL) (string, *url
 	return "", defaultURL, nil
 }
 
+func (dac *testDauthContext) AssertionsProxyURL() (*url.URL, error) {
+	return dac.assertionsProxyURL, nil
+}
+
 func (dac *testDauthContext) CloudInfo() (*auth.CloudInfo, error) {
 	return dac.cloudInfo, nil
 }
@@ -476,6 +485,23 @@ func (s *storeTestSuite) TestDownloadStreamOK(c *C) {
 	c.Check(buf.String(), Equals, string(expectedContent))
 }
 
+func (s *storeTestSuite) TestDownloadStreamNoEntitlement(c *C) {
+	restore := store.MockDoDownloadReq(func(ctx context.Context, url *url.URL, cdnHeader string, s *store.Store, user *auth.UserState) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 403,
+			Body:       ioutil.NopCloser(strings.NewReader(noEntitlementResponse)),
+		}, nil
+	})
+	defer restore()
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "http://anon-url"
+
+	_, err := s.store.DownloadStream(context.TODO(), "foo", &snap.DownloadInfo, nil)
+	c.Assert(err, DeepEquals, &store.NoEntitlementError{Snap: "foo"})
+}
+
 func (s *storeTestSuite) TestDownloadOK(c *C) {
 	expectedContent := []byte("I was downloaded")
 
@@ -571,6 +597,8 @@ func (s *storeTestSuite) TestDownloadEOFHandlesResumeHashCorrectly(c *C) {
 			mockServer.CloseClientConnections()
 			return
 		}
+		c.Check(r.Header.Get("Range"), Equals, fmt.Sprintf("bytes=%d-", len(buf)-5))
+		w.WriteHeader(206)
 		w.Write(buf[len(buf)-5:])
 	}))
 
@@ -1244,6 +1272,102 @@ func (s *storeTestSuite) TestDoRequestRefreshesAuth(c *C) {
 	c.Check(refreshDischargeEndpointHit, Equals, true)
 }
 
+const noEntitlementResponse = `{"error_list":[{"code":"no-entitlement","message":"not entitled"}]}`
+
+func (s *storeTestSuite) TestDownloadNoEntitlementRefreshesAuth(c *C) {
+	refresh, err := makeTestRefreshDischargeResponse()
+	c.Assert(err, IsNil)
+
+	refreshDischargeEndpointHit := false
+	mockSSOServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fmt.Sprintf(`{"discharge_macaroon": "%s"}`, refresh))
+		refreshDischargeEndpointHit = true
+	}))
+	defer mockSSOServer.Close()
+	store.UbuntuoneRefreshDischargeAPI = mockSSOServer.URL + "/tokens/refresh"
+
+	// the snap was bought after the discharge was obtained
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if s.user.StoreDischarges[0] == refresh {
+			io.WriteString(w, "response-data")
+		} else {
+			w.WriteHeader(403)
+			io.WriteString(w, noEntitlementResponse)
+		}
+	}))
+	defer mockServer.Close()
+
+	dauthCtx := &testDauthContext{c: c, device: s.device, user: s.user}
+	sto := store.New(&store.Config{}, dauthCtx)
+
+	var buf SillyBuffer
+	err = store.Download(s.ctx, "foo", "", mockServer.URL, s.user, sto, &buf, 0, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, "response-data")
+	c.Check(refreshDischargeEndpointHit, Equals, true)
+	c.Check(n, Equals, 2)
+}
+
+func (s *storeTestSuite) TestDownloadNoEntitlement(c *C) {
+	refresh, err := makeTestRefreshDischargeResponse()
+	c.Assert(err, IsNil)
+
+	mockSSOServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fmt.Sprintf(`{"discharge_macaroon": "%s"}`, refresh))
+	}))
+	defer mockSSOServer.Close()
+	store.UbuntuoneRefreshDischargeAPI = mockSSOServer.URL + "/tokens/refresh"
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(401)
+		io.WriteString(w, noEntitlementResponse)
+	}))
+	defer mockServer.Close()
+
+	dauthCtx := &testDauthContext{c: c, device: s.device, user: s.user}
+	sto := store.New(&store.Config{}, dauthCtx)
+
+	var buf SillyBuffer
+	err = store.Download(s.ctx, "foo", "", mockServer.URL, s.user, sto, &buf, 0, nil, nil)
+	c.Assert(err, DeepEquals, &store.NoEntitlementError{Snap: "foo"})
+	c.Check(err, ErrorMatches, `no entitlement to snap "foo": it is private or needs to be bought, log in with an account that has access to it`)
+	// the authorization was refreshed only once
+	c.Check(n, Equals, 2)
+}
+
+func (s *storeTestSuite) TestDownloadForbiddenNotEntitlement(c *C) {
+	refreshDischargeEndpointHit := false
+	mockSSOServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshDischargeEndpointHit = true
+	}))
+	defer mockSSOServer.Close()
+	store.UbuntuoneRefreshDischargeAPI = mockSSOServer.URL + "/tokens/refresh"
+
+	for _, body := range []string{"", "<html>Forbidden</html>", `{"error_list":[{"code":"other-error","message":"no"}]}`} {
+		n := 0
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n++
+			w.WriteHeader(403)
+			io.WriteString(w, body)
+		}))
+
+		dauthCtx := &testDauthContext{c: c, device: s.device, user: s.user}
+		sto := store.New(&store.Config{}, dauthCtx)
+
+		var buf SillyBuffer
+		err := store.Download(s.ctx, "foo", "", mockServer.URL, s.user, sto, &buf, 0, nil, nil)
+		mockServer.Close()
+		c.Assert(err, FitsTypeOf, &store.DownloadError{}, Commentf(body))
+		c.Check(err.(*store.DownloadError).Code, Equals, 403)
+		c.Check(n, Equals, 1)
+	}
+	c.Check(refreshDischargeEndpointHit, Equals, false)
+}
+
 func (s *storeTestSuite) TestDoRequestForwardsRefreshAuthFailure(c *C) {
 	// mock refresh response
 	refreshDischargeEndpointHit := false
@@ -3417,6 +3541,137 @@ func (s *storeTestSuite) TestAssertionProxyStoreFromAuthContext(c *C) {
 	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
 }
 
+func (s *storeTestSuite) TestAssertionFromAssertionsProxy(c *C) {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/mirror/api/v1/snaps/assertions/.*")
+		c.Check(r.URL.Path, Matches, ".*/snap-declaration/16/snapidfoo")
+		// the proxy is not given any credentials
+		c.Check(r.Header.Get("Authorization"), Equals, "")
+		c.Check(r.Header.Get("X-Device-Authorization"), Equals, "")
+		io.WriteString(w, testAssertion)
+	}))
+	c.Assert(proxyServer, NotNil)
+	defer proxyServer.Close()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Errorf("unexpected request to the store: %v", r.URL)
+		w.WriteHeader(500)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	proxyURL, _ := url.Parse(proxyServer.URL + "/mirror/")
+	cfg := store.Config{
+		AssertionsBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{
+		c:                  c,
+		device:             s.device,
+		assertionsProxyURL: proxyURL,
+	}
+	sto := store.New(&cfg, dauthCtx)
+
+	a, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, s.user)
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
+}
+
+func (s *storeTestSuite) TestAssertionAssertionsProxyFailureFallback(c *C) {
+	store.MockAssertionsProxyRetryStrategy(&s.BaseTest, retry.LimitCount(2, retry.Exponential{
+		Initial: time.Millisecond,
+		Factor:  1,
+	}))
+
+	proxyHits := 0
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
+		c.Check(r.Header.Get("Authorization"), Equals, "")
+		proxyHits++
+		w.WriteHeader(500)
+	}))
+	c.Assert(proxyServer, NotNil)
+	defer proxyServer.Close()
+
+	storeHits := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
+		// only the store is given the credentials
+		c.Check(r.Header.Get("Authorization"), Not(Equals), "")
+		storeHits++
+		io.WriteString(w, testAssertion)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	cfg := store.Config{
+		AssertionsBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{
+		c:                  c,
+		device:             s.device,
+		assertionsProxyURL: proxyURL,
+	}
+	sto := store.New(&cfg, dauthCtx)
+
+	a, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, s.user)
+	c.Assert(err, IsNil)
+	c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
+	c.Check(proxyHits, Equals, 2)
+	c.Check(storeHits, Equals, 1)
+
+	// the failing proxy is not used for a while
+	_, err = sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, s.user)
+	c.Assert(err, IsNil)
+	c.Check(proxyHits, Equals, 2)
+	c.Check(storeHits, Equals, 2)
+}
+
+func (s *storeTestSuite) TestAssertionAssertionsProxyNotFoundFallback(c *C) {
+	proxyHits := 0
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
+		proxyHits++
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(404)
+		io.WriteString(w, `{"status": 404,"title": "not found"}`)
+	}))
+	c.Assert(proxyServer, NotNil)
+	defer proxyServer.Close()
+
+	storeHits := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
+		storeHits++
+		io.WriteString(w, testAssertion)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	cfg := store.Config{
+		AssertionsBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{
+		c:                  c,
+		device:             s.device,
+		assertionsProxyURL: proxyURL,
+	}
+	sto := store.New(&cfg, dauthCtx)
+
+	for i := 1; i <= 2; i++ {
+		a, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "snapidfoo"}, nil)
+		c.Assert(err, IsNil)
+		c.Check(a.Type(), Equals, asserts.SnapDeclarationType)
+		// a proxy not having the assertion is not a failure
+		c.Check(proxyHits, Equals, i)
+		c.Check(storeHits, Equals, i)
+	}
+}
+
 func (s *storeTestSuite) TestAssertionNotFound(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assertRequest(c, r, "GET", "/api/v1/snaps/assertions/.*")
@@ -4293,6 +4548,152 @@ func (s *storeTestSuite) TestDownloadCacheMiss(c *C) {
 	c.Check(obs.puts, DeepEquals, []string{fmt.Sprintf("the-snaps-sha3_384:%s", path)})
 }
 
+type fakeLANPeers struct {
+	err     error
+	fetches []string
+	shared  []string
+}
+
+func (p *fakeLANPeers) Share(sha3_384 string) {
+	p.shared = append(p.shared, sha3_384)
+}
+
+func (p *fakeLANPeers) Fetch(ctx context.Context, name, sha3_384 string, size int64, targetPath string, pbar progress.Meter) error {
+	p.fetches = append(p.fetches, fmt.Sprintf("%s:%s:%d:%s", name, sha3_384, size, targetPath))
+	if p.err != nil {
+		return p.err
+	}
+	return ioutil.WriteFile(targetPath, []byte("from a peer"), 0600)
+}
+
+func (s *storeTestSuite) TestDownloadFromLANPeers(c *C) {
+	obs := &cacheObserver{inCache: map[string]bool{}}
+	restore := s.store.MockCacher(obs)
+	defer restore()
+	peers := &fakeLANPeers{}
+	s.store.SetLANPeers(peers)
+
+	restore = store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		c.Fatalf("download should not be called when a peer has the snap")
+		return nil
+	})
+	defer restore()
+
+	snap := &snap.Info{}
+	snap.Sha3_384 = "the-snaps-sha3_384"
+	snap.Size = 11
+
+	path := filepath.Join(c.MkDir(), "downloaded-file")
+	err := s.store.Download(s.ctx, "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(path, testutil.FileEquals, "from a peer")
+	c.Check(peers.fetches, DeepEquals, []string{fmt.Sprintf("foo:the-snaps-sha3_384:11:%s", path)})
+	c.Check(obs.puts, DeepEquals, []string{fmt.Sprintf("the-snaps-sha3_384:%s", path)})
+}
+
+func (s *storeTestSuite) TestDownloadFromLANPeersFallsBackToStore(c *C) {
+	obs := &cacheObserver{inCache: map[string]bool{}}
+	restore := s.store.MockCacher(obs)
+	defer restore()
+
+	downloads := 0
+	restore = store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		downloads++
+		return nil
+	})
+	defer restore()
+
+	snap := &snap.Info{}
+	snap.Sha3_384 = "the-snaps-sha3_384"
+	snap.Size = 11
+
+	for _, err := range []error{lanpeers.ErrNotFound, errors.New("boom")} {
+		peers := &fakeLANPeers{err: err}
+		s.store.SetLANPeers(peers)
+
+		path := filepath.Join(c.MkDir(), "downloaded-file")
+		err := s.store.Download(s.ctx, "foo", path, &snap.DownloadInfo, nil, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(peers.fetches, HasLen, 1)
+	}
+	c.Check(downloads, Equals, 2)
+
+	// peers are not asked when the size of the snap is unknown
+	peers := &fakeLANPeers{}
+	s.store.SetLANPeers(peers)
+	snap.Size = 0
+	err := s.store.Download(s.ctx, "foo", filepath.Join(c.MkDir(), "downloaded-file"), &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(peers.fetches, HasLen, 0)
+	c.Check(downloads, Equals, 3)
+}
+
+func (s *storeTestSuite) TestDownloadSharesOnlyPublicBlobsWithLANPeers(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "POST", snapActionPath)
+		io.WriteString(w, `{
+  "results": [{
+     "result": "download",
+     "instance-key": "download-1",
+     "snap-id": "public-id",
+     "name": "public",
+     "snap": {"snap-id": "public-id", "name": "public", "revision": 1, "download": {"sha3-384": "public-sha3_384", "size": 11}}
+  }, {
+     "result": "download",
+     "instance-key": "download-2",
+     "snap-id": "private-id",
+     "name": "private",
+     "snap": {"snap-id": "private-id", "name": "private", "revision": 1, "private": true, "download": {"sha3-384": "private-sha3_384", "size": 11}}
+  }, {
+     "result": "download",
+     "instance-key": "download-3",
+     "snap-id": "paid-id",
+     "name": "paid",
+     "snap": {"snap-id": "paid-id", "name": "paid", "revision": 1, "prices": {"EUR": "1.99"}, "download": {"sha3-384": "paid-sha3_384", "size": 11}}
+  }]
+}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+	restore := sto.MockCacher(&cacheObserver{inCache: map[string]bool{}})
+	defer restore()
+	peers := &fakeLANPeers{}
+	sto.SetLANPeers(peers)
+
+	results, err := sto.SnapAction(s.ctx, nil, []*store.SnapAction{
+		{Action: "download", InstanceName: "public"},
+		{Action: "download", InstanceName: "private"},
+		{Action: "download", InstanceName: "paid"},
+	}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+
+	for _, info := range results {
+		path := filepath.Join(c.MkDir(), "downloaded-file")
+		err := sto.Download(s.ctx, info.InstanceName(), path, &info.DownloadInfo, nil, nil, nil)
+		c.Assert(err, IsNil)
+	}
+	c.Check(peers.fetches, HasLen, 3)
+	c.Check(peers.shared, DeepEquals, []string{"public-sha3_384"})
+
+	// nothing is shared when the download fails
+	peers.err = errors.New("boom")
+	restore = store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		return errors.New("cannot download")
+	})
+	defer restore()
+	err = sto.Download(s.ctx, "public", filepath.Join(c.MkDir(), "downloaded-file"), &results[0].DownloadInfo, nil, nil, nil)
+	c.Assert(err, NotNil)
+	c.Check(peers.shared, HasLen, 1)
+}
+
 var (
 	helloRefreshedDateStr = "2018-02-27T11:00:00Z"
 	helloRefreshedDate    time.Time
@@ -7302,3 +7703,85 @@ func (s *storeTestSuite) TestCreateCohort(c *C) {
 		"potato": "U3VwZXIgc2VjcmV0IHN0dWZmIGVuY3J5cHRlZCBoZXJlLg==",
 	})
 }
+
+func (s *storeTestSuite) TestReportRefreshMetrics(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertRequest(c, r, "POST", metricsPath)
+		// the device is not identified
+		c.Check(r.Header.Get("Snap-Device-Authorization"), Equals, "")
+		c.Check(r.Header.Get("Authorization"), Equals, "")
+		c.Check(r.Header.Get("Content-Type"), Equals, "application/json")
+
+		var req map[string]interface{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&req), IsNil)
+		c.Check(req, DeepEquals, map[string]interface{}{
+			"metrics": []interface{}{
+				map[string]interface{}{
+					"snap":          "foo",
+					"from-revision": "1",
+					"to-revision":   "2",
+					"auto":          false,
+					"success":       true,
+					"duration":      1.5,
+					"delta":         false,
+					"time":          "2019-11-05T10:20:30Z",
+				},
+			},
+		})
+
+		n++
+		if n == 1 {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(204)
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	mockServerURL, _ := url.Parse(mockServer.URL)
+	cfg := store.Config{
+		StoreBaseURL: mockServerURL,
+	}
+	dauthCtx := &testDauthContext{c: c, device: s.device}
+	sto := store.New(&cfg, dauthCtx)
+
+	metrics := []*store.RefreshMetric{{
+		Snap:         "foo",
+		FromRevision: snap.R(1),
+		ToRevision:   snap.R(2),
+		Success:      true,
+		Duration:     1.5,
+		Time:         time.Date(2019, 11, 5, 10, 20, 30, 0, time.UTC),
+	}}
+	// nowhere to report to
+	err := sto.ReportRefreshMetrics(s.ctx, metrics)
+	c.Check(err, Equals, store.ErrNoRefreshMetricsURL)
+
+	cfg.RefreshMetricsURL = mockServerURL.ResolveReference(&url.URL{Path: metricsPath})
+	sto = store.New(&cfg, dauthCtx)
+	err = sto.ReportRefreshMetrics(s.ctx, metrics)
+	c.Check(err, ErrorMatches, `cannot report refresh metrics: got unexpected HTTP status code 400 via POST to "http://.*/v2/snaps/refresh-metrics"`)
+	err = sto.ReportRefreshMetrics(s.ctx, metrics)
+	c.Check(err, IsNil)
+	c.Check(n, Equals, 2)
+}
+
+func (s *storeTestSuite) TestRefreshMetricsURL(c *C) {
+	defer os.Unsetenv("SNAPPY_FORCE_REFRESH_METRICS_URL")
+
+	u, err := store.RefreshMetricsURL()
+	c.Assert(err, IsNil)
+	c.Check(u, IsNil)
+
+	os.Setenv("SNAPPY_FORCE_REFRESH_METRICS_URL", "https://metrics.example.com/refresh")
+	u, err = store.RefreshMetricsURL()
+	c.Assert(err, IsNil)
+	c.Check(u.String(), Equals, "https://metrics.example.com/refresh")
+
+	os.Setenv("SNAPPY_FORCE_REFRESH_METRICS_URL", "http://metrics.example.com/refresh")
+	_, err = store.RefreshMetricsURL()
+	c.Check(err, ErrorMatches, `invalid SNAPPY_FORCE_REFRESH_METRICS_URL: "http://metrics.example.com/refresh" is not an https URL`)
+}
@@ -97,6 +97,10 @@ func (Store) CreateCohorts(context.Context, []string) (map[string]string, error)
 	panic("CreateCohort not expected")
 }
 
+func (Store) ReportRefreshMetrics(context.Context, []*store.RefreshMetric) error {
+	panic("ReportRefreshMetrics not expected")
+}
+
 func (Store) LoginUser(username, password, otp string) (string, string, error) {
 	panic("LoginUser not expected")
 }
@@ -0,0 +1,168 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package systemd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// emulation is the Systemd used while preseeding, when systemd is not
+// running: units are only enabled and disabled on disk, and snaps are
+// mounted directly.
+type emulation struct {
+	rootDir string
+}
+
+type notImplementedError struct {
+	op string
+}
+
+func (e *notImplementedError) Error() string {
+	return fmt.Sprintf("%q is not implemented in emulation mode", e.op)
+}
+
+func (s *emulation) DaemonReload() error {
+	return nil
+}
+
+func (s *emulation) Enable(service string) error {
+	_, err := systemctlCmd("--root", s.rootDir, "enable", service)
+	return err
+}
+
+func (s *emulation) Disable(service string) error {
+	_, err := systemctlCmd("--root", s.rootDir, "disable", service)
+	return err
+}
+
+func (s *emulation) Mask(service string) error {
+	_, err := systemctlCmd("--root", s.rootDir, "mask", service)
+	return err
+}
+
+func (s *emulation) Unmask(service string) error {
+	_, err := systemctlCmd("--root", s.rootDir, "unmask", service)
+	return err
+}
+
+func (s *emulation) Start(service ...string) error {
+	return &notImplementedError{"Start"}
+}
+
+func (s *emulation) StartNoBlock(service ...string) error {
+	return &notImplementedError{"StartNoBlock"}
+}
+
+func (s *emulation) Stop(service string, timeout time.Duration) error {
+	return &notImplementedError{"Stop"}
+}
+
+func (s *emulation) Kill(service, signal, who string) error {
+	return &notImplementedError{"Kill"}
+}
+
+func (s *emulation) Restart(service string, timeout time.Duration) error {
+	return &notImplementedError{"Restart"}
+}
+
+func (s *emulation) Status(units ...string) ([]*UnitStatus, error) {
+	return nil, &notImplementedError{"Status"}
+}
+
+func (s *emulation) ResourceUsage(units ...string) ([]*UnitResourceUsage, error) {
+	return nil, &notImplementedError{"ResourceUsage"}
+}
+
+func (s *emulation) IsEnabled(service string) (bool, error) {
+	return false, &notImplementedError{"IsEnabled"}
+}
+
+func (s *emulation) IsActive(service string) (bool, error) {
+	return false, &notImplementedError{"IsActive"}
+}
+
+func (s *emulation) LogReader(services []string, n int, follow bool, afterCursor string) (io.ReadCloser, error) {
+	return nil, &notImplementedError{"LogReader"}
+}
+
+// AddMountUnitFile writes and enables the mount unit and mounts what
+// at where directly, as systemd would on boot.
+func (s *emulation) AddMountUnitFile(snapName, revision, what, where, fstype string) (string, error) {
+	fstype, options, err := mountOptions(what, fstype)
+	if err != nil {
+		return "", err
+	}
+	mountUnitName, err := writeMountUnitFile(snapName, revision, what, where, fstype, options)
+	if err != nil {
+		return "", err
+	}
+
+	// what and where are relative to the root directory
+	hostWhat := filepath.Join(dirs.GlobalRootDir, what)
+	hostWhere := filepath.Join(dirs.GlobalRootDir, where)
+	if err := os.MkdirAll(hostWhere, 0755); err != nil {
+		return "", err
+	}
+	if _, err := osutil.RunHelper(&osutil.HelperCommand{
+		Name: "mount",
+		Args: []string{"-t", fstype, hostWhat, hostWhere, "-o", strings.Join(options, ",")},
+	}); err != nil {
+		return "", err
+	}
+
+	if err := s.Enable(mountUnitName); err != nil {
+		return "", err
+	}
+	return mountUnitName, nil
+}
+
+// RemoveMountUnitFile unmounts the directory and disables and removes
+// its mount unit.
+func (s *emulation) RemoveMountUnitFile(mountedDir string) error {
+	unit := MountUnitPath(dirs.StripRootDir(mountedDir))
+	if !osutil.FileExists(unit) {
+		return nil
+	}
+
+	isMounted, err := osutil.IsMounted(mountedDir)
+	if err != nil {
+		return err
+	}
+	if isMounted {
+		if _, err := osutil.RunHelper(&osutil.HelperCommand{
+			Name: "umount",
+			Args: []string{"-d", "-l", mountedDir},
+		}); err != nil {
+			return err
+		}
+	}
+	if err := s.Disable(filepath.Base(unit)); err != nil {
+		return err
+	}
+	return os.Remove(unit)
+}
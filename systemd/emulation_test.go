@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package systemd_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil/squashfs"
+	"github.com/snapcore/snapd/snapdenv"
+	"github.com/snapcore/snapd/testutil"
+
+	. "github.com/snapcore/snapd/systemd"
+)
+
+func (s *SystemdTestSuite) TestEmulationEnableDisable(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	sysd := New("/path", SystemMode, nil)
+	c.Assert(sysd.DaemonReload(), IsNil)
+	c.Assert(sysd.Enable("foo.service"), IsNil)
+	c.Assert(sysd.Disable("foo.service"), IsNil)
+	c.Assert(sysd.Mask("bar.service"), IsNil)
+	c.Assert(sysd.Unmask("bar.service"), IsNil)
+
+	c.Check(s.argses, DeepEquals, [][]string{
+		{"--root", "/path", "enable", "foo.service"},
+		{"--root", "/path", "disable", "foo.service"},
+		{"--root", "/path", "mask", "bar.service"},
+		{"--root", "/path", "unmask", "bar.service"},
+	})
+}
+
+func (s *SystemdTestSuite) TestEmulationNotImplemented(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+
+	sysd := New("/path", SystemMode, nil)
+	c.Check(sysd.Start("foo.service"), ErrorMatches, `"Start" is not implemented in emulation mode`)
+	c.Check(sysd.Stop("foo.service", time.Second), ErrorMatches, `"Stop" is not implemented in emulation mode`)
+	_, err := sysd.Status("foo.service")
+	c.Check(err, ErrorMatches, `"Status" is not implemented in emulation mode`)
+	_, err = sysd.ResourceUsage("foo.service")
+	c.Check(err, ErrorMatches, `"ResourceUsage" is not implemented in emulation mode`)
+	c.Check(s.argses, HasLen, 0)
+
+	// user instances are not emulated
+	c.Check(New("/path", UserMode, nil).Start("foo.service"), IsNil)
+}
+
+func (s *SystemdTestSuite) TestEmulationAddRemoveMountUnit(c *C) {
+	restore := snapdenv.MockPreseeding(true)
+	defer restore()
+	restore = squashfs.MockUseFuse(false)
+	defer restore()
+
+	mockMount := testutil.MockCommand(c, "mount", "")
+	defer mockMount.Restore()
+
+	rootDir := dirs.GlobalRootDir
+	mockSnapPath := filepath.Join(rootDir, "/var/lib/snapd/snaps/foo_42.snap")
+	makeMockFile(c, mockSnapPath)
+	where := filepath.Join(rootDir, "/snap/snapname/123")
+
+	sysd := New(rootDir, SystemMode, nil)
+	mountUnitName, err := sysd.AddMountUnitFile("foo", "42", "/var/lib/snapd/snaps/foo_42.snap", "/snap/snapname/123", "squashfs")
+	c.Assert(err, IsNil)
+	c.Check(mountUnitName, Equals, "snap-snapname-123.mount")
+
+	mountUnit := filepath.Join(dirs.SnapServicesDir, mountUnitName)
+	c.Check(mountUnit, testutil.FileContains, "What=/var/lib/snapd/snaps/foo_42.snap\nWhere=/snap/snapname/123\nType=squashfs\nOptions=nodev,ro,x-gdu.hide\n")
+	c.Check(where, testutil.FilePresent)
+	c.Check(mockMount.Calls(), DeepEquals, [][]string{
+		{"mount", "-t", "squashfs", mockSnapPath, where, "-o", "nodev,ro,x-gdu.hide"},
+	})
+	c.Check(s.argses, DeepEquals, [][]string{
+		{"--root", rootDir, "enable", "snap-snapname-123.mount"},
+	})
+
+	// not mounted for real, so only disabled and removed
+	c.Assert(sysd.RemoveMountUnitFile(where), IsNil)
+	c.Check(mountUnit, testutil.FileAbsent)
+	c.Check(s.argses[1:], DeepEquals, [][]string{
+		{"--root", rootDir, "disable", "snap-snapname-123.mount"},
+	})
+	_, err = os.Stat(where)
+	c.Check(err, IsNil)
+}
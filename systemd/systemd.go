@@ -40,6 +40,7 @@ import (
 	"github.com/snapcore/snapd/osutil/squashfs"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/selinux"
+	"github.com/snapcore/snapd/snapdenv"
 )
 
 var (
@@ -130,11 +131,16 @@ func Available() error {
 
 var osutilStreamCommand = osutil.StreamCommand
 
-// jctl calls journalctl to get the JSON logs of the given services.
-var jctl = func(svcs []string, n int, follow bool) (io.ReadCloser, error) {
+// jctl calls journalctl to get the JSON logs of the given services,
+// interleaved, optionally only the ones after the given cursor.
+var jctl = func(svcs []string, n int, follow bool, afterCursor string) (io.ReadCloser, error) {
 	// args will need two entries per service, plus a fixed number (give or take
 	// one) for the initial options.
-	args := make([]string, 0, 2*len(svcs)+6)        // the fixed number is 6
+	fixed := 6
+	if afterCursor != "" {
+		fixed++
+	}
+	args := make([]string, 0, 2*len(svcs)+fixed)    // the fixed number is 6 (or 7)
 	args = append(args, "-o", "json", "--no-pager") //   3...
 	if n < 0 {
 		args = append(args, "--no-tail") // < 2
@@ -144,6 +150,9 @@ var jctl = func(svcs []string, n int, follow bool) (io.ReadCloser, error) {
 	if follow {
 		args = append(args, "-f") // ... + 1 == 6
 	}
+	if afterCursor != "" {
+		args = append(args, "--after-cursor="+afterCursor) // ... + 1 == 7
+	}
 
 	for i := range svcs {
 		args = append(args, "-u", svcs[i]) // this is why 2×
@@ -152,7 +161,7 @@ var jctl = func(svcs []string, n int, follow bool) (io.ReadCloser, error) {
 	return osutilStreamCommand("journalctl", args...)
 }
 
-func MockJournalctl(f func(svcs []string, n int, follow bool) (io.ReadCloser, error)) func() {
+func MockJournalctl(f func(svcs []string, n int, follow bool, afterCursor string) (io.ReadCloser, error)) func() {
 	oldJctl := jctl
 	jctl = f
 	return func() {
@@ -171,9 +180,10 @@ type Systemd interface {
 	Kill(service, signal, who string) error
 	Restart(service string, timeout time.Duration) error
 	Status(units ...string) ([]*UnitStatus, error)
+	ResourceUsage(units ...string) ([]*UnitResourceUsage, error)
 	IsEnabled(service string) (bool, error)
 	IsActive(service string) (bool, error)
-	LogReader(services []string, n int, follow bool) (io.ReadCloser, error)
+	LogReader(services []string, n int, follow bool, afterCursor string) (io.ReadCloser, error)
 	AddMountUnitFile(name, revision, what, where, fstype string) (string, error)
 	RemoveMountUnitFile(baseDir string) error
 	Mask(service string) error
@@ -203,6 +213,9 @@ type reporter interface {
 
 // New returns a Systemd that uses the given rootDir
 func New(rootDir string, mode InstanceMode, rep reporter) Systemd {
+	if mode == SystemMode && snapdenv.Preseeding() {
+		return &emulation{rootDir: rootDir}
+	}
 	return &systemd{rootDir: rootDir, mode: mode, reporter: rep}
 }
 
@@ -303,9 +316,11 @@ func (s *systemd) StartNoBlock(serviceNames ...string) error {
 	return err
 }
 
-// LogReader for the given services
-func (*systemd) LogReader(serviceNames []string, n int, follow bool) (io.ReadCloser, error) {
-	return jctl(serviceNames, n, follow)
+// LogReader for the given services, their logs are interleaved. If
+// afterCursor is not empty only the logs after the entry with that
+// journal cursor are returned.
+func (*systemd) LogReader(serviceNames []string, n int, follow bool, afterCursor string) (io.ReadCloser, error) {
+	return jctl(serviceNames, n, follow, afterCursor)
 }
 
 var statusregex = regexp.MustCompile(`(?m)^(?:(.+?)=(.*)|(.*))?$`)
@@ -315,10 +330,19 @@ type UnitStatus struct {
 	UnitName string
 	Enabled  bool
 	Active   bool
+	// Listening is true for socket units listening for connections
+	Listening bool
+	// NextTrigger is when a timer unit elapses next, zero if it is not
+	// scheduled
+	NextTrigger time.Time
 }
 
 var baseProperties = []string{"Id", "ActiveState", "UnitFileState"}
 var extendedProperties = []string{"Id", "ActiveState", "UnitFileState", "Type"}
+
+// activatorProperties are requested for timer and socket units, on top of
+// baseProperties they have optional properties that not all units have
+var activatorProperties = []string{"Id", "ActiveState", "UnitFileState", "SubState", "NextElapseUSecRealtime"}
 var unitProperties = map[string][]string{
 	".timer":  baseProperties,
 	".socket": baseProperties,
@@ -328,6 +352,9 @@ var unitProperties = map[string][]string{
 	".mount": extendedProperties,
 }
 
+// systemctl shows timestamps in the local time zone, e.g. "Mon 2020-11-02 12:15:00 UTC"
+const systemctlTimestampLayout = "Mon 2006-01-02 15:04:05 MST"
+
 func (s *systemd) getUnitStatus(properties []string, unitNames []string) ([]*UnitStatus, error) {
 	cmd := make([]string, len(unitNames)+2)
 	cmd[0] = "show"
@@ -379,7 +406,7 @@ func (s *systemd) getUnitStatus(properties []string, unitNames []string) ([]*Uni
 		k := string(bs[1])
 		v := string(bs[2])
 
-		if v == "" {
+		if v == "" && k != "NextElapseUSecRealtime" {
 			return nil, fmt.Errorf("cannot get unit status: empty field %q in ‘systemctl show’ output", k)
 		}
 
@@ -394,6 +421,15 @@ func (s *systemd) getUnitStatus(properties []string, unitNames []string) ([]*Uni
 		case "UnitFileState":
 			// "static" means it can't be disabled
 			cur.Enabled = v == "enabled" || v == "static"
+		case "SubState":
+			cur.Listening = v == "listening"
+		case "NextElapseUSecRealtime":
+			// not set when the timer is not scheduled, and the
+			// timestamp is only informative so it is not an error
+			// if it cannot be parsed
+			if t, err := time.ParseInLocation(systemctlTimestampLayout, v, time.Local); err == nil {
+				cur.NextTrigger = t
+			}
 		default:
 			return nil, fmt.Errorf("cannot get unit status: unexpected field %q in ‘systemctl show’ output", k)
 		}
@@ -434,7 +470,7 @@ func (s *systemd) Status(unitNames ...string) ([]*UnitStatus, error) {
 		properties []string
 	}{
 		{units: extendedUnits, properties: extendedProperties},
-		{units: limitedUnits, properties: baseProperties},
+		{units: limitedUnits, properties: activatorProperties},
 	} {
 		if len(set.units) == 0 {
 			continue
@@ -461,6 +497,98 @@ func (s *systemd) Status(unitNames ...string) ([]*UnitStatus, error) {
 	return sts, nil
 }
 
+// UnitResourceUsage is the resource usage of a unit, as accounted by
+// systemd in the unit cgroup.
+type UnitResourceUsage struct {
+	UnitName string
+	// Memory is the memory currently used in bytes, or -1 if not
+	// accounted
+	Memory int64
+	// CPUTime is the CPU time consumed since the unit was started, or
+	// -1 if not accounted
+	CPUTime time.Duration
+}
+
+// systemd reports usage that is not accounted as "[not set]" or as the
+// maximum value of the type, depending on the version
+func parseResourceUsage(v string) (int64, error) {
+	if v == "[not set]" || v == "18446744073709551615" {
+		return -1, nil
+	}
+	usage, err := strconv.ParseUint(v, 10, 63)
+	if err != nil {
+		return 0, err
+	}
+	return int64(usage), nil
+}
+
+// ResourceUsage fetches the resource usage of the given units. Usages are
+// returned in the same order as unit names passed in argument.
+func (s *systemd) ResourceUsage(unitNames ...string) ([]*UnitResourceUsage, error) {
+	if s.mode == GlobalUserMode {
+		panic("cannot call resource usage with GlobalUserMode")
+	}
+	if len(unitNames) == 0 {
+		return nil, nil
+	}
+	cmd := append([]string{"show", "--property=Id,MemoryCurrent,CPUUsageNSec"}, unitNames...)
+	bs, err := s.systemctl(cmd...)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]*UnitResourceUsage, 0, len(unitNames))
+	cur := &UnitResourceUsage{}
+	seen := 0
+	for _, bs := range statusregex.FindAllSubmatch(bs, -1) {
+		if len(bs[0]) == 0 {
+			if seen == 0 {
+				continue
+			}
+			// systemctl separates data pertaining to particular units by an empty line
+			if seen != 3 {
+				return nil, fmt.Errorf("cannot get unit %q resource usage: missing fields in ‘systemctl show’ output", cur.UnitName)
+			}
+			usages = append(usages, cur)
+			if len(usages) > len(unitNames) {
+				break
+			}
+			if cur.UnitName != unitNames[len(usages)-1] {
+				return nil, fmt.Errorf("cannot get unit resource usage: queried usage of %q but got usage of %q", unitNames[len(usages)-1], cur.UnitName)
+			}
+			cur = &UnitResourceUsage{}
+			seen = 0
+			continue
+		}
+		if len(bs[3]) > 0 {
+			return nil, fmt.Errorf("cannot get unit resource usage: bad line %q in ‘systemctl show’ output", bs[3])
+		}
+		k := string(bs[1])
+		v := string(bs[2])
+		switch k {
+		case "Id":
+			cur.UnitName = v
+		case "MemoryCurrent":
+			cur.Memory, err = parseResourceUsage(v)
+		case "CPUUsageNSec":
+			var nsec int64
+			nsec, err = parseResourceUsage(v)
+			cur.CPUTime = time.Duration(nsec)
+		default:
+			return nil, fmt.Errorf("cannot get unit resource usage: unexpected field %q in ‘systemctl show’ output", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot get unit resource usage: invalid value of field %q in ‘systemctl show’ output: %q", k, v)
+		}
+		seen++
+	}
+
+	if len(usages) != len(unitNames) {
+		return nil, fmt.Errorf("cannot get unit resource usage: expected %d results, got %d", len(unitNames), len(usages))
+	}
+	return usages, nil
+}
+
 // IsEnabled checkes whether the given service is enabled
 func (s *systemd) IsEnabled(serviceName string) (bool, error) {
 	if s.mode == GlobalUserMode {
@@ -635,22 +763,34 @@ func (l Log) PID() string {
 	return "-"
 }
 
+// Unit is the systemd unit the Log comes from, if any; otherwise, "-".
+func (l Log) Unit() string {
+	if unit, ok := l["_SYSTEMD_UNIT"]; ok {
+		return unit
+	}
+
+	return "-"
+}
+
+// Cursor is the journal cursor of the Log, if any; otherwise, "".
+func (l Log) Cursor() string {
+	return l["__CURSOR"]
+}
+
 // MountUnitPath returns the path of a {,auto}mount unit
 func MountUnitPath(baseDir string) string {
 	escapedPath := EscapeUnitNamePath(baseDir)
 	return filepath.Join(dirs.SnapServicesDir, escapedPath+".mount")
 }
 
-// AddMountUnitFile adds/enables/starts a mount unit.
-func (s *systemd) AddMountUnitFile(snapName, revision, what, where, fstype string) (string, error) {
-	daemonReloadLock.Lock()
-	defer daemonReloadLock.Unlock()
-
+// mountOptions returns the actual filesystem type and the options to
+// use to mount what.
+func mountOptions(what, fstype string) (string, []string, error) {
 	options := []string{"nodev"}
 	if fstype == "squashfs" {
 		newFsType, newOptions, err := squashfs.FsType()
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 		options = append(options, newOptions...)
 		fstype = newFsType
@@ -664,7 +804,12 @@ func (s *systemd) AddMountUnitFile(snapName, revision, what, where, fstype strin
 		options = append(options, "bind")
 		fstype = "none"
 	}
+	return fstype, options, nil
+}
 
+// writeMountUnitFile writes the mount unit for the given snap
+// revision mounting what at where and returns the name of the unit.
+func writeMountUnitFile(snapName, revision, what, where, fstype string, options []string) (string, error) {
 	c := fmt.Sprintf(`[Unit]
 Description=Mount unit for %s, revision %s
 Before=snapd.service
@@ -680,7 +825,22 @@ WantedBy=multi-user.target
 `, snapName, revision, what, where, fstype, strings.Join(options, ","))
 
 	mu := MountUnitPath(where)
-	mountUnitName, err := filepath.Base(mu), osutil.AtomicWriteFile(mu, []byte(c), 0644, 0)
+	if err := osutil.AtomicWriteFile(mu, []byte(c), 0644, 0); err != nil {
+		return "", err
+	}
+	return filepath.Base(mu), nil
+}
+
+// AddMountUnitFile adds/enables/starts a mount unit.
+func (s *systemd) AddMountUnitFile(snapName, revision, what, where, fstype string) (string, error) {
+	daemonReloadLock.Lock()
+	defer daemonReloadLock.Unlock()
+
+	fstype, options, err := mountOptions(what, fstype)
+	if err != nil {
+		return "", err
+	}
+	mountUnitName, err := writeMountUnitFile(snapName, revision, what, where, fstype, options)
 	if err != nil {
 		return "", err
 	}
@@ -120,7 +120,7 @@ func (s *SystemdTestSuite) myRun(args ...string) (out []byte, err error) {
 	return out, err
 }
 
-func (s *SystemdTestSuite) myJctl(svcs []string, n int, follow bool) (io.ReadCloser, error) {
+func (s *SystemdTestSuite) myJctl(svcs []string, n int, follow bool, afterCursor string) (io.ReadCloser, error) {
 	var err error
 	var out []byte
 
@@ -202,10 +202,13 @@ UnitFileState=disabled
 Id=some.timer
 ActiveState=active
 UnitFileState=enabled
+SubState=waiting
+NextElapseUSecRealtime=Mon 2020-11-02 12:15:00 UTC
 
 Id=other.socket
 ActiveState=active
 UnitFileState=disabled
+SubState=listening
 `[1:]),
 	}
 	s.errors = []error{nil}
@@ -228,22 +231,95 @@ UnitFileState=disabled
 			Active:   false,
 			Enabled:  false,
 		}, {
-			UnitName: "some.timer",
-			Active:   true,
-			Enabled:  true,
+			UnitName:    "some.timer",
+			Active:      true,
+			Enabled:     true,
+			NextTrigger: time.Date(2020, 11, 2, 12, 15, 0, 0, time.UTC),
 		}, {
-			UnitName: "other.socket",
-			Active:   true,
-			Enabled:  false,
+			UnitName:  "other.socket",
+			Active:    true,
+			Enabled:   false,
+			Listening: true,
 		},
 	})
 	c.Check(s.rep.msgs, IsNil)
 	c.Assert(s.argses, DeepEquals, [][]string{
 		{"show", "--property=Id,ActiveState,UnitFileState,Type", "foo.service", "bar.service", "baz.service"},
-		{"show", "--property=Id,ActiveState,UnitFileState", "some.timer", "other.socket"},
+		{"show", "--property=Id,ActiveState,UnitFileState,SubState,NextElapseUSecRealtime", "some.timer", "other.socket"},
+	})
+}
+
+func (s *SystemdTestSuite) TestStatusTimerNotScheduled(c *C) {
+	s.outs = [][]byte{
+		[]byte(`
+Id=some.timer
+ActiveState=inactive
+UnitFileState=disabled
+SubState=dead
+NextElapseUSecRealtime=
+`[1:]),
+	}
+	s.errors = []error{nil}
+	out, err := New("", SystemMode, s.rep).Status("some.timer")
+	c.Assert(err, IsNil)
+	c.Check(out, DeepEquals, []*UnitStatus{
+		{
+			UnitName: "some.timer",
+		},
+	})
+}
+
+func (s *SystemdTestSuite) TestResourceUsage(c *C) {
+	s.outs = [][]byte{
+		[]byte(`
+Id=foo.service
+MemoryCurrent=1048576
+CPUUsageNSec=1500000000
+
+Id=bar.service
+MemoryCurrent=[not set]
+CPUUsageNSec=18446744073709551615
+`[1:]),
+	}
+	s.errors = []error{nil}
+	out, err := New("", SystemMode, s.rep).ResourceUsage("foo.service", "bar.service")
+	c.Assert(err, IsNil)
+	c.Check(out, DeepEquals, []*UnitResourceUsage{
+		{
+			UnitName: "foo.service",
+			Memory:   1048576,
+			CPUTime:  1500 * time.Millisecond,
+		}, {
+			UnitName: "bar.service",
+			Memory:   -1,
+			CPUTime:  -1,
+		},
+	})
+	c.Check(s.argses, DeepEquals, [][]string{
+		{"show", "--property=Id,MemoryCurrent,CPUUsageNSec", "foo.service", "bar.service"},
 	})
 }
 
+func (s *SystemdTestSuite) TestResourceUsageErrors(c *C) {
+	for _, t := range []struct {
+		out string
+		err string
+	}{
+		{"Id=foo.service\nMemoryCurrent=1\n", `cannot get unit "foo.service" resource usage: missing fields in ‘systemctl show’ output`},
+		{"Id=bar.service\nMemoryCurrent=1\nCPUUsageNSec=1\n", `cannot get unit resource usage: queried usage of "foo.service" but got usage of "bar.service"`},
+		{"Id=foo.service\nMemoryCurrent=lots\nCPUUsageNSec=1\n", `cannot get unit resource usage: invalid value of field "MemoryCurrent" in ‘systemctl show’ output: "lots"`},
+		{"Id=foo.service\nPotatoes=1\nCPUUsageNSec=1\n", `cannot get unit resource usage: unexpected field "Potatoes" in ‘systemctl show’ output`},
+		{"Id=foo.service\nMemoryCurrent=1\nCPUUsageNSec=1\n\nId=foo.service\nMemoryCurrent=1\nCPUUsageNSec=1\n", `cannot get unit resource usage: expected 1 results, got 2`},
+	} {
+		s.i = 0
+		s.outs = [][]byte{[]byte(t.out)}
+		s.errors = []error{nil}
+		out, err := New("", SystemMode, s.rep).ResourceUsage("foo.service")
+		c.Check(err, ErrorMatches, t.err)
+		c.Check(out, IsNil)
+	}
+}
+
 func (s *SystemdTestSuite) TestStatusBadNumberOfValues(c *C) {
 	s.outs = [][]byte{
 		[]byte(`
@@ -438,7 +514,7 @@ func (s *SystemdTestSuite) TestIsTimeout(c *C) {
 func (s *SystemdTestSuite) TestLogErrJctl(c *C) {
 	s.jerrs = []error{&Timeout{}}
 
-	reader, err := New("", SystemMode, s.rep).LogReader([]string{"foo"}, 24, false)
+	reader, err := New("", SystemMode, s.rep).LogReader([]string{"foo"}, 24, false, "")
 	c.Check(err, NotNil)
 	c.Check(reader, IsNil)
 	c.Check(s.jns, DeepEquals, []string{"24"})
@@ -453,7 +529,7 @@ func (s *SystemdTestSuite) TestLogs(c *C) {
 `
 	s.jouts = [][]byte{[]byte(expected)}
 
-	reader, err := New("", SystemMode, s.rep).LogReader([]string{"foo"}, 24, false)
+	reader, err := New("", SystemMode, s.rep).LogReader([]string{"foo"}, 24, false, "")
 	c.Check(err, IsNil)
 	logs, err := ioutil.ReadAll(reader)
 	c.Assert(err, IsNil)
@@ -473,6 +549,13 @@ func (s *SystemdTestSuite) TestLogPID(c *C) {
 	c.Check(Log{"_PID": "42", "SYSLOG_PID": "99"}.PID(), Equals, "42")
 }
 
+func (s *SystemdTestSuite) TestLogUnitAndCursor(c *C) {
+	c.Check(Log{}.Unit(), Equals, "-")
+	c.Check(Log{"_SYSTEMD_UNIT": "snap.foo.svc.service"}.Unit(), Equals, "snap.foo.svc.service")
+	c.Check(Log{}.Cursor(), Equals, "")
+	c.Check(Log{"__CURSOR": "s=abc;i=42"}.Cursor(), Equals, "s=abc;i=42")
+}
+
 func (s *SystemdTestSuite) TestTime(c *C) {
 	t, err := Log{}.Time()
 	c.Check(t.IsZero(), Equals, true)
@@ -690,15 +773,18 @@ func (s *SystemdTestSuite) TestJctl(c *C) {
 		return nil, nil
 	})
 
-	_, err = Jctl([]string{"foo", "bar"}, 10, false)
+	_, err = Jctl([]string{"foo", "bar"}, 10, false, "")
 	c.Assert(err, IsNil)
 	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-n", "10", "-u", "foo", "-u", "bar"})
-	_, err = Jctl([]string{"foo", "bar", "baz"}, 99, true)
+	_, err = Jctl([]string{"foo", "bar", "baz"}, 99, true, "")
 	c.Assert(err, IsNil)
 	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-n", "99", "-f", "-u", "foo", "-u", "bar", "-u", "baz"})
-	_, err = Jctl([]string{"foo", "bar"}, -1, false)
+	_, err = Jctl([]string{"foo", "bar"}, -1, false, "")
 	c.Assert(err, IsNil)
 	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "--no-tail", "-u", "foo", "-u", "bar"})
+	_, err = Jctl([]string{"foo", "bar"}, 10, true, "s=abc;i=42")
+	c.Assert(err, IsNil)
+	c.Check(args, DeepEquals, []string{"-o", "json", "--no-pager", "-n", "10", "-f", "--after-cursor=s=abc;i=42", "-u", "foo", "-u", "bar"})
 }
 
 func (s *SystemdTestSuite) TestIsActiveIsInactive(c *C) {
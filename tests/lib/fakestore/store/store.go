@@ -28,7 +28,9 @@ import (
 	"net"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/tylerb/graceful.v1"
@@ -65,6 +67,21 @@ type Store struct {
 	fallback       *store.Store
 
 	srv *graceful.Server
+
+	mu       sync.Mutex
+	faults   map[string][]*Fault
+	scripted map[string]http.HandlerFunc
+	requests map[string]int
+}
+
+// Fault describes a failure the store responds with.
+type Fault struct {
+	Status      int
+	ContentType string
+	Body        string
+	// Times is the number of consecutive requests that fail, 0
+	// meaning one.
+	Times int
 }
 
 // NewStore creates a new store server serving snaps from the given top directory and assertions from topDir/asserts. If assertFallback is true missing assertions are looked up in the main online store.
@@ -91,20 +108,105 @@ func NewStore(topDir, addr string, assertFallback bool) *Store {
 				Handler: mux,
 			},
 		},
+
+		faults:   make(map[string][]*Fault),
+		scripted: make(map[string]http.HandlerFunc),
+		requests: make(map[string]int),
 	}
 
-	mux.HandleFunc("/", rootEndpoint)
-	mux.HandleFunc("/api/v1/snaps/search", store.searchEndpoint)
-	mux.HandleFunc("/api/v1/snaps/details/", store.detailsEndpoint)
-	mux.HandleFunc("/api/v1/snaps/metadata", store.bulkEndpoint)
-	mux.Handle("/download/", http.StripPrefix("/download/", http.FileServer(http.Dir(topDir))))
-	mux.HandleFunc("/api/v1/snaps/assertions/", store.assertionsEndpoint)
+	handle := func(path string, h http.Handler) {
+		mux.Handle(path, store.handler(path, h))
+	}
+	handle("/", http.HandlerFunc(rootEndpoint))
+	handle(SearchEndpoint, http.HandlerFunc(store.searchEndpoint))
+	handle(DetailsEndpoint, http.HandlerFunc(store.detailsEndpoint))
+	handle(BulkEndpoint, http.HandlerFunc(store.bulkEndpoint))
+	handle(DownloadEndpoint, http.StripPrefix(DownloadEndpoint, http.FileServer(http.Dir(topDir))))
+	handle(AssertionsEndpoint, http.HandlerFunc(store.assertionsEndpoint))
 	// v2
-	mux.HandleFunc("/v2/snaps/refresh", store.snapActionEndpoint)
+	handle(SnapActionEndpoint, http.HandlerFunc(store.snapActionEndpoint))
 
 	return store
 }
 
+// Endpoints of the store, as can be given to InjectFault, Script
+// and Requests.
+const (
+	SearchEndpoint     = "/api/v1/snaps/search"
+	DetailsEndpoint    = "/api/v1/snaps/details/"
+	BulkEndpoint       = "/api/v1/snaps/metadata"
+	DownloadEndpoint   = "/download/"
+	AssertionsEndpoint = "/api/v1/snaps/assertions/"
+	SnapActionEndpoint = "/v2/snaps/refresh"
+)
+
+// InjectFault makes the next requests to the given endpoint fail as
+// described by fault. Faults are consumed in the order they were
+// injected.
+func (s *Store) InjectFault(endpoint string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = append(s.faults[endpoint], &fault)
+}
+
+// Script makes the given handler serve the requests to the given
+// endpoint instead of the store. A nil handler restores the default
+// behavior.
+func (s *Store) Script(endpoint string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.scripted, endpoint)
+		return
+	}
+	s.scripted[endpoint] = h
+}
+
+// Requests returns the number of requests received for the given
+// endpoint, including the failed ones.
+func (s *Store) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+func (s *Store) handler(endpoint string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[endpoint]++
+		fault := s.nextFault(endpoint)
+		scripted := s.scripted[endpoint]
+		s.mu.Unlock()
+
+		switch {
+		case fault != nil:
+			if fault.ContentType != "" {
+				w.Header().Set("Content-Type", fault.ContentType)
+			}
+			w.WriteHeader(fault.Status)
+			w.Write([]byte(fault.Body))
+		case scripted != nil:
+			scripted(w, r)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *Store) nextFault(endpoint string) *Fault {
+	faults := s.faults[endpoint]
+	if len(faults) == 0 {
+		return nil
+	}
+	fault := faults[0]
+	if fault.Times > 1 {
+		fault.Times--
+	} else {
+		s.faults[endpoint] = faults[1:]
+	}
+	return fault
+}
+
 // URL returns the base-url that the store is listening on
 func (s *Store) URL() string {
 	return s.url
@@ -121,10 +223,16 @@ func (s *Store) Start() error {
 		return err
 	}
 
-	go s.srv.Serve(l)
+	s.Serve(l)
 	return nil
 }
 
+// Serve serves the store on the given listener, which must be
+// listening on the address the store was created with.
+func (s *Store) Serve(l net.Listener) {
+	go s.srv.Serve(l)
+}
+
 // Stop stops the server
 func (s *Store) Stop() error {
 	timeoutTime := 2000 * time.Millisecond
@@ -252,13 +360,79 @@ type detailsReplyJSON struct {
 	Type            string   `json:"type"`
 }
 
+type searchReplyJSON struct {
+	Payload searchPayloadJSON `json:"_embedded"`
+}
+
 func (s *Store) searchEndpoint(w http.ResponseWriter, req *http.Request) {
-	w.WriteHeader(501)
-	fmt.Fprintf(w, "search not implemented")
+	q := req.URL.Query()
+	name := q.Get("name")
+	term := q.Get("q")
+
+	bs, err := s.collectAssertions()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error collecting assertions: %v", err), 500)
+		return
+	}
+	snaps, err := s.collectSnaps()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error collecting snaps: %v", err), 500)
+		return
+	}
+
+	names := make([]string, 0, len(snaps))
+	for snapName := range snaps {
+		if name != "" && snapName != name {
+			continue
+		}
+		if term != "" && !strings.Contains(snapName, term) {
+			continue
+		}
+		names = append(names, snapName)
+	}
+	sort.Strings(names)
+
+	replyData := searchReplyJSON{
+		Payload: searchPayloadJSON{
+			Packages: []detailsReplyJSON{},
+		},
+	}
+	for _, snapName := range names {
+		fn := snaps[snapName]
+		essInfo, err := snapEssentialInfo(w, fn, "", bs)
+		if essInfo == nil {
+			if err != errInfo {
+				panic(err)
+			}
+			return
+		}
+		replyData.Payload.Packages = append(replyData.Payload.Packages, detailsReplyJSON{
+			Architectures:   []string{"all"},
+			SnapID:          essInfo.SnapID,
+			PackageName:     essInfo.Name,
+			Developer:       essInfo.DevelName,
+			DeveloperID:     essInfo.DeveloperID,
+			AnonDownloadURL: fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
+			DownloadURL:     fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
+			Version:         essInfo.Version,
+			Revision:        essInfo.Revision,
+			DownloadDigest:  hexify(essInfo.Digest),
+			Confinement:     essInfo.Confinement,
+			Type:            essInfo.Type,
+		})
+	}
+
+	out, err := json.MarshalIndent(replyData, "", "    ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot marshal: %v: %v", replyData, err), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/hal+json")
+	w.Write(out)
 }
 
 func (s *Store) detailsEndpoint(w http.ResponseWriter, req *http.Request) {
-	pkg := strings.TrimPrefix(req.URL.Path, "/api/v1/snaps/details/")
+	pkg := strings.TrimPrefix(req.URL.Path, DetailsEndpoint)
 	if pkg == req.URL.Path {
 		panic("how?")
 	}
@@ -650,7 +824,7 @@ func (s *Store) retrieveAssertion(bs asserts.Backstore, assertType *asserts.Asse
 }
 
 func (s *Store) assertionsEndpoint(w http.ResponseWriter, req *http.Request) {
-	assertPath := strings.TrimPrefix(req.URL.Path, "/api/v1/snaps/assertions/")
+	assertPath := strings.TrimPrefix(req.URL.Path, AssertionsEndpoint)
 
 	bs, err := s.collectAssertions()
 	if err != nil {
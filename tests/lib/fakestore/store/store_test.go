@@ -22,6 +22,7 @@ package store
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -104,15 +105,100 @@ func (s *storeTestSuite) TestTrivialGetWorks(c *C) {
 }
 
 func (s *storeTestSuite) TestSearchEndpoint(c *C) {
-	resp, err := s.StoreGet("/api/v1/snaps/search")
+	snapFn := s.makeTestSnap(c, "name: foo\nversion: 1")
+	s.makeTestSnap(c, "name: bar\nversion: 2")
+
+	resp, err := s.StoreGet("/api/v1/snaps/search?q=fo")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Check(resp.Header.Get("Content-Type"), Equals, "application/hal+json")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&body), IsNil)
+	sha3_384, _ := getSha(snapFn)
+	c.Check(body, DeepEquals, map[string]interface{}{
+		"_embedded": map[string]interface{}{
+			"clickindex:package": []interface{}{
+				map[string]interface{}{
+					"architecture":      []interface{}{"all"},
+					"snap_id":           "",
+					"package_name":      "foo",
+					"origin":            "canonical",
+					"developer_id":      "canonical",
+					"anon_download_url": s.store.URL() + "/download/foo_1_all.snap",
+					"download_url":      s.store.URL() + "/download/foo_1_all.snap",
+					"version":           "1",
+					"revision":          float64(424242),
+					"download_sha3_384": sha3_384,
+					"confinement":       "strict",
+					"type":              "app",
+				},
+			},
+		},
+	})
+}
+
+func (s *storeTestSuite) TestSearchEndpointNoMatch(c *C) {
+	s.makeTestSnap(c, "name: foo\nversion: 1")
+
+	resp, err := s.StoreGet("/api/v1/snaps/search?name=bar")
 	c.Assert(err, IsNil)
 	defer resp.Body.Close()
 
-	c.Assert(resp.StatusCode, Equals, 501)
+	c.Assert(resp.StatusCode, Equals, 200)
 	body, err := ioutil.ReadAll(resp.Body)
 	c.Assert(err, IsNil)
-	c.Assert(string(body), Equals, "search not implemented")
+	c.Check(string(body), Equals, "{\n    \"_embedded\": {\n        \"clickindex:package\": []\n    }\n}")
+}
 
+func (s *storeTestSuite) TestInjectFault(c *C) {
+	s.store.InjectFault(SearchEndpoint, Fault{
+		Status:      500,
+		ContentType: "application/json",
+		Body:        `{"error": "boom"}`,
+		Times:       2,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := s.StoreGet("/api/v1/snaps/search")
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Check(resp.StatusCode, Equals, 500)
+		c.Check(resp.Header.Get("Content-Type"), Equals, "application/json")
+		c.Check(string(body), Equals, `{"error": "boom"}`)
+	}
+
+	// back to normal
+	resp, err := s.StoreGet("/api/v1/snaps/search")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+	c.Check(s.store.Requests(SearchEndpoint), Equals, 3)
+}
+
+func (s *storeTestSuite) TestScript(c *C) {
+	s.store.Script(AssertionsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(418)
+		fmt.Fprintf(w, "scripted %s", r.URL.Path)
+	})
+
+	resp, err := s.StoreGet("/api/v1/snaps/assertions/account/testrootorg")
+	c.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Check(resp.StatusCode, Equals, 418)
+	c.Check(string(body), Equals, "scripted /api/v1/snaps/assertions/account/testrootorg")
+
+	s.store.Script(AssertionsEndpoint, nil)
+	resp, err = s.StoreGet("/api/v1/snaps/assertions/account/testrootorg")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+	c.Check(s.store.Requests(AssertionsEndpoint), Equals, 2)
 }
 
 func (s *storeTestSuite) TestDetailsEndpointWithAssertions(c *C) {
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package fakestore provides an in-process fake of the store HTTP API,
+// serving search, install/refresh, download and assertion requests from
+// the snap files and assertions added by the test, with support for
+// scripting responses and injecting faults.
+//
+// It runs the fake store the spread tests use, see
+// tests/lib/fakestore/store, on a local port.
+package fakestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/tests/lib/fakestore/store"
+)
+
+// Endpoints of the fake store, as can be given to InjectFault, Script
+// and Requests.
+const (
+	SearchEndpoint     = store.SearchEndpoint
+	SnapActionEndpoint = store.SnapActionEndpoint
+	DownloadEndpoint   = store.DownloadEndpoint
+	AssertionsEndpoint = store.AssertionsEndpoint
+)
+
+// Fault describes a failure the fake store responds with.
+type Fault = store.Fault
+
+// Store is an in-process fake of the store HTTP API.
+type Store struct {
+	*store.Store
+
+	topDir string
+}
+
+// New creates and starts a fake store, keeping the snaps and
+// assertions it serves in topDir. It needs to be stopped with Close.
+func New(topDir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(topDir, "asserts"), 0755); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	sto := store.NewStore(topDir, l.Addr().String(), false)
+	sto.Serve(l)
+
+	return &Store{Store: sto, topDir: topDir}, nil
+}
+
+// Close stops the fake store.
+func (s *Store) Close() error {
+	return s.Stop()
+}
+
+// AddSnap makes the given snap file available from the store. Unless
+// snap-declaration and snap-revision assertions for it are added as
+// well, the snap has no snap-id and a made up revision.
+func (s *Store) AddSnap(snapPath string) error {
+	if !strings.HasSuffix(snapPath, ".snap") {
+		return fmt.Errorf("cannot add snap %q: not a .snap file", snapPath)
+	}
+	return osutil.CopyFile(snapPath, filepath.Join(s.topDir, filepath.Base(snapPath)), 0)
+}
+
+// AddAssertion makes the given assertion available from the store.
+func (s *Store) AddAssertion(a asserts.Assertion) error {
+	name := strings.Join(append([]string{a.Type().Name}, a.Ref().PrimaryKey...), "_")
+	name = strings.Replace(name, "/", "-", -1)
+	return ioutil.WriteFile(filepath.Join(s.topDir, "asserts", name), asserts.Encode(a), 0644)
+}
@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fakestore_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/testutil/fakestore"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type fakeStoreSuite struct {
+	fake *fakestore.Store
+	sto  *store.Store
+
+	snapPath string
+
+	restoreSanitize func()
+}
+
+var _ = Suite(&fakeStoreSuite{})
+
+func (s *fakeStoreSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	s.restoreSanitize = snap.MockSanitizePlugsSlots(func(snapInfo *snap.Info) {})
+
+	fake, err := fakestore.New(c.MkDir())
+	c.Assert(err, IsNil)
+	s.fake = fake
+
+	s.snapPath = snaptest.MakeTestSnapWithFiles(c, "name: hello\nversion: 1.0", nil)
+	c.Assert(s.fake.AddSnap(s.snapPath), IsNil)
+	s.addSnapAssertions(c, s.snapPath, "hello", "hello-id", 7)
+
+	u, err := url.Parse(s.fake.URL())
+	c.Assert(err, IsNil)
+	cfg := store.DefaultConfig()
+	cfg.StoreBaseURL = u
+	cfg.AssertionsBaseURL = u
+	s.sto = store.New(cfg, nil)
+}
+
+func (s *fakeStoreSuite) TearDownTest(c *C) {
+	c.Check(s.fake.Close(), IsNil)
+	s.restoreSanitize()
+	dirs.SetRootDir("")
+}
+
+func (s *fakeStoreSuite) addSnapAssertions(c *C, snapPath, name, snapID string, revision int) {
+	storeStack := assertstest.NewStoreStack("canonical", nil)
+	acct := assertstest.NewAccount(storeStack, "developer1", map[string]interface{}{
+		"account-id": "developer1-id",
+	}, "")
+	c.Assert(s.fake.AddAssertion(acct), IsNil)
+
+	decl, err := storeStack.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      snapID,
+		"snap-name":    name,
+		"publisher-id": "developer1-id",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	c.Assert(s.fake.AddAssertion(decl), IsNil)
+
+	digest, size, err := asserts.SnapFileSHA3_384(snapPath)
+	c.Assert(err, IsNil)
+	rev, err := storeStack.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": digest,
+		"snap-id":       snapID,
+		"snap-size":     fmt.Sprintf("%d", size),
+		"snap-revision": fmt.Sprintf("%d", revision),
+		"developer-id":  "developer1-id",
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	c.Assert(s.fake.AddAssertion(rev), IsNil)
+}
+
+func (s *fakeStoreSuite) TestFind(c *C) {
+	snaps, err := s.sto.Find(context.TODO(), &store.Search{Query: "hello"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 1)
+	c.Check(snaps[0].SnapName(), Equals, "hello")
+	c.Check(snaps[0].SnapID, Equals, "hello-id")
+	c.Check(snaps[0].Revision, Equals, snap.R(7))
+	c.Check(snaps[0].Version, Equals, "1.0")
+
+	snaps, err = s.sto.Find(context.TODO(), &store.Search{Query: "nothing"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(snaps, HasLen, 0)
+	c.Check(s.fake.Requests(fakestore.SearchEndpoint), Equals, 2)
+}
+
+func (s *fakeStoreSuite) TestInstallAndDownload(c *C) {
+	results, err := s.sto.SnapAction(context.TODO(), nil, []*store.SnapAction{{
+		Action:       "install",
+		InstanceName: "hello",
+	}}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	info := results[0]
+	c.Check(info.SnapName(), Equals, "hello")
+	c.Check(info.SnapID, Equals, "hello-id")
+	c.Check(info.Revision, Equals, snap.R(7))
+
+	target := filepath.Join(c.MkDir(), "hello_7.snap")
+	err = s.sto.Download(context.TODO(), "hello", target, &info.DownloadInfo, progress.Null, nil, nil)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	expected, err := ioutil.ReadFile(s.snapPath)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, expected)
+}
+
+func (s *fakeStoreSuite) TestRefresh(c *C) {
+	results, err := s.sto.SnapAction(context.TODO(), []*store.CurrentSnap{{
+		InstanceName:    "hello",
+		SnapID:          "hello-id",
+		Revision:        snap.R(1),
+		TrackingChannel: "stable",
+	}}, []*store.SnapAction{{
+		Action:       "refresh",
+		InstanceName: "hello",
+		SnapID:       "hello-id",
+	}}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Revision, Equals, snap.R(7))
+}
+
+func (s *fakeStoreSuite) TestAddSnapNotSnapFile(c *C) {
+	err := s.fake.AddSnap("/some/file")
+	c.Check(err, ErrorMatches, `cannot add snap "/some/file": not a .snap file`)
+}
+
+func (s *fakeStoreSuite) TestAssertion(c *C) {
+	a, err := s.sto.Assertion(asserts.AccountType, []string{"developer1-id"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a.(*asserts.Account).Username(), Equals, "developer1")
+
+	_, err = s.sto.Assertion(asserts.AccountType, []string{"missing"}, nil)
+	c.Check(asserts.IsNotFound(err), Equals, true)
+}
+
+func (s *fakeStoreSuite) TestInjectFault(c *C) {
+	s.fake.InjectFault(fakestore.SearchEndpoint, fakestore.Fault{
+		Status: 418,
+		Body:   "I'm a teapot",
+		Times:  2,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.fake.URL() + "/api/v1/snaps/search?q=hello")
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Check(resp.StatusCode, Equals, 418)
+		c.Check(string(body), Equals, "I'm a teapot")
+	}
+
+	// back to normal
+	snaps, err := s.sto.Find(context.TODO(), &store.Search{Query: "hello"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(snaps, HasLen, 1)
+	c.Check(s.fake.Requests(fakestore.SearchEndpoint), Equals, 3)
+}
+
+func (s *fakeStoreSuite) TestScript(c *C) {
+	s.fake.Script(fakestore.SearchEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/hal+json")
+		w.Write([]byte(`{"_embedded": {"clickindex:package": [{"package_name": "scripted", "snap_id": "scripted-id", "revision": 3}]}}`))
+	})
+
+	snaps, err := s.sto.Find(context.TODO(), &store.Search{Query: "hello"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 1)
+	c.Check(snaps[0].SnapName(), Equals, "scripted")
+	c.Check(snaps[0].Revision, Equals, snap.R(3))
+
+	s.fake.Script(fakestore.SearchEndpoint, nil)
+	snaps, err = s.sto.Find(context.TODO(), &store.Search{Query: "hello"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 1)
+	c.Check(snaps[0].SnapName(), Equals, "hello")
+}
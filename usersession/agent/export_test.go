@@ -19,6 +19,18 @@
 
 package agent
 
+import (
+	"github.com/snapcore/snapd/usersession/portalinfo"
+)
+
 var (
-	SessionInfoCmd = sessionInfoCmd
+	SessionInfoCmd  = sessionInfoCmd
+	PortalInfoCmd   = portalInfoCmd
+	DesktopEntryCmd = desktopEntryCmd
 )
+
+func MockSnapdClient(cli portalinfo.SnapdClient) (restore func()) {
+	old := snapdClient
+	snapdClient = func() portalinfo.SnapdClient { return cli }
+	return func() { snapdClient = old }
+}
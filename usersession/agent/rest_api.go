@@ -21,11 +21,18 @@ package agent
 
 import (
 	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/usersession/portalinfo"
 )
 
 var restApi = []*Command{
 	rootCmd,
 	sessionInfoCmd,
+	portalInfoCmd,
+	desktopEntryCmd,
 }
 
 var (
@@ -38,11 +45,52 @@ var (
 		Path: "/v1/session-info",
 		GET:  sessionInfo,
 	}
+
+	portalInfoCmd = &Command{
+		Path: "/v1/portal-info",
+		GET:  portalInfo,
+	}
+
+	desktopEntryCmd = &Command{
+		Path: "/v1/desktop-entry",
+		GET:  desktopEntry,
+	}
 )
 
+var snapdClient = func() portalinfo.SnapdClient {
+	return client.New(nil)
+}
+
 func sessionInfo(c *Command, r *http.Request) Response {
 	m := map[string]interface{}{
 		"version": c.s.Version,
 	}
 	return SyncResponse(m)
 }
+
+func portalInfo(c *Command, r *http.Request) Response {
+	pid, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	if err != nil || pid <= 0 {
+		return BadRequest("invalid process ID %q", r.URL.Query().Get("pid"))
+	}
+	info, err := portalinfo.ForPid(snapdClient(), pid)
+	if err != nil {
+		return NotFound("%v", err)
+	}
+	return SyncResponse(info)
+}
+
+func desktopEntry(c *Command, r *http.Request) Response {
+	desktopFileID := r.URL.Query().Get("desktop-file")
+	if desktopFileID == "" {
+		return BadRequest("desktop file ID not specified")
+	}
+	entry, err := portalinfo.ResolveDesktopFile(desktopFileID)
+	if os.IsNotExist(err) {
+		return NotFound("no desktop file %q", desktopFileID)
+	}
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+	return SyncResponse(entry)
+}
@@ -22,11 +22,15 @@ package agent_test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/usersession/agent"
 )
@@ -74,3 +78,94 @@ func (s *restSuite) TestSessionInfo(c *C) {
 		"version": "42b1",
 	})
 }
+
+type fakeSnapdClient struct{}
+
+func (fakeSnapdClient) Snap(name string) (*client.Snap, *client.ResultInfo, error) {
+	if name != "hello" {
+		return nil, nil, fmt.Errorf("snap not installed")
+	}
+	return &client.Snap{
+		Name: "hello",
+		Apps: []client.AppInfo{{Snap: "hello", Name: "hello", DesktopFile: "/var/lib/snapd/desktop/applications/hello_hello.desktop"}},
+	}, nil, nil
+}
+
+func (fakeSnapdClient) Connections(opts *client.ConnectionOptions) (client.Connections, error) {
+	return client.Connections{}, nil
+}
+
+func (s *restSuite) TestPortalInfo(c *C) {
+	c.Check(agent.PortalInfoCmd.Path, Equals, "/v1/portal-info")
+	c.Check(agent.PortalInfoCmd.POST, IsNil)
+
+	restore := agent.MockSnapdClient(fakeSnapdClient{})
+	defer restore()
+
+	procDir := filepath.Join(dirs.GlobalRootDir, "proc/333")
+	c.Assert(os.MkdirAll(procDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(procDir, "cgroup"), []byte("7:freezer:/snap.hello\n"), 0644), IsNil)
+
+	req, err := http.NewRequest("GET", "/v1/portal-info?pid=333", nil)
+	c.Assert(err, IsNil)
+	rec := httptest.NewRecorder()
+	agent.PortalInfoCmd.GET(agent.PortalInfoCmd, req).ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 200)
+
+	var rsp resp
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), IsNil)
+	c.Check(rsp.Type, Equals, agent.ResponseTypeSync)
+	c.Check(rsp.Result, DeepEquals, map[string]interface{}{
+		"instance-name":      "hello",
+		"app-name":           "hello",
+		"desktop-file":       "hello_hello.desktop",
+		"has-network-status": false,
+	})
+
+	for query, code := range map[string]int{
+		"":        400,
+		"pid=foo": 400,
+		"pid=444": 404,
+	} {
+		req, err := http.NewRequest("GET", "/v1/portal-info?"+query, nil)
+		c.Assert(err, IsNil)
+		rec := httptest.NewRecorder()
+		agent.PortalInfoCmd.GET(agent.PortalInfoCmd, req).ServeHTTP(rec, req)
+		c.Check(rec.Code, Equals, code, Commentf(query))
+	}
+}
+
+func (s *restSuite) TestDesktopEntry(c *C) {
+	c.Check(agent.DesktopEntryCmd.Path, Equals, "/v1/desktop-entry")
+	c.Check(agent.DesktopEntryCmd.POST, IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapDesktopFilesDir, 0755), IsNil)
+	desktopFile := filepath.Join(dirs.SnapDesktopFilesDir, "hello_hello.desktop")
+	c.Assert(ioutil.WriteFile(desktopFile, nil, 0644), IsNil)
+
+	req, err := http.NewRequest("GET", "/v1/desktop-entry?desktop-file=hello_hello.desktop", nil)
+	c.Assert(err, IsNil)
+	rec := httptest.NewRecorder()
+	agent.DesktopEntryCmd.GET(agent.DesktopEntryCmd, req).ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 200)
+
+	var rsp resp
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), IsNil)
+	c.Check(rsp.Result, DeepEquals, map[string]interface{}{
+		"path":          desktopFile,
+		"instance-name": "hello",
+		"app-name":      "hello",
+	})
+
+	for query, code := range map[string]int{
+		"":                                 400,
+		"desktop-file=foo.desktop":         400,
+		"desktop-file=hello_other.desktop": 404,
+	} {
+		req, err := http.NewRequest("GET", "/v1/desktop-entry?"+query, nil)
+		c.Assert(err, IsNil)
+		rec := httptest.NewRecorder()
+		agent.DesktopEntryCmd.GET(agent.DesktopEntryCmd, req).ServeHTTP(rec, req)
+		c.Check(rec.Code, Equals, code, Commentf(query))
+	}
+}
@@ -0,0 +1,188 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package portalinfo provides the information about snap confined
+// processes and snap desktop files that xdg-desktop-portal backends
+// need, so that they do not have to look into /var/lib/snapd
+// themselves.
+package portalinfo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/sandbox/cgroup"
+	"github.com/snapcore/snapd/snap"
+)
+
+var snapNameFromPid = cgroup.SnapNameFromPid
+
+// SnapdClient is the part of the snapd client used to look up the
+// snap of a process.
+type SnapdClient interface {
+	Snap(name string) (*client.Snap, *client.ResultInfo, error)
+	Connections(opts *client.ConnectionOptions) (client.Connections, error)
+}
+
+// Info describes the snap a process belongs to.
+type Info struct {
+	InstanceName     string `json:"instance-name"`
+	AppName          string `json:"app-name,omitempty"`
+	DesktopFile      string `json:"desktop-file,omitempty"`
+	HasNetworkStatus bool   `json:"has-network-status"`
+}
+
+// ForPid returns the information about the snap the given process
+// belongs to.
+func ForPid(cli SnapdClient, pid int) (*Info, error) {
+	snapName, err := snapNameFromPid(pid)
+	if err != nil {
+		return nil, err
+	}
+	snapInfo, _, err := cli.Snap(snapName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve info for snap %q: %v", snapName, err)
+	}
+
+	// try to identify the app from the AppArmor label of the process
+	var app *client.AppInfo
+	if labelSnap, appName, err := appFromPid(pid); err == nil && labelSnap == snapInfo.Name {
+		for i := range snapInfo.Apps {
+			if snapInfo.Apps[i].Name == appName {
+				app = &snapInfo.Apps[i]
+				break
+			}
+		}
+	}
+	// as a fallback pick an app with a desktop file, favouring the one
+	// named like the snap
+	if app == nil {
+		for i := range snapInfo.Apps {
+			if snapInfo.Apps[i].DesktopFile == "" {
+				continue
+			}
+			if app == nil || snapInfo.Apps[i].Name == snapInfo.Name {
+				app = &snapInfo.Apps[i]
+			}
+		}
+	}
+
+	info := &Info{InstanceName: snapInfo.Name}
+	if app != nil {
+		info.AppName = app.Name
+		if app.DesktopFile != "" {
+			info.DesktopFile = filepath.Base(app.DesktopFile)
+		}
+	}
+
+	connections, err := cli.Connections(&client.ConnectionOptions{
+		Snap:      snapInfo.Name,
+		Interface: "network-status",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get connections for snap %q: %v", snapInfo.Name, err)
+	}
+	// the filtering is done on the interface name, but the snap may
+	// also be on the slot side
+	for _, conn := range connections.Established {
+		if conn.Plug.Snap == snapInfo.Name && conn.Interface == "network-status" {
+			info.HasNetworkStatus = true
+			break
+		}
+	}
+	return info, nil
+}
+
+// KeyFile returns the information in the keyfile format understood by
+// xdg-desktop-portal.
+func (info *Info) KeyFile() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[Snap Info]\n")
+	fmt.Fprintf(&buf, "InstanceName=%s\n", info.InstanceName)
+	if info.AppName != "" {
+		fmt.Fprintf(&buf, "AppName=%s\n", info.AppName)
+	}
+	if info.DesktopFile != "" {
+		fmt.Fprintf(&buf, "DesktopFile=%s\n", info.DesktopFile)
+	}
+	fmt.Fprintf(&buf, "HasNetworkStatus=%t\n", info.HasNetworkStatus)
+	return buf.String()
+}
+
+// appFromPid returns the snap and app names from the AppArmor label of
+// the given process, which looks like "snap.<snap>.<app> (enforce)".
+func appFromPid(pid int) (snapName, appName string, err error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/proc/%d/attr/current", dirs.GlobalRootDir, pid))
+	if err != nil {
+		return "", "", err
+	}
+	label := strings.TrimSpace(string(content))
+	if i := strings.IndexByte(label, ' '); i >= 0 {
+		label = label[:i]
+	}
+	parts := strings.Split(label, ".")
+	if len(parts) != 3 || parts[0] != "snap" {
+		return "", "", fmt.Errorf("security label %q does not belong to a snap app", label)
+	}
+	return parts[1], parts[2], nil
+}
+
+// DesktopEntry describes a desktop file installed for a snap app.
+type DesktopEntry struct {
+	Path         string `json:"path"`
+	InstanceName string `json:"instance-name"`
+	AppName      string `json:"app-name"`
+}
+
+// ResolveDesktopFile returns the snap app the desktop file with the
+// given ID, as in "<snap>_<app>.desktop", was installed for.
+func ResolveDesktopFile(desktopFileID string) (*DesktopEntry, error) {
+	if desktopFileID != filepath.Base(desktopFileID) || !strings.HasSuffix(desktopFileID, ".desktop") {
+		return nil, fmt.Errorf("invalid desktop file ID %q", desktopFileID)
+	}
+	// app names cannot contain an underscore, instance names can
+	base := strings.TrimSuffix(desktopFileID, ".desktop")
+	i := strings.LastIndexByte(base, '_')
+	if i <= 0 {
+		return nil, fmt.Errorf("desktop file ID %q does not belong to a snap app", desktopFileID)
+	}
+	instanceName, appName := base[:i], base[i+1:]
+	if err := snap.ValidateInstanceName(instanceName); err != nil {
+		return nil, fmt.Errorf("desktop file ID %q does not belong to a snap app: %v", desktopFileID, err)
+	}
+	if !snap.ValidAppName(appName) {
+		return nil, fmt.Errorf("desktop file ID %q does not belong to a snap app: invalid app name %q", desktopFileID, appName)
+	}
+
+	path := filepath.Join(dirs.SnapDesktopFilesDir, desktopFileID)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &DesktopEntry{
+		Path:         path,
+		InstanceName: instanceName,
+		AppName:      appName,
+	}, nil
+}
@@ -0,0 +1,180 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package portalinfo_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/usersession/portalinfo"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type portalInfoSuite struct {
+	snaps       map[string]*client.Snap
+	connections client.Connections
+}
+
+var _ = Suite(&portalInfoSuite{})
+
+func (s *portalInfoSuite) SetUpTest(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	s.snaps = map[string]*client.Snap{
+		"hello": {
+			Name: "hello",
+			Apps: []client.AppInfo{
+				{Snap: "hello", Name: "universe"},
+				{Snap: "hello", Name: "hello", DesktopFile: "/var/lib/snapd/desktop/applications/hello_hello.desktop"},
+				{Snap: "hello", Name: "other", DesktopFile: "/var/lib/snapd/desktop/applications/hello_other.desktop"},
+			},
+		},
+	}
+	s.connections = client.Connections{}
+}
+
+func (s *portalInfoSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+}
+
+func (s *portalInfoSuite) Snap(name string) (*client.Snap, *client.ResultInfo, error) {
+	if snap, ok := s.snaps[name]; ok {
+		return snap, nil, nil
+	}
+	return nil, nil, fmt.Errorf("snap not installed")
+}
+
+func (s *portalInfoSuite) Connections(opts *client.ConnectionOptions) (client.Connections, error) {
+	return s.connections, nil
+}
+
+func (s *portalInfoSuite) mockAppArmorLabel(c *C, pid int, label string) {
+	dir := filepath.Join(dirs.GlobalRootDir, fmt.Sprintf("proc/%d/attr", pid))
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "current"), []byte(label), 0644), IsNil)
+}
+
+func (s *portalInfoSuite) TestForPid(c *C) {
+	restore := portalinfo.MockSnapNameFromPid(func(pid int) (string, error) {
+		c.Check(pid, Equals, 42)
+		return "hello", nil
+	})
+	defer restore()
+	s.mockAppArmorLabel(c, 42, "snap.hello.other (enforce)\n")
+	s.connections.Established = []client.Connection{{
+		Plug:      client.PlugRef{Snap: "hello", Name: "network-status"},
+		Slot:      client.SlotRef{Snap: "core", Name: "network-status"},
+		Interface: "network-status",
+	}}
+
+	info, err := portalinfo.ForPid(s, 42)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &portalinfo.Info{
+		InstanceName:     "hello",
+		AppName:          "other",
+		DesktopFile:      "hello_other.desktop",
+		HasNetworkStatus: true,
+	})
+	c.Check(info.KeyFile(), Equals, `[Snap Info]
+InstanceName=hello
+AppName=other
+DesktopFile=hello_other.desktop
+HasNetworkStatus=true
+`)
+}
+
+func (s *portalInfoSuite) TestForPidNoAppArmorLabel(c *C) {
+	restore := portalinfo.MockSnapNameFromPid(func(pid int) (string, error) {
+		return "hello", nil
+	})
+	defer restore()
+
+	// the app named like the snap is picked
+	info, err := portalinfo.ForPid(s, 42)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &portalinfo.Info{
+		InstanceName: "hello",
+		AppName:      "hello",
+		DesktopFile:  "hello_hello.desktop",
+	})
+	c.Check(info.KeyFile(), Equals, `[Snap Info]
+InstanceName=hello
+AppName=hello
+DesktopFile=hello_hello.desktop
+HasNetworkStatus=false
+`)
+}
+
+func (s *portalInfoSuite) TestForPidErrors(c *C) {
+	restore := portalinfo.MockSnapNameFromPid(func(pid int) (string, error) {
+		return "", fmt.Errorf("cannot find a snap for pid %v", pid)
+	})
+	defer restore()
+	_, err := portalinfo.ForPid(s, 42)
+	c.Check(err, ErrorMatches, "cannot find a snap for pid 42")
+
+	restore = portalinfo.MockSnapNameFromPid(func(pid int) (string, error) {
+		return "missing", nil
+	})
+	defer restore()
+	_, err = portalinfo.ForPid(s, 42)
+	c.Check(err, ErrorMatches, `cannot retrieve info for snap "missing": snap not installed`)
+}
+
+func (s *portalInfoSuite) TestResolveDesktopFile(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapDesktopFilesDir, 0755), IsNil)
+	for _, name := range []string{"hello_hello.desktop", "hello_foo_universe.desktop"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapDesktopFilesDir, name), nil, 0644), IsNil)
+	}
+
+	entry, err := portalinfo.ResolveDesktopFile("hello_hello.desktop")
+	c.Assert(err, IsNil)
+	c.Check(entry, DeepEquals, &portalinfo.DesktopEntry{
+		Path:         filepath.Join(dirs.SnapDesktopFilesDir, "hello_hello.desktop"),
+		InstanceName: "hello",
+		AppName:      "hello",
+	})
+
+	entry, err = portalinfo.ResolveDesktopFile("hello_foo_universe.desktop")
+	c.Assert(err, IsNil)
+	c.Check(entry.InstanceName, Equals, "hello_foo")
+	c.Check(entry.AppName, Equals, "universe")
+
+	for _, t := range []struct {
+		id  string
+		err string
+	}{
+		{"../hello_hello.desktop", `invalid desktop file ID "../hello_hello.desktop"`},
+		{"hello_hello", `invalid desktop file ID "hello_hello"`},
+		{"hello.desktop", `desktop file ID "hello.desktop" does not belong to a snap app`},
+		{"Hello_hello.desktop", `desktop file ID "Hello_hello.desktop" does not belong to a snap app: .*`},
+		{"hello_other.desktop", `stat .*/hello_other.desktop: no such file or directory`},
+	} {
+		_, err := portalinfo.ResolveDesktopFile(t.id)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.id))
+	}
+}
@@ -187,8 +187,11 @@ func updateDesktopDatabase(desktopFiles []string) error {
 	}
 
 	if _, err := exec.LookPath("update-desktop-database"); err == nil {
-		if output, err := exec.Command("update-desktop-database", dirs.SnapDesktopFilesDir).CombinedOutput(); err != nil {
-			return fmt.Errorf("cannot update-desktop-database %q: %s", output, err)
+		if _, err := osutil.RunHelper(&osutil.HelperCommand{
+			Name: "update-desktop-database",
+			Args: []string{dirs.SnapDesktopFilesDir},
+		}); err != nil {
+			return fmt.Errorf("cannot update-desktop-database: %v", err)
 		}
 		logger.Debugf("update-desktop-database successful")
 	}
@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package wrappers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/systemd"
+)
+
+// QuotaSlice describes the systemd slice enforcing the resource limits of
+// a quota group, and the services put in it.
+type QuotaSlice struct {
+	// Name is the name of the slice unit, the names of nested slices
+	// have the name of their parent slice as prefix, see systemd.slice(5)
+	Name        string
+	Description string
+
+	// MemoryLimit is the maximum memory usage in bytes, if not zero
+	MemoryLimit uint64
+	// CPUPercentage is the maximum CPU time relative to one CPU, if
+	// not zero
+	CPUPercentage int
+	// IOWeight is the relative IO weight, if not zero
+	IOWeight int
+
+	Services []*snap.AppInfo
+}
+
+// quotaDropIn is the name of the drop-in file putting a service in the
+// slice of its quota group.
+const quotaDropIn = "snap-quota.conf"
+
+func quotaDropInPath(app *snap.AppInfo) string {
+	return filepath.Join(app.ServiceFile()+".d", quotaDropIn)
+}
+
+func genQuotaSliceFile(slice *QuotaSlice) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=%s
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+`, slice.Description)
+	if slice.MemoryLimit != 0 {
+		fmt.Fprintf(&buf, "MemoryAccounting=true\nMemoryMax=%d\n", slice.MemoryLimit)
+	}
+	if slice.CPUPercentage != 0 {
+		fmt.Fprintf(&buf, "CPUAccounting=true\nCPUQuota=%d%%\n", slice.CPUPercentage)
+	}
+	if slice.IOWeight != 0 {
+		fmt.Fprintf(&buf, "IOAccounting=true\nIOWeight=%d\n", slice.IOWeight)
+	}
+	return buf.Bytes()
+}
+
+func genQuotaDropInFile(sliceName string) []byte {
+	return []byte(fmt.Sprintf(`[Service]
+# Auto-generated, DO NOT EDIT
+Slice=%s
+`, sliceName))
+}
+
+// ensureQuotaDropIn writes or removes the drop-in file of the given
+// service, it returns whether the file was modified.
+func ensureQuotaDropIn(path, sliceName string) (modified bool, err error) {
+	if sliceName == "" {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// the directory is only removed if empty
+		os.Remove(filepath.Dir(path))
+		return true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	err = osutil.EnsureFileState(path, &osutil.FileState{
+		Content: genQuotaDropInFile(sliceName),
+		Mode:    0644,
+	})
+	if err == osutil.ErrSameState {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EnsureQuotaSlices ensures that the slice units of the given quota groups
+// are the only ones on disk, and that their services, and only them, are
+// put in their slice. Services are only moved to their new slice when
+// they are restarted.
+func EnsureQuotaSlices(slices []*QuotaSlice, inter interacter) error {
+	dir := dirs.SnapServicesDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	content := make(map[string]*osutil.FileState, len(slices))
+	dropIns := make(map[string]string)
+	for _, slice := range slices {
+		content[slice.Name] = &osutil.FileState{
+			Content: genQuotaSliceFile(slice),
+			Mode:    0644,
+		}
+		for _, app := range slice.Services {
+			if !app.IsService() {
+				continue
+			}
+			dropIns[quotaDropInPath(app)] = slice.Name
+		}
+	}
+
+	changed, removed, err := osutil.EnsureDirState(dir, "snap-*.slice", content)
+	if err != nil {
+		return err
+	}
+	modified := len(changed) > 0 || len(removed) > 0
+
+	existing, err := filepath.Glob(filepath.Join(dir, "snap.*.service.d", quotaDropIn))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if _, ok := dropIns[path]; !ok {
+			dropIns[path] = ""
+		}
+	}
+	for path, sliceName := range dropIns {
+		dropInModified, err := ensureQuotaDropIn(path, sliceName)
+		if err != nil {
+			return err
+		}
+		modified = modified || dropInModified
+	}
+
+	if modified {
+		sysd := systemd.New(dirs.GlobalRootDir, systemd.SystemMode, inter)
+		if err := sysd.DaemonReload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureSnapQuotaDropIns puts the services of the given snap in the given
+// slice, or in none if the slice name is empty, removing the drop-in files
+// of services the snap no longer has. It does not reload systemd, as it is
+// meant to be called before the services of the snap are written.
+func EnsureSnapQuotaDropIns(s *snap.Info, sliceName string) error {
+	dropIns := make(map[string]string)
+	existing, err := filepath.Glob(filepath.Join(dirs.SnapServicesDir, fmt.Sprintf("snap.%s.*.service.d", s.InstanceName()), quotaDropIn))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		dropIns[path] = ""
+	}
+	for _, app := range s.Services() {
+		dropIns[quotaDropInPath(app)] = sliceName
+	}
+
+	for path, sliceName := range dropIns {
+		if _, err := ensureQuotaDropIn(path, sliceName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package wrappers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/systemd"
+	"github.com/snapcore/snapd/testutil"
+	"github.com/snapcore/snapd/wrappers"
+)
+
+type quotaTestSuite struct {
+	tempdir string
+
+	sysdLog [][]string
+
+	systemctlRestorer func()
+}
+
+var _ = Suite(&quotaTestSuite{})
+
+func (s *quotaTestSuite) SetUpTest(c *C) {
+	s.tempdir = c.MkDir()
+	s.sysdLog = nil
+	dirs.SetRootDir(s.tempdir)
+
+	s.systemctlRestorer = systemd.MockSystemctl(func(cmd ...string) ([]byte, error) {
+		s.sysdLog = append(s.sysdLog, cmd)
+		return nil, nil
+	})
+}
+
+func (s *quotaTestSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+	s.systemctlRestorer()
+}
+
+func (s *quotaTestSuite) TestEnsureQuotaSlices(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+
+	slices := []*wrappers.QuotaSlice{{
+		Name:          "snap-db.slice",
+		Description:   "Slice for snap quota group db",
+		MemoryLimit:   1024 * 1024 * 1024,
+		CPUPercentage: 150,
+	}, {
+		Name:        "snap-db-small.slice",
+		Description: "Slice for snap quota group small",
+		IOWeight:    50,
+		Services:    []*snap.AppInfo{info.Apps["svc1"], info.Apps["hello"]},
+	}}
+	err := wrappers.EnsureQuotaSlices(slices, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{{"daemon-reload"}})
+
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileEquals, `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Slice for snap quota group db
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+MemoryAccounting=true
+MemoryMax=1073741824
+CPUAccounting=true
+CPUQuota=150%
+`)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db-small.slice"), testutil.FileEquals, `[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Slice for snap quota group small
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+IOAccounting=true
+IOWeight=50
+`)
+	dropIn := filepath.Join(dirs.SnapServicesDir, "snap.hello-snap.svc1.service.d/snap-quota.conf")
+	c.Check(dropIn, testutil.FileEquals, `[Service]
+# Auto-generated, DO NOT EDIT
+Slice=snap-db-small.slice
+`)
+	// not a service
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.hello-snap.hello.service.d"), testutil.FileAbsent)
+
+	// nothing changed, no reload
+	s.sysdLog = nil
+	err = wrappers.EnsureQuotaSlices(slices, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, HasLen, 0)
+
+	// the service moves to the parent group
+	slices[0].Services = slices[1].Services
+	slices = slices[:1]
+	err = wrappers.EnsureQuotaSlices(slices, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{{"daemon-reload"}})
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db-small.slice"), testutil.FileAbsent)
+	c.Check(dropIn, testutil.FileContains, "Slice=snap-db.slice\n")
+
+	// all groups are removed
+	s.sysdLog = nil
+	err = wrappers.EnsureQuotaSlices(nil, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{{"daemon-reload"}})
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap-db.slice"), testutil.FileAbsent)
+	c.Check(filepath.Dir(dropIn), testutil.FileAbsent)
+}
+
+func (s *quotaTestSuite) TestEnsureSnapQuotaDropIns(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+
+	err := wrappers.EnsureSnapQuotaDropIns(info, "snap-db.slice")
+	c.Assert(err, IsNil)
+	dropIn := filepath.Join(dirs.SnapServicesDir, "snap.hello-snap.svc1.service.d/snap-quota.conf")
+	c.Check(dropIn, testutil.FileEquals, `[Service]
+# Auto-generated, DO NOT EDIT
+Slice=snap-db.slice
+`)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.hello-snap.hello.service.d"), testutil.FileAbsent)
+
+	// the drop-ins of services the snap no longer has are removed
+	stale := filepath.Join(dirs.SnapServicesDir, "snap.hello-snap.gone.service.d/snap-quota.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(stale), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(stale, nil, 0644), IsNil)
+	err = wrappers.EnsureSnapQuotaDropIns(info, "snap-db-small.slice")
+	c.Assert(err, IsNil)
+	c.Check(dropIn, testutil.FileContains, "Slice=snap-db-small.slice\n")
+	c.Check(filepath.Dir(stale), testutil.FileAbsent)
+
+	// without a slice the snap is taken out of its group
+	err = wrappers.EnsureSnapQuotaDropIns(info, "")
+	c.Assert(err, IsNil)
+	c.Check(filepath.Dir(dropIn), testutil.FileAbsent)
+
+	// systemd is reloaded when the services are written
+	c.Check(s.sysdLog, HasLen, 0)
+}
@@ -426,6 +426,12 @@ Restart={{.Restart}}
 {{- if .App.RestartDelay}}
 RestartSec={{.App.RestartDelay.Seconds}}
 {{- end}}
+{{- if .App.RestartLimitInterval}}
+StartLimitInterval={{.App.RestartLimitInterval.Seconds}}
+{{- end}}
+{{- if .App.RestartLimitBurst}}
+StartLimitBurst={{.App.RestartLimitBurst}}
+{{- end}}
 WorkingDirectory={{.App.Snap.DataDir}}
 {{- if .App.StopCommand}}
 ExecStop={{.App.LauncherStopCommand}}
@@ -721,3 +721,45 @@ Type=simple
 WantedBy=multi-user.target
 `, mountUnitPrefix, mountUnitPrefix))
 }
+
+func (s *servicesWrapperGenSuite) TestRestartLimit(c *C) {
+	service := &snap.AppInfo{
+		Snap: &snap.Info{
+			SuggestedName: "snap",
+			Version:       "0.3.4",
+			SideInfo:      snap.SideInfo{Revision: snap.R(44)},
+		},
+		Name:                 "app",
+		Command:              "bin/foo start",
+		Daemon:               "simple",
+		WatchdogTimeout:      timeout.Timeout(10 * time.Second),
+		RestartLimitBurst:    3,
+		RestartLimitInterval: timeout.Timeout(time.Minute),
+	}
+
+	generatedWrapper, err := wrappers.GenerateSnapServiceFile(service)
+	c.Assert(err, IsNil)
+
+	c.Check(string(generatedWrapper), Equals, fmt.Sprintf(`[Unit]
+# Auto-generated, DO NOT EDIT
+Description=Service for snap application snap.app
+Requires=%s-snap-44.mount
+Wants=network.target
+After=%s-snap-44.mount network.target
+X-Snappy=yes
+
+[Service]
+ExecStart=/usr/bin/snap run snap.app
+SyslogIdentifier=snap.app
+Restart=on-failure
+StartLimitInterval=60
+StartLimitBurst=3
+WorkingDirectory=/var/snap/snap/44
+TimeoutStopSec=30
+Type=simple
+WatchdogSec=10
+
+[Install]
+WantedBy=multi-user.target
+`, mountUnitPrefix, mountUnitPrefix))
+}